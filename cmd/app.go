@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wedevctl/util"
+	"github.com/wedevctl/wedev"
+)
+
+// App carries the state commands run against: the database and the managers
+// built on it. NewRootCommand creates an empty App and opens it in
+// PersistentPreRunE; every command constructor takes the App, so two root
+// commands in one process never share state.
+type App struct {
+	dbPath    string
+	opts      wedev.StorageOptions // what the database was last opened with
+	storage   *wedev.StorageManager
+	vnManager *wedev.VirtualNetworkManager
+	generator *wedev.WireGuardConfigGenerator
+	validator util.IPValidator
+	assume    assumptions // prompt answers given by --assume or $WEDEVCTL_ASSUME
+
+	passphraseFile string // --passphrase-file, read by unlockKeys
+
+	metrics      *wedev.Metrics // collecting for --metrics-file; nil once written
+	metricsFile  string
+	metricsStart time.Time
+}
+
+// open opens the database at dbPath and builds the managers on it.
+func (app *App) open(ctx context.Context, dbPath string, opts wedev.StorageOptions) error {
+	storage, err := wedev.NewStorageManagerWithOptionsCtx(ctx, dbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", wedev.DiagnoseOpenError(dbPath, err))
+	}
+
+	validator := util.NewDefaultIPValidator()
+	vnManager, err := wedev.NewVirtualNetworkManager(storage, validator)
+	if err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = storage.Close()
+		return fmt.Errorf("failed to initialize virtual network manager: %w", err)
+	}
+
+	app.dbPath = dbPath
+	app.opts = opts
+	app.storage = storage
+	app.vnManager = vnManager
+	app.generator = wedev.NewWireGuardConfigGenerator(storage)
+	app.validator = validator
+	return nil
+}
+
+// close closes the database if it is open. It is safe to call more than once.
+func (app *App) close() error {
+	if app.storage == nil {
+		return nil
+	}
+	err := app.storage.Close()
+	app.storage = nil
+	return err
+}
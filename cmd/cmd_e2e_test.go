@@ -1,11 +1,27 @@
 package cmd
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wedevctl/wedev"
+	"go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
 )
 
 // useTempDB points wedevctl at a fresh temp-file database for the test.
@@ -14,46 +30,54 @@ func useTempDB(t *testing.T) {
 	t.Setenv("WEDEVCTL_DB_PATH", t.TempDir())
 }
 
-// runCLI executes the root command with the given args. If stdin is non-empty
-// it is fed to os.Stdin (for confirmation prompts). Stdout is captured and
-// returned alongside the execution error.
+// runCLI executes the root command with the given args, with stdin as its
+// input (for confirmation prompts), and returns what it wrote to its output
+// alongside the execution error.
 func runCLI(t *testing.T, stdin string, args ...string) (string, error) {
 	t.Helper()
 
-	if stdin != "" {
-		origStdin := os.Stdin
-		r, w, err := os.Pipe()
-		if err != nil {
-			t.Fatalf("os.Pipe() error = %v", err)
-		}
-		os.Stdin = r
-		defer func() {
-			os.Stdin = origStdin
-			r.Close()
-		}()
-		if _, err := w.WriteString(stdin); err != nil {
-			t.Fatalf("write to stdin pipe error = %v", err)
-		}
-		w.Close()
-	}
-
-	origStdout := os.Stdout
-	tmp, err := os.CreateTemp(t.TempDir(), "stdout-*")
+	app := &App{}
+	root := newRootCommand(app)
+	root.SetArgs(args)
+	out, err := execute(root, stdin)
+	// PersistentPostRunE is skipped when a command fails, so release the
+	// database here; otherwise the next invocation times out on its lock.
 	if err != nil {
-		t.Fatalf("os.CreateTemp() error = %v", err)
+		app.close()
 	}
-	os.Stdout = tmp
+	return out, err
+}
 
-	root := NewRootCommand()
+// runCLIStderr is runCLI for commands whose standard error matters: it
+// returns what they wrote to their output and error output separately.
+func runCLIStderr(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+
+	app := &App{}
+	root := newRootCommand(app)
 	root.SetArgs(args)
-	root.SetOut(io.Discard)
-	root.SetErr(io.Discard)
-	execErr := root.Execute()
+	var stdout, stderr bytes.Buffer
+	root.SetIn(strings.NewReader(stdin))
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+	root.SilenceUsage = true
+	err := root.Execute()
+	if err != nil {
+		app.close()
+	}
+	return stdout.String(), stderr.String(), err
+}
 
-	os.Stdout = origStdout
-	tmp.Close()
-	data, _ := os.ReadFile(tmp.Name())
-	return string(data), execErr
+// execute runs cmd with stdin as its input and returns its captured output.
+// Cobra's error and usage messages are left out.
+func execute(cmd *cobra.Command, stdin string) (string, error) {
+	var out bytes.Buffer
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SilenceUsage = true
+	err := cmd.Execute()
+	return out.String(), err
 }
 
 // TestCLIFullFlow walks a complete lifecycle through the CLI against one DB.
@@ -83,7 +107,7 @@ func TestCLIFullFlow(t *testing.T) {
 		{"config info v1", "", []string{"vn", "testnet", "config", "info", "1"}, false, "Content Hash:"},
 		{"config history", "", []string{"vn", "testnet", "config", "history"}, false, "Version"},
 		{"node delete", "y\n", []string{"vn", "testnet", "node", "delete", "peerA"}, false, "deleted successfully"},
-		{"server delete", "y\n", []string{"vn", "testnet", "server", "delete"}, false, "deleted successfully"},
+		{"server delete", "y\n", []string{"vn", "testnet", "server", "delete", "--cascade"}, false, "Node 'routeA' deleted"},
 		{"vn delete", "y\n", []string{"vn", "delete", "testnet"}, false, "deleted successfully"},
 	}
 
@@ -212,6 +236,117 @@ func TestCLINodeAndServerEditBranches(t *testing.T) {
 	if _, err := runCLI(t, "", "vn", "ed", "node", "edit", "r1", "--public-address", ""); err == nil {
 		t.Error("clearing a peer node's address should fail")
 	}
+
+	// Changes that depend on each other are applied as one edit: p1 stops
+	// routing and becomes a peer again in the same write.
+	if _, err := runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--route-cidr", "192.168.50.0/24"); err != nil {
+		t.Fatalf("node edit --route-cidr error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--type", "peer", "--route-cidr", "", "--port", "51830", "--ignore-conflict")
+	if err != nil || !strings.Contains(out, "Type: peer") || strings.Contains(out, "Routed CIDRs") {
+		t.Errorf("node edit of type and routes = %q, %v; want a peer without routed CIDRs", out, err)
+	}
+
+	// A peer that r1 dials directly becomes a client only when forced, and
+	// the configs it drops out of are listed.
+	if _, err := runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--type", "client", "--public-address", ""); ExitCode(err) != ExitValidation {
+		t.Errorf("node edit p1 ->client exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	out, err = runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--type", "client", "--public-address", "", "--force")
+	if err != nil || !strings.Contains(out, "Other configs that change: r1, srv\n") {
+		t.Errorf("node edit p1 ->client --force = %q, %v; want r1 and srv listed", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--type", "route", "--route-cidr", "192.168.60.0/24"); err != nil {
+		t.Fatalf("node edit p1 ->route error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "ed", "node", "edit", "p1", "--type", "peer", "--public-address", "1.2.3.4", "--clear-routes")
+	if err != nil || strings.Contains(out, "Routed CIDRs") {
+		t.Errorf("node edit p1 ->peer --clear-routes = %q, %v; want a peer without routed CIDRs", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "ed", "server", "edit", "--port", "51900", "--ignore-conflict"); err != nil || !strings.Contains(out, ":51900") {
+		t.Errorf("server edit --ignore-conflict = %q, %v", out, err)
+	}
+
+	if got := ExitCode(fmt.Errorf("failed to update node: %w", wedev.ErrConflict)); got != ExitConflict {
+		t.Errorf("ExitCode(conflict) = %d, want %d", got, ExitConflict)
+	}
+}
+
+func TestCLIConfigFreeze(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+	for _, args := range [][]string{
+		{"vn", "add", "prodnet", "10.0.0.0/24"},
+		{"vn", "prodnet", "server", "add", "hub", "vpn.example.com"},
+		{"vn", "prodnet", "config", "generate", "--output-dir", outDir},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "prodnet", "config", "freeze", "--reason", "incident 42")
+	if err != nil || !strings.Contains(out, "incident 42") {
+		t.Fatalf("config freeze = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "prodnet", "config", "freeze"); ExitCode(err) != ExitFrozen {
+		t.Errorf("config freeze twice exit code = %d (%v), want %d", ExitCode(err), err, ExitFrozen)
+	}
+	if out, err := runCLI(t, "", "vn", "prodnet", "info"); err != nil || !strings.Contains(out, "Config: FROZEN") || !strings.Contains(out, "incident 42") {
+		t.Errorf("vn info while frozen = %q, %v; want the freeze", out, err)
+	}
+
+	// Changes made while frozen are not generated: version 1 is written again.
+	if _, err := runCLI(t, "", "vn", "prodnet", "node", "add", "laptop", "client"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	_, stderr, err := runCLIStderr(t, "", "vn", "prodnet", "config", "generate", "--output-dir", outDir, "--force")
+	if err != nil {
+		t.Fatalf("config generate --force while frozen error = %v", err)
+	}
+	if !strings.Contains(stderr, "override is recorded") {
+		t.Errorf("config generate --force stderr = %q, want the override warned about", stderr)
+	}
+	if _, err := runCLI(t, "", "vn", "prodnet", "node", "add", "phone", "client"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	out, stderr, err = runCLIStderr(t, "y\n", "vn", "prodnet", "config", "generate", "--output-dir", outDir)
+	if err != nil || !strings.Contains(out, "wrote version 2, no new version saved") || !strings.Contains(stderr, "frozen") {
+		t.Errorf("config generate while frozen = %q, %q, %v; want version 2 written", out, stderr, err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "phone.conf")); err == nil {
+		t.Error("config generate while frozen wrote a config for a node added since the freeze")
+	}
+	if _, err := runCLI(t, "", "vn", "prodnet", "config", "generate", "--output-dir", outDir, "--check"); err != nil {
+		t.Errorf("config generate --check while frozen error = %v, want the written version to match", err)
+	}
+	if _, err := runCLI(t, "", "vn", "prodnet", "guest", "create", "acme", "--ttl", "24h"); ExitCode(err) != ExitFrozen {
+		t.Errorf("guest create while frozen exit code = %d (%v), want %d", ExitCode(err), err, ExitFrozen)
+	}
+	if out, err := runCLI(t, "", "vn", "prodnet", "guest", "list"); err != nil || strings.Contains(out, "acme") {
+		t.Errorf("guest list after refused create = %q, %v; want no guest", out, err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "prodnet", "edit", "--cidr", "10.0.0.0/16"); ExitCode(err) != ExitFrozen {
+		t.Errorf("edit --cidr while frozen exit code = %d (%v), want %d", ExitCode(err), err, ExitFrozen)
+	}
+	if out, err := runCLI(t, "", "vn", "prodnet", "info"); err != nil || !strings.Contains(out, "10.0.0.0/24") {
+		t.Errorf("vn info after refused resize = %q, %v; want the CIDR unchanged", out, err)
+	}
+
+	out, err = runCLI(t, "", "vn", "prodnet", "config", "history")
+	if err != nil || !strings.HasPrefix(out, "FROZEN") || !strings.Contains(out, "freeze overridden") {
+		t.Errorf("config history while frozen = %q, %v; want the banner and the override", out, err)
+	}
+
+	if out, err := runCLI(t, "", "vn", "prodnet", "config", "unfreeze"); err != nil || !strings.Contains(out, "unfrozen") {
+		t.Fatalf("config unfreeze = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "prodnet", "config", "generate", "--output-dir", outDir, "--force"); err != nil || !strings.Contains(out, "saved") || strings.Contains(out, "frozen") {
+		t.Errorf("config generate after unfreeze = %q, %v; want a new version", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "prodnet", "info"); err != nil || strings.Contains(out, "FROZEN") {
+		t.Errorf("vn info after unfreeze = %q, %v", out, err)
+	}
 }
 
 func TestCLIConfigGenerateOverwrite(t *testing.T) {
@@ -261,7 +396,7 @@ func TestCLIDeleteCancellations(t *testing.T) {
 		t.Error("node should survive a declined deletion")
 	}
 	// Declined server deletion: no error, server survives.
-	if out, err := runCLI(t, "n\n", "vn", "dc", "server", "delete"); err != nil {
+	if out, err := runCLI(t, "n\n", "vn", "dc", "server", "delete", "--keep-nodes"); err != nil {
 		t.Errorf("declined server delete error = %v (out: %s)", err, out)
 	}
 	if out, _ := runCLI(t, "", "vn", "dc", "server", "info"); !strings.Contains(out, "srv") {
@@ -269,14 +404,60 @@ func TestCLIDeleteCancellations(t *testing.T) {
 	}
 }
 
-func TestCLIConfigGenerateNoServer(t *testing.T) {
+func TestCLIServerDeleteDependents(t *testing.T) {
 	useTempDB(t)
-	if _, err := runCLI(t, "y\n", "vn", "add", "emptynet", "10.0.0.0/24"); err != nil {
+	if _, err := runCLI(t, "y\n", "vn", "add", "sd", "10.0.0.0/24"); err != nil {
 		t.Fatalf("vn add error = %v", err)
 	}
-	// Generating configs for a network without a server must fail.
-	if _, err := runCLI(t, "", "vn", "emptynet", "config", "generate", "--output-dir", t.TempDir(), "--force"); err == nil {
-		t.Error("config generate without a server should fail")
+	for _, args := range [][]string{
+		{"server", "add", "hub1", "vpn1.example.com"},
+		{"server", "add", "hub2", "vpn2.example.com"},
+		{"node", "add", "n1", "route"},
+		{"node", "add", "n2", "route", "--server", "hub2"},
+		{"node", "add", "n3", "route", "--server", "hub2"},
+	} {
+		if out, err := runCLI(t, "", append([]string{"vn", "sd"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v (out: %s)", args, err, out)
+		}
+	}
+
+	// Refused by default, before asking, with the impact and the way out.
+	_, err := runCLI(t, "y\n", "vn", "sd", "server", "delete", "hub2")
+	if ExitCode(err) != ExitValidation {
+		t.Fatalf("server delete error = %v, want exit code %d", err, ExitValidation)
+	}
+	for _, want := range []string{"(n2, n3)", `move to server "hub1"`, "--cascade", "--keep-nodes"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("server delete error = %v, want it to contain %q", err, want)
+		}
+	}
+	if _, err := runCLI(t, "", "vn", "sd", "server", "delete", "hub2", "--cascade", "--keep-nodes"); err == nil {
+		t.Error("server delete with --cascade and --keep-nodes should fail")
+	}
+
+	out, err := runCLI(t, "y\n", "vn", "sd", "server", "delete", "hub2", "--cascade")
+	if err != nil {
+		t.Fatalf("server delete --cascade error = %v (out: %s)", err, out)
+	}
+	for _, want := range []string{"n2, n3", "Node 'n2' deleted", "Node 'n3' deleted", "Server 'hub2' deleted successfully"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("server delete --cascade output %q does not contain %q", out, want)
+		}
+	}
+	if out, _ := runCLI(t, "", "vn", "sd", "node", "list"); !strings.Contains(out, "n1") || strings.Contains(out, "n2") {
+		t.Errorf("node list after the cascade = %q, want only n1", out)
+	}
+
+	// --keep-nodes deletes the last server with a warning; n1 stays.
+	stdout, stderr, err := runCLIStderr(t, "y\n", "vn", "sd", "server", "delete", "--keep-nodes")
+	if err != nil {
+		t.Fatalf("server delete --keep-nodes error = %v (out: %s)", err, stdout)
+	}
+	if !strings.Contains(stderr, "Warning: 1 node(s) depend on server 'hub1' (n1)") {
+		t.Errorf("server delete --keep-nodes stderr = %q, want a warning naming n1", stderr)
+	}
+	if out, _ := runCLI(t, "", "vn", "sd", "node", "list"); !strings.Contains(out, "n1") {
+		t.Errorf("node list after --keep-nodes = %q, want n1 kept", out)
 	}
 }
 
@@ -310,3 +491,3803 @@ func TestCLIEmptyListings(t *testing.T) {
 		t.Errorf("expected empty history message, got %q", out)
 	}
 }
+
+func TestCLIDBRepair(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "rep", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "rep", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "db", "repair")
+	if err != nil {
+		t.Fatalf("db repair error = %v", err)
+	}
+	if !strings.Contains(out, "No orphaned index entries") {
+		t.Errorf("expected clean repair message, got %q", out)
+	}
+
+	// Deleting and re-creating a server with the same name must succeed.
+	if _, err := runCLI(t, "y\n", "vn", "rep", "server", "delete"); err != nil {
+		t.Fatalf("server delete error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "rep", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Errorf("re-adding server with the same name error = %v", err)
+	}
+}
+
+func TestCLIDBFsck(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "fsk", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "db", "fsck")
+	if err != nil {
+		t.Fatalf("db fsck on a clean database error = %v", err)
+	}
+	if !strings.Contains(out, "No integrity problems found") {
+		t.Errorf("db fsck output = %q", out)
+	}
+
+	// Leave a config version behind for a network that does not exist.
+	sm, err := wedev.NewStorageManager(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if _, err := sm.SaveConfigVersion("gone", "hash", map[string]string{"a": "b"}); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	sm.Close()
+
+	out, err = runCLI(t, "", "db", "fsck", "--output", "json")
+	if err == nil {
+		t.Error("db fsck with problems should exit with an error")
+	}
+	var report wedev.IntegrityReport
+	if jErr := json.Unmarshal([]byte(out), &report); jErr != nil {
+		t.Fatalf("db fsck json = %q: %v", out, jErr)
+	}
+	if len(report.OrphanedConfigs) != 1 || report.OrphanedConfigs[0].NetworkID != "gone" || report.Problems() != 1 {
+		t.Errorf("db fsck report = %+v, want one orphaned config", report)
+	}
+
+	out, err = runCLI(t, "", "db", "fsck", "--fix")
+	if err != nil {
+		t.Fatalf("db fsck --fix error = %v", err)
+	}
+	if !strings.Contains(out, "orphaned_config") || !strings.Contains(out, "Repaired 1 problem(s)") {
+		t.Errorf("db fsck --fix output = %q", out)
+	}
+
+	if _, err := runCLI(t, "", "db", "fsck"); err != nil {
+		t.Errorf("db fsck after --fix error = %v", err)
+	}
+}
+
+func TestCLIVNRename(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "oldnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "oldnet", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "rename", "oldnet", "newnet")
+	if err != nil {
+		t.Fatalf("vn rename error = %v (out: %s)", err, out)
+	}
+	if !strings.Contains(out, "renamed to 'newnet'") {
+		t.Errorf("expected rename confirmation, got %q", out)
+	}
+
+	// The server follows the network to its new name.
+	if out, err := runCLI(t, "", "vn", "newnet", "server", "info"); err != nil || !strings.Contains(out, "srv") {
+		t.Errorf("server info under new name = %q (err %v)", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "oldnet", "server", "info"); err == nil {
+		t.Error("old network name should no longer resolve")
+	}
+	if _, err := runCLI(t, "", "vn", "rename", "newnet", "bad-name"); err == nil {
+		t.Error("rename to an invalid name should fail")
+	}
+}
+
+func TestCLIAliasesAndNetworkPrefixes(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+	for _, name := range []string{"prodnet", "stageeu", "stageus"} {
+		if _, err := runCLI(t, "y\n", "vn", "add", name, "10.0.0.0/24"); err != nil {
+			t.Fatalf("vn add %s error = %v", name, err)
+		}
+	}
+
+	steps := []struct {
+		name     string
+		stdin    string
+		args     []string
+		contains string
+	}{
+		{"net ls", "", []string{"net", "ls"}, "stageus"},
+		{"server add by prefix", "", []string{"net", "prod", "server", "add", "srv", "vpn.example.com"}, "created successfully"},
+		{"node add by prefix", "", []string{"vn", "p", "node", "add", "laptop", "route"}, "created successfully"},
+		{"node ls", "", []string{"vn", "prod", "node", "ls"}, "laptop"},
+		{"gen", "", []string{"vn", "prod", "config", "gen", "--output-dir", outDir}, "version 1 saved"},
+		{"node rm", "y\n", []string{"vn", "prod", "node", "rm", "laptop"}, "deleted successfully"},
+		{"vn rm", "y\n", []string{"net", "rm", "stageus"}, "deleted successfully"},
+		{"no longer ambiguous", "", []string{"vn", "stage", "node", "ls"}, ""},
+	}
+	for _, s := range steps {
+		if out, err := runCLI(t, s.stdin, s.args...); err != nil || !strings.Contains(out, s.contains) {
+			t.Errorf("[%s] = %q, %v; want %q", s.name, out, err, s.contains)
+		}
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "stageus", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	_, err := runCLI(t, "", "vn", "stage", "node", "ls")
+	if ExitCode(err) != ExitValidation || !strings.Contains(err.Error(), "stageeu, stageus") {
+		t.Errorf("ambiguous prefix error = %v (exit %d), want the candidates and exit %d", err, ExitCode(err), ExitValidation)
+	}
+	if _, err := runCLI(t, "", "vn", "qa", "node", "ls"); ExitCode(err) != ExitNotFound {
+		t.Errorf("unknown network exit code = %d (%v), want %d", ExitCode(err), err, ExitNotFound)
+	}
+}
+
+func TestCLINodeAndServerRename(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "rn", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "rn", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "rn", "node", "add", "n1", "peer", "1.2.3.4"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	if out, err := runCLI(t, "", "vn", "rn", "node", "rename", "n1", "laptop"); err != nil || !strings.Contains(out, "renamed to 'laptop'") {
+		t.Errorf("node rename = %q (err %v)", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "rn", "node", "list"); !strings.Contains(out, "laptop") || strings.Contains(out, "n1 ") {
+		t.Errorf("node list after rename = %q", out)
+	}
+	if _, err := runCLI(t, "", "vn", "rn", "node", "rename", "laptop", "srv"); err == nil {
+		t.Error("renaming a node to the server's name should fail")
+	}
+
+	if out, err := runCLI(t, "", "vn", "rn", "server", "rename", "gateway"); err != nil || !strings.Contains(out, "gateway") {
+		t.Errorf("server rename = %q (err %v)", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "rn", "server", "info"); !strings.Contains(out, "Server: gateway") {
+		t.Errorf("server info after rename = %q", out)
+	}
+}
+
+func TestCLINodeRouteCIDRs(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "rc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "rc", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "rc", "node", "add", "office", "route", "--route-cidr", "192.168.50.0/24")
+	if err != nil {
+		t.Fatalf("node add --route-cidr error = %v", err)
+	}
+	if !strings.Contains(out, "Routed CIDRs: 192.168.50.0/24") {
+		t.Errorf("node add output missing routed CIDRs: %q", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "rc", "node", "edit", "office", "--route-cidr", "192.168.50.0/24", "--route-cidr", "192.168.60.0/24")
+	if err != nil {
+		t.Fatalf("node edit --route-cidr error = %v", err)
+	}
+	if !strings.Contains(out, "192.168.50.0/24, 192.168.60.0/24") {
+		t.Errorf("node edit output missing routed CIDRs: %q", out)
+	}
+
+	// Clearing the subnets and switching to peer in one edit.
+	if out, err := runCLI(t, "", "vn", "rc", "node", "edit", "office", "--type", "peer", "--public-address", "1.2.3.4", "--route-cidr", ""); err != nil {
+		t.Errorf("node edit to peer clearing routes error = %v (out: %s)", err, out)
+	}
+
+	// Only route nodes may route subnets.
+	if _, err := runCLI(t, "", "vn", "rc", "node", "add", "p1", "peer", "1.2.3.5", "--route-cidr", "192.168.70.0/24"); err == nil {
+		t.Error("node add peer with --route-cidr should fail")
+	}
+}
+
+func TestCLIConfigApplyDryRun(t *testing.T) {
+	useTempDB(t)
+	configDir := t.TempDir()
+	if _, err := runCLI(t, "y\n", "vn", "add", "ap", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ap", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "ap", "config", "apply", "srv", "--dry-run", "--config-dir", configDir, "--interface", "wg0")
+	if err != nil {
+		t.Fatalf("config apply --dry-run error = %v", err)
+	}
+	for _, want := range []string{"Would write: " + filepath.Join(configDir, "wg0.conf"), "[Interface]", "wg-quick up"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry-run output missing %q: %q", want, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "wg0.conf")); err == nil {
+		t.Error("dry-run must not write the config file")
+	}
+
+	if _, err := runCLI(t, "", "vn", "ap", "config", "apply", "ghost", "--dry-run"); err == nil {
+		t.Error("config apply for an unknown entity should fail")
+	}
+}
+
+// TestCLIConfigApplyNative checks that --native fails cleanly where devices
+// cannot be configured natively: on kernels without WireGuard, other
+// platforms, and without root. Where they can, --dry-run only reads.
+func TestCLIConfigApplyNative(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "nat", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "nat", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "nat", "config", "apply", "srv", "--native", "--dry-run"); err != nil && !errors.Is(err, wedev.ErrNativeUnsupported) && !strings.Contains(err.Error(), "requires root") {
+		t.Errorf("config apply --native error = %v, want ErrNativeUnsupported or a missing root error", err)
+	}
+	for _, args := range [][]string{{"--no-restart"}, {"--config-dir", t.TempDir()}} {
+		args = append([]string{"vn", "nat", "config", "apply", "srv", "--native"}, args...)
+		if _, err := runCLI(t, "", args...); err == nil || errors.Is(err, wedev.ErrNativeUnsupported) {
+			t.Errorf("%v error = %v, want a flag conflict", args, err)
+		}
+	}
+}
+
+func TestCLIConfigStale(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "cs", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "cs", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "cs", "config", "stale"); err == nil {
+		t.Error("config stale before any config generate should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "cs", "config", "generate", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	// A dry-run apply is not a deployment.
+	if _, err := runCLI(t, "", "vn", "cs", "config", "apply", "srv", "--dry-run"); err != nil {
+		t.Fatalf("config apply --dry-run error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "cs", "config", "stale")
+	if err != nil {
+		t.Fatalf("config stale error = %v", err)
+	}
+	for _, want := range []string{"Entity", "Deployed At", "srv", "never deployed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config stale output missing %q: %q", want, out)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "cs", "config", "stale", "-o", "json")
+	if err != nil {
+		t.Fatalf("config stale -o json error = %v", err)
+	}
+	var states []wedev.EntityDeployment
+	if err := json.Unmarshal([]byte(out), &states); err != nil {
+		t.Fatalf("config stale -o json is not valid JSON: %v\n%s", err, out)
+	}
+	if len(states) != 1 || states[0].Entity != "srv" || states[0].Status != wedev.DeploymentNever || states[0].CurrentVersion != 1 {
+		t.Errorf("config stale -o json = %+v, want srv never deployed at version 1", states)
+	}
+}
+
+// TestCLIConfigDeploy deploys through a fake ssh on PATH that stores the
+// config it is sent under the name of the host it was asked to reach.
+func TestCLIConfigDeploy(t *testing.T) {
+	useTempDB(t)
+	binDir, received := t.TempDir(), t.TempDir()
+	fakeSSH := "#!/bin/sh\n# ssh -o BatchMode=yes -- <host> <command>\nshift 3\ncat > \"" + received + "/$1\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(fakeSSH), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, args := range [][]string{
+		{"vn", "add", "dep", "10.0.0.0/24"},
+		{"vn", "dep", "server", "add", "hub", "vpn.example.com"},
+		{"vn", "dep", "node", "add", "laptop", "client"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	if _, err := runCLI(t, "", "vn", "dep", "config", "deploy"); err == nil {
+		t.Error("config deploy before any config generate should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "dep", "config", "generate", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	for _, args := range [][]string{{"--parallel", "0"}, {"--host", "laptop"}, {"--timeout", "0s"}} {
+		args = append([]string{"vn", "dep", "config", "deploy"}, args...)
+		if _, err := runCLI(t, "", args...); ExitCode(err) != ExitValidation {
+			t.Errorf("%v exit code = %d (%v), want %d", args, ExitCode(err), err, ExitValidation)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "dep", "config", "deploy", "--user", "root")
+	if err != nil {
+		t.Fatalf("config deploy error = %v", err)
+	}
+	for _, want := range []string{"root@vpn.example.com", "succeeded", "no public address", "1 succeeded, 1 skipped, 0 failed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config deploy output missing %q: %q", want, out)
+		}
+	}
+	config, err := runCLI(t, "", "vn", "dep", "config", "show", "hub")
+	if err != nil {
+		t.Fatalf("config show error = %v", err)
+	}
+	if sent, err := os.ReadFile(filepath.Join(received, "root@vpn.example.com")); err != nil || !strings.Contains(config, strings.TrimSpace(string(sent))) {
+		t.Errorf("ssh received %q, %v; want the hub's config", sent, err)
+	}
+	if out, err := runCLI(t, "", "vn", "dep", "config", "stale"); err != nil || !strings.Contains(out, "current") {
+		t.Errorf("config stale after deploy = %q, %v; want hub current", out, err)
+	}
+
+	out, err = runCLI(t, "", "vn", "dep", "config", "deploy", "--host", "laptop=laptop.lan", "-o", "json")
+	if err != nil {
+		t.Fatalf("config deploy --host error = %v", err)
+	}
+	var results []wedev.DeployResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("config deploy -o json is not valid JSON: %v\n%s", err, out)
+	}
+	want := map[string]wedev.DeployStatus{"hub": wedev.DeploySkipped, "laptop": wedev.DeploySucceeded}
+	for _, r := range results {
+		if want[r.Entity] != r.Status {
+			t.Errorf("%s = %+v, want %s", r.Entity, r, want[r.Entity])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(received, "laptop.lan")); err != nil {
+		t.Errorf("laptop was not deployed at its --host: %v", err)
+	}
+}
+
+func TestCLIDBFlag(t *testing.T) {
+	useTempDB(t)
+	inventory := filepath.Join(t.TempDir(), "inventory.db")
+
+	if _, err := runCLI(t, "y\n", "--db", inventory, "vn", "add", "inv", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add with --db error = %v", err)
+	}
+	// Global flags before 'vn <network>' are skipped when routing.
+	if _, err := runCLI(t, "", "--db", inventory, "-q", "vn", "inv", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add with leading --db error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "inv", "server", "list", "--db="+inventory)
+	if err != nil || !strings.Contains(out, "srv") {
+		t.Errorf("server list with trailing --db = %q, %v; want srv", out, err)
+	}
+
+	// The environment's database knows nothing of the network.
+	if _, err := runCLI(t, "", "vn", "inv", "server", "list"); err == nil {
+		t.Error("the network should exist only in the --db database")
+	}
+}
+
+func TestCLIStatusErrors(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "st", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	// Invalid output formats are rejected before touching WireGuard.
+	if _, err := runCLI(t, "", "vn", "st", "status", "--output", "xml"); err == nil {
+		t.Error("status with an invalid --output should fail")
+	}
+	// An interface that does not exist is reported, not dumped raw.
+	if _, err := runCLI(t, "", "vn", "st", "status", "--interface", "wgnonexist0"); err == nil {
+		t.Error("status on a missing interface should fail")
+	}
+}
+
+func TestCLIDBBackupRestoreInfo(t *testing.T) {
+	useTempDB(t)
+	backup := filepath.Join(t.TempDir(), "backup.db")
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "keep", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if out, err := runCLI(t, "", "db", "backup", backup); err != nil || !strings.Contains(out, "backed up") {
+		t.Fatalf("db backup = %q (err %v)", out, err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "later", "10.1.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	// Declining the prompt leaves the database alone.
+	if out, err := runCLI(t, "n\n", "db", "restore", backup); err != nil || !strings.Contains(out, "Cancelled") {
+		t.Errorf("declined db restore = %q (err %v)", out, err)
+	}
+	if out, err := runCLI(t, "", "db", "restore", backup, "--yes"); err != nil || !strings.Contains(out, "restored") {
+		t.Fatalf("db restore = %q (err %v)", out, err)
+	}
+	out, _ := runCLI(t, "", "vn", "list")
+	if !strings.Contains(out, "keep") || strings.Contains(out, "later") {
+		t.Errorf("vn list after restore = %q, want only keep", out)
+	}
+
+	out, err := runCLI(t, "", "db", "info")
+	if err != nil {
+		t.Fatalf("db info error = %v", err)
+	}
+	for _, want := range []string{"Path:", "wedevctl.db", "Size:", "networks"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("db info output missing %q: %q", want, out)
+		}
+	}
+
+	if _, err := runCLI(t, "", "db", "restore", filepath.Join(t.TempDir(), "missing.db"), "--yes"); err == nil {
+		t.Error("db restore of a missing file should fail")
+	}
+}
+
+func TestCLIDBMigrateStatus(t *testing.T) {
+	useTempDB(t)
+
+	out, err := runCLI(t, "", "db", "migrate")
+	if err != nil || !strings.Contains(out, "up to date") {
+		t.Fatalf("db migrate = %q (err %v)", out, err)
+	}
+
+	out, err = runCLI(t, "", "db", "migrate", "--status")
+	if err != nil {
+		t.Fatalf("db migrate --status error = %v", err)
+	}
+	for _, want := range []string{"applied", "configs_by_version", "nodes_by_network"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("db migrate --status output missing %q: %q", want, out)
+		}
+	}
+	if strings.Contains(out, "pending") {
+		t.Errorf("db migrate --status reports pending migrations on a fresh database: %q", out)
+	}
+}
+
+func TestCLILabelsAndSelectors(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "pay", "10.0.0.0/24", "--label", "team=payments", "--label", "env=prod"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "search", "10.1.0.0/24", "--label", "team=search"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "bad", "10.2.0.0/24", "--label", "my team=x"); err == nil {
+		t.Error("vn add with a spaced label key should fail")
+	}
+
+	out, err := runCLI(t, "", "vn", "list", "--selector", "team=payments")
+	if err != nil || !strings.Contains(out, "pay") || strings.Contains(out, "search") {
+		t.Errorf("vn list --selector team=payments = %q (err %v)", out, err)
+	}
+	out, _ = runCLI(t, "", "vn", "list", "--selector", "team!=payments", "--output", "json")
+	var networks []map[string]any
+	if err := json.Unmarshal([]byte(out), &networks); err != nil {
+		t.Fatalf("vn list json = %q: %v", out, err)
+	}
+	if len(networks) != 1 || networks[0]["name"] != "search" {
+		t.Errorf("vn list --selector team!=payments = %v, want only search", networks)
+	}
+
+	if out, err := runCLI(t, "", "vn", "edit", "pay", "--remove-label", "env", "--label", "tier=gold"); err != nil || !strings.Contains(out, "team=payments,tier=gold") {
+		t.Errorf("vn edit = %q (err %v)", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "pay", "node", "add", "db1", "route", "--label", "role=db"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "pay", "node", "add", "web1", "route", "--label", "role=web"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "pay", "node", "edit", "web1", "--label", "canary=true"); err != nil {
+		t.Fatalf("node edit error = %v", err)
+	}
+
+	out, err = runCLI(t, "", "vn", "pay", "node", "list", "--selector", "role=web,canary=true", "-o", "json")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	var nodes []map[string]any
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list json = %q: %v", out, err)
+	}
+	if len(nodes) != 1 || nodes[0]["name"] != "web1" {
+		t.Errorf("node list --selector = %v, want only web1", nodes)
+	}
+	if _, ok := nodes[0]["private_key"]; ok {
+		t.Error("node list json must not include private keys")
+	}
+	if labels, _ := nodes[0]["labels"].(map[string]any); labels["canary"] != "true" || labels["role"] != "web" {
+		t.Errorf("node list json labels = %v", nodes[0]["labels"])
+	}
+}
+
+// completeCLI runs cobra's hidden completion request command and returns the
+// offered completions (without descriptions or the trailing directive line).
+func completeCLI(t *testing.T, args ...string) []string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	root := NewRootCommand()
+	root.SetArgs(append([]string{cobra.ShellCompNoDescRequestCmd}, args...))
+	root.SetOut(&buf)
+	root.SetErr(io.Discard)
+	if err := root.Execute(); err != nil {
+		t.Fatalf("completion %v error = %v", args, err)
+	}
+
+	var completions []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line != "" && !strings.HasPrefix(line, ":") {
+			completions = append(completions, line)
+		}
+	}
+	return completions
+}
+
+func TestCLICompletion(t *testing.T) {
+	useTempDB(t)
+
+	// An empty database directory must yield static completions only and
+	// must not create the database.
+	if got := completeCLI(t, "vn", ""); !slices.Contains(got, "add") || !slices.Contains(got, "list") {
+		t.Errorf("vn completions on a missing database = %v, want static subcommands", got)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db")); !os.IsNotExist(err) {
+		t.Errorf("completion created the database (stat err %v)", err)
+	}
+	if got := completeCLI(t, "vn", "prod", "node", "edit", ""); len(got) != 0 {
+		t.Errorf("node completions on a missing database = %v, want none", got)
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "prod", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "prod", "server", "add", "gw", "vpn.example.com", "51820"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "prod", "node", "add", "n1", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "prod", "config", "generate", "--force", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"vn", ""}, []string{"add", "list", "delete", "prod"}},
+		{[]string{"vn", "p"}, []string{"prod"}},
+		{[]string{"vn", "delete", ""}, []string{"prod"}},
+		{[]string{"vn", "prod", ""}, []string{"server", "node", "config", "status"}},
+		{[]string{"vn", "prod", "node", "edit", ""}, []string{"n1"}},
+		{[]string{"vn", "prod", "node", "delete", "n"}, []string{"n1"}},
+		{[]string{"vn", "prod", "config", "info", ""}, []string{"1"}},
+		{[]string{"vn", "prod", "config", "apply", ""}, []string{"gw", "n1"}},
+		{[]string{"completion", ""}, []string{"bash", "zsh", "fish"}},
+	}
+	for _, tt := range tests {
+		got := completeCLI(t, tt.args...)
+		for _, want := range tt.want {
+			if !slices.Contains(got, want) {
+				t.Errorf("completions for %q = %v, missing %q", tt.args, got, want)
+			}
+		}
+	}
+
+	if got := completeCLI(t, "vn", "prod", "node", "edit", "n1", ""); len(got) != 0 {
+		t.Errorf("completions after the node name = %v, want none", got)
+	}
+}
+
+func TestCLINetworkFlagCommands(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "prod", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	// --network works before or after the subcommand and its arguments.
+	for _, args := range [][]string{
+		{"server", "add", "--network", "prod", "gw", "vpn.example.com"},
+		{"node", "--network", "prod", "add", "n1", "route", "--label", "site=a", "--label", "tier=web"},
+		{"node", "add", "n2", "client", "--network=prod", "--no-drift-check"},
+	} {
+		if _, err := runCLI(t, "", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	out, err := runCLI(t, "", "node", "list", "--network", "prod", "--selector", "tier=web")
+	if err != nil || !strings.Contains(out, "n1") || strings.Contains(out, "n2") {
+		t.Errorf("node list --network prod --selector tier=web = %q, %v", out, err)
+	}
+	viaVN, err := runCLI(t, "", "vn", "prod", "node", "info", "n1")
+	if err != nil {
+		t.Fatalf("vn prod node info error = %v", err)
+	}
+	if out, err := runCLI(t, "", "node", "info", "n1", "--network", "prod"); err != nil || out != viaVN {
+		t.Errorf("node info --network prod = %q, %v; want the output of 'vn prod node info'\n%s", out, err, viaVN)
+	}
+	out, stderr, err := runCLIStderr(t, "", "config", "generate", "--network", "prod", "--output-dir", t.TempDir(), "--no-perm-check")
+	if err != nil || !strings.Contains(out, "Configuration version 1 saved") {
+		t.Errorf("config generate --network prod = %q (stderr %q), %v", out, stderr, err)
+	}
+	// The drift check of the shared implementation runs too.
+	if _, stderr, err = runCLIStderr(t, "", "node", "edit", "n2", "--network", "prod", "--port", "51999"); err != nil || !strings.Contains(stderr, "Configuration drift") {
+		t.Errorf("node edit --network prod stderr = %q, %v; want the drift warning", stderr, err)
+	}
+
+	// Help, unknown flags, typos and a missing or unknown network get
+	// cobra's usual handling.
+	out, err = runCLI(t, "", "server", "add", "--help")
+	if err != nil || !strings.Contains(out, "--network string") || !strings.Contains(out, "--additional-address") {
+		t.Errorf("server add --help = %q, %v", out, err)
+	}
+	if out, err = runCLI(t, "", "node", "--help"); err != nil || !strings.Contains(out, "'wedevctl vn <network> node' is the same") {
+		t.Errorf("node --help = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "node", "list", "--network", "prod", "--bogus"); err == nil || !strings.Contains(err.Error(), "unknown flag: --bogus") {
+		t.Errorf("node list --bogus error = %v, want an unknown flag error", err)
+	}
+	if _, err := runCLI(t, "", "nod", "list", "--network", "prod"); err == nil || !strings.Contains(err.Error(), "Did you mean this?") {
+		t.Errorf("nod list error = %v, want a suggestion", err)
+	}
+	if _, err := runCLI(t, "", "node", "list"); err == nil || !strings.Contains(err.Error(), `required flag(s) "network" not set`) {
+		t.Errorf("node list without --network error = %v", err)
+	}
+	if _, err := runCLI(t, "", "node", "list", "--network", "nope"); ExitCode(err) != ExitNotFound {
+		t.Errorf("node list --network nope error = %v, want not found", err)
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"node", "list", "--network", ""}, []string{"prod"}},
+		{[]string{"node", "edit", "--network", "prod", ""}, []string{"n1", "n2"}},
+		{[]string{"config", "info", "--network", "prod", ""}, []string{"1"}},
+		{[]string{"node", "add", "n3", "route", "--network", "prod", "--server", ""}, []string{"gw"}},
+		{[]string{""}, []string{"server", "node", "config", "vn"}},
+	}
+	for _, tt := range tests {
+		got := completeCLI(t, tt.args...)
+		for _, want := range tt.want {
+			if !slices.Contains(got, want) {
+				t.Errorf("completions for %q = %v, missing %q", tt.args, got, want)
+			}
+		}
+	}
+	// Without --network only the required flag itself is offered.
+	if got := completeCLI(t, "node", "edit", ""); slices.Contains(got, "n1") {
+		t.Errorf("node edit completions without --network = %v, want no nodes", got)
+	}
+}
+
+func TestCLICompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		out, err := runCLI(t, "", "completion", shell)
+		if err != nil || !strings.Contains(out, "wedevctl") {
+			t.Errorf("completion %s = %.60q (err %v)", shell, out, err)
+		}
+	}
+	if _, err := runCLI(t, "", "completion", "tcsh"); err == nil {
+		t.Error("completion for an unsupported shell should fail")
+	}
+}
+
+func TestCLIConfigGenerateDryRun(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "dry", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "dry", "server", "add", "srv", "vpn.example.com", "51820"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "dry", "config", "generate", "--output-dir", outDir, "--force"); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "dry", "config", "generate", "--dry-run")
+	if err != nil || !strings.Contains(out, "No changes") {
+		t.Errorf("dry run without changes = %q (err %v)", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "dry", "node", "add", "n1", "peer", "1.2.3.4"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	dryDir := t.TempDir()
+	out, err = runCLI(t, "", "vn", "dry", "config", "generate", "--dry-run", "--output-dir", dryDir)
+	if err != nil {
+		t.Fatalf("dry run error = %v", err)
+	}
+	for _, want := range []string{"new file: n1.conf", "--- a/srv.conf (version 1)", "+++ b/srv.conf (generated)", "+AllowedIPs = 10.0.0.2/32"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry run output missing %q:\n%s", want, out)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dryDir); len(entries) != 0 {
+		t.Errorf("dry run wrote %d files", len(entries))
+	}
+	if _, err := runCLI(t, "", "vn", "dry", "config", "info", "2"); err == nil {
+		t.Error("dry run saved a version")
+	}
+}
+
+func TestCLINetworkEditCIDR(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "grow", "10.0.0.0/29"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "grow", "server", "add", "srv", "vpn.example.com", "51820"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "grow", "node", "add", "n1", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "grow", "edit"); err == nil {
+		t.Error("vn edit without --cidr should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "grow", "edit", "--cidr", "10.0.0.0/30"); err == nil {
+		t.Error("shrinking the network should fail")
+	}
+
+	out, err := runCLI(t, "", "vn", "grow", "edit", "--cidr", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("vn edit --cidr error = %v", err)
+	}
+	if !strings.Contains(out, "10.0.0.0/24") || !strings.Contains(out, "Configuration version 1 saved") {
+		t.Errorf("vn edit --cidr output = %q", out)
+	}
+	if out, _ := runCLI(t, "", "vn", "list"); !strings.Contains(out, "10.0.0.0/24") {
+		t.Errorf("vn list after resize = %q", out)
+	}
+}
+
+func TestCLIDBTimeout(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "locked", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "locked", "node", "list", "--db-timeout", "2s"); err != nil {
+		t.Errorf("node list --db-timeout 2s error = %v", err)
+	}
+	if _, err := runCLI(t, "", "--db-timeout=2s", "vn", "list"); err != nil {
+		t.Errorf("--db-timeout=2s vn list error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "list", "--db-timeout", "0s"); err == nil {
+		t.Error("--db-timeout 0s should fail")
+	}
+
+	// Hold the database open as another process would.
+	holder, err := wedev.NewStorageManager(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer holder.Close()
+
+	_, err = runCLI(t, "", "vn", "locked", "node", "list", "--db-timeout=50ms")
+	if ExitCode(err) != ExitDBLocked || !strings.Contains(err.Error(), "locked by another wedevctl process") {
+		t.Errorf("node list on a locked database error = %v, want locked error", err)
+	}
+}
+
+func TestCLIReadOnlyCommands(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "ro", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ro", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ro", "config", "generate", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	// A reader, such as a monitoring cron job, holds the database open.
+	reader, err := wedev.OpenStorageReadOnly(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"))
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer reader.Close()
+
+	for _, args := range [][]string{
+		{"vn", "list"},
+		{"vn", "ro", "server", "list"},
+		{"--db-timeout", "50ms", "vn", "ro", "node", "list"},
+		{"vn", "ro", "config", "history"},
+		{"vn", "ro", "config", "show", "srv"},
+		{"db", "info"},
+	} {
+		if _, err := runCLI(t, "", append(args, "--db-timeout=50ms")...); err != nil {
+			t.Errorf("%v beside a reader error = %v", args, err)
+		}
+	}
+
+	_, err = runCLI(t, "", "vn", "ro", "node", "add", "n1", "route", "--db-timeout=50ms")
+	if !errors.Is(err, wedev.ErrDBLocked) {
+		t.Errorf("node add beside a reader error = %v, want locked error", err)
+	}
+}
+
+func TestCLIImportKeys(t *testing.T) {
+	useTempDB(t)
+
+	// RFC 7748 §6.1 X25519 test vectors (Alice and Bob).
+	const (
+		alicePrivate = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+		alicePublic  = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+		bobPublic    = "3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="
+	)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "keys", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "keys", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "private.key")
+	if err := os.WriteFile(keyFile, []byte(alicePrivate+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "keys", "node", "add", "alice", "route", "--key-file", keyFile)
+	if err != nil {
+		t.Fatalf("node add --key-file error = %v", err)
+	}
+	if !strings.Contains(out, "Public Key: "+alicePublic+" (imported)") {
+		t.Errorf("node add --key-file output = %q, want derived public key", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "keys", "node", "add", "bob", "peer", "203.0.113.7", "--public-key", bobPublic)
+	if err != nil {
+		t.Fatalf("node add --public-key error = %v", err)
+	}
+	if !strings.Contains(out, "managed outside wedevctl") {
+		t.Errorf("node add --public-key output = %q", out)
+	}
+
+	// Invalid or conflicting keys are rejected without creating the node.
+	for _, args := range [][]string{
+		{"--private-key", "short"},
+		{"--private-key", alicePrivate, "--public-key", bobPublic},
+		{"--private-key", alicePrivate, "--key-file", keyFile},
+		{"--public-key", alicePublic},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "keys", "node", "add", "carol", "route"}, args...)...); err == nil {
+			t.Errorf("node add %v should fail", args)
+		}
+	}
+	if out, _ := runCLI(t, "", "vn", "keys", "node", "list"); strings.Contains(out, "carol") {
+		t.Errorf("rejected key import left node behind: %q", out)
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "keys", "config", "generate", "--output-dir", outDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "bob.conf")); !os.IsNotExist(err) {
+		t.Errorf("config generate wrote bob.conf for an externally managed node (stat err %v)", err)
+	}
+	alice, err := os.ReadFile(filepath.Join(outDir, "alice.conf"))
+	if err != nil {
+		t.Fatalf("failed to read alice.conf: %v", err)
+	}
+	if !strings.Contains(string(alice), "PrivateKey = "+alicePrivate) {
+		t.Errorf("alice.conf = %q, want the imported private key", alice)
+	}
+	srv, err := os.ReadFile(filepath.Join(outDir, "srv.conf"))
+	if err != nil {
+		t.Fatalf("failed to read srv.conf: %v", err)
+	}
+	if !strings.Contains(string(srv), "PublicKey = "+bobPublic) {
+		t.Errorf("srv.conf = %q, want bob as a peer", srv)
+	}
+}
+
+func TestCLIConfigInfoRedaction(t *testing.T) {
+	useTempDB(t)
+
+	const nodePrivate = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	if _, err := runCLI(t, "y\n", "vn", "add", "sec", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "sec", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "sec", "node", "add", "n1", "route", "--private-key", nodePrivate); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "sec", "config", "generate", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "sec", "config", "info")
+	if err != nil {
+		t.Fatalf("config info error = %v", err)
+	}
+	if strings.Contains(out, nodePrivate) || strings.Count(out, "PrivateKey = (redacted)") != 2 {
+		t.Errorf("config info = %q, want both private keys redacted", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "sec", "config", "info", "1", "--show-secrets")
+	if err != nil {
+		t.Fatalf("config info --show-secrets error = %v", err)
+	}
+	if !strings.Contains(out, "PrivateKey = "+nodePrivate) || strings.Contains(out, "(redacted)") {
+		t.Errorf("config info --show-secrets = %q, want keys in clear", out)
+	}
+}
+
+func TestCLINodePorts(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "ports", "10.0.0.0/24", "--default-port", "51900"); err != nil {
+		t.Fatalf("vn add --default-port error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "ports", "node", "add", "n1", "peer", "host.example.com")
+	if err != nil || !strings.Contains(out, "host.example.com:51900") {
+		t.Fatalf("node add = %q, %v; want network default port 51900", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "ports", "node", "add", "n2", "peer", "host.example.com"); err == nil ||
+		!strings.Contains(err.Error(), `already used by node "n1"`) {
+		t.Errorf("node add with a duplicate endpoint error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ports", "node", "add", "n2", "peer", "host.example.com", "51901", "--auto-port"); err == nil {
+		t.Error("--auto-port with an explicit port should fail")
+	}
+
+	out, err = runCLI(t, "", "vn", "ports", "node", "add", "n2", "peer", "host.example.com", "--auto-port")
+	if err != nil || !strings.Contains(out, "host.example.com:51901") {
+		t.Errorf("node add --auto-port = %q, %v; want port 51901", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "ports", "node", "add", "n3", "peer", "host.example.com", "--auto-port", "--port-range", "52000-52010")
+	if err != nil || !strings.Contains(out, "host.example.com:52000") {
+		t.Errorf("node add --port-range = %q, %v; want port 52000", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "ports", "node", "add", "n4", "peer", "host.example.com", "--port-range", "52000-52010"); err == nil {
+		t.Error("--port-range without --auto-port should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "ports", "node", "add", "n4", "peer", "host.example.com", "51900", "--allow-duplicate-endpoint"); err != nil {
+		t.Errorf("node add --allow-duplicate-endpoint error = %v", err)
+	}
+
+	out, err = runCLI(t, "", "vn", "edit", "ports", "--default-port", "52100")
+	if err != nil || !strings.Contains(out, "Default Port: 52100") {
+		t.Fatalf("vn edit --default-port = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "ports", "node", "add", "n5", "route"); err != nil {
+		t.Fatalf("node add after default port change error = %v", err)
+	}
+	if out, _ := runCLI(t, "", "vn", "ports", "node", "list", "-o", "json"); !strings.Contains(out, `"port": 52100`) {
+		t.Errorf("node list = %q, want n5 on port 52100", out)
+	}
+}
+
+func TestCLILogFlags(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "logs", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	// captureStderr runs the CLI and returns what the logger wrote.
+	captureStderr := func(args ...string) string {
+		t.Helper()
+		app := &App{}
+		root := newRootCommand(app)
+		root.SetArgs(args)
+		var stderr bytes.Buffer
+		root.SetOut(io.Discard)
+		root.SetErr(&stderr)
+		if err := root.Execute(); err != nil {
+			app.close()
+			t.Fatalf("%v error = %v", args, err)
+		}
+		return stderr.String()
+	}
+
+	if out := captureStderr("vn", "logs", "node", "list", "--verbose"); !strings.Contains(out, "level=DEBUG") {
+		t.Errorf("vn <net> node list --verbose stderr = %q, want debug records", out)
+	}
+	if out := captureStderr("-v", "vn", "list"); !strings.Contains(out, "op=ListNetworks") {
+		t.Errorf("-v vn list stderr = %q, want transaction records", out)
+	}
+	if out := captureStderr("vn", "list", "--quiet"); out != "" {
+		t.Errorf("vn list --quiet stderr = %q, want nothing", out)
+	}
+	if out := captureStderr("vn", "list"); strings.Contains(out, "level=DEBUG") {
+		t.Errorf("vn list stderr = %q, want no debug records by default", out)
+	}
+
+	if _, err := runCLI(t, "", "vn", "logs", "node", "list", "-v", "-q"); err == nil {
+		t.Error("--verbose with --quiet should fail")
+	}
+}
+
+func TestCLIConfigGenerateOnlyAndShow(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "sel", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "sel", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	for _, name := range []string{"n1", "n2"} {
+		if _, err := runCLI(t, "", "vn", "sel", "node", "add", name, "route"); err != nil {
+			t.Fatalf("node add %s error = %v", name, err)
+		}
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "sel", "config", "generate", "--output-dir", outDir, "--only", "ghost"); err == nil {
+		t.Error("config generate --only with an unknown name should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "sel", "config", "generate", "--only", "n1", "--dry-run"); err == nil {
+		t.Error("config generate --only --dry-run should fail")
+	}
+
+	out, err := runCLI(t, "", "vn", "sel", "config", "generate", "--output-dir", outDir, "--only", "n1", "--only", "srv")
+	if err != nil {
+		t.Fatalf("config generate --only error = %v", err)
+	}
+	if !strings.Contains(out, "Configuration version 1 saved") {
+		t.Errorf("config generate --only output = %q, want a saved version", out)
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	var written []string
+	for _, e := range entries {
+		written = append(written, e.Name())
+	}
+	if !slices.Equal(written, []string{"n1.conf", "srv.conf"}) {
+		t.Errorf("config generate --only wrote %v, want [n1.conf srv.conf]", written)
+	}
+
+	// The saved version still covers every entity.
+	if out, _ := runCLI(t, "", "vn", "sel", "config", "info", "1"); !strings.Contains(out, "[n2.conf]") {
+		t.Errorf("config info after --only = %q, want n2.conf in the version", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "sel", "config", "show", "n2")
+	if err != nil {
+		t.Fatalf("config show error = %v", err)
+	}
+	if !strings.HasPrefix(out, "# network: sel, ") || !strings.Contains(out, "\n[Interface]\n") || !strings.Contains(out, "PrivateKey = ") || strings.Contains(out, "(redacted)") {
+		t.Errorf("config show = %q, want the raw config", out)
+	}
+	if _, err := runCLI(t, "", "vn", "sel", "config", "show", "ghost"); err == nil {
+		t.Error("config show with an unknown name should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "sel", "config", "info", "2"); err == nil {
+		t.Error("config show should not save a version")
+	}
+	if out, _ := runCLI(t, "", "vn", "sel", "config", "show", "n2", "--no-comments"); !strings.HasPrefix(out, "[Interface]\n") || strings.Contains(out, "#") {
+		t.Errorf("config show --no-comments = %q, want the config without comments", out)
+	}
+
+	// Comments are not content: writing without them saves no new version.
+	out, err = runCLI(t, "", "vn", "sel", "config", "generate", "--output-dir", outDir, "--force", "--no-comments")
+	if err != nil {
+		t.Fatalf("config generate --no-comments error = %v", err)
+	}
+	if !strings.Contains(out, "No changes detected") {
+		t.Errorf("config generate --no-comments output = %q, want no new version", out)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "n2.conf"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(data), "#") {
+		t.Errorf("n2.conf written with --no-comments = %q, want no comments", data)
+	}
+}
+
+func TestCLIConfigFilenameTemplate(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "fn", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "fn", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "fn", "node", "add", "n1", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	listDir := func(dir string) []string {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("os.ReadDir() error = %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "fn", "config", "generate", "--output-dir", outDir, "--filename-template", "wg-{{.Network}}-{{.Entity}}.conf"); err != nil {
+		t.Fatalf("config generate --filename-template error = %v", err)
+	}
+	if got := listDir(outDir); !slices.Equal(got, []string{"wg-fn-n1.conf", "wg-fn-srv.conf"}) {
+		t.Errorf("config generate --filename-template wrote %v", got)
+	}
+
+	if _, err := runCLI(t, "", "vn", "fn", "config", "generate", "--output-dir", t.TempDir(), "--filename-template", "../{{.Entity}}.conf"); err == nil {
+		t.Error("config generate with a path in the template should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "edit", "fn", "--filename-template", "{{.Bogus}}"); err == nil {
+		t.Error("vn edit with an invalid template should fail")
+	}
+
+	out, err := runCLI(t, "", "vn", "edit", "fn", "--filename-template", "{{.Type}}-{{.Entity}}.conf")
+	if err != nil {
+		t.Fatalf("vn edit --filename-template error = %v", err)
+	}
+	if !strings.Contains(out, "Filename Template: {{.Type}}-{{.Entity}}.conf") {
+		t.Errorf("vn edit output = %q, want the template", out)
+	}
+	outDir = t.TempDir()
+	if _, err := runCLI(t, "", "vn", "fn", "config", "generate", "--output-dir", outDir, "--force"); err != nil {
+		t.Fatalf("config generate with stored template error = %v", err)
+	}
+	if got := listDir(outDir); !slices.Equal(got, []string{"route-n1.conf", "server-srv.conf"}) {
+		t.Errorf("config generate with stored template wrote %v", got)
+	}
+}
+
+func TestCLIIPAuditRepair(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "ipn", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	for _, name := range []string{"n1", "n2"} {
+		if _, err := runCLI(t, "", "vn", "ipn", "node", "add", name, "route"); err != nil {
+			t.Fatalf("node add %s error = %v", name, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "ipn", "ip", "audit")
+	if err != nil {
+		t.Fatalf("ip audit on a clean network error = %v", err)
+	}
+	if !strings.Contains(out, "matches its records") {
+		t.Errorf("ip audit output = %q", out)
+	}
+
+	// Drop n2's allocation from the saved state, as an old bug could.
+	sm, err := wedev.NewStorageManager(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	network, err := sm.GetNetworkByName("ipn")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	n2, err := sm.GetNodeByName(network.ID, "n2")
+	if err != nil {
+		t.Fatalf("GetNodeByName() error = %v", err)
+	}
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	state.Allocated = slices.DeleteFunc(state.Allocated, func(ip string) bool { return ip == n2.VirtualIP })
+	if err := sm.SaveIPPoolState(network.ID, state); err != nil {
+		t.Fatalf("SaveIPPoolState() error = %v", err)
+	}
+	sm.Close()
+
+	out, err = runCLI(t, "", "vn", "ipn", "ip", "audit", "--output", "json")
+	if err == nil {
+		t.Error("ip audit with drift should exit with an error")
+	}
+	var report wedev.IPAuditReport
+	if jErr := json.Unmarshal([]byte(out), &report); jErr != nil {
+		t.Fatalf("ip audit json = %q: %v", out, jErr)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Kind != wedev.IPIssueMissingAllocation || report.Issues[0].IP != n2.VirtualIP {
+		t.Errorf("ip audit issues = %+v, want n2's allocation missing", report.Issues)
+	}
+
+	out, err = runCLI(t, "", "vn", "ipn", "ip", "repair")
+	if err != nil {
+		t.Fatalf("ip repair error = %v", err)
+	}
+	if !strings.Contains(out, "missing_allocation") || !strings.Contains(out, "rebuilt") {
+		t.Errorf("ip repair output = %q", out)
+	}
+	if _, err := runCLI(t, "", "vn", "ipn", "ip", "audit"); err != nil {
+		t.Errorf("ip audit after repair error = %v", err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "ipn", "ip", "audit", "--output", "xml"); err == nil {
+		t.Error("ip audit --output xml should fail")
+	}
+}
+
+func TestCLIMultipleServers(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "multi", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	// Scripts can list the servers of a network that has none yet.
+	if out, err := runCLI(t, "", "vn", "multi", "server", "list"); err != nil || out != "No servers found\n" {
+		t.Errorf("server list without servers = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "multi", "server", "list", "--output", "json"); err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("server list --output json without servers = %q, %v; want []", out, err)
+	}
+	for _, args := range [][]string{
+		{"server", "add", "hub1", "vpn1.example.com"},
+		{"server", "add", "hub2", "vpn2.example.com"},
+		{"node", "add", "a", "route"},
+		{"node", "add", "b", "route", "--server", "hub2", "--mesh-servers"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "multi"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "multi", "node", "add", "c", "route", "--server", "nope"); err == nil {
+		t.Error("node add --server with an unknown server should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "multi", "node", "edit", "c", "--port", "51821"); err == nil {
+		t.Error("node add with an unknown server should not leave the node behind")
+	}
+	if _, err := runCLI(t, "", "vn", "multi", "server", "info"); err == nil {
+		t.Error("server info without a name should fail with two servers")
+	}
+
+	out, err := runCLI(t, "", "vn", "multi", "server", "list", "--output", "json")
+	if err != nil {
+		t.Fatalf("server list error = %v", err)
+	}
+	var servers []serverListEntry
+	if err := json.Unmarshal([]byte(out), &servers); err != nil {
+		t.Fatalf("server list output is not JSON: %v\n%s", err, out)
+	}
+	if len(servers) != 2 || servers[0].Nodes != 1 || servers[1].Nodes != 1 {
+		t.Errorf("server list = %+v, want hub1 and hub2 with one node each", servers)
+	}
+
+	out, err = runCLI(t, "", "vn", "multi", "node", "list", "--output", "json")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	var nodes []nodeListEntry
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list output is not JSON: %v\n%s", err, out)
+	}
+	for _, node := range nodes {
+		if node.Name == "b" && (node.Server != "hub2" || !node.MeshServers) {
+			t.Errorf("node b = %+v, want server hub2 with mesh servers", node)
+		}
+	}
+
+	// Moving b back to the first server keeps its mesh setting.
+	if _, err := runCLI(t, "", "vn", "multi", "node", "edit", "b", "--server", ""); err != nil {
+		t.Fatalf("node edit --server error = %v", err)
+	}
+
+	outputDir := t.TempDir()
+	if _, err := runCLI(t, "y\n", "vn", "multi", "config", "generate", "--output-dir", outputDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	for _, name := range []string{"hub1", "hub2", "a", "b"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name+".conf")); err != nil {
+			t.Errorf("config for %s not written: %v", name, err)
+		}
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "multi", "server", "delete", "hub2"); err != nil {
+		t.Fatalf("server delete error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "multi", "server", "info")
+	if err != nil || !strings.Contains(out, "Server: hub1") {
+		t.Errorf("server info after delete = %q, %v", out, err)
+	}
+}
+
+func TestCLINetworkTopology(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "bad", "10.0.0.0/24", "--topology", "ring"); err == nil {
+		t.Error("vn add --topology ring should fail")
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "topo", "10.0.0.0/24", "--topology", "mesh"); err != nil {
+		t.Fatalf("vn add --topology mesh error = %v", err)
+	}
+	if out, _ := runCLI(t, "", "vn", "list", "-o", "json"); !strings.Contains(out, `"topology": "mesh"`) {
+		t.Errorf("vn list = %q, want topology mesh", out)
+	}
+
+	for _, args := range [][]string{
+		{"server", "add", "srv", "vpn.example.com"},
+		{"node", "add", "a", "peer", "203.0.113.1"},
+		{"node", "add", "b", "route"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "topo"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	if _, err := runCLI(t, "y\n", "vn", "topo", "config", "generate", "--output-dir", t.TempDir()); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "edit", "topo", "--topology", "hub-spoke")
+	if err != nil || !strings.Contains(out, "Topology: hub-spoke") {
+		t.Fatalf("vn edit --topology = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "edit", "topo", "--topology", "ring"); err == nil {
+		t.Error("vn edit --topology ring should fail")
+	}
+}
+
+func TestCLIClientNode(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "cl", "10.0.0.0/24"},
+		{"vn", "cl", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "cl", "node", "add", "laptop", "client"},
+		{"vn", "cl", "node", "add", "desk", "peer", "203.0.113.1"},
+	} {
+		if out, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v (out: %s)", args, err, out)
+		}
+	}
+	if out, _ := runCLI(t, "", "vn", "cl", "node", "list"); !strings.Contains(out, "client") {
+		t.Errorf("node list = %q, want the client type", out)
+	}
+
+	for _, args := range [][]string{
+		{"node", "add", "phone", "client", "203.0.113.2"},
+		{"node", "add", "phone", "roamer"},
+		{"node", "add", "phone", "client", "--route-cidr", "192.168.50.0/24"},
+		{"node", "edit", "desk", "--type", "client"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "cl"}, args...)...); err == nil {
+			t.Errorf("%v should fail", args)
+		}
+	}
+	out, err := runCLI(t, "", "vn", "cl", "node", "edit", "desk", "--type", "client", "--public-address", "")
+	if err != nil {
+		t.Fatalf("node edit --type client error = %v (out: %s)", err, out)
+	}
+	if out, _ := runCLI(t, "", "vn", "cl", "node", "info", "desk"); !strings.Contains(out, "client") {
+		t.Errorf("node info after the type change = %q, want client", out)
+	}
+}
+
+func TestCLIEntityHistory(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "hist", "10.0.0.0/24"},
+		{"vn", "hist", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "hist", "node", "add", "laptop", "peer", "203.0.113.1"},
+		{"vn", "hist", "node", "edit", "laptop", "--port", "51999"},
+		{"vn", "hist", "node", "rename", "laptop", "desk"},
+		{"vn", "hist", "server", "rename", "gateway"},
+	} {
+		if out, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v (out: %s)", args, err, out)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "hist", "node", "history", "desk")
+	if err != nil {
+		t.Fatalf("node history error = %v (out: %s)", err, out)
+	}
+	for _, want := range []string{"update", "port", "51820 -> 51999", "rename", "laptop -> desk"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("node history = %q, want it to contain %q", out, want)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "hist", "server", "history", "-o", "json")
+	if err != nil {
+		t.Fatalf("server history -o json error = %v (out: %s)", err, out)
+	}
+	var history []wedev.EntityRevision
+	if err := json.Unmarshal([]byte(out), &history); err != nil {
+		t.Fatalf("server history -o json = %q: %v", out, err)
+	}
+	if len(history) != 1 || history[0].Action != wedev.HistoryRename {
+		t.Errorf("server history = %+v, want the rename", history)
+	}
+
+	if out, _ := runCLI(t, "y\n", "vn", "hist", "node", "add", "fresh", "peer", "203.0.113.2"); !strings.Contains(out, "fresh") {
+		t.Fatalf("node add fresh output = %q", out)
+	}
+	if out, _ := runCLI(t, "", "vn", "hist", "node", "history", "fresh"); !strings.Contains(out, "No changes recorded") {
+		t.Errorf("node history of an unchanged node = %q, want No changes recorded", out)
+	}
+}
+
+func TestCLINetworkNATMode(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "bad", "10.0.0.0/24", "--nat-mode", "snat"); err == nil {
+		t.Error("vn add --nat-mode snat should fail")
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "nat", "10.0.0.0/24", "--nat-mode", "none"); err != nil {
+		t.Fatalf("vn add --nat-mode none error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"server", "add", "srv", "vpn.example.com"},
+		{"node", "add", "office", "route", "--route-cidr", "192.168.50.0/24"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "nat"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	if out, _ := runCLI(t, "", "vn", "nat", "info"); !strings.Contains(out, "NAT Mode: none") {
+		t.Errorf("vn info = %q, want NAT mode none", out)
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "y\n", "vn", "nat", "config", "generate", "--output-dir", outDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if out, _ := runCLI(t, "", "vn", "nat", "config", "show", "srv"); strings.Contains(out, "iptables") {
+		t.Errorf("server config in NAT mode none = %q, want no iptables rules", out)
+	}
+
+	out, err := runCLI(t, "", "vn", "edit", "nat", "--nat-mode", "masquerade")
+	if err != nil || !strings.Contains(out, "NAT Mode: masquerade") {
+		t.Fatalf("vn edit --nat-mode = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "nat", "config", "generate", "--output-dir", outDir, "--force"); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if out, _ := runCLI(t, "", "vn", "nat", "config", "show", "srv"); !strings.Contains(out, "PostUp = iptables -A FORWARD -i %i -d 192.168.50.0/24 -j ACCEPT") {
+		t.Errorf("server config in NAT mode masquerade = %q, want rules for the routed subnet", out)
+	}
+	if _, err := runCLI(t, "", "vn", "edit", "nat", "--nat-mode", "snat"); err == nil {
+		t.Error("vn edit --nat-mode snat should fail")
+	}
+}
+
+func TestCLIConfigMessage(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "msg", "10.0.0.0/24"},
+		{"vn", "msg", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "msg", "node", "add", "n1", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "y\n", "vn", "msg", "config", "generate", "--output-dir", t.TempDir(), "-m", "first rollout")
+	if err != nil {
+		t.Fatalf("config generate -m error = %v", err)
+	}
+	if !strings.Contains(out, "Message: first rollout (servers: +srv; nodes: +n1)") {
+		t.Errorf("config generate output = %q", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "msg", "config", "history")
+	if err != nil || !strings.Contains(out, "first rollout") {
+		t.Errorf("config history = %q, %v; want the message", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "msg", "config", "info", "1")
+	if err != nil || !strings.Contains(out, "Message: first rollout") {
+		t.Errorf("config info = %q, %v; want the message", out, err)
+	}
+}
+
+func TestCLINodeExpiry(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "temp", "10.0.0.0/24"},
+		{"vn", "temp", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "temp", "node", "add", "gone", "route", "--expires", "2000-01-01"},
+		{"vn", "temp", "node", "add", "soon", "route", "--ttl", "1h"},
+		{"vn", "temp", "node", "add", "perm", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"node", "add", "bad", "route", "--expires", "next week"},
+		{"node", "add", "bad", "route", "--ttl", "-1h"},
+		{"node", "add", "bad", "route", "--ttl", "1h", "--expires", "2030-01-01"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "temp"}, args...)...); err == nil {
+			t.Errorf("%v should fail", args)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "temp", "node", "list", "--expired")
+	if err != nil || !strings.Contains(out, "gone") || strings.Contains(out, "soon") || strings.Contains(out, "perm") {
+		t.Errorf("node list --expired = %q, %v; want only gone", out, err)
+	}
+	if !strings.Contains(out, "2000-01-01 00:00 (expired)") {
+		t.Errorf("node list --expired = %q, want the expiry marked", out)
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "y\n", "vn", "temp", "config", "generate", "--output-dir", outDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "gone.conf")); !os.IsNotExist(err) {
+		t.Errorf("config generate wrote a config for the expired node (stat err %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "soon.conf")); err != nil {
+		t.Errorf("config generate skipped the unexpired node: %v", err)
+	}
+
+	if out, _ := runCLI(t, "n\n", "vn", "temp", "node", "purge-expired"); !strings.Contains(out, "Cancelled") {
+		t.Errorf("purge-expired declined = %q, want Cancelled", out)
+	}
+	out, err = runCLI(t, "y\n", "vn", "temp", "node", "purge-expired")
+	if err != nil || !strings.Contains(out, "Deleted node 'gone'") || !strings.Contains(out, "Purged 1 expired node(s)") {
+		t.Fatalf("purge-expired = %q, %v", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "temp", "node", "purge-expired"); !strings.Contains(out, "No expired nodes") {
+		t.Errorf("second purge-expired = %q, want nothing to purge", out)
+	}
+
+	out, err = runCLI(t, "", "vn", "temp", "node", "edit", "soon", "--expires", "")
+	if err != nil || strings.Contains(out, "Expires:") {
+		t.Errorf("node edit --expires \"\" = %q, %v; want the expiry removed", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "temp", "node", "list", "-o", "json")
+	if err != nil || strings.Contains(out, "expires_at") || strings.Contains(out, `"gone"`) {
+		t.Errorf("node list = %q, %v; want no expiries and gone purged", out, err)
+	}
+}
+
+func TestCLIYAMLOutput(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "one", "10.0.0.0/24", "--label", "zone=b", "--label", "env=prod"},
+		{"vn", "add", "two", "10.1.0.0/24"},
+		{"vn", "one", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "one", "node", "add", "n1", "route", "--label", "role=web"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	if _, err := runCLI(t, "y\n", "vn", "one", "config", "generate", "--output-dir", t.TempDir(), "-m", "initial"); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "list", "-o", "yaml")
+	if err != nil {
+		t.Fatalf("vn list -o yaml error = %v", err)
+	}
+	if !strings.Contains(out, "labels:\n  env: prod\n  zone: b\n") {
+		t.Errorf("vn list -o yaml = %q, want labels with sorted keys", out)
+	}
+	dec := yaml.NewDecoder(strings.NewReader(out))
+	var names []string
+	for {
+		var doc map[string]any
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("vn list -o yaml is not valid YAML: %v\n%s", err, out)
+		}
+		names = append(names, doc["name"].(string))
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"one", "two"}) {
+		t.Errorf("vn list -o yaml documents = %v, want one per network", names)
+	}
+
+	out, err = runCLI(t, "", "vn", "one", "node", "list", "--output", "yaml")
+	if err != nil {
+		t.Fatalf("node list -o yaml error = %v", err)
+	}
+	var nodes []map[string]any
+	if err := yaml.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list -o yaml is not valid YAML: %v\n%s", err, out)
+	}
+	if len(nodes) != 1 || nodes[0]["name"] != "n1" || nodes[0]["virtual_ip"] == nil {
+		t.Errorf("node list -o yaml = %v, want n1 with JSON field names", nodes)
+	}
+
+	out, err = runCLI(t, "", "vn", "one", "config", "history", "-o", "yaml")
+	if err != nil {
+		t.Fatalf("config history -o yaml error = %v", err)
+	}
+	var history []map[string]any
+	if err := yaml.Unmarshal([]byte(out), &history); err != nil {
+		t.Fatalf("config history -o yaml is not valid YAML: %v\n%s", err, out)
+	}
+	if len(history) != 1 || history[0]["content_hash"] == nil || !strings.HasPrefix(history[0]["message"].(string), "initial") {
+		t.Errorf("config history -o yaml = %v, want one version with its message", history)
+	}
+	configs, _ := history[0]["configs"].(map[string]any)
+	if !strings.Contains(configs["srv"].(string), "\n[Interface]\n") {
+		t.Errorf("config history -o yaml configs = %v, want the config text", configs)
+	}
+	if strings.Index(out, "    n1: ") > strings.Index(out, "    srv: ") {
+		t.Errorf("config history -o yaml = %q, want configs keys sorted", out)
+	}
+
+	if _, err := runCLI(t, "", "vn", "list", "-o", "xml"); err == nil || !strings.Contains(err.Error(), "'yaml'") {
+		t.Errorf("vn list -o xml error = %v, want the formats listed", err)
+	}
+}
+
+func TestCLIApply(t *testing.T) {
+	useTempDB(t)
+
+	specPath := filepath.Join(t.TempDir(), "office.yaml")
+	writeSpec := func(spec string) {
+		t.Helper()
+		if err := os.WriteFile(specPath, []byte(spec), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	writeSpec(`name: office
+cidr: 10.8.0.0/24
+dns: [10.8.0.1]
+server: {name: hub, public_address: vpn.example.com}
+nodes:
+  - {name: laptop, public_address: 203.0.113.7, labels: {team: ops}}
+  - {name: branch, type: route, routed_cidrs: [192.168.10.0/24]}
+`)
+
+	out, err := runCLI(t, "", "apply", "-f", specPath, "--dry-run")
+	if err != nil || !strings.Contains(out, "+ node laptop") {
+		t.Fatalf("apply --dry-run = %q, %v; want the plan", out, err)
+	}
+	if !strings.Contains(out, "Configuration version 1 would be saved") || !strings.Contains(out, "Configs that would change: branch, hub, laptop") {
+		t.Errorf("apply --dry-run = %q, want the version it would save", out)
+	}
+	if out, _ := runCLI(t, "", "vn", "list"); strings.Contains(out, "office") {
+		t.Error("apply --dry-run created the network")
+	}
+	if _, err := runCLI(t, "", "apply", "-f", specPath); err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Errorf("apply without --yes error = %v, want it to ask for --yes", err)
+	}
+
+	out, err = runCLI(t, "", "apply", "-f", specPath, "--yes")
+	if err != nil || !strings.Contains(out, "Applied 4 change(s)") || !strings.Contains(out, "Configuration version 1 saved") {
+		t.Fatalf("apply --yes = %q, %v", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "office", "config", "show", "laptop"); !strings.Contains(out, "DNS = 10.8.0.1") {
+		t.Errorf("laptop config = %q, want the spec's DNS", out)
+	}
+	if out, err := runCLI(t, "", "apply", "-f", specPath, "--yes"); err != nil || !strings.Contains(out, "already matches") {
+		t.Errorf("second apply = %q, %v; want no changes", out, err)
+	}
+
+	// Without --prune a node dropped from the spec is only reported.
+	spec := `name: office
+cidr: 10.8.0.0/24
+dns: [10.8.0.1]
+server: {name: hub, public_address: vpn.example.com}
+nodes:
+  - {name: laptop, public_address: 203.0.113.7, labels: {team: dev}}
+`
+	out, err = runCLI(t, spec, "apply", "-f", "-", "--dry-run")
+	if err != nil || !strings.Contains(out, "Configurations would be unchanged") {
+		t.Errorf("apply --dry-run of a label change = %q, %v; want unchanged configs", out, err)
+	}
+	out, err = runCLI(t, spec, "apply", "-f", "-", "--yes")
+	if err != nil || !strings.Contains(out, "~ node laptop (labels: {team=ops} -> {team=dev})") || !strings.Contains(out, "use --prune to delete): node branch") {
+		t.Fatalf("apply from stdin = %q, %v", out, err)
+	}
+	out, err = runCLI(t, spec, "apply", "-f", "-", "--yes", "--prune", "-m", "drop branch")
+	if err != nil || !strings.Contains(out, "- node branch") || !strings.Contains(out, "Message: drop branch") {
+		t.Fatalf("apply --prune = %q, %v", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "office", "node", "list"); strings.Contains(out, "branch") {
+		t.Errorf("node list after prune = %q", out)
+	}
+
+	if _, err := runCLI(t, "name: office\ncidr: 10.8.0.0/24\nnodes: [{name: a, type: route, private_key: x}]", "apply", "-f", "-", "--yes"); err == nil {
+		t.Error("apply with a private key in the spec should fail")
+	}
+	if _, err := runCLI(t, "", "apply", "--yes"); err == nil {
+		t.Error("apply without -f should fail")
+	}
+}
+
+func TestCLIVNClone(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "prod", "10.8.0.0/24", "--label", "env=prod"},
+		{"vn", "prod", "server", "add", "hub", "vpn.example.com"},
+		{"vn", "prod", "node", "add", "laptop", "peer", "203.0.113.7", "51821"},
+		{"vn", "prod", "node", "add", "branch", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "clone", "prod", "staging", "--cidr", "10.9.0.0/24", "--clear-addresses")
+	if err != nil {
+		t.Fatalf("vn clone error = %v", err)
+	}
+	for _, want := range []string{"cloned from 'prod'", "CIDR: 10.9.0.0/24", "Servers: 1, Nodes: 2", "Set public addresses before generating configs: hub, laptop"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("vn clone output = %q, want %q", out, want)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "staging", "node", "list", "-o", "json")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	var nodes []nodeListEntry
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list output is not JSON: %v", err)
+	}
+	ips := map[string]string{}
+	for _, n := range nodes {
+		ips[n.Name] = n.VirtualIP
+		if n.PublicAddress != "" {
+			t.Errorf("node %s kept address %q", n.Name, n.PublicAddress)
+		}
+	}
+	if ips["laptop"] != "10.9.0.2" || ips["branch"] != "10.9.0.3" {
+		t.Errorf("cloned node IPs = %v, want the source offsets in 10.9.0.0/24", ips)
+	}
+
+	if _, err := runCLI(t, "", "vn", "clone", "prod", "staging"); err == nil {
+		t.Error("cloning onto an existing network should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "clone", "prod", "tiny", "--cidr", "10.9.0.0/30"); err == nil {
+		t.Error("cloning into a too small CIDR should fail")
+	}
+	if out, _ := runCLI(t, "", "vn", "list"); strings.Contains(out, "tiny") {
+		t.Errorf("vn list = %q, want no partial copy", out)
+	}
+}
+
+func TestCLIVNImportWG(t *testing.T) {
+	useTempDB(t)
+	srcDir := filepath.Join(t.TempDir(), "src")
+	for _, args := range [][]string{
+		{"vn", "add", "prod", "10.8.0.0/24"},
+		{"vn", "prod", "server", "add", "hub", "vpn.example.com"},
+		{"vn", "prod", "node", "add", "laptop", "peer", "203.0.113.7", "51821"},
+		{"vn", "prod", "node", "add", "branch", "route", "--route-cidr", "192.168.10.0/24"},
+		{"vn", "prod", "config", "generate", "--output-dir", srcDir},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	conf := func(name string) string { return filepath.Join(srcDir, name+".conf") }
+	out, err := runCLI(t, "", "vn", "import-wg", "copy", "--server-conf", conf("hub"), "--node-conf", conf("laptop"), "--node-conf", conf("branch"))
+	if err != nil {
+		t.Fatalf("vn import-wg error = %v", err)
+	}
+	for _, want := range []string{"Virtual network 'copy' imported from 3 config file(s)", "CIDR: 10.8.0.0/24", "Servers: 1, Nodes: 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("vn import-wg output = %q, want %q", out, want)
+		}
+	}
+
+	// The imported network generates the configs it was imported from.
+	dstDir := filepath.Join(t.TempDir(), "dst")
+	if _, err := runCLI(t, "", "vn", "copy", "config", "generate", "--output-dir", dstDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	for _, name := range []string{"hub", "laptop", "branch"} {
+		src, err := os.ReadFile(conf(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.ReadFile(filepath.Join(dstDir, name+".conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, srcBody, _ := strings.Cut(string(src), "\n")
+		_, dstBody, _ := strings.Cut(string(dst), "\n")
+		if srcBody != dstBody {
+			t.Errorf("%s.conf of the import differs:\n%s\nwant:\n%s", name, dstBody, srcBody)
+		}
+	}
+
+	bad := filepath.Join(t.TempDir(), "wg0.conf")
+	if err := os.WriteFile(bad, []byte("[Interface]\nAddress = 10.1.0.1/24, 10.2.0.1/24\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = runCLI(t, "", "vn", "import-wg", "other", "--server-conf", bad)
+	if err == nil || !strings.Contains(err.Error(), "wg0.conf: line 2: [Interface] has 2 addresses") {
+		t.Errorf("importing a config with two addresses error = %v, want it reported with its line", err)
+	}
+	if got := ExitCode(err); got != ExitValidation {
+		t.Errorf("exit code = %d, want %d", got, ExitValidation)
+	}
+}
+
+func TestCLIInternalEndpoints(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "dc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "dc", "server", "add", "hub", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	for _, name := range []string{"db1", "db2"} {
+		if _, err := runCLI(t, "", "vn", "dc", "node", "add", name, "route"); err != nil {
+			t.Fatalf("node add %s error = %v", name, err)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "dc", "server", "edit", "--internal-port", "51830"); err == nil {
+		t.Error("server edit --internal-port without an address should fail")
+	}
+	out, err := runCLI(t, "", "vn", "dc", "server", "edit", "--internal-address", "10.10.0.5")
+	if err != nil {
+		t.Fatalf("server edit --internal-address error = %v", err)
+	}
+	if !strings.Contains(out, "Internal Endpoint: 10.10.0.5:51820") || !strings.Contains(out, "Public Address: vpn.example.com:51820") {
+		t.Errorf("server edit output = %q", out)
+	}
+	out, err = runCLI(t, "", "vn", "dc", "node", "edit", "db2", "--prefer-internal")
+	if err != nil {
+		t.Fatalf("node edit --prefer-internal error = %v", err)
+	}
+	if !strings.Contains(out, "Prefer Internal: yes") {
+		t.Errorf("node edit output = %q", out)
+	}
+
+	for name, want := range map[string]string{
+		"db1": "Endpoint = vpn.example.com:51820\n",
+		"db2": "Endpoint = 10.10.0.5:51820\n",
+	} {
+		out, err := runCLI(t, "", "vn", "dc", "config", "show", name)
+		if err != nil {
+			t.Fatalf("config show %s error = %v", name, err)
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("%s config = %q, want %q", name, out, want)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "dc", "server", "edit", "--internal-address", ""); err != nil {
+		t.Fatalf("server edit --internal-address \"\" error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "dc", "config", "show", "db2")
+	if err != nil {
+		t.Fatalf("config show db2 error = %v", err)
+	}
+	if !strings.Contains(out, "Endpoint = vpn.example.com:51820\n") {
+		t.Errorf("db2 config after clearing the internal address = %q", out)
+	}
+}
+
+func TestCLINetworkValidate(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "val", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "server", "add", "hub", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "node", "add", "a", "peer", "203.0.113.1", "51821"); err != nil {
+		t.Fatalf("node add a error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "node", "add", "b", "peer", "203.0.113.2", "51821"); err != nil {
+		t.Fatalf("node add b error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "val", "validate")
+	if err != nil || !strings.Contains(out, "Network val passed validation") {
+		t.Fatalf("validate = %q, %v; want a clean network", out, err)
+	}
+
+	// Moving b onto a's endpoint is refused, unless allowed.
+	if _, err := runCLI(t, "", "vn", "val", "node", "edit", "b", "--public-address", "203.0.113.1"); ExitCode(err) != ExitAlreadyExists || !strings.Contains(err.Error(), `already used by node "a"`) {
+		t.Errorf("node edit onto a used endpoint error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "node", "edit", "b", "--public-address", "203.0.113.1", "--allow-duplicate-endpoint"); err != nil {
+		t.Fatalf("node edit --allow-duplicate-endpoint onto a used endpoint error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "server", "add", "hub2", "vpn.example.com", "--strict"); err == nil {
+		t.Error("server add --strict onto the hub's endpoint should fail")
+	}
+
+	out, err = runCLI(t, "", "vn", "val", "validate")
+	if err != nil {
+		t.Fatalf("validate with a warning error = %v", err)
+	}
+	if !strings.Contains(out, "duplicate_endpoint") || !strings.Contains(out, "endpoint 203.0.113.1:51821 is used by node a, node b") {
+		t.Errorf("validate output = %q", out)
+	}
+	if _, err := runCLI(t, "", "vn", "val", "validate", "--strict"); err == nil || !strings.Contains(err.Error(), "0 error(s) and 1 warning(s)") {
+		t.Errorf("validate --strict error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "val", "validate", "-o", "json")
+	if err != nil || !strings.Contains(out, `"severity": "warning"`) {
+		t.Errorf("validate -o json = %q, %v", out, err)
+	}
+}
+
+func TestCLINodeFullTunnel(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "ft", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ft", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	out, err := runCLI(t, "", "vn", "ft", "node", "add", "laptop", "route", "--full-tunnel")
+	if err != nil {
+		t.Fatalf("node add --full-tunnel error = %v", err)
+	}
+	if !strings.Contains(out, "Full Tunnel: yes") {
+		t.Errorf("node add output = %q", out)
+	}
+	if _, err := runCLI(t, "", "vn", "ft", "node", "add", "desktop", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "ft", "config", "generate", "--output-dir", outDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	for name, want := range map[string]string{
+		"laptop":  "AllowedIPs = 0.0.0.0/0, ::/0\n",
+		"desktop": "AllowedIPs = 10.0.0.0/24\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(outDir, name+".conf"))
+		if err != nil {
+			t.Fatalf("failed to read %s.conf: %v", name, err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s.conf = %q, want %q", name, data, want)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "ft", "node", "edit", "laptop", "--full-tunnel=false"); err != nil {
+		t.Fatalf("node edit --full-tunnel=false error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "ft", "node", "list", "--output", "json")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	if strings.Contains(out, "full_tunnel") {
+		t.Errorf("node list after --full-tunnel=false = %q", out)
+	}
+}
+
+// TestCLIExitCodes checks that common failures map to their documented exit
+// codes.
+func TestCLIExitCodes(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "codes", "10.0.0.0/30"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "codes", "server", "add", "hub", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "codes", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  int
+	}{
+		{"success", "", []string{"vn", "codes", "node", "list"}, ExitOK},
+		{"missing network", "", []string{"vn", "missing", "node", "list"}, ExitNotFound},
+		{"missing node", "", []string{"vn", "codes", "node", "rename", "missing", "other"}, ExitNotFound},
+		{"duplicate network", "y\n", []string{"vn", "add", "codes", "10.1.0.0/24"}, ExitAlreadyExists},
+		{"invalid CIDR", "y\n", []string{"vn", "add", "bad", "10.0.0.0/33"}, ExitValidation},
+		{"pool exhausted", "", []string{"vn", "codes", "node", "add", "b", "route"}, ExitPoolExhausted},
+	}
+	for _, tt := range tests {
+		_, err := runCLI(t, tt.stdin, tt.args...)
+		if got := ExitCode(err); got != tt.want {
+			t.Errorf("%s: ExitCode(%v) = %d, want %d", tt.name, err, got, tt.want)
+		}
+	}
+}
+
+func TestCLINodeLimitAndPoolUsage(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "bad", "10.0.0.0/29", "--max-nodes", "-1"); err == nil {
+		t.Error("vn add --max-nodes -1 should fail")
+	}
+	if _, err := runCLI(t, "y\n", "vn", "add", "small", "10.0.0.0/29", "--max-nodes", "4", "--pool-warn-percent", "50"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "small", "node", "add", "a", "route")
+	if err != nil || !strings.Contains(out, "Addresses: 2 of 6 addresses used") {
+		t.Fatalf("node add = %q, %v; want the pool utilization", out, err)
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		if _, err := runCLI(t, "", "vn", "small", "node", "add", name, "route"); err != nil {
+			t.Fatalf("node add %s error = %v", name, err)
+		}
+	}
+	if _, err := runCLI(t, "", "vn", "small", "node", "add", "e", "route"); err == nil || !strings.Contains(err.Error(), "limit of 4 nodes") {
+		t.Errorf("node add past --max-nodes error = %v, want the node limit", err)
+	}
+
+	// info reports the usage and warns on stderr past the threshold.
+	app := &App{}
+	root := newRootCommand(app)
+	root.SetArgs([]string{"vn", "small", "info"})
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+	if err := root.Execute(); err != nil {
+		app.close()
+		t.Fatalf("vn info error = %v", err)
+	}
+	for _, want := range []string{"Nodes: 4 (4 route)", "Max Nodes: 4", "Addresses: 5 of 6 addresses used (83%)", "Pool Warning: 50%"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("vn info = %q, want %q", stdout.String(), want)
+		}
+	}
+	if !strings.Contains(stderr.String(), "Warning: IP pool of network 'small' is 83% used") {
+		t.Errorf("vn info stderr = %q, want a pool warning", stderr.String())
+	}
+
+	out, err = runCLI(t, "", "vn", "edit", "small", "--max-nodes", "0", "--pool-warn-percent", "0")
+	if err != nil || strings.Contains(out, "Max Nodes") || !strings.Contains(out, "Pool Warning: 90%") {
+		t.Errorf("vn edit --max-nodes 0 = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "small", "node", "add", "e", "route"); err != nil {
+		t.Errorf("node add without a limit error = %v", err)
+	}
+
+	// A rejected flag keeps the others in the same edit from being saved.
+	if _, err := runCLI(t, "", "vn", "edit", "small", "--default-port", "51900", "--label", "team=ops", "--max-nodes", "2"); err == nil || !strings.Contains(err.Error(), "more than the limit of 2") {
+		t.Errorf("vn edit --max-nodes 2 error = %v, want the node count rejected", err)
+	}
+	out, err = runCLI(t, "", "vn", "small", "info")
+	if err != nil || strings.Contains(out, "51900") || strings.Contains(out, "team=ops") {
+		t.Errorf("vn info after a rejected edit = %q, %v; want no changes saved", out, err)
+	}
+}
+
+func TestCLIConfigArchive(t *testing.T) {
+	useTempDB(t)
+	dir := t.TempDir()
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "arc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"server", "add", "srv", "vpn.example.com"},
+		{"node", "add", "a", "route"},
+		{"node", "add", "b", "route"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "arc"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	archive := filepath.Join(dir, "arc.tar.gz")
+	out, err := runCLI(t, "", "vn", "arc", "config", "generate", "--archive", archive, "--per-entity")
+	if err != nil || !strings.Contains(out, "Archived 3 config(s)") || !strings.Contains(out, "version 1 saved") {
+		t.Fatalf("config generate --archive = %q, %v", out, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("output dir holds %v, %v; want only the archive", entries, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "arc", "config", "generate", "--archive", filepath.Join(dir, "arc.rar")); err == nil {
+		t.Error("config generate --archive with an unknown extension should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "arc", "config", "generate", "--per-entity"); err == nil {
+		t.Error("config generate --per-entity without --archive should fail")
+	}
+	// An existing archive is only replaced once confirmed.
+	if out, err := runCLI(t, "n\n", "vn", "arc", "config", "generate", "--archive", archive); err != nil || !strings.Contains(out, "Cancelled") {
+		t.Errorf("config generate over an existing archive = %q, %v; want it cancelled", out, err)
+	}
+
+	zipPath := filepath.Join(dir, "v1.zip")
+	out, err = runCLI(t, "", "vn", "arc", "config", "export", "1", "--archive", zipPath)
+	if err != nil || !strings.Contains(out, "Archived 3 config(s) of version 1") {
+		t.Fatalf("config export = %q, %v", out, err)
+	}
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("zip.OpenReader() error = %v", err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"README", "a.conf", "b.conf", "srv.conf"}; !slices.Equal(names, want) {
+		t.Errorf("exported archive holds %v, want %v", names, want)
+	}
+
+	if _, err := runCLI(t, "", "vn", "arc", "config", "export", "9", "--archive", filepath.Join(dir, "v9.zip")); err == nil {
+		t.Error("config export of a missing version should fail")
+	}
+	if _, err := runCLI(t, "", "vn", "arc", "config", "export", "1"); err == nil {
+		t.Error("config export without --archive should fail")
+	}
+}
+
+func TestCLIConfigGenerateNoServer(t *testing.T) {
+	useTempDB(t)
+	dir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"vn", "add", "hub", "10.0.0.0/24"},
+		{"vn", "hub", "node", "add", "a", "peer", "203.0.113.1"},
+		{"vn", "add", "mesh", "10.1.0.0/24", "--topology", "mesh"},
+		{"vn", "mesh", "node", "add", "a", "peer", "203.0.113.1"},
+		{"vn", "mesh", "node", "add", "b", "route"},
+	} {
+		if out, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v (out: %s)", args, err, out)
+		}
+	}
+
+	_, err := runCLI(t, "", "vn", "hub", "config", "generate", "--output-dir", dir, "--no-perm-check")
+	if err == nil || !strings.Contains(err.Error(), `network "hub" has no server`) || !strings.Contains(err.Error(), "server add") {
+		t.Errorf("config generate without a server error = %v, want the network named with a server add hint", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "mesh", "config", "generate", "--output-dir", dir, "--no-perm-check")
+	if err != nil {
+		t.Fatalf("config generate of a serverless mesh error = %v (out: %s)", err, out)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if !slices.Equal(files, []string{"a.conf", "b.conf"}) {
+		t.Errorf("config generate wrote %v, want only the node configs", files)
+	}
+	if !strings.Contains(out, "version 1") {
+		t.Errorf("config generate output = %q, want version 1 saved", out)
+	}
+}
+
+func TestCLIConfigGenerateStdout(t *testing.T) {
+	useTempDB(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "so", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"server", "add", "srv", "vpn.example.com"},
+		{"node", "add", "a", "route"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "so"}, args...)...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	// --no-save streams without saving a version.
+	stdout, stderr, err := runCLIStderr(t, "", "vn", "so", "config", "generate", "--stdout", "--no-save")
+	if err != nil {
+		t.Fatalf("config generate --stdout --no-save error = %v", err)
+	}
+	if !strings.HasPrefix(stdout, "# --- a.conf ---\n# network: so, ") || !strings.Contains(stdout, "\n# --- srv.conf ---\n") {
+		t.Errorf("config generate --stdout = %q, want both configs with separators", stdout)
+	}
+	if stderr != "" {
+		t.Errorf("config generate --stdout --no-save stderr = %q, want nothing", stderr)
+	}
+	if out, _ := runCLI(t, "", "vn", "so", "config", "history"); !strings.Contains(out, "No configuration versions") {
+		t.Errorf("config history after --no-save = %q, want no versions", out)
+	}
+
+	// The tar stream holds only the configs; the version is saved.
+	stdout, stderr, err = runCLIStderr(t, "", "vn", "so", "config", "generate", "--stdout", "--format", "tar", "--only", "srv")
+	if err != nil {
+		t.Fatalf("config generate --stdout --format tar error = %v", err)
+	}
+	if !strings.Contains(stderr, "Configuration version 1 saved") {
+		t.Errorf("config generate --stdout stderr = %q, want the saved version", stderr)
+	}
+	tr := tar.NewReader(strings.NewReader(stdout))
+	header, err := tr.Next()
+	if err != nil || header.Name != "srv.conf" || header.Mode != 0o600 {
+		t.Fatalf("tar stream first entry = %+v, %v; want srv.conf readable by its owner", header, err)
+	}
+	if _, err := tr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("tar stream next entry error = %v, want only srv.conf", err)
+	}
+
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("working directory holds %v, %v; want nothing written", entries, err)
+	}
+
+	for _, args := range [][]string{
+		{"--stdout", "--format", "zip"},
+		{"--stdout", "--output-dir", dir},
+		{"--stdout", "--archive", filepath.Join(dir, "x.zip")},
+		{"--format", "tar"},
+		{"--no-save"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "so", "config", "generate"}, args...)...); err == nil {
+			t.Errorf("config generate %v should fail", args)
+		}
+	}
+}
+
+func TestCLIConfigGenerateOutputDirPerms(t *testing.T) {
+	useTempDB(t)
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := os.Mkdir(outDir, 0o755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := os.Chmod(outDir, 0o755); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"vn", "add", "perm", "10.0.0.0/24"},
+		{"vn", "perm", "server", "add", "srv", "vpn.example.com"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	_, stderr, err := runCLIStderr(t, "", "vn", "perm", "config", "generate", "--output-dir", outDir)
+	if err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if !strings.Contains(stderr, "Warning: output directory "+outDir+" is readable by group or others (mode 0755)") {
+		t.Errorf("config generate stderr = %q, want a permission warning", stderr)
+	}
+
+	_, stderr, err = runCLIStderr(t, "", "vn", "perm", "config", "generate", "--output-dir", outDir, "--force", "--no-perm-check")
+	if err != nil || strings.Contains(stderr, "Warning") {
+		t.Errorf("config generate --no-perm-check stderr = %q, %v; want no warning", stderr, err)
+	}
+
+	if err := os.Chmod(outDir, 0o700); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	_, stderr, err = runCLIStderr(t, "", "vn", "perm", "config", "generate", "--output-dir", outDir, "--force")
+	if err != nil || strings.Contains(stderr, "Warning") {
+		t.Errorf("config generate into a private directory stderr = %q, %v; want no warning", stderr, err)
+	}
+}
+
+func TestCLINodeBundleAndJoin(t *testing.T) {
+	useTempDB(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "laptop.tar.gz")
+
+	for _, args := range [][]string{
+		{"vn", "add", "join", "10.0.0.0/24"},
+		{"vn", "join", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "join", "node", "add", "laptop", "client"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "join", "node", "bundle", "laptop", "--file", bundle, "--interface", "wg0")
+	if err != nil || !strings.Contains(out, "Bundled node 'laptop' as interface 'wg0' to "+bundle) {
+		t.Fatalf("node bundle = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "n\n", "vn", "join", "node", "bundle", "laptop", "--file", bundle); err != nil {
+		t.Errorf("declined bundle overwrite error = %v", err)
+	}
+
+	// join needs no database: point it at one that cannot be opened.
+	configDir := filepath.Join(dir, "wireguard")
+	out, err = runCLI(t, "", "join", bundle, "--config-dir", configDir, "--dry-run", "--db", filepath.Join(dir, "missing", "dir", "x.db"))
+	if err != nil {
+		t.Fatalf("join --dry-run error = %v", err)
+	}
+	for _, want := range []string{"Bundle of node 'laptop' of network 'join' (SHA-256 verified)", "Would write: " + filepath.Join(configDir, "wg0.conf")} {
+		if !strings.Contains(out, want) {
+			t.Errorf("join --dry-run output missing %q:\n%s", want, out)
+		}
+	}
+	if _, err := os.Stat(configDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("join --dry-run created %s: %v", configDir, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("join opened a database: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"vn", "join", "node", "bundle", "srv", "--file", filepath.Join(dir, "srv.tar.gz")},
+		{"vn", "join", "node", "bundle", "laptop", "--file", filepath.Join(dir, "laptop.rar")},
+		{"vn", "join", "node", "bundle", "laptop"},
+		{"join", filepath.Join(dir, "absent.tar.gz"), "--dry-run"},
+	} {
+		if _, err := runCLI(t, "", args...); err == nil {
+			t.Errorf("%v should fail", args)
+		}
+	}
+}
+
+func TestCLIFirewall(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "fw", "10.0.0.0/24"},
+		{"vn", "fw", "server", "add", "hub", "vpn.example.com", "51820"},
+		{"vn", "fw", "node", "add", "nas", "peer", "198.51.100.7", "51821"},
+		{"vn", "fw", "node", "add", "backup", "peer", "198.51.100.7", "51822"},
+		{"vn", "fw", "node", "add", "phone", "client"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "fw", "firewall")
+	if err != nil {
+		t.Fatalf("firewall error = %v", err)
+	}
+	for _, want := range []string{"198.51.100.7", "51821/udp", "nas (peer)", "51822/udp", "vpn.example.com", "hub (server)", "No inbound required: phone (client)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("firewall output missing %q:\n%s", want, out)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "fw", "firewall", "--format", "ufw")
+	if err != nil {
+		t.Fatalf("firewall --format ufw error = %v", err)
+	}
+	if !strings.Contains(out, "# 198.51.100.7\nufw allow 51821/udp comment 'wedevctl fw: nas (peer)'\nufw allow 51822/udp") {
+		t.Errorf("firewall --format ufw = %q, want one block for both nodes' host", out)
+	}
+
+	if _, err := runCLI(t, "", "vn", "fw", "firewall", "--format", "pf"); err == nil {
+		t.Error("firewall --format pf should fail")
+	}
+}
+
+func TestCLINodeDeleteBulk(t *testing.T) {
+	useTempDB(t)
+
+	for _, args := range [][]string{
+		{"vn", "add", "lt", "10.0.0.0/24"},
+		{"vn", "lt", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "lt", "node", "add", "loadtest1", "route", "--label", "env=test"},
+		{"vn", "lt", "node", "add", "loadtest2", "route", "--label", "env=test"},
+		{"vn", "lt", "node", "add", "loadtest3", "route"},
+		{"vn", "lt", "node", "add", "laptop", "route", "--label", "env=test"},
+		{"vn", "lt", "node", "add", "desk", "route"},
+		{"vn", "lt", "node", "add", "printer", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	remaining := func() string {
+		t.Helper()
+		out, err := runCLI(t, "", "vn", "lt", "node", "list")
+		if err != nil {
+			t.Fatalf("node list error = %v", err)
+		}
+		return out
+	}
+
+	// The set is listed and confirmed once; declining deletes nothing.
+	out, err := runCLI(t, "n\n", "vn", "lt", "node", "delete", "--selector", "env=test", "--pattern", "loadtest*")
+	if err != nil || !strings.Contains(out, "2 nodes will be deleted:\n  loadtest1 (") || !strings.Contains(out, "Cancelled") {
+		t.Errorf("declined bulk delete = %q, %v; want the nodes listed and nothing deleted", out, err)
+	}
+	if !strings.Contains(remaining(), "loadtest1") {
+		t.Error("declined bulk delete deleted loadtest1")
+	}
+
+	out, err = runCLI(t, "y\n", "vn", "lt", "node", "delete", "--selector", "env=test", "--pattern", "loadtest*")
+	if err != nil || !strings.Contains(out, "2 deleted, 0 failed") {
+		t.Errorf("bulk delete = %q, %v; want both deleted", out, err)
+	}
+	if list := remaining(); strings.Contains(list, "loadtest1") || strings.Contains(list, "loadtest2") || !strings.Contains(list, "loadtest3") || !strings.Contains(list, "laptop") {
+		t.Errorf("nodes after selector and pattern delete:\n%s", list)
+	}
+
+	// Several names with --yes, no prompt.
+	out, err = runCLI(t, "", "vn", "lt", "node", "delete", "desk", "printer", "--yes")
+	if err != nil || strings.Contains(out, "(y/n)") || !strings.Contains(out, "Deleted node 'desk'") || !strings.Contains(out, "Deleted node 'printer'") {
+		t.Errorf("node delete desk printer --yes = %q, %v", out, err)
+	}
+
+	// A missing name fails before anything is deleted.
+	if _, err := runCLI(t, "y\n", "vn", "lt", "node", "delete", "laptop", "missing"); err == nil {
+		t.Error("node delete with a missing name should fail")
+	}
+	if !strings.Contains(remaining(), "laptop") {
+		t.Error("node delete with a missing name deleted laptop")
+	}
+
+	if out, err := runCLI(t, "", "vn", "lt", "node", "delete", "--pattern", "db*"); err != nil || !strings.Contains(out, "No matching nodes") {
+		t.Errorf("node delete matching nothing = %q, %v", out, err)
+	}
+	for _, args := range [][]string{
+		{},
+		{"--pattern", "["},
+		{"--selector", "bad"},
+	} {
+		if _, err := runCLI(t, "y\n", append([]string{"vn", "lt", "node", "delete"}, args...)...); err == nil {
+			t.Errorf("node delete %v should fail", args)
+		}
+	}
+
+	// The released IPs leave no drift in the pool.
+	if out, err := runCLI(t, "", "vn", "lt", "ip", "audit"); err != nil {
+		t.Errorf("ip audit after bulk delete = %q, %v", out, err)
+	}
+}
+
+func TestCLIConfigGenerateCheck(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"vn", "add", "ci", "10.0.0.0/24"},
+		{"vn", "ci", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "ci", "node", "add", "a", "route"},
+		{"vn", "ci", "config", "generate", "--output-dir", outDir, "--no-perm-check"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	check := func(extra ...string) (string, error) {
+		t.Helper()
+		return runCLI(t, "", append([]string{"vn", "ci", "config", "generate", "--check", "--output-dir", outDir}, extra...)...)
+	}
+
+	// Regenerating at another time still matches.
+	out, err := check()
+	if err != nil || !strings.Contains(out, "2 match, 0 differ, 0 missing, 0 extra") {
+		t.Fatalf("config generate --check = %q, %v; want everything to match", out, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(outDir, "old.conf"), []byte("[Interface]\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	out, err = check()
+	if err == nil || !strings.Contains(out, "old.conf") || !strings.Contains(out, "1 extra") {
+		t.Errorf("config generate --check with an extra file = %q, %v; want it reported and a failure", out, err)
+	}
+	out, err = check("--ignore-extra")
+	if err != nil || strings.Contains(out, "old.conf") {
+		t.Errorf("config generate --check --ignore-extra = %q, %v; want the extra file ignored", out, err)
+	}
+
+	// A node added since is missing; editing the server config makes it differ.
+	if _, err := runCLI(t, "", "vn", "ci", "node", "add", "b", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "srv.conf"), []byte("[Interface]\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	out, err = check("--ignore-extra")
+	if err == nil {
+		t.Errorf("config generate --check with stale configs succeeded:\n%s", out)
+	}
+	for _, want := range []string{"b.conf", "missing", "srv.conf", "differs", "1 match, 1 differ, 1 missing"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config generate --check output missing %q:\n%s", want, out)
+		}
+	}
+
+	// Nothing was written or saved.
+	if _, err := os.Stat(filepath.Join(outDir, "b.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("config generate --check wrote b.conf: %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ci", "config", "info", "2"); err == nil {
+		t.Error("config generate --check saved a version")
+	}
+
+	for _, args := range [][]string{
+		{"--check", "--dry-run"},
+		{"--check", "--stdout"},
+		{"--check", "--only", "a"},
+		{"--ignore-extra"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "ci", "config", "generate"}, args...)...); err == nil {
+			t.Errorf("config generate %v should fail", args)
+		}
+	}
+}
+
+func TestCLIVNDeleteConfirmation(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "small", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add small error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "small", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "small", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	// The summary is printed before the prompt.
+	out, err := runCLI(t, "n\n", "vn", "delete", "small")
+	if err != nil {
+		t.Fatalf("vn delete error = %v", err)
+	}
+	for _, want := range []string{"Servers: srv", "Nodes: 1 (1 route)", "Config Versions: 0", "Cancelled"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("vn delete = %q, want %q", out, want)
+		}
+	}
+	if out, err := runCLI(t, "", "vn", "delete", "small", "--yes"); err != nil || !strings.Contains(out, "deleted successfully") {
+		t.Errorf("vn delete --yes = %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "large", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add large error = %v", err)
+	}
+	for i := range typedConfirmNodes + 1 {
+		if _, err := runCLI(t, "", "vn", "large", "node", "add", fmt.Sprintf("n%d", i), "route"); err != nil {
+			t.Fatalf("node add error = %v", err)
+		}
+	}
+
+	// A large network needs its name typed; y or --yes alone is not enough.
+	if out, err := runCLI(t, "y\n", "vn", "delete", "large"); err != nil || !strings.Contains(out, "Type the network name 'large'") || !strings.Contains(out, "Cancelled") {
+		t.Errorf("vn delete answered y = %q, %v; want it cancelled", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "delete", "large", "--yes"); err == nil || !strings.Contains(err.Error(), "--yes --force") {
+		t.Errorf("vn delete --yes of a large network error = %v, want it refused", err)
+	}
+	if out, _ := runCLI(t, "", "vn", "list"); !strings.Contains(out, "large") {
+		t.Fatal("large network should still exist")
+	}
+	if out, err := runCLI(t, "large\n", "vn", "delete", "large"); err != nil || !strings.Contains(out, "deleted successfully") {
+		t.Errorf("vn delete with the name typed = %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "large2", "10.0.1.0/24"); err != nil {
+		t.Fatalf("vn add large2 error = %v", err)
+	}
+	for i := range typedConfirmNodes + 1 {
+		if _, err := runCLI(t, "", "vn", "large2", "node", "add", fmt.Sprintf("n%d", i), "route"); err != nil {
+			t.Fatalf("node add error = %v", err)
+		}
+	}
+	if out, err := runCLI(t, "", "vn", "delete", "large2", "--yes", "--force"); err != nil || !strings.Contains(out, "deleted successfully") {
+		t.Errorf("vn delete --yes --force = %q, %v", out, err)
+	}
+}
+
+func TestCLINodeInfo(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "ni", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ni", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"p1", "peer", "203.0.113.1", "--label", "role=db"},
+		{"p2", "peer", "203.0.113.2"},
+		{"r1", "route"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "ni", "node", "add"}, args...)...); err != nil {
+			t.Fatalf("node add %s error = %v", args[0], err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "ni", "node", "info", "p1")
+	if err != nil {
+		t.Fatalf("node info error = %v", err)
+	}
+	for _, want := range []string{"Node: p1", "Type: peer", "Public Address: 203.0.113.1:51820", "Labels: role=db", "Private Key: (redacted)", "Peers in its config: srv, p2", "Listed in configs of: srv, p2, r1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("node info = %q, want %q", out, want)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "ni", "node", "info", "r1", "--output", "json", "--show-secrets")
+	if err != nil {
+		t.Fatalf("node info --output json error = %v", err)
+	}
+	var info nodeInfo
+	if err := json.Unmarshal([]byte(out), &info); err != nil {
+		t.Fatalf("node info output is not JSON: %v\n%s", err, out)
+	}
+	if info.Name != "r1" || info.PrivateKey == "" || info.ID == "" || info.CreatedAt.IsZero() {
+		t.Errorf("node info JSON = %+v, want r1 with its private key", info)
+	}
+	if want := []string{"srv", "p1", "p2"}; !slices.Equal(info.Peers, want) {
+		t.Errorf("Peers = %v, want %v", info.Peers, want)
+	}
+	if want := []string{"srv"}; !slices.Equal(info.PeerOf, want) {
+		t.Errorf("PeerOf = %v, want %v", info.PeerOf, want)
+	}
+	if out, _ := runCLI(t, "", "vn", "ni", "node", "info", "r1", "--output", "json"); strings.Contains(out, "private_key") {
+		t.Errorf("node info JSON without --show-secrets = %q, want no private key", out)
+	}
+
+	_, err = runCLI(t, "", "vn", "ni", "node", "info", "ghost")
+	if err == nil || !strings.Contains(err.Error(), "wedevctl vn ni node list") {
+		t.Errorf("node info for an unknown node error = %v, want a hint to node list", err)
+	}
+}
+
+func TestCLIIPReserve(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "res", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "res", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "res", "ip", "reserve", "10.0.0.0/28"); err == nil || !strings.Contains(err.Error(), "node a (10.0.0.2)") {
+		t.Errorf("ip reserve over node a error = %v, want the node named", err)
+	}
+	out, err := runCLI(t, "", "vn", "res", "ip", "reserve", "10.0.0.16/28")
+	if err != nil || !strings.Contains(out, "Reserved 10.0.0.16/28 (16 addresses)") {
+		t.Fatalf("ip reserve = %q, %v", out, err)
+	}
+
+	out, err = runCLI(t, "", "vn", "res", "ip", "list")
+	if err != nil {
+		t.Fatalf("ip list error = %v", err)
+	}
+	for _, want := range []string{"2 of 254 addresses used, 16 reserved", "10.0.0.2", "node a", "10.0.0.16/28", "reserved"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ip list = %q, want %q", out, want)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "res", "ip", "unreserve", "10.0.0.16/29"); err == nil {
+		t.Error("ip unreserve of a range that is not reserved succeeded")
+	}
+	if out, err := runCLI(t, "", "vn", "res", "ip", "unreserve", "10.0.0.16/28"); err != nil || !strings.Contains(out, "Unreserved 10.0.0.16/28") {
+		t.Errorf("ip unreserve = %q, %v", out, err)
+	}
+	if out, _ := runCLI(t, "", "vn", "res", "ip", "list", "--output", "json"); !strings.Contains(out, `"reserved": []`) {
+		t.Errorf("ip list --output json = %q, want no reservations", out)
+	}
+}
+
+func TestCLIDoctor(t *testing.T) {
+	useTempDB(t)
+	dir := os.Getenv("WEDEVCTL_DB_PATH")
+
+	// A missing database is reported, not created.
+	out, err := runCLI(t, "", "doctor")
+	if err == nil || !strings.Contains(out, "FAIL  database") {
+		t.Errorf("doctor without a database = %q, %v; want a failing database check", out, err)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "wedevctl.db")); statErr == nil {
+		t.Error("doctor created the database")
+	}
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "doc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "doc", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "report.tar.gz")
+	out, err = runCLI(t, "", "doctor", "--network", "doc", "--bundle", bundle)
+	if err != nil {
+		t.Fatalf("doctor error = %v\n%s", err, out)
+	}
+	for _, want := range []string{"PASS  integrity", "PASS  keys (doc)", "0 failed", "Wrote bug report bundle to " + bundle} {
+		if !strings.Contains(out, want) {
+			t.Errorf("doctor = %q, want %q", out, want)
+		}
+	}
+	if _, err := os.Stat(bundle); err != nil {
+		t.Errorf("bundle not written: %v", err)
+	}
+
+	out, err = runCLI(t, "", "doctor", "--output", "json")
+	if err != nil || !strings.Contains(out, `"status": "PASS"`) {
+		t.Errorf("doctor --output json = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "doctor", "--network", "ghost"); ExitCode(err) != ExitNotFound {
+		t.Errorf("doctor --network ghost exit code = %d, want %d", ExitCode(err), ExitNotFound)
+	}
+}
+
+func TestCLINodeGroups(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "grp", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "grp", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"desk", "route", "--group", "office"},
+		{"printer", "route", "--group", "office", "--label", "floor=2"},
+		{"web", "route", "--group", "cloud"},
+		{"stray", "route"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"vn", "grp", "node", "add"}, args...)...); err != nil {
+			t.Fatalf("node add %v error = %v", args, err)
+		}
+	}
+	_, err := runCLI(t, "", "vn", "grp", "node", "add", "x", "route", "--group", "office", "--label", "group=cloud")
+	if ExitCode(err) != ExitValidation {
+		t.Errorf("node add with conflicting --group and --label error = %v, want a validation error", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "grp", "node", "edit", "stray", "--group", "cloud")
+	if err != nil {
+		t.Fatalf("node edit --group error = %v\n%s", err, out)
+	}
+	out, err = runCLI(t, "", "vn", "grp", "group", "list")
+	if err != nil {
+		t.Fatalf("group list error = %v", err)
+	}
+	for _, want := range []string{"cloud", "stray, web", "office", "desk, printer"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("group list = %q, want %q", out, want)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "grp", "node", "list", "--selector", "group=office,floor!=2")
+	if err != nil || !strings.Contains(out, "desk") || strings.Contains(out, "printer") {
+		t.Errorf("node list --selector = %q, %v; want desk only", out, err)
+	}
+
+	dir := t.TempDir()
+	out, err = runCLI(t, "", "vn", "grp", "config", "generate", "--output-dir", dir, "--selector", "group=office")
+	if err != nil {
+		t.Fatalf("config generate --selector error = %v\n%s", err, out)
+	}
+	entries, _ := os.ReadDir(dir)
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if !slices.Equal(files, []string{"desk.conf", "printer.conf"}) {
+		t.Errorf("config generate --selector wrote %v, want desk.conf and printer.conf", files)
+	}
+	// The saved version still holds every config.
+	if out, err := runCLI(t, "", "vn", "grp", "config", "show", "web"); err != nil || !strings.Contains(out, "[Interface]") {
+		t.Errorf("config show web = %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "grp", "config", "generate", "--output-dir", dir, "--selector", "group=lab"); ExitCode(err) != ExitNotFound {
+		t.Errorf("config generate for an empty group error = %v, want not found", err)
+	}
+	if _, err := runCLI(t, "", "vn", "grp", "config", "generate", "--selector", "group=office", "--only", "web"); err == nil {
+		t.Error("config generate with --selector and --only succeeded")
+	}
+}
+
+func TestCLIConfigVerify(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "ver", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ver", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "ver", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	dir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "ver", "config", "generate", "--output-dir", dir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+
+	good := filepath.Join(dir, "a.conf")
+	out, err := runCLI(t, "", "vn", "ver", "config", "verify", good)
+	if err != nil || !strings.Contains(out, good+": verified") || !strings.Contains(out, "'a' in version 1") {
+		t.Errorf("config verify = %q, %v; want a in version 1", out, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "srv.conf"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	tampered := filepath.Join(dir, "srv-copy.conf")
+	if err := os.WriteFile(tampered, []byte(strings.Replace(string(data), "ListenPort = 51820", "ListenPort = 4444", 1)), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	out, err = runCLI(t, "", "vn", "ver", "config", "verify", good, tampered)
+	if err == nil {
+		t.Error("config verify of a modified file succeeded")
+	}
+	for _, want := range []string{tampered + ": unknown/modified", "closest: 'srv' in version 1", "+ListenPort = 4444", "PrivateKey = (redacted)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config verify = %q, want %q", out, want)
+		}
+	}
+
+	out, err = runCLI(t, "", "vn", "ver", "config", "history", "--output", "json")
+	if err != nil {
+		t.Fatalf("config history error = %v", err)
+	}
+	var history []wedev.ConfigVersion
+	if err := json.Unmarshal([]byte(out), &history); err != nil || len(history) != 1 {
+		t.Fatalf("config history = %q, %v", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "ver", "config", "info", "--hash", history[0].ContentHash[:10])
+	if err != nil || !strings.Contains(out, "Configuration Version: 1") {
+		t.Errorf("config info --hash = %q, %v; want version 1", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "ver", "config", "info", "1", "--hash", history[0].ContentHash[:10]); err == nil {
+		t.Error("config info with a version and --hash succeeded")
+	}
+}
+
+func TestCLIConfigWatch(t *testing.T) {
+	useTempDB(t)
+
+	if _, err := runCLI(t, "y\n", "vn", "add", "w", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "w", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "w", "node", "add", "a", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	dir := t.TempDir()
+
+	// watch runs until its context is done; node b is added while it does.
+	watch := func(timeout time.Duration, during func() error) (string, error) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		root := newRootCommand(&App{})
+		root.SetArgs([]string{"vn", "w", "config", "watch", "--output-dir", dir, "--interval", "20ms"})
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(io.Discard)
+		duringErr := make(chan error, 1)
+		go func() {
+			time.Sleep(timeout / 3)
+			duringErr <- during()
+		}()
+		err := root.ExecuteContext(ctx)
+		if err := <-duringErr; err != nil {
+			t.Fatalf("change while watching error = %v", err)
+		}
+		return out.String(), err
+	}
+
+	out, err := watch(time.Second, func() error {
+		_, err := runCLI(t, "", "vn", "w", "node", "add", "b", "route")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("config watch error = %v", err)
+	}
+	for _, want := range []string{"revision 3: wrote a.conf, srv.conf, saved version 1", "revision 4: wrote b.conf, srv.conf, saved version 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config watch = %q, want %q", out, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.conf")); err != nil {
+		t.Errorf("b.conf was not written: %v", err)
+	}
+
+	// Restarted on an unchanged network, it writes and saves nothing.
+	out, err = watch(300*time.Millisecond, func() error { return nil })
+	if err != nil || !strings.Contains(out, "revision 4: no files changed\n") {
+		t.Errorf("config watch = %q, %v; want no files changed", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "w", "config", "watch", "--output-dir", dir, "--interval", "0s"); !errors.Is(err, wedev.ErrValidation) {
+		t.Errorf("config watch --interval 0s error = %v, want ErrValidation", err)
+	}
+}
+
+func TestCLIVersion(t *testing.T) {
+	useTempDB(t)
+
+	// The JSON shape is what scripts and bug reports rely on.
+	readReport := func() map[string]any {
+		t.Helper()
+		out, err := runCLI(t, "", "version", "-o", "json")
+		if err != nil {
+			t.Fatalf("version error = %v", err)
+		}
+		var report map[string]any
+		if err := json.Unmarshal([]byte(out), &report); err != nil {
+			t.Fatalf("version output is not JSON: %v\n%s", err, out)
+		}
+		return report
+	}
+	report := readReport()
+	for _, key := range []string{"version", "commit", "date", "go_version"} {
+		if value, ok := report[key].(string); !ok || value == "" {
+			t.Errorf("version JSON %s = %v, want a non-empty string", key, report[key])
+		}
+	}
+	db, ok := report["database"].(map[string]any)
+	if !ok {
+		t.Fatalf("version JSON database = %v, want an object", report["database"])
+	}
+	want := map[string]any{
+		"path":                     filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"),
+		"source":                   "env",
+		"schema_version":           float64(0),
+		"supported_schema_version": float64(wedev.LatestSchemaVersion()),
+		"status":                   "missing",
+	}
+	if !reflect.DeepEqual(db, want) {
+		t.Errorf("version JSON database = %v, want %v", db, want)
+	}
+	if _, err := os.Stat(want["path"].(string)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("version created the database (stat error = %v)", err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "list"); err != nil {
+		t.Fatalf("vn list error = %v", err)
+	}
+	db = readReport()["database"].(map[string]any)
+	if db["status"] != "current" || db["schema_version"] != float64(wedev.LatestSchemaVersion()) {
+		t.Errorf("version JSON database = %v, want the current schema", db)
+	}
+
+	out, err := runCLI(t, "", "version")
+	if err != nil {
+		t.Fatalf("version error = %v", err)
+	}
+	if !strings.HasPrefix(out, "wedevctl dev\n") || !strings.Contains(out, fmt.Sprintf("Schema:   %d (current)", wedev.LatestSchemaVersion())) {
+		t.Errorf("version output = %q, want the version and current schema", out)
+	}
+}
+
+func TestCLIInit(t *testing.T) {
+	t.Setenv("WEDEVCTL_DB_PATH", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	project := t.TempDir()
+	nested := filepath.Join(project, "deploy")
+	if err := os.Mkdir(nested, 0o700); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "init", project)
+	dbPath := filepath.Join(project, ".wedevctl", "wedevctl.db")
+	if err != nil || !strings.Contains(out, "Created: "+dbPath) {
+		t.Fatalf("init = %q, %v; want the database created", out, err)
+	}
+	if ignore, err := os.ReadFile(filepath.Join(project, ".wedevctl", ".gitignore")); err != nil || !strings.Contains(string(ignore), "*.pid") {
+		t.Errorf(".gitignore = %q, %v; want the lock file ignored", ignore, err)
+	}
+	if _, err := runCLI(t, "", "init", project); ExitCode(err) != ExitAlreadyExists {
+		t.Errorf("init twice exit code = %d (%v), want %d", ExitCode(err), err, ExitAlreadyExists)
+	}
+
+	// Below the project, its database is the one in effect.
+	t.Chdir(nested)
+	if _, err := runCLI(t, "y\n", "vn", "add", "local", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	out, err = runCLI(t, "", "version")
+	if err != nil || !strings.Contains(out, "Database: "+dbPath+" (project-local)") {
+		t.Errorf("version = %q, %v; want the project database", out, err)
+	}
+	sm, err := wedev.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer sm.Close()
+	if _, err := sm.GetNetworkByName("local"); err != nil {
+		t.Errorf("network not created in the project database: %v", err)
+	}
+}
+
+func TestCLIAssumedPromptAnswers(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+
+	// Creation answered by the environment, with nothing on stdin.
+	t.Setenv("WEDEVCTL_ASSUME_CREATE", "yes")
+	out, err := runCLI(t, "", "vn", "add", "auto", "10.0.0.0/24")
+	if err != nil || !strings.Contains(out, "yes (assumed by $WEDEVCTL_ASSUME_CREATE)") || !strings.Contains(out, "created successfully") {
+		t.Fatalf("vn add with WEDEVCTL_ASSUME_CREATE=yes = %q, %v", out, err)
+	}
+	for _, args := range [][]string{
+		{"vn", "auto", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "auto", "node", "add", "a", "route"},
+		{"vn", "auto", "node", "add", "b", "route"},
+		{"vn", "auto", "config", "generate", "--output-dir", outDir, "--no-perm-check"},
+	} {
+		if _, err := runCLI(t, "", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	// Yes to everything but overwrites: piped "y" is not read.
+	t.Setenv("WEDEVCTL_ASSUME", "yes")
+	t.Setenv("WEDEVCTL_ASSUME_OVERWRITE", "no")
+	out, err = runCLI(t, "y\n", "vn", "auto", "config", "generate", "--output-dir", outDir, "--no-perm-check")
+	if err != nil || !strings.Contains(out, "no (assumed by $WEDEVCTL_ASSUME_OVERWRITE)") || !strings.Contains(out, "Cancelled") {
+		t.Errorf("config generate with WEDEVCTL_ASSUME_OVERWRITE=no = %q, %v; want it cancelled", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "auto", "node", "delete", "a")
+	if err != nil || !strings.Contains(out, "yes (assumed by $WEDEVCTL_ASSUME)") || !strings.Contains(out, "deleted") {
+		t.Errorf("node delete with WEDEVCTL_ASSUME=yes = %q, %v", out, err)
+	}
+
+	// The flag beats the environment.
+	out, err = runCLI(t, "", "--assume", "delete=no", "vn", "auto", "node", "delete", "b")
+	if err != nil || !strings.Contains(out, "no (assumed by --assume delete)") || !strings.Contains(out, "Cancelled") {
+		t.Errorf("node delete --assume delete=no = %q, %v; want it cancelled", out, err)
+	}
+
+	// Without assumptions the answer is read from stdin.
+	t.Setenv("WEDEVCTL_ASSUME", "")
+	t.Setenv("WEDEVCTL_ASSUME_CREATE", "")
+	t.Setenv("WEDEVCTL_ASSUME_OVERWRITE", "")
+	if out, err := runCLI(t, "y\n", "vn", "auto", "node", "delete", "b"); err != nil || !strings.Contains(out, "deleted") {
+		t.Errorf("node delete with piped y = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "n\n", "vn", "add", "piped", "10.1.0.0/24"); err != nil || !strings.Contains(out, "Cancelled") {
+		t.Errorf("vn add with piped n = %q, %v", out, err)
+	}
+
+	t.Setenv("WEDEVCTL_ASSUME", "maybe")
+	_, err = runCLI(t, "", "vn", "list")
+	if err == nil || ExitCode(err) != ExitValidation || !strings.Contains(err.Error(), "$WEDEVCTL_ASSUME") {
+		t.Errorf("vn list with WEDEVCTL_ASSUME=maybe error = %v, want a validation error", err)
+	}
+}
+
+func TestCLIListSortAndPage(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "zulu", "10.1.0.0/24"},
+		{"vn", "add", "pages", "10.0.0.0/24"},
+		{"vn", "pages", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "pages", "node", "add", "c", "route"},
+		{"vn", "pages", "node", "add", "a", "route"},
+		{"vn", "pages", "node", "add", "b", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "list", "--output", "json")
+	var networks []wedev.VirtualNetwork
+	if err != nil || json.Unmarshal([]byte(out), &networks) != nil || len(networks) != 2 || networks[0].Name != "pages" {
+		t.Errorf("vn list --output json = %q, %v; want pages before zulu", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "list", "--sort", "created")
+	if err != nil || strings.Index(out, "zulu") > strings.Index(out, "pages") {
+		t.Errorf("vn list --sort created = %q, %v; want zulu first", out, err)
+	}
+
+	out, err = runCLI(t, "", "vn", "pages", "node", "list", "--output", "json")
+	var nodes []struct{ Name string }
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list --output json = %q: %v", out, err)
+	}
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("node list --output json names = %v, want %v", names, want)
+	}
+
+	out, err = runCLI(t, "", "vn", "pages", "node", "list", "--sort", "ip", "--limit", "1", "--offset", "1")
+	if err != nil || !strings.Contains(out, "10.0.0.3") || strings.Contains(out, "10.0.0.2 ") || !strings.Contains(out, "Showing 2-2 of 3 nodes") {
+		t.Errorf("node list --sort ip --limit 1 --offset 1 = %q, %v; want a alone", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "pages", "node", "list", "--offset", "5")
+	if err != nil || !strings.Contains(out, "No nodes at offset 5 (3 matching)") {
+		t.Errorf("node list --offset 5 = %q, %v", out, err)
+	}
+	for _, args := range [][]string{{"--sort", "size"}, {"--limit", "-1"}} {
+		if _, err := runCLI(t, "", append([]string{"vn", "pages", "node", "list"}, args...)...); err == nil {
+			t.Errorf("node list %v succeeded, want an error", args)
+		}
+	}
+}
+
+func TestCLIConfigGenerateSystemd(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "units", "10.0.0.0/24"},
+		{"vn", "units", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "units", "node", "add", "a", "route", "--route-cidr", "192.168.50.0/24"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	outDir := t.TempDir()
+	out, err := runCLI(t, "", "vn", "units", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--systemd", "--restart-on-failure")
+	if err != nil || !strings.Contains(out, "systemctl enable --now") {
+		t.Fatalf("config generate --systemd = %q, %v", out, err)
+	}
+	for _, name := range []string{"a.conf", "srv.conf", "wireguard-a.service", "wireguard-srv.service.d/restart.conf"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("config generate --systemd did not write %s: %v", name, err)
+		}
+	}
+	unit, _ := os.ReadFile(filepath.Join(outDir, "wireguard-a.service"))
+	if !strings.Contains(string(unit), "ExecStart=/usr/bin/wg-quick up a\n") {
+		t.Errorf("wireguard-a.service = %q, want it to bring up interface a", unit)
+	}
+
+	outDir = t.TempDir()
+	out, stderr, err := runCLIStderr(t, "", "vn", "units", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--netdev")
+	if err != nil || !strings.Contains(out, "networkctl reload") {
+		t.Fatalf("config generate --netdev = %q, %v", out, err)
+	}
+	if !strings.Contains(stderr, "iptables") {
+		t.Errorf("config generate --netdev stderr = %q, want the server's skipped iptables commands", stderr)
+	}
+	entries, _ := os.ReadDir(outDir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"a.netdev", "a.network", "srv.netdev", "srv.network"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("config generate --netdev wrote %v, want %v", names, want)
+	}
+	if info, err := os.Stat(filepath.Join(outDir, "a.netdev")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("a.netdev = %v, %v; want it readable by its owner only", info, err)
+	}
+
+	for _, args := range [][]string{
+		{"--restart-on-failure"},
+		{"--systemd", "--netdev"},
+		{"--netdev", "--stdout"},
+		{"--systemd", "--check"},
+	} {
+		args = append([]string{"vn", "units", "config", "generate", "--output-dir", t.TempDir(), "--no-perm-check"}, args...)
+		if _, err := runCLI(t, "", args...); err == nil {
+			t.Errorf("%v succeeded, want an error", args)
+		}
+	}
+}
+
+func TestCLIConfigGenerateWriteFailure(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "atomic", "10.0.0.0/24"},
+		{"vn", "atomic", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "atomic", "node", "add", "a", "route"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	// A directory where srv.conf goes cannot be replaced by the file.
+	outDir := t.TempDir()
+	blocker := filepath.Join(outDir, "srv.conf")
+	if err := os.MkdirAll(filepath.Join(blocker, "keep"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCLI(t, "", "vn", "atomic", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--force")
+	if err == nil || !strings.Contains(err.Error(), "srv.conf") {
+		t.Fatalf("config generate over a directory = %q, %v; want an error naming srv.conf", out, err)
+	}
+	entries, _ := os.ReadDir(outDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("config generate left temporary file %s behind", entry.Name())
+		}
+	}
+	if out, err := runCLI(t, "", "vn", "atomic", "config", "history"); err != nil || !strings.Contains(out, "No configuration versions found") {
+		t.Errorf("config history after a failed write = %q, %v; want no saved version", out, err)
+	}
+
+	// Once the directory is gone the rerun writes everything and saves.
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runCLI(t, "", "vn", "atomic", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--force")
+	if err != nil || !strings.Contains(out, "Configuration version 1 saved") {
+		t.Fatalf("config generate rerun = %q, %v", out, err)
+	}
+	if data, err := os.ReadFile(blocker); err != nil || !strings.Contains(string(data), "[Interface]") {
+		t.Errorf("srv.conf after the rerun = %q, %v", data, err)
+	}
+}
+
+func TestCLIConfigGenerateSetconf(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "plain", "10.0.0.0/24"},
+		{"vn", "plain", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "plain", "node", "add", "a", "route", "--route-cidr", "192.168.50.0/24"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	outDir := t.TempDir()
+	if out, err := runCLI(t, "", "vn", "plain", "config", "generate", "--output-dir", outDir, "--no-perm-check"); err != nil || !strings.Contains(out, "Configuration version 1 saved") {
+		t.Fatalf("config generate = %q, %v", out, err)
+	}
+
+	// Switching formats writes other files but saves no new version.
+	outDir = t.TempDir()
+	out, stderr, err := runCLIStderr(t, "", "vn", "plain", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--config-format", "wg")
+	if err != nil || !strings.Contains(out, "No changes detected") {
+		t.Fatalf("config generate --config-format wg = %q, %v; want no new version", out, err)
+	}
+	if !strings.Contains(stderr, "iptables") || !strings.Contains(stderr, "srv.addresses") {
+		t.Errorf("config generate --config-format wg stderr = %q, want the server's skipped commands", stderr)
+	}
+	entries, _ := os.ReadDir(outDir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"a.addresses", "a.conf", "srv.addresses", "srv.conf"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("config generate --config-format wg wrote %v, want %v", names, want)
+	}
+	conf, _ := os.ReadFile(filepath.Join(outDir, "a.conf"))
+	addresses, _ := os.ReadFile(filepath.Join(outDir, "a.addresses"))
+	if strings.Contains(string(conf), "Address =") || !strings.Contains(string(addresses), "Address = 10.0.0.2/24\n") {
+		t.Errorf("a.conf =\n%s\na.addresses =\n%s", conf, addresses)
+	}
+
+	out, err = runCLI(t, "", "vn", "plain", "config", "generate", "--stdout", "--no-save", "--config-format", "wg")
+	if err != nil || !strings.Contains(out, "# --- a.addresses ---\n") || strings.Contains(out, "Address = 10.0.0.2/24\nListenPort") {
+		t.Errorf("config generate --stdout --config-format wg = %q, %v", out, err)
+	}
+
+	out, stderr, err = runCLIStderr(t, "", "vn", "plain", "config", "show", "a", "--config-format", "wg")
+	if err != nil || strings.Contains(out, "Address =") || !strings.Contains(out, "PrivateKey =") || !strings.Contains(stderr, "Address = 10.0.0.2/24") {
+		t.Errorf("config show --config-format wg = %q (stderr %q), %v", out, stderr, err)
+	}
+
+	for _, args := range [][]string{
+		{"--config-format", "setconf"},
+		{"--config-format", "wg", "--netdev"},
+		{"--config-format", "wg", "--systemd"},
+	} {
+		args = append([]string{"vn", "plain", "config", "generate", "--output-dir", t.TempDir(), "--no-perm-check"}, args...)
+		if _, err := runCLI(t, "", args...); err == nil {
+			t.Errorf("%v succeeded, want an error", args)
+		}
+	}
+}
+
+func TestCLIConfigHistoryFilters(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+	for _, args := range [][]string{
+		{"vn", "add", "hist", "10.0.0.0/24"},
+		{"vn", "hist", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "hist", "node", "add", "a", "route"},
+		{"vn", "hist", "config", "generate", "--output-dir", outDir, "--no-perm-check"},
+		{"vn", "hist", "node", "add", "b", "route"},
+		{"vn", "hist", "config", "generate", "--output-dir", outDir, "--no-perm-check", "--force"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "hist", "config", "history", "--since", "1d")
+	if err != nil || !strings.Contains(out, "nodes: +b") || strings.Contains(out, "filtered out") {
+		t.Errorf("config history --since 1d = %q, %v; want both versions", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "hist", "config", "history", "--last", "1")
+	if err != nil || !strings.Contains(out, "nodes: +b") || strings.Contains(out, "nodes: +a") || !strings.Contains(out, "Showing 1 of 2 versions (1 filtered out)") {
+		t.Errorf("config history --last 1 = %q, %v", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "hist", "config", "history", "--until", "2020-01-01T00:00:00Z")
+	if err != nil || out != "No configuration versions match (2 filtered out)\n" {
+		t.Errorf("config history --until 2020 = %q, %v", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "hist", "config", "history", "--last", "1", "--output", "json")
+	var versions []configHistoryEntry
+	if err != nil || json.Unmarshal([]byte(out), &versions) != nil || len(versions) != 1 || versions[0].Version != 2 {
+		t.Errorf("config history --last 1 --output json = %q, %v", out, err)
+	}
+
+	for _, args := range [][]string{
+		{"--since", "yesterday"},
+		{"--last", "-1"},
+		{"--since", "1h", "--until", "2d"},
+	} {
+		args = append([]string{"vn", "hist", "config", "history"}, args...)
+		if _, err := runCLI(t, "", args...); ExitCode(err) != ExitValidation {
+			t.Errorf("%v error = %v, want a validation error", args, err)
+		}
+	}
+}
+
+func TestCLIConfigTags(t *testing.T) {
+	useTempDB(t)
+	outDir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"vn", "add", "tg", "10.0.0.0/24"},
+		{"vn", "tg", "server", "add", "srv", "vpn.example.com"},
+		{"vn", "tg", "node", "add", "a", "route"},
+		{"vn", "tg", "config", "generate", "--output-dir", outDir, "--no-perm-check"},
+		{"vn", "tg", "node", "add", "b", "route"},
+		{"vn", "tg", "config", "generate", "--output-dir", outDir, "--no-perm-check"},
+		{"vn", "tg", "config", "tag", "1", "prod"},
+		{"vn", "tg", "config", "tag", "prod", "stable"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "tg", "config", "history")
+	if err != nil || !strings.Contains(out, "prod,stable") {
+		t.Errorf("config history = %q, %v; want version 1 tagged prod,stable", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "tg", "config", "info", "prod")
+	if err != nil || !strings.Contains(out, "Configuration Version: 1\n") || !strings.Contains(out, "Tags: prod, stable") || strings.Contains(out, "[b.conf]") {
+		t.Errorf("config info prod = %q, %v; want version 1", out, err)
+	}
+	archive := filepath.Join(t.TempDir(), "prod.tar.gz")
+	if out, err := runCLI(t, "", "vn", "tg", "config", "export", "prod", "--archive", archive); err != nil || !strings.Contains(out, "version 1") {
+		t.Errorf("config export prod = %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "tg", "config", "tag", "2", "prod"); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("config tag moving prod error = %v, want a hint at --force", err)
+	}
+	out, err = runCLI(t, "", "vn", "tg", "config", "tag", "2", "prod", "--force")
+	if err != nil || !strings.Contains(out, "moved from version 1 to version 2") {
+		t.Errorf("config tag --force = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "tg", "config", "tag", "1", "2024-06-01"); err == nil {
+		t.Error("config tag with an invalid name succeeded")
+	}
+
+	out, err = runCLI(t, "", "vn", "tg", "config", "history", "-o", "json")
+	var entries []struct {
+		Version int      `json:"version"`
+		Tags    []string `json:"tags"`
+	}
+	if err != nil || json.Unmarshal([]byte(out), &entries) != nil || len(entries) != 2 || !slices.Equal(entries[1].Tags, []string{"prod"}) {
+		t.Errorf("config history -o json = %q, %v; want prod on version 2", out, err)
+	}
+
+	if out, err := runCLI(t, "", "vn", "tg", "config", "untag", "stable"); err != nil || !strings.Contains(out, "removed from version 1") {
+		t.Errorf("config untag = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "tg", "config", "info", "stable"); err == nil {
+		t.Error("config info of a removed tag succeeded")
+	}
+}
+
+func TestCLICorruptDatabaseHint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WEDEVCTL_DB_PATH", dir)
+	if err := os.WriteFile(filepath.Join(dir, "wedevctl.db"), bytes.Repeat([]byte("x"), 40000), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	_, err := runCLI(t, "", "vn", "list")
+	if err == nil || !strings.Contains(err.Error(), "invalid database") || !strings.Contains(err.Error(), "wedevctl db restore") {
+		t.Errorf("vn list on a corrupt database error = %v, want a hint at db restore", err)
+	}
+}
+
+func TestCLINetworkSettings(t *testing.T) {
+	useTempDB(t)
+	if _, err := runCLI(t, "y\n", "vn", "add", "opts", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "server", "add", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+
+	out, err := runCLI(t, "", "vn", "opts", "get", "keepalive")
+	if err != nil || out != "25\n" {
+		t.Errorf("get keepalive = %q, %v, want the default 25", out, err)
+	}
+	if out, err = runCLI(t, "", "vn", "opts", "set", "mtu", "1420"); err != nil || !strings.Contains(out, "Set mtu=1420") {
+		t.Errorf("set mtu = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "set", "mtu", "64"); ExitCode(err) != ExitValidation {
+		t.Errorf("set mtu 64 exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "set", "owner", "ops"); ExitCode(err) != ExitValidation {
+		t.Errorf("set of an unknown key exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "set", "--raw", "owner", "ops"); err != nil {
+		t.Errorf("set --raw error = %v", err)
+	}
+
+	out, err = runCLI(t, "", "vn", "opts", "settings", "list")
+	if err != nil {
+		t.Fatalf("settings list error = %v", err)
+	}
+	for _, want := range []string{"keepalive          25", "default", "mtu                1420", "owner              ops", "topology           hub-spoke"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("settings list missing %q:\n%s", want, out)
+		}
+	}
+	if out, err = runCLI(t, "", "vn", "opts", "config", "show", "srv"); err != nil || !strings.Contains(out, "MTU = 1420\n") {
+		t.Errorf("config show = %q, %v, want MTU = 1420", out, err)
+	}
+
+	if _, err := runCLI(t, "", "vn", "opts", "unset", "mtu"); err != nil {
+		t.Errorf("unset mtu error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "unset", "mtu"); ExitCode(err) != ExitNotFound {
+		t.Errorf("unset of an unset key exit code = %d (%v), want %d", ExitCode(err), err, ExitNotFound)
+	}
+	if _, err := runCLI(t, "", "vn", "opts", "get", "colour"); ExitCode(err) != ExitNotFound {
+		t.Errorf("get of an unknown key exit code = %d (%v), want %d", ExitCode(err), err, ExitNotFound)
+	}
+}
+
+func TestCLIDBEncrypt(t *testing.T) {
+	useTempDB(t)
+	passFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passFile, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"vn", "add", "sec", "10.0.0.0/24"},
+		{"vn", "sec", "server", "add", "srv", "vpn.example.com", "51820"},
+		{"vn", "sec", "node", "add", "n1", "peer", "1.2.3.4"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	if _, err := runCLI(t, "one\ntwo\n", "db", "encrypt"); ExitCode(err) != ExitValidation {
+		t.Errorf("db encrypt with mismatched passphrases exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	out, err := runCLI(t, "", "db", "encrypt", "--passphrase-file", passFile)
+	if err != nil || !strings.Contains(out, "Encrypted 2 private key(s)") {
+		t.Fatalf("db encrypt = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "", "db", "info"); err != nil || !strings.Contains(out, "Private keys: encrypted") {
+		t.Errorf("db info = %q, %v", out, err)
+	}
+
+	// Commands not needing a key run without a passphrase.
+	if out, err := runCLI(t, "", "vn", "sec", "node", "info", "n1"); err != nil || !strings.Contains(out, "Private Key: (redacted)") {
+		t.Errorf("node info locked = %q, %v", out, err)
+	}
+	outDir := t.TempDir()
+	if _, err := runCLI(t, "", "vn", "sec", "config", "generate", "--output-dir", outDir); ExitCode(err) != ExitKeysLocked {
+		t.Errorf("config generate without a passphrase exit code = %d (%v), want %d", ExitCode(err), err, ExitKeysLocked)
+	}
+	t.Setenv(passphraseEnv, "wrong")
+	if _, err := runCLI(t, "", "vn", "sec", "config", "generate", "--output-dir", outDir); ExitCode(err) != ExitKeysLocked {
+		t.Errorf("config generate with a wrong passphrase exit code = %d (%v), want %d", ExitCode(err), err, ExitKeysLocked)
+	}
+	t.Setenv(passphraseEnv, "")
+
+	if _, err := runCLI(t, "correct horse\n", "node", "add", "n2", "peer", "1.2.3.5", "--network", "sec"); err != nil {
+		t.Errorf("node add with a prompted passphrase error = %v", err)
+	}
+	if _, err := runCLI(t, "", "vn", "sec", "config", "generate", "--output-dir", outDir, "--passphrase-file", passFile); err != nil {
+		t.Fatalf("config generate with --passphrase-file error = %v", err)
+	}
+	// Given before 'vn', in either form, the flag is not taken for the network.
+	for _, flag := range [][]string{{"--passphrase-file", passFile}, {"--passphrase-file=" + passFile}} {
+		args := append(flag, "vn", "sec", "config", "generate", "--output-dir", outDir, "--force")
+		if _, err := runCLI(t, "", args...); err != nil {
+			t.Errorf("%v error = %v", args, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "n2.conf"))
+	if err != nil || !strings.Contains(string(data), "PrivateKey = ") || strings.Contains(string(data), "wedev-enc:") {
+		t.Errorf("generated config = %q, %v; want the plain private key", data, err)
+	}
+
+	if out, err := runCLI(t, "", "db", "decrypt", "--passphrase-file", passFile); err != nil || !strings.Contains(out, "Decrypted 3 private key(s)") {
+		t.Fatalf("db decrypt = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "sec", "config", "generate", "--output-dir", outDir, "--force"); err != nil {
+		t.Errorf("config generate after db decrypt error = %v", err)
+	}
+}
+
+func TestCLIGuest(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "partners", "10.0.0.0/24"},
+		{"vn", "partners", "server", "add", "hub", "vpn.example.com", "51820"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	if _, err := runCLI(t, "", "vn", "partners", "guest", "create", "acme"); ExitCode(err) != ExitValidation {
+		t.Errorf("guest create without an expiry exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	out, errOut, err := runCLIStderr(t, "", "vn", "partners", "guest", "create", "acme", "--ttl", "24h")
+	if err != nil {
+		t.Fatalf("guest create error = %v", err)
+	}
+	if !strings.Contains(out, "PrivateKey = ") || !strings.Contains(out, "Endpoint = vpn.example.com:51820") || strings.Contains(out, "created with IP") {
+		t.Errorf("guest create stdout = %q, want only the config", out)
+	}
+	if !strings.Contains(errOut, "Guest 'acme' created with IP 10.0.0.") || !strings.Contains(errOut, "Configuration version 1 saved") {
+		t.Errorf("guest create stderr = %q", errOut)
+	}
+	if out, err := runCLI(t, "", "vn", "partners", "guest", "list"); err != nil || !strings.Contains(out, "acme") || !strings.Contains(out, "hub") {
+		t.Errorf("guest list = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "partners", "config", "show", "hub"); err != nil || !strings.Contains(out, "# acme (") {
+		t.Errorf("config show hub = %q, %v; want the guest as a peer", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "partners", "guest", "create", "globex", "--ttl", "1h", "--qr"); err != nil || strings.Contains(out, "PrivateKey") || !strings.Contains(out, "█") {
+		t.Errorf("guest create --qr = %q, %v; want a QR code", out, err)
+	}
+
+	if out, err := runCLI(t, "", "vn", "partners", "guest", "revoke", "acme", "--yes"); err != nil || !strings.Contains(out, "Revoked guest 'acme'") {
+		t.Errorf("guest revoke = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "partners", "guest", "revoke", "acme", "--yes"); ExitCode(err) != ExitNotFound {
+		t.Errorf("guest revoke twice exit code = %d (%v), want %d", ExitCode(err), err, ExitNotFound)
+	}
+}
+
+func TestCLIListColumns(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "office", "10.0.0.0/24"},
+		{"vn", "office", "server", "add", "hub", "vpn.example.com", "51820"},
+		{"vn", "office", "node", "add", "laptop", "route"},
+		{"vn", "office", "node", "add", "nas", "peer", "198.51.100.7"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "office", "node", "list", "--columns", "virtual-ip,name", "--no-header")
+	if err != nil || out != "10.0.0.2  laptop\n10.0.0.3  nas\n" {
+		t.Errorf("node list --columns virtual-ip,name --no-header = %q, %v", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "office", "node", "list", "--columns", "name,type", "--limit", "1")
+	if err != nil || !strings.HasPrefix(out, "Name    Type\n") || !strings.Contains(out, "Showing 1-1 of 2 nodes") {
+		t.Errorf("node list --columns name,type --limit 1 = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "", "vn", "list", "--columns", "name", "--no-header"); err != nil || out != "office\n" {
+		t.Errorf("vn list --columns name --no-header = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "office", "server", "list", "--columns", "name,bogus"); ExitCode(err) != ExitValidation {
+		t.Errorf("server list with an unknown column exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+}
+
+func TestCLIEndpointCheck(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "home", "10.0.0.0/24"},
+		{"vn", "home", "server", "add", "hub", "localhost", "51820"},
+		{"vn", "home", "node", "add", "nas", "peer", "198.51.100.1"},
+		{"vn", "home", "node", "edit", "nas", "--public-address", "198.51.100.2"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	out, err := runCLI(t, "", "vn", "home", "node", "history", "nas", "--addresses")
+	if err != nil || !strings.Contains(out, "198.51.100.1") || !strings.Contains(out, "198.51.100.2  (current)") {
+		t.Errorf("node history --addresses = %q, %v", out, err)
+	}
+	out, err = runCLI(t, "", "vn", "home", "server", "history", "hub", "--addresses", "--output", "json")
+	if err != nil || !strings.Contains(out, `"current": "localhost"`) || !strings.Contains(out, `"previous": []`) {
+		t.Errorf("server history --addresses --output json = %q, %v", out, err)
+	}
+
+	for range 2 {
+		out, err = runCLI(t, "", "vn", "home", "check", "--strict")
+		if err != nil || !strings.Contains(out, "Network home: 1 hostname(s) resolve as at the last check") {
+			t.Errorf("check = %q, %v", out, err)
+		}
+	}
+}
+
+func TestCLIInvalidNetworkCIDR(t *testing.T) {
+	useTempDB(t)
+	for _, args := range [][]string{
+		{"vn", "add", "legacy", "10.0.0.0/24"},
+		{"vn", "legacy", "server", "add", "hub", "vpn.example.com", "51820"},
+		{"vn", "legacy", "node", "add", "nas", "peer", "198.51.100.1"},
+		{"vn", "legacy", "node", "add", "lab", "peer", "198.51.100.2"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+
+	// Store an IPv6 range, as older builds accepted, without its pool state.
+	db, err := bbolt.Open(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"), 0o600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open() error = %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		networks := tx.Bucket([]byte(wedev.BucketNetworks))
+		return networks.ForEach(func(k, v []byte) error {
+			var record map[string]any
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			record["cidr"] = "fd00::/64"
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(wedev.BucketIPPools)).Delete(k); err != nil {
+				return err
+			}
+			return networks.Put(k, data)
+		})
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatalf("storing the IPv6 CIDR error = %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"vn", "list", "--wide"},
+		{"vn", "legacy", "info"},
+		{"vn", "legacy", "node", "list"},
+		{"vn", "legacy", "server", "list"},
+		{"vn", "legacy", "node", "delete", "lab", "--yes"},
+	} {
+		if _, err := runCLI(t, "", args...); err != nil {
+			t.Errorf("%v error = %v; want it to work without the pool", args, err)
+		}
+	}
+	if out, _ := runCLI(t, "", "vn", "legacy", "info"); !strings.Contains(out, "Addresses: unknown, the CIDR is invalid") {
+		t.Errorf("vn info = %q, want the pool reported unknown", out)
+	}
+
+	want := "network legacy has invalid CIDR 'fd00::/64'; fix with 'vn edit --cidr'"
+	for _, args := range [][]string{
+		{"vn", "legacy", "node", "add", "new", "peer", "198.51.100.3"},
+		{"vn", "legacy", "ip", "list"},
+		{"vn", "legacy", "config", "generate", "--output-dir", t.TempDir()},
+	} {
+		if _, err := runCLI(t, "", args...); ExitCode(err) != ExitValidation || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v exit code = %d (%v), want %d and %q", args, ExitCode(err), err, ExitValidation, want)
+		}
+	}
+	if out, err := runCLI(t, "", "vn", "legacy", "validate"); err == nil || !strings.Contains(out, "invalid_cidr") {
+		t.Errorf("validate = %q, %v; want an invalid_cidr error", out, err)
+	}
+	for _, args := range [][]string{{"db", "fsck"}, {"db", "fsck", "--fix"}} {
+		if out, err := runCLI(t, "", args...); err == nil || !strings.Contains(out, "network legacy has invalid CIDR 'fd00::/64'") {
+			t.Errorf("%v = %q, %v; want the CIDR flagged", args, out, err)
+		}
+	}
+
+	if out, err := runCLI(t, "", "vn", "legacy", "edit", "--cidr", "10.0.0.0/24"); err != nil || !strings.Contains(out, "now uses CIDR 10.0.0.0/24") {
+		t.Fatalf("vn edit --cidr = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "", "vn", "legacy", "node", "add", "new", "peer", "198.51.100.3"); err != nil {
+		t.Errorf("node add after the fix error = %v", err)
+	}
+	if out, err := runCLI(t, "", "db", "fsck"); err != nil || !strings.Contains(out, "No integrity problems found") {
+		t.Errorf("db fsck after the fix = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "y\n", "vn", "delete", "legacy", "--yes"); err != nil {
+		t.Errorf("vn delete error = %v", err)
+	}
+}
+
+func TestCLIMetricsFile(t *testing.T) {
+	useTempDB(t)
+	path := filepath.Join(t.TempDir(), "wedevctl.prom")
+	for _, args := range [][]string{
+		{"vn", "add", "home", "10.0.0.0/24"},
+		{"vn", "home", "server", "add", "hub", "vpn.example.com", "51820"},
+		{"vn", "home", "config", "generate", "--output-dir", t.TempDir()},
+		{"--metrics-file", path, "vn", "home", "node", "add", "nas", "client"},
+	} {
+		if _, err := runCLI(t, "y\n", args...); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("metrics file not written: %v", err)
+	}
+	for _, want := range []string{
+		`wedevctl_command_duration_seconds{command="vn node add",result="ok"} `,
+		`wedevctl_storage_transactions_total{kind="update",op="CreateNodeWithPoolState",result="ok"} 1`,
+		`wedevctl_storage_transaction_seconds_total{kind="update",op="CreateNodeWithPoolState"} `,
+		"wedevctl_db_size_bytes ",
+		"wedevctl_networks 1",
+		`wedevctl_nodes{network="home"} 1`,
+		`wedevctl_config_version_age_seconds{network="home"} `,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics file is missing %s:\n%s", want, data)
+		}
+	}
+
+	// A failed command is written too, under its name and not the network's.
+	if _, err := runCLI(t, "", "vn", "missing", "node", "list", "--metrics-file", path); ExitCode(err) != ExitNotFound {
+		t.Fatalf("node list of a missing network error = %v, want not found", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `wedevctl_command_duration_seconds{command="vn node list",result="error"} `) {
+		t.Errorf("metrics file after a failed command = %s, %v", data, err)
+	}
+	if _, err := runCLI(t, "", "vn", "list", "--metrics-file", path); err != nil {
+		t.Fatalf("vn list error = %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `command="vn list",result="ok"`) {
+		t.Errorf("metrics file after vn list = %s, %v", data, err)
+	}
+}
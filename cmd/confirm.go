@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wedevctl/wedev"
+)
+
+// promptCategory is the kind of change a confirmation prompt guards, so
+// automation can answer some kinds and not others.
+type promptCategory string
+
+const (
+	promptCreate    promptCategory = "create"    // creating a network
+	promptDelete    promptCategory = "delete"    // deleting networks, servers, or nodes
+	promptOverwrite promptCategory = "overwrite" // replacing files, archives, or the database
+)
+
+// promptCategories lists the categories in the order help text names them.
+var promptCategories = []promptCategory{promptCreate, promptDelete, promptOverwrite}
+
+// assumption is an answer given to prompts of a category without asking,
+// and the flag or environment variable it came from.
+type assumption struct {
+	yes    bool
+	source string
+}
+
+// assumptions are the answers assumed per prompt category. Prompts of a
+// category without one are asked as usual.
+type assumptions map[promptCategory]assumption
+
+// assumeEnv is the environment variable answering every prompt category;
+// assumeEnv + "_" + the upper-case category answers one.
+const assumeEnv = "WEDEVCTL_ASSUME"
+
+// resolveAssumptions reads the assumed answers from the environment and the
+// --assume flag values. Each source answers every category with yes or no,
+// or one category with <category>=yes|no; a category answer beats a blanket
+// one, and the flag beats the environment.
+func resolveAssumptions(flagValues []string, getenv func(string) string) (assumptions, error) {
+	assumed := assumptions{}
+	set := func(categories []promptCategory, answer, source string) error {
+		var yes bool
+		switch strings.ToLower(answer) {
+		case "yes":
+			yes = true
+		case "no":
+		default:
+			return withKind(wedev.ErrValidation, fmt.Errorf("invalid %s %q: expected yes or no", source, answer))
+		}
+		for _, category := range categories {
+			assumed[category] = assumption{yes: yes, source: source}
+		}
+		return nil
+	}
+
+	if value := getenv(assumeEnv); value != "" {
+		if err := set(promptCategories, value, "$"+assumeEnv); err != nil {
+			return nil, err
+		}
+	}
+	for _, category := range promptCategories {
+		name := assumeEnv + "_" + strings.ToUpper(string(category))
+		if value := getenv(name); value != "" {
+			if err := set([]promptCategory{category}, value, "$"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var terms []string
+	for _, value := range flagValues {
+		for _, term := range strings.Split(value, ",") {
+			if term = strings.TrimSpace(term); term != "" {
+				terms = append(terms, term)
+			}
+		}
+	}
+	// Blanket answers first, so category answers win whatever their order.
+	for _, term := range terms {
+		if !strings.Contains(term, "=") {
+			if err := set(promptCategories, term, "--assume"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, term := range terms {
+		name, answer, ok := strings.Cut(term, "=")
+		if !ok {
+			continue
+		}
+		category := promptCategory(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(promptCategories, category) {
+			return nil, withKind(wedev.ErrValidation, fmt.Errorf("invalid --assume category %q: expected create, delete, or overwrite", name))
+		}
+		if err := set([]promptCategory{category}, strings.TrimSpace(answer), "--assume "+string(category)); err != nil {
+			return nil, err
+		}
+	}
+	return assumed, nil
+}
+
+// assumeFlag returns the --assume values. Like dbFlag, it reads the raw
+// arguments of commands under 'vn'.
+func assumeFlag(cmd *cobra.Command, args []string) ([]string, error) {
+	if cmd.DisableFlagParsing {
+		var values []string
+		for i, arg := range args {
+			if v, ok := strings.CutPrefix(arg, "--assume="); ok {
+				values = append(values, v)
+			} else if arg == "--assume" && i+1 < len(args) {
+				values = append(values, args[i+1])
+			}
+		}
+		return values, nil
+	}
+	if cmd.Flags().Lookup("assume") == nil {
+		return nil, nil
+	}
+	values, err := cmd.Flags().GetStringArray("assume")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assume flag: %w", err)
+	}
+	return values, nil
+}
+
+// confirmRequest is a prompt confirmAction asks, as set up by its options.
+type confirmRequest struct {
+	category promptCategory
+	assumed  *assumption
+	logger   *slog.Logger
+}
+
+// confirmOption sets up a confirmAction prompt.
+type confirmOption func(*confirmRequest)
+
+// assumeFor marks a prompt as guarding a change of category, so the answer
+// the App assumes for that category, if any, is given without asking.
+func assumeFor(app *App, category promptCategory) confirmOption {
+	return func(req *confirmRequest) {
+		req.category = category
+		if answer, ok := app.assume[category]; ok {
+			req.assumed = &answer
+		}
+		req.logger = app.opts.Logger
+	}
+}
+
+// confirmAction prompts for confirmation on the command's output and reads
+// the answer from its input. When an option assumes the answer, the prompt
+// is printed with that answer and the source it was assumed from, and
+// nothing is read.
+func confirmAction(cmd *cobra.Command, prompt string, opts ...confirmOption) bool {
+	req := &confirmRequest{}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	if req.assumed != nil {
+		answer := "no"
+		if req.assumed.yes {
+			answer = "yes"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s (y/n): %s (assumed by %s)\n", prompt, answer, req.assumed.source)
+		if req.logger != nil {
+			req.logger.Info("assumed prompt answer", "category", string(req.category), "answer", answer, "source", req.assumed.source)
+		}
+		return req.assumed.yes
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s (y/n): ", prompt)
+	var response string
+	_, err := fmt.Fscanln(cmd.InOrStdin(), &response)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return response == "y" || response == "Y" || response == "yes" || response == "YES"
+}
+
+// confirmByTyping prompts for want to be typed back, as a guard for
+// destructive actions a reflexive "y" should not trigger. An assumed "no"
+// answers it; an assumed "yes" does not, as --yes does not.
+func confirmByTyping(cmd *cobra.Command, prompt, want string, opts ...confirmOption) bool {
+	req := &confirmRequest{}
+	for _, opt := range opts {
+		opt(req)
+	}
+	if req.assumed != nil && !req.assumed.yes {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: (declined, assumed by %s)\n", prompt, req.assumed.source)
+		return false
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s: ", prompt)
+	var response string
+	_, err := fmt.Fscanln(cmd.InOrStdin(), &response)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return response == want
+}
+
+// confirmArchiveOverwrite reports whether the archive at path may be
+// written: it does not exist yet, force is set, or the user agrees.
+func confirmArchiveOverwrite(app *App, cmd *cobra.Command, path string, force bool) bool {
+	if _, err := os.Stat(path); err != nil || force {
+		return true
+	}
+	return confirmAction(cmd, fmt.Sprintf("Archive %s already exists. Overwrite it?", path), assumeFor(app, promptOverwrite))
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+// Where the database in effect comes from, in the order resolveDBLocation
+// tries them.
+const (
+	dbSourceFlag    = "flag"    // --db
+	dbSourceEnv     = "env"     // $WEDEVCTL_DB_PATH
+	dbSourceProject = "project" // a .wedevctl directory at or above the working directory
+	dbSourceXDG     = "xdg"     // $XDG_DATA_HOME/wedevctl
+	dbSourceLegacy  = "legacy"  // ~/.wedevctl, the default before the XDG location
+)
+
+const (
+	dbFileName     = "wedevctl.db"
+	projectDirName = ".wedevctl"
+	// noMigrationMarker, next to a legacy database, records that copying it
+	// to the XDG location was declined.
+	noMigrationMarker = ".no-xdg-migration"
+)
+
+// dbLocation is the database file resolveDBLocation picked and why.
+type dbLocation struct {
+	path   string
+	source string
+	// xdgPath is where a legacy database is offered to be copied to; set
+	// only when source is dbSourceLegacy.
+	xdgPath string
+}
+
+// dbEnvironment is what resolveDBLocation reads besides the --db flag, so
+// tests can resolve without touching the process environment.
+type dbEnvironment struct {
+	getenv func(string) string
+	wd     string
+	home   string // empty when unknown
+}
+
+// currentDBEnvironment returns the environment of this process.
+func currentDBEnvironment() (dbEnvironment, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return dbEnvironment{}, fmt.Errorf("failed to get working directory: %w", err)
+	}
+	// A missing home only matters when the XDG or legacy location is needed.
+	//nolint:errcheck // Reported by resolveDBLocation when needed
+	home, _ := os.UserHomeDir()
+	return dbEnvironment{getenv: os.Getenv, wd: wd, home: home}, nil
+}
+
+// resolveDBLocation picks the database file. The first of these wins:
+//
+//  1. the --db flag: a path ending in .db is the file itself, anything else
+//     a directory holding wedevctl.db;
+//  2. wedevctl.db in $WEDEVCTL_DB_PATH;
+//  3. wedevctl.db in the nearest .wedevctl directory at or above the working
+//     directory, as created by 'wedevctl init' (~/.wedevctl is not one);
+//  4. $XDG_DATA_HOME/wedevctl/wedevctl.db, $XDG_DATA_HOME defaulting to
+//     ~/.local/share, when it exists;
+//  5. ~/.wedevctl/wedevctl.db, when it exists;
+//  6. the XDG location, to be created.
+//
+// Relative paths are taken from the working directory.
+func resolveDBLocation(flag string, env dbEnvironment) (dbLocation, error) {
+	abs := func(path string) string {
+		if filepath.IsAbs(path) {
+			return filepath.Clean(path)
+		}
+		return filepath.Join(env.wd, path)
+	}
+
+	switch {
+	case strings.HasSuffix(flag, ".db"):
+		return dbLocation{path: abs(flag), source: dbSourceFlag}, nil
+	case flag != "":
+		return dbLocation{path: filepath.Join(abs(flag), dbFileName), source: dbSourceFlag}, nil
+	}
+	if dir := env.getenv("WEDEVCTL_DB_PATH"); dir != "" {
+		return dbLocation{path: filepath.Join(abs(dir), dbFileName), source: dbSourceEnv}, nil
+	}
+
+	legacyDir := ""
+	if env.home != "" {
+		legacyDir = filepath.Join(env.home, projectDirName)
+	}
+	if dir := findProjectDir(env.wd, legacyDir); dir != "" {
+		return dbLocation{path: filepath.Join(dir, dbFileName), source: dbSourceProject}, nil
+	}
+
+	// Per the XDG base directory spec, a relative $XDG_DATA_HOME is ignored.
+	dataHome := env.getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dataHome) {
+		if env.home == "" {
+			return dbLocation{}, fmt.Errorf("failed to get home directory: set $HOME, $XDG_DATA_HOME or $WEDEVCTL_DB_PATH")
+		}
+		dataHome = filepath.Join(env.home, ".local", "share")
+	}
+	xdgPath := filepath.Join(dataHome, "wedevctl", dbFileName)
+	if fileExists(xdgPath) || legacyDir == "" {
+		return dbLocation{path: xdgPath, source: dbSourceXDG}, nil
+	}
+	if legacyPath := filepath.Join(legacyDir, dbFileName); fileExists(legacyPath) {
+		return dbLocation{path: legacyPath, source: dbSourceLegacy, xdgPath: xdgPath}, nil
+	}
+	return dbLocation{path: xdgPath, source: dbSourceXDG}, nil
+}
+
+// findProjectDir returns the nearest .wedevctl directory at or above dir,
+// other than skip, or "" when there is none.
+func findProjectDir(dir, skip string) string {
+	for {
+		candidate := filepath.Join(dir, projectDirName)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() && candidate != skip {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// fileExists reports whether path names an existing file.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// resolveDBPath returns the path of the database file that resolveDBLocation
+// picks for the --db flag value and the environment of this process.
+func resolveDBPath(flag string) (string, error) {
+	env, err := currentDBEnvironment()
+	if err != nil {
+		return "", err
+	}
+	loc, err := resolveDBLocation(flag, env)
+	if err != nil {
+		return "", err
+	}
+	return loc.path, nil
+}
+
+// offerXDGMigration asks, once, to copy the legacy database of loc to the XDG
+// location, and returns the path to use: the copy when it was made, the
+// legacy file otherwise. It only asks when stdin is a terminal, so scripts
+// keep using the legacy file; a refusal is remembered by a marker next to
+// it. The legacy file is left in place either way.
+func offerXDGMigration(cmd *cobra.Command, loc dbLocation, opts wedev.StorageOptions) string {
+	tty, ok := cmd.InOrStdin().(*os.File)
+	if !ok || !isTerminal(tty) {
+		return loc.path
+	}
+	marker := filepath.Join(filepath.Dir(loc.path), noMigrationMarker)
+	if fileExists(marker) {
+		return loc.path
+	}
+
+	w := cmd.ErrOrStderr()
+	fmt.Fprintf(w, "The database is at %s, where wedevctl kept it before following the XDG base directory spec.\n", loc.path)
+	fmt.Fprintf(w, "Copy it to %s and use the copy from now on? (y/n): ", loc.xdgPath)
+	var response string
+	//nolint:errcheck // No answer is a refusal
+	_, _ = fmt.Fscanln(tty, &response)
+	if response != "y" && response != "Y" && response != "yes" && response != "YES" {
+		if err := os.WriteFile(marker, nil, 0o600); err != nil {
+			fmt.Fprintf(w, "Warning: failed to remember the answer: %v\n", err)
+			return loc.path
+		}
+		fmt.Fprintf(w, "Keeping %s; delete %s to be asked again.\n", loc.path, marker)
+		return loc.path
+	}
+
+	if err := copyDatabase(cmd.Context(), loc.path, loc.xdgPath, opts); err != nil {
+		fmt.Fprintf(w, "Warning: %v; keeping %s\n", err, loc.path)
+		return loc.path
+	}
+	fmt.Fprintf(w, "Copied the database to %s; %s is kept but no longer used.\n", loc.xdgPath, loc.path)
+	return loc.xdgPath
+}
+
+// copyDatabase writes a consistent copy of the database at from to to,
+// creating its directory. An encrypted database is copied as it is, still
+// encrypted.
+func copyDatabase(ctx context.Context, from, to string, opts wedev.StorageOptions) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o700); err != nil {
+		return fmt.Errorf("failed to create db directory: %w", wedev.DiagnoseOpenError(filepath.Dir(to), err))
+	}
+	opts.ReadOnly = true
+	sm, err := wedev.NewStorageManagerWithOptionsCtx(ctx, from, opts)
+	if errors.Is(err, wedev.ErrSchemaOutdated) {
+		// Only a read-write open migrates the database.
+		opts.ReadOnly = false
+		sm, err = wedev.NewStorageManagerWithOptionsCtx(ctx, from, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", from, err)
+	}
+	//nolint:errcheck // The copy is synced by Backup
+	defer func() { _ = sm.Close() }()
+
+	if _, err := sm.Backup(to); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = os.Remove(to)
+		return fmt.Errorf("failed to copy the database: %w", err)
+	}
+	return nil
+}
+
+// ========== Init ==========
+
+// NewInitCommand creates the 'init' command
+func NewInitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Create a project-local database",
+		Long: `Create a .wedevctl directory holding a new database in dir, or in the
+current directory. Commands run in dir or below it then use that database
+instead of the one in $XDG_DATA_HOME/wedevctl, so each project keeps its
+networks next to its code. --db and $WEDEVCTL_DB_PATH still take precedence.
+
+The directory gets a .gitignore for the lock file. Encrypt the database with
+'wedevctl db encrypt' before committing it, as it holds private keys.
+
+Examples:
+  wedevctl init
+  wedevctl init ~/src/homelab`,
+		Args: cobra.MaximumNArgs(1),
+		// The database to create is given by dir, not resolved.
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			dir, err := filepath.Abs(dir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve directory: %w", err)
+			}
+			projectDir := filepath.Join(dir, projectDirName)
+			if home, err := os.UserHomeDir(); err == nil && projectDir == filepath.Join(home, projectDirName) {
+				return withKind(wedev.ErrValidation, fmt.Errorf("%s is the legacy global database directory, not a project; run init in a project directory", projectDir))
+			}
+			if _, err := os.Stat(projectDir); err == nil {
+				return withKind(wedev.ErrAlreadyExists, fmt.Errorf("%s already exists", projectDir))
+			}
+			timeout, err := dbTimeout(cmd, args)
+			if err != nil {
+				return err
+			}
+			level, err := logLevel(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(projectDir, 0o700); err != nil {
+				return fmt.Errorf("failed to create %s: %w", projectDir, wedev.DiagnoseOpenError(projectDir, err))
+			}
+			ignore := "# Written while a wedevctl command runs\n*.pid\n"
+			if err := os.WriteFile(filepath.Join(projectDir, ".gitignore"), []byte(ignore), 0o644); err != nil {
+				return fmt.Errorf("failed to write .gitignore: %w", err)
+			}
+			dbPath := filepath.Join(projectDir, dbFileName)
+			sm, err := wedev.NewStorageManagerWithOptionsCtx(cmd.Context(), dbPath, wedev.StorageOptions{
+				LockTimeout: timeout,
+				Logger:      wedev.NewLogger(cmd.ErrOrStderr(), level),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create database: %w", err)
+			}
+			if err := sm.Close(); err != nil {
+				return fmt.Errorf("failed to close database: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Created: %s\n", dbPath)
+			fmt.Fprintf(out, "Commands run in %s or below it now use this database (--db and $WEDEVCTL_DB_PATH still take precedence).\n", dir)
+			fmt.Fprintln(out, "Run 'wedevctl db encrypt' before committing it: it holds private keys.")
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"github.com/spf13/pflag"
+	"github.com/wedevctl/version"
+	"github.com/wedevctl/wedev"
+)
+
+// docsNetworkName stands for the network name in the documented
+// 'vn <network> ...' commands.
+const docsNetworkName = "<network>"
+
+// NewDocsCommand creates the 'docs' command group.
+func NewDocsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate offline documentation",
+		// The command tree is documented without a database.
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+	}
+
+	cmd.AddCommand(NewDocsGenerateCommand())
+
+	return cmd
+}
+
+// NewDocsGenerateCommand creates the 'docs generate' command.
+func NewDocsGenerateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate --dir <dir> [--format man|markdown]",
+		Short: "Write a man page or markdown page for every command",
+		Long: `Write one page per command to a directory, for machines without access to
+the website. Pages cover every flag and the examples of each command's help,
+including the commands under 'vn <network>', which are documented once with
+<network> standing for the network name.
+
+Man pages are named like wedevctl-vn-network-node-add.1 and markdown pages
+like wedevctl_vn_network_node_add.md. The directory is created if needed and
+existing pages are overwritten. The database is not used.
+
+Examples:
+  wedevctl docs generate --dir ./man --format man
+  sudo cp man/*.1 /usr/local/share/man/man1/
+  wedevctl docs generate --dir ./docs`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			dir, err := cmd.Flags().GetString("dir")
+			if err != nil {
+				return fmt.Errorf("failed to get dir flag: %w", err)
+			}
+			format, err := cmd.Flags().GetString("format")
+			if err != nil {
+				return fmt.Errorf("failed to get format flag: %w", err)
+			}
+			if format != "man" && format != "markdown" {
+				return withKind(wedev.ErrValidation, fmt.Errorf("invalid format: %s (must be 'man' or 'markdown')", format))
+			}
+
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			written, err := writeDocs(documentedCommandTree(), dir, format)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d %s pages to %s\n", written, format, dir)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("dir", "", "Directory to write the pages to")
+	cmd.Flags().String("format", "markdown", "Page format (man or markdown)")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.MarkFlagRequired("dir")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{"man", "markdown"}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+// documentedCommandTree returns the full command tree: the root's commands
+// and, under 'vn', the commands 'vn' builds for a network when it runs,
+// named for docsNetworkName. Building the tree looks nothing up.
+func documentedCommandTree() *cobra.Command {
+	app := &App{}
+	root := newRootCommand(app)
+	for _, sub := range root.Commands() {
+		if sub.Name() == "vn" {
+			sub.AddCommand(makeNetworkCommand(app, docsNetworkName))
+		}
+	}
+	return root
+}
+
+// documentedCommands returns cmd and the commands below it that get a page:
+// those cobra lists in help, so neither hidden nor deprecated ones.
+func documentedCommands(cmd *cobra.Command) []*cobra.Command {
+	commands := []*cobra.Command{cmd}
+	for _, sub := range cmd.Commands() {
+		if !sub.IsAvailableCommand() || sub.IsAdditionalHelpTopicCommand() {
+			continue
+		}
+		commands = append(commands, documentedCommands(sub)...)
+	}
+	return commands
+}
+
+// docsFileName returns the name of the page of cmd in format, after cobra's
+// own naming with the brackets of docsNetworkName dropped.
+func docsFileName(cmd *cobra.Command, format string) string {
+	name := strings.NewReplacer("<", "", ">", "").Replace(cmd.CommandPath())
+	if format == "man" {
+		return strings.ReplaceAll(name, " ", "-") + ".1"
+	}
+	return strings.ReplaceAll(name, " ", "_") + ".md"
+}
+
+// writeDocs writes the page of every documented command of root to dir and
+// returns how many it wrote. Pages carry no generation time: man pages are
+// dated with the binary's build date where it is known, so regenerating them
+// gives the same files. The help text of root's commands is rewritten for the
+// generators on the way.
+func writeDocs(root *cobra.Command, dir, format string) (int, error) {
+	var date *time.Time
+	if built, err := time.Parse(time.RFC3339, version.Get().Date); err == nil {
+		date = &built
+	}
+
+	commands := documentedCommands(root)
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = docsFileName(cmd, format)
+	}
+	prepareDocs(commands, format)
+
+	for i, cmd := range commands {
+		path := filepath.Join(dir, names[i])
+		f, err := os.Create(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		if format == "man" {
+			header := &doc.GenManHeader{
+				Title:   strings.ToUpper(strings.TrimSuffix(names[i], ".1")),
+				Section: "1",
+				Source:  "wedevctl " + version.Version,
+				Manual:  "wedevctl Manual",
+				Date:    date,
+			}
+			err = doc.GenMan(cmd, header, f)
+		} else {
+			err = doc.GenMarkdownCustom(cmd, f, func(name string) string {
+				return strings.NewReplacer("<", "", ">", "").Replace(name)
+			})
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return len(commands), nil
+}
+
+// prepareDocs rewrites the help of commands for cobra's generators, which
+// treat it as markdown: descriptions go through docsMarkdown. For man pages,
+// which are rendered from markdown throughout, the usage lines, short
+// descriptions and flag usages are escaped too.
+func prepareDocs(commands []*cobra.Command, format string) {
+	escaped := map[*pflag.Flag]bool{}
+	for _, cmd := range commands {
+		cmd.DisableAutoGenTag = true
+		cmd.Long = docsMarkdown(cmd.Long)
+		if format != "man" {
+			continue
+		}
+		cmd.Use = escapeMarkdown(cmd.Use)
+		cmd.Short = escapeMarkdown(cmd.Short)
+		for _, flags := range []*pflag.FlagSet{cmd.LocalFlags(), cmd.InheritedFlags()} {
+			flags.VisitAll(func(f *pflag.Flag) {
+				if !escaped[f] {
+					f.Usage = escapeMarkdown(f.Usage)
+					escaped[f] = true
+				}
+			})
+		}
+	}
+}
+
+// docsMarkdown turns help text into markdown: runs of indented lines, such
+// as examples, become code blocks so they keep their layout, and the rest is
+// escaped so <placeholders> and the like show as written. Blank lines
+// between indented ones stay in the block.
+func docsMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	indented := func(i int) bool {
+		return strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t")
+	}
+
+	var b strings.Builder
+	inCode := false
+	for i, line := range lines {
+		code := indented(i)
+		if line == "" && inCode {
+			next := i + 1
+			for next < len(lines) && lines[next] == "" {
+				next++
+			}
+			code = next < len(lines) && indented(next)
+		}
+		switch {
+		case code && !inCode:
+			b.WriteString("```\n")
+		case !code && inCode:
+			b.WriteString("```\n")
+		}
+		inCode = code
+		if !inCode {
+			line = escapeMarkdown(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	if inCode {
+		b.WriteString("```\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// markdownEscaper backslash-escapes the characters markdown gives meaning.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+// escapeMarkdown escapes s for use as markdown text.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
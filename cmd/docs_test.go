@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// TestCLIDocsGenerate checks that every command, including those under
+// 'vn <network>', gets a page naming each of its flags and example lines.
+func TestCLIDocsGenerate(t *testing.T) {
+	dbDir := t.TempDir()
+	t.Setenv("WEDEVCTL_DB_PATH", dbDir)
+
+	for _, format := range []string{"markdown", "man"} {
+		t.Run(format, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "docs")
+			if _, err := runCLI(t, "", "docs", "generate", "--dir", dir, "--format", format); err != nil {
+				t.Fatalf("docs generate error = %v", err)
+			}
+
+			commands := documentedCommands(documentedCommandTree())
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir() error = %v", err)
+			}
+			if len(entries) != len(commands) {
+				t.Errorf("wrote %d pages for %d commands", len(entries), len(commands))
+			}
+
+			for _, cmd := range commands {
+				name := docsFileName(cmd, format)
+				page, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("%s: no page: %v", cmd.CommandPath(), err)
+					continue
+				}
+				for _, want := range docsExpectations(cmd) {
+					if !strings.Contains(string(page), want) {
+						t.Errorf("%s does not mention %q", name, want)
+					}
+				}
+			}
+			networkPage := map[string]string{"markdown": "wedevctl_vn_network_node_edit.md", "man": "wedevctl-vn-network-node-edit.1"}[format]
+			if _, err := os.Stat(filepath.Join(dir, networkPage)); err != nil {
+				t.Errorf("the commands under 'vn <network>' are not documented: %v", err)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dbDir, "wedevctl.db")); !os.IsNotExist(err) {
+		t.Errorf("docs generate created the database (stat error = %v)", err)
+	}
+	if _, err := runCLI(t, "", "docs", "generate", "--dir", t.TempDir(), "--format", "html"); ExitCode(err) != ExitValidation {
+		t.Errorf("docs generate --format html exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+}
+
+// docsExpectations lists what the page of cmd must contain: the name of
+// each of its flags and each line of its examples.
+func docsExpectations(cmd *cobra.Command) []string {
+	var want []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Hidden {
+			want = append(want, "--"+f.Name)
+		}
+	})
+	for _, line := range strings.Split(cmd.Long, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "wedevctl ") {
+			want = append(want, line)
+		}
+	}
+	return want
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+// makeGuestCommand creates the 'guest' command group for a specific network.
+func makeGuestCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "guest",
+		Short: "Hand out temporary configs to third parties",
+		Long: fmt.Sprintf(`Manage the guests of virtual network '%s': one-off clients, such as an
+external partner's machine, that are not nodes. A guest holds a virtual IP
+until it expires or is revoked and is a peer in its server's config only.
+Its private key is never stored, so its config is printed once, when it is
+created.`, networkName),
+	}
+
+	cmd.AddCommand(makeGuestCreateCommand(app, networkName))
+	cmd.AddCommand(makeGuestListCommand(app, networkName))
+	cmd.AddCommand(makeGuestRevokeCommand(app, networkName))
+
+	return cmd
+}
+
+// makeGuestCreateCommand creates the 'guest create' command.
+func makeGuestCreateCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "create <guest-name> (--ttl <duration> | --expires <date>) [--server <name>] [--qr]",
+		Annotations: withKeys(nil),
+		Short:       "Create a guest and print its config",
+		Long: fmt.Sprintf(`Create a guest with a fresh key pair and an address from the network's IP
+pool, add it as a peer to its server's config and save a new config version,
+then print the guest's config, or with --qr a QR code of it for the
+WireGuard mobile apps. The config goes to standard output and everything
+else to standard error, so it can be redirected to a file.
+
+The guest's private key is not stored: keep the printed config, it cannot be
+shown again. Deploy the server's config (for example with 'config apply')
+for the guest to connect. Once the guest expires it is left out of generated
+configs, and its address is released by the next 'guest create' or
+'config generate'.
+
+Examples:
+  wedevctl vn %[1]s guest create acme --ttl 24h > acme.conf
+  wedevctl vn %[1]s guest create auditor --expires 2024-08-01 --qr`, networkName),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+			errOut := cmd.ErrOrStderr()
+
+			expiresAt, _, err := parseExpiryFlags(cmd)
+			if err != nil {
+				return withKind(wedev.ErrValidation, err)
+			}
+			if expiresAt == nil {
+				return withKind(wedev.ErrValidation, fmt.Errorf("a guest needs an expiry: give --ttl or --expires"))
+			}
+			serverName, err := cmd.Flags().GetString("server")
+			if err != nil {
+				return fmt.Errorf("failed to get server flag: %w", err)
+			}
+			qr, err := cmd.Flags().GetBool("qr")
+			if err != nil {
+				return fmt.Errorf("failed to get qr flag: %w", err)
+			}
+
+			guest, err := app.vnManager.CreateGuest(networkName, args[0], serverName, *expiresAt)
+			if err != nil {
+				return fmt.Errorf("failed to create guest: %w", err)
+			}
+			// Without a saved version the server would never learn the
+			// guest, so a failure here takes the guest back out.
+			version, created, err := app.generator.SaveConfigVersionWithMessageCtx(cmd.Context(), networkName, fmt.Sprintf("guest %s created", guest.Name))
+			if err == nil {
+				var config string
+				if config, err = app.generator.GuestConfig(cmd.Context(), networkName, guest); err == nil {
+					err = printGuestConfig(out, config, qr)
+				}
+			}
+			if err != nil {
+				if _, revokeErr := app.vnManager.RevokeGuest(networkName, guest.Name); revokeErr != nil {
+					app.storage.Logger().Warn("failed to remove guest after a failed create", "guest", guest.Name, "error", revokeErr)
+				}
+				return fmt.Errorf("failed to create guest: %w", err)
+			}
+
+			fmt.Fprintf(errOut, "Guest '%s' created with IP %s, access until %s\n", guest.Name, guest.VirtualIP, guest.ExpiresAt.Local().Format("2006-01-02 15:04"))
+			printSavedVersion(errOut, version, created, false)
+			fmt.Fprintln(errOut, "The guest's private key is not stored; keep this config. Deploy the server's config for the guest to connect.")
+			return nil
+		},
+	}
+
+	expiryFlags(cmd, false)
+	cmd.Flags().String("server", "", "Server the guest connects to (default: the network's only server)")
+	cmd.Flags().Bool("qr", false, "Print the config as a QR code")
+	_ = cmd.RegisterFlagCompletionFunc("server", completeServerFlag(networkName))
+
+	return cmd
+}
+
+// printGuestConfig writes a guest's config to w, as text or a QR code.
+func printGuestConfig(w io.Writer, config string, qr bool) error {
+	if !qr {
+		_, err := io.WriteString(w, config)
+		return err
+	}
+	code, err := qrcode.New(wedev.StripComments(config), qrcode.Low)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	_, err = io.WriteString(w, code.ToSmallString(false))
+	return err
+}
+
+// guestListEntry is a guest as 'guest list' shows it.
+type guestListEntry struct {
+	Name      string    `json:"name"`
+	VirtualIP string    `json:"virtual_ip"`
+	Server    string    `json:"server"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired"`
+}
+
+// makeGuestListCommand creates the 'guest list' command.
+func makeGuestListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List guests",
+		Long: `List the guests of the network, sorted by name. Expired guests are listed
+until their address is released (see 'guest create').`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			guests, err := app.vnManager.ListGuests(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list guests: %w", err)
+			}
+			servers, err := app.vnManager.ListServersCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list servers: %w", err)
+			}
+
+			now := time.Now()
+			entries := make([]guestListEntry, 0, len(guests))
+			for _, guest := range guests {
+				entry := guestListEntry{Name: guest.Name, VirtualIP: guest.VirtualIP, PublicKey: guest.PublicKey, CreatedAt: guest.CreatedAt, ExpiresAt: guest.ExpiresAt, Expired: guest.Expired(now)}
+				for _, server := range servers {
+					if server.ID == guest.ServerID {
+						entry.Server = server.Name
+					}
+				}
+				entries = append(entries, entry)
+			}
+			switch output {
+			case "json":
+				return printJSON(out, entries)
+			case "yaml":
+				return printYAML(out, entries)
+			}
+
+			rows := make([][]string, 0, len(entries))
+			for _, entry := range entries {
+				server := entry.Server
+				if server == "" {
+					server = "-"
+				}
+				rows = append(rows, []string{entry.Name, entry.VirtualIP, server, formatExpiry(&entry.ExpiresAt)})
+			}
+			return printListTable(cmd, out, "No guests found", []string{"Name", "Virtual IP", "Server", "Expires"}, rows)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// makeGuestRevokeCommand creates the 'guest revoke' command.
+func makeGuestRevokeCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "revoke <guest-name> [--yes]",
+		Annotations: withKeys(nil),
+		Short:       "End a guest's access before it expires",
+		Long: `Delete a guest, release its virtual IP and save a config version without
+it. Deploy the server's config for the revocation to take effect.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeGuestNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			yes, err := cmd.Flags().GetBool("yes")
+			if err != nil {
+				return fmt.Errorf("failed to get yes flag: %w", err)
+			}
+			if !yes && !confirmAction(cmd, fmt.Sprintf("Revoke guest '%s'?", args[0]), assumeFor(app, promptDelete)) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+
+			guest, err := app.vnManager.RevokeGuest(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to revoke guest: %w", err)
+			}
+			fmt.Fprintf(out, "Revoked guest '%s' and released %s\n", guest.Name, guest.VirtualIP)
+
+			version, created, err := app.generator.SaveConfigVersionWithMessageCtx(cmd.Context(), networkName, fmt.Sprintf("guest %s revoked", guest.Name))
+			if err != nil {
+				return fmt.Errorf("guest revoked, but saving a config version failed; run 'config generate': %w", err)
+			}
+			printSavedVersion(out, version, created, false)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("yes", "y", false, "Skip the confirmation prompt")
+
+	return cmd
+}
+
+// releaseExpiredGuests purges the expired guests of a network, releasing
+// their addresses, and reports each on w.
+func releaseExpiredGuests(app *App, w io.Writer, networkName string) error {
+	purged, err := app.vnManager.PurgeExpiredGuests(networkName)
+	if err != nil {
+		return fmt.Errorf("failed to release expired guests: %w", err)
+	}
+	for _, guest := range purged {
+		fmt.Fprintf(w, "Released expired guest '%s' (%s)\n", guest.Name, guest.VirtualIP)
+	}
+	return nil
+}
+
+// completeGuestNames completes the first argument with the network's guest
+// names.
+func completeGuestNames(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			guests, err := sm.ListGuestsByNetworkID(network.ID)
+			if err != nil {
+				return nil
+			}
+			var names []string
+			for _, guest := range guests {
+				if strings.HasPrefix(guest.Name, toComplete) {
+					names = append(names, guest.Name+"\t"+guest.VirtualIP)
+				}
+			}
+			return names
+		}), cobra.ShellCompDirectiveNoFileComp
+	}
+}
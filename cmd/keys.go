@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+// annotationKeys marks commands that read or store private keys: they
+// unlock an encrypted database before running (see App.unlockKeys).
+const annotationKeys = "wedevctl/keys"
+
+// passphraseEnv is the environment variable holding the passphrase of an
+// encrypted database, read when --passphrase-file is not given.
+const passphraseEnv = "WEDEVCTL_PASSPHRASE"
+
+// withKeys adds annotationKeys to annotations, which may be nil.
+func withKeys(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[annotationKeys] = "true"
+	return annotations
+}
+
+// needsKeys reports whether cmd, run with args, reads or stores private
+// keys. Like opensReadOnly, it resolves the command a 'vn <network> ...'
+// line runs from the raw arguments.
+func needsKeys(app *App, cmd *cobra.Command, args []string) bool {
+	return resolveCommand(app, cmd, args).Annotations[annotationKeys] == "true"
+}
+
+// passphraseFileFlag returns the --passphrase-file value, empty when it is
+// not given. Like dbFlag, it reads the raw arguments of commands under 'vn'.
+func passphraseFileFlag(cmd *cobra.Command, args []string) (string, error) {
+	if cmd.DisableFlagParsing {
+		value := ""
+		for i, arg := range args {
+			if v, ok := strings.CutPrefix(arg, "--passphrase-file="); ok {
+				value = v
+			} else if arg == "--passphrase-file" && i+1 < len(args) {
+				value = args[i+1]
+			}
+		}
+		return value, nil
+	}
+	if cmd.Flags().Lookup("passphrase-file") == nil {
+		return "", nil
+	}
+	value, err := cmd.Flags().GetString("passphrase-file")
+	if err != nil {
+		return "", fmt.Errorf("failed to get passphrase-file flag: %w", err)
+	}
+	return value, nil
+}
+
+// unlockKeys unlocks the private keys of an encrypted database with the
+// passphrase from --passphrase-file, $WEDEVCTL_PASSPHRASE, or a prompt.
+// It does nothing when the database is not encrypted or already unlocked.
+func (app *App) unlockKeys(cmd *cobra.Command) error {
+	if app.storage.Unlocked() {
+		return nil
+	}
+	passphrase, err := app.readPassphrase(cmd, false)
+	if err != nil {
+		return err
+	}
+	if err := app.storage.Unlock(passphrase); err != nil {
+		return fmt.Errorf("failed to unlock private keys: %w", err)
+	}
+	return nil
+}
+
+// readPassphrase returns the passphrase given by --passphrase-file or
+// $WEDEVCTL_PASSPHRASE, or else prompts for it on stderr and reads it from
+// the command's input, without echo on a terminal. With confirm the prompt
+// asks for it twice. An empty passphrase is an error.
+func (app *App) readPassphrase(cmd *cobra.Command, confirm bool) (string, error) {
+	var passphrase string
+	switch env := os.Getenv(passphraseEnv); {
+	case app.passphraseFile != "":
+		data, err := os.ReadFile(app.passphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	case env != "":
+		passphrase = env
+	default:
+		in := bufio.NewReader(cmd.InOrStdin())
+		var err error
+		if passphrase, err = promptPassphrase(cmd, in, "Passphrase: "); err != nil {
+			return "", err
+		}
+		if confirm {
+			again, err := promptPassphrase(cmd, in, "Repeat passphrase: ")
+			if err != nil {
+				return "", err
+			}
+			if again != passphrase {
+				return "", withKind(wedev.ErrValidation, fmt.Errorf("passphrases do not match"))
+			}
+		}
+	}
+	if passphrase == "" {
+		return "", withKind(wedev.ErrKeysLocked, fmt.Errorf("the database's private keys are encrypted and no passphrase was given: use --passphrase-file or $%s", passphraseEnv))
+	}
+	return passphrase, nil
+}
+
+// promptPassphrase prints prompt on stderr and reads a line from in. Echo
+// is turned off with stty while a terminal is read.
+func promptPassphrase(cmd *cobra.Command, in *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(cmd.ErrOrStderr(), prompt)
+	if tty, ok := cmd.InOrStdin().(*os.File); ok && isTerminal(tty) {
+		if _, err := stty(tty, "-echo"); err == nil {
+			//nolint:errcheck // Best effort; nothing more to do if restoring fails
+			defer func() { _, _ = stty(tty, "echo") }()
+			defer fmt.Fprintln(cmd.ErrOrStderr())
+		}
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty runs stty with args against tty and returns its output.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
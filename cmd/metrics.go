@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+// metricsFileFlag returns the --metrics-file value, empty when it is not
+// given. Like dbFlag, it reads the raw arguments of commands under 'vn'.
+func metricsFileFlag(cmd *cobra.Command, args []string) (string, error) {
+	if cmd.DisableFlagParsing {
+		value := ""
+		for i, arg := range args {
+			if v, ok := strings.CutPrefix(arg, "--metrics-file="); ok {
+				value = v
+			} else if arg == "--metrics-file" && i+1 < len(args) {
+				value = args[i+1]
+			}
+		}
+		return value, nil
+	}
+	value, err := cmd.Flags().GetString("metrics-file")
+	if err != nil {
+		return "", fmt.Errorf("failed to get metrics-file flag: %w", err)
+	}
+	return value, nil
+}
+
+// startMetrics starts collecting metrics for the run when --metrics-file is
+// given. The collector goes to the storage options, so every transaction is
+// counted.
+func (app *App) startMetrics(cmd *cobra.Command, args []string) error {
+	path, err := metricsFileFlag(cmd, args)
+	if err != nil || path == "" {
+		return err
+	}
+	app.metrics = wedev.NewMetrics()
+	app.metricsFile = path
+	app.metricsStart = time.Now()
+	return nil
+}
+
+// finishMetrics records the outcome of cmd, run with args, takes a snapshot
+// of the database if it is open and writes the metrics file. It runs once
+// per run, when the command returns or the database fails to open. A metrics
+// file that cannot be written is a warning: the command's own outcome
+// stands.
+func (app *App) finishMetrics(cmd *cobra.Command, args []string, runErr error) {
+	metrics := app.metrics
+	if metrics == nil {
+		return
+	}
+	app.metrics = nil
+
+	metrics.ObserveCommand(metricsCommand(app, cmd, args), time.Since(app.metricsStart), runErr)
+	if app.storage != nil {
+		db, err := app.storage.DatabaseMetrics(cmd.Context())
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to read database metrics: %v\n", err)
+		}
+		metrics.SetDatabase(db)
+	}
+	if err := metrics.WriteFile(app.metricsFile, time.Now()); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+	}
+}
+
+// instrumentCommands wraps the RunE of cmd and its subcommands to finish
+// the run's metrics when they return. PersistentPostRunE does not run after
+// a failed command, so it cannot do this.
+func instrumentCommands(app *App, cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			err := run(cmd, args)
+			app.finishMetrics(cmd, args, err)
+			return err
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		instrumentCommands(app, sub)
+	}
+}
+
+// metricsCommand names cmd, run with args, in metrics: its path below the
+// root, with 'vn <network> ...' lines as 'vn ...', so that no series is
+// kept per network name.
+func metricsCommand(app *App, cmd *cobra.Command, args []string) string {
+	target := resolveCommand(app, cmd, args)
+	path := strings.Fields(target.CommandPath())
+	if target.Root() != cmd.Root() {
+		// A network command tree is its own root, named after the network.
+		return strings.Join(append([]string{"vn"}, path[1:]...), " ")
+	}
+	return strings.Join(path[1:], " ")
+}
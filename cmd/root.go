@@ -2,106 +2,438 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/wedevctl/util"
+	"github.com/wedevctl/version"
 	"github.com/wedevctl/wedev"
+	"gopkg.in/yaml.v3"
 )
 
-var dbPath string
-var storage *wedev.StorageManager
-var vnManager *wedev.VirtualNetworkManager
-var validator util.IPValidator
+// Exit codes returned by ExitCode, so scripts can tell failures apart.
+const (
+	ExitOK            = 0
+	ExitError         = 1 // any other failure
+	ExitNotFound      = 2 // a network, server, node or config version does not exist
+	ExitAlreadyExists = 3 // a name, public key or endpoint is already in use
+	ExitValidation    = 4 // an argument or spec was rejected
+	ExitPoolExhausted = 5 // no free virtual IP or port is left
+	ExitDBLocked      = 6 // another process held the database past --db-timeout
+	ExitConflict      = 7 // the record changed during an edit; re-run it
+	ExitKeysLocked    = 8 // the private keys are encrypted and the passphrase is missing or wrong
+	ExitFrozen        = 9 // the network's config is frozen; see 'config unfreeze'
+)
+
+// exitCodeHelp documents the exit codes in the root command's help.
+const exitCodeHelp = `Exit codes:
+  0  success
+  1  any other error
+  2  not found (network, server, node, or config version)
+  3  already exists (name, public key, or endpoint in use)
+  4  validation failed (malformed argument or spec)
+  5  IP pool or port range exhausted
+  6  database locked by another process (see --db-timeout)
+  7  edit conflict: another process changed the record (re-run the edit)
+  8  private keys encrypted: passphrase missing or wrong (see 'db encrypt')
+  9  config frozen: no new version is saved (see 'config freeze')`
+
+// ExitCode maps an error returned by the root command to the process exit
+// code: ExitOK for nil, the code of its wedev error kind, or ExitError.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, wedev.ErrDBLocked):
+		return ExitDBLocked
+	case errors.Is(err, wedev.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, wedev.ErrAlreadyExists):
+		return ExitAlreadyExists
+	case errors.Is(err, wedev.ErrPoolExhausted):
+		return ExitPoolExhausted
+	case errors.Is(err, wedev.ErrValidation):
+		return ExitValidation
+	case errors.Is(err, wedev.ErrConflict):
+		return ExitConflict
+	case errors.Is(err, wedev.ErrKeysLocked):
+		return ExitKeysLocked
+	case errors.Is(err, wedev.ErrFrozen):
+		return ExitFrozen
+	default:
+		return ExitError
+	}
+}
+
+// kindError gives an error written by a command one of the wedev error
+// kinds, so ExitCode maps it, without changing its message.
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// withKind tags err with kind.
+func withKind(kind, err error) error {
+	return &kindError{err: err, kind: kind}
+}
 
 // NewRootCommand creates the root CLI command
 func NewRootCommand() *cobra.Command {
+	return newRootCommand(&App{})
+}
+
+// newRootCommand creates the root CLI command around app. The database is
+// opened into app before any command runs, unless it is already open.
+func newRootCommand(app *App) *cobra.Command {
 	root := &cobra.Command{
 		Use:   "wedevctl",
 		Short: "WeDev resource management CLI tool",
-		Long:  "wedevctl is a CLI tool for managing WeDev virtual networks and WireGuard configurations",
-		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
-			// Initialize database
-			// Check environment variable first
-			dbDir := os.Getenv("WEDEVCTL_DB_PATH")
-
-			// If not set, use default ~/.wedevctl
-			if dbDir == "" {
-				homeDir, err := os.UserHomeDir()
-				if err != nil {
-					return fmt.Errorf("failed to get home directory: %w", err)
-				}
-				dbDir = filepath.Join(homeDir, ".wedevctl")
+		Long:  "wedevctl is a CLI tool for managing WeDev virtual networks and WireGuard configurations\n\n" + exitCodeHelp,
+		// --version prints the version alone; 'version' adds the build and
+		// database details.
+		Version: version.Version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Shell completion opens the database read-only on demand (see
+			// completionStorage) and must never create or migrate it.
+			if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+				return nil
+			}
+			if app.storage != nil {
+				return nil
 			}
 
-			// Expand relative paths to absolute
-			if !filepath.IsAbs(dbDir) {
-				absDir, err := filepath.Abs(dbDir)
+			if err := app.startMetrics(cmd, args); err != nil {
+				return err
+			}
+			// A command that fails here never runs; its metrics are written
+			// all the same.
+			defer func() {
 				if err != nil {
-					return fmt.Errorf("failed to resolve db path: %w", err)
+					app.finishMetrics(cmd, args, err)
 				}
-				dbDir = absDir
+			}()
+
+			flag, err := dbFlag(cmd, args)
+			if err != nil {
+				return err
+			}
+			env, err := currentDBEnvironment()
+			if err != nil {
+				return err
+			}
+			loc, err := resolveDBLocation(flag, env)
+			if err != nil {
+				return err
 			}
 
-			// Create directory with secure permissions
-			if err := os.MkdirAll(dbDir, 0o700); err != nil {
-				return fmt.Errorf("failed to create db directory: %w", err)
+			timeout, err := dbTimeout(cmd, args)
+			if err != nil {
+				return err
+			}
+			level, err := logLevel(cmd, args)
+			if err != nil {
+				return err
+			}
+			historyLimit, err := historyLimit()
+			if err != nil {
+				return err
+			}
+			assumeValues, err := assumeFlag(cmd, args)
+			if err != nil {
+				return err
+			}
+			if app.assume, err = resolveAssumptions(assumeValues, os.Getenv); err != nil {
+				return err
+			}
+			if app.passphraseFile, err = passphraseFileFlag(cmd, args); err != nil {
+				return err
 			}
 
-			dbPath = filepath.Join(dbDir, "wedevctl.db")
+			opts := wedev.StorageOptions{
+				LockTimeout:  timeout,
+				Logger:       wedev.NewLogger(cmd.ErrOrStderr(), level),
+				ReadOnly:     opensReadOnly(app, cmd, args),
+				HistoryLimit: historyLimit,
+				Metrics:      app.metrics,
+			}
+			dbPath := loc.path
+			if loc.source == dbSourceLegacy {
+				dbPath = offerXDGMigration(cmd, loc, opts)
+			}
 
-			var sErr error
-			storage, sErr = wedev.NewStorageManager(dbPath)
-			if sErr != nil {
-				return fmt.Errorf("failed to initialize storage: %w", sErr)
+			// Create directory with secure permissions
+			if err := os.MkdirAll(filepath.Dir(dbPath), 0o700); err != nil {
+				return fmt.Errorf("failed to create db directory: %w", wedev.DiagnoseOpenError(filepath.Dir(dbPath), err))
 			}
 
-			validator = util.NewDefaultIPValidator()
-			var mnErr error
-			vnManager, mnErr = wedev.NewVirtualNetworkManager(storage, validator)
-			if mnErr != nil {
-				return fmt.Errorf("failed to initialize virtual network manager: %w", mnErr)
+			err = app.open(cmd.Context(), dbPath, opts)
+			if opts.ReadOnly && (errors.Is(err, fs.ErrNotExist) || errors.Is(err, wedev.ErrSchemaOutdated)) {
+				// Only a read-write open creates or migrates the database.
+				opts.ReadOnly = false
+				err = app.open(cmd.Context(), dbPath, opts)
 			}
-			return nil
+			if err != nil || !needsKeys(app, cmd, args) {
+				return err
+			}
+			return app.unlockKeys(cmd)
 		},
 		PersistentPostRunE: func(_cmd *cobra.Command, _args []string) error {
-			if storage != nil {
-				return storage.Close()
-			}
-			return nil
+			return app.close()
 		},
 	}
 
+	globalFlags(root)
+
+	// Replace cobra's default completion command with one limited to the
+	// shells the dynamic completions are tested against.
+	root.CompletionOptions.DisableDefaultCmd = true
+
 	// Add subcommands
-	root.AddCommand(NewVirtualNetworkCommand())
+	root.AddCommand(NewVirtualNetworkCommand(app))
+	root.AddCommand(newNetworkScopedCommands(app)...)
+	root.AddCommand(NewApplyCommand(app))
+	root.AddCommand(NewDBCommand(app))
+	root.AddCommand(NewUICommand(app))
+	root.AddCommand(NewDoctorCommand(app))
+	root.AddCommand(NewInitCommand())
+	root.AddCommand(NewJoinCommand())
+	root.AddCommand(NewDocsCommand())
+	root.AddCommand(NewVersionCommand())
+	root.AddCommand(NewCompletionCommand())
+	instrumentCommands(app, root)
 
 	return root
 }
 
+// annotationReadOnly marks commands that never write the database.
+const annotationReadOnly = "wedevctl/read-only"
+
+// readOnlyAnnotations returns the annotations of a command that never writes
+// the database. Such commands open it read-only, under a shared lock, so
+// they neither block nor wait for each other; only writers exclude them.
+func readOnlyAnnotations() map[string]string {
+	return map[string]string{annotationReadOnly: "true"}
+}
+
+// opensReadOnly reports whether cmd, run with args, only reads the
+// database.
+func opensReadOnly(app *App, cmd *cobra.Command, args []string) bool {
+	return resolveCommand(app, cmd, args).Annotations[annotationReadOnly] == "true"
+}
+
+// resolveCommand returns the command that cmd, run with args, runs. 'vn'
+// routes network commands manually, so the command a 'vn <network> ...'
+// line runs is resolved from the raw arguments; when that fails, cmd
+// itself is returned.
+func resolveCommand(app *App, cmd *cobra.Command, args []string) *cobra.Command {
+	if !cmd.DisableFlagParsing {
+		return cmd
+	}
+	args = args[leadingGlobalFlags(args):]
+	if len(args) == 0 {
+		return cmd
+	}
+	tree := makeNetworkCommand(app, args[0])
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == args[0] || sub.HasAlias(args[0]) {
+			tree = sub
+		}
+	}
+	target, _, err := tree.Find(args[1:])
+	if err != nil {
+		return cmd
+	}
+	return target
+}
+
+// globalFlags declares the persistent flags every command accepts.
+func globalFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("db", "", "Database directory, or database file ending in .db (overrides $WEDEVCTL_DB_PATH and project-local databases)")
+	cmd.PersistentFlags().Duration("db-timeout", wedev.DefaultLockTimeout, "How long to wait for another wedevctl process to release the database")
+	cmd.PersistentFlags().BoolP("verbose", "v", false, "Log debug detail (storage transactions, IP pool decisions) to stderr")
+	cmd.PersistentFlags().BoolP("quiet", "q", false, "Log only errors to stderr")
+	cmd.PersistentFlags().StringArray("assume", nil, "Answer confirmation prompts without asking: yes, no, or <create|delete|overwrite>=yes|no (repeatable; overrides $WEDEVCTL_ASSUME)")
+	cmd.PersistentFlags().String("passphrase-file", "", "File holding the passphrase of an encrypted database (overrides $WEDEVCTL_PASSPHRASE)")
+	cmd.PersistentFlags().String("metrics-file", "", "Write command and storage timings and database metrics to this file on exit, in Prometheus textfile format")
+	cmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+}
+
+// logLevel returns the log level selected by --verbose or --quiet; warnings
+// and above by default. Like dbTimeout, it reads the raw arguments of
+// commands under 'vn'.
+func logLevel(cmd *cobra.Command, args []string) (slog.Level, error) {
+	var verbose, quiet bool
+	if cmd.DisableFlagParsing {
+		for _, arg := range args {
+			switch arg {
+			case "--verbose", "-v":
+				verbose = true
+			case "--quiet", "-q":
+				quiet = true
+			}
+		}
+	} else {
+		var err error
+		if verbose, err = cmd.Flags().GetBool("verbose"); err != nil {
+			return 0, fmt.Errorf("failed to get verbose flag: %w", err)
+		}
+		if quiet, err = cmd.Flags().GetBool("quiet"); err != nil {
+			return 0, fmt.Errorf("failed to get quiet flag: %w", err)
+		}
+	}
+
+	switch {
+	case verbose && quiet:
+		return 0, fmt.Errorf("--verbose and --quiet cannot be combined")
+	case verbose:
+		return slog.LevelDebug, nil
+	case quiet:
+		return slog.LevelError, nil
+	default:
+		return slog.LevelWarn, nil
+	}
+}
+
+// dbTimeout returns the --db-timeout value. Commands under 'vn' disable
+// flag parsing for manual routing, so for them the flag is read from the raw
+// arguments.
+func dbTimeout(cmd *cobra.Command, args []string) (time.Duration, error) {
+	timeout := wedev.DefaultLockTimeout
+	if cmd.DisableFlagParsing {
+		for i, arg := range args {
+			value, ok := strings.CutPrefix(arg, "--db-timeout=")
+			if !ok && arg == "--db-timeout" && i+1 < len(args) {
+				value, ok = args[i+1], true
+			}
+			if ok {
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return 0, fmt.Errorf("invalid --db-timeout %q: %w", value, err)
+				}
+				timeout = d
+			}
+		}
+	} else if flag := cmd.Flags().Lookup("db-timeout"); flag != nil {
+		d, err := cmd.Flags().GetDuration("db-timeout")
+		if err != nil {
+			return 0, fmt.Errorf("failed to get db-timeout flag: %w", err)
+		}
+		timeout = d
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("--db-timeout must be positive, got %s", timeout)
+	}
+	return timeout, nil
+}
+
+// dbFlag returns the --db value, empty when it is not given. Like
+// dbTimeout, it reads the raw arguments of commands under 'vn'.
+func dbFlag(cmd *cobra.Command, args []string) (string, error) {
+	if cmd.DisableFlagParsing {
+		value := ""
+		for i, arg := range args {
+			if v, ok := strings.CutPrefix(arg, "--db="); ok {
+				value = v
+			} else if arg == "--db" && i+1 < len(args) {
+				value = args[i+1]
+			}
+		}
+		return value, nil
+	}
+	if cmd.Flags().Lookup("db") == nil {
+		return "", nil
+	}
+	value, err := cmd.Flags().GetString("db")
+	if err != nil {
+		return "", fmt.Errorf("failed to get db flag: %w", err)
+	}
+	return value, nil
+}
+
+// leadingGlobalFlags returns how many args, from the start, are global
+// flags and their values. 'vn' does not parse flags, so global flags given
+// before it reach it ahead of the network name.
+func leadingGlobalFlags(args []string) int {
+	i := 0
+	for i < len(args) {
+		switch arg := args[i]; {
+		case arg == "--db", arg == "--db-timeout", arg == "--assume", arg == "--metrics-file", arg == "--passphrase-file":
+			i += 2
+		case strings.HasPrefix(arg, "--db="), strings.HasPrefix(arg, "--db-timeout="), strings.HasPrefix(arg, "--assume="), strings.HasPrefix(arg, "--metrics-file="),
+			strings.HasPrefix(arg, "--passphrase-file="),
+			arg == "--verbose", arg == "-v", arg == "--quiet", arg == "-q":
+			i++
+		default:
+			return i
+		}
+	}
+	return min(i, len(args))
+}
+
+// historyLimit returns $WEDEVCTL_HISTORY_LIMIT, the number of revisions kept
+// per server and node, or 0 for the default when it is not set.
+func historyLimit() (int, error) {
+	value := os.Getenv("WEDEVCTL_HISTORY_LIMIT")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid $WEDEVCTL_HISTORY_LIMIT %q: must be a positive number", value)
+	}
+	return limit, nil
+}
+
 // NewVirtualNetworkCommand creates the 'vn' command group
-func NewVirtualNetworkCommand() *cobra.Command {
+func NewVirtualNetworkCommand(app *App) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "vn [network-name]",
-		Short: "Manage virtual networks",
+		Use:     "vn [network-name]",
+		Aliases: []string{"net"},
+		Short:   "Manage virtual networks",
 		Long: `Create, list, and manage virtual networks.
 
 Without arguments: shows available commands
 With network-name: manage specific network resources
 
+The network may be given by the start of its name when no other network
+starts the same way; a network with exactly that name always wins. 'net'
+is short for 'vn', 'ls' for list, 'rm' for delete and 'gen' for config
+generate.
+
 Examples:
   wedevctl vn add prod-net 10.0.0.0/24
   wedevctl vn list
   wedevctl vn prod-net server add server1 example.com
   wedevctl vn prod-net node add node1 192.168.1.1 51821 peer
-  wedevctl vn prod-net config generate`,
+  wedevctl vn prod-net config generate
+  wedevctl net prod gen`,
 		// Disable flag parsing for this command since we handle routing manually
 		DisableFlagParsing: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			// Global flags given before vn (wedevctl --db dir vn prod ...)
+			// were read when the database was opened; skip them.
+			args = args[leadingGlobalFlags(args):]
+
 			// If no args, show help
 			if len(args) == 0 {
 				return c.Help()
@@ -109,743 +441,7296 @@ Examples:
 
 			networkName := args[0]
 
-			// Check if this is a direct subcommand (add, list, delete)
-			switch networkName {
-			case "add", "list", "delete":
-				// Re-enable normal command processing for these
-				for _, cmd := range c.Commands() {
-					if cmd.Name() == networkName {
-						cmd.SetArgs(args[1:])
-						return cmd.Execute()
-					}
+			// Check if this is a direct subcommand (add, list, edit, delete,
+			// rename, clone, import-wg) or an alias of one
+			for _, cmd := range c.Commands() {
+				if cmd.Name() == networkName || cmd.HasAlias(networkName) {
+					// Re-enable normal command processing for these
+					cmd.SetArgs(args[1:])
+					return cmd.ExecuteContext(c.Context())
 				}
-				return c.Help()
 			}
 
-			// Validate network exists
-			_, err := storage.GetNetworkByName(networkName)
+			// The network may be given by a unique prefix of its name
+			networkName, err := resolveNetworkName(c.Context(), app.storage, networkName)
 			if err != nil {
-				return fmt.Errorf("network '%s' not found. Use 'wedevctl vn list' to see available networks", networkName)
+				return err
 			}
 
-			// Create dynamic subcommand for this network
-			networkCmd := &cobra.Command{
-				Use:   networkName,
-				Short: fmt.Sprintf("Manage network '%s'", networkName),
-				Long:  fmt.Sprintf("Manage servers, nodes, and configurations for virtual network '%s'", networkName),
+			// Create dynamic subcommand for this network. It runs as its
+			// own root, so it takes this command's streams and usage
+			// setting. Output is only handed on when redirected: cobra
+			// prints usage to a set output stream, and to stderr otherwise.
+			networkCmd := makeNetworkCommand(app, networkName)
+			networkCmd.SetIn(c.InOrStdin())
+			networkCmd.SetErr(c.ErrOrStderr())
+			if out := c.OutOrStderr(); out != os.Stderr {
+				networkCmd.SetOut(out)
 			}
-
-			// Disable default completion command on network-scoped commands
-			networkCmd.CompletionOptions.DisableDefaultCmd = true
-
-			// Add server/node/config subcommands with network context
-			networkCmd.AddCommand(makeServerCommand(networkName))
-			networkCmd.AddCommand(makeNodeCommand(networkName))
-			networkCmd.AddCommand(makeConfigCommand(networkName))
+			networkCmd.SilenceUsage = c.Root().SilenceUsage
 
 			// Execute with remaining args
 			if len(args) > 1 {
 				networkCmd.SetArgs(args[1:])
 			}
-			return networkCmd.Execute()
+			return networkCmd.ExecuteContext(c.Context())
 		},
+		ValidArgsFunction: completeVNArgs(app),
 	}
 
-	cmd.AddCommand(NewVNAddCommand())
-	cmd.AddCommand(NewVNListCommand())
-	cmd.AddCommand(NewVNDeleteCommand())
+	cmd.AddCommand(NewVNAddCommand(app))
+	cmd.AddCommand(NewVNListCommand(app))
+	cmd.AddCommand(NewVNEditCommand(app))
+	cmd.AddCommand(NewVNDeleteCommand(app))
+	cmd.AddCommand(NewVNRenameCommand(app))
+	cmd.AddCommand(NewVNCloneCommand(app))
+	cmd.AddCommand(NewVNImportWGCommand(app))
 
 	return cmd
 }
 
-// ========== Virtual Network Commands ==========
+// resolveNetworkName returns the network that name stands for on a 'vn'
+// command line: the network of that name, or else the only one whose name
+// starts with it. With no match the error is ErrNotFound; with several it is
+// ErrValidation, listing them.
+func resolveNetworkName(ctx context.Context, storage *wedev.StorageManager, name string) (string, error) {
+	_, err := storage.GetNetworkByNameCtx(ctx, name)
+	if err == nil {
+		return name, nil
+	}
+	if !errors.Is(err, wedev.ErrNotFound) {
+		return "", fmt.Errorf("failed to get network: %w", err)
+	}
 
-// NewVNAddCommand creates the 'vn add' command
-func NewVNAddCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "add <network-name> <network-cidr>",
-		Short: "Create a new virtual network",
-		Args:  cobra.ExactArgs(2),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			cidr := args[1]
+	networks, err := storage.ListNetworksCtx(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list networks: %w", err)
+	}
+	var matches []string
+	for _, network := range networks {
+		if strings.HasPrefix(network.Name, name) {
+			matches = append(matches, network.Name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", withKind(wedev.ErrNotFound, fmt.Errorf("network '%s' not found. Use 'wedevctl vn list' to see available networks", name))
+	case 1:
+		return matches[0], nil
+	}
+	slices.Sort(matches)
+	return "", withKind(wedev.ErrValidation, fmt.Errorf("network '%s' is ambiguous; it is the start of %s", name, strings.Join(matches, ", ")))
+}
 
-			// Ask for confirmation
-			if !confirmAction(fmt.Sprintf("Create virtual network '%s' with CIDR %s?", name, cidr)) {
-				fmt.Println("Cancelled")
-				return nil
+// makeNetworkCommand creates the dynamic command tree for 'vn <network-name>'.
+func makeNetworkCommand(app *App, networkName string) *cobra.Command {
+	networkCmd := &cobra.Command{
+		Use:   networkName,
+		Short: fmt.Sprintf("Manage network '%s'", networkName),
+		Long:  fmt.Sprintf("Manage servers, nodes, and configurations for virtual network '%s'", networkName),
+	}
+
+	// Disable default completion command on network-scoped commands
+	networkCmd.CompletionOptions.DisableDefaultCmd = true
+
+	// Add server/node/config subcommands with network context
+	networkCmd.AddCommand(makeServerCommand(app, networkName))
+	networkCmd.AddCommand(makeNodeCommand(app, networkName))
+	networkCmd.AddCommand(makeConfigCommand(app, networkName))
+	networkCmd.AddCommand(makeStatusCommand(app, networkName))
+	networkCmd.AddCommand(makeIPCommand(app, networkName))
+	networkCmd.AddCommand(makeGroupCommand(app, networkName))
+	networkCmd.AddCommand(makeGuestCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkEditCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkInfoCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkSetCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkGetCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkUnsetCommand(app, networkName))
+	networkCmd.AddCommand(makeSettingsCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkValidateCommand(app, networkName))
+	networkCmd.AddCommand(makeNetworkCheckCommand(app, networkName))
+	networkCmd.AddCommand(makeFirewallCommand(app, networkName))
+
+	// The root command already applied the global flags when opening the
+	// database; declare them here too so the network's subcommands accept them.
+	globalFlags(networkCmd)
+
+	return networkCmd
+}
+
+// makeNetworkEditCommand creates the 'vn <network> edit' command.
+func makeNetworkEditCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit --cidr <new-cidr>",
+		Short: "Edit network settings",
+		Long: fmt.Sprintf(`Edit settings of virtual network '%s'.
+
+--cidr expands the network to a larger range. The new CIDR must keep the same
+network address with a shorter prefix (for example 10.0.0.0/28 to
+10.0.0.0/24), so every existing address stays valid. Shrinking or moving the
+network is rejected. A new config version is saved automatically because node
+configs route the network CIDR.
+
+A network whose stored CIDR is invalid, such as an IPv6 range written by an
+older build, can be given any valid CIDR that holds its servers' and nodes'
+addresses; its IP pool is rebuilt from them.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			cidr, err := cmd.Flags().GetString("cidr")
+			if err != nil {
+				return fmt.Errorf("failed to get cidr flag: %w", err)
+			}
+			if cidr == "" {
+				return fmt.Errorf("nothing to change (use --cidr)")
 			}
 
-			net, err := vnManager.CreateVirtualNetwork(name, cidr)
+			net, version, err := app.vnManager.ResizeNetwork(networkName, cidr)
 			if err != nil {
-				return fmt.Errorf("failed to create network: %w", err)
+				return fmt.Errorf("failed to update network: %w", err)
 			}
 
-			fmt.Printf("Virtual network '%s' created successfully (ID: %s)\n", net.Name, net.ID)
+			fmt.Fprintf(out, "Virtual network '%s' now uses CIDR %s\n", net.Name, net.CIDR)
+			if version != nil {
+				fmt.Fprintf(out, "Configuration version %d saved; run 'config generate' to write the updated files\n", version.Version)
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().String("cidr", "", "Expanded network CIDR (same network address, shorter prefix)")
+
+	return cmd
 }
 
-// NewVNListCommand creates the 'vn list' command
-func NewVNListCommand() *cobra.Command {
+// makeNetworkInfoCommand creates the 'vn <network> info' command.
+func makeNetworkInfoCommand(app *App, networkName string) *cobra.Command {
 	return &cobra.Command{
-		Use:   "list",
-		Short: "List all virtual networks",
-		RunE: func(_cmd *cobra.Command, _args []string) error {
-			networks, err := vnManager.ListVirtualNetworks()
+		Use:         "info",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show network settings, contents and IP pool utilization",
+		Long: fmt.Sprintf(`Show the settings of virtual network '%s', its servers, node counts by
+type and saved config versions, and how many of its addresses are in use. A warning is printed to stderr when the IP pool
+has reached the network's warning threshold (see 'vn edit --pool-warn-percent').`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			summary, err := app.vnManager.DescribeNetworkCtx(cmd.Context(), networkName)
 			if err != nil {
-				return fmt.Errorf("failed to list networks: %w", err)
+				return fmt.Errorf("failed to describe network: %w", err)
 			}
+			net, usage := summary.Network, summary.Pool
 
-			if len(networks) == 0 {
-				fmt.Println("No virtual networks found")
-				return nil
+			fmt.Fprintf(out, "Network: %s\n", net.Name)
+			if net.Freeze != nil {
+				fmt.Fprintf(out, "Config: FROZEN %s; no new version is saved until 'config unfreeze'\n", net.Freeze)
 			}
-
-			fmt.Printf("%-20s %-20s\n", "Name", "CIDR")
-			fmt.Println("----------------------------------------------")
-			for _, net := range networks {
-				fmt.Printf("%-20s %-20s\n", net.Name, net.CIDR)
+			fmt.Fprintf(out, "CIDR: %s\n", net.CIDR)
+			fmt.Fprintf(out, "Default Port: %d\n", net.NodePort())
+			fmt.Fprintf(out, "Topology: %s\n", net.EffectiveTopology())
+			fmt.Fprintf(out, "NAT Mode: %s\n", net.EffectiveNATMode())
+			if dns := net.DNS(); len(dns) > 0 {
+				fmt.Fprintf(out, "DNS: %s\n", strings.Join(dns, ", "))
+			}
+			if len(net.Labels) > 0 {
+				fmt.Fprintf(out, "Labels: %s\n", formatLabels(net.Labels))
+			}
+			if len(net.Settings) > 0 {
+				fmt.Fprintf(out, "Settings: %s\n", formatLabels(net.Settings))
+			}
+			fmt.Fprintln(out, "Contents:")
+			printNetworkContents(out, summary)
+			if net.MaxNodes != 0 {
+				fmt.Fprintf(out, "Max Nodes: %d\n", net.MaxNodes)
+			}
+			if usage == nil {
+				fmt.Fprintf(out, "Addresses: unknown, the CIDR is invalid; fix it with 'vn %s edit --cidr'\n", net.Name)
+			} else {
+				fmt.Fprintf(out, "Addresses: %s (%.0f%%)\n", formatPoolUsage(usage), usage.Percent())
+				if usage.Recycled > 0 {
+					fmt.Fprintf(out, "Recycled Addresses: %d\n", usage.Recycled)
+				}
 			}
+			fmt.Fprintf(out, "Pool Warning: %d%%\n", net.PoolWarnThreshold())
+			fmt.Fprintf(out, "ID: %s\n", net.ID)
 
+			if usage != nil && usage.NearlyExhausted(net.PoolWarnThreshold()) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: IP pool of network '%s' is %.0f%% used; widen it with 'vn %s edit --cidr'\n", net.Name, usage.Percent(), net.Name)
+			}
 			return nil
 		},
 	}
 }
 
-// NewVNDeleteCommand creates the 'vn delete' command
-func NewVNDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "delete <network-name>",
-		Short: "Delete a virtual network",
-		Args:  cobra.ExactArgs(1),
+// makeNetworkSetCommand creates the 'vn <network> set' command.
+func makeNetworkSetCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <key> <value> [--raw]",
+		Short: "Set a network setting",
+		Long: fmt.Sprintf(`Set a setting of virtual network '%s'. 'settings list' shows the
+known settings with their defaults; their values are validated. Other keys
+are rejected unless --raw is given, which stores the value unchecked for
+tools reading the database. Settings that alter configs take effect with
+the next 'config generate'.
+
+Examples:
+  wedevctl vn %s set keepalive 15
+  wedevctl vn %s set mtu 1420
+  wedevctl vn %s set --raw owner.team payments`, networkName, networkName, networkName, networkName),
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeSettingKeys(networkName, false),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
+			out := cmd.OutOrStdout()
 
-			// Warn about cascade deletion
-			if !confirmAction(fmt.Sprintf("Delete network '%s'? This will also delete the server, all nodes, and all configuration history.", name)) {
-				fmt.Println("Cancelled")
-				return nil
+			raw, err := cmd.Flags().GetBool("raw")
+			if err != nil {
+				return fmt.Errorf("failed to get raw flag: %w", err)
 			}
 
-			err := vnManager.DeleteVirtualNetwork(name)
+			net, err := app.vnManager.SetNetworkSetting(networkName, args[0], args[1], raw)
 			if err != nil {
-				return fmt.Errorf("failed to delete network: %w", err)
+				return fmt.Errorf("failed to set %s: %w", args[0], err)
 			}
 
-			fmt.Printf("Virtual network '%s' deleted successfully\n", name)
+			fmt.Fprintf(out, "Set %s=%s on network '%s'\n", args[0], net.Settings[args[0]], net.Name)
+			if _, known := wedev.LookupSetting(args[0]); known {
+				fmt.Fprintln(out, "Run 'config generate' to write the updated configs")
+			}
 			return nil
 		},
 	}
-}
-
-// ========== Server Commands ==========
-
-// makeServerCommand creates the 'server' command group for a specific network
-func makeServerCommand(networkName string) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "server",
-		Short: "Manage servers",
-		Long:  fmt.Sprintf("Manage servers in virtual network '%s'", networkName),
-	}
 
-	cmd.AddCommand(makeServerAddCommand(networkName))
-	cmd.AddCommand(makeServerInfoCommand(networkName))
-	cmd.AddCommand(makeServerEditCommand(networkName))
-	cmd.AddCommand(makeServerDeleteCommand(networkName))
+	cmd.Flags().Bool("raw", false, "Store a key wedevctl does not know, without validation")
 
 	return cmd
 }
 
-// makeServerAddCommand creates the 'server add' command for a specific network
-func makeServerAddCommand(networkName string) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "add <server-name> <public-address> [port]",
-		Short: "Create a new server",
-		Args:  cobra.RangeArgs(2, 3),
+// makeNetworkGetCommand creates the 'vn <network> get' command.
+func makeNetworkGetCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:         "get <key>",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Print a network setting",
+		Long: `Print the value of a setting, or the default of a known setting that is
+not set (an empty line when it has none). A key that is neither known nor
+set is not found.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeSettingKeys(networkName, false),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			serverName := args[0]
-			publicAddress := args[1]
-
-			port := 51820
-			if len(args) == 3 {
-				_, err := fmt.Sscanf(args[2], "%d", &port)
-				if err != nil {
-					return fmt.Errorf("invalid port number: %w", err)
-				}
-			}
-
-			server, err := vnManager.CreateServer(networkName, serverName, publicAddress, port)
+			setting, err := app.vnManager.NetworkSettingCtx(cmd.Context(), networkName, args[0])
 			if err != nil {
-				return fmt.Errorf("failed to create server: %w", err)
+				return fmt.Errorf("failed to get %s: %w", args[0], err)
 			}
-
-			fmt.Printf("Server '%s' created successfully\n", server.Name)
-			fmt.Printf("Virtual IP: %s\n", server.VirtualIP)
-			fmt.Printf("Public Address: %s:%d\n", server.PublicAddress, server.Port)
-
+			fmt.Fprintln(cmd.OutOrStdout(), setting.Value)
 			return nil
 		},
 	}
-
-	return cmd
 }
 
-// makeServerInfoCommand creates the 'server info' command for a specific network
-func makeServerInfoCommand(networkName string) *cobra.Command {
+// makeNetworkUnsetCommand creates the 'vn <network> unset' command.
+func makeNetworkUnsetCommand(app *App, networkName string) *cobra.Command {
 	return &cobra.Command{
-		Use:   "info",
-		Short: "Show server information",
-		Args:  cobra.NoArgs,
-		RunE: func(_cmd *cobra.Command, _args []string) error {
-			server, err := vnManager.GetServer(networkName)
+		Use:               "unset <key>",
+		Short:             "Remove a network setting, restoring its default",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeSettingKeys(networkName, true),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			net, err := app.vnManager.UnsetNetworkSetting(networkName, args[0])
 			if err != nil {
-				return fmt.Errorf("failed to get server: %w", err)
+				return fmt.Errorf("failed to unset %s: %w", args[0], err)
 			}
 
-			fmt.Printf("Server: %s\n", server.Name)
-			fmt.Printf("Virtual IP: %s\n", server.VirtualIP)
-			fmt.Printf("Public Address: %s:%d\n", server.PublicAddress, server.Port)
-			fmt.Printf("ID: %s\n", server.ID)
-
+			def, known := wedev.LookupSetting(args[0])
+			switch {
+			case known && def.Default != "":
+				fmt.Fprintf(out, "Unset %s on network '%s'; the default %s applies\n", args[0], net.Name, def.Default)
+			default:
+				fmt.Fprintf(out, "Unset %s on network '%s'\n", args[0], net.Name)
+			}
+			if known {
+				fmt.Fprintln(out, "Run 'config generate' to write the updated configs")
+			}
 			return nil
 		},
 	}
 }
 
-// makeServerEditCommand creates the 'server edit' command for a specific network
-func makeServerEditCommand(networkName string) *cobra.Command {
+// makeSettingsCommand creates the 'settings' command group for a specific
+// network.
+func makeSettingsCommand(app *App, networkName string) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "edit --public-address <addr> --port <port>",
-		Short: "Edit server information",
-		Args:  cobra.NoArgs,
-		RunE: func(cmd *cobra.Command, args []string) error {
+		Use:   "settings",
+		Short: "Show network settings",
+		Long: `Network settings are key/value pairs changed with 'set' and 'unset'.
+Known settings have defaults and validated values; others are stored with
+'set --raw' and only listed.`,
+	}
 
-			publicAddress, err := cmd.Flags().GetString("public-address")
-			if err != nil {
-				return fmt.Errorf("failed to get public-address flag: %w", err)
-			}
-			port, err := cmd.Flags().GetInt("port")
-			if err != nil {
-				return fmt.Errorf("failed to get port flag: %w", err)
-			}
+	cmd.AddCommand(makeSettingsListCommand(app, networkName))
 
-			if publicAddress == "" && port == 0 {
-				return fmt.Errorf("must specify at least --public-address or --port")
-			}
+	return cmd
+}
 
-			server, err := vnManager.GetServer(networkName)
+// makeSettingsListCommand creates the 'settings list' command.
+func makeSettingsListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List known settings with their values and defaults, then other keys",
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
 			if err != nil {
-				return fmt.Errorf("failed to get server: %w", err)
+				return err
 			}
 
-			// Use current values if not specified
-			if publicAddress == "" {
-				publicAddress = server.PublicAddress
+			settings, err := app.vnManager.NetworkSettingsCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list settings: %w", err)
 			}
-			if port == 0 {
-				port = server.Port
+			switch output {
+			case "json":
+				return printJSON(out, settings)
+			case "yaml":
+				return printYAML(out, settings)
 			}
 
-			updated, err := vnManager.UpdateServer(networkName, publicAddress, port)
-			if err != nil {
-				return fmt.Errorf("failed to update server: %w", err)
+			rows := make([][]string, 0, len(settings))
+			for _, setting := range settings {
+				value, source := setting.Value, "set"
+				switch {
+				case !setting.Known:
+					source = "raw"
+				case !setting.Set:
+					source = "default"
+				}
+				if value == "" {
+					value = "-"
+				}
+				description := setting.Description
+				if description == "" {
+					description = "-"
+				}
+				rows = append(rows, []string{setting.Key, value, source, description})
 			}
-
-			fmt.Printf("Server '%s' updated successfully\n", updated.Name)
-			fmt.Printf("Public Address: %s:%d\n", updated.PublicAddress, updated.Port)
-
-			return nil
+			return printListTable(cmd, out, "No settings found", []string{"Key", "Value", "Source", "Description"}, rows)
 		},
 	}
 
-	cmd.Flags().String("public-address", "", "Public address or domain")
-	cmd.Flags().Int("port", 0, "Port number")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
 
 	return cmd
 }
 
-// makeServerDeleteCommand creates the 'server delete' command for a specific network
-func makeServerDeleteCommand(networkName string) *cobra.Command {
-	return &cobra.Command{
-		Use:   "delete",
-		Short: "Delete the server",
-		Args:  cobra.NoArgs,
-		RunE: func(cmd *cobra.Command, args []string) error {
+// makeFirewallCommand creates the 'firewall' command for a specific network.
+func makeFirewallCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "firewall [--format table|iptables|nftables|ufw]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show the inbound UDP ports each host must open",
+		Long: fmt.Sprintf(`Show the inbound UDP ports the hosts of network '%s' must open for
+WireGuard: every server, and every node with a public address, listens on its
+port there. Entities sharing a public address are grouped under one host.
+Route and client nodes without a public address only connect outbound and are
+listed as needing no inbound rules; expired nodes are left out.
+
+--format prints ready-to-paste rules instead of the table, one block per
+host: iptables or ufw commands, or nft commands adding to the chain 'input'
+of the table 'inet filter'.
 
-			if !confirmAction(fmt.Sprintf("Delete server in network '%s'?", networkName)) {
-				fmt.Println("Cancelled")
-				return nil
+Examples:
+  wedevctl vn %[1]s firewall
+  wedevctl vn %[1]s firewall --format ufw`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			formatName, err := cmd.Flags().GetString("format")
+			if err != nil {
+				return fmt.Errorf("failed to get format flag: %w", err)
+			}
+			var format wedev.FirewallFormat
+			if formatName != "table" {
+				if format, err = wedev.ParseFirewallFormat(formatName); err != nil {
+					return err
+				}
 			}
 
-			err := vnManager.DeleteServer(networkName)
+			report, err := app.vnManager.FirewallRules(networkName)
 			if err != nil {
-				return fmt.Errorf("failed to delete server: %w", err)
+				return fmt.Errorf("failed to derive firewall rules: %w", err)
+			}
+			if format != "" {
+				rules, err := report.RenderFirewall(format)
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(out, rules)
+				return nil
 			}
 
-			fmt.Println("Server deleted successfully")
+			if len(report.Hosts) == 0 {
+				fmt.Fprintln(out, "No host needs inbound rules")
+			} else {
+				var rows [][]string
+				for _, host := range report.Hosts {
+					for _, port := range host.Ports {
+						rows = append(rows, []string{host.Address, fmt.Sprintf("%d/udp", port.Port), port.EntityList()})
+					}
+				}
+				printTable(out, []string{"Address", "Port", "Entities"}, rows)
+			}
+			if len(report.NoInbound) > 0 {
+				names := make([]string, 0, len(report.NoInbound))
+				for _, entity := range report.NoInbound {
+					names = append(names, entity.String())
+				}
+				fmt.Fprintf(out, "\nNo inbound required: %s\n", strings.Join(names, ", "))
+			}
 			return nil
 		},
 	}
-}
-
-// ========== Node Commands ==========
-
-// makeNodeCommand creates the 'node' command group for a specific network
-func makeNodeCommand(networkName string) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "node",
-		Short: "Manage nodes",
-		Long:  fmt.Sprintf("Manage nodes in virtual network '%s'", networkName),
-	}
 
-	cmd.AddCommand(makeNodeAddCommand(networkName))
-	cmd.AddCommand(makeNodeListCommand(networkName))
-	cmd.AddCommand(makeNodeEditCommand(networkName))
-	cmd.AddCommand(makeNodeDeleteCommand(networkName))
+	cmd.Flags().String("format", "table", "Output format (table, iptables, nftables, or ufw)")
 
 	return cmd
 }
 
-// makeNodeAddCommand creates the 'node add' command for a specific network
-func makeNodeAddCommand(networkName string) *cobra.Command {
+// formatPoolUsage renders IP pool utilization as "X of Y addresses used",
+// followed by the reserved count when addresses are reserved.
+func formatPoolUsage(usage *wedev.PoolUsage) string {
+	s := fmt.Sprintf("%d of %d addresses used", usage.Allocated, usage.Total)
+	if usage.Reserved > 0 {
+		s += fmt.Sprintf(", %d reserved", usage.Reserved)
+	}
+	return s
+}
+
+// ========== Virtual Network Commands ==========
+
+// NewVNAddCommand creates the 'vn add' command
+func NewVNAddCommand(app *App) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "add <node-name> <type> [public-address] [port]",
-		Short: "Create a new node",
-		Long: `Create a new node in the virtual network.
+		Use:   "add <network-name> <network-cidr> [--label key=value] [--default-port <port>] [--topology hub-spoke|mesh] [--max-nodes <n>] [--pool-warn-percent <percent>]",
+		Short: "Create a new virtual network",
+		Long: `Create a new virtual network.
 
-Type can be 'peer' or 'route':
-  - peer: requires public-address, participates in peer-to-peer connections
-  - route: public-address is optional, only connects to server
+--topology sets how nodes peer. In 'hub-spoke' (the default) nodes reach
+each other through the server, except that peer nodes peer with each other
+and route nodes with peer nodes. In 'mesh' every pair of nodes where at least
+one has a public address peers directly; the rest still use the server.
 
-Examples:
-  # Peer node (public-address required)
-  wedevctl vn mynet node add node1 peer 192.168.1.100
-  wedevctl vn mynet node add node1 peer 192.168.1.100 51821
+--nat-mode sets what server configs do for the LAN subnets of route nodes.
+With 'masquerade' (the default) each such node gets PostUp/PostDown iptables
+rules forwarding traffic to and from its subnets on the WireGuard interface
+and masquerading it, so LAN hosts can reply. With 'none' no rules are added.
 
-  # Route node (public-address optional)
-  wedevctl vn mynet node add node2 route
-  wedevctl vn mynet node add node2 route 192.168.1.200 51822`,
-		Args: cobra.RangeArgs(2, 4),
+--max-nodes caps how many nodes 'node add' accepts. Adding a node warns once
+the IP pool is --pool-warn-percent full (default 90).`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			nodeName := args[0]
-			nodeTypeStr := args[1]
+			out := cmd.OutOrStdout()
 
-			// Validate and parse node type
-			var nodeType wedev.NodeType
-			switch nodeTypeStr {
-			case "route":
-				nodeType = wedev.NodeTypeRoute
-			case "peer":
-				nodeType = wedev.NodeTypePeer
-			default:
-				return fmt.Errorf("invalid node type: %s (must be 'peer' or 'route')", nodeTypeStr)
-			}
+			name := args[0]
+			cidr := args[1]
 
-			// Parse public address
-			publicAddress := ""
-			if len(args) >= 3 {
-				publicAddress = args[2]
+			labelPairs, err := cmd.Flags().GetStringArray("label")
+			if err != nil {
+				return fmt.Errorf("failed to get label flag: %w", err)
 			}
-
-			// Validate: peer type requires public address
-			if nodeType == wedev.NodeTypePeer && publicAddress == "" {
-				return fmt.Errorf("peer type nodes require a public address")
+			labels, err := util.ParseLabels(labelPairs)
+			if err != nil {
+				return err
 			}
-
-			// Parse port (default 51820)
-			port := 51820
-			if len(args) >= 4 {
-				_, err := fmt.Sscanf(args[3], "%d", &port)
-				if err != nil {
-					return fmt.Errorf("invalid port number: %w", err)
+			defaultPort, err := cmd.Flags().GetInt("default-port")
+			if err != nil {
+				return fmt.Errorf("failed to get default-port flag: %w", err)
+			}
+			if cmd.Flags().Changed("default-port") {
+				if err := util.ValidatePort(defaultPort); err != nil {
+					return err
 				}
 			}
-
-			node, err := vnManager.CreateNode(networkName, nodeName, publicAddress, port, nodeType)
+			topologyStr, err := cmd.Flags().GetString("topology")
 			if err != nil {
-				return fmt.Errorf("failed to create node: %w", err)
+				return fmt.Errorf("failed to get topology flag: %w", err)
 			}
-
-			fmt.Printf("Node '%s' created successfully\n", node.Name)
-			fmt.Printf("Virtual IP: %s\n", node.VirtualIP)
-			fmt.Printf("Type: %s\n", node.Type)
-			if publicAddress != "" {
-				fmt.Printf("Public Address: %s:%d\n", node.PublicAddress, node.Port)
+			topology, err := wedev.ParseTopology(topologyStr)
+			if err != nil {
+				return err
 			}
-
-			return nil
-		},
-	}
-
-	return cmd
-}
-
-// makeNodeListCommand creates the 'node list' command for a specific network
-func makeNodeListCommand(networkName string) *cobra.Command {
-	return &cobra.Command{
-		Use:   "list",
-		Short: "List all nodes",
-		Args:  cobra.NoArgs,
-		RunE: func(_cmd *cobra.Command, _args []string) error {
-
-			nodes, err := vnManager.ListNodes(networkName)
+			natModeStr, err := cmd.Flags().GetString("nat-mode")
 			if err != nil {
-				return fmt.Errorf("failed to list nodes: %w", err)
+				return fmt.Errorf("failed to get nat-mode flag: %w", err)
+			}
+			natMode, err := wedev.ParseNATMode(natModeStr)
+			if err != nil {
+				return err
+			}
+			maxNodes, err := cmd.Flags().GetInt("max-nodes")
+			if err != nil {
+				return fmt.Errorf("failed to get max-nodes flag: %w", err)
+			}
+			warnPercent, err := cmd.Flags().GetInt("pool-warn-percent")
+			if err != nil {
+				return fmt.Errorf("failed to get pool-warn-percent flag: %w", err)
+			}
+			if maxNodes < 0 {
+				return fmt.Errorf("--max-nodes must not be negative")
+			}
+			if warnPercent < 0 || warnPercent > 100 {
+				return fmt.Errorf("--pool-warn-percent must be between 0 and 100")
 			}
 
-			if len(nodes) == 0 {
-				fmt.Println("No nodes found")
+			// A CIDR with host bits set names the network it falls in;
+			// say so rather than store it as typed.
+			if normalized, err := util.NormalizeCIDR(cidr); err == nil && normalized != cidr {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: normalized %s to %s\n", cidr, normalized)
+				cidr = normalized
+			}
+
+			// Ask for confirmation
+			if !confirmAction(cmd, fmt.Sprintf("Create virtual network '%s' with CIDR %s?", name, cidr), assumeFor(app, promptCreate)) {
+				fmt.Fprintln(out, "Cancelled")
 				return nil
 			}
 
-			fmt.Printf("%-15s %-15s %-20s %-10s\n", "Name", "Virtual IP", "Public Address", "Type")
-			fmt.Println("--------------------------------------------------------------")
-			for _, node := range nodes {
-				endpoint := fmt.Sprintf("%s:%d", node.PublicAddress, node.Port)
-				fmt.Printf("%-15s %-15s %-20s %-10s\n", node.Name, node.VirtualIP, endpoint, node.Type)
+			net, err := app.vnManager.CreateVirtualNetworkCtx(cmd.Context(), name, cidr)
+			if err != nil {
+				return fmt.Errorf("failed to create network: %w", err)
+			}
+			if len(labels) > 0 {
+				if _, err := app.vnManager.UpdateVirtualNetworkLabels(name, labels, nil); err != nil {
+					return fmt.Errorf("failed to set network labels: %w", err)
+				}
+			}
+			if defaultPort != 0 {
+				if _, err := app.vnManager.SetDefaultPort(name, defaultPort); err != nil {
+					return fmt.Errorf("failed to set default port: %w", err)
+				}
+			}
+			if topology != wedev.TopologyHubSpoke {
+				if _, err := app.vnManager.SetTopology(name, topology); err != nil {
+					return fmt.Errorf("failed to set topology: %w", err)
+				}
+			}
+			if natMode != wedev.NATModeMasquerade {
+				if _, err := app.vnManager.SetNATMode(name, natMode); err != nil {
+					return fmt.Errorf("failed to set NAT mode: %w", err)
+				}
+			}
+			if maxNodes != 0 {
+				if _, err := app.vnManager.SetMaxNodes(name, maxNodes); err != nil {
+					return fmt.Errorf("failed to set max nodes: %w", err)
+				}
+			}
+			if warnPercent != 0 {
+				if _, err := app.vnManager.SetPoolWarnPercent(name, warnPercent); err != nil {
+					return fmt.Errorf("failed to set pool warning threshold: %w", err)
+				}
 			}
 
+			fmt.Fprintf(out, "Virtual network '%s' created successfully (ID: %s)\n", net.Name, net.ID)
 			return nil
 		},
 	}
-}
 
-// makeNodeEditCommand creates the 'node edit' command for a specific network.
-func makeNodeEditCommand(networkName string) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "edit <node-name> [--type <type>] [--public-address <addr>] [--port <port>]",
-		Short: "Edit node information",
-		Long: `Edit node information including type, public address, and port.
+	cmd.Flags().StringArray("label", nil, "Label as key=value (repeatable)")
+	cmd.Flags().Int("default-port", 0, "Port for nodes added without one (default 51820)")
+	cmd.Flags().String("topology", string(wedev.TopologyHubSpoke), "How nodes peer: hub-spoke or mesh")
+	cmd.Flags().String("nat-mode", string(wedev.NATModeMasquerade), "Server rules for routed subnets: masquerade or none")
+	cmd.Flags().Int("max-nodes", 0, "Most nodes the network may hold (0 means no limit)")
+	cmd.Flags().Int("pool-warn-percent", 0, "IP pool utilization that adding a node warns at (default 90)")
 
-Validation rules:
-  - When changing type to 'peer': public-address is required
-  - When changing type to 'route': public-address is optional
-  - Peer type nodes must always have a public-address
+	return cmd
+}
 
-Examples:
-  # Change node type to route (can clear public address)
-  wedevctl vn mynet node edit node1 --type route --public-address ""
+// NewVNListCommand creates the 'vn list' command
+func NewVNListCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--selector <expr>] [--sort name|created] [--wide] [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List all virtual networks",
+		Long: `List virtual networks, sorted by name, or with --sort created oldest
+first. JSON and YAML output use the same order.
 
-  # Change node type to peer (must provide public address)
-  wedevctl vn mynet node edit node1 --type peer --public-address 192.168.1.100
+--selector filters by label with comma-separated key=value and key!=value
+terms, all of which must match.
 
-  # Update only port
-  wedevctl vn mynet node edit node1 --port 51821`,
-		Args: cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			nodeName := args[0]
+--wide adds the endpoint of each network's first server, its node count, its
+latest saved config version and how long ago it was saved, and how much of
+its IP pool is in use. JSON output always includes them.
 
-			publicAddress, err := cmd.Flags().GetString("public-address")
-			if err != nil {
-				return fmt.Errorf("failed to get public-address flag: %w", err)
-			}
-			port, err := cmd.Flags().GetInt("port")
+Examples:
+  wedevctl vn list --selector team=payments
+  wedevctl vn list --selector team=payments,env!=prod --output json
+  wedevctl vn list --sort created
+  wedevctl vn list --wide`,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			selector, output, err := listFilterFlags(cmd)
 			if err != nil {
-				return fmt.Errorf("failed to get port flag: %w", err)
+				return err
 			}
-			nodeTypeStr, err := cmd.Flags().GetString("type")
+
+			order, err := cmd.Flags().GetString("sort")
 			if err != nil {
-				return fmt.Errorf("failed to get type flag: %w", err)
+				return fmt.Errorf("failed to get sort flag: %w", err)
 			}
 
-			node, err := vnManager.GetNode(networkName, nodeName)
+			networks, err := app.vnManager.ListVirtualNetworksOrdered(cmd.Context(), wedev.ListOrder(order))
 			if err != nil {
-				return fmt.Errorf("failed to get node: %w", err)
+				return fmt.Errorf("failed to list networks: %w", err)
 			}
 
-			// Determine the final node type
-			nodeType := node.Type
-			typeChanged := false
-			if nodeTypeStr != "" {
-				typeChanged = true
-				switch nodeTypeStr {
-				case "route":
-					nodeType = wedev.NodeTypeRoute
-				case "peer":
-					nodeType = wedev.NodeTypePeer
-				default:
-					return fmt.Errorf("invalid node type: %s (must be 'peer' or 'route')", nodeTypeStr)
+			matched := make([]*wedev.VirtualNetwork, 0, len(networks))
+			for _, net := range networks {
+				if selector.Matches(net.Labels) {
+					matched = append(matched, net)
 				}
 			}
-
-			// Determine the final public address
-			publicAddressProvided := cmd.Flags().Changed("public-address")
-			if !publicAddressProvided {
-				publicAddress = node.PublicAddress
-			}
-
-			// Validate type and public address combination
-			if typeChanged && nodeType == wedev.NodeTypePeer && publicAddress == "" {
-				return fmt.Errorf("peer type nodes require a public address (use --public-address)")
+			wide, err := cmd.Flags().GetBool("wide")
+			if err != nil {
+				return fmt.Errorf("failed to get wide flag: %w", err)
 			}
-
-			// If already peer type and trying to clear public address
-			if node.Type == wedev.NodeTypePeer && publicAddressProvided && publicAddress == "" && nodeType == wedev.NodeTypePeer {
-				return fmt.Errorf("cannot clear public address for peer type nodes (change type to route first)")
+			var overviews map[string]*wedev.NetworkOverview
+			if wide || output == "json" {
+				if overviews, err = app.vnManager.NetworkOverviews(cmd.Context(), matched); err != nil {
+					return fmt.Errorf("failed to summarize networks: %w", err)
+				}
 			}
 
-			// Use current port if not specified
-			if port == 0 {
-				port = node.Port
+			switch output {
+			case "json":
+				entries := make([]vnListEntry, 0, len(matched))
+				for _, net := range matched {
+					entries = append(entries, vnListEntry{net, overviews[net.ID]})
+				}
+				return printJSON(out, entries)
+			case "yaml":
+				// One document per network, so each can be applied on its own.
+				docs := make([]any, 0, len(matched))
+				for _, net := range matched {
+					docs = append(docs, net)
+				}
+				return printYAML(out, docs...)
 			}
 
-			updated, err := vnManager.UpdateNode(networkName, nodeName, publicAddress, port, nodeType)
-			if err != nil {
-				return fmt.Errorf("failed to update node: %w", err)
+			rows := make([][]string, 0, len(matched))
+			for _, net := range matched {
+				row := []string{net.Name, net.CIDR}
+				if wide {
+					overview := overviews[net.ID]
+					endpoint, version, pool := "-", "-", "-"
+					if overview.ServerEndpoint != "" {
+						endpoint = overview.ServerEndpoint
+					}
+					if overview.LatestVersion != 0 {
+						version = fmt.Sprintf("v%d", overview.LatestVersion)
+					}
+					if !overview.InvalidCIDR {
+						pool = fmt.Sprintf("%.0f%%", overview.PoolPercent)
+					}
+					row = append(row, endpoint, strconv.Itoa(overview.Nodes), version, formatAge(overview.GeneratedAt), pool)
+				}
+				rows = append(rows, append(row, formatLabels(net.Labels)))
 			}
-
-			fmt.Printf("Node '%s' updated successfully\n", updated.Name)
-			fmt.Printf("Type: %s\n", updated.Type)
-			if updated.PublicAddress != "" {
-				fmt.Printf("Public Address: %s:%d\n", updated.PublicAddress, updated.Port)
-			} else {
-				fmt.Printf("Public Address: (none)\n")
+			headers := []string{"Name", "CIDR", "Labels"}
+			if wide {
+				headers = []string{"Name", "CIDR", "Server", "Nodes", "Version", "Generated", "Pool", "Labels"}
 			}
-
-			return nil
+			return printListTable(cmd, out, "No virtual networks found", headers, rows)
 		},
 	}
 
-	cmd.Flags().String("public-address", "", "Public address or domain (empty string to clear for route type)")
-	cmd.Flags().Int("port", 0, "Port number")
-	cmd.Flags().String("type", "", "Node type (peer or route)")
+	cmd.Flags().String("selector", "", "Filter by labels (key=value,key!=value)")
+	cmd.Flags().Bool("wide", false, "Add server endpoint, node count, latest config version and pool usage columns")
+	cmd.Flags().String("sort", string(wedev.OrderByName), "Sort by name or created")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+	_ = cmd.RegisterFlagCompletionFunc("sort", completeListOrders(wedev.NetworkListOrders))
 
 	return cmd
 }
 
-// makeNodeDeleteCommand creates the 'node delete' command for a specific network.
-func makeNodeDeleteCommand(networkName string) *cobra.Command {
-	return &cobra.Command{
-		Use:   "delete <node-name>",
-		Short: "Delete a node",
-		Args:  cobra.ExactArgs(1),
+// vnListEntry is a network as 'vn list --output json' prints it: its record
+// and its overview, in one object.
+type vnListEntry struct {
+	*wedev.VirtualNetwork
+	*wedev.NetworkOverview
+}
+
+// NewVNEditCommand creates the 'vn edit' command
+func NewVNEditCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit <network-name> [--label key=value] [--remove-label key] [--default-port <port>] [--filename-template <template>] [--topology hub-spoke|mesh] [--nat-mode masquerade|none] [--dns <ip>] [--max-nodes <n>] [--pool-warn-percent <percent>]",
+		Short: "Edit virtual network labels and settings",
+		Long: `Set or remove labels on a virtual network, change the port nodes get
+when 'node add' is given none, set the template 'config generate' names
+config files with (an empty template restores <entity>.conf), or switch how
+nodes peer with --topology, or the server rules for routed subnets with
+--nat-mode (see 'vn add --help'). --dns sets the resolvers node configs use
+(repeatable; --dns "" removes them). A topology, NAT mode or DNS change
+alters configs, so the next 'config generate' saves a new version.
+--max-nodes caps the node count (0 removes the cap) and --pool-warn-percent
+sets when adding a node warns that the IP pool is filling up.
+
+Examples:
+  wedevctl vn edit prod-net --label team=payments --label env=prod
+  wedevctl vn edit prod-net --remove-label env
+  wedevctl vn edit prod-net --default-port 51900
+  wedevctl vn edit prod-net --filename-template 'wg-{{.Entity}}.conf'
+  wedevctl vn edit prod-net --topology mesh
+  wedevctl vn edit prod-net --nat-mode none
+  wedevctl vn edit prod-net --dns 10.0.0.1 --dns 1.1.1.1
+  wedevctl vn edit prod-net --max-nodes 50 --pool-warn-percent 80`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNetworkNames,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			nodeName := args[0]
+			out := cmd.OutOrStdout()
 
-			if !confirmAction(fmt.Sprintf("Delete node '%s'?", nodeName)) {
-				fmt.Println("Cancelled")
-				return nil
+			name := args[0]
+
+			set, remove, err := labelEditFlags(cmd)
+			if err != nil {
+				return err
+			}
+			edit := wedev.NetworkEdit{SetLabels: set, RemoveLabels: remove}
+			flags := cmd.Flags()
+			if flags.Changed("default-port") {
+				defaultPort, err := flags.GetInt("default-port")
+				if err != nil {
+					return fmt.Errorf("failed to get default-port flag: %w", err)
+				}
+				edit.DefaultPort = &defaultPort
+			}
+			if flags.Changed("filename-template") {
+				filenameTemplate, err := flags.GetString("filename-template")
+				if err != nil {
+					return fmt.Errorf("failed to get filename-template flag: %w", err)
+				}
+				edit.FilenameTemplate = &filenameTemplate
+			}
+			if flags.Changed("topology") {
+				topology, err := flags.GetString("topology")
+				if err != nil {
+					return fmt.Errorf("failed to get topology flag: %w", err)
+				}
+				edit.Topology = (*wedev.Topology)(&topology)
+			}
+			if flags.Changed("nat-mode") {
+				natMode, err := flags.GetString("nat-mode")
+				if err != nil {
+					return fmt.Errorf("failed to get nat-mode flag: %w", err)
+				}
+				edit.NATMode = (*wedev.NATMode)(&natMode)
+			}
+			if flags.Changed("dns") {
+				dnsFlag, err := flags.GetStringArray("dns")
+				if err != nil {
+					return fmt.Errorf("failed to get dns flag: %w", err)
+				}
+				var dns []string
+				for _, d := range dnsFlag {
+					if d != "" {
+						dns = append(dns, d)
+					}
+				}
+				edit.DNS = &dns
+			}
+			if flags.Changed("max-nodes") {
+				maxNodes, err := flags.GetInt("max-nodes")
+				if err != nil {
+					return fmt.Errorf("failed to get max-nodes flag: %w", err)
+				}
+				edit.MaxNodes = &maxNodes
+			}
+			if flags.Changed("pool-warn-percent") {
+				warnPercent, err := flags.GetInt("pool-warn-percent")
+				if err != nil {
+					return fmt.Errorf("failed to get pool-warn-percent flag: %w", err)
+				}
+				edit.PoolWarnPercent = &warnPercent
+			}
+			if len(set) == 0 && len(remove) == 0 && edit.DefaultPort == nil && edit.FilenameTemplate == nil && edit.Topology == nil &&
+				edit.NATMode == nil && edit.DNS == nil && edit.MaxNodes == nil && edit.PoolWarnPercent == nil {
+				return fmt.Errorf("nothing to change (use --label, --remove-label, --default-port, --filename-template, --topology, --nat-mode, --dns, --max-nodes, or --pool-warn-percent)")
 			}
 
-			err := vnManager.DeleteNode(networkName, nodeName)
+			net, err := app.vnManager.EditNetwork(name, edit)
 			if err != nil {
-				return fmt.Errorf("failed to delete node: %w", err)
+				return fmt.Errorf("failed to update network: %w", err)
 			}
 
-			fmt.Println("Node deleted successfully")
+			fmt.Fprintf(out, "Virtual network '%s' updated successfully\n", net.Name)
+			fmt.Fprintf(out, "Labels: %s\n", formatLabels(net.Labels))
+			fmt.Fprintf(out, "Default Port: %d\n", net.NodePort())
+			if tmpl, set := net.Setting(wedev.SettingFilenameTemplate); set {
+				fmt.Fprintf(out, "Filename Template: %s\n", tmpl)
+			}
+			fmt.Fprintf(out, "Topology: %s\n", net.EffectiveTopology())
+			fmt.Fprintf(out, "NAT Mode: %s\n", net.EffectiveNATMode())
+			if dns := net.DNS(); len(dns) > 0 {
+				fmt.Fprintf(out, "DNS: %s\n", strings.Join(dns, ", "))
+			}
+			if net.MaxNodes != 0 {
+				fmt.Fprintf(out, "Max Nodes: %d\n", net.MaxNodes)
+			}
+			fmt.Fprintf(out, "Pool Warning: %d%%\n", net.PoolWarnThreshold())
 			return nil
 		},
 	}
-}
-
-// ========== Config Commands ==========
-
-// makeConfigCommand creates the 'config' command group for a specific network
-func makeConfigCommand(networkName string) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "config",
-		Short: "Manage WireGuard configurations",
-		Long:  fmt.Sprintf("Manage WireGuard configurations for virtual network '%s'", networkName),
-	}
 
-	cmd.AddCommand(makeConfigGenerateCommand(networkName))
-	cmd.AddCommand(makeConfigInfoCommand(networkName))
-	cmd.AddCommand(makeConfigHistoryCommand(networkName))
+	cmd.Flags().StringArray("label", nil, "Set a label as key=value (repeatable)")
+	cmd.Flags().StringArray("remove-label", nil, "Remove the label with this key (repeatable)")
+	cmd.Flags().Int("default-port", 0, "Port for nodes added without one")
+	cmd.Flags().String("filename-template", "", "Go template for config file names, e.g. 'wg-{{.Entity}}.conf' (empty restores the default)")
+	cmd.Flags().String("topology", "", "How nodes peer: hub-spoke or mesh")
+	cmd.Flags().String("nat-mode", "", "Server rules for routed subnets: masquerade or none")
+	cmd.Flags().StringArray("dns", nil, "DNS server for node configs (repeatable; \"\" removes them)")
+	cmd.Flags().Int("max-nodes", 0, "Most nodes the network may hold (0 removes the limit)")
+	cmd.Flags().Int("pool-warn-percent", 0, "IP pool utilization that adding a node warns at (0 restores 90)")
 
 	return cmd
 }
 
-// makeConfigGenerateCommand creates the 'config generate' command for a specific network
-func makeConfigGenerateCommand(networkName string) *cobra.Command {
+// typedConfirmNodes is the node count above which 'vn delete' asks for the
+// network name to be typed instead of a y/n answer.
+const typedConfirmNodes = 10
+
+// NewVNDeleteCommand creates the 'vn delete' command
+func NewVNDeleteCommand(app *App) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "generate",
-		Short: "Generate WireGuard configuration files",
-		Args:  cobra.NoArgs,
+		Use:     "delete <network-name> [--yes [--force]]",
+		Aliases: []string{"rm"},
+		Short:   "Delete a virtual network",
+		Long: fmt.Sprintf(`Delete a virtual network with its servers, nodes, and configuration
+history.
+
+What will be removed is listed before asking for confirmation. A network with
+more than %d nodes must be confirmed by typing its name; --yes skips the
+question only for smaller networks, and --yes --force for any network.`, typedConfirmNodes),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNetworkNames,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			outputDir, err := cmd.Flags().GetString("output-dir")
+			out := cmd.OutOrStdout()
+
+			name := args[0]
+
+			yes, err := cmd.Flags().GetBool("yes")
 			if err != nil {
-				return fmt.Errorf("failed to get output-dir flag: %w", err)
+				return fmt.Errorf("failed to get yes flag: %w", err)
 			}
 			force, err := cmd.Flags().GetBool("force")
 			if err != nil {
 				return fmt.Errorf("failed to get force flag: %w", err)
 			}
 
-			if outputDir == "" {
-				var getWdErr error
-				outputDir, getWdErr = os.Getwd()
-				if getWdErr != nil {
-					return fmt.Errorf("failed to get current directory: %w", getWdErr)
-				}
-			}
-
-			// Ensure directory exists
-			mkdirErr := os.MkdirAll(outputDir, 0o700)
-			if mkdirErr != nil {
-				return fmt.Errorf("failed to create output directory: %w", mkdirErr)
-			}
-
-			generator := wedev.NewWireGuardConfigGenerator(storage)
-			configs, _, err := generator.GenerateConfigs(networkName, storage)
+			summary, err := app.vnManager.DescribeNetworkCtx(cmd.Context(), name)
 			if err != nil {
-				return fmt.Errorf("failed to generate configs: %w", err)
-			}
-
-			// Check for existing files
-			var existingFiles []string
-			for name := range configs {
-				filePath := filepath.Join(outputDir, name+".conf")
-				if _, statErr := os.Stat(filePath); statErr == nil {
-					existingFiles = append(existingFiles, filePath)
-				}
+				return fmt.Errorf("failed to delete network: %w", err)
 			}
-
-			// Ask for overwrite confirmation
-			if len(existingFiles) > 0 && !force {
-				fmt.Println("The following files already exist:")
-				for _, f := range existingFiles {
-					fmt.Printf("  %s\n", f)
-				}
-				if !confirmAction("Overwrite existing files?") {
-					fmt.Println("Cancelled")
+			fmt.Fprintf(out, "Deleting network '%s' (%s) removes:\n", name, summary.Network.CIDR)
+			printNetworkContents(out, summary)
+
+			// Larger networks must be confirmed by typing their name.
+			large := summary.Nodes > typedConfirmNodes
+			switch {
+			case yes && (force || !large):
+				// Confirmed by the flags.
+			case yes:
+				return fmt.Errorf("network '%s' has %d nodes; confirm by typing its name, or pass --yes --force", name, summary.Nodes)
+			case large:
+				if !confirmByTyping(cmd, fmt.Sprintf("Type the network name '%s' to confirm", name), name, assumeFor(app, promptDelete)) {
+					fmt.Fprintln(out, "Cancelled")
 					return nil
 				}
-			}
-
-			// Write files
-			for name, config := range configs {
-				filePath := filepath.Join(outputDir, name+".conf")
-				if writeErr := os.WriteFile(filePath, []byte(config), 0o600); writeErr != nil {
-					return fmt.Errorf("failed to write config file %s: %w", filePath, writeErr)
+			default:
+				if !confirmAction(cmd, fmt.Sprintf("Delete network '%s'?", name), assumeFor(app, promptDelete)) {
+					fmt.Fprintln(out, "Cancelled")
+					return nil
 				}
-				fmt.Printf("Generated: %s\n", filePath)
 			}
 
-			// Save version
-			version, created, err := generator.SaveConfigVersion(networkName)
+			err = app.vnManager.DeleteVirtualNetwork(name)
 			if err != nil {
-				return fmt.Errorf("failed to save config version: %w", err)
-			}
-
-			if created {
-				fmt.Printf("\nConfiguration version %d saved\n", version.Version)
-			} else {
-				fmt.Println("\nNo changes detected, version not updated")
+				return fmt.Errorf("failed to delete network: %w", err)
 			}
 
+			fmt.Fprintf(out, "Virtual network '%s' deleted successfully\n", name)
 			return nil
 		},
 	}
 
-	cmd.Flags().String("output-dir", "", "Output directory (default: current directory)")
-	cmd.Flags().Bool("force", false, "Skip all interactive confirmations")
+	cmd.Flags().Bool("yes", false, fmt.Sprintf("Skip the confirmation prompt (networks with up to %d nodes)", typedConfirmNodes))
+	cmd.Flags().Bool("force", false, "With --yes, also skip typing the name of a larger network")
 
 	return cmd
 }
 
-// makeConfigInfoCommand creates the 'config info' command for a specific network
-func makeConfigInfoCommand(networkName string) *cobra.Command {
+// printNetworkContents prints the servers, nodes and config versions of a
+// network, indented under a heading.
+func printNetworkContents(w io.Writer, summary *wedev.NetworkSummary) {
+	servers := "none"
+	if len(summary.Servers) > 0 {
+		servers = strings.Join(summary.Servers, ", ")
+	}
+	fmt.Fprintf(w, "  Servers: %s\n", servers)
+
+	types := make([]string, 0, len(summary.NodesByType))
+	for nodeType := range summary.NodesByType {
+		types = append(types, string(nodeType))
+	}
+	sort.Strings(types)
+	counts := make([]string, 0, len(types))
+	for _, nodeType := range types {
+		counts = append(counts, fmt.Sprintf("%d %s", summary.NodesByType[wedev.NodeType(nodeType)], nodeType))
+	}
+	if len(counts) > 0 {
+		fmt.Fprintf(w, "  Nodes: %d (%s)\n", summary.Nodes, strings.Join(counts, ", "))
+	} else {
+		fmt.Fprintln(w, "  Nodes: 0")
+	}
+
+	fmt.Fprintf(w, "  Config Versions: %d\n", summary.ConfigVersions)
+}
+
+// NewVNRenameCommand creates the 'vn rename' command
+func NewVNRenameCommand(app *App) *cobra.Command {
 	return &cobra.Command{
-		Use:   "info [version]",
-		Short: "View configuration information",
-		Args:  cobra.RangeArgs(0, 1),
+		Use:               "rename <old-name> <new-name>",
+		Short:             "Rename a virtual network",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeNetworkNames,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
 
-			generator := wedev.NewWireGuardConfigGenerator(storage)
+			oldName := args[0]
+			newName := args[1]
 
-			var version *wedev.ConfigVersion
-			var err error
+			net, err := app.vnManager.RenameVirtualNetwork(oldName, newName)
+			if err != nil {
+				return fmt.Errorf("failed to rename network: %w", err)
+			}
 
-			if len(args) == 1 {
-				var ver int
-				_, parseErr := fmt.Sscanf(args[0], "%d", &ver)
-				if parseErr != nil {
-					return fmt.Errorf("invalid version number: %w", parseErr)
-				}
-				version, err = generator.GetConfig(networkName, ver)
-			} else {
-				history, histErr := generator.GetConfigHistory(networkName)
-				if histErr != nil || len(history) == 0 {
-					return fmt.Errorf("no configuration versions found")
+			fmt.Fprintf(out, "Virtual network '%s' renamed to '%s'\n", oldName, net.Name)
+			return nil
+		},
+	}
+}
+
+// NewVNCloneCommand creates the 'vn clone' command
+func NewVNCloneCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "clone <source-network> <new-network> [--cidr <new-cidr>] [--clear-addresses]",
+		Annotations: withKeys(nil),
+		Short:       "Copy a network under a new name",
+		Long: `Create a new network with the layout of an existing one: the same
+settings and labels, and a server and nodes with the same names, types,
+ports, labels and routed subnets. Every entity gets fresh keys and the same
+offset in the network as its original, so with --cidr 10.9.0.0/24 a node at
+10.8.0.5 in the source is copied to 10.9.0.5. Config history is not copied.
+
+Public addresses are copied unless --clear-addresses is given; the server
+and peer nodes then need one set with 'edit' before configs are generated.
+
+If any entity cannot be copied, the partial copy is removed.
+
+Examples:
+  wedevctl vn clone prod staging --cidr 10.9.0.0/24
+  wedevctl vn clone prod staging --cidr 10.9.0.0/24 --clear-addresses`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeNetworkNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			src := args[0]
+			dst := args[1]
+
+			cidr, err := cmd.Flags().GetString("cidr")
+			if err != nil {
+				return fmt.Errorf("failed to get cidr flag: %w", err)
+			}
+			clearAddresses, err := cmd.Flags().GetBool("clear-addresses")
+			if err != nil {
+				return fmt.Errorf("failed to get clear-addresses flag: %w", err)
+			}
+
+			net, err := app.vnManager.CloneVirtualNetworkCtx(cmd.Context(), src, dst, wedev.CloneOptions{CIDR: cidr, ClearAddresses: clearAddresses})
+			if err != nil {
+				return err
+			}
+			servers, err := app.vnManager.ListServersCtx(cmd.Context(), dst)
+			if err != nil {
+				return fmt.Errorf("failed to list servers: %w", err)
+			}
+			nodes, err := app.vnManager.ListNodesCtx(cmd.Context(), dst)
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
+			}
+
+			fmt.Fprintf(out, "Virtual network '%s' cloned from '%s'\n", net.Name, src)
+			fmt.Fprintf(out, "CIDR: %s\n", net.CIDR)
+			fmt.Fprintf(out, "Servers: %d, Nodes: %d (all with new keys)\n", len(servers), len(nodes))
+			if clearAddresses {
+				var missing []string
+				for _, s := range servers {
+					missing = append(missing, s.Name)
+				}
+				for _, n := range nodes {
+					if n.Type == wedev.NodeTypePeer {
+						missing = append(missing, n.Name)
+					}
+				}
+				if len(missing) > 0 {
+					fmt.Fprintf(out, "Set public addresses before generating configs: %s\n", strings.Join(missing, ", "))
 				}
-				version = history[len(history)-1]
 			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("cidr", "", "CIDR of the new network (default: the source's)")
+	cmd.Flags().Bool("clear-addresses", false, "Do not copy public addresses")
+
+	return cmd
+}
+
+// NewVNImportWGCommand creates the 'vn import-wg' command
+func NewVNImportWGCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "import-wg <network-name> --server-conf <file> [--node-conf <file>]... [--cidr <cidr>] [--server-address <address>]",
+		Annotations: withKeys(nil),
+		Short:       "Create a network from existing WireGuard configs",
+		Long: `Create a network from the WireGuard configs of a server and its nodes,
+keeping their private keys, interface addresses, listen ports and
+endpoints. Each server or node is named after its file, so wg0.conf becomes
+server wg0; rename files whose names are not alphanumeric.
+
+Nodes are matched to the server's [Peer] sections by public key. A node the
+server has an Endpoint for becomes a peer node; the others become route
+nodes, routing the AllowedIPs the server has for them outside the network.
+The server's public address is taken from the Endpoint the node configs give
+for it, unless --server-address is set.
+
+The network CIDR is inferred from the interface addresses (10.0.0.1/24 is
+in 10.0.0.0/24) unless --cidr is given. The IP pool starts with every
+imported address allocated.
+
+All files are checked before anything is created; problems such as an
+interface with several addresses or a peer matching none of the configs are
+reported together, with their file and line.
 
+Examples:
+  wedevctl vn import-wg office --server-conf wg0.conf --node-conf laptop.conf --node-conf nas.conf
+  wedevctl vn import-wg office --server-conf wg0.conf --node-conf laptop.conf --cidr 10.0.0.0/24`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			serverConf, err := cmd.Flags().GetString("server-conf")
 			if err != nil {
-				return fmt.Errorf("failed to get configuration: %w", err)
+				return fmt.Errorf("failed to get server-conf flag: %w", err)
+			}
+			nodeConfs, err := cmd.Flags().GetStringArray("node-conf")
+			if err != nil {
+				return fmt.Errorf("failed to get node-conf flag: %w", err)
+			}
+			cidr, err := cmd.Flags().GetString("cidr")
+			if err != nil {
+				return fmt.Errorf("failed to get cidr flag: %w", err)
+			}
+			serverAddress, err := cmd.Flags().GetString("server-address")
+			if err != nil {
+				return fmt.Errorf("failed to get server-address flag: %w", err)
 			}
 
-			fmt.Printf("Configuration Version: %d\n", version.Version)
-			fmt.Printf("Content Hash: %s\n", version.ContentHash)
-			fmt.Printf("Created At: %s\n", version.CreatedAt)
-			fmt.Printf("\nConfigurations:\n")
-			fmt.Println("================================================================================")
+			readConf := func(path string) (wedev.WireGuardConfigFile, error) {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return wedev.WireGuardConfigFile{}, fmt.Errorf("failed to read config file: %w", err)
+				}
+				return wedev.WireGuardConfigFile{Path: path, Content: string(data)}, nil
+			}
+			opts := wedev.ImportOptions{CIDR: cidr, ServerAddress: serverAddress}
+			if opts.Server, err = readConf(serverConf); err != nil {
+				return err
+			}
+			for _, path := range nodeConfs {
+				file, err := readConf(path)
+				if err != nil {
+					return err
+				}
+				opts.Nodes = append(opts.Nodes, file)
+			}
 
-			// Sort names for consistent output
-			names := make([]string, 0, len(version.Configs))
-			for name := range version.Configs {
-				names = append(names, name)
+			net, err := app.vnManager.ImportWireGuardCtx(cmd.Context(), args[0], opts)
+			if err != nil {
+				return err
+			}
+			nodes, err := app.vnManager.ListNodesCtx(cmd.Context(), net.Name)
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
 			}
-			sort.Strings(names)
 
-			// Display each config content
-			for i, name := range names {
-				if i > 0 {
-					fmt.Println("\n--------------------------------------------------------------------------------")
+			fmt.Fprintf(out, "Virtual network '%s' imported from %d config file(s)\n", net.Name, len(nodeConfs)+1)
+			fmt.Fprintf(out, "CIDR: %s\n", net.CIDR)
+			fmt.Fprintf(out, "Servers: 1, Nodes: %d (keys and addresses kept)\n", len(nodes))
+			return nil
+		},
+	}
+
+	cmd.Flags().String("server-conf", "", "WireGuard config of the server (required)")
+	cmd.Flags().StringArray("node-conf", nil, "WireGuard config of a node (repeatable)")
+	cmd.Flags().String("cidr", "", "Network CIDR (default: inferred from the interface addresses)")
+	cmd.Flags().String("server-address", "", "Public address of the server (default: from the node configs' Endpoint)")
+	//nolint:errcheck // The flag is defined just above
+	_ = cmd.MarkFlagRequired("server-conf")
+
+	return cmd
+}
+
+// ========== Server Commands ==========
+
+// makeServerCommand creates the 'server' command group for a specific network
+func makeServerCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "server",
+		Short: "Manage servers",
+		Long:  fmt.Sprintf("Manage servers in virtual network '%s'", networkName),
+	}
+
+	cmd.AddCommand(withDriftCheck(app, networkName, makeServerAddCommand(app, networkName)))
+	cmd.AddCommand(makeServerListCommand(app, networkName))
+	cmd.AddCommand(makeServerInfoCommand(app, networkName))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeServerEditCommand(app, networkName)))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeServerRenameCommand(app, networkName)))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeServerDeleteCommand(app, networkName)))
+	cmd.AddCommand(makeServerHistoryCommand(app, networkName))
+
+	return cmd
+}
+
+// withDriftCheck makes cmd, a command that changes servers or nodes, report
+// afterwards when the latest saved config version no longer matches what
+// 'config generate' would write now, so deployed configs are known to be
+// stale. --no-drift-check skips it. The check is best-effort: a network
+// whose configs cannot be generated yet (no server) reports nothing.
+func withDriftCheck(app *App, networkName string, cmd *cobra.Command) *cobra.Command {
+	cmd.Flags().Bool("no-drift-check", false, "Do not check whether the latest saved config version is still current")
+	cmd.PostRunE = func(cmd *cobra.Command, _args []string) error {
+		skip, err := cmd.Flags().GetBool("no-drift-check")
+		if err != nil {
+			return fmt.Errorf("failed to get no-drift-check flag: %w", err)
+		}
+		if skip {
+			return nil
+		}
+		drift, err := app.generator.CheckDriftCtx(cmd.Context(), networkName)
+		if err != nil {
+			app.storage.Logger().Debug("skipping config drift check", "network", networkName, "error", err)
+			return nil
+		}
+		if drift.Drifted {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Configuration drift: latest saved version v%d no longer matches; run 'config generate'\n", drift.LatestVersion)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// makeServerAddCommand creates the 'server add' command for a specific network
+func makeServerAddCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "add <server-name> <public-address> [port] [--additional-address <addr>]",
+		Annotations: withKeys(nil),
+		Short:       "Create a new server",
+		Long: `Create a new server in the virtual network.
+
+A network can have several servers, for example a primary and a failover
+hub in different regions. The first server takes the network's reserved
+server IP; later servers are allocated an IP like a node. Nodes peer with
+the first server unless assigned another with 'node add --server'.
+
+An endpoint another server or node already uses is reported as a warning,
+or refused with --strict.
+
+--additional-address gives further public addresses of the server, such as
+one per ISP, on the same port. Nodes dial the public address unless their
+--endpoint-preference picks another; the addresses they do not dial are
+written commented out in their configs, to switch to by hand.
+
+Examples:
+  wedevctl vn mynet server add hub1 vpn1.example.com
+  wedevctl vn mynet server add hub2 vpn2.example.com 51820
+  wedevctl vn mynet server add hub3 isp1.example.com --additional-address isp2.example.com`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			serverName := args[0]
+			publicAddress := args[1]
+
+			port := 51820
+			if len(args) == 3 {
+				var err error
+				if port, err = parsePort(args[2]); err != nil {
+					return err
+				}
+			}
+
+			keys, err := importedKeys(cmd)
+			if err != nil {
+				return err
+			}
+			additional, err := additionalAddressesFlag(cmd)
+			if err != nil {
+				return err
+			}
+			if err := checkEndpoint(app, cmd, networkName, serverName, publicAddress, port); err != nil {
+				return fmt.Errorf("failed to create server: %w", err)
+			}
+
+			server, err := app.vnManager.CreateServer(networkName, serverName, publicAddress, port)
+			if err != nil {
+				return fmt.Errorf("failed to create server: %w", err)
+			}
+			if keys != nil {
+				server, err = app.vnManager.ImportServerKeys(networkName, serverName, keys)
+				if err != nil {
+					// Remove the server again rather than leave it with
+					// generated keys the user did not ask for.
+					//nolint:errcheck // Acceptable to ignore in error cleanup path
+					_ = app.vnManager.DeleteServer(networkName, wedev.DeleteServerOptions{Name: serverName, KeepNodes: true})
+					return fmt.Errorf("failed to import server keys: %w", err)
 				}
-				fmt.Printf("\n[%s.conf]\n\n", name)
-				fmt.Print(version.Configs[name])
-				if !strings.HasSuffix(version.Configs[name], "\n") {
-					fmt.Println()
+			}
+			if len(additional) > 0 {
+				server, err = app.vnManager.EditServer(networkName, serverName, wedev.ServerEdit{IgnoreConflict: true, AdditionalAddresses: &additional})
+				if err != nil {
+					//nolint:errcheck // Acceptable to ignore in error cleanup path
+					_ = app.vnManager.DeleteServer(networkName, wedev.DeleteServerOptions{Name: serverName, KeepNodes: true})
+					return fmt.Errorf("failed to create server: %w", err)
 				}
 			}
-			fmt.Println("================================================================================")
+
+			fmt.Fprintf(out, "Server '%s' created successfully\n", server.Name)
+			fmt.Fprintf(out, "Virtual IP: %s\n", server.VirtualIP)
+			fmt.Fprintf(out, "Public Address: %s:%d\n", server.PublicAddress, server.Port)
+			if len(server.AdditionalAddresses) > 0 {
+				fmt.Fprintf(out, "Additional Addresses: %s\n", formatAdditionalAddresses(server))
+			}
+			printImportedKeys(out, keys, server.PublicKey)
 
 			return nil
 		},
 	}
+
+	cmd.Flags().StringSlice("additional-address", nil, "Further public address of the server, on the same port (repeatable)")
+	keyImportFlags(cmd)
+	strictEndpointFlag(cmd)
+
+	return cmd
 }
 
-// makeConfigHistoryCommand creates the 'config history' command for a specific network
-func makeConfigHistoryCommand(networkName string) *cobra.Command {
+// serverListEntry is the JSON shape of one server in 'server list --output
+// json'. The private key is left out.
+type serverListEntry struct {
+	Name                string   `json:"name"`
+	VirtualIP           string   `json:"virtual_ip"`
+	PublicAddress       string   `json:"public_address"`
+	Port                int      `json:"port"`
+	InternalAddress     string   `json:"internal_address,omitempty"`
+	InternalPort        int      `json:"internal_port,omitempty"`
+	AdditionalAddresses []string `json:"additional_addresses,omitempty"`
+	PublicKey           string   `json:"public_key"`
+	Nodes               int      `json:"nodes"`
+}
+
+// makeServerListCommand creates the 'server list' command for a specific network
+func makeServerListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List servers",
+		Long: `List the servers in the network with the number of nodes assigned to
+each. Nodes without an explicit assignment count towards the first server.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			servers, err := app.vnManager.ListServersCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list servers: %w", err)
+			}
+			nodes, err := app.vnManager.ListNodesCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
+			}
+			counts := make(map[string]int, len(servers))
+			for _, node := range nodes {
+				if home := wedev.NodeServer(node, servers); home != nil {
+					counts[home.ID]++
+				}
+			}
+
+			entries := make([]serverListEntry, 0, len(servers))
+			for _, server := range servers {
+				entries = append(entries, serverListEntry{
+					Name:                server.Name,
+					VirtualIP:           server.VirtualIP,
+					PublicAddress:       server.PublicAddress,
+					Port:                server.Port,
+					InternalAddress:     server.InternalAddress,
+					InternalPort:        server.InternalPort,
+					AdditionalAddresses: server.AdditionalAddresses,
+					PublicKey:           server.PublicKey,
+					Nodes:               counts[server.ID],
+				})
+			}
+
+			switch output {
+			case "json":
+				return printJSON(out, entries)
+			case "yaml":
+				return printYAML(out, entries)
+			}
+
+			rows := make([][]string, 0, len(entries))
+			for _, e := range entries {
+				rows = append(rows, []string{e.Name, e.VirtualIP, fmt.Sprintf("%s:%d", e.PublicAddress, e.Port), strconv.Itoa(e.Nodes)})
+			}
+			return printListTable(cmd, out, "No servers found", []string{"Name", "Virtual IP", "Endpoint", "Nodes"}, rows)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// makeServerInfoCommand creates the 'server info' command for a specific network
+func makeServerInfoCommand(app *App, networkName string) *cobra.Command {
 	return &cobra.Command{
-		Use:   "history",
-		Short: "View configuration history",
-		Args:  cobra.NoArgs,
-		RunE: func(_ *cobra.Command, _ []string) error {
+		Use:         "info [server-name]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show server information",
+		Long: `Show a server's details. The name may be omitted when the network has one
+server. For a server with additional addresses, the endpoint each of its
+nodes dials is listed too.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeServerNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
 
-			generator := wedev.NewWireGuardConfigGenerator(storage)
-			history, err := generator.GetConfigHistory(networkName)
+			server, err := app.vnManager.GetServer(networkName, optionalArg(args, 0))
 			if err != nil {
-				return fmt.Errorf("failed to get config history: %w", err)
+				return fmt.Errorf("failed to get server: %w", err)
+			}
+
+			fmt.Fprintf(out, "Server: %s\n", server.Name)
+			fmt.Fprintf(out, "Virtual IP: %s\n", server.VirtualIP)
+			fmt.Fprintf(out, "Public Address: %s:%d\n", server.PublicAddress, server.Port)
+			if len(server.AdditionalAddresses) > 0 {
+				fmt.Fprintf(out, "Additional Addresses: %s\n", formatAdditionalAddresses(server))
+			}
+			if server.InternalAddress != "" {
+				fmt.Fprintf(out, "Internal Endpoint: %s\n", server.EndpointFor(true))
 			}
+			fmt.Fprintf(out, "ID: %s\n", server.ID)
 
-			if len(history) == 0 {
-				fmt.Println("No configuration versions found")
+			// With several addresses, show which one each node dials.
+			if len(server.AdditionalAddresses) == 0 {
 				return nil
 			}
+			servers, err := app.vnManager.ListServersCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list servers: %w", err)
+			}
+			nodes, err := app.vnManager.ListNodesCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
+			}
+			var rows [][]string
+			for _, node := range nodes {
+				if home := wedev.NodeServer(node, servers); home == nil || (home.ID != server.ID && !node.MeshServers) {
+					continue
+				}
+				endpoint := server.EndpointForNode(node)
+				rows = append(rows, []string{node.Name, endpoint.Endpoint, endpoint.Assignment})
+			}
+			if len(rows) > 0 {
+				slices.SortFunc(rows, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+				fmt.Fprintln(out)
+				printTable(out, []string{"Node", "Endpoint", "Assignment"}, rows)
+			}
+			return nil
+		},
+	}
+}
 
-			fmt.Printf("%-8s %-35s %-20s\n", "Version", "Hash", "Created")
-			fmt.Println("--------------------------------------------------------------")
-			for _, cfg := range history {
-				fmt.Printf("%-8d %-35s %-20s\n", cfg.Version, cfg.ContentHash, cfg.CreatedAt.Format("2006-01-02 15:04:05"))
+// makeServerEditCommand creates the 'server edit' command for a specific network
+func makeServerEditCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit [server-name] [--public-address <addr>] [--port <port>] [--additional-address <addr>] [--internal-address <addr>] [--internal-port <port>] [--strict] [--ignore-conflict]",
+		Short: "Edit server information",
+		Long: `Edit a server's endpoint. The name may be omitted when the network has one server.
+
+--internal-address sets a second endpoint, such as the server's address on a
+datacenter or office LAN, that nodes marked with 'node edit --prefer-internal'
+dial instead of the public one. --internal-port defaults to the public port;
+an empty --internal-address removes the internal endpoint.
+
+--additional-address replaces the server's further public addresses, which
+nodes dial by their --endpoint-preference; an empty string clears them.
+
+The edit fails when another process changed the server after it was read;
+re-run it, or pass --ignore-conflict to write it anyway.
+
+Examples:
+  wedevctl vn mynet server edit --public-address vpn.example.com --port 51820
+  wedevctl vn mynet server edit hub --internal-address 10.10.0.5
+  wedevctl vn mynet server edit hub --internal-address ""
+  wedevctl vn mynet server edit hub --additional-address isp2.example.com`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeServerNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			serverName := optionalArg(args, 0)
+
+			publicAddress, err := cmd.Flags().GetString("public-address")
+			if err != nil {
+				return fmt.Errorf("failed to get public-address flag: %w", err)
+			}
+			port, err := cmd.Flags().GetInt("port")
+			if err != nil {
+				return fmt.Errorf("failed to get port flag: %w", err)
+			}
+
+			internalChanged := cmd.Flags().Changed("internal-address") || cmd.Flags().Changed("internal-port")
+			additionalChanged := cmd.Flags().Changed("additional-address")
+
+			if publicAddress == "" && port == 0 && !internalChanged && !additionalChanged {
+				return fmt.Errorf("must specify at least --public-address, --port, --additional-address, --internal-address or --internal-port")
+			}
+
+			server, err := app.vnManager.GetServer(networkName, serverName)
+			if err != nil {
+				return fmt.Errorf("failed to get server: %w", err)
+			}
+
+			ignoreConflict, err := cmd.Flags().GetBool("ignore-conflict")
+			if err != nil {
+				return fmt.Errorf("failed to get ignore-conflict flag: %w", err)
+			}
+			edit := wedev.ServerEdit{Revision: server.Revision, IgnoreConflict: ignoreConflict}
+			if publicAddress != "" || port != 0 {
+				// Use current values if not specified
+				if publicAddress == "" {
+					publicAddress = server.PublicAddress
+				}
+				if port == 0 {
+					port = server.Port
+				}
+				if err := checkEndpoint(app, cmd, networkName, server.Name, publicAddress, port); err != nil {
+					return fmt.Errorf("failed to update server: %w", err)
+				}
+				edit.PublicAddress, edit.Port = &publicAddress, &port
+			}
+
+			if internalChanged {
+				address, internalPort, err := internalEndpointFlags(cmd, server.InternalAddress, server.InternalPort)
+				if err != nil {
+					return err
+				}
+				edit.InternalAddress, edit.InternalPort = &address, &internalPort
+			}
+			if additionalChanged {
+				additional, err := additionalAddressesFlag(cmd)
+				if err != nil {
+					return err
+				}
+				edit.AdditionalAddresses = &additional
+			}
+
+			updated, err := app.vnManager.EditServer(networkName, server.Name, edit)
+			if err != nil {
+				return fmt.Errorf("failed to update server: %w", err)
+			}
+
+			fmt.Fprintf(out, "Server '%s' updated successfully\n", updated.Name)
+			fmt.Fprintf(out, "Public Address: %s:%d\n", updated.PublicAddress, updated.Port)
+			if len(updated.AdditionalAddresses) > 0 {
+				fmt.Fprintf(out, "Additional Addresses: %s\n", formatAdditionalAddresses(updated))
+			}
+			if updated.InternalAddress != "" {
+				fmt.Fprintf(out, "Internal Endpoint: %s\n", updated.EndpointFor(true))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("public-address", "", "Public address or domain")
+	cmd.Flags().Int("port", 0, "Port number")
+	cmd.Flags().StringSlice("additional-address", nil, "Further public address of the server, on the same port (repeatable; empty clears)")
+	internalEndpointFlagSet(cmd)
+	strictEndpointFlag(cmd)
+	ignoreConflictFlag(cmd)
+
+	return cmd
+}
+
+// internalEndpointFlagSet declares the --internal-address and
+// --internal-port flags of 'server edit' and 'node edit'.
+func internalEndpointFlagSet(cmd *cobra.Command) {
+	cmd.Flags().String("internal-address", "", "Address dialled by nodes with --prefer-internal (empty string clears)")
+	cmd.Flags().Int("internal-port", 0, "Port of the internal endpoint (default: the public port)")
+}
+
+// internalEndpointFlags returns the internal endpoint set by
+// --internal-address and --internal-port, keeping the current value of
+// whichever flag was not given.
+func internalEndpointFlags(cmd *cobra.Command, currentAddress string, currentPort int) (string, int, error) {
+	address, port := currentAddress, currentPort
+	if cmd.Flags().Changed("internal-address") {
+		var err error
+		if address, err = cmd.Flags().GetString("internal-address"); err != nil {
+			return "", 0, fmt.Errorf("failed to get internal-address flag: %w", err)
+		}
+	}
+	if cmd.Flags().Changed("internal-port") {
+		var err error
+		if port, err = cmd.Flags().GetInt("internal-port"); err != nil {
+			return "", 0, fmt.Errorf("failed to get internal-port flag: %w", err)
+		}
+	}
+	if address == "" && cmd.Flags().Changed("internal-port") && !cmd.Flags().Changed("internal-address") {
+		return "", 0, fmt.Errorf("--internal-port requires an internal address (use --internal-address)")
+	}
+	return address, port, nil
+}
+
+// makeServerRenameCommand creates the 'server rename' command for a specific network
+func makeServerRenameCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "rename [old-name] <new-name>",
+		Short:             "Rename a server",
+		Long:              "Rename a server. The old name may be omitted when the network has one server.",
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeServerNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			oldName, newName := "", args[0]
+			if len(args) == 2 {
+				oldName, newName = args[0], args[1]
+			}
+
+			server, err := app.vnManager.RenameServer(networkName, oldName, newName)
+			if err != nil {
+				return fmt.Errorf("failed to rename server: %w", err)
 			}
 
+			fmt.Fprintf(out, "Server renamed to '%s'\n", server.Name)
 			return nil
 		},
 	}
 }
 
-// confirmAction prompts user for confirmation.
-func confirmAction(prompt string) bool {
-	// For testing, we may redirect stdin
-	fmt.Printf("%s (y/n): ", prompt)
-	var response string
-	_, err := fmt.Scanln(&response)
-	if err != nil && err != io.EOF {
-		return false
+// makeServerDeleteCommand creates the 'server delete' command for a specific network
+func makeServerDeleteCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete [server-name]",
+		Aliases: []string{"rm"},
+		Short:   "Delete a server",
+		Long: `Delete a server. The name may be omitted when the network has one server.
+
+A server with nodes depending on it (assigned to it, or falling back to it as
+the first server) is only deleted with --cascade, which deletes those nodes
+too and releases their IPs, or --keep-nodes, which keeps them: they fall back
+to the first remaining server and their configs must be regenerated. With no
+server left, a hub-spoke network's configs cannot be generated; a mesh
+network's nodes then peer only with each other.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeServerNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			cascade, err := cmd.Flags().GetBool("cascade")
+			if err != nil {
+				return fmt.Errorf("failed to get cascade flag: %w", err)
+			}
+			keepNodes, err := cmd.Flags().GetBool("keep-nodes")
+			if err != nil {
+				return fmt.Errorf("failed to get keep-nodes flag: %w", err)
+			}
+			opts := wedev.DeleteServerOptions{Cascade: cascade, KeepNodes: keepNodes}
+
+			server, err := app.vnManager.GetServer(networkName, optionalArg(args, 0))
+			if err != nil {
+				return fmt.Errorf("failed to get server: %w", err)
+			}
+			opts.Name = server.Name
+
+			dependents, err := app.vnManager.ServerDependents(networkName, server.Name)
+			if err != nil {
+				return fmt.Errorf("failed to get server: %w", err)
+			}
+			names := make([]string, 0, len(dependents))
+			for _, node := range dependents {
+				names = append(names, node.Name)
+			}
+
+			prompt := fmt.Sprintf("Delete server '%s' in network '%s'?", server.Name, networkName)
+			switch {
+			case len(dependents) == 0:
+			case cascade:
+				prompt = fmt.Sprintf("Delete server '%s' in network '%s' and its %d node(s) (%s)?",
+					server.Name, networkName, len(dependents), strings.Join(names, ", "))
+			case keepNodes:
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %d node(s) depend on server '%s' (%s); their configs must be regenerated once it is deleted\n",
+					len(dependents), server.Name, strings.Join(names, ", "))
+			default:
+				// The manager refuses and explains the impact, unless the
+				// nodes have moved away since they were listed.
+				if err := app.vnManager.DeleteServer(networkName, opts); err != nil {
+					return fmt.Errorf("failed to delete server: %w; pass --cascade to delete the nodes too, or --keep-nodes to keep them", err)
+				}
+				fmt.Fprintf(out, "Server '%s' deleted successfully\n", server.Name)
+				return nil
+			}
+
+			if !confirmAction(cmd, prompt, assumeFor(app, promptDelete)) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+
+			err = app.vnManager.DeleteServer(networkName, opts)
+			if err != nil {
+				return fmt.Errorf("failed to delete server: %w", err)
+			}
+
+			if cascade {
+				for _, name := range names {
+					fmt.Fprintf(out, "Node '%s' deleted\n", name)
+				}
+			}
+			fmt.Fprintf(out, "Server '%s' deleted successfully\n", server.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("cascade", false, "Also delete the nodes depending on the server, releasing their IPs")
+	cmd.Flags().Bool("keep-nodes", false, "Delete the server even though nodes depend on it, keeping them")
+	cmd.MarkFlagsMutuallyExclusive("cascade", "keep-nodes")
+
+	return cmd
+}
+
+// ========== Node Commands ==========
+
+// makeServerHistoryCommand creates the 'server history' command for a specific network
+func makeServerHistoryCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "history [server-name] [--addresses] [--output table|json|yaml]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show a server's change history",
+		Long: `Show the recent edits, renames and key rotations of a server, oldest
+first, with the fields each changed. The name may be omitted when the
+network has one server. Private keys are shown as "(redacted)".
+
+--addresses lists the public addresses the server had instead, each with the
+time it was replaced, and the current one.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeServerNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			addresses, err := cmd.Flags().GetBool("addresses")
+			if err != nil {
+				return fmt.Errorf("failed to get addresses flag: %w", err)
+			}
+			if addresses {
+				server, err := app.vnManager.GetServer(networkName, optionalArg(args, 0))
+				if err != nil {
+					return fmt.Errorf("failed to get server: %w", err)
+				}
+				return printAddressHistory(cmd.OutOrStdout(), output, server.PublicAddress, &server.EndpointHistory)
+			}
+			history, err := app.vnManager.ServerHistory(networkName, optionalArg(args, 0))
+			if err != nil {
+				return fmt.Errorf("failed to get server history: %w", err)
+			}
+			return printEntityHistory(cmd.OutOrStdout(), output, history)
+		},
+	}
+
+	cmd.Flags().Bool("addresses", false, "List the server's previous public addresses")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// makeNodeCommand creates the 'node' command group for a specific network
+func makeNodeCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "node",
+		Short: "Manage nodes",
+		Long:  fmt.Sprintf("Manage nodes in virtual network '%s'", networkName),
+	}
+
+	cmd.AddCommand(withDriftCheck(app, networkName, makeNodeAddCommand(app, networkName)))
+	cmd.AddCommand(makeNodeListCommand(app, networkName))
+	cmd.AddCommand(makeNodeInfoCommand(app, networkName))
+	cmd.AddCommand(makeNodeExplainCommand(app, networkName))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeNodeEditCommand(app, networkName)))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeNodeRenameCommand(app, networkName)))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeNodeDeleteCommand(app, networkName)))
+	cmd.AddCommand(withDriftCheck(app, networkName, makeNodePurgeExpiredCommand(app, networkName)))
+	cmd.AddCommand(makeNodeHistoryCommand(app, networkName))
+	cmd.AddCommand(makeNodeBundleCommand(app, networkName))
+
+	return cmd
+}
+
+// makeNodeAddCommand creates the 'node add' command for a specific network
+func makeNodeAddCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "add <node-name> <type> [public-address] [port] [--route-cidr <cidr>] [--label key=value] [--group <name>] [--server <name>] [--mesh-servers] [--full-tunnel] [--endpoint-preference <pref>] [--expires <date> | --ttl <duration>] [--private-key <key> | --key-file <path>] [--public-key <key>]",
+		Annotations: withKeys(nil),
+		Short:       "Create a new node",
+		Long: `Create a new node in the virtual network.
+
+Type can be 'peer', 'route' or 'client':
+  - peer: requires public-address, participates in peer-to-peer connections
+  - route: public-address is optional, only connects to server
+  - client: a roaming device without public-address or routed subnets; it
+    peers with its server only (everything else goes through the server, or
+    all traffic with --full-tunnel), and no other node lists it as a peer
+
+The port defaults to the network's default port (see 'vn edit
+--default-port'). A public address and port already used by another node or
+the server is rejected, naming it and suggesting the next free port, unless
+--allow-duplicate-endpoint is given. A public address another node or the
+server uses on a different port is accepted with a warning: one host can
+run several peers.
+
+In a network with several servers the node peers with the first server
+unless --server names another. With --mesh-servers it peers with every
+server, and still reaches the rest of the network through its own.
+
+--endpoint-preference picks which address the node dials a server with
+additional addresses (see 'server add --additional-address') at: primary
+(the default), secondary, or round-robin, which spreads nodes over all of
+them.
+
+--full-tunnel routes all of the node's traffic (0.0.0.0/0 and ::/0) through
+its server, using the network's DNS servers if it has any; otherwise only the
+VPN subnet goes through the tunnel.
+
+--group puts the node in a group by setting its "group" label, so commands
+taking --selector can target the group with --selector group=<name>; see
+'vn <network> group list'.
+
+--expires or --ttl gives the node temporary access: once it passes, the node
+is left out of generated configs and can be removed with 'node
+purge-expired'.
+
+Examples:
+  # Peer node (public-address required)
+  wedevctl vn mynet node add node1 peer 192.168.1.100
+  wedevctl vn mynet node add node1 peer 192.168.1.100 51821
+
+  # Route node (public-address optional)
+  wedevctl vn mynet node add node2 route
+  wedevctl vn mynet node add node2 route 192.168.1.200 51822
+
+  # Route node exposing a LAN subnet behind it
+  wedevctl vn mynet node add office route --route-cidr 192.168.50.0/24
+
+  # Roaming laptop talking to the server only
+  wedevctl vn mynet node add alice-laptop client
+
+  # Node in the "laptops" group
+  wedevctl vn mynet node add alice-laptop route --group laptops
+
+  # Second node on the same host, on the next free port
+  wedevctl vn mynet node add node3 peer 192.168.1.100 --auto-port
+
+  # Enroll a machine with its existing WireGuard identity
+  wedevctl vn mynet node add laptop route --key-file /etc/wireguard/private.key
+
+  # Peer whose config is managed elsewhere (only its public key is known)
+  wedevctl vn mynet node add edge peer 203.0.113.7 --public-key <base64-key>
+
+  # Node homed on the failover server, with tunnels to every server
+  wedevctl vn mynet node add branch route --server hub2 --mesh-servers
+
+  # Laptop sending all of its traffic through the VPN
+  wedevctl vn mynet node add laptop route --full-tunnel
+
+  # Contractor access for two weeks
+  wedevctl vn mynet node add contractor route --ttl 336h`,
+		Args: cobra.RangeArgs(2, 4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			nodeName := args[0]
+			nodeTypeStr := args[1]
+
+			routeCIDRs, err := cmd.Flags().GetStringSlice("route-cidr")
+			if err != nil {
+				return fmt.Errorf("failed to get route-cidr flag: %w", err)
+			}
+			labelPairs, err := cmd.Flags().GetStringArray("label")
+			if err != nil {
+				return fmt.Errorf("failed to get label flag: %w", err)
+			}
+			labels, err := util.ParseLabels(labelPairs)
+			if err != nil {
+				return err
+			}
+			if err := groupFlag(cmd, labels); err != nil {
+				return err
+			}
+			keys, err := importedKeys(cmd)
+			if err != nil {
+				return err
+			}
+			serverName, err := cmd.Flags().GetString("server")
+			if err != nil {
+				return fmt.Errorf("failed to get server flag: %w", err)
+			}
+			meshServers, err := cmd.Flags().GetBool("mesh-servers")
+			if err != nil {
+				return fmt.Errorf("failed to get mesh-servers flag: %w", err)
+			}
+			fullTunnel, err := cmd.Flags().GetBool("full-tunnel")
+			if err != nil {
+				return fmt.Errorf("failed to get full-tunnel flag: %w", err)
+			}
+			endpointPreference, err := changedEndpointPreference(cmd)
+			if err != nil {
+				return err
+			}
+			expiresAt, _, err := parseExpiryFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			nodeType, err := wedev.ParseNodeType(nodeTypeStr)
+			if err != nil {
+				return err
+			}
+
+			// Parse public address
+			publicAddress := ""
+			if len(args) >= 3 {
+				publicAddress = args[2]
+			}
+
+			// Validate: peer type requires public address, client type has none
+			if nodeType == wedev.NodeTypePeer && publicAddress == "" {
+				return fmt.Errorf("peer type nodes require a public address")
+			}
+			if nodeType == wedev.NodeTypeClient && publicAddress != "" {
+				return fmt.Errorf("client type nodes have no public address")
+			}
+
+			// Validate: only route nodes expose subnets
+			if nodeType != wedev.NodeTypeRoute && len(routeCIDRs) > 0 {
+				return fmt.Errorf("--route-cidr is only supported for route nodes")
+			}
+
+			// Parse port; 0 takes the network's default port.
+			port := 0
+			if len(args) >= 4 {
+				if port, err = parsePort(args[3]); err != nil {
+					return err
+				}
+			}
+
+			port, err = resolveNodePort(app, cmd, networkName, publicAddress, port, len(args) >= 4)
+			if err != nil {
+				return err
+			}
+
+			allowDuplicate, err := cmd.Flags().GetBool("allow-duplicate-endpoint")
+			if err != nil {
+				return fmt.Errorf("failed to get allow-duplicate-endpoint flag: %w", err)
+			}
+			node, err := app.vnManager.CreateNodeWithOptions(networkName, nodeName, publicAddress, port, nodeType,
+				wedev.NodeOptions{RoutedCIDRs: routeCIDRs, AllowDuplicateEndpoint: allowDuplicate})
+			if err != nil {
+				return fmt.Errorf("failed to create node: %w", err)
+			}
+			if keys != nil {
+				node, err = app.vnManager.ImportNodeKeys(networkName, nodeName, keys)
+				if err != nil {
+					// Remove the node again rather than leave it with
+					// generated keys the user did not ask for.
+					//nolint:errcheck // Acceptable to ignore in error cleanup path
+					_ = app.vnManager.DeleteNode(networkName, nodeName)
+					return fmt.Errorf("failed to import node keys: %w", err)
+				}
+			}
+			if serverName != "" || meshServers {
+				node, err = app.vnManager.AssignNodeServer(networkName, nodeName, serverName, meshServers)
+				if err != nil {
+					//nolint:errcheck // Acceptable to ignore in error cleanup path
+					_ = app.vnManager.DeleteNode(networkName, nodeName)
+					return fmt.Errorf("failed to assign node server: %w", err)
+				}
+			}
+			if fullTunnel {
+				node, err = app.vnManager.SetNodeFullTunnel(networkName, nodeName, true)
+				if err != nil {
+					return fmt.Errorf("failed to set full tunnel: %w", err)
+				}
+			}
+			if endpointPreference != nil {
+				node, err = app.vnManager.EditNode(networkName, nodeName, wedev.NodeEdit{IgnoreConflict: true, EndpointPreference: endpointPreference})
+				if err != nil {
+					return fmt.Errorf("failed to set endpoint preference: %w", err)
+				}
+			}
+			if len(labels) > 0 {
+				node, err = app.vnManager.UpdateNodeLabels(networkName, nodeName, labels, nil)
+				if err != nil {
+					return fmt.Errorf("failed to set node labels: %w", err)
+				}
+			}
+			if expiresAt != nil {
+				node, err = app.vnManager.SetNodeExpiry(networkName, nodeName, expiresAt)
+				if err != nil {
+					return fmt.Errorf("failed to set node expiry: %w", err)
+				}
+			}
+
+			fmt.Fprintf(out, "Node '%s' created successfully\n", node.Name)
+			fmt.Fprintf(out, "Virtual IP: %s\n", node.VirtualIP)
+			fmt.Fprintf(out, "Type: %s\n", node.Type)
+			if publicAddress != "" {
+				fmt.Fprintf(out, "Public Address: %s:%d\n", node.PublicAddress, node.Port)
+			}
+			if len(node.RoutedCIDRs) > 0 {
+				fmt.Fprintf(out, "Routed CIDRs: %s\n", strings.Join(node.RoutedCIDRs, ", "))
+			}
+			if len(node.Labels) > 0 {
+				fmt.Fprintf(out, "Labels: %s\n", formatLabels(node.Labels))
+			}
+			if serverName != "" {
+				fmt.Fprintf(out, "Server: %s\n", serverName)
+			}
+			if node.MeshServers {
+				fmt.Fprintln(out, "Mesh Servers: yes")
+			}
+			if node.FullTunnel {
+				fmt.Fprintln(out, "Full Tunnel: yes")
+			}
+			if node.EndpointPreference != "" {
+				fmt.Fprintf(out, "Endpoint Preference: %s\n", node.EndpointPreference)
+			}
+			if node.ExpiresAt != nil {
+				fmt.Fprintf(out, "Expires: %s\n", formatExpiry(node.ExpiresAt))
+			}
+			if usage, err := app.vnManager.PoolUsage(networkName); err == nil {
+				fmt.Fprintf(out, "Addresses: %s\n", formatPoolUsage(usage))
+			}
+			printImportedKeys(out, keys, node.PublicKey)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSlice("route-cidr", nil, "LAN subnet behind a route node (repeatable)")
+	cmd.Flags().StringArray("label", nil, "Label as key=value (repeatable)")
+	cmd.Flags().String("group", "", "Put the node in this group (sets the \"group\" label)")
+	cmd.Flags().Bool("auto-port", false, "Pick the next port not used by another node at the same public address")
+	cmd.Flags().String("port-range", "", "Range --auto-port picks from, as start-end (default: network default port to 65535)")
+	cmd.Flags().Bool("allow-duplicate-endpoint", false, "Allow a public address and port already used by another node or the server")
+	cmd.Flags().String("server", "", "Server the node peers with (default: the network's first server)")
+	cmd.Flags().Bool("mesh-servers", false, "Peer with every server, not only the assigned one")
+	cmd.Flags().Bool("full-tunnel", false, "Route all of the node's traffic through its server")
+	endpointPreferenceFlag(cmd)
+	expiryFlags(cmd, false)
+	keyImportFlags(cmd)
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.RegisterFlagCompletionFunc("server", completeServerFlag(networkName))
+
+	return cmd
+}
+
+// makeNodeListCommand creates the 'node list' command for a specific network
+func makeNodeListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--selector <expr>] [--expired] [--sort name|created|ip] [--limit <n>] [--offset <n>] [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List all nodes",
+		Long: `List nodes in the virtual network, sorted by name, or with --sort by
+creation time (oldest first) or virtual IP. JSON and YAML output use the same
+order.
+
+--selector filters by label with comma-separated key=value and key!=value
+terms, all of which must match. --expired lists only nodes whose access has
+ended (see 'node add --expires').
+
+--limit and --offset page through large networks: the matching nodes are
+sorted, --offset of them skipped, and at most --limit listed. The table then
+ends with the range shown and the number of matching nodes, unless
+--no-header leaves it out.
+
+Examples:
+  wedevctl vn mynet node list --selector role=db
+  wedevctl vn mynet node list --selector role=db,site!=ams --output json
+  wedevctl vn mynet node list --expired
+  wedevctl vn mynet node list --sort ip --limit 50 --offset 100`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			selector, output, err := listFilterFlags(cmd)
+			if err != nil {
+				return err
+			}
+			opts := wedev.NodeListOptions{Selector: selector}
+			if opts.ExpiredOnly, err = cmd.Flags().GetBool("expired"); err != nil {
+				return fmt.Errorf("failed to get expired flag: %w", err)
+			}
+			order, err := cmd.Flags().GetString("sort")
+			if err != nil {
+				return fmt.Errorf("failed to get sort flag: %w", err)
+			}
+			opts.Order = wedev.ListOrder(order)
+			if opts.Limit, err = cmd.Flags().GetInt("limit"); err != nil {
+				return fmt.Errorf("failed to get limit flag: %w", err)
+			}
+			if opts.Offset, err = cmd.Flags().GetInt("offset"); err != nil {
+				return fmt.Errorf("failed to get offset flag: %w", err)
+			}
+
+			list, err := app.vnManager.ListNodesPage(cmd.Context(), networkName, opts)
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
+			}
+			servers, err := app.vnManager.ListServersCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list servers: %w", err)
+			}
+
+			matched := make([]nodeListEntry, 0, len(list.Nodes))
+			for _, node := range list.Nodes {
+				matched = append(matched, newNodeListEntry(node, servers))
+			}
+			paged := opts.Limit > 0 || opts.Offset > 0
+
+			switch output {
+			case "json":
+				return printJSON(out, matched)
+			case "yaml":
+				return printYAML(out, matched)
+			}
+
+			noHeader, err := noHeaderFlag(cmd)
+			if err != nil {
+				return err
+			}
+			empty := "No nodes found"
+			if paged && list.Total > 0 {
+				empty = fmt.Sprintf("No nodes at offset %d (%d matching)", opts.Offset, list.Total)
+			}
+
+			rows := make([][]string, 0, len(matched))
+			for _, node := range matched {
+				endpoint := fmt.Sprintf("%s:%d", node.PublicAddress, node.Port)
+				rows = append(rows, []string{node.Name, node.VirtualIP, endpoint, string(node.Type), formatExpiry(node.ExpiresAt), formatLabels(node.Labels)})
+			}
+			if err := printListTable(cmd, out, empty, []string{"Name", "Virtual IP", "Public Address", "Type", "Expires", "Labels"}, rows); err != nil {
+				return err
+			}
+			if paged && len(matched) > 0 && !noHeader {
+				fmt.Fprintf(out, "\nShowing %d-%d of %d nodes\n", opts.Offset+1, opts.Offset+len(matched), list.Total)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("selector", "", "Filter by labels (key=value,key!=value)")
+	cmd.Flags().Bool("expired", false, "List only nodes whose access has expired")
+	cmd.Flags().String("sort", string(wedev.OrderByName), "Sort by name, created, or ip")
+	cmd.Flags().Int("limit", 0, "List at most this many nodes (0 for all)")
+	cmd.Flags().Int("offset", 0, "Skip this many matching nodes")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+	_ = cmd.RegisterFlagCompletionFunc("sort", completeListOrders(wedev.NodeListOrders))
+
+	return cmd
+}
+
+// makeNodeInfoCommand creates the 'node info' command for a specific network
+func makeNodeInfoCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "info <node-name> [--show-secrets] [--output table|json|yaml]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show node information",
+		Long: `Show a node's details, the peers its generated config lists and the
+configs that list it.
+
+The private key is shown as "(redacted)" so the output is safe in shared
+terminals and logs; pass --show-secrets to print it.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			showSecrets, err := cmd.Flags().GetBool("show-secrets")
+			if err != nil {
+				return fmt.Errorf("failed to get show-secrets flag: %w", err)
+			}
+			// Of an encrypted database, only printing the key needs the
+			// passphrase.
+			if showSecrets {
+				if err := app.unlockKeys(cmd); err != nil {
+					return err
+				}
+			}
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			summary, err := app.vnManager.DescribeNodeCtx(cmd.Context(), networkName, args[0])
+			if errors.Is(err, wedev.ErrNotFound) {
+				return withKind(wedev.ErrNotFound, fmt.Errorf("node '%s' not found in network '%s'. Use 'wedevctl vn %s node list' to see available nodes", args[0], networkName, networkName))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get node: %w", err)
+			}
+			network, err := app.vnManager.GetVirtualNetworkCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to get network: %w", err)
+			}
+
+			info := newNodeInfo(summary, showSecrets)
+			switch output {
+			case "json":
+				return printJSON(out, info)
+			case "yaml":
+				return printYAML(out, info)
+			}
+
+			node := summary.Node
+			fmt.Fprintf(out, "Node: %s\n", node.Name)
+			fmt.Fprintf(out, "Type: %s\n", node.Type)
+			fmt.Fprintf(out, "Virtual IP: %s\n", node.VirtualIP)
+			if node.PublicAddress != "" {
+				fmt.Fprintf(out, "Public Address: %s\n", node.EndpointFor(false))
+			} else {
+				fmt.Fprintf(out, "Port: %d\n", node.Port)
+			}
+			if node.InternalAddress != "" {
+				fmt.Fprintf(out, "Internal Endpoint: %s\n", node.EndpointFor(true))
+			}
+			if node.PreferInternal {
+				fmt.Fprintln(out, "Prefer Internal: yes")
+			}
+			if node.EndpointPreference != "" {
+				fmt.Fprintf(out, "Endpoint Preference: %s\n", node.EndpointPreference)
+			}
+			if len(node.RoutedCIDRs) > 0 {
+				fmt.Fprintf(out, "Routes: %s\n", strings.Join(node.RoutedCIDRs, ", "))
+			}
+			if summary.Server != "" {
+				fmt.Fprintf(out, "Server: %s\n", summary.Server)
+			}
+			if node.MeshServers {
+				fmt.Fprintln(out, "Mesh Servers: yes")
+			}
+			if node.FullTunnel {
+				fmt.Fprintln(out, "Full Tunnel: yes")
+			}
+			fmt.Fprintf(out, "Labels: %s\n", formatLabels(node.Labels))
+			fmt.Fprintf(out, "Expires: %s\n", formatExpiry(node.ExpiresAt))
+			fmt.Fprintf(out, "Public Key: %s\n", node.PublicKey)
+			switch {
+			case node.ExternallyManaged():
+				fmt.Fprintln(out, "Private Key: (imported public-only keys; managed outside wedevctl)")
+			case showSecrets:
+				fmt.Fprintf(out, "Private Key: %s\n", node.PrivateKey)
+			default:
+				fmt.Fprintln(out, "Private Key: (redacted)")
+			}
+			fmt.Fprintf(out, "Created At: %s\n", node.CreatedAt.Local().Format(time.RFC3339))
+			fmt.Fprintf(out, "Updated At: %s\n", node.UpdatedAt.Local().Format(time.RFC3339))
+			fmt.Fprintf(out, "ID: %s\n", node.ID)
+
+			fmt.Fprintln(out)
+			switch {
+			case summary.Expired:
+				fmt.Fprintln(out, "The node has expired; it is in no generated config.")
+			case summary.Server == "" && network.NeedsServer():
+				fmt.Fprintln(out, "The network has no server yet; no configs are generated.")
+			default:
+				if node.ExternallyManaged() {
+					fmt.Fprintln(out, "Peers in its config: none (no config is generated for it)")
+				} else {
+					fmt.Fprintf(out, "Peers in its config: %s\n", strings.Join(summary.Peers, ", "))
+				}
+				fmt.Fprintf(out, "Listed in configs of: %s\n", strings.Join(summary.PeerOf, ", "))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("show-secrets", false, "Print the private key instead of redacting it")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// nodeInfo is the 'node info' view of a node: its 'node list' entry with
+// its ID and timestamps, the private key only when asked for, and where the
+// node appears in the generated configs.
+type nodeInfo struct {
+	ID string `json:"id"`
+	nodeListEntry
+	PrivateKey string    `json:"private_key,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Peers      []string  `json:"peers"`
+	PeerOf     []string  `json:"peer_of"`
+}
+
+func newNodeInfo(summary *wedev.NodeSummary, showSecrets bool) nodeInfo {
+	node := summary.Node
+	// The summary already resolved the server and expiry.
+	entry := newNodeListEntry(node, nil)
+	entry.Server = summary.Server
+	entry.Expired = summary.Expired
+	info := nodeInfo{
+		ID:            node.ID,
+		nodeListEntry: entry,
+		CreatedAt:     node.CreatedAt,
+		UpdatedAt:     node.UpdatedAt,
+		Peers:         summary.Peers,
+		PeerOf:        summary.PeerOf,
+	}
+	if showSecrets {
+		info.PrivateKey = node.PrivateKey
+	}
+	return info
+}
+
+// makeNodeExplainCommand creates the 'node explain' command for a specific network
+func makeNodeExplainCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "explain <node-name> [--show-secrets] [--output table|json|yaml]",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Show where each line of a node's config comes from",
+		Long: `Generate a node's config and list every directive in it with the source
+of its value:
+
+  node              the node's own record (key, port, full tunnel)
+  network           a network setting (mtu, keepalive) or the network's DNS
+  built-in default  wedevctl's default for a network setting that is not set
+  derived           computed from the network's CIDR and other records
+  peer              the record of the server or node the [Peer] section is for
+
+The directives are exactly those 'config generate' writes for the node.
+Nothing is written or saved. Private keys are shown as "(redacted)" unless
+--show-secrets is given.
+
+Examples:
+  wedevctl vn production node explain laptop1
+  wedevctl vn production node explain office --output json`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			showSecrets, err := cmd.Flags().GetBool("show-secrets")
+			if err != nil {
+				return fmt.Errorf("failed to get show-secrets flag: %w", err)
+			}
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			config, err := app.generator.GenerateNodeConfigStructuredCtx(cmd.Context(), networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to explain config of node '%s': %w", args[0], err)
+			}
+			if !showSecrets {
+				config = config.Redacted()
+			}
+
+			out := cmd.OutOrStdout()
+			switch output {
+			case "json":
+				return printJSON(out, config)
+			case "yaml":
+				return printYAML(out, config)
+			}
+
+			var rows [][]string
+			for _, section := range config.Sections {
+				label := "[" + section.Kind + "]"
+				if section.Name != "" {
+					label += " " + section.Name
+				}
+				for _, directive := range section.Directives {
+					rows = append(rows, []string{label, directive.Key, directive.Value, string(directive.Source), directive.Reason})
+					label = ""
+				}
+			}
+			printTable(out, []string{"Section", "Directive", "Value", "Source", "Reason"}, rows)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("show-secrets", false, "Print the private key instead of redacting it")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// resolveNodePort returns the port 'node add' creates a node with: the
+// given port, the next free one with --auto-port, or the network default.
+func resolveNodePort(app *App, cmd *cobra.Command, networkName, publicAddress string, port int, portGiven bool) (int, error) {
+	autoPort, err := cmd.Flags().GetBool("auto-port")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get auto-port flag: %w", err)
+	}
+	portRange, err := cmd.Flags().GetString("port-range")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get port-range flag: %w", err)
+	}
+	if autoPort && portGiven {
+		return 0, fmt.Errorf("--auto-port cannot be combined with an explicit port")
+	}
+	if portRange != "" && !autoPort {
+		return 0, fmt.Errorf("--port-range requires --auto-port")
+	}
+
+	network, err := app.vnManager.GetVirtualNetwork(networkName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get network: %w", err)
+	}
+
+	switch {
+	case autoPort:
+		start, end := network.NodePort(), 65535
+		if portRange != "" {
+			if start, end, err = parsePortRange(portRange); err != nil {
+				return 0, err
+			}
+		}
+		if port, err = app.vnManager.NextFreePort(networkName, publicAddress, start, end); err != nil {
+			return 0, err
+		}
+	case port == 0:
+		port = network.NodePort()
+	}
+	return port, nil
+}
+
+// parsePort parses a port argument. Unlike Sscanf it rejects trailing
+// characters, as in "51820abc", and it checks the port is in range.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, withKind(wedev.ErrValidation, fmt.Errorf("invalid port %q: not a number", s))
+	}
+	if err := util.ValidatePort(port); err != nil {
+		return 0, withKind(wedev.ErrValidation, err)
+	}
+	return port, nil
+}
+
+// parsePortRange parses a "start-end" port range.
+func parsePortRange(s string) (int, int, error) {
+	startStr, endStr, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid port range %q (expected start-end)", s)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(startStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	return start, end, nil
+}
+
+// nodeListEntry is the 'node list' view of a node; it leaves out the
+// private key so JSON output is safe to share.
+type nodeListEntry struct {
+	Name               string                   `json:"name"`
+	VirtualIP          string                   `json:"virtual_ip"`
+	PublicAddress      string                   `json:"public_address"`
+	Port               int                      `json:"port"`
+	Type               wedev.NodeType           `json:"type"`
+	PublicKey          string                   `json:"public_key"`
+	RoutedCIDRs        []string                 `json:"routed_cidrs,omitempty"`
+	Labels             map[string]string        `json:"labels,omitempty"`
+	External           bool                     `json:"externally_managed,omitempty"`
+	Server             string                   `json:"server,omitempty"`
+	MeshServers        bool                     `json:"mesh_servers,omitempty"`
+	FullTunnel         bool                     `json:"full_tunnel,omitempty"`
+	InternalAddress    string                   `json:"internal_address,omitempty"`
+	InternalPort       int                      `json:"internal_port,omitempty"`
+	PreferInternal     bool                     `json:"prefer_internal,omitempty"`
+	EndpointPreference wedev.EndpointPreference `json:"endpoint_preference,omitempty"`
+	ExpiresAt          *time.Time               `json:"expires_at,omitempty"`
+	Expired            bool                     `json:"expired,omitempty"`
+}
+
+func newNodeListEntry(node *wedev.Node, servers []*wedev.Server) nodeListEntry {
+	server := ""
+	if home := wedev.NodeServer(node, servers); home != nil {
+		server = home.Name
+	}
+	return nodeListEntry{
+		Name:               node.Name,
+		VirtualIP:          node.VirtualIP,
+		PublicAddress:      node.PublicAddress,
+		Port:               node.Port,
+		Type:               node.Type,
+		PublicKey:          node.PublicKey,
+		RoutedCIDRs:        node.RoutedCIDRs,
+		Labels:             node.Labels,
+		External:           node.ExternallyManaged(),
+		Server:             server,
+		MeshServers:        node.MeshServers,
+		FullTunnel:         node.FullTunnel,
+		InternalAddress:    node.InternalAddress,
+		InternalPort:       node.InternalPort,
+		PreferInternal:     node.PreferInternal,
+		EndpointPreference: node.EndpointPreference,
+		ExpiresAt:          node.ExpiresAt,
+		Expired:            node.Expired(time.Now()),
+	}
+}
+
+// makeNodeEditCommand creates the 'node edit' command for a specific network.
+func makeNodeEditCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit <node-name> [--type <type>] [--public-address <addr>] [--port <port>] [--route-cidr <cidr> | --clear-routes] [--force] [--label key=value] [--remove-label key] [--group <name>] [--server <name>] [--mesh-servers] [--full-tunnel] [--internal-address <addr>] [--internal-port <port>] [--prefer-internal] [--endpoint-preference <pref>] [--expires <date> | --ttl <duration>] [--allow-duplicate-endpoint] [--ignore-conflict]",
+		Short: "Edit node information",
+		Long: `Edit node information including type, public address, port, and labels.
+
+Validation rules:
+  - When changing type to 'peer': public-address is required
+  - When changing type to 'route': public-address is optional
+  - When changing type to 'client': public-address must be cleared
+  - Peer type nodes must always have a public-address
+  - A route node keeps its routed CIDRs only as a route node; clear them
+    with --clear-routes (or --route-cidr "") when changing its type
+  - A peer node the other peer nodes dial directly cannot become a client,
+    or a route node without a public address, unless --force is given: it
+    drops out of their configs
+  - A type change lists the other configs that change with it
+  - A new endpoint another server or node already uses is refused, naming
+    it and suggesting a free port, unless --allow-duplicate-endpoint is
+    given; a public address shared on another port is only a warning
+  - All changes are written at once, and only if no other process changed
+    the node since it was read; re-run the edit, or pass --ignore-conflict
+
+Examples:
+  # Change node type to route (can clear public address)
+  wedevctl vn mynet node edit node1 --type route --public-address ""
+
+  # Change node type to peer (must provide public address)
+  wedevctl vn mynet node edit node1 --type peer --public-address 192.168.1.100
+
+  # Change node type to client (public address cleared)
+  wedevctl vn mynet node edit node1 --type client --public-address ""
+
+  # Turn a route node into a peer, dropping the subnets it exposed
+  wedevctl vn mynet node edit node2 --type peer --public-address 192.168.1.101 --clear-routes
+
+  # Update only port
+  wedevctl vn mynet node edit node1 --port 51821
+
+  # Replace the subnets a route node exposes (empty string clears them)
+  wedevctl vn mynet node edit node2 --route-cidr 192.168.50.0/24 --route-cidr 192.168.60.0/24
+  wedevctl vn mynet node edit node2 --route-cidr ""
+
+  # Set and remove labels
+  wedevctl vn mynet node edit node1 --label role=db --remove-label canary
+
+  # Move a node to another group, or out of every group
+  wedevctl vn mynet node edit node1 --group cloud
+  wedevctl vn mynet node edit node1 --remove-label group
+
+  # Move a node to another server (empty string: the first server)
+  wedevctl vn mynet node edit node1 --server hub2 --mesh-servers=false
+
+  # Send all of a laptop's traffic through the VPN, or only the VPN subnet
+  wedevctl vn mynet node edit laptop --full-tunnel
+  wedevctl vn mynet node edit laptop --full-tunnel=false
+
+  # Nodes in the same datacenter dial each other and the server on the LAN
+  wedevctl vn mynet node edit db1 --internal-address 10.10.0.21 --prefer-internal
+  wedevctl vn mynet node edit db2 --internal-address 10.10.0.22 --prefer-internal
+
+  # Dial the server's second address, or spread over all of them
+  wedevctl vn mynet node edit branch1 --endpoint-preference secondary
+  wedevctl vn mynet node edit branch2 --endpoint-preference round-robin
+
+  # Extend temporary access, or make it permanent
+  wedevctl vn mynet node edit contractor --expires 2024-09-01
+  wedevctl vn mynet node edit contractor --expires ""`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			nodeName := args[0]
+
+			publicAddress, err := cmd.Flags().GetString("public-address")
+			if err != nil {
+				return fmt.Errorf("failed to get public-address flag: %w", err)
+			}
+			port, err := cmd.Flags().GetInt("port")
+			if err != nil {
+				return fmt.Errorf("failed to get port flag: %w", err)
+			}
+			nodeTypeStr, err := cmd.Flags().GetString("type")
+			if err != nil {
+				return fmt.Errorf("failed to get type flag: %w", err)
+			}
+			expiresAt, expiryChanged, err := parseExpiryFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			node, err := app.vnManager.GetNode(networkName, nodeName)
+			if err != nil {
+				return fmt.Errorf("failed to get node: %w", err)
+			}
+
+			// Determine the final node type
+			nodeType := node.Type
+			typeChanged := false
+			if nodeTypeStr != "" {
+				typeChanged = true
+				if nodeType, err = wedev.ParseNodeType(nodeTypeStr); err != nil {
+					return err
+				}
+			}
+
+			// Determine the final public address
+			publicAddressProvided := cmd.Flags().Changed("public-address")
+			if !publicAddressProvided {
+				publicAddress = node.PublicAddress
+			}
+
+			// Validate type and public address combination
+			if typeChanged && nodeType == wedev.NodeTypePeer && publicAddress == "" {
+				return fmt.Errorf("peer type nodes require a public address (use --public-address)")
+			}
+
+			// If already peer type and trying to clear public address
+			if node.Type == wedev.NodeTypePeer && publicAddressProvided && publicAddress == "" && nodeType == wedev.NodeTypePeer {
+				return fmt.Errorf("cannot clear public address for peer type nodes (change type to route first)")
+			}
+			if nodeType == wedev.NodeTypeClient && publicAddress != "" {
+				return fmt.Errorf("client type nodes have no public address (clear it with --public-address \"\")")
+			}
+
+			// Use current port if not specified
+			if port == 0 {
+				port = node.Port
+			}
+			allowDuplicate, err := cmd.Flags().GetBool("allow-duplicate-endpoint")
+			if err != nil {
+				return fmt.Errorf("failed to get allow-duplicate-endpoint flag: %w", err)
+			}
+
+			ignoreConflict, err := cmd.Flags().GetBool("ignore-conflict")
+			if err != nil {
+				return fmt.Errorf("failed to get ignore-conflict flag: %w", err)
+			}
+			edit := wedev.NodeEdit{
+				Revision:               node.Revision,
+				IgnoreConflict:         ignoreConflict,
+				AllowDuplicateEndpoint: allowDuplicate,
+				Type:                   &nodeType,
+				PublicAddress:          &publicAddress,
+				Port:                   &port,
+				ExpiryChanged:          expiryChanged,
+				ExpiresAt:              expiresAt,
+			}
+
+			if edit.Force, err = cmd.Flags().GetBool("force"); err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			if cmd.Flags().Changed("route-cidr") {
+				routeCIDRs, err := cmd.Flags().GetStringSlice("route-cidr")
+				if err != nil {
+					return fmt.Errorf("failed to get route-cidr flag: %w", err)
+				}
+				edit.RoutedCIDRs = &routeCIDRs
+			}
+			clearRoutes, err := cmd.Flags().GetBool("clear-routes")
+			if err != nil {
+				return fmt.Errorf("failed to get clear-routes flag: %w", err)
+			}
+			if clearRoutes {
+				edit.RoutedCIDRs = &[]string{}
+			}
+
+			if edit.SetLabels, edit.RemoveLabels, err = labelEditFlags(cmd); err != nil {
+				return err
+			}
+			if err := groupFlag(cmd, edit.SetLabels); err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed("server") {
+				serverName, err := cmd.Flags().GetString("server")
+				if err != nil {
+					return fmt.Errorf("failed to get server flag: %w", err)
+				}
+				edit.ServerName = &serverName
+			}
+			if edit.MeshServers, err = changedBoolFlag(cmd, "mesh-servers"); err != nil {
+				return err
+			}
+			if edit.FullTunnel, err = changedBoolFlag(cmd, "full-tunnel"); err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed("internal-address") || cmd.Flags().Changed("internal-port") {
+				address, internalPort, err := internalEndpointFlags(cmd, node.InternalAddress, node.InternalPort)
+				if err != nil {
+					return err
+				}
+				edit.InternalAddress, edit.InternalPort = &address, &internalPort
+			}
+			if edit.PreferInternal, err = changedBoolFlag(cmd, "prefer-internal"); err != nil {
+				return err
+			}
+			if edit.EndpointPreference, err = changedEndpointPreference(cmd); err != nil {
+				return err
+			}
+
+			// A type change moves the node in or out of other configs; take
+			// them before and after to list those. A network whose configs
+			// cannot be generated yet has none to list.
+			var before map[string]string
+			if nodeType != node.Type {
+				//nolint:errcheck // Without configs before, none are listed
+				before, _ = app.generator.ConfigSnapshotCtx(cmd.Context(), networkName)
+			}
+
+			updated, err := app.vnManager.EditNode(networkName, nodeName, edit)
+			if err != nil {
+				return fmt.Errorf("failed to update node: %w", err)
+			}
+
+			fmt.Fprintf(out, "Node '%s' updated successfully\n", updated.Name)
+			fmt.Fprintf(out, "Type: %s\n", updated.Type)
+			if updated.PublicAddress != "" {
+				fmt.Fprintf(out, "Public Address: %s:%d\n", updated.PublicAddress, updated.Port)
+			} else {
+				fmt.Fprintf(out, "Public Address: (none)\n")
+			}
+			if len(updated.RoutedCIDRs) > 0 {
+				fmt.Fprintf(out, "Routed CIDRs: %s\n", strings.Join(updated.RoutedCIDRs, ", "))
+			}
+			if len(updated.Labels) > 0 {
+				fmt.Fprintf(out, "Labels: %s\n", formatLabels(updated.Labels))
+			}
+			if updated.MeshServers {
+				fmt.Fprintln(out, "Mesh Servers: yes")
+			}
+			if updated.FullTunnel {
+				fmt.Fprintln(out, "Full Tunnel: yes")
+			}
+			if updated.InternalAddress != "" {
+				fmt.Fprintf(out, "Internal Endpoint: %s\n", updated.EndpointFor(true))
+			}
+			if updated.PreferInternal {
+				fmt.Fprintln(out, "Prefer Internal: yes")
+			}
+			if updated.EndpointPreference != "" {
+				fmt.Fprintf(out, "Endpoint Preference: %s\n", updated.EndpointPreference)
+			}
+			if updated.ExpiresAt != nil {
+				fmt.Fprintf(out, "Expires: %s\n", formatExpiry(updated.ExpiresAt))
+			}
+			if before != nil {
+				after, err := app.generator.ConfigSnapshotCtx(cmd.Context(), networkName)
+				if err != nil {
+					return fmt.Errorf("failed to generate configs: %w", err)
+				}
+				changed := slices.DeleteFunc(wedev.ChangedConfigs(before, after), func(name string) bool { return name == updated.Name })
+				if len(changed) > 0 {
+					fmt.Fprintf(out, "Other configs that change: %s\n", strings.Join(changed, ", "))
+				} else {
+					fmt.Fprintln(out, "Other configs that change: (none)")
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("public-address", "", "Public address or domain (empty string to clear for route type)")
+	cmd.Flags().Int("port", 0, "Port number")
+	cmd.Flags().String("type", "", "Node type (peer, route or client)")
+	cmd.Flags().StringSlice("route-cidr", nil, "LAN subnet behind a route node (repeatable; empty string clears)")
+	cmd.Flags().Bool("clear-routes", false, "Clear the node's routed CIDRs, e.g. when it stops being a route node")
+	cmd.MarkFlagsMutuallyExclusive("route-cidr", "clear-routes")
+	cmd.Flags().Bool("force", false, "Change the type of a peer node even if it drops out of other peers' configs")
+	cmd.Flags().StringArray("label", nil, "Set a label as key=value (repeatable)")
+	cmd.Flags().StringArray("remove-label", nil, "Remove the label with this key (repeatable)")
+	cmd.Flags().String("group", "", "Move the node to this group (sets the \"group\" label)")
+	cmd.Flags().String("server", "", "Server the node peers with (empty string: the network's first server)")
+	cmd.Flags().Bool("mesh-servers", false, "Peer with every server, not only the assigned one")
+	cmd.Flags().Bool("full-tunnel", false, "Route all of the node's traffic through its server")
+	internalEndpointFlagSet(cmd)
+	cmd.Flags().Bool("prefer-internal", false, "Dial the server and peers at their internal endpoints where set")
+	endpointPreferenceFlag(cmd)
+	cmd.Flags().Bool("allow-duplicate-endpoint", false, "Allow a public address and port already used by another node or the server")
+	// --strict refused what is now refused by default.
+	cmd.Flags().Bool("strict", false, "")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.Flags().MarkDeprecated("strict", "a used endpoint is refused unless --allow-duplicate-endpoint is given")
+	ignoreConflictFlag(cmd)
+	expiryFlags(cmd, true)
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.RegisterFlagCompletionFunc("server", completeServerFlag(networkName))
+
+	return cmd
+}
+
+// makeNodeRenameCommand creates the 'node rename' command for a specific network.
+func makeNodeRenameCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rename <old-name> <new-name>",
+		Short: "Rename a node",
+		Long: `Rename a node in the virtual network.
+
+The node keeps its keys and virtual IP, so configs already deployed to its
+peers remain valid.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			oldName := args[0]
+			newName := args[1]
+
+			node, err := app.vnManager.RenameNode(networkName, oldName, newName)
+			if err != nil {
+				return fmt.Errorf("failed to rename node: %w", err)
+			}
+
+			fmt.Fprintf(out, "Node '%s' renamed to '%s'\n", oldName, node.Name)
+			return nil
+		},
+	}
+}
+
+// makeNodeDeleteCommand creates the 'node delete' command for a specific network.
+func makeNodeDeleteCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete [<node-name>...] [--selector <expr>] [--pattern <glob>] [--yes]",
+		Aliases: []string{"rm"},
+		Short:   "Delete one or more nodes",
+		Long: `Delete the named nodes, plus, with --selector or --pattern, the nodes whose
+labels match the selector and whose name matches the glob (both when both are
+given). The nodes to delete are listed first and confirmed once.
+
+Each node is deleted on its own: one that cannot be deleted is reported and
+the rest are still deleted, and the command exits non-zero at the end. The
+virtual IPs of the deleted nodes are released together.
+
+Examples:
+  wedevctl vn mynet node delete laptop
+  wedevctl vn mynet node delete laptop desk printer
+  wedevctl vn mynet node delete --pattern 'loadtest*' --yes
+  wedevctl vn mynet node delete --selector env=test --pattern 'loadtest*'`,
+		ValidArgsFunction: completeNodeNameList(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			selectorExpr, err := cmd.Flags().GetString("selector")
+			if err != nil {
+				return fmt.Errorf("failed to get selector flag: %w", err)
+			}
+			selector, err := util.ParseLabelSelector(selectorExpr)
+			if err != nil {
+				return withKind(wedev.ErrValidation, err)
+			}
+			pattern, err := cmd.Flags().GetString("pattern")
+			if err != nil {
+				return fmt.Errorf("failed to get pattern flag: %w", err)
+			}
+			yes, err := cmd.Flags().GetBool("yes")
+			if err != nil {
+				return fmt.Errorf("failed to get yes flag: %w", err)
+			}
+			if len(args) == 0 && len(selector) == 0 && pattern == "" {
+				return fmt.Errorf("give the nodes to delete by name, --selector or --pattern")
+			}
+
+			nodes, err := app.vnManager.SelectNodes(networkName, wedev.NodeSelection{Names: args, Selector: selector, Pattern: pattern})
+			if err != nil {
+				return fmt.Errorf("failed to delete node: %w", err)
+			}
+			if len(nodes) == 0 {
+				fmt.Fprintln(out, "No matching nodes")
+				return nil
+			}
+
+			names := make([]string, 0, len(nodes))
+			for _, node := range nodes {
+				names = append(names, node.Name)
+			}
+			prompt := fmt.Sprintf("Delete node '%s'?", names[0])
+			if len(nodes) > 1 {
+				fmt.Fprintf(out, "%d nodes will be deleted:\n", len(nodes))
+				for _, node := range nodes {
+					fmt.Fprintf(out, "  %s (%s)\n", node.Name, node.VirtualIP)
+				}
+				prompt = fmt.Sprintf("Delete %d nodes?", len(nodes))
+			}
+			if !yes && !confirmAction(cmd, prompt, assumeFor(app, promptDelete)) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+
+			result, err := app.vnManager.DeleteNodes(networkName, names)
+			if err != nil && result == nil {
+				return fmt.Errorf("failed to delete node: %w", err)
+			}
+			if len(nodes) == 1 && len(result.Deleted) == 1 && err == nil {
+				fmt.Fprintln(out, "Node deleted successfully")
+				return nil
+			}
+			for _, node := range result.Deleted {
+				fmt.Fprintf(out, "Deleted node '%s'\n", node.Name)
+			}
+			for _, failure := range result.Failed {
+				fmt.Fprintf(out, "Failed to delete node '%s': %v\n", failure.Name, failure.Err)
+			}
+			fmt.Fprintf(out, "%d deleted, %d failed\n", len(result.Deleted), len(result.Failed))
+			if err != nil {
+				return err
+			}
+			if len(result.Failed) > 0 {
+				if len(nodes) == 1 {
+					return fmt.Errorf("failed to delete node: %w", result.Failed[0].Err)
+				}
+				return fmt.Errorf("failed to delete %d of %d nodes", len(result.Failed), len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("selector", "", "Delete the nodes matching these labels (key=value,key!=value)")
+	cmd.Flags().String("pattern", "", "Delete the nodes whose name matches this glob (e.g. 'loadtest*')")
+	cmd.Flags().BoolP("yes", "y", false, "Skip the confirmation prompt")
+
+	return cmd
+}
+
+// makeNodeBundleCommand creates the 'node bundle' command for a specific
+// network.
+func makeNodeBundleCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "bundle <node-name> --file <file.tar.gz|file.zip> [--interface <name>] [--force]",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Package a node's config with an install script for a new machine",
+		Long: fmt.Sprintf(`Write everything a new machine needs to join network '%s' as one node
+into a .tar.gz (or .tgz) or .zip file, in a directory named after the node:
+
+  <interface>.conf  the node's config, holding its own private key only
+  install.sh        a POSIX sh script that checks the config's checksum,
+                    copies it to /etc/wireguard and enables wg-quick@<interface>
+  metadata.json     the network, node, interface, wedevctl version and the
+                    config's SHA-256
+
+The interface name defaults to the network name. On the new machine, run
+'sudo sh <node>/install.sh' after extracting the bundle, or, where wedevctl is
+installed, 'sudo wedevctl join <bundle>'. Nothing is saved; run 'config
+generate' as usual to version the network's configs.
+
+Examples:
+  wedevctl vn %[1]s node bundle laptop --file laptop.tar.gz
+  wedevctl vn %[1]s node bundle laptop --file laptop.zip --interface wg0`, networkName),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return fmt.Errorf("failed to get file flag: %w", err)
+			}
+			iface, err := cmd.Flags().GetString("interface")
+			if err != nil {
+				return fmt.Errorf("failed to get interface flag: %w", err)
+			}
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			if _, err := wedev.ArchiveFormatFor(file); err != nil {
+				return err
+			}
+
+			bundle, err := app.generator.BuildNodeBundle(cmd.Context(), networkName, args[0], iface)
+			if err != nil {
+				return fmt.Errorf("failed to build bundle: %w", err)
+			}
+			if !confirmArchiveOverwrite(app, cmd, file, force) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+			if err := wedev.WriteNodeBundle(file, bundle); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "Bundled node '%s' as interface '%s' to %s\n", args[0], bundle.Metadata.Interface, file)
+			fmt.Fprintf(out, "Config SHA-256: %s\n", bundle.Metadata.SHA256)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("file", "", "Bundle to write: a .tar.gz, .tgz or .zip file")
+	cmd.Flags().String("interface", "", "WireGuard interface name on the new machine (default: network name)")
+	cmd.Flags().Bool("force", false, "Overwrite an existing bundle without asking")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+// makeNodePurgeExpiredCommand creates the 'node purge-expired' command for a
+// specific network.
+func makeNodePurgeExpiredCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "purge-expired",
+		Short: "Delete nodes whose access has expired",
+		Long: `Delete every node whose access has expired (see 'node add --expires'),
+releasing their virtual IPs. Expired nodes are already left out of generated
+configs; purging removes their records. Run 'config generate' afterwards to
+save a version without them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			expired, err := app.vnManager.ListExpiredNodes(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list expired nodes: %w", err)
+			}
+			if len(expired) == 0 {
+				fmt.Fprintln(out, "No expired nodes")
+				return nil
+			}
+
+			names := make([]string, 0, len(expired))
+			for _, node := range expired {
+				names = append(names, node.Name)
+			}
+			if !confirmAction(cmd, fmt.Sprintf("Delete %d expired node(s): %s?", len(names), strings.Join(names, ", ")), assumeFor(app, promptDelete)) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+
+			purged, err := app.vnManager.PurgeExpiredNodes(networkName)
+			for _, node := range purged {
+				fmt.Fprintf(out, "Deleted node '%s' (expired %s)\n", node.Name, node.ExpiresAt.Local().Format("2006-01-02 15:04"))
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "Purged %d expired node(s)\n", len(purged))
+			return nil
+		},
+	}
+}
+
+// ========== Config Commands ==========
+
+// makeNodeHistoryCommand creates the 'node history' command for a specific network
+func makeNodeHistoryCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "history <node-name> [--addresses] [--output table|json|yaml]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show a node's change history",
+		Long: `Show the recent edits, renames and key rotations of a node, oldest
+first, with the fields each changed. Private keys are shown as "(redacted)".
+
+--addresses lists the public addresses the node had instead, each with the
+time it was replaced, and the current one.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeNodeNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			addresses, err := cmd.Flags().GetBool("addresses")
+			if err != nil {
+				return fmt.Errorf("failed to get addresses flag: %w", err)
+			}
+			if addresses {
+				node, err := app.vnManager.GetNode(networkName, args[0])
+				if err != nil {
+					return fmt.Errorf("failed to get node: %w", err)
+				}
+				return printAddressHistory(cmd.OutOrStdout(), output, node.PublicAddress, &node.EndpointHistory)
+			}
+			history, err := app.vnManager.NodeHistory(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get node history: %w", err)
+			}
+			return printEntityHistory(cmd.OutOrStdout(), output, history)
+		},
+	}
+
+	cmd.Flags().Bool("addresses", false, "List the node's previous public addresses")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// printEntityHistory prints the revisions of a server or node in the given
+// output format; the table has a row per changed field.
+func printEntityHistory(out io.Writer, output string, history []wedev.EntityRevision) error {
+	switch output {
+	case "json":
+		return printJSON(out, history)
+	case "yaml":
+		return printYAML(out, history)
+	}
+
+	if len(history) == 0 {
+		fmt.Fprintln(out, "No changes recorded")
+		return nil
+	}
+
+	var rows [][]string
+	for _, rev := range history {
+		at, action := rev.At.Format("2006-01-02 15:04:05"), rev.Action
+		for _, change := range rev.Changes {
+			rows = append(rows, []string{at, action, change.Field, historyValue(change.Old) + " -> " + historyValue(change.New)})
+			at, action = "", ""
+		}
+	}
+	printTable(out, []string{"Time", "Action", "Field", "Change"}, rows)
+	return nil
+}
+
+// addressHistoryOutput is 'history --addresses' in JSON and YAML.
+type addressHistoryOutput struct {
+	Current  string                `json:"current"`
+	Previous []wedev.AddressChange `json:"previous"`
+}
+
+// printAddressHistory prints the previous public addresses of a server or
+// node, oldest first, then the current one.
+func printAddressHistory(out io.Writer, output, current string, history *wedev.EndpointHistory) error {
+	previous := history.AddressHistory
+	if previous == nil {
+		previous = []wedev.AddressChange{}
+	}
+	switch output {
+	case "json":
+		return printJSON(out, addressHistoryOutput{Current: current, Previous: previous})
+	case "yaml":
+		return printYAML(out, addressHistoryOutput{Current: current, Previous: previous})
+	}
+
+	rows := make([][]string, 0, len(previous)+1)
+	for _, change := range previous {
+		rows = append(rows, []string{historyValue(change.Address), change.Until.Local().Format("2006-01-02 15:04:05")})
+	}
+	rows = append(rows, []string{historyValue(current), "(current)"})
+	printTable(out, []string{"Address", "Until"}, rows)
+	return nil
+}
+
+// historyValue shows an empty field value as "(none)".
+func historyValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// makeConfigCommand creates the 'config' command group for a specific network
+func makeConfigCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage WireGuard configurations",
+		Long:  fmt.Sprintf("Manage WireGuard configurations for virtual network '%s'", networkName),
+	}
+
+	cmd.AddCommand(makeConfigGenerateCommand(app, networkName))
+	cmd.AddCommand(makeConfigShowCommand(app, networkName))
+	cmd.AddCommand(makeConfigInfoCommand(app, networkName))
+	cmd.AddCommand(makeConfigHistoryCommand(app, networkName))
+	cmd.AddCommand(makeConfigTagCommand(app, networkName))
+	cmd.AddCommand(makeConfigUntagCommand(app, networkName))
+	cmd.AddCommand(makeConfigFreezeCommand(app, networkName))
+	cmd.AddCommand(makeConfigUnfreezeCommand(app, networkName))
+	cmd.AddCommand(makeConfigStaleCommand(app, networkName))
+	cmd.AddCommand(makeConfigApplyCommand(app, networkName))
+	cmd.AddCommand(makeConfigDeployCommand(app, networkName))
+	cmd.AddCommand(makeConfigExportCommand(app, networkName))
+	cmd.AddCommand(makeConfigVerifyCommand(app, networkName))
+	cmd.AddCommand(makeConfigWatchCommand(app, networkName))
+
+	return cmd
+}
+
+// makeConfigGenerateCommand creates the 'config generate' command for a specific network
+func makeConfigGenerateCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "generate [--only <name> | --selector <expr>] [--filename-template <template>] [--archive <file.tar.gz|file.zip> [--per-entity] | --stdout [--format text|tar] [--no-save] | --check [--ignore-extra] | --systemd [--restart-on-failure] | --netdev] [--config-format wg-quick|wg]",
+		Aliases:     []string{"gen"},
+		Annotations: withKeys(nil),
+		Short:       "Generate WireGuard configuration files",
+		Long: `Generate WireGuard configuration files and save them as a new version.
+
+Files are named <entity>.conf unless --filename-template (or the network's
+template, see 'vn edit --filename-template') gives a Go template using
+.Network, .Entity, and .Type ("server", "peer", or "route"), for example
+'wg-{{.Entity}}.conf'. wg-quick names the interface after the file, so names
+that are not valid interface names (at most 15 characters) are warned about.
+
+With --only, only the named server or node configs are written to disk. The
+full config set is still generated and versioned, so the saved version matches
+what every entity should run. --selector does the same for the nodes whose
+labels match, with comma-separated key=value and key!=value terms; select a
+node group with --selector group=<name>.
+
+With --dry-run the configs are generated in memory and compared to the latest
+saved version; the per-file diff is printed and nothing is written or saved.
+
+With --check the configs are generated in memory and compared with the files
+in the output directory, byte for byte but for the generation time in the
+header, for CI jobs that fail when regenerated configs were not committed.
+Each file is listed as match, differs, missing, or extra (a .conf file no
+entity generates), and the command fails unless all match; --ignore-extra
+leaves extra files out. Nothing is written or saved. Give the same
+--filename-template and --no-comments the files were written with.
+
+A saved version records --message, a summary of what changed since the
+previous version (for example "nodes: +node5, ~node1"), and the OS user who
+saved it; see 'config history'.
+
+Each file starts with a "# network: ..." header and names every [Peer]
+section's server or node in a comment; --no-comments writes them without.
+Comments never count as changes, so the saved version is the same either way.
+
+With --archive the configs are packaged into one .tar.gz (or .tgz) or .zip
+file instead of loose files, with a README naming the version and content
+hash. --per-entity puts each config in a directory named after its server or
+node. The version is saved before the archive is written, so the README can
+name it.
+
+With --systemd each <interface>.conf gets a wireguard-<interface>.service
+unit running wg-quick, for hosts without wg-quick@.service; with
+--restart-on-failure also a drop-in, <unit>.d/restart.conf, restarting it when
+bringing the interface up fails. With --netdev each config is written as
+systemd-networkd <interface>.netdev and <interface>.network files instead of a
+wg-quick config. PostUp and PostDown commands other than enabling forwarding
+have no networkd equivalent; they are warned about and listed in the .network
+file. Configs routing all traffic through the tunnel (AllowedIPs 0.0.0.0/0)
+cannot be translated.
+
+With --config-format wg the configs are written for 'wg setconf', which
+rejects the [Interface] keys wg-quick adds: only PrivateKey, ListenPort and
+FwMark are kept. Each <name>.conf gets a <name>.addresses file listing its
+Address, DNS and MTU values, and any PostUp or PostDown commands, for setting
+up the interface by other means. Versions are saved from the wg-quick
+configs either way, so switching formats does not save a new version.
+
+With --stdout nothing is written to disk: the configs go to standard output,
+concatenated with a "# --- <file> ---" line before each, or with --format tar
+as a tarball to extract elsewhere, for example:
+
+  wedevctl vn mynet config generate --stdout --format tar --only hub | ssh hub 'tar -x -C /etc/wireguard'
+
+The version is still saved unless --no-save is given; messages go to
+standard error so the stream stays intact.
+
+While the config is frozen (see 'config freeze') the latest saved version is
+written instead, whatever changed since, and no version is saved; --check
+compares against it too. --force generates and saves a new version anyway,
+with a warning and "freeze overridden" recorded in its message.
+
+The configs hold private keys. Files are written readable by their owner
+only, and an output directory readable by its group or others is warned
+about unless --no-perm-check is given. Each file is written to a temporary
+file and renamed into place once all are written, and the version is saved
+only after every file was written and read back intact.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			outputDir, err := cmd.Flags().GetString("output-dir")
+			if err != nil {
+				return fmt.Errorf("failed to get output-dir flag: %w", err)
+			}
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return fmt.Errorf("failed to get dry-run flag: %w", err)
+			}
+			only, err := cmd.Flags().GetStringArray("only")
+			if err != nil {
+				return fmt.Errorf("failed to get only flag: %w", err)
+			}
+			selectorExpr, err := cmd.Flags().GetString("selector")
+			if err != nil {
+				return fmt.Errorf("failed to get selector flag: %w", err)
+			}
+			selector, err := util.ParseLabelSelector(selectorExpr)
+			if err != nil {
+				return withKind(wedev.ErrValidation, err)
+			}
+			filenameTemplate, err := cmd.Flags().GetString("filename-template")
+			if err != nil {
+				return fmt.Errorf("failed to get filename-template flag: %w", err)
+			}
+			message, err := cmd.Flags().GetString("message")
+			if err != nil {
+				return fmt.Errorf("failed to get message flag: %w", err)
+			}
+			noComments, err := cmd.Flags().GetBool("no-comments")
+			if err != nil {
+				return fmt.Errorf("failed to get no-comments flag: %w", err)
+			}
+			archive, err := cmd.Flags().GetString("archive")
+			if err != nil {
+				return fmt.Errorf("failed to get archive flag: %w", err)
+			}
+			perEntity, err := cmd.Flags().GetBool("per-entity")
+			if err != nil {
+				return fmt.Errorf("failed to get per-entity flag: %w", err)
+			}
+			toStdout, err := cmd.Flags().GetBool("stdout")
+			if err != nil {
+				return fmt.Errorf("failed to get stdout flag: %w", err)
+			}
+			formatFlag, err := cmd.Flags().GetString("format")
+			if err != nil {
+				return fmt.Errorf("failed to get format flag: %w", err)
+			}
+			streamFormat, err := wedev.ParseStreamFormat(formatFlag)
+			if err != nil {
+				return err
+			}
+			noSave, err := cmd.Flags().GetBool("no-save")
+			if err != nil {
+				return fmt.Errorf("failed to get no-save flag: %w", err)
+			}
+			noPermCheck, err := cmd.Flags().GetBool("no-perm-check")
+			if err != nil {
+				return fmt.Errorf("failed to get no-perm-check flag: %w", err)
+			}
+			check, err := cmd.Flags().GetBool("check")
+			if err != nil {
+				return fmt.Errorf("failed to get check flag: %w", err)
+			}
+			ignoreExtra, err := cmd.Flags().GetBool("ignore-extra")
+			if err != nil {
+				return fmt.Errorf("failed to get ignore-extra flag: %w", err)
+			}
+			systemd, err := cmd.Flags().GetBool("systemd")
+			if err != nil {
+				return fmt.Errorf("failed to get systemd flag: %w", err)
+			}
+			restartOnFailure, err := cmd.Flags().GetBool("restart-on-failure")
+			if err != nil {
+				return fmt.Errorf("failed to get restart-on-failure flag: %w", err)
+			}
+			netdev, err := cmd.Flags().GetBool("netdev")
+			if err != nil {
+				return fmt.Errorf("failed to get netdev flag: %w", err)
+			}
+			configFormatFlag, err := cmd.Flags().GetString("config-format")
+			if err != nil {
+				return fmt.Errorf("failed to get config-format flag: %w", err)
+			}
+			configFormat, err := wedev.ParseConfigFormat(configFormatFlag)
+			if err != nil {
+				return err
+			}
+			if configFormat == wedev.ConfigWG && (systemd || netdev || dryRun) {
+				return fmt.Errorf("--config-format wg cannot be combined with --systemd, --netdev or --dry-run, which work on wg-quick configs")
+			}
+			if restartOnFailure && !systemd {
+				return fmt.Errorf("--restart-on-failure requires --systemd")
+			}
+			if (systemd || netdev) && (archive != "" || toStdout || check || dryRun) {
+				return fmt.Errorf("--systemd and --netdev write files to --output-dir; they cannot be combined with --archive, --stdout, --check or --dry-run")
+			}
+			if !toStdout && (cmd.Flags().Changed("format") || noSave) {
+				return fmt.Errorf("--format and --no-save require --stdout")
+			}
+			if ignoreExtra && !check {
+				return fmt.Errorf("--ignore-extra requires --check")
+			}
+			if check && (len(only) > 0 || len(selector) > 0) {
+				return fmt.Errorf("--only and --selector cannot be combined with --check")
+			}
+			if archive != "" {
+				if _, err := wedev.ArchiveFormatFor(archive); err != nil {
+					return err
+				}
+				if outputDir != "" || dryRun {
+					return fmt.Errorf("--archive cannot be combined with --output-dir or --dry-run")
+				}
+			} else if perEntity {
+				return fmt.Errorf("--per-entity requires --archive")
+			}
+
+			if dryRun {
+				if len(only) > 0 || len(selector) > 0 {
+					return fmt.Errorf("--only and --selector cannot be combined with --dry-run")
+				}
+				preview, err := app.generator.PreviewConfigsCtx(cmd.Context(), networkName)
+				if err != nil {
+					return fmt.Errorf("failed to generate configs: %w", err)
+				}
+				printConfigPreview(out, preview)
+				return nil
+			}
+			// A frozen config is written from the latest saved version and
+			// no version is saved, unless --force overrides the freeze.
+			saves := !check && !(toStdout && noSave)
+			pinned, err := frozenConfigVersion(cmd, app, networkName, force && saves)
+			if err != nil {
+				return err
+			}
+			if !check && !noSave && pinned == nil {
+				if err := releaseExpiredGuests(app, cmd.ErrOrStderr(), networkName); err != nil {
+					return err
+				}
+			}
+
+			generator := app.generator
+			var configs map[string]string
+			if pinned != nil {
+				configs = maps.Clone(pinned.Configs)
+			} else if configs, _, err = generator.GenerateConfigsCtx(cmd.Context(), networkName, app.storage); err != nil {
+				return fmt.Errorf("failed to generate configs: %w", err)
+			}
+			if len(only) > 0 {
+				if configs, err = generator.SelectConfigs(networkName, configs, only); err != nil {
+					return err
+				}
+			}
+			if len(selector) > 0 {
+				if configs, err = generator.SelectConfigsMatching(cmd.Context(), networkName, configs, selector); err != nil {
+					return err
+				}
+			}
+			filenames, err := generator.ConfigFilenames(networkName, filenameTemplate)
+			if err != nil {
+				return err
+			}
+			for name := range configs {
+				// Entities deleted since the pinned version was saved
+				if filenames[name] == "" {
+					filenames[name] = name + ".conf"
+				}
+			}
+			if noComments {
+				for name, config := range configs {
+					configs[name] = wedev.StripComments(config)
+				}
+			}
+			// Versions are saved from the wg-quick configs whatever is
+			// written, so switching formats never makes a new version.
+			var addresses map[string]string
+			if configFormat == wedev.ConfigWG {
+				if addresses, err = translateSetconf(cmd, configs, filenames); err != nil {
+					return err
+				}
+			}
+
+			saveVersion := func() (*wedev.ConfigVersion, bool, error) {
+				return generator.SaveConfigVersionWithOptionsCtx(cmd.Context(), networkName, wedev.ConfigSaveOptions{Message: message, OverrideFreeze: force})
+			}
+
+			if toStdout {
+				return writeConfigStream(cmd, saveVersion, networkName, configs, filenames, addresses, streamFormat, noSave || pinned != nil)
+			}
+
+			if check {
+				if outputDir == "" {
+					if outputDir, err = os.Getwd(); err != nil {
+						return fmt.Errorf("failed to get current directory: %w", err)
+					}
+				}
+				report, err := wedev.CheckConfigDir(outputDir, configs, filenames)
+				if err != nil {
+					return err
+				}
+				return printConfigCheck(out, report, ignoreExtra)
+			}
+
+			if archive != "" {
+				if !confirmArchiveOverwrite(app, cmd, archive, force) {
+					fmt.Fprintln(out, "Cancelled")
+					return nil
+				}
+				version, created := pinned, false
+				if pinned == nil {
+					if version, created, err = saveVersion(); err != nil {
+						return fmt.Errorf("failed to save config version: %w", err)
+					}
+				}
+				if err := writeConfigArchive(archive, networkName, version, configs, filenames, addresses, perEntity); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Archived %d config(s) to %s\n", len(configs), archive)
+				printSavedVersion(out, version, created, pinned != nil)
+				return nil
+			}
+
+			if outputDir == "" {
+				var getWdErr error
+				outputDir, getWdErr = os.Getwd()
+				if getWdErr != nil {
+					return fmt.Errorf("failed to get current directory: %w", getWdErr)
+				}
+			}
+
+			// Ensure directory exists
+			mkdirErr := os.MkdirAll(outputDir, 0o700)
+			if mkdirErr != nil {
+				return fmt.Errorf("failed to create output directory: %w", mkdirErr)
+			}
+			if !noPermCheck {
+				warnOutputDirPerms(cmd.ErrOrStderr(), outputDir)
+			}
+
+			files, skipped, err := configOutputFiles(networkName, configs, filenames, configFileStyle{systemd: systemd, restartOnFailure: restartOnFailure, netdev: netdev, addresses: addresses})
+			if err != nil {
+				return err
+			}
+
+			// Check for existing files
+			var existingFiles []string
+			for _, file := range files {
+				filePath := filepath.Join(outputDir, file.name)
+				if _, statErr := os.Stat(filePath); statErr == nil {
+					existingFiles = append(existingFiles, filePath)
+				}
+			}
+
+			// Ask for overwrite confirmation
+			if len(existingFiles) > 0 && !force {
+				fmt.Fprintln(out, "The following files already exist:")
+				for _, f := range existingFiles {
+					fmt.Fprintf(out, "  %s\n", f)
+				}
+				if !confirmAction(cmd, "Overwrite existing files?", assumeFor(app, promptOverwrite)) {
+					fmt.Fprintln(out, "Cancelled")
+					return nil
+				}
+			}
+
+			// Write files. The version is saved only once every file is in
+			// place, so an interrupted run is redone in full next time.
+			if err := writeOutputFiles(outputDir, files); err != nil {
+				return err
+			}
+			for _, file := range files {
+				fmt.Fprintf(out, "Generated: %s\n", filepath.Join(outputDir, file.name))
+			}
+			if mismatched := verifyOutputFiles(outputDir, files); len(mismatched) > 0 {
+				return fmt.Errorf("written files do not read back as generated: %s; no version saved", strings.Join(mismatched, ", "))
+			}
+			for _, command := range skipped {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: systemd-networkd does not run %s; it is listed in the .network file\n", command)
+			}
+			printSystemdInstructions(out, systemd, netdev)
+
+			// Save version
+			if pinned != nil {
+				printSavedVersion(out, pinned, false, true)
+				return nil
+			}
+			version, created, err := saveVersion()
+			if err != nil {
+				return fmt.Errorf("failed to save config version: %w", err)
+			}
+			printSavedVersion(out, version, created, false)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("output-dir", "", "Output directory (default: current directory)")
+	cmd.Flags().Bool("force", false, "Skip all interactive confirmations, and save a new version of a frozen config")
+	cmd.Flags().Bool("dry-run", false, "Show the diff against the latest version without writing files or saving")
+	cmd.Flags().StringArray("only", nil, "Write only this server or node's config (repeatable)")
+	cmd.Flags().String("selector", "", "Write only the configs of nodes matching these labels (key=value,key!=value)")
+	cmd.MarkFlagsMutuallyExclusive("only", "selector")
+	cmd.Flags().String("filename-template", "", "Go template for config file names (default: the network's template, or {{.Entity}}.conf)")
+	cmd.Flags().StringP("message", "m", "", "Why this version is being saved (recorded in config history)")
+	cmd.Flags().Bool("no-comments", false, "Write configs without the header and peer name comments")
+	cmd.Flags().String("archive", "", "Write the configs into this .tar.gz, .tgz or .zip file instead of loose files")
+	cmd.Flags().Bool("per-entity", false, "Put each config in a directory named after its entity (with --archive)")
+	cmd.Flags().Bool("stdout", false, "Write the configs to standard output instead of files")
+	cmd.Flags().String("format", string(wedev.StreamText), "Format of --stdout: text or tar")
+	cmd.Flags().Bool("no-save", false, "Do not save a config version (with --stdout)")
+	cmd.Flags().Bool("no-perm-check", false, "Do not warn about an output directory readable by group or others")
+	cmd.Flags().Bool("check", false, "Compare the configs with the files in the output directory instead of writing them")
+	cmd.Flags().Bool("ignore-extra", false, "Do not report .conf files no entity generates (with --check)")
+	cmd.Flags().Bool("systemd", false, "Also write a systemd service unit bringing up each config with wg-quick")
+	cmd.Flags().Bool("restart-on-failure", false, "Also write a drop-in restarting each unit when it fails (with --systemd)")
+	cmd.Flags().Bool("netdev", false, "Write systemd-networkd .netdev and .network files instead of wg-quick configs")
+	cmd.Flags().String("config-format", string(wedev.ConfigWGQuick), "Config dialect: wg-quick, or wg for 'wg setconf' with a .addresses file per config")
+	cmd.MarkFlagsMutuallyExclusive("systemd", "netdev")
+	cmd.MarkFlagsMutuallyExclusive("stdout", "output-dir")
+	cmd.MarkFlagsMutuallyExclusive("check", "dry-run")
+	cmd.MarkFlagsMutuallyExclusive("check", "stdout")
+	cmd.MarkFlagsMutuallyExclusive("check", "archive")
+	cmd.MarkFlagsMutuallyExclusive("stdout", "archive")
+	cmd.MarkFlagsMutuallyExclusive("stdout", "dry-run")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.RegisterFlagCompletionFunc("only", completeEntityNames(networkName))
+
+	return cmd
+}
+
+// configFileStyle selects the files 'config generate' writes for each
+// config besides, or instead of, the config itself.
+type configFileStyle struct {
+	systemd          bool // a service unit next to each config
+	restartOnFailure bool // and a drop-in restarting it on failure
+	netdev           bool // systemd-networkd files instead of the config
+	// addresses are the interface settings of 'wg setconf' configs by
+	// entity, each written next to its config.
+	addresses map[string]string
+}
+
+// outputFile is a file 'config generate' writes, named relative to the
+// output directory.
+type outputFile struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+// configOutputFiles lists the files written for configs in style, sorted by
+// name, and the wg-quick commands the systemd-networkd files leave out.
+// Files holding a private key are readable by their owner only.
+func configOutputFiles(networkName string, configs, filenames map[string]string, style configFileStyle) ([]outputFile, []string, error) {
+	var files []outputFile
+	var skipped []string
+	for name, config := range configs {
+		filename := filenames[name]
+		iface, valid := wedev.InterfaceName(filename)
+		if (style.systemd || style.netdev) && !valid {
+			return nil, nil, withKind(wedev.ErrValidation, fmt.Errorf("config file name %q of %s is not a valid interface name (at most 15 letters, digits, or _=+.- followed by .conf); pick another --filename-template", filename, name))
+		}
+
+		if !style.netdev {
+			files = append(files, outputFile{name: filename, content: config, mode: 0o600})
+		}
+		if addresses, ok := style.addresses[name]; ok {
+			files = append(files, outputFile{name: wedev.AddressesFilename(filename), content: addresses, mode: 0o644})
+		}
+		if style.systemd {
+			unit, err := wedev.SystemdUnit(networkName, name, iface)
+			if err != nil {
+				return nil, nil, err
+			}
+			unitName := wedev.SystemdUnitName(iface)
+			files = append(files, outputFile{name: unitName, content: unit, mode: 0o644})
+			if style.restartOnFailure {
+				files = append(files, outputFile{name: filepath.Join(unitName+".d", "restart.conf"), content: wedev.SystemdRestartDropIn, mode: 0o644})
+			}
+		}
+		if style.netdev {
+			networkd, err := wedev.TranslateNetworkd(config, iface)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to translate the config of %s for systemd-networkd: %w", name, err)
+			}
+			files = append(files,
+				outputFile{name: iface + ".netdev", content: networkd.NetDev, mode: 0o600},
+				outputFile{name: iface + ".network", content: networkd.Network, mode: 0o644})
+			for _, command := range networkd.Skipped {
+				skipped = append(skipped, name+": "+command)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	sort.Strings(skipped)
+	return files, skipped, nil
+}
+
+// writeOutputFiles writes files below dir. Each is written to a temporary
+// file next to it first; only when all of them are written are they renamed
+// into place, so a failed or interrupted run never leaves a file half
+// written. The temporary files are removed on failure.
+func writeOutputFiles(dir string, files []outputFile) (err error) {
+	staged := make([]string, 0, len(files))
+	defer func() {
+		if err != nil {
+			for _, tmp := range staged {
+				//nolint:errcheck // Removing a renamed temp file is a harmless no-op
+				_ = os.Remove(tmp)
+			}
+		}
+	}()
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		if mkdirErr := os.MkdirAll(filepath.Dir(path), 0o700); mkdirErr != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, mkdirErr)
+		}
+		tmp, createErr := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+		if createErr != nil {
+			return fmt.Errorf("failed to write config file %s: %w", path, createErr)
+		}
+		staged = append(staged, tmp.Name())
+		_, writeErr := tmp.WriteString(file.content)
+		if writeErr == nil {
+			writeErr = tmp.Chmod(file.mode)
+		}
+		if writeErr == nil {
+			writeErr = tmp.Sync()
+		}
+		if closeErr := tmp.Close(); writeErr == nil {
+			writeErr = closeErr
+		}
+		if writeErr != nil {
+			return fmt.Errorf("failed to write config file %s: %w", path, writeErr)
+		}
+	}
+
+	for i, file := range files {
+		path := filepath.Join(dir, file.name)
+		if renameErr := os.Rename(staged[i], path); renameErr != nil {
+			return fmt.Errorf("failed to write config file %s: %w", path, renameErr)
+		}
+	}
+	return nil
+}
+
+// verifyOutputFiles reads back the files writeOutputFiles wrote below dir
+// and returns the paths of those whose content is not what was written.
+func verifyOutputFiles(dir string, files []outputFile) []string {
+	var mismatched []string
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		data, err := os.ReadFile(path) // #nosec G304 -- written by this run just before
+		if err != nil || string(data) != file.content {
+			mismatched = append(mismatched, path)
+		}
+	}
+	return mismatched
+}
+
+// printSystemdInstructions tells where the files written by --systemd or
+// --netdev go on each host.
+func printSystemdInstructions(w io.Writer, systemd, netdev bool) {
+	switch {
+	case systemd:
+		fmt.Fprintln(w, "\nOn each host, install <interface>.conf in /etc/wireguard and wireguard-<interface>.service")
+		fmt.Fprintln(w, "(with its .d directory) in /etc/systemd/system, then run 'systemctl enable --now wireguard-<interface>'.")
+	case netdev:
+		fmt.Fprintln(w, "\nOn each host, install <interface>.netdev and <interface>.network in /etc/systemd/network, the")
+		fmt.Fprintln(w, ".netdev owned by root:systemd-network with mode 0640, then run 'networkctl reload'.")
+	}
+}
+
+// printConfigCheck prints a config check report, leaving out extra files when
+// ignoreExtra is set, and fails unless the directory matches.
+func printConfigCheck(w io.Writer, report *wedev.ConfigCheckReport, ignoreExtra bool) error {
+	rows := make([][]string, 0, len(report.Files))
+	for _, f := range report.Files {
+		if ignoreExtra && f.Status == wedev.CheckExtra {
+			continue
+		}
+		entity := f.Entity
+		if entity == "" {
+			entity = "-"
+		}
+		rows = append(rows, []string{f.File, entity, string(f.Status)})
+	}
+	printTable(w, []string{"File", "Entity", "Status"}, rows)
+
+	summary := fmt.Sprintf("%d match, %d differ, %d missing", report.Count(wedev.CheckMatch), report.Count(wedev.CheckDiffers), report.Count(wedev.CheckMissing))
+	if !ignoreExtra {
+		summary += fmt.Sprintf(", %d extra", report.Count(wedev.CheckExtra))
+	}
+	fmt.Fprintf(w, "\n%s\n", summary)
+	if !report.OK(ignoreExtra) {
+		return fmt.Errorf("configs in %s do not match the generated ones", report.Dir)
+	}
+	return nil
+}
+
+// frozenConfigVersion returns the latest saved config version of a network
+// whose config is frozen, which 'config generate' writes instead of
+// generating, or nil when it is not frozen. With override, a frozen config is
+// generated and saved anyway, and the override is announced on the command's
+// error output; the saved version records it too.
+func frozenConfigVersion(cmd *cobra.Command, app *App, networkName string, override bool) (*wedev.ConfigVersion, error) {
+	network, err := app.storage.GetNetworkByNameCtx(cmd.Context(), networkName)
+	if err != nil {
+		return nil, err
+	}
+	if network.Freeze == nil {
+		return nil, nil
+	}
+	errOut := cmd.ErrOrStderr()
+	if override {
+		fmt.Fprintf(errOut, "Warning: config of network '%s' is frozen %s; --force saves a new version anyway, and the override is recorded\n", networkName, network.Freeze)
+		return nil, nil
+	}
+	pinned, err := app.storage.GetLatestConfigVersionCtx(cmd.Context(), network.ID)
+	if errors.Is(err, wedev.ErrNotFound) {
+		return nil, withKind(wedev.ErrFrozen, fmt.Errorf("config of network '%s' is frozen %s and has no saved version to write; 'config unfreeze' it or pass --force", networkName, network.Freeze))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest config version: %w", err)
+	}
+	fmt.Fprintf(errOut, "Config of network '%s' is frozen %s; writing version %d (pass --force to save a new one)\n", networkName, network.Freeze, pinned.Version)
+	return pinned, nil
+}
+
+// printSavedVersion reports the outcome of saving a config version, or,
+// when the config is frozen, that version was written without saving.
+func printSavedVersion(w io.Writer, version *wedev.ConfigVersion, created, frozen bool) {
+	if frozen {
+		fmt.Fprintf(w, "\nConfig is frozen: wrote version %d, no new version saved\n", version.Version)
+		return
+	}
+	if !created {
+		fmt.Fprintln(w, "\nNo changes detected, version not updated")
+		return
+	}
+	fmt.Fprintf(w, "\nConfiguration version %d saved\n", version.Version)
+	if version.Message != "" {
+		fmt.Fprintf(w, "Message: %s\n", version.Message)
+	}
+}
+
+// writeConfigStream writes configs to the command's output in format, saving
+// the version with save first unless noSave is set. Messages go to its error
+// output so they do not mix with the stream.
+func writeConfigStream(cmd *cobra.Command, save func() (*wedev.ConfigVersion, bool, error), networkName string, configs, filenames, addresses map[string]string, format wedev.StreamFormat, noSave bool) error {
+	stream := &wedev.ConfigArchive{Network: networkName, Configs: configs, Filenames: filenames, Addresses: addresses, CreatedAt: time.Now()}
+	var version *wedev.ConfigVersion
+	var created bool
+	if !noSave {
+		var err error
+		version, created, err = save()
+		if err != nil {
+			return fmt.Errorf("failed to save config version: %w", err)
+		}
+		stream.CreatedAt = version.CreatedAt
+	}
+
+	if err := wedev.WriteConfigStream(cmd.OutOrStdout(), stream, format); err != nil {
+		return fmt.Errorf("failed to write configs: %w", err)
+	}
+	if version != nil {
+		printSavedVersion(cmd.ErrOrStderr(), version, created, false)
+	}
+	return nil
+}
+
+// translateSetconf replaces configs with their 'wg setconf' translations and
+// returns the interface settings taken out of each, warning on standard
+// error about the wg-quick commands no file applies.
+func translateSetconf(cmd *cobra.Command, configs, filenames map[string]string) (map[string]string, error) {
+	addresses := make(map[string]string, len(configs))
+	var skipped []string
+	for name, config := range configs {
+		files, err := wedev.TranslateSetconf(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to translate the config of %s for wg setconf: %w", name, err)
+		}
+		configs[name] = files.Config
+		addresses[name] = files.Addresses
+		for _, command := range files.Skipped {
+			skipped = append(skipped, fmt.Sprintf("%s (listed in %s)", command, wedev.AddressesFilename(filenames[name])))
+		}
+	}
+	sort.Strings(skipped)
+	for _, command := range skipped {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: wg setconf does not run %s\n", command)
+	}
+	return addresses, nil
+}
+
+// warnOutputDirPerms warns on w when dir, which receives configs holding
+// private keys, is readable by its group or others.
+func warnOutputDirPerms(w io.Writer, dir string) {
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm()&0o044 == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Warning: output directory %s is readable by group or others (mode %04o) and the configs hold private keys; consider 'chmod 700 %s' (--no-perm-check silences this)\n",
+		dir, info.Mode().Perm(), dir)
+}
+
+// writeConfigArchive packages configs, which belong to version, into the
+// archive at path, each with its entry of addresses when there is one.
+func writeConfigArchive(path, networkName string, version *wedev.ConfigVersion, configs, filenames, addresses map[string]string, perEntity bool) error {
+	err := wedev.WriteConfigArchive(path, &wedev.ConfigArchive{
+		Network:     networkName,
+		Version:     version.Version,
+		ContentHash: version.ContentHash,
+		Configs:     configs,
+		Filenames:   filenames,
+		Addresses:   addresses,
+		PerEntity:   perEntity,
+		CreatedAt:   version.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", path, err)
+	}
+	return nil
+}
+
+// makeConfigExportCommand creates the 'config export' command for a specific network
+func makeConfigExportCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "export <version|tag> --archive <file.tar.gz|file.zip> [--per-entity] [--filename-template <template>]",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Package a saved config version into an archive",
+		Long: `Write the configs of a saved version into one .tar.gz (or .tgz) or .zip
+file, laid out as 'config generate --archive' does: a README naming the
+version and content hash, and one file per server or node, in a directory of
+its own with --per-entity. The version is given by number or by a tag set
+with 'config tag'. Files are named with --filename-template, or the
+network's template; entities deleted since the version was saved get
+<entity>.conf.
+
+Examples:
+  wedevctl vn mynet config export 3 --archive mynet-v3.tar.gz
+  wedevctl vn mynet config export 3 --archive mynet-v3.zip --per-entity
+  wedevctl vn mynet config export prod --archive mynet-prod.tar.gz`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigVersions(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			archive, err := cmd.Flags().GetString("archive")
+			if err != nil {
+				return fmt.Errorf("failed to get archive flag: %w", err)
+			}
+			perEntity, err := cmd.Flags().GetBool("per-entity")
+			if err != nil {
+				return fmt.Errorf("failed to get per-entity flag: %w", err)
+			}
+			filenameTemplate, err := cmd.Flags().GetString("filename-template")
+			if err != nil {
+				return fmt.Errorf("failed to get filename-template flag: %w", err)
+			}
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			if _, err := wedev.ArchiveFormatFor(archive); err != nil {
+				return err
+			}
+
+			version, err := app.generator.GetConfigByRef(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get configuration: %w", err)
+			}
+			filenames, err := app.generator.ConfigFilenames(networkName, filenameTemplate)
+			if err != nil {
+				return err
+			}
+
+			if !confirmArchiveOverwrite(app, cmd, archive, force) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+			if err := writeConfigArchive(archive, networkName, version, version.Configs, filenames, nil, perEntity); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "Archived %d config(s) of version %d to %s\n", len(version.Configs), version.Version, archive)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("archive", "", "Archive to write: a .tar.gz, .tgz or .zip file")
+	cmd.Flags().Bool("per-entity", false, "Put each config in a directory named after its entity")
+	cmd.Flags().String("filename-template", "", "Go template for config file names (default: the network's template, or {{.Entity}}.conf)")
+	cmd.Flags().Bool("force", false, "Overwrite an existing archive without asking")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.MarkFlagRequired("archive")
+
+	return cmd
+}
+
+// makeConfigShowCommand creates the 'config show' command for a specific network
+func makeConfigShowCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "show <name>",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Print one entity's generated config",
+		Long: `Generate the config of the named server or node and print it to stdout,
+including its private key, for piping into other tools. No files are written
+and no version is saved. --no-comments leaves out the header and peer name
+comments.
+
+--config-format wg prints the config for 'wg setconf' instead, without the
+wg-quick [Interface] keys; the Address, DNS and MTU values it leaves out are
+printed to standard error.
+
+Examples:
+  wedevctl vn mynet config show node1 > /etc/wireguard/mynet.conf
+  wedevctl vn mynet config show node1 | kubectl create secret generic wg --from-file=wg0.conf=/dev/stdin`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeEntityNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			noComments, err := cmd.Flags().GetBool("no-comments")
+			if err != nil {
+				return fmt.Errorf("failed to get no-comments flag: %w", err)
+			}
+
+			configFormatFlag, err := cmd.Flags().GetString("config-format")
+			if err != nil {
+				return fmt.Errorf("failed to get config-format flag: %w", err)
+			}
+			configFormat, err := wedev.ParseConfigFormat(configFormatFlag)
+			if err != nil {
+				return err
+			}
+
+			config, err := app.generator.GenerateConfigCtx(cmd.Context(), networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to generate config: %w", err)
+			}
+			if noComments {
+				config = wedev.StripComments(config)
+			}
+			if configFormat == wedev.ConfigWG {
+				files, err := wedev.TranslateSetconf(config)
+				if err != nil {
+					return fmt.Errorf("failed to translate config for wg setconf: %w", err)
+				}
+				config = files.Config
+				fmt.Fprint(cmd.ErrOrStderr(), files.Addresses)
+			}
+
+			fmt.Fprint(out, config)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("no-comments", false, "Print the config without the header and peer name comments")
+	cmd.Flags().String("config-format", string(wedev.ConfigWGQuick), "Config dialect: wg-quick, or wg for 'wg setconf'")
+
+	return cmd
+}
+
+// printConfigPreview prints a dry-run diff of generated configs.
+func printConfigPreview(w io.Writer, preview *wedev.ConfigPreview) {
+	if preview.Unchanged {
+		fmt.Fprintf(w, "No changes: generated configs match version %d\n", preview.BaseVersion)
+		return
+	}
+
+	if preview.BaseVersion == 0 {
+		fmt.Fprintln(w, "No saved version yet; all configs are new")
+	} else {
+		fmt.Fprintf(w, "Changes against version %d:\n", preview.BaseVersion)
+	}
+	fmt.Fprintln(w)
+	for _, file := range preview.Files {
+		fmt.Fprint(w, file.String())
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Dry run: %d file(s) would change; nothing written or saved\n", len(preview.Files))
+}
+
+// makeConfigInfoCommand creates the 'config info' command for a specific network
+func makeConfigInfoCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "info [version | tag | --hash <prefix>] [--show-secrets]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "View configuration information",
+		Long: `View a stored configuration version (the latest by default), given by
+number or by a tag set with 'config tag'.
+
+--hash selects the version by a prefix of its content hash instead of its
+number, as printed by 'config history' or an archive README. When later
+versions restored the same configs, the latest of them is shown.
+
+PrivateKey and PresharedKey values are shown as "(redacted)" so the output is
+safe in shared terminals and logs; pass --show-secrets to print them.`,
+		Args:              cobra.RangeArgs(0, 1),
+		ValidArgsFunction: completeConfigVersions(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			showSecrets, err := cmd.Flags().GetBool("show-secrets")
+			if err != nil {
+				return fmt.Errorf("failed to get show-secrets flag: %w", err)
+			}
+			// Of an encrypted database, only printing the keys needs the
+			// passphrase.
+			if showSecrets {
+				if err := app.unlockKeys(cmd); err != nil {
+					return err
+				}
+			}
+
+			hash, err := cmd.Flags().GetString("hash")
+			if err != nil {
+				return fmt.Errorf("failed to get hash flag: %w", err)
+			}
+
+			generator := app.generator
+
+			var version *wedev.ConfigVersion
+
+			if hash != "" {
+				if len(args) == 1 {
+					return fmt.Errorf("give a version or --hash, not both")
+				}
+				version, err = generator.GetConfigByHashCtx(cmd.Context(), networkName, hash)
+			} else if len(args) == 1 {
+				version, err = generator.GetConfigByRef(networkName, args[0])
+			} else {
+				history, histErr := generator.GetConfigHistoryCtx(cmd.Context(), networkName)
+				if histErr != nil || len(history) == 0 {
+					return fmt.Errorf("no configuration versions found")
+				}
+				version = history[len(history)-1]
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to get configuration: %w", err)
+			}
+
+			tags, err := generator.ConfigTagsByVersion(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to get config tags: %w", err)
+			}
+
+			fmt.Fprintf(out, "Configuration Version: %d\n", version.Version)
+			fmt.Fprintf(out, "Content Hash: %s\n", version.ContentHash)
+			if len(tags[version.Version]) > 0 {
+				fmt.Fprintf(out, "Tags: %s\n", strings.Join(tags[version.Version], ", "))
+			}
+			fmt.Fprintf(out, "Created At: %s\n", version.CreatedAt)
+			if version.ChangedBy != "" {
+				fmt.Fprintf(out, "Changed By: %s\n", version.ChangedBy)
+			}
+			if version.Message != "" {
+				fmt.Fprintf(out, "Message: %s\n", version.Message)
+			}
+			fmt.Fprintf(out, "\nConfigurations:\n")
+			fmt.Fprintln(out, "================================================================================")
+
+			// Sort names for consistent output
+			names := make([]string, 0, len(version.Configs))
+			for name := range version.Configs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			// Display each config content
+			for i, name := range names {
+				if i > 0 {
+					fmt.Fprintln(out, "\n--------------------------------------------------------------------------------")
+				}
+				content := version.Configs[name]
+				if !showSecrets {
+					content = wedev.RedactConfig(content)
+				}
+				fmt.Fprintf(out, "\n[%s.conf]\n\n", name)
+				fmt.Fprint(out, content)
+				if !strings.HasSuffix(content, "\n") {
+					fmt.Fprintln(out)
+				}
+			}
+			fmt.Fprintln(out, "================================================================================")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("show-secrets", false, "Print private and preshared keys instead of redacting them")
+	cmd.Flags().String("hash", "", "Select the version whose content hash starts with this prefix")
+
+	return cmd
+}
+
+// makeConfigVerifyCommand creates the 'config verify' command for a specific network
+func makeConfigVerifyCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "verify <file>... [--show-secrets] [--output table|json|yaml]",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Find the saved versions a config file comes from",
+		Long: fmt.Sprintf(`Look up each config file in the saved versions of network '%s' and print
+the server or node it belongs to, the versions holding it, and when the first
+of them was saved. The generation time in the header is ignored, and files
+written with --no-comments match too.
+
+A file matching no saved config is reported as unknown/modified, with a diff
+from the closest config of the latest version (the entity named like the
+file, or else the one with the same interface address) to the file. Keys are
+redacted from the diff unless --show-secrets is given.
+
+The command exits non-zero when any file is unknown or modified.
+
+Examples:
+  wedevctl vn %s config verify /etc/wireguard/wg0.conf
+  wedevctl vn %s config verify ./configs/*.conf --output json`, networkName, networkName, networkName),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			showSecrets, err := cmd.Flags().GetBool("show-secrets")
+			if err != nil {
+				return fmt.Errorf("failed to get show-secrets flag: %w", err)
+			}
+
+			results := make([]*wedev.ConfigVerification, 0, len(args))
+			unverified := 0
+			for _, file := range args {
+				content, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read config file: %w", err)
+				}
+				result, err := app.generator.VerifyConfigCtx(cmd.Context(), networkName, file, string(content), !showSecrets)
+				if err != nil {
+					return fmt.Errorf("failed to verify %s: %w", file, err)
+				}
+				if !result.Verified() {
+					unverified++
+				}
+				results = append(results, result)
+			}
+
+			switch output {
+			case "json":
+				err = printJSON(out, results)
+			case "yaml":
+				err = printYAML(out, results)
+			default:
+				for _, result := range results {
+					printConfigVerification(out, result)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			if unverified > 0 {
+				return fmt.Errorf("%d of %d file(s) match no saved configuration", unverified, len(args))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("show-secrets", false, "Show private and preshared keys in diffs instead of redacting them")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// printConfigVerification prints what 'config verify' found for one file.
+func printConfigVerification(w io.Writer, result *wedev.ConfigVerification) {
+	if !result.Verified() {
+		fmt.Fprintf(w, "%s: unknown/modified\n", result.File)
+		switch {
+		case result.LatestVersion == 0:
+			fmt.Fprintln(w, "  no configuration versions saved")
+		case result.Closest == "":
+			fmt.Fprintf(w, "  no server or node of version %d is named like it or has its address\n", result.LatestVersion)
+		case result.Diff == "":
+			fmt.Fprintf(w, "  differs from '%s' in version %d only in its keys (see --show-secrets)\n", result.Closest, result.LatestVersion)
+		default:
+			fmt.Fprintf(w, "  closest: '%s' in version %d\n", result.Closest, result.LatestVersion)
+			fmt.Fprint(w, result.Diff)
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "%s: verified\n", result.File)
+	for _, match := range result.Matches {
+		versions := make([]string, 0, len(match.Versions))
+		for _, v := range match.Versions {
+			versions = append(versions, strconv.Itoa(v))
+		}
+		label := "version"
+		if len(versions) > 1 {
+			label = "versions"
+		}
+		fmt.Fprintf(w, "  '%s' in %s %s, created %s", match.Entity, label, strings.Join(versions, ", "), match.CreatedAt.Format("2006-01-02 15:04:05"))
+		if match.WithoutComments {
+			fmt.Fprint(w, " (without comments)")
+		}
+		if last := match.Versions[len(match.Versions)-1]; last != result.LatestVersion {
+			fmt.Fprintf(w, "; latest version is %d", result.LatestVersion)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// makeConfigWatchCommand creates the 'config watch' command for a specific
+// network.
+func makeConfigWatchCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "watch --output-dir <dir> [--interval <duration>] [--filename-template <template>]",
+		Annotations: withKeys(nil),
+		Short:       "Regenerate configs whenever the network changes",
+		Long: `Keep a directory of configs in line with the database: whenever a change
+to the network, its servers or its nodes is seen, the configs are regenerated,
+the files whose content changed are rewritten, and a new version is saved if
+the content hash changed. Each sync prints one line naming the network
+revision, the files written, and the version saved.
+
+The network revision, a counter every change increments, is polled every
+--interval. It must read the same on two polls in a row before the configs
+are regenerated, so a burst of edits leads to one sync. The configs are
+synced once at startup.
+
+The database is only held between polls while syncing, so other wedevctl
+commands can change it meanwhile. Errors are printed and watching goes on;
+Ctrl-C stops it. Files of removed servers and nodes are left in place.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			outputDir, err := cmd.Flags().GetString("output-dir")
+			if err != nil {
+				return fmt.Errorf("failed to get output-dir flag: %w", err)
+			}
+			interval, err := cmd.Flags().GetDuration("interval")
+			if err != nil {
+				return fmt.Errorf("failed to get interval flag: %w", err)
+			}
+			if interval <= 0 {
+				return withKind(wedev.ErrValidation, fmt.Errorf("--interval must be positive, got %s", interval))
+			}
+			filenameTemplate, err := cmd.Flags().GetString("filename-template")
+			if err != nil {
+				return fmt.Errorf("failed to get filename-template flag: %w", err)
+			}
+			if _, err := app.generator.ConfigFilenames(networkName, filenameTemplate); err != nil {
+				return err
+			}
+
+			// Release the handle opened for this command; the watcher holds
+			// none between polls, so writers never wait for it.
+			dbPath, opts := app.dbPath, app.opts
+			opts.ReadOnly = false
+			if err := app.close(); err != nil {
+				return fmt.Errorf("failed to close database: %w", err)
+			}
+
+			watcher := &wedev.RevisionWatcher{
+				Interval: interval,
+				Revision: func(ctx context.Context) (revision uint64, err error) {
+					err = withReadOnlyStorage(dbPath, func(sm *wedev.StorageManager) error {
+						network, err := sm.GetNetworkByNameCtx(ctx, networkName)
+						if err != nil {
+							return err
+						}
+						revision, err = sm.NetworkRevisionCtx(ctx, network.ID)
+						return err
+					})
+					return revision, err
+				},
+				Changed: func(ctx context.Context, revision uint64) error {
+					if err := app.open(ctx, dbPath, opts); err != nil {
+						return err
+					}
+					//nolint:errcheck // Reopened on the next sync
+					defer func() { _ = app.close() }()
+					return syncWatchedConfigs(ctx, out, app, networkName, outputDir, filenameTemplate, revision)
+				},
+				Failed: func(err error) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s error: %v\n", time.Now().Format(time.TimeOnly), err)
+				},
+			}
+			fmt.Fprintf(out, "Watching network '%s' every %s; press Ctrl-C to stop\n", networkName, interval)
+			return watcher.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().String("output-dir", "", "Directory to keep the configs in (required)")
+	cmd.Flags().Duration("interval", 2*time.Second, "How often to poll the database for changes")
+	cmd.Flags().String("filename-template", "", "Go template for config file names (default: the network's template, or {{.Entity}}.conf)")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.MarkFlagRequired("output-dir")
+
+	return cmd
+}
+
+// syncWatchedConfigs regenerates the configs of a network into outputDir,
+// saves a version if they changed, and prints a one-line summary. While the
+// config is frozen the files are left as they are.
+func syncWatchedConfigs(ctx context.Context, w io.Writer, app *App, networkName, outputDir, filenameTemplate string, revision uint64) error {
+	network, err := app.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return err
+	}
+	if network.Freeze != nil {
+		fmt.Fprintf(w, "%s revision %d: config frozen %s, files left as they are\n", time.Now().Format(time.TimeOnly), revision, network.Freeze)
+		return nil
+	}
+	configs, _, err := app.generator.GenerateConfigsCtx(ctx, networkName, app.storage)
+	if err != nil {
+		return fmt.Errorf("failed to generate configs: %w", err)
+	}
+	filenames, err := app.generator.ConfigFilenames(networkName, filenameTemplate)
+	if err != nil {
+		return err
+	}
+	written, err := wedev.WriteChangedConfigs(outputDir, configs, filenames)
+	if err != nil {
+		return err
+	}
+	version, created, err := app.generator.SaveConfigVersionWithMessageCtx(ctx, networkName, fmt.Sprintf("config watch at revision %d", revision))
+	if err != nil {
+		return fmt.Errorf("failed to save config version: %w", err)
+	}
+
+	summary := "no files changed"
+	if len(written) > 0 {
+		summary = fmt.Sprintf("wrote %s", strings.Join(written, ", "))
+	}
+	if created {
+		summary += fmt.Sprintf(", saved version %d", version.Version)
+	}
+	fmt.Fprintf(w, "%s revision %d: %s\n", time.Now().Format(time.TimeOnly), revision, summary)
+	return nil
+}
+
+// makeConfigHistoryCommand creates the 'config history' command for a specific network
+func makeConfigHistoryCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "history [--since <time>] [--until <time>] [--last <n>] [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "View configuration history",
+		Long: `List the saved config versions of the network, oldest first.
+
+--since and --until keep the versions saved at or after, and at or before, a
+time: an RFC 3339 timestamp, or a duration before now such as 90m, 72h or
+30d. --last keeps only the newest n of the versions left. The table notes how
+many versions were filtered out, and starts with a banner while the config is
+frozen (see 'config freeze'), unless --no-header leaves both out.
+
+Examples:
+  wedevctl vn mynet config history --since 30d
+  wedevctl vn mynet config history --since 2026-01-01T00:00:00Z --until 72h
+  wedevctl vn mynet config history --last 10`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			filter, err := configVersionFilter(cmd, time.Now())
+			if err != nil {
+				return err
+			}
+
+			generator := app.generator
+			history, total, err := generator.GetConfigHistoryFilteredCtx(cmd.Context(), networkName, filter)
+			if err != nil {
+				return fmt.Errorf("failed to get config history: %w", err)
+			}
+			tags, err := generator.ConfigTagsByVersion(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to get config tags: %w", err)
+			}
+
+			switch output {
+			case "json", "yaml":
+				entries := make([]configHistoryEntry, 0, len(history))
+				for _, cfg := range history {
+					entries = append(entries, configHistoryEntry{ConfigVersion: cfg, Tags: tags[cfg.Version]})
+				}
+				if output == "json" {
+					return printJSON(out, entries)
+				}
+				return printYAML(out, entries)
+			}
+
+			noHeader, err := noHeaderFlag(cmd)
+			if err != nil {
+				return err
+			}
+			empty := "No configuration versions found"
+			if total > 0 {
+				empty = fmt.Sprintf("No configuration versions match (%d filtered out)", total)
+			}
+
+			if !noHeader {
+				network, err := app.storage.GetNetworkByNameCtx(cmd.Context(), networkName)
+				if err != nil {
+					return err
+				}
+				if network.Freeze != nil {
+					fmt.Fprintf(out, "FROZEN %s; no new version is saved until 'config unfreeze'\n\n", network.Freeze)
+				}
+			}
+
+			rows := make([][]string, 0, len(history))
+			for _, cfg := range history {
+				rows = append(rows, []string{strconv.Itoa(cfg.Version), cfg.ContentHash, cfg.CreatedAt.Format("2006-01-02 15:04:05"), strings.Join(tags[cfg.Version], ","), cfg.ChangedBy, cfg.Message})
+			}
+			if err := printListTable(cmd, out, empty, []string{"Version", "Hash", "Created", "Tags", "By", "Message"}, rows); err != nil {
+				return err
+			}
+			if filtered := total - len(history); filtered > 0 && len(history) > 0 && !noHeader {
+				fmt.Fprintf(out, "\nShowing %d of %d versions (%d filtered out)\n", len(history), total, filtered)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	cmd.Flags().String("since", "", "Only versions saved at or after this RFC 3339 time or duration ago (e.g. 72h, 30d)")
+	cmd.Flags().String("until", "", "Only versions saved at or before this RFC 3339 time or duration ago")
+	cmd.Flags().Int("last", 0, "Only the newest n versions (after --since and --until)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// configVersionFilter reads the --since, --until and --last flags of
+// 'config history', resolving durations against now.
+func configVersionFilter(cmd *cobra.Command, now time.Time) (wedev.ConfigVersionFilter, error) {
+	var filter wedev.ConfigVersionFilter
+	for _, bound := range []struct {
+		flag string
+		to   *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value, err := cmd.Flags().GetString(bound.flag)
+		if err != nil {
+			return filter, fmt.Errorf("failed to get %s flag: %w", bound.flag, err)
+		}
+		if value == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			*bound.to = t
+			continue
+		}
+		d, err := util.ParseDuration(value)
+		if err != nil {
+			return filter, withKind(wedev.ErrValidation, fmt.Errorf("invalid --%s %q (expected an RFC 3339 time or a duration such as 72h or 30d)", bound.flag, value))
+		}
+		*bound.to = now.Add(-d)
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Since.After(filter.Until) {
+		return filter, withKind(wedev.ErrValidation, fmt.Errorf("--since %s is after --until %s", filter.Since.Format(time.RFC3339), filter.Until.Format(time.RFC3339)))
+	}
+
+	last, err := cmd.Flags().GetInt("last")
+	if err != nil {
+		return filter, fmt.Errorf("failed to get last flag: %w", err)
+	}
+	if last < 0 {
+		return filter, withKind(wedev.ErrValidation, fmt.Errorf("--last must not be negative"))
+	}
+	filter.Last = last
+	return filter, nil
+}
+
+// configHistoryEntry is a config version as 'config history' prints it in
+// JSON and YAML: the version's fields plus its tags.
+type configHistoryEntry struct {
+	*wedev.ConfigVersion
+	Tags []string `json:"tags,omitempty"`
+}
+
+// makeConfigTagCommand creates the 'config tag' command for a specific network
+func makeConfigTagCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag <version> <tag> [--force]",
+		Short: "Name a saved config version",
+		Long: `Give a saved config version a name, such as "prod" for the version rolled out
+to production. 'config info' and 'config export' accept the tag wherever they
+accept a version number, and 'config history' lists each version's tags.
+
+A tag names one version at a time and is unique within the network; moving
+an existing tag to another version needs --force. Tag names follow the rules
+for network names: a letter, then letters and digits.
+
+Examples:
+  wedevctl vn mynet config tag 12 prod
+  wedevctl vn mynet config tag 14 prod --force`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigVersions(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+
+			version, err := app.generator.ResolveConfigVersion(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to tag config version: %w", err)
+			}
+			tag := args[1]
+			previous, err := app.generator.TagConfigVersion(networkName, version, tag, force)
+			if err != nil {
+				return fmt.Errorf("failed to tag config version: %w", err)
+			}
+
+			if previous != 0 && previous != version {
+				fmt.Fprintf(out, "Tag '%s' moved from version %d to version %d\n", tag, previous, version)
+			} else {
+				fmt.Fprintf(out, "Tagged version %d as '%s'\n", version, tag)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("force", false, "Move the tag if it already names another version")
+
+	return cmd
+}
+
+// makeConfigUntagCommand creates the 'config untag' command for a specific network
+func makeConfigUntagCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "untag <tag>",
+		Short: "Remove a config version tag",
+		Long: `Remove a tag set with 'config tag'. The version it named is kept.
+
+Examples:
+  wedevctl vn mynet config untag staging`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigTags(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := app.generator.UntagConfigVersion(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to remove tag: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Tag '%s' removed from version %d\n", args[0], version)
+			return nil
+		},
+	}
+}
+
+// makeConfigFreezeCommand creates the 'config freeze' command for a specific network
+func makeConfigFreezeCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "freeze [--reason <text>]",
+		Short: "Stop saving new config versions",
+		Long: `Freeze the config of the network, for example during an incident, so no new
+config version is saved until 'config unfreeze'. 'config generate' still
+writes configs, from the latest saved version, and commands that would save a
+version, such as 'guest create', fail with exit code 9. 'vn info' and
+'config history' show who froze it, when and why.
+
+'config generate --force' saves a new version anyway; the override is logged
+and recorded in the version's message.
+
+Examples:
+  wedevctl vn mynet config freeze --reason "incident 42: rollback in progress"
+  wedevctl vn mynet config unfreeze`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reason, err := cmd.Flags().GetString("reason")
+			if err != nil {
+				return fmt.Errorf("failed to get reason flag: %w", err)
+			}
+
+			freeze, err := app.vnManager.FreezeConfig(networkName, reason)
+			if err != nil {
+				return fmt.Errorf("failed to freeze config: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Config of network '%s' frozen %s\n", networkName, freeze)
+			fmt.Fprintln(cmd.OutOrStdout(), "'config generate' writes the latest saved version and saves no new one until 'config unfreeze'")
+			return nil
+		},
+	}
+
+	cmd.Flags().String("reason", "", "Why the config is frozen, shown wherever the freeze is")
+
+	return cmd
+}
+
+// makeConfigUnfreezeCommand creates the 'config unfreeze' command for a specific network
+func makeConfigUnfreezeCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "unfreeze",
+		Short: "Save config versions again",
+		Long: `Lift a freeze set with 'config freeze'. The next 'config generate' saves a
+new version again if the configs changed while frozen.
+
+Examples:
+  wedevctl vn mynet config unfreeze`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			freeze, err := app.vnManager.UnfreezeConfig(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to unfreeze config: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Config of network '%s' unfrozen (was frozen %s)\n", networkName, freeze)
+			return nil
+		},
+	}
+}
+
+// makeConfigStaleCommand creates the 'config stale' command for a specific network
+func makeConfigStaleCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "stale [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Annotations: withKeys(readOnlyAnnotations()),
+		Short:       "Show which servers and nodes run an out-of-date config",
+		Long: fmt.Sprintf(`Compare the config last deployed to each server and node of network '%s'
+with the latest saved version.
+
+Deployments are recorded by 'config apply'. An entity is stale when a version
+saved since its deployment changed its config; the Current column is the
+latest version that did. Entities never deployed with 'config apply' are
+listed as never deployed.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			states, err := app.generator.DeploymentStatesCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to check deployments: %w", err)
+			}
+
+			switch output {
+			case "json":
+				return printJSON(out, states)
+			case "yaml":
+				return printYAML(out, states)
+			}
+
+			rows := make([][]string, 0, len(states))
+			for _, state := range states {
+				deployed, deployedAt := "-", "-"
+				if state.DeployedAt != nil {
+					deployed = strconv.Itoa(state.DeployedVersion)
+					if state.DeployedVersion == 0 {
+						deployed = "unsaved"
+					}
+					deployedAt = state.DeployedAt.Format("2006-01-02 15:04:05")
+				}
+				status := strings.ReplaceAll(string(state.Status), "_", " ")
+				if state.Behind > 0 {
+					status = fmt.Sprintf("stale (%d change(s) behind)", state.Behind)
+				}
+				rows = append(rows, []string{state.Entity, state.Kind, strconv.Itoa(state.CurrentVersion), deployed, deployedAt, status})
+			}
+			return printListTable(cmd, out, "No servers or nodes found", []string{"Entity", "Kind", "Current", "Deployed", "Deployed At", "Status"}, rows)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// makeConfigApplyCommand creates the 'config apply' command for a specific network
+func makeConfigApplyCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "apply <entity-name>",
+		Annotations: withKeys(nil),
+		Short:       "Install an entity's config locally and bring it up with wg-quick",
+		Long: fmt.Sprintf(`Write the generated config of the server or a node to
+<config-dir>/<interface>.conf and (re)start the interface with wg-quick.
+
+The interface name defaults to the network name ('%s'). Requires root and
+wireguard-tools unless --dry-run is given. A successful apply is recorded as
+the entity's deployment, which 'config stale' compares with later versions.
+
+With --native, no config file is written and wg-quick is not run: wedevctl
+reads the device over netlink, creates it if it is missing, and sends only
+the keys, ports and peers that differ. Addresses, DNS and hooks are left to
+the system. It needs Linux with WireGuard in the kernel, and root; with
+--dry-run it still needs root, to read the device.
+
+Examples:
+  sudo wedevctl vn %s config apply node1
+  sudo wedevctl vn %s config apply node1 --interface wg0 --no-restart
+  sudo wedevctl vn %s config apply node1 --native
+  wedevctl vn %s config apply node1 --dry-run`, networkName, networkName, networkName, networkName, networkName),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeEntityNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			entityName := args[0]
+
+			iface, err := cmd.Flags().GetString("interface")
+			if err != nil {
+				return fmt.Errorf("failed to get interface flag: %w", err)
+			}
+			configDir, err := cmd.Flags().GetString("config-dir")
+			if err != nil {
+				return fmt.Errorf("failed to get config-dir flag: %w", err)
+			}
+			noRestart, err := cmd.Flags().GetBool("no-restart")
+			if err != nil {
+				return fmt.Errorf("failed to get no-restart flag: %w", err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return fmt.Errorf("failed to get dry-run flag: %w", err)
+			}
+			native, err := cmd.Flags().GetBool("native")
+			if err != nil {
+				return fmt.Errorf("failed to get native flag: %w", err)
+			}
+			if native {
+				if cmd.Flags().Changed("config-dir") {
+					return fmt.Errorf("--native writes no config file; it cannot be combined with --config-dir")
+				}
+				return applyNative(cmd, app, networkName, entityName, iface, dryRun)
+			}
+
+			opts := wedev.ApplyOptions{Interface: iface, ConfigDir: configDir, NoRestart: noRestart}
+			applier := wedev.NewConfigApplier(app.storage)
+			plan, err := applier.PlanCtx(cmd.Context(), networkName, entityName, opts)
+			if err != nil {
+				return fmt.Errorf("failed to plan config apply: %w", err)
+			}
+
+			if dryRun {
+				fmt.Fprintf(out, "Would write: %s\n\n", plan.ConfigPath)
+				fmt.Fprint(out, plan.Config)
+				fmt.Fprintln(out, "Would run:")
+				for _, c := range plan.Commands {
+					fmt.Fprintf(out, "  %s\n", strings.Join(c, " "))
+				}
+				return nil
+			}
+
+			if err := applier.ApplyCtx(cmd.Context(), plan, opts); err != nil {
+				return fmt.Errorf("failed to apply config: %w", err)
+			}
+
+			fmt.Fprintf(out, "Wrote: %s\n", plan.ConfigPath)
+			fmt.Fprintf(out, "Interface '%s' is up with the config for '%s'\n", plan.Interface, entityName)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("interface", "", "WireGuard interface name (default: network name)")
+	cmd.Flags().String("config-dir", wedev.DefaultWireGuardDir, "Directory to write <interface>.conf into")
+	cmd.Flags().Bool("no-restart", false, "Update the running interface with 'wg syncconf' instead of restarting it")
+	cmd.Flags().Bool("dry-run", false, "Print the config and commands without changing anything")
+	cmd.Flags().Bool("native", false, "Configure the device over netlink instead of writing a config for wg-quick (Linux)")
+	cmd.MarkFlagsMutuallyExclusive("native", "no-restart")
+
+	return cmd
+}
+
+// applyNative brings the device of an entity in line with its config through
+// a NativeSyncer, printing the changes it makes (or would make).
+func applyNative(cmd *cobra.Command, app *App, networkName, entityName, iface string, dryRun bool) error {
+	out := cmd.OutOrStdout()
+
+	client, err := wedev.NewNativeClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		//nolint:errcheck // Nothing to do about a failed close of the netlink client
+		_ = client.Close()
+	}()
+
+	syncer := wedev.NewNativeSyncer(app.storage, client)
+	plan, err := syncer.Plan(cmd.Context(), networkName, entityName, iface)
+	if err != nil {
+		return fmt.Errorf("failed to plan config apply: %w", err)
+	}
+
+	if dryRun {
+		if len(plan.Changes) == 0 {
+			fmt.Fprintf(out, "Interface '%s' already matches the config for '%s'\n", plan.Interface, entityName)
+		} else {
+			fmt.Fprintln(out, "Would apply:")
+			for _, change := range plan.Changes {
+				fmt.Fprintf(out, "  %s\n", change)
+			}
+		}
+		printSkippedKeys(cmd, plan.Skipped)
+		return nil
+	}
+
+	if err := syncer.Apply(cmd.Context(), plan); err != nil {
+		return fmt.Errorf("failed to apply config: %w", err)
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Fprintf(out, "Interface '%s' already matches the config for '%s'\n", plan.Interface, entityName)
+	} else {
+		fmt.Fprintf(out, "Applied to interface '%s':\n", plan.Interface)
+		for _, change := range plan.Changes {
+			fmt.Fprintf(out, "  %s\n", change)
+		}
+	}
+	printSkippedKeys(cmd, plan.Skipped)
+	return nil
+}
+
+// printSkippedKeys notes on stderr the [Interface] keys a native apply leaves
+// to the system.
+func printSkippedKeys(cmd *cobra.Command, skipped []string) {
+	if len(skipped) > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Not applied natively: %s; set them up with the system's network configuration\n", strings.Join(skipped, ", "))
+	}
+}
+
+// makeConfigDeployCommand creates the 'config deploy' command for a specific network
+func makeConfigDeployCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy [entity-name...]",
+		Short: "Install the latest configs on their hosts over SSH",
+		Long: fmt.Sprintf(`Install the latest saved config of each server and node of network '%s'
+(or only the named ones) on its host over SSH, and (re)start the interface
+with wg-quick there.
+
+A host is reached at its public address, or at the host given with
+--host <entity>=<host>; entities with neither are skipped. The ssh client on
+PATH is used, so your ssh config, agent and known hosts apply; the remote
+user must be able to write --config-dir and run wg-quick.
+
+--parallel hosts are deployed at a time, each within --timeout. Every
+successful deploy is recorded as the entity's deployment (see 'config
+stale'), and hosts whose recorded deployment is current are skipped unless
+--force is given, so re-running after a partial failure only retries what
+is left. A summary of succeeded, skipped and failed hosts is printed at the
+end.
+
+Ctrl-C stops starting new hosts; the hosts in flight finish (within
+--timeout) and are recorded before the command exits.
+
+Examples:
+  wedevctl vn %s config deploy --user root --parallel 16
+  wedevctl vn %s config deploy hub --host hub=10.1.0.1
+  wedevctl vn %s config deploy --force --timeout 30s`, networkName, networkName, networkName, networkName),
+		ValidArgsFunction: completeEntityNames(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			var opts wedev.DeployOptions
+			if opts.Interface, err = cmd.Flags().GetString("interface"); err != nil {
+				return fmt.Errorf("failed to get interface flag: %w", err)
+			}
+			if opts.ConfigDir, err = cmd.Flags().GetString("config-dir"); err != nil {
+				return fmt.Errorf("failed to get config-dir flag: %w", err)
+			}
+			if opts.User, err = cmd.Flags().GetString("user"); err != nil {
+				return fmt.Errorf("failed to get user flag: %w", err)
+			}
+			if opts.Parallel, err = cmd.Flags().GetInt("parallel"); err != nil {
+				return fmt.Errorf("failed to get parallel flag: %w", err)
+			}
+			if opts.Parallel < 1 {
+				return withKind(wedev.ErrValidation, fmt.Errorf("--parallel must be at least 1, got %d", opts.Parallel))
+			}
+			if opts.Timeout, err = cmd.Flags().GetDuration("timeout"); err != nil {
+				return fmt.Errorf("failed to get timeout flag: %w", err)
+			}
+			if opts.Timeout <= 0 {
+				return withKind(wedev.ErrValidation, fmt.Errorf("--timeout must be positive, got %s", opts.Timeout))
+			}
+			if opts.Force, err = cmd.Flags().GetBool("force"); err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			hostPairs, err := cmd.Flags().GetStringArray("host")
+			if err != nil {
+				return fmt.Errorf("failed to get host flag: %w", err)
+			}
+			opts.Hosts = make(map[string]string, len(hostPairs))
+			for _, pair := range hostPairs {
+				name, host, ok := strings.Cut(pair, "=")
+				if !ok || name == "" || host == "" || strings.HasPrefix(host, "-") {
+					return withKind(wedev.ErrValidation, fmt.Errorf("invalid --host %q: want <entity>=<host>", pair))
+				}
+				opts.Hosts[name] = host
+			}
+
+			results, deployErr := wedev.NewRemoteDeployer(app.storage).Deploy(cmd.Context(), networkName, args, opts)
+			if results == nil {
+				return fmt.Errorf("failed to deploy configs: %w", deployErr)
+			}
+
+			switch output {
+			case "json":
+				if err := printJSON(out, results); err != nil {
+					return err
+				}
+			case "yaml":
+				if err := printYAML(out, results); err != nil {
+					return err
+				}
+			default:
+				counts := map[wedev.DeployStatus]int{}
+				rows := make([][]string, 0, len(results))
+				for _, r := range results {
+					counts[r.Status]++
+					host := r.Host
+					if host == "" {
+						host = "-"
+					}
+					rows = append(rows, []string{r.Entity, host, strconv.Itoa(r.Version), string(r.Status), r.Detail})
+				}
+				printTable(out, []string{"Entity", "Host", "Version", "Status", "Detail"}, rows)
+				fmt.Fprintf(out, "\n%d succeeded, %d skipped, %d failed\n",
+					counts[wedev.DeploySucceeded], counts[wedev.DeploySkipped], counts[wedev.DeployFailed])
+			}
+
+			if errors.Is(deployErr, context.Canceled) {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Interrupted: hosts not yet started were skipped; finished deploys are recorded")
+			}
+			if deployErr != nil {
+				return fmt.Errorf("failed to deploy configs: %w", deployErr)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	cmd.Flags().String("interface", "", "WireGuard interface name on the hosts (default: network name)")
+	cmd.Flags().String("config-dir", wedev.DefaultWireGuardDir, "Directory on the hosts to write <interface>.conf into")
+	cmd.Flags().String("user", "", "SSH login user (default: from your ssh config)")
+	cmd.Flags().StringArray("host", nil, "SSH host for an entity as <entity>=<host>, instead of its public address (repeatable)")
+	cmd.Flags().Int("parallel", wedev.DefaultDeployParallel, "Number of hosts to deploy at once")
+	cmd.Flags().Duration("timeout", wedev.DefaultDeployTimeout, "Time limit for deploying to one host")
+	cmd.Flags().Bool("force", false, "Also deploy hosts whose recorded deployment is current")
+
+	return cmd
+}
+
+// makeNetworkValidateCommand creates the 'vn <network> validate' command.
+func makeNetworkValidateCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "validate [--strict] [--output table|json|yaml]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Check the network's servers and nodes for conflicts",
+		Long: fmt.Sprintf(`Check the servers and nodes of network '%s' for problems that produce
+broken configs and report every finding.
+
+Errors: a network CIDR that does not parse or is not IPv4, a public key or
+virtual IP used by more than one server or node, a virtual IP outside the
+network's CIDR, a peer node without a public address, a node assigned to a
+server that no longer exists, and routed CIDRs on a non-route node or
+overlapping the network or another node's routes.
+
+Warnings: a public endpoint (address:port) shared by several servers or
+nodes, which is only right when they sit behind one NAT address with port
+forwarding, and a network without a server.
+
+Exits non-zero when any error is found, or any finding at all with --strict.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			strict, err := cmd.Flags().GetBool("strict")
+			if err != nil {
+				return fmt.Errorf("failed to get strict flag: %w", err)
+			}
+
+			report, err := app.vnManager.ValidateNetwork(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to validate network: %w", err)
+			}
+
+			switch output {
+			case "json":
+				err = printJSON(out, report)
+			case "yaml":
+				err = printYAML(out, report)
+			default:
+				if len(report.Findings) == 0 {
+					fmt.Fprintf(out, "Network %s passed validation\n", report.Network)
+					break
+				}
+				rows := make([][]string, 0, len(report.Findings))
+				for _, f := range report.Findings {
+					rows = append(rows, []string{string(f.Severity), f.Rule, f.Message})
+				}
+				printTable(out, []string{"Severity", "Rule", "Details"}, rows)
+			}
+			if err != nil {
+				return err
+			}
+
+			if errs := report.Errors(); errs > 0 || (strict && report.Warnings() > 0) {
+				return withKind(wedev.ErrValidation, fmt.Errorf("validation found %d error(s) and %d warning(s)", errs, report.Warnings()))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("strict", false, "Fail on warnings as well as errors")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// makeNetworkCheckCommand creates the 'vn <network> check' command.
+func makeNetworkCheckCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [--strict] [--output table|json|yaml]",
+		Short: "Check that endpoint hostnames still resolve as they did",
+		Long: fmt.Sprintf(`Look up each server and node of network '%s' whose public address is a
+hostname, such as a dynamic DNS name, and flag those that do not resolve or
+resolve to other addresses than at the last check: configs generated before
+may point peers at a stale endpoint. The addresses each hostname resolves to
+are stored for the next check.
+
+Servers and nodes whose public address was changed %d or more times within
+%g hours are flagged as flapping; see 'server history --addresses' and
+'node history --addresses'.
+
+Exits non-zero with --strict when anything is flagged.`, networkName, wedev.FlappingChanges, wedev.FlappingWindow.Hours()),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			strict, err := cmd.Flags().GetBool("strict")
+			if err != nil {
+				return fmt.Errorf("failed to get strict flag: %w", err)
+			}
+
+			report, err := app.vnManager.CheckEndpoints(cmd.Context(), networkName, nil)
+			if err != nil {
+				return fmt.Errorf("failed to check endpoints: %w", err)
+			}
+
+			switch output {
+			case "json":
+				err = printJSON(out, report)
+			case "yaml":
+				err = printYAML(out, report)
+			default:
+				if len(report.Findings) == 0 {
+					fmt.Fprintf(out, "Network %s: %d hostname(s) resolve as at the last check\n", report.Network, report.Resolved)
+					break
+				}
+				rows := make([][]string, 0, len(report.Findings))
+				for _, f := range report.Findings {
+					rows = append(rows, []string{f.Rule, f.Entity, f.Message})
+				}
+				printTable(out, []string{"Rule", "Entity", "Details"}, rows)
+			}
+			if err != nil {
+				return err
+			}
+
+			if strict && len(report.Findings) > 0 {
+				return withKind(wedev.ErrValidation, fmt.Errorf("endpoint check flagged %d address(es)", len(report.Findings)))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("strict", false, "Fail when any address is flagged")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// checkEndpoint warns on stderr when another server or node of the network
+// already uses the endpoint publicAddress:port, or fails with --strict.
+func checkEndpoint(app *App, cmd *cobra.Command, networkName, entityName, publicAddress string, port int) error {
+	strict, err := cmd.Flags().GetBool("strict")
+	if err != nil {
+		return fmt.Errorf("failed to get strict flag: %w", err)
+	}
+	if err := app.vnManager.CheckEndpointAvailable(networkName, entityName, publicAddress, port); err != nil {
+		if strict {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+	}
+	return nil
+}
+
+// strictEndpointFlag declares the --strict flag read by checkEndpoint.
+func strictEndpointFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("strict", false, "Fail instead of warning when the endpoint is already in use")
+}
+
+// changedBoolFlag returns a pointer to the value of a bool flag, or nil when
+// it was not given.
+func changedBoolFlag(cmd *cobra.Command, name string) (*bool, error) {
+	if !cmd.Flags().Changed(name) {
+		return nil, nil
+	}
+	value, err := cmd.Flags().GetBool(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s flag: %w", name, err)
+	}
+	return &value, nil
+}
+
+// ignoreConflictFlag declares the --ignore-conflict flag of 'node edit' and
+// 'server edit'.
+func ignoreConflictFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("ignore-conflict", false, "Write the edit even if another process changed the record since it was read")
+}
+
+// endpointPreferenceFlag declares the --endpoint-preference flag of 'node
+// add' and 'node edit'.
+func endpointPreferenceFlag(cmd *cobra.Command) {
+	cmd.Flags().String("endpoint-preference", "", "Server address to dial: primary, secondary, or round-robin over all of them")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.RegisterFlagCompletionFunc("endpoint-preference", cobra.FixedCompletions(
+		[]string{string(wedev.EndpointPrimary), string(wedev.EndpointSecondary), string(wedev.EndpointRoundRobin)}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// changedEndpointPreference returns the --endpoint-preference given, or nil
+// when the flag was not.
+func changedEndpointPreference(cmd *cobra.Command) (*wedev.EndpointPreference, error) {
+	if !cmd.Flags().Changed("endpoint-preference") {
+		return nil, nil
+	}
+	value, err := cmd.Flags().GetString("endpoint-preference")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoint-preference flag: %w", err)
+	}
+	preference, err := wedev.ParseEndpointPreference(value)
+	if err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+// additionalAddressesFlag returns the server addresses given with
+// --additional-address, without the empty string that clears them.
+func additionalAddressesFlag(cmd *cobra.Command) ([]string, error) {
+	addresses, err := cmd.Flags().GetStringSlice("additional-address")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get additional-address flag: %w", err)
+	}
+	return slices.DeleteFunc(addresses, func(address string) bool { return address == "" }), nil
+}
+
+// formatAdditionalAddresses lists a server's additional endpoints.
+func formatAdditionalAddresses(server *wedev.Server) string {
+	endpoints := make([]string, len(server.AdditionalAddresses))
+	for i, address := range server.AdditionalAddresses {
+		endpoints[i] = util.FormatEndpoint(address, server.Port)
+	}
+	return strings.Join(endpoints, ", ")
+}
+
+// ========== Status Commands ==========
+
+// makeStatusCommand creates the 'status' command for a specific network
+func makeStatusCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "status [--interface <name>] [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show live WireGuard peer status",
+		Long: fmt.Sprintf(`Show which peers are connected on the local WireGuard interface for
+network '%s', using 'wg show <interface> dump'.
+
+Peers are matched to the network's server and nodes by public key. Keys not
+stored for the network are flagged 'unmanaged'; stored entities missing from
+the interface are flagged 'not connected'. --no-header prints only the peer
+rows.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			iface, err := cmd.Flags().GetString("interface")
+			if err != nil {
+				return fmt.Errorf("failed to get interface flag: %w", err)
+			}
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return fmt.Errorf("failed to get output flag: %w", err)
+			}
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+
+			status, err := wedev.NewWireGuardStatusReader(app.storage).StatusCtx(cmd.Context(), networkName, iface)
+			if err != nil {
+				return fmt.Errorf("failed to read status: %w", err)
+			}
+
+			switch output {
+			case "json":
+				data, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode status: %w", err)
+				}
+				fmt.Fprintln(out, string(data))
+				return nil
+			case "yaml":
+				return printYAML(out, status)
+			}
+
+			noHeader, err := noHeaderFlag(cmd)
+			if err != nil {
+				return err
+			}
+			if !noHeader {
+				fmt.Fprintf(out, "Interface: %s\n", status.Interface)
+				if status.Self != "" {
+					fmt.Fprintf(out, "Local entity: %s\n", status.Self)
+				}
+				fmt.Fprintln(out)
+			}
+			rows := make([][]string, 0, len(status.Peers))
+			for _, p := range status.Peers {
+				name := p.Name
+				if name == "" {
+					name = p.PublicKey[:min(len(p.PublicKey), 12)] + "..."
+				}
+				endpoint := p.Endpoint
+				if endpoint == "" {
+					endpoint = "-"
+				}
+				handshake := "-"
+				if p.LatestHandshake != nil {
+					handshake = time.Since(*p.LatestHandshake).Round(time.Second).String() + " ago"
+				}
+				rows = append(rows, []string{name, endpoint, handshake, strconv.FormatInt(p.TransferRx, 10), strconv.FormatInt(p.TransferTx, 10), p.State})
+			}
+			return printListTable(cmd, out, "No peers found", []string{"Name", "Endpoint", "Handshake", "Received", "Sent", "State"}, rows)
+		},
+	}
+
+	cmd.Flags().String("interface", "", "WireGuard interface name (default: network name)")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// ========== IP Pool Commands ==========
+
+// makeIPCommand creates the 'ip' command group for a specific network
+func makeIPCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ip",
+		Short: "List, reserve, check and repair the network's IP addresses",
+	}
+
+	cmd.AddCommand(makeIPListCommand(app, networkName))
+	cmd.AddCommand(makeIPReserveCommand(app, networkName))
+	cmd.AddCommand(makeIPUnreserveCommand(app, networkName))
+	cmd.AddCommand(makeIPAuditCommand(app, networkName))
+	cmd.AddCommand(makeIPRepairCommand(app, networkName))
+
+	return cmd
+}
+
+// makeIPAuditCommand creates the 'ip audit' command
+func makeIPAuditCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "audit",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Compare the IP pool state with the server and node addresses",
+		Long: fmt.Sprintf(`Compare the saved IP pool state of network '%s' with the virtual IPs of
+its server and nodes and report every discrepancy: allocations no node holds,
+node addresses the pool does not record (and could hand out again), and
+duplicate addresses, which are data corruption.
+
+Exits non-zero when any discrepancy is found, so it can be used in monitoring.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := app.vnManager.AuditIPPool(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to audit IP pool: %w", err)
+			}
+			if err := printIPAuditReport(out, report, output); err != nil {
+				return err
+			}
+
+			if len(report.Issues) > 0 {
+				return fmt.Errorf("IP pool audit found %d issue(s)", len(report.Issues))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// makeIPRepairCommand creates the 'ip repair' command
+func makeIPRepairCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Rebuild the IP pool state from the server and node addresses",
+		Long: fmt.Sprintf(`Rebuild the saved IP pool state of network '%s' from the virtual IPs of
+its server and nodes, which are authoritative, if 'ip audit' finds drift.
+
+Duplicate addresses cannot be repaired this way: delete and re-add all but one
+of the nodes sharing an address. The command exits non-zero while any remain.`, networkName),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := app.vnManager.RepairIPPool(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to repair IP pool: %w", err)
+			}
+			if err := printIPAuditReport(out, report, output); err != nil {
+				return err
+			}
+			if output == "table" && report.Repaired {
+				fmt.Fprintln(out, "IP pool state rebuilt from the server and node records")
+			}
+
+			if dups := report.Duplicates(); len(dups) > 0 {
+				return fmt.Errorf("%d duplicate IP(s) remain; delete and re-add the affected nodes", len(dups))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// makeIPListCommand creates the 'ip list' command
+func makeIPListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List allocated, recycled and reserved addresses",
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			listing, err := app.vnManager.ListIPs(networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list IPs: %w", err)
+			}
+			switch output {
+			case "json":
+				return printJSON(out, listing)
+			case "yaml":
+				return printYAML(out, listing)
+			}
+
+			noHeader, err := noHeaderFlag(cmd)
+			if err != nil {
+				return err
+			}
+			if !noHeader {
+				fmt.Fprintf(out, "Network: %s (%s)\n", listing.Network, listing.CIDR)
+				fmt.Fprintf(out, "Addresses: %s\n\n", formatPoolUsage(&listing.Pool))
+			}
+
+			rows := make([][]string, 0, len(listing.Allocated)+len(listing.Recycled)+len(listing.Reserved))
+			for _, holder := range listing.Allocated {
+				holderName := holder.Kind + " " + holder.Name
+				if holder.Status != "" {
+					holderName += " (" + holder.Status + ")"
+				}
+				rows = append(rows, []string{holder.IP, "allocated", holderName})
+			}
+			for _, ip := range listing.Recycled {
+				rows = append(rows, []string{ip, "recycled", "-"})
+			}
+			for _, r := range listing.Reserved {
+				rows = append(rows, []string{r, "reserved", "-"})
+			}
+			return printListTable(cmd, out, "No addresses in use", []string{"Address", "State", "Holder"}, rows)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// makeIPReserveCommand creates the 'ip reserve' command
+func makeIPReserveCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "reserve <cidr-or-range>",
+		Short: "Keep a range of addresses from being allocated",
+		Long: fmt.Sprintf(`Keep a range of the addresses of network '%s' from being allocated to
+servers and nodes, for instance because other infrastructure uses them.
+
+The range is a CIDR, a first-last range or a single address. A range holding
+the address of the server or a node is refused with their names.
+
+Examples:
+  wedevctl vn %[1]s ip reserve 10.0.0.64/28
+  wedevctl vn %[1]s ip reserve 10.0.0.10-10.0.0.20
+  wedevctl vn %[1]s ip reserve 10.0.0.5`, networkName),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := app.vnManager.ReserveIPRange(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to reserve IP range: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Reserved %s (%d addresses) in network '%s'\n", r, r.Size(), networkName)
+			return nil
+		},
+	}
+}
+
+// makeIPUnreserveCommand creates the 'ip unreserve' command
+func makeIPUnreserveCommand(app *App, networkName string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "unreserve <cidr-or-range>",
+		Short:             "Make a reserved range available for allocation again",
+		Long:              "Remove a reservation made with 'ip reserve'. The range must name the same addresses; see 'ip list'.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReservedRanges(networkName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := app.vnManager.UnreserveIPRange(networkName, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to unreserve IP range: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Unreserved %s in network '%s'\n", r, networkName)
+			return nil
+		},
+	}
+}
+
+// outputFlag reads and validates the --output flag of the 'ip',
+// 'server list' and 'config history' commands.
+func outputFlag(cmd *cobra.Command) (string, error) {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return "", fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if err := validateOutput(output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// validateOutput reports an error unless output is a supported --output format.
+func validateOutput(output string) error {
+	switch output {
+	case "table", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("invalid output format: %s (must be 'table', 'json', or 'yaml')", output)
+}
+
+// printIPAuditReport prints an IP pool audit as a table, JSON or YAML.
+func printIPAuditReport(w io.Writer, report *wedev.IPAuditReport, output string) error {
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode audit report: %w", err)
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	case "yaml":
+		return printYAML(w, report)
+	}
+
+	if len(report.Issues) == 0 {
+		fmt.Fprintf(w, "IP pool state of network %s matches its records\n", report.Network)
+		return nil
+	}
+	fmt.Fprintf(w, "%-20s %-16s %s\n", "Issue", "IP", "Details")
+	fmt.Fprintln(w, "--------------------------------------------------------------------------------")
+	for _, issue := range report.Issues {
+		ip := issue.IP
+		if ip == "" {
+			ip = "-"
+		}
+		fmt.Fprintf(w, "%-20s %-16s %s\n", issue.Kind, ip, issue.Message)
+	}
+	return nil
+}
+
+// makeGroupCommand creates the 'group' command group for a specific network
+func makeGroupCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "group",
+		Short: "Show node groups (nodes sharing a \"group\" label)",
+		Long: `A node group is the nodes sharing a value of the "group" label, set with
+'node add --group' or 'node edit --group'. Commands taking --selector target
+a group with --selector group=<name>.`,
+	}
+
+	cmd.AddCommand(makeGroupListCommand(app, networkName))
+
+	return cmd
+}
+
+// makeGroupListCommand creates the 'group list' command
+func makeGroupListCommand(app *App, networkName string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "list [--output table|json|yaml] [--columns <list>] [--no-header]",
+		Aliases:     []string{"ls"},
+		Annotations: readOnlyAnnotations(),
+		Short:       "List node groups with their member counts",
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			groups, err := app.vnManager.ListNodeGroupsCtx(cmd.Context(), networkName)
+			if err != nil {
+				return fmt.Errorf("failed to list groups: %w", err)
+			}
+			switch output {
+			case "json":
+				return printJSON(out, groups)
+			case "yaml":
+				return printYAML(out, groups)
+			}
+
+			rows := make([][]string, 0, len(groups))
+			for _, group := range groups {
+				name := group.Name
+				if name == "" {
+					name = "(none)"
+				}
+				rows = append(rows, []string{name, strconv.Itoa(len(group.Members)), strings.Join(group.Members, ", ")})
+			}
+			return printListTable(cmd, out, "No nodes found", []string{"Group", "Nodes", "Members"}, rows)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+	tableFlags(cmd)
+
+	return cmd
+}
+
+// ========== Apply Command ==========
+
+// NewApplyCommand creates the 'apply' command
+func NewApplyCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "apply -f <spec.yaml> [--dry-run | --yes] [--prune] [--message <text>]",
+		Annotations: withKeys(nil),
+		Short:       "Make a network match a spec file",
+		Long: `Reconcile a network with a YAML spec describing it, so the desired
+topology can be kept in version control.
+
+The spec names the network, its CIDR, DNS servers, default port, topology
+and labels, its server (or servers) and its nodes:
+
+  name: office
+  cidr: 10.8.0.0/24
+  dns: [10.8.0.1]
+  server:
+    name: hub
+    public_address: vpn.example.com
+  nodes:
+    - name: laptop
+      type: peer
+      public_address: 203.0.113.7
+      labels: {team: ops}
+    - name: branch
+      type: route
+      routed_cidrs: [192.168.10.0/24]
+
+Keys and virtual IPs are never part of the spec: existing entities keep
+theirs and new ones get generated ones. Ports, default_port and topology
+may be left out, in which case existing values are kept.
+
+The plan of changes is printed first. --dry-run stops there, after making
+the changes to an in-memory copy of the network to show which configs they
+would change; otherwise --yes is required to make the changes. Servers and
+nodes missing from the spec are only deleted with --prune. If anything
+changed and the network has a server, a new configuration version is saved.
+
+Use '-f -' to read the spec from standard input.
+
+Examples:
+  wedevctl apply -f office.yaml --dry-run
+  wedevctl apply -f office.yaml --yes
+  wedevctl apply -f office.yaml --yes --prune -m "remove old laptops"`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return fmt.Errorf("failed to get file flag: %w", err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return fmt.Errorf("failed to get dry-run flag: %w", err)
+			}
+			yes, err := cmd.Flags().GetBool("yes")
+			if err != nil {
+				return fmt.Errorf("failed to get yes flag: %w", err)
+			}
+			prune, err := cmd.Flags().GetBool("prune")
+			if err != nil {
+				return fmt.Errorf("failed to get prune flag: %w", err)
+			}
+			message, err := cmd.Flags().GetString("message")
+			if err != nil {
+				return fmt.Errorf("failed to get message flag: %w", err)
+			}
+
+			var data []byte
+			if file == "-" {
+				data, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				data, err = os.ReadFile(file) // #nosec G304 -- the user names the spec file to read
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read spec: %w", err)
+			}
+			spec, err := wedev.ParseNetworkSpec(data)
+			if err != nil {
+				return err
+			}
+
+			plan, err := app.vnManager.PlanSpecCtx(cmd.Context(), spec, prune)
+			if err != nil {
+				return fmt.Errorf("failed to plan apply: %w", err)
+			}
+
+			if len(plan.Changes) == 0 {
+				fmt.Fprintf(out, "Network '%s' already matches the spec\n", plan.Network)
+			} else {
+				fmt.Fprintf(out, "Plan for network '%s':\n", plan.Network)
+				for _, change := range plan.Changes {
+					fmt.Fprintf(out, "  %s\n", change)
+				}
+			}
+			if len(plan.Unmanaged) > 0 {
+				fmt.Fprintf(out, "Not in the spec, kept (use --prune to delete): %s\n", strings.Join(plan.Unmanaged, ", "))
+			}
+			if len(plan.Changes) == 0 {
+				return nil
+			}
+			if dryRun {
+				if len(spec.Servers) == 0 {
+					return nil
+				}
+				version, created, err := app.vnManager.PreviewSpecCtx(cmd.Context(), spec, prune)
+				if err != nil {
+					return fmt.Errorf("failed to preview configs: %w", err)
+				}
+				if !created {
+					fmt.Fprintln(out, "\nConfigurations would be unchanged")
+					return nil
+				}
+				fmt.Fprintf(out, "\nConfiguration version %d would be saved\n", version.Version)
+				if len(version.Changed) > 0 {
+					fmt.Fprintf(out, "Configs that would change: %s\n", strings.Join(version.Changed, ", "))
+				}
+				return nil
+			}
+			if !yes {
+				return fmt.Errorf("re-run with --yes to apply this plan, or --dry-run to only show it")
+			}
+
+			if err := app.vnManager.ApplySpecCtx(cmd.Context(), plan); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "\nApplied %d change(s) to network '%s'\n", len(plan.Changes), plan.Network)
+
+			if len(spec.Servers) == 0 {
+				fmt.Fprintln(out, "No server in the spec, configuration version not saved")
+				return nil
+			}
+			version, created, err := app.generator.SaveConfigVersionWithMessageCtx(cmd.Context(), plan.Network, message)
+			if err != nil {
+				return fmt.Errorf("failed to save config version: %w", err)
+			}
+			if created {
+				fmt.Fprintf(out, "Configuration version %d saved\n", version.Version)
+				if version.Message != "" {
+					fmt.Fprintf(out, "Message: %s\n", version.Message)
+				}
+			} else {
+				fmt.Fprintln(out, "Configurations unchanged, version not updated")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Spec file to apply ('-' reads standard input)")
+	cmd.Flags().Bool("dry-run", false, "Print the plan without changing anything")
+	cmd.Flags().BoolP("yes", "y", false, "Apply the plan without further confirmation")
+	cmd.Flags().Bool("prune", false, "Delete servers and nodes that are not in the spec")
+	cmd.Flags().StringP("message", "m", "", "Why this version is being saved (recorded in config history)")
+	//nolint:errcheck // The flag is declared just above
+	_ = cmd.MarkFlagRequired("file")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "yes")
+
+	return cmd
+}
+
+// ========== Database Commands ==========
+
+// NewDBCommand creates the 'db' command group
+func NewDBCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the wedevctl database",
+	}
+
+	cmd.AddCommand(NewDBRepairCommand(app))
+	cmd.AddCommand(NewDBFsckCommand(app))
+	cmd.AddCommand(NewDBBackupCommand(app))
+	cmd.AddCommand(NewDBRestoreCommand(app))
+	cmd.AddCommand(NewDBInfoCommand(app))
+	cmd.AddCommand(NewDBMigrateCommand(app))
+	cmd.AddCommand(NewDBEncryptCommand(app))
+	cmd.AddCommand(NewDBDecryptCommand(app))
+
+	return cmd
+}
+
+// NewDBRepairCommand creates the 'db repair' command
+func NewDBRepairCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair",
+		Short: "Remove orphaned index entries from the database",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			removed, err := app.vnManager.RepairIndexes()
+			if err != nil {
+				return fmt.Errorf("failed to repair database: %w", err)
+			}
+
+			if removed == 0 {
+				fmt.Fprintln(out, "No orphaned index entries found")
+				return nil
+			}
+			fmt.Fprintf(out, "Removed %d orphaned index entries\n", removed)
+			return nil
+		},
+	}
+}
+
+// NewDBFsckCommand creates the 'db fsck' command
+func NewDBFsckCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fsck [--fix]",
+		Short: "Check the database for referential integrity problems",
+		Long: `Check the whole database for referential integrity problems: index entries
+that do not resolve to a matching record, servers, nodes and IP pools whose
+network no longer exists, virtual IPs held by more than one server or node of
+a network, config versions whose network no longer exists, and networks
+whose CIDR does not parse or is not IPv4, as older builds could store.
+
+With --fix the problems are repaired in one transaction: orphaned entries and
+records are deleted, and of the holders of a duplicate virtual IP a server
+(or else the oldest node) keeps it while the others get free addresses.
+Regenerate and redistribute the configs of reassigned servers and nodes.
+An invalid CIDR is not repaired: set a valid one with 'vn <network> edit
+--cidr'.
+
+Without --fix the command exits non-zero when any problem is found, so it can
+be run from cron.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			fix, err := cmd.Flags().GetBool("fix")
+			if err != nil {
+				return fmt.Errorf("failed to get fix flag: %w", err)
+			}
+
+			var report *wedev.IntegrityReport
+			if fix {
+				report, err = app.vnManager.FixIntegrity()
+			} else {
+				report, err = app.vnManager.CheckIntegrity()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to check database: %w", err)
+			}
+			if err := printIntegrityReport(out, report, output); err != nil {
+				return err
+			}
+
+			if report.Repairable() > 0 && !report.Fixed {
+				return fmt.Errorf("database check found %d problem(s); re-run with --fix to repair them", report.Problems())
+			}
+			if len(report.InvalidCIDRs) > 0 {
+				return fmt.Errorf("database check found %d network(s) with an invalid CIDR; set a valid one with 'vn <network> edit --cidr'", len(report.InvalidCIDRs))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("fix", false, "Repair the problems found")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// printIntegrityReport prints a database integrity report as a table, JSON
+// or YAML.
+func printIntegrityReport(w io.Writer, report *wedev.IntegrityReport, output string) error {
+	switch output {
+	case "json":
+		return printJSON(w, report)
+	case "yaml":
+		return printYAML(w, report)
+	}
+
+	if report.Problems() == 0 {
+		fmt.Fprintln(w, "No integrity problems found")
+		printClockSkewedConfigs(w, report)
+		return nil
+	}
+	fmt.Fprintf(w, "%-20s %-20s %s\n", "Problem", "Bucket", "Details")
+	fmt.Fprintln(w, "--------------------------------------------------------------------------------")
+	for _, rec := range report.OrphanedIndexKeys {
+		fmt.Fprintf(w, "%-20s %-20s %s\n", "orphaned_index_key", rec.Bucket, rec.Key)
+	}
+	for _, rec := range report.DanglingReferences {
+		details := fmt.Sprintf("%s references missing network %s", rec.Key, rec.NetworkID)
+		if rec.NetworkID == "" {
+			details = fmt.Sprintf("%s references a missing server or node", rec.Key)
+		} else if rec.Name != "" {
+			details = fmt.Sprintf("%s (%s) references missing network %s", rec.Name, rec.Key, rec.NetworkID)
+		}
+		fmt.Fprintf(w, "%-20s %-20s %s\n", "dangling_reference", rec.Bucket, details)
+	}
+	for _, dup := range report.DuplicateVirtualIPs {
+		details := fmt.Sprintf("%s in network %s held by %s", dup.IP, dup.Network, strings.Join(dup.Holders, ", "))
+		fmt.Fprintf(w, "%-20s %-20s %s\n", "duplicate_ip", "-", details)
+		for _, holder := range dup.Holders[1:] {
+			if ip, ok := dup.Reassigned[holder]; ok {
+				fmt.Fprintf(w, "%-20s %-20s %s moved to %s\n", "", "", holder, ip)
+			}
+		}
+	}
+	for _, rec := range report.OrphanedConfigs {
+		fmt.Fprintf(w, "%-20s %-20s %s %s of missing network %s\n", "orphaned_config", rec.Bucket, rec.Key, rec.Name, rec.NetworkID)
+	}
+	for _, dup := range report.DuplicateConfigVersions {
+		details := fmt.Sprintf("%s v%d of network %s saved %s", dup.ConfigID, dup.Version, dup.Network, dup.CreatedAt.Format(time.RFC3339))
+		if dup.RenumberedTo != 0 {
+			details += fmt.Sprintf(", renumbered to v%d", dup.RenumberedTo)
+		}
+		fmt.Fprintf(w, "%-20s %-20s %s\n", "duplicate_version", wedev.BucketConfigs, details)
+	}
+	for _, inv := range report.InvalidCIDRs {
+		fmt.Fprintf(w, "%-20s %-20s network %s has invalid CIDR '%s'\n", "invalid_cidr", wedev.BucketNetworks, inv.Network, inv.CIDR)
+	}
+	if report.Fixed {
+		fmt.Fprintf(w, "\nRepaired %d problem(s)\n", report.Repairable())
+	}
+	printClockSkewedConfigs(w, report)
+	return nil
+}
+
+// printClockSkewedConfigs notes the config versions saved earlier than the
+// version before them. They need no repair.
+func printClockSkewedConfigs(w io.Writer, report *wedev.IntegrityReport) {
+	if len(report.ClockSkewedConfigs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nSaved earlier than the version before them (clock skew; versions are ordered by number):")
+	for _, rec := range report.ClockSkewedConfigs {
+		fmt.Fprintf(w, "  %s (%s) of network %s\n", rec.Name, rec.Key, rec.NetworkID)
+	}
+}
+
+// NewDBBackupCommand creates the 'db backup' command
+func NewDBBackupCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:         "backup <file>",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Write a consistent copy of the database to a file",
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			written, err := app.storage.Backup(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to back up database: %w", err)
+			}
+
+			fmt.Fprintf(out, "Database backed up to %s (%d bytes)\n", args[0], written)
+			return nil
+		},
+	}
+}
+
+// NewDBRestoreCommand creates the 'db restore' command
+func NewDBRestoreCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Replace the database with a backup",
+		Long: `Replace the database with a backup created by 'db backup'.
+
+The backup is validated before anything is changed, and the restore refuses
+to run while another wedevctl process is using the database.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			backupPath := args[0]
+
+			yes, err := cmd.Flags().GetBool("yes")
+			if err != nil {
+				return fmt.Errorf("failed to get yes flag: %w", err)
+			}
+
+			if err := wedev.ValidateBackup(backupPath); err != nil {
+				return fmt.Errorf("failed to restore database: %w", err)
+			}
+
+			if !yes && !confirmAction(cmd, fmt.Sprintf("Replace database %s with %s? All current data will be lost.", app.dbPath, backupPath), assumeFor(app, promptOverwrite)) {
+				fmt.Fprintln(out, "Cancelled")
+				return nil
+			}
+
+			// Release this process's own lock before swapping the file.
+			if err := app.close(); err != nil {
+				return fmt.Errorf("failed to close database: %w", err)
+			}
+
+			if err := wedev.RestoreDatabase(app.dbPath, backupPath); err != nil {
+				return fmt.Errorf("failed to restore database: %w", err)
+			}
+
+			fmt.Fprintf(out, "Database restored from %s\n", backupPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("yes", false, "Skip the confirmation prompt")
+
+	return cmd
+}
+
+// NewDBInfoCommand creates the 'db info' command
+func NewDBInfoCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:         "info",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Show database path, size, and record counts",
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			info, err := app.storage.Info()
+			if err != nil {
+				return fmt.Errorf("failed to read database info: %w", err)
+			}
+
+			fmt.Fprintf(out, "Path: %s\n", info.Path)
+			fmt.Fprintf(out, "Size: %d bytes\n", info.Size)
+			if app.storage.Encrypted() {
+				fmt.Fprintln(out, "Private keys: encrypted")
+			} else {
+				fmt.Fprintln(out, "Private keys: plain")
+			}
+			fmt.Fprintln(out)
+
+			names := make([]string, 0, len(info.Buckets))
+			for name := range info.Buckets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			rows := make([][]string, 0, len(names))
+			for _, name := range names {
+				rows = append(rows, []string{name, strconv.Itoa(info.Buckets[name])})
+			}
+			printTable(out, []string{"Bucket", "Records"}, rows)
+
+			return nil
+		},
+	}
+}
+
+// NewDBMigrateCommand creates the 'db migrate' command
+func NewDBMigrateCommand(app *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Show or apply database schema migrations",
+		Long: `Bring the database schema up to date.
+
+Pending migrations are applied automatically whenever the database is opened,
+so this command mainly reports the schema state. Use --status to list every
+migration and whether it has been applied.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			out := cmd.OutOrStdout()
+
+			status, err := cmd.Flags().GetBool("status")
+			if err != nil {
+				return fmt.Errorf("failed to get status flag: %w", err)
+			}
+
+			if !status {
+				version, err := app.storage.SchemaVersion()
+				if err != nil {
+					return fmt.Errorf("failed to read schema version: %w", err)
+				}
+				fmt.Fprintf(out, "Database schema is up to date (version %d)\n", version)
+				return nil
+			}
+
+			states, err := app.storage.MigrationStatus()
+			if err != nil {
+				return fmt.Errorf("failed to read migration status: %w", err)
+			}
+
+			rows := make([][]string, 0, len(states))
+			for _, s := range states {
+				state, appliedAt := "pending", "-"
+				if s.Applied {
+					state = "applied"
+					if !s.AppliedAt.IsZero() {
+						appliedAt = s.AppliedAt.Format(time.RFC3339)
+					}
+				}
+				rows = append(rows, []string{strconv.Itoa(s.Version), state, appliedAt, s.Description})
+			}
+			printTable(out, []string{"Version", "Status", "Applied At", "Description"}, rows)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("status", false, "List applied and pending migrations")
+
+	return cmd
+}
+
+// NewDBEncryptCommand creates the 'db encrypt' command
+func NewDBEncryptCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the private keys stored in the database with a passphrase",
+		Long: `Encrypt the private key of every server and node, and the private and
+preshared keys in every saved config version, with AES-256-GCM under a key
+derived from a passphrase with argon2id.
+
+The passphrase is read from --passphrase-file or $WEDEVCTL_PASSPHRASE, or
+asked for twice. Afterwards, commands that need private keys (adding servers
+and nodes, generating, showing, exporting or applying configs, and
+--show-secrets) read it the same way; other commands, such as listing, need
+none. A wrong passphrase fails rather than yield wrong keys (exit code 8).
+
+Backups taken before encrypting still hold the keys in plain. Use
+'db decrypt' to store them in plain again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			if app.storage.Encrypted() {
+				return withKind(wedev.ErrValidation, fmt.Errorf("the database is already encrypted"))
+			}
+			passphrase, err := app.readPassphrase(cmd, true)
+			if err != nil {
+				return err
+			}
+			count, err := app.storage.EncryptKeys(passphrase)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt private keys: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Encrypted %d private key(s); commands that need them now ask for the passphrase\n", count)
+			return nil
+		},
+	}
+}
+
+// NewDBDecryptCommand creates the 'db decrypt' command
+func NewDBDecryptCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:         "decrypt",
+		Annotations: withKeys(nil),
+		Short:       "Store the private keys of an encrypted database in plain again",
+		Long: `Decrypt every private and preshared key 'db encrypt' encrypted and drop the
+passphrase, which is read from --passphrase-file or $WEDEVCTL_PASSPHRASE, or
+asked for.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _args []string) error {
+			if !app.storage.Encrypted() {
+				return withKind(wedev.ErrValidation, fmt.Errorf("the database is not encrypted"))
+			}
+			count, err := app.storage.DecryptKeys()
+			if err != nil {
+				return fmt.Errorf("failed to decrypt private keys: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Decrypted %d private key(s)\n", count)
+			return nil
+		},
+	}
+}
+
+// ========== Doctor ==========
+
+// NewDoctorCommand creates the 'doctor' command: a health check of the
+// database and every network, for diagnosing problems and reporting bugs.
+func NewDoctorCommand(_ *App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor [--network <name>] [--resolve] [--bundle <file>]",
+		Short: "Check the database and networks for problems",
+		Long: `Run a battery of checks and print PASS, WARN or FAIL for each, with a hint
+on how to fix what is not a PASS:
+
+  database   the database opens and its schema is up to date
+  buckets    every bucket is present
+  integrity  no orphaned or dangling records (see 'db fsck')
+  ip_pool    each network's IP pool state matches its servers and nodes
+  keys       each key is a WireGuard key and matches its private key
+  validate   each network's records are consistent (see 'vn <network> validate')
+  endpoints  with --resolve, each endpoint hostname resolves
+
+The database is opened read-only and never created or migrated, so doctor
+also diagnoses one that other commands fail to open. --network limits the
+per-network checks to one network.
+
+With --bundle the JSON report and an export of the database are written to a
+.tar.gz file to attach to a bug report. Private keys, preshared keys and the
+keys in stored configs are redacted from the export; names, addresses and
+public keys are not.
+
+The command exits non-zero when any check fails.`,
+		Args: cobra.NoArgs,
+		// The database is opened by the checks themselves, read-only, so
+		// a missing or outdated one is reported instead of created or
+		// migrated.
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			network, err := cmd.Flags().GetString("network")
+			if err != nil {
+				return fmt.Errorf("failed to get network flag: %w", err)
+			}
+			resolve, err := cmd.Flags().GetBool("resolve")
+			if err != nil {
+				return fmt.Errorf("failed to get resolve flag: %w", err)
+			}
+			bundle, err := cmd.Flags().GetString("bundle")
+			if err != nil {
+				return fmt.Errorf("failed to get bundle flag: %w", err)
+			}
+
+			flag, err := dbFlag(cmd, args)
+			if err != nil {
+				return err
+			}
+			dbPath, err := resolveDBPath(flag)
+			if err != nil {
+				return err
+			}
+			timeout, err := dbTimeout(cmd, args)
+			if err != nil {
+				return err
+			}
+			level, err := logLevel(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			report, err := wedev.Doctor(cmd.Context(), dbPath, wedev.DoctorOptions{
+				Storage: wedev.StorageOptions{LockTimeout: timeout, Logger: wedev.NewLogger(cmd.ErrOrStderr(), level)},
+				Network: network,
+				Resolve: resolve,
+				Bundle:  bundle != "",
+			})
+			if err != nil {
+				return err
+			}
+			if bundle != "" {
+				if err := wedev.WriteDoctorBundle(bundle, report); err != nil {
+					return fmt.Errorf("failed to write bundle: %w", err)
+				}
+			}
+
+			if err := printDoctorReport(out, report, output); err != nil {
+				return err
+			}
+			if bundle != "" && output == "table" {
+				fmt.Fprintf(out, "\nWrote bug report bundle to %s\n", bundle)
+			}
+
+			if failed := report.Count(wedev.DoctorFail); failed > 0 {
+				return fmt.Errorf("doctor found %d failing check(s)", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("network", "", "Limit the per-network checks to this network")
+	cmd.Flags().Bool("resolve", false, "Check that endpoint hostnames resolve")
+	cmd.Flags().String("bundle", "", "Write the report and a redacted database export to this .tar.gz file")
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// printDoctorReport prints a doctor report as a list of checks, JSON or
+// YAML.
+func printDoctorReport(w io.Writer, report *wedev.DoctorReport, output string) error {
+	switch output {
+	case "json":
+		return printJSON(w, report)
+	case "yaml":
+		return printYAML(w, report)
+	}
+
+	fmt.Fprintf(w, "Database: %s\n\n", report.Database)
+	for _, check := range report.Checks {
+		name := check.Name
+		if check.Network != "" {
+			name += " (" + check.Network + ")"
+		}
+		fmt.Fprintf(w, "%-5s %-28s %s\n", check.Status, name, check.Message)
+		for _, detail := range check.Details {
+			fmt.Fprintf(w, "      - %s\n", detail)
+		}
+		if check.Hint != "" {
+			fmt.Fprintf(w, "      hint: %s\n", check.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warning(s), %d failed\n",
+		report.Count(wedev.DoctorPass), report.Count(wedev.DoctorWarn), report.Count(wedev.DoctorFail))
+	return nil
+}
+
+// ========== Version ==========
+
+// NewJoinCommand creates the 'join' command, which installs a node bundle
+// on the local machine. It does not use the database.
+func NewJoinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "join <bundle> [--config-dir <dir>] [--force] [--dry-run]",
+		Short: "Install a node bundle on this machine",
+		Long: `Install a bundle written by 'vn <network> node bundle', doing natively what
+its install.sh does: the config is checked against the checksum in the
+bundle's metadata, written to <config-dir>/<interface>.conf readable by root
+only, and the interface is enabled and (re)started with systemd's
+wg-quick@<interface> unit, or with wg-quick where there is no systemd.
+
+An existing config with other content is only replaced with --force.
+Requires root and wireguard-tools unless --dry-run is given. The database is
+not used, so this runs on machines that only received the bundle.
+
+Examples:
+  sudo wedevctl join laptop.tar.gz
+  wedevctl join laptop.tar.gz --dry-run`,
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			configDir, err := cmd.Flags().GetString("config-dir")
+			if err != nil {
+				return fmt.Errorf("failed to get config-dir flag: %w", err)
+			}
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return fmt.Errorf("failed to get force flag: %w", err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return fmt.Errorf("failed to get dry-run flag: %w", err)
+			}
+
+			bundle, err := wedev.ReadNodeBundle(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read bundle: %w", err)
+			}
+			installer := wedev.NewBundleInstaller()
+			plan := installer.Plan(bundle, configDir)
+
+			if dryRun {
+				fmt.Fprintf(out, "Bundle of node '%s' of network '%s' (SHA-256 verified)\n", bundle.Metadata.Node, bundle.Metadata.Network)
+				fmt.Fprintf(out, "Would write: %s\n", plan.ConfigPath)
+				fmt.Fprintln(out, "Would run:")
+				for _, c := range plan.Commands {
+					fmt.Fprintf(out, "  %s\n", strings.Join(c, " "))
+				}
+				return nil
+			}
+
+			if err := installer.Install(cmd.Context(), plan, force); err != nil {
+				return fmt.Errorf("failed to join network: %w", err)
+			}
+
+			fmt.Fprintf(out, "Wrote: %s\n", plan.ConfigPath)
+			fmt.Fprintf(out, "Joined network '%s' as node '%s' on interface '%s'\n", bundle.Metadata.Network, bundle.Metadata.Node, plan.Interface)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("config-dir", wedev.DefaultWireGuardDir, "Directory to write <interface>.conf into")
+	cmd.Flags().Bool("force", false, "Replace an existing config with other content")
+	cmd.Flags().Bool("dry-run", false, "Check the bundle and print what would be done without changing anything")
+
+	return cmd
+}
+
+// NewVersionCommand creates the 'version' command
+func NewVersionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version [--output <format>]",
+		Short: "Show the wedevctl version and database compatibility",
+		Long: `Show the version, git commit and build date of this binary, and the
+database it uses, with where it was found and its schema version. A
+database written by a newer wedevctl, which this one refuses to open, is
+flagged; an older one is migrated by the next command that writes.
+
+The database is read without being created or migrated.`,
+		Args: cobra.NoArgs,
+		// The schema version is read straight from the file, so a missing
+		// or newer database is reported instead of created or refused.
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := outputFlag(cmd)
+			if err != nil {
+				return err
+			}
+			flag, err := dbFlag(cmd, args)
+			if err != nil {
+				return err
+			}
+			env, err := currentDBEnvironment()
+			if err != nil {
+				return err
+			}
+			loc, err := resolveDBLocation(flag, env)
+			if err != nil {
+				return err
+			}
+			timeout, err := dbTimeout(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			report := versionReport{Info: version.Get(), Database: inspectDatabase(cmd.Context(), loc.path, timeout)}
+			report.Database.Source = loc.source
+			return printVersionReport(cmd.OutOrStdout(), report, output)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "table", "Output format (table, json, or yaml)")
+
+	return cmd
+}
+
+// Database compatibility states reported by 'version'.
+const (
+	dbStatusCurrent    = "current"    // at the schema version of this binary
+	dbStatusOutdated   = "outdated"   // migrated by the next command that writes
+	dbStatusNewer      = "newer"      // written by a newer binary; not opened
+	dbStatusMissing    = "missing"    // created by the next command that writes
+	dbStatusUnreadable = "unreadable" // see versionDatabase.Error
+)
+
+// dbSourceNames describes the database sources in the text of 'version'.
+var dbSourceNames = map[string]string{
+	dbSourceFlag:    "from --db",
+	dbSourceEnv:     "from $WEDEVCTL_DB_PATH",
+	dbSourceProject: "project-local",
+	dbSourceXDG:     "XDG data directory",
+	dbSourceLegacy:  "legacy location",
+}
+
+// versionReport is what 'version' prints.
+type versionReport struct {
+	version.Info
+	Database versionDatabase `json:"database"`
+}
+
+// versionDatabase describes the database in effect and whether this binary
+// can use it.
+type versionDatabase struct {
+	Path                   string `json:"path"`
+	Source                 string `json:"source"` // one of the dbSource constants
+	SchemaVersion          int    `json:"schema_version"`
+	SupportedSchemaVersion int    `json:"supported_schema_version"`
+	Status                 string `json:"status"`
+	Error                  string `json:"error,omitempty"`
+}
+
+// inspectDatabase reads the schema version of the database at dbPath.
+func inspectDatabase(ctx context.Context, dbPath string, timeout time.Duration) versionDatabase {
+	db := versionDatabase{Path: dbPath, SupportedSchemaVersion: wedev.LatestSchemaVersion()}
+	schema, err := wedev.ReadSchemaVersion(ctx, dbPath, timeout)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		db.Status = dbStatusMissing
+	case err != nil:
+		db.Status, db.Error = dbStatusUnreadable, err.Error()
+	case schema > db.SupportedSchemaVersion:
+		db.SchemaVersion, db.Status = schema, dbStatusNewer
+	case schema < db.SupportedSchemaVersion:
+		db.SchemaVersion, db.Status = schema, dbStatusOutdated
+	default:
+		db.SchemaVersion, db.Status = schema, dbStatusCurrent
+	}
+	return db
+}
+
+// printVersionReport prints a version report as text, JSON or YAML.
+func printVersionReport(w io.Writer, report versionReport, output string) error {
+	switch output {
+	case "json":
+		return printJSON(w, report)
+	case "yaml":
+		return printYAML(w, report)
+	}
+
+	fmt.Fprintf(w, "wedevctl %s\n", report.Version)
+	fmt.Fprintf(w, "Commit:   %s\n", report.Commit)
+	fmt.Fprintf(w, "Built:    %s\n", report.Date)
+	fmt.Fprintf(w, "Go:       %s\n", report.GoVersion)
+	db := report.Database
+	if source, ok := dbSourceNames[db.Source]; ok {
+		fmt.Fprintf(w, "Database: %s (%s)\n", db.Path, source)
+	} else {
+		fmt.Fprintf(w, "Database: %s\n", db.Path)
+	}
+	switch db.Status {
+	case dbStatusCurrent:
+		fmt.Fprintf(w, "Schema:   %d (current)\n", db.SchemaVersion)
+	case dbStatusOutdated:
+		fmt.Fprintf(w, "Schema:   %d (older than %d; migrated by the next command that writes)\n", db.SchemaVersion, db.SupportedSchemaVersion)
+	case dbStatusNewer:
+		fmt.Fprintf(w, "Schema:   %d (WRITTEN BY A NEWER WEDEVCTL: this one supports up to %d; upgrade wedevctl)\n", db.SchemaVersion, db.SupportedSchemaVersion)
+	case dbStatusMissing:
+		fmt.Fprintf(w, "Schema:   none (the database does not exist yet)\n")
+	default:
+		fmt.Fprintf(w, "Schema:   unknown (%s)\n", db.Error)
+	}
+	return nil
+}
+
+// ========== Completion ==========
+
+// NewCompletionCommand creates the 'completion' command
+func NewCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish",
+		Short: "Generate a shell completion script",
+		Long: `Generate a completion script for bash, zsh, or fish.
+
+Network, node, and entity names and config versions are completed from the
+database, which is only read — never created or modified.
+
+Examples:
+  # bash
+  source <(wedevctl completion bash)
+
+  # zsh
+  wedevctl completion zsh > "${fpath[1]}/_wedevctl"
+
+  # fish
+  wedevctl completion fish > ~/.config/fish/completions/wedevctl.fish`,
+		Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs:             []string{"bash", "zsh", "fish"},
+		DisableFlagsInUseLine: true,
+		// Generating a script needs no database.
+		PersistentPreRunE: func(_cmd *cobra.Command, _args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(cmd.OutOrStdout(), true)
+			case "zsh":
+				return root.GenZshCompletion(cmd.OutOrStdout())
+			case "fish":
+				return root.GenFishCompletion(cmd.OutOrStdout(), true)
+			default:
+				return fmt.Errorf("unsupported shell: %s (must be bash, zsh, or fish)", args[0])
+			}
+		},
+	}
+}
+
+// NewUICommand creates the 'ui' command: a read-only terminal dashboard of
+// every network.
+func NewUICommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:         "ui",
+		Annotations: readOnlyAnnotations(),
+		Short:       "Browse networks, nodes, and config history in a terminal dashboard",
+		Long: `Open a read-only dashboard with the networks on the left and the selected
+network's servers, nodes, and config history on the right.
+
+Keys: up/down (or k/j) move, tab switches between the network and node lists,
+enter shows the selected node's generated config with secrets redacted, esc
+goes back, r refreshes, and q quits.
+
+The database is opened read-only for each refresh only, so other wedevctl
+commands can change it while the dashboard is open; press r to see their
+changes.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			tty, ok := cmd.InOrStdin().(*os.File)
+			if !ok || !isTerminal(tty) {
+				return fmt.Errorf("wedevctl ui needs an interactive terminal")
+			}
+
+			// Release the handle opened for this command; the dashboard
+			// holds none between refreshes, so writers never wait for it.
+			dbPath := app.dbPath
+			if err := app.close(); err != nil {
+				return fmt.Errorf("failed to close database: %w", err)
+			}
+
+			ctx := cmd.Context()
+			d, err := newDashboard(
+				func() (networks []dashboardNetwork, err error) {
+					err = withReadOnlyStorage(dbPath, func(sm *wedev.StorageManager) error {
+						networks, err = loadDashboard(ctx, sm)
+						return err
+					})
+					return networks, err
+				},
+				func(network, node string) (config string, err error) {
+					err = withReadOnlyStorage(dbPath, func(sm *wedev.StorageManager) error {
+						config, err = wedev.NewWireGuardConfigGenerator(sm).GenerateConfigCtx(ctx, network, node)
+						return err
+					})
+					return config, err
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("failed to read database: %w", err)
+			}
+
+			return runDashboard(ctx, tty, cmd.OutOrStdout(), d)
+		},
+	}
+}
+
+// completionFunc is the signature cobra uses for dynamic argument completion.
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// withCompletionStorage runs fn against a read-only handle on the database
+// selected by the --db flag of the line being completed (cmd's root), the
+// environment, or the default. A missing, locked, or unreadable database
+// yields no completions rather than an error, so the shell falls back to
+// offering nothing.
+func withCompletionStorage(cmd *cobra.Command, fn func(sm *wedev.StorageManager) []string) []string {
+	flag, _ := cmd.Root().PersistentFlags().GetString("db")
+	dbPath, err := resolveDBPath(flag)
+	if err != nil {
+		return nil
+	}
+	sm, err := wedev.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return nil
+	}
+	//nolint:errcheck // Read-only handle; nothing to flush on close
+	defer func() { _ = sm.Close() }()
+
+	return fn(sm)
+}
+
+// setDBFlag sets the --db flag of root to value, unless value is empty.
+func setDBFlag(root *cobra.Command, value string) {
+	if value != "" {
+		//nolint:errcheck // A string flag accepts any value
+		_ = root.PersistentFlags().Set("db", value)
+	}
+}
+
+// withoutDBFlag returns args without any --db flag and its value.
+func withoutDBFlag(args []string) []string {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--db":
+			i++
+		case strings.HasPrefix(args[i], "--db="):
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest
+}
+
+// completeVNArgs completes 'vn <TAB>' with network names (cobra adds the
+// static subcommands itself) and, because vn routes network commands
+// manually, resolves 'vn <network> ...' through the dynamic command tree.
+func completeVNArgs(app *App) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		// vn does not parse flags, so a --db for the line being completed is
+		// still in args. Move it to the root flag withCompletionStorage reads.
+		db, _ := dbFlag(cmd, args)
+		setDBFlag(cmd.Root(), db)
+		args = withoutDBFlag(args[leadingGlobalFlags(args):])
+
+		if len(args) == 0 {
+			return completeNetworkNames(cmd, args, toComplete)
+		}
+
+		for _, sub := range cmd.Commands() {
+			if sub.Name() == args[0] {
+				return completeCommandTree(sub, args[1:], toComplete)
+			}
+		}
+		// The network's command tree is a root of its own.
+		networkCmd := makeNetworkCommand(app, args[0])
+		setDBFlag(networkCmd, db)
+		return completeCommandTree(networkCmd, args[1:], toComplete)
+	}
+}
+
+// completeCommandTree completes args within the tree rooted at root: flag
+// names, subcommand names, or the resolved command's own argument completion.
+func completeCommandTree(root *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	target, rest, err := root.Find(args)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err := target.ParseFlags(rest); err == nil {
+		rest = target.Flags().Args()
+	}
+
+	var completions []string
+	switch {
+	case strings.HasPrefix(toComplete, "-"):
+		target.Flags().VisitAll(func(f *pflag.Flag) {
+			if name := "--" + f.Name; strings.HasPrefix(name, toComplete) {
+				completions = append(completions, name+"\t"+f.Usage)
+			}
+		})
+	case len(rest) == 0 && target.HasAvailableSubCommands():
+		for _, sub := range target.Commands() {
+			if sub.IsAvailableCommand() && strings.HasPrefix(sub.Name(), toComplete) {
+				completions = append(completions, sub.Name()+"\t"+sub.Short)
+			}
+		}
+	case target.ValidArgsFunction != nil:
+		return target.ValidArgsFunction(target, rest, toComplete)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeNetworkNames completes the first argument with network names.
+func completeNetworkNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+		networks, err := sm.ListNetworks()
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, net := range networks {
+			if strings.HasPrefix(net.Name, toComplete) {
+				names = append(names, net.Name+"\t"+net.CIDR)
+			}
+		}
+		return names
+	}), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeNodeNames completes the first argument with the network's node names.
+func completeNodeNames(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			nodes, err := sm.ListNodesByNetworkID(network.ID)
+			if err != nil {
+				return nil
+			}
+			var names []string
+			for _, node := range nodes {
+				if strings.HasPrefix(node.Name, toComplete) {
+					names = append(names, node.Name+"\t"+string(node.Type)+" "+node.VirtualIP)
+				}
+			}
+			return names
+		}), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeNodeNameList completes every argument with the network's node
+// names not already given.
+func completeNodeNameList(networkName string) completionFunc {
+	nodeNames := completeNodeNames(networkName)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		names, directive := nodeNames(cmd, nil, toComplete)
+		names = slices.DeleteFunc(names, func(name string) bool {
+			name, _, _ = strings.Cut(name, "\t")
+			return slices.Contains(args, name)
+		})
+		return names, directive
+	}
+}
+
+// completeEntityNames completes the first argument with the network's server
+// and node names.
+func completeEntityNames(networkName string) completionFunc {
+	nodeNames := completeNodeNames(networkName)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		names := withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			return serverNameCompletions(sm, network, toComplete)
+		})
+		nodes, directive := nodeNames(cmd, args, toComplete)
+		return append(names, nodes...), directive
+	}
+}
+
+// completeServerNames completes the first argument with the network's server
+// names.
+func completeServerNames(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeServerFlag(networkName)(cmd, nil, toComplete)
+	}
+}
+
+// completeServerFlag completes a --server flag value with the network's
+// server names, whatever positional arguments precede it.
+func completeServerFlag(networkName string) completionFunc {
+	return func(cmd *cobra.Command, _args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			return serverNameCompletions(sm, network, toComplete)
+		}), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeListOrders completes a --sort flag value with orders.
+func completeListOrders(orders []wedev.ListOrder) completionFunc {
+	return func(_ *cobra.Command, _args []string, _toComplete string) ([]string, cobra.ShellCompDirective) {
+		names := make([]string, 0, len(orders))
+		for _, order := range orders {
+			names = append(names, string(order))
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeSettingKeys completes the first argument with setting keys: the
+// keys set on the network and, unless setOnly, the known ones.
+func completeSettingKeys(networkName string, setOnly bool) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		keys := withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			return slices.Collect(maps.Keys(network.Settings))
+		})
+		if !setOnly {
+			for _, def := range wedev.KnownSettings() {
+				if !slices.Contains(keys, def.Key) {
+					keys = append(keys, def.Key)
+				}
+			}
+		}
+		var matches []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, toComplete) {
+				matches = append(matches, key)
+			}
+		}
+		slices.Sort(matches)
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// serverNameCompletions returns the network's server names starting with
+// toComplete, described by their virtual IP.
+func serverNameCompletions(sm *wedev.StorageManager, network *wedev.VirtualNetwork, toComplete string) []string {
+	servers, err := sm.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, server := range servers {
+		if strings.HasPrefix(server.Name, toComplete) {
+			names = append(names, server.Name+"\tserver "+server.VirtualIP)
+		}
+	}
+	return names
+}
+
+// completeConfigVersions completes the first argument with the network's
+// config version numbers.
+func completeConfigVersions(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			versions, err := sm.ListConfigVersions(network.ID)
+			if err != nil {
+				return nil
+			}
+			var completions []string
+			for _, v := range versions {
+				if version := strconv.Itoa(v.Version); strings.HasPrefix(version, toComplete) {
+					completions = append(completions, version+"\t"+v.CreatedAt.Format(time.RFC3339))
+				}
+			}
+			tags, err := sm.ListConfigTags(network.ID)
+			if err != nil {
+				return completions
+			}
+			for _, t := range tags {
+				if strings.HasPrefix(t.Tag, toComplete) {
+					completions = append(completions, t.Tag+"\tversion "+strconv.Itoa(t.Version))
+				}
+			}
+			return completions
+		}), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+	}
+}
+
+// completeConfigTags completes the first argument with the network's config
+// version tags.
+func completeConfigTags(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			tags, err := sm.ListConfigTags(network.ID)
+			if err != nil {
+				return nil
+			}
+			var completions []string
+			for _, t := range tags {
+				if strings.HasPrefix(t.Tag, toComplete) {
+					completions = append(completions, t.Tag+"\tversion "+strconv.Itoa(t.Version))
+				}
+			}
+			return completions
+		}), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeReservedRanges completes the first argument with the network's
+// reserved IP ranges.
+func completeReservedRanges(networkName string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return withCompletionStorage(cmd, func(sm *wedev.StorageManager) []string {
+			network, err := completionNetwork(cmd, sm, networkName)
+			if err != nil {
+				return nil
+			}
+			state, err := sm.GetIPPoolState(network.ID)
+			if err != nil {
+				return nil
+			}
+			var ranges []string
+			for _, r := range state.Reserved {
+				if strings.HasPrefix(r, toComplete) {
+					ranges = append(ranges, r)
+				}
+			}
+			return ranges
+		}), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// listFilterFlags reads the --selector and --output flags shared by list
+// commands.
+func listFilterFlags(cmd *cobra.Command) (util.LabelSelector, string, error) {
+	expr, err := cmd.Flags().GetString("selector")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get selector flag: %w", err)
+	}
+	selector, err := util.ParseLabelSelector(expr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if err := validateOutput(output); err != nil {
+		return nil, "", err
+	}
+
+	return selector, output, nil
+}
+
+// labelEditFlags reads the --label and --remove-label flags of edit commands.
+func labelEditFlags(cmd *cobra.Command) (map[string]string, []string, error) {
+	labelPairs, err := cmd.Flags().GetStringArray("label")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get label flag: %w", err)
+	}
+	set, err := util.ParseLabels(labelPairs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remove, err := cmd.Flags().GetStringArray("remove-label")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get remove-label flag: %w", err)
+	}
+
+	return set, remove, nil
+}
+
+// groupFlag adds the --group value of 'node add' and 'node edit' to labels
+// as the group label. A --label setting that label differently is an error.
+func groupFlag(cmd *cobra.Command, labels map[string]string) error {
+	group, err := cmd.Flags().GetString("group")
+	if err != nil {
+		return fmt.Errorf("failed to get group flag: %w", err)
+	}
+	if group == "" {
+		return nil
+	}
+	if value, ok := labels[wedev.GroupLabel]; ok && value != group {
+		return withKind(wedev.ErrValidation, fmt.Errorf("--group %s conflicts with --label %s=%s", group, wedev.GroupLabel, value))
+	}
+	if _, err := util.ParseLabels([]string{wedev.GroupLabel + "=" + group}); err != nil {
+		return withKind(wedev.ErrValidation, fmt.Errorf("invalid group %q: %w", group, err))
+	}
+	labels[wedev.GroupLabel] = group
+	return nil
+}
+
+// expiryFlags declares the flags 'node add' and 'node edit' use to give a
+// node temporary access.
+func expiryFlags(cmd *cobra.Command, clearable bool) {
+	usage := "Access ends at this date (YYYY-MM-DD, local midnight) or RFC 3339 time"
+	if clearable {
+		usage += "; empty string removes the expiry"
+	}
+	cmd.Flags().String("expires", "", usage)
+	cmd.Flags().Duration("ttl", 0, "Access ends this long from now (e.g. 336h)")
+	cmd.MarkFlagsMutuallyExclusive("expires", "ttl")
+}
+
+// parseExpiryFlags reads --expires and --ttl. changed is false when neither
+// was given; a nil expiry with changed set means --expires "" (no expiry).
+func parseExpiryFlags(cmd *cobra.Command) (expiresAt *time.Time, changed bool, err error) {
+	if cmd.Flags().Changed("ttl") {
+		ttl, err := cmd.Flags().GetDuration("ttl")
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get ttl flag: %w", err)
+		}
+		if ttl <= 0 {
+			return nil, false, fmt.Errorf("--ttl must be positive, got %s", ttl)
+		}
+		t := time.Now().Add(ttl)
+		return &t, true, nil
+	}
+	if !cmd.Flags().Changed("expires") {
+		return nil, false, nil
+	}
+
+	value, err := cmd.Flags().GetString("expires")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get expires flag: %w", err)
+	}
+	if value == "" {
+		return nil, true, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return &t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid --expires %q (expected YYYY-MM-DD or an RFC 3339 time)", value)
+	}
+	return &t, true, nil
+}
+
+// formatExpiry renders a node's expiry for display, marking one that has
+// passed.
+func formatExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return "-"
+	}
+	s := expiresAt.Local().Format("2006-01-02 15:04")
+	if !time.Now().Before(*expiresAt) {
+		s += " (expired)"
+	}
+	return s
+}
+
+// formatAge renders how long ago t was, to the largest whole unit.
+func formatAge(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	age := time.Since(*t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+}
+
+// keyImportFlags declares the flags 'server add' and 'node add' use to
+// import an existing WireGuard identity instead of generating one.
+func keyImportFlags(cmd *cobra.Command) {
+	cmd.Flags().String("private-key", "", "Existing WireGuard private key (base64); the public key is derived")
+	cmd.Flags().String("key-file", "", "File containing the private key, to keep it out of shell history")
+	cmd.Flags().String("public-key", "", "Existing WireGuard public key; alone, marks the config as managed outside wedevctl")
+	cmd.MarkFlagsMutuallyExclusive("private-key", "key-file")
+}
+
+// importedKeys reads the key import flags, returning nil when none are set.
+func importedKeys(cmd *cobra.Command) (*util.WireGuardKeyPair, error) {
+	privateKey, err := cmd.Flags().GetString("private-key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get private-key flag: %w", err)
+	}
+	keyFile, err := cmd.Flags().GetString("key-file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key-file flag: %w", err)
+	}
+	publicKey, err := cmd.Flags().GetString("public-key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public-key flag: %w", err)
+	}
+
+	if keyFile != "" {
+		// #nosec G304 -- the user names their own key file to read.
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		privateKey = strings.TrimSpace(string(data))
+		if privateKey == "" {
+			return nil, fmt.Errorf("key file %s is empty", keyFile)
+		}
+	}
+
+	if privateKey == "" && publicKey == "" {
+		return nil, nil
+	}
+	return util.ParseWireGuardKeys(privateKey, publicKey)
+}
+
+// printImportedKeys reports how an imported identity will be used.
+func printImportedKeys(w io.Writer, keys *util.WireGuardKeyPair, publicKey string) {
+	if keys == nil {
+		return
+	}
+	fmt.Fprintf(w, "Public Key: %s (imported)\n", publicKey)
+	if keys.PrivateKey == "" {
+		fmt.Fprintln(w, "No private key imported: its config is managed outside wedevctl and will not be generated")
+	}
+}
+
+// formatLabels renders labels as sorted key=value pairs, or "-" when empty.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// optionalArg returns args[i], or "" when it was not given.
+func optionalArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// printJSON writes v to w as indented JSON.
+func printJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+// printYAML writes each of docs to w as a separate YAML document. Values go
+// through their JSON encoding first, so field names match the JSON tags and
+// map keys come out sorted.
+func printYAML(w io.Writer, docs ...any) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, v := range docs {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		clearYAMLStyle(&node)
+		if err := enc.Encode(&node); err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+	}
+	return enc.Close()
+}
+
+// clearYAMLStyle resets the flow and quoting styles decoded from JSON, so the
+// encoder writes block YAML and quotes only where needed.
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
 	}
-	return response == "y" || response == "Y" || response == "yes" || response == "YES"
 }
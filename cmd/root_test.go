@@ -1,40 +1,174 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
 )
 
-// Test VN Add Command - Can be created
+// newTestApp returns an App opened over a fresh temp-file database, closed
+// when the test ends.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	app := &App{}
+	if err := app.open(context.Background(), filepath.Join(t.TempDir(), "test.db"), wedev.StorageOptions{}); err != nil {
+		t.Fatalf("app.open() error = %v", err)
+	}
+	t.Cleanup(func() { app.close() })
+	return app
+}
+
+// runCommand executes cmd on its own, without the root command, so only its
+// RunE runs against the App it was built with. Output is captured as in runCLI.
+func runCommand(t *testing.T, cmd *cobra.Command, stdin string, args ...string) (string, error) {
+	t.Helper()
+	cmd.SetArgs(args)
+	return execute(cmd, stdin)
+}
+
+// Test VN Add Command - creates a network in the App's database
 func TestVNAddCommand(t *testing.T) {
-	cmd := NewVNAddCommand()
-	if cmd == nil {
-		t.Errorf("NewVNAddCommand() returned nil")
+	app := newTestApp(t)
+
+	out, err := runCommand(t, NewVNAddCommand(app), "y\n", "testnet", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if !strings.Contains(out, "testnet") {
+		t.Errorf("vn add output = %q", out)
+	}
+	if _, err := app.vnManager.GetVirtualNetwork("testnet"); err != nil {
+		t.Errorf("GetVirtualNetwork() after vn add error = %v", err)
+	}
+
+	// Host bits are cleared before the prompt, which shows the stored CIDR.
+	out, err = runCommand(t, NewVNAddCommand(app), "y\n", "hostbits", "10.1.0.1/24")
+	if err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if !strings.Contains(out, "with CIDR 10.1.0.0/24?") {
+		t.Errorf("vn add prompt = %q, want the normalized CIDR", out)
+	}
+	if net, err := app.vnManager.GetVirtualNetwork("hostbits"); err != nil || net.CIDR != "10.1.0.0/24" {
+		t.Errorf("GetVirtualNetwork(hostbits) = %+v, %v; want CIDR 10.1.0.0/24", net, err)
 	}
 }
 
-// Test VN List Command - Can be created
+// Test VN List Command - prints the App's networks as a table and JSON
 func TestVNListCommand(t *testing.T) {
-	cmd := NewVNListCommand()
-	if cmd == nil {
-		t.Errorf("NewVNListCommand() returned nil")
+	app := newTestApp(t)
+
+	out, err := runCommand(t, NewVNListCommand(app), "")
+	if err != nil || out != "No virtual networks found\n" {
+		t.Errorf("vn list on an empty database = %q, %v", out, err)
+	}
+
+	if _, err := app.vnManager.CreateVirtualNetwork("alpha", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := app.vnManager.CreateVirtualNetwork("longernetwork", "10.1.0.0/16"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	out, err = runCommand(t, NewVNListCommand(app), "")
+	if err != nil {
+		t.Fatalf("vn list error = %v", err)
+	}
+	// Columns are padded to the widest cell; rows follow storage order.
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	slices.Sort(lines[2:])
+	want := []string{
+		"Name           CIDR         Labels",
+		"----------------------------------",
+		"alpha          10.0.0.0/24  -",
+		"longernetwork  10.1.0.0/16  -",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("vn list =\n%s\nwant rows\n%s", out, strings.Join(want, "\n"))
+	}
+
+	out, err = runCommand(t, NewVNListCommand(app), "", "-o", "json")
+	if err != nil {
+		t.Fatalf("vn list -o json error = %v", err)
+	}
+	var networks []wedev.VirtualNetwork
+	if err := json.Unmarshal([]byte(out), &networks); err != nil || len(networks) != 2 {
+		t.Errorf("vn list -o json = %q (%v), want two networks", out, err)
+	}
+
+	if _, err := app.vnManager.CreateServer("alpha", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"n1", "n2"} {
+		if _, err := app.vnManager.CreateNode("alpha", name, "", 0, wedev.NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, _, err := app.generator.SaveConfigVersion("alpha"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	out, err = runCommand(t, NewVNListCommand(app), "", "--wide")
+	if err != nil {
+		t.Fatalf("vn list --wide error = %v", err)
+	}
+	lines = strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if got := strings.Fields(lines[0]); !slices.Equal(got, []string{"Name", "CIDR", "Server", "Nodes", "Version", "Generated", "Pool", "Labels"}) {
+		t.Errorf("vn list --wide headers = %v", got)
+	}
+	if got := strings.Fields(lines[2]); !slices.Equal(got, []string{"alpha", "10.0.0.0/24", "vpn.example.com:51820", "2", "v1", "just", "now", "1%", "-"}) {
+		t.Errorf("vn list --wide alpha row = %v", got)
+	}
+	if got := strings.Fields(lines[3]); !slices.Equal(got, []string{"longernetwork", "10.1.0.0/16", "-", "0", "-", "-", "0%", "-"}) {
+		t.Errorf("vn list --wide longernetwork row = %v", got)
+	}
+
+	out, err = runCommand(t, NewVNListCommand(app), "", "-o", "json")
+	if err != nil {
+		t.Fatalf("vn list -o json error = %v", err)
+	}
+	var entries []struct {
+		Name          string `json:"name"`
+		Nodes         int    `json:"nodes"`
+		LatestVersion int    `json:"latest_version"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil || len(entries) != 2 || entries[0].Name != "alpha" || entries[0].Nodes != 2 || entries[0].LatestVersion != 1 {
+		t.Errorf("vn list -o json = %q (%v), want alpha with 2 nodes at v1", out, err)
 	}
 }
 
-// Test Server Add Command - Can be created
+// Test Server Add Command - adds a server to the App's network
 func TestServerAddCommand(t *testing.T) {
-	cmd := makeServerAddCommand("test-network")
-	if cmd == nil {
-		t.Errorf("makeServerAddCommand() returned nil")
+	app := newTestApp(t)
+	if _, err := app.vnManager.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	if _, err := runCommand(t, makeServerAddCommand(app, "testnet"), "", "srv", "vpn.example.com"); err != nil {
+		t.Fatalf("server add error = %v", err)
+	}
+	server, err := app.vnManager.GetServer("testnet", "srv")
+	if err != nil || server.VirtualIP != "10.0.0.1" {
+		t.Errorf("GetServer() = %v, %v, want srv at 10.0.0.1", server, err)
+	}
+	if _, err := runCommand(t, makeServerAddCommand(app, "missing"), "", "srv", "vpn.example.com"); err == nil {
+		t.Error("server add to an unknown network should fail")
 	}
 }
 
 // Test Server Info Command - Can be created
 func TestServerInfoCommand(t *testing.T) {
-	cmd := makeServerInfoCommand("test-network")
+	cmd := makeServerInfoCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeServerInfoCommand() returned nil")
 	}
@@ -42,7 +176,7 @@ func TestServerInfoCommand(t *testing.T) {
 
 // Test Server Edit Command - Can be created
 func TestServerEditCommand(t *testing.T) {
-	cmd := makeServerEditCommand("test-network")
+	cmd := makeServerEditCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeServerEditCommand() returned nil")
 	}
@@ -50,55 +184,303 @@ func TestServerEditCommand(t *testing.T) {
 
 // Test Server Delete Command - Can be created
 func TestServerDeleteCommand(t *testing.T) {
-	cmd := makeServerDeleteCommand("test-network")
+	cmd := makeServerDeleteCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeServerDeleteCommand() returned nil")
 	}
 }
 
-// Test Node Add Command - Can be created
+// newTestNetwork returns an App with network "testnet" and a server.
+func newTestNetwork(t *testing.T) *App {
+	t.Helper()
+	app := newTestApp(t)
+	if _, err := app.vnManager.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := app.vnManager.CreateServer("testnet", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	return app
+}
+
+// Test Node Add Command - validates arguments and creates nodes
 func TestNodeAddCommand(t *testing.T) {
-	cmd := makeNodeAddCommand("test-network")
-	if cmd == nil {
-		t.Errorf("makeNodeAddCommand() returned nil")
+	app := newTestNetwork(t)
+
+	if _, err := runCommand(t, makeNodeAddCommand(app, "testnet"), "", "n1", "peer"); err == nil {
+		t.Error("node add of a peer without a public address should fail")
+	}
+	out, err := runCommand(t, makeNodeAddCommand(app, "testnet"), "", "n1", "route", "--label", "role=db")
+	if err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if !strings.Contains(out, "Virtual IP: 10.0.0.2") || !strings.Contains(out, "Labels: role=db") {
+		t.Errorf("node add output = %q", out)
+	}
+}
+
+// Test parsePort - ports parse strictly and must be in range
+func TestParsePort(t *testing.T) {
+	for _, tt := range []struct {
+		arg     string
+		want    int
+		wantErr bool
+	}{
+		{"1", 1, false},
+		{"51820", 51820, false},
+		{"65535", 65535, false},
+		{"0", 0, true},
+		{"65536", 0, true},
+		{"99999", 0, true},
+		{"-1", 0, true},
+		{"51820abc", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+	} {
+		got, err := parsePort(tt.arg)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePort(%q) = %d, %v; want %d, error %v", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && ExitCode(err) != ExitValidation {
+			t.Errorf("parsePort(%q) exit code = %d, want %d", tt.arg, ExitCode(err), ExitValidation)
+		}
+	}
+
+	app := newTestNetwork(t)
+	if _, err := runCommand(t, makeNodeAddCommand(app, "testnet"), "", "n1", "peer", "1.2.3.4", "51820abc"); ExitCode(err) != ExitValidation {
+		t.Errorf("node add with port 51820abc exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+	if _, err := app.vnManager.GetNode("testnet", "n1"); err == nil {
+		t.Error("node add with an invalid port stored the node")
 	}
 }
 
-// Test Node List Command - Can be created
+// Test Node List Command - lists the App's nodes as JSON
 func TestNodeListCommand(t *testing.T) {
-	cmd := makeNodeListCommand("test-network")
-	if cmd == nil {
-		t.Errorf("makeNodeListCommand() returned nil")
+	app := newTestNetwork(t)
+	if _, err := app.vnManager.CreateNode("testnet", "n1", "", 0, wedev.NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	out, err := runCommand(t, makeNodeListCommand(app, "testnet"), "", "-o", "json")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	var nodes []nodeListEntry
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		t.Fatalf("node list output is not JSON: %v\n%s", err, out)
+	}
+	if len(nodes) != 1 || nodes[0].Name != "n1" || nodes[0].Server != "srv" {
+		t.Errorf("node list = %+v, want n1 on srv", nodes)
+	}
+
+	out, err = runCommand(t, makeNodeListCommand(app, "testnet"), "")
+	if err != nil {
+		t.Fatalf("node list error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Name ") || strings.Trim(lines[1], "-") != "" {
+		t.Fatalf("node list = %q, want a header, a rule, and one row", out)
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 6 || fields[0] != "n1" || fields[1] != "10.0.0.2" || fields[3] != "route" {
+		t.Errorf("node list row = %q", lines[2])
 	}
 }
 
 // Test Node Edit Command - Can be created
 func TestNodeEditCommand(t *testing.T) {
-	cmd := makeNodeEditCommand("test-network")
+	cmd := makeNodeEditCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeNodeEditCommand() returned nil")
 	}
 }
 
+// Test Node Rename Command - Can be created
+func TestNodeRenameCommand(t *testing.T) {
+	cmd := makeNodeRenameCommand(&App{}, "test-network")
+	if cmd == nil {
+		t.Errorf("makeNodeRenameCommand() returned nil")
+	}
+}
+
+// Test Server Rename Command - Can be created
+func TestServerRenameCommand(t *testing.T) {
+	cmd := makeServerRenameCommand(&App{}, "test-network")
+	if cmd == nil {
+		t.Errorf("makeServerRenameCommand() returned nil")
+	}
+}
+
 // Test Node Delete Command - Can be created
 func TestNodeDeleteCommand(t *testing.T) {
-	cmd := makeNodeDeleteCommand("test-network")
+	cmd := makeNodeDeleteCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeNodeDeleteCommand() returned nil")
 	}
 }
 
-// Test Config Generate Command - Can be created
+// Test Config Generate Command - writes files and saves a version
 func TestConfigGenerateCommand(t *testing.T) {
-	cmd := makeConfigGenerateCommand("test-network")
-	if cmd == nil {
-		t.Errorf("makeConfigGenerateCommand() returned nil")
+	app := newTestNetwork(t)
+	outDir := t.TempDir()
+
+	if _, err := runCommand(t, makeConfigGenerateCommand(app, "testnet"), "", "--output-dir", outDir); err != nil {
+		t.Fatalf("config generate error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "srv.conf")); err != nil {
+		t.Errorf("config generate did not write srv.conf: %v", err)
+	}
+	history, err := app.generator.GetConfigHistory("testnet")
+	if err != nil || len(history) != 1 {
+		t.Errorf("GetConfigHistory() = %d versions, %v, want 1", len(history), err)
+	}
+}
+
+// TestNodeExplainCommand checks that 'node explain' lists the node's config
+// directive by directive with their sources, keys redacted.
+func TestNodeExplainCommand(t *testing.T) {
+	app := newTestNetwork(t)
+	node, err := app.vnManager.CreateRouteNode("testnet", "n1", "", 51821, []string{"192.168.10.0/24"})
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	out, err := runCommand(t, makeNodeExplainCommand(app, "testnet"), "", "n1")
+	if err != nil {
+		t.Fatalf("node explain error = %v", err)
+	}
+	for _, want := range []string{"[Interface]", "[Peer] srv", "ListenPort", "built-in default", "derived", "(redacted)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("node explain output does not mention %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, node.PrivateKey) {
+		t.Errorf("node explain printed the private key without --show-secrets")
+	}
+
+	out, err = runCommand(t, makeNodeExplainCommand(app, "testnet"), "", "n1", "--show-secrets", "--output", "json")
+	if err != nil {
+		t.Fatalf("node explain --output json error = %v", err)
+	}
+	var config wedev.StructuredConfig
+	if err := json.Unmarshal([]byte(out), &config); err != nil {
+		t.Fatalf("node explain --output json is not JSON: %v\n%s", err, out)
+	}
+	if len(config.Sections) == 0 || config.Sections[0].Directives[0].Value != node.PrivateKey {
+		t.Errorf("node explain --show-secrets did not print the private key first: %+v", config.Sections)
+	}
+
+	if _, err := runCommand(t, makeNodeExplainCommand(app, "testnet"), "", "nope"); ExitCode(err) != ExitNotFound {
+		t.Errorf("node explain of an unknown node exit code = %d (%v), want %d", ExitCode(err), err, ExitNotFound)
+	}
+}
+
+func TestServerFailoverAddresses(t *testing.T) {
+	app := newTestNetwork(t)
+
+	out, err := runCommand(t, makeServerEditCommand(app, "testnet"), "", "--additional-address", "isp2.example.com")
+	if err != nil {
+		t.Fatalf("server edit --additional-address error = %v", err)
+	}
+	if !strings.Contains(out, "Additional Addresses: isp2.example.com:51820") {
+		t.Errorf("server edit output = %q", out)
+	}
+	if _, err := runCommand(t, makeNodeAddCommand(app, "testnet"), "", "n1", "route", "--endpoint-preference", "secondary"); err != nil {
+		t.Fatalf("node add --endpoint-preference error = %v", err)
+	}
+	if _, err := runCommand(t, makeNodeAddCommand(app, "testnet"), "", "n2", "route"); err != nil {
+		t.Fatalf("node add error = %v", err)
+	}
+	if _, err := runCommand(t, makeNodeEditCommand(app, "testnet"), "", "n2", "--endpoint-preference", "nearest"); ExitCode(err) != ExitValidation {
+		t.Errorf("node edit --endpoint-preference nearest exit code = %d (%v), want %d", ExitCode(err), err, ExitValidation)
+	}
+
+	out, err = runCommand(t, makeServerInfoCommand(app, "testnet"), "")
+	if err != nil {
+		t.Fatalf("server info error = %v", err)
+	}
+	for _, want := range []string{"isp2.example.com:51820", "secondary", "vpn.example.com:51820", "primary"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("server info output does not mention %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := runCommand(t, makeServerEditCommand(app, "testnet"), "", "--additional-address", ""); err != nil {
+		t.Fatalf("server edit --additional-address \"\" error = %v", err)
+	}
+	server, err := app.vnManager.GetServer("testnet", "srv")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+	if len(server.AdditionalAddresses) != 0 {
+		t.Errorf("AdditionalAddresses = %v after clearing", server.AdditionalAddresses)
+	}
+}
+
+// TestDriftCheck runs mutating commands through their group command, as 'vn'
+// does, and checks what they report about the latest saved config version.
+func TestDriftCheck(t *testing.T) {
+	app := newTestNetwork(t)
+	run := func(group *cobra.Command, args ...string) string {
+		t.Helper()
+		var stderr bytes.Buffer
+		group.SetArgs(args)
+		group.SetOut(io.Discard)
+		group.SetErr(&stderr)
+		if err := group.Execute(); err != nil {
+			t.Fatalf("%v error = %v", args, err)
+		}
+		return stderr.String()
+	}
+
+	// Nothing is saved yet, so nothing can drift.
+	if got := run(makeNodeCommand(app, "testnet"), "add", "n1", "route"); got != "" {
+		t.Errorf("node add before any version reported %q", got)
+	}
+	if _, _, err := app.generator.SaveConfigVersion("testnet"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	const drift = "Configuration drift: latest saved version v1 no longer matches; run 'config generate'\n"
+	if got := run(makeServerCommand(app, "testnet"), "edit", "--port", "51821"); got != drift {
+		t.Errorf("server edit reported %q, want %q", got, drift)
+	}
+	if got := run(makeNodeCommand(app, "testnet"), "edit", "n1", "--port", "51830", "--no-drift-check"); got != "" {
+		t.Errorf("node edit --no-drift-check reported %q", got)
+	}
+
+	if _, _, err := app.generator.SaveConfigVersion("testnet"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	// Labels are not part of any config.
+	if got := run(makeNodeCommand(app, "testnet"), "edit", "n1", "--label", "role=db"); got != "" {
+		t.Errorf("node edit of a label reported %q", got)
+	}
+}
+
+// TestAppsAreIndependent runs commands against two Apps in one process; each
+// sees only its own database.
+func TestAppsAreIndependent(t *testing.T) {
+	first, second := newTestApp(t), newTestApp(t)
+
+	if _, err := runCommand(t, NewVNAddCommand(first), "y\n", "onlyfirst", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+	if _, err := first.vnManager.GetVirtualNetwork("onlyfirst"); err != nil {
+		t.Fatalf("GetVirtualNetwork() on the first App error = %v", err)
+	}
+	out, err := runCommand(t, NewVNListCommand(second), "")
+	if err != nil {
+		t.Fatalf("vn list error = %v", err)
+	}
+	if strings.Contains(out, "onlyfirst") {
+		t.Errorf("second App lists the first App's network: %q", out)
 	}
 }
 
 // Test Config History Command - Can be created
 func TestConfigHistoryCommand(t *testing.T) {
-	cmd := makeConfigHistoryCommand("test-network")
+	cmd := makeConfigHistoryCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeConfigHistoryCommand() returned nil")
 	}
@@ -106,20 +488,93 @@ func TestConfigHistoryCommand(t *testing.T) {
 
 // Test Config Info Command - Can be created
 func TestConfigInfoCommand(t *testing.T) {
-	cmd := makeConfigInfoCommand("test-network")
+	cmd := makeConfigInfoCommand(&App{}, "test-network")
 	if cmd == nil {
 		t.Errorf("makeConfigInfoCommand() returned nil")
 	}
 }
 
+// Test Config Apply Command - Can be created
+func TestConfigApplyCommand(t *testing.T) {
+	cmd := makeConfigApplyCommand(&App{}, "test-network")
+	if cmd == nil {
+		t.Errorf("makeConfigApplyCommand() returned nil")
+	}
+}
+
+// Test Status Command - Can be created
+func TestStatusCommand(t *testing.T) {
+	cmd := makeStatusCommand(&App{}, "test-network")
+	if cmd == nil {
+		t.Errorf("makeStatusCommand() returned nil")
+	}
+}
+
 // Test VN Delete Command - Can be created
 func TestVNDeleteCommand(t *testing.T) {
-	cmd := NewVNDeleteCommand()
+	cmd := NewVNDeleteCommand(&App{})
 	if cmd == nil {
 		t.Errorf("NewVNDeleteCommand() returned nil")
 	}
 }
 
+// Test VN Rename Command - Can be created
+func TestVNRenameCommand(t *testing.T) {
+	cmd := NewVNRenameCommand(&App{})
+	if cmd == nil {
+		t.Errorf("NewVNRenameCommand() returned nil")
+	}
+}
+
+func TestVNCloneCommand(t *testing.T) {
+	cmd := NewVNCloneCommand(&App{})
+	if cmd.Flags().Lookup("cidr") == nil || cmd.Flags().Lookup("clear-addresses") == nil {
+		t.Error("vn clone lacks --cidr or --clear-addresses")
+	}
+}
+
+// Test VN Edit Command - Can be created
+func TestVNEditCommand(t *testing.T) {
+	cmd := NewVNEditCommand(&App{})
+	if cmd == nil {
+		t.Fatalf("NewVNEditCommand() returned nil")
+	}
+	if cmd.Flags().Lookup("label") == nil || cmd.Flags().Lookup("remove-label") == nil {
+		t.Errorf("NewVNEditCommand() is missing --label or --remove-label")
+	}
+}
+
+// Test Completion Command - Can be created
+func TestCompletionCommand(t *testing.T) {
+	cmd := NewCompletionCommand()
+	if cmd == nil {
+		t.Fatalf("NewCompletionCommand() returned nil")
+	}
+	if len(cmd.ValidArgs) != 3 {
+		t.Errorf("Expected 3 supported shells, got %v", cmd.ValidArgs)
+	}
+}
+
+func TestApplyCommand(t *testing.T) {
+	cmd := NewApplyCommand(&App{})
+	for _, flag := range []string{"file", "dry-run", "yes", "prune", "message"} {
+		if cmd.Flags().Lookup(flag) == nil {
+			t.Errorf("apply has no --%s flag", flag)
+		}
+	}
+}
+
+// Test DB Commands - Can be created
+func TestDBCommands(t *testing.T) {
+	cmd := NewDBCommand(&App{})
+	if cmd == nil {
+		t.Fatalf("NewDBCommand() returned nil")
+	}
+	if len(cmd.Commands()) != 8 {
+		t.Errorf("Expected 8 subcommands, got %d", len(cmd.Commands()))
+	}
+}
+
 // TestCustomDBPath tests using custom database path via environment variable
 func TestCustomDBPath(t *testing.T) {
 	// Create temporary directory
@@ -199,9 +654,11 @@ func TestDefaultDBPath(t *testing.T) {
 	oldPath := os.Getenv("WEDEVCTL_DB_PATH")
 	os.Unsetenv("WEDEVCTL_DB_PATH")
 	defer os.Setenv("WEDEVCTL_DB_PATH", oldPath)
+	// Keep the database out of the real home directory
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
 
-	// We can't easily test the actual home directory in unit tests,
-	// but we can verify the command doesn't error during initialization
 	rootCmd := NewRootCommand()
 	rootCmd.SetArgs([]string{"vn", "list"})
 	rootCmd.SetOut(io.Discard)
@@ -213,6 +670,10 @@ func TestDefaultDBPath(t *testing.T) {
 		t.Logf("Command execution error (expected in test with empty db): %v", err)
 	}
 	// Not checking for specific error since we're just testing initialization
+
+	if _, err := os.Stat(filepath.Join(home, ".local", "share", "wedevctl", "wedevctl.db")); err != nil {
+		t.Errorf("Database should exist in the XDG data directory: %v", err)
+	}
 }
 
 // TestDBPathPrecedence tests that environment variable takes precedence
@@ -238,6 +699,220 @@ func TestDBPathPrecedence(t *testing.T) {
 	}
 }
 
+// TestDBFlagPrecedence tests that --db takes precedence over the environment
+// variable, as a directory or as a file ending in .db
+func TestDBFlagPrecedence(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("WEDEVCTL_DB_PATH", filepath.Join(tmpDir, "env-db"))
+
+	tests := []struct {
+		name   string
+		args   []string
+		dbFile string
+	}{
+		{"directory", []string{"--db", filepath.Join(tmpDir, "flag-dir"), "vn", "list"}, filepath.Join(tmpDir, "flag-dir", "wedevctl.db")},
+		{"file", []string{"--db=" + filepath.Join(tmpDir, "files", "inventory.db"), "vn", "list"}, filepath.Join(tmpDir, "files", "inventory.db")},
+		{"after subcommand", []string{"vn", "list", "--db", filepath.Join(tmpDir, "late.db")}, filepath.Join(tmpDir, "late.db")},
+		{"network command", []string{"--db", filepath.Join(tmpDir, "net.db"), "-v", "vn", "nosuchnet", "node", "list"}, filepath.Join(tmpDir, "net.db")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootCmd := NewRootCommand()
+			rootCmd.SetArgs(tt.args)
+			rootCmd.SetOut(io.Discard)
+			rootCmd.SetErr(io.Discard)
+
+			_ = rootCmd.Execute()
+
+			if _, err := os.Stat(tt.dbFile); err != nil {
+				t.Errorf("Database should exist at %s", tt.dbFile)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "env-db")); err == nil {
+		t.Error("--db should keep the environment variable's directory unused")
+	}
+}
+
+// TestResolveDBLocation tests each level of the flag > environment >
+// project > XDG > legacy resolution order
+func TestResolveDBLocation(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	project := filepath.Join(root, "src", "project")
+	nested := filepath.Join(project, "deploy", "prod")
+	xdgHome := filepath.Join(root, "xdg")
+	for _, dir := range []string{filepath.Join(home, ".wedevctl"), filepath.Join(project, ".wedevctl"), nested, filepath.Join(xdgHome, "wedevctl")} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	touch := func(path string) {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	touch(filepath.Join(home, ".wedevctl", "wedevctl.db"))
+	touch(filepath.Join(xdgHome, "wedevctl", "wedevctl.db"))
+	// A home without a legacy database, and one with only a legacy database.
+	freshHome := filepath.Join(root, "fresh")
+	legacyHome := filepath.Join(root, "legacy")
+	if err := os.MkdirAll(filepath.Join(legacyHome, ".wedevctl"), 0o700); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	touch(filepath.Join(legacyHome, ".wedevctl", "wedevctl.db"))
+
+	tests := []struct {
+		name string
+		flag string
+		vars map[string]string
+		wd   string
+		home string
+		want dbLocation
+	}{
+		{"flag directory", "/flag/dir", map[string]string{"WEDEVCTL_DB_PATH": "/env/dir"}, nested, home,
+			dbLocation{path: "/flag/dir/wedevctl.db", source: dbSourceFlag}},
+		{"flag file", "/flag/inventory.db", map[string]string{"WEDEVCTL_DB_PATH": "/env/dir"}, nested, home,
+			dbLocation{path: "/flag/inventory.db", source: dbSourceFlag}},
+		{"relative flag directory", "relative", nil, root, home,
+			dbLocation{path: filepath.Join(root, "relative", "wedevctl.db"), source: dbSourceFlag}},
+		{"relative flag file", "relative.db", nil, root, home,
+			dbLocation{path: filepath.Join(root, "relative.db"), source: dbSourceFlag}},
+		{"environment", "", map[string]string{"WEDEVCTL_DB_PATH": "/env/dir", "XDG_DATA_HOME": xdgHome}, nested, home,
+			dbLocation{path: "/env/dir/wedevctl.db", source: dbSourceEnv}},
+		{"relative environment", "", map[string]string{"WEDEVCTL_DB_PATH": "data"}, root, home,
+			dbLocation{path: filepath.Join(root, "data", "wedevctl.db"), source: dbSourceEnv}},
+		{"project", "", map[string]string{"XDG_DATA_HOME": xdgHome}, project, home,
+			dbLocation{path: filepath.Join(project, ".wedevctl", "wedevctl.db"), source: dbSourceProject}},
+		{"project above", "", map[string]string{"XDG_DATA_HOME": xdgHome}, nested, home,
+			dbLocation{path: filepath.Join(project, ".wedevctl", "wedevctl.db"), source: dbSourceProject}},
+		{"xdg", "", map[string]string{"XDG_DATA_HOME": xdgHome}, root, home,
+			dbLocation{path: filepath.Join(xdgHome, "wedevctl", "wedevctl.db"), source: dbSourceXDG}},
+		{"legacy directory is not a project", "", map[string]string{"XDG_DATA_HOME": xdgHome}, home, home,
+			dbLocation{path: filepath.Join(xdgHome, "wedevctl", "wedevctl.db"), source: dbSourceXDG}},
+		{"relative xdg ignored", "", map[string]string{"XDG_DATA_HOME": "xdg"}, root, freshHome,
+			dbLocation{path: filepath.Join(freshHome, ".local", "share", "wedevctl", "wedevctl.db"), source: dbSourceXDG}},
+		{"legacy", "", nil, root, legacyHome,
+			dbLocation{
+				path:    filepath.Join(legacyHome, ".wedevctl", "wedevctl.db"),
+				source:  dbSourceLegacy,
+				xdgPath: filepath.Join(legacyHome, ".local", "share", "wedevctl", "wedevctl.db"),
+			}},
+		{"xdg over legacy", "", map[string]string{"XDG_DATA_HOME": xdgHome}, root, legacyHome,
+			dbLocation{path: filepath.Join(xdgHome, "wedevctl", "wedevctl.db"), source: dbSourceXDG}},
+		{"default", "", nil, root, freshHome,
+			dbLocation{path: filepath.Join(freshHome, ".local", "share", "wedevctl", "wedevctl.db"), source: dbSourceXDG}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := dbEnvironment{getenv: func(key string) string { return tt.vars[key] }, wd: tt.wd, home: tt.home}
+			got, err := resolveDBLocation(tt.flag, env)
+			if err != nil || got != tt.want {
+				t.Errorf("resolveDBLocation(%q) = %+v, %v; want %+v", tt.flag, got, err, tt.want)
+			}
+		})
+	}
+
+	noHome := dbEnvironment{getenv: func(string) string { return "" }, wd: root}
+	if _, err := resolveDBLocation("", noHome); err == nil {
+		t.Error("resolveDBLocation() without a home or $XDG_DATA_HOME succeeded")
+	}
+}
+
+// TestCopyDatabaseToXDG tests the copy offered for a legacy database, after
+// which the XDG location is the one resolved
+func TestCopyDatabaseToXDG(t *testing.T) {
+	home := t.TempDir()
+	env := dbEnvironment{getenv: func(string) string { return "" }, wd: t.TempDir(), home: home}
+	legacyPath := filepath.Join(home, ".wedevctl", "wedevctl.db")
+	if _, err := runCLI(t, "y\n", "--db", legacyPath, "vn", "add", "home", "10.0.0.0/24"); err != nil {
+		t.Fatalf("vn add error = %v", err)
+	}
+
+	loc, err := resolveDBLocation("", env)
+	if err != nil || loc.source != dbSourceLegacy {
+		t.Fatalf("resolveDBLocation() = %+v, %v; want the legacy database", loc, err)
+	}
+	if err := copyDatabase(t.Context(), loc.path, loc.xdgPath, wedev.StorageOptions{}); err != nil {
+		t.Fatalf("copyDatabase() error = %v", err)
+	}
+	if got, err := resolveDBLocation("", env); err != nil || got.path != loc.xdgPath || got.source != dbSourceXDG {
+		t.Errorf("resolveDBLocation() after the copy = %+v, %v; want %s", got, err, loc.xdgPath)
+	}
+	copied, err := wedev.OpenStorageReadOnly(loc.xdgPath)
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer copied.Close()
+	if _, err := copied.GetNetworkByName("home"); err != nil {
+		t.Errorf("copy lacks the network: %v", err)
+	}
+	if _, err := os.Stat(legacyPath); err != nil {
+		t.Errorf("legacy database removed: %v", err)
+	}
+}
+
+// TestResolveNetworkName tests resolving a network by its name or a unique
+// prefix of it
+func TestResolveNetworkName(t *testing.T) {
+	useTempDB(t)
+	for _, name := range []string{"prod", "prodeu", "prodeast", "stageeu"} {
+		if _, err := runCLI(t, "y\n", "vn", "add", name, "10.0.0.0/24"); err != nil {
+			t.Fatalf("vn add %s error = %v", name, err)
+		}
+	}
+	sm, err := wedev.OpenStorageReadOnly(filepath.Join(os.Getenv("WEDEVCTL_DB_PATH"), "wedevctl.db"))
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer sm.Close()
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{"prod", "prod", nil},              // exact match wins over prodeu and prodeast
+		{"prodeu", "prodeu", nil},          // exact
+		{"prodea", "prodeast", nil},        // unique prefix
+		{"s", "stageeu", nil},              // unique prefix
+		{"prode", "", wedev.ErrValidation}, // ambiguous
+		{"qa", "", wedev.ErrNotFound},
+	}
+	for _, tt := range tests {
+		got, err := resolveNetworkName(t.Context(), sm, tt.name)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("resolveNetworkName(%q) = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := resolveNetworkName(t.Context(), sm, "prode"); err == nil || !strings.Contains(err.Error(), "prodeast, prodeu") {
+		t.Errorf("resolveNetworkName(\"prode\") error = %v, want the candidates", err)
+	}
+}
+
+// TestLeadingGlobalFlags tests skipping global flags given before 'vn <network>'
+func TestLeadingGlobalFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"prod", "node", "list"}, 0},
+		{[]string{"--db", "x.db", "prod"}, 2},
+		{[]string{"--db=x.db", "-v", "--db-timeout", "1s", "prod", "--db", "y"}, 4},
+		{[]string{"-q", "--db-timeout=2s"}, 2},
+		{[]string{"--assume", "delete=no", "--assume=yes", "prod"}, 3},
+		{[]string{"--passphrase-file", "pass.txt", "prod"}, 2},
+		{[]string{"--passphrase-file=pass.txt", "--db", "x.db", "prod"}, 3},
+		{[]string{"--db"}, 1},
+	}
+	for _, tt := range tests {
+		if got := leadingGlobalFlags(tt.args); got != tt.want {
+			t.Errorf("leadingGlobalFlags(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
 // TestDBDirectoryCreation tests that nested directories are created
 func TestDBDirectoryCreation(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -269,56 +944,94 @@ func TestDBDirectoryCreation(t *testing.T) {
 
 // TestConfirmActionYes tests confirmation with yes response
 func TestConfirmActionYes(t *testing.T) {
-	// Mock stdin with "y"
-	oldStdin := os.Stdin
-	r, w, err := os.Pipe()
-	if err != nil {
-		t.Fatalf("failed to create pipe: %v", err)
-	}
-	os.Stdin = r
-	defer func() {
-		os.Stdin = oldStdin
-		r.Close()
-	}()
-
-	// Write input and close writer in goroutine
-	go func() {
-		defer w.Close()
-		if _, err := w.WriteString("y\n"); err != nil {
-			t.Errorf("failed to write to pipe: %v", err)
-		}
-	}()
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader("y\n"))
+	cmd.SetOut(&out)
 
-	result := confirmAction("Test prompt")
-	if !result {
+	if !confirmAction(cmd, "Test prompt") {
 		t.Error("Expected true for 'y' input")
 	}
+	if out.String() != "Test prompt (y/n): " {
+		t.Errorf("prompt = %q, want it on the command's output", out.String())
+	}
 }
 
 // TestConfirmActionNo tests confirmation with no response
 func TestConfirmActionNo(t *testing.T) {
-	oldStdin := os.Stdin
-	r, w, err := os.Pipe()
-	if err != nil {
-		t.Fatalf("failed to create pipe: %v", err)
-	}
-	os.Stdin = r
-	defer func() {
-		os.Stdin = oldStdin
-		r.Close()
-	}()
-
-	// Write input and close writer in goroutine
-	go func() {
-		defer w.Close()
-		if _, err := w.WriteString("n\n"); err != nil {
-			t.Errorf("failed to write to pipe: %v", err)
+	for _, input := range []string{"n\n", ""} {
+		cmd := &cobra.Command{}
+		cmd.SetIn(strings.NewReader(input))
+		cmd.SetOut(io.Discard)
+
+		if confirmAction(cmd, "Test prompt") {
+			t.Errorf("Expected false for %q input", input)
+		}
+	}
+}
+
+func TestConfirmActionAssumed(t *testing.T) {
+	for _, yes := range []bool{true, false} {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.SetIn(strings.NewReader("y\n"))
+		cmd.SetOut(&out)
+		app := &App{assume: assumptions{promptDelete: {yes: yes, source: "$WEDEVCTL_ASSUME_DELETE"}}}
+
+		if got := confirmAction(cmd, "Delete?", assumeFor(app, promptDelete)); got != yes {
+			t.Errorf("confirmAction() with assumed %v = %v", yes, got)
+		}
+		if !strings.HasSuffix(out.String(), "(assumed by $WEDEVCTL_ASSUME_DELETE)\n") {
+			t.Errorf("prompt = %q, want it to name the assumed answer's source", out.String())
 		}
-	}()
+		// Other categories are still asked.
+		if !confirmAction(cmd, "Create?", assumeFor(app, promptCreate)) {
+			t.Error("confirmAction() for an unassumed category did not read the answer")
+		}
+	}
+}
 
-	result := confirmAction("Test prompt")
-	if result {
-		t.Error("Expected false for 'n' input")
+func TestResolveAssumptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		flags   []string
+		want    map[promptCategory]bool
+		wantErr bool
+	}{
+		{"none", nil, nil, map[promptCategory]bool{}, false},
+		{"blanket env", map[string]string{"WEDEVCTL_ASSUME": "yes"}, nil,
+			map[promptCategory]bool{promptCreate: true, promptDelete: true, promptOverwrite: true}, false},
+		{"category env beats blanket", map[string]string{"WEDEVCTL_ASSUME": "YES", "WEDEVCTL_ASSUME_OVERWRITE": "no"}, nil,
+			map[promptCategory]bool{promptCreate: true, promptDelete: true, promptOverwrite: false}, false},
+		{"flag beats env", map[string]string{"WEDEVCTL_ASSUME_DELETE": "yes"}, []string{"delete=no"},
+			map[promptCategory]bool{promptDelete: false}, false},
+		{"flag category beats flag blanket", nil, []string{"overwrite=no,create=yes", "no"},
+			map[promptCategory]bool{promptCreate: true, promptDelete: false, promptOverwrite: false}, false},
+		{"invalid env answer", map[string]string{"WEDEVCTL_ASSUME": "maybe"}, nil, nil, true},
+		{"invalid flag category", nil, []string{"rename=yes"}, nil, true},
+		{"invalid flag answer", nil, []string{"create=sure"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveAssumptions(tt.flags, func(name string) string { return tt.env[name] })
+			if tt.wantErr {
+				if !errors.Is(err, wedev.ErrValidation) {
+					t.Errorf("resolveAssumptions() error = %v, want ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveAssumptions() error = %v", err)
+			}
+			answers := map[promptCategory]bool{}
+			for category, answer := range got {
+				answers[category] = answer.yes
+			}
+			if !reflect.DeepEqual(answers, tt.want) {
+				t.Errorf("resolveAssumptions() = %v, want %v", answers, tt.want)
+			}
+		})
 	}
 }
 
@@ -329,7 +1042,7 @@ func TestVirtualNetworkCommandHelp(t *testing.T) {
 	os.Setenv("WEDEVCTL_DB_PATH", tmpDir)
 	defer os.Setenv("WEDEVCTL_DB_PATH", oldPath)
 
-	cmd := NewVirtualNetworkCommand()
+	cmd := NewVirtualNetworkCommand(&App{})
 	cmd.SetArgs([]string{})
 	cmd.SetOut(io.Discard)
 	cmd.SetErr(io.Discard)
@@ -357,41 +1070,63 @@ func TestVirtualNetworkCommandNonExistentNetwork(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for non-existent network")
 	}
-	if !strings.Contains(err.Error(), "not found") {
-		t.Errorf("Expected 'not found' error, got: %v", err)
+	if !errors.Is(err, wedev.ErrNotFound) || ExitCode(err) != ExitNotFound {
+		t.Errorf("Expected a not found error, got: %v", err)
 	}
 }
 
 // TestMakeServerCommand tests server command group creation
 func TestMakeServerCommand(t *testing.T) {
-	cmd := makeServerCommand("test-net")
+	cmd := makeServerCommand(&App{}, "test-net")
 	if cmd == nil {
 		t.Error("makeServerCommand returned nil")
 	}
-	if len(cmd.Commands()) != 4 {
-		t.Errorf("Expected 4 subcommands, got %d", len(cmd.Commands()))
+	if len(cmd.Commands()) != 7 {
+		t.Errorf("Expected 7 subcommands, got %d", len(cmd.Commands()))
 	}
 }
 
 // TestMakeNodeCommand tests node command group creation
 func TestMakeNodeCommand(t *testing.T) {
-	cmd := makeNodeCommand("test-net")
+	cmd := makeNodeCommand(&App{}, "test-net")
 	if cmd == nil {
 		t.Error("makeNodeCommand returned nil")
 	}
-	if len(cmd.Commands()) != 4 {
-		t.Errorf("Expected 4 subcommands, got %d", len(cmd.Commands()))
+	if len(cmd.Commands()) != 10 {
+		t.Errorf("Expected 10 subcommands, got %d", len(cmd.Commands()))
 	}
 }
 
 // TestMakeConfigCommand tests config command group creation
 func TestMakeConfigCommand(t *testing.T) {
-	cmd := makeConfigCommand("test-net")
+	cmd := makeConfigCommand(&App{}, "test-net")
 	if cmd == nil {
 		t.Error("makeConfigCommand returned nil")
 	}
-	if len(cmd.Commands()) != 3 {
-		t.Errorf("Expected 3 subcommands, got %d", len(cmd.Commands()))
+	if len(cmd.Commands()) != 14 {
+		t.Errorf("Expected 14 subcommands, got %d", len(cmd.Commands()))
+	}
+}
+
+// TestMakeNetworkCommand tests the dynamic 'vn <network>' command creation
+func TestMakeNetworkCommand(t *testing.T) {
+	cmd := makeNetworkCommand(&App{}, "test-net")
+	if cmd == nil {
+		t.Fatal("makeNetworkCommand returned nil")
+	}
+	if len(cmd.Commands()) != 16 {
+		t.Errorf("Expected 10 subcommands, got %d", len(cmd.Commands()))
+	}
+}
+
+// TestMakeIPCommand tests the ip command group
+func TestMakeIPCommand(t *testing.T) {
+	cmd := makeIPCommand(&App{}, "test-net")
+	if cmd.Use != "ip" {
+		t.Errorf("Expected 'ip', got '%s'", cmd.Use)
+	}
+	if len(cmd.Commands()) != 5 {
+		t.Errorf("Expected 5 subcommands, got %d", len(cmd.Commands()))
 	}
 }
 
@@ -406,3 +1141,32 @@ func TestRootCommandCreation(t *testing.T) {
 		t.Errorf("Expected 'wedevctl', got '%s'", cmd.Use)
 	}
 }
+
+func TestPrintVersionReport(t *testing.T) {
+	for _, tt := range []struct {
+		db   versionDatabase
+		want string
+	}{
+		{versionDatabase{SchemaVersion: 5, SupportedSchemaVersion: 5, Status: dbStatusCurrent}, "Schema:   5 (current)"},
+		{versionDatabase{SchemaVersion: 3, SupportedSchemaVersion: 5, Status: dbStatusOutdated}, "Schema:   3 (older than 5; migrated by the next command that writes)"},
+		{versionDatabase{SchemaVersion: 7, SupportedSchemaVersion: 5, Status: dbStatusNewer}, "Schema:   7 (WRITTEN BY A NEWER WEDEVCTL: this one supports up to 5; upgrade wedevctl)"},
+		{versionDatabase{SupportedSchemaVersion: 5, Status: dbStatusMissing}, "Schema:   none"},
+		{versionDatabase{SupportedSchemaVersion: 5, Status: dbStatusUnreadable, Error: "invalid schema version"}, "Schema:   unknown (invalid schema version)"},
+	} {
+		var out bytes.Buffer
+		report := versionReport{Database: tt.db}
+		report.Version = "v1.2.0"
+		if err := printVersionReport(&out, report, "table"); err != nil {
+			t.Fatalf("printVersionReport() error = %v", err)
+		}
+		if !strings.HasPrefix(out.String(), "wedevctl v1.2.0\n") || !strings.Contains(out.String(), tt.want) {
+			t.Errorf("printVersionReport() = %q, want the version and %q", out.String(), tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	report := versionReport{Database: versionDatabase{Path: "/p/.wedevctl/wedevctl.db", Source: dbSourceProject, Status: dbStatusMissing}}
+	if err := printVersionReport(&out, report, "table"); err != nil || !strings.Contains(out.String(), "Database: /p/.wedevctl/wedevctl.db (project-local)\n") {
+		t.Errorf("printVersionReport() = %q, %v; want the path and its source", out.String(), err)
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/wedevctl/wedev"
+)
+
+// networkFlagPlaceholder is the network name the top-level 'server', 'node'
+// and 'config' command trees are built with. Their network comes from
+// --network: each command runs its counterpart in the tree of that network
+// (see delegateToNetwork), and completions look it up (see
+// completionNetwork).
+const networkFlagPlaceholder = "<network>"
+
+// networkScopedCommands build the command groups that are also registered at
+// the top level, taking the network from --network instead of from
+// 'vn <network>'.
+var networkScopedCommands = []func(app *App, networkName string) *cobra.Command{
+	makeServerCommand,
+	makeNodeCommand,
+	makeConfigCommand,
+}
+
+// newNetworkScopedCommands returns the top-level 'server', 'node' and
+// 'config' commands. They are registered statically, so cobra parses their
+// flags, prints their help and suggests their names like any other command,
+// which the manually routed 'vn <network>' cannot.
+func newNetworkScopedCommands(app *App) []*cobra.Command {
+	commands := make([]*cobra.Command, 0, len(networkScopedCommands))
+	for _, build := range networkScopedCommands {
+		group := build(app, networkFlagPlaceholder)
+		group.Long += "\n\nThe network is given with --network; 'wedevctl vn <network> " + group.Name() + "' is the same."
+		group.PersistentFlags().String("network", "", "Network to manage")
+		//nolint:errcheck // The flag is declared just above
+		_ = group.MarkPersistentFlagRequired("network")
+		//nolint:errcheck // The flag is declared just above
+		_ = group.RegisterFlagCompletionFunc("network", func(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeNetworkNames(cmd, nil, toComplete)
+		})
+		delegateToNetwork(app, group, build)
+		commands = append(commands, group)
+	}
+	return commands
+}
+
+// delegateToNetwork makes every runnable command below group, built by build
+// for networkFlagPlaceholder, run its counterpart in the tree build makes for
+// the --network network, with the same arguments and flags. Both ways of
+// naming the network so share one implementation.
+func delegateToNetwork(app *App, group *cobra.Command, build func(app *App, networkName string) *cobra.Command) {
+	var walk func(cmd *cobra.Command, path []string)
+	walk = func(cmd *cobra.Command, path []string) {
+		for _, sub := range cmd.Commands() {
+			walk(sub, append(slices.Clone(path), sub.Name()))
+		}
+		if cmd.RunE == nil {
+			return
+		}
+		// The counterpart's PostRunE, such as the drift check, runs with it.
+		cmd.PostRunE = nil
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			networkName, err := cmd.Flags().GetString("network")
+			if err != nil {
+				return fmt.Errorf("failed to get network flag: %w", err)
+			}
+			if _, err := app.storage.GetNetworkByName(networkName); errors.Is(err, wedev.ErrNotFound) {
+				return withKind(wedev.ErrNotFound, fmt.Errorf("network '%s' not found. Use 'wedevctl vn list' to see available networks", networkName))
+			} else if err != nil {
+				return fmt.Errorf("failed to get network: %w", err)
+			}
+
+			tree := build(app, networkName)
+			target, _, err := tree.Find(path)
+			if err != nil {
+				return err
+			}
+			if err := copyFlags(cmd, target); err != nil {
+				return err
+			}
+			tree.SetIn(cmd.InOrStdin())
+			tree.SetOut(cmd.OutOrStdout())
+			tree.SetErr(cmd.ErrOrStderr())
+			target.SetContext(cmd.Context())
+
+			if err := target.RunE(target, args); err != nil {
+				return err
+			}
+			if target.PostRunE != nil {
+				return target.PostRunE(target, args)
+			}
+			return nil
+		}
+	}
+	walk(group, nil)
+}
+
+// copyFlags sets the flags given to from on to, which declares the same
+// ones. Flags to lacks, such as the global ones the root command already
+// applied, are skipped.
+func copyFlags(from, to *cobra.Command) error {
+	var err error
+	from.Flags().Visit(func(f *pflag.Flag) {
+		dst := to.Flags().Lookup(f.Name)
+		if dst == nil || err != nil {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			if dstSlice, ok := dst.Value.(pflag.SliceValue); ok {
+				err = dstSlice.Replace(slice.GetSlice())
+				dst.Changed = true
+				return
+			}
+		}
+		err = to.Flags().Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pass on flags: %w", err)
+	}
+	return nil
+}
+
+// completionNetwork looks up the network a completion is for: networkName,
+// or the --network flag of the line being completed when the command tree
+// was built for networkFlagPlaceholder.
+func completionNetwork(cmd *cobra.Command, sm *wedev.StorageManager, networkName string) (*wedev.VirtualNetwork, error) {
+	if networkName == networkFlagPlaceholder {
+		var err error
+		if networkName, err = cmd.Flags().GetString("network"); err != nil {
+			return nil, err
+		}
+	}
+	return sm.GetNetworkByName(networkName)
+}
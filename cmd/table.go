@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+// tableFlags adds --columns and --no-header to a command that lists its
+// results with printListTable.
+func tableFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("columns", nil, "Table columns to print, in order (e.g. name,virtual-ip)")
+	cmd.Flags().Bool("no-header", false, "Leave out the table header, for piping into other tools")
+}
+
+// noHeaderFlag returns --no-header, false for commands without it.
+func noHeaderFlag(cmd *cobra.Command) (bool, error) {
+	if cmd.Flags().Lookup("no-header") == nil {
+		return false, nil
+	}
+	noHeader, err := cmd.Flags().GetBool("no-header")
+	if err != nil {
+		return false, fmt.Errorf("failed to get no-header flag: %w", err)
+	}
+	return noHeader, nil
+}
+
+// printListTable prints the rows of a list command with printTable, keeping
+// the columns given with --columns and leaving out the header with
+// --no-header. With no rows it prints empty instead, unless the header is
+// left out: a script reading the table then sees no lines at all.
+func printListTable(cmd *cobra.Command, w io.Writer, empty string, header []string, rows [][]string) error {
+	columns, err := cmd.Flags().GetStringSlice("columns")
+	if err != nil {
+		return fmt.Errorf("failed to get columns flag: %w", err)
+	}
+	noHeader, err := noHeaderFlag(cmd)
+	if err != nil {
+		return err
+	}
+	if len(columns) > 0 {
+		if header, rows, err = selectColumns(header, rows, columns); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case len(rows) == 0 && !noHeader:
+		fmt.Fprintln(w, empty)
+	case noHeader:
+		printTable(w, nil, rows)
+	default:
+		printTable(w, header, rows)
+	}
+	return nil
+}
+
+// selectColumns returns header and rows reduced to columns, in that order.
+// A column is named by its header, in any case and with '-' or '_' for
+// spaces: "virtual-ip" selects "Virtual IP".
+func selectColumns(header []string, rows [][]string, columns []string) ([]string, [][]string, error) {
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = -1
+		for j, name := range header {
+			if columnKey(name) == columnKey(column) {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			names := make([]string, len(header))
+			for j, name := range header {
+				names[j] = strings.ReplaceAll(strings.ToLower(name), " ", "-")
+			}
+			return nil, nil, withKind(wedev.ErrValidation, fmt.Errorf("unknown column %q (available: %s)", column, strings.Join(names, ", ")))
+		}
+	}
+
+	pick := func(row []string) []string {
+		picked := make([]string, len(indexes))
+		for i, index := range indexes {
+			picked[i] = row[index]
+		}
+		return picked
+	}
+	selected := make([][]string, len(rows))
+	for i, row := range rows {
+		selected[i] = pick(row)
+	}
+	return pick(header), selected, nil
+}
+
+// columnKey normalizes a column name for matching.
+func columnKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '_' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(name))
+}
+
+// tableGutter separates the columns of printTable. It is wider than the
+// single spaces inside headers such as "PUBLIC KEY", so column boundaries
+// stay unambiguous for awk and cut.
+const tableGutter = "  "
+
+// printTable writes rows under a header line and a dashed rule, padding each
+// column but the last to its widest cell by display width and separating
+// columns with tableGutter. Cells are never truncated. A nil header leaves out
+// the header line and the rule.
+func printTable(w io.Writer, header []string, rows [][]string) {
+	all := rows
+	if header != nil {
+		all = append([][]string{header}, rows...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	widths := make([]int, len(all[0]))
+	for _, row := range all {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	writeRow := func(row []string) {
+		last := len(row) - 1
+		for i, cell := range row[:last] {
+			fmt.Fprint(w, cell, strings.Repeat(" ", widths[i]-displayWidth(cell)), tableGutter)
+		}
+		fmt.Fprintln(w, row[last])
+	}
+
+	if header != nil {
+		writeRow(header)
+		rule := (len(header) - 1) * len(tableGutter)
+		for _, width := range widths {
+			rule += width
+		}
+		fmt.Fprintln(w, strings.Repeat("-", rule))
+	}
+	for _, row := range rows {
+		writeRow(row)
+	}
+}
+
+// displayWidth returns the number of terminal columns s takes: combining
+// marks take none, and East Asian wide characters and emoji take two.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		case isWide(r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// wideRanges are the East Asian wide and fullwidth blocks and the emoji
+// blocks, each drawn two columns wide.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x2E80, 0x303E},   // CJK radicals, punctuation
+	{0x3041, 0xA4CF},   // Kana, CJK ideographs, Yi
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE30, 0xFE4F},   // CJK compatibility forms
+	{0xFF00, 0xFF60},   // Fullwidth forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x1F300, 0x1F6FF}, // Pictographs, emoticons, transport symbols
+	{0x1F900, 0x1F9FF}, // Supplemental pictographs
+	{0x20000, 0x3FFFD}, // CJK extensions
+}
+
+// isWide reports whether r is drawn two columns wide.
+func isWide(r rune) bool {
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/wedevctl/wedev"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, rewriting the file with
+// -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+	}
+}
+
+// tableHeader and tableRows are a node list with a long domain name and
+// cells wider or narrower than their length in bytes.
+var (
+	tableHeader = []string{"Name", "Virtual IP", "Public Address", "Labels"}
+	tableRows   = [][]string{
+		{"gateway", "10.0.0.2", "gateway-01.eu-central-1.compute.internal.example.com:51820", "-"},
+		{"café", "10.0.0.3", "198.51.100.7:51820", "site=zürich"},
+		{"東京", "10.0.0.4", "203.0.113.9:51820", "site=東京"},
+		{"n🚀", "10.0.0.5", "-", "-"},
+	}
+)
+
+// runListTable prints tableRows with printListTable as a command given args.
+func runListTable(t *testing.T, rows [][]string, args ...string) (string, error) {
+	t.Helper()
+	cmd := &cobra.Command{Use: "list"}
+	tableFlags(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatalf("ParseFlags(%v) error = %v", args, err)
+	}
+	var out bytes.Buffer
+	err := printListTable(cmd, &out, "No nodes found", tableHeader, rows)
+	return out.String(), err
+}
+
+func TestPrintTableAlignment(t *testing.T) {
+	var out bytes.Buffer
+	printTable(&out, tableHeader, tableRows)
+	checkGolden(t, "table_long_names.golden", out.String())
+}
+
+func TestPrintListTableColumns(t *testing.T) {
+	out, err := runListTable(t, tableRows, "--columns", "public-address,NAME,virtual_ip")
+	if err != nil {
+		t.Fatalf("printListTable() error = %v", err)
+	}
+	checkGolden(t, "table_columns.golden", out)
+
+	out, err = runListTable(t, tableRows, "--columns", "name,labels", "--no-header")
+	if err != nil {
+		t.Fatalf("printListTable(--no-header) error = %v", err)
+	}
+	checkGolden(t, "table_no_header.golden", out)
+
+	if _, err := runListTable(t, tableRows, "--columns", "name,endpoint"); !errors.Is(err, wedev.ErrValidation) {
+		t.Errorf("printListTable(unknown column) error = %v, want ErrValidation", err)
+	}
+	if out, err := runListTable(t, nil); err != nil || out != "No nodes found\n" {
+		t.Errorf("printListTable(no rows) = %q, %v", out, err)
+	}
+	if out, err := runListTable(t, nil, "--no-header"); err != nil || out != "" {
+		t.Errorf("printListTable(no rows, --no-header) = %q, %v; want nothing", out, err)
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	for s, want := range map[string]int{
+		"":         0,
+		"node":     4,
+		"zürich":   6,
+		"zu\u0308": 2, // u and a combining diaeresis
+		"東京":       4,
+		"n🚀":       3,
+	} {
+		if got := displayWidth(s); got != want {
+			t.Errorf("displayWidth(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/wedevctl/wedev"
+)
+
+// dashboardNetwork is one network as the ui dashboard shows it, read in a
+// single refresh.
+type dashboardNetwork struct {
+	network  *wedev.VirtualNetwork
+	servers  []*wedev.Server
+	nodes    []*wedev.Node
+	versions []*wedev.ConfigVersion
+}
+
+// loadDashboard reads every network with its servers, nodes, and config
+// history, networks and nodes sorted by name.
+func loadDashboard(ctx context.Context, sm *wedev.StorageManager) ([]dashboardNetwork, error) {
+	networks, err := sm.ListNetworksCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+
+	result := make([]dashboardNetwork, 0, len(networks))
+	for _, network := range networks {
+		servers, err := sm.ListServersByNetworkIDCtx(ctx, network.ID)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := sm.ListNodesByNetworkIDCtx(ctx, network.ID)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		versions, err := sm.ListConfigVersionsCtx(ctx, network.ID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dashboardNetwork{network: network, servers: servers, nodes: nodes, versions: versions})
+	}
+	return result, nil
+}
+
+// withReadOnlyStorage runs fn against a read-only handle on the database at
+// dbPath, closed again before it returns. The dashboard holds no handle
+// between refreshes, so other wedevctl commands can write meanwhile.
+func withReadOnlyStorage(dbPath string, fn func(sm *wedev.StorageManager) error) error {
+	sm, err := wedev.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck // Read-only handle; nothing to flush on close
+	defer func() { _ = sm.Close() }()
+
+	return fn(sm)
+}
+
+// dashboardPane is the part of the dashboard that has the keyboard focus.
+type dashboardPane int
+
+const (
+	paneNetworks dashboardPane = iota // network list on the left
+	paneNodes                         // node list of the selected network
+	paneConfig                        // full-screen config of the selected node
+)
+
+// Keys as bubbletea names them (tea.KeyMsg.String).
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyRight     = "right"
+	keyLeft      = "left"
+	keyEnter     = "enter"
+	keyTab       = "tab"
+	keyEsc       = "esc"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl+c"
+)
+
+// dashboard is the state of the 'ui' command and the bubbletea model that
+// shows it. It only reads: load returns a fresh snapshot of every network
+// and nodeConfig generates one node's config. handleKey and render are
+// independent of the terminal.
+type dashboard struct {
+	load       func() ([]dashboardNetwork, error)
+	nodeConfig func(network, node string) (string, error)
+
+	networks    []dashboardNetwork
+	selected    int // index into networks
+	node        int // index into the selected network's nodes
+	pane        dashboardPane
+	configTitle string
+	config      []string // redacted config lines shown in paneConfig
+	scroll      int      // first config line shown
+	status      string   // result of the last refresh
+	width       int      // terminal size from the last tea.WindowSizeMsg
+	height      int
+}
+
+// newDashboard creates a dashboard and loads its first snapshot.
+func newDashboard(load func() ([]dashboardNetwork, error), nodeConfig func(network, node string) (string, error)) (*dashboard, error) {
+	networks, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return &dashboard{load: load, nodeConfig: nodeConfig, networks: networks}, nil
+}
+
+// current returns the selected network, or nil when there is none.
+func (d *dashboard) current() *dashboardNetwork {
+	if d.selected >= len(d.networks) {
+		return nil
+	}
+	return &d.networks[d.selected]
+}
+
+// refresh reloads the snapshot, keeping the selected network and node by
+// name where they still exist. A config on screen is regenerated.
+func (d *dashboard) refresh() {
+	networks, err := d.load()
+	if err != nil {
+		d.status = "refresh failed: " + err.Error()
+		return
+	}
+
+	networkName, nodeName := "", ""
+	if net := d.current(); net != nil {
+		networkName = net.network.Name
+		if d.node < len(net.nodes) {
+			nodeName = net.nodes[d.node].Name
+		}
+	}
+
+	d.networks = networks
+	d.selected, d.node = 0, 0
+	for i, net := range networks {
+		if net.network.Name != networkName {
+			continue
+		}
+		d.selected = i
+		for j, node := range net.nodes {
+			if node.Name == nodeName {
+				d.node = j
+			}
+		}
+	}
+
+	if net := d.current(); net == nil || len(net.nodes) == 0 || net.nodes[d.node].Name != nodeName {
+		// The focused node is gone.
+		d.pane = paneNetworks
+	} else if d.pane == paneConfig {
+		scroll := d.scroll
+		d.showConfig()
+		d.scroll = min(scroll, max(len(d.config)-1, 0))
+	}
+	d.status = "refreshed at " + time.Now().Format("15:04:05")
+}
+
+// showConfig switches to the redacted config of the selected node. A config
+// that cannot be generated shows the reason instead.
+func (d *dashboard) showConfig() {
+	net := d.current()
+	if net == nil || d.node >= len(net.nodes) {
+		return
+	}
+	node := net.nodes[d.node]
+
+	config, err := d.nodeConfig(net.network.Name, node.Name)
+	if err != nil {
+		config = "No config for this node: " + err.Error()
+	}
+	d.configTitle = fmt.Sprintf("%s / %s", net.network.Name, node.Name)
+	d.config = strings.Split(strings.TrimRight(wedev.RedactConfig(config), "\n"), "\n")
+	d.scroll = 0
+	d.pane = paneConfig
+}
+
+// handleKey applies one key press and reports whether the dashboard should
+// quit.
+func (d *dashboard) handleKey(key string) bool {
+	switch key {
+	case "q", keyCtrlC:
+		return true
+	case "r":
+		d.refresh()
+		return false
+	}
+
+	net := d.current()
+	switch d.pane {
+	case paneNetworks:
+		switch key {
+		case keyUp, "k":
+			if d.selected > 0 {
+				d.selected--
+				d.node = 0
+			}
+		case keyDown, "j":
+			if d.selected < len(d.networks)-1 {
+				d.selected++
+				d.node = 0
+			}
+		case keyRight, "l", keyTab, keyEnter:
+			if net != nil && len(net.nodes) > 0 {
+				d.pane = paneNodes
+			}
+		}
+	case paneNodes:
+		switch key {
+		case keyUp, "k":
+			d.node = max(d.node-1, 0)
+		case keyDown, "j":
+			d.node = min(d.node+1, len(net.nodes)-1)
+		case keyLeft, "h", keyTab, keyEsc:
+			d.pane = paneNetworks
+		case keyEnter:
+			d.showConfig()
+		}
+	case paneConfig:
+		switch key {
+		case keyUp, "k":
+			d.scroll = max(d.scroll-1, 0)
+		case keyDown, "j":
+			d.scroll = min(d.scroll+1, max(len(d.config)-1, 0))
+		case keyLeft, "h", keyEsc, keyBackspace:
+			d.pane = paneNodes
+		}
+	}
+	return false
+}
+
+// render returns the screen as height lines of at most width runes.
+func (d *dashboard) render(width, height int) []string {
+	body := max(height-1, 0)
+	var lines []string
+	help := "up/down move  tab switch pane  enter show config  r refresh  q quit"
+
+	switch {
+	case len(d.networks) == 0:
+		lines = []string{
+			"No networks in the database.",
+			"Create one with 'wedevctl vn add <name> <cidr>', then press r to refresh.",
+		}
+		help = "r refresh  q quit"
+	case d.pane == paneConfig:
+		lines = append(lines, "Config: "+d.configTitle+" (secrets redacted)", "")
+		end := max(min(d.scroll+body-2, len(d.config)), d.scroll)
+		lines = append(lines, d.config[d.scroll:end]...)
+		help = "up/down scroll  esc back  r refresh  q quit"
+	default:
+		lines = d.renderPanes(body)
+	}
+
+	if d.status != "" {
+		help += "  | " + d.status
+	}
+	for len(lines) < body {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:body], help)
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > width {
+			lines[i] = string(runes[:width])
+		}
+	}
+	return lines
+}
+
+// renderPanes lays the network list out beside the selected network's
+// details, scrolled so the selected node stays visible.
+func (d *dashboard) renderPanes(body int) []string {
+	left := []string{"Networks"}
+	for i, net := range d.networks {
+		left = append(left, selectionMarker(i == d.selected, d.pane == paneNetworks)+net.network.Name)
+	}
+	leftWidth := 0
+	for _, line := range left {
+		leftWidth = max(leftWidth, len([]rune(line)))
+	}
+
+	right, selectedLine := d.networkDetails()
+	offset := 0
+	if selectedLine >= body {
+		offset = selectedLine - body + 1
+	}
+
+	lines := make([]string, 0, body)
+	for i := range body {
+		l, r := "", ""
+		if i < len(left) {
+			l = left[i]
+		}
+		if i+offset < len(right) {
+			r = right[i+offset]
+		}
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%-*s | %s", leftWidth, l, r), " "))
+	}
+	return lines
+}
+
+// networkDetails returns the right pane for the selected network and the
+// index of the selected node's line.
+func (d *dashboard) networkDetails() (lines []string, selectedLine int) {
+	net := d.current()
+	network := net.network
+	lines = append(lines, fmt.Sprintf("%s  %s  %s", network.Name, network.CIDR, network.EffectiveTopology()), "", "Servers")
+
+	if len(net.servers) == 0 {
+		lines = append(lines, "  (none)")
+	}
+	var rows [][]string
+	for _, server := range net.servers {
+		rows = append(rows, []string{server.Name, server.VirtualIP, endpoint(server.PublicAddress, server.Port)})
+	}
+	for _, row := range alignColumns(rows) {
+		lines = append(lines, "  "+row)
+	}
+
+	lines = append(lines, "", "Nodes")
+	if len(net.nodes) == 0 {
+		lines = append(lines, "  (none)")
+	}
+	rows = nil
+	for _, node := range net.nodes {
+		rows = append(rows, []string{node.Name, node.VirtualIP, string(node.Type), endpoint(node.PublicAddress, node.Port)})
+	}
+	for i, row := range alignColumns(rows) {
+		if i == d.node {
+			selectedLine = len(lines)
+		}
+		lines = append(lines, selectionMarker(i == d.node && d.pane == paneNodes, true)+row)
+	}
+
+	lines = append(lines, "", "Config history")
+	if len(net.versions) == 0 {
+		lines = append(lines, "  (no versions saved; run 'config generate')")
+	}
+	rows = nil
+	for i := len(net.versions) - 1; i >= 0; i-- {
+		v := net.versions[i]
+		rows = append(rows, []string{"v" + strconv.Itoa(v.Version), v.CreatedAt.Format("2006-01-02 15:04"), v.ChangedBy, v.Message})
+	}
+	for _, row := range alignColumns(rows) {
+		lines = append(lines, "  "+row)
+	}
+	return lines, selectedLine
+}
+
+// selectionMarker prefixes a list entry: "> " for the selection of the
+// focused pane, "* " for the selection of an unfocused one.
+func selectionMarker(selected, focused bool) string {
+	switch {
+	case selected && focused:
+		return "> "
+	case selected:
+		return "* "
+	}
+	return "  "
+}
+
+// endpoint formats a public address and port, or "-" without an address.
+func endpoint(address string, port int) string {
+	if address == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d", address, port)
+}
+
+// alignColumns pads the cells of rows into columns separated by two spaces.
+func alignColumns(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			fmt.Fprintf(&b, "%-*s  ", widths[i], cell)
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return lines
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Init implements tea.Model; the first snapshot is loaded by newDashboard.
+func (d *dashboard) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model: keys go to handleKey and a resize redraws
+// the dashboard at the new size.
+func (d *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if d.handleKey(msg.String()) {
+			return d, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		d.width, d.height = msg.Width, msg.Height
+	}
+	return d, nil
+}
+
+// View implements tea.Model, rendering at the last reported terminal size,
+// 80x24 before the first one arrives.
+func (d *dashboard) View() string {
+	width, height := d.width, d.height
+	if width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	return strings.Join(d.render(width, height), "\n")
+}
+
+// runDashboard shows d full screen, reading keys from in, until a quit key
+// is pressed or ctx is cancelled. A terminal in is restored on return.
+func runDashboard(ctx context.Context, in io.Reader, out io.Writer, d *dashboard) error {
+	p := tea.NewProgram(d,
+		tea.WithContext(ctx),
+		tea.WithInput(in),
+		tea.WithOutput(out),
+		tea.WithAltScreen(),
+	)
+	if _, err := p.Run(); err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+		return err
+	}
+	return nil
+}
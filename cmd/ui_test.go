@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/wedevctl/util"
+	"github.com/wedevctl/wedev"
+)
+
+// newTestDashboard creates a database with two networks and a dashboard
+// reading it the way 'ui' does.
+func newTestDashboard(t *testing.T) (*dashboard, *wedev.VirtualNetworkManager) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "wedevctl.db")
+	sm, err := wedev.NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sm.Close() })
+	vnm, err := wedev.NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+
+	for _, name := range []string{"office", "lab"} {
+		if _, err := vnm.CreateVirtualNetwork(name, "10.0.0.0/24"); err != nil {
+			t.Fatalf("CreateVirtualNetwork(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"laptop", "desktop"} {
+		if _, err := vnm.CreateNode("office", name, "", 51820, wedev.NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	generator := wedev.NewWireGuardConfigGenerator(sm)
+	if _, _, err := generator.SaveConfigVersionWithMessage("office", "first cut"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	d, err := newDashboard(
+		func() ([]dashboardNetwork, error) { return loadDashboard(context.Background(), sm) },
+		generator.GenerateConfig,
+	)
+	if err != nil {
+		t.Fatalf("newDashboard() error = %v", err)
+	}
+	return d, vnm
+}
+
+func screen(d *dashboard) string {
+	return strings.Join(d.render(120, 30), "\n")
+}
+
+func TestDashboardNavigation(t *testing.T) {
+	d, _ := newTestDashboard(t)
+
+	// Networks are sorted, so lab comes first and has no nodes to enter.
+	out := screen(d)
+	for _, want := range []string{"> lab", "  office", "lab  10.0.0.0/24  hub-spoke", "(none)", "no versions saved"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("initial screen missing %q:\n%s", want, out)
+		}
+	}
+	d.handleKey(keyEnter)
+	if d.pane != paneNetworks {
+		t.Error("entering a network without nodes should keep the network list focused")
+	}
+
+	d.handleKey("j")
+	d.handleKey(keyTab)
+	out = screen(d)
+	for _, want := range []string{"* office", "hub  10.0.0.1  vpn.example.com:51820", "> desktop", "  laptop", "v1", "first cut"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("office screen missing %q:\n%s", want, out)
+		}
+	}
+
+	d.handleKey(keyDown)
+	d.handleKey(keyEnter)
+	if d.pane != paneConfig {
+		t.Fatalf("enter on a node should show its config, pane = %v", d.pane)
+	}
+	out = screen(d)
+	if !strings.Contains(out, "Config: office / laptop (secrets redacted)") || !strings.Contains(out, "[Interface]") {
+		t.Errorf("config screen missing title or config:\n%s", out)
+	}
+	if !strings.Contains(out, "PrivateKey = "+wedev.Redacted) {
+		t.Errorf("config screen should redact the private key:\n%s", out)
+	}
+
+	d.handleKey(keyEsc)
+	if d.pane != paneNodes {
+		t.Errorf("esc from a config should return to the node list, pane = %v", d.pane)
+	}
+	if !d.handleKey("q") {
+		t.Error("q should quit")
+	}
+}
+
+func TestDashboardRefresh(t *testing.T) {
+	d, vnm := newTestDashboard(t)
+	d.handleKey(keyDown)
+	d.handleKey(keyTab)
+	d.handleKey(keyDown) // laptop
+
+	if _, err := vnm.CreateNode("office", "phone", "", 51820, wedev.NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(phone) error = %v", err)
+	}
+	if strings.Contains(screen(d), "phone") {
+		t.Fatal("a change should not show before a refresh")
+	}
+	d.handleKey("r")
+	out := screen(d)
+	if !strings.Contains(out, "phone") || !strings.Contains(out, "> laptop") || !strings.Contains(out, "refreshed at") {
+		t.Errorf("refresh should show phone and keep laptop selected:\n%s", out)
+	}
+
+	// Deleting the selected node moves the focus back to the networks.
+	if err := vnm.DeleteNode("office", "laptop"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+	d.handleKey("r")
+	if d.pane != paneNetworks || !strings.Contains(screen(d), "> office") {
+		t.Errorf("refresh after deleting the selected node:\n%s", screen(d))
+	}
+
+	d.load = func() ([]dashboardNetwork, error) { return nil, errors.New("locked") }
+	d.handleKey("r")
+	if !strings.Contains(screen(d), "refresh failed: locked") || len(d.networks) != 2 {
+		t.Errorf("a failed refresh should keep the last snapshot and say why:\n%s", screen(d))
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	d := &dashboard{}
+	out := screen(d)
+	if !strings.Contains(out, "No networks in the database.") || !strings.Contains(out, "r refresh  q quit") {
+		t.Errorf("empty database screen:\n%s", out)
+	}
+
+	d, _ = newTestDashboard(t)
+	lines := d.render(20, 5)
+	if len(lines) != 5 {
+		t.Errorf("render(20, 5) = %d lines, want 5", len(lines))
+	}
+	for _, line := range lines {
+		if len([]rune(line)) > 20 {
+			t.Errorf("line %q is wider than 20", line)
+		}
+	}
+
+	// A tiny screen still renders a config without panicking.
+	d.handleKey(keyDown)
+	d.handleKey(keyTab)
+	d.handleKey(keyEnter)
+	if lines := d.render(10, 1); len(lines) != 1 {
+		t.Errorf("render(10, 1) = %d lines, want 1", len(lines))
+	}
+}
+
+func TestDashboardUpdate(t *testing.T) {
+	d, _ := newTestDashboard(t)
+
+	if lines := strings.Split(d.View(), "\n"); len(lines) != 24 {
+		t.Errorf("View() before a size = %d lines, want 24", len(lines))
+	}
+	d.Update(tea.WindowSizeMsg{Width: 40, Height: 6})
+	if lines := strings.Split(d.View(), "\n"); len(lines) != 6 {
+		t.Errorf("View() after a resize to 40x6 = %d lines, want 6", len(lines))
+	}
+
+	d.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if !strings.Contains(d.View(), "> office") {
+		t.Errorf("View() after down:\n%s", d.View())
+	}
+	if _, cmd := d.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")}); cmd != nil {
+		t.Error("Update(j) returned a command, want none")
+	}
+	_, cmd := d.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil {
+		t.Fatal("Update(q) returned no command, want tea.Quit")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Errorf("Update(q) command = %T, want tea.QuitMsg", cmd())
+	}
+}
+
+func TestRunDashboard(t *testing.T) {
+	d, _ := newTestDashboard(t)
+
+	var out bytes.Buffer
+	if err := runDashboard(context.Background(), strings.NewReader("q"), &out, d); err != nil {
+		t.Fatalf("runDashboard() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "> lab") {
+		t.Errorf("runDashboard() output = %q, want the dashboard drawn", out.String())
+	}
+
+	// A cancelled context ends the dashboard without an error.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := runDashboard(ctx, strings.NewReader(""), &out, d); err != nil {
+		t.Errorf("runDashboard() after cancel error = %v", err)
+	}
+}
+
+func TestCLIUINeedsTerminal(t *testing.T) {
+	useTempDB(t)
+	_, err := runCLI(t, "", "ui")
+	if err == nil || !strings.Contains(err.Error(), "interactive terminal") {
+		t.Errorf("ui without a terminal error = %v, want interactive terminal error", err)
+	}
+}
@@ -1,17 +1,28 @@
 // Package main is the entry point for wedevctl CLI.
+//
+// Release builds stamp the version, commit and build date with -ldflags; see
+// package github.com/wedevctl/version.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	cmd "github.com/wedevctl/cmd"
 )
 
 func main() {
+	// Ctrl-C or SIGTERM cancels the command context, so long generations
+	// and running wg-quick commands stop promptly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	root := cmd.NewRootCommand()
-	if err := root.Execute(); err != nil {
+	err := root.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
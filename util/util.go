@@ -6,12 +6,24 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"math/bits"
 	"net"
+	"net/netip"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// ErrPoolExhausted is returned by IPPool.AllocateNodeIP when every usable
+// address is taken.
+var ErrPoolExhausted = errors.New("no available IPs in pool")
+
 // IPValidator validates network names and IP addresses
 type IPValidator interface {
 	IsValidNetworkName(name string) error
@@ -53,7 +65,20 @@ func (v *DefaultIPValidator) IsValidCIDR(cidr string) error {
 	return nil
 }
 
-// IsValidPublicAddress validates public address (domain or IP)
+// NormalizeCIDR returns cidr with its host bits cleared, so "10.0.0.1/24"
+// becomes "10.0.0.0/24". IPv6 prefixes are normalized the same way, in
+// their canonical compressed form.
+func NormalizeCIDR(cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR notation: %w", err)
+	}
+	return prefix.Masked().String(), nil
+}
+
+// IsValidPublicAddress validates a public address: an IPv4 or IPv6 address,
+// or a host name made of RFC 1123 labels. The port is configured separately,
+// so an address carrying one ("host:51820") is rejected.
 func (v *DefaultIPValidator) IsValidPublicAddress(addr string) error {
 	if addr == "" {
 		return fmt.Errorf("public address cannot be empty")
@@ -70,14 +95,69 @@ func (v *DefaultIPValidator) IsValidPublicAddress(addr string) error {
 	if ip := net.ParseIP(addr); ip != nil {
 		return nil
 	}
-	// Otherwise, it should be a valid domain name
-	// Basic validation: no spaces, contains at least one dot or is localhost
 	if strings.Contains(addr, " ") {
 		return fmt.Errorf("public address cannot contain spaces")
 	}
-	if !strings.Contains(addr, ".") && addr != "localhost" {
+	// Anything else with a colon is an address with a port, or a bracketed
+	// IPv6 address; the port would be appended a second time.
+	if strings.Contains(addr, ":") {
+		return fmt.Errorf("public address %q must not include a port; give the port as its own argument", addr)
+	}
+	if addr == "localhost" {
+		return nil
+	}
+	if !strings.Contains(addr, ".") {
 		return fmt.Errorf("public address must be a valid IP or domain name")
 	}
+	return validateHostname(addr)
+}
+
+// maxHostnameLength is the longest host name DNS can carry.
+const maxHostnameLength = 253
+
+// hostnameLabel matches one RFC 1123 host name label: letters, digits and
+// hyphens, neither starting nor ending with a hyphen.
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// validateHostname checks a dotted host name against RFC 1123. A name made
+// only of digits and dots is a malformed IPv4 address, not a host name.
+func validateHostname(name string) error {
+	if len(name) > maxHostnameLength {
+		return fmt.Errorf("host name %q is longer than %d characters", name, maxHostnameLength)
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return fmt.Errorf("host name %q has an empty label", name)
+		case len(label) > 63:
+			return fmt.Errorf("host name %q has a label longer than 63 characters", name)
+		case !hostnameLabel.MatchString(label):
+			return fmt.Errorf("host name %q has an invalid label %q (letters, digits and inner hyphens only)", name, label)
+		}
+	}
+	// A numeric top-level label means an IP address was intended.
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return fmt.Errorf("public address %q is not a valid IP address", name)
+	}
+	return nil
+}
+
+// ValidateAddressOutsideCIDR returns an error if addr is an IP address inside
+// cidr: a peer cannot be reached through the tunnel it is the endpoint of.
+// Host names are not resolved and always pass.
+func ValidateAddressOutsideCIDR(addr, cidr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR: %w", err)
+	}
+	if ipnet.Contains(ip) {
+		return fmt.Errorf("public address %s is inside the network's own range %s; use the host's address outside the VPN", addr, cidr)
+	}
 	return nil
 }
 
@@ -92,6 +172,7 @@ type IPPool struct {
 	serverIP    string          // Reserved server IP (first usable)
 	allocated   map[string]bool // Current allocated IPs: ip -> true
 	recycled    []string        // Recycled IPs (for reuse)
+	reserved    []IPRange       // Ranges never handed out, sorted by first address
 	nextIndex   int             // Next index to allocate from
 	firstUsable string
 	lastUsable  string
@@ -177,24 +258,36 @@ func (p *IPPool) GetServerIP() string {
 // Returns the IP or an error if no IPs are available
 func (p *IPPool) AllocateNodeIP() (string, error) {
 	// Try to reuse recycled IP first
-	if len(p.recycled) > 0 {
+	for len(p.recycled) > 0 {
 		ip := p.recycled[0]
 		p.recycled = p.recycled[1:]
+		if p.IsReserved(ip) {
+			continue
+		}
 		p.allocated[ip] = true
 		return ip, nil
 	}
 
-	// Allocate new IP if index doesn't exceed total
-	if p.nextIndex >= p.totalUsable {
-		return "", fmt.Errorf("no available IPs in pool (total usable: %d, allocated: %d)",
-			p.totalUsable, len(p.allocated))
-	}
-
 	// The IP at nextIndex is firstUsable + nextIndex — O(1) arithmetic.
 	firstVal, ok := ipToUint32(p.firstUsable)
 	if !ok {
 		return "", fmt.Errorf("invalid first usable IP: %s", p.firstUsable)
 	}
+
+	// Skip past reserved ranges in one step each.
+	for _, r := range p.reserved {
+		// #nosec G115 -- nextIndex is bounded by totalUsable, far below uint32 max.
+		next := firstVal + uint32(p.nextIndex)
+		if r.first <= next && next <= r.last {
+			p.nextIndex = int(r.last-firstVal) + 1
+		}
+	}
+
+	// Allocate new IP if index doesn't exceed total
+	if p.nextIndex >= p.totalUsable {
+		return "", fmt.Errorf("%w (total usable: %d, allocated: %d, reserved: %d)",
+			ErrPoolExhausted, p.totalUsable, len(p.allocated), p.ReservedCount())
+	}
 	p.nextIndex++
 
 	// #nosec G115 -- nextIndex is bounded by totalUsable, far below uint32 max.
@@ -212,6 +305,9 @@ func (p *IPPool) MarkIPAllocated(ip string) error {
 	if p.allocated[ip] {
 		return fmt.Errorf("IP %s is already allocated", ip)
 	}
+	if p.IsReserved(ip) {
+		return fmt.Errorf("IP %s is reserved", ip)
+	}
 	p.allocated[ip] = true
 	return nil
 }
@@ -251,6 +347,50 @@ func (p *IPPool) SyncNextIndex() {
 	p.nextIndex = maxIndex + 1
 }
 
+// Resize returns a copy of the pool for newCIDR, keeping every allocation,
+// the recycle list, and nextIndex. newCIDR must share the pool's first usable
+// address (the same network base), so allocation indexes keep their meaning;
+// every allocated IP must fall inside it.
+func (p *IPPool) Resize(newCIDR string) (*IPPool, error) {
+	resized, err := NewIPPool(newCIDR)
+	if err != nil {
+		return nil, err
+	}
+	if resized.firstUsable != p.firstUsable {
+		return nil, fmt.Errorf("CIDR %s does not share the network address of %s", newCIDR, p.networkCIDR)
+	}
+
+	firstVal, _ := ipToUint32(resized.firstUsable)
+	lastVal, _ := ipToUint32(resized.lastUsable)
+	var outside []string
+	for ip := range p.allocated {
+		v, ok := ipToUint32(ip)
+		if !ok || v < firstVal || v > lastVal {
+			outside = append(outside, ip)
+			continue
+		}
+		resized.allocated[ip] = true
+	}
+	if len(outside) > 0 {
+		sort.Strings(outside)
+		return nil, fmt.Errorf("allocated IPs outside %s: %s", newCIDR, strings.Join(outside, ", "))
+	}
+
+	for _, ip := range p.recycled {
+		if v, ok := ipToUint32(ip); ok && v >= firstVal && v <= lastVal {
+			resized.recycled = append(resized.recycled, ip)
+		}
+	}
+	for _, r := range p.reserved {
+		if !resized.containsRange(r) {
+			return nil, fmt.Errorf("reserved range %s is outside %s", r, newCIDR)
+		}
+	}
+	resized.reserved = append([]IPRange(nil), p.reserved...)
+	resized.nextIndex = p.nextIndex
+	return resized, nil
+}
+
 // ReleaseNodeIP returns an IP to the pool for recycling
 func (p *IPPool) ReleaseNodeIP(ip string) error {
 	if ip == p.serverIP {
@@ -274,6 +414,202 @@ func (p *IPPool) GetAllocatedIPs() map[string]bool {
 	return result
 }
 
+// Utilization returns how much of the pool is taken: allocated addresses (the
+// reserved server IP included), released addresses waiting to be reused,
+// and the total usable addresses of the subnet.
+func (p *IPPool) Utilization() (allocated, recycled, total int) {
+	allocated = len(p.allocated)
+	if !p.allocated[p.serverIP] {
+		// The server IP is reserved even before a server holds it.
+		allocated++
+	}
+	return allocated, len(p.recycled), p.totalUsable
+}
+
+// IPRange is an inclusive range of IPv4 addresses, written as a CIDR
+// (10.0.0.64/28), a first-last range (10.0.0.10-10.0.0.20) or a single
+// address.
+type IPRange struct {
+	first, last uint32
+	prefix      bool // written as a CIDR
+}
+
+// ParseIPRange parses an IPv4 range written as a CIDR, a first-last range or
+// a single address.
+func ParseIPRange(s string) (IPRange, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil || !prefix.Addr().Is4() {
+			return IPRange{}, fmt.Errorf("invalid range %q: not an IPv4 CIDR", s)
+		}
+		prefix = prefix.Masked()
+		first, _ := ipToUint32(prefix.Addr().String())
+		// #nosec G115 -- Bits() of an IPv4 prefix is 0 to 32.
+		size := uint64(1) << uint(32-prefix.Bits())
+		// #nosec G115 -- first+size-1 is the prefix's last IPv4 address.
+		return IPRange{first: first, last: uint32(uint64(first) + size - 1), prefix: true}, nil
+	}
+
+	firstStr, lastStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		lastStr = firstStr
+	}
+	first, okFirst := parseIPv4(strings.TrimSpace(firstStr))
+	last, okLast := parseIPv4(strings.TrimSpace(lastStr))
+	if !okFirst || !okLast {
+		return IPRange{}, fmt.Errorf("invalid range %q: expected an IPv4 CIDR, first-last range or address", s)
+	}
+	if first > last {
+		return IPRange{}, fmt.Errorf("invalid range %q: %s comes after %s", s, uint32ToIP(first), uint32ToIP(last))
+	}
+	return IPRange{first: first, last: last}, nil
+}
+
+// parseIPv4 parses a dotted-quad IPv4 address; unlike ipToUint32 it rejects
+// IPv6 forms of IPv4 addresses.
+func parseIPv4(s string) (uint32, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil || !addr.Is4() {
+		return 0, false
+	}
+	return ipToUint32(addr.String())
+}
+
+// String returns the range in the form ParseIPRange reads: the CIDR it was
+// written as, a single address, or first-last.
+func (r IPRange) String() string {
+	switch {
+	case r.prefix:
+		// The range holds 2^(32-bits) addresses.
+		return fmt.Sprintf("%s/%d", uint32ToIP(r.first), 33-bits.Len64(uint64(r.Size())))
+	case r.first == r.last:
+		return uint32ToIP(r.first)
+	}
+	return uint32ToIP(r.first) + "-" + uint32ToIP(r.last)
+}
+
+// Size returns the number of addresses in the range.
+func (r IPRange) Size() int {
+	return int(uint64(r.last) - uint64(r.first) + 1)
+}
+
+// Contains reports whether ip is in the range.
+func (r IPRange) Contains(ip string) bool {
+	v, ok := ipToUint32(ip)
+	return ok && r.first <= v && v <= r.last
+}
+
+// overlaps reports whether the two ranges share an address.
+func (r IPRange) overlaps(o IPRange) bool {
+	return r.first <= o.last && o.first <= r.last
+}
+
+// sortRanges sorts ranges by their first address.
+func sortRanges(ranges []IPRange) {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+}
+
+// containsRange reports whether r lies within the pool's network.
+func (p *IPPool) containsRange(r IPRange) bool {
+	firstVal, _ := ipToUint32(p.firstUsable)
+	lastVal, _ := ipToUint32(p.lastUsable)
+	// The network and broadcast addresses may be part of a CIDR range.
+	return r.first >= firstVal-1 && r.last <= lastVal+1
+}
+
+// IsReserved reports whether ip is in a reserved range of the pool.
+func (p *IPPool) IsReserved(ip string) bool {
+	for _, r := range p.reserved {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserved returns the reserved ranges of the pool, sorted by first address.
+func (p *IPPool) Reserved() []IPRange {
+	return append([]IPRange(nil), p.reserved...)
+}
+
+// ReservedCount returns the number of usable addresses in reserved ranges.
+func (p *IPPool) ReservedCount() int {
+	firstVal, _ := ipToUint32(p.firstUsable)
+	lastVal, _ := ipToUint32(p.lastUsable)
+	count := 0
+	for _, r := range p.reserved {
+		first, last := max(r.first, firstVal), min(r.last, lastVal)
+		if first <= last {
+			count += int(last-first) + 1
+		}
+	}
+	return count
+}
+
+// Reserve keeps the addresses of r from ever being allocated. The range must
+// lie within the network, leave out the server IP, and not overlap another
+// reserved range or any allocated address. Recycled addresses in the range
+// are dropped from the recycle list.
+func (p *IPPool) Reserve(r IPRange) error {
+	if !p.containsRange(r) {
+		return fmt.Errorf("range %s is outside the network %s", r, p.networkCIDR)
+	}
+	if r.Contains(p.serverIP) {
+		return fmt.Errorf("range %s includes the server IP %s", r, p.serverIP)
+	}
+	for _, other := range p.reserved {
+		if r.overlaps(other) {
+			return fmt.Errorf("range %s overlaps reserved range %s", r, other)
+		}
+	}
+	var taken []string
+	for ip := range p.allocated {
+		if r.Contains(ip) {
+			taken = append(taken, ip)
+		}
+	}
+	if len(taken) > 0 {
+		sort.Strings(taken)
+		return fmt.Errorf("range %s includes allocated IPs: %s", r, strings.Join(taken, ", "))
+	}
+
+	recycled := p.recycled[:0:0]
+	for _, ip := range p.recycled {
+		if !r.Contains(ip) {
+			recycled = append(recycled, ip)
+		}
+	}
+	p.recycled = recycled
+	p.reserved = append(p.reserved, r)
+	sortRanges(p.reserved)
+	return nil
+}
+
+// Unreserve removes the reserved range r, which must match a reservation
+// exactly. Its addresses below the next allocation index, which sequential
+// allocation has already passed, are recycled so they can be handed out.
+func (p *IPPool) Unreserve(r IPRange) error {
+	i := slices.IndexFunc(p.reserved, func(other IPRange) bool {
+		return other.first == r.first && other.last == r.last
+	})
+	if i < 0 {
+		return fmt.Errorf("range %s is not reserved", r)
+	}
+	p.reserved = slices.Delete(p.reserved, i, i+1)
+
+	firstVal, _ := ipToUint32(p.firstUsable)
+	lastVal, _ := ipToUint32(p.lastUsable)
+	// #nosec G115 -- nextIndex is bounded by totalUsable, far below uint32 max.
+	passed := firstVal + uint32(p.nextIndex)
+	for v := max(r.first, firstVal); v <= min(r.last, lastVal) && v < passed; v++ {
+		if ip := uint32ToIP(v); !p.allocated[ip] {
+			p.recycled = append(p.recycled, ip)
+		}
+	}
+	return nil
+}
+
 // GetState returns current state for persistence
 func (p *IPPool) GetState() *IPPoolState {
 	allocated := make([]string, 0, len(p.allocated))
@@ -282,11 +618,16 @@ func (p *IPPool) GetState() *IPPoolState {
 			allocated = append(allocated, ip)
 		}
 	}
+	var reserved []string
+	for _, r := range p.reserved {
+		reserved = append(reserved, r.String())
+	}
 	return &IPPoolState{
 		NetworkCIDR: p.networkCIDR,
 		ServerIP:    p.serverIP,
 		Allocated:   allocated,
 		Recycled:    p.recycled,
+		Reserved:    reserved,
 		NextIndex:   p.nextIndex,
 	}
 }
@@ -297,7 +638,11 @@ type IPPoolState struct {
 	ServerIP    string   `json:"server_ip"`
 	Allocated   []string `json:"allocated"`
 	Recycled    []string `json:"recycled"`
+	Reserved    []string `json:"reserved,omitempty"` // ranges kept from allocation, as ParseIPRange reads them
 	NextIndex   int      `json:"next_index"`
+	// Revision counts the times the state was saved; the storage layer sets
+	// it. A cached pool restored from an older revision is out of date.
+	Revision uint64 `json:"revision,omitempty"`
 }
 
 // RestoreIPPool creates an IP pool from saved state.
@@ -318,6 +663,16 @@ func RestoreIPPool(state *IPPoolState) (*IPPool, error) {
 	// Restore recycled IPs
 	pool.recycled = state.Recycled
 
+	// Restore reserved ranges
+	for _, spec := range state.Reserved {
+		r, err := ParseIPRange(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reserved range: %w", err)
+		}
+		pool.reserved = append(pool.reserved, r)
+	}
+	sortRanges(pool.reserved)
+
 	// Restore next index
 	pool.nextIndex = state.NextIndex
 
@@ -361,6 +716,170 @@ func GenerateWireGuardKeys() (*WireGuardKeyPair, error) {
 	}, nil
 }
 
+// wireGuardKeyLen is the length of a base64-encoded 32-byte WireGuard key.
+const wireGuardKeyLen = 44
+
+// ValidateWireGuardKey checks that key is a WireGuard key: 44 characters of
+// standard base64 decoding to 32 bytes.
+func ValidateWireGuardKey(key string) error {
+	if len(key) != wireGuardKeyLen {
+		return fmt.Errorf("invalid WireGuard key: must be %d base64 characters, got %d", wireGuardKeyLen, len(key))
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return fmt.Errorf("invalid WireGuard key: must be base64 encoding of 32 bytes")
+	}
+	return nil
+}
+
+// WireGuardPublicKey derives the public key of a base64 WireGuard private
+// key, as `wg pubkey` does.
+func WireGuardPublicKey(privateKey string) (string, error) {
+	if err := ValidateWireGuardKey(privateKey); err != nil {
+		return "", fmt.Errorf("private key: %w", err)
+	}
+	//nolint:errcheck // Decoding already succeeded in ValidateWireGuardKey
+	raw, _ := base64.StdEncoding.DecodeString(privateKey)
+
+	// X25519 clamps the scalar itself, so unclamped keys derive the same
+	// public key wg(8) would.
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to construct private key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// ParseWireGuardKeys builds a key pair from existing keys. With a private key
+// the public key is derived, and must match publicKey if that is also given.
+// With only a public key the pair has an empty private key: the peer's own
+// config is managed outside wedevctl.
+func ParseWireGuardKeys(privateKey, publicKey string) (*WireGuardKeyPair, error) {
+	if privateKey == "" {
+		if publicKey == "" {
+			return nil, fmt.Errorf("a private or public key is required")
+		}
+		if err := ValidateWireGuardKey(publicKey); err != nil {
+			return nil, fmt.Errorf("public key: %w", err)
+		}
+		return &WireGuardKeyPair{PublicKey: publicKey}, nil
+	}
+
+	derived, err := WireGuardPublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != "" && publicKey != derived {
+		return nil, fmt.Errorf("public key does not match the private key (derived %s)", derived)
+	}
+	return &WireGuardKeyPair{PrivateKey: privateKey, PublicKey: derived}, nil
+}
+
+// WireGuardConfigEntry is one "Key = Value" line of a WireGuard config.
+type WireGuardConfigEntry struct {
+	Key   string
+	Value string
+	Line  int // 1-based line number in the file
+}
+
+// WireGuardConfigSection is an [Interface] or [Peer] section of a WireGuard
+// config, with its entries in file order.
+type WireGuardConfigSection struct {
+	Name    string // "Interface" or "Peer"
+	Line    int    // line of the section header
+	Entries []WireGuardConfigEntry
+}
+
+// Get returns the entries of a key, which is matched case-insensitively as
+// wg(8) does. Keys such as Address and AllowedIPs may be given repeatedly.
+func (s *WireGuardConfigSection) Get(key string) []WireGuardConfigEntry {
+	var entries []WireGuardConfigEntry
+	for _, entry := range s.Entries {
+		if strings.EqualFold(entry.Key, key) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ParseWireGuardConfig parses a WireGuard config file, as written by wg-quick
+// or 'wg showconf', into its sections. Comments start with '#' and run to the
+// end of the line; blank lines are ignored. Section names are matched
+// case-insensitively and returned as "Interface" or "Peer". Errors name the
+// offending line.
+func ParseWireGuardConfig(content string) ([]WireGuardConfigSection, error) {
+	var sections []WireGuardConfigSection
+	for i, line := range strings.Split(content, "\n") {
+		lineNo := i + 1
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header %q", lineNo, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			switch {
+			case strings.EqualFold(name, "Interface"):
+				name = "Interface"
+			case strings.EqualFold(name, "Peer"):
+				name = "Peer"
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]: expected [Interface] or [Peer]", lineNo, name)
+			}
+			sections = append(sections, WireGuardConfigSection{Name: name, Line: lineNo})
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected Key = Value, got %q", lineNo, line)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key before '='", lineNo)
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("line %d: %s is outside of a section", lineNo, key)
+		}
+		section := &sections[len(sections)-1]
+		section.Entries = append(section.Entries, WireGuardConfigEntry{Key: key, Value: strings.TrimSpace(value), Line: lineNo})
+	}
+	return sections, nil
+}
+
+// ParseDuration parses a duration as time.ParseDuration does, also
+// accepting a leading number of days with the "d" suffix: "30d", "1d12h".
+// A day is 24 hours. Negative durations are rejected.
+func ParseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	rest := s
+	if n, after, ok := strings.Cut(s, "d"); ok && n != "" && strings.Trim(n, "0123456789") == "" {
+		count, err := strconv.Atoi(n)
+		if err != nil || count > int(math.MaxInt64/int64(24*time.Hour)) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		days = time.Duration(count) * 24 * time.Hour
+		if after == "" {
+			return days, nil
+		}
+		rest = after
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (expected e.g. 90m, 72h or 30d)", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
+	}
+	return days + d, nil
+}
+
 // ValidatePort checks that a port number is within the valid TCP/UDP range.
 func ValidatePort(port int) error {
 	if port < 1 || port > 65535 {
@@ -381,3 +900,97 @@ func ValidateEndpoint(address string, port int) error {
 func FormatEndpoint(address string, port int) string {
 	return fmt.Sprintf("%s:%d", address, port)
 }
+
+// ValidateLabelKey checks a label key: it must be non-empty and may not
+// contain whitespace or the selector operators '=', '!' and ','.
+func ValidateLabelKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("label key cannot be empty")
+	}
+	if strings.ContainsAny(key, " \t\n\r=!,") {
+		return fmt.Errorf("invalid label key %q: must not contain spaces, '=', '!' or ','", key)
+	}
+	return nil
+}
+
+// ParseLabels parses key=value pairs (as given to --label) into a map.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", pair)
+		}
+		if err := ValidateLabelKey(key); err != nil {
+			return nil, err
+		}
+		if strings.Contains(value, ",") {
+			return nil, fmt.Errorf("invalid label %q: value must not contain ','", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// LabelRequirement is one term of a label selector.
+type LabelRequirement struct {
+	Key      string
+	Value    string
+	NotEqual bool
+}
+
+// LabelSelector is a set of requirements that must all hold.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a comma-separated list of key=value and
+// key!=value expressions. An empty expression selects everything.
+func ParseLabelSelector(expr string) (LabelSelector, error) {
+	var selector LabelSelector
+	if strings.TrimSpace(expr) == "" {
+		return selector, nil
+	}
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		req := LabelRequirement{}
+		var ok bool
+		if req.Key, req.Value, ok = strings.Cut(term, "!="); ok {
+			req.NotEqual = true
+		} else if req.Key, req.Value, ok = strings.Cut(term, "="); !ok {
+			return nil, fmt.Errorf("invalid selector term %q: expected key=value or key!=value", term)
+		}
+		if err := ValidateLabelKey(req.Key); err != nil {
+			return nil, err
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// String returns the selector in the form ParseLabelSelector accepts.
+func (s LabelSelector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, req := range s {
+		op := "="
+		if req.NotEqual {
+			op = "!="
+		}
+		terms = append(terms, req.Key+op+req.Value)
+	}
+	return strings.Join(terms, ",")
+}
+
+// Matches reports whether labels satisfy every requirement. A key!=value
+// term also matches when the key is absent.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.Key]
+		if req.NotEqual {
+			if ok && value == req.Value {
+				return false
+			}
+		} else if !ok || value != req.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -3,7 +3,11 @@ package util
 import (
 	"crypto/ecdh"
 	"encoding/base64"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaultIPValidator_IsValidNetworkName(t *testing.T) {
@@ -62,6 +66,30 @@ func TestDefaultIPValidator_IsValidCIDR(t *testing.T) {
 	}
 }
 
+func TestNormalizeCIDR(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.1/24", "10.0.0.0/24", false},
+		{"192.168.37.200/16", "192.168.0.0/16", false},
+		{"10.0.0.0/24", "10.0.0.0/24", false},
+		{"10.0.0.5/32", "10.0.0.5/32", false},
+		{"fd00::1/64", "fd00::/64", false},
+		{"FD00:0:0:0::/64", "fd00::/64", false},
+		{"10.0.0.1", "", true},
+		{"10.0.0.0/33", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeCIDR(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeCIDR(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
 func TestDefaultIPValidator_IsValidPublicAddress(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -80,6 +108,44 @@ func TestDefaultIPValidator_IsValidPublicAddress(t *testing.T) {
 		{"carriage return injection", "example.com\rPostUp=evil", true},
 		{"tab injection", "example.com\tDNS=8.8.8.8", true},
 		{"del control char", "example.com\x7f", true},
+
+		// IP addresses
+		{"IPv6", "2001:db8::1", false},
+		{"IPv6 loopback", "::1", false},
+		{"IPv4-mapped IPv6", "::ffff:192.0.2.1", false},
+		{"octet over 255", "256.1.1.1", true},
+		{"three octets", "1.2.3", true},
+		{"five octets", "1.2.3.4.5", true},
+		{"leading zero octet", "01.2.3.4", true},
+		{"trailing dot IP", "1.2.3.4.", true},
+		{"negative octet", "1.2.3.-4", true},
+
+		// Addresses with a port
+		{"domain with port", "server.example.com:51820", true},
+		{"single label with port", "server:51820", true},
+		{"IPv4 with port", "192.168.1.1:51820", true},
+		{"bracketed IPv6", "[2001:db8::1]", true},
+		{"bracketed IPv6 with port", "[2001:db8::1]:51820", true},
+
+		// Host names (RFC 1123)
+		{"digit-leading label", "1password.example.com", false},
+		{"hyphenated label", "vpn-eu-1.example.com", false},
+		{"numeric inner label", "host.123.example.com", false},
+		{"mixed case", "VPN.Example.COM", false},
+		{"63 character label", strings.Repeat("a", 63) + ".com", false},
+		{"253 character name", strings.Repeat(strings.Repeat("a", 49)+".", 5) + "com", false},
+		{"double dot", "example..com", true},
+		{"leading dot", ".example.com", true},
+		{"trailing dot", "example.com.", true},
+		{"label starts with hyphen", "-vpn.example.com", true},
+		{"label ends with hyphen", "vpn-.example.com", true},
+		{"underscore", "vpn_1.example.com", true},
+		{"64 character label", strings.Repeat("a", 64) + ".com", true},
+		{"254 character name", strings.Repeat(strings.Repeat("a", 49)+".", 5) + "comm", true},
+		{"slash", "example.com/path", true},
+		{"at sign", "user@example.com", true},
+		{"numeric top-level label", "example.123", true},
+		{"non-ASCII", "exämple.com", true},
 	}
 
 	validator := NewDefaultIPValidator()
@@ -93,6 +159,53 @@ func TestDefaultIPValidator_IsValidPublicAddress(t *testing.T) {
 	}
 }
 
+func TestDefaultIPValidator_IsValidPublicAddress_Messages(t *testing.T) {
+	validator := NewDefaultIPValidator()
+	for _, tt := range []struct {
+		input string
+		want  string
+	}{
+		{"server:51820", "give the port as its own argument"},
+		{"example..com", "empty label"},
+		{"256.1.1.1", "not a valid IP address"},
+		{"vpn_1.example.com", `invalid label "vpn_1"`},
+		{strings.Repeat("a", 64) + ".com", "longer than 63 characters"},
+	} {
+		err := validator.IsValidPublicAddress(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("IsValidPublicAddress(%q) error = %v, want it to mention %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestValidateAddressOutsideCIDR(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		cidr    string
+		wantErr bool
+	}{
+		{"outside", "203.0.113.1", "10.0.0.0/24", false},
+		{"inside", "10.0.0.5", "10.0.0.0/24", true},
+		{"network address", "10.0.0.0", "10.0.0.0/24", true},
+		{"broadcast address", "10.0.0.255", "10.0.0.0/24", true},
+		{"just past the range", "10.0.1.0", "10.0.0.0/24", false},
+		{"IPv6 outside IPv4 range", "2001:db8::1", "10.0.0.0/24", false},
+		{"IPv6 inside", "fd00::5", "fd00::/64", true},
+		{"host name", "vpn.example.com", "10.0.0.0/24", false},
+		{"invalid CIDR", "10.0.0.5", "10.0.0.0/33", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddressOutsideCIDR(tt.addr, tt.cidr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAddressOutsideCIDR(%q, %q) error = %v, wantErr %v", tt.addr, tt.cidr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestNewIPPool(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -211,6 +324,62 @@ func TestIPPool_ReleaseAndReuse(t *testing.T) {
 	_ = ip3
 }
 
+func TestIPPool_Utilization(t *testing.T) {
+	type usage struct{ allocated, recycled, total int }
+	utilization := func(p *IPPool) usage {
+		a, r, total := p.Utilization()
+		return usage{a, r, total}
+	}
+
+	// A /30 has two usable addresses: the server's and one node's.
+	pool, err := NewIPPool("10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	if got, want := utilization(pool), (usage{1, 0, 2}); got != want {
+		t.Errorf("empty /30 Utilization() = %+v, want %+v", got, want)
+	}
+	ip, err := pool.AllocateNodeIP()
+	if err != nil {
+		t.Fatalf("AllocateNodeIP() error = %v", err)
+	}
+	if got, want := utilization(pool), (usage{2, 0, 2}); got != want {
+		t.Errorf("full /30 Utilization() = %+v, want %+v", got, want)
+	}
+	if err := pool.ReleaseNodeIP(ip); err != nil {
+		t.Fatalf("ReleaseNodeIP() error = %v", err)
+	}
+	if got, want := utilization(pool), (usage{1, 1, 2}); got != want {
+		t.Errorf("/30 after release Utilization() = %+v, want %+v", got, want)
+	}
+
+	// A /29 has six; the server IP marked allocated counts once.
+	pool, err = NewIPPool("10.0.0.0/29")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	if err := pool.MarkIPAllocated(pool.GetServerIP()); err != nil {
+		t.Fatalf("MarkIPAllocated() error = %v", err)
+	}
+	for range 3 {
+		if _, err := pool.AllocateNodeIP(); err != nil {
+			t.Fatalf("AllocateNodeIP() error = %v", err)
+		}
+	}
+	if got, want := utilization(pool), (usage{4, 0, 6}); got != want {
+		t.Errorf("/29 Utilization() = %+v, want %+v", got, want)
+	}
+
+	// A restored pool reports the same as the one it was saved from.
+	restored, err := RestoreIPPool(pool.GetState())
+	if err != nil {
+		t.Fatalf("RestoreIPPool() error = %v", err)
+	}
+	if got, want := utilization(restored), utilization(pool); got != want {
+		t.Errorf("restored /29 Utilization() = %+v, want %+v", got, want)
+	}
+}
+
 func TestIPPool_GetState_RestoreIPPool(t *testing.T) {
 	// Create and populate a pool
 	pool, err := NewIPPool("10.0.0.0/24")
@@ -415,3 +584,453 @@ func TestFormatEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"single", []string{"team=payments"}, map[string]string{"team": "payments"}, false},
+		{"multiple", []string{"team=payments", "env=prod"}, map[string]string{"team": "payments", "env": "prod"}, false},
+		{"empty value", []string{"canary="}, map[string]string{"canary": ""}, false},
+		{"missing equals", []string{"team"}, nil, true},
+		{"empty key", []string{"=payments"}, nil, true},
+		{"space in key", []string{"my team=payments"}, nil, true},
+		{"comma in value", []string{"team=a,b"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabels(tt.pairs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabels(%v) error = %v, wantErr %v", tt.pairs, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseLabels(%v) = %v, want %v", tt.pairs, got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("ParseLabels(%v)[%q] = %q, want %q", tt.pairs, k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{"team=payments", true, false},
+		{"team=search", false, false},
+		{"team!=search", true, false},
+		{"team!=payments", false, false},
+		{"tier!=gold", true, false},
+		{"tier=gold", false, false},
+		{"team=payments, env=prod", true, false},
+		{"team=payments,env!=prod", false, false},
+		{"team!=search,env=prod", true, false},
+		{"team!=search,env!=dev,tier!=gold", true, false},
+		{"team=payments,env=prod,tier=gold", false, false},
+		{"team=", false, false},
+		{"tier=", false, false},
+		{"team", false, true},
+		{"=payments", false, true},
+		{"team=payments,", false, true},
+		{"team!=", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			selector, err := ParseLabelSelector(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabelSelector(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := selector.Matches(labels); got != tt.want {
+				t.Errorf("ParseLabelSelector(%q).Matches() = %v, want %v", tt.expr, got, tt.want)
+			}
+			reparsed, err := ParseLabelSelector(selector.String())
+			if err != nil || !slices.Equal(reparsed, selector) {
+				t.Errorf("ParseLabelSelector(%q) = %v, %v; want %v", selector.String(), reparsed, err, selector)
+			}
+		})
+	}
+}
+
+func TestIPPool_Resize(t *testing.T) {
+	pool, err := NewIPPool("10.0.0.0/29")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	if err := pool.MarkIPAllocated(pool.GetServerIP()); err != nil {
+		t.Fatalf("MarkIPAllocated() error = %v", err)
+	}
+	var last string
+	for {
+		ip, err := pool.AllocateNodeIP()
+		if err != nil {
+			break
+		}
+		last = ip
+	}
+	if err := pool.ReleaseNodeIP("10.0.0.3"); err != nil {
+		t.Fatalf("ReleaseNodeIP() error = %v", err)
+	}
+
+	resized, err := pool.Resize("10.0.0.0/28")
+	if err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	if resized.GetServerIP() != pool.GetServerIP() {
+		t.Errorf("Resize() server IP = %s, want %s", resized.GetServerIP(), pool.GetServerIP())
+	}
+	if !resized.GetAllocatedIPs()[last] {
+		t.Errorf("Resize() dropped allocation %s", last)
+	}
+	// The recycled address is reused first, then allocation continues past
+	// the old range.
+	if ip, _ := resized.AllocateNodeIP(); ip != "10.0.0.3" {
+		t.Errorf("first allocation after Resize() = %s, want recycled 10.0.0.3", ip)
+	}
+	if ip, err := resized.AllocateNodeIP(); err != nil || ip != "10.0.0.7" {
+		t.Errorf("second allocation after Resize() = %s (err %v), want 10.0.0.7", ip, err)
+	}
+
+	if _, err := pool.Resize("10.0.0.0/30"); err == nil || !strings.Contains(err.Error(), "10.0.0.6") {
+		t.Errorf("Resize() to a smaller range error = %v, want it to list 10.0.0.6", err)
+	}
+	if _, err := pool.Resize("10.0.1.0/24"); err == nil {
+		t.Error("Resize() to a different network address should fail")
+	}
+}
+
+// RFC 7748 §6.1 X25519 test vector (Alice), base64-encoded as wg(8) prints it.
+const (
+	testPrivateKey = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	testPublicKey  = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+)
+
+func TestValidateWireGuardKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"valid key", testPublicKey, false},
+		{"empty", "", true},
+		{"too short", testPublicKey[:43], true},
+		{"not base64", strings.Repeat("!", 44), true},
+		{"wrong decoded length", base64Of(33)[:44], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateWireGuardKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateWireGuardKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// base64Of returns the base64 encoding of n zero bytes.
+func base64Of(n int) string {
+	return base64.StdEncoding.EncodeToString(make([]byte, n))
+}
+
+func TestParseWireGuardKeys(t *testing.T) {
+	generated, err := GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		privateKey string
+		publicKey  string
+		want       WireGuardKeyPair
+		wantErr    bool
+	}{
+		{"derives RFC 7748 public key", testPrivateKey, "", WireGuardKeyPair{testPrivateKey, testPublicKey}, false},
+		{"matching public key", testPrivateKey, testPublicKey, WireGuardKeyPair{testPrivateKey, testPublicKey}, false},
+		{"derives generated public key", generated.PrivateKey, "", *generated, false},
+		{"public key only", "", testPublicKey, WireGuardKeyPair{"", testPublicKey}, false},
+		{"mismatched public key", testPrivateKey, generated.PublicKey, WireGuardKeyPair{}, true},
+		{"invalid private key", "not-a-key", "", WireGuardKeyPair{}, true},
+		{"invalid public key", "", "not-a-key", WireGuardKeyPair{}, true},
+		{"no keys", "", "", WireGuardKeyPair{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWireGuardKeys(tt.privateKey, tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWireGuardKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("ParseWireGuardKeys() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWireGuardConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []WireGuardConfigSection
+		wantErr string
+	}{
+		{
+			name: "interface and peers",
+			content: "# network: net\n[Interface]\nPrivateKey = abc=\nAddress = 10.0.0.1/24\n\n" +
+				"# a (10.0.0.2)\n[Peer]\nPublicKey=def=\nAllowedIPs = 10.0.0.2/32, 192.168.1.0/24 # LAN\n",
+			want: []WireGuardConfigSection{
+				{Name: "Interface", Line: 2, Entries: []WireGuardConfigEntry{
+					{Key: "PrivateKey", Value: "abc=", Line: 3},
+					{Key: "Address", Value: "10.0.0.1/24", Line: 4},
+				}},
+				{Name: "Peer", Line: 7, Entries: []WireGuardConfigEntry{
+					{Key: "PublicKey", Value: "def=", Line: 8},
+					{Key: "AllowedIPs", Value: "10.0.0.2/32, 192.168.1.0/24", Line: 9},
+				}},
+			},
+		},
+		{
+			name:    "section names ignore case",
+			content: "[interface]\r\n  ListenPort = 51820\r\n[PEER]\r\n",
+			want: []WireGuardConfigSection{
+				{Name: "Interface", Line: 1, Entries: []WireGuardConfigEntry{{Key: "ListenPort", Value: "51820", Line: 2}}},
+				{Name: "Peer", Line: 3},
+			},
+		},
+		{name: "empty", content: "\n# nothing\n"},
+		{name: "key outside a section", content: "# header\nAddress = 10.0.0.1/24\n", wantErr: "line 2:"},
+		{name: "line without a value", content: "[Interface]\nAddress\n", wantErr: "line 2: expected Key = Value"},
+		{name: "missing key", content: "[Interface]\n= 10.0.0.1\n", wantErr: "line 2: missing key"},
+		{name: "unknown section", content: "[Interface]\n[Network]\n", wantErr: "line 2: unknown section [Network]"},
+		{name: "unterminated section", content: "[Peer\n", wantErr: "line 1: unterminated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWireGuardConfig(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseWireGuardConfig() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWireGuardConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWireGuardConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWireGuardConfigSection_Get(t *testing.T) {
+	section := WireGuardConfigSection{Name: "Interface", Entries: []WireGuardConfigEntry{
+		{Key: "Address", Value: "10.0.0.1/24", Line: 2},
+		{Key: "DNS", Value: "10.0.0.1", Line: 3},
+		{Key: "address", Value: "fd00::1/64", Line: 4},
+	}}
+	got := section.Get("ADDRESS")
+	if len(got) != 2 || got[0].Line != 2 || got[1].Line != 4 {
+		t.Errorf("Get(ADDRESS) = %+v, want the entries of lines 2 and 4", got)
+	}
+	if got := section.Get("ListenPort"); got != nil {
+		t.Errorf("Get(ListenPort) = %+v, want none", got)
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		size     int
+	}{
+		{"10.0.0.64/28", "10.0.0.64/28", 16},
+		{"10.0.0.70/28", "10.0.0.64/28", 16},
+		{"10.0.0.10-10.0.0.20", "10.0.0.10-10.0.0.20", 11},
+		{" 10.0.0.10 - 10.0.0.10 ", "10.0.0.10", 1},
+		{"10.0.0.5", "10.0.0.5", 1},
+		{"10.0.0.5/32", "10.0.0.5/32", 1},
+	} {
+		r, err := ParseIPRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseIPRange(%q) error = %v", tt.in, err)
+			continue
+		}
+		if r.String() != tt.want || r.Size() != tt.size {
+			t.Errorf("ParseIPRange(%q) = %s (%d addresses), want %s (%d)", tt.in, r, r.Size(), tt.want, tt.size)
+		}
+	}
+	for _, in := range []string{"", "10.0.0.20-10.0.0.10", "10.0.0.1-", "fd00::/64", "::ffff:10.0.0.1", "host.example.com", "10.0.0.0/33"} {
+		if _, err := ParseIPRange(in); err == nil {
+			t.Errorf("ParseIPRange(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestIPPool_Reserve(t *testing.T) {
+	pool, err := NewIPPool("10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	mustRange := func(s string) IPRange {
+		r, err := ParseIPRange(s)
+		if err != nil {
+			t.Fatalf("ParseIPRange(%q) error = %v", s, err)
+		}
+		return r
+	}
+
+	first, _ := pool.AllocateNodeIP()  // 10.0.0.2
+	second, _ := pool.AllocateNodeIP() // 10.0.0.3
+	if err := pool.ReleaseNodeIP(second); err != nil {
+		t.Fatalf("ReleaseNodeIP() error = %v", err)
+	}
+
+	for _, tt := range []struct{ spec, want string }{
+		{"10.0.0.1", "server IP"},
+		{first, "allocated IPs: " + first},
+		{"10.0.1.0/28", "outside the network"},
+	} {
+		if err := pool.Reserve(mustRange(tt.spec)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Reserve(%s) error = %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+
+	// Reserving drops the recycled address inside the range, and allocation
+	// skips the reserved addresses.
+	if err := pool.Reserve(mustRange("10.0.0.3-10.0.0.5")); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := pool.Reserve(mustRange("10.0.0.4")); err == nil || !strings.Contains(err.Error(), "overlaps reserved range 10.0.0.3-10.0.0.5") {
+		t.Errorf("Reserve() of an overlapping range error = %v", err)
+	}
+	if err := pool.Reserve(mustRange("10.0.0.6/31")); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if ip, err := pool.AllocateNodeIP(); err != nil || ip != "10.0.0.8" {
+		t.Errorf("AllocateNodeIP() = %s, %v; want 10.0.0.8 past both reserved ranges", ip, err)
+	}
+	if !pool.IsReserved("10.0.0.7") || pool.IsReserved("10.0.0.8") {
+		t.Errorf("IsReserved() disagrees with the reserved ranges")
+	}
+	if err := pool.MarkIPAllocated("10.0.0.4"); err == nil {
+		t.Errorf("MarkIPAllocated() of a reserved IP succeeded")
+	}
+	if got := pool.ReservedCount(); got != 5 {
+		t.Errorf("ReservedCount() = %d, want 5", got)
+	}
+
+	// Reservations survive a save and restore.
+	restored, err := RestoreIPPool(pool.GetState())
+	if err != nil {
+		t.Fatalf("RestoreIPPool() error = %v", err)
+	}
+	if got := restored.GetState().Reserved; !slices.Equal(got, []string{"10.0.0.3-10.0.0.5", "10.0.0.6/31"}) {
+		t.Errorf("restored Reserved = %v", got)
+	}
+	if ip, err := restored.AllocateNodeIP(); err != nil || ip != "10.0.0.9" {
+		t.Errorf("restored AllocateNodeIP() = %s, %v; want 10.0.0.9", ip, err)
+	}
+
+	// Unreserving needs the same range; the addresses already passed are
+	// recycled.
+	if err := restored.Unreserve(mustRange("10.0.0.3-10.0.0.4")); err == nil {
+		t.Errorf("Unreserve() of a partial range succeeded")
+	}
+	if err := restored.Unreserve(mustRange("10.0.0.3-10.0.0.5")); err != nil {
+		t.Fatalf("Unreserve() error = %v", err)
+	}
+	if got := restored.GetState().Recycled; !slices.Equal(got, []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"}) {
+		t.Errorf("Recycled after Unreserve() = %v", got)
+	}
+	if ip, err := restored.AllocateNodeIP(); err != nil || ip != "10.0.0.3" {
+		t.Errorf("AllocateNodeIP() after Unreserve() = %s, %v; want 10.0.0.3", ip, err)
+	}
+}
+
+func TestIPPool_ReserveExhaustsPool(t *testing.T) {
+	// A /29 has six usable addresses; with the server's and four reserved,
+	// one is left.
+	pool, err := NewIPPool("10.0.0.0/29")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	r, _ := ParseIPRange("10.0.0.3-10.0.0.6")
+	if err := pool.Reserve(r); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if ip, err := pool.AllocateNodeIP(); err != nil || ip != "10.0.0.2" {
+		t.Fatalf("AllocateNodeIP() = %s, %v; want 10.0.0.2", ip, err)
+	}
+	if _, err := pool.AllocateNodeIP(); err == nil {
+		t.Fatal("AllocateNodeIP() succeeded in a pool left without addresses")
+	}
+}
+
+func TestIPPool_ResizeKeepsReservations(t *testing.T) {
+	pool, err := NewIPPool("10.0.0.0/25")
+	if err != nil {
+		t.Fatalf("NewIPPool() error = %v", err)
+	}
+	r, _ := ParseIPRange("10.0.0.64/28")
+	if err := pool.Reserve(r); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	resized, err := pool.Resize("10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	if !resized.IsReserved("10.0.0.70") {
+		t.Error("Resize() dropped the reservation")
+	}
+	if _, err := pool.Resize("10.0.0.0/26"); err == nil {
+		t.Error("Resize() to a CIDR leaving out a reservation succeeded")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"72h", 72 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"0d", 0, false},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"-3h", 0, true},
+		{"1d-3h", 0, true},
+		{"7days", 0, true},
+		{"99999999999d", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package version holds the wedevctl build information. Release builds set
+// it at link time:
+//
+//	go build -ldflags "-X github.com/wedevctl/version.Version=v1.2.0 \
+//	  -X github.com/wedevctl/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/wedevctl/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X"; see the package documentation.
+var (
+	// Version is the release, written into config headers. "dev" for
+	// builds that do not set it.
+	Version = "dev"
+	// Commit is the git commit the binary was built from.
+	Commit = ""
+	// Date is when the binary was built, in RFC 3339.
+	Date = ""
+)
+
+// Info is the build information of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information. A commit or date not set at link time
+// is taken from the VCS stamp 'go build' records in a git checkout, and is
+// "unknown" without one.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		vcs := make(map[string]string)
+		for _, setting := range build.Settings {
+			vcs[setting.Key] = setting.Value
+		}
+		if info.Commit == "" && vcs["vcs.revision"] != "" {
+			info.Commit = vcs["vcs.revision"]
+			if vcs["vcs.modified"] == "true" {
+				info.Commit += "-dirty"
+			}
+		}
+		if info.Date == "" {
+			info.Date = vcs["vcs.time"]
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
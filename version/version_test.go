@@ -0,0 +1,22 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+
+	Version, Commit, Date = "v1.2.0", "3f9c2a1", "2026-10-01T12:00:00Z"
+	want := Info{Version: "v1.2.0", Commit: "3f9c2a1", Date: "2026-10-01T12:00:00Z", GoVersion: runtime.Version()}
+	if got := Get(); got != want {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+
+	// Test binaries carry no VCS stamp, so unset values fall back.
+	Version, Commit, Date = "dev", "", ""
+	if got := Get(); got.Version != "dev" || got.Commit != "unknown" || got.Date != "unknown" {
+		t.Errorf("Get() = %+v, want dev with unknown commit and date", got)
+	}
+}
@@ -0,0 +1,207 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// MaxAddressHistory is how many previous public addresses each server and
+// node keeps.
+const MaxAddressHistory = 10
+
+// An address that changed FlappingChanges times within FlappingWindow is
+// reported as flapping by CheckEndpoints.
+const (
+	FlappingChanges = 3
+	FlappingWindow  = 24 * time.Hour
+)
+
+// AddressChange is a public address a server or node had until a time.
+type AddressChange struct {
+	Address string    `json:"address"`
+	Until   time.Time `json:"until"`
+}
+
+// EndpointHistory is kept on server and node records: the public addresses
+// they had before, oldest first, and what the current address resolved to
+// at the last CheckEndpoints.
+type EndpointHistory struct {
+	AddressHistory []AddressChange `json:"address_history,omitempty"`
+	ResolvedIPs    []string        `json:"resolved_ips,omitempty"`
+	ResolvedAt     *time.Time      `json:"resolved_at,omitempty"`
+}
+
+// trackAddress records that the public address changed from old to current
+// at at, dropping the oldest entries past MaxAddressHistory. The resolution
+// of the old address no longer applies and is cleared.
+func (h *EndpointHistory) trackAddress(old, current string, at time.Time) {
+	if old == current {
+		return
+	}
+	if old != "" {
+		h.AddressHistory = append(h.AddressHistory, AddressChange{Address: old, Until: at})
+		if over := len(h.AddressHistory) - MaxAddressHistory; over > 0 {
+			h.AddressHistory = slices.Delete(h.AddressHistory, 0, over)
+		}
+	}
+	h.ResolvedIPs, h.ResolvedAt = nil, nil
+}
+
+// changesSince counts the address changes at or after since.
+func (h *EndpointHistory) changesSince(since time.Time) int {
+	count := 0
+	for _, change := range h.AddressHistory {
+		if !change.Until.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// Endpoint check rules, the Rule of an EndpointFinding.
+const (
+	EndpointUnresolved = "unresolved"
+	EndpointChanged    = "resolution_changed"
+	EndpointFlapping   = "address_flapping"
+)
+
+// EndpointFinding is a public address CheckEndpoints flagged.
+type EndpointFinding struct {
+	Rule     string   `json:"rule"`
+	Entity   string   `json:"entity"` // "server <name>" or "node <name>"
+	Address  string   `json:"address"`
+	Resolved []string `json:"resolved,omitempty"`
+	Previous []string `json:"previous,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// EndpointReport is the result of CheckEndpoints.
+type EndpointReport struct {
+	Network  string            `json:"network"`
+	Resolved int               `json:"resolved"` // hostnames looked up
+	Findings []EndpointFinding `json:"findings"`
+}
+
+// CheckEndpoints looks up the public address of each server and node that
+// is a hostname and flags those that do not resolve, or resolve to other
+// addresses than at the last check, which leaves configs generated since
+// pointing at a stale endpoint. Addresses changed FlappingChanges times
+// within FlappingWindow are flagged too. The resolutions are stored for the
+// next check. A nil resolver uses the system resolver.
+func (vnm *VirtualNetworkManager) CheckEndpoints(ctx context.Context, networkName string, resolver func(context.Context, string) ([]string, error)) (*EndpointReport, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver.LookupHost
+	}
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := vnm.now()
+	report := &EndpointReport{Network: network.Name, Findings: []EndpointFinding{}}
+	resolutions := make(map[string][]string)
+	check := func(id, entity, address string, history *EndpointHistory) error {
+		if changes := history.changesSince(now.Add(-FlappingWindow)); changes >= FlappingChanges {
+			report.Findings = append(report.Findings, EndpointFinding{Rule: EndpointFlapping, Entity: entity, Address: address,
+				Message: fmt.Sprintf("%s changed its public address %d times in the last %g hours", entity, changes, FlappingWindow.Hours())})
+		}
+		if _, err := netip.ParseAddr(address); address == "" || err == nil {
+			return nil
+		}
+
+		report.Resolved++
+		lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		ips, err := resolver(lookupCtx, address)
+		cancel()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			report.Findings = append(report.Findings, EndpointFinding{Rule: EndpointUnresolved, Entity: entity, Address: address, Previous: history.ResolvedIPs,
+				Message: fmt.Sprintf("%s does not resolve: %v", address, err)})
+			return nil
+		}
+		slices.Sort(ips)
+		ips = slices.Compact(ips)
+		if len(history.ResolvedIPs) > 0 && !slices.Equal(ips, history.ResolvedIPs) {
+			report.Findings = append(report.Findings, EndpointFinding{Rule: EndpointChanged, Entity: entity, Address: address, Resolved: ips, Previous: history.ResolvedIPs,
+				Message: fmt.Sprintf("%s resolves to %s, not %s as at the last check", address, strings.Join(ips, ", "), strings.Join(history.ResolvedIPs, ", "))})
+		}
+		resolutions[id] = ips
+		return nil
+	}
+	for _, server := range servers {
+		if err := check(server.ID, "server "+server.Name, server.PublicAddress, &server.EndpointHistory); err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range nodes {
+		if err := check(node.ID, "node "+node.Name, node.PublicAddress, &node.EndpointHistory); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(resolutions) > 0 {
+		if err := vnm.storage.RecordResolutions(resolutions, now); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// RecordResolutions stores what the public addresses of servers and nodes,
+// keyed by ID, resolved to at at. Unlike edits, it neither bumps the
+// record's or the network's revision nor records history: the generated
+// configs do not change.
+func (sm *StorageManager) RecordResolutions(resolutions map[string][]string, at time.Time) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		for id, ips := range resolutions {
+			bucket := tx.Bucket([]byte(BucketServers))
+			data := bucket.Get([]byte(id))
+			if data == nil {
+				bucket = tx.Bucket([]byte(BucketNodes))
+				data = bucket.Get([]byte(id))
+			}
+			if data == nil {
+				return kindErrorf(ErrNotFound, "server or node %s not found", id)
+			}
+
+			// Patched as JSON, so servers and nodes take one path.
+			var record map[string]json.RawMessage
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("failed to unmarshal %s: %w", id, err)
+			}
+			var err error
+			if record["resolved_ips"], err = json.Marshal(ips); err != nil {
+				return err
+			}
+			if record["resolved_at"], err = json.Marshal(at); err != nil {
+				return err
+			}
+			updated, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to marshal %s: %w", id, err)
+			}
+			if err := bucket.Put([]byte(id), updated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
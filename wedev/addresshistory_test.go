@@ -0,0 +1,172 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAddressHistory(t *testing.T) {
+	for name, newManager := range map[string]func(*testing.T) *VirtualNetworkManager{
+		"bolt":   func(t *testing.T) *VirtualNetworkManager { vnm, _ := newTestManager(t); return vnm },
+		"memory": func(t *testing.T) *VirtualNetworkManager { vnm, _ := newMemoryTestManager(t); return vnm },
+	} {
+		t.Run(name, func(t *testing.T) {
+			vnm := newManager(t)
+			if _, err := vnm.CreateVirtualNetwork("home", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.CreateServer("home", "hub", "a.dyn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			if _, err := vnm.CreateNode("home", "nas", "198.51.100.1", 51820, NodeTypePeer); err != nil {
+				t.Fatalf("CreateNode() error = %v", err)
+			}
+
+			if _, err := vnm.UpdateServer("home", "hub", "b.dyn.example.com", 51820); err != nil {
+				t.Fatalf("UpdateServer() error = %v", err)
+			}
+			// A port-only change is not an address change.
+			if _, err := vnm.UpdateServer("home", "hub", "b.dyn.example.com", 51821); err != nil {
+				t.Fatalf("UpdateServer() error = %v", err)
+			}
+			server, err := vnm.GetServer("home", "hub")
+			if err != nil {
+				t.Fatalf("GetServer() error = %v", err)
+			}
+			if len(server.AddressHistory) != 1 || server.AddressHistory[0].Address != "a.dyn.example.com" || server.AddressHistory[0].Until.IsZero() {
+				t.Errorf("server AddressHistory = %+v, want [a.dyn.example.com]", server.AddressHistory)
+			}
+
+			for i := 2; i <= MaxAddressHistory+2; i++ {
+				address := fmt.Sprintf("198.51.100.%d", i)
+				if _, err := vnm.EditNode("home", "nas", NodeEdit{PublicAddress: &address, IgnoreConflict: true}); err != nil {
+					t.Fatalf("EditNode(%s) error = %v", address, err)
+				}
+			}
+			node, err := vnm.GetNode("home", "nas")
+			if err != nil {
+				t.Fatalf("GetNode() error = %v", err)
+			}
+			if len(node.AddressHistory) != MaxAddressHistory || node.AddressHistory[0].Address != "198.51.100.2" || node.AddressHistory[MaxAddressHistory-1].Address != "198.51.100.11" {
+				t.Errorf("node AddressHistory = %+v, want the %d addresses before 198.51.100.12", node.AddressHistory, MaxAddressHistory)
+			}
+
+			// The change history lists the public address, not the address
+			// history alongside it.
+			history, err := vnm.NodeHistory("home", "nas")
+			if err != nil {
+				t.Fatalf("NodeHistory() error = %v", err)
+			}
+			for _, rev := range history {
+				for _, change := range rev.Changes {
+					if change.Field != "public_address" {
+						t.Errorf("NodeHistory() lists field %q", change.Field)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckEndpoints(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("home", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	steps := []func() error{
+		func() error { _, err := vnm.CreateServer("home", "hub", "hub.dyn.example.com", 51820); return err },
+		func() error {
+			_, err := vnm.CreateNode("home", "nas", "nas.dyn.example.com", 51820, NodeTypePeer)
+			return err
+		},
+		func() error { _, err := vnm.CreateNode("home", "lab", "198.51.100.7", 51820, NodeTypePeer); return err },
+		func() error { _, err := vnm.CreateNode("home", "laptop", "", 0, NodeTypeClient); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("setup error = %v", err)
+		}
+	}
+
+	records := map[string][]string{
+		"hub.dyn.example.com": {"203.0.113.1"},
+		"nas.dyn.example.com": {"203.0.113.9", "2001:db8::9", "203.0.113.9"},
+	}
+	lookups := 0
+	resolver := func(_ context.Context, host string) ([]string, error) {
+		lookups++
+		if ips, ok := records[host]; ok {
+			return slices.Clone(ips), nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	revision, err := sm.NetworkRevision(network.ID)
+	if err != nil {
+		t.Fatalf("NetworkRevision() error = %v", err)
+	}
+	report, err := vnm.CheckEndpoints(t.Context(), "home", resolver)
+	if err != nil || report.Resolved != 2 || len(report.Findings) != 0 || lookups != 2 {
+		t.Fatalf("CheckEndpoints() first = %+v, %v (%d lookups); want two clean lookups", report, err, lookups)
+	}
+	node, err := vnm.GetNode("home", "nas")
+	if err != nil || !slices.Equal(node.ResolvedIPs, []string{"2001:db8::9", "203.0.113.9"}) || node.ResolvedAt == nil {
+		t.Errorf("GetNode() resolution = %v at %v, %v; want the sorted addresses", node.ResolvedIPs, node.ResolvedAt, err)
+	}
+	if after, err := sm.NetworkRevision(network.ID); err != nil || after != revision {
+		t.Errorf("NetworkRevision() after a check = %d, %v; want %d, unchanged", after, err, revision)
+	}
+
+	// The hub's name now points elsewhere and the NAS's record is gone.
+	records["hub.dyn.example.com"] = []string{"203.0.113.2"}
+	delete(records, "nas.dyn.example.com")
+	report, err = vnm.CheckEndpoints(t.Context(), "home", resolver)
+	if err != nil {
+		t.Fatalf("CheckEndpoints() error = %v", err)
+	}
+	rules := make(map[string]string)
+	for _, finding := range report.Findings {
+		rules[finding.Entity] = finding.Rule
+	}
+	if len(report.Findings) != 2 || rules["server hub"] != EndpointChanged || rules["node nas"] != EndpointUnresolved {
+		t.Errorf("CheckEndpoints() findings = %+v, want hub changed and nas unresolved", report.Findings)
+	}
+	if report, err := vnm.CheckEndpoints(t.Context(), "home", resolver); err != nil || len(report.Findings) != 1 {
+		t.Errorf("CheckEndpoints() third = %+v, %v; want only nas, the hub's new address stored", report, err)
+	}
+
+	// Editing the address drops the old resolution, so the new name is not
+	// compared with it.
+	address := "nas2.dyn.example.com"
+	if _, err := vnm.EditNode("home", "nas", NodeEdit{PublicAddress: &address, IgnoreConflict: true}); err != nil {
+		t.Fatalf("EditNode() error = %v", err)
+	}
+	records[address] = []string{"203.0.113.50"}
+	if report, err := vnm.CheckEndpoints(t.Context(), "home", resolver); err != nil || len(report.Findings) != 0 {
+		t.Errorf("CheckEndpoints() after an edit = %+v, %v; want no findings", report, err)
+	}
+
+	// Three address changes within a day are flapping.
+	for _, address := range []string{"198.51.100.8", "198.51.100.9", "198.51.100.10"} {
+		if _, err := vnm.EditNode("home", "lab", NodeEdit{PublicAddress: &address, IgnoreConflict: true}); err != nil {
+			t.Fatalf("EditNode() error = %v", err)
+		}
+	}
+	report, err = vnm.CheckEndpoints(t.Context(), "home", resolver)
+	if err != nil || len(report.Findings) != 1 || report.Findings[0].Rule != EndpointFlapping || report.Findings[0].Entity != "node lab" {
+		t.Errorf("CheckEndpoints() = %+v, %v; want lab flapping", report, err)
+	}
+	vnm.now = func() time.Time { return time.Now().Add(FlappingWindow + time.Minute) }
+	if report, err := vnm.CheckEndpoints(t.Context(), "home", resolver); err != nil || len(report.Findings) != 0 {
+		t.Errorf("CheckEndpoints() a day later = %+v, %v; want no findings", report, err)
+	}
+
+	if _, err := vnm.CheckEndpoints(t.Context(), "missing", resolver); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CheckEndpoints(missing) error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,220 @@
+package wedev
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultWireGuardDir is where wg-quick looks up interface configs.
+const DefaultWireGuardDir = "/etc/wireguard"
+
+// interfaceNamePattern matches a valid Linux network interface name (at most
+// 15 bytes, the kernel's IFNAMSIZ minus the terminator), using the characters
+// wg-quick accepts.
+var interfaceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
+// CommandRunner runs external programs. It is an interface so tests can
+// substitute a fake instead of invoking wg-quick on the host.
+type CommandRunner interface {
+	LookPath(file string) (string, error)
+	// Run returns what the program wrote to stdout and stderr, to report it.
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+	// Output returns only what the program wrote to stdout, for output that
+	// is parsed or saved; on failure its stderr is part of the error.
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// execRunner is the CommandRunner backed by os/exec.
+type execRunner struct{}
+
+func (execRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	// #nosec G204 -- name is a fixed WireGuard tool; args are validated interface names and paths.
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (execRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	// #nosec G204 -- name is a fixed WireGuard tool; args are validated interface names and paths.
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	return out, err
+}
+
+// ApplyOptions controls how a generated config is installed locally.
+type ApplyOptions struct {
+	Interface string // interface name; defaults to the network name
+	ConfigDir string // directory for <interface>.conf; defaults to DefaultWireGuardDir
+	NoRestart bool   // use `wg syncconf` instead of bouncing the interface
+}
+
+// ApplyPlan describes the file an apply writes and the commands it runs.
+type ApplyPlan struct {
+	Network    string
+	Entity     string
+	Interface  string
+	ConfigPath string
+	Config     string
+	Commands   [][]string
+}
+
+// ConfigApplier installs a generated WireGuard config on the local machine
+// and (re)starts the interface with wg-quick.
+type ConfigApplier struct {
+	generator *WireGuardConfigGenerator
+	storage   Storage
+	runner    CommandRunner
+	geteuid   func() int
+}
+
+// NewConfigApplier creates a new ConfigApplier
+func NewConfigApplier(storage Storage) *ConfigApplier {
+	return &ConfigApplier{
+		generator: NewWireGuardConfigGenerator(storage),
+		storage:   storage,
+		runner:    execRunner{},
+		geteuid:   os.Geteuid,
+	}
+}
+
+// Plan generates the config for one entity (the server or a node) of a
+// network and works out where it will be written and which commands apply it.
+func (ca *ConfigApplier) Plan(networkName, entityName string, opts ApplyOptions) (*ApplyPlan, error) {
+	return ca.PlanCtx(context.Background(), networkName, entityName, opts)
+}
+
+// PlanCtx is Plan with a context.
+func (ca *ConfigApplier) PlanCtx(ctx context.Context, networkName, entityName string, opts ApplyOptions) (*ApplyPlan, error) {
+	config, err := ca.generator.GenerateConfigCtx(ctx, networkName, entityName)
+	if err != nil {
+		return nil, err
+	}
+
+	iface := opts.Interface
+	if iface == "" {
+		iface = networkName
+	}
+	if !interfaceNamePattern.MatchString(iface) {
+		return nil, fmt.Errorf("invalid interface name %q (at most 15 letters, digits, or _=+.-); use --interface", iface)
+	}
+
+	dir := opts.ConfigDir
+	if dir == "" {
+		dir = DefaultWireGuardDir
+	}
+	configPath := filepath.Join(dir, iface+".conf")
+
+	plan := &ApplyPlan{Network: networkName, Entity: entityName, Interface: iface, ConfigPath: configPath, Config: config}
+	if opts.NoRestart {
+		plan.Commands = [][]string{
+			{"wg-quick", "strip", configPath},
+			{"wg", "syncconf", iface, "<stripped config>"},
+		}
+	} else {
+		plan.Commands = [][]string{
+			{"wg-quick", "down", configPath},
+			{"wg-quick", "up", configPath},
+		}
+	}
+	return plan, nil
+}
+
+// Apply writes the planned config and runs its commands, then records the
+// deployment (see WireGuardConfigGenerator.RecordDeployment). It requires
+// root and the WireGuard tools on PATH, and reports which is missing
+// otherwise.
+func (ca *ConfigApplier) Apply(plan *ApplyPlan, opts ApplyOptions) error {
+	return ca.ApplyCtx(context.Background(), plan, opts)
+}
+
+// ApplyCtx is Apply with a context. Cancelling it kills a running wg-quick
+// or wg command.
+func (ca *ConfigApplier) ApplyCtx(ctx context.Context, plan *ApplyPlan, opts ApplyOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ca.geteuid() != 0 {
+		return fmt.Errorf("applying a WireGuard config requires root privileges (try sudo, or use --dry-run)")
+	}
+	tools := []string{"wg-quick"}
+	if opts.NoRestart {
+		tools = append(tools, "wg")
+	}
+	for _, tool := range tools {
+		if _, err := ca.runner.LookPath(tool); err != nil {
+			return fmt.Errorf("%s not found in PATH; install wireguard-tools: %w", tool, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plan.ConfigPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(plan.ConfigPath, []byte(plan.Config), 0o600); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", plan.ConfigPath, err)
+	}
+
+	if opts.NoRestart {
+		if err := ca.syncConf(ctx, plan); err != nil {
+			return err
+		}
+	} else {
+		// `wg-quick down` fails when the interface is not up yet; that is
+		// the expected state on a first apply, so its error is not fatal.
+		//nolint:errcheck // Interface may legitimately be down already
+		_, _ = ca.runner.Run(ctx, "wg-quick", "down", plan.ConfigPath)
+		if out, err := ca.runner.Run(ctx, "wg-quick", "up", plan.ConfigPath); err != nil {
+			return fmt.Errorf("wg-quick up failed: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	if _, err := ca.generator.RecordDeploymentCtx(ctx, plan.Network, plan.Entity, plan.Config); err != nil {
+		return fmt.Errorf("config applied, but recording the deployment failed: %w", err)
+	}
+	return nil
+}
+
+// syncConf updates a running interface in place: wg-quick-only directives are
+// stripped and the result is handed to `wg syncconf`, leaving live sessions up.
+// Only the stdout of `wg-quick strip` is the config; warnings it prints to
+// stderr must not end up in the file.
+func (ca *ConfigApplier) syncConf(ctx context.Context, plan *ApplyPlan) error {
+	stripped, err := ca.runner.Output(ctx, "wg-quick", "strip", plan.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("wg-quick strip failed: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(plan.ConfigPath), "."+plan.Interface+"-*.conf")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary config: %w", err)
+	}
+	defer func() {
+		//nolint:errcheck // Best-effort cleanup of the temporary file
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(stripped); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write temporary config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary config: %w", err)
+	}
+
+	if out, err := ca.runner.Run(ctx, "wg", "syncconf", plan.Interface, tmp.Name()); err != nil {
+		return fmt.Errorf("wg syncconf failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
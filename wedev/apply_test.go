@@ -0,0 +1,201 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRunner records the commands it is asked to run instead of executing them.
+type fakeRunner struct {
+	missing map[string]bool
+	fail    map[string]bool
+	calls   []string
+}
+
+func (f *fakeRunner) LookPath(file string) (string, error) {
+	if f.missing[file] {
+		return "", errors.New("executable file not found in $PATH")
+	}
+	return "/usr/bin/" + file, nil
+}
+
+func (f *fakeRunner) Run(_ context.Context, name string, args ...string) ([]byte, error) {
+	call := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, call)
+	if f.fail[name+" "+args[0]] {
+		return []byte("boom"), errors.New("exit status 1")
+	}
+	return nil, nil
+}
+
+func (f *fakeRunner) Output(_ context.Context, name string, args ...string) ([]byte, error) {
+	call := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, call)
+	if f.fail[name+" "+args[0]] {
+		return nil, errors.New("exit status 1: boom")
+	}
+	if name == "wg-quick" && args[0] == "strip" {
+		return []byte("[Interface]\nPrivateKey = x\n"), nil
+	}
+	return nil, nil
+}
+
+// newApplyTestNetwork creates a network with a server and one node, and an
+// applier wired to a fake runner that reports the given effective UID.
+func newApplyTestNetwork(t *testing.T, euid int) (*ConfigApplier, *fakeRunner) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("applynet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("applynet", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("applynet", "n1", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	runner := &fakeRunner{missing: map[string]bool{}, fail: map[string]bool{}}
+	ca := NewConfigApplier(sm)
+	ca.runner = runner
+	ca.geteuid = func() int { return euid }
+	return ca, runner
+}
+
+func TestConfigApplier_Plan(t *testing.T) {
+	ca, _ := newApplyTestNetwork(t, 0)
+
+	plan, err := ca.Plan("applynet", "n1", ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if plan.Interface != "applynet" || plan.ConfigPath != "/etc/wireguard/applynet.conf" {
+		t.Errorf("Plan() = %s at %s, want applynet at /etc/wireguard/applynet.conf", plan.Interface, plan.ConfigPath)
+	}
+	if !strings.Contains(plan.Config, "[Interface]") {
+		t.Errorf("Plan() config missing [Interface]: %q", plan.Config)
+	}
+	if len(plan.Commands) != 2 || plan.Commands[1][1] != "up" {
+		t.Errorf("Plan() commands = %v, want wg-quick down/up", plan.Commands)
+	}
+
+	plan, err = ca.Plan("applynet", "srv", ApplyOptions{Interface: "wg0", ConfigDir: "/tmp/wg", NoRestart: true})
+	if err != nil {
+		t.Fatalf("Plan(no-restart) error = %v", err)
+	}
+	if plan.ConfigPath != "/tmp/wg/wg0.conf" || plan.Commands[1][1] != "syncconf" {
+		t.Errorf("Plan(no-restart) = %s %v, want /tmp/wg/wg0.conf with syncconf", plan.ConfigPath, plan.Commands)
+	}
+
+	if _, err := ca.Plan("applynet", "ghost", ApplyOptions{}); err == nil {
+		t.Error("Plan() for an unknown entity should fail")
+	}
+	if _, err := ca.Plan("applynet", "n1", ApplyOptions{Interface: "averyveryverylongname"}); err == nil {
+		t.Error("Plan() with an over-long interface name should fail")
+	}
+	if _, err := ca.Plan("missing", "n1", ApplyOptions{}); err == nil {
+		t.Error("Plan() for a missing network should fail")
+	}
+}
+
+func TestConfigApplier_Apply(t *testing.T) {
+	ca, runner := newApplyTestNetwork(t, 0)
+	dir := filepath.Join(t.TempDir(), "wireguard")
+
+	opts := ApplyOptions{ConfigDir: dir}
+	plan, err := ca.Plan("applynet", "n1", opts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := ca.Apply(plan, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	info, err := os.Stat(plan.ConfigPath)
+	if err != nil {
+		t.Fatalf("config file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+	}
+	want := []string{"wg-quick down " + plan.ConfigPath, "wg-quick up " + plan.ConfigPath}
+	if strings.Join(runner.calls, "|") != strings.Join(want, "|") {
+		t.Errorf("Apply() ran %v, want %v", runner.calls, want)
+	}
+
+	// wg-quick up failures are surfaced with the tool's output.
+	runner.fail["wg-quick up"] = true
+	if err := ca.Apply(plan, opts); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Apply() with failing wg-quick up error = %v, want tool output", err)
+	}
+}
+
+func TestConfigApplier_ApplyNoRestart(t *testing.T) {
+	ca, runner := newApplyTestNetwork(t, 0)
+
+	opts := ApplyOptions{ConfigDir: t.TempDir(), NoRestart: true}
+	plan, err := ca.Plan("applynet", "n1", opts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := ca.Apply(plan, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(runner.calls) != 2 || !strings.HasPrefix(runner.calls[1], "wg syncconf applynet ") {
+		t.Errorf("Apply(no-restart) ran %v, want strip then syncconf", runner.calls)
+	}
+
+	runner.fail["wg syncconf"] = true
+	if err := ca.Apply(plan, opts); err == nil {
+		t.Error("Apply() with failing wg syncconf should fail")
+	}
+	runner.fail["wg-quick strip"] = true
+	if err := ca.Apply(plan, opts); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Apply() with failing wg-quick strip error = %v, want its stderr", err)
+	}
+}
+
+// TestExecRunner_Output checks that Output keeps stderr out of the output,
+// as wg-quick strip warnings would otherwise end up in the synced config,
+// and reports it in the error instead.
+func TestExecRunner_Output(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	var runner execRunner
+	out, err := runner.Output(t.Context(), "sh", "-c", "echo '[Interface]'; echo 'Warning: deprecated' >&2")
+	if err != nil || string(out) != "[Interface]\n" {
+		t.Errorf("Output() = %q, %v; want stdout only", out, err)
+	}
+	if _, err := runner.Output(t.Context(), "sh", "-c", "echo 'no such file' >&2; exit 3"); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("Output() of a failing command error = %v, want its stderr", err)
+	}
+}
+
+func TestConfigApplier_ApplyPreconditions(t *testing.T) {
+	ca, runner := newApplyTestNetwork(t, 1000)
+	opts := ApplyOptions{ConfigDir: t.TempDir()}
+	plan, err := ca.Plan("applynet", "n1", opts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// Non-root is rejected before anything is written.
+	if err := ca.Apply(plan, opts); err == nil || !strings.Contains(err.Error(), "root") {
+		t.Errorf("Apply() as non-root error = %v, want root privileges error", err)
+	}
+	if _, statErr := os.Stat(plan.ConfigPath); statErr == nil {
+		t.Error("Apply() as non-root should not write the config")
+	}
+
+	// Missing wg-quick is reported by name.
+	ca.geteuid = func() int { return 0 }
+	runner.missing["wg-quick"] = true
+	if err := ca.Apply(plan, opts); err == nil || !strings.Contains(err.Error(), "wg-quick not found") {
+		t.Errorf("Apply() without wg-quick error = %v, want not-found error", err)
+	}
+}
@@ -0,0 +1,307 @@
+package wedev
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat is the container a config archive is written in.
+type ArchiveFormat string
+
+const (
+	// ArchiveTarGz is a gzip-compressed tarball.
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	// ArchiveZip is a zip file.
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// ArchiveFormatFor picks the archive format from a file name: .tar.gz or
+// .tgz for a tarball, .zip for a zip file.
+func ArchiveFormatFor(path string) (ArchiveFormat, error) {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	}
+	return "", kindErrorf(ErrValidation, "archive %q must end in .tar.gz, .tgz or .zip", path)
+}
+
+// archiveReadme names the file describing an archive's contents.
+const archiveReadme = "README"
+
+// ConfigArchive is a set of configs packaged into a single file, with a
+// README naming the network, version and content hash they belong to.
+type ConfigArchive struct {
+	Network     string
+	Version     int
+	ContentHash string
+	Configs     map[string]string // entity name -> config content
+	Filenames   map[string]string // entity name -> file name; <entity>.conf when missing
+	PerEntity   bool              // put each config in a directory named after its entity
+	CreatedAt   time.Time
+
+	// Addresses maps entity names to the interface settings of their 'wg
+	// setconf' configs (see TranslateSetconf), written next to each config
+	// as AddressesFilename.
+	Addresses map[string]string
+}
+
+// archiveEntry is one file of an archive.
+type archiveEntry struct {
+	name    string
+	content string
+	mode    fs.FileMode // 0 means 0600
+}
+
+// fileMode returns the permissions the entry is archived with.
+func (e archiveEntry) fileMode() fs.FileMode {
+	if e.mode == 0 {
+		return 0o600
+	}
+	return e.mode
+}
+
+// entries returns the files of the archive, README first and the configs
+// sorted by path. Every path is checked to stay inside the archive.
+func (a *ConfigArchive) entries() ([]archiveEntry, error) {
+	configs, err := a.configEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var readme strings.Builder
+	fmt.Fprintf(&readme, "WireGuard configs of network '%s'\n\n", a.Network)
+	fmt.Fprintf(&readme, "Version: %d\n", a.Version)
+	fmt.Fprintf(&readme, "Content Hash: %s\n", a.ContentHash)
+	fmt.Fprintf(&readme, "Created At: %s\n\n", a.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintln(&readme, "Files:")
+	for _, entry := range configs {
+		fmt.Fprintf(&readme, "  %s\n", entry.name)
+	}
+	fmt.Fprintln(&readme, "\nThe configs hold private keys; keep them readable by their owner only.")
+
+	return append([]archiveEntry{{name: archiveReadme, content: readme.String()}}, configs...), nil
+}
+
+// configEntries returns the configs of the archive sorted by path, each
+// path checked to stay inside the archive.
+func (a *ConfigArchive) configEntries() ([]archiveEntry, error) {
+	configs := make([]archiveEntry, 0, len(a.Configs))
+	for entity, config := range a.Configs {
+		filename := a.Filenames[entity]
+		if filename == "" {
+			filename = entity + ".conf"
+		}
+		name := filename
+		if a.PerEntity {
+			name = entity + "/" + filename
+		}
+		if err := checkArchivePath(name); err != nil {
+			return nil, err
+		}
+		configs = append(configs, archiveEntry{name: name, content: config})
+		if addresses, ok := a.Addresses[entity]; ok {
+			configs = append(configs, archiveEntry{name: AddressesFilename(name), content: addresses, mode: 0o644})
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].name < configs[j].name })
+	return configs, nil
+}
+
+// checkArchivePath rejects archive paths that are absolute or climb out of
+// the archive, so extracting it can only create files below one directory.
+func checkArchivePath(name string) error {
+	if strings.Contains(name, `\`) {
+		return kindErrorf(ErrValidation, "archive path %q must not contain a backslash", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return kindErrorf(ErrValidation, "archive path %q must be relative, without empty, . or .. elements", name)
+		}
+	}
+	return nil
+}
+
+// WriteConfigArchive writes archive to path in the format its extension
+// names (see ArchiveFormatFor). The file is written to a temporary file next
+// to path and renamed into place, so path never holds a partial archive.
+// Entries are readable by their owner only.
+func WriteConfigArchive(path string, archive *ConfigArchive) error {
+	format, err := ArchiveFormatFor(path)
+	if err != nil {
+		return err
+	}
+	entries, err := archive.entries()
+	if err != nil {
+		return err
+	}
+
+	return writeArchiveFile(path, func(w io.Writer) error {
+		if format == ArchiveZip {
+			return writeZip(w, entries, archive.PerEntity, archive.CreatedAt)
+		}
+		return writeTarGz(w, entries, archive.PerEntity, archive.CreatedAt)
+	})
+}
+
+// StreamFormat is how WriteConfigStream lays configs out.
+type StreamFormat string
+
+const (
+	// StreamText concatenates the configs, each preceded by a
+	// "# --- <file> ---" line.
+	StreamText StreamFormat = "text"
+	// StreamTar is an uncompressed tarball, for piping into 'tar -x'.
+	StreamTar StreamFormat = "tar"
+)
+
+// ParseStreamFormat validates a stream format name.
+func ParseStreamFormat(s string) (StreamFormat, error) {
+	switch StreamFormat(s) {
+	case StreamText, StreamTar:
+		return StreamFormat(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid stream format: %s (must be '%s' or '%s')", s, StreamText, StreamTar)
+}
+
+// WriteConfigStream writes the configs of archive to w in format, sorted by
+// path like an archive but without its README, so a tar stream extracts
+// into a directory such as /etc/wireguard as nothing but config files.
+func WriteConfigStream(w io.Writer, archive *ConfigArchive, format StreamFormat) error {
+	entries, err := archive.configEntries()
+	if err != nil {
+		return err
+	}
+	if format == StreamTar {
+		return writeTar(w, entries, archive.PerEntity, archive.CreatedAt)
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "# --- %s ---\n%s", entry.name, entry.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArchiveFile writes an archive to a temporary file next to path with
+// write, makes it readable by its owner only, and renames it into place.
+func writeArchiveFile(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	tmpName := tmp.Name()
+	//nolint:errcheck // Removing a renamed temp file is a harmless no-op
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if err := write(tmp); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// entryDirs returns the directories holding entries, in the order they are
+// first needed; with per-entity layout each config sits in one.
+func entryDirs(entries []archiveEntry) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		dir, _, found := strings.Cut(entry.name, "/")
+		if found && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// writeTarGz writes entries to w as a gzip-compressed tarball.
+func writeTarGz(w io.Writer, entries []archiveEntry, withDirs bool, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	if err := writeTar(gz, entries, withDirs, modTime); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeTar writes entries to w as a tarball.
+func writeTar(w io.Writer, entries []archiveEntry, withDirs bool, modTime time.Time) error {
+	tw := tar.NewWriter(w)
+
+	if withDirs {
+		for _, dir := range entryDirs(entries) {
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o700, ModTime: modTime}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Mode: int64(entry.fileMode()), Size: int64(len(entry.content)), ModTime: modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, entry.content); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeZip writes entries to w as a zip file.
+func writeZip(w io.Writer, entries []archiveEntry, withDirs bool, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+
+	if withDirs {
+		for _, dir := range entryDirs(entries) {
+			header := &zip.FileHeader{Name: dir + "/", Modified: modTime}
+			header.SetMode(fs.ModeDir | 0o700)
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: modTime}
+		header.SetMode(entry.fileMode())
+		f, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, entry.content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,229 @@
+package wedev
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readArchive returns the modes and contents of the entries of the archive
+// at path, keyed by entry name.
+func readArchive(t *testing.T, path string) (map[string]fs.FileMode, map[string]string) {
+	t.Helper()
+	modes := make(map[string]fs.FileMode)
+	contents := make(map[string]string)
+
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatalf("zip.OpenReader() error = %v", err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			modes[f.Name] = f.Mode()
+			if f.Mode().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Open(%s) error = %v", f.Name, err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("ReadAll(%s) error = %v", f.Name, err)
+			}
+			contents[f.Name] = string(data)
+		}
+		return modes, contents
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	return readTar(t, gz)
+}
+
+// readTar returns the modes and contents of the entries of the tarball in r,
+// keyed by entry name.
+func readTar(t *testing.T, r io.Reader) (map[string]fs.FileMode, map[string]string) {
+	t.Helper()
+	modes := make(map[string]fs.FileMode)
+	contents := make(map[string]string)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar Next() error = %v", err)
+		}
+		modes[header.Name] = header.FileInfo().Mode()
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error = %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			contents[header.Name] = string(data)
+		}
+	}
+	return modes, contents
+}
+
+func TestArchiveFormatFor(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want ArchiveFormat
+	}{
+		{"out.tar.gz", ArchiveTarGz},
+		{"dir/OUT.TGZ", ArchiveTarGz},
+		{"out.zip", ArchiveZip},
+	} {
+		if got, err := ArchiveFormatFor(tt.path); err != nil || got != tt.want {
+			t.Errorf("ArchiveFormatFor(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+	for _, path := range []string{"out.tar", "out.gz", "out"} {
+		if _, err := ArchiveFormatFor(path); !errors.Is(err, ErrValidation) {
+			t.Errorf("ArchiveFormatFor(%q) error = %v, want ErrValidation", path, err)
+		}
+	}
+}
+
+func TestWriteConfigArchive(t *testing.T) {
+	archive := &ConfigArchive{
+		Network:     "net",
+		Version:     3,
+		ContentHash: "abc123",
+		Configs:     map[string]string{"srv": "[Interface]\n# srv\n", "node1": "[Interface]\n# node1\n"},
+		Filenames:   map[string]string{"srv": "wg-srv.conf"},
+		CreatedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	for _, tt := range []struct {
+		file      string
+		perEntity bool
+		want      []string
+	}{
+		{"flat.tar.gz", false, []string{"wg-srv.conf", "node1.conf"}},
+		{"flat.zip", false, []string{"wg-srv.conf", "node1.conf"}},
+		{"nested.tgz", true, []string{"srv/wg-srv.conf", "node1/node1.conf"}},
+		{"nested.zip", true, []string{"srv/wg-srv.conf", "node1/node1.conf"}},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.file)
+			archive.PerEntity = tt.perEntity
+			if err := WriteConfigArchive(path, archive); err != nil {
+				t.Fatalf("WriteConfigArchive() error = %v", err)
+			}
+
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("archive file mode = %v, %v; want 0600", info.Mode(), err)
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(dir, ".*")); len(leftovers) != 0 {
+				t.Errorf("temporary files left behind: %v", leftovers)
+			}
+
+			modes, contents := readArchive(t, path)
+			if len(contents) != len(tt.want)+1 {
+				t.Errorf("archive files = %v, want README and %v", contents, tt.want)
+			}
+			for _, name := range tt.want {
+				entity := strings.TrimSuffix(filepath.Base(name), ".conf")
+				entity = strings.TrimPrefix(entity, "wg-")
+				if contents[name] != archive.Configs[entity] {
+					t.Errorf("%s = %q, want the config of %s", name, contents[name], entity)
+				}
+				if modes[name].Perm() != 0o600 {
+					t.Errorf("%s mode = %v, want 0600", name, modes[name])
+				}
+			}
+			if tt.perEntity && modes["srv/"].Perm() != 0o700 {
+				t.Errorf("srv/ mode = %v, want 0700", modes["srv/"])
+			}
+
+			readme := contents["README"]
+			for _, want := range []string{"network 'net'", "Version: 3", "Content Hash: abc123", "Created At: 2026-01-02T03:04:05Z", "  " + tt.want[1]} {
+				if !strings.Contains(readme, want) {
+					t.Errorf("README = %q, want %q", readme, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteConfigStream(t *testing.T) {
+	archive := &ConfigArchive{
+		Network:   "net",
+		Configs:   map[string]string{"srv": "[Interface]\n# srv\n", "node1": "[Interface]\n# node1\n"},
+		Filenames: map[string]string{"srv": "wg-srv.conf"},
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	var text strings.Builder
+	if err := WriteConfigStream(&text, archive, StreamText); err != nil {
+		t.Fatalf("WriteConfigStream(text) error = %v", err)
+	}
+	want := "# --- node1.conf ---\n[Interface]\n# node1\n# --- wg-srv.conf ---\n[Interface]\n# srv\n"
+	if text.String() != want {
+		t.Errorf("text stream = %q, want %q", text.String(), want)
+	}
+
+	var tarball strings.Builder
+	if err := WriteConfigStream(&tarball, archive, StreamTar); err != nil {
+		t.Fatalf("WriteConfigStream(tar) error = %v", err)
+	}
+	modes, contents := readTar(t, strings.NewReader(tarball.String()))
+	if len(contents) != 2 || contents["wg-srv.conf"] != archive.Configs["srv"] || contents["node1.conf"] != archive.Configs["node1"] {
+		t.Errorf("tar stream = %v, want only the two configs", contents)
+	}
+	if modes["wg-srv.conf"].Perm() != 0o600 {
+		t.Errorf("wg-srv.conf mode = %v, want 0600", modes["wg-srv.conf"])
+	}
+
+	if _, err := ParseStreamFormat("zip"); !errors.Is(err, ErrValidation) {
+		t.Errorf("ParseStreamFormat(zip) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestWriteConfigArchive_RejectsUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, filenames := range []map[string]string{
+		{"a": "../escape.conf"},
+		{"a": "/etc/wireguard/a.conf"},
+		{"a": `..\escape.conf`},
+		{"a": "sub//a.conf"},
+	} {
+		path := filepath.Join(dir, "out.tar.gz")
+		err := WriteConfigArchive(path, &ConfigArchive{Network: "net", Configs: map[string]string{"a": "x"}, Filenames: filenames})
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("WriteConfigArchive(%v) error = %v, want ErrValidation", filenames, err)
+		}
+		if _, statErr := os.Stat(path); statErr == nil {
+			t.Errorf("WriteConfigArchive(%v) wrote an archive", filenames)
+		}
+	}
+
+	// An entity name cannot climb out either.
+	err := WriteConfigArchive(filepath.Join(dir, "out.zip"), &ConfigArchive{Network: "net", Configs: map[string]string{"..": "x"}, PerEntity: true})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("WriteConfigArchive() with entity .. error = %v, want ErrValidation", err)
+	}
+}
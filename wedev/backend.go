@@ -0,0 +1,117 @@
+package wedev
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wedevctl/util"
+)
+
+// Storage is what VirtualNetworkManager and WireGuardConfigGenerator keep
+// networks, servers, nodes, IP pool state and config versions in.
+// StorageManager implements it on a bbolt database and MemoryStorage in
+// memory. The methods behave as the StorageManager methods of the same
+// name document; maintenance of the database file itself (Backup, Info,
+// migrations) is StorageManager's alone.
+type Storage interface {
+	// Logger returns the logger managers and generators built on the
+	// storage log through.
+	Logger() *slog.Logger
+	Close() error
+
+	CreateNetwork(name, cidr string) (*VirtualNetwork, error)
+	CreateNetworkCtx(ctx context.Context, name, cidr string) (*VirtualNetwork, error)
+	GetNetworkByName(name string) (*VirtualNetwork, error)
+	GetNetworkByNameCtx(ctx context.Context, name string) (*VirtualNetwork, error)
+	GetNetworkByID(id string) (*VirtualNetwork, error)
+	ListNetworks() ([]*VirtualNetwork, error)
+	ListNetworksCtx(ctx context.Context) ([]*VirtualNetwork, error)
+	RenameNetwork(oldName, newName string) (*VirtualNetwork, error)
+	UpdateNetwork(id string, change func(*VirtualNetwork) error) (*VirtualNetwork, error)
+	UpdateNetworkLabels(id string, labels map[string]string) error
+	UpdateNetworkSettings(id string, settings map[string]string) error
+	UpdateNetworkDefaultPort(id string, port int) error
+	UpdateNetworkMaxNodes(id string, maxNodes int) error
+	UpdateNetworkPoolWarnPercent(id string, percent int) error
+	UpdateNetworkNATMode(id string, mode NATMode) error
+	UpdateNetworkFreeze(id string, freeze *ConfigFreeze) error
+	ResizeNetwork(id, cidr string, state *util.IPPoolState) (*VirtualNetwork, error)
+	DeleteNetwork(name string) error
+
+	CreateServer(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error)
+	CreateServerWithPoolState(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string, state *util.IPPoolState) (*Server, error)
+	GetServerByName(networkID, name string) (*Server, error)
+	GetServerByNetworkID(networkID string) (*Server, error)
+	ListServersByNetworkID(networkID string) ([]*Server, error)
+	ListServersByNetworkIDCtx(ctx context.Context, networkID string) ([]*Server, error)
+	UpdateServer(id, publicAddress string, port int) error
+	UpdateServerInternalEndpoint(id, address string, port int) error
+	UpdateServerKeys(id, privateKey, publicKey string) error
+	ReplaceServer(server *Server, revision int) error
+	RenameServer(networkID, oldName, newName string) (*Server, error)
+	DeleteServer(networkID, name string) error
+	DeleteServerWithPoolState(networkID, name string, state *util.IPPoolState) error
+	DeleteServerAndNodesWithPoolState(networkID, name string, nodeNames []string, state *util.IPPoolState) error
+
+	CreateNode(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error)
+	CreateNodeWithPoolState(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string, state *util.IPPoolState) (*Node, error)
+	GetNodeByName(networkID, name string) (*Node, error)
+	ListNodesByNetworkID(networkID string) ([]*Node, error)
+	ListNodesByNetworkIDCtx(ctx context.Context, networkID string) ([]*Node, error)
+	CountNodesByNetworkCtx(ctx context.Context) (map[string]int, error)
+	UpdateNode(id, publicAddress string, port int, nodeType NodeType) error
+	UpdateNodeRoutedCIDRs(id string, routedCIDRs []string) error
+	UpdateNodeLabels(id string, labels map[string]string) error
+	UpdateNodeServer(id, serverID string, meshServers bool) error
+	UpdateNodeExpiry(id string, expiresAt *time.Time) error
+	UpdateNodeFullTunnel(id string, fullTunnel bool) error
+	UpdateNodeInternalEndpoint(id, address string, port int) error
+	UpdateNodePreferInternal(id string, preferInternal bool) error
+	UpdateNodeKeys(id, privateKey, publicKey string) error
+	ReplaceNode(node *Node, revision int) error
+	RenameNode(networkID, oldName, newName string) (*Node, error)
+	DeleteNode(networkID, name string) error
+	DeleteNodeWithPoolState(networkID, name string, state *util.IPPoolState) error
+
+	SaveConfigVersion(networkID, contentHash string, configs map[string]string) (*ConfigVersion, error)
+	SaveConfigVersionWithMessage(networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error)
+	SaveConfigVersionWithMessageCtx(ctx context.Context, networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error)
+	GetLatestConfigVersion(networkID string) (*ConfigVersion, error)
+	GetLatestConfigVersionCtx(ctx context.Context, networkID string) (*ConfigVersion, error)
+	GetConfigVersion(networkID string, version int) (*ConfigVersion, error)
+	ListConfigVersions(networkID string) ([]*ConfigVersion, error)
+	ListConfigVersionsCtx(ctx context.Context, networkID string) ([]*ConfigVersion, error)
+	ListConfigVersionsFilteredCtx(ctx context.Context, networkID string, filter ConfigVersionFilter) ([]*ConfigVersion, int, error)
+	GetConfigHashByVersion(networkID string, version int) (string, error)
+	SetConfigTag(networkID, tag string, version int, force bool) (int, error)
+	DeleteConfigTag(networkID, tag string) (int, error)
+	GetConfigTag(networkID, tag string) (int, error)
+	ListConfigTags(networkID string) ([]ConfigTag, error)
+
+	CreateGuestWithPoolState(networkID, name, virtualIP, publicKey, serverID string, expiresAt time.Time, state *util.IPPoolState) (*Guest, error)
+	ListGuestsByNetworkID(networkID string) ([]*Guest, error)
+	DeleteGuestsWithPoolState(networkID string, names []string, state *util.IPPoolState) error
+
+	SaveDeployment(deployment *Deployment) error
+	ListDeployments(networkID string) (map[string]*Deployment, error)
+	ListDeploymentsCtx(ctx context.Context, networkID string) (map[string]*Deployment, error)
+
+	NetworkRevision(networkID string) (uint64, error)
+	NetworkRevisionCtx(ctx context.Context, networkID string) (uint64, error)
+	EntityHistory(entityID string) ([]EntityRevision, error)
+	RecordResolutions(resolutions map[string][]string, at time.Time) error
+
+	SaveIPPoolState(networkID string, state *util.IPPoolState) error
+	GetIPPoolState(networkID string) (*util.IPPoolState, error)
+
+	ValidateNetwork(networkID string) (*ValidationReport, error)
+	RepairIndexes() (int, error)
+	CheckIntegrity() (*IntegrityReport, error)
+	FixIntegrity() (*IntegrityReport, error)
+}
+
+var (
+	_ Storage = (*StorageManager)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+)
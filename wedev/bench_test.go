@@ -1,9 +1,11 @@
 package wedev
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/wedevctl/util"
 )
@@ -57,6 +59,89 @@ func BenchmarkGenerateConfigs(b *testing.B) {
 	}
 }
 
+// BenchmarkGenerateConfigsWithRoutes measures config generation when a tenth
+// of the nodes are route nodes exposing a LAN subnet, which adds the routed
+// subnets to every other node's server peer.
+func BenchmarkGenerateConfigsWithRoutes(b *testing.B) {
+	for _, n := range []int{10, 50, 200} {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			vnm, sm := benchNetwork(b, n)
+			for j := 0; j < n/10; j++ {
+				cidr := fmt.Sprintf("192.168.%d.0/24", j)
+				if _, err := vnm.CreateRouteNode("benchnet", fmt.Sprintf("route%d", j), "", 51820, []string{cidr}); err != nil {
+					b.Fatalf("CreateRouteNode() error = %v", err)
+				}
+			}
+			gen := NewWireGuardConfigGenerator(sm)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := gen.GenerateConfigs("benchnet", sm); err != nil {
+					b.Fatalf("GenerateConfigs() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+// syntheticNetwork builds a network of one server and n nodes in memory,
+// every tenth a route node exposing a LAN subnet and some with internal
+// endpoints or preferring them, sorted by virtual IP as
+// GenerateConfigs sorts them. Nothing is stored, so large networks are cheap
+// to set up.
+func syntheticNetwork(n int) (*VirtualNetwork, []*Server, []*Node) {
+	network := &VirtualNetwork{ID: "synthetic", Name: "synthetic", CIDR: "10.0.0.0/16"}
+	servers := []*Server{{
+		ID: "srv", Name: "srv", PublicAddress: "vpn.example.com", Port: 51820,
+		VirtualIP: "10.0.0.1", PrivateKey: "srv-private", PublicKey: "srv-public",
+	}}
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		ip := i + 2
+		node := &Node{
+			ID: fmt.Sprintf("node%d", i), Name: fmt.Sprintf("node%d", i), Type: NodeTypePeer,
+			PublicAddress: fmt.Sprintf("198.51.%d.%d", ip/256, ip%256), Port: 51820,
+			VirtualIP:  fmt.Sprintf("10.0.%d.%d", ip/256, ip%256),
+			PrivateKey: fmt.Sprintf("node%d-private", i), PublicKey: fmt.Sprintf("node%d-public", i),
+		}
+		if i%7 == 0 {
+			node.InternalAddress = node.PublicAddress[:len("198.51")] + ".200." + node.PublicAddress[len("198.51.0."):]
+		}
+		node.PreferInternal = i%5 == 0
+		if i%10 == 0 {
+			node.Type = NodeTypeRoute
+			node.PublicAddress = ""
+			node.RoutedCIDRs = []string{fmt.Sprintf("192.168.%d.0/24", i/10%256)}
+		}
+		nodes[i] = node
+	}
+	return network, servers, nodes
+}
+
+// BenchmarkGenerateAll compares generating the configs of 1k synthetic nodes
+// one at a time with the default worker pool.
+func BenchmarkGenerateAll(b *testing.B) {
+	network, servers, nodes := syntheticNetwork(1000)
+	for _, bc := range []struct {
+		name    string
+		workers int
+	}{
+		{"sequential", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			gen := &WireGuardConfigGenerator{now: time.Now, workers: bc.workers}
+			for b.Loop() {
+				configs, err := gen.generateAll(context.Background(), network, servers, nodes)
+				if err != nil {
+					b.Fatalf("generateAll() error = %v", err)
+				}
+				gen.calculateConfigHash(configs)
+			}
+		})
+	}
+}
+
 // BenchmarkCreateNode measures a single node creation (IP allocation, key
 // generation, storage write, IP-pool persistence) against a populated network.
 func BenchmarkCreateNode(b *testing.B) {
@@ -130,9 +215,10 @@ func BenchmarkGetServerByNetworkID(b *testing.B) {
 }
 
 // BenchmarkSaveConfigVersion measures appending a config version to a network
-// that already holds many versions.
+// that already holds many versions. Version numbers come from the network's
+// sequence, so the cost should not grow with the history.
 func BenchmarkSaveConfigVersion(b *testing.B) {
-	for _, n := range []int{10, 100, 500} {
+	for _, n := range []int{10, 100, 1000} {
 		b.Run(fmt.Sprintf("existing=%d", n), func(b *testing.B) {
 			dbPath := filepath.Join(b.TempDir(), "bench.db")
 			sm, err := NewStorageManager(dbPath)
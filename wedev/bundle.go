@@ -0,0 +1,387 @@
+package wedev
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/wedevctl/version"
+)
+
+// Files of a node bundle besides the config, in a directory named after
+// the node.
+const (
+	bundleMetadataFile = "metadata.json"
+	bundleInstallFile  = "install.sh"
+)
+
+// maxBundleEntrySize caps how much of one bundle entry ReadNodeBundle reads.
+const maxBundleEntrySize = 1 << 20
+
+// BundleMetadata describes a node bundle; it is its metadata.json.
+type BundleMetadata struct {
+	Network   string    `json:"network"`
+	Node      string    `json:"node"`
+	Interface string    `json:"interface"`
+	Config    string    `json:"config"`  // file name of the config in the bundle
+	Version   string    `json:"version"` // wedevctl version that built the bundle
+	SHA256    string    `json:"sha256"`  // hex SHA-256 of the config
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NodeBundle is what a machine needs to join a network as one node: the
+// node's config, and an install script for machines without wedevctl.
+type NodeBundle struct {
+	Metadata BundleMetadata
+	Config   string
+}
+
+// BuildNodeBundle generates the config of a node and wraps it in a bundle
+// installing it as interface iface, the network name when empty. The
+// config holds the node's own private key and no other.
+func (wcg *WireGuardConfigGenerator) BuildNodeBundle(ctx context.Context, networkName, nodeName, iface string) (*NodeBundle, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	node, err := wcg.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if iface == "" {
+		iface = networkName
+	}
+	if !interfaceNamePattern.MatchString(iface) {
+		return nil, kindErrorf(ErrValidation, "invalid interface name %q (at most 15 letters, digits, or _=+.-); use --interface", iface)
+	}
+
+	config, err := wcg.GenerateConfigCtx(ctx, networkName, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOnlyPrivateKey(config, node.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(config))
+	return &NodeBundle{
+		Metadata: BundleMetadata{
+			Network:   networkName,
+			Node:      nodeName,
+			Interface: iface,
+			Config:    iface + ".conf",
+			Version:   version.Version,
+			SHA256:    hex.EncodeToString(sum[:]),
+			CreatedAt: time.Now().UTC(),
+		},
+		Config: config,
+	}, nil
+}
+
+// checkOnlyPrivateKey makes sure config holds no private key but key, so a
+// bundle can never leak another entity's.
+func checkOnlyPrivateKey(config, key string) error {
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(name) == "PrivateKey" && strings.TrimSpace(value) != key {
+			return fmt.Errorf("refusing to bundle a config holding a private key other than the node's")
+		}
+	}
+	return scanner.Err()
+}
+
+// installScript is the POSIX sh script of a bundle. It checks the config
+// against its checksum, then does what BundleInstaller does.
+var installScript = template.Must(template.New("install.sh").Parse(`#!/bin/sh
+# Installs the WireGuard config of node {{.Node}} of network {{.Network}}
+# as interface {{.Interface}}. Generated by wedevctl {{.Version}}.
+# Run as root from anywhere: sh install.sh [--force]
+set -eu
+
+IFACE='{{.Interface}}'
+CONF='{{.Config}}'
+SHA256='{{.SHA256}}'
+TARGET="/etc/wireguard/$IFACE.conf"
+
+cd "$(dirname "$0")"
+
+if command -v sha256sum >/dev/null 2>&1; then
+	sum=$(sha256sum "$CONF" | cut -d ' ' -f 1)
+elif command -v shasum >/dev/null 2>&1; then
+	sum=$(shasum -a 256 "$CONF" | cut -d ' ' -f 1)
+else
+	echo "install.sh: sha256sum or shasum is needed to check $CONF" >&2
+	exit 1
+fi
+if [ "$sum" != "$SHA256" ]; then
+	echo "install.sh: checksum mismatch for $CONF; the bundle is damaged or was modified" >&2
+	exit 1
+fi
+
+if [ "$(id -u)" -ne 0 ]; then
+	echo "install.sh: must run as root (try sudo)" >&2
+	exit 1
+fi
+if ! command -v wg-quick >/dev/null 2>&1; then
+	echo "install.sh: wg-quick not found in PATH; install wireguard-tools" >&2
+	exit 1
+fi
+if [ -e "$TARGET" ] && ! cmp -s "$CONF" "$TARGET" && [ "${1:-}" != "--force" ]; then
+	echo "install.sh: $TARGET exists with other content; rerun with --force to replace it" >&2
+	exit 1
+fi
+
+umask 077
+mkdir -p /etc/wireguard
+cp "$CONF" "$TARGET"
+chmod 600 "$TARGET"
+
+if command -v systemctl >/dev/null 2>&1; then
+	systemctl enable "wg-quick@$IFACE"
+	systemctl restart "wg-quick@$IFACE"
+else
+	wg-quick down "$TARGET" >/dev/null 2>&1 || true
+	wg-quick up "$TARGET"
+fi
+echo "Installed $TARGET and started interface $IFACE"
+`))
+
+// entries returns the files of the bundle, each in a directory named after
+// the node: the config, install.sh and metadata.json.
+func (b *NodeBundle) entries() ([]archiveEntry, error) {
+	var script bytes.Buffer
+	if err := installScript.Execute(&script, b.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to render install script: %w", err)
+	}
+	metadata, err := json.MarshalIndent(b.Metadata, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bundle metadata: %w", err)
+	}
+
+	dir := b.Metadata.Node + "/"
+	entries := []archiveEntry{
+		{name: dir + b.Metadata.Config, content: b.Config},
+		{name: dir + bundleInstallFile, content: script.String(), mode: 0o700},
+		{name: dir + bundleMetadataFile, content: string(metadata) + "\n"},
+	}
+	for _, entry := range entries {
+		if err := checkArchivePath(entry.name); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// WriteNodeBundle writes bundle to path as a .tar.gz, .tgz or .zip file
+// (see ArchiveFormatFor), like WriteConfigArchive.
+func WriteNodeBundle(path string, bundle *NodeBundle) error {
+	format, err := ArchiveFormatFor(path)
+	if err != nil {
+		return err
+	}
+	entries, err := bundle.entries()
+	if err != nil {
+		return err
+	}
+
+	return writeArchiveFile(path, func(w io.Writer) error {
+		if format == ArchiveZip {
+			return writeZip(w, entries, true, bundle.Metadata.CreatedAt)
+		}
+		return writeTarGz(w, entries, true, bundle.Metadata.CreatedAt)
+	})
+}
+
+// ReadNodeBundle reads a bundle written by WriteNodeBundle and checks its
+// config against the checksum in its metadata. A bundle that is damaged,
+// was modified, or is not a bundle is an ErrValidation error.
+func ReadNodeBundle(path string) (*NodeBundle, error) {
+	format, err := ArchiveFormatFor(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := readBundleFiles(path, format)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := files[bundleMetadataFile]
+	if !ok {
+		return nil, kindErrorf(ErrValidation, "%s is not a node bundle: it has no %s", path, bundleMetadataFile)
+	}
+	bundle := &NodeBundle{}
+	if err := json.Unmarshal(data, &bundle.Metadata); err != nil {
+		return nil, kindErrorf(ErrValidation, "invalid bundle metadata: %v", err)
+	}
+	meta := &bundle.Metadata
+	if !interfaceNamePattern.MatchString(meta.Interface) || meta.Config != meta.Interface+".conf" {
+		return nil, kindErrorf(ErrValidation, "invalid bundle metadata: interface %q, config %q", meta.Interface, meta.Config)
+	}
+	config, ok := files[meta.Config]
+	if !ok {
+		return nil, kindErrorf(ErrValidation, "bundle is missing its config %s", meta.Config)
+	}
+	sum := sha256.Sum256(config)
+	if hex.EncodeToString(sum[:]) != meta.SHA256 {
+		return nil, kindErrorf(ErrValidation, "checksum mismatch for %s; the bundle is damaged or was modified", meta.Config)
+	}
+	bundle.Config = string(config)
+	return bundle, nil
+}
+
+// readBundleFiles returns the regular files of a bundle archive by base
+// name.
+func readBundleFiles(file string, format ArchiveFormat) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	add := func(name string, r io.Reader) error {
+		data, err := io.ReadAll(io.LimitReader(r, maxBundleEntrySize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read bundle entry %s: %w", name, err)
+		}
+		if len(data) > maxBundleEntrySize {
+			return kindErrorf(ErrValidation, "bundle entry %s is larger than %d bytes", name, maxBundleEntrySize)
+		}
+		files[path.Base(name)] = data
+		return nil
+	}
+
+	if format == ArchiveZip {
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, kindErrorf(ErrValidation, "failed to open bundle: %v", err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, kindErrorf(ErrValidation, "failed to read bundle entry %s: %v", f.Name, err)
+			}
+			err = add(f.Name, rc)
+			//nolint:errcheck // Read-only entry; the read error is what matters
+			_ = rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	f, err := os.Open(file) // #nosec G304 -- the bundle path is given by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, kindErrorf(ErrValidation, "failed to open bundle: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, kindErrorf(ErrValidation, "failed to read bundle: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := add(header.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// BundleInstaller installs node bundles on the local machine the way their
+// install.sh does: the config is written to <dir>/<interface>.conf and the
+// interface is enabled and (re)started with systemd's wg-quick@ unit, or
+// with wg-quick where there is no systemd.
+type BundleInstaller struct {
+	runner  CommandRunner
+	geteuid func() int
+}
+
+// NewBundleInstaller creates a new BundleInstaller.
+func NewBundleInstaller() *BundleInstaller {
+	return &BundleInstaller{runner: execRunner{}, geteuid: os.Geteuid}
+}
+
+// Plan works out where a bundle's config is written, in configDir
+// (DefaultWireGuardDir when empty), and which commands start it.
+func (bi *BundleInstaller) Plan(bundle *NodeBundle, configDir string) *ApplyPlan {
+	if configDir == "" {
+		configDir = DefaultWireGuardDir
+	}
+	meta := bundle.Metadata
+	plan := &ApplyPlan{
+		Network:    meta.Network,
+		Entity:     meta.Node,
+		Interface:  meta.Interface,
+		ConfigPath: filepath.Join(configDir, meta.Config),
+		Config:     bundle.Config,
+	}
+	if _, err := bi.runner.LookPath("systemctl"); err == nil {
+		unit := "wg-quick@" + meta.Interface
+		plan.Commands = [][]string{{"systemctl", "enable", unit}, {"systemctl", "restart", unit}}
+	} else {
+		plan.Commands = [][]string{{"wg-quick", "down", plan.ConfigPath}, {"wg-quick", "up", plan.ConfigPath}}
+	}
+	return plan
+}
+
+// Install writes the planned config and runs its commands. It requires root
+// and wg-quick on PATH. An existing config with other content is replaced
+// only with force.
+func (bi *BundleInstaller) Install(ctx context.Context, plan *ApplyPlan, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if bi.geteuid() != 0 {
+		return fmt.Errorf("joining a network requires root privileges (try sudo, or use --dry-run)")
+	}
+	if _, err := bi.runner.LookPath("wg-quick"); err != nil {
+		return fmt.Errorf("wg-quick not found in PATH; install wireguard-tools: %w", err)
+	}
+	if existing, err := os.ReadFile(plan.ConfigPath); err == nil && string(existing) != plan.Config && !force {
+		return kindErrorf(ErrAlreadyExists, "%s exists with other content; use --force to replace it", plan.ConfigPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plan.ConfigPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(plan.ConfigPath, []byte(plan.Config), 0o600); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", plan.ConfigPath, err)
+	}
+	// WriteFile keeps the mode of a file it replaces.
+	if err := os.Chmod(plan.ConfigPath, 0o600); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	for _, command := range plan.Commands {
+		out, err := bi.runner.Run(ctx, command[0], command[1:]...)
+		if err != nil && !(command[0] == "wg-quick" && command[1] == "down") {
+			return fmt.Errorf("%s failed: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
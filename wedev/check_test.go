@@ -0,0 +1,78 @@
+package wedev
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckConfigDir(t *testing.T) {
+	configs := map[string]string{
+		"hub":    "# network: office, generated by wedevctl dev at 2026-01-01T00:00:00Z\n[Interface]\nAddress = 10.0.0.1/24\n",
+		"laptop": "# network: office, generated by wedevctl dev at 2026-01-01T00:00:00Z\n[Interface]\nAddress = 10.0.0.2/32\n",
+		"desk":   "[Interface]\nAddress = 10.0.0.3/32\n",
+		"branch": "[Interface]\nAddress = 10.0.0.4/32\n",
+	}
+	filenames := map[string]string{"hub": "wg-hub.conf", "laptop": "wg-laptop.conf", "desk": "wg-desk.conf", "branch": "wg-branch.conf"}
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	// hub was written at another time, which still matches; laptop was
+	// edited; desk is missing; branch has a trailing newline too many.
+	write("wg-hub.conf", "# network: office, generated by wedevctl dev at 2026-05-05T12:00:00Z\n[Interface]\nAddress = 10.0.0.1/24\n")
+	write("wg-laptop.conf", "# network: office, generated by wedevctl dev at 2026-01-01T00:00:00Z\n[Interface]\nAddress = 10.0.0.9/32\n")
+	write("wg-branch.conf", configs["branch"]+"\n")
+	write("old.conf", "[Interface]\n")
+	write("notes.txt", "not a config")
+	if err := os.Mkdir(filepath.Join(dir, "backup.conf"), 0o700); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	report, err := CheckConfigDir(dir, configs, filenames)
+	if err != nil {
+		t.Fatalf("CheckConfigDir() error = %v", err)
+	}
+	want := []ConfigFileCheck{
+		{File: "old.conf", Status: CheckExtra},
+		{File: "wg-branch.conf", Entity: "branch", Status: CheckDiffers},
+		{File: "wg-desk.conf", Entity: "desk", Status: CheckMissing},
+		{File: "wg-hub.conf", Entity: "hub", Status: CheckMatch},
+		{File: "wg-laptop.conf", Entity: "laptop", Status: CheckDiffers},
+	}
+	if !reflect.DeepEqual(report.Files, want) {
+		t.Errorf("Files = %+v\nwant %+v", report.Files, want)
+	}
+	if report.OK(false) || report.OK(true) {
+		t.Error("OK() = true with differing and missing files")
+	}
+
+	// Fix everything but the extra file.
+	write("wg-branch.conf", configs["branch"])
+	write("wg-laptop.conf", configs["laptop"])
+	write("wg-desk.conf", configs["desk"])
+	if report, err = CheckConfigDir(dir, configs, filenames); err != nil {
+		t.Fatalf("CheckConfigDir() error = %v", err)
+	}
+	if report.Count(CheckMatch) != 4 || report.Count(CheckExtra) != 1 {
+		t.Errorf("Files = %+v, want 4 matches and the extra file", report.Files)
+	}
+	if report.OK(false) || !report.OK(true) {
+		t.Errorf("OK(false), OK(true) = %v, %v; want only the extra file to fail the check", report.OK(false), report.OK(true))
+	}
+}
+
+func TestCheckConfigDir_MissingDir(t *testing.T) {
+	report, err := CheckConfigDir(filepath.Join(t.TempDir(), "absent"), map[string]string{"hub": "[Interface]\n"}, nil)
+	if err != nil {
+		t.Fatalf("CheckConfigDir() error = %v", err)
+	}
+	if want := []ConfigFileCheck{{File: "hub.conf", Entity: "hub", Status: CheckMissing}}; !reflect.DeepEqual(report.Files, want) {
+		t.Errorf("Files = %+v, want hub.conf missing", report.Files)
+	}
+}
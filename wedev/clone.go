@@ -0,0 +1,303 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/wedevctl/util"
+)
+
+// CloneOptions controls how CloneVirtualNetwork copies a network.
+type CloneOptions struct {
+	CIDR           string // CIDR of the copy; empty keeps the source's
+	ClearAddresses bool   // leave public and internal addresses of the copy empty
+}
+
+// CloneVirtualNetwork creates network dst as a copy of src. Settings, labels,
+// servers and nodes are copied with the same names, types, ports, labels,
+// routed CIDRs, server assignments, full-tunnel setting and expiry, each at
+// the same offset from the start of the network, but with fresh key pairs.
+// IP reservations are copied when the copy keeps the source's CIDR.
+// Config history is not copied. If any part fails, the partly created copy is deleted again.
+func (vnm *VirtualNetworkManager) CloneVirtualNetwork(src, dst string, opts CloneOptions) (*VirtualNetwork, error) {
+	return vnm.CloneVirtualNetworkCtx(context.Background(), src, dst, opts)
+}
+
+// CloneVirtualNetworkCtx is CloneVirtualNetwork with a context, checked
+// before each server and node is copied.
+func (vnm *VirtualNetworkManager) CloneVirtualNetworkCtx(ctx context.Context, src, dst string, opts CloneOptions) (*VirtualNetwork, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	source, err := vnm.storage.GetNetworkByNameCtx(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := vnm.validator.IsValidNetworkName(dst); err != nil {
+		return nil, err
+	}
+	if reservedNetworkNames[dst] {
+		return nil, fmt.Errorf("network name %q is reserved (it collides with a CLI command)", dst)
+	}
+
+	cidr := opts.CIDR
+	if cidr == "" {
+		cidr = source.CIDR
+	}
+	cidr, err = vnm.networkCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := util.NewIPPool(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IP pool: %w", err)
+	}
+
+	servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, source.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkIDCtx(ctx, source.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work out every address first, so a CIDR too small for the layout
+	// fails before anything is written.
+	remap, err := newOffsetRemapper(source.CIDR, cidr)
+	if err != nil {
+		return nil, err
+	}
+	serverIPs := make([]string, len(servers))
+	for i, server := range servers {
+		if serverIPs[i], err = remap.ip(server.VirtualIP); err != nil {
+			return nil, fmt.Errorf("server %s: %w", server.Name, err)
+		}
+		if err := pool.MarkIPAllocated(serverIPs[i]); err != nil {
+			return nil, fmt.Errorf("server %s: %w", server.Name, err)
+		}
+	}
+	nodeIPs := make([]string, len(nodes))
+	for i, node := range nodes {
+		if nodeIPs[i], err = remap.ip(node.VirtualIP); err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		if err := pool.MarkIPAllocated(nodeIPs[i]); err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		for _, routed := range node.RoutedCIDRs {
+			if prefix, err := netip.ParsePrefix(routed); err == nil && prefix.Overlaps(remap.to) {
+				return nil, fmt.Errorf("CIDR %s overlaps %s routed by node %s", remap.to, routed, node.Name)
+			}
+		}
+	}
+	// Addresses the source holds for reuse are recycled in the copy too, so
+	// its pool state matches.
+	var recycled []string
+	state, err := vnm.storage.GetIPPoolState(source.ID)
+	if err == nil && state != nil {
+		for _, ip := range state.Recycled {
+			if mapped, err := remap.ip(ip); err == nil && pool.MarkIPAllocated(mapped) == nil {
+				recycled = append(recycled, mapped)
+			}
+		}
+	}
+	pool.SyncNextIndex()
+	for _, ip := range recycled {
+		//nolint:errcheck // Marked allocated just above
+		_ = pool.ReleaseNodeIP(ip)
+	}
+	// Reserved ranges stand for addresses used outside wedevctl, so they
+	// only carry over when the copy keeps the same addresses.
+	if state != nil && remap.from == remap.to {
+		if err := keepReservations(pool, state); err != nil {
+			return nil, err
+		}
+	}
+
+	network, err := vnm.storage.CreateNetworkCtx(ctx, dst, cidr)
+	if err != nil {
+		return nil, err
+	}
+	if err := vnm.cloneInto(ctx, network, source, servers, serverIPs, nodes, nodeIPs, pool, opts); err != nil {
+		if delErr := vnm.storage.DeleteNetwork(dst); delErr != nil {
+			return nil, fmt.Errorf("failed to clone network: %w (and failed to remove the partial copy: %v)", err, delErr)
+		}
+		return nil, fmt.Errorf("failed to clone network: %w", err)
+	}
+
+	return vnm.storage.GetNetworkByNameCtx(ctx, dst)
+}
+
+// cloneInto copies the settings, servers and nodes of source into the newly
+// created network, giving each entity the address precomputed for it.
+func (vnm *VirtualNetworkManager) cloneInto(ctx context.Context, network, source *VirtualNetwork, servers []*Server, serverIPs []string, nodes []*Node, nodeIPs []string, pool *util.IPPool, opts CloneOptions) error {
+	if source.DefaultPort != 0 {
+		if err := vnm.storage.UpdateNetworkDefaultPort(network.ID, source.DefaultPort); err != nil {
+			return err
+		}
+	}
+	if source.NATMode != "" {
+		if err := vnm.storage.UpdateNetworkNATMode(network.ID, source.NATMode); err != nil {
+			return err
+		}
+	}
+	if source.MaxNodes != 0 {
+		if err := vnm.storage.UpdateNetworkMaxNodes(network.ID, source.MaxNodes); err != nil {
+			return err
+		}
+	}
+	if source.PoolWarnPercent != 0 {
+		if err := vnm.storage.UpdateNetworkPoolWarnPercent(network.ID, source.PoolWarnPercent); err != nil {
+			return err
+		}
+	}
+	if len(source.Labels) > 0 {
+		if err := vnm.storage.UpdateNetworkLabels(network.ID, source.Labels); err != nil {
+			return err
+		}
+	}
+	if len(source.Settings) > 0 {
+		if err := vnm.storage.UpdateNetworkSettings(network.ID, source.Settings); err != nil {
+			return err
+		}
+	}
+
+	state := pool.GetState()
+	serverIDs := make(map[string]string, len(servers)) // source ID -> copy ID
+	for i, server := range servers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, err := util.GenerateWireGuardKeys()
+		if err != nil {
+			return err
+		}
+		address := server.PublicAddress
+		if opts.ClearAddresses {
+			address = ""
+		}
+		created, err := vnm.storage.CreateServerWithPoolState(network.ID, server.Name, address, server.Port, serverIPs[i], keys.PrivateKey, keys.PublicKey, state)
+		if err != nil {
+			return fmt.Errorf("server %s: %w", server.Name, err)
+		}
+		if len(server.AdditionalAddresses) > 0 && !opts.ClearAddresses {
+			created.AdditionalAddresses = server.AdditionalAddresses
+			if err := vnm.storage.ReplaceServer(created, AnyRevision); err != nil {
+				return fmt.Errorf("server %s: %w", server.Name, err)
+			}
+		}
+		if server.InternalAddress != "" && !opts.ClearAddresses {
+			if err := vnm.storage.UpdateServerInternalEndpoint(created.ID, server.InternalAddress, server.InternalPort); err != nil {
+				return fmt.Errorf("server %s: %w", server.Name, err)
+			}
+		}
+		serverIDs[server.ID] = created.ID
+	}
+
+	for i, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, err := util.GenerateWireGuardKeys()
+		if err != nil {
+			return err
+		}
+		address := node.PublicAddress
+		if opts.ClearAddresses {
+			address = ""
+		}
+		created, err := vnm.storage.CreateNodeWithPoolState(network.ID, node.Name, address, node.Port, nodeIPs[i], node.Type, keys.PrivateKey, keys.PublicKey, state)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		if node.EndpointPreference != "" {
+			created.EndpointPreference = node.EndpointPreference
+			if err := vnm.storage.ReplaceNode(created, AnyRevision); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if len(node.RoutedCIDRs) > 0 {
+			if err := vnm.storage.UpdateNodeRoutedCIDRs(created.ID, node.RoutedCIDRs); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if len(node.Labels) > 0 {
+			if err := vnm.storage.UpdateNodeLabels(created.ID, node.Labels); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if node.ServerID != "" || node.MeshServers {
+			if err := vnm.storage.UpdateNodeServer(created.ID, serverIDs[node.ServerID], node.MeshServers); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if node.FullTunnel {
+			if err := vnm.storage.UpdateNodeFullTunnel(created.ID, true); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if node.InternalAddress != "" && !opts.ClearAddresses {
+			if err := vnm.storage.UpdateNodeInternalEndpoint(created.ID, node.InternalAddress, node.InternalPort); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if node.PreferInternal {
+			if err := vnm.storage.UpdateNodePreferInternal(created.ID, true); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if node.ExpiresAt != nil {
+			if err := vnm.storage.UpdateNodeExpiry(created.ID, node.ExpiresAt); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// offsetRemapper moves addresses from one network to another, keeping their
+// offset from the network address.
+type offsetRemapper struct {
+	from, to netip.Prefix
+}
+
+func newOffsetRemapper(fromCIDR, toCIDR string) (*offsetRemapper, error) {
+	from, err := netip.ParsePrefix(fromCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %s: %w", fromCIDR, err)
+	}
+	to, err := netip.ParsePrefix(toCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %s: %w", toCIDR, err)
+	}
+	if !from.Addr().Is4() || !to.Addr().Is4() {
+		return nil, fmt.Errorf("only IPv4 networks can be cloned")
+	}
+	return &offsetRemapper{from: from.Masked(), to: to.Masked()}, nil
+}
+
+// ip returns the address at ip's offset in the destination network. The
+// result must be a usable host address there: neither the network nor the
+// broadcast address.
+func (r *offsetRemapper) ip(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !r.from.Contains(addr) {
+		return "", fmt.Errorf("address %s is not in %s", ip, r.from)
+	}
+	offset := ipv4Value(addr) - ipv4Value(r.from.Addr())
+	size := uint64(1) << (32 - r.to.Bits())
+	if uint64(offset) >= size-1 {
+		return "", fmt.Errorf("address %s has offset %d, which does not fit in %s", ip, offset, r.to)
+	}
+	v := ipv4Value(r.to.Addr()) + offset
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}).String(), nil
+}
+
+// ipv4Value returns an IPv4 address as a number.
+func ipv4Value(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,206 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newCloneSource creates network "prod" with two servers, a peer node, a
+// route node with a LAN subnet, and a gap left by a deleted node.
+func newCloneSource(t *testing.T, vnm *VirtualNetworkManager) {
+	t.Helper()
+	if _, err := vnm.CreateVirtualNetwork("prod", "10.8.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.UpdateVirtualNetworkLabels("prod", map[string]string{"env": "prod"}, nil); err != nil {
+		t.Fatalf("UpdateVirtualNetworkLabels() error = %v", err)
+	}
+	if _, err := vnm.SetDNS("prod", []string{"10.8.0.1"}); err != nil {
+		t.Fatalf("SetDNS() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("prod", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer(hub) error = %v", err)
+	}
+	if _, err := vnm.CreateServer("prod", "edge", "edge.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer(edge) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("prod", "gone", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(gone) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("prod", "laptop", "203.0.113.7", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("prod", "branch", "", 0, []string{"192.168.10.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode() error = %v", err)
+	}
+	if err := vnm.DeleteNode("prod", "gone"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+	if _, err := vnm.UpdateNodeLabels("prod", "laptop", map[string]string{"team": "ops"}, nil); err != nil {
+		t.Fatalf("UpdateNodeLabels() error = %v", err)
+	}
+	if _, err := vnm.AssignNodeServer("prod", "branch", "edge", false); err != nil {
+		t.Fatalf("AssignNodeServer() error = %v", err)
+	}
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := vnm.SetNodeExpiry("prod", "laptop", &expires); err != nil {
+		t.Fatalf("SetNodeExpiry() error = %v", err)
+	}
+	if _, err := vnm.SetNodeFullTunnel("prod", "laptop", true); err != nil {
+		t.Fatalf("SetNodeFullTunnel() error = %v", err)
+	}
+	if _, err := vnm.SetNodeInternalEndpoint("prod", "laptop", "10.10.0.7", 0); err != nil {
+		t.Fatalf("SetNodeInternalEndpoint() error = %v", err)
+	}
+	if _, err := vnm.SetNodePreferInternal("prod", "laptop", true); err != nil {
+		t.Fatalf("SetNodePreferInternal() error = %v", err)
+	}
+}
+
+func TestCloneVirtualNetwork(t *testing.T) {
+	vnm, storage := newTestManager(t)
+	newCloneSource(t, vnm)
+
+	network, err := vnm.CloneVirtualNetwork("prod", "staging", CloneOptions{CIDR: "10.9.0.0/24"})
+	if err != nil {
+		t.Fatalf("CloneVirtualNetwork() error = %v", err)
+	}
+	if network.CIDR != "10.9.0.0/24" || network.Labels["env"] != "prod" || len(network.DNS()) != 1 {
+		t.Errorf("clone = %+v, want the new CIDR and copied settings", network)
+	}
+
+	for _, name := range []string{"hub", "edge"} {
+		src, _ := vnm.GetServer("prod", name)
+		dst, err := vnm.GetServer("staging", name)
+		if err != nil {
+			t.Fatalf("GetServer(staging, %s) error = %v", name, err)
+		}
+		if dst.PublicKey == src.PublicKey {
+			t.Errorf("server %s kept its key", name)
+		}
+		if dst.PublicAddress != src.PublicAddress {
+			t.Errorf("server %s address = %q, want %q", name, dst.PublicAddress, src.PublicAddress)
+		}
+	}
+	want := map[string]string{"laptop": "10.9.0.4", "branch": "10.9.0.5"}
+	for name, ip := range want {
+		src, _ := vnm.GetNode("prod", name)
+		dst, err := vnm.GetNode("staging", name)
+		if err != nil {
+			t.Fatalf("GetNode(staging, %s) error = %v", name, err)
+		}
+		if dst.VirtualIP != ip {
+			t.Errorf("node %s IP = %s, want %s (source %s)", name, dst.VirtualIP, ip, src.VirtualIP)
+		}
+		if dst.PublicKey == src.PublicKey || dst.Port != src.Port || dst.Type != src.Type {
+			t.Errorf("node %s = %+v, want a copy of %+v with new keys", name, dst, src)
+		}
+	}
+	laptop, _ := vnm.GetNode("staging", "laptop")
+	if laptop.Labels["team"] != "ops" || laptop.ExpiresAt == nil || !laptop.FullTunnel || laptop.InternalAddress != "10.10.0.7" || !laptop.PreferInternal {
+		t.Errorf("laptop = %+v, want its labels, expiry, full tunnel and internal endpoint copied", laptop)
+	}
+	branch, _ := vnm.GetNode("staging", "branch")
+	edge, _ := vnm.GetServer("staging", "edge")
+	if branch.ServerID != edge.ID || len(branch.RoutedCIDRs) != 1 {
+		t.Errorf("branch = %+v, want it on the copied edge server with its route", branch)
+	}
+
+	// The gap left by the deleted node is reused first, as in the source.
+	node, err := vnm.CreateNode("staging", "extra", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(staging) error = %v", err)
+	}
+	if node.VirtualIP != "10.9.0.3" {
+		t.Errorf("next IP = %s, want the recycled 10.9.0.3", node.VirtualIP)
+	}
+	report, err := vnm.AuditIPPool("staging")
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool(staging) = %+v, %v; want a consistent pool", report, err)
+	}
+
+	// The source is untouched.
+	srcNodes, err := storage.ListNodesByNetworkID(mustNetworkID(t, vnm, "prod"))
+	if err != nil || len(srcNodes) != 2 {
+		t.Errorf("source nodes = %d, %v; want 2", len(srcNodes), err)
+	}
+}
+
+func mustNetworkID(t *testing.T, vnm *VirtualNetworkManager, name string) string {
+	t.Helper()
+	network, err := vnm.GetVirtualNetwork(name)
+	if err != nil {
+		t.Fatalf("GetVirtualNetwork(%s) error = %v", name, err)
+	}
+	return network.ID
+}
+
+func TestCloneVirtualNetwork_Options(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	newCloneSource(t, vnm)
+
+	if _, err := vnm.CloneVirtualNetwork("prod", "same", CloneOptions{ClearAddresses: true}); err != nil {
+		t.Fatalf("CloneVirtualNetwork() error = %v", err)
+	}
+	laptop, _ := vnm.GetNode("same", "laptop")
+	hub, _ := vnm.GetServer("same", "hub")
+	if laptop.VirtualIP != "10.8.0.4" || laptop.PublicAddress != "" || laptop.InternalAddress != "" || hub.PublicAddress != "" {
+		t.Errorf("clone with cleared addresses: laptop %+v, hub %+v", laptop, hub)
+	}
+
+	for name, tc := range map[string]struct {
+		dst  string
+		opts CloneOptions
+	}{
+		"existing name": {"same", CloneOptions{}},
+		"reserved name": {"clone", CloneOptions{}},
+		"too small":     {"tiny", CloneOptions{CIDR: "10.9.0.0/30"}},
+		"overlap route": {"lan", CloneOptions{CIDR: "192.168.0.0/16"}},
+	} {
+		if _, err := vnm.CloneVirtualNetwork("prod", tc.dst, tc.opts); err == nil {
+			t.Errorf("CloneVirtualNetwork(%s) should fail", name)
+		}
+	}
+	if _, err := vnm.GetVirtualNetwork("tiny"); err == nil {
+		t.Error("a failed clone left the network behind")
+	}
+	if _, err := vnm.GetServer("same", "hub"); err != nil {
+		t.Errorf("cloning onto an existing name touched it: %v", err)
+	}
+	if _, err := vnm.CloneVirtualNetwork("missing", "other", CloneOptions{}); err == nil {
+		t.Error("cloning a missing network should fail")
+	}
+}
+
+// cancelOnNetworkCtx reports itself cancelled once network name exists, so
+// a clone fails after creating the copy.
+type cancelOnNetworkCtx struct {
+	context.Context
+	vnm  *VirtualNetworkManager
+	name string
+}
+
+func (c cancelOnNetworkCtx) Err() error {
+	if _, err := c.vnm.storage.GetNetworkByName(c.name); err == nil {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestCloneVirtualNetwork_RollsBack(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	newCloneSource(t, vnm)
+
+	ctx := cancelOnNetworkCtx{Context: context.Background(), vnm: vnm, name: "staging"}
+	if _, err := vnm.CloneVirtualNetworkCtx(ctx, "prod", "staging", CloneOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CloneVirtualNetworkCtx() error = %v, want context.Canceled", err)
+	}
+	if _, err := vnm.GetVirtualNetwork("staging"); err == nil {
+		t.Error("the partial copy was not removed")
+	}
+	if _, err := vnm.CloneVirtualNetwork("prod", "staging", CloneOptions{}); err != nil {
+		t.Errorf("CloneVirtualNetwork() after rollback error = %v", err)
+	}
+}
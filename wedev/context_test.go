@@ -0,0 +1,72 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCtxVariants_Cancelled(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("ctx", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("ctx", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("ctx", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sm.ListNodesByNetworkIDCtx(ctx, network.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListNodesByNetworkIDCtx() error = %v, want context.Canceled", err)
+	}
+	if _, err := sm.CreateNetworkCtx(ctx, "other", "10.1.0.0/24"); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateNetworkCtx() error = %v, want context.Canceled", err)
+	}
+	if _, err := sm.GetNetworkByName("other"); err == nil {
+		t.Error("CreateNetworkCtx() with a cancelled context created the network")
+	}
+
+	generator := NewWireGuardConfigGenerator(sm)
+	if _, _, err := generator.GenerateConfigsCtx(ctx, "ctx", sm); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateConfigsCtx() error = %v, want context.Canceled", err)
+	}
+	if _, _, err := generator.SaveConfigVersionWithMessageCtx(ctx, "ctx", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveConfigVersionWithMessageCtx() error = %v, want context.Canceled", err)
+	}
+	if history, err := generator.GetConfigHistory("ctx"); err != nil || len(history) != 0 {
+		t.Errorf("GetConfigHistory() = %d versions, %v, want none saved", len(history), err)
+	}
+
+	// The plain wrappers still work against the same storage.
+	if configs, _, err := generator.GenerateConfigs("ctx", sm); err != nil || len(configs) != 2 {
+		t.Errorf("GenerateConfigs() = %d configs, %v, want 2", len(configs), err)
+	}
+}
+
+func TestOpenWithRetry_Cancelled(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	holder, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer holder.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = NewStorageManagerWithOptionsCtx(ctx, dbPath, StorageOptions{LockTimeout: 10 * time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("open error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("open gave up after %s, want it to stop when the context ended", elapsed)
+	}
+}
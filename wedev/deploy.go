@@ -0,0 +1,298 @@
+package wedev
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDeployParallel is how many hosts a deploy configures at once.
+	DefaultDeployParallel = 4
+	// DefaultDeployTimeout bounds the deploy of a single host.
+	DefaultDeployTimeout = 2 * time.Minute
+)
+
+// RemoteRunner runs a shell command on a remote host with input on its
+// standard input. It is an interface so tests can substitute a fake for ssh.
+type RemoteRunner interface {
+	Run(ctx context.Context, host, command string, input []byte) ([]byte, error)
+}
+
+// sshRunner is the RemoteRunner backed by the ssh client on PATH, so the
+// user's ssh config, agent and known hosts apply.
+type sshRunner struct{}
+
+func (sshRunner) Run(ctx context.Context, host, command string, input []byte) ([]byte, error) {
+	// #nosec G204 -- host is a stored address or --host value after "--"; command is built from quoted paths.
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "--", host, command)
+	cmd.Stdin = bytes.NewReader(input)
+	return cmd.CombinedOutput()
+}
+
+// DeployOptions controls how configs are deployed to remote hosts.
+type DeployOptions struct {
+	Interface string            // interface name on the hosts; defaults to the network name
+	ConfigDir string            // directory for <interface>.conf on the hosts; defaults to DefaultWireGuardDir
+	User      string            // SSH login user; empty leaves it to the ssh config
+	Hosts     map[string]string // SSH host per server or node name, instead of its public address
+	Parallel  int               // hosts deployed at once; 0 means DefaultDeployParallel
+	Timeout   time.Duration     // limit for one host; 0 means DefaultDeployTimeout
+	Force     bool              // also deploy hosts whose recorded deployment is current
+}
+
+// DeployStatus is the outcome of deploying to one host.
+type DeployStatus string
+
+const (
+	// DeploySucceeded means the config was installed and the interface is up.
+	DeploySucceeded DeployStatus = "succeeded"
+	// DeploySkipped means the host was not contacted; Detail says why.
+	DeploySkipped DeployStatus = "skipped"
+	// DeployFailed means installing the config failed; Detail holds the error.
+	DeployFailed DeployStatus = "failed"
+)
+
+// DeployResult reports the deploy of one server or node.
+type DeployResult struct {
+	Entity  string       `json:"entity"`
+	Kind    string       `json:"kind"` // "server" or "node"
+	Host    string       `json:"host,omitempty"`
+	Version int          `json:"version"`
+	Status  DeployStatus `json:"status"`
+	Detail  string       `json:"detail,omitempty"`
+}
+
+// RemoteDeployer installs the configs of a network's latest saved version on
+// its servers and nodes over SSH and records each successful deployment, so
+// a later run skips the hosts that are still current.
+type RemoteDeployer struct {
+	generator *WireGuardConfigGenerator
+	storage   Storage
+	runner    RemoteRunner
+}
+
+// NewRemoteDeployer creates a new RemoteDeployer
+func NewRemoteDeployer(storage Storage) *RemoteDeployer {
+	return &RemoteDeployer{
+		generator: NewWireGuardConfigGenerator(storage),
+		storage:   storage,
+		runner:    sshRunner{},
+	}
+}
+
+// Deploy installs the latest saved config of each of the named servers and
+// nodes (all of them when names is empty) on its host and (re)starts the
+// interface with wg-quick, opts.Parallel hosts at a time. A host is reached
+// at its entry in opts.Hosts or else its public address; entities with
+// neither, and unless opts.Force those whose recorded deployment is current,
+// are skipped. Each success is recorded as it happens.
+//
+// Cancelling ctx stops starting new hosts: the hosts in flight finish, within
+// opts.Timeout, and are recorded, the rest are skipped, and Deploy returns
+// the results with ctx's error. Otherwise the error says how many failed.
+func (rd *RemoteDeployer) Deploy(ctx context.Context, networkName string, names []string, opts DeployOptions) ([]DeployResult, error) {
+	iface := opts.Interface
+	if iface == "" {
+		iface = networkName
+	}
+	if !interfaceNamePattern.MatchString(iface) {
+		return nil, fmt.Errorf("invalid interface name %q (at most 15 letters, digits, or _=+.-); use --interface", iface)
+	}
+	dir := opts.ConfigDir
+	if dir == "" {
+		dir = DefaultWireGuardDir
+	}
+	parallel := opts.Parallel
+	if parallel == 0 {
+		parallel = DefaultDeployParallel
+	}
+	if parallel < 0 {
+		return nil, fmt.Errorf("parallel must be at least 1, got %d", parallel)
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultDeployTimeout
+	}
+
+	network, err := rd.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := rd.storage.GetLatestConfigVersionCtx(ctx, network.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("no configuration versions saved for network %s; run 'config generate' first", networkName)
+		}
+		return nil, err
+	}
+	states, err := rd.generator.DeploymentStatesCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := rd.publicAddresses(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range opts.Hosts {
+		if _, ok := addresses[name]; !ok {
+			return nil, fmt.Errorf("--host for %s: no server or node named %s in network %s", name, name, networkName)
+		}
+	}
+	if len(names) > 0 {
+		wanted := make(map[string]bool, len(names))
+		for _, name := range names {
+			if _, ok := latest.Configs[name]; !ok {
+				return nil, fmt.Errorf("no config for %s in version %d of network %s", name, latest.Version, networkName)
+			}
+			wanted[name] = true
+		}
+		var selected []EntityDeployment
+		for _, state := range states {
+			if wanted[state.Entity] {
+				selected = append(selected, state)
+			}
+		}
+		states = selected
+	}
+
+	configPath := path.Join(dir, iface+".conf")
+	script := deployScript(configPath)
+	results := make([]DeployResult, len(states))
+	var pending []int
+	for i, state := range states {
+		result := DeployResult{Entity: state.Entity, Kind: state.Kind, Version: latest.Version}
+		host := opts.Hosts[state.Entity]
+		if host == "" {
+			host = addresses[state.Entity]
+		}
+		switch {
+		case state.Status == DeploymentCurrent && !opts.Force:
+			result.Status, result.Version = DeploySkipped, state.DeployedVersion
+			result.Detail = "up to date"
+		case host == "":
+			result.Status = DeploySkipped
+			result.Detail = "no public address; pass --host " + state.Entity + "=<host>"
+		default:
+			if opts.User != "" {
+				host = opts.User + "@" + host
+			}
+			result.Host = host
+			pending = append(pending, i)
+		}
+		results[i] = result
+	}
+
+	// Hosts in flight keep running after ctx is cancelled, bounded by the
+	// timeout, and their deployments are still recorded; hosts not started
+	// by then are skipped.
+	const notStarted = "interrupted before it started"
+	detached := context.WithoutCancel(ctx)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(parallel, len(pending)) {
+		wg.Go(func() {
+			for i := range jobs {
+				result := &results[i]
+				if ctx.Err() != nil {
+					result.Status, result.Detail = DeploySkipped, notStarted
+					continue
+				}
+				config := latest.Configs[result.Entity]
+				hostCtx, cancel := context.WithTimeout(detached, timeout)
+				out, err := rd.runner.Run(hostCtx, result.Host, script, []byte(config))
+				if errors.Is(hostCtx.Err(), context.DeadlineExceeded) {
+					err = fmt.Errorf("timed out after %s", timeout)
+				}
+				cancel()
+				if err != nil {
+					result.Status, result.Detail = DeployFailed, err.Error()
+					if msg := strings.TrimSpace(string(out)); msg != "" {
+						result.Detail += ": " + msg
+					}
+					continue
+				}
+				if _, err := rd.generator.RecordDeploymentCtx(detached, networkName, result.Entity, config); err != nil {
+					result.Status = DeployFailed
+					result.Detail = "deployed, but recording the deployment failed: " + err.Error()
+					continue
+				}
+				result.Status = DeploySucceeded
+			}
+		})
+	}
+
+	for _, i := range pending {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i].Status, results[i].Detail = DeploySkipped, notStarted
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Status == DeployFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("deploy failed on %d of %d host(s)", failed, len(pending))
+	}
+	return results, nil
+}
+
+// publicAddresses maps the name of each server and node of a network to its
+// public address, which is empty for nodes that have none.
+func (rd *RemoteDeployer) publicAddresses(ctx context.Context, networkID string) (map[string]string, error) {
+	servers, err := rd.storage.ListServersByNetworkIDCtx(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := rd.storage.ListNodesByNetworkIDCtx(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[string]string, len(servers)+len(nodes))
+	for _, server := range servers {
+		addresses[server.Name] = server.PublicAddress
+	}
+	for _, node := range nodes {
+		addresses[node.Name] = node.PublicAddress
+	}
+	return addresses, nil
+}
+
+// deployScript returns the shell script a host runs to install the config it
+// reads from standard input at configPath and restart the interface. The
+// file is replaced by a rename, so an interrupted copy leaves the old one.
+func deployScript(configPath string) string {
+	file := shellQuote(configPath)
+	tmp := shellQuote(configPath + ".tmp")
+	return strings.Join([]string{
+		"set -e",
+		"umask 077",
+		"mkdir -p " + shellQuote(path.Dir(configPath)),
+		"cat > " + tmp,
+		"mv " + tmp + " " + file,
+		"wg-quick down " + file + " >/dev/null 2>&1 || true",
+		"wg-quick up " + file,
+	}, "\n")
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,247 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemote records the hosts it deploys to and the configs it receives.
+type fakeRemote struct {
+	mu       sync.Mutex
+	configs  map[string]string // host -> config read from stdin
+	fail     map[string]bool
+	active   int
+	peak     int
+	onRun    func(ctx context.Context, host string) error
+	commands []string
+}
+
+func (f *fakeRemote) Run(ctx context.Context, host, command string, input []byte) ([]byte, error) {
+	f.mu.Lock()
+	f.active++
+	f.peak = max(f.peak, f.active)
+	f.commands = append(f.commands, command)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	if f.onRun != nil {
+		if err := f.onRun(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	if f.fail[host] {
+		return []byte("wg-quick: `wedev' already exists"), errors.New("exit status 1")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs[host] = string(input)
+	return nil, nil
+}
+
+// newDeployTestNetwork creates a network with a server, two peers with public
+// addresses and a client without one, saves a version, and returns a
+// deployer wired to a fake remote.
+func newDeployTestNetwork(t *testing.T) (*RemoteDeployer, *fakeRemote) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("wedev", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("wedev", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("wedev", "n1", "198.51.100.1", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(n1) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("wedev", "n2", "198.51.100.2", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(n2) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("wedev", "laptop", "", 0, NodeTypeClient); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+	rd := NewRemoteDeployer(sm)
+	if _, _, err := rd.generator.SaveConfigVersion("wedev"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	remote := &fakeRemote{configs: map[string]string{}, fail: map[string]bool{}}
+	rd.runner = remote
+	return rd, remote
+}
+
+func deployResults(results []DeployResult) map[string]DeployResult {
+	byName := make(map[string]DeployResult, len(results))
+	for _, r := range results {
+		byName[r.Entity] = r
+	}
+	return byName
+}
+
+func TestRemoteDeployer_Deploy(t *testing.T) {
+	rd, remote := newDeployTestNetwork(t)
+	ctx := context.Background()
+
+	results, err := rd.Deploy(ctx, "wedev", nil, DeployOptions{User: "root", Parallel: 2})
+	if err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	got := deployResults(results)
+	for _, name := range []string{"hub", "n1", "n2"} {
+		if got[name].Status != DeploySucceeded || got[name].Version != 1 {
+			t.Errorf("%s = %+v, want succeeded at version 1", name, got[name])
+		}
+	}
+	if got["hub"].Host != "root@vpn.example.com" {
+		t.Errorf("hub host = %q, want the user and public address", got["hub"].Host)
+	}
+	if got["laptop"].Status != DeploySkipped || !strings.Contains(got["laptop"].Detail, "--host laptop=") {
+		t.Errorf("laptop = %+v, want skipped for lack of a host", got["laptop"])
+	}
+	config, err := rd.generator.GenerateConfig("wedev", "n1")
+	if err != nil {
+		t.Fatalf("GenerateConfig() error = %v", err)
+	}
+	if remote.configs["root@198.51.100.1"] != config {
+		t.Errorf("n1 received %q, want its saved config", remote.configs["root@198.51.100.1"])
+	}
+	if !strings.Contains(remote.commands[0], "mv '/etc/wireguard/wedev.conf.tmp' '/etc/wireguard/wedev.conf'") ||
+		!strings.Contains(remote.commands[0], "wg-quick up '/etc/wireguard/wedev.conf'") {
+		t.Errorf("remote command = %q, want the config installed and the interface brought up", remote.commands[0])
+	}
+
+	// Deployed hosts are current now and skipped; a --host reaches the laptop.
+	results, err = rd.Deploy(ctx, "wedev", nil, DeployOptions{Hosts: map[string]string{"laptop": "laptop.lan"}})
+	if err != nil {
+		t.Fatalf("Deploy(again) error = %v", err)
+	}
+	got = deployResults(results)
+	for _, name := range []string{"hub", "n1", "n2"} {
+		if got[name].Status != DeploySkipped || got[name].Detail != "up to date" {
+			t.Errorf("%s on re-run = %+v, want skipped as up to date", name, got[name])
+		}
+	}
+	if got["laptop"].Status != DeploySucceeded || got["laptop"].Host != "laptop.lan" {
+		t.Errorf("laptop with --host = %+v, want succeeded", got["laptop"])
+	}
+
+	results, err = rd.Deploy(ctx, "wedev", []string{"n2"}, DeployOptions{Force: true})
+	if err != nil || len(results) != 1 || results[0].Status != DeploySucceeded {
+		t.Errorf("Deploy(n2, force) = %+v, %v; want n2 redeployed", results, err)
+	}
+
+	if _, err := rd.Deploy(ctx, "wedev", []string{"ghost"}, DeployOptions{}); err == nil {
+		t.Error("Deploy(unknown entity) should fail")
+	}
+	if _, err := rd.Deploy(ctx, "wedev", nil, DeployOptions{Hosts: map[string]string{"ghost": "h"}}); err == nil {
+		t.Error("Deploy(--host for unknown entity) should fail")
+	}
+}
+
+func TestRemoteDeployer_Failures(t *testing.T) {
+	rd, remote := newDeployTestNetwork(t)
+	remote.fail["198.51.100.2"] = true
+
+	results, err := rd.Deploy(context.Background(), "wedev", nil, DeployOptions{})
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("Deploy() error = %v, want one failed host reported", err)
+	}
+	got := deployResults(results)
+	if got["n2"].Status != DeployFailed || !strings.Contains(got["n2"].Detail, "already exists") {
+		t.Errorf("n2 = %+v, want failed with the remote output", got["n2"])
+	}
+
+	// Only the failed host is retried.
+	remote.fail = map[string]bool{}
+	results, err = rd.Deploy(context.Background(), "wedev", nil, DeployOptions{})
+	if err != nil {
+		t.Fatalf("Deploy(retry) error = %v", err)
+	}
+	got = deployResults(results)
+	if got["n2"].Status != DeploySucceeded || got["n1"].Status != DeploySkipped || got["hub"].Status != DeploySkipped {
+		t.Errorf("retry = %+v, want only n2 deployed", results)
+	}
+}
+
+func TestRemoteDeployer_Parallel(t *testing.T) {
+	rd, remote := newDeployTestNetwork(t)
+	remote.onRun = func(context.Context, string) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+
+	if _, err := rd.Deploy(context.Background(), "wedev", nil, DeployOptions{Parallel: 2}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	if remote.peak != 2 {
+		t.Errorf("peak concurrent hosts = %d, want 2", remote.peak)
+	}
+}
+
+func TestRemoteDeployer_Timeout(t *testing.T) {
+	rd, remote := newDeployTestNetwork(t)
+	remote.onRun = func(ctx context.Context, host string) error {
+		if host != "vpn.example.com" {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	results, err := rd.Deploy(context.Background(), "wedev", nil, DeployOptions{Timeout: 20 * time.Millisecond})
+	if err == nil {
+		t.Error("Deploy() with a hung host should fail")
+	}
+	got := deployResults(results)
+	if got["hub"].Status != DeployFailed || !strings.Contains(got["hub"].Detail, "timed out") {
+		t.Errorf("hub = %+v, want failed on the timeout", got["hub"])
+	}
+	if got["n1"].Status != DeploySucceeded {
+		t.Errorf("n1 = %+v, want succeeded despite the hung hub", got["n1"])
+	}
+}
+
+// TestRemoteDeployer_Interrupt cancels the deploy while the first host is in
+// flight: that host finishes and is recorded, the others are not started.
+func TestRemoteDeployer_Interrupt(t *testing.T) {
+	rd, remote := newDeployTestNetwork(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remote.onRun = func(hostCtx context.Context, _ string) error {
+		cancel()
+		if hostCtx.Err() != nil {
+			t.Error("the in-flight host's context was cancelled with the deploy")
+		}
+		return nil
+	}
+
+	results, err := rd.Deploy(ctx, "wedev", nil, DeployOptions{Parallel: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Deploy() error = %v, want context.Canceled", err)
+	}
+	got := deployResults(results)
+	if got["hub"].Status != DeploySucceeded {
+		t.Errorf("hub = %+v, want the in-flight host finished", got["hub"])
+	}
+	for _, name := range []string{"n1", "n2"} {
+		if got[name].Status != DeploySkipped || !strings.Contains(got[name].Detail, "interrupted") {
+			t.Errorf("%s = %+v, want skipped as interrupted", name, got[name])
+		}
+	}
+
+	states, err := rd.generator.DeploymentStates("wedev")
+	if err != nil {
+		t.Fatalf("DeploymentStates() error = %v", err)
+	}
+	for _, s := range states {
+		if (s.Entity == "hub") != (s.Status == DeploymentCurrent) {
+			t.Errorf("%s state = %s, want only hub recorded", s.Entity, s.Status)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DeploymentStatus says whether the config deployed to an entity is current.
+type DeploymentStatus string
+
+const (
+	// DeploymentCurrent means the deployed config matches the latest version.
+	DeploymentCurrent DeploymentStatus = "current"
+	// DeploymentStale means a later version changed the entity's config.
+	DeploymentStale DeploymentStatus = "stale"
+	// DeploymentNever means no deployment of the entity was recorded.
+	DeploymentNever DeploymentStatus = "never_deployed"
+)
+
+// EntityDeployment compares the config an entity runs with the latest saved
+// version. CurrentVersion is the latest version that changed the entity's
+// config; Behind counts the versions since the deployed one that did.
+type EntityDeployment struct {
+	Entity          string           `json:"entity"`
+	Kind            string           `json:"kind"` // "server" or "node"
+	CurrentVersion  int              `json:"current_version"`
+	DeployedVersion int              `json:"deployed_version,omitempty"`
+	DeployedAt      *time.Time       `json:"deployed_at,omitempty"`
+	Behind          int              `json:"behind,omitempty"`
+	Status          DeploymentStatus `json:"status"`
+}
+
+// DeploymentStates reports, for every server and node with a config in the
+// network's latest saved version, whether the config last deployed to it
+// (by 'config apply') is still current. It fails when no version is saved.
+func (wcg *WireGuardConfigGenerator) DeploymentStates(networkName string) ([]EntityDeployment, error) {
+	return wcg.DeploymentStatesCtx(context.Background(), networkName)
+}
+
+// DeploymentStatesCtx is DeploymentStates with a context.
+func (wcg *WireGuardConfigGenerator) DeploymentStatesCtx(ctx context.Context, networkName string) ([]EntityDeployment, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := wcg.storage.ListConfigVersionsCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no configuration versions saved for network %s; run 'config generate' first", networkName)
+	}
+	latest := versions[len(versions)-1]
+
+	servers, err := wcg.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := wcg.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := wcg.storage.ListDeploymentsCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	type entity struct{ id, kind string }
+	entities := make(map[string]entity, len(servers)+len(nodes))
+	for _, server := range servers {
+		entities[server.Name] = entity{server.ID, "server"}
+	}
+	for _, node := range nodes {
+		entities[node.Name] = entity{node.ID, "node"}
+	}
+
+	var states []EntityDeployment
+	for name, config := range latest.Configs {
+		e, ok := entities[name]
+		if !ok {
+			// Deleted since the latest version was saved.
+			continue
+		}
+		state := EntityDeployment{Entity: name, Kind: e.kind, CurrentVersion: lastChanged(versions, name)}
+
+		deployment := deployments[e.id]
+		switch {
+		case deployment == nil:
+			state.Status = DeploymentNever
+		case deployment.ContentHash == EntityConfigHash(config), deployment.Version >= state.CurrentVersion:
+			state.Status = DeploymentCurrent
+		default:
+			state.Status = DeploymentStale
+			state.Behind = changedCount(versions, name, deployment.Version)
+		}
+		if deployment != nil {
+			state.DeployedVersion = deployment.Version
+			deployedAt := deployment.DeployedAt
+			state.DeployedAt = &deployedAt
+		}
+		states = append(states, state)
+	}
+
+	// Servers first, then by name.
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Kind != states[j].Kind {
+			return states[i].Kind == "server"
+		}
+		return states[i].Entity < states[j].Entity
+	})
+	return states, nil
+}
+
+// lastChanged returns the latest of versions (ordered oldest first) that
+// changed name's config, or 0 when none did.
+func lastChanged(versions []*ConfigVersion, name string) int {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if slices.Contains(versions[i].Changed, name) {
+			return versions[i].Version
+		}
+	}
+	return 0
+}
+
+// changedCount returns how many of versions after version since changed
+// name's config.
+func changedCount(versions []*ConfigVersion, name string, since int) int {
+	count := 0
+	for _, v := range versions {
+		if v.Version > since && slices.Contains(v.Changed, name) {
+			count++
+		}
+	}
+	return count
+}
+
+// RecordDeployment records that config was deployed to the server or node
+// entityName of a network. The deployment is tied to the latest saved
+// version when config matches the entity's config there, and to no version
+// (0) otherwise, for example when changes have not been saved yet.
+func (wcg *WireGuardConfigGenerator) RecordDeployment(networkName, entityName, config string) (*Deployment, error) {
+	return wcg.RecordDeploymentCtx(context.Background(), networkName, entityName, config)
+}
+
+// RecordDeploymentCtx is RecordDeployment with a context.
+func (wcg *WireGuardConfigGenerator) RecordDeploymentCtx(ctx context.Context, networkName, entityName, config string) (*Deployment, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	entityID := ""
+	if server, err := wcg.storage.GetServerByName(network.ID, entityName); err == nil {
+		entityID = server.ID
+	} else if node, err := wcg.storage.GetNodeByName(network.ID, entityName); err == nil {
+		entityID = node.ID
+	} else {
+		return nil, fmt.Errorf("no server or node named %s in network %s", entityName, networkName)
+	}
+
+	deployment := &Deployment{
+		EntityID:    entityID,
+		NetworkID:   network.ID,
+		ContentHash: EntityConfigHash(config),
+		DeployedAt:  time.Now(),
+	}
+	if latest, err := wcg.storage.GetLatestConfigVersionCtx(ctx, network.ID); err == nil {
+		if saved, ok := latest.Configs[entityName]; ok && normalizeConfig(saved) == normalizeConfig(config) {
+			deployment.Version = latest.Version
+		}
+	}
+
+	if err := wcg.storage.SaveDeployment(deployment); err != nil {
+		return nil, fmt.Errorf("failed to save deployment: %w", err)
+	}
+	return deployment, nil
+}
@@ -0,0 +1,160 @@
+package wedev
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestDeploymentStates walks a network through generate, deploy and change
+// cycles and checks the per-entity staleness reported at each step.
+func TestDeploymentStates(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	gen := NewWireGuardConfigGenerator(sm)
+
+	if _, err := vnm.CreateVirtualNetwork("deploy", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("deploy", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("deploy", "laptop", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+
+	if _, err := gen.DeploymentStates("deploy"); err == nil {
+		t.Error("DeploymentStates() without a saved version should fail")
+	}
+
+	v1, _, err := gen.SaveConfigVersion("deploy")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if !slices.Equal(v1.Changed, []string{"hub", "laptop"}) {
+		t.Errorf("version 1 Changed = %v, want [hub laptop]", v1.Changed)
+	}
+
+	states := deploymentStates(t, gen)
+	for name, state := range states {
+		if state.Status != DeploymentNever || state.CurrentVersion != 1 {
+			t.Errorf("%s before any deploy = %+v, want never deployed at version 1", name, state)
+		}
+	}
+
+	for name, config := range v1.Configs {
+		d, err := gen.RecordDeployment("deploy", name, config)
+		if err != nil {
+			t.Fatalf("RecordDeployment(%s) error = %v", name, err)
+		}
+		if d.Version != 1 {
+			t.Errorf("RecordDeployment(%s).Version = %d, want 1", name, d.Version)
+		}
+	}
+	for name, state := range deploymentStates(t, gen) {
+		if state.Status != DeploymentCurrent || state.DeployedVersion != 1 || state.DeployedAt == nil {
+			t.Errorf("%s after deploy = %+v, want current at version 1", name, state)
+		}
+	}
+
+	// A new node changes the hub's config but not the laptop's.
+	if _, err := vnm.CreateNode("deploy", "phone", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(phone) error = %v", err)
+	}
+	v2, _, err := gen.SaveConfigVersion("deploy")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if !slices.Equal(v2.Changed, []string{"hub", "phone"}) {
+		t.Errorf("version 2 Changed = %v, want [hub phone]", v2.Changed)
+	}
+
+	states = deploymentStates(t, gen)
+	if s := states["hub"]; s.Status != DeploymentStale || s.Behind != 1 || s.CurrentVersion != 2 || s.DeployedVersion != 1 {
+		t.Errorf("hub = %+v, want stale by one at version 2", s)
+	}
+	if s := states["laptop"]; s.Status != DeploymentCurrent || s.CurrentVersion != 1 {
+		t.Errorf("laptop = %+v, want current at version 1", s)
+	}
+	if s := states["phone"]; s.Status != DeploymentNever {
+		t.Errorf("phone = %+v, want never deployed", s)
+	}
+
+	// Deploying content that was never saved ties the deployment to no
+	// version; it is current only while it matches the latest config.
+	if d, err := gen.RecordDeployment("deploy", "hub", "unsaved"); err != nil || d.Version != 0 {
+		t.Fatalf("RecordDeployment(unsaved) = %+v, %v; want version 0", d, err)
+	}
+	if s := deploymentStates(t, gen)["hub"]; s.Status != DeploymentStale || s.Behind != 2 {
+		t.Errorf("hub after unsaved deploy = %+v, want stale by two", s)
+	}
+	if d, err := gen.RecordDeployment("deploy", "hub", v2.Configs["hub"]); err != nil || d.Version != 2 {
+		t.Fatalf("RecordDeployment(hub) = %+v, %v; want version 2", d, err)
+	}
+	if s := deploymentStates(t, gen)["hub"]; s.Status != DeploymentCurrent {
+		t.Errorf("hub after redeploy = %+v, want current", s)
+	}
+
+	if _, err := gen.RecordDeployment("deploy", "ghost", "x"); err == nil {
+		t.Error("RecordDeployment() for an unknown entity should fail")
+	}
+
+	// Deleting an entity drops its deployment record.
+	if err := vnm.DeleteNode("deploy", "laptop"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+	network, err := sm.GetNetworkByName("deploy")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	deployments, err := sm.ListDeployments(network.ID)
+	if err != nil {
+		t.Fatalf("ListDeployments() error = %v", err)
+	}
+	if len(deployments) != 1 {
+		t.Errorf("ListDeployments() after delete = %d records, want 1 (hub)", len(deployments))
+	}
+}
+
+// TestConfigApplier_RecordsDeployment checks that a successful apply is
+// recorded against the latest saved version.
+func TestConfigApplier_RecordsDeployment(t *testing.T) {
+	ca, _ := newApplyTestNetwork(t, 0)
+	if _, _, err := ca.generator.SaveConfigVersion("applynet"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	opts := ApplyOptions{ConfigDir: t.TempDir()}
+	plan, err := ca.Plan("applynet", "n1", opts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := ca.Apply(plan, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	states, err := ca.generator.DeploymentStates("applynet")
+	if err != nil {
+		t.Fatalf("DeploymentStates() error = %v", err)
+	}
+	for _, s := range states {
+		want := DeploymentNever
+		if s.Entity == "n1" {
+			want = DeploymentCurrent
+		}
+		if s.Status != want {
+			t.Errorf("%s status = %s, want %s", s.Entity, s.Status, want)
+		}
+	}
+}
+
+func deploymentStates(t *testing.T, gen *WireGuardConfigGenerator) map[string]EntityDeployment {
+	t.Helper()
+	states, err := gen.DeploymentStates("deploy")
+	if err != nil {
+		t.Fatalf("DeploymentStates() error = %v", err)
+	}
+	byName := make(map[string]EntityDeployment, len(states))
+	for _, s := range states {
+		byName[s.Entity] = s
+	}
+	return byName
+}
@@ -0,0 +1,237 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// NetworkSummary is what a network holds: the records deleting it removes
+// and how much of its IP pool is in use.
+type NetworkSummary struct {
+	Network        *VirtualNetwork  `json:"network"`
+	Servers        []string         `json:"servers"`       // server names, sorted
+	Nodes          int              `json:"nodes"`         // node count
+	NodesByType    map[NodeType]int `json:"nodes_by_type"` // node count per type
+	ConfigVersions int              `json:"config_versions"`
+	Pool           *PoolUsage       `json:"pool,omitempty"` // nil when the network's CIDR is invalid
+}
+
+// DescribeNetwork summarizes a network's servers, nodes, saved config
+// versions and IP pool utilization. It changes nothing.
+func (vnm *VirtualNetworkManager) DescribeNetwork(name string) (*NetworkSummary, error) {
+	return vnm.DescribeNetworkCtx(context.Background(), name)
+}
+
+// DescribeNetworkCtx is DescribeNetwork with a context.
+func (vnm *VirtualNetworkManager) DescribeNetworkCtx(ctx context.Context, name string) (*NetworkSummary, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &NetworkSummary{Network: network, Servers: []string{}, NodesByType: make(map[NodeType]int)}
+
+	servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		summary.Servers = append(summary.Servers, server.Name)
+	}
+	sort.Strings(summary.Servers)
+
+	nodes, err := vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Nodes = len(nodes)
+	for _, node := range nodes {
+		summary.NodesByType[node.Type]++
+	}
+
+	versions, err := vnm.storage.ListConfigVersionsCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	summary.ConfigVersions = len(versions)
+
+	// A network with an invalid CIDR is still described, to help fix it.
+	if checkNetworkCIDR(network) == nil {
+		if summary.Pool, err = vnm.PoolUsage(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// NetworkOverview is the state of a network at a glance, as 'vn list --wide'
+// shows it.
+type NetworkOverview struct {
+	ServerEndpoint string     `json:"server_endpoint,omitempty"` // public endpoint of the network's first server
+	Nodes          int        `json:"nodes"`
+	LatestVersion  int        `json:"latest_version,omitempty"` // latest saved config version; 0 when none is saved
+	GeneratedAt    *time.Time `json:"generated_at,omitempty"`   // when the latest version was saved
+	PoolPercent    float64    `json:"pool_percent"`             // IP pool utilization, see PoolUsage
+	InvalidCIDR    bool       `json:"invalid_cidr,omitempty"`   // the CIDR cannot hold a pool; PoolPercent is 0
+}
+
+// NetworkOverviews returns the overview of each of networks, by network ID.
+// Nodes are counted for all networks in one pass; the server, latest
+// version and pool of each network are indexed lookups.
+func (vnm *VirtualNetworkManager) NetworkOverviews(ctx context.Context, networks []*VirtualNetwork) (map[string]*NetworkOverview, error) {
+	counts, err := vnm.storage.CountNodesByNetworkCtx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count nodes: %w", err)
+	}
+
+	overviews := make(map[string]*NetworkOverview, len(networks))
+	for _, network := range networks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		overview := &NetworkOverview{Nodes: counts[network.ID]}
+		servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+		if err != nil {
+			return nil, fmt.Errorf("network %s: %w", network.Name, err)
+		}
+		if len(servers) > 0 && servers[0].PublicAddress != "" {
+			overview.ServerEndpoint = servers[0].EndpointFor(false)
+		}
+		latest, err := vnm.storage.GetLatestConfigVersionCtx(ctx, network.ID)
+		switch {
+		case err == nil:
+			overview.LatestVersion, overview.GeneratedAt = latest.Version, &latest.CreatedAt
+		case !errors.Is(err, ErrNotFound):
+			return nil, fmt.Errorf("network %s: %w", network.Name, err)
+		}
+		if checkNetworkCIDR(network) != nil {
+			overview.InvalidCIDR = true
+		} else {
+			pool, err := vnm.readIPPool(network)
+			if err != nil {
+				return nil, fmt.Errorf("network %s: %w", network.Name, err)
+			}
+			overview.PoolPercent = poolUsage(pool).Percent()
+		}
+		overviews[network.ID] = overview
+	}
+	return overviews, nil
+}
+
+// NodeSummary is a node with its place in the generated configs: the peers
+// its own config lists and the configs that list it.
+type NodeSummary struct {
+	Node    *Node    `json:"node"`
+	Server  string   `json:"server,omitempty"` // assigned server; empty when the network has none
+	Expired bool     `json:"expired,omitempty"`
+	Peers   []string `json:"peers"`   // peers of the node's config: servers first, then nodes by name
+	PeerOf  []string `json:"peer_of"` // configs listing the node: servers first, then nodes by name
+}
+
+// DescribeNode returns a node of a network with the peers of its generated
+// config and the configs that list it. An expired node is in no config, and
+// a node with imported public-only keys gets none of its own. It changes
+// nothing.
+func (vnm *VirtualNetworkManager) DescribeNode(networkName, nodeName string) (*NodeSummary, error) {
+	return vnm.DescribeNodeCtx(context.Background(), networkName, nodeName)
+}
+
+// DescribeNodeCtx is DescribeNode with a context.
+func (vnm *VirtualNetworkManager) DescribeNodeCtx(ctx context.Context, networkName, nodeName string) (*NodeSummary, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := vnm.now()
+	summary := &NodeSummary{Node: node, Expired: node.Expired(now), Peers: []string{}, PeerOf: []string{}}
+	server := NodeServer(node, servers)
+	if server != nil {
+		summary.Server = server.Name
+	}
+	// Without a server only a mesh network's configs are generated; an
+	// expired node is left out of all of them.
+	if (server == nil && network.NeedsServer()) || summary.Expired {
+		return summary, nil
+	}
+
+	active := make([]*Node, 0, len(nodes))
+	for _, other := range nodes {
+		if !other.Expired(now) {
+			active = append(active, other)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Name < active[j].Name })
+
+	if !node.ExternallyManaged() {
+		summary.Peers = nodeConfigPeers(network, servers, node, active)
+	}
+	for _, s := range servers {
+		if !s.ExternallyManaged() && servesNode(s, servers, node) {
+			summary.PeerOf = append(summary.PeerOf, s.Name)
+		}
+	}
+	for _, other := range active {
+		if other.ID == node.ID || other.ExternallyManaged() {
+			continue
+		}
+		if slices.Contains(nodeConfigPeers(network, servers, other, active), node.Name) {
+			summary.PeerOf = append(summary.PeerOf, other.Name)
+		}
+	}
+
+	return summary, nil
+}
+
+// nodeConfigPeers returns the names of the peers generateNodeConfig lists in
+// the config of node, given the network's servers and its nodes that have
+// not expired: the assigned server, the other servers for a node meshed with
+// all of them, and the nodes it peers with directly.
+func nodeConfigPeers(network *VirtualNetwork, servers []*Server, node *Node, nodes []*Node) []string {
+	peers := []string{}
+	if server := NodeServer(node, servers); server != nil {
+		peers = append(peers, server.Name)
+		if node.MeshServers {
+			for _, other := range servers {
+				if other.ID != server.ID {
+					peers = append(peers, other.Name)
+				}
+			}
+		}
+	}
+
+	for _, other := range nodes {
+		var direct bool
+		switch {
+		case network.EffectiveTopology() == TopologyMesh:
+			direct = meshPeers(node, other)
+		case other.Type != NodeTypePeer || other.ID == node.ID:
+			// Hub-spoke configs list peer nodes only, never the node itself.
+		default:
+			// Peer nodes list the other peer nodes; route nodes list every
+			// peer node.
+			direct = node.Type == NodeTypePeer || node.Type == NodeTypeRoute
+		}
+		if direct {
+			peers = append(peers, other.Name)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,220 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/wedevctl/util"
+)
+
+func TestDescribeNetwork(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("desc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	summary, err := vnm.DescribeNetwork("desc")
+	if err != nil {
+		t.Fatalf("DescribeNetwork() error = %v", err)
+	}
+	if len(summary.Servers) != 0 || summary.Nodes != 0 || summary.ConfigVersions != 0 {
+		t.Errorf("DescribeNetwork() of an empty network = %+v", summary)
+	}
+
+	for _, name := range []string{"hub2", "hub1"} {
+		if _, err := vnm.CreateServer("desc", name, name+".example.com", 0); err != nil {
+			t.Fatalf("CreateServer(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.CreateNode("desc", "p1", "203.0.113.1", 0, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(p1) error = %v", err)
+	}
+	for _, name := range []string{"r1", "r2"} {
+		if _, err := vnm.CreateNode("desc", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, _, err := NewWireGuardConfigGenerator(vnm.storage).SaveConfigVersion("desc"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	summary, err = vnm.DescribeNetwork("desc")
+	if err != nil {
+		t.Fatalf("DescribeNetwork() error = %v", err)
+	}
+	if want := []string{"hub1", "hub2"}; !reflect.DeepEqual(summary.Servers, want) {
+		t.Errorf("Servers = %v, want %v", summary.Servers, want)
+	}
+	if want := map[NodeType]int{NodeTypePeer: 1, NodeTypeRoute: 2}; summary.Nodes != 3 || !reflect.DeepEqual(summary.NodesByType, want) {
+		t.Errorf("Nodes = %d %v, want 3 %v", summary.Nodes, summary.NodesByType, want)
+	}
+	if summary.ConfigVersions != 1 {
+		t.Errorf("ConfigVersions = %d, want 1", summary.ConfigVersions)
+	}
+	if want := (PoolUsage{Allocated: 5, Total: 254}); summary.Pool == nil || *summary.Pool != want {
+		t.Errorf("Pool = %+v, want %+v", summary.Pool, want)
+	}
+
+	if _, err := vnm.DescribeNetwork("ghost"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DescribeNetwork() for an unknown network error = %v, want ErrNotFound", err)
+	}
+}
+
+// configPeers returns the peer names in each generated config of a network,
+// read from the comment above each [Peer] section.
+func configPeers(t *testing.T, vnm *VirtualNetworkManager, network string) map[string][]string {
+	t.Helper()
+	configs, _, err := NewWireGuardConfigGenerator(vnm.storage).GenerateConfigs(network, vnm.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	header := regexp.MustCompile(`(?m)^# (\S+) \(.*\)\n\[Peer\]`)
+	peers := make(map[string][]string)
+	for name, config := range configs {
+		peers[name] = []string{}
+		for _, m := range header.FindAllStringSubmatch(config, -1) {
+			peers[name] = append(peers[name], m[1])
+		}
+	}
+	return peers
+}
+
+func TestNetworkOverviews(t *testing.T) {
+	for _, backend := range []struct {
+		name    string
+		storage func(t *testing.T) Storage
+	}{
+		{"bolt", func(t *testing.T) Storage { _, sm := newTestManager(t); return sm }},
+		{"memory", func(*testing.T) Storage { return NewMemoryStorage() }},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			vnm, err := NewVirtualNetworkManager(backend.storage(t), util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+			for _, name := range []string{"busy", "quiet"} {
+				if _, err := vnm.CreateVirtualNetwork(name, "10.0.0.0/29"); err != nil {
+					t.Fatalf("CreateVirtualNetwork(%s) error = %v", name, err)
+				}
+			}
+			if _, err := vnm.CreateServer("busy", "srv", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			for _, name := range []string{"n1", "n2", "n3"} {
+				if _, err := vnm.CreateNode("busy", name, "", 0, NodeTypeRoute); err != nil {
+					t.Fatalf("CreateNode(%s) error = %v", name, err)
+				}
+			}
+			gen := NewWireGuardConfigGenerator(vnm.storage)
+			for i := 0; i < 2; i++ {
+				if _, _, err := gen.SaveConfigVersion("busy"); err != nil {
+					t.Fatalf("SaveConfigVersion() error = %v", err)
+				}
+				if _, err := vnm.CreateNode("busy", fmt.Sprintf("extra%d", i), "", 0, NodeTypeRoute); err != nil {
+					t.Fatalf("CreateNode() error = %v", err)
+				}
+			}
+
+			networks, err := vnm.ListVirtualNetworks()
+			if err != nil {
+				t.Fatalf("ListVirtualNetworks() error = %v", err)
+			}
+			overviews, err := vnm.NetworkOverviews(context.Background(), networks)
+			if err != nil {
+				t.Fatalf("NetworkOverviews() error = %v", err)
+			}
+			byName := map[string]*NetworkOverview{}
+			for _, network := range networks {
+				byName[network.Name] = overviews[network.ID]
+			}
+
+			busy := byName["busy"]
+			if busy.Nodes != 5 || busy.ServerEndpoint != "vpn.example.com:51820" || busy.LatestVersion != 2 || busy.GeneratedAt == nil {
+				t.Errorf("busy overview = %+v, want 5 nodes, the server endpoint and v2", busy)
+			}
+			// A /29 has 6 usable addresses, all held by the server and nodes.
+			if busy.PoolPercent != 100 {
+				t.Errorf("busy pool = %.1f%%, want 100%%", busy.PoolPercent)
+			}
+			// Only the address reserved for the first server is taken.
+			if quiet := byName["quiet"]; *quiet != (NetworkOverview{PoolPercent: 100.0 / 6}) {
+				t.Errorf("quiet overview = %+v, want no nodes, server or version", quiet)
+			}
+		})
+	}
+}
+
+func TestDescribeNode(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("desc", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("desc", "hub", "hub.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for name, address := range map[string]string{"p2": "203.0.113.2", "p1": "203.0.113.1"} {
+		if _, err := vnm.CreateNode("desc", name, address, 0, NodeTypePeer); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"r1", "r2", "gone"} {
+		if _, err := vnm.CreateNode("desc", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, err := vnm.SetNodeExpiry("desc", "gone", &past); err != nil {
+		t.Fatalf("SetNodeExpiry() error = %v", err)
+	}
+
+	// The summary agrees with the generated configs in both topologies.
+	for _, topology := range []Topology{TopologyHubSpoke, TopologyMesh} {
+		if _, err := vnm.SetTopology("desc", topology); err != nil {
+			t.Fatalf("SetTopology(%s) error = %v", topology, err)
+		}
+		peers := configPeers(t, vnm, "desc")
+		for _, name := range []string{"p1", "p2", "r1", "r2"} {
+			summary, err := vnm.DescribeNode("desc", name)
+			if err != nil {
+				t.Fatalf("DescribeNode(%s) error = %v", name, err)
+			}
+			if summary.Server != "hub" {
+				t.Errorf("%s: %s Server = %q, want hub", topology, name, summary.Server)
+			}
+			want := append([]string(nil), peers[name]...)
+			sort.Strings(want[1:])
+			if !reflect.DeepEqual(summary.Peers, want) {
+				t.Errorf("%s: %s Peers = %v, want %v", topology, name, summary.Peers, want)
+			}
+			peerOf := []string{}
+			for config, listed := range peers {
+				for _, peer := range listed {
+					if peer == name && config != "hub" {
+						peerOf = append(peerOf, config)
+					}
+				}
+			}
+			sort.Strings(peerOf)
+			if want := append([]string{"hub"}, peerOf...); !reflect.DeepEqual(summary.PeerOf, want) {
+				t.Errorf("%s: %s PeerOf = %v, want %v", topology, name, summary.PeerOf, want)
+			}
+		}
+	}
+
+	summary, err := vnm.DescribeNode("desc", "gone")
+	if err != nil {
+		t.Fatalf("DescribeNode(gone) error = %v", err)
+	}
+	if !summary.Expired || len(summary.Peers) != 0 || len(summary.PeerOf) != 0 {
+		t.Errorf("DescribeNode() of an expired node = %+v, want it in no config", summary)
+	}
+	if _, err := vnm.DescribeNode("desc", "ghost"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DescribeNode() for an unknown node error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,287 @@
+package wedev
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// FileDiffStatus describes how a config file differs between two sets.
+type FileDiffStatus string
+
+const (
+	// FileAdded marks a config present only in the newer set.
+	FileAdded FileDiffStatus = "new file"
+	// FileRemoved marks a config present only in the older set.
+	FileRemoved FileDiffStatus = "removed file"
+	// FileModified marks a config whose content changed.
+	FileModified FileDiffStatus = "modified"
+)
+
+// FileDiff is the difference for one config file (entity name).
+type FileDiff struct {
+	Name    string
+	Status  FileDiffStatus
+	Unified string // unified diff body; empty for added and removed files
+}
+
+// String renders the diff: a marker line for added and removed files, a
+// unified diff for modified ones.
+func (d FileDiff) String() string {
+	if d.Status != FileModified {
+		return fmt.Sprintf("%s: %s.conf\n", d.Status, d.Name)
+	}
+	return d.Unified
+}
+
+// DiffConfigs compares two name -> config maps and returns one FileDiff per
+// changed file, sorted by name. Generation times are left out, so configs
+// differing only there are unchanged. oldLabel and newLabel annotate the
+// ---/+++ headers (for example "version 3" and "generated").
+func DiffConfigs(oldConfigs, newConfigs map[string]string, oldLabel, newLabel string) []FileDiff {
+	names := make(map[string]bool, len(oldConfigs)+len(newConfigs))
+	for name := range oldConfigs {
+		names[name] = true
+	}
+	for name := range newConfigs {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var diffs []FileDiff
+	for _, name := range sorted {
+		oldConfig, inOld := oldConfigs[name]
+		newConfig, inNew := newConfigs[name]
+		oldConfig, newConfig = normalizeConfig(oldConfig), normalizeConfig(newConfig)
+		switch {
+		case !inOld:
+			diffs = append(diffs, FileDiff{Name: name, Status: FileAdded})
+		case !inNew:
+			diffs = append(diffs, FileDiff{Name: name, Status: FileRemoved})
+		case oldConfig != newConfig:
+			file := name + ".conf"
+			diffs = append(diffs, FileDiff{
+				Name:    name,
+				Status:  FileModified,
+				Unified: UnifiedDiff("a/"+file+" ("+oldLabel+")", "b/"+file+" ("+newLabel+")", oldConfig, newConfig),
+			})
+		}
+	}
+	return diffs
+}
+
+// summaryListLimit is how many names SummarizeChanges lists per change kind
+// before it gives a count instead.
+const summaryListLimit = 3
+
+// SummarizeChanges describes how newConfigs differ from oldConfigs in one
+// line, grouped by servers and nodes, for example
+// "servers: ~hub1; nodes: +node5, -node2". + marks an added config, - a
+// removed one, and ~ a modified one. It returns "" when nothing changed.
+func SummarizeChanges(oldConfigs, newConfigs map[string]string) string {
+	type changes struct{ added, removed, modified []string }
+	var servers, nodes changes
+	for _, diff := range DiffConfigs(oldConfigs, newConfigs, "", "") {
+		config, ok := newConfigs[diff.Name]
+		if !ok {
+			config = oldConfigs[diff.Name]
+		}
+		group := &nodes
+		if isServerConfig(config) {
+			group = &servers
+		}
+		switch diff.Status {
+		case FileAdded:
+			group.added = append(group.added, diff.Name)
+		case FileRemoved:
+			group.removed = append(group.removed, diff.Name)
+		case FileModified:
+			group.modified = append(group.modified, diff.Name)
+		}
+	}
+
+	list := func(names []string, mark, verb string) []string {
+		if len(names) > summaryListLimit {
+			return []string{fmt.Sprintf("%d %s", len(names), verb)}
+		}
+		marked := make([]string, len(names))
+		for i, name := range names {
+			marked[i] = mark + name
+		}
+		return marked
+	}
+	var parts []string
+	for _, g := range []struct {
+		label string
+		c     changes
+	}{{"servers", servers}, {"nodes", nodes}} {
+		var items []string
+		items = append(items, list(g.c.added, "+", "added")...)
+		items = append(items, list(g.c.removed, "-", "removed")...)
+		items = append(items, list(g.c.modified, "~", "changed")...)
+		if len(items) > 0 {
+			parts = append(parts, g.label+": "+strings.Join(items, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// isServerConfig reports whether a generated config is a server's: only
+// server configs turn on IP forwarding.
+func isServerConfig(config string) bool {
+	return strings.Contains(config, "\nPostUp = sysctl -w net.ipv4.ip_forward=1\n")
+}
+
+// diffOp is one line of an edit script.
+type diffOp struct {
+	kind byte // ' ', '-', or '+'
+	line string
+}
+
+// UnifiedDiff returns a unified diff of a and b, or "" when they are equal.
+func UnifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// Walk the edit script, emitting one hunk per run of changes with up to
+	// diffContext lines of context; runs closer than 2*diffContext merge.
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-diffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end = min(end+diffContext, len(ops))
+
+		aStart, bStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		// An empty range is reported at the line before it, per diff(1).
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+
+	return out.String()
+}
+
+// splitLines splits s into lines without their trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a line edit script from a to b. The common prefix and
+// suffix are trimmed first, so the quadratic LCS table only covers the
+// changed region — small for typical config edits.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the LCS length of midA[i:] and midB[j:].
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', midA[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		ops = append(ops, diffOp{'-', midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		ops = append(ops, diffOp{'+', midB[j]})
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
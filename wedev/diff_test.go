@@ -0,0 +1,114 @@
+package wedev
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\ntwo\nTHREE\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n"
+
+	got := UnifiedDiff("a", "b", a, b)
+	want := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ one
+ two
+-three
++THREE
+ four
+ five
+ six
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+`
+	if got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := UnifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("UnifiedDiff() of equal inputs = %q, want empty", got)
+	}
+}
+
+func TestUnifiedDiff_MergesNearbyChanges(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	b := "1\nX\n3\n4\n5\n6\nY\n8\n"
+
+	got := UnifiedDiff("a", "b", a, b)
+	if strings.Count(got, "@@ -") != 1 {
+		t.Errorf("changes 4 lines apart should share one hunk:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -1,8 +1,8 @@") {
+		t.Errorf("unexpected hunk header:\n%s", got)
+	}
+}
+
+func TestUnifiedDiff_FromEmpty(t *testing.T) {
+	got := UnifiedDiff("a", "b", "", "x\ny\n")
+	if !strings.Contains(got, "@@ -0,0 +1,2 @@\n+x\n+y\n") {
+		t.Errorf("UnifiedDiff() from empty =\n%s", got)
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfigs := map[string]string{"gone": "x\n", "same": "s\n", "changed": "a\nb\n"}
+	newConfigs := map[string]string{"added": "y\n", "same": "s\n", "changed": "a\nc\n"}
+
+	diffs := DiffConfigs(oldConfigs, newConfigs, "version 1", "generated")
+	if len(diffs) != 3 {
+		t.Fatalf("DiffConfigs() returned %d diffs, want 3: %+v", len(diffs), diffs)
+	}
+
+	want := []struct {
+		name   string
+		status FileDiffStatus
+	}{{"added", FileAdded}, {"changed", FileModified}, {"gone", FileRemoved}}
+	for i, w := range want {
+		if diffs[i].Name != w.name || diffs[i].Status != w.status {
+			t.Errorf("diffs[%d] = %s/%s, want %s/%s", i, diffs[i].Name, diffs[i].Status, w.name, w.status)
+		}
+	}
+
+	if got := diffs[0].String(); got != "new file: added.conf\n" {
+		t.Errorf("added String() = %q", got)
+	}
+	if got := diffs[2].String(); got != "removed file: gone.conf\n" {
+		t.Errorf("removed String() = %q", got)
+	}
+	if got := diffs[1].String(); !strings.Contains(got, "--- a/changed.conf (version 1)") || !strings.Contains(got, "-b\n+c\n") {
+		t.Errorf("modified String() =\n%s", got)
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	server := "[Interface]\nPrivateKey = s\nPostUp = sysctl -w net.ipv4.ip_forward=1\n"
+	old := map[string]string{"hub": server, "a": "a1", "b": "b1", "c": "c1"}
+
+	tests := []struct {
+		name string
+		new  map[string]string
+		want string
+	}{
+		{"unchanged", old, ""},
+		{"node added and removed", map[string]string{"hub": server, "a": "a1", "b": "b1", "d": "d1"}, "nodes: +d, -c"},
+		{"server and node changed", map[string]string{"hub": server + "x", "a": "a2", "b": "b1", "c": "c1"}, "servers: ~hub; nodes: ~a"},
+		{"many changed", map[string]string{"hub": server, "a": "a2", "b": "b2", "c": "c2", "e": "e", "f": "f", "g": "g", "h": "h"}, "nodes: 4 added, ~a, ~b, ~c"},
+		{"server removed", map[string]string{"a": "a1", "b": "b1", "c": "c1"}, "servers: -hub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizeChanges(old, tt.new); got != tt.want {
+				t.Errorf("SummarizeChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := SummarizeChanges(nil, map[string]string{"hub": server, "a": "a1"}); got != "servers: +hub; nodes: +a" {
+		t.Errorf("SummarizeChanges() from nothing = %q", got)
+	}
+}
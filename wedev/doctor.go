@@ -0,0 +1,492 @@
+package wedev
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wedevctl/util"
+	"github.com/wedevctl/version"
+	"go.etcd.io/bbolt"
+)
+
+// DoctorStatus is the outcome of one doctor check.
+type DoctorStatus string
+
+const (
+	// DoctorPass is a check that found nothing wrong.
+	DoctorPass DoctorStatus = "PASS"
+	// DoctorWarn is a check that found something suspicious but harmless
+	// to the configs, such as a hostname that does not resolve from here.
+	DoctorWarn DoctorStatus = "WARN"
+	// DoctorFail is a check that found something broken.
+	DoctorFail DoctorStatus = "FAIL"
+)
+
+// DoctorCheck is the result of one check run by Doctor.
+type DoctorCheck struct {
+	Name    string       `json:"name"`
+	Network string       `json:"network,omitempty"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+	Details []string     `json:"details,omitempty"`
+	Hint    string       `json:"hint,omitempty"` // how to fix a WARN or FAIL
+}
+
+// DoctorReport is what Doctor found. It is meant to be attached to bug
+// reports, so it holds no secrets.
+type DoctorReport struct {
+	Database  string        `json:"database"`
+	Version   string        `json:"wedevctl_version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Checks    []DoctorCheck `json:"checks"`
+
+	export *DatabaseExport // set with DoctorOptions.Bundle
+}
+
+// Count returns the number of checks with the given status.
+func (r *DoctorReport) Count(status DoctorStatus) int {
+	n := 0
+	for _, check := range r.Checks {
+		if check.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *DoctorReport) add(check DoctorCheck) {
+	r.Checks = append(r.Checks, check)
+}
+
+// DoctorOptions configures Doctor.
+type DoctorOptions struct {
+	// Storage opens the database; Doctor always opens it read-only.
+	Storage StorageOptions
+	// Network limits the per-network checks to one network; all when empty.
+	Network string
+	// Resolve looks up the hostnames used as server and node endpoints.
+	Resolve bool
+	// Resolver looks up a hostname; net.DefaultResolver when nil.
+	Resolver func(ctx context.Context, host string) ([]string, error)
+	// Bundle keeps a secrets-redacted export of the database with the
+	// report, for WriteDoctorBundle.
+	Bundle bool
+}
+
+// resolveTimeout bounds each hostname lookup of the endpoint check.
+const resolveTimeout = 5 * time.Second
+
+// Doctor checks the health of the database at dbPath: that it opens, has
+// every bucket, passes CheckIntegrity, and that each network's IP pool state
+// matches its records, its keys are well-formed, its records pass
+// ValidateNetwork and, with DoctorOptions.Resolve, its endpoint hostnames
+// resolve. A database that does not open is a FAIL, not an error; the error
+// is for a DoctorOptions.Network that does not exist, or a cancelled ctx.
+func Doctor(ctx context.Context, dbPath string, opts DoctorOptions) (*DoctorReport, error) {
+	report := &DoctorReport{Database: dbPath, Version: version.Version, CreatedAt: time.Now().UTC(), Checks: []DoctorCheck{}}
+
+	storageOpts := opts.Storage
+	storageOpts.ReadOnly = true
+	sm, err := NewStorageManagerWithOptionsCtx(ctx, dbPath, storageOpts)
+	if err != nil {
+		report.add(openFailure(dbPath, err))
+		return report, nil
+	}
+	//nolint:errcheck // Read-only handle; nothing to flush on close
+	defer func() { _ = sm.Close() }()
+
+	version, err := sm.SchemaVersion()
+	if err != nil {
+		report.add(DoctorCheck{Name: "database", Status: DoctorFail, Message: fmt.Sprintf("cannot read the schema version: %v", err),
+			Hint: "restore the latest backup with 'wedevctl db restore'"})
+		return report, nil
+	}
+	report.add(DoctorCheck{Name: "database", Status: DoctorPass, Message: fmt.Sprintf("opened, schema version %d", version)})
+
+	if !checkDoctorBuckets(sm, report) {
+		return report, nil
+	}
+	checkDoctorIntegrity(sm, report)
+
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		return nil, err
+	}
+	networks, err := sm.ListNetworksCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Network != "" {
+		network, err := sm.GetNetworkByNameCtx(ctx, opts.Network)
+		if err != nil {
+			return nil, err
+		}
+		networks = []*VirtualNetwork{network}
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+
+	for _, network := range networks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := checkNetwork(ctx, vnm, network, opts, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Bundle {
+		if report.export, err = sm.ExportRedacted(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// openFailure turns the error opening the database into a failed check
+// with a hint matching its cause.
+func openFailure(dbPath string, err error) DoctorCheck {
+	check := DoctorCheck{Name: "database", Status: DoctorFail, Message: err.Error()}
+	var openErr *OpenError
+	switch {
+	case errors.As(DiagnoseOpenError(dbPath, err), &openErr) && openErr.Hint != "":
+		check.Hint = openErr.Hint
+	case errors.Is(err, fs.ErrNotExist):
+		check.Message = "database does not exist"
+		check.Hint = "check --db and $WEDEVCTL_DB_PATH; any write command, such as 'wedevctl vn add', creates it"
+	case errors.Is(err, ErrDBLocked):
+		check.Hint = "wait for the other wedevctl process to finish, or raise --db-timeout"
+	case errors.Is(err, ErrSchemaOutdated):
+		check.Hint = "run 'wedevctl db migrate' to bring the schema up to date"
+	default:
+		check.Hint = "the file may be damaged; restore the latest backup with 'wedevctl db restore'"
+	}
+	return check
+}
+
+// doctorBuckets are the buckets a database with an up-to-date schema holds.
+var doctorBuckets = append([]string{BucketMeta, BucketDeployments, BucketRevisions, BucketHistory, BucketTags, BucketSequences}, allBuckets...)
+
+// checkDoctorBuckets reports missing buckets and whether all are present; the
+// other checks need them.
+func checkDoctorBuckets(sm *StorageManager, report *DoctorReport) bool {
+	var missing []string
+	if err := sm.view(func(tx *bbolt.Tx) error {
+		for _, name := range doctorBuckets {
+			if tx.Bucket([]byte(name)) == nil {
+				missing = append(missing, name)
+			}
+		}
+		return nil
+	}); err != nil {
+		report.add(DoctorCheck{Name: "buckets", Status: DoctorFail, Message: err.Error()})
+		return false
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		report.add(DoctorCheck{Name: "buckets", Status: DoctorFail, Message: fmt.Sprintf("%d bucket(s) missing", len(missing)), Details: missing,
+			Hint: "run 'wedevctl db migrate', which creates missing buckets"})
+		return false
+	}
+	report.add(DoctorCheck{Name: "buckets", Status: DoctorPass, Message: fmt.Sprintf("all %d buckets present", len(doctorBuckets))})
+	return true
+}
+
+// checkDoctorIntegrity reports the problems CheckIntegrity finds.
+func checkDoctorIntegrity(sm *StorageManager, report *DoctorReport) {
+	integrity, err := sm.CheckIntegrity()
+	if err != nil {
+		report.add(DoctorCheck{Name: "integrity", Status: DoctorFail, Message: err.Error()})
+		return
+	}
+	if integrity.Problems() == 0 {
+		report.add(DoctorCheck{Name: "integrity", Status: DoctorPass, Message: "no orphaned or dangling records"})
+		return
+	}
+	var details []string
+	for _, rec := range integrity.OrphanedIndexKeys {
+		details = append(details, fmt.Sprintf("orphaned index key %s in %s", rec.Key, rec.Bucket))
+	}
+	for _, rec := range integrity.DanglingReferences {
+		details = append(details, fmt.Sprintf("%s in %s references a missing record", rec.Key, rec.Bucket))
+	}
+	for _, dup := range integrity.DuplicateVirtualIPs {
+		details = append(details, fmt.Sprintf("%s in network %s held by %s", dup.IP, dup.Network, strings.Join(dup.Holders, ", ")))
+	}
+	for _, rec := range integrity.OrphanedConfigs {
+		details = append(details, fmt.Sprintf("config version %s of missing network %s", rec.Key, rec.NetworkID))
+	}
+	for _, inv := range integrity.InvalidCIDRs {
+		details = append(details, fmt.Sprintf("network %s has invalid CIDR '%s'", inv.Network, inv.CIDR))
+	}
+	hint := "run 'wedevctl db fsck --fix' to repair them"
+	if integrity.Repairable() == 0 {
+		hint = "set a valid CIDR with 'wedevctl vn <network> edit --cidr'"
+	}
+	report.add(DoctorCheck{Name: "integrity", Status: DoctorFail, Message: fmt.Sprintf("%d problem(s)", integrity.Problems()), Details: details,
+		Hint: hint})
+}
+
+// checkNetwork runs the per-network checks.
+func checkNetwork(ctx context.Context, vnm *VirtualNetworkManager, network *VirtualNetwork, opts DoctorOptions, report *DoctorReport) error {
+	// A network whose CIDR is invalid has no pool to audit.
+	if checkNetworkCIDR(network) != nil {
+		report.add(DoctorCheck{Name: "ip_pool", Network: network.Name, Status: DoctorFail, Message: fmt.Sprintf("no IP pool for invalid CIDR '%s'", network.CIDR),
+			Hint: fmt.Sprintf("set a valid CIDR with 'wedevctl vn %s edit --cidr'", network.Name)})
+	} else {
+		audit, err := vnm.auditIPPool(network)
+		if err != nil {
+			return err
+		}
+		report.add(ipPoolCheck(network, audit))
+	}
+
+	servers, err := vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	report.add(keysCheck(network, servers, nodes))
+	report.add(validationCheck(network, validateNetwork(network, servers, nodes)))
+	if opts.Resolve {
+		report.add(endpointsCheck(ctx, network, servers, nodes, opts.Resolver))
+	}
+	return nil
+}
+
+// ipPoolCheck turns an IP pool audit into a check. Missing state and stale
+// allocations only waste addresses; the other issues can hand out an address
+// in use.
+func ipPoolCheck(network *VirtualNetwork, audit *IPAuditReport) DoctorCheck {
+	check := DoctorCheck{Name: "ip_pool", Network: network.Name, Status: DoctorPass, Message: "IP pool state matches the records"}
+	if len(audit.Issues) == 0 {
+		return check
+	}
+	check.Status = DoctorWarn
+	for _, issue := range audit.Issues {
+		check.Details = append(check.Details, fmt.Sprintf("%s: %s", issue.Kind, issue.Message))
+		if issue.Kind != IPIssueMissingState && issue.Kind != IPIssueStaleAllocation {
+			check.Status = DoctorFail
+		}
+	}
+	check.Message = fmt.Sprintf("%d IP pool issue(s)", len(audit.Issues))
+	check.Hint = fmt.Sprintf("run 'wedevctl vn %s ip repair'", network.Name)
+	if len(audit.Duplicates()) > 0 {
+		check.Hint = fmt.Sprintf("run 'wedevctl db fsck --fix' to reassign duplicate IPs, then 'wedevctl vn %s ip repair'", network.Name)
+	}
+	return check
+}
+
+// keysCheck reports keys that are not WireGuard keys, and private keys
+// whose public key is not the one stored.
+func keysCheck(network *VirtualNetwork, servers []*Server, nodes []*Node) DoctorCheck {
+	var details []string
+	encrypted := 0
+	check := func(entity, privateKey, publicKey string) {
+		if err := util.ValidateWireGuardKey(publicKey); err != nil {
+			details = append(details, fmt.Sprintf("%s: public key: %v", entity, err))
+			return
+		}
+		if privateKey == "" {
+			return // imported public-only keys
+		}
+		if IsEncryptedKey(privateKey) {
+			encrypted++
+			return
+		}
+		derived, err := util.WireGuardPublicKey(privateKey)
+		switch {
+		case err != nil:
+			details = append(details, fmt.Sprintf("%s: %v", entity, err))
+		case derived != publicKey:
+			details = append(details, fmt.Sprintf("%s: private key does not match the public key", entity))
+		}
+	}
+	for _, server := range servers {
+		check("server "+server.Name, server.PrivateKey, server.PublicKey)
+	}
+	for _, node := range nodes {
+		check("node "+node.Name, node.PrivateKey, node.PublicKey)
+	}
+
+	if len(details) > 0 {
+		return DoctorCheck{Name: "keys", Network: network.Name, Status: DoctorFail, Message: fmt.Sprintf("%d invalid key(s)", len(details)), Details: details,
+			Hint: "delete and re-add the affected servers and nodes, importing their keys with --private-key or --key-file"}
+	}
+	message := fmt.Sprintf("%d key pair(s) valid", len(servers)+len(nodes))
+	if encrypted > 0 {
+		message = fmt.Sprintf("%d public key(s) valid; %d encrypted private key(s) not checked", len(servers)+len(nodes), encrypted)
+	}
+	return DoctorCheck{Name: "keys", Network: network.Name, Status: DoctorPass, Message: message}
+}
+
+// validationCheck turns a ValidateNetwork report into a check.
+func validationCheck(network *VirtualNetwork, validation *ValidationReport) DoctorCheck {
+	check := DoctorCheck{Name: "validate", Network: network.Name, Status: DoctorPass, Message: "records are consistent"}
+	if len(validation.Findings) == 0 {
+		return check
+	}
+	for _, finding := range validation.Findings {
+		check.Details = append(check.Details, fmt.Sprintf("%s: %s", finding.Severity, finding.Message))
+	}
+	check.Status = DoctorWarn
+	if validation.Errors() > 0 {
+		check.Status = DoctorFail
+	}
+	check.Message = fmt.Sprintf("%d error(s), %d warning(s)", validation.Errors(), validation.Warnings())
+	check.Hint = fmt.Sprintf("run 'wedevctl vn %s validate' for details", network.Name)
+	return check
+}
+
+// endpointsCheck looks up each hostname used as a server or node endpoint.
+// A hostname that does not resolve is a warning: it may resolve only where
+// the peers run.
+func endpointsCheck(ctx context.Context, network *VirtualNetwork, servers []*Server, nodes []*Node, resolver func(context.Context, string) ([]string, error)) DoctorCheck {
+	if resolver == nil {
+		resolver = net.DefaultResolver.LookupHost
+	}
+
+	users := make(map[string][]string) // hostname -> entities using it
+	addHost := func(entity, address string) {
+		if _, err := netip.ParseAddr(address); address == "" || err == nil {
+			return
+		}
+		users[address] = append(users[address], entity)
+	}
+	for _, server := range servers {
+		addHost("server "+server.Name, server.PublicAddress)
+		addHost("server "+server.Name, server.InternalAddress)
+	}
+	for _, node := range nodes {
+		addHost("node "+node.Name, node.PublicAddress)
+		addHost("node "+node.Name, node.InternalAddress)
+	}
+	hosts := make([]string, 0, len(users))
+	for host := range users {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var details []string
+	for _, host := range hosts {
+		lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		_, err := resolver(lookupCtx, host)
+		cancel()
+		if err != nil {
+			details = append(details, fmt.Sprintf("%s (%s): %v", host, strings.Join(users[host], ", "), err))
+		}
+	}
+
+	if len(details) > 0 {
+		return DoctorCheck{Name: "endpoints", Network: network.Name, Status: DoctorWarn, Message: fmt.Sprintf("%d of %d hostname(s) do not resolve", len(details), len(hosts)), Details: details,
+			Hint: "check the DNS records, or change the addresses with 'server edit' or 'node edit'"}
+	}
+	return DoctorCheck{Name: "endpoints", Network: network.Name, Status: DoctorPass, Message: fmt.Sprintf("%d hostname(s) resolve", len(hosts))}
+}
+
+// DatabaseExport is a dump of every bucket of a database, keyed by bucket
+// and record key, with private keys, preshared keys and the secrets in
+// stored configs redacted.
+type DatabaseExport struct {
+	SchemaVersion int                       `json:"schema_version"`
+	Buckets       map[string]map[string]any `json:"buckets"`
+}
+
+// secretFields are the JSON fields of stored records that hold secrets.
+var secretFields = map[string]bool{"private_key": true, "preshared_key": true}
+
+// ExportRedacted dumps the database with its secrets redacted, so it can be
+// attached to a bug report. JSON records are exported as JSON, anything else
+// as a string.
+func (sm *StorageManager) ExportRedacted() (*DatabaseExport, error) {
+	version, err := sm.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	export := &DatabaseExport{SchemaVersion: version, Buckets: make(map[string]map[string]any)}
+	err = sm.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			records := make(map[string]any)
+			export.Buckets[string(name)] = records
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					records[string(k)] = "(bucket)"
+					return nil
+				}
+				var value any
+				if string(name) == BucketRevisions && len(v) == 8 {
+					value = binary.BigEndian.Uint64(v)
+				} else if json.Unmarshal(v, &value) != nil {
+					value = string(v)
+				}
+				records[string(k)] = redactValue(value)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// redactValue redacts the secrets in a decoded JSON value: non-empty
+// secret fields, and the keys in config text.
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if s, ok := field.(string); ok && secretFields[key] && s != "" {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redactValue(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	case string:
+		return RedactConfig(v)
+	}
+	return value
+}
+
+// WriteDoctorBundle writes report, and the redacted database export when
+// the report was made with DoctorOptions.Bundle, to path as a tar.gz holding
+// report.json and database.json. Like WriteConfigArchive it never leaves a
+// partial file at path.
+func WriteDoctorBundle(path string, report *DoctorReport) error {
+	if format, err := ArchiveFormatFor(path); err != nil || format != ArchiveTarGz {
+		return kindErrorf(ErrValidation, "bundle %q must end in .tar.gz or .tgz", path)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	entries := []archiveEntry{{name: "report.json", content: string(data) + "\n"}}
+	if report.export != nil {
+		data, err := json.MarshalIndent(report.export, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode database export: %w", err)
+		}
+		entries = append(entries, archiveEntry{name: "database.json", content: string(data) + "\n"})
+	}
+
+	return writeArchiveFile(path, func(w io.Writer) error {
+		return writeTarGz(w, entries, false, report.CreatedAt)
+	})
+}
@@ -0,0 +1,150 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// findCheck returns the check of report with the given name and network.
+func findCheck(t *testing.T, report *DoctorReport, name, network string) DoctorCheck {
+	t.Helper()
+	for _, check := range report.Checks {
+		if check.Name == name && check.Network == network {
+			return check
+		}
+	}
+	t.Fatalf("report has no %s check for network %q: %+v", name, network, report.Checks)
+	return DoctorCheck{}
+}
+
+func TestDoctor(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	for _, name := range []string{"good", "bad"} {
+		if _, err := vnm.CreateVirtualNetwork(name, "10.0.0.0/24"); err != nil {
+			t.Fatalf("CreateVirtualNetwork(%s) error = %v", name, err)
+		}
+		if _, err := vnm.CreateServer(name, "srv", "vpn."+name+".example.com", 0); err != nil {
+			t.Fatalf("CreateServer(%s) error = %v", name, err)
+		}
+		if _, err := vnm.CreateNode(name, "a", "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, _, err := NewWireGuardConfigGenerator(sm).SaveConfigVersion("good"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	// Give node a of network bad the public key of another key pair.
+	node, err := vnm.GetNode("bad", "a")
+	if err != nil {
+		t.Fatalf("GetNode() error = %v", err)
+	}
+	other, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+	if err := sm.UpdateNodeKeys(node.ID, node.PrivateKey, other.PublicKey); err != nil {
+		t.Fatalf("UpdateNodeKeys() error = %v", err)
+	}
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	resolver := func(_ context.Context, host string) ([]string, error) {
+		if host == "vpn.bad.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+	report, err := Doctor(context.Background(), dbPath, DoctorOptions{Resolve: true, Resolver: resolver, Bundle: true})
+	if err != nil {
+		t.Fatalf("Doctor() error = %v", err)
+	}
+
+	for _, name := range []string{"database", "buckets", "integrity"} {
+		if check := findCheck(t, report, name, ""); check.Status != DoctorPass {
+			t.Errorf("%s check = %+v, want PASS", name, check)
+		}
+	}
+	for _, name := range []string{"ip_pool", "keys", "validate", "endpoints"} {
+		if check := findCheck(t, report, name, "good"); check.Status != DoctorPass {
+			t.Errorf("%s check of network good = %+v, want PASS", name, check)
+		}
+	}
+	keys := findCheck(t, report, "keys", "bad")
+	if keys.Status != DoctorFail || len(keys.Details) != 1 || !strings.Contains(keys.Details[0], "node a") || keys.Hint == "" {
+		t.Errorf("keys check of network bad = %+v, want a FAIL naming node a with a hint", keys)
+	}
+	if check := findCheck(t, report, "endpoints", "bad"); check.Status != DoctorWarn {
+		t.Errorf("endpoints check of network bad = %+v, want WARN", check)
+	}
+	if got := report.Count(DoctorFail); got != 1 {
+		t.Errorf("Count(FAIL) = %d, want 1", got)
+	}
+
+	// --network limits the per-network checks; an unknown one is an error.
+	scoped, err := Doctor(context.Background(), dbPath, DoctorOptions{Network: "good"})
+	if err != nil {
+		t.Fatalf("Doctor(good) error = %v", err)
+	}
+	if scoped.Count(DoctorFail) != 0 || len(scoped.Checks) != 6 {
+		t.Errorf("Doctor(good) checks = %+v, want 6 passing", scoped.Checks)
+	}
+	if _, err := Doctor(context.Background(), dbPath, DoctorOptions{Network: "ghost"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Doctor(ghost) error = %v, want ErrNotFound", err)
+	}
+
+	// The bundle holds the report and an export without private keys.
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := WriteDoctorBundle(bundle, report); err != nil {
+		t.Fatalf("WriteDoctorBundle() error = %v", err)
+	}
+	_, contents := readArchive(t, bundle)
+	var decoded DoctorReport
+	if err := json.Unmarshal([]byte(contents["report.json"]), &decoded); err != nil || len(decoded.Checks) != len(report.Checks) {
+		t.Errorf("report.json = %q, %v; want the report", contents["report.json"], err)
+	}
+	export := contents["database.json"]
+	if !strings.Contains(export, `"private_key": "`+Redacted+`"`) || !strings.Contains(export, "PrivateKey = "+Redacted) {
+		t.Errorf("database.json does not redact private keys:\n%s", export)
+	}
+	if strings.Contains(export, node.PrivateKey) {
+		t.Errorf("database.json holds the private key of node a")
+	}
+	if !strings.Contains(export, other.PublicKey) {
+		t.Errorf("database.json is missing the public key of node a")
+	}
+	if err := WriteDoctorBundle(filepath.Join(t.TempDir(), "bundle.zip"), report); !errors.Is(err, ErrValidation) {
+		t.Errorf("WriteDoctorBundle(.zip) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestDoctor_DatabaseDoesNotOpen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "missing.db")
+	report, err := Doctor(context.Background(), dbPath, DoctorOptions{})
+	if err != nil {
+		t.Fatalf("Doctor() error = %v", err)
+	}
+	if len(report.Checks) != 1 {
+		t.Fatalf("Doctor() checks = %+v, want the database check only", report.Checks)
+	}
+	if check := report.Checks[0]; check.Status != DoctorFail || !strings.Contains(check.Hint, "--db") {
+		t.Errorf("database check = %+v, want a FAIL hinting at --db", check)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dbPath), "*")); len(matches) != 0 {
+		t.Errorf("Doctor() created %v", matches)
+	}
+}
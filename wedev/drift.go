@@ -0,0 +1,68 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+)
+
+// ConfigDrift tells whether a network's configs, generated from its current
+// records, still match its latest saved config version.
+type ConfigDrift struct {
+	LatestVersion int  // latest saved version; 0 when none is saved
+	Drifted       bool // the configs generated now differ from LatestVersion
+}
+
+// CheckDriftCtx compares the content hash of a network's configs, generated
+// now, with that of its latest saved version. A network without a saved
+// version has nothing to drift from, so nothing is generated for it. The
+// generation logs nothing: the check runs after commands that already said
+// what they changed.
+func (wcg *WireGuardConfigGenerator) CheckDriftCtx(ctx context.Context, networkName string) (*ConfigDrift, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := wcg.storage.GetLatestConfigVersionCtx(ctx, network.ID)
+	if errors.Is(err, ErrNotFound) {
+		return &ConfigDrift{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, hash, err := wcg.quiet().GenerateConfigsCtx(ctx, networkName, wcg.storage)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigDrift{LatestVersion: latest.Version, Drifted: hash != latest.ContentHash}, nil
+}
+
+// ConfigSnapshotCtx generates a network's configs without logging, to be
+// compared with ChangedConfigs once a change is made.
+func (wcg *WireGuardConfigGenerator) ConfigSnapshotCtx(ctx context.Context, networkName string) (map[string]string, error) {
+	configs, _, err := wcg.quiet().GenerateConfigsCtx(ctx, networkName, wcg.storage)
+	return configs, err
+}
+
+// ChangedConfigs returns the sorted names of the configs that differ between
+// two generations of a network's configs, including those only one has.
+func ChangedConfigs(before, after map[string]string) []string {
+	changed := changedConfigs(before, after)
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// quiet returns a copy of the generator that logs nothing, for generations
+// made only to compare their results.
+func (wcg *WireGuardConfigGenerator) quiet() *WireGuardConfigGenerator {
+	quiet := *wcg
+	quiet.logger = slog.New(slog.DiscardHandler)
+	return &quiet
+}
@@ -0,0 +1,410 @@
+package wedev
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wedevctl/util"
+)
+
+// AnyRevision passed to ReplaceNode or ReplaceServer writes the record
+// whatever its stored revision.
+const AnyRevision = -1
+
+// NodeEdit is a set of changes to a node, applied by EditNode in one write.
+// Nil fields are left as they are.
+type NodeEdit struct {
+	// Revision is the revision of the node the edit was based on, as read
+	// with GetNode. The edit fails with ErrConflict when the node changed
+	// since, unless IgnoreConflict is set.
+	Revision       int
+	IgnoreConflict bool
+	// Force allows a type change that drops the node from the configs of
+	// the peer nodes dialing it; see checkTypeTransition.
+	Force bool
+	// AllowDuplicateEndpoint lets the node take the public address and
+	// port of the server or another node; see checkNodeEndpoint.
+	AllowDuplicateEndpoint bool
+
+	Type               *NodeType
+	PublicAddress      *string
+	Port               *int
+	RoutedCIDRs        *[]string // an empty list clears them
+	SetLabels          map[string]string
+	RemoveLabels       []string
+	ServerName         *string // empty: the network's first server
+	MeshServers        *bool
+	FullTunnel         *bool
+	InternalAddress    *string // empty removes the internal endpoint
+	InternalPort       *int    // 0 reuses the public port
+	PreferInternal     *bool
+	EndpointPreference *EndpointPreference
+	ExpiryChanged      bool
+	ExpiresAt          *time.Time // with ExpiryChanged; nil removes the expiry
+}
+
+// EditNode applies edit to a node in a single write. The node's final state
+// is validated as a whole, so changes that depend on each other, such as
+// clearing routed CIDRs while changing a route node's type, can be made
+// together.
+func (vnm *VirtualNetworkManager) EditNode(networkName, nodeName string, edit NodeEdit) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := edit.Revision
+	if edit.IgnoreConflict {
+		revision = AnyRevision
+	} else if node.Revision != revision {
+		return nil, kindErrorf(ErrConflict, "node %q was modified by another process, re-run your edit", nodeName)
+	}
+
+	oldType, oldAddress, oldPort := node.Type, node.PublicAddress, node.Port
+	if edit.Type != nil {
+		node.Type = *edit.Type
+	}
+	if edit.PublicAddress != nil {
+		node.PublicAddress = *edit.PublicAddress
+	}
+	if edit.Port != nil {
+		node.Port = *edit.Port
+	}
+	if err := validateNodeTypeAddress(node.Type, node.PublicAddress); err != nil {
+		return nil, err
+	}
+	if node.PublicAddress != "" {
+		if valErr := vnm.validateNetworkPublicAddress(network, node.PublicAddress); valErr != nil {
+			return nil, valErr
+		}
+	}
+	if valErr := util.ValidatePort(node.Port); valErr != nil {
+		return nil, withKind(ErrValidation, valErr)
+	}
+	if edit.Port != nil {
+		vnm.warnPrivilegedPort("node", node.Name, node.Port)
+	}
+	if node.PublicAddress != oldAddress || node.Port != oldPort {
+		if err := vnm.checkNodeEndpoint(network, node.Name, node.PublicAddress, node.Port, edit.AllowDuplicateEndpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if edit.RoutedCIDRs != nil {
+		if len(*edit.RoutedCIDRs) > 0 && node.Type != NodeTypeRoute {
+			return nil, kindErrorf(ErrValidation, "routed CIDRs are only supported for route nodes")
+		}
+		if node.RoutedCIDRs, err = vnm.validateRoutedCIDRs(network, node.ID, *edit.RoutedCIDRs); err != nil {
+			return nil, err
+		}
+	}
+	if err := vnm.checkTypeTransition(network, node, oldType, edit.Force); err != nil {
+		return nil, err
+	}
+
+	if len(edit.SetLabels) > 0 || len(edit.RemoveLabels) > 0 {
+		if node.Labels, err = mergeLabels(node.Labels, edit.SetLabels, edit.RemoveLabels); err != nil {
+			return nil, err
+		}
+	}
+
+	if edit.ServerName != nil {
+		node.ServerID = ""
+		if *edit.ServerName != "" {
+			server, err := vnm.storage.GetServerByName(network.ID, *edit.ServerName)
+			if err != nil {
+				return nil, err
+			}
+			node.ServerID = server.ID
+		}
+	}
+	if edit.MeshServers != nil {
+		node.MeshServers = *edit.MeshServers
+	}
+	if edit.FullTunnel != nil {
+		node.FullTunnel = *edit.FullTunnel
+	}
+
+	if edit.InternalAddress != nil || edit.InternalPort != nil {
+		if edit.InternalAddress != nil {
+			node.InternalAddress = *edit.InternalAddress
+		}
+		if edit.InternalPort != nil {
+			node.InternalPort = *edit.InternalPort
+		}
+		if err := vnm.validateInternalEndpoint(node.InternalAddress, node.InternalPort); err != nil {
+			return nil, err
+		}
+		if node.InternalAddress == "" {
+			node.InternalPort = 0
+		}
+	}
+	if edit.PreferInternal != nil {
+		node.PreferInternal = *edit.PreferInternal
+	}
+	if edit.EndpointPreference != nil {
+		node.EndpointPreference = *edit.EndpointPreference
+	}
+	if edit.ExpiryChanged {
+		node.ExpiresAt = edit.ExpiresAt
+	}
+
+	if err := vnm.storage.ReplaceNode(node, revision); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// checkTypeTransition refuses to leave node, whose type was oldType, in a
+// state its configs cannot express. Routed subnets only make sense behind a
+// route node. A peer node is dialed directly by the other peer nodes; as a
+// client, or a route node without a public address, it drops out of their
+// configs, which is refused while there are any, unless force is set.
+func (vnm *VirtualNetworkManager) checkTypeTransition(network *VirtualNetwork, node *Node, oldType NodeType, force bool) error {
+	if node.Type != NodeTypeRoute && len(node.RoutedCIDRs) > 0 {
+		return kindErrorf(ErrValidation, "node %q routes %s; clear its routed CIDRs before changing its type (or pass --clear-routes)", node.Name, strings.Join(node.RoutedCIDRs, ", "))
+	}
+	if force || oldType != NodeTypePeer || node.Type == NodeTypePeer || (node.Type == NodeTypeRoute && node.PublicAddress != "") {
+		return nil
+	}
+
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return err
+	}
+	var dialing []string
+	for _, other := range nodes {
+		if other.ID != node.ID && other.Type == NodeTypePeer {
+			dialing = append(dialing, other.Name)
+		}
+	}
+	if len(dialing) == 0 {
+		return nil
+	}
+	slices.Sort(dialing)
+	return kindErrorf(ErrValidation, "peer nodes %s dial node %q directly; as a %s without a public address it drops out of their configs (pass --force to change it anyway)", strings.Join(dialing, ", "), node.Name, node.Type)
+}
+
+// ServerEdit is a set of changes to a server's endpoints, applied by
+// EditServer in one write. Nil fields are left as they are.
+type ServerEdit struct {
+	// Revision and IgnoreConflict work as in NodeEdit.
+	Revision       int
+	IgnoreConflict bool
+
+	PublicAddress       *string
+	Port                *int
+	InternalAddress     *string   // empty removes the internal endpoint
+	InternalPort        *int      // 0 reuses the public port
+	AdditionalAddresses *[]string // an empty list clears them
+}
+
+// EditServer applies edit to a server in a single write. An empty server
+// name selects the network's only server.
+func (vnm *VirtualNetworkManager) EditServer(networkName, serverName string, edit ServerEdit) (*Server, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := vnm.resolveServer(network, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := edit.Revision
+	if edit.IgnoreConflict {
+		revision = AnyRevision
+	} else if server.Revision != revision {
+		return nil, kindErrorf(ErrConflict, "server %q was modified by another process, re-run your edit", server.Name)
+	}
+
+	if edit.PublicAddress != nil || edit.Port != nil {
+		if edit.PublicAddress != nil {
+			server.PublicAddress = *edit.PublicAddress
+		}
+		if edit.Port != nil {
+			server.Port = *edit.Port
+		}
+		if valErr := vnm.validateNetworkPublicAddress(network, server.PublicAddress); valErr != nil {
+			return nil, valErr
+		}
+		if valErr := util.ValidatePort(server.Port); valErr != nil {
+			return nil, withKind(ErrValidation, valErr)
+		}
+		if edit.Port != nil {
+			vnm.warnPrivilegedPort("server", server.Name, server.Port)
+		}
+	}
+
+	if edit.InternalAddress != nil || edit.InternalPort != nil {
+		if edit.InternalAddress != nil {
+			server.InternalAddress = *edit.InternalAddress
+		}
+		if edit.InternalPort != nil {
+			server.InternalPort = *edit.InternalPort
+		}
+		if err := vnm.validateInternalEndpoint(server.InternalAddress, server.InternalPort); err != nil {
+			return nil, err
+		}
+		if server.InternalAddress == "" {
+			server.InternalPort = 0
+		}
+	}
+
+	if edit.AdditionalAddresses != nil {
+		server.AdditionalAddresses = slices.Clone(*edit.AdditionalAddresses)
+		if len(server.AdditionalAddresses) == 0 {
+			server.AdditionalAddresses = nil
+		}
+	}
+	if err := vnm.validateAdditionalAddresses(network, server); err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.ReplaceServer(server, revision); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetServerByName(network.ID, server.Name)
+}
+
+// copyNodeEdit copies the fields of src that EditNode changes onto dst.
+func copyNodeEdit(dst, src *Node) {
+	dst.Type = src.Type
+	dst.PublicAddress = src.PublicAddress
+	dst.Port = src.Port
+	dst.RoutedCIDRs = slices.Clone(src.RoutedCIDRs)
+	dst.Labels = maps.Clone(src.Labels)
+	dst.ServerID = src.ServerID
+	dst.MeshServers = src.MeshServers
+	dst.FullTunnel = src.FullTunnel
+	dst.InternalAddress = src.InternalAddress
+	dst.InternalPort = src.InternalPort
+	dst.PreferInternal = src.PreferInternal
+	dst.EndpointPreference = src.EndpointPreference
+	dst.ExpiresAt = nil
+	if src.ExpiresAt != nil {
+		expiresAt := *src.ExpiresAt
+		dst.ExpiresAt = &expiresAt
+	}
+}
+
+// copyServerEdit copies the fields of src that EditServer changes onto dst.
+func copyServerEdit(dst, src *Server) {
+	dst.PublicAddress = src.PublicAddress
+	dst.Port = src.Port
+	dst.InternalAddress = src.InternalAddress
+	dst.InternalPort = src.InternalPort
+	dst.AdditionalAddresses = slices.Clone(src.AdditionalAddresses)
+}
+
+// NetworkEdit is a set of changes to a network's labels and settings,
+// applied by EditNetwork in one write. Nil fields are left as they are.
+type NetworkEdit struct {
+	SetLabels        map[string]string
+	RemoveLabels     []string
+	DefaultPort      *int
+	FilenameTemplate *string // empty restores the default
+	Topology         *Topology
+	NATMode          *NATMode
+	DNS              *[]string // an empty list removes them
+	MaxNodes         *int      // 0 removes the limit
+	PoolWarnPercent  *int      // 0 restores DefaultPoolWarnPercent
+}
+
+// EditNetwork validates every change in edit before applying them to a
+// network in a single write, so one rejected change saves none of the others.
+// Configs already generated are unchanged; the next 'config generate'
+// produces a new version.
+func (vnm *VirtualNetworkManager) EditNetwork(name string, edit NetworkEdit) (*VirtualNetwork, error) {
+	network, err := vnm.storage.GetNetworkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if edit.DefaultPort != nil {
+		if valErr := util.ValidatePort(*edit.DefaultPort); valErr != nil {
+			return nil, withKind(ErrValidation, valErr)
+		}
+	}
+	if edit.FilenameTemplate != nil {
+		if _, err := ParseFilenameTemplate(*edit.FilenameTemplate); err != nil {
+			return nil, withKind(ErrValidation, err)
+		}
+	}
+	if edit.Topology != nil {
+		if _, err := ParseTopology(string(*edit.Topology)); err != nil {
+			return nil, err
+		}
+	}
+	if edit.NATMode != nil {
+		if _, err := ParseNATMode(string(*edit.NATMode)); err != nil {
+			return nil, err
+		}
+	}
+	var dns []string
+	if edit.DNS != nil {
+		if dns, err = normalizeDNS(*edit.DNS); err != nil {
+			return nil, err
+		}
+	}
+	if edit.MaxNodes != nil {
+		if err := vnm.checkMaxNodes(network, *edit.MaxNodes); err != nil {
+			return nil, err
+		}
+	}
+	if edit.PoolWarnPercent != nil {
+		if percent := *edit.PoolWarnPercent; percent < 0 || percent > 100 {
+			return nil, kindErrorf(ErrValidation, "pool warning threshold must be between 0 and 100 percent, got %d", percent)
+		}
+	}
+	editLabels := len(edit.SetLabels) > 0 || len(edit.RemoveLabels) > 0
+	if editLabels {
+		if _, err := mergeLabels(network.Labels, edit.SetLabels, edit.RemoveLabels); err != nil {
+			return nil, err
+		}
+	}
+
+	return vnm.storage.UpdateNetwork(network.ID, func(n *VirtualNetwork) error {
+		if edit.DefaultPort != nil {
+			n.DefaultPort = *edit.DefaultPort
+		}
+		if edit.FilenameTemplate != nil {
+			n.putSetting(SettingFilenameTemplate, *edit.FilenameTemplate)
+		}
+		if edit.Topology != nil {
+			n.putSetting(SettingTopology, string(*edit.Topology))
+		}
+		if edit.NATMode != nil {
+			n.NATMode = *edit.NATMode
+		}
+		if edit.DNS != nil {
+			n.putSetting(SettingDNS, strings.Join(dns, ","))
+		}
+		if edit.MaxNodes != nil {
+			n.MaxNodes = *edit.MaxNodes
+		}
+		if edit.PoolWarnPercent != nil {
+			n.PoolWarnPercent = *edit.PoolWarnPercent
+		}
+		if editLabels {
+			// Merged again onto the stored labels so a label another
+			// process set since the read above is kept.
+			labels, err := mergeLabels(n.Labels, edit.SetLabels, edit.RemoveLabels)
+			if err != nil {
+				return err
+			}
+			n.Labels = labels
+		}
+		return nil
+	})
+}
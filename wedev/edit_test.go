@@ -0,0 +1,345 @@
+package wedev
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// TestReplaceConflict interleaves a direct update between the read an edit
+// is based on and its write, on both backends.
+func TestReplaceConflict(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"bbolt": func(t *testing.T) Storage {
+			_, sm := newTestManager(t)
+			return sm
+		},
+		"memory": func(*testing.T) Storage { return NewMemoryStorage() },
+	}
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			vnm, err := NewVirtualNetworkManager(storage, util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+			if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			server, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820)
+			if err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			node, err := vnm.CreateNode("office", "n1", "1.2.3.4", 51820, NodeTypePeer)
+			if err != nil {
+				t.Fatalf("CreateNode() error = %v", err)
+			}
+
+			// Another process changes the node after it was read.
+			read := node.Revision
+			if err := storage.UpdateNodeFullTunnel(node.ID, true); err != nil {
+				t.Fatalf("UpdateNodeFullTunnel() error = %v", err)
+			}
+			node.Port = 51821
+			if err := storage.ReplaceNode(node, read); !errors.Is(err, ErrConflict) {
+				t.Fatalf("ReplaceNode() after an interleaved update error = %v, want ErrConflict", err)
+			}
+			stored, err := storage.GetNodeByName(node.NetworkID, "n1")
+			if err != nil {
+				t.Fatalf("GetNodeByName() error = %v", err)
+			}
+			if stored.Port != 51820 || !stored.FullTunnel || stored.Revision != read+1 {
+				t.Errorf("node after a refused write = port %d, full tunnel %v, revision %d; want the interleaved update only",
+					stored.Port, stored.FullTunnel, stored.Revision)
+			}
+
+			// The write goes through against the current revision, or any.
+			if err := storage.ReplaceNode(node, stored.Revision); err != nil {
+				t.Fatalf("ReplaceNode() at the current revision error = %v", err)
+			}
+			node.Port = 51822
+			if err := storage.ReplaceNode(node, AnyRevision); err != nil {
+				t.Fatalf("ReplaceNode(AnyRevision) error = %v", err)
+			}
+			stored, err = storage.GetNodeByName(node.NetworkID, "n1")
+			if err != nil {
+				t.Fatalf("GetNodeByName() error = %v", err)
+			}
+			if stored.Port != 51822 || stored.Revision != read+3 {
+				t.Errorf("node = port %d, revision %d; want 51822 at revision %d", stored.Port, stored.Revision, read+3)
+			}
+
+			read = server.Revision
+			if err := storage.UpdateServer(server.ID, "vpn2.example.com", 51820); err != nil {
+				t.Fatalf("UpdateServer() error = %v", err)
+			}
+			server.Port = 51900
+			if err := storage.ReplaceServer(server, read); !errors.Is(err, ErrConflict) {
+				t.Errorf("ReplaceServer() after an interleaved update error = %v, want ErrConflict", err)
+			}
+			if err := storage.ReplaceServer(server, AnyRevision); err != nil {
+				t.Errorf("ReplaceServer(AnyRevision) error = %v", err)
+			}
+		})
+	}
+}
+
+func TestEditNode(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("office", "gw", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	node, err := vnm.SetNodeRoutedCIDRs("office", "gw", []string{"192.168.50.0/24"})
+	if err != nil {
+		t.Fatalf("SetNodeRoutedCIDRs() error = %v", err)
+	}
+
+	// A type change that needs the routed CIDRs cleared works in one edit.
+	peer, address, none := NodeTypePeer, "5.6.7.8", []string{}
+	fullTunnel := true
+	edited, err := vnm.EditNode("office", "gw", NodeEdit{
+		Revision:      node.Revision,
+		Type:          &peer,
+		PublicAddress: &address,
+		RoutedCIDRs:   &none,
+		SetLabels:     map[string]string{"role": "db"},
+		FullTunnel:    &fullTunnel,
+	})
+	if err != nil {
+		t.Fatalf("EditNode() error = %v", err)
+	}
+	if edited.Type != NodeTypePeer || edited.PublicAddress != address || len(edited.RoutedCIDRs) != 0 ||
+		edited.Labels["role"] != "db" || !edited.FullTunnel || edited.Revision != node.Revision+1 {
+		t.Errorf("EditNode() = %+v, want a full-tunnel peer at %s labelled role=db", edited, address)
+	}
+
+	// The edit is refused when based on a stale read, and nothing is written.
+	port := 51999
+	if _, err := vnm.EditNode("office", "gw", NodeEdit{Revision: node.Revision, Port: &port}); !errors.Is(err, ErrConflict) {
+		t.Errorf("EditNode() of a stale read error = %v, want ErrConflict", err)
+	}
+	if _, err := vnm.EditNode("office", "gw", NodeEdit{Revision: node.Revision, IgnoreConflict: true, Port: &port}); err != nil {
+		t.Errorf("EditNode(IgnoreConflict) error = %v", err)
+	}
+
+	client := NodeTypeClient
+	if _, err := vnm.EditNode("office", "gw", NodeEdit{Revision: edited.Revision + 1, Type: &client}); !errors.Is(err, ErrValidation) {
+		t.Errorf("EditNode() to a client with an address error = %v, want ErrValidation", err)
+	}
+	cidrs := []string{"192.168.60.0/24"}
+	if _, err := vnm.EditNode("office", "gw", NodeEdit{Revision: edited.Revision + 1, RoutedCIDRs: &cidrs}); !errors.Is(err, ErrValidation) {
+		t.Errorf("EditNode() routing from a peer error = %v, want ErrValidation", err)
+	}
+	ghost := "ghost"
+	if _, err := vnm.EditNode("office", "gw", NodeEdit{Revision: edited.Revision + 1, ServerName: &ghost}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("EditNode() to a missing server error = %v, want ErrNotFound", err)
+	}
+
+	history, err := vnm.NodeHistory("office", "gw")
+	if err != nil {
+		t.Fatalf("NodeHistory() error = %v", err)
+	}
+	for _, rev := range history {
+		if slices.ContainsFunc(rev.Changes, func(c FieldChange) bool { return c.Field == "revision" }) {
+			t.Errorf("history lists the revision field: %+v", rev.Changes)
+		}
+	}
+}
+
+func TestEditServer(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+
+	port, internal := 51900, "10.10.0.5"
+	edited, err := vnm.EditServer("office", "", ServerEdit{Revision: server.Revision, Port: &port, InternalAddress: &internal})
+	if err != nil {
+		t.Fatalf("EditServer() error = %v", err)
+	}
+	if edited.PublicAddress != "vpn.example.com" || edited.Port != port || edited.InternalAddress != internal {
+		t.Errorf("EditServer() = %+v, want port %d and internal address %s", edited, port, internal)
+	}
+	if _, err := vnm.EditServer("office", "hub", ServerEdit{Revision: server.Revision, Port: &port}); !errors.Is(err, ErrConflict) {
+		t.Errorf("EditServer() of a stale read error = %v, want ErrConflict", err)
+	}
+	bad := 70000
+	if _, err := vnm.EditServer("office", "hub", ServerEdit{Revision: edited.Revision, Port: &bad}); !errors.Is(err, ErrValidation) {
+		t.Errorf("EditServer() with port %d error = %v, want ErrValidation", bad, err)
+	}
+}
+
+// TestNodeTypeTransitions enumerates type changes from each node type, with
+// and without routed subnets and peers dialing the node.
+func TestNodeTypeTransitions(t *testing.T) {
+	type state struct {
+		nodeType NodeType
+		address  string
+		routes   []string
+	}
+	route := state{NodeTypeRoute, "", nil}
+	router := state{NodeTypeRoute, "", []string{"192.168.50.0/24"}}
+	peer := state{NodeTypePeer, "203.0.113.1", nil}
+	client := state{NodeTypeClient, "", nil}
+	tests := []struct {
+		name        string
+		from        state
+		otherPeer   bool // another peer node dials peers directly
+		to          state
+		clearRoutes bool
+		force       bool
+		wantErr     bool
+	}{
+		{"route with routes to peer", router, true, peer, false, false, true},
+		{"route with routes to peer clearing them", router, true, peer, true, false, false},
+		{"route with routes to client", router, false, client, false, false, true},
+		{"route with routes to client clearing them", router, false, client, true, false, false},
+		{"route with routes to client, forced", router, false, client, false, true, true},
+		{"route to peer", route, true, peer, false, false, false},
+		{"route to client", route, true, client, false, false, false},
+		{"peer to route keeping its address", peer, true, state{NodeTypeRoute, "203.0.113.1", nil}, false, false, false},
+		{"peer to route without address", peer, true, route, false, false, true},
+		{"peer to route without address, forced", peer, true, route, false, true, false},
+		{"peer to route without address, no other peer", peer, false, route, false, false, false},
+		{"peer to client", peer, true, client, false, false, true},
+		{"peer to client, forced", peer, true, client, false, true, false},
+		{"peer to client, no other peer", peer, false, client, false, false, false},
+		{"client to peer", client, true, peer, false, false, false},
+		{"client to route", client, true, route, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vnm, _ := newTestManager(t)
+			if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			if tt.otherPeer {
+				if _, err := vnm.CreateNode("office", "other", "203.0.113.2", 51820, NodeTypePeer); err != nil {
+					t.Fatalf("CreateNode(other) error = %v", err)
+				}
+			}
+			// A route node only dials peers; it never counts as dialing n.
+			if _, err := vnm.CreateNode("office", "gateway", "", 51820, NodeTypeRoute); err != nil {
+				t.Fatalf("CreateNode(gateway) error = %v", err)
+			}
+			node, err := vnm.CreateNode("office", "n", tt.from.address, 51821, tt.from.nodeType)
+			if err != nil {
+				t.Fatalf("CreateNode(n) error = %v", err)
+			}
+			if len(tt.from.routes) > 0 {
+				if node, err = vnm.SetNodeRoutedCIDRs("office", "n", tt.from.routes); err != nil {
+					t.Fatalf("SetNodeRoutedCIDRs() error = %v", err)
+				}
+			}
+
+			edit := NodeEdit{Revision: node.Revision, Force: tt.force, Type: &tt.to.nodeType, PublicAddress: &tt.to.address}
+			if tt.clearRoutes {
+				edit.RoutedCIDRs = &[]string{}
+			}
+			updated, err := vnm.EditNode("office", "n", edit)
+			if tt.wantErr {
+				if !errors.Is(err, ErrValidation) {
+					t.Errorf("EditNode() error = %v, want ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EditNode() error = %v", err)
+			}
+			if updated.Type != tt.to.nodeType || len(updated.RoutedCIDRs) != 0 {
+				t.Errorf("EditNode() = type %s, routes %v; want %s without routes", updated.Type, updated.RoutedCIDRs, tt.to.nodeType)
+			}
+		})
+	}
+}
+
+// TestUpdateNodeTypeTransition checks that UpdateNode refuses what EditNode
+// refuses without Force.
+func TestUpdateNodeTypeTransition(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	for i, name := range []string{"a", "b"} {
+		if _, err := vnm.CreateNode("office", name, "203.0.113.1", 51820+i, NodeTypePeer); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	_, err := vnm.UpdateNode("office", "a", "", 51820, NodeTypeClient)
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "peer nodes b dial") {
+		t.Errorf("UpdateNode(peer to client) error = %v, want one naming b", err)
+	}
+}
+
+// TestEditNetwork checks that a rejected change in an edit keeps the valid
+// ones in it from being saved, and that an accepted edit writes them all.
+func TestEditNetwork(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	revision, err := sm.NetworkRevision(network.ID)
+	if err != nil {
+		t.Fatalf("NetworkRevision() error = %v", err)
+	}
+
+	port, mesh, percent := 51900, TopologyMesh, 150
+	_, err = vnm.EditNetwork("office", NetworkEdit{
+		SetLabels:       map[string]string{"team": "payments"},
+		DefaultPort:     &port,
+		Topology:        &mesh,
+		PoolWarnPercent: &percent,
+	})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("EditNetwork() with an invalid warning threshold error = %v, want ErrValidation", err)
+	}
+	stored, err := vnm.GetVirtualNetwork("office")
+	if err != nil {
+		t.Fatalf("GetVirtualNetwork() error = %v", err)
+	}
+	if stored.DefaultPort != 0 || len(stored.Settings) != 0 || len(stored.Labels) != 0 {
+		t.Errorf("network after a rejected edit = port %d, settings %v, labels %v; want it unchanged",
+			stored.DefaultPort, stored.Settings, stored.Labels)
+	}
+
+	percent = 80
+	dns := []string{" 10.0.0.1 "}
+	edited, err := vnm.EditNetwork("office", NetworkEdit{
+		SetLabels:       map[string]string{"team": "payments"},
+		DefaultPort:     &port,
+		Topology:        &mesh,
+		DNS:             &dns,
+		PoolWarnPercent: &percent,
+	})
+	if err != nil {
+		t.Fatalf("EditNetwork() error = %v", err)
+	}
+	if edited.DefaultPort != port || edited.EffectiveTopology() != mesh || edited.PoolWarnPercent != percent ||
+		edited.Labels["team"] != "payments" || !slices.Equal(edited.DNS(), []string{"10.0.0.1"}) {
+		t.Errorf("EditNetwork() = %+v, want every change applied", edited)
+	}
+	after, err := sm.NetworkRevision(network.ID)
+	if err != nil {
+		t.Fatalf("NetworkRevision() error = %v", err)
+	}
+	if after != revision+1 {
+		t.Errorf("network revision after one edit = %d, want %d (a single write)", after, revision+1)
+	}
+}
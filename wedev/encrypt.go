@@ -0,0 +1,438 @@
+package wedev
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// metaKeyEncryption is the BucketMeta key of the encryptionHeader of an
+	// encrypted database; it is missing when keys are stored in plain.
+	metaKeyEncryption = "encryption"
+	// sealedPrefix starts every value stored encrypted.
+	sealedPrefix = "wedev-enc:v1:"
+	// encryptionCheck is sealed into the header, so a passphrase is known
+	// to be wrong before any key is decrypted with it.
+	encryptionCheck = "wedevctl"
+)
+
+// kdfParams are the argon2id parameters new encryption headers are written
+// with: 3 passes over 64 MiB with 4 threads. The parameters are stored in
+// the header, so changing them does not affect existing databases.
+var kdfParams = struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+}{time: 3, memory: 64 * 1024, threads: 4}
+
+// encryptionHeader records how the key encrypting an encrypted database's
+// secrets is derived from its passphrase.
+type encryptionHeader struct {
+	KDF       string    `json:"kdf"` // always "argon2id"
+	Salt      []byte    `json:"salt"`
+	Time      uint32    `json:"time"`
+	Memory    uint32    `json:"memory"` // KiB
+	Threads   uint8     `json:"threads"`
+	Check     string    `json:"check"` // encryptionCheck, sealed
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// keyCipher seals and opens the secrets of an encrypted database with
+// AES-256-GCM. The nonce is an HMAC of the plaintext, so a secret always
+// seals to the same value: a key stored in its record and in every saved
+// config that uses it stays comparable, and re-saving unchanged configs
+// stores unchanged values.
+type keyCipher struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// newKeyCipher derives the cipher of header from passphrase.
+func newKeyCipher(header *encryptionHeader, passphrase string) (*keyCipher, error) {
+	if header.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", header.KDF)
+	}
+	key := argon2.IDKey([]byte(passphrase), header.Salt, header.Time, header.Memory, header.Threads, 64)
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &keyCipher{aead: aead, mac: key[32:]}, nil
+}
+
+// seal returns plaintext encrypted, as stored.
+func (c *keyCipher) seal(plaintext string) (string, error) {
+	mac := hmac.New(sha256.New, c.mac)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value seal returned. Values not sealed are returned as
+// they are. A value sealed with another key fails authentication.
+func (c *keyCipher) open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return "", kindErrorf(ErrKeysLocked, "malformed encrypted value")
+	}
+	nonce := c.aead.NonceSize()
+	plaintext, err := c.aead.Open(nil, data[:nonce], data[nonce:], nil)
+	if err != nil {
+		return "", kindErrorf(ErrKeysLocked, "failed to decrypt: wrong passphrase or damaged value")
+	}
+	return string(plaintext), nil
+}
+
+// IsEncryptedKey reports whether value is a private key, or another secret,
+// stored encrypted: the database is encrypted and has not been unlocked.
+func IsEncryptedKey(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// checkKeysDecrypted fails with ErrKeysLocked when a private key of servers
+// or nodes is still encrypted, so no config is ever generated with one.
+func checkKeysDecrypted(servers []*Server, nodes []*Node) error {
+	for _, server := range servers {
+		if IsEncryptedKey(server.PrivateKey) {
+			return errKeysLocked()
+		}
+	}
+	for _, node := range nodes {
+		if IsEncryptedKey(node.PrivateKey) {
+			return errKeysLocked()
+		}
+	}
+	return nil
+}
+
+// errKeysLocked is returned when a secret is needed from, or is to be
+// written to, an encrypted database that is not unlocked.
+func errKeysLocked() error {
+	return kindErrorf(ErrKeysLocked, "private keys are encrypted and the database is not unlocked; the passphrase is needed")
+}
+
+// readEncryptionHeader reads the encryption header of the database, nil
+// when it is not encrypted.
+func readEncryptionHeader(tx *bbolt.Tx) (*encryptionHeader, error) {
+	meta := tx.Bucket([]byte(BucketMeta))
+	if meta == nil {
+		return nil, nil
+	}
+	data := meta.Get([]byte(metaKeyEncryption))
+	if data == nil {
+		return nil, nil
+	}
+	header := &encryptionHeader{}
+	if err := json.Unmarshal(data, header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal encryption header: %w", err)
+	}
+	return header, nil
+}
+
+// Encrypted reports whether the database stores its private keys
+// encrypted (see EncryptKeys).
+func (sm *StorageManager) Encrypted() bool {
+	return sm.encryption != nil
+}
+
+// Unlocked reports whether private keys are read and written in plain: the
+// database is not encrypted, or Unlock succeeded.
+func (sm *StorageManager) Unlocked() bool {
+	return sm.encryption == nil || sm.keys != nil
+}
+
+// Unlock derives the key of an encrypted database from passphrase. Records
+// and config versions read afterwards carry their private keys decrypted,
+// and new keys are stored encrypted. A wrong passphrase is an ErrKeysLocked
+// error. Unlocking a database that is not encrypted, or again, does
+// nothing.
+func (sm *StorageManager) Unlock(passphrase string) error {
+	if sm.Unlocked() {
+		return nil
+	}
+	keys, err := newKeyCipher(sm.encryption, passphrase)
+	if err != nil {
+		return err
+	}
+	if check, err := keys.open(sm.encryption.Check); err != nil || check != encryptionCheck {
+		return kindErrorf(ErrKeysLocked, "wrong passphrase")
+	}
+	sm.keys = keys
+	sm.logger.Debug("unlocked private keys")
+	return nil
+}
+
+// EncryptKeys encrypts the private keys of every server and node, and the
+// PrivateKey and PresharedKey values of every saved config version, with a
+// key derived from passphrase by argon2id, in one transaction. It returns
+// how many private keys were encrypted; the database is left unlocked.
+func (sm *StorageManager) EncryptKeys(passphrase string) (int, error) {
+	if sm.encryption != nil {
+		return 0, kindErrorf(ErrValidation, "database is already encrypted")
+	}
+	if passphrase == "" {
+		return 0, kindErrorf(ErrValidation, "passphrase must not be empty")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	header := &encryptionHeader{
+		KDF:       "argon2id",
+		Salt:      salt,
+		Time:      kdfParams.time,
+		Memory:    kdfParams.memory,
+		Threads:   kdfParams.threads,
+		CreatedAt: time.Now(),
+	}
+	keys, err := newKeyCipher(header, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	if header.Check, err = keys.seal(encryptionCheck); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		if count, err = rewriteSecrets(tx, keys.seal); err != nil {
+			return err
+		}
+		data, err := json.Marshal(header)
+		if err != nil {
+			return fmt.Errorf("failed to marshal encryption header: %w", err)
+		}
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(metaKeyEncryption), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	sm.encryption, sm.keys = header, keys
+	return count, nil
+}
+
+// DecryptKeys stores every encrypted secret in plain again and removes the
+// encryption header, in one transaction. The database must be unlocked. It
+// returns how many private keys were decrypted.
+func (sm *StorageManager) DecryptKeys() (int, error) {
+	if sm.encryption == nil {
+		return 0, kindErrorf(ErrValidation, "database is not encrypted")
+	}
+	if sm.keys == nil {
+		return 0, errKeysLocked()
+	}
+
+	var count int
+	err := sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		if count, err = rewriteSecrets(tx, sm.keys.open); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketMeta)).Delete([]byte(metaKeyEncryption))
+	})
+	if err != nil {
+		return 0, err
+	}
+	sm.encryption, sm.keys = nil, nil
+	return count, nil
+}
+
+// rewriteSecrets replaces the private key of every server and node, and the
+// secret values of every saved config, by what convert returns for them,
+// and returns how many private keys it converted. Revisions and history are
+// left alone: the keys themselves do not change.
+func rewriteSecrets(tx *bbolt.Tx, convert func(string) (string, error)) (int, error) {
+	count := 0
+	rewrite := func(bucketName string, update func(data []byte) ([]byte, error)) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		updated := make(map[string][]byte)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			data, err := update(v)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				updated[string(k)] = data
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// bbolt does not allow writes while iterating.
+		for k, data := range updated {
+			if err := bucket.Put([]byte(k), data); err != nil {
+				return fmt.Errorf("failed to save %s record: %w", bucketName, err)
+			}
+		}
+		return nil
+	}
+
+	if err := rewrite(BucketServers, func(data []byte) ([]byte, error) {
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal server: %w", err)
+		}
+		if server.PrivateKey == "" {
+			return nil, nil
+		}
+		var err error
+		if server.PrivateKey, err = convert(server.PrivateKey); err != nil {
+			return nil, fmt.Errorf("server %q: %w", server.Name, err)
+		}
+		count++
+		return json.Marshal(server)
+	}); err != nil {
+		return 0, err
+	}
+	if err := rewrite(BucketNodes, func(data []byte) ([]byte, error) {
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal node: %w", err)
+		}
+		if node.PrivateKey == "" {
+			return nil, nil
+		}
+		var err error
+		if node.PrivateKey, err = convert(node.PrivateKey); err != nil {
+			return nil, fmt.Errorf("node %q: %w", node.Name, err)
+		}
+		count++
+		return json.Marshal(node)
+	}); err != nil {
+		return 0, err
+	}
+	if err := rewrite(BucketConfigs, func(data []byte) ([]byte, error) {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		if err := convertConfigs(config.Configs, convert); err != nil {
+			return nil, fmt.Errorf("config version %d: %w", config.Version, err)
+		}
+		return json.Marshal(config)
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// convertConfigs replaces, in place, each config of configs by the config
+// with convert applied to its PrivateKey and PresharedKey values.
+func convertConfigs(configs map[string]string, convert func(string) (string, error)) error {
+	for name, config := range configs {
+		lines := strings.Split(config, "\n")
+		for i, line := range lines {
+			key, value, found := strings.Cut(line, "=")
+			if !found || !secretConfigKeys[strings.TrimSpace(key)] {
+				continue
+			}
+			converted, err := convert(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("config %q: %w", name, err)
+			}
+			lines[i] = key + "= " + converted
+		}
+		configs[name] = strings.Join(lines, "\n")
+	}
+	return nil
+}
+
+// sealKey returns privateKey as it is stored: encrypted when the database
+// is. Storing a key in an encrypted database that is not unlocked fails.
+func (sm *StorageManager) sealKey(privateKey string) (string, error) {
+	if sm.encryption == nil || privateKey == "" {
+		return privateKey, nil
+	}
+	if sm.keys == nil {
+		return "", errKeysLocked()
+	}
+	return sm.keys.seal(privateKey)
+}
+
+// sealConfigs returns configs as they are stored: a copy with the secret
+// values encrypted when the database is encrypted.
+func (sm *StorageManager) sealConfigs(configs map[string]string) (map[string]string, error) {
+	if sm.encryption == nil {
+		return configs, nil
+	}
+	if sm.keys == nil {
+		return nil, errKeysLocked()
+	}
+	sealed := make(map[string]string, len(configs))
+	for name, config := range configs {
+		sealed[name] = config
+	}
+	if err := convertConfigs(sealed, sm.keys.seal); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// openKey decrypts a private key read from the database once it is
+// unlocked; otherwise the key is returned as stored.
+func (sm *StorageManager) openKey(value string) (string, error) {
+	if sm.keys == nil {
+		return value, nil
+	}
+	return sm.keys.open(value)
+}
+
+// openServers decrypts the private keys of servers read from the database
+// once it is unlocked.
+func (sm *StorageManager) openServers(servers ...*Server) error {
+	for _, server := range servers {
+		var err error
+		if server.PrivateKey, err = sm.openKey(server.PrivateKey); err != nil {
+			return fmt.Errorf("server %q: %w", server.Name, err)
+		}
+	}
+	return nil
+}
+
+// openNodes decrypts the private keys of nodes read from the database once
+// it is unlocked.
+func (sm *StorageManager) openNodes(nodes ...*Node) error {
+	for _, node := range nodes {
+		var err error
+		if node.PrivateKey, err = sm.openKey(node.PrivateKey); err != nil {
+			return fmt.Errorf("node %q: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// openConfigVersions decrypts the secret values of config versions read
+// from the database once it is unlocked.
+func (sm *StorageManager) openConfigVersions(versions ...*ConfigVersion) error {
+	if sm.keys == nil {
+		return nil
+	}
+	for _, version := range versions {
+		if err := convertConfigs(version.Configs, sm.keys.open); err != nil {
+			return fmt.Errorf("config version %d: %w", version.Version, err)
+		}
+	}
+	return nil
+}
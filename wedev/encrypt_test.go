@@ -0,0 +1,204 @@
+package wedev
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// cheapKDF lowers the argon2id cost of the encryption headers tests write.
+func cheapKDF(t *testing.T) {
+	t.Helper()
+	saved := kdfParams
+	kdfParams.time, kdfParams.memory, kdfParams.threads = 1, 1024, 1
+	t.Cleanup(func() { kdfParams = saved })
+}
+
+// openEncryptTestManager opens the database at dbPath with a manager on it.
+func openEncryptTestManager(t *testing.T, dbPath string) (*VirtualNetworkManager, *StorageManager) {
+	t.Helper()
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	return vnm, sm
+}
+
+// rawBuckets returns the stored bytes of the server, node and config
+// buckets.
+func rawBuckets(t *testing.T, sm *StorageManager) []byte {
+	t.Helper()
+	var raw bytes.Buffer
+	if err := sm.db.View(func(tx *bbolt.Tx) error {
+		for _, name := range []string{BucketServers, BucketNodes, BucketConfigs} {
+			if err := tx.Bucket([]byte(name)).ForEach(func(_, v []byte) error {
+				raw.Write(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	return raw.Bytes()
+}
+
+func TestEncryptKeys(t *testing.T) {
+	cheapKDF(t)
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	vnm, sm := openEncryptTestManager(t, dbPath)
+	if _, err := vnm.CreateVirtualNetwork("secure", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("secure", "srv", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	node, err := vnm.CreateNode("secure", "n1", "1.2.3.4", 51820, NodeTypePeer)
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(sm)
+	saved, _, err := gen.SaveConfigVersion("secure")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	if _, err := sm.EncryptKeys(""); !errors.Is(err, ErrValidation) {
+		t.Errorf("EncryptKeys(\"\") error = %v, want ErrValidation", err)
+	}
+	count, err := sm.EncryptKeys("correct horse")
+	if err != nil || count != 2 {
+		t.Fatalf("EncryptKeys() = %d, %v; want 2 keys", count, err)
+	}
+	if _, err := sm.EncryptKeys("again"); !errors.Is(err, ErrValidation) {
+		t.Errorf("EncryptKeys() twice error = %v, want ErrValidation", err)
+	}
+	raw := rawBuckets(t, sm)
+	for _, key := range []string{server.PrivateKey, node.PrivateKey} {
+		if bytes.Contains(raw, []byte(key)) {
+			t.Errorf("private key %s is still stored in plain", key)
+		}
+	}
+	sm.Close()
+
+	// Reopened, the database is locked: records and configs read fine, with
+	// their keys encrypted, but nothing needing a key works.
+	vnm, sm = openEncryptTestManager(t, dbPath)
+	gen = NewWireGuardConfigGenerator(sm)
+	if !sm.Encrypted() || sm.Unlocked() {
+		t.Fatalf("Encrypted() = %v, Unlocked() = %v after reopening", sm.Encrypted(), sm.Unlocked())
+	}
+	nodes, err := vnm.ListNodes("secure")
+	if err != nil || len(nodes) != 1 || !IsEncryptedKey(nodes[0].PrivateKey) || nodes[0].PublicKey != node.PublicKey {
+		t.Fatalf("ListNodes() = %+v, %v; want the node with its key encrypted", nodes, err)
+	}
+	latest, err := gen.GetConfig("secure", saved.Version)
+	if err != nil || strings.Contains(latest.Configs["n1"], node.PrivateKey) || !strings.Contains(latest.Configs["n1"], "PrivateKey = "+sealedPrefix) {
+		t.Errorf("GetConfig() = %q, %v; want the private key encrypted", latest.Configs["n1"], err)
+	}
+	if _, _, err := gen.GenerateConfigs("secure", sm); !errors.Is(err, ErrKeysLocked) {
+		t.Errorf("GenerateConfigs() locked error = %v, want ErrKeysLocked", err)
+	}
+	if _, err := vnm.CreateNode("secure", "n2", "1.2.3.5", 51820, NodeTypePeer); !errors.Is(err, ErrKeysLocked) {
+		t.Errorf("CreateNode() locked error = %v, want ErrKeysLocked", err)
+	}
+	if _, err := vnm.EditNode("secure", "n1", NodeEdit{IgnoreConflict: true, SetLabels: map[string]string{"site": "a"}}); err != nil {
+		t.Errorf("EditNode() locked error = %v; edits not touching keys need no passphrase", err)
+	}
+
+	if err := sm.Unlock("wrong"); !errors.Is(err, ErrKeysLocked) {
+		t.Errorf("Unlock(wrong) error = %v, want ErrKeysLocked", err)
+	}
+	if err := sm.Unlock("correct horse"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	got, err := vnm.GetNode("secure", "n1")
+	if err != nil || got.PrivateKey != node.PrivateKey {
+		t.Errorf("GetNode() key = %q, %v; want the original key", got.PrivateKey, err)
+	}
+	configs, hash, err := gen.GenerateConfigs("secure", sm)
+	if err != nil || !strings.Contains(configs["n1"], "PrivateKey = "+node.PrivateKey) {
+		t.Fatalf("GenerateConfigs() unlocked = %q, %v", configs["n1"], err)
+	}
+	if hash != saved.ContentHash {
+		t.Errorf("GenerateConfigs() hash = %s, want %s of the version saved before encrypting", hash, saved.ContentHash)
+	}
+	latest, err = gen.GetConfig("secure", saved.Version)
+	if err != nil || latest.Configs["n1"] != saved.Configs["n1"] {
+		t.Errorf("GetConfig() unlocked = %q, %v; want the saved config", latest.Configs["n1"], err)
+	}
+	added, err := vnm.CreateNode("secure", "n2", "1.2.3.5", 51820, NodeTypePeer)
+	if err != nil || IsEncryptedKey(added.PrivateKey) {
+		t.Fatalf("CreateNode() unlocked = %+v, %v", added, err)
+	}
+	version, changed, err := gen.SaveConfigVersion("secure")
+	if err != nil || !changed || strings.Join(version.Changed, ",") != "n1,n2,srv" {
+		t.Fatalf("SaveConfigVersion() = %+v, %v, %v", version, changed, err)
+	}
+	if bytes.Contains(rawBuckets(t, sm), []byte(added.PrivateKey)) {
+		t.Error("a key added while unlocked is stored in plain")
+	}
+
+	count, err = sm.DecryptKeys()
+	if err != nil || count != 3 {
+		t.Fatalf("DecryptKeys() = %d, %v; want 3 keys", count, err)
+	}
+	sm.Close()
+	vnm, sm = openEncryptTestManager(t, dbPath)
+	if sm.Encrypted() {
+		t.Error("Encrypted() after DecryptKeys")
+	}
+	if got, err := vnm.GetNode("secure", "n2"); err != nil || got.PrivateKey != added.PrivateKey {
+		t.Errorf("GetNode() after DecryptKeys key = %q, %v", got.PrivateKey, err)
+	}
+	if _, err := sm.DecryptKeys(); !errors.Is(err, ErrValidation) {
+		t.Errorf("DecryptKeys() unencrypted error = %v, want ErrValidation", err)
+	}
+}
+
+func TestKeyCipher(t *testing.T) {
+	cheapKDF(t)
+	header := &encryptionHeader{KDF: "argon2id", Salt: []byte("0123456789abcdef"), Time: kdfParams.time, Memory: kdfParams.memory, Threads: kdfParams.threads}
+	keys, err := newKeyCipher(header, "one")
+	if err != nil {
+		t.Fatalf("newKeyCipher() error = %v", err)
+	}
+	sealed, err := keys.seal("secret")
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if again, _ := keys.seal("secret"); again != sealed {
+		t.Errorf("seal() = %q then %q, want the same value", sealed, again)
+	}
+	if got, err := keys.open(sealed); err != nil || got != "secret" {
+		t.Errorf("open() = %q, %v", got, err)
+	}
+	if got, err := keys.open("plain"); err != nil || got != "plain" {
+		t.Errorf("open(plain) = %q, %v; want it unchanged", got, err)
+	}
+
+	other, err := newKeyCipher(header, "two")
+	if err != nil {
+		t.Fatalf("newKeyCipher() error = %v", err)
+	}
+	if _, err := other.open(sealed); !errors.Is(err, ErrKeysLocked) {
+		t.Errorf("open() with another passphrase error = %v, want ErrKeysLocked", err)
+	}
+	tampered := sealed[:len(sealed)-4] + "AAA="
+	if _, err := keys.open(tampered); !errors.Is(err, ErrKeysLocked) {
+		t.Errorf("open(tampered) error = %v, want ErrKeysLocked", err)
+	}
+}
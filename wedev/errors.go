@@ -0,0 +1,81 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wedevctl/util"
+)
+
+// Error kinds returned by StorageManager and VirtualNetworkManager. Errors
+// carry their usual message and match one of these with errors.Is, so
+// callers can tell failures apart without parsing text.
+var (
+	// ErrNotFound means a network, server, node, config version or other
+	// record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists means a name, public key or endpoint is already used
+	// by another record.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrPoolExhausted means a network has no free virtual IP (or no free
+	// port in the range asked for).
+	ErrPoolExhausted = util.ErrPoolExhausted
+	// ErrDBLocked means another process held the database for longer than
+	// the lock timeout.
+	ErrDBLocked = errors.New("database locked")
+	// ErrValidation means an argument was rejected: a malformed name,
+	// address, port, CIDR, key or label, or a combination the network
+	// does not allow.
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict means a record changed between being read and being
+	// written back, so the write was refused rather than lose that change.
+	ErrConflict = errors.New("conflict")
+	// ErrKeysLocked means the private keys of an encrypted database were
+	// needed but could not be decrypted: no passphrase was given, or a
+	// wrong one.
+	ErrKeysLocked = errors.New("keys locked")
+	// ErrFrozen means a network's config is frozen with 'config freeze', so
+	// no new config version may be saved until it is unfrozen.
+	ErrFrozen = errors.New("config frozen")
+)
+
+// kindError tags err with one of the error kinds above for errors.Is while
+// keeping err's message.
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// withKind tags err with kind; a nil err stays nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{err: err, kind: kind}
+}
+
+// kindErrorf is fmt.Errorf tagged with kind.
+func kindErrorf(kind error, format string, args ...any) error {
+	return withKind(kind, fmt.Errorf(format, args...))
+}
+
+// validationErrors tags the errors of an IPValidator with ErrValidation.
+type validationErrors struct {
+	util.IPValidator
+}
+
+func (v validationErrors) IsValidNetworkName(name string) error {
+	return withKind(ErrValidation, v.IPValidator.IsValidNetworkName(name))
+}
+
+func (v validationErrors) IsValidCIDR(cidr string) error {
+	return withKind(ErrValidation, v.IPValidator.IsValidCIDR(cidr))
+}
+
+func (v validationErrors) IsValidPublicAddress(addr string) error {
+	return withKind(ErrValidation, v.IPValidator.IsValidPublicAddress(addr))
+}
@@ -0,0 +1,63 @@
+package wedev
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestErrorKinds checks that common failures match their error kind with
+// errors.Is while keeping their usual message.
+func TestErrorKinds(t *testing.T) {
+	vnm, sm := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("tiny", "10.0.0.0/30"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("tiny", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("tiny", "a", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, err := vnm.CreateVirtualNetwork("wide", "10.1.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork(wide) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("wide", "a", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(wide) error = %v", err)
+	}
+	network, err := sm.GetNetworkByName("tiny")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		kind error
+		msg  string
+	}{
+		{"missing network", second(vnm.GetVirtualNetwork("missing")), ErrNotFound, `network "missing" not found`},
+		{"missing server", second(vnm.GetServer("tiny", "missing")), ErrNotFound, ""},
+		{"missing node", second(vnm.GetNode("tiny", "missing")), ErrNotFound, ""},
+		{"missing config version", second(sm.GetConfigVersion(network.ID, 99)), ErrNotFound, ""},
+		{"duplicate network", second(vnm.CreateVirtualNetwork("tiny", "10.2.0.0/24")), ErrAlreadyExists, `network name "tiny" already exists`},
+		{"duplicate node", second(vnm.CreateNode("wide", "a", "", 51820, NodeTypeRoute)), ErrAlreadyExists, ""},
+		{"pool exhausted", second(vnm.CreateNode("tiny", "b", "", 51820, NodeTypeRoute)), ErrPoolExhausted, ""},
+		{"invalid CIDR", second(vnm.CreateVirtualNetwork("bad", "10.0.0.0/33")), ErrValidation, ""},
+		{"invalid port", second(vnm.CreateServer("tiny", "hub2", "vpn2.example.com", 70000)), ErrValidation, ""},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.kind) {
+			t.Errorf("%s: error = %v, want %v", tt.name, tt.err, tt.kind)
+			continue
+		}
+		if tt.msg != "" && tt.err.Error() != tt.msg {
+			t.Errorf("%s: message = %q, want %q", tt.name, tt.err.Error(), tt.msg)
+		}
+	}
+}
+
+// second returns the error of a two-value call.
+func second[T any](_ T, err error) error {
+	return err
+}
@@ -0,0 +1,239 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConfigSource says where the value of a config directive comes from.
+type ConfigSource string
+
+const (
+	// SourceNode is the node's own record: its key, port or flags.
+	SourceNode ConfigSource = "node"
+	// SourceNetwork is a network setting.
+	SourceNetwork ConfigSource = "network"
+	// SourceBuiltin is wedevctl's default for a network setting that is not set.
+	SourceBuiltin ConfigSource = "built-in default"
+	// SourceDerived is computed from the network's CIDR and records.
+	SourceDerived ConfigSource = "derived"
+	// SourcePeer is the record of the server or node a [Peer] section is for.
+	SourcePeer ConfigSource = "peer"
+)
+
+// ConfigDirective is one "Key = Value" line of a config with where its value
+// comes from.
+type ConfigDirective struct {
+	Key    string       `json:"key" yaml:"key"`
+	Value  string       `json:"value" yaml:"value"`
+	Source ConfigSource `json:"source" yaml:"source"`
+	Reason string       `json:"reason" yaml:"reason"` // how the value was arrived at
+	// Comment marks a line written commented out, such as an alternative
+	// endpoint to switch to by hand.
+	Comment bool `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// ConfigSection is the [Interface] section of a config or one of its [Peer]
+// sections.
+type ConfigSection struct {
+	Kind       string            `json:"kind" yaml:"kind"`                                 // "Interface" or "Peer"
+	Name       string            `json:"name,omitempty" yaml:"name,omitempty"`             // the peer's name
+	VirtualIP  string            `json:"virtual_ip,omitempty" yaml:"virtual_ip,omitempty"` // the peer's virtual IP
+	Directives []ConfigDirective `json:"directives" yaml:"directives"`
+
+	// text is the section rendered in advance, for the peer sections every
+	// node config of a network repeats; empty when it is rendered on demand.
+	text string
+}
+
+// StructuredConfig is the config of a node, section by section. Sections may
+// be shared with the other node configs of the same generation and must not
+// be modified.
+type StructuredConfig struct {
+	Network  string           `json:"network" yaml:"network"`
+	Node     string           `json:"node" yaml:"node"`
+	Sections []*ConfigSection `json:"sections" yaml:"sections"` // [Interface] first
+}
+
+// GenerateNodeConfigStructured generates the config of a node, as
+// GenerateConfigs does with the rest of its network, and returns it
+// directive by directive with where each value comes from. Nothing is
+// written or saved.
+func (wcg *WireGuardConfigGenerator) GenerateNodeConfigStructured(networkName, nodeName string) (*StructuredConfig, error) {
+	return wcg.GenerateNodeConfigStructuredCtx(context.Background(), networkName, nodeName)
+}
+
+// GenerateNodeConfigStructuredCtx is GenerateNodeConfigStructured with a
+// context.
+func (wcg *WireGuardConfigGenerator) GenerateNodeConfigStructuredCtx(ctx context.Context, networkName, nodeName string) (*StructuredConfig, error) {
+	network, servers, nodes, err := wcg.loadNetwork(ctx, networkName, wcg.storage)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.Name != nodeName || node.ExternallyManaged() {
+			continue
+		}
+		routes, peers := networkPeers(network, nodes)
+		return wcg.structuredNodeConfig(network, servers, node, nodes, peers, routes), nil
+	}
+	if _, err := wcg.storage.GetNodeByName(network.ID, nodeName); err != nil {
+		return nil, err
+	}
+	// The node exists but gets no config; SelectConfigs says why.
+	_, err = wcg.SelectConfigs(networkName, map[string]string{}, []string{nodeName})
+	return nil, err
+}
+
+// Render returns the config as GenerateConfigs writes it, without the header
+// comment.
+func (c *StructuredConfig) Render() string {
+	size := 1
+	for _, section := range c.Sections {
+		size += section.size()
+	}
+	var config strings.Builder
+	config.Grow(size)
+	for _, section := range c.Sections {
+		section.writeTo(&config)
+	}
+	// Trailing blank line at end of file.
+	config.WriteString("\n")
+	return config.String()
+}
+
+// Redacted returns a copy of the config with the values of secret
+// directives replaced by Redacted.
+func (c *StructuredConfig) Redacted() *StructuredConfig {
+	redacted := *c
+	redacted.Sections = make([]*ConfigSection, len(c.Sections))
+	for i, section := range c.Sections {
+		redacted.Sections[i] = section
+		for j, directive := range section.Directives {
+			if !secretConfigKeys[directive.Key] {
+				continue
+			}
+			if redacted.Sections[i] == section {
+				clone := *section
+				clone.Directives = append([]ConfigDirective(nil), section.Directives...)
+				clone.text = ""
+				redacted.Sections[i] = &clone
+			}
+			redacted.Sections[i].Directives[j].Value = Redacted
+		}
+	}
+	return &redacted
+}
+
+// newPeerConfigSection returns a [Peer] section rendered in advance.
+func newPeerConfigSection(name, virtualIP string, directives []ConfigDirective) *ConfigSection {
+	section := &ConfigSection{Kind: "Peer", Name: name, VirtualIP: virtualIP, Directives: directives}
+	var text strings.Builder
+	section.writeTo(&text)
+	section.text = text.String()
+	return section
+}
+
+// writeTo renders the section.
+func (s *ConfigSection) writeTo(config *strings.Builder) {
+	if s.text != "" {
+		config.WriteString(s.text)
+		return
+	}
+	if s.Kind == "Interface" {
+		config.WriteString("[Interface]\n")
+	} else {
+		writePeerHeader(config, s.Name, s.VirtualIP)
+	}
+	for _, directive := range s.Directives {
+		if directive.Comment {
+			config.WriteString("# ")
+		}
+		config.WriteString(directive.Key)
+		config.WriteString(" = ")
+		config.WriteString(directive.Value)
+		config.WriteString("\n")
+	}
+}
+
+// size returns about how many bytes the rendered section takes.
+func (s *ConfigSection) size() int {
+	if s.text != "" {
+		return len(s.text)
+	}
+	size := len(s.Name) + len(s.VirtualIP) + 16
+	for _, directive := range s.Directives {
+		size += len(directive.Key) + len(directive.Value) + 6
+	}
+	return size
+}
+
+// keepaliveDirective returns the PersistentKeepalive directive of the peers
+// of route and client nodes, and false when the network turns it off.
+func keepaliveDirective(network *VirtualNetwork) (ConfigDirective, bool) {
+	seconds := network.Keepalive()
+	if seconds <= 0 {
+		return ConfigDirective{}, false
+	}
+	directive := ConfigDirective{Key: "PersistentKeepalive", Value: strconv.Itoa(seconds)}
+	if _, set := network.Settings[SettingKeepalive]; set {
+		directive.Source, directive.Reason = SourceNetwork, "setting "+SettingKeepalive+"; route and client nodes connect outbound only"
+	} else {
+		directive.Source, directive.Reason = SourceBuiltin, SettingKeepalive+" is not set; route and client nodes connect outbound only"
+	}
+	return directive, true
+}
+
+// endpointDirective returns the Endpoint directive for dialing what (e.g.
+// "server srv") at endpoint, its internal endpoint when internal is set.
+func endpointDirective(what, endpoint string, internal bool) ConfigDirective {
+	reason := what + "'s public endpoint"
+	if internal {
+		reason = what + "'s internal endpoint; the node prefers internal endpoints"
+	}
+	return ConfigDirective{Key: "Endpoint", Value: endpoint, Source: SourcePeer, Reason: reason}
+}
+
+// serverEndpointDirectives returns the Endpoint directive of the section for
+// server in node's config, followed by the server's other public endpoints,
+// commented out so they can be switched to if the first fails. None are
+// returned for a server without a public address.
+func serverEndpointDirectives(server *Server, node *Node) []ConfigDirective {
+	endpoint := server.EndpointForNode(node)
+	if endpoint.Endpoint == "" {
+		return nil
+	}
+	what := "server " + server.Name
+	directive := endpointDirective(what, endpoint.Endpoint, endpoint.Assignment == "internal")
+	if endpoint.Assignment != "internal" && (endpoint.Assignment != string(EndpointPrimary) || len(endpoint.Alternatives) > 0) {
+		directive.Reason += " (" + endpoint.Assignment + ")"
+	}
+	directives := []ConfigDirective{directive}
+	for _, alternative := range endpoint.Alternatives {
+		directives = append(directives, ConfigDirective{
+			Key: "Endpoint", Value: alternative, Source: SourcePeer, Comment: true,
+			Reason: what + "'s alternative public endpoint, for failover by hand",
+		})
+	}
+	return directives
+}
+
+// interfaceDirectives returns the [Interface] directives of a node's config.
+func interfaceDirectives(network *VirtualNetwork, node *Node) []ConfigDirective {
+	address := ConfigDirective{Key: "Address", Value: interfaceAddress(network, node.VirtualIP), Source: SourceDerived}
+	address.Reason = fmt.Sprintf("the node's virtual IP with the prefix length of network CIDR %s", network.CIDR)
+	directives := []ConfigDirective{
+		{Key: "PrivateKey", Value: node.PrivateKey, Source: SourceNode, Reason: "the node's private key"},
+		address,
+		{Key: "ListenPort", Value: strconv.Itoa(node.Port), Source: SourceNode, Reason: "the node's port"},
+	}
+	if mtu := network.MTU(); mtu != 0 {
+		directives = append(directives, ConfigDirective{Key: "MTU", Value: strconv.Itoa(mtu), Source: SourceNetwork, Reason: "setting " + SettingMTU})
+	}
+	if dns := network.DNS(); len(dns) > 0 {
+		directives = append(directives, ConfigDirective{Key: "DNS", Value: strings.Join(dns, ", "), Source: SourceNetwork, Reason: "setting " + SettingDNS})
+	}
+	return directives
+}
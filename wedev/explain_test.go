@@ -0,0 +1,157 @@
+package wedev
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// explainNetwork builds a network with a server, two peers (one with an
+// internal endpoint), a route node and an expired node.
+func explainNetwork(t *testing.T) (*VirtualNetworkManager, *WireGuardConfigGenerator) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("explain", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("explain", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	// Two nodes behind one NAT share an endpoint; p2 is reached at its
+	// internal one.
+	for _, name := range []string{"p1", "p2"} {
+		if _, err := vnm.CreateNodeWithOptions("explain", name, "203.0.113.1", 51821, NodeTypePeer, NodeOptions{AllowDuplicateEndpoint: true}); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.SetNodeInternalEndpoint("explain", "p2", "192.168.1.2", 0); err != nil {
+		t.Fatalf("SetNodeInternalEndpoint() error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("explain", "office", "", 51822, []string{"192.168.10.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("explain", "gone", "203.0.113.9", 51823, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(gone) error = %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, err := vnm.SetNodeExpiry("explain", "gone", &past); err != nil {
+		t.Fatalf("SetNodeExpiry() error = %v", err)
+	}
+	if _, err := vnm.SetDNS("explain", []string{"1.1.1.1"}); err != nil {
+		t.Fatalf("SetDNS() error = %v", err)
+	}
+	if _, err := vnm.SetNetworkSetting("explain", SettingMTU, "1420", false); err != nil {
+		t.Fatalf("SetNetworkSetting() error = %v", err)
+	}
+	return vnm, NewWireGuardConfigGenerator(sm)
+}
+
+// findDirective returns the directive key of the section named section
+// ("" for [Interface]).
+func findDirective(t *testing.T, config *StructuredConfig, section, key string) ConfigDirective {
+	t.Helper()
+	for _, s := range config.Sections {
+		if s.Name != section {
+			continue
+		}
+		for _, d := range s.Directives {
+			if d.Key == key {
+				return d
+			}
+		}
+	}
+	t.Fatalf("no %s in section %q of %s", key, section, config.Node)
+	return ConfigDirective{}
+}
+
+func TestGenerateNodeConfigStructured(t *testing.T) {
+	vnm, gen := explainNetwork(t)
+
+	configs, _, err := gen.GenerateConfigs("explain", gen.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for _, name := range []string{"p1", "p2", "office"} {
+		structured, err := gen.GenerateNodeConfigStructured("explain", name)
+		if err != nil {
+			t.Fatalf("GenerateNodeConfigStructured(%s) error = %v", name, err)
+		}
+		_, want, _ := strings.Cut(configs[name], "\n")
+		if got := structured.Render(); got != want {
+			t.Errorf("Render() of %s =\n%s\nwant the generated config\n%s", name, got, want)
+		}
+	}
+
+	office, err := gen.GenerateNodeConfigStructured("explain", "office")
+	if err != nil {
+		t.Fatalf("GenerateNodeConfigStructured(office) error = %v", err)
+	}
+	for _, tt := range []struct {
+		section, key string
+		source       ConfigSource
+	}{
+		{"", "PrivateKey", SourceNode},
+		{"", "Address", SourceDerived},
+		{"", "ListenPort", SourceNode},
+		{"", "MTU", SourceNetwork},
+		{"", "DNS", SourceNetwork},
+		{"srv", "PublicKey", SourcePeer},
+		{"srv", "AllowedIPs", SourceDerived},
+		{"srv", "Endpoint", SourcePeer},
+		{"srv", "PersistentKeepalive", SourceBuiltin},
+		{"p1", "PersistentKeepalive", SourceBuiltin},
+	} {
+		if got := findDirective(t, office, tt.section, tt.key); got.Source != tt.source {
+			t.Errorf("%s in section %q has source %q, want %q", tt.key, tt.section, got.Source, tt.source)
+		}
+	}
+
+	if _, err := vnm.SetNetworkSetting("explain", SettingKeepalive, "15", false); err != nil {
+		t.Fatalf("SetNetworkSetting() error = %v", err)
+	}
+	if _, err := vnm.SetNodePreferInternal("explain", "office", true); err != nil {
+		t.Fatalf("SetNodePreferInternal() error = %v", err)
+	}
+	office, err = gen.GenerateNodeConfigStructured("explain", "office")
+	if err != nil {
+		t.Fatalf("GenerateNodeConfigStructured(office) error = %v", err)
+	}
+	if got := findDirective(t, office, "srv", "PersistentKeepalive"); got.Source != SourceNetwork || got.Value != "15" {
+		t.Errorf("PersistentKeepalive = %+v, want 15 from the network", got)
+	}
+	if got := findDirective(t, office, "p2", "Endpoint"); got.Value != "192.168.1.2:51821" || !strings.Contains(got.Reason, "internal") {
+		t.Errorf("p2's Endpoint = %+v, want its internal endpoint", got)
+	}
+}
+
+func TestGenerateNodeConfigStructuredErrors(t *testing.T) {
+	_, gen := explainNetwork(t)
+
+	if _, err := gen.GenerateNodeConfigStructured("explain", "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown node error = %v, want ErrNotFound", err)
+	}
+	if _, err := gen.GenerateNodeConfigStructured("explain", "gone"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired node error = %v, want it to say the node expired", err)
+	}
+}
+
+func TestStructuredConfigRedacted(t *testing.T) {
+	_, gen := explainNetwork(t)
+
+	config, err := gen.GenerateNodeConfigStructured("explain", "p1")
+	if err != nil {
+		t.Fatalf("GenerateNodeConfigStructured() error = %v", err)
+	}
+	rendered := config.Render()
+	redacted := config.Redacted()
+	if got := findDirective(t, redacted, "", "PrivateKey").Value; got != Redacted {
+		t.Errorf("redacted PrivateKey = %q, want %q", got, Redacted)
+	}
+	if got := config.Render(); got != rendered {
+		t.Errorf("Redacted() changed the config it was called on")
+	}
+	if got, want := redacted.Render(), RedactConfig(rendered); got != want {
+		t.Errorf("redacted Render() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package wedev
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	"github.com/wedevctl/util"
+)
+
+// EndpointPreference selects which public address of a server a node dials
+// when the server has additional addresses, such as one per ISP.
+type EndpointPreference string
+
+const (
+	// EndpointPrimary dials the server's PublicAddress; it is the default.
+	EndpointPrimary EndpointPreference = "primary"
+	// EndpointSecondary dials the server's first additional address.
+	EndpointSecondary EndpointPreference = "secondary"
+	// EndpointRoundRobin spreads nodes over all of the server's addresses by
+	// node ID, so a node keeps its address as other nodes come and go.
+	EndpointRoundRobin EndpointPreference = "round-robin"
+)
+
+// ParseEndpointPreference validates an endpoint preference name.
+func ParseEndpointPreference(s string) (EndpointPreference, error) {
+	switch EndpointPreference(s) {
+	case EndpointPrimary, EndpointSecondary, EndpointRoundRobin:
+		return EndpointPreference(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid endpoint preference: %s (must be '%s', '%s' or '%s')", s, EndpointPrimary, EndpointSecondary, EndpointRoundRobin)
+}
+
+// PublicAddresses returns the server's public address followed by its
+// additional addresses.
+func (s *Server) PublicAddresses() []string {
+	return append([]string{s.PublicAddress}, s.AdditionalAddresses...)
+}
+
+// ServerEndpoint is the endpoint a node's config dials a server at.
+type ServerEndpoint struct {
+	Endpoint     string   `json:"endpoint"`
+	Assignment   string   `json:"assignment"`             // how Endpoint was picked, e.g. "secondary" or "round-robin 2/3"
+	Alternatives []string `json:"alternatives,omitempty"` // the server's other public endpoints, commented out in the config
+}
+
+// EndpointForNode returns the endpoint node dials the server at: the
+// internal one when the node prefers it and the server has one, otherwise
+// the public address the node's EndpointPreference picks. The picked
+// address depends only on the node and the server's addresses, so
+// regenerating configs assigns it the same one.
+func (s *Server) EndpointForNode(node *Node) ServerEndpoint {
+	if node.PreferInternal && s.InternalAddress != "" {
+		return ServerEndpoint{Endpoint: s.EndpointFor(true), Assignment: "internal"}
+	}
+	if s.PublicAddress == "" {
+		return ServerEndpoint{}
+	}
+
+	addresses := s.PublicAddresses()
+	picked, assignment := 0, string(EndpointPrimary)
+	switch node.EndpointPreference {
+	case EndpointSecondary:
+		if len(addresses) > 1 {
+			picked, assignment = 1, string(EndpointSecondary)
+		} else {
+			assignment = "primary, no secondary address"
+		}
+	case EndpointRoundRobin:
+		hash := fnv.New32a()
+		hash.Write([]byte(node.ID))
+		picked = int(hash.Sum32() % uint32(len(addresses)))
+		assignment = fmt.Sprintf("round-robin %d/%d", picked+1, len(addresses))
+	}
+
+	endpoint := ServerEndpoint{Endpoint: util.FormatEndpoint(addresses[picked], s.Port), Assignment: assignment}
+	for i, address := range addresses {
+		if i != picked {
+			endpoint.Alternatives = append(endpoint.Alternatives, util.FormatEndpoint(address, s.Port))
+		}
+	}
+	return endpoint
+}
+
+// validateAdditionalAddresses checks a server's additional addresses: valid
+// public addresses, each given once and none its primary address.
+func (vnm *VirtualNetworkManager) validateAdditionalAddresses(network *VirtualNetwork, server *Server) error {
+	for i, address := range server.AdditionalAddresses {
+		if err := vnm.validateNetworkPublicAddress(network, address); err != nil {
+			return err
+		}
+		if address == server.PublicAddress || slices.Contains(server.AdditionalAddresses[:i], address) {
+			return kindErrorf(ErrValidation, "server %q lists address %s twice", server.Name, address)
+		}
+	}
+	return nil
+}
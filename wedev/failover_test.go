@@ -0,0 +1,158 @@
+package wedev
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseEndpointPreference(t *testing.T) {
+	for _, s := range []string{"primary", "secondary", "round-robin"} {
+		if got, err := ParseEndpointPreference(s); err != nil || string(got) != s {
+			t.Errorf("ParseEndpointPreference(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseEndpointPreference("random"); !errors.Is(err, ErrValidation) {
+		t.Errorf("ParseEndpointPreference(random) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestServerEndpointForNode(t *testing.T) {
+	server := &Server{
+		Name:                "srv",
+		PublicAddress:       "isp1.example.com",
+		AdditionalAddresses: []string{"isp2.example.com", "isp3.example.com"},
+		Port:                51820,
+		InternalAddress:     "10.10.0.1",
+	}
+
+	tests := []struct {
+		name       string
+		node       *Node
+		endpoint   string
+		assignment string
+	}{
+		{"default", &Node{ID: "a"}, "isp1.example.com:51820", "primary"},
+		{"primary", &Node{ID: "a", EndpointPreference: EndpointPrimary}, "isp1.example.com:51820", "primary"},
+		{"secondary", &Node{ID: "a", EndpointPreference: EndpointSecondary}, "isp2.example.com:51820", "secondary"},
+		{"internal", &Node{ID: "a", EndpointPreference: EndpointSecondary, PreferInternal: true}, "10.10.0.1:51820", "internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := server.EndpointForNode(tt.node)
+			if got.Endpoint != tt.endpoint || got.Assignment != tt.assignment {
+				t.Errorf("EndpointForNode() = %+v, want %s (%s)", got, tt.endpoint, tt.assignment)
+			}
+			if tt.assignment != "internal" && len(got.Alternatives) != 2 {
+				t.Errorf("Alternatives = %v, want the other two addresses", got.Alternatives)
+			}
+		})
+	}
+
+	// Round-robin is stable per node and spreads nodes over every address.
+	picked := map[string]bool{}
+	for _, id := range []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9", "n10"} {
+		node := &Node{ID: id, EndpointPreference: EndpointRoundRobin}
+		first := server.EndpointForNode(node)
+		if again := server.EndpointForNode(node); again.Endpoint != first.Endpoint {
+			t.Errorf("round-robin endpoint of %s changed from %s to %s", id, first.Endpoint, again.Endpoint)
+		}
+		if !strings.HasPrefix(first.Assignment, "round-robin ") || !strings.HasSuffix(first.Assignment, "/3") {
+			t.Errorf("round-robin assignment = %q", first.Assignment)
+		}
+		if slices.Contains(first.Alternatives, first.Endpoint) {
+			t.Errorf("alternatives %v include the picked endpoint", first.Alternatives)
+		}
+		picked[first.Endpoint] = true
+	}
+	if len(picked) < 2 {
+		t.Errorf("round-robin put every node on %v", picked)
+	}
+
+	single := &Server{Name: "one", PublicAddress: "vpn.example.com", Port: 51820}
+	got := single.EndpointForNode(&Node{ID: "a", EndpointPreference: EndpointSecondary})
+	if got.Endpoint != "vpn.example.com:51820" || got.Assignment != "primary, no secondary address" || got.Alternatives != nil {
+		t.Errorf("secondary without additional addresses = %+v", got)
+	}
+}
+
+func TestEditServerAdditionalAddresses(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("failover", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("failover", "srv", "isp1.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+
+	for _, addresses := range [][]string{
+		{"isp1.example.com"},
+		{"isp2.example.com", "isp2.example.com"},
+		{"10.0.0.9"},
+	} {
+		edit := ServerEdit{IgnoreConflict: true, AdditionalAddresses: &addresses}
+		if _, err := vnm.EditServer("failover", "srv", edit); !errors.Is(err, ErrValidation) {
+			t.Errorf("EditServer(%v) error = %v, want ErrValidation", addresses, err)
+		}
+	}
+
+	addresses := []string{"isp2.example.com"}
+	server, err := vnm.EditServer("failover", "srv", ServerEdit{IgnoreConflict: true, AdditionalAddresses: &addresses})
+	if err != nil {
+		t.Fatalf("EditServer() error = %v", err)
+	}
+	if !slices.Equal(server.AdditionalAddresses, addresses) {
+		t.Errorf("AdditionalAddresses = %v, want %v", server.AdditionalAddresses, addresses)
+	}
+	server, err = vnm.EditServer("failover", "srv", ServerEdit{IgnoreConflict: true, AdditionalAddresses: &[]string{}})
+	if err != nil {
+		t.Fatalf("EditServer() error = %v", err)
+	}
+	if len(server.AdditionalAddresses) != 0 {
+		t.Errorf("AdditionalAddresses = %v after clearing", server.AdditionalAddresses)
+	}
+}
+
+func TestGenerateConfigsFailoverEndpoints(t *testing.T) {
+	vnm, gen := explainNetwork(t)
+
+	before, _, err := gen.GenerateConfigs("explain", gen.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	addresses := []string{"isp2.example.com"}
+	if _, err := vnm.EditServer("explain", "srv", ServerEdit{IgnoreConflict: true, AdditionalAddresses: &addresses}); err != nil {
+		t.Fatalf("EditServer() error = %v", err)
+	}
+	preference := EndpointSecondary
+	if _, err := vnm.EditNode("explain", "office", NodeEdit{IgnoreConflict: true, EndpointPreference: &preference}); err != nil {
+		t.Fatalf("EditNode() error = %v", err)
+	}
+
+	configs, _, err := gen.GenerateConfigs("explain", gen.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if want := "Endpoint = isp2.example.com:51820\n# Endpoint = vpn.example.com:51820\n"; !strings.Contains(configs["office"], want) {
+		t.Errorf("office config lacks\n%s\ngot\n%s", want, configs["office"])
+	}
+	if want := "Endpoint = vpn.example.com:51820\n# Endpoint = isp2.example.com:51820\n"; !strings.Contains(configs["p1"], want) {
+		t.Errorf("p1 config lacks\n%s\ngot\n%s", want, configs["p1"])
+	}
+	// The server's own config does not dial itself.
+	if configs["srv"] != before["srv"] {
+		t.Errorf("server config changed:\n%s", configs["srv"])
+	}
+
+	office, err := gen.GenerateNodeConfigStructured("explain", "office")
+	if err != nil {
+		t.Fatalf("GenerateNodeConfigStructured() error = %v", err)
+	}
+	if got := findDirective(t, office, "srv", "Endpoint"); !strings.Contains(got.Reason, "(secondary)") {
+		t.Errorf("Endpoint reason = %q, want it to name the assignment", got.Reason)
+	}
+	if _, want, _ := strings.Cut(configs["office"], "\n"); office.Render() != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", office.Render(), want)
+	}
+}
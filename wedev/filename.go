@@ -0,0 +1,108 @@
+package wedev
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultFilenameTemplate names generated config files after their entity.
+const DefaultFilenameTemplate = "{{.Entity}}.conf"
+
+// ConfigFileData is the data a filename template is executed with.
+type ConfigFileData struct {
+	Network string // network name
+	Entity  string // server or node name
+	Type    string // "server", "peer", or "route"
+}
+
+// ParseFilenameTemplate parses a config filename template and checks that it
+// renders to a plain file name. An empty template is the default one.
+func ParseFilenameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultFilenameTemplate
+	}
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename template: %w", err)
+	}
+	if _, err := renderFilename(tmpl, ConfigFileData{Network: "net", Entity: "node", Type: string(NodeTypePeer)}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderFilename executes tmpl for one entity. The result must be a file
+// name, not a path, so a template cannot write outside the output directory.
+func renderFilename(tmpl *template.Template, data ConfigFileData) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("invalid filename template: %w", err)
+	}
+	filename := name.String()
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, `/\`) {
+		return "", fmt.Errorf("filename template produced %q for %q; it must be a plain file name", filename, data.Entity)
+	}
+	return filename, nil
+}
+
+// InterfaceName returns the wg-quick interface name for a config file name
+// (the name without its .conf suffix) and whether wg-quick accepts it.
+func InterfaceName(filename string) (string, bool) {
+	iface, hasSuffix := strings.CutSuffix(filename, ".conf")
+	return iface, hasSuffix && interfaceNamePattern.MatchString(iface)
+}
+
+// ConfigFilenames maps each server and node of a network to the file name
+// its config is written to, using tmplText, or the network's stored template
+// when tmplText is empty. Two entities may not share a file name. Names that
+// wg-quick cannot use as an interface are logged as warnings.
+func (wcg *WireGuardConfigGenerator) ConfigFilenames(networkName, tmplText string) (map[string]string, error) {
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	if tmplText == "" {
+		tmplText = network.FilenameTemplate()
+	}
+	tmpl, err := ParseFilenameTemplate(tmplText)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make([]ConfigFileData, 0)
+	servers, err := wcg.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		entities = append(entities, ConfigFileData{Network: network.Name, Entity: server.Name, Type: "server"})
+	}
+	nodes, err := wcg.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		entities = append(entities, ConfigFileData{Network: network.Name, Entity: node.Name, Type: string(node.Type)})
+	}
+
+	filenames := make(map[string]string, len(entities))
+	owners := make(map[string]string, len(entities))
+	for _, entity := range entities {
+		filename, err := renderFilename(tmpl, entity)
+		if err != nil {
+			return nil, err
+		}
+		if owner, dup := owners[filename]; dup {
+			return nil, fmt.Errorf("filename template gives %q and %q the same file name %q", owner, entity.Entity, filename)
+		}
+		owners[filename] = entity.Entity
+		filenames[entity.Entity] = filename
+
+		if iface, ok := InterfaceName(filename); !ok {
+			wcg.logger.Warn("config file name is not a valid wg-quick interface name (at most 15 letters, digits, or _=+.- followed by .conf)",
+				"entity", entity.Entity, "file", filename, "interface", iface)
+		}
+	}
+	return filenames, nil
+}
@@ -0,0 +1,104 @@
+package wedev
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr bool
+	}{
+		{"default", "", false},
+		{"custom", "wg-{{.Network}}-{{.Entity}}.conf", false},
+		{"bad syntax", "{{.Entity", true},
+		{"unknown field", "{{.Host}}.conf", true},
+		{"path separator", "{{.Network}}/{{.Entity}}.conf", true},
+		{"empty result", "{{if false}}x{{end}}", true},
+		{"dot dot", "..", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilenameTemplate(tt.tmpl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFilenameTemplate(%q) error = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInterfaceName(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+		ok       bool
+	}{
+		{"wg0.conf", "wg0", true},
+		{"alice.conf", "alice", true},
+		{"a-very-long-interface.conf", "a-very-long-interface", false},
+		{"bad name.conf", "bad name", false},
+		{"alice.txt", "alice.txt", false},
+	}
+	for _, tt := range tests {
+		got, ok := InterfaceName(tt.filename)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("InterfaceName(%q) = %q, %v, want %q, %v", tt.filename, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConfigFilenames(t *testing.T) {
+	vnm, sm, buf := newLoggedManager(t, slog.LevelWarn)
+
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("office", "alice", "1.2.3.4", 0, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(sm)
+
+	got, err := gen.ConfigFilenames("office", "")
+	if err != nil {
+		t.Fatalf("ConfigFilenames() error = %v", err)
+	}
+	if got["srv"] != "srv.conf" || got["alice"] != "alice.conf" {
+		t.Errorf("default filenames = %v", got)
+	}
+
+	got, err = gen.ConfigFilenames("office", "wireguard-{{.Type}}-{{.Entity}}.conf")
+	if err != nil {
+		t.Fatalf("ConfigFilenames() error = %v", err)
+	}
+	if got["srv"] != "wireguard-server-srv.conf" || got["alice"] != "wireguard-peer-alice.conf" {
+		t.Errorf("templated filenames = %v", got)
+	}
+	if !strings.Contains(buf.String(), "not a valid wg-quick interface name") {
+		t.Errorf("expected warning for long interface name, log = %q", buf.String())
+	}
+
+	// The network's stored template applies when none is given.
+	if _, err := vnm.SetFilenameTemplate("office", "{{.Network}}-{{.Entity}}.conf"); err != nil {
+		t.Fatalf("SetFilenameTemplate() error = %v", err)
+	}
+	got, err = gen.ConfigFilenames("office", "")
+	if err != nil {
+		t.Fatalf("ConfigFilenames() error = %v", err)
+	}
+	if got["alice"] != "office-alice.conf" {
+		t.Errorf("stored template filename = %q, want office-alice.conf", got["alice"])
+	}
+
+	if _, err := gen.ConfigFilenames("office", "{{.Network}}.conf"); err == nil {
+		t.Error("expected error when entities share a file name")
+	}
+	if _, err := vnm.SetFilenameTemplate("office", "{{.Nope}}"); err == nil {
+		t.Error("expected error for invalid stored template")
+	}
+}
@@ -0,0 +1,195 @@
+package wedev
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FirewallFormat is a firewall tool RenderFirewall writes rules for.
+type FirewallFormat string
+
+const (
+	// FirewallIPTables writes iptables commands.
+	FirewallIPTables FirewallFormat = "iptables"
+	// FirewallNFTables writes nft commands adding to the inet filter table.
+	FirewallNFTables FirewallFormat = "nftables"
+	// FirewallUFW writes ufw commands.
+	FirewallUFW FirewallFormat = "ufw"
+)
+
+// ParseFirewallFormat validates a firewall format name.
+func ParseFirewallFormat(s string) (FirewallFormat, error) {
+	switch FirewallFormat(s) {
+	case FirewallIPTables, FirewallNFTables, FirewallUFW:
+		return FirewallFormat(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid firewall format: %s (must be '%s', '%s' or '%s')", s, FirewallIPTables, FirewallNFTables, FirewallUFW)
+}
+
+// FirewallEntity is a server or node named in a firewall report, as
+// "name (kind)" where kind is "server" or the node type.
+type FirewallEntity struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+func (e FirewallEntity) String() string {
+	return fmt.Sprintf("%s (%s)", e.Name, e.Kind)
+}
+
+// FirewallPort is a UDP port a host must accept WireGuard traffic on, and
+// the entities listening on it.
+type FirewallPort struct {
+	Port     int              `json:"port"`
+	Entities []FirewallEntity `json:"entities"`
+}
+
+// EntityList returns the port's entities as a comma-separated list.
+func (p FirewallPort) EntityList() string {
+	names := make([]string, 0, len(p.Entities))
+	for _, e := range p.Entities {
+		names = append(names, e.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// FirewallHost is a public address and the ports its entities listen on.
+type FirewallHost struct {
+	Address string         `json:"address"`
+	Ports   []FirewallPort `json:"ports"`
+}
+
+// FirewallReport lists the inbound UDP ports a network's hosts must open,
+// by public address, and the nodes that only connect outbound.
+type FirewallReport struct {
+	Network   string           `json:"network"`
+	Hosts     []FirewallHost   `json:"hosts"`      // sorted by address
+	NoInbound []FirewallEntity `json:"no_inbound"` // nodes without a public address, sorted by name
+}
+
+// FirewallRules derives the inbound UDP ports a network needs from its
+// servers and nodes: every server, and every node with a public address,
+// listens on its port at that address. Entities sharing an address are
+// grouped under one host. Expired nodes are left out, as they are of
+// generated configs.
+func (vnm *VirtualNetworkManager) FirewallRules(networkName string) (*FirewallReport, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FirewallReport{Network: network.Name, Hosts: []FirewallHost{}, NoInbound: []FirewallEntity{}}
+	ports := make(map[string]map[int][]FirewallEntity)
+	listen := func(address string, port int, entity FirewallEntity) {
+		if ports[address] == nil {
+			ports[address] = make(map[int][]FirewallEntity)
+		}
+		ports[address][port] = append(ports[address][port], entity)
+	}
+	for _, server := range servers {
+		listen(server.PublicAddress, server.Port, FirewallEntity{Name: server.Name, Kind: "server"})
+	}
+	now := vnm.now()
+	for _, node := range nodes {
+		if node.Expired(now) {
+			continue
+		}
+		entity := FirewallEntity{Name: node.Name, Kind: string(node.Type)}
+		if node.PublicAddress == "" {
+			report.NoInbound = append(report.NoInbound, entity)
+			continue
+		}
+		listen(node.PublicAddress, node.Port, entity)
+	}
+
+	for address, byPort := range ports {
+		host := FirewallHost{Address: address}
+		for _, port := range slices.Sorted(maps.Keys(byPort)) {
+			entities := byPort[port]
+			sort.Slice(entities, func(i, j int) bool { return entities[i].Name < entities[j].Name })
+			host.Ports = append(host.Ports, FirewallPort{Port: port, Entities: entities})
+		}
+		report.Hosts = append(report.Hosts, host)
+	}
+	sort.Slice(report.Hosts, func(i, j int) bool { return report.Hosts[i].Address < report.Hosts[j].Address })
+	sort.Slice(report.NoInbound, func(i, j int) bool { return report.NoInbound[i].Name < report.NoInbound[j].Name })
+	return report, nil
+}
+
+// firewallTemplates render a FirewallReport as rules to paste on each host.
+// Every host gets its own block; the nodes needing no rules are listed last.
+var firewallTemplates = map[FirewallFormat]*template.Template{
+	FirewallIPTables: firewallTemplate("iptables", `# WireGuard inbound rules for network {{.Network}}, one block per host
+{{- range .Hosts}}
+
+# {{.Address}}
+{{- range .Ports}}
+iptables -A INPUT -p udp --dport {{.Port}} -m comment --comment "wedevctl {{$.Network}}: {{.EntityList}}" -j ACCEPT
+{{- end}}
+{{- end}}
+{{- template "noinbound" .}}
+`),
+	FirewallNFTables: firewallTemplate("nftables", `# WireGuard inbound rules for network {{.Network}}, one block per host
+{{- range .Hosts}}
+
+# {{.Address}}
+{{- range .Ports}}
+nft add rule inet filter input udp dport {{.Port}} accept comment \"wedevctl {{$.Network}}: {{.EntityList}}\"
+{{- end}}
+{{- end}}
+{{- template "noinbound" .}}
+`),
+	FirewallUFW: firewallTemplate("ufw", `# WireGuard inbound rules for network {{.Network}}, one block per host
+{{- range .Hosts}}
+
+# {{.Address}}
+{{- range .Ports}}
+ufw allow {{.Port}}/udp comment 'wedevctl {{$.Network}}: {{.EntityList}}'
+{{- end}}
+{{- end}}
+{{- template "noinbound" .}}
+`),
+}
+
+const firewallNoInboundTemplate = `
+{{- if .NoInbound}}
+
+# No inbound required (outbound only):
+{{- range .NoInbound}}
+#   {{.}}
+{{- end}}
+{{- end}}`
+
+// firewallTemplate parses a firewall template along with the "noinbound"
+// template it ends with.
+func firewallTemplate(name, text string) *template.Template {
+	t := template.Must(template.New(name).Parse(text))
+	template.Must(t.New("noinbound").Parse(firewallNoInboundTemplate))
+	return t
+}
+
+// RenderFirewall renders the report as rules for format.
+func (r *FirewallReport) RenderFirewall(format FirewallFormat) (string, error) {
+	t, ok := firewallTemplates[format]
+	if !ok {
+		_, err := ParseFirewallFormat(string(format))
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("failed to render %s rules: %w", format, err)
+	}
+	return b.String(), nil
+}
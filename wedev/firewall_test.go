@@ -0,0 +1,131 @@
+package wedev
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// newFirewallNetwork creates network "office" with two servers on one host,
+// two peer nodes on another, a route node with and one without a public
+// address, a client, and an expired peer.
+func newFirewallNetwork(t *testing.T) *VirtualNetworkManager {
+	t.Helper()
+	vnm, _ := newTestManager(t)
+	steps := []func() error{
+		func() error { _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); return err },
+		func() error { _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); return err },
+		func() error { _, err := vnm.CreateServer("office", "edge", "vpn.example.com", 51821); return err },
+		func() error {
+			_, err := vnm.CreateNode("office", "nas", "198.51.100.7", 51820, NodeTypePeer)
+			return err
+		},
+		func() error {
+			_, err := vnm.CreateNode("office", "backup", "198.51.100.7", 51822, NodeTypePeer)
+			return err
+		},
+		func() error {
+			_, err := vnm.CreateNode("office", "branch", "203.0.113.5", 51820, NodeTypeRoute)
+			return err
+		},
+		func() error { _, err := vnm.CreateNode("office", "lan", "", 0, NodeTypeRoute); return err },
+		func() error { _, err := vnm.CreateNode("office", "phone", "", 0, NodeTypeClient); return err },
+		func() error {
+			_, err := vnm.CreateNode("office", "contractor", "192.0.2.9", 51820, NodeTypePeer)
+			return err
+		},
+		func() error {
+			past := time.Now().Add(-time.Hour)
+			_, err := vnm.SetNodeExpiry("office", "contractor", &past)
+			return err
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("setup error = %v", err)
+		}
+	}
+	return vnm
+}
+
+func TestFirewallRules(t *testing.T) {
+	vnm := newFirewallNetwork(t)
+
+	report, err := vnm.FirewallRules("office")
+	if err != nil {
+		t.Fatalf("FirewallRules() error = %v", err)
+	}
+	want := []FirewallHost{
+		{Address: "198.51.100.7", Ports: []FirewallPort{
+			{Port: 51820, Entities: []FirewallEntity{{Name: "nas", Kind: "peer"}}},
+			{Port: 51822, Entities: []FirewallEntity{{Name: "backup", Kind: "peer"}}},
+		}},
+		{Address: "203.0.113.5", Ports: []FirewallPort{
+			{Port: 51820, Entities: []FirewallEntity{{Name: "branch", Kind: "route"}}},
+		}},
+		{Address: "vpn.example.com", Ports: []FirewallPort{
+			{Port: 51820, Entities: []FirewallEntity{{Name: "hub", Kind: "server"}}},
+			{Port: 51821, Entities: []FirewallEntity{{Name: "edge", Kind: "server"}}},
+		}},
+	}
+	if !reflect.DeepEqual(report.Hosts, want) {
+		t.Errorf("Hosts = %+v\nwant %+v", report.Hosts, want)
+	}
+	if wantNone := []FirewallEntity{{Name: "lan", Kind: "route"}, {Name: "phone", Kind: "client"}}; !reflect.DeepEqual(report.NoInbound, wantNone) {
+		t.Errorf("NoInbound = %+v, want %+v", report.NoInbound, wantNone)
+	}
+
+	if _, err := vnm.FirewallRules("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FirewallRules(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRenderFirewall_Golden(t *testing.T) {
+	report, err := newFirewallNetwork(t).FirewallRules("office")
+	if err != nil {
+		t.Fatalf("FirewallRules() error = %v", err)
+	}
+
+	for _, format := range []FirewallFormat{FirewallIPTables, FirewallNFTables, FirewallUFW} {
+		t.Run(string(format), func(t *testing.T) {
+			got, err := report.RenderFirewall(format)
+			if err != nil {
+				t.Fatalf("RenderFirewall() error = %v", err)
+			}
+			golden := filepath.Join("testdata", "firewall_"+string(format)+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v (run go test -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("RenderFirewall(%s) =\n%s\nwant\n%s", format, got, want)
+			}
+		})
+	}
+
+	if _, err := report.RenderFirewall("pf"); !errors.Is(err, ErrValidation) {
+		t.Errorf("RenderFirewall(pf) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestRenderFirewall_NoNodes(t *testing.T) {
+	report := &FirewallReport{Network: "empty", Hosts: []FirewallHost{}, NoInbound: []FirewallEntity{}}
+	got, err := report.RenderFirewall(FirewallUFW)
+	if err != nil {
+		t.Fatalf("RenderFirewall() error = %v", err)
+	}
+	if want := "# WireGuard inbound rules for network empty, one block per host\n"; got != want {
+		t.Errorf("RenderFirewall() = %q, want %q", got, want)
+	}
+}
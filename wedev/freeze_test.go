@@ -0,0 +1,160 @@
+package wedev
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFreeze(t *testing.T) {
+	for _, backend := range []struct {
+		name string
+		new  func(t *testing.T) (*VirtualNetworkManager, Storage)
+	}{
+		{"bolt", func(t *testing.T) (*VirtualNetworkManager, Storage) { return newTestManager(t) }},
+		{"memory", func(t *testing.T) (*VirtualNetworkManager, Storage) { return newMemoryTestManager(t) }},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			vnm, storage := backend.new(t)
+			if _, err := vnm.CreateVirtualNetwork("prod", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.CreateServer("prod", "hub", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			generator := NewWireGuardConfigGenerator(storage)
+			if _, _, err := generator.SaveConfigVersion("prod"); err != nil {
+				t.Fatalf("SaveConfigVersion() error = %v", err)
+			}
+
+			clock := time.Date(2024, 7, 18, 9, 0, 0, 0, time.UTC)
+			vnm.now = func() time.Time { return clock }
+			if _, err := vnm.UnfreezeConfig("prod"); !errors.Is(err, ErrValidation) {
+				t.Errorf("UnfreezeConfig(not frozen) error = %v, want ErrValidation", err)
+			}
+			freeze, err := vnm.FreezeConfig("prod", "incident 42")
+			if err != nil {
+				t.Fatalf("FreezeConfig() error = %v", err)
+			}
+			if freeze.Reason != "incident 42" || !freeze.At.Equal(clock) {
+				t.Errorf("FreezeConfig() = %+v, want the reason and time", freeze)
+			}
+			if got := freeze.String(); !strings.HasSuffix(got, "at 2024-07-18 09:00 UTC: incident 42") {
+				t.Errorf("String() = %q", got)
+			}
+			if _, err := vnm.FreezeConfig("prod", "again"); !errors.Is(err, ErrFrozen) {
+				t.Errorf("FreezeConfig(frozen) error = %v, want ErrFrozen", err)
+			}
+			network, err := storage.GetNetworkByName("prod")
+			if err != nil || network.Freeze == nil || network.Freeze.Reason != "incident 42" {
+				t.Fatalf("GetNetworkByName() = %+v, %v; want the freeze stored", network, err)
+			}
+
+			// Unchanged configs need no new version, so they are not refused.
+			if _, created, err := generator.SaveConfigVersion("prod"); err != nil || created {
+				t.Errorf("SaveConfigVersion(unchanged) = %v, %v; want no new version", created, err)
+			}
+			if _, err := vnm.CreateNode("prod", "laptop", "", 0, NodeTypeClient); err != nil {
+				t.Fatalf("CreateNode() error = %v", err)
+			}
+			if _, _, err := generator.SaveConfigVersionWithMessage("prod", "add laptop"); !errors.Is(err, ErrFrozen) || !strings.Contains(err.Error(), "incident 42") {
+				t.Errorf("SaveConfigVersion(frozen) error = %v, want ErrFrozen naming the reason", err)
+			}
+			version, created, err := generator.SaveConfigVersionWithOptionsCtx(t.Context(), "prod", ConfigSaveOptions{Message: "add laptop", OverrideFreeze: true})
+			if err != nil || !created {
+				t.Fatalf("SaveConfigVersionWithOptionsCtx(override) = %v, %v", created, err)
+			}
+			if !strings.HasPrefix(version.Message, "freeze overridden: add laptop") {
+				t.Errorf("overriding version message = %q, want the override recorded", version.Message)
+			}
+
+			if unfrozen, err := vnm.UnfreezeConfig("prod"); err != nil || unfrozen.Reason != "incident 42" {
+				t.Fatalf("UnfreezeConfig() = %+v, %v; want the lifted freeze", unfrozen, err)
+			}
+			if network, err := storage.GetNetworkByName("prod"); err != nil || network.Freeze != nil {
+				t.Errorf("GetNetworkByName() after unfreeze = %+v, %v; want no freeze", network, err)
+			}
+			if _, err := vnm.CreateNode("prod", "phone", "", 0, NodeTypeClient); err != nil {
+				t.Fatalf("CreateNode() error = %v", err)
+			}
+			if _, created, err := generator.SaveConfigVersion("prod"); err != nil || !created {
+				t.Errorf("SaveConfigVersion(unfrozen) = %v, %v; want a new version", created, err)
+			}
+		})
+	}
+}
+
+// TestConfigFreezeRefusesChanges checks that the changes that save a config
+// version are refused on a frozen network before anything is written.
+func TestConfigFreezeRefusesChanges(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("prod", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("prod", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateGuest("prod", "acme", "", time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatalf("CreateGuest() error = %v", err)
+	}
+	if _, _, err := NewWireGuardConfigGenerator(sm).SaveConfigVersion("prod"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, err := vnm.FreezeConfig("prod", "incident 42"); err != nil {
+		t.Fatalf("FreezeConfig() error = %v", err)
+	}
+
+	network, err := sm.GetNetworkByName("prod")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	pool, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+
+	if _, _, err := vnm.ResizeNetwork("prod", "10.0.0.0/16"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("ResizeNetwork() error = %v, want ErrFrozen", err)
+	}
+	if _, err := vnm.CreateGuest("prod", "auditor", "", time.Now().Add(time.Hour)); !errors.Is(err, ErrFrozen) {
+		t.Errorf("CreateGuest() error = %v, want ErrFrozen", err)
+	}
+	if _, err := vnm.RevokeGuest("prod", "acme"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("RevokeGuest() error = %v, want ErrFrozen", err)
+	}
+	spec := &NetworkSpec{
+		Name:    "prod",
+		CIDR:    "10.0.0.0/24",
+		Servers: []ServerSpec{{Name: "hub", PublicAddress: "vpn.example.com", Port: 51820}},
+		Nodes:   []NodeSpec{{Name: "phone", Type: NodeTypeClient}},
+	}
+	plan, err := vnm.PlanSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PlanSpec() error = %v", err)
+	}
+	if err := vnm.ApplySpec(plan); !errors.Is(err, ErrFrozen) {
+		t.Errorf("ApplySpec() error = %v, want ErrFrozen", err)
+	}
+	if _, _, err := vnm.PreviewSpec(spec, false); !errors.Is(err, ErrFrozen) {
+		t.Errorf("PreviewSpec() error = %v, want ErrFrozen", err)
+	}
+
+	after, err := sm.GetNetworkByName("prod")
+	if err != nil || after.CIDR != "10.0.0.0/24" {
+		t.Errorf("network after refused resize = %+v, %v; want the CIDR unchanged", after, err)
+	}
+	if state, err := sm.GetIPPoolState(network.ID); err != nil || !reflect.DeepEqual(state, pool) {
+		t.Errorf("IP pool after refused changes = %+v, %v; want %+v", state, err, pool)
+	}
+	if guests, err := vnm.ListGuests("prod"); err != nil || len(guests) != 1 || guests[0].Name != "acme" {
+		t.Errorf("ListGuests() after refused changes = %+v, %v; want only acme", guests, err)
+	}
+	if _, err := sm.GetNodeByName(network.ID, "phone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetNodeByName(phone) error = %v, want ErrNotFound after a refused apply", err)
+	}
+	if history, err := NewWireGuardConfigGenerator(sm).GetConfigHistory("prod"); err != nil || len(history) != 1 {
+		t.Errorf("GetConfigHistory() = %d versions, %v; want 1", len(history), err)
+	}
+}
@@ -0,0 +1,83 @@
+package wedev
+
+import (
+	"context"
+	"sort"
+
+	"github.com/wedevctl/util"
+)
+
+// GroupLabel is the label 'node add --group' sets. A node group is the set
+// of nodes sharing its value, so --selector group=<name> selects one.
+const GroupLabel = "group"
+
+// NodeGroup is the nodes of a network that share a GroupLabel value.
+type NodeGroup struct {
+	Name    string   `json:"name"`    // empty for the nodes in no group
+	Members []string `json:"members"` // node names, sorted
+}
+
+// ListNodeGroups returns the node groups of a network sorted by name, with
+// the nodes in no group last under an empty name. It changes nothing.
+func (vnm *VirtualNetworkManager) ListNodeGroups(networkName string) ([]NodeGroup, error) {
+	return vnm.ListNodeGroupsCtx(context.Background(), networkName)
+}
+
+// ListNodeGroupsCtx is ListNodeGroups with a context.
+func (vnm *VirtualNetworkManager) ListNodeGroupsCtx(ctx context.Context, networkName string) ([]NodeGroup, error) {
+	nodes, err := vnm.ListNodesCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]string)
+	for _, node := range nodes {
+		group := node.Labels[GroupLabel]
+		members[group] = append(members[group], node.Name)
+	}
+
+	groups := make([]NodeGroup, 0, len(members))
+	for name, names := range members {
+		sort.Strings(names)
+		groups = append(groups, NodeGroup{Name: name, Members: names})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i].Name == "") != (groups[j].Name == "") {
+			return groups[j].Name == ""
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// SelectConfigsMatching returns the subset of a network's generated configs
+// that belong to nodes whose labels match selector. Servers carry no labels
+// and are never selected. Matching nodes without a config, because they
+// have expired or are managed outside wedevctl, are left out; a selector
+// matching no node at all is an error.
+func (wcg *WireGuardConfigGenerator) SelectConfigsMatching(ctx context.Context, networkName string, configs map[string]string, selector util.LabelSelector) (map[string]string, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := wcg.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]string)
+	matched := false
+	for _, node := range nodes {
+		if !selector.Matches(node.Labels) {
+			continue
+		}
+		matched = true
+		if config, ok := configs[node.Name]; ok {
+			selected[node.Name] = config
+		}
+	}
+	if !matched {
+		return nil, kindErrorf(ErrNotFound, "no node of network %q matches selector %q", networkName, selector.String())
+	}
+	return selected, nil
+}
@@ -0,0 +1,78 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+func TestListNodeGroups(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("groups", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("groups", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for name, group := range map[string]string{"desk": "office", "printer": "office", "web": "cloud", "stray": ""} {
+		if _, err := vnm.CreateNode("groups", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+		if group == "" {
+			continue
+		}
+		if _, err := vnm.UpdateNodeLabels("groups", name, map[string]string{GroupLabel: group}, nil); err != nil {
+			t.Fatalf("UpdateNodeLabels(%s) error = %v", name, err)
+		}
+	}
+
+	groups, err := vnm.ListNodeGroups("groups")
+	if err != nil {
+		t.Fatalf("ListNodeGroups() error = %v", err)
+	}
+	want := []NodeGroup{
+		{Name: "cloud", Members: []string{"web"}},
+		{Name: "office", Members: []string{"desk", "printer"}},
+		{Name: "", Members: []string{"stray"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("ListNodeGroups() = %+v, want %+v", groups, want)
+	}
+
+	// Selecting a group keeps the configs of its members only; the full
+	// set is what gets generated and versioned.
+	generator := NewWireGuardConfigGenerator(sm)
+	configs, _, err := generator.GenerateConfigs("groups", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	selector, err := util.ParseLabelSelector("group=office")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector() error = %v", err)
+	}
+	selected, err := generator.SelectConfigsMatching(context.Background(), "groups", configs, selector)
+	if err != nil {
+		t.Fatalf("SelectConfigsMatching() error = %v", err)
+	}
+	if len(selected) != 2 || selected["desk"] != configs["desk"] || selected["printer"] != configs["printer"] {
+		t.Errorf("SelectConfigsMatching(group=office) = %v, want desk and printer", selected)
+	}
+
+	// != also selects the nodes without the label, but never the server.
+	selector, _ = util.ParseLabelSelector("group!=office")
+	selected, err = generator.SelectConfigsMatching(context.Background(), "groups", configs, selector)
+	if err != nil {
+		t.Fatalf("SelectConfigsMatching() error = %v", err)
+	}
+	if _, ok := selected["srv"]; ok || len(selected) != 2 || selected["web"] == "" || selected["stray"] == "" {
+		t.Errorf("SelectConfigsMatching(group!=office) = %v, want web and stray", selected)
+	}
+
+	selector, _ = util.ParseLabelSelector("group=lab")
+	if _, err := generator.SelectConfigsMatching(context.Background(), "groups", configs, selector); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SelectConfigsMatching(group=lab) error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,381 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// BucketGuests is the BoltDB bucket for guests (networkID:name -> guest).
+const BucketGuests = "guests"
+
+// Guest is a one-off client of a network, such as an external partner's
+// machine. It holds a virtual IP from the network's pool and is a peer in
+// its server's config, but it is not a node: no other config lists it, and
+// its private key is never stored, so its own config can only be handed out
+// when it is created. Its access ends at ExpiresAt, after which it is left
+// out of generated configs and its address is released.
+type Guest struct {
+	ID        string    `json:"id"`
+	NetworkID string    `json:"network_id"`
+	Name      string    `json:"name"`
+	VirtualIP string    `json:"virtual_ip"`
+	PublicKey string    `json:"public_key"`
+	ServerID  string    `json:"server_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// PrivateKey is set only on the guest CreateGuest returns, for its
+	// config.
+	PrivateKey string `json:"-"`
+}
+
+// Expired reports whether the guest's access has ended at now.
+func (g *Guest) Expired(now time.Time) bool {
+	return !now.Before(g.ExpiresAt)
+}
+
+// node returns the guest as generation sees it: a client node without a
+// private key, so it is a peer of its server only and gets no config of its
+// own.
+func (g *Guest) node() *Node {
+	return &Node{
+		ID:         g.ID,
+		NetworkID:  g.NetworkID,
+		Name:       g.Name,
+		VirtualIP:  g.VirtualIP,
+		Type:       NodeTypeClient,
+		PublicKey:  g.PublicKey,
+		PrivateKey: g.PrivateKey,
+		ServerID:   g.ServerID,
+		CreatedAt:  g.CreatedAt,
+	}
+}
+
+// guestKey is the key of a guest in BucketGuests.
+func guestKey(networkID, name string) []byte {
+	return []byte(networkID + ":" + name)
+}
+
+// ========== Storage ==========
+
+// CreateGuestWithPoolState stores a new guest and saves the network's IP
+// pool state, which records the guest's address as allocated, in one
+// transaction.
+func (sm *StorageManager) CreateGuestWithPoolState(networkID, name, virtualIP, publicKey, serverID string, expiresAt time.Time, state *util.IPPoolState) (*Guest, error) {
+	var guest *Guest
+	err := sm.update(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(BucketNetworks)).Get([]byte(networkID)) == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", networkID)
+		}
+		bucket := tx.Bucket([]byte(BucketGuests))
+		if bucket.Get(guestKey(networkID, name)) != nil {
+			return kindErrorf(ErrAlreadyExists, "guest %q already exists", name)
+		}
+		guest = &Guest{
+			ID:        uuid.New().String(),
+			NetworkID: networkID,
+			Name:      name,
+			VirtualIP: virtualIP,
+			PublicKey: publicKey,
+			ServerID:  serverID,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}
+		data, err := json.Marshal(guest)
+		if err != nil {
+			return fmt.Errorf("failed to marshal guest: %w", err)
+		}
+		if err := bucket.Put(guestKey(networkID, name), data); err != nil {
+			return err
+		}
+		if err := sm.putIPPoolState(tx, networkID, state); err != nil {
+			return err
+		}
+		return bumpRevision(tx, networkID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return guest, nil
+}
+
+// ListGuestsByNetworkID returns the guests of a network, expired ones
+// included, sorted by name.
+func (sm *StorageManager) ListGuestsByNetworkID(networkID string) ([]*Guest, error) {
+	var guests []*Guest
+	err := sm.view(func(tx *bbolt.Tx) error {
+		var err error
+		guests, err = listGuests(tx, networkID)
+		return err
+	})
+	return guests, err
+}
+
+// listGuests returns the guests of a network within tx, sorted by name.
+func listGuests(tx *bbolt.Tx, networkID string) ([]*Guest, error) {
+	guests := []*Guest{}
+	bucket := tx.Bucket([]byte(BucketGuests))
+	if bucket == nil {
+		// A read-only handle on a database not yet migrated has none.
+		return guests, nil
+	}
+	err := forEachWithPrefix(bucket, []byte(networkID+":"), func(k, v []byte) error {
+		guest := &Guest{}
+		if err := json.Unmarshal(v, guest); err != nil {
+			return fmt.Errorf("failed to decode guest %s: %w", k, err)
+		}
+		guests = append(guests, guest)
+		return nil
+	})
+	return guests, err
+}
+
+// DeleteGuestsWithPoolState removes guests of a network and saves its IP
+// pool state, which no longer records their addresses, in one transaction.
+// A name that is not a guest of the network is ErrNotFound and deletes
+// nothing.
+func (sm *StorageManager) DeleteGuestsWithPoolState(networkID string, names []string, state *util.IPPoolState) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketGuests))
+		for _, name := range names {
+			if bucket.Get(guestKey(networkID, name)) == nil {
+				return kindErrorf(ErrNotFound, "guest %q not found", name)
+			}
+			if err := bucket.Delete(guestKey(networkID, name)); err != nil {
+				return err
+			}
+		}
+		if err := sm.putIPPoolState(tx, networkID, state); err != nil {
+			return err
+		}
+		return bumpRevision(tx, networkID)
+	})
+}
+
+// deleteGuests removes the guests of a network within tx.
+func deleteGuests(tx *bbolt.Tx, networkID string) error {
+	bucket := tx.Bucket([]byte(BucketGuests))
+	var keys [][]byte
+	if err := forEachWithPrefix(bucket, []byte(networkID+":"), func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== Manager ==========
+
+// CreateGuest gives a network a guest with a fresh key pair and an address
+// from its IP pool, peering with serverName (which may be empty when the
+// network has one server) until expiresAt. The returned guest carries its
+// private key, which is not stored: render its config with GuestConfig
+// before dropping it. Expired guests are purged first, so their addresses
+// can be handed out again. A guest only reaches its server through a new
+// config version, so a network whose config is frozen gets none (ErrFrozen).
+func (vnm *VirtualNetworkManager) CreateGuest(networkName, guestName, serverName string, expiresAt time.Time) (*Guest, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFrozen(network); err != nil {
+		return nil, err
+	}
+	if valErr := vnm.validator.IsValidNetworkName(guestName); valErr != nil {
+		return nil, valErr
+	}
+	if !expiresAt.After(vnm.now()) {
+		return nil, kindErrorf(ErrValidation, "guest access must end in the future, got %s", expiresAt.Format(time.RFC3339))
+	}
+	server, err := vnm.resolveServer(network, serverName)
+	if err != nil {
+		return nil, err
+	}
+	// The guest is a peer in its server's config, which names peers.
+	if _, err := vnm.storage.GetServerByName(network.ID, guestName); err == nil {
+		return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a server in this network", guestName)
+	}
+	if _, err := vnm.storage.GetNodeByName(network.ID, guestName); err == nil {
+		return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a node in this network", guestName)
+	}
+
+	if _, err := vnm.purgeExpiredGuests(network); err != nil {
+		return nil, err
+	}
+
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+	guestIP, err := ipPool.AllocateNodeIP()
+	if err != nil {
+		return nil, err
+	}
+	vnm.logger.Debug("allocated guest IP", "network", networkName, "guest", guestName, "ip", guestIP)
+
+	keys, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return nil, err
+	}
+	state := ipPool.GetState()
+	guest, err := vnm.storage.CreateGuestWithPoolState(network.ID, guestName, guestIP, keys.PublicKey, server.ID, expiresAt, state)
+	if err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return nil, err
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	vnm.warnPoolUsage(network, ipPool)
+
+	guest.PrivateKey = keys.PrivateKey
+	return guest, nil
+}
+
+// ListGuests returns the guests of a network, expired ones included, sorted
+// by name.
+func (vnm *VirtualNetworkManager) ListGuests(networkName string) ([]*Guest, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.ListGuestsByNetworkID(network.ID)
+}
+
+// RevokeGuest ends a guest's access before it expires: the guest is deleted
+// and its address released. Save a config version afterwards to drop it
+// from its server's config; while the network's config is frozen, the guest
+// is kept (ErrFrozen).
+func (vnm *VirtualNetworkManager) RevokeGuest(networkName, guestName string) (*Guest, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFrozen(network); err != nil {
+		return nil, err
+	}
+	guests, err := vnm.storage.ListGuestsByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, guest := range guests {
+		if guest.Name == guestName {
+			return guest, vnm.deleteGuests(network, []*Guest{guest})
+		}
+	}
+	return nil, kindErrorf(ErrNotFound, "guest %q not found", guestName)
+}
+
+// PurgeExpiredGuests deletes the expired guests of a network, releasing
+// their addresses, and returns them.
+func (vnm *VirtualNetworkManager) PurgeExpiredGuests(networkName string) ([]*Guest, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.purgeExpiredGuests(network)
+}
+
+// purgeExpiredGuests does the work of PurgeExpiredGuests for a network
+// already looked up. Callers hold poolMu.
+func (vnm *VirtualNetworkManager) purgeExpiredGuests(network *VirtualNetwork) ([]*Guest, error) {
+	guests, err := vnm.storage.ListGuestsByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := vnm.now()
+	var expired []*Guest
+	for _, guest := range guests {
+		if guest.Expired(now) {
+			expired = append(expired, guest)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	if err := vnm.deleteGuests(network, expired); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// deleteGuests deletes guests of a network and releases their addresses.
+// Callers hold poolMu.
+func (vnm *VirtualNetworkManager) deleteGuests(network *VirtualNetwork, guests []*Guest) error {
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return fmt.Errorf("failed to ensure IP pool: %w", err)
+	}
+	names := make([]string, 0, len(guests))
+	for _, guest := range guests {
+		if err := ipPool.ReleaseNodeIP(guest.VirtualIP); err != nil {
+			vnm.logger.Warn("failed to release IP", "ip", guest.VirtualIP, "error", err)
+		}
+		names = append(names, guest.Name)
+	}
+	state := ipPool.GetState()
+	if err := vnm.storage.DeleteGuestsWithPoolState(network.ID, names, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return err
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	return nil
+}
+
+// ========== Generation ==========
+
+// withGuests adds the unexpired guests of a network to its nodes, as client
+// nodes without a private key (see Guest.node), and warns which expired
+// guests were left out.
+func (wcg *WireGuardConfigGenerator) withGuests(networkName string, nodes []*Node, guests []*Guest) []*Node {
+	now := wcg.now()
+	var expired []string
+	for _, guest := range guests {
+		if guest.Expired(now) {
+			expired = append(expired, guest.Name)
+			continue
+		}
+		nodes = append(nodes, guest.node())
+	}
+	if len(expired) > 0 {
+		sort.Strings(expired)
+		wcg.logger.Warn("excluding expired guests from configs", "network", networkName, "guests", strings.Join(expired, ","))
+	}
+	return nodes
+}
+
+// GuestConfig generates the config of a guest CreateGuest returned, whose
+// private key it carries: a client of its server, as a client node's config
+// would be.
+func (wcg *WireGuardConfigGenerator) GuestConfig(ctx context.Context, networkName string, guest *Guest) (string, error) {
+	if guest.PrivateKey == "" {
+		return "", fmt.Errorf("guest %q has no private key; its config is only available when it is created", guest.Name)
+	}
+	network, servers, nodes, err := wcg.loadNetwork(ctx, networkName, wcg.storage)
+	if err != nil {
+		return "", err
+	}
+	routes, peers := networkPeers(network, nodes)
+	return configHeader(network, wcg.now()) + wcg.generateNodeConfig(network, servers, guest.node(), nodes, peers, routes), nil
+}
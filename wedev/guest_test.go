@@ -0,0 +1,145 @@
+package wedev
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuestLifecycle(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("partners", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("partners", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("partners", "office", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	clock := time.Date(2024, 7, 18, 9, 0, 0, 0, time.UTC)
+	vnm.now = func() time.Time { return clock }
+	generator := NewWireGuardConfigGenerator(sm)
+	generator.now = func() time.Time { return clock }
+	expiresAt := clock.Add(24 * time.Hour)
+
+	if _, err := vnm.CreateGuest("partners", "office", "", expiresAt); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("CreateGuest(node name) error = %v, want ErrAlreadyExists", err)
+	}
+	if _, err := vnm.CreateGuest("partners", "acme", "", clock); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateGuest(expired) error = %v, want ErrValidation", err)
+	}
+	guest, err := vnm.CreateGuest("partners", "acme", "", expiresAt)
+	if err != nil {
+		t.Fatalf("CreateGuest() error = %v", err)
+	}
+	if guest.PrivateKey == "" || guest.ServerID != server.ID || guest.VirtualIP == "" {
+		t.Fatalf("CreateGuest() = %+v, want a key pair, an address and the server", guest)
+	}
+	if _, err := vnm.CreateGuest("partners", "acme", "", expiresAt); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("CreateGuest() twice error = %v, want ErrAlreadyExists", err)
+	}
+
+	stored, err := vnm.ListGuests("partners")
+	if err != nil || len(stored) != 1 || stored[0].PrivateKey != "" || stored[0].PublicKey != guest.PublicKey {
+		t.Fatalf("ListGuests() = %+v, %v; want the guest without its private key", stored, err)
+	}
+
+	configs, _, err := generator.GenerateConfigs("partners", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if _, ok := configs["acme"]; ok {
+		t.Error("guest got a config of its own")
+	}
+	if !hasPeer(configs["hub"], guest.PublicKey) || !strings.Contains(configs["hub"], "# acme ("+guest.VirtualIP+")") {
+		t.Errorf("server config lacks the guest:\n%s", configs["hub"])
+	}
+	if hasPeer(configs["office"], guest.PublicKey) {
+		t.Errorf("node config lists the guest:\n%s", configs["office"])
+	}
+
+	config, err := generator.GuestConfig(t.Context(), "partners", guest)
+	if err != nil {
+		t.Fatalf("GuestConfig() error = %v", err)
+	}
+	for _, want := range []string{"PrivateKey = " + guest.PrivateKey, "Address = " + guest.VirtualIP + "/24", "PublicKey = " + server.PublicKey, "Endpoint = vpn.example.com:51820"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("GuestConfig() missing %q:\n%s", want, config)
+		}
+	}
+	if _, err := generator.GuestConfig(t.Context(), "partners", stored[0]); err == nil {
+		t.Error("GuestConfig() of a stored guest succeeded without its private key")
+	}
+	if report, err := vnm.AuditIPPool("partners"); err != nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() = %+v, %v; want the guest's address accounted for", report, err)
+	}
+
+	// Once expired, the guest leaves the server config and its address is
+	// released by the next purge.
+	clock = expiresAt
+	configs, _, err = generator.GenerateConfigs("partners", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if hasPeer(configs["hub"], guest.PublicKey) {
+		t.Errorf("server still peers with the expired guest:\n%s", configs["hub"])
+	}
+	purged, err := vnm.PurgeExpiredGuests("partners")
+	if err != nil || len(purged) != 1 || purged[0].Name != "acme" {
+		t.Fatalf("PurgeExpiredGuests() = %v, %v; want [acme]", purged, err)
+	}
+	if report, err := vnm.AuditIPPool("partners"); err != nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() after purge = %+v, %v; want the address released cleanly", report, err)
+	}
+
+	guest, err = vnm.CreateGuest("partners", "globex", "hub", clock.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateGuest() error = %v", err)
+	}
+	if _, err := vnm.RevokeGuest("partners", "globex"); err != nil {
+		t.Fatalf("RevokeGuest() error = %v", err)
+	}
+	if _, err := vnm.RevokeGuest("partners", "globex"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeGuest() twice error = %v, want ErrNotFound", err)
+	}
+	listing, err := vnm.ListIPs("partners")
+	if err != nil {
+		t.Fatalf("ListIPs() error = %v", err)
+	}
+	for _, holder := range listing.Allocated {
+		if holder.IP == guest.VirtualIP {
+			t.Errorf("revoked guest's address %s is still allocated to %s", guest.VirtualIP, holder.Name)
+		}
+	}
+}
+
+func TestGuestMemoryStorage(t *testing.T) {
+	vnm, ms := newMemoryTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("partners", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateGuest("partners", "acme", "", time.Now().Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CreateGuest() without a server error = %v, want ErrNotFound", err)
+	}
+	if _, err := vnm.CreateServer("partners", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	guest, err := vnm.CreateGuest("partners", "acme", "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateGuest() error = %v", err)
+	}
+	configs, _, err := NewWireGuardConfigGenerator(ms).GenerateConfigs("partners", ms)
+	if err != nil || !hasPeer(configs["hub"], guest.PublicKey) {
+		t.Errorf("GenerateConfigs() = %v, %v; want the guest in the server config", configs, err)
+	}
+	if err := vnm.DeleteVirtualNetwork("partners"); err != nil {
+		t.Fatalf("DeleteVirtualNetwork() error = %v", err)
+	}
+	if guests, err := ms.ListGuestsByNetworkID(guest.NetworkID); err != nil || len(guests) != 0 {
+		t.Errorf("ListGuestsByNetworkID() after deleting the network = %v, %v", guests, err)
+	}
+}
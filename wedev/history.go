@@ -0,0 +1,209 @@
+package wedev
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// DefaultHistoryLimit is how many revisions of each server and node are kept
+// when StorageOptions.HistoryLimit is zero.
+const DefaultHistoryLimit = 20
+
+// Revision actions, recorded with each EntityRevision.
+const (
+	HistoryUpdate = "update"
+	HistoryRename = "rename"
+	HistoryRekey  = "rekey"
+)
+
+// EntityRevision records one change to a server or node.
+type EntityRevision struct {
+	At      time.Time       `json:"at"`
+	Action  string          `json:"action"`
+	Changes []FieldChange   `json:"changes"`
+	Before  json.RawMessage `json:"before"` // the record before the change, its private key blanked
+}
+
+// FieldChange is a field of a record that a revision changed. Values are
+// the JSON values, strings unquoted; a missing field is "". Private keys
+// are shown as "(redacted)".
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// historyIgnoredFields are record fields that change with every update, or
+// that keep their own history (EndpointHistory), and are not worth listing.
+var historyIgnoredFields = map[string]bool{"updated_at": true, "revision": true, "address_history": true, "resolved_ips": true, "resolved_at": true}
+
+// recordHistory appends the change from before to after to the history of
+// the entity within tx, dropping the oldest revisions past the limit of its
+// network. A change touching no listed field records nothing.
+func (sm *StorageManager) recordHistory(tx *bbolt.Tx, entityID, action string, before, after any) error {
+	rev, err := newRevision(action, before, after)
+	if err != nil || rev == nil {
+		return err
+	}
+
+	bucket := tx.Bucket([]byte(BucketHistory))
+	var revisions []EntityRevision
+	if data := bucket.Get([]byte(entityID)); data != nil {
+		if err := json.Unmarshal(data, &revisions); err != nil {
+			return fmt.Errorf("failed to unmarshal history of %s: %w", entityID, err)
+		}
+	}
+	limit := sm.historyLimit
+	if data := tx.Bucket([]byte(BucketNetworks)).Get([]byte(historyNetworkID(after))); data != nil {
+		var network VirtualNetwork
+		if err := json.Unmarshal(data, &network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+		limit = network.historyLimit(limit)
+	}
+	revisions = appendRevision(revisions, *rev, limit)
+
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+	return bucket.Put([]byte(entityID), data)
+}
+
+// historyNetworkID returns the network of a server or node record.
+func historyNetworkID(record any) string {
+	switch r := record.(type) {
+	case *Server:
+		return r.NetworkID
+	case *Node:
+		return r.NetworkID
+	}
+	return ""
+}
+
+// newRevision returns the revision changing before to after, or nil when
+// no listed field changed.
+func newRevision(action string, before, after any) (*EntityRevision, error) {
+	oldFields, err := recordFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := recordFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := fieldChanges(oldFields, newFields)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	if _, ok := oldFields["private_key"]; ok {
+		oldFields["private_key"] = json.RawMessage(`""`)
+	}
+	snapshot, err := json.Marshal(oldFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return &EntityRevision{At: time.Now(), Action: action, Changes: changes, Before: snapshot}, nil
+}
+
+// appendRevision appends rev to revisions, keeping the last limit of them.
+func appendRevision(revisions []EntityRevision, rev EntityRevision, limit int) []EntityRevision {
+	revisions = append(revisions, rev)
+	if len(revisions) > limit {
+		revisions = revisions[len(revisions)-limit:]
+	}
+	return revisions
+}
+
+// recordFields returns the JSON fields of a record.
+func recordFields(record any) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record: %w", err)
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+	}
+	return fields, nil
+}
+
+// fieldChanges lists the fields that differ between two records, by name.
+func fieldChanges(oldFields, newFields map[string]json.RawMessage) []FieldChange {
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+
+	var changes []FieldChange
+	for name := range names {
+		if historyIgnoredFields[name] || bytes.Equal(oldFields[name], newFields[name]) {
+			continue
+		}
+		change := FieldChange{Field: name, Old: fieldValue(oldFields[name]), New: fieldValue(newFields[name])}
+		if secretFields[name] {
+			change.Old, change.New = Redacted, Redacted
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// fieldValue renders a JSON value for a FieldChange.
+func fieldValue(raw json.RawMessage) string {
+	if raw == nil {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// deleteHistory removes the history of an entity within tx.
+func deleteHistory(tx *bbolt.Tx, entityID []byte) error {
+	return tx.Bucket([]byte(BucketHistory)).Delete(entityID)
+}
+
+// EntityHistory returns the recorded revisions of a server or node, oldest
+// first; none when it was never changed.
+func (sm *StorageManager) EntityHistory(entityID string) ([]EntityRevision, error) {
+	var revisions []EntityRevision
+	err := sm.view(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(BucketHistory)).Get([]byte(entityID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &revisions)
+	})
+	return revisions, err
+}
+
+// NodeHistory returns the recorded revisions of a node, oldest first.
+func (vnm *VirtualNetworkManager) NodeHistory(networkName, nodeName string) ([]EntityRevision, error) {
+	node, err := vnm.GetNode(networkName, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.EntityHistory(node.ID)
+}
+
+// ServerHistory returns the recorded revisions of a server, oldest first.
+func (vnm *VirtualNetworkManager) ServerHistory(networkName, serverName string) ([]EntityRevision, error) {
+	server, err := vnm.GetServer(networkName, serverName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.EntityHistory(server.ID)
+}
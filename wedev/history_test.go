@@ -0,0 +1,203 @@
+package wedev
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+func TestNodeHistory(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("hist", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("hist", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("hist", "laptop", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	if history, err := vnm.NodeHistory("hist", "laptop"); err != nil || len(history) != 0 {
+		t.Fatalf("NodeHistory() before any change = %v, %v; want none", history, err)
+	}
+
+	if _, err := vnm.UpdateNode("hist", "laptop", "5.6.7.8", 51821, NodeTypeRoute); err != nil {
+		t.Fatalf("UpdateNode() error = %v", err)
+	}
+	if _, err := vnm.RenameNode("hist", "laptop", "desk"); err != nil {
+		t.Fatalf("RenameNode() error = %v", err)
+	}
+	keys, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+	if _, err := vnm.ImportNodeKeys("hist", "desk", keys); err != nil {
+		t.Fatalf("ImportNodeKeys() error = %v", err)
+	}
+
+	history, err := vnm.NodeHistory("hist", "desk")
+	if err != nil {
+		t.Fatalf("NodeHistory() error = %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("NodeHistory() = %d revisions, want 3", len(history))
+	}
+
+	if history[0].Action != HistoryUpdate || !reflect.DeepEqual(history[0].Changes, []FieldChange{
+		{Field: "port", Old: "51820", New: "51821"},
+		{Field: "public_address", Old: "1.2.3.4", New: "5.6.7.8"},
+		{Field: "type", Old: "peer", New: "route"},
+	}) {
+		t.Errorf("update revision = %s %+v, want port, public_address and type changes", history[0].Action, history[0].Changes)
+	}
+	if history[1].Action != HistoryRename || !reflect.DeepEqual(history[1].Changes, []FieldChange{{Field: "name", Old: "laptop", New: "desk"}}) {
+		t.Errorf("rename revision = %s %+v, want name laptop -> desk", history[1].Action, history[1].Changes)
+	}
+	if history[2].Action != HistoryRekey || !reflect.DeepEqual(history[2].Changes, []FieldChange{
+		{Field: "private_key", Old: Redacted, New: Redacted},
+		{Field: "public_key", Old: history[2].Changes[1].Old, New: keys.PublicKey},
+	}) {
+		t.Errorf("rekey revision = %s %+v, want redacted private key and the new public key", history[2].Action, history[2].Changes)
+	}
+
+	// Snapshots hold the record before the change, without its private key.
+	before := &Node{}
+	if err := json.Unmarshal(history[1].Before, before); err != nil {
+		t.Fatalf("unmarshal snapshot error = %v", err)
+	}
+	if before.Name != "laptop" || before.PublicAddress != "5.6.7.8" || before.PrivateKey != "" {
+		t.Errorf("rename snapshot = %+v, want laptop at 5.6.7.8 without a private key", before)
+	}
+
+	// An update that changes nothing is not recorded.
+	if _, err := vnm.UpdateNode("hist", "desk", "5.6.7.8", 51821, NodeTypeRoute); err != nil {
+		t.Fatalf("UpdateNode() error = %v", err)
+	}
+	if history, _ := vnm.NodeHistory("hist", "desk"); len(history) != 3 {
+		t.Errorf("NodeHistory() after a no-op update = %d revisions, want 3", len(history))
+	}
+
+	if _, err := vnm.NodeHistory("hist", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("NodeHistory(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestServerHistory(t *testing.T) {
+	vnm, storage := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("hist", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("hist", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+
+	if _, err := vnm.UpdateServer("hist", "", "vpn2.example.com", 51820); err != nil {
+		t.Fatalf("UpdateServer() error = %v", err)
+	}
+	if _, err := vnm.RenameServer("hist", "", "gateway"); err != nil {
+		t.Fatalf("RenameServer() error = %v", err)
+	}
+
+	history, err := vnm.ServerHistory("hist", "")
+	if err != nil {
+		t.Fatalf("ServerHistory() error = %v", err)
+	}
+	if len(history) != 2 ||
+		!reflect.DeepEqual(history[0].Changes, []FieldChange{{Field: "public_address", Old: "vpn.example.com", New: "vpn2.example.com"}}) ||
+		!reflect.DeepEqual(history[1].Changes, []FieldChange{{Field: "name", Old: "hub", New: "gateway"}}) {
+		t.Fatalf("ServerHistory() = %+v, want the address change and the rename", history)
+	}
+
+	// Deleting the server deletes its history with it.
+	if err := vnm.DeleteServer("hist", DeleteServerOptions{Name: "gateway"}); err != nil {
+		t.Fatalf("DeleteServer() error = %v", err)
+	}
+	if history, err := storage.EntityHistory(server.ID); err != nil || len(history) != 0 {
+		t.Errorf("EntityHistory() after delete = %v, %v; want none", history, err)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	sm, err := NewStorageManagerWithOptions(filepath.Join(t.TempDir(), "test.db"), StorageOptions{HistoryLimit: 3})
+	if err != nil {
+		t.Fatalf("NewStorageManagerWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	if _, err := vnm.CreateVirtualNetwork("hist", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("hist", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+
+	for port := 51821; port <= 51825; port++ {
+		if _, err := vnm.UpdateServer("hist", "hub", "vpn.example.com", port); err != nil {
+			t.Fatalf("UpdateServer(%d) error = %v", port, err)
+		}
+	}
+
+	history, err := vnm.ServerHistory("hist", "hub")
+	if err != nil {
+		t.Fatalf("ServerHistory() error = %v", err)
+	}
+	var ports []string
+	for _, rev := range history {
+		ports = append(ports, rev.Changes[0].New)
+	}
+	if got := strings.Join(ports, ","); got != "51823,51824,51825" {
+		t.Errorf("kept revisions set ports %s, want the last 3: 51823,51824,51825", got)
+	}
+}
+
+func TestHistoryRetentionSetting(t *testing.T) {
+	sm, err := NewStorageManagerWithOptions(filepath.Join(t.TempDir(), "test.db"), StorageOptions{HistoryLimit: 3})
+	if err != nil {
+		t.Fatalf("NewStorageManagerWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+
+	for name, storage := range map[string]Storage{
+		"bolt":   sm,
+		"memory": NewMemoryStorageWithOptions(StorageOptions{HistoryLimit: 3}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			vnm, err := NewVirtualNetworkManager(storage, util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+			if _, err := vnm.CreateVirtualNetwork("hist", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.SetNetworkSetting("hist", SettingHistoryRetention, "2", false); err != nil {
+				t.Fatalf("SetNetworkSetting() error = %v", err)
+			}
+			if _, err := vnm.CreateServer("hist", "hub", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			for port := 51821; port <= 51825; port++ {
+				if _, err := vnm.UpdateServer("hist", "hub", "vpn.example.com", port); err != nil {
+					t.Fatalf("UpdateServer(%d) error = %v", port, err)
+				}
+			}
+
+			history, err := vnm.ServerHistory("hist", "hub")
+			if err != nil {
+				t.Fatalf("ServerHistory() error = %v", err)
+			}
+			if len(history) != 2 {
+				t.Errorf("kept %d revisions, want the network's history-retention of 2", len(history))
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wedevctl/util"
+)
+
+// WireGuardConfigFile is a WireGuard config file to import. The base name
+// of Path without ".conf" names the server or node it configures.
+type WireGuardConfigFile struct {
+	Path    string
+	Content string
+}
+
+// ImportOptions controls how ImportWireGuard builds a network.
+type ImportOptions struct {
+	CIDR string // network CIDR; empty infers it from the interface addresses
+	// ServerAddress is the server's public address; empty takes it from the
+	// Endpoint the node configs give for the server.
+	ServerAddress string
+	Server        WireGuardConfigFile
+	Nodes         []WireGuardConfigFile
+}
+
+// ImportWireGuard creates network name from existing WireGuard configs: a
+// server's and those of its nodes. Keys, interface addresses, listen ports
+// and endpoints are kept, and the IP pool starts with every address
+// allocated. Nodes are the server's peers, matched by public key; a node is
+// a peer node when the server has an Endpoint for it and a route node
+// otherwise, routing the AllowedIPs the server has for it beyond the network.
+// The CIDR is inferred from the interface addresses unless opts.CIDR is set.
+//
+// The files are checked before anything is written. Every problem found,
+// such as an interface with several addresses or a peer matching none of the
+// configs, is reported in one ErrValidation error, each with its file and
+// line. If creating the network fails part way, it is deleted again.
+func (vnm *VirtualNetworkManager) ImportWireGuard(name string, opts ImportOptions) (*VirtualNetwork, error) {
+	return vnm.ImportWireGuardCtx(context.Background(), name, opts)
+}
+
+// ImportWireGuardCtx is ImportWireGuard with a context, checked before each
+// server and node is created.
+func (vnm *VirtualNetworkManager) ImportWireGuardCtx(ctx context.Context, name string, opts ImportOptions) (*VirtualNetwork, error) {
+	if err := vnm.validator.IsValidNetworkName(name); err != nil {
+		return nil, err
+	}
+	if reservedNetworkNames[name] {
+		return nil, fmt.Errorf("network name %q is reserved (it collides with a CLI command)", name)
+	}
+
+	plan, err := vnm.planImport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.CreateNetworkCtx(ctx, name, plan.cidr.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := vnm.importInto(ctx, network, plan); err != nil {
+		if delErr := vnm.storage.DeleteNetwork(name); delErr != nil {
+			return nil, fmt.Errorf("failed to import network: %w (and failed to remove the partial import: %v)", err, delErr)
+		}
+		return nil, fmt.Errorf("failed to import network: %w", err)
+	}
+
+	return vnm.storage.GetNetworkByNameCtx(ctx, name)
+}
+
+// importedInterface is the [Interface] of an imported config, with the
+// [Peer] sections of the file.
+type importedInterface struct {
+	file        string
+	name        string
+	keys        *util.WireGuardKeyPair
+	address     netip.Prefix // as written, e.g. 10.0.0.2/24
+	addressLine int
+	port        int // ListenPort, 0 when absent
+	peers       []importedPeer
+}
+
+// importedPeer is a [Peer] section of an imported config.
+type importedPeer struct {
+	line       int
+	publicKey  string
+	host       string // Endpoint host, empty when absent
+	port       int    // Endpoint port
+	endpointAt int    // line of the Endpoint
+	allowedIPs []netip.Prefix
+}
+
+// importedNode is a node about to be created.
+type importedNode struct {
+	*importedInterface
+	nodeType      NodeType
+	publicAddress string
+	port          int
+	routedCIDRs   []string
+}
+
+// importPlan is what ImportWireGuard creates, once the files check out.
+type importPlan struct {
+	cidr          netip.Prefix
+	server        *importedInterface
+	serverAddress string
+	serverPort    int
+	nodes         []importedNode
+	pool          *util.IPPool
+}
+
+// importProblems collects what is wrong with the imported files.
+type importProblems []string
+
+// add records a problem at a line of file; line 0 stands for the whole file.
+func (p *importProblems) add(file string, line int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if line > 0 {
+		*p = append(*p, fmt.Sprintf("%s: line %d: %s", file, line, msg))
+		return
+	}
+	*p = append(*p, fmt.Sprintf("%s: %s", file, msg))
+}
+
+// err returns the problems as one ErrValidation error, or nil.
+func (p importProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return kindErrorf(ErrValidation, "cannot import the WireGuard configs:\n  %s", strings.Join(p, "\n  "))
+}
+
+// planImport parses and cross-checks the files of an import.
+func (vnm *VirtualNetworkManager) planImport(opts ImportOptions) (*importPlan, error) {
+	var problems importProblems
+	server := vnm.parseImportedInterface(opts.Server, &problems)
+	nodes := make([]*importedInterface, 0, len(opts.Nodes))
+	for _, file := range opts.Nodes {
+		if node := vnm.parseImportedInterface(file, &problems); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	if server == nil {
+		return nil, problems.err()
+	}
+
+	// Entities are told apart by name and public key.
+	names := map[string]string{server.name: server.file}
+	keys := map[string]*importedInterface{server.keys.PublicKey: server}
+	for _, node := range nodes {
+		if other, ok := names[node.name]; ok {
+			problems.add(node.file, 0, "name %q is also taken by %s", node.name, other)
+		}
+		names[node.name] = node.file
+		if other, ok := keys[node.keys.PublicKey]; ok {
+			problems.add(node.file, 0, "has the same private key as %s", other.file)
+		}
+		keys[node.keys.PublicKey] = node
+	}
+
+	// The server's peers are the nodes.
+	plan := &importPlan{server: server, serverAddress: opts.ServerAddress, serverPort: server.port}
+	ofNode := make(map[*importedInterface]*importedPeer, len(nodes))
+	for i := range server.peers {
+		peer := &server.peers[i]
+		node, ok := keys[peer.publicKey]
+		if !ok {
+			problems.add(server.file, peer.line, "peer %s matches none of the node configs", peer.publicKey)
+			continue
+		}
+		if node == server {
+			problems.add(server.file, peer.line, "peer %s is the server itself", peer.publicKey)
+			continue
+		}
+		if first, ok := ofNode[node]; ok {
+			problems.add(server.file, peer.line, "peer %s of %s is also on line %d", peer.publicKey, node.file, first.line)
+			continue
+		}
+		ofNode[node] = peer
+	}
+
+	// The nodes' peers are the server, which gives its endpoint, and each
+	// other.
+	type endpoint struct {
+		host string
+		port int
+		file string
+		line int
+	}
+	var endpoints []endpoint
+	for _, node := range nodes {
+		if ofNode[node] == nil {
+			problems.add(node.file, 0, "public key %s is not a peer in %s", node.keys.PublicKey, server.file)
+		}
+		for _, peer := range node.peers {
+			other, ok := keys[peer.publicKey]
+			switch {
+			case !ok:
+				problems.add(node.file, peer.line, "peer %s matches none of the configs", peer.publicKey)
+			case other == server && peer.host != "":
+				endpoints = append(endpoints, endpoint{peer.host, peer.port, node.file, peer.endpointAt})
+			}
+		}
+	}
+	if plan.serverAddress == "" {
+		for _, e := range endpoints {
+			if plan.serverAddress == "" {
+				plan.serverAddress = e.host
+			} else if e.host != plan.serverAddress {
+				problems.add(e.file, e.line, "server endpoint %s differs from %s in %s line %d", e.host, plan.serverAddress, endpoints[0].file, endpoints[0].line)
+			}
+		}
+		if plan.serverAddress == "" {
+			problems.add(server.file, 0, "no node config has an Endpoint for the server; give its public address")
+		}
+	}
+	if plan.serverPort == 0 && len(endpoints) > 0 {
+		plan.serverPort = endpoints[0].port
+	}
+
+	plan.cidr = vnm.importCIDR(opts.CIDR, server, nodes, &problems)
+	if len(problems) > 0 {
+		return nil, problems.err()
+	}
+	if plan.serverAddress != "" {
+		if err := vnm.validatePublicAddress(plan.cidr.String(), plan.serverAddress); err != nil {
+			problems.add(server.file, 0, "server address: %v", err)
+		}
+	}
+
+	// Every address must be a host address of the network, used once.
+	pool, err := util.NewIPPool(plan.cidr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IP pool: %w", err)
+	}
+	broadcast := lastAddr(plan.cidr)
+	for _, entity := range append([]*importedInterface{server}, nodes...) {
+		addr := entity.address.Addr()
+		if !plan.cidr.Contains(addr) || addr == plan.cidr.Addr() || addr == broadcast {
+			problems.add(entity.file, entity.addressLine, "address %s is not a host address of %s", addr, plan.cidr)
+			continue
+		}
+		if err := pool.MarkIPAllocated(addr.String()); err != nil {
+			problems.add(entity.file, entity.addressLine, "%v", err)
+		}
+	}
+	pool.SyncNextIndex()
+	plan.pool = pool
+
+	for _, node := range nodes {
+		planned := importedNode{importedInterface: node, nodeType: NodeTypeRoute, port: node.port}
+		if peer := ofNode[node]; peer != nil {
+			for _, allowed := range peer.allowedIPs {
+				if !allowed.Overlaps(plan.cidr) {
+					planned.routedCIDRs = append(planned.routedCIDRs, allowed.Masked().String())
+				}
+			}
+			if peer.host != "" {
+				planned.publicAddress = peer.host
+				planned.port = peer.port
+				if err := vnm.validatePublicAddress(plan.cidr.String(), peer.host); err != nil {
+					problems.add(server.file, peer.endpointAt, "endpoint of %s: %v", node.name, err)
+				}
+				if len(planned.routedCIDRs) == 0 {
+					planned.nodeType = NodeTypePeer
+				}
+			}
+		}
+		plan.nodes = append(plan.nodes, planned)
+	}
+
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// importCIDR returns the network CIDR of an import: the given one, or else
+// the network of the interface addresses, which must agree on it. Addresses
+// written as /32 say nothing about the network and are left out.
+func (vnm *VirtualNetworkManager) importCIDR(cidr string, server *importedInterface, nodes []*importedInterface, problems *importProblems) netip.Prefix {
+	if cidr != "" {
+		if err := vnm.validator.IsValidCIDR(cidr); err != nil {
+			problems.add("--cidr", 0, "%v", err)
+			return netip.Prefix{}
+		}
+		//nolint:errcheck // IsValidCIDR parsed it
+		prefix, _ := netip.ParsePrefix(cidr)
+		return prefix.Masked()
+	}
+
+	sources := make(map[netip.Prefix][]string)
+	for _, entity := range append([]*importedInterface{server}, nodes...) {
+		if entity.address.Bits() < 32 {
+			network := entity.address.Masked()
+			sources[network] = append(sources[network], fmt.Sprintf("%s line %d", entity.file, entity.addressLine))
+		}
+	}
+	switch len(sources) {
+	case 0:
+		problems.add(server.file, 0, "cannot infer the network CIDR: no interface address has a prefix length below /32; give the CIDR")
+		return netip.Prefix{}
+	case 1:
+		for network := range sources {
+			if err := vnm.validator.IsValidCIDR(network.String()); err != nil {
+				problems.add(server.file, server.addressLine, "network %s: %v", network, err)
+			}
+			return network
+		}
+	}
+	found := make([]string, 0, len(sources))
+	for network, at := range sources {
+		found = append(found, fmt.Sprintf("%s (%s)", network, strings.Join(at, ", ")))
+	}
+	sort.Strings(found)
+	problems.add(server.file, 0, "cannot infer the network CIDR: the interface addresses are in %s; give the CIDR", strings.Join(found, " and "))
+	return netip.Prefix{}
+}
+
+// parseImportedInterface parses one config file, recording its problems.
+// It returns nil when the file has no usable [Interface].
+func (vnm *VirtualNetworkManager) parseImportedInterface(file WireGuardConfigFile, problems *importProblems) *importedInterface {
+	entity := &importedInterface{file: file.Path, name: strings.TrimSuffix(filepath.Base(file.Path), ".conf")}
+	sections, err := util.ParseWireGuardConfig(file.Content)
+	if err != nil {
+		problems.add(file.Path, 0, "%v", err)
+		return nil
+	}
+	if err := vnm.validator.IsValidNetworkName(entity.name); err != nil {
+		problems.add(file.Path, 0, "name %q, taken from the file name: %v", entity.name, err)
+	}
+
+	var iface *util.WireGuardConfigSection
+	for i := range sections {
+		section := &sections[i]
+		if section.Name == "Peer" {
+			if peer, ok := parseImportedPeer(file.Path, section, problems); ok {
+				entity.peers = append(entity.peers, peer)
+			}
+			continue
+		}
+		if iface != nil {
+			problems.add(file.Path, section.Line, "second [Interface] section; the first is on line %d", iface.Line)
+			continue
+		}
+		iface = section
+	}
+	if iface == nil {
+		problems.add(file.Path, 0, "no [Interface] section")
+		return nil
+	}
+
+	ok := true
+	privateKey, line, found := singleValue(file.Path, iface, "PrivateKey", true, problems)
+	if !found {
+		ok = false
+	} else if entity.keys, err = util.ParseWireGuardKeys(privateKey, ""); err != nil {
+		problems.add(file.Path, line, "%v", err)
+		ok = false
+	}
+
+	var addresses []string
+	var lines []int
+	for _, entry := range iface.Get("Address") {
+		for _, address := range splitList(entry.Value) {
+			addresses = append(addresses, address)
+			lines = append(lines, entry.Line)
+		}
+	}
+	switch {
+	case len(addresses) == 0:
+		problems.add(file.Path, iface.Line, "[Interface] has no Address")
+		ok = false
+	case len(addresses) > 1:
+		problems.add(file.Path, lines[0], "[Interface] has %d addresses (%s); a wedevctl interface has one",
+			len(addresses), describeAddresses(addresses, lines))
+		ok = false
+	default:
+		entity.addressLine = lines[0]
+		if entity.address, err = parseIPv4Prefix(addresses[0]); err != nil {
+			problems.add(file.Path, entity.addressLine, "%v", err)
+			ok = false
+		}
+	}
+
+	if value, line, found := singleValue(file.Path, iface, "ListenPort", false, problems); found {
+		port, err := strconv.Atoi(value)
+		if err == nil {
+			err = util.ValidatePort(port)
+		}
+		if err != nil {
+			problems.add(file.Path, line, "invalid ListenPort %q", value)
+		}
+		entity.port = port
+	}
+
+	if !ok {
+		return nil
+	}
+	return entity
+}
+
+// parseImportedPeer parses a [Peer] section.
+func parseImportedPeer(file string, section *util.WireGuardConfigSection, problems *importProblems) (importedPeer, bool) {
+	peer := importedPeer{line: section.Line}
+	publicKey, line, found := singleValue(file, section, "PublicKey", true, problems)
+	if !found {
+		return peer, false
+	}
+	if err := util.ValidateWireGuardKey(publicKey); err != nil {
+		problems.add(file, line, "%v", err)
+		return peer, false
+	}
+	peer.publicKey = publicKey
+
+	if value, line, found := singleValue(file, section, "Endpoint", false, problems); found {
+		host, port, err := net.SplitHostPort(value)
+		if err == nil {
+			peer.port, err = strconv.Atoi(port)
+		}
+		if err != nil || host == "" {
+			problems.add(file, line, "invalid Endpoint %q: expected host:port", value)
+		} else {
+			peer.host, peer.endpointAt = host, line
+		}
+	}
+
+	for _, entry := range section.Get("AllowedIPs") {
+		for _, value := range splitList(entry.Value) {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				problems.add(file, entry.Line, "invalid AllowedIPs entry %q", value)
+				continue
+			}
+			// IPv6 routes have no place in an IPv4 network; wg-quick
+			// configs commonly carry ::/0 next to 0.0.0.0/0.
+			if prefix.Addr().Is4() {
+				peer.allowedIPs = append(peer.allowedIPs, prefix)
+			}
+		}
+	}
+	return peer, true
+}
+
+// singleValue returns the value and line of a key that may appear at most
+// once in a section; a missing key is a problem when it is required.
+func singleValue(file string, section *util.WireGuardConfigSection, key string, required bool, problems *importProblems) (string, int, bool) {
+	entries := section.Get(key)
+	switch {
+	case len(entries) == 0:
+		if required {
+			problems.add(file, section.Line, "[%s] has no %s", section.Name, key)
+		}
+		return "", 0, false
+	case len(entries) > 1:
+		problems.add(file, entries[1].Line, "%s is given again; the first is on line %d", key, entries[0].Line)
+	}
+	return entries[0].Value, entries[0].Line, true
+}
+
+// describeAddresses lists addresses with the line of each, e.g.
+// "10.0.0.1/24 and fd00::1/64 on line 3".
+func describeAddresses(addresses []string, lines []int) string {
+	var parts []string
+	for i := 0; i < len(addresses); {
+		j := i
+		for j < len(addresses) && lines[j] == lines[i] {
+			j++
+		}
+		parts = append(parts, fmt.Sprintf("%s on line %d", strings.Join(addresses[i:j], " and "), lines[i]))
+		i = j
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitList splits a comma-separated config value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIPv4Prefix parses an interface address; one without a prefix length
+// is a /32.
+func parseIPv4Prefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(s)
+		if addrErr != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if !prefix.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("address %s is not IPv4; wedevctl networks are IPv4", s)
+	}
+	return prefix, nil
+}
+
+// lastAddr returns the broadcast address of an IPv4 network.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	v := ipv4Value(prefix.Masked().Addr()) | (uint32(1)<<(32-prefix.Bits()) - 1)
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// importInto creates the server and nodes of a plan in the newly created
+// network, saving the pool state with each.
+func (vnm *VirtualNetworkManager) importInto(ctx context.Context, network *VirtualNetwork, plan *importPlan) error {
+	state := plan.pool.GetState()
+	server := plan.server
+	if _, err := vnm.storage.CreateServerWithPoolState(network.ID, server.name, plan.serverAddress, portOrDefault(plan.serverPort, DefaultWireGuardPort),
+		server.address.Addr().String(), server.keys.PrivateKey, server.keys.PublicKey, state); err != nil {
+		return fmt.Errorf("server %s: %w", server.name, err)
+	}
+
+	for _, node := range plan.nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		routed, err := vnm.validateRoutedCIDRs(network, "", node.routedCIDRs)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.name, err)
+		}
+		created, err := vnm.storage.CreateNodeWithPoolState(network.ID, node.name, node.publicAddress, portOrDefault(node.port, DefaultWireGuardPort),
+			node.address.Addr().String(), node.nodeType, node.keys.PrivateKey, node.keys.PublicKey, state)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.name, err)
+		}
+		if len(routed) > 0 {
+			if err := vnm.storage.UpdateNodeRoutedCIDRs(created.ID, routed); err != nil {
+				return fmt.Errorf("node %s: %w", node.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// portOrDefault returns port, or def when it is 0.
+func portOrDefault(port, def int) int {
+	if port == 0 {
+		return def
+	}
+	return port
+}
@@ -0,0 +1,251 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// importFixture holds the configs of a hand-run WireGuard network: server
+// wg0 with peer node laptop and route node branch, which routes a LAN.
+type importFixture struct {
+	server, laptop, branch *util.WireGuardKeyPair
+}
+
+func newImportFixture(t *testing.T) *importFixture {
+	t.Helper()
+	f := &importFixture{}
+	for _, keys := range []**util.WireGuardKeyPair{&f.server, &f.laptop, &f.branch} {
+		var err error
+		if *keys, err = util.GenerateWireGuardKeys(); err != nil {
+			t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+		}
+	}
+	return f
+}
+
+func (f *importFixture) files() ImportOptions {
+	return ImportOptions{
+		Server: WireGuardConfigFile{Path: "conf/wg0.conf", Content: fmt.Sprintf(`[Interface]
+Address = 10.5.0.1/24
+ListenPort = 51820
+PrivateKey = %s
+
+# laptop
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.5.0.7/32
+Endpoint = 203.0.113.7:51821
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.5.0.3/32, 192.168.10.0/24
+`, f.server.PrivateKey, f.laptop.PublicKey, f.branch.PublicKey)},
+		Nodes: []WireGuardConfigFile{
+			{Path: "laptop.conf", Content: fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.5.0.7/32
+ListenPort = 51821
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.5.0.0/24
+Endpoint = vpn.example.com:51820
+`, f.laptop.PrivateKey, f.server.PublicKey)},
+			{Path: "branch.conf", Content: fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.5.0.3/24
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.5.0.0/24
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = 25
+`, f.branch.PrivateKey, f.server.PublicKey)},
+		},
+	}
+}
+
+func TestImportWireGuard(t *testing.T) {
+	vnm, storage := newTestManager(t)
+	f := newImportFixture(t)
+
+	network, err := vnm.ImportWireGuard("office", f.files())
+	if err != nil {
+		t.Fatalf("ImportWireGuard() error = %v", err)
+	}
+	if network.CIDR != "10.5.0.0/24" {
+		t.Errorf("CIDR = %s, want 10.5.0.0/24 inferred from the addresses", network.CIDR)
+	}
+
+	server, err := vnm.GetServer("office", "wg0")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+	if server.VirtualIP != "10.5.0.1" || server.PublicAddress != "vpn.example.com" || server.Port != 51820 ||
+		server.PrivateKey != f.server.PrivateKey || server.PublicKey != f.server.PublicKey {
+		t.Errorf("server = %+v, want 10.5.0.1 at vpn.example.com:51820 with its keys", server)
+	}
+
+	laptop, err := vnm.GetNode("office", "laptop")
+	if err != nil {
+		t.Fatalf("GetNode(laptop) error = %v", err)
+	}
+	if laptop.Type != NodeTypePeer || laptop.VirtualIP != "10.5.0.7" || laptop.PublicAddress != "203.0.113.7" ||
+		laptop.Port != 51821 || laptop.PrivateKey != f.laptop.PrivateKey {
+		t.Errorf("laptop = %+v, want peer 10.5.0.7 at 203.0.113.7:51821 with its key", laptop)
+	}
+	branch, err := vnm.GetNode("office", "branch")
+	if err != nil {
+		t.Fatalf("GetNode(branch) error = %v", err)
+	}
+	if branch.Type != NodeTypeRoute || branch.VirtualIP != "10.5.0.3" || branch.PublicAddress != "" ||
+		!reflect.DeepEqual(branch.RoutedCIDRs, []string{"192.168.10.0/24"}) {
+		t.Errorf("branch = %+v, want route node 10.5.0.3 routing 192.168.10.0/24", branch)
+	}
+
+	// The pool holds every imported node address (the first one is the
+	// server's anyway), so new nodes get free ones.
+	state, err := storage.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	for _, ip := range []string{"10.5.0.3", "10.5.0.7"} {
+		if !slices.Contains(state.Allocated, ip) {
+			t.Errorf("pool state allocated = %v, want %s in it", state.Allocated, ip)
+		}
+	}
+	node, err := vnm.CreateNode("office", "extra", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if node.VirtualIP != "10.5.0.8" {
+		t.Errorf("new node got %s, want 10.5.0.8 past the imported addresses", node.VirtualIP)
+	}
+
+	if _, err := vnm.ImportWireGuard("office", f.files()); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("importing over an existing network error = %v, want ErrAlreadyExists", err)
+	}
+}
+
+func TestImportWireGuard_Options(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	f := newImportFixture(t)
+
+	opts := f.files()
+	opts.CIDR = "10.5.0.0/16"
+	opts.ServerAddress = "198.51.100.1"
+	network, err := vnm.ImportWireGuard("office", opts)
+	if err != nil {
+		t.Fatalf("ImportWireGuard() error = %v", err)
+	}
+	if network.CIDR != "10.5.0.0/16" {
+		t.Errorf("CIDR = %s, want the given 10.5.0.0/16", network.CIDR)
+	}
+	if server, _ := vnm.GetServer("office", "wg0"); server == nil || server.PublicAddress != "198.51.100.1" {
+		t.Errorf("server = %+v, want the given public address", server)
+	}
+}
+
+func TestImportWireGuard_Problems(t *testing.T) {
+	f := newImportFixture(t)
+	stranger, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(opts *ImportOptions)
+		want   []string
+	}{
+		{
+			name: "several interface addresses",
+			modify: func(opts *ImportOptions) {
+				opts.Server.Content = strings.Replace(opts.Server.Content, "10.5.0.1/24", "10.5.0.1/24, fd00::1/64", 1)
+			},
+			want: []string{"conf/wg0.conf: line 2: [Interface] has 2 addresses (10.5.0.1/24 and fd00::1/64 on line 2)"},
+		},
+		{
+			name: "unknown peers",
+			modify: func(opts *ImportOptions) {
+				opts.Server.Content += "\n[Peer]\nPublicKey = " + stranger.PublicKey + "\n"
+				opts.Nodes[0].Content += "\n[Peer]\nPublicKey = " + stranger.PublicKey + "\n"
+			},
+			want: []string{
+				"conf/wg0.conf: line 16: peer " + stranger.PublicKey + " matches none of the node configs",
+				"laptop.conf: line 11: peer " + stranger.PublicKey + " matches none of the configs",
+			},
+		},
+		{
+			name: "node missing from the server",
+			modify: func(opts *ImportOptions) {
+				opts.Nodes = append(opts.Nodes, nodeConf("desk.conf", stranger, "10.5.0.9/24"))
+			},
+			want: []string{"desk.conf: public key " + stranger.PublicKey + " is not a peer in conf/wg0.conf"},
+		},
+		{
+			name: "networks disagree",
+			modify: func(opts *ImportOptions) {
+				opts.Nodes[1].Content = strings.Replace(opts.Nodes[1].Content, "10.5.0.3/24", "10.6.0.3/24", 1)
+			},
+			want: []string{"cannot infer the network CIDR: the interface addresses are in 10.5.0.0/24 (conf/wg0.conf line 2) and 10.6.0.0/24 (branch.conf line 3)"},
+		},
+		{
+			name:   "address outside the given CIDR",
+			modify: func(opts *ImportOptions) { opts.CIDR = "10.5.0.0/29" },
+			want:   []string{"laptop.conf: line 3: address 10.5.0.7 is not a host address of 10.5.0.0/29"},
+		},
+		{
+			name: "no server endpoint",
+			modify: func(opts *ImportOptions) {
+				for i := range opts.Nodes {
+					opts.Nodes[i].Content = strings.ReplaceAll(opts.Nodes[i].Content, "Endpoint = vpn.example.com:51820\n", "")
+				}
+			},
+			want: []string{"conf/wg0.conf: no node config has an Endpoint for the server"},
+		},
+		{
+			name: "malformed file",
+			modify: func(opts *ImportOptions) {
+				opts.Nodes[0].Content = "[Interface]\nPrivateKey\n"
+			},
+			want: []string{"laptop.conf: line 2: expected Key = Value"},
+		},
+		{
+			name:   "entity name",
+			modify: func(opts *ImportOptions) { opts.Server.Path = "wg-office.conf" },
+			want:   []string{`wg-office.conf: name "wg-office", taken from the file name`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vnm, _ := newTestManager(t)
+			opts := f.files()
+			tt.modify(&opts)
+			_, err := vnm.ImportWireGuard("office", opts)
+			if !errors.Is(err, ErrValidation) {
+				t.Fatalf("ImportWireGuard() error = %v, want ErrValidation", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ImportWireGuard() error = %v, want it to contain %q", err, want)
+				}
+			}
+			if _, err := vnm.GetVirtualNetwork("office"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVirtualNetwork() error = %v, want ErrNotFound: nothing is created on problems", err)
+			}
+		})
+	}
+}
+
+// nodeConf returns a node config with only an [Interface].
+func nodeConf(path string, keys *util.WireGuardKeyPair, address string) WireGuardConfigFile {
+	return WireGuardConfigFile{Path: path, Content: fmt.Sprintf("[Interface]\nPrivateKey = %s\nAddress = %s\n", keys.PrivateKey, address)}
+}
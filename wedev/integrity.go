@@ -0,0 +1,634 @@
+package wedev
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// IntegrityRecord identifies one database entry found by CheckIntegrity.
+type IntegrityRecord struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	NetworkID string `json:"network_id,omitempty"`
+	Name      string `json:"name,omitempty"` // server or node name, or config version
+}
+
+// DuplicateVirtualIP is one virtual IP held by several servers or nodes of a
+// network. Holders are listed as "server <name>" or "node <name>", the one
+// that keeps the address first. Reassigned is set by FixIntegrity and maps
+// each other holder to the address it was given.
+type DuplicateVirtualIP struct {
+	NetworkID  string            `json:"network_id"`
+	Network    string            `json:"network"`
+	IP         string            `json:"ip"`
+	Holders    []string          `json:"holders"`
+	Reassigned map[string]string `json:"reassigned,omitempty"`
+}
+
+// DuplicateConfigVersion is a saved config version whose number another
+// version of the same network also has, as versions numbered by scanning the
+// index could end up. The version the index points at keeps the number;
+// RenumberedTo is set by FixIntegrity to the number this one was given.
+type DuplicateConfigVersion struct {
+	NetworkID    string    `json:"network_id"`
+	Network      string    `json:"network"`
+	ConfigID     string    `json:"config_id"`
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	RenumberedTo int       `json:"renumbered_to,omitempty"`
+}
+
+// InvalidNetworkCIDR is a network whose stored CIDR cannot hold an IP pool
+// (see checkNetworkCIDR). FixIntegrity cannot choose a range for it; 'vn
+// edit --cidr' sets one.
+type InvalidNetworkCIDR struct {
+	NetworkID string `json:"network_id"`
+	Network   string `json:"network"`
+	CIDR      string `json:"cidr"`
+}
+
+// IntegrityReport lists the referential integrity problems in a database:
+// index entries that do not resolve to a matching record, servers, nodes,
+// IP pools, revisions and sequences whose network does not exist,
+// deployments whose server or node does not exist, virtual IPs held more
+// than once within a network, config versions whose network does not exist,
+// config version numbers saved more than once, and networks whose CIDR is
+// invalid. Fixed is set by FixIntegrity when it repaired them, all but the
+// invalid CIDRs.
+//
+// ClockSkewedConfigs lists config versions saved with an earlier time than
+// the version before them, after the clock of the machine saving them was
+// turned back. They are not problems: versions are ordered by number, never
+// by time, so they are reported but neither counted nor changed.
+type IntegrityReport struct {
+	OrphanedIndexKeys       []IntegrityRecord        `json:"orphaned_index_keys"`
+	DanglingReferences      []IntegrityRecord        `json:"dangling_references"`
+	DuplicateVirtualIPs     []DuplicateVirtualIP     `json:"duplicate_virtual_ips"`
+	OrphanedConfigs         []IntegrityRecord        `json:"orphaned_configs"`
+	DuplicateConfigVersions []DuplicateConfigVersion `json:"duplicate_config_versions"`
+	InvalidCIDRs            []InvalidNetworkCIDR     `json:"invalid_cidrs"`
+	ClockSkewedConfigs      []IntegrityRecord        `json:"clock_skewed_configs"`
+	Fixed                   bool                     `json:"fixed,omitempty"`
+}
+
+// Problems returns the number of problems in the report.
+func (r *IntegrityReport) Problems() int {
+	return r.Repairable() + len(r.InvalidCIDRs)
+}
+
+// Repairable returns the number of problems FixIntegrity repairs.
+func (r *IntegrityReport) Repairable() int {
+	return len(r.OrphanedIndexKeys) + len(r.DanglingReferences) + len(r.DuplicateVirtualIPs) + len(r.OrphanedConfigs) + len(r.DuplicateConfigVersions)
+}
+
+// CheckIntegrity scans the whole database for referential integrity problems
+// and reports them. It changes nothing.
+func (sm *StorageManager) CheckIntegrity() (*IntegrityReport, error) {
+	var report *IntegrityReport
+	err := sm.view(func(tx *bbolt.Tx) error {
+		var err error
+		report, err = checkIntegrity(tx)
+		return err
+	})
+	return report, err
+}
+
+// FixIntegrity finds the problems CheckIntegrity reports and repairs them in
+// one transaction: orphaned index entries, dangling servers, nodes, IP
+// pools, revisions and sequences, and orphaned config versions with their
+// sequence are deleted;
+// of the holders of a duplicate virtual IP, a server (or else the oldest
+// node) keeps it and the others are given free addresses, after which the
+// network's IP pool state is rebuilt from its records; duplicate config
+// versions are given the next free version numbers. Invalid network CIDRs
+// are left as they are. It returns the problems found.
+func (sm *StorageManager) FixIntegrity() (*IntegrityReport, error) {
+	var report *IntegrityReport
+	err := sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		report, err = checkIntegrity(tx)
+		if err != nil {
+			return err
+		}
+		if report.Repairable() == 0 {
+			return nil
+		}
+		if err := fixIntegrity(tx, report); err != nil {
+			return err
+		}
+		report.Fixed = true
+		return nil
+	})
+	return report, err
+}
+
+// ipHolder is a server or node holding a virtual IP, as seen by
+// checkIntegrity.
+type ipHolder struct {
+	bucket    string
+	label     string // "server <name>" or "node <name>"
+	createdAt time.Time
+}
+
+// checkIntegrity does the work of CheckIntegrity within tx.
+func checkIntegrity(tx *bbolt.Tx) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		OrphanedIndexKeys:   []IntegrityRecord{},
+		DanglingReferences:  []IntegrityRecord{},
+		DuplicateVirtualIPs: []DuplicateVirtualIP{},
+		OrphanedConfigs:     []IntegrityRecord{},
+	}
+
+	for _, c := range indexChecks {
+		stale, err := staleIndexKeys(tx.Bucket([]byte(c.index)), tx.Bucket([]byte(c.primary)), c.keyOf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check index %s: %w", c.index, err)
+		}
+		for _, k := range stale {
+			report.OrphanedIndexKeys = append(report.OrphanedIndexKeys, IntegrityRecord{Bucket: c.index, Key: string(k)})
+		}
+	}
+
+	networks, err := loadNetworks(tx)
+	if err != nil {
+		return nil, err
+	}
+	report.InvalidCIDRs = invalidCIDRs(networks)
+
+	// holders groups the live servers and nodes by network and virtual IP.
+	holders := make(map[string]map[string][]ipHolder)
+	entities := make(map[string]bool)
+	addHolder := func(networkID, ip string, h ipHolder) {
+		if holders[networkID] == nil {
+			holders[networkID] = make(map[string][]ipHolder)
+		}
+		holders[networkID][ip] = append(holders[networkID][ip], h)
+	}
+
+	if err := tx.Bucket([]byte(BucketServers)).ForEach(func(k, v []byte) error {
+		server := &Server{}
+		if err := json.Unmarshal(v, server); err != nil {
+			return fmt.Errorf("failed to decode server %s: %w", k, err)
+		}
+		if networks[server.NetworkID] == nil {
+			report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketServers, Key: string(k), NetworkID: server.NetworkID, Name: server.Name})
+			return nil
+		}
+		entities[server.ID] = true
+		addHolder(server.NetworkID, server.VirtualIP, ipHolder{BucketServers, "server " + server.Name, server.CreatedAt})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Bucket([]byte(BucketNodes)).ForEach(func(k, v []byte) error {
+		node := &Node{}
+		if err := json.Unmarshal(v, node); err != nil {
+			return fmt.Errorf("failed to decode node %s: %w", k, err)
+		}
+		if networks[node.NetworkID] == nil {
+			report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketNodes, Key: string(k), NetworkID: node.NetworkID, Name: node.Name})
+			return nil
+		}
+		entities[node.ID] = true
+		addHolder(node.NetworkID, node.VirtualIP, ipHolder{BucketNodes, "node " + node.Name, node.CreatedAt})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Bucket([]byte(BucketIPPools)).ForEach(func(k, _ []byte) error {
+		if networks[string(k)] == nil {
+			report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketIPPools, Key: string(k), NetworkID: string(k)})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if revisions := tx.Bucket([]byte(BucketRevisions)); revisions != nil {
+		if err := revisions.ForEach(func(k, _ []byte) error {
+			if networks[string(k)] == nil {
+				report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketRevisions, Key: string(k), NetworkID: string(k)})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if deployments := tx.Bucket([]byte(BucketDeployments)); deployments != nil {
+		if err := deployments.ForEach(func(k, _ []byte) error {
+			if !entities[string(k)] {
+				report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketDeployments, Key: string(k)})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if history := tx.Bucket([]byte(BucketHistory)); history != nil {
+		if err := history.ForEach(func(k, _ []byte) error {
+			if !entities[string(k)] {
+				report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketHistory, Key: string(k)})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// A tag is dangling when its network or the version it names is gone.
+	if tags := tx.Bucket([]byte(BucketTags)); tags != nil {
+		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+		if err := tags.ForEach(func(k, v []byte) error {
+			networkID, _, _ := strings.Cut(string(k), ":")
+			version, err := strconv.Atoi(string(v))
+			if networks[networkID] == nil || err != nil || configsByVer.Get([]byte(networkID+":"+padVersion(version))) == nil {
+				report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketTags, Key: string(k), NetworkID: networkID})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Bucket([]byte(BucketConfigs)).ForEach(func(k, v []byte) error {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(v, config); err != nil {
+			return fmt.Errorf("failed to decode config %s: %w", k, err)
+		}
+		if networks[config.NetworkID] == nil {
+			report.OrphanedConfigs = append(report.OrphanedConfigs, IntegrityRecord{Bucket: BucketConfigs, Key: string(k), NetworkID: config.NetworkID, Name: fmt.Sprintf("v%d", config.Version)})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if report.DuplicateConfigVersions, report.ClockSkewedConfigs, err = checkConfigVersions(tx, networks); err != nil {
+		return nil, err
+	}
+
+	// The sequence of a missing network goes with its orphaned configs, if
+	// it has any; otherwise it is dangling.
+	if sequences := tx.Bucket([]byte(BucketSequences)); sequences != nil {
+		orphaned := make(map[string]bool, len(report.OrphanedConfigs))
+		for _, rec := range report.OrphanedConfigs {
+			orphaned[rec.NetworkID] = true
+		}
+		if err := sequences.ForEach(func(k, _ []byte) error {
+			if networks[string(k)] == nil && !orphaned[string(k)] {
+				report.DanglingReferences = append(report.DanglingReferences, IntegrityRecord{Bucket: BucketSequences, Key: string(k), NetworkID: string(k)})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for networkID, byIP := range holders {
+		for ip, hs := range byIP {
+			if len(hs) < 2 {
+				continue
+			}
+			// Servers keep their address over nodes; otherwise the oldest
+			// holder keeps it.
+			sort.SliceStable(hs, func(i, j int) bool {
+				if (hs[i].bucket == BucketServers) != (hs[j].bucket == BucketServers) {
+					return hs[i].bucket == BucketServers
+				}
+				if !hs[i].createdAt.Equal(hs[j].createdAt) {
+					return hs[i].createdAt.Before(hs[j].createdAt)
+				}
+				return hs[i].label < hs[j].label
+			})
+			dup := DuplicateVirtualIP{NetworkID: networkID, Network: networks[networkID].Name, IP: ip}
+			for _, h := range hs {
+				dup.Holders = append(dup.Holders, h.label)
+			}
+			report.DuplicateVirtualIPs = append(report.DuplicateVirtualIPs, dup)
+		}
+	}
+	sort.Slice(report.DuplicateVirtualIPs, func(i, j int) bool {
+		a, b := report.DuplicateVirtualIPs[i], report.DuplicateVirtualIPs[j]
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		return a.IP < b.IP
+	})
+
+	return report, nil
+}
+
+// fixIntegrity repairs the problems in report within tx; see FixIntegrity.
+func fixIntegrity(tx *bbolt.Tx, report *IntegrityReport) error {
+	// Dangling records go with their index entries, which are still
+	// consistent with them and so not reported as orphaned.
+	for _, rec := range report.DanglingReferences {
+		switch rec.Bucket {
+		case BucketServers:
+			if err := deleteRecord(tx, BucketServers, rec.Key, BucketServersByName, rec.NetworkID+":"+rec.Name, BucketServersByNetwork, rec.NetworkID+":"+rec.Key); err != nil {
+				return fmt.Errorf("failed to delete server %s: %w", rec.Key, err)
+			}
+		case BucketNodes:
+			if err := deleteRecord(tx, BucketNodes, rec.Key, BucketNodesByName, rec.NetworkID+":"+rec.Name, BucketNodesByNetwork, rec.NetworkID+":"+rec.Key); err != nil {
+				return fmt.Errorf("failed to delete node %s: %w", rec.Key, err)
+			}
+		default:
+			if err := tx.Bucket([]byte(rec.Bucket)).Delete([]byte(rec.Key)); err != nil {
+				return fmt.Errorf("failed to delete %s entry %s: %w", rec.Bucket, rec.Key, err)
+			}
+		}
+	}
+
+	for _, rec := range report.OrphanedConfigs {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(tx.Bucket([]byte(BucketConfigs)).Get([]byte(rec.Key)), config); err != nil {
+			return fmt.Errorf("failed to decode config %s: %w", rec.Key, err)
+		}
+		if err := deleteRecord(tx, BucketConfigs, rec.Key, BucketConfigsByVer, config.NetworkID+":"+padVersion(config.Version)); err != nil {
+			return fmt.Errorf("failed to delete config %s: %w", rec.Key, err)
+		}
+		if err := deleteSequence(tx, config.NetworkID); err != nil {
+			return fmt.Errorf("failed to delete config sequence %s: %w", config.NetworkID, err)
+		}
+	}
+
+	for _, rec := range report.OrphanedIndexKeys {
+		if err := tx.Bucket([]byte(rec.Bucket)).Delete([]byte(rec.Key)); err != nil {
+			return fmt.Errorf("failed to delete %s entry %s: %w", rec.Bucket, rec.Key, err)
+		}
+	}
+
+	if err := renumberConfigVersions(tx, report.DuplicateConfigVersions); err != nil {
+		return err
+	}
+
+	byNetwork := make(map[string][]*DuplicateVirtualIP)
+	for i := range report.DuplicateVirtualIPs {
+		dup := &report.DuplicateVirtualIPs[i]
+		byNetwork[dup.NetworkID] = append(byNetwork[dup.NetworkID], dup)
+	}
+	for networkID, dups := range byNetwork {
+		if err := reassignDuplicateIPs(tx, networkID, dups); err != nil {
+			return fmt.Errorf("failed to reassign duplicate IPs in network %s: %w", dups[0].Network, err)
+		}
+	}
+
+	return nil
+}
+
+// loadNetworks returns every network in the database by ID.
+func loadNetworks(tx *bbolt.Tx) (map[string]*VirtualNetwork, error) {
+	networks := make(map[string]*VirtualNetwork)
+	err := tx.Bucket([]byte(BucketNetworks)).ForEach(func(k, v []byte) error {
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(v, network); err != nil {
+			return fmt.Errorf("failed to decode network %s: %w", k, err)
+		}
+		networks[string(k)] = network
+		return nil
+	})
+	return networks, err
+}
+
+// invalidCIDRs lists the networks whose CIDR cannot hold an IP pool, sorted
+// by name.
+func invalidCIDRs(networks map[string]*VirtualNetwork) []InvalidNetworkCIDR {
+	invalid := []InvalidNetworkCIDR{}
+	for _, network := range networks {
+		if checkNetworkCIDR(network) != nil {
+			invalid = append(invalid, InvalidNetworkCIDR{NetworkID: network.ID, Network: network.Name, CIDR: network.CIDR})
+		}
+	}
+	sort.Slice(invalid, func(i, j int) bool { return invalid[i].Network < invalid[j].Network })
+	return invalid
+}
+
+// checkConfigVersions finds the config versions of the given networks that
+// share their number with another, and those saved earlier than the version
+// before them.
+func checkConfigVersions(tx *bbolt.Tx, networks map[string]*VirtualNetwork) ([]DuplicateConfigVersion, []IntegrityRecord, error) {
+	configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+	type versionKey struct {
+		networkID string
+		version   int
+	}
+	byVersion := make(map[versionKey][]*ConfigVersion)
+	if err := tx.Bucket([]byte(BucketConfigs)).ForEach(func(k, v []byte) error {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(v, config); err != nil {
+			return fmt.Errorf("failed to decode config %s: %w", k, err)
+		}
+		if networks[config.NetworkID] != nil {
+			key := versionKey{config.NetworkID, config.Version}
+			byVersion[key] = append(byVersion[key], config)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	duplicates := []DuplicateConfigVersion{}
+	// indexed holds the version each network's index points at, in order.
+	indexed := make(map[string][]*ConfigVersion)
+	for key, configs := range byVersion {
+		// The indexed version keeps the number, or else the oldest.
+		id := string(configsByVer.Get([]byte(key.networkID + ":" + padVersion(key.version))))
+		sort.Slice(configs, func(i, j int) bool {
+			if (configs[i].ID == id) != (configs[j].ID == id) {
+				return configs[i].ID == id
+			}
+			if !configs[i].CreatedAt.Equal(configs[j].CreatedAt) {
+				return configs[i].CreatedAt.Before(configs[j].CreatedAt)
+			}
+			return configs[i].ID < configs[j].ID
+		})
+		if configs[0].ID == id {
+			indexed[key.networkID] = append(indexed[key.networkID], configs[0])
+		}
+		for _, config := range configs[1:] {
+			duplicates = append(duplicates, DuplicateConfigVersion{
+				NetworkID: key.networkID,
+				Network:   networks[key.networkID].Name,
+				ConfigID:  config.ID,
+				Version:   config.Version,
+				CreatedAt: config.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		a, b := duplicates[i], duplicates[j]
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ConfigID < b.ConfigID
+	})
+
+	skewed := []IntegrityRecord{}
+	networkIDs := slices.Sorted(maps.Keys(indexed))
+	sort.SliceStable(networkIDs, func(i, j int) bool { return networks[networkIDs[i]].Name < networks[networkIDs[j]].Name })
+	for _, networkID := range networkIDs {
+		versions := indexed[networkID]
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		for i := 1; i < len(versions); i++ {
+			if versions[i].CreatedAt.Before(versions[i-1].CreatedAt) {
+				skewed = append(skewed, IntegrityRecord{Bucket: BucketConfigs, Key: versions[i].ID, NetworkID: networkID, Name: fmt.Sprintf("v%d", versions[i].Version)})
+			}
+		}
+	}
+	return duplicates, skewed, nil
+}
+
+// renumberConfigVersions gives each duplicate config version the next
+// version number of its network and indexes it under that number.
+func renumberConfigVersions(tx *bbolt.Tx, duplicates []DuplicateConfigVersion) error {
+	configsBucket := tx.Bucket([]byte(BucketConfigs))
+	for i := range duplicates {
+		dup := &duplicates[i]
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(configsBucket.Get([]byte(dup.ConfigID)), config); err != nil {
+			return fmt.Errorf("failed to decode config %s: %w", dup.ConfigID, err)
+		}
+		version, err := nextConfigVersion(tx, config.NetworkID)
+		if err != nil {
+			return err
+		}
+		config.Version = version
+		data, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config %s: %w", config.ID, err)
+		}
+		if err := configsBucket.Put([]byte(config.ID), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketConfigsByVer)).Put([]byte(config.NetworkID+":"+padVersion(version)), []byte(config.ID)); err != nil {
+			return err
+		}
+		dup.RenumberedTo = version
+	}
+	return nil
+}
+
+// deleteRecord deletes key from the primary bucket and the given index
+// entries, passed as bucket, key pairs, when they point at key.
+func deleteRecord(tx *bbolt.Tx, primary, key string, indexes ...string) error {
+	for i := 0; i+1 < len(indexes); i += 2 {
+		index := tx.Bucket([]byte(indexes[i]))
+		if string(index.Get([]byte(indexes[i+1]))) != key {
+			continue
+		}
+		if err := index.Delete([]byte(indexes[i+1])); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte(primary)).Delete([]byte(key))
+}
+
+// reassignDuplicateIPs gives every holder but the first of each duplicate
+// IP of a network a free address, then saves the network's IP pool state
+// rebuilt from its records.
+func reassignDuplicateIPs(tx *bbolt.Tx, networkID string, dups []*DuplicateVirtualIP) error {
+	network := &VirtualNetwork{}
+	if err := json.Unmarshal(tx.Bucket([]byte(BucketNetworks)).Get([]byte(networkID)), network); err != nil {
+		return fmt.Errorf("failed to decode network: %w", err)
+	}
+	pool, err := util.NewIPPool(network.CIDR)
+	if err != nil {
+		return fmt.Errorf("failed to create IP pool: %w", err)
+	}
+
+	servers := make(map[string]*Server)
+	if err := forEachWithPrefix(tx.Bucket([]byte(BucketServersByNetwork)), []byte(networkID+":"), func(_, id []byte) error {
+		server := &Server{}
+		if err := json.Unmarshal(tx.Bucket([]byte(BucketServers)).Get(id), server); err != nil {
+			return err
+		}
+		servers["server "+server.Name] = server
+		//nolint:errcheck // Duplicates are what is being fixed
+		_ = pool.MarkIPAllocated(server.VirtualIP)
+		return nil
+	}); err != nil {
+		return err
+	}
+	nodes := make(map[string]*Node)
+	if err := forEachWithPrefix(tx.Bucket([]byte(BucketNodesByNetwork)), []byte(networkID+":"), func(_, id []byte) error {
+		node := &Node{}
+		if err := json.Unmarshal(tx.Bucket([]byte(BucketNodes)).Get(id), node); err != nil {
+			return err
+		}
+		nodes["node "+node.Name] = node
+		//nolint:errcheck // Duplicates are what is being fixed
+		_ = pool.MarkIPAllocated(node.VirtualIP)
+		return nil
+	}); err != nil {
+		return err
+	}
+	guests, err := listGuests(tx, networkID)
+	if err != nil {
+		return err
+	}
+	for _, guest := range guests {
+		//nolint:errcheck // Guests keep their addresses
+		_ = pool.MarkIPAllocated(guest.VirtualIP)
+	}
+	pool.SyncNextIndex()
+	state := &util.IPPoolState{}
+	if data := tx.Bucket([]byte(BucketIPPools)).Get([]byte(networkID)); data != nil && json.Unmarshal(data, state) == nil && state.NetworkCIDR == network.CIDR {
+		//nolint:errcheck // Reservations that no longer fit are dropped
+		_ = keepReservations(pool, state)
+	}
+
+	now := time.Now()
+	for _, dup := range dups {
+		dup.Reassigned = make(map[string]string)
+		for _, holder := range dup.Holders[1:] {
+			ip, err := pool.AllocateNodeIP()
+			if err != nil {
+				return fmt.Errorf("%s: %w", holder, err)
+			}
+			var record any
+			var bucket, id string
+			if server, ok := servers[holder]; ok {
+				server.VirtualIP, server.UpdatedAt = ip, now
+				server.Revision++
+				record, bucket, id = server, BucketServers, server.ID
+			} else if node, ok := nodes[holder]; ok {
+				node.VirtualIP, node.UpdatedAt = ip, now
+				node.Revision++
+				record, bucket, id = node, BucketNodes, node.ID
+			} else {
+				return fmt.Errorf("%s not found", holder)
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to marshal %s: %w", holder, err)
+			}
+			if err := tx.Bucket([]byte(bucket)).Put([]byte(id), data); err != nil {
+				return err
+			}
+			dup.Reassigned[holder] = ip
+		}
+	}
+
+	if err := writeIPPoolState(tx, networkID, pool.GetState()); err != nil {
+		return err
+	}
+	return bumpRevision(tx, networkID)
+}
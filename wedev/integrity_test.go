@@ -0,0 +1,252 @@
+package wedev
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// TestCheckIntegrity plants one problem of each kind and verifies
+// CheckIntegrity reports them without changing anything, then that
+// FixIntegrity repairs them all in one go.
+func TestCheckIntegrity(t *testing.T) {
+	vnm, sm := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("fsck", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("fsck", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	first, err := vnm.CreateNode("fsck", "first", "", 51820, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(first) error = %v", err)
+	}
+	second, err := vnm.CreateNode("fsck", "second", "", 51820, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(second) error = %v", err)
+	}
+
+	report, err := sm.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity() error = %v", err)
+	}
+	if report.Problems() != 0 {
+		t.Fatalf("CheckIntegrity() on a clean database = %+v; want no problems", report)
+	}
+
+	// Give second the address of first, and plant an orphaned index entry,
+	// a node, IP pool and revision of a missing network, and an orphaned
+	// config.
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		second.VirtualIP = first.VirtualIP
+		data, err := json.Marshal(second)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketNodes)).Put([]byte(second.ID), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketNodesByName)).Put([]byte(second.NetworkID+":ghost"), []byte("gone")); err != nil {
+			return err
+		}
+		stray, err := json.Marshal(&Node{ID: "stray", NetworkID: "missing", Name: "stray", VirtualIP: "10.9.0.2"})
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketNodes)).Put([]byte("stray"), stray); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketNodesByName)).Put([]byte("missing:stray"), []byte("stray")); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BucketRevisions)).Put([]byte("missing"), make([]byte, 8)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketIPPools)).Put([]byte("missing"), []byte("{}"))
+	}); err != nil {
+		t.Fatalf("seeding problems error = %v", err)
+	}
+	if _, err := sm.SaveConfigVersion("missing", "hash", map[string]string{"a": "b"}); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	report, err = sm.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity() error = %v", err)
+	}
+	if len(report.OrphanedIndexKeys) != 1 || report.OrphanedIndexKeys[0].Key != second.NetworkID+":ghost" {
+		t.Errorf("OrphanedIndexKeys = %+v; want the ghost node entry", report.OrphanedIndexKeys)
+	}
+	if len(report.DanglingReferences) != 3 {
+		t.Errorf("DanglingReferences = %+v; want the stray node, IP pool and revision", report.DanglingReferences)
+	}
+	if len(report.DuplicateVirtualIPs) != 1 {
+		t.Fatalf("DuplicateVirtualIPs = %+v; want one", report.DuplicateVirtualIPs)
+	}
+	if dup := report.DuplicateVirtualIPs[0]; dup.IP != first.VirtualIP || len(dup.Holders) != 2 || dup.Holders[0] != "node first" {
+		t.Errorf("DuplicateVirtualIPs[0] = %+v; want %s held by first, then second", dup, first.VirtualIP)
+	}
+	if len(report.OrphanedConfigs) != 1 {
+		t.Errorf("OrphanedConfigs = %+v; want one", report.OrphanedConfigs)
+	}
+	if report.Fixed {
+		t.Error("CheckIntegrity() report is marked fixed")
+	}
+
+	report, err = vnm.FixIntegrity()
+	if err != nil {
+		t.Fatalf("FixIntegrity() error = %v", err)
+	}
+	if !report.Fixed || report.Problems() != 6 {
+		t.Errorf("FixIntegrity() = %d problems (fixed %v); want 6 fixed", report.Problems(), report.Fixed)
+	}
+
+	report, err = sm.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity() after fix error = %v", err)
+	}
+	if report.Problems() != 0 {
+		t.Errorf("CheckIntegrity() after fix = %+v; want no problems", report)
+	}
+
+	// first keeps its address, second moved to a free one, and the pool
+	// hands out neither again.
+	got, err := sm.GetNodeByName(first.NetworkID, "second")
+	if err != nil {
+		t.Fatalf("GetNodeByName(second) error = %v", err)
+	}
+	if got.VirtualIP == first.VirtualIP {
+		t.Errorf("second still holds %s after fix", got.VirtualIP)
+	}
+	third, err := vnm.CreateNode("fsck", "third", "", 51820, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(third) error = %v", err)
+	}
+	if third.VirtualIP == first.VirtualIP || third.VirtualIP == got.VirtualIP {
+		t.Errorf("third got %s, which is already in use", third.VirtualIP)
+	}
+}
+
+// TestConfigVersionSequence checks that version numbers come from the
+// network's sequence: a removed version's number is not given out again.
+func TestConfigVersionSequence(t *testing.T) {
+	_, sm := newTestManager(t)
+	network, err := sm.CreateNetwork("seq", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	var last *ConfigVersion
+	for i := 0; i < 2; i++ {
+		if last, err = sm.SaveConfigVersion(network.ID, "hash", map[string]string{"a": "b"}); err != nil {
+			t.Fatalf("SaveConfigVersion() error = %v", err)
+		}
+	}
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		return deleteRecord(tx, BucketConfigs, last.ID, BucketConfigsByVer, network.ID+":"+padVersion(last.Version))
+	}); err != nil {
+		t.Fatalf("deleting v2 error = %v", err)
+	}
+
+	next, err := sm.SaveConfigVersion(network.ID, "hash", map[string]string{"a": "c"})
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if next.Version != 3 {
+		t.Errorf("version after removing v2 = %d, want 3", next.Version)
+	}
+	if len(next.Changed) != 1 || next.Changed[0] != "a" {
+		t.Errorf("Changed = %v, want [a] compared with v1", next.Changed)
+	}
+	if latest, err := sm.GetLatestConfigVersion(network.ID); err != nil || latest.ID != next.ID {
+		t.Errorf("GetLatestConfigVersion() = %+v (err %v), want v3", latest, err)
+	}
+
+	if err := sm.DeleteNetwork(network.Name); err != nil {
+		t.Fatalf("DeleteNetwork() error = %v", err)
+	}
+	if report, err := sm.CheckIntegrity(); err != nil || report.Problems() != 0 {
+		t.Errorf("CheckIntegrity() after DeleteNetwork = %+v (err %v), want no dangling sequence", report, err)
+	}
+}
+
+// TestCheckIntegrityConfigVersions plants a config version saved under a
+// number another version has and one saved with the clock turned back, and
+// checks that the migration and FixIntegrity renumber the first and only
+// report the second.
+func TestCheckIntegrityConfigVersions(t *testing.T) {
+	_, sm := newTestManager(t)
+	network, err := sm.CreateNetwork("dupver", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	var saved []*ConfigVersion
+	for i := 0; i < 2; i++ {
+		config, err := sm.SaveConfigVersion(network.ID, "hash", map[string]string{"a": "b"})
+		if err != nil {
+			t.Fatalf("SaveConfigVersion() error = %v", err)
+		}
+		saved = append(saved, config)
+	}
+
+	// plant writes a second v2, unindexed, and moves v2 before v1 in time.
+	plant := func(id string) {
+		t.Helper()
+		if err := sm.db.Update(func(tx *bbolt.Tx) error {
+			dup := *saved[1]
+			dup.ID = id
+			data, err := json.Marshal(&dup)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(BucketConfigs)).Put([]byte(dup.ID), data); err != nil {
+				return err
+			}
+			skewed := *saved[1]
+			skewed.CreatedAt = saved[0].CreatedAt.Add(-time.Hour)
+			if data, err = json.Marshal(&skewed); err != nil {
+				return err
+			}
+			return tx.Bucket([]byte(BucketConfigs)).Put([]byte(skewed.ID), data)
+		}); err != nil {
+			t.Fatalf("planting versions error = %v", err)
+		}
+	}
+
+	plant("dup-1")
+	if err := sm.db.Update(addSequences); err != nil {
+		t.Fatalf("addSequences() error = %v", err)
+	}
+	if config, err := sm.GetConfigVersion(network.ID, 3); err != nil || config.ID != "dup-1" {
+		t.Errorf("v3 after migration = %+v (err %v), want the duplicate", config, err)
+	}
+
+	plant("dup-2")
+	report, err := sm.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity() error = %v", err)
+	}
+	if len(report.DuplicateConfigVersions) != 1 || report.DuplicateConfigVersions[0].ConfigID != "dup-2" || report.DuplicateConfigVersions[0].Version != 2 {
+		t.Errorf("DuplicateConfigVersions = %+v, want dup-2 at v2", report.DuplicateConfigVersions)
+	}
+	if len(report.ClockSkewedConfigs) != 1 || report.ClockSkewedConfigs[0].Key != saved[1].ID {
+		t.Errorf("ClockSkewedConfigs = %+v, want v2", report.ClockSkewedConfigs)
+	}
+
+	report, err = sm.FixIntegrity()
+	if err != nil {
+		t.Fatalf("FixIntegrity() error = %v", err)
+	}
+	if !report.Fixed || report.DuplicateConfigVersions[0].RenumberedTo != 4 {
+		t.Errorf("FixIntegrity() = %+v, want dup-2 renumbered to v4", report.DuplicateConfigVersions)
+	}
+	report, err = sm.CheckIntegrity()
+	if err != nil || report.Problems() != 0 || len(report.ClockSkewedConfigs) != 1 {
+		t.Errorf("CheckIntegrity() after fix = %+v (err %v), want only the clock skew", report, err)
+	}
+	if next, err := sm.SaveConfigVersion(network.ID, "hash", map[string]string{"a": "b"}); err != nil || next.Version != 5 {
+		t.Errorf("SaveConfigVersion() after fix = %+v (err %v), want v5", next, err)
+	}
+}
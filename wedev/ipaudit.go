@@ -0,0 +1,216 @@
+package wedev
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/wedevctl/util"
+)
+
+// IPIssueKind classifies a disagreement between a network's saved IP pool
+// state and the virtual IPs of its server and nodes.
+type IPIssueKind string
+
+const (
+	// IPIssueMissingState means no IP pool state is saved for the network.
+	IPIssueMissingState IPIssueKind = "missing_state"
+	// IPIssueCIDRMismatch means the saved state is for a different CIDR.
+	IPIssueCIDRMismatch IPIssueKind = "cidr_mismatch"
+	// IPIssueStaleAllocation is an allocated IP no node holds.
+	IPIssueStaleAllocation IPIssueKind = "stale_allocation"
+	// IPIssueMissingAllocation is a node or server IP the saved state does
+	// not record, so it can be handed out again.
+	IPIssueMissingAllocation IPIssueKind = "missing_allocation"
+	// IPIssueRecycledInUse is a node IP queued for reuse.
+	IPIssueRecycledInUse IPIssueKind = "recycled_in_use"
+	// IPIssueReservedInUse is a node or server IP inside a reserved range.
+	IPIssueReservedInUse IPIssueKind = "reserved_in_use"
+	// IPIssueNextIndexBehind means new allocations would start inside the
+	// range already in use.
+	IPIssueNextIndexBehind IPIssueKind = "next_index_behind"
+	// IPIssueDuplicateIP is one IP held by several entities. The records
+	// themselves are corrupt, so 'ip repair' cannot fix it.
+	IPIssueDuplicateIP IPIssueKind = "duplicate_ip"
+)
+
+// IPIssue is one discrepancy found by AuditIPPool.
+type IPIssue struct {
+	Kind     IPIssueKind `json:"kind"`
+	IP       string      `json:"ip,omitempty"`
+	Entities []string    `json:"entities,omitempty"`
+	Message  string      `json:"message"`
+}
+
+// IPAuditReport lists the discrepancies between a network's IP pool state
+// and its records. Repaired is set by RepairIPPool when it rewrote the state.
+type IPAuditReport struct {
+	Network  string    `json:"network"`
+	Issues   []IPIssue `json:"issues"`
+	Repaired bool      `json:"repaired,omitempty"`
+}
+
+// Duplicates returns the duplicate-IP issues, which only fixing the records
+// can resolve.
+func (r *IPAuditReport) Duplicates() []IPIssue {
+	var dups []IPIssue
+	for _, issue := range r.Issues {
+		if issue.Kind == IPIssueDuplicateIP {
+			dups = append(dups, issue)
+		}
+	}
+	return dups
+}
+
+// AuditIPPool compares a network's saved IP pool state with the virtual IPs
+// of its server and nodes, which are authoritative, and reports every
+// discrepancy. It changes nothing.
+func (vnm *VirtualNetworkManager) AuditIPPool(networkName string) (*IPAuditReport, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.auditIPPool(network)
+}
+
+// RepairIPPool audits a network's IP pool state and, when it has drifted,
+// rebuilds it from the server and node records and saves it. The returned
+// report lists what was found before the repair. Duplicate IPs are left as
+// they are: they are corrupt records, not pool state.
+func (vnm *VirtualNetworkManager) RepairIPPool(networkName string) (*IPAuditReport, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	report, err := vnm.auditIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Issues) == len(report.Duplicates()) {
+		return report, nil
+	}
+
+	ipPool, err := vnm.rebuildIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+	state := ipPool.GetState()
+	if err := vnm.storage.SaveIPPoolState(network.ID, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return nil, fmt.Errorf("failed to save IP pool state: %w", err)
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	vnm.logger.Info("repaired IP pool state", "network", network.Name, "issues", len(report.Issues))
+
+	report.Repaired = true
+	return report, nil
+}
+
+// auditIPPool does the work of AuditIPPool for a network already looked up.
+func (vnm *VirtualNetworkManager) auditIPPool(network *VirtualNetwork) (*IPAuditReport, error) {
+	report := &IPAuditReport{Network: network.Name, Issues: []IPIssue{}}
+	add := func(kind IPIssueKind, ip string, entities []string, format string, args ...any) {
+		report.Issues = append(report.Issues, IPIssue{Kind: kind, IP: ip, Entities: entities, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Collect who holds each address, servers first.
+	owners := make(map[string][]string)
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		owners[server.VirtualIP] = append(owners[server.VirtualIP], server.Name)
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		owners[node.VirtualIP] = append(owners[node.VirtualIP], node.Name)
+	}
+	guests, err := vnm.storage.ListGuestsByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, guest := range guests {
+		owners[guest.VirtualIP] = append(owners[guest.VirtualIP], "guest "+guest.Name)
+	}
+
+	heldIPs := make([]string, 0, len(owners))
+	for ip := range owners {
+		heldIPs = append(heldIPs, ip)
+	}
+	sort.Strings(heldIPs)
+	for _, ip := range heldIPs {
+		if holders := owners[ip]; len(holders) > 1 {
+			holders = slices.Clone(holders)
+			slices.Sort(holders)
+			add(IPIssueDuplicateIP, ip, holders, "%s is held by %d entities; delete and re-add all but one", ip, len(holders))
+		}
+	}
+
+	state, err := vnm.storage.GetIPPoolState(network.ID)
+	if err != nil {
+		add(IPIssueMissingState, "", nil, "no IP pool state is saved; it is rebuilt from the records on the next change")
+		return report, nil
+	}
+
+	if state.NetworkCIDR != network.CIDR {
+		add(IPIssueCIDRMismatch, "", nil, "state is for %s but the network is %s", state.NetworkCIDR, network.CIDR)
+	}
+
+	allocated := make(map[string]bool, len(state.Allocated))
+	for _, ip := range state.Allocated {
+		allocated[ip] = true
+	}
+	stale := make([]string, 0)
+	for ip := range allocated {
+		if len(owners[ip]) == 0 {
+			stale = append(stale, ip)
+		}
+	}
+	sort.Strings(stale)
+	for _, ip := range stale {
+		add(IPIssueStaleAllocation, ip, nil, "%s is allocated but no node holds it", ip)
+	}
+	// The reserved server IP is never in the allocated list.
+	for _, ip := range heldIPs {
+		if !allocated[ip] && ip != state.ServerIP {
+			add(IPIssueMissingAllocation, ip, owners[ip], "%s (%s) is not recorded as allocated and can be handed out again", ip, owners[ip][0])
+		}
+	}
+
+	recycled := slices.Clone(state.Recycled)
+	sort.Strings(recycled)
+	for _, ip := range slices.Compact(recycled) {
+		if holders := owners[ip]; len(holders) > 0 {
+			add(IPIssueRecycledInUse, ip, holders, "%s (%s) is queued for reuse", ip, holders[0])
+		}
+	}
+
+	for _, spec := range state.Reserved {
+		r, err := util.ParseIPRange(spec)
+		if err != nil {
+			continue
+		}
+		for _, ip := range heldIPs {
+			if r.Contains(ip) {
+				add(IPIssueReservedInUse, ip, owners[ip], "%s (%s) is inside reserved range %s", ip, owners[ip][0], spec)
+			}
+		}
+	}
+
+	// Compare with the pool the records imply; an index behind it means
+	// fresh allocations would land on addresses already in use.
+	if rebuilt, err := vnm.rebuildIPPool(network); err == nil {
+		if want := rebuilt.GetState().NextIndex; state.NextIndex < want {
+			add(IPIssueNextIndexBehind, "", nil, "next index is %d but addresses up to index %d are in use", state.NextIndex, want)
+		}
+	}
+
+	return report, nil
+}
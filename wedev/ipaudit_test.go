@@ -0,0 +1,166 @@
+package wedev
+
+import (
+	"slices"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// issueKinds returns the kinds of a report's issues, in order.
+func issueKinds(report *IPAuditReport) []IPIssueKind {
+	kinds := make([]IPIssueKind, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	return kinds
+}
+
+func TestAuditIPPool_Consistent(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("audit", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("audit", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := vnm.CreateNode("audit", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if err := vnm.DeleteNode("audit", "b"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+
+	report, err := vnm.AuditIPPool("audit")
+	if err != nil {
+		t.Fatalf("AuditIPPool() error = %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() issues = %+v, want none", report.Issues)
+	}
+	if _, err := vnm.AuditIPPool("ghost"); err == nil {
+		t.Error("AuditIPPool() for an unknown network should fail")
+	}
+}
+
+func TestAuditAndRepairIPPool_Drift(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("audit", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("audit", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	a, err := vnm.CreateNode("audit", "a", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+	b, err := vnm.CreateNode("audit", "b", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(b) error = %v", err)
+	}
+
+	// Hand-edited state: b's IP is missing and queued for reuse, a deleted
+	// node's IP is still allocated, and the index is behind.
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	state.Allocated = []string{a.VirtualIP, "10.0.0.9"}
+	state.Recycled = []string{b.VirtualIP}
+	state.NextIndex = 1
+	if err := sm.SaveIPPoolState(network.ID, state); err != nil {
+		t.Fatalf("SaveIPPoolState() error = %v", err)
+	}
+
+	report, err := vnm.AuditIPPool("audit")
+	if err != nil {
+		t.Fatalf("AuditIPPool() error = %v", err)
+	}
+	want := []IPIssueKind{IPIssueStaleAllocation, IPIssueMissingAllocation, IPIssueRecycledInUse, IPIssueNextIndexBehind}
+	if got := issueKinds(report); !slices.Equal(got, want) {
+		t.Errorf("AuditIPPool() kinds = %v, want %v", got, want)
+	}
+
+	report, err = vnm.RepairIPPool("audit")
+	if err != nil {
+		t.Fatalf("RepairIPPool() error = %v", err)
+	}
+	if !report.Repaired || len(report.Issues) != len(want) {
+		t.Errorf("RepairIPPool() = repaired %v with %d issues, want repaired with %d", report.Repaired, len(report.Issues), len(want))
+	}
+
+	report, err = vnm.AuditIPPool("audit")
+	if err != nil {
+		t.Fatalf("AuditIPPool() after repair error = %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() after repair issues = %+v, want none", report.Issues)
+	}
+
+	// The repaired pool hands out a fresh address.
+	c, err := vnm.CreateNode("audit", "c", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(c) error = %v", err)
+	}
+	if c.VirtualIP == a.VirtualIP || c.VirtualIP == b.VirtualIP {
+		t.Errorf("CreateNode(c) after repair got %s, already in use", c.VirtualIP)
+	}
+
+	// A consistent pool is left alone.
+	if report, err := vnm.RepairIPPool("audit"); err != nil || report.Repaired {
+		t.Errorf("RepairIPPool() on a consistent pool = repaired %v (err %v)", report.Repaired, err)
+	}
+}
+
+func TestAuditIPPool_MissingStateAndDuplicates(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("audit", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	a, err := vnm.CreateNode("audit", "a", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+	// A record written around the manager, sharing a's address.
+	if _, err := sm.CreateNode(network.ID, "dup", "", 51820, a.VirtualIP, NodeTypeRoute, "p", "p"); err != nil {
+		t.Fatalf("sm.CreateNode() error = %v", err)
+	}
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BucketIPPools)).Delete([]byte(network.ID))
+	}); err != nil {
+		t.Fatalf("deleting IP pool state error = %v", err)
+	}
+
+	report, err := vnm.AuditIPPool("audit")
+	if err != nil {
+		t.Fatalf("AuditIPPool() error = %v", err)
+	}
+	want := []IPIssueKind{IPIssueDuplicateIP, IPIssueMissingState}
+	if got := issueKinds(report); !slices.Equal(got, want) {
+		t.Fatalf("AuditIPPool() kinds = %v, want %v", got, want)
+	}
+	if dup := report.Issues[0]; dup.IP != a.VirtualIP || !slices.Equal(dup.Entities, []string{"a", "dup"}) {
+		t.Errorf("duplicate issue = %+v", dup)
+	}
+
+	// Repair saves the state but cannot resolve the duplicate.
+	report, err = vnm.RepairIPPool("audit")
+	if err != nil {
+		t.Fatalf("RepairIPPool() error = %v", err)
+	}
+	if !report.Repaired {
+		t.Error("RepairIPPool() should save the missing state")
+	}
+	report, err = vnm.AuditIPPool("audit")
+	if err != nil {
+		t.Fatalf("AuditIPPool() after repair error = %v", err)
+	}
+	if got := issueKinds(report); !slices.Equal(got, []IPIssueKind{IPIssueDuplicateIP}) || len(report.Duplicates()) != 1 {
+		t.Errorf("AuditIPPool() after repair kinds = %v, want only the duplicate", got)
+	}
+}
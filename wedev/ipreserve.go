@@ -0,0 +1,216 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/wedevctl/util"
+)
+
+// ReserveIPRange keeps a range of a network's addresses, written as a CIDR,
+// a first-last range or a single address, from being allocated to servers
+// and nodes, for instance because other infrastructure uses them. A range
+// holding the address of a server or node is rejected with their names.
+// The reservation is saved with the IP pool state.
+func (vnm *VirtualNetworkManager) ReserveIPRange(networkName, spec string) (util.IPRange, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return util.IPRange{}, err
+	}
+	r, err := util.ParseIPRange(spec)
+	if err != nil {
+		return util.IPRange{}, kindErrorf(ErrValidation, "%w", err)
+	}
+
+	holders, err := vnm.holdersIn(network.ID, r)
+	if err != nil {
+		return util.IPRange{}, err
+	}
+	if len(holders) > 0 {
+		return util.IPRange{}, kindErrorf(ErrValidation, "range %s includes addresses in use by %s", r, strings.Join(holders, ", "))
+	}
+
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return util.IPRange{}, fmt.Errorf("failed to ensure IP pool: %w", err)
+	}
+	if err := ipPool.Reserve(r); err != nil {
+		return util.IPRange{}, kindErrorf(ErrValidation, "%w", err)
+	}
+	state := ipPool.GetState()
+	if err := vnm.storage.SaveIPPoolState(network.ID, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return util.IPRange{}, fmt.Errorf("failed to save IP pool state: %w", err)
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	vnm.logger.Debug("reserved IP range", "network", networkName, "range", r.String())
+	return r, nil
+}
+
+// UnreserveIPRange removes a reservation made by ReserveIPRange; spec must
+// name the same addresses.
+func (vnm *VirtualNetworkManager) UnreserveIPRange(networkName, spec string) (util.IPRange, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return util.IPRange{}, err
+	}
+	r, err := util.ParseIPRange(spec)
+	if err != nil {
+		return util.IPRange{}, kindErrorf(ErrValidation, "%w", err)
+	}
+
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return util.IPRange{}, fmt.Errorf("failed to ensure IP pool: %w", err)
+	}
+	if err := ipPool.Unreserve(r); err != nil {
+		return util.IPRange{}, kindErrorf(ErrNotFound, "%w", err)
+	}
+	state := ipPool.GetState()
+	if err := vnm.storage.SaveIPPoolState(network.ID, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return util.IPRange{}, fmt.Errorf("failed to save IP pool state: %w", err)
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	return r, nil
+}
+
+// holdersIn returns the servers, nodes and guests of a network whose virtual
+// IP is in r, as "name (ip)", sorted.
+func (vnm *VirtualNetworkManager) holdersIn(networkID string, r util.IPRange) ([]string, error) {
+	var holders []string
+	servers, err := vnm.storage.ListServersByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if r.Contains(server.VirtualIP) {
+			holders = append(holders, fmt.Sprintf("server %s (%s)", server.Name, server.VirtualIP))
+		}
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if r.Contains(node.VirtualIP) {
+			holders = append(holders, fmt.Sprintf("node %s (%s)", node.Name, node.VirtualIP))
+		}
+	}
+	guests, err := vnm.storage.ListGuestsByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, guest := range guests {
+		if r.Contains(guest.VirtualIP) {
+			holders = append(holders, fmt.Sprintf("guest %s (%s)", guest.Name, guest.VirtualIP))
+		}
+	}
+	sort.Strings(holders)
+	return holders, nil
+}
+
+// IPHolder is an allocated address and the server or node holding it.
+type IPHolder struct {
+	IP     string `json:"ip"`
+	Kind   string `json:"kind"` // "server", "node" or "guest"
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"` // "expired" for an expired node or guest
+}
+
+// IPListing is how a network's addresses are used: allocated to servers,
+// nodes and guests, recycled for reuse, or reserved.
+type IPListing struct {
+	Network   string     `json:"network"`
+	CIDR      string     `json:"cidr"`
+	Allocated []IPHolder `json:"allocated"` // sorted by address
+	Recycled  []string   `json:"recycled"`  // in the order they are handed out again
+	Reserved  []string   `json:"reserved"`  // sorted by first address
+	Pool      PoolUsage  `json:"pool"`
+}
+
+// ListIPs lists the allocated, recycled and reserved addresses of a network.
+// Like PoolUsage, it changes nothing.
+func (vnm *VirtualNetworkManager) ListIPs(networkName string) (*IPListing, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := vnm.readIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &IPListing{Network: network.Name, CIDR: network.CIDR, Allocated: []IPHolder{}, Recycled: []string{}, Reserved: []string{}, Pool: poolUsage(pool)}
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		listing.Allocated = append(listing.Allocated, IPHolder{IP: server.VirtualIP, Kind: "server", Name: server.Name})
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := vnm.now()
+	for _, node := range nodes {
+		holder := IPHolder{IP: node.VirtualIP, Kind: "node", Name: node.Name}
+		if node.Expired(now) {
+			holder.Status = "expired"
+		}
+		listing.Allocated = append(listing.Allocated, holder)
+	}
+	guests, err := vnm.storage.ListGuestsByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, guest := range guests {
+		holder := IPHolder{IP: guest.VirtualIP, Kind: "guest", Name: guest.Name}
+		if guest.Expired(now) {
+			holder.Status = "expired"
+		}
+		listing.Allocated = append(listing.Allocated, holder)
+	}
+	sort.Slice(listing.Allocated, func(i, j int) bool {
+		a, errA := netip.ParseAddr(listing.Allocated[i].IP)
+		b, errB := netip.ParseAddr(listing.Allocated[j].IP)
+		if errA != nil || errB != nil {
+			return listing.Allocated[i].IP < listing.Allocated[j].IP
+		}
+		return a.Less(b)
+	})
+
+	listing.Recycled = append(listing.Recycled, pool.GetState().Recycled...)
+	for _, r := range pool.Reserved() {
+		listing.Reserved = append(listing.Reserved, r.String())
+	}
+	return listing, nil
+}
+
+// keepReservations applies the reserved ranges of state, a network's saved
+// IP pool state, to pool, rebuilt from the records, which do not hold them.
+// Ranges that no longer fit, such as one overlapping an address in use, are
+// left out and reported in the error.
+func keepReservations(pool *util.IPPool, state *util.IPPoolState) error {
+	var errs []error
+	for _, spec := range state.Reserved {
+		r, err := util.ParseIPRange(spec)
+		if err == nil {
+			err = pool.Reserve(r)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("dropped reserved range %s: %w", spec, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package wedev
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+func TestReserveIPRange(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("res", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("res", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := vnm.CreateNode("res", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+
+	// A range over node addresses names the nodes.
+	_, err := vnm.ReserveIPRange("res", "10.0.0.0/29")
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "node a (10.0.0.2), node b (10.0.0.3), server srv (10.0.0.1)") {
+		t.Errorf("ReserveIPRange() over nodes error = %v, want the holders", err)
+	}
+	if _, err := vnm.ReserveIPRange("res", "nonsense"); !errors.Is(err, ErrValidation) {
+		t.Errorf("ReserveIPRange(nonsense) error = %v, want ErrValidation", err)
+	}
+
+	r, err := vnm.ReserveIPRange("res", "10.0.0.4-10.0.0.9")
+	if err != nil {
+		t.Fatalf("ReserveIPRange() error = %v", err)
+	}
+	if r.String() != "10.0.0.4-10.0.0.9" {
+		t.Errorf("ReserveIPRange() = %s", r)
+	}
+	node, err := vnm.CreateNode("res", "c", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(c) error = %v", err)
+	}
+	if node.VirtualIP != "10.0.0.10" {
+		t.Errorf("node c got %s, want 10.0.0.10 past the reservation", node.VirtualIP)
+	}
+
+	listing, err := vnm.ListIPs("res")
+	if err != nil {
+		t.Fatalf("ListIPs() error = %v", err)
+	}
+	var held []string
+	for _, holder := range listing.Allocated {
+		held = append(held, holder.Name+"="+holder.IP)
+	}
+	if want := []string{"srv=10.0.0.1", "a=10.0.0.2", "b=10.0.0.3", "c=10.0.0.10"}; !slices.Equal(held, want) {
+		t.Errorf("ListIPs() Allocated = %v, want %v", held, want)
+	}
+	if want := []string{"10.0.0.4-10.0.0.9"}; !slices.Equal(listing.Reserved, want) {
+		t.Errorf("ListIPs() Reserved = %v, want %v", listing.Reserved, want)
+	}
+	if listing.Pool.Reserved != 6 {
+		t.Errorf("ListIPs() Pool.Reserved = %d, want 6", listing.Pool.Reserved)
+	}
+
+	if _, err := vnm.UnreserveIPRange("res", "10.0.0.4-10.0.0.8"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UnreserveIPRange() of another range error = %v, want ErrNotFound", err)
+	}
+	if _, err := vnm.UnreserveIPRange("res", "10.0.0.4-10.0.0.9"); err != nil {
+		t.Fatalf("UnreserveIPRange() error = %v", err)
+	}
+	node, err = vnm.CreateNode("res", "d", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(d) error = %v", err)
+	}
+	if node.VirtualIP != "10.0.0.4" {
+		t.Errorf("node d got %s, want the unreserved 10.0.0.4", node.VirtualIP)
+	}
+}
+
+func TestReserveIPRange_AcrossRestartAndRepair(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	open := func() (*VirtualNetworkManager, *StorageManager) {
+		sm, err := NewStorageManager(dbPath)
+		if err != nil {
+			t.Fatalf("NewStorageManager() error = %v", err)
+		}
+		vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+		if err != nil {
+			t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+		}
+		return vnm, sm
+	}
+
+	vnm, sm := open()
+	if _, err := vnm.CreateVirtualNetwork("res", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.ReserveIPRange("res", "10.0.0.2/31"); err != nil {
+		t.Fatalf("ReserveIPRange() error = %v", err)
+	}
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// A new process restores the reservation with the pool state.
+	vnm, sm = open()
+	defer sm.Close()
+	node, err := vnm.CreateNode("res", "a", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if node.VirtualIP != "10.0.0.4" {
+		t.Errorf("node a got %s after restart, want 10.0.0.4", node.VirtualIP)
+	}
+
+	// Rebuilding the pool from the records keeps the reservation.
+	network, err := sm.GetNetworkByName("res")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	state.Allocated = nil
+	if err := sm.SaveIPPoolState(network.ID, state); err != nil {
+		t.Fatalf("SaveIPPoolState() error = %v", err)
+	}
+	report, err := vnm.RepairIPPool("res")
+	if err != nil || !report.Repaired {
+		t.Fatalf("RepairIPPool() = %+v, %v; want a repair", report, err)
+	}
+	if state, err = sm.GetIPPoolState(network.ID); err != nil || !slices.Equal(state.Reserved, []string{"10.0.0.2/31"}) {
+		t.Errorf("Reserved after repair = %v, %v; want [10.0.0.2/31]", state.Reserved, err)
+	}
+}
@@ -0,0 +1,146 @@
+package wedev
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+
+	"github.com/wedevctl/util"
+)
+
+// ListOrder is the order list commands return networks and nodes in.
+// Storage lists both by name; the others sort by a field and then by name,
+// so the order is the same on every run.
+type ListOrder string
+
+const (
+	// OrderByName sorts by name.
+	OrderByName ListOrder = "name"
+	// OrderByCreated sorts by creation time, oldest first.
+	OrderByCreated ListOrder = "created"
+	// OrderByIP sorts nodes by virtual IP.
+	OrderByIP ListOrder = "ip"
+)
+
+// NetworkListOrders and NodeListOrders are the orders networks and nodes
+// can be listed in.
+var (
+	NetworkListOrders = []ListOrder{OrderByName, OrderByCreated}
+	NodeListOrders    = []ListOrder{OrderByName, OrderByCreated, OrderByIP}
+)
+
+// SortNetworks sorts networks in order; an empty order is OrderByName.
+func SortNetworks(networks []*VirtualNetwork, order ListOrder) error {
+	var less func(a, b *VirtualNetwork) bool
+	switch order {
+	case "", OrderByName:
+		less = func(a, b *VirtualNetwork) bool { return a.Name < b.Name }
+	case OrderByCreated:
+		less = func(a, b *VirtualNetwork) bool {
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return a.Name < b.Name
+		}
+	default:
+		return kindErrorf(ErrValidation, "invalid sort order %q for networks: expected name or created", order)
+	}
+	sort.SliceStable(networks, func(i, j int) bool { return less(networks[i], networks[j]) })
+	return nil
+}
+
+// SortNodes sorts nodes in order; an empty order is OrderByName.
+func SortNodes(nodes []*Node, order ListOrder) error {
+	var less func(a, b *Node) bool
+	switch order {
+	case "", OrderByName:
+		less = func(a, b *Node) bool { return a.Name < b.Name }
+	case OrderByCreated:
+		less = func(a, b *Node) bool {
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return a.Name < b.Name
+		}
+	case OrderByIP:
+		less = func(a, b *Node) bool {
+			ipA, errA := netip.ParseAddr(a.VirtualIP)
+			ipB, errB := netip.ParseAddr(b.VirtualIP)
+			if errA != nil || errB != nil {
+				// Fall back to string order if a virtual IP is unparseable.
+				return a.VirtualIP < b.VirtualIP
+			}
+			return ipA.Less(ipB)
+		}
+	default:
+		return kindErrorf(ErrValidation, "invalid sort order %q for nodes: expected name, created, or ip", order)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return less(nodes[i], nodes[j]) })
+	return nil
+}
+
+// ListVirtualNetworksOrdered lists all virtual networks in order.
+func (vnm *VirtualNetworkManager) ListVirtualNetworksOrdered(ctx context.Context, order ListOrder) ([]*VirtualNetwork, error) {
+	networks, err := vnm.storage.ListNetworksCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := SortNetworks(networks, order); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// NodeListOptions selects, orders and pages the nodes ListNodesPage returns.
+type NodeListOptions struct {
+	Selector    util.LabelSelector // only nodes whose labels match
+	ExpiredOnly bool               // only nodes whose access has ended
+	Order       ListOrder          // empty is OrderByName
+	Offset      int                // matching nodes to skip
+	Limit       int                // most nodes to return; 0 for all
+}
+
+// NodeList is a page of the nodes of a network.
+type NodeList struct {
+	Nodes []*Node
+	Total int // nodes matching before Offset and Limit were applied
+}
+
+// ListNodesPage lists the nodes of a network matching opts, sorted, then
+// skips Offset of them and returns at most Limit. An offset past the end
+// returns no nodes.
+func (vnm *VirtualNetworkManager) ListNodesPage(ctx context.Context, networkName string, opts NodeListOptions) (*NodeList, error) {
+	if opts.Offset < 0 {
+		return nil, kindErrorf(ErrValidation, "invalid offset %d: must not be negative", opts.Offset)
+	}
+	if opts.Limit < 0 {
+		return nil, kindErrorf(ErrValidation, "invalid limit %d: must not be negative", opts.Limit)
+	}
+
+	nodes, err := vnm.ListNodesCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	now := vnm.now()
+	matched := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if opts.ExpiredOnly && !node.Expired(now) {
+			continue
+		}
+		if opts.Selector.Matches(node.Labels) {
+			matched = append(matched, node)
+		}
+	}
+	if err := SortNodes(matched, opts.Order); err != nil {
+		return nil, err
+	}
+
+	list := &NodeList{Total: len(matched)}
+	start := min(opts.Offset, len(matched))
+	end := len(matched)
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, end)
+	}
+	list.Nodes = matched[start:end]
+	return list, nil
+}
@@ -0,0 +1,142 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+func TestListOrder(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"bbolt": func(t *testing.T) Storage {
+			_, sm := newTestManager(t)
+			return sm
+		},
+		"memory": func(*testing.T) Storage { return NewMemoryStorage() },
+	}
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			vnm, err := NewVirtualNetworkManager(newStorage(t), util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+			for _, network := range []string{"zulu", "alpha", "mike"} {
+				if _, err := vnm.CreateVirtualNetwork(network, "10.0.0.0/24"); err != nil {
+					t.Fatalf("CreateVirtualNetwork(%s) error = %v", network, err)
+				}
+			}
+			if _, err := vnm.CreateServer("alpha", "hub", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			// zeta gets .2 and is deleted, so beta, created last, takes .2.
+			for _, node := range []string{"zeta", "alpha", "mid"} {
+				if _, err := vnm.CreateNode("alpha", node, "", 0, NodeTypeRoute); err != nil {
+					t.Fatalf("CreateNode(%s) error = %v", node, err)
+				}
+			}
+			if err := vnm.DeleteNode("alpha", "zeta"); err != nil {
+				t.Fatalf("DeleteNode() error = %v", err)
+			}
+			if _, err := vnm.CreateNode("alpha", "beta", "", 0, NodeTypeRoute); err != nil {
+				t.Fatalf("CreateNode(beta) error = %v", err)
+			}
+
+			networks, err := vnm.ListVirtualNetworks()
+			if err != nil {
+				t.Fatalf("ListVirtualNetworks() error = %v", err)
+			}
+			var names []string
+			for _, network := range networks {
+				names = append(names, network.Name)
+			}
+			if want := []string{"alpha", "mike", "zulu"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("ListVirtualNetworks() = %v, want %v", names, want)
+			}
+			networks, err = vnm.ListVirtualNetworksOrdered(context.Background(), OrderByCreated)
+			if err != nil {
+				t.Fatalf("ListVirtualNetworksOrdered() error = %v", err)
+			}
+			names = names[:0]
+			for _, network := range networks {
+				names = append(names, network.Name)
+			}
+			if want := []string{"zulu", "alpha", "mike"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("ListVirtualNetworksOrdered(created) = %v, want %v", names, want)
+			}
+			if _, err := vnm.ListVirtualNetworksOrdered(context.Background(), OrderByIP); !errors.Is(err, ErrValidation) {
+				t.Errorf("ListVirtualNetworksOrdered(ip) error = %v, want ErrValidation", err)
+			}
+
+			nodes, err := vnm.ListNodes("alpha")
+			if want := []string{"alpha", "beta", "mid"}; err != nil || !reflect.DeepEqual(nodeNames(nodes), want) {
+				t.Errorf("ListNodes() = %v, %v; want %v", nodeNames(nodes), err, want)
+			}
+			for order, want := range map[ListOrder][]string{
+				OrderByName:    {"alpha", "beta", "mid"},
+				OrderByCreated: {"alpha", "mid", "beta"},
+				OrderByIP:      {"beta", "alpha", "mid"},
+			} {
+				list, err := vnm.ListNodesPage(context.Background(), "alpha", NodeListOptions{Order: order})
+				if err != nil || !reflect.DeepEqual(nodeNames(list.Nodes), want) {
+					t.Errorf("ListNodesPage(%s) = %v, %v; want %v", order, nodeNames(list.Nodes), err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestListNodesPage(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"n1", "n2", "n3", "n4", "n5"} {
+		if _, err := vnm.CreateNode("office", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.UpdateNodeLabels("office", "n4", map[string]string{"role": "db"}, nil); err != nil {
+		t.Fatalf("UpdateNodeLabels() error = %v", err)
+	}
+	if _, err := vnm.UpdateNodeLabels("office", "n2", map[string]string{"role": "db"}, nil); err != nil {
+		t.Fatalf("UpdateNodeLabels() error = %v", err)
+	}
+	selector, _ := util.ParseLabelSelector("role=db")
+
+	tests := []struct {
+		name  string
+		opts  NodeListOptions
+		want  []string
+		total int
+	}{
+		{"all", NodeListOptions{}, []string{"n1", "n2", "n3", "n4", "n5"}, 5},
+		{"limit", NodeListOptions{Limit: 2}, []string{"n1", "n2"}, 5},
+		{"offset and limit", NodeListOptions{Offset: 2, Limit: 2}, []string{"n3", "n4"}, 5},
+		{"last page", NodeListOptions{Offset: 4, Limit: 2}, []string{"n5"}, 5},
+		{"past the end", NodeListOptions{Offset: 9}, []string{}, 5},
+		{"selector then page", NodeListOptions{Selector: selector, Offset: 1}, []string{"n4"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := vnm.ListNodesPage(context.Background(), "office", tt.opts)
+			if err != nil {
+				t.Fatalf("ListNodesPage() error = %v", err)
+			}
+			if !reflect.DeepEqual(nodeNames(list.Nodes), tt.want) || list.Total != tt.total {
+				t.Errorf("ListNodesPage() = %v of %d, want %v of %d", nodeNames(list.Nodes), list.Total, tt.want, tt.total)
+			}
+		})
+	}
+
+	for _, opts := range []NodeListOptions{{Offset: -1}, {Limit: -1}, {Order: "size"}} {
+		if _, err := vnm.ListNodesPage(context.Background(), "office", opts); !errors.Is(err, ErrValidation) {
+			t.Errorf("ListNodesPage(%+v) error = %v, want ErrValidation", opts, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultLockTimeout is how long NewStorageManager waits for another
+	// process to release the database lock before giving up.
+	DefaultLockTimeout = 5 * time.Second
+
+	// Lock attempts back off from lockInitialBackoff, doubling up to
+	// lockMaxBackoff, until the timeout passes.
+	lockInitialBackoff = 25 * time.Millisecond
+	lockMaxBackoff     = 500 * time.Millisecond
+)
+
+// openWithRetry opens the database, for writing unless readOnly, retrying
+// with exponential backoff while another process holds a conflicting lock. bbolt's own Timeout is kept
+// at its minimum so each attempt is a single non-blocking try; the waiting
+// happens here, where it can back off and produce a useful error. Cancelling
+// ctx stops the wait.
+func openWithRetry(ctx context.Context, dbPath string, timeout time.Duration, readOnly bool) (*bbolt.DB, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := lockInitialBackoff
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("gave up waiting for database %s: %w", dbPath, err)
+		}
+		db, err := bbolt.Open(dbPath, 0o600, &bbolt.Options{Timeout: time.Nanosecond, ReadOnly: readOnly})
+		if err == nil {
+			return db, nil
+		}
+		if !errors.Is(err, bbolt.ErrTimeout) {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, lockedError(dbPath)
+		}
+		timer := time.NewTimer(min(backoff, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, lockMaxBackoff)
+	}
+}
+
+// lockedError describes a database held by another process, naming the
+// holder's pid when the lock info file points at a live process.
+func lockedError(dbPath string) error {
+	if pid, ok := lockHolder(dbPath); ok {
+		return kindErrorf(ErrDBLocked, "database %s is locked by another wedevctl process (pid %d); wait for it to finish or raise --db-timeout", dbPath, pid)
+	}
+	return kindErrorf(ErrDBLocked, "database %s is locked by another wedevctl process; wait for it to finish or raise --db-timeout", dbPath)
+}
+
+// lockInfoPath is the sidecar file recording which process holds dbPath.
+func lockInfoPath(dbPath string) string {
+	return dbPath + ".pid"
+}
+
+// writeLockInfo records this process as the lock holder. It is only a hint
+// for error messages, so failures are ignored.
+func writeLockInfo(dbPath string) {
+	//nolint:errcheck // Best effort: the pid file only improves error messages
+	_ = os.WriteFile(lockInfoPath(dbPath), []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600)
+}
+
+// removeLockInfo deletes the lock info file if it still names this process.
+func removeLockInfo(dbPath string) {
+	if pid, err := readLockInfo(dbPath); err == nil && pid == os.Getpid() {
+		//nolint:errcheck // Best effort: a leftover pid file is detected as stale
+		_ = os.Remove(lockInfoPath(dbPath))
+	}
+}
+
+// readLockInfo returns the pid recorded in the lock info file.
+func readLockInfo(dbPath string) (int, error) {
+	data, err := os.ReadFile(lockInfoPath(dbPath))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// lockHolder returns the pid recorded as holding dbPath. A pid file left by a
+// process that has since exited is stale — the kernel released its lock when
+// it died — and is reported as no holder.
+func lockHolder(dbPath string) (int, bool) {
+	pid, err := readLockInfo(dbPath)
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		return 0, false
+	}
+	return pid, processAlive(pid)
+}
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	// EPERM means the process exists but belongs to another user.
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,188 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestNewStorageManager_LockedDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	holder, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if pid, err := readLockInfo(dbPath); err != nil || pid != os.Getpid() {
+		t.Errorf("lock info = %d (err %v), want this process", pid, err)
+	}
+
+	start := time.Now()
+	_, err = NewStorageManagerWithOptions(dbPath, StorageOptions{LockTimeout: 200 * time.Millisecond})
+	if !errors.Is(err, ErrDBLocked) || !strings.Contains(err.Error(), "locked by another wedevctl process") {
+		t.Fatalf("second open error = %v, want locked error", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("second open gave up after %s, want it to retry for the full timeout", elapsed)
+	}
+
+	// A live holder recorded in the lock info file is named in the error.
+	parent := os.Getppid()
+	if err := os.WriteFile(lockInfoPath(dbPath), []byte(strconv.Itoa(parent)), 0o600); err != nil {
+		t.Fatalf("failed to write lock info: %v", err)
+	}
+	if _, err := NewStorageManagerWithOptions(dbPath, StorageOptions{LockTimeout: 10 * time.Millisecond}); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("(pid %d)", parent)) {
+		t.Errorf("locked error = %v, want pid %d", err, parent)
+	}
+
+	// A pid file naming a process that no longer exists is stale.
+	if err := os.WriteFile(lockInfoPath(dbPath), []byte("2147483646"), 0o600); err != nil {
+		t.Fatalf("failed to write lock info: %v", err)
+	}
+	if _, err := NewStorageManagerWithOptions(dbPath, StorageOptions{LockTimeout: 10 * time.Millisecond}); err == nil || strings.Contains(err.Error(), "pid") {
+		t.Errorf("locked error with stale pid file = %v, want no pid hint", err)
+	}
+
+	holder.Close()
+	sm, err := NewStorageManagerWithOptions(dbPath, StorageOptions{LockTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("open after release error = %v", err)
+	}
+	sm.Close()
+	if _, err := os.Stat(lockInfoPath(dbPath)); !os.IsNotExist(err) {
+		t.Errorf("Close() left the lock info file behind (stat err %v)", err)
+	}
+}
+
+// readOnlyHelperEnv names the database TestReadOnlyHelperProcess opens when
+// the test binary is re-run as a second process.
+const readOnlyHelperEnv = "WEDEVCTL_TEST_READ_ONLY_DB"
+
+// TestReadOnlyHelperProcess is not a real test: run by
+// TestNewStorageManager_ReadOnly in a child process, it opens the database
+// read-only while the parent holds its own read-only handle.
+func TestReadOnlyHelperProcess(t *testing.T) {
+	dbPath := os.Getenv(readOnlyHelperEnv)
+	if dbPath == "" {
+		t.Skip("helper process for TestNewStorageManager_ReadOnly")
+	}
+	sm, err := NewStorageManagerWithOptions(dbPath, StorageOptions{ReadOnly: true, LockTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("read-only open in second process error = %v", err)
+	}
+	defer sm.Close()
+	if networks, err := sm.ListNetworks(); err != nil || len(networks) != 1 {
+		t.Fatalf("ListNetworks() = %d networks (err %v), want 1", len(networks), err)
+	}
+}
+
+func TestNewStorageManager_ReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	readOnly := StorageOptions{ReadOnly: true, LockTimeout: 10 * time.Millisecond}
+
+	if _, err := NewStorageManagerWithOptions(dbPath, readOnly); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read-only open of a missing database error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("read-only open created the database (stat err %v)", err)
+	}
+
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if _, err := sm.CreateNetwork("ro", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	sm.Close()
+
+	first, err := NewStorageManagerWithOptions(dbPath, readOnly)
+	if err != nil {
+		t.Fatalf("first read-only open error = %v", err)
+	}
+	defer first.Close()
+	if !first.ReadOnly() {
+		t.Error("ReadOnly() = false for a read-only manager")
+	}
+	if _, err := os.Stat(lockInfoPath(dbPath)); !os.IsNotExist(err) {
+		t.Errorf("a read-only open wrote the lock info file (stat err %v)", err)
+	}
+
+	// Another process shares the lock...
+	child := exec.Command(os.Args[0], "-test.run=^TestReadOnlyHelperProcess$")
+	child.Env = append(os.Environ(), readOnlyHelperEnv+"="+dbPath)
+	if out, err := child.CombinedOutput(); err != nil {
+		t.Errorf("second read-only process failed: %v\n%s", err, out)
+	}
+
+	// ...but a writer waits for the readers.
+	if _, err := NewStorageManagerWithOptions(dbPath, StorageOptions{LockTimeout: 50 * time.Millisecond}); !errors.Is(err, ErrDBLocked) {
+		t.Errorf("read-write open beside a reader error = %v, want locked error", err)
+	}
+
+	if _, err := first.CreateNetwork("blocked", "10.1.0.0/24"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CreateNetwork() through a read-only manager error = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestNewStorageManager_ReadOnlyOutdatedSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(metaKeySchemaVersion), []byte("1"))
+	}); err != nil {
+		t.Fatalf("failed to roll back the schema version: %v", err)
+	}
+	sm.Close()
+
+	if _, err := NewStorageManagerWithOptions(dbPath, StorageOptions{ReadOnly: true}); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("read-only open of an outdated database error = %v, want ErrSchemaOutdated", err)
+	}
+}
+
+func TestLoadIPPool_ReloadsFromDatabase(t *testing.T) {
+	vnm1, sm := newTestManager(t)
+	vnm2, err := NewVirtualNetworkManager(sm, vnm1.validator)
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+
+	if _, err := vnm1.CreateVirtualNetwork("shared", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	// Alternate allocations between two managers with their own caches,
+	// as two CLI processes would; every node must get a distinct IP.
+	seen := make(map[string]string)
+	for i, vnm := range []*VirtualNetworkManager{vnm1, vnm2, vnm1, vnm2} {
+		name := fmt.Sprintf("n%d", i)
+		node, err := vnm.CreateNode("shared", name, "", 51820, NodeTypeRoute)
+		if err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+		if other, dup := seen[node.VirtualIP]; dup {
+			t.Fatalf("nodes %s and %s were both given %s", other, name, node.VirtualIP)
+		}
+		seen[node.VirtualIP] = name
+	}
+
+	// A release by one manager is visible to the other.
+	if err := vnm2.DeleteNode("shared", "n0"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+	node, err := vnm1.CreateNode("shared", "n4", "", 51820, NodeTypeRoute)
+	if err != nil || node.VirtualIP != "10.0.0.2" {
+		t.Errorf("CreateNode() after release = %+v (err %v), want recycled 10.0.0.2", node, err)
+	}
+}
@@ -0,0 +1,64 @@
+package wedev
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// NewLogger returns a logger writing text records at level and above to w.
+// StorageManager, VirtualNetworkManager, and WireGuardConfigGenerator log
+// warnings (recoverable data problems) and debug detail (transaction timings,
+// IP pool decisions) through it.
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		// Drop the timestamp: CLI diagnostics are read as they happen.
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+// observeTx logs a finished transaction at debug level and records it in
+// metrics, named after the StorageManager method that ran it.
+func observeTx(logger *slog.Logger, metrics *Metrics, kind string, start time.Time, err error) {
+	debug := logger.Enabled(context.Background(), slog.LevelDebug)
+	if !debug && metrics == nil {
+		return
+	}
+	duration := time.Since(start)
+
+	// Skip observeTx and the update/view wrappers to reach the storage method.
+	// Ctx variants log under their plain name so ops read the same either way.
+	op := "unknown"
+	pcs := make([]uintptr, 8)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		name := strings.TrimSuffix(frame.Function[strings.LastIndex(frame.Function, ".")+1:], "Ctx")
+		if name != "update" && name != "view" && name != "" {
+			op = name
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	metrics.observeTx(kind, op, duration, err)
+	if !debug {
+		return
+	}
+	attrs := []any{"op", op, "duration", duration}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Debug(kind+" transaction", attrs...)
+}
@@ -0,0 +1,120 @@
+package wedev
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// newLoggedManager opens a manager whose logger writes to the returned buffer.
+func newLoggedManager(t *testing.T, level slog.Level) (*VirtualNetworkManager, *StorageManager, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	sm, err := NewStorageManagerWithOptions(filepath.Join(t.TempDir(), "test.db"), StorageOptions{
+		Logger: NewLogger(&buf, level),
+	})
+	if err != nil {
+		t.Fatalf("NewStorageManagerWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	return vnm, sm, &buf
+}
+
+func TestLogging_Debug(t *testing.T) {
+	vnm, sm, buf := newLoggedManager(t, slog.LevelDebug)
+
+	network, err := vnm.CreateVirtualNetwork("logs", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("logs", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	// The cached pool would be used as is; a dropped one is restored.
+	vnm.InvalidateIPPool(network.ID)
+	if _, err := vnm.CreateNode("logs", "n1", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, _, err := NewWireGuardConfigGenerator(sm).GenerateConfigs("logs", sm); err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg="applied schema migration"`,
+		`msg="update transaction" op=CreateNetwork duration=`,
+		`msg="view transaction" op=GetNetworkByName`,
+		`msg="restored IP pool"`,
+		`msg="allocated node IP" network=logs node=n1 ip=10.0.0.2`,
+		`msg="generated configs" network=logs configs=2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("debug log is missing %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "time=") {
+		t.Errorf("log records should not carry timestamps:\n%s", out)
+	}
+}
+
+func TestLogging_Levels(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		level    slog.Level
+		wantWarn bool
+	}{
+		{"warn shows warnings", slog.LevelWarn, true},
+		{"error silences warnings", slog.LevelError, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vnm, sm, buf := newLoggedManager(t, tt.level)
+			network, err := vnm.CreateVirtualNetwork("logs", "10.0.0.0/24")
+			if err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+
+			// An unrestorable pool state makes the manager warn and rebuild it.
+			if err := sm.SaveIPPoolState(network.ID, &util.IPPoolState{NetworkCIDR: network.CIDR, Reserved: []string{"bogus"}}); err != nil {
+				t.Fatalf("SaveIPPoolState() error = %v", err)
+			}
+			if _, err := vnm.CreateNode("logs", "n1", "", 0, NodeTypeRoute); err != nil {
+				t.Fatalf("CreateNode() error = %v", err)
+			}
+
+			out := buf.String()
+			if got := strings.Contains(out, "level=WARN msg=\"failed to restore IP pool state, reconstructing\""); got != tt.wantWarn {
+				t.Errorf("warning logged = %v, want %v:\n%s", got, tt.wantWarn, out)
+			}
+			if strings.Contains(out, "level=DEBUG") {
+				t.Errorf("debug records logged above debug level:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestPrivilegedPortWarning(t *testing.T) {
+	vnm, _, buf := newLoggedManager(t, slog.LevelWarn)
+	if _, err := vnm.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("testnet", "gw", "1.2.3.4", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("CreateServer(51820) logged %q, want nothing", buf.String())
+	}
+	if _, err := vnm.CreateNode("testnet", "n1", "1.2.3.4", 443, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(443) error = %v", err)
+	}
+	if want := `msg="listen port below 1024 needs extra privileges to bind" node=n1 port=443`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log = %q, want %s", buf.String(), want)
+	}
+}
@@ -2,70 +2,134 @@
 package wedev
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net/netip"
 	"os"
+	"os/user"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/wedevctl/util"
+	"github.com/wedevctl/version"
 )
 
+// DefaultWireGuardPort is the WireGuard listen port used when neither the
+// command nor the network specifies one.
+const DefaultWireGuardPort = 51820
+
 // VirtualNetworkManager manages virtual networks and their resources
 type VirtualNetworkManager struct {
-	storage   *StorageManager
-	ipPools   map[string]*util.IPPool // networkID -> IPPool
+	storage   Storage
+	pools     *ipPoolCache // IP pools by network ID; see loadIPPool
 	validator util.IPValidator
+	logger    *slog.Logger
+	now       func() time.Time // clock for node expiry; replaced in tests
+
+	// poolMu serializes operations that load, mutate, and persist an IP pool
+	// within this process; the database file lock serializes processes.
+	poolMu sync.Mutex
 }
 
-// NewVirtualNetworkManager creates a new VirtualNetworkManager
-func NewVirtualNetworkManager(storage *StorageManager, validator util.IPValidator) (*VirtualNetworkManager, error) {
+// NewVirtualNetworkManager creates a new VirtualNetworkManager. It logs
+// through the storage's logger.
+func NewVirtualNetworkManager(storage Storage, validator util.IPValidator) (*VirtualNetworkManager, error) {
 	return &VirtualNetworkManager{
 		storage:   storage,
-		ipPools:   make(map[string]*util.IPPool),
-		validator: validator,
+		pools:     newIPPoolCache(DefaultIPPoolCacheSize),
+		validator: validationErrors{validator},
+		logger:    storage.Logger(),
+		now:       time.Now,
 	}, nil
 }
 
-// ensureIPPool ensures an IP pool exists for the network and is properly initialized
-// with all existing IP allocations from the database
-func (vnm *VirtualNetworkManager) ensureIPPool(networkID, networkCIDR string) error {
-	if _, exists := vnm.ipPools[networkID]; exists {
-		return nil
+// loadIPPool returns the network's IP pool, with all existing IP
+// allocations. Another process may have allocated addresses since this
+// manager last looked, so the cached pool is only used when it matches the
+// revision of the saved state and the network's current CIDR, which 'vn
+// resize' changes; otherwise the pool is restored from the saved state, or
+// rebuilt from the records. Callers hold poolMu; once they change the pool
+// they save it and cache it with cacheIPPool, or invalidate it on failure.
+// A network whose CIDR cannot hold a pool fails with checkNetworkCIDR's
+// error.
+func (vnm *VirtualNetworkManager) loadIPPool(network *VirtualNetwork) (*util.IPPool, error) {
+	if err := checkNetworkCIDR(network); err != nil {
+		return nil, err
+	}
+	networkID, networkCIDR := network.ID, network.CIDR
+	state, stateErr := vnm.storage.GetIPPoolState(networkID)
+	if stateErr == nil {
+		if cached, ok := vnm.pools.get(networkID); ok && cached.revision == state.Revision && cached.cidr == networkCIDR {
+			return cached.pool, nil
+		}
+		if state.NetworkCIDR == networkCIDR {
+			ipPool, restoreErr := util.RestoreIPPool(state)
+			if restoreErr == nil {
+				vnm.cacheIPPool(networkID, networkCIDR, ipPool, state)
+				vnm.logger.Debug("restored IP pool", "network", networkID, "allocated", len(state.Allocated), "recycled", len(state.Recycled), "next_index", state.NextIndex)
+				return ipPool, nil
+			}
+			// If restore fails, fall back to reconstruction
+			vnm.logger.Warn("failed to restore IP pool state, reconstructing", "network", networkID, "error", restoreErr)
+		} else {
+			vnm.logger.Warn("saved IP pool state is for another CIDR, reconstructing", "network", networkID, "saved", state.NetworkCIDR, "cidr", networkCIDR)
+		}
 	}
 
-	// Try to restore IP pool state from database first
-	if state, err := vnm.storage.GetIPPoolState(networkID); err == nil {
-		ipPool, restoreErr := util.RestoreIPPool(state)
-		if restoreErr == nil {
-			vnm.ipPools[networkID] = ipPool
-			return nil
+	// Rebuild the pool from the records (fallback if no saved state exists)
+	ipPool, err := vnm.rebuildIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only save the reconstructed state if no saved state exists
+	// Don't overwrite an existing saved state with a reconstruction
+	if stateErr != nil {
+		state = ipPool.GetState()
+		if saveErr := vnm.storage.SaveIPPoolState(networkID, state); saveErr != nil {
+			return nil, fmt.Errorf("failed to save reconstructed IP pool state: %w", saveErr)
 		}
-		// If restore fails, fall back to reconstruction
-		fmt.Fprintf(os.Stderr, "Warning: failed to restore IP pool state, reconstructing: %v\n", restoreErr)
 	}
+	vnm.cacheIPPool(networkID, networkCIDR, ipPool, state)
+
+	return ipPool, nil
+}
 
-	// Create new IP pool (fallback if no saved state exists)
+// rebuildIPPool builds a network's IP pool from its server, node and guest
+// records, which are authoritative for which addresses are in use.
+func (vnm *VirtualNetworkManager) rebuildIPPool(network *VirtualNetwork) (*util.IPPool, error) {
+	if err := checkNetworkCIDR(network); err != nil {
+		return nil, err
+	}
+	networkID, networkCIDR := network.ID, network.CIDR
 	ipPool, err := util.NewIPPool(networkCIDR)
 	if err != nil {
-		return fmt.Errorf("failed to create IP pool: %w", err)
+		return nil, fmt.Errorf("failed to create IP pool: %w", err)
 	}
 
-	// Load existing server and mark its IP as allocated
-	server, err := vnm.storage.GetServerByNetworkID(networkID)
-	if err == nil && server != nil {
-		// Server IP is already reserved by GetServerIP(), but we need to mark it as allocated
+	// Load existing servers and mark their IPs as allocated
+	servers, err := vnm.storage.ListServersByNetworkID(networkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing servers: %w", err)
+	}
+	for _, server := range servers {
+		// The first server's IP is already reserved by GetServerIP(), but we need to mark it as allocated
 		if markErr := ipPool.MarkIPAllocated(server.VirtualIP); markErr != nil {
-			return fmt.Errorf("failed to mark server IP as allocated: %w", markErr)
+			return nil, fmt.Errorf("failed to mark server IP as allocated: %w", markErr)
 		}
 	}
 
 	// Load existing nodes and mark their IPs as allocated
 	nodes, err := vnm.storage.ListNodesByNetworkID(networkID)
 	if err != nil {
-		return fmt.Errorf("failed to load existing nodes: %w", err)
+		return nil, fmt.Errorf("failed to load existing nodes: %w", err)
 	}
 
 	// Mark all existing node IPs as allocated
@@ -74,64 +138,107 @@ func (vnm *VirtualNetworkManager) ensureIPPool(networkID, networkCIDR string) er
 			// If IP is already allocated, it means we have duplicate IPs in the database
 			// This is a data integrity issue, but we'll log it and continue
 			// rather than failing completely
-			fmt.Fprintf(os.Stderr, "Warning: duplicate IP detected for node %s: %s\n", node.Name, node.VirtualIP)
+			vnm.logger.Warn("duplicate IP detected", "node", node.Name, "ip", node.VirtualIP)
+		}
+	}
+
+	// Guests hold their addresses until they are revoked or purged.
+	guests, err := vnm.storage.ListGuestsByNetworkID(networkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing guests: %w", err)
+	}
+	for _, guest := range guests {
+		if markErr := ipPool.MarkIPAllocated(guest.VirtualIP); markErr != nil {
+			vnm.logger.Warn("duplicate IP detected", "guest", guest.Name, "ip", guest.VirtualIP)
 		}
 	}
 
 	// Sync nextIndex to ensure new allocations don't conflict with existing ones
 	ipPool.SyncNextIndex()
 
-	vnm.ipPools[networkID] = ipPool
-
-	// Only save the reconstructed state if no saved state exists
-	// Don't overwrite an existing saved state with a reconstruction
-	_, err = vnm.storage.GetIPPoolState(networkID)
-	if err != nil {
-		// No saved state exists, save the reconstructed one
-		if saveErr := vnm.storage.SaveIPPoolState(networkID, ipPool.GetState()); saveErr != nil {
-			return fmt.Errorf("failed to save reconstructed IP pool state: %w", saveErr)
+	// Reservations live only in the saved state; carry them over.
+	if state, stateErr := vnm.storage.GetIPPoolState(networkID); stateErr == nil && state.NetworkCIDR == networkCIDR {
+		if err := keepReservations(ipPool, state); err != nil {
+			vnm.logger.Warn("failed to keep IP reservations", "network", networkID, "error", err)
 		}
 	}
+	vnm.logger.Debug("reconstructed IP pool from records", "network", networkID, "nodes", len(nodes))
 
-	return nil
+	return ipPool, nil
 }
 
 // reservedNetworkNames are names that collide with `vn` CLI subcommands (and
 // cobra's built-in commands). A network with one of these names would be
 // unreachable via `wedevctl vn <name> ...`, so they are rejected at creation.
 var reservedNetworkNames = map[string]bool{
-	"add": true, "list": true, "delete": true, "help": true, "completion": true,
+	"add": true, "list": true, "delete": true, "rename": true, "edit": true, "clone": true, "help": true, "completion": true,
+	"ls": true, "rm": true, // aliases of list and delete
 }
 
 // CreateVirtualNetwork creates a new virtual network.
 func (vnm *VirtualNetworkManager) CreateVirtualNetwork(name, cidr string) (*VirtualNetwork, error) {
+	return vnm.CreateVirtualNetworkCtx(context.Background(), name, cidr)
+}
+
+// CreateVirtualNetworkCtx is CreateVirtualNetwork with a context.
+func (vnm *VirtualNetworkManager) CreateVirtualNetworkCtx(ctx context.Context, name, cidr string) (*VirtualNetwork, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
 	// Validate input
 	if err := vnm.validator.IsValidNetworkName(name); err != nil {
 		return nil, err
 	}
 	if reservedNetworkNames[name] {
-		return nil, fmt.Errorf("network name %q is reserved (it collides with a CLI command)", name)
+		return nil, kindErrorf(ErrValidation, "network name %q is reserved (it collides with a CLI command)", name)
 	}
-	if err := vnm.validator.IsValidCIDR(cidr); err != nil {
+	cidr, err := vnm.networkCIDR(cidr)
+	if err != nil {
 		return nil, err
 	}
 
-	// Create IP pool
-	ipPool, err := util.NewIPPool(cidr)
-	if err != nil {
+	// Check the range can hold an IP pool; the pool itself is built and
+	// saved on first use (see loadIPPool).
+	if _, err := util.NewIPPool(cidr); err != nil {
 		return nil, fmt.Errorf("failed to create IP pool: %w", err)
 	}
 
 	// Create network in storage
-	network, err := vnm.storage.CreateNetwork(name, cidr)
+	return vnm.storage.CreateNetworkCtx(ctx, name, cidr)
+}
+
+// minNetworkPrefix is the longest IPv4 prefix a network may have: a /30
+// holds the server and one node.
+const minNetworkPrefix = 30
+
+// networkCIDR validates the CIDR of a new network and returns it normalized
+// to its network address. Networks too small for a server and a node are
+// rejected.
+func (vnm *VirtualNetworkManager) networkCIDR(cidr string) (string, error) {
+	if err := vnm.validator.IsValidCIDR(cidr); err != nil {
+		return "", err
+	}
+	normalized, err := util.NormalizeCIDR(cidr)
 	if err != nil {
-		return nil, err
+		return "", kindErrorf(ErrValidation, "%w", err)
 	}
+	//nolint:errcheck // NormalizeCIDR parsed it
+	prefix, _ := netip.ParsePrefix(normalized)
+	if prefix.Addr().Is4() && prefix.Bits() > minNetworkPrefix {
+		return "", kindErrorf(ErrValidation, "network %s is too small (/%d); use a /%d or shorter prefix to hold the server and a node", normalized, prefix.Bits(), minNetworkPrefix)
+	}
+	return normalized, nil
+}
 
-	// Store IP pool
-	vnm.ipPools[network.ID] = ipPool
-
-	return network, nil
+// checkNetworkCIDR returns an ErrValidation naming the fix when a stored
+// network's CIDR cannot hold an IP pool: a record written by an older build,
+// which accepted IPv6 ranges, or edited by hand. Operations that need the
+// pool fail with it; those that do not still work on the network.
+func checkNetworkCIDR(network *VirtualNetwork) error {
+	if _, err := util.NewIPPool(network.CIDR); err != nil {
+		return kindErrorf(ErrValidation, "network %s has invalid CIDR '%s'; fix with 'vn edit --cidr'", network.Name, network.CIDR)
+	}
+	return nil
 }
 
 // GetVirtualNetwork retrieves a virtual network by name
@@ -139,26 +246,257 @@ func (vnm *VirtualNetworkManager) GetVirtualNetwork(name string) (*VirtualNetwor
 	return vnm.storage.GetNetworkByName(name)
 }
 
-// ListVirtualNetworks lists all virtual networks
+// GetVirtualNetworkCtx is GetVirtualNetwork with a context.
+func (vnm *VirtualNetworkManager) GetVirtualNetworkCtx(ctx context.Context, name string) (*VirtualNetwork, error) {
+	return vnm.storage.GetNetworkByNameCtx(ctx, name)
+}
+
+// ListVirtualNetworks lists all virtual networks, sorted by name.
 func (vnm *VirtualNetworkManager) ListVirtualNetworks() ([]*VirtualNetwork, error) {
 	return vnm.storage.ListNetworks()
 }
 
+// ListVirtualNetworksCtx is ListVirtualNetworks with a context.
+func (vnm *VirtualNetworkManager) ListVirtualNetworksCtx(ctx context.Context) ([]*VirtualNetwork, error) {
+	return vnm.storage.ListNetworksCtx(ctx)
+}
+
+// RenameVirtualNetwork renames a virtual network.
+func (vnm *VirtualNetworkManager) RenameVirtualNetwork(oldName, newName string) (*VirtualNetwork, error) {
+	if err := vnm.validator.IsValidNetworkName(newName); err != nil {
+		return nil, err
+	}
+	if reservedNetworkNames[newName] {
+		return nil, kindErrorf(ErrValidation, "network name %q is reserved (it collides with a CLI command)", newName)
+	}
+
+	return vnm.storage.RenameNetwork(oldName, newName)
+}
+
+// UpdateVirtualNetworkLabels sets the labels in set and removes the keys in
+// remove from a virtual network.
+func (vnm *VirtualNetworkManager) UpdateVirtualNetworkLabels(name string, set map[string]string, remove []string) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{SetLabels: set, RemoveLabels: remove})
+}
+
+// SetDefaultPort sets the port nodes of the network get when none is given.
+func (vnm *VirtualNetworkManager) SetDefaultPort(name string, port int) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{DefaultPort: &port})
+}
+
+// SetFilenameTemplate stores the template config files of the network are
+// named with (see ParseFilenameTemplate); "" restores the default.
+func (vnm *VirtualNetworkManager) SetFilenameTemplate(name, tmpl string) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{FilenameTemplate: &tmpl})
+}
+
+// SetTopology sets how the network's nodes peer. Configs already generated
+// are unchanged; the next 'config generate' produces a new version.
+func (vnm *VirtualNetworkManager) SetTopology(name string, topology Topology) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{Topology: &topology})
+}
+
+// SetNATMode sets the firewall rules server configs get for routed subnets.
+// Configs already generated are unchanged; the next 'config generate'
+// produces a new version.
+func (vnm *VirtualNetworkManager) SetNATMode(name string, mode NATMode) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{NATMode: &mode})
+}
+
+// SetDNS sets the DNS servers written into the network's node configs. An
+// empty list removes them.
+func (vnm *VirtualNetworkManager) SetDNS(name string, dns []string) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{DNS: &dns})
+}
+
+// normalizeDNS validates DNS server addresses and returns them in canonical
+// form, or nil for an empty list.
+func normalizeDNS(dns []string) ([]string, error) {
+	if len(dns) == 0 {
+		return nil, nil
+	}
+	servers := make([]string, 0, len(dns))
+	for _, s := range dns {
+		addr, err := netip.ParseAddr(strings.TrimSpace(s))
+		if err != nil {
+			return nil, kindErrorf(ErrValidation, "invalid DNS server %q: must be an IP address", s)
+		}
+		servers = append(servers, addr.String())
+	}
+	return servers, nil
+}
+
+// ResizeNetwork expands a network to newCIDR, which must keep the network
+// address and use a shorter prefix so every existing address and IP pool
+// index stays valid. The IP pool is rebuilt for the larger range and, when the
+// network has a server, a new config version is saved because node configs
+// carry the network CIDR. The returned ConfigVersion is nil without a server.
+// A network whose config is frozen is not resized (ErrFrozen).
+//
+// A network whose stored CIDR cannot hold a pool (see checkNetworkCIDR) can
+// be given any valid newCIDR that holds its addresses; its pool is rebuilt
+// from the records.
+func (vnm *VirtualNetworkManager) ResizeNetwork(name, newCIDR string) (*VirtualNetwork, *ConfigVersion, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkFrozen(network); err != nil {
+		return nil, nil, err
+	}
+	if err := vnm.validator.IsValidCIDR(newCIDR); err != nil {
+		return nil, nil, err
+	}
+
+	invalid := checkNetworkCIDR(network) != nil
+	oldPrefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil && !invalid {
+		return nil, nil, fmt.Errorf("invalid network CIDR %s: %w", network.CIDR, err)
+	}
+	oldPrefix = oldPrefix.Masked()
+	newPrefix, err := netip.ParsePrefix(newCIDR)
+	if err != nil {
+		return nil, nil, kindErrorf(ErrValidation, "invalid CIDR %s: %w", newCIDR, err)
+	}
+	newPrefix = newPrefix.Masked()
+
+	if invalid {
+		if _, err := vnm.networkCIDR(newPrefix.String()); err != nil {
+			return nil, nil, err
+		}
+	} else if newPrefix == oldPrefix {
+		return nil, nil, kindErrorf(ErrValidation, "network %s already uses CIDR %s", name, oldPrefix)
+	} else if newPrefix.Addr() != oldPrefix.Addr() || newPrefix.Bits() > oldPrefix.Bits() {
+		msg := fmt.Sprintf("cannot change CIDR from %s to %s: only expanding to a shorter prefix with the same network address (e.g. %s/%d) is supported",
+			oldPrefix, newPrefix, oldPrefix.Addr(), oldPrefix.Bits()-1)
+		outside, err := vnm.addressesOutside(network.ID, newPrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(outside) > 0 {
+			msg += "; these addresses would fall outside it: " + strings.Join(outside, ", ")
+		}
+		return nil, nil, kindErrorf(ErrValidation, "%s", msg)
+	}
+
+	// A larger range may swallow a LAN subnet a route node exposes.
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range nodes {
+		for _, cidr := range node.RoutedCIDRs {
+			if routed, err := netip.ParsePrefix(cidr); err == nil && routed.Overlaps(newPrefix) {
+				return nil, nil, kindErrorf(ErrValidation, "CIDR %s overlaps %s routed by node %s", newPrefix, cidr, node.Name)
+			}
+		}
+	}
+
+	outside, err := vnm.addressesOutside(network.ID, newPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(outside) > 0 {
+		return nil, nil, kindErrorf(ErrValidation, "these addresses would fall outside %s: %s", newPrefix, strings.Join(outside, ", "))
+	}
+
+	var pool *util.IPPool
+	if invalid {
+		repaired := *network
+		repaired.CIDR = newPrefix.String()
+		if pool, err = vnm.rebuildIPPool(&repaired); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		ipPool, err := vnm.loadIPPool(network)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pool, err = ipPool.Resize(newPrefix.String()); err != nil {
+			return nil, nil, fmt.Errorf("failed to resize IP pool: %w", err)
+		}
+	}
+
+	state := pool.GetState()
+	resized, err := vnm.storage.ResizeNetwork(network.ID, newPrefix.String(), state)
+	if err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return nil, nil, err
+	}
+	vnm.cacheIPPool(network.ID, resized.CIDR, pool, state)
+
+	// Without a server, only a mesh network has configs to save.
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return resized, nil, err
+	}
+	if len(servers) == 0 && resized.NeedsServer() {
+		return resized, nil, nil
+	}
+	message := fmt.Sprintf("network resized to %s", resized.CIDR)
+	version, _, err := NewWireGuardConfigGenerator(vnm.storage).SaveConfigVersionWithMessage(name, message)
+	if err != nil {
+		return resized, nil, fmt.Errorf("network resized, but saving a config version failed: %w", err)
+	}
+
+	return resized, version, nil
+}
+
+// addressesOutside lists the virtual IPs of a network's server and nodes that
+// are not contained in prefix.
+func (vnm *VirtualNetworkManager) addressesOutside(networkID string, prefix netip.Prefix) ([]string, error) {
+	var outside []string
+	check := func(entity, ip string) {
+		if addr, err := netip.ParseAddr(ip); err != nil || !prefix.Contains(addr) {
+			outside = append(outside, fmt.Sprintf("%s (%s)", ip, entity))
+		}
+	}
+
+	servers, err := vnm.storage.ListServersByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		check(server.Name, server.VirtualIP)
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		check(node.Name, node.VirtualIP)
+	}
+
+	sort.Strings(outside)
+	return outside, nil
+}
+
 // DeleteVirtualNetwork deletes a virtual network
 func (vnm *VirtualNetworkManager) DeleteVirtualNetwork(name string) error {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
 	network, err := vnm.storage.GetNetworkByName(name)
 	if err != nil {
 		return err
 	}
 
 	// Remove IP pool
-	delete(vnm.ipPools, network.ID)
+	vnm.InvalidateIPPool(network.ID)
 
 	return vnm.storage.DeleteNetwork(name)
 }
 
-// CreateServer creates a new server in the network.
+// CreateServer creates a new server in the network. The first server gets
+// the pool's reserved server IP; further servers are allocated addresses the
+// way nodes are.
 func (vnm *VirtualNetworkManager) CreateServer(networkName, serverName, publicAddress string, port int) (*Server, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
 	// Get network
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
@@ -172,17 +510,18 @@ func (vnm *VirtualNetworkManager) CreateServer(networkName, serverName, publicAd
 	}
 
 	// Validate public address
-	if valErr := vnm.validator.IsValidPublicAddress(publicAddress); valErr != nil {
+	if valErr := vnm.validateNetworkPublicAddress(network, publicAddress); valErr != nil {
 		return nil, valErr
 	}
 
 	// Set default port if not specified, then validate the range.
 	if port == 0 {
-		port = 51820
+		port = DefaultWireGuardPort
 	}
 	if valErr := util.ValidatePort(port); valErr != nil {
-		return nil, valErr
+		return nil, withKind(ErrValidation, valErr)
 	}
+	vnm.warnPrivilegedPort("server", serverName, port)
 
 	// A node and the server cannot share a name: configs are keyed by name,
 	// so a collision would silently drop one config file.
@@ -192,70 +531,133 @@ func (vnm *VirtualNetworkManager) CreateServer(networkName, serverName, publicAd
 	}
 	for _, n := range nodes {
 		if n.Name == serverName {
-			return nil, fmt.Errorf("name %q is already used by a node in this network", serverName)
+			return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a node in this network", serverName)
 		}
 	}
 
 	// Ensure IP pool exists and is properly initialized
-	if err := vnm.ensureIPPool(network.ID, network.CIDR); err != nil {
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
 		return nil, err
 	}
 
-	// Server always gets the first usable IP
-	serverIP := vnm.ipPools[network.ID].GetServerIP()
+	// The reserved first usable IP goes to the server that holds it; when
+	// another server already does, allocate one.
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	serverIP := ipPool.GetServerIP()
+	allocated := false
+	for _, s := range servers {
+		if s.VirtualIP == serverIP {
+			if serverIP, err = ipPool.AllocateNodeIP(); err != nil {
+				return nil, err
+			}
+			allocated = true
+			break
+		}
+	}
+	// An allocated address is only in the cached pool until it is saved.
+	release := func() {
+		if allocated {
+			vnm.InvalidateIPPool(network.ID)
+		}
+	}
 
 	// Generate keys
 	keys, err := util.GenerateWireGuardKeys()
 	if err != nil {
+		release()
 		return nil, err
 	}
 
-	// Create server in storage
-	server, err := vnm.storage.CreateServer(network.ID, serverName, publicAddress, port, serverIP, keys.PrivateKey, keys.PublicKey)
+	// Create the server and persist the IP pool state in one transaction
+	state := ipPool.GetState()
+	server, err := vnm.storage.CreateServerWithPoolState(network.ID, serverName, publicAddress, port, serverIP, keys.PrivateKey, keys.PublicKey, state)
 	if err != nil {
+		vnm.InvalidateIPPool(network.ID)
 		return nil, err
 	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	return server, nil
+}
 
-	// Persist IP pool state after server creation
-	if err := vnm.storage.SaveIPPoolState(network.ID, vnm.ipPools[network.ID].GetState()); err != nil {
-		return nil, fmt.Errorf("failed to save IP pool state: %w", err)
+// resolveServer returns the named server of a network. An empty name selects
+// the network's only server and is an error when it has several.
+func (vnm *VirtualNetworkManager) resolveServer(network *VirtualNetwork, serverName string) (*Server, error) {
+	if serverName != "" {
+		return vnm.storage.GetServerByName(network.ID, serverName)
 	}
 
-	return server, nil
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	switch len(servers) {
+	case 0:
+		return nil, kindErrorf(ErrNotFound, "no server found in network %s", network.Name)
+	case 1:
+		return servers[0], nil
+	}
+	names := make([]string, len(servers))
+	for i, server := range servers {
+		names[i] = server.Name
+	}
+	return nil, kindErrorf(ErrValidation, "network %s has %d servers (%s); name one", network.Name, len(servers), strings.Join(names, ", "))
 }
 
-// GetServer retrieves a server by name
-func (vnm *VirtualNetworkManager) GetServer(networkName string) (*Server, error) {
+// GetServer retrieves a server by name. An empty name selects the network's
+// only server.
+func (vnm *VirtualNetworkManager) GetServer(networkName, serverName string) (*Server, error) {
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
 		return nil, err
 	}
 
-	return vnm.storage.GetServerByNetworkID(network.ID)
+	return vnm.resolveServer(network, serverName)
+}
+
+// ListServers lists the servers of a network, oldest first. The first one
+// serves nodes that are not assigned a server.
+func (vnm *VirtualNetworkManager) ListServers(networkName string) ([]*Server, error) {
+	return vnm.ListServersCtx(context.Background(), networkName)
+}
+
+// ListServersCtx is ListServers with a context.
+func (vnm *VirtualNetworkManager) ListServersCtx(ctx context.Context, networkName string) ([]*Server, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID)
 }
 
-// UpdateServer updates server information.
-func (vnm *VirtualNetworkManager) UpdateServer(networkName, publicAddress string, port int) (*Server, error) {
+// UpdateServer updates server information. An empty server name selects the
+// network's only server.
+func (vnm *VirtualNetworkManager) UpdateServer(networkName, serverName, publicAddress string, port int) (*Server, error) {
 	// Get network and server
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
 		return nil, err
 	}
 
-	server, err := vnm.storage.GetServerByNetworkID(network.ID)
+	server, err := vnm.resolveServer(network, serverName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Validate new public address
-	if valErr := vnm.validator.IsValidPublicAddress(publicAddress); valErr != nil {
+	if valErr := vnm.validateNetworkPublicAddress(network, publicAddress); valErr != nil {
 		return nil, valErr
 	}
 
 	// Validate the port range
 	if valErr := util.ValidatePort(port); valErr != nil {
-		return nil, valErr
+		return nil, withKind(ErrValidation, valErr)
 	}
+	vnm.warnPrivilegedPort("server", serverName, port)
 
 	// Update in storage
 	if updateErr := vnm.storage.UpdateServer(server.ID, publicAddress, port); updateErr != nil {
@@ -266,227 +668,1173 @@ func (vnm *VirtualNetworkManager) UpdateServer(networkName, publicAddress string
 	return vnm.storage.GetServerByName(server.NetworkID, server.Name)
 }
 
-// DeleteServer deletes the server from a network
-func (vnm *VirtualNetworkManager) DeleteServer(networkName string) error {
+// SetServerInternalEndpoint sets the endpoint nodes with PreferInternal dial
+// to reach a server, such as its datacenter address. A port of 0 reuses the
+// server's public port; an empty address removes the internal endpoint. An
+// empty server name selects the network's only server.
+func (vnm *VirtualNetworkManager) SetServerInternalEndpoint(networkName, serverName, address string, port int) (*Server, error) {
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return vnm.storage.DeleteServer(network.ID)
-}
-
-// CreateNode creates a new node in the network.
-func (vnm *VirtualNetworkManager) CreateNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType) (*Node, error) {
-	// Get network
-	network, err := vnm.storage.GetNetworkByName(networkName)
+	server, err := vnm.resolveServer(network, serverName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate the node name (alphanumeric, letter-first) — names become
-	// config file names, so this also prevents path-traversal characters.
-	if valErr := vnm.validator.IsValidNetworkName(nodeName); valErr != nil {
-		return nil, valErr
+	if err := vnm.validateInternalEndpoint(address, port); err != nil {
+		return nil, err
 	}
-
-	// Default type is peer; resolve it before the type-dependent checks below.
-	if nodeType == "" {
-		nodeType = NodeTypePeer
+	if address == "" {
+		port = 0
 	}
 
-	// Validate input: peer type requires public address, route type is optional
-	if nodeType == NodeTypePeer && publicAddress == "" {
-		return nil, fmt.Errorf("peer type nodes require a public address")
-	}
-	if publicAddress != "" {
-		if valErr := vnm.validator.IsValidPublicAddress(publicAddress); valErr != nil {
-			return nil, valErr
-		}
+	if err := vnm.storage.UpdateServerInternalEndpoint(server.ID, address, port); err != nil {
+		return nil, err
 	}
 
-	// Set default port if not specified, then validate the range.
-	if port == 0 {
-		port = 51820
-	}
-	if valErr := util.ValidatePort(port); valErr != nil {
-		return nil, valErr
-	}
+	return vnm.storage.GetServerByName(network.ID, server.Name)
+}
 
-	// A node and the server cannot share a name (configs are keyed by name).
-	if server, sErr := vnm.storage.GetServerByNetworkID(network.ID); sErr == nil && server.Name == nodeName {
-		return nil, fmt.Errorf("name %q is already used by the server in this network", nodeName)
+// validateNodeTypeAddress checks a node type and whether a node of that type
+// may have publicAddress: peer nodes need one, client nodes have none, and
+// it is optional for route nodes.
+func validateNodeTypeAddress(nodeType NodeType, publicAddress string) error {
+	if _, err := ParseNodeType(string(nodeType)); err != nil {
+		return err
 	}
-
-	// Ensure IP pool exists and is properly initialized
-	if err := vnm.ensureIPPool(network.ID, network.CIDR); err != nil {
-		return nil, err
+	switch {
+	case nodeType == NodeTypePeer && publicAddress == "":
+		return kindErrorf(ErrValidation, "peer type nodes require a public address")
+	case nodeType == NodeTypeClient && publicAddress != "":
+		return kindErrorf(ErrValidation, "client type nodes have no public address")
 	}
+	return nil
+}
 
-	// Allocate IP for node
-	nodeIP, err := vnm.ipPools[network.ID].AllocateNodeIP()
-	if err != nil {
-		return nil, err
+// validatePublicAddress checks a server or node public address, which must
+// not be an IP inside the network's own range cidr.
+func (vnm *VirtualNetworkManager) validatePublicAddress(cidr, addr string) error {
+	if err := vnm.validator.IsValidPublicAddress(addr); err != nil {
+		return err
 	}
+	return withKind(ErrValidation, util.ValidateAddressOutsideCIDR(addr, cidr))
+}
 
-	// Generate keys
-	keys, err := util.GenerateWireGuardKeys()
-	if err != nil {
-		// Free the IP if key generation fails
-		//nolint:errcheck // Acceptable to ignore in error cleanup path
-		_ = vnm.ipPools[network.ID].ReleaseNodeIP(nodeIP)
-		return nil, err
+// validateNetworkPublicAddress is validatePublicAddress within a stored
+// network, whose CIDR may be invalid (see checkNetworkCIDR).
+func (vnm *VirtualNetworkManager) validateNetworkPublicAddress(network *VirtualNetwork, addr string) error {
+	if err := checkNetworkCIDR(network); err != nil {
+		return err
 	}
+	return vnm.validatePublicAddress(network.CIDR, addr)
+}
 
-	// Create node in storage
-	node, err := vnm.storage.CreateNode(network.ID, nodeName, publicAddress, port, nodeIP, nodeType, keys.PrivateKey, keys.PublicKey)
-	if err != nil {
-		// Free the IP if node creation fails
-		//nolint:errcheck // Acceptable to ignore in error cleanup path
-		_ = vnm.ipPools[network.ID].ReleaseNodeIP(nodeIP)
-		return nil, err
+// validateInternalEndpoint checks an internal address and port; both may be
+// empty (0 for the port).
+func (vnm *VirtualNetworkManager) validateInternalEndpoint(address string, port int) error {
+	if address != "" {
+		if err := vnm.validator.IsValidPublicAddress(address); err != nil {
+			return kindErrorf(ErrValidation, "invalid internal address: %w", err)
+		}
 	}
-
-	// Persist IP pool state after allocating IP
-	if err := vnm.storage.SaveIPPoolState(network.ID, vnm.ipPools[network.ID].GetState()); err != nil {
-		return nil, fmt.Errorf("failed to save IP pool state: %w", err)
+	if port != 0 {
+		if err := util.ValidatePort(port); err != nil {
+			return kindErrorf(ErrValidation, "invalid internal port: %w", err)
+		}
 	}
+	return nil
+}
 
-	return node, nil
+// warnPrivilegedPort logs a warning when a server or node listens on a port
+// below 1024, which takes root or CAP_NET_BIND_SERVICE to bind: userspace
+// WireGuard and wg-quick without full privileges fail to bring it up.
+func (vnm *VirtualNetworkManager) warnPrivilegedPort(kind, name string, port int) {
+	if port < 1024 {
+		vnm.logger.Warn("listen port below 1024 needs extra privileges to bind", kind, name, "port", port)
+	}
 }
 
-// GetNode retrieves a node by name within a network
-func (vnm *VirtualNetworkManager) GetNode(networkName, nodeName string) (*Node, error) {
+// ImportServerKeys replaces a server's generated keys with an existing
+// WireGuard identity. A pair without a private key marks the server as
+// externally managed: peers still reference its public key, but no config
+// is generated for it. An empty server name selects the network's only
+// server.
+func (vnm *VirtualNetworkManager) ImportServerKeys(networkName, serverName string, keys *util.WireGuardKeyPair) (*Server, error) {
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
 		return nil, err
 	}
-	return vnm.storage.GetNodeByName(network.ID, nodeName)
-}
 
-// ListNodes lists all nodes in a network
-func (vnm *VirtualNetworkManager) ListNodes(networkName string) ([]*Node, error) {
-	network, err := vnm.storage.GetNetworkByName(networkName)
+	server, err := vnm.resolveServer(network, serverName)
 	if err != nil {
 		return nil, err
 	}
 
-	return vnm.storage.ListNodesByNetworkID(network.ID)
+	if err := vnm.storage.UpdateServerKeys(server.ID, keys.PrivateKey, keys.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetServerByName(network.ID, server.Name)
 }
 
-// UpdateNode updates node information.
-func (vnm *VirtualNetworkManager) UpdateNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType) (*Node, error) {
+// RenameServer renames a server of a network. Its keys and virtual IP are
+// kept, so deployed peer configs stay valid. An empty old name selects the
+// network's only server.
+func (vnm *VirtualNetworkManager) RenameServer(networkName, oldName, newName string) (*Server, error) {
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get node
-	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	server, err := vnm.resolveServer(network, oldName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate: peer type requires public address, route type is optional
-	if nodeType == NodeTypePeer && publicAddress == "" {
-		return nil, fmt.Errorf("peer type nodes require a public address")
-	}
-	if publicAddress != "" {
-		if valErr := vnm.validator.IsValidPublicAddress(publicAddress); valErr != nil {
-			return nil, valErr
-		}
-	}
-
-	// Validate the port range
-	if valErr := util.ValidatePort(port); valErr != nil {
+	if valErr := vnm.validator.IsValidNetworkName(newName); valErr != nil {
 		return nil, valErr
 	}
 
-	// Update in storage
-	if err := vnm.storage.UpdateNode(node.ID, publicAddress, port, nodeType); err != nil {
-		return nil, err
+	// A node and a server cannot share a name (configs are keyed by name).
+	if _, nErr := vnm.storage.GetNodeByName(network.ID, newName); nErr == nil {
+		return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a node in this network", newName)
 	}
 
-	// Retrieve updated node
-	return vnm.storage.GetNodeByName(network.ID, nodeName)
+	return vnm.storage.RenameServer(network.ID, server.Name, newName)
 }
 
-// DeleteNode deletes a node
-func (vnm *VirtualNetworkManager) DeleteNode(networkName, nodeName string) error {
+// DeleteServerOptions controls DeleteServer.
+type DeleteServerOptions struct {
+	Name string // server to delete; empty selects the network's only server
+	// Cascade also deletes the nodes depending on the server (see
+	// ServerDependents), releasing their virtual IPs.
+	Cascade bool
+	// KeepNodes deletes the server even though nodes depend on it. They fall
+	// back to the first remaining server, or are left without one.
+	KeepNodes bool
+}
+
+// DeleteServer deletes a server of a network. When nodes depend on it, it
+// refuses with ErrValidation unless opts.Cascade or opts.KeepNodes says what
+// becomes of them: their configs name the server, and without any server
+// left, configs cannot be generated for the network at all.
+func (vnm *VirtualNetworkManager) DeleteServer(networkName string, opts DeleteServerOptions) error {
+	if opts.Cascade && opts.KeepNodes {
+		return kindErrorf(ErrValidation, "cascade and keep-nodes cannot be combined")
+	}
+
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
 	network, err := vnm.storage.GetNetworkByName(networkName)
 	if err != nil {
 		return err
 	}
 
-	// Get node
-	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	server, err := vnm.resolveServer(network, opts.Name)
 	if err != nil {
 		return err
 	}
 
-	// Ensure IP pool is loaded
-	if err := vnm.ensureIPPool(network.ID, network.CIDR); err != nil {
-		return fmt.Errorf("failed to ensure IP pool: %w", err)
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return err
 	}
+	dependents, err := vnm.serverDependents(network, server, servers)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(dependents))
+	for _, node := range dependents {
+		names = append(names, node.Name)
+	}
+	if len(dependents) > 0 && !opts.Cascade && !opts.KeepNodes {
+		impact := "with no server left, configs cannot be generated for the network"
+		if !network.NeedsServer() {
+			impact = "with no server left, they would only reach the mesh nodes they peer with directly"
+		}
+		if fallback := remainingServer(servers, server); fallback != nil {
+			impact = fmt.Sprintf("they would move to server %q, and their configs would need to be regenerated and redistributed", fallback.Name)
+		}
+		return kindErrorf(ErrValidation, "server %q has %d node(s) depending on it (%s): %s",
+			server.Name, len(dependents), strings.Join(names, ", "), impact)
+	}
+
+	// A network whose CIDR is invalid has no pool to release addresses to;
+	// the records are still deleted, and fixing the CIDR rebuilds the pool.
+	if checkNetworkCIDR(network) != nil {
+		vnm.InvalidateIPPool(network.ID)
+		if opts.Cascade && len(dependents) > 0 {
+			return vnm.storage.DeleteServerAndNodesWithPoolState(network.ID, server.Name, names, nil)
+		}
+		return vnm.storage.DeleteServerWithPoolState(network.ID, server.Name, nil)
+	}
+
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return fmt.Errorf("failed to ensure IP pool: %w", err)
+	}
+
+	// The reserved server IP stays reserved for the next server; addresses
+	// allocated to further servers return to the pool, as do those of the
+	// nodes deleted along with it.
+	released := []string{}
+	if server.VirtualIP != ipPool.GetServerIP() {
+		released = append(released, server.VirtualIP)
+	}
+	if opts.Cascade {
+		for _, node := range dependents {
+			released = append(released, node.VirtualIP)
+		}
+	}
+	for _, ip := range released {
+		if err := ipPool.ReleaseNodeIP(ip); err != nil {
+			vnm.logger.Warn("failed to release IP", "ip", ip, "error", err)
+		}
+	}
+	state := ipPool.GetState()
+	if opts.Cascade && len(dependents) > 0 {
+		err = vnm.storage.DeleteServerAndNodesWithPoolState(network.ID, server.Name, names, state)
+	} else {
+		err = vnm.storage.DeleteServerWithPoolState(network.ID, server.Name, state)
+	}
+	if err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return err
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	return nil
+}
+
+// ServerDependents returns the nodes of a network that depend on a server,
+// sorted by name: those assigned to it, and, when it is the network's first
+// server, those falling back to it (see NodeServer). An empty server name
+// selects the network's only server.
+func (vnm *VirtualNetworkManager) ServerDependents(networkName, serverName string) ([]*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	server, err := vnm.resolveServer(network, serverName)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.serverDependents(network, server, servers)
+}
+
+// serverDependents returns the nodes of network whose server, among
+// servers, is server, sorted by name.
+func (vnm *VirtualNetworkManager) serverDependents(network *VirtualNetwork, server *Server, servers []*Server) ([]*Node, error) {
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	var dependents []*Node
+	for _, node := range nodes {
+		if assigned := NodeServer(node, servers); assigned != nil && assigned.ID == server.ID {
+			dependents = append(dependents, node)
+		}
+	}
+	sort.Slice(dependents, func(i, j int) bool { return dependents[i].Name < dependents[j].Name })
+	return dependents, nil
+}
+
+// remainingServer returns the server nodes fall back to once deleted is
+// gone: the first of the others, or nil when there are none.
+func remainingServer(servers []*Server, deleted *Server) *Server {
+	for _, server := range servers {
+		if server.ID != deleted.ID {
+			return server
+		}
+	}
+	return nil
+}
+
+// AssignNodeServer sets the server a node peers with. An empty server name
+// assigns the network's first server. With meshServers the node peers with
+// every server, keeping the assigned one as the route to the rest of the
+// network.
+func (vnm *VirtualNetworkManager) AssignNodeServer(networkName, nodeName, serverName string, meshServers bool) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	serverID := ""
+	if serverName != "" {
+		server, err := vnm.storage.GetServerByName(network.ID, serverName)
+		if err != nil {
+			return nil, err
+		}
+		serverID = server.ID
+	}
+
+	if err := vnm.storage.UpdateNodeServer(node.ID, serverID, meshServers); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// NodeServer returns the server a node is assigned to: the one it names, or
+// the first of servers when it names none or one that no longer exists. It
+// returns nil when servers is empty.
+func NodeServer(node *Node, servers []*Server) *Server {
+	for _, server := range servers {
+		if server.ID == node.ServerID {
+			return server
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	return servers[0]
+}
+
+// NodeOptions are the options of CreateNodeWithOptions.
+type NodeOptions struct {
+	// RoutedCIDRs are the LAN subnets a route node exposes.
+	RoutedCIDRs []string
+	// AllowDuplicateEndpoint creates the node even when the server or
+	// another node has its public address and port (see checkNodeEndpoint).
+	AllowDuplicateEndpoint bool
+}
+
+// CreateNode creates a new node in the network.
+func (vnm *VirtualNetworkManager) CreateNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType) (*Node, error) {
+	return vnm.CreateNodeWithOptions(networkName, nodeName, publicAddress, port, nodeType, NodeOptions{})
+}
+
+// CreateNodeWithOptions creates a new node in the network with opts. A
+// route node's subnets are validated before the node is created; if they
+// cannot be saved the node is removed again so no half-configured node is
+// left behind.
+func (vnm *VirtualNetworkManager) CreateNodeWithOptions(networkName, nodeName, publicAddress string, port int, nodeType NodeType, opts NodeOptions) (*Node, error) {
+	if len(opts.RoutedCIDRs) == 0 {
+		return vnm.createNode(networkName, nodeName, publicAddress, port, nodeType, opts.AllowDuplicateEndpoint)
+	}
+	if nodeType != NodeTypeRoute {
+		return nil, kindErrorf(ErrValidation, "routed CIDRs are only supported for route nodes")
+	}
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	routed, err := vnm.validateRoutedCIDRs(network, "", opts.RoutedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.createNode(networkName, nodeName, publicAddress, port, NodeTypeRoute, opts.AllowDuplicateEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(routed) == 0 {
+		return node, nil
+	}
+
+	if err := vnm.storage.UpdateNodeRoutedCIDRs(node.ID, routed); err != nil {
+		if delErr := vnm.DeleteNode(networkName, nodeName); delErr != nil {
+			return nil, fmt.Errorf("failed to save routed CIDRs: %w (and failed to remove node: %v)", err, delErr)
+		}
+		return nil, fmt.Errorf("failed to save routed CIDRs: %w", err)
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// createNode is CreateNodeWithOptions without routed CIDRs.
+func (vnm *VirtualNetworkManager) createNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType, allowDuplicateEndpoint bool) (*Node, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	// Get network
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the node name (alphanumeric, letter-first) — names become
+	// config file names, so this also prevents path-traversal characters.
+	if valErr := vnm.validator.IsValidNetworkName(nodeName); valErr != nil {
+		return nil, valErr
+	}
+
+	// Default type is peer; resolve it before the type-dependent checks below.
+	if nodeType == "" {
+		nodeType = NodeTypePeer
+	}
+	if err := validateNodeTypeAddress(nodeType, publicAddress); err != nil {
+		return nil, err
+	}
+	if publicAddress != "" {
+		if valErr := vnm.validateNetworkPublicAddress(network, publicAddress); valErr != nil {
+			return nil, valErr
+		}
+	}
+
+	// Use the network's default port if none is specified, then validate
+	// the range.
+	if port == 0 {
+		port = network.NodePort()
+	}
+	if valErr := util.ValidatePort(port); valErr != nil {
+		return nil, withKind(ErrValidation, valErr)
+	}
+	vnm.warnPrivilegedPort("node", nodeName, port)
+	if err := vnm.checkNodeEndpoint(network, nodeName, publicAddress, port, allowDuplicateEndpoint); err != nil {
+		return nil, err
+	}
+
+	// A node and a server cannot share a name (configs are keyed by name).
+	if _, sErr := vnm.storage.GetServerByName(network.ID, nodeName); sErr == nil {
+		return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a server in this network", nodeName)
+	}
+	if err := vnm.checkNodeLimit(network); err != nil {
+		return nil, err
+	}
+
+	// Ensure IP pool exists and is properly initialized
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+
+	// Allocate IP for node
+	nodeIP, err := ipPool.AllocateNodeIP()
+	if err != nil {
+		return nil, err
+	}
+	vnm.logger.Debug("allocated node IP", "network", networkName, "node", nodeName, "ip", nodeIP)
+
+	// Generate keys
+	keys, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		// Drop the pool holding the unsaved allocation
+		vnm.InvalidateIPPool(network.ID)
+		return nil, err
+	}
+
+	// Create the node and persist the IP pool state recording its IP in one
+	// transaction, so the saved pool never misses an address a node holds.
+	state := ipPool.GetState()
+	node, err := vnm.storage.CreateNodeWithPoolState(network.ID, nodeName, publicAddress, port, nodeIP, nodeType, keys.PrivateKey, keys.PublicKey, state)
+	if err != nil {
+		// Drop the pool holding the unsaved allocation
+		vnm.InvalidateIPPool(network.ID)
+		return nil, err
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	vnm.warnPoolUsage(network, ipPool)
+
+	return node, nil
+}
+
+// CheckEndpointAvailable returns an error if publicAddress:port is already
+// the endpoint of the server or a node of the network other than entityName.
+// Two peers behind one endpoint cannot both receive traffic, so this is
+// usually a mistake; an empty publicAddress never conflicts.
+func (vnm *VirtualNetworkManager) CheckEndpointAvailable(networkName, entityName, publicAddress string, port int) error {
+	if publicAddress == "" {
+		return nil
+	}
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return err
+	}
+
+	endpoint := util.FormatEndpoint(publicAddress, port)
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		if server.Name != entityName && server.PublicAddress == publicAddress && server.Port == port {
+			return kindErrorf(ErrAlreadyExists, "endpoint %s is already used by server %q", endpoint, server.Name)
+		}
+	}
+
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if n.Name != entityName && n.PublicAddress == publicAddress && n.Port == port {
+			return kindErrorf(ErrAlreadyExists, "endpoint %s is already used by node %q", endpoint, n.Name)
+		}
+	}
+	return nil
+}
+
+// checkNodeEndpoint rejects publicAddress:port as the endpoint of node
+// nodeName when the server or another node of the network already has it.
+// Giving a node the server's endpoint is a common copy-paste mistake: the
+// node would dial itself through the server's address. The error names the
+// holder and suggests the next free port at the address. With allow the
+// duplicate is logged as a warning instead. Sharing only the address, on
+// another port, is logged as a warning: one host can run several peers.
+func (vnm *VirtualNetworkManager) checkNodeEndpoint(network *VirtualNetwork, nodeName, publicAddress string, port int, allow bool) error {
+	if publicAddress == "" {
+		return nil
+	}
+
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return err
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return err
+	}
+	var holders []string // "server <name>" or "node <name>"
+	var duplicate string
+	for _, server := range servers {
+		if server.PublicAddress == publicAddress {
+			holders = append(holders, fmt.Sprintf("server %q", server.Name))
+			if server.Port == port && duplicate == "" {
+				duplicate = holders[len(holders)-1]
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.Name != nodeName && n.PublicAddress == publicAddress {
+			holders = append(holders, fmt.Sprintf("node %q", n.Name))
+			if n.Port == port && duplicate == "" {
+				duplicate = holders[len(holders)-1]
+			}
+		}
+	}
+
+	endpoint := util.FormatEndpoint(publicAddress, port)
+	switch {
+	case duplicate == "":
+		for _, holder := range holders {
+			vnm.logger.Warn("public address is shared on another port", "node", nodeName, "address", publicAddress, "with", holder)
+		}
+		return nil
+	case allow:
+		vnm.logger.Warn("endpoint is shared", "node", nodeName, "endpoint", endpoint, "with", duplicate)
+		return nil
+	}
+
+	hint := "give the node another port or its own public address"
+	if free, err := vnm.NextFreePort(network.Name, publicAddress, port+1, 65535); err == nil {
+		hint = fmt.Sprintf("use port %d, free at this address, or give the node its own public address", free)
+	}
+	if strings.HasPrefix(duplicate, "server ") {
+		hint = "a node's public address is its own, not the server's; " + hint
+	}
+	return kindErrorf(ErrAlreadyExists, "endpoint %s is already used by %s: %s (or allow it with --allow-duplicate-endpoint)", endpoint, duplicate, hint)
+}
+
+// NextFreePort returns the lowest port in [start, end] that no server or node
+// of the network uses together with publicAddress.
+func (vnm *VirtualNetworkManager) NextFreePort(networkName, publicAddress string, start, end int) (int, error) {
+	if err := util.ValidatePort(start); err != nil {
+		return 0, withKind(ErrValidation, err)
+	}
+	if err := util.ValidatePort(end); err != nil {
+		return 0, withKind(ErrValidation, err)
+	}
+	if start > end {
+		return 0, kindErrorf(ErrValidation, "invalid port range %d-%d", start, end)
+	}
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return 0, err
+	}
+
+	used := make(map[int]bool)
+	servers, err := vnm.storage.ListServersByNetworkID(network.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, server := range servers {
+		if server.PublicAddress == publicAddress {
+			used[server.Port] = true
+		}
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range nodes {
+		if n.PublicAddress == publicAddress {
+			used[n.Port] = true
+		}
+	}
+
+	for port := start; port <= end; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, kindErrorf(ErrPoolExhausted, "no free port in range %d-%d for %q", start, end, publicAddress)
+}
+
+// CreateRouteNode creates a route node that exposes the given LAN subnets;
+// see CreateNodeWithOptions.
+func (vnm *VirtualNetworkManager) CreateRouteNode(networkName, nodeName, publicAddress string, port int, routedCIDRs []string) (*Node, error) {
+	return vnm.CreateNodeWithOptions(networkName, nodeName, publicAddress, port, NodeTypeRoute, NodeOptions{RoutedCIDRs: routedCIDRs})
+}
+
+// SetNodeRoutedCIDRs replaces the LAN subnets a route node exposes. An empty
+// list clears them.
+func (vnm *VirtualNetworkManager) SetNodeRoutedCIDRs(networkName, nodeName string, routedCIDRs []string) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(routedCIDRs) > 0 && node.Type != NodeTypeRoute {
+		return nil, kindErrorf(ErrValidation, "routed CIDRs are only supported for route nodes")
+	}
+
+	routed, err := vnm.validateRoutedCIDRs(network, node.ID, routedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.UpdateNodeRoutedCIDRs(node.ID, routed); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// validateRoutedCIDRs checks routed subnets for a node and returns them in
+// canonical form. Each must be a valid CIDR that overlaps neither the
+// network's own CIDR, another entry in the list, nor a subnet already routed
+// by a different node (nodeID) in the network — overlapping AllowedIPs would
+// make WireGuard's routing ambiguous.
+func (vnm *VirtualNetworkManager) validateRoutedCIDRs(network *VirtualNetwork, nodeID string, routedCIDRs []string) ([]string, error) {
+	if len(routedCIDRs) == 0 {
+		return nil, nil
+	}
+
+	networkPrefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR %q: %w", network.CIDR, err)
+	}
+
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := make([]string, 0, len(routedCIDRs))
+	prefixes := make([]netip.Prefix, 0, len(routedCIDRs))
+	for _, cidr := range routedCIDRs {
+		if valErr := vnm.validator.IsValidCIDR(cidr); valErr != nil {
+			return nil, valErr
+		}
+		prefix, parseErr := netip.ParsePrefix(cidr)
+		if parseErr != nil {
+			return nil, kindErrorf(ErrValidation, "invalid CIDR notation: %w", parseErr)
+		}
+		prefix = prefix.Masked()
+
+		if prefix.Overlaps(networkPrefix) {
+			return nil, kindErrorf(ErrValidation, "routed CIDR %s overlaps the network CIDR %s", prefix, network.CIDR)
+		}
+		for _, seen := range prefixes {
+			if prefix.Overlaps(seen) {
+				return nil, kindErrorf(ErrValidation, "routed CIDR %s overlaps %s", prefix, seen)
+			}
+		}
+		for _, other := range nodes {
+			if other.ID == nodeID {
+				continue
+			}
+			for _, otherCIDR := range other.RoutedCIDRs {
+				otherPrefix, otherErr := netip.ParsePrefix(otherCIDR)
+				if otherErr == nil && prefix.Overlaps(otherPrefix) {
+					return nil, kindErrorf(ErrValidation, "routed CIDR %s overlaps %s routed by node %q", prefix, otherCIDR, other.Name)
+				}
+			}
+		}
+
+		prefixes = append(prefixes, prefix)
+		routed = append(routed, prefix.String())
+	}
+
+	return routed, nil
+}
+
+// GetNode retrieves a node by name within a network
+func (vnm *VirtualNetworkManager) GetNode(networkName, nodeName string) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// ListNodes lists all nodes in a network, sorted by name.
+func (vnm *VirtualNetworkManager) ListNodes(networkName string) ([]*Node, error) {
+	return vnm.ListNodesCtx(context.Background(), networkName)
+}
+
+// ListNodesCtx is ListNodes with a context.
+func (vnm *VirtualNetworkManager) ListNodesCtx(ctx context.Context, networkName string) ([]*Node, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+}
+
+// UpdateNodeLabels sets the labels in set and removes the keys in remove
+// from a node.
+func (vnm *VirtualNetworkManager) UpdateNodeLabels(networkName, nodeName string, set map[string]string, remove []string) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := mergeLabels(node.Labels, set, remove)
+	if err != nil {
+		return nil, err
+	}
+	if err := vnm.storage.UpdateNodeLabels(node.ID, labels); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// SetNodeExpiry sets when a node's access ends; nil removes the expiry. An
+// expired node stays in the database, but is left out of generated configs
+// until its expiry is extended or it is purged.
+func (vnm *VirtualNetworkManager) SetNodeExpiry(networkName, nodeName string, expiresAt *time.Time) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.UpdateNodeExpiry(node.ID, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// SetNodeFullTunnel sets whether a node routes all of its traffic through its
+// assigned server. The server peer in a full-tunnel node's config allows
+// 0.0.0.0/0 and ::/0 instead of the network's subnets; everyone else still
+// reaches the node at its /32.
+func (vnm *VirtualNetworkManager) SetNodeFullTunnel(networkName, nodeName string, fullTunnel bool) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.UpdateNodeFullTunnel(node.ID, fullTunnel); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// SetNodeInternalEndpoint sets the endpoint peers with PreferInternal dial
+// to reach a node. A port of 0 reuses the node's public port; an empty
+// address removes the internal endpoint.
+func (vnm *VirtualNetworkManager) SetNodeInternalEndpoint(networkName, nodeName, address string, port int) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.validateInternalEndpoint(address, port); err != nil {
+		return nil, err
+	}
+	if address == "" {
+		port = 0
+	}
+
+	if err := vnm.storage.UpdateNodeInternalEndpoint(node.ID, address, port); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// SetNodePreferInternal sets whether a node's config dials its server and
+// peers at their internal endpoints where they have one.
+func (vnm *VirtualNetworkManager) SetNodePreferInternal(networkName, nodeName string, preferInternal bool) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.UpdateNodePreferInternal(node.ID, preferInternal); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// ListExpiredNodes lists the nodes of a network whose access has ended.
+func (vnm *VirtualNetworkManager) ListExpiredNodes(networkName string) ([]*Node, error) {
+	nodes, err := vnm.ListNodes(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := vnm.now()
+	var expired []*Node
+	for _, node := range nodes {
+		if node.Expired(now) {
+			expired = append(expired, node)
+		}
+	}
+	return expired, nil
+}
+
+// PurgeExpiredNodes deletes the expired nodes of a network, releasing their
+// IPs, and returns the nodes it deleted. It stops at the first failure; the
+// nodes deleted before it are still returned.
+func (vnm *VirtualNetworkManager) PurgeExpiredNodes(networkName string) ([]*Node, error) {
+	expired, err := vnm.ListExpiredNodes(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]*Node, 0, len(expired))
+	for _, node := range expired {
+		if err := vnm.DeleteNode(networkName, node.Name); err != nil {
+			return purged, fmt.Errorf("failed to delete expired node %q: %w", node.Name, err)
+		}
+		purged = append(purged, node)
+	}
+	return purged, nil
+}
+
+// ImportNodeKeys replaces a node's generated keys with an existing WireGuard
+// identity. A pair without a private key marks the node as externally
+// managed: it is still a peer in every other config, but its own config is
+// not generated.
+func (vnm *VirtualNetworkManager) ImportNodeKeys(networkName, nodeName string, keys *util.WireGuardKeyPair) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vnm.storage.UpdateNodeKeys(node.ID, keys.PrivateKey, keys.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// mergeLabels returns a copy of current with set applied and the keys in
+// remove deleted, or nil when no labels remain.
+func mergeLabels(current, set map[string]string, remove []string) (map[string]string, error) {
+	merged := make(map[string]string, len(current)+len(set))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range set {
+		if err := util.ValidateLabelKey(k); err != nil {
+			return nil, withKind(ErrValidation, err)
+		}
+		merged[k] = v
+	}
+	for _, k := range remove {
+		if err := util.ValidateLabelKey(k); err != nil {
+			return nil, withKind(ErrValidation, err)
+		}
+		delete(merged, k)
+	}
+
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// UpdateNode updates node information. Type changes that would leave
+// routed subnets or dialing peers stranded are refused (see
+// checkTypeTransition), and so is an endpoint the server or another node
+// has (see checkNodeEndpoint).
+func (vnm *VirtualNetworkManager) UpdateNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType) (*Node, error) {
+	return vnm.updateNode(networkName, nodeName, publicAddress, port, nodeType, false)
+}
+
+// updateNode is UpdateNode; force allows type changes that drop the node
+// from the configs of the peer nodes dialing it.
+func (vnm *VirtualNetworkManager) updateNode(networkName, nodeName, publicAddress string, port int, nodeType NodeType, force bool) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get node
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateNodeTypeAddress(nodeType, publicAddress); err != nil {
+		return nil, err
+	}
+	if publicAddress != "" {
+		if valErr := vnm.validateNetworkPublicAddress(network, publicAddress); valErr != nil {
+			return nil, valErr
+		}
+	}
+
+	updated := *node
+	updated.Type, updated.PublicAddress = nodeType, publicAddress
+	if err := vnm.checkTypeTransition(network, &updated, node.Type, force); err != nil {
+		return nil, err
+	}
+
+	// Validate the port range
+	if valErr := util.ValidatePort(port); valErr != nil {
+		return nil, withKind(ErrValidation, valErr)
+	}
+	vnm.warnPrivilegedPort("node", nodeName, port)
+	if publicAddress != node.PublicAddress || port != node.Port {
+		if err := vnm.checkNodeEndpoint(network, nodeName, publicAddress, port, false); err != nil {
+			return nil, err
+		}
+	}
+
+	// Update in storage
+	if err := vnm.storage.UpdateNode(node.ID, publicAddress, port, nodeType); err != nil {
+		return nil, err
+	}
+
+	// Retrieve updated node
+	return vnm.storage.GetNodeByName(network.ID, nodeName)
+}
+
+// RenameNode renames a node. Its ID, keys, and virtual IP are kept, so
+// deployed peer configs stay valid.
+func (vnm *VirtualNetworkManager) RenameNode(networkName, oldName, newName string) (*Node, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	if valErr := vnm.validator.IsValidNetworkName(newName); valErr != nil {
+		return nil, valErr
+	}
+
+	// A node and a server cannot share a name (configs are keyed by name).
+	if _, sErr := vnm.storage.GetServerByName(network.ID, newName); sErr == nil {
+		return nil, kindErrorf(ErrAlreadyExists, "name %q is already used by a server in this network", newName)
+	}
+
+	return vnm.storage.RenameNode(network.ID, oldName, newName)
+}
+
+// DeleteNode deletes a node
+func (vnm *VirtualNetworkManager) DeleteNode(networkName, nodeName string) error {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
 
-	// Delete the node from storage first, then release its IP. In this order
-	// a failed delete leaves the IP still allocated (consistent) rather than
-	// recycling an IP that a surviving node record still owns.
-	if err := vnm.storage.DeleteNode(network.ID, nodeName); err != nil {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
 		return err
 	}
 
-	// Free the IP and persist the updated pool state.
-	if ipPool, exists := vnm.ipPools[node.NetworkID]; exists {
-		if err := ipPool.ReleaseNodeIP(node.VirtualIP); err != nil {
-			// Log warning but continue - IP might already be released
-			fmt.Fprintf(os.Stderr, "Warning: failed to release IP %s: %v\n", node.VirtualIP, err)
-		}
-		// Persist IP pool state after releasing IP
-		if err := vnm.storage.SaveIPPoolState(network.ID, ipPool.GetState()); err != nil {
-			return fmt.Errorf("failed to save IP pool state: %w", err)
-		}
+	// Get node
+	node, err := vnm.storage.GetNodeByName(network.ID, nodeName)
+	if err != nil {
+		return err
+	}
+
+	// A network whose CIDR is invalid has no pool to release the IP to; the
+	// node is still deleted, and fixing the CIDR rebuilds the pool.
+	if checkNetworkCIDR(network) != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return vnm.storage.DeleteNodeWithPoolState(network.ID, nodeName, nil)
+	}
+
+	// Ensure IP pool is loaded
+	ipPool, err := vnm.loadIPPool(network)
+	if err != nil {
+		return fmt.Errorf("failed to ensure IP pool: %w", err)
 	}
 
+	// Release the IP in memory, then delete the node and persist the pool
+	// state without its IP in one transaction. If that fails, neither is
+	// written and the pool is reloaded from the database next time.
+	if err := ipPool.ReleaseNodeIP(node.VirtualIP); err != nil {
+		// Log warning but continue - IP might already be released
+		vnm.logger.Warn("failed to release IP", "ip", node.VirtualIP, "error", err)
+	}
+	state := ipPool.GetState()
+	if err := vnm.storage.DeleteNodeWithPoolState(network.ID, nodeName, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return err
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
 	return nil
 }
 
-// ========== WireGuard Configuration Generation ==========
+// RepairIndexes removes orphaned index entries left in the database (see
+// StorageManager.RepairIndexes) and returns how many were removed.
+func (vnm *VirtualNetworkManager) RepairIndexes() (int, error) {
+	return vnm.storage.RepairIndexes()
+}
+
+// CheckIntegrity reports the referential integrity problems in the database
+// (see StorageManager.CheckIntegrity).
+func (vnm *VirtualNetworkManager) CheckIntegrity() (*IntegrityReport, error) {
+	return vnm.storage.CheckIntegrity()
+}
+
+// FixIntegrity repairs the referential integrity problems in the database
+// (see StorageManager.FixIntegrity) and returns the problems found. It may
+// reassign virtual IPs, so it holds the pool lock.
+func (vnm *VirtualNetworkManager) FixIntegrity() (*IntegrityReport, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
 
-// persistentKeepalive is the PersistentKeepalive interval (seconds) added to
-// every peer in a route node's config. Route nodes connect outbound only
-// (typically behind NAT), so a keepalive is needed to hold the tunnel open.
-const persistentKeepalive = 25
+	report, err := vnm.storage.FixIntegrity()
+	if err != nil {
+		return nil, err
+	}
+	if report.Fixed {
+		vnm.logger.Info("repaired database integrity", "problems", report.Repairable())
+	}
+	return report, nil
+}
+
+// ========== WireGuard Configuration Generation ==========
 
 // WireGuardConfigGenerator generates WireGuard configurations
 type WireGuardConfigGenerator struct {
-	storage *StorageManager
+	storage Storage
+	logger  *slog.Logger
+	now     func() time.Time // clock for node expiry; replaced in tests
+	workers int              // node configs generated at once; 0 means GOMAXPROCS
 }
 
-// NewWireGuardConfigGenerator creates a new WireGuardConfigGenerator
-func NewWireGuardConfigGenerator(storage *StorageManager) *WireGuardConfigGenerator {
-	return &WireGuardConfigGenerator{storage: storage}
+// NewWireGuardConfigGenerator creates a new WireGuardConfigGenerator. It logs
+// through the storage's logger.
+func NewWireGuardConfigGenerator(storage Storage) *WireGuardConfigGenerator {
+	return &WireGuardConfigGenerator{storage: storage, logger: storage.Logger(), now: time.Now}
 }
 
 // GenerateConfigs generates WireGuard configurations for all entities in a network.
-func (wcg *WireGuardConfigGenerator) GenerateConfigs(networkName string, storage *StorageManager) (configs map[string]string, hash string, err error) {
-	// Get network
-	network, err := storage.GetNetworkByName(networkName)
+func (wcg *WireGuardConfigGenerator) GenerateConfigs(networkName string, storage Storage) (configs map[string]string, hash string, err error) {
+	return wcg.GenerateConfigsCtx(context.Background(), networkName, storage)
+}
+
+// GenerateConfigsCtx is GenerateConfigs with a context, checked between
+// entities so a large network stops generating promptly once cancelled.
+func (wcg *WireGuardConfigGenerator) GenerateConfigsCtx(ctx context.Context, networkName string, storage Storage) (configs map[string]string, hash string, err error) {
+	network, servers, nodes, err := wcg.loadNetwork(ctx, networkName, storage)
+	if err != nil {
+		return nil, "", err
+	}
+
+	allConfigs, err := wcg.generateAll(ctx, network, servers, nodes)
 	if err != nil {
 		return nil, "", err
 	}
 
-	// Get server
-	server, sErr := storage.GetServerByNetworkID(network.ID)
+	// Calculate content hash
+	contentHash := wcg.calculateConfigHash(allConfigs)
+	wcg.logger.Debug("generated configs", "network", networkName, "configs", len(allConfigs), "hash", contentHash)
+
+	return allConfigs, contentHash, nil
+}
+
+// loadNetwork reads what the configs of a network are generated from: the
+// network, its servers, and its unexpired nodes and guests sorted by virtual
+// IP.
+func (wcg *WireGuardConfigGenerator) loadNetwork(ctx context.Context, networkName string, storage Storage) (*VirtualNetwork, []*Server, []*Node, error) {
+	// Get network; configs carry its CIDR.
+	network, err := storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkNetworkCIDR(network); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Get servers
+	servers, sErr := storage.ListServersByNetworkIDCtx(ctx, network.ID)
 	if sErr != nil {
-		return nil, "", fmt.Errorf("no server found in network")
+		return nil, nil, nil, sErr
+	}
+	if len(servers) == 0 {
+		if network.NeedsServer() {
+			return nil, nil, nil, kindErrorf(ErrNotFound, "network %q has no server, so no configs can be generated; add one with 'wedevctl vn %s server add <name> <endpoint>'", network.Name, network.Name)
+		}
+		wcg.logger.Debug("generating mesh configs without a server", "network", networkName)
 	}
 
 	// Get all nodes
-	nodes, nErr := storage.ListNodesByNetworkID(network.ID)
+	nodes, nErr := storage.ListNodesByNetworkIDCtx(ctx, network.ID)
 	if nErr != nil {
-		return nil, "", nErr
+		return nil, nil, nil, nErr
+	}
+	nodes = wcg.withoutExpired(networkName, nodes)
+	if err := checkKeysDecrypted(servers, nodes); err != nil {
+		return nil, nil, nil, err
+	}
+	guests, gErr := storage.ListGuestsByNetworkID(network.ID)
+	if gErr != nil {
+		return nil, nil, nil, gErr
 	}
+	nodes = wcg.withGuests(networkName, nodes, guests)
 
 	// Sort nodes by virtual IP so peer blocks are emitted in a stable,
 	// reproducible order regardless of storage iteration order (UUID-keyed).
@@ -499,45 +1847,322 @@ func (wcg *WireGuardConfigGenerator) GenerateConfigs(networkName string, storage
 		}
 		return a.Less(b)
 	})
+	return network, servers, nodes, nil
+}
+
+// networkPeers collects what every node config needs from the other nodes:
+// the LAN subnets exposed by route nodes, so each node's server peer can
+// route them without another pass over every node, and the sections of the
+// peer nodes.
+func networkPeers(network *VirtualNetwork, nodes []*Node) ([]routedCIDR, []peerSection) {
+	var routes []routedCIDR
+	var peers []peerSection
+	keepalive, ok := keepaliveDirective(network)
+	for _, node := range nodes {
+		for _, cidr := range node.RoutedCIDRs {
+			routes = append(routes, routedCIDR{nodeID: node.ID, cidr: cidr})
+		}
+		if node.Type == NodeTypePeer {
+			peers = append(peers, newPeerSection(node, keepalive, ok))
+		}
+	}
+	return routes, peers
+}
+
+// generateAll generates the configs of a network's servers and nodes, nodes
+// sorted by virtual IP. What every node config needs from the others (routed
+// subnets, rendered peer sections) is built once; node configs are then generated by
+// a bounded pool of workers, each writing only its own slot of a result
+// slice, since a large network has hundreds of configs that each list
+// hundreds of peers.
+func (wcg *WireGuardConfigGenerator) generateAll(ctx context.Context, network *VirtualNetwork, servers []*Server, nodes []*Node) (map[string]string, error) {
+	routes, peers := networkPeers(network, nodes)
+
+	// Entities with imported public-only keys are externally managed: they
+	// appear as peers in the other configs, but get no config of their own.
+	header := configHeader(network, wcg.now())
+	allConfigs := make(map[string]string, len(servers)+len(nodes))
+	for _, server := range servers {
+		if !server.ExternallyManaged() {
+			allConfigs[server.Name] = header + wcg.generateServerConfig(network, server, servers, nodes)
+		}
+	}
+
+	workers := wcg.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	results := make([]string, len(nodes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for i := range jobs {
+				results[i] = header + wcg.generateNodeConfig(network, servers, nodes[i], nodes, peers, routes)
+			}
+		})
+	}
+	for i, node := range nodes {
+		if ctx.Err() != nil {
+			break
+		}
+		if !node.ExternallyManaged() {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// Generate server config
-	serverConfig := wcg.generateServerConfig(network, server, nodes)
+	for i, node := range nodes {
+		if !node.ExternallyManaged() {
+			allConfigs[node.Name] = results[i]
+		}
+	}
+	return allConfigs, nil
+}
 
-	// Generate node configs
-	nodeConfigs := make(map[string]string)
+// withoutExpired drops expired nodes, so they get no config of their own and
+// appear in no peer list, and warns which nodes were left out.
+func (wcg *WireGuardConfigGenerator) withoutExpired(networkName string, nodes []*Node) []*Node {
+	now := wcg.now()
+	active := nodes[:0:0]
+	var expired []string
 	for _, node := range nodes {
-		nodeConfigs[node.Name] = wcg.generateNodeConfig(network, server, node, nodes)
+		if node.Expired(now) {
+			expired = append(expired, node.Name)
+			continue
+		}
+		active = append(active, node)
+	}
+	if len(expired) > 0 {
+		sort.Strings(expired)
+		wcg.logger.Warn("excluding expired nodes from configs", "network", networkName, "nodes", strings.Join(expired, ","))
 	}
+	return active
+}
+
+// GenerateConfig generates the configs of a network and returns the one for
+// entityName, the server or a node. Nothing is written or saved.
+func (wcg *WireGuardConfigGenerator) GenerateConfig(networkName, entityName string) (string, error) {
+	return wcg.GenerateConfigCtx(context.Background(), networkName, entityName)
+}
 
-	// Combine all configs
-	allConfigs := make(map[string]string)
-	allConfigs[server.Name] = serverConfig
-	for name, config := range nodeConfigs {
-		allConfigs[name] = config
+// GenerateConfigCtx is GenerateConfig with a context.
+func (wcg *WireGuardConfigGenerator) GenerateConfigCtx(ctx context.Context, networkName, entityName string) (string, error) {
+	configs, _, err := wcg.GenerateConfigsCtx(ctx, networkName, wcg.storage)
+	if err != nil {
+		return "", err
 	}
+	selected, err := wcg.SelectConfigs(networkName, configs, []string{entityName})
+	if err != nil {
+		return "", err
+	}
+	return selected[entityName], nil
+}
 
-	// Calculate content hash
-	contentHash := wcg.calculateConfigHash(allConfigs)
+// SelectConfigs returns the subset of a network's generated configs named in
+// names. A name that is not the server or a node of the network, or that is
+// managed outside wedevctl, is an error.
+func (wcg *WireGuardConfigGenerator) SelectConfigs(networkName string, configs map[string]string, names []string) (map[string]string, error) {
+	selected := make(map[string]string, len(names))
+	for _, name := range names {
+		config, ok := configs[name]
+		if !ok {
+			if wcg.externallyManaged(networkName, name) {
+				return nil, fmt.Errorf("%q has imported public-only keys and is managed outside wedevctl; no config is generated for it", name)
+			}
+			if wcg.expired(networkName, name) {
+				return nil, fmt.Errorf("node %q has expired; no config is generated for it (extend it with 'node edit --expires' or remove it with 'node purge-expired')", name)
+			}
+			return nil, kindErrorf(ErrNotFound, "no server or node named %q in network %q", name, networkName)
+		}
+		selected[name] = config
+	}
+	return selected, nil
+}
 
-	return allConfigs, contentHash, nil
+// expired reports whether entityName is an expired node of the network.
+func (wcg *WireGuardConfigGenerator) expired(networkName, entityName string) bool {
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return false
+	}
+	node, err := wcg.storage.GetNodeByName(network.ID, entityName)
+	return err == nil && node.Expired(wcg.now())
+}
+
+// externallyManaged reports whether entityName is a server or node of the
+// network whose keys were imported without a private key.
+func (wcg *WireGuardConfigGenerator) externallyManaged(networkName, entityName string) bool {
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return false
+	}
+	if server, err := wcg.storage.GetServerByName(network.ID, entityName); err == nil {
+		return server.ExternallyManaged()
+	}
+	if node, err := wcg.storage.GetNodeByName(network.ID, entityName); err == nil {
+		return node.ExternallyManaged()
+	}
+	return false
+}
+
+// peerSection is the [Peer] section of a peer node as the other nodes list
+// it, built and rendered once per generation with its public and its
+// internal endpoint, each with and without PersistentKeepalive, since every
+// node config of a hub-spoke network repeats it.
+type peerSection struct {
+	nodeID   string
+	sections [2][2]*ConfigSection // by preferInternal, then keepalive
 }
 
-// generateServerConfig generates the server configuration.
-func (wcg *WireGuardConfigGenerator) generateServerConfig(_ *VirtualNetwork, server *Server, nodes []*Node) string {
+// newPeerSection builds the sections of a peer node; keepalive is the
+// directive route nodes add, if ok.
+func newPeerSection(node *Node, keepalive ConfigDirective, ok bool) peerSection {
+	p := peerSection{nodeID: node.ID}
+	for i, preferInternal := range []bool{false, true} {
+		directives := []ConfigDirective{
+			{Key: "PublicKey", Value: node.PublicKey, Source: SourcePeer, Reason: "peer " + node.Name + "'s public key"},
+			{Key: "AllowedIPs", Value: node.VirtualIP + "/32", Source: SourceDerived, Reason: "peer " + node.Name + "'s virtual IP"},
+		}
+		if endpoint := node.EndpointFor(preferInternal); endpoint != "" {
+			directives = append(directives, endpointDirective("peer "+node.Name, endpoint, preferInternal && node.InternalAddress != ""))
+		}
+		p.sections[i][0] = newPeerConfigSection(node.Name, node.VirtualIP, directives)
+		p.sections[i][1] = p.sections[i][0]
+		if ok {
+			p.sections[i][1] = newPeerConfigSection(node.Name, node.VirtualIP, append(directives[:len(directives):len(directives)], keepalive))
+		}
+	}
+	return p
+}
+
+// For returns the section for a node that does or does not prefer internal
+// endpoints and keep its tunnels alive.
+func (p peerSection) For(preferInternal, keepalive bool) *ConfigSection {
+	i, j := 0, 0
+	if preferInternal {
+		i = 1
+	}
+	if keepalive {
+		j = 1
+	}
+	return p.sections[i][j]
+}
+
+// routedCIDR is a LAN subnet exposed behind a route node.
+type routedCIDR struct {
+	nodeID string
+	cidr   string
+}
+
+// interfaceAddress returns the [Interface] Address for ip: the address with
+// the network's prefix length, so the kernel installs the on-link route for
+// the VPN subnet. Peer AllowedIPs keep /32 host routes.
+func interfaceAddress(network *VirtualNetwork, ip string) string {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return ip + "/32"
+	}
+	return fmt.Sprintf("%s/%d", ip, prefix.Bits())
+}
+
+// configHeaderPrefix starts the header comment of every generated config.
+const configHeaderPrefix = "# network: "
+
+// configHeader returns the first line of every config of network: the
+// network, the wedevctl version, and the generation time. The time is
+// ignored when configs are hashed or compared (see normalizeConfig), so
+// regenerating unchanged configs still matches the saved version.
+func configHeader(network *VirtualNetwork, generatedAt time.Time) string {
+	return fmt.Sprintf("%s%s, generated by wedevctl %s at %s\n", configHeaderPrefix, network.Name, version.Version, generatedAt.UTC().Format(time.RFC3339))
+}
+
+// writePeerHeader starts a [Peer] section, preceded by a blank line and a
+// comment naming the peer and its virtual IP.
+func writePeerHeader(config *strings.Builder, name, virtualIP string) {
+	fmt.Fprintf(config, "\n# %s (%s)\n[Peer]\n", name, virtualIP)
+}
+
+// normalizeConfig drops the generation time from a config's header, so two
+// generations of the same content compare and hash equal.
+func normalizeConfig(config string) string {
+	if !strings.HasPrefix(config, configHeaderPrefix) {
+		return config
+	}
+	header, rest, _ := strings.Cut(config, "\n")
+	if i := strings.LastIndex(header, " at "); i >= 0 {
+		header = header[:i]
+	}
+	return header + "\n" + rest
+}
+
+// StripComments removes the comment lines (the header and the peer names)
+// from a generated config, for people who want minimal files.
+func StripComments(config string) string {
+	lines := strings.SplitAfter(config, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// servesNode reports whether server has node as a direct peer: it is the
+// node's assigned server, or the node peers with every server.
+func servesNode(server *Server, servers []*Server, node *Node) bool {
+	return node.MeshServers || NodeServer(node, servers).ID == server.ID
+}
+
+// generateServerConfig generates a server configuration: its own nodes, then
+// the other servers, each routing the nodes only it serves. In the
+// masquerade NAT mode, each route node exposing LAN subnets gets its own
+// PostUp and PostDown lines forwarding and masquerading traffic to those
+// subnets on the WireGuard interface, in node order (by virtual IP), so
+// regenerating an unchanged network hashes the same.
+func (wcg *WireGuardConfigGenerator) generateServerConfig(network *VirtualNetwork, server *Server, servers []*Server, nodes []*Node) string {
 	var config strings.Builder
 
+	var postUp, postDown []string
+	if network.EffectiveNATMode() == NATModeMasquerade {
+		for _, node := range nodes {
+			if len(node.RoutedCIDRs) > 0 {
+				postUp = append(postUp, natRules("-A", node.RoutedCIDRs))
+				postDown = append(postDown, natRules("-D", node.RoutedCIDRs))
+			}
+		}
+	}
+
 	config.WriteString("[Interface]\n")
 	fmt.Fprintf(&config, "PrivateKey = %s\n", server.PrivateKey)
-	fmt.Fprintf(&config, "Address = %s/32\n", server.VirtualIP)
+	fmt.Fprintf(&config, "Address = %s\n", interfaceAddress(network, server.VirtualIP))
 	fmt.Fprintf(&config, "ListenPort = %d\n", server.Port)
+	if mtu := network.MTU(); mtu != 0 {
+		fmt.Fprintf(&config, "MTU = %d\n", mtu)
+	}
 	config.WriteString("PostUp = sysctl -w net.ipv4.ip_forward=1\n")
+	for _, rules := range postUp {
+		fmt.Fprintf(&config, "PostUp = %s\n", rules)
+	}
 	config.WriteString("PostDown = sysctl -w net.ipv4.ip_forward=0\n")
+	for _, rules := range postDown {
+		fmt.Fprintf(&config, "PostDown = %s\n", rules)
+	}
 
-	// Add peer for each node
+	// Add peer for each node the server serves
 	for _, node := range nodes {
-		config.WriteString("\n[Peer]\n")
+		if !servesNode(server, servers, node) {
+			continue
+		}
+		writePeerHeader(&config, node.Name, node.VirtualIP)
 		fmt.Fprintf(&config, "PublicKey = %s\n", node.PublicKey)
-		fmt.Fprintf(&config, "AllowedIPs = %s/32\n", node.VirtualIP)
+		allowedIPs := append([]string{node.VirtualIP + "/32"}, node.RoutedCIDRs...)
+		fmt.Fprintf(&config, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
 		// Only add Endpoint for peer type nodes (route nodes connect to server, not vice versa)
 		if node.Type == NodeTypePeer && node.PublicAddress != "" {
 			endpoint := util.FormatEndpoint(node.PublicAddress, node.Port)
@@ -545,76 +2170,211 @@ func (wcg *WireGuardConfigGenerator) generateServerConfig(_ *VirtualNetwork, ser
 		}
 	}
 
+	// Add the other servers, each routing the nodes reachable only through it
+	for _, other := range servers {
+		if other.ID == server.ID {
+			continue
+		}
+		allowedIPs := []string{other.VirtualIP + "/32"}
+		for _, node := range nodes {
+			if NodeServer(node, servers).ID == other.ID && !servesNode(server, servers, node) {
+				allowedIPs = append(allowedIPs, node.VirtualIP+"/32")
+				allowedIPs = append(allowedIPs, node.RoutedCIDRs...)
+			}
+		}
+		writePeerHeader(&config, other.Name, other.VirtualIP)
+		fmt.Fprintf(&config, "PublicKey = %s\n", other.PublicKey)
+		fmt.Fprintf(&config, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+		if other.PublicAddress != "" {
+			fmt.Fprintf(&config, "Endpoint = %s\n", util.FormatEndpoint(other.PublicAddress, other.Port))
+		}
+	}
+
 	// Trailing blank line at end of file.
 	config.WriteString("\n")
 
 	return config.String()
 }
 
-// generateNodeConfig generates a configuration for a specific node
-func (wcg *WireGuardConfigGenerator) generateNodeConfig(network *VirtualNetwork, server *Server, node *Node, allNodes []*Node) string {
-	var config strings.Builder
+// natRules returns the iptables commands, joined for one PostUp or PostDown
+// line, that add (action "-A") or delete ("-D") the masquerade NAT mode's
+// rules for a route node's subnets: forward traffic to and from each subnet
+// on the WireGuard interface, and masquerade what goes to it. IPv6 subnets
+// use ip6tables.
+func natRules(action string, cidrs []string) string {
+	var rules []string
+	for _, cidr := range cidrs {
+		iptables := "iptables"
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Addr().Is6() {
+			iptables = "ip6tables"
+		}
+		rules = append(rules,
+			fmt.Sprintf("%s %s FORWARD -i %%i -d %s -j ACCEPT", iptables, action, cidr),
+			fmt.Sprintf("%s %s FORWARD -o %%i -s %s -j ACCEPT", iptables, action, cidr),
+			fmt.Sprintf("%s -t nat %s POSTROUTING -o %%i -d %s -j MASQUERADE", iptables, action, cidr),
+		)
+	}
+	return strings.Join(rules, "; ")
+}
 
-	config.WriteString("[Interface]\n")
-	fmt.Fprintf(&config, "PrivateKey = %s\n", node.PrivateKey)
-	fmt.Fprintf(&config, "Address = %s/32\n", node.VirtualIP)
-	fmt.Fprintf(&config, "ListenPort = %d\n", node.Port)
+// meshPeers reports whether two nodes of a mesh network peer directly: at
+// least one of them must have a public address for the other to dial, and
+// client nodes only ever peer with servers.
+func meshPeers(a, b *Node) bool {
+	if a.Type == NodeTypeClient || b.Type == NodeTypeClient {
+		return false
+	}
+	return a.ID != b.ID && (a.PublicAddress != "" || b.PublicAddress != "")
+}
 
-	// Add server peer
-	config.WriteString("\n[Peer]\n")
-	fmt.Fprintf(&config, "PublicKey = %s\n", server.PublicKey)
-	fmt.Fprintf(&config, "AllowedIPs = %s\n", network.CIDR)
-	if server.PublicAddress != "" {
-		endpoint := util.FormatEndpoint(server.PublicAddress, server.Port)
-		fmt.Fprintf(&config, "Endpoint = %s\n", endpoint)
+// fullTunnelAllowedIPs is the server peer's AllowedIPs in the config of a
+// full-tunnel node: every IPv4 and IPv6 destination.
+var fullTunnelAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+
+// generateNodeConfig generates a configuration for a specific node by
+// rendering its structuredNodeConfig.
+func (wcg *WireGuardConfigGenerator) generateNodeConfig(network *VirtualNetwork, servers []*Server, node *Node, allNodes []*Node, peers []peerSection, routes []routedCIDR) string {
+	return wcg.structuredNodeConfig(network, servers, node, allNodes, peers, routes).Render()
+}
+
+// structuredNodeConfig builds the configuration of a specific node. The rest
+// of the network, including subnets routed by other route nodes, is reached
+// through the node's assigned server, so those go in that server peer's
+// AllowedIPs; a full-tunnel node sends all traffic there instead. A node
+// meshed with every server also peers with the others, each for its own
+// address only. In a mesh network the node peers directly with every node it
+// can reach, and their subnets move to those peers; a mesh network without a
+// server has only those peers. A client node peers with servers only, in
+// either topology.
+func (wcg *WireGuardConfigGenerator) structuredNodeConfig(network *VirtualNetwork, servers []*Server, node *Node, allNodes []*Node, peers []peerSection, routes []routedCIDR) *StructuredConfig {
+	server := NodeServer(node, servers)
+	mesh := network.EffectiveTopology() == TopologyMesh
+	direct := make(map[string]bool)
+	if mesh {
+		for _, other := range allNodes {
+			direct[other.ID] = meshPeers(node, other)
+		}
 	}
-	// Route nodes connect outbound only; keep the tunnel to the server alive.
-	if node.Type == NodeTypeRoute {
-		fmt.Fprintf(&config, "PersistentKeepalive = %d\n", persistentKeepalive)
+
+	// Route and client nodes connect outbound only (typically behind NAT), so
+	// a keepalive holds their tunnels open; the network may turn it off.
+	keepalive, hasKeepalive := keepaliveDirective(network)
+	withKeepalive := func(directives []ConfigDirective, keep bool) []ConfigDirective {
+		if keep && hasKeepalive {
+			return append(directives, keepalive)
+		}
+		return directives
 	}
 
-	// For peer type nodes, add peer connections to other peer nodes
-	if node.Type == NodeTypePeer {
-		for _, otherNode := range allNodes {
-			if otherNode.ID != node.ID && otherNode.Type == NodeTypePeer {
-				config.WriteString("\n[Peer]\n")
-				fmt.Fprintf(&config, "PublicKey = %s\n", otherNode.PublicKey)
-				fmt.Fprintf(&config, "AllowedIPs = %s/32\n", otherNode.VirtualIP)
-				if otherNode.PublicAddress != "" {
-					endpoint := util.FormatEndpoint(otherNode.PublicAddress, otherNode.Port)
-					fmt.Fprintf(&config, "Endpoint = %s\n", endpoint)
+	config := &StructuredConfig{Network: network.Name, Node: node.Name}
+	config.Sections = append(config.Sections, &ConfigSection{Kind: "Interface", Directives: interfaceDirectives(network, node)})
+
+	// Add server peer; a serverless mesh has none.
+	if server != nil {
+		serverAllowedIPs := []string{network.CIDR}
+		var routed []string
+		for _, r := range routes {
+			if r.nodeID != node.ID && !direct[r.nodeID] {
+				serverAllowedIPs = append(serverAllowedIPs, r.cidr)
+				routed = append(routed, r.cidr)
+			}
+		}
+		allowedIPs := ConfigDirective{Key: "AllowedIPs", Source: SourceDerived, Reason: "the network CIDR"}
+		if len(routed) > 0 {
+			allowedIPs.Reason += " and the subnets of route nodes reached through the server"
+		}
+		if node.FullTunnel {
+			serverAllowedIPs = fullTunnelAllowedIPs
+			allowedIPs.Source, allowedIPs.Reason = SourceNode, "the node is full-tunnel: all traffic goes through the server"
+		}
+		allowedIPs.Value = strings.Join(serverAllowedIPs, ", ")
+		directives := []ConfigDirective{
+			{Key: "PublicKey", Value: server.PublicKey, Source: SourcePeer, Reason: "server " + server.Name + "'s public key"},
+			allowedIPs,
+		}
+		directives = append(directives, serverEndpointDirectives(server, node)...)
+		// Route and client nodes connect outbound only; keep the tunnel to the
+		// server alive.
+		directives = withKeepalive(directives, node.keepsAlive())
+		config.Sections = append(config.Sections, &ConfigSection{Kind: "Peer", Name: server.Name, VirtualIP: server.VirtualIP, Directives: directives})
+
+		// Add the other servers for a node meshed with all of them
+		if node.MeshServers {
+			for _, other := range servers {
+				if other.ID == server.ID {
+					continue
+				}
+				directives := []ConfigDirective{
+					{Key: "PublicKey", Value: other.PublicKey, Source: SourcePeer, Reason: "server " + other.Name + "'s public key"},
+					{Key: "AllowedIPs", Value: other.VirtualIP + "/32", Source: SourceDerived, Reason: "server " + other.Name + "'s virtual IP; the node meshes with every server"},
 				}
+				directives = append(directives, serverEndpointDirectives(other, node)...)
+				directives = withKeepalive(directives, node.keepsAlive())
+				config.Sections = append(config.Sections, &ConfigSection{Kind: "Peer", Name: other.Name, VirtualIP: other.VirtualIP, Directives: directives})
 			}
 		}
 	}
 
-	// For route type nodes, add peer connections to all peer nodes
-	// This allows route nodes to communicate directly with peer nodes
-	// Route-to-route communication still goes through the server
-	if node.Type == NodeTypeRoute {
+	switch {
+	case node.Type == NodeTypeClient:
+		// Clients reach everything through their server; no other node
+		// lists them either.
+
+	case mesh:
+		// Peer with every node either side can dial; pairs where neither
+		// has a public address keep going through the server.
 		for _, otherNode := range allNodes {
-			if otherNode.Type != NodeTypePeer {
+			if !direct[otherNode.ID] {
 				continue
 			}
-			config.WriteString("\n[Peer]\n")
-			fmt.Fprintf(&config, "PublicKey = %s\n", otherNode.PublicKey)
-			fmt.Fprintf(&config, "AllowedIPs = %s/32\n", otherNode.VirtualIP)
-			if otherNode.PublicAddress != "" {
-				endpoint := util.FormatEndpoint(otherNode.PublicAddress, otherNode.Port)
-				fmt.Fprintf(&config, "Endpoint = %s\n", endpoint)
+			allowedIPs := ConfigDirective{Key: "AllowedIPs", Source: SourceDerived, Reason: "peer " + otherNode.Name + "'s virtual IP"}
+			allowedIPs.Value = strings.Join(append([]string{otherNode.VirtualIP + "/32"}, otherNode.RoutedCIDRs...), ", ")
+			if len(otherNode.RoutedCIDRs) > 0 {
+				allowedIPs.Reason += " and routed subnets"
+			}
+			directives := []ConfigDirective{
+				{Key: "PublicKey", Value: otherNode.PublicKey, Source: SourcePeer, Reason: "peer " + otherNode.Name + "'s public key"},
+				allowedIPs,
+			}
+			if endpoint := otherNode.EndpointFor(node.PreferInternal); endpoint != "" {
+				directives = append(directives, endpointDirective("peer "+otherNode.Name, endpoint, node.PreferInternal && otherNode.InternalAddress != ""))
+			}
+			directives = withKeepalive(directives, node.Type == NodeTypeRoute)
+			config.Sections = append(config.Sections, &ConfigSection{Kind: "Peer", Name: otherNode.Name, VirtualIP: otherNode.VirtualIP, Directives: directives})
+		}
+
+	case node.Type == NodeTypePeer:
+		// For peer type nodes, add peer connections to other peer nodes
+		config.Sections = slices.Grow(config.Sections, len(peers))
+		for _, peer := range peers {
+			if peer.nodeID != node.ID {
+				config.Sections = append(config.Sections, peer.For(node.PreferInternal, false))
 			}
-			// Route node behind NAT: keep the tunnel to this peer alive.
-			fmt.Fprintf(&config, "PersistentKeepalive = %d\n", persistentKeepalive)
+		}
+
+	case node.Type == NodeTypeRoute:
+		// For route type nodes, add peer connections to all peer nodes
+		// This allows route nodes to communicate directly with peer nodes
+		// Route-to-route communication still goes through the server.
+		// Route node behind NAT: keep the tunnel to each peer alive.
+		config.Sections = slices.Grow(config.Sections, len(peers))
+		for _, peer := range peers {
+			config.Sections = append(config.Sections, peer.For(node.PreferInternal, true))
 		}
 	}
 
-	// Trailing blank line at end of file.
-	config.WriteString("\n")
+	return config
+}
 
-	return config.String()
+// EntityConfigHash returns the hash a Deployment records for one entity's
+// config. The generation time in the header does not affect it.
+func EntityConfigHash(config string) string {
+	hash := sha256.Sum256([]byte(normalizeConfig(config)))
+	return hex.EncodeToString(hash[:])
 }
 
-// calculateConfigHash calculates the hash of all configurations
+// calculateConfigHash calculates the hash of all configurations, ignoring
+// their generation time
 func (wcg *WireGuardConfigGenerator) calculateConfigHash(configs map[string]string) string {
 	// Sort config names for consistent hashing
 	names := make([]string, 0, len(configs))
@@ -623,42 +2383,86 @@ func (wcg *WireGuardConfigGenerator) calculateConfigHash(configs map[string]stri
 	}
 	sort.Strings(names)
 
-	// Concatenate all configs in sorted order
-	var combined strings.Builder
+	// Hash "name:config" for each config in sorted order, streamed rather
+	// than concatenated first
+	hash := sha256.New()
 	for _, name := range names {
-		combined.WriteString(name)
-		combined.WriteString(":")
-		combined.WriteString(configs[name])
+		hash.Write([]byte(name + ":"))
+		hash.Write([]byte(normalizeConfig(configs[name])))
 	}
-
-	// Calculate SHA256 hash
-	hash := sha256.Sum256([]byte(combined.String()))
-	return hex.EncodeToString(hash[:])
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 // SaveConfigVersion saves a configuration version if content has changed
 func (wcg *WireGuardConfigGenerator) SaveConfigVersion(networkName string) (*ConfigVersion, bool, error) {
+	return wcg.SaveConfigVersionWithMessage(networkName, "")
+}
+
+// SaveConfigVersionWithMessage saves a configuration version if content has
+// changed. The version records message followed by a summary of the changes
+// against the previous version, and the OS user running wedevctl.
+func (wcg *WireGuardConfigGenerator) SaveConfigVersionWithMessage(networkName, message string) (*ConfigVersion, bool, error) {
+	return wcg.SaveConfigVersionWithMessageCtx(context.Background(), networkName, message)
+}
+
+// SaveConfigVersionWithMessageCtx is SaveConfigVersionWithMessage with a
+// context.
+func (wcg *WireGuardConfigGenerator) SaveConfigVersionWithMessageCtx(ctx context.Context, networkName, message string) (*ConfigVersion, bool, error) {
+	return wcg.SaveConfigVersionWithOptionsCtx(ctx, networkName, ConfigSaveOptions{Message: message})
+}
+
+// ConfigSaveOptions are the options of SaveConfigVersionWithOptionsCtx.
+type ConfigSaveOptions struct {
+	Message string
+	// OverrideFreeze saves a new version even though the network's config
+	// is frozen. The override is logged and noted in the version message.
+	OverrideFreeze bool
+}
+
+// SaveConfigVersionWithOptionsCtx is SaveConfigVersionWithMessageCtx with
+// options. A new version of a network whose config is frozen is refused
+// with ErrFrozen unless opts.OverrideFreeze is set; unchanged configs are
+// not a new version, so they are never refused.
+func (wcg *WireGuardConfigGenerator) SaveConfigVersionWithOptionsCtx(ctx context.Context, networkName string, opts ConfigSaveOptions) (*ConfigVersion, bool, error) {
 	// Generate current configs
-	configs, currentHash, err := wcg.GenerateConfigs(networkName, wcg.storage)
+	configs, currentHash, err := wcg.GenerateConfigsCtx(ctx, networkName, wcg.storage)
 	if err != nil {
 		return nil, false, err
 	}
 
 	// Get network
-	network, err := wcg.storage.GetNetworkByName(networkName)
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
 	if err != nil {
 		return nil, false, err
 	}
 
 	// Check if latest version has same hash
-	latest, err := wcg.storage.GetLatestConfigVersion(network.ID)
-	if err == nil && latest.ContentHash == currentHash {
-		// No change
-		return latest, false, nil
+	var previous map[string]string
+	latest, err := wcg.storage.GetLatestConfigVersionCtx(ctx, network.ID)
+	if err == nil {
+		if latest.ContentHash == currentHash {
+			// No change
+			return latest, false, nil
+		}
+		previous = latest.Configs
+	}
+
+	message := opts.Message
+	if network.Freeze != nil {
+		if !opts.OverrideFreeze {
+			return nil, false, frozenError(network)
+		}
+		wcg.logger.Warn("config freeze overridden", "network", network.Name, "by", currentUsername(), "freeze", network.Freeze.String())
+		if message == "" {
+			message = "freeze overridden"
+		} else {
+			message = "freeze overridden: " + message
+		}
 	}
 
 	// Save new version
-	version, err := wcg.storage.SaveConfigVersion(network.ID, currentHash, configs)
+	message = joinVersionMessage(message, SummarizeChanges(previous, configs))
+	version, err := wcg.storage.SaveConfigVersionWithMessageCtx(ctx, network.ID, currentHash, configs, message, currentUsername())
 	if err != nil {
 		return nil, false, err
 	}
@@ -666,14 +2470,51 @@ func (wcg *WireGuardConfigGenerator) SaveConfigVersion(networkName string) (*Con
 	return version, true, nil
 }
 
+// joinVersionMessage combines a user's version message with the generated
+// change summary: "message (summary)", or whichever of the two is set.
+func joinVersionMessage(message, summary string) string {
+	switch {
+	case message == "":
+		return summary
+	case summary == "":
+		return message
+	}
+	return message + " (" + summary + ")"
+}
+
+// currentUsername returns the name of the OS user running wedevctl, or ""
+// when it cannot be determined.
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
 // GetConfigHistory retrieves the configuration history for a network
 func (wcg *WireGuardConfigGenerator) GetConfigHistory(networkName string) ([]*ConfigVersion, error) {
-	network, err := wcg.storage.GetNetworkByName(networkName)
+	return wcg.GetConfigHistoryCtx(context.Background(), networkName)
+}
+
+// GetConfigHistoryCtx is GetConfigHistory with a context.
+func (wcg *WireGuardConfigGenerator) GetConfigHistoryCtx(ctx context.Context, networkName string) ([]*ConfigVersion, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
 	if err != nil {
 		return nil, err
 	}
 
-	return wcg.storage.ListConfigVersions(network.ID)
+	return wcg.storage.ListConfigVersionsCtx(ctx, network.ID)
+}
+
+// GetConfigHistoryFilteredCtx is GetConfigHistoryCtx returning only the
+// versions filter selects, along with how many versions the network has.
+func (wcg *WireGuardConfigGenerator) GetConfigHistoryFilteredCtx(ctx context.Context, networkName string, filter ConfigVersionFilter) ([]*ConfigVersion, int, error) {
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return wcg.storage.ListConfigVersionsFilteredCtx(ctx, network.ID, filter)
 }
 
 // GetConfig retrieves a specific configuration version
@@ -685,3 +2526,49 @@ func (wcg *WireGuardConfigGenerator) GetConfig(networkName string, version int)
 
 	return wcg.storage.GetConfigVersion(network.ID, version)
 }
+
+// ConfigPreview is the result of generating configs without saving them:
+// the diff against the latest saved version.
+type ConfigPreview struct {
+	Configs     map[string]string
+	ContentHash string
+	BaseVersion int  // latest saved version; 0 when none exists
+	Unchanged   bool // content hash matches the latest version
+	Files       []FileDiff
+}
+
+// PreviewConfigs generates configs in memory and diffs them against the
+// latest saved version, without writing files or saving a version.
+func (wcg *WireGuardConfigGenerator) PreviewConfigs(networkName string) (*ConfigPreview, error) {
+	return wcg.PreviewConfigsCtx(context.Background(), networkName)
+}
+
+// PreviewConfigsCtx is PreviewConfigs with a context.
+func (wcg *WireGuardConfigGenerator) PreviewConfigsCtx(ctx context.Context, networkName string) (*ConfigPreview, error) {
+	configs, hash, err := wcg.GenerateConfigsCtx(ctx, networkName, wcg.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := wcg.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &ConfigPreview{Configs: configs, ContentHash: hash}
+
+	var base map[string]string
+	baseLabel := "none"
+	if latest, err := wcg.storage.GetLatestConfigVersionCtx(ctx, network.ID); err == nil {
+		preview.BaseVersion = latest.Version
+		preview.Unchanged = latest.ContentHash == hash
+		base = latest.Configs
+		baseLabel = fmt.Sprintf("version %d", latest.Version)
+	}
+
+	if !preview.Unchanged {
+		preview.Files = DiffConfigs(base, configs, baseLabel, "generated")
+	}
+
+	return preview, nil
+}
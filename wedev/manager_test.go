@@ -1,12 +1,21 @@
 package wedev
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/wedevctl/util"
+	"github.com/wedevctl/version"
+	"go.etcd.io/bbolt"
 )
 
 func TestCreateVirtualNetwork_Success(t *testing.T) {
@@ -84,7 +93,7 @@ func TestCreateVirtualNetwork_ReservedName(t *testing.T) {
 
 	// These names collide with `vn` CLI subcommands; a network with one of
 	// them would be unmanageable, so creation must reject them.
-	for _, name := range []string{"add", "list", "delete", "help", "completion"} {
+	for _, name := range []string{"add", "list", "delete", "help", "completion", "ls", "rm"} {
 		if _, err := vnm.CreateVirtualNetwork(name, "10.0.0.0/24"); err == nil {
 			t.Errorf("CreateVirtualNetwork(%q) accepted a reserved name", name)
 		}
@@ -96,6 +105,28 @@ func TestCreateVirtualNetwork_ReservedName(t *testing.T) {
 	}
 }
 
+func TestCreateVirtualNetwork_NormalizesCIDR(t *testing.T) {
+	vnm := newReviewTestManager(t)
+
+	net, err := vnm.CreateVirtualNetwork("hostbits", "10.0.0.1/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if net.CIDR != "10.0.0.0/24" {
+		t.Errorf("CIDR = %s, want 10.0.0.0/24", net.CIDR)
+	}
+
+	for _, cidr := range []string{"10.1.0.0/31", "10.1.0.5/32"} {
+		_, err := vnm.CreateVirtualNetwork("tiny", cidr)
+		if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "too small") {
+			t.Errorf("CreateVirtualNetwork(%s) error = %v, want a too-small ErrValidation", cidr, err)
+		}
+	}
+	if net, err := vnm.CreateVirtualNetwork("smallest", "10.2.0.1/30"); err != nil || net.CIDR != "10.2.0.0/30" {
+		t.Errorf("CreateVirtualNetwork(/30) = %+v, %v", net, err)
+	}
+}
+
 func TestCreateServer_Success(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -311,6 +342,56 @@ func TestCreateServerNode_InvalidPort(t *testing.T) {
 	if _, err := vnm.CreateNode("testnet", "n1", "1.2.3.4", 51821, NodeTypePeer); err != nil {
 		t.Errorf("CreateNode() rejected a valid port: %v", err)
 	}
+
+	// The error names the offending port; edits are checked the same way.
+	if _, err := vnm.CreateNode("testnet", "n2", "1.2.3.4", 65536, NodeTypePeer); !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "65536") {
+		t.Errorf("CreateNode(65536) error = %v, want ErrValidation naming the port", err)
+	}
+	for _, p := range []int{0, 65536} {
+		if _, err := vnm.UpdateServer("testnet", "gw", "1.2.3.4", p); !errors.Is(err, ErrValidation) {
+			t.Errorf("UpdateServer() port %d error = %v, want ErrValidation", p, err)
+		}
+		if _, err := vnm.EditNode("testnet", "n1", NodeEdit{Port: &p}); !errors.Is(err, ErrValidation) {
+			t.Errorf("EditNode() port %d error = %v, want ErrValidation", p, err)
+		}
+	}
+	for _, p := range []int{1, 65535} {
+		if _, err := vnm.EditNode("testnet", "n1", NodeEdit{Port: &p, IgnoreConflict: true}); err != nil {
+			t.Errorf("EditNode() rejected boundary port %d: %v", p, err)
+		}
+	}
+}
+
+func TestPublicAddressInsideNetwork(t *testing.T) {
+	vnm := newReviewTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	// An endpoint inside the VPN range cannot carry the tunnel itself.
+	if _, err := vnm.CreateServer("testnet", "gw", "10.0.0.200", 0); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateServer() with an address in the network error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.CreateServer("testnet", "gw", "1.2.3.4", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.UpdateServer("testnet", "gw", "10.0.0.200", 51820); !errors.Is(err, ErrValidation) {
+		t.Errorf("UpdateServer() with an address in the network error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.CreateNode("testnet", "n1", "10.0.0.9", 0, NodeTypePeer); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateNode() with an address in the network error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.CreateNode("testnet", "n1", "1.2.3.5", 0, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, err := vnm.UpdateNode("testnet", "n1", "10.0.0.9", 51820, NodeTypePeer); !errors.Is(err, ErrValidation) {
+		t.Errorf("UpdateNode() with an address in the network error = %v, want ErrValidation", err)
+	}
+
+	// An address with a port is rejected rather than doubled in the endpoint.
+	if _, err := vnm.CreateNode("testnet", "n2", "host.example.com:51820", 0, NodeTypePeer); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateNode() with host:port error = %v, want ErrValidation", err)
+	}
 }
 
 func TestServerNodeNameCollision(t *testing.T) {
@@ -420,11 +501,11 @@ func TestIPReuseAcrossManagerRestart(t *testing.T) {
 	if _, err := vnm1.CreateVirtualNetwork("testnet", "192.168.1.0/24"); err != nil {
 		t.Fatalf("CreateVirtualNetwork() error = %v", err)
 	}
-	if _, err := vnm1.CreateServer("testnet", "server1", "192.168.1.100", 51820); err != nil {
+	if _, err := vnm1.CreateServer("testnet", "server1", "203.0.113.100", 51820); err != nil {
 		t.Fatalf("CreateServer() error = %v", err)
 	}
 
-	node1, err := vnm1.CreateNode("testnet", "node1", "192.168.1.2", 51821, NodeTypePeer)
+	node1, err := vnm1.CreateNode("testnet", "node1", "203.0.113.2", 51821, NodeTypePeer)
 	if err != nil {
 		t.Fatalf("CreateNode(node1) error = %v", err)
 	}
@@ -432,7 +513,7 @@ func TestIPReuseAcrossManagerRestart(t *testing.T) {
 		t.Errorf("Expected node1 IP 192.168.1.2, got %s", node1.VirtualIP)
 	}
 
-	node2, err := vnm1.CreateNode("testnet", "node2", "192.168.1.3", 51822, NodeTypePeer)
+	node2, err := vnm1.CreateNode("testnet", "node2", "203.0.113.3", 51822, NodeTypePeer)
 	if err != nil {
 		t.Fatalf("CreateNode(node2) error = %v", err)
 	}
@@ -440,7 +521,7 @@ func TestIPReuseAcrossManagerRestart(t *testing.T) {
 		t.Errorf("Expected node2 IP 192.168.1.3, got %s", node2.VirtualIP)
 	}
 
-	node3, err := vnm1.CreateNode("testnet", "node3", "192.168.1.4", 51823, NodeTypePeer)
+	node3, err := vnm1.CreateNode("testnet", "node3", "203.0.113.4", 51823, NodeTypePeer)
 	if err != nil {
 		t.Fatalf("CreateNode(node3) error = %v", err)
 	}
@@ -475,7 +556,7 @@ func TestIPReuseAcrossManagerRestart(t *testing.T) {
 	}
 
 	// Create new node - should reuse 192.168.1.3 (the deleted node2's IP)
-	node4, err := vnm2.CreateNode("testnet", "node4", "192.168.1.5", 51824, NodeTypePeer)
+	node4, err := vnm2.CreateNode("testnet", "node4", "203.0.113.5", 51824, NodeTypePeer)
 	if err != nil {
 		t.Fatalf("CreateNode(node4) error = %v", err)
 	}
@@ -540,6 +621,15 @@ func TestGenerateServerConfig(t *testing.T) {
 		t.Errorf("Server config missing PostDown directive")
 	}
 
+	// The interface address carries the network prefix so the kernel installs
+	// the on-link route; peers keep /32 host routes.
+	if !strings.Contains(serverConfig, "Address = "+server.VirtualIP+"/24\n") {
+		t.Errorf("Server config Address should use the network prefix /24:\n%s", serverConfig)
+	}
+	if !strings.Contains(serverConfig, "AllowedIPs = "+node1.VirtualIP+"/32\n") {
+		t.Errorf("Server config peer AllowedIPs should stay /32:\n%s", serverConfig)
+	}
+
 	// Server should have peers for both nodes
 	if !strings.Contains(serverConfig, node1.PublicKey) {
 		t.Errorf("Server config missing peer for node1")
@@ -559,6 +649,25 @@ func TestGenerateServerConfig(t *testing.T) {
 	}
 }
 
+func TestInterfaceAddress(t *testing.T) {
+	tests := []struct {
+		cidr string
+		ip   string
+		want string
+	}{
+		{"10.0.0.0/24", "10.0.0.1", "10.0.0.1/24"},
+		{"172.16.0.0/16", "172.16.3.7", "172.16.3.7/16"},
+		{"10.9.0.0/30", "10.9.0.2", "10.9.0.2/30"},
+		{"not-a-cidr", "10.0.0.2", "10.0.0.2/32"},
+	}
+
+	for _, tt := range tests {
+		if got := interfaceAddress(&VirtualNetwork{CIDR: tt.cidr}, tt.ip); got != tt.want {
+			t.Errorf("interfaceAddress(%s, %s) = %s, want %s", tt.cidr, tt.ip, got, tt.want)
+		}
+	}
+}
+
 func TestGeneratePeerNodeConfig(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -602,6 +711,14 @@ func TestGeneratePeerNodeConfig(t *testing.T) {
 
 	node1Config := configs[node1.Name]
 
+	// The interface address carries the network prefix, not /32.
+	if !strings.Contains(node1Config, "Address = "+node1.VirtualIP+"/24\n") {
+		t.Errorf("Peer node config Address should use the network prefix /24:\n%s", node1Config)
+	}
+	if !strings.Contains(node1Config, "AllowedIPs = "+node2.VirtualIP+"/32\n") {
+		t.Errorf("Peer node config peer AllowedIPs should stay /32:\n%s", node1Config)
+	}
+
 	// Peer node should have server peer
 	if !strings.Contains(node1Config, server.PublicKey) {
 		t.Errorf("Peer node config missing server peer")
@@ -671,6 +788,10 @@ func TestGenerateRouteNodeConfig(t *testing.T) {
 
 	routeNodeConfig := configs[node1.Name]
 
+	if !strings.Contains(routeNodeConfig, "Address = "+node1.VirtualIP+"/24\n") {
+		t.Errorf("Route node config Address should use the network prefix /24:\n%s", routeNodeConfig)
+	}
+
 	// Route node should have server peer
 	if !strings.Contains(routeNodeConfig, server.PublicKey) {
 		t.Errorf("Route node config missing server peer")
@@ -698,6 +819,596 @@ func TestGenerateRouteNodeConfig(t *testing.T) {
 	}
 }
 
+func TestGenerateClientNodeConfig(t *testing.T) {
+	for _, topology := range []Topology{TopologyHubSpoke, TopologyMesh} {
+		t.Run(string(topology), func(t *testing.T) {
+			vnm, storage := newTestManager(t)
+
+			if _, err := vnm.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.SetTopology("testnet", topology); err != nil {
+				t.Fatalf("SetTopology() error = %v", err)
+			}
+			if _, err := vnm.SetDNS("testnet", []string{"10.0.0.1"}); err != nil {
+				t.Fatalf("SetDNS() error = %v", err)
+			}
+			server, err := vnm.CreateServer("testnet", "server1", "192.168.1.1", 51820)
+			if err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			client, err := vnm.CreateNode("testnet", "laptop", "", 0, NodeTypeClient)
+			if err != nil {
+				t.Fatalf("CreateNode(laptop) error = %v", err)
+			}
+			for _, n := range []struct {
+				name, address string
+				nodeType      NodeType
+			}{{"peer1", "192.168.1.2", NodeTypePeer}, {"route1", "192.168.1.3", NodeTypeRoute}, {"route2", "", NodeTypeRoute}} {
+				if _, err := vnm.CreateNode("testnet", n.name, n.address, 0, n.nodeType); err != nil {
+					t.Fatalf("CreateNode(%s) error = %v", n.name, err)
+				}
+			}
+
+			generator := NewWireGuardConfigGenerator(storage)
+			configs, _, err := generator.GenerateConfigs("testnet", storage)
+			if err != nil {
+				t.Fatalf("GenerateConfigs() error = %v", err)
+			}
+
+			// The server lists the client, without an Endpoint to dial.
+			want := fmt.Sprintf("[Peer]\nPublicKey = %s\nAllowedIPs = %s/32\n\n", client.PublicKey, client.VirtualIP)
+			if !strings.Contains(configs["server1"], want) {
+				t.Errorf("server config missing the client peer %q:\n%s", want, configs["server1"])
+			}
+
+			// The client peers with the server only, kept alive, with the DNS.
+			clientConfig := configs["laptop"]
+			if got := strings.Count(clientConfig, "[Peer]"); got != 1 || !strings.Contains(clientConfig, server.PublicKey) {
+				t.Errorf("client config has %d peers, want the server only:\n%s", got, clientConfig)
+			}
+			for _, want := range []string{"AllowedIPs = 10.0.0.0/24\n", "PersistentKeepalive = 25\n", "DNS = 10.0.0.1\n"} {
+				if !strings.Contains(clientConfig, want) {
+					t.Errorf("client config missing %q:\n%s", want, clientConfig)
+				}
+			}
+
+			// No other node lists the client.
+			for _, name := range []string{"peer1", "route1", "route2"} {
+				if strings.Contains(configs[name], client.PublicKey) {
+					t.Errorf("%s config lists the client:\n%s", name, configs[name])
+				}
+			}
+		})
+	}
+}
+
+func TestClientNodeValidation(t *testing.T) {
+	vnm, _ := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("testnet", "laptop", "203.0.113.5", 0, NodeTypeClient); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateNode(client with an address) error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.CreateNode("testnet", "laptop", "", 0, "roamer"); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateNode(unknown type) error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.CreateNode("testnet", "laptop", "203.0.113.5", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+	if _, err := vnm.UpdateNode("testnet", "laptop", "203.0.113.5", 51821, NodeTypeClient); !errors.Is(err, ErrValidation) {
+		t.Errorf("UpdateNode(to client keeping the address) error = %v, want ErrValidation", err)
+	}
+	node, err := vnm.UpdateNode("testnet", "laptop", "", 51821, NodeTypeClient)
+	if err != nil || node.Type != NodeTypeClient {
+		t.Errorf("UpdateNode(to client) = %v, %v; want a client node", node, err)
+	}
+	if _, err := vnm.SetNodeRoutedCIDRs("testnet", "laptop", []string{"192.168.50.0/24"}); err == nil {
+		t.Error("SetNodeRoutedCIDRs() on a client node should fail")
+	}
+}
+
+func TestRouteNodeRoutedCIDRs(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("testnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("testnet", "server1", "192.168.1.1", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	office, err := vnm.CreateRouteNode("testnet", "office", "", 51821, []string{"192.168.50.1/24"})
+	if err != nil {
+		t.Fatalf("CreateRouteNode(office) error = %v", err)
+	}
+	// Stored in canonical (masked) form.
+	if len(office.RoutedCIDRs) != 1 || office.RoutedCIDRs[0] != "192.168.50.0/24" {
+		t.Errorf("RoutedCIDRs = %v, want [192.168.50.0/24]", office.RoutedCIDRs)
+	}
+	laptop, err := vnm.CreateNode("testnet", "laptop", "203.0.113.5", 51822, NodeTypePeer)
+	if err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+
+	// Rejected subnets: malformed, overlapping the network, overlapping each
+	// other, and overlapping another node's routed subnet.
+	bad := [][]string{
+		{"not-a-cidr"},
+		{"10.0.0.0/25"},
+		{"172.16.1.0/24", "172.16.1.128/25"},
+		{"192.168.50.128/25"},
+	}
+	for i, cidrs := range bad {
+		if _, err := vnm.CreateRouteNode("testnet", fmt.Sprintf("bad%d", i), "", 51820, cidrs); err == nil {
+			t.Errorf("CreateRouteNode(%v) should fail", cidrs)
+		}
+	}
+	// A rejected route node must not be created.
+	if nodes, _ := vnm.ListNodes("testnet"); len(nodes) != 2 {
+		t.Errorf("ListNodes() len = %d after rejected creates, want 2", len(nodes))
+	}
+	// Peer nodes cannot route subnets.
+	if _, err := vnm.SetNodeRoutedCIDRs("testnet", "laptop", []string{"172.20.0.0/24"}); err == nil {
+		t.Error("SetNodeRoutedCIDRs() on a peer node should fail")
+	}
+	// A node may re-save its own subnets without tripping the overlap check.
+	if _, err := vnm.SetNodeRoutedCIDRs("testnet", "office", []string{"192.168.50.0/24", "192.168.60.0/24"}); err != nil {
+		t.Fatalf("SetNodeRoutedCIDRs(office) error = %v", err)
+	}
+	// Changing a routing node to peer requires clearing its subnets first.
+	if _, err := vnm.UpdateNode("testnet", "office", "198.51.100.7", 51821, NodeTypePeer); err == nil {
+		t.Error("UpdateNode() to peer with routed CIDRs should fail")
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	configs, _, err := generator.GenerateConfigs("testnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+
+	// The server routes the subnets through the office node and masquerades.
+	serverConfig := configs["server1"]
+	wantAllowed := fmt.Sprintf("AllowedIPs = %s/32, 192.168.50.0/24, 192.168.60.0/24", office.VirtualIP)
+	if !strings.Contains(serverConfig, wantAllowed) {
+		t.Errorf("server config missing %q:\n%s", wantAllowed, serverConfig)
+	}
+	if !strings.Contains(serverConfig, "PostUp = iptables") || !strings.Contains(serverConfig, "MASQUERADE") {
+		t.Errorf("server config missing masquerade rules:\n%s", serverConfig)
+	}
+
+	// Other nodes reach the subnets via their server peer.
+	if !strings.Contains(configs[laptop.Name], "AllowedIPs = 10.0.0.0/24, 192.168.50.0/24, 192.168.60.0/24") {
+		t.Errorf("peer config server AllowedIPs missing routed subnets:\n%s", configs[laptop.Name])
+	}
+	// The routing node itself does not send its own LAN into the tunnel.
+	if strings.Contains(configs[office.Name], "192.168.50.0/24") {
+		t.Errorf("route node config should not route its own subnet:\n%s", configs[office.Name])
+	}
+
+	// Clearing the subnets removes the masquerade rules again.
+	if _, err := vnm.SetNodeRoutedCIDRs("testnet", "office", nil); err != nil {
+		t.Fatalf("SetNodeRoutedCIDRs(nil) error = %v", err)
+	}
+	configs, _, err = generator.GenerateConfigs("testnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if strings.Contains(configs["server1"], "iptables") {
+		t.Errorf("server config should drop masquerade rules without routed CIDRs:\n%s", configs["server1"])
+	}
+}
+
+func TestSetDNS(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("dnsnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("dnsnet", "server1", "192.168.1.1", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("dnsnet", "node1", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	if _, err := vnm.SetDNS("dnsnet", []string{"resolver.local"}); err == nil {
+		t.Error("SetDNS(hostname) should fail")
+	}
+	network, err := vnm.SetDNS("dnsnet", []string{"10.0.0.1", " 1.1.1.1"})
+	if err != nil {
+		t.Fatalf("SetDNS() error = %v", err)
+	}
+	if strings.Join(network.DNS(), ",") != "10.0.0.1,1.1.1.1" {
+		t.Errorf("DNS = %v", network.DNS())
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	configs, _, err := generator.GenerateConfigs("dnsnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if !strings.Contains(configs["node1"], "DNS = 10.0.0.1, 1.1.1.1\n") {
+		t.Errorf("node config lacks DNS:\n%s", configs["node1"])
+	}
+	if strings.Contains(configs["server1"], "DNS =") {
+		t.Errorf("server config has DNS:\n%s", configs["server1"])
+	}
+
+	if network, err = vnm.SetDNS("dnsnet", nil); err != nil || network.DNS() != nil {
+		t.Errorf("SetDNS(nil) = %v, %v; want DNS removed", network.DNS(), err)
+	}
+}
+
+// TestSetNodeFullTunnel mixes full-tunnel and split-tunnel nodes in one
+// network: only the full-tunnel node's server peer allows everything, and
+// every other config still reaches it at its /32.
+func TestSetNodeFullTunnel(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("tunnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.SetDNS("tunnet", []string{"10.0.0.1"}); err != nil {
+		t.Fatalf("SetDNS() error = %v", err)
+	}
+	server, err := vnm.CreateServer("tunnet", "server1", "192.168.1.1", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("tunnet", "full", "203.0.113.1", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(full) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("tunnet", "split", "203.0.113.2", 51822, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(split) error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("tunnet", "office", "", 51823, []string{"192.168.50.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode(office) error = %v", err)
+	}
+
+	if _, err := vnm.SetNodeFullTunnel("tunnet", "missing", true); err == nil {
+		t.Error("SetNodeFullTunnel(missing) should fail")
+	}
+	full, err := vnm.SetNodeFullTunnel("tunnet", "full", true)
+	if err != nil {
+		t.Fatalf("SetNodeFullTunnel() error = %v", err)
+	}
+	if !full.FullTunnel {
+		t.Error("FullTunnel not set")
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	configs, _, err := generator.GenerateConfigs("tunnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+
+	serverPeer := "[Peer]\nPublicKey = " + server.PublicKey + "\nAllowedIPs = "
+	if want := serverPeer + "0.0.0.0/0, ::/0\n"; !strings.Contains(configs["full"], want) {
+		t.Errorf("full config server peer does not allow everything:\n%s", configs["full"])
+	}
+	if !strings.Contains(configs["full"], "DNS = 10.0.0.1\n") {
+		t.Errorf("full config lacks DNS:\n%s", configs["full"])
+	}
+	if want := serverPeer + "10.0.0.0/24, 192.168.50.0/24\n"; !strings.Contains(configs["split"], want) {
+		t.Errorf("split config server peer is not split-tunnel:\n%s", configs["split"])
+	}
+	if strings.Contains(configs["split"], "0.0.0.0/0") || strings.Contains(configs["office"], "0.0.0.0/0") {
+		t.Error("a split-tunnel config allows 0.0.0.0/0")
+	}
+	fullHost := "AllowedIPs = " + full.VirtualIP + "/32\n"
+	for _, name := range []string{"server1", "split", "office"} {
+		if !strings.Contains(configs[name], fullHost) {
+			t.Errorf("%s config does not reach full at its /32:\n%s", name, configs[name])
+		}
+	}
+
+	if _, err := vnm.SetNodeFullTunnel("tunnet", "full", false); err != nil {
+		t.Fatalf("SetNodeFullTunnel(false) error = %v", err)
+	}
+	configs, _, err = generator.GenerateConfigs("tunnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if strings.Contains(configs["full"], "0.0.0.0/0") {
+		t.Errorf("full config still allows everything after turning full tunnel off:\n%s", configs["full"])
+	}
+}
+
+// TestPreferInternalEndpoints checks that only nodes preferring internal
+// endpoints dial them, and that they fall back to the public endpoint of
+// peers without one.
+func TestPreferInternalEndpoints(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("dcnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("dcnet", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for name, address := range map[string]string{"db1": "203.0.113.1", "db2": "203.0.113.2", "web": "203.0.113.3"} {
+		if _, err := vnm.CreateNode("dcnet", name, address, 51821, NodeTypePeer); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+
+	if _, err := vnm.SetServerInternalEndpoint("dcnet", "hub", "not an address!", 0); err == nil {
+		t.Error("SetServerInternalEndpoint() with an invalid address should fail")
+	}
+	if _, err := vnm.SetNodeInternalEndpoint("dcnet", "db1", "10.10.0.21", 70000); err == nil {
+		t.Error("SetNodeInternalEndpoint() with an invalid port should fail")
+	}
+	server, err := vnm.SetServerInternalEndpoint("dcnet", "", "10.10.0.5", 0)
+	if err != nil {
+		t.Fatalf("SetServerInternalEndpoint() error = %v", err)
+	}
+	if got := server.EndpointFor(true); got != "10.10.0.5:51820" {
+		t.Errorf("server internal endpoint = %s, want the public port", got)
+	}
+	if _, err := vnm.SetNodeInternalEndpoint("dcnet", "db1", "10.10.0.21", 51900); err != nil {
+		t.Fatalf("SetNodeInternalEndpoint() error = %v", err)
+	}
+	for _, name := range []string{"db1", "db2"} {
+		node, err := vnm.SetNodePreferInternal("dcnet", name, true)
+		if err != nil || !node.PreferInternal {
+			t.Fatalf("SetNodePreferInternal(%s) = %+v, %v", name, node, err)
+		}
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	configs, _, err := generator.GenerateConfigs("dcnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for name, tc := range map[string]struct{ want, notWant []string }{
+		"db2": {
+			want:    []string{"Endpoint = 10.10.0.5:51820\n", "Endpoint = 10.10.0.21:51900\n", "Endpoint = 203.0.113.3:51821\n"},
+			notWant: []string{"vpn.example.com", "203.0.113.1"},
+		},
+		"db1": {
+			want:    []string{"Endpoint = 10.10.0.5:51820\n", "Endpoint = 203.0.113.2:51821\n"},
+			notWant: []string{"vpn.example.com"},
+		},
+		"web": {
+			want:    []string{"Endpoint = vpn.example.com:51820\n", "Endpoint = 203.0.113.1:51821\n"},
+			notWant: []string{"10.10.0."},
+		},
+		"hub": {
+			want:    []string{"Endpoint = 203.0.113.1:51821\n"},
+			notWant: []string{"10.10.0."},
+		},
+	} {
+		for _, want := range tc.want {
+			if !strings.Contains(configs[name], want) {
+				t.Errorf("%s config lacks %q:\n%s", name, want, configs[name])
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if strings.Contains(configs[name], notWant) {
+				t.Errorf("%s config contains %q:\n%s", name, notWant, configs[name])
+			}
+		}
+	}
+
+	// Clearing the address clears the port too.
+	node, err := vnm.SetNodeInternalEndpoint("dcnet", "db1", "", 51900)
+	if err != nil || node.InternalAddress != "" || node.InternalPort != 0 {
+		t.Errorf("SetNodeInternalEndpoint(\"\") = %+v, %v; want no internal endpoint", node, err)
+	}
+}
+
+func TestGenerateMeshTopology(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("meshnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("meshnet", "server1", "192.168.1.1", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	peer, err := vnm.CreateNode("meshnet", "peer", "203.0.113.1", 51821, NodeTypePeer)
+	if err != nil {
+		t.Fatalf("CreateNode(peer) error = %v", err)
+	}
+	public, err := vnm.CreateNode("meshnet", "public", "203.0.113.2", 51822, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(public) error = %v", err)
+	}
+	office, err := vnm.CreateRouteNode("meshnet", "office", "", 51823, []string{"192.168.50.0/24"})
+	if err != nil {
+		t.Fatalf("CreateRouteNode(office) error = %v", err)
+	}
+	home, err := vnm.CreateNode("meshnet", "home", "", 51824, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(home) error = %v", err)
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	_, hubHash, err := generator.GenerateConfigs("meshnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+
+	if _, err := vnm.SetTopology("meshnet", "ring"); err == nil {
+		t.Error("SetTopology(ring) should fail")
+	}
+	network, err := vnm.SetTopology("meshnet", TopologyMesh)
+	if err != nil {
+		t.Fatalf("SetTopology(mesh) error = %v", err)
+	}
+	if network.EffectiveTopology() != TopologyMesh {
+		t.Errorf("EffectiveTopology() = %s, want mesh", network.EffectiveTopology())
+	}
+
+	configs, meshHash, err := generator.GenerateConfigs("meshnet", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if meshHash == hubHash {
+		t.Error("mesh configs hash the same as hub-spoke configs")
+	}
+
+	// Every node peers with the server plus each node either side can dial;
+	// office and home have no public address, so they only meet via the server.
+	tests := []struct {
+		node   *Node
+		want   []*Node
+		absent []*Node
+	}{
+		{peer, []*Node{public, office, home}, nil},
+		{public, []*Node{peer, office, home}, nil},
+		{office, []*Node{peer, public}, []*Node{home}},
+		{home, []*Node{peer, public}, []*Node{office}},
+	}
+	for _, tt := range tests {
+		config := configs[tt.node.Name]
+		if !hasPeer(config, server.PublicKey) {
+			t.Errorf("%s config has no server peer:\n%s", tt.node.Name, config)
+		}
+		for _, other := range tt.want {
+			if !hasPeer(config, other.PublicKey) {
+				t.Errorf("%s config has no peer %s:\n%s", tt.node.Name, other.Name, config)
+			}
+		}
+		for _, other := range tt.absent {
+			if hasPeer(config, other.PublicKey) {
+				t.Errorf("%s config peers with %s:\n%s", tt.node.Name, other.Name, config)
+			}
+		}
+		if !strings.HasSuffix(config, "\n\n") {
+			t.Errorf("%s config missing trailing blank line", tt.node.Name)
+		}
+	}
+
+	// The office subnet goes to the office peer where there is one, and
+	// through the server where there is not.
+	officePeer := fmt.Sprintf("AllowedIPs = %s/32, 192.168.50.0/24", office.VirtualIP)
+	if !strings.Contains(configs[peer.Name], officePeer) {
+		t.Errorf("peer config missing %q:\n%s", officePeer, configs[peer.Name])
+	}
+	if !strings.Contains(configs[peer.Name], "AllowedIPs = 10.0.0.0/24\n") {
+		t.Errorf("peer config server peer should not route the office subnet:\n%s", configs[peer.Name])
+	}
+	if !strings.Contains(configs[home.Name], "AllowedIPs = 10.0.0.0/24, 192.168.50.0/24\n") {
+		t.Errorf("home config should route the office subnet via the server:\n%s", configs[home.Name])
+	}
+
+	// Route nodes keep every tunnel alive: server, peer, and public.
+	if got := strings.Count(configs[home.Name], "PersistentKeepalive = 25"); got != 3 {
+		t.Errorf("home config PersistentKeepalive count = %d, want 3", got)
+	}
+	// Only peers with a public address get an Endpoint.
+	if strings.Contains(configs[peer.Name], ":51823") || strings.Contains(configs[peer.Name], ":51824") {
+		t.Errorf("peer config has an endpoint for an address-less node:\n%s", configs[peer.Name])
+	}
+
+	// The server still serves every node.
+	for _, node := range []*Node{peer, public, office, home} {
+		if !hasPeer(configs[server.Name], node.PublicKey) {
+			t.Errorf("server config has no peer %s", node.Name)
+		}
+	}
+
+	// Switching back reproduces the hub-spoke configs exactly.
+	if _, err := vnm.SetTopology("meshnet", TopologyHubSpoke); err != nil {
+		t.Fatalf("SetTopology(hub-spoke) error = %v", err)
+	}
+	if _, hash, err := generator.GenerateConfigs("meshnet", storage); err != nil || hash != hubHash {
+		t.Errorf("hub-spoke hash after switching back = %s (err %v), want %s", hash, err, hubHash)
+	}
+}
+
+func TestGenerateConfigs_NoServer(t *testing.T) {
+	vnm, storage := newTestManager(t)
+	generator := NewWireGuardConfigGenerator(storage)
+
+	for _, name := range []string{"hub", "mesh"} {
+		if _, err := vnm.CreateVirtualNetwork(name, "10.0.0.0/24"); err != nil {
+			t.Fatalf("CreateVirtualNetwork(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.SetTopology("mesh", TopologyMesh); err != nil {
+		t.Fatalf("SetTopology(mesh) error = %v", err)
+	}
+	nodes := make(map[string]*Node)
+	for _, n := range []struct {
+		name, address string
+		nodeType      NodeType
+	}{
+		{"peer", "203.0.113.1", NodeTypePeer},
+		{"office", "", NodeTypeRoute},
+		{"home", "", NodeTypeRoute},
+	} {
+		if _, err := vnm.CreateNode("hub", n.name, n.address, 51821, n.nodeType); err != nil {
+			t.Fatalf("CreateNode(hub, %s) error = %v", n.name, err)
+		}
+		node, err := vnm.CreateNode("mesh", n.name, n.address, 51821, n.nodeType)
+		if err != nil {
+			t.Fatalf("CreateNode(mesh, %s) error = %v", n.name, err)
+		}
+		nodes[n.name] = node
+	}
+
+	// Hub-spoke nodes only reach each other through a server.
+	_, _, err := generator.GenerateConfigs("hub", storage)
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), `network "hub" has no server`) ||
+		!strings.Contains(err.Error(), "wedevctl vn hub server add") {
+		t.Errorf("GenerateConfigs(hub) error = %v, want ErrNotFound naming the network with a server add hint", err)
+	}
+	if _, _, err := generator.SaveConfigVersion("hub"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveConfigVersion(hub) error = %v, want ErrNotFound", err)
+	}
+
+	// Mesh nodes peer with each other alone; office and home have no
+	// public address, so they cannot reach each other.
+	configs, hash, err := generator.GenerateConfigs("mesh", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs(mesh) error = %v", err)
+	}
+	if len(configs) != 3 {
+		t.Errorf("GenerateConfigs(mesh) = %d configs, want one per node", len(configs))
+	}
+	tests := []struct {
+		node string
+		want []string
+	}{
+		{"peer", []string{"office", "home"}},
+		{"office", []string{"peer"}},
+		{"home", []string{"peer"}},
+	}
+	for _, tt := range tests {
+		keys := peerKeys(configs[tt.node])
+		want := make([]string, 0, len(tt.want))
+		for _, name := range tt.want {
+			want = append(want, nodes[name].PublicKey)
+		}
+		slices.Sort(keys)
+		slices.Sort(want)
+		if !slices.Equal(keys, want) {
+			t.Errorf("%s config peers with %v, want only %v:\n%s", tt.node, keys, tt.want, configs[tt.node])
+		}
+	}
+
+	version, created, err := generator.SaveConfigVersion("mesh")
+	if err != nil || !created {
+		t.Fatalf("SaveConfigVersion(mesh) = %v, %v; want a new version", created, err)
+	}
+	if version.ContentHash != hash || len(version.Configs) != 3 {
+		t.Errorf("saved version hash %s with %d configs, want %s with 3", version.ContentHash, len(version.Configs), hash)
+	}
+
+	summary, err := vnm.DescribeNode("mesh", "office")
+	if err != nil {
+		t.Fatalf("DescribeNode() error = %v", err)
+	}
+	if summary.Server != "" || !slices.Equal(summary.Peers, []string{"peer"}) || !slices.Equal(summary.PeerOf, []string{"peer"}) {
+		t.Errorf("DescribeNode(office) = server %q, peers %v, peer of %v; want no server and peer on both sides", summary.Server, summary.Peers, summary.PeerOf)
+	}
+}
+
 func TestConfigPeerOrderingByVirtualIP(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -792,6 +1503,64 @@ func TestConfigPeerOrderingByVirtualIP(t *testing.T) {
 	}
 }
 
+// TestServerConfigNATRules checks the exact PostUp and PostDown lines the
+// server gets for two route nodes, in node order, and that the none NAT mode
+// drops them.
+func TestServerConfigNATRules(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("nat", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("nat", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("nat", "office", "", 51821, []string{"192.168.50.0/24", "192.168.60.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode(office) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("nat", "laptop", "203.0.113.5", 51822, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("nat", "lab", "", 51823, []string{"172.16.0.0/16"}); err != nil {
+		t.Fatalf("CreateRouteNode(lab) error = %v", err)
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	configs, _, err := generator.GenerateConfigs("nat", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	want := `PostUp = sysctl -w net.ipv4.ip_forward=1
+PostUp = iptables -A FORWARD -i %i -d 192.168.50.0/24 -j ACCEPT; iptables -A FORWARD -o %i -s 192.168.50.0/24 -j ACCEPT; iptables -t nat -A POSTROUTING -o %i -d 192.168.50.0/24 -j MASQUERADE; iptables -A FORWARD -i %i -d 192.168.60.0/24 -j ACCEPT; iptables -A FORWARD -o %i -s 192.168.60.0/24 -j ACCEPT; iptables -t nat -A POSTROUTING -o %i -d 192.168.60.0/24 -j MASQUERADE
+PostUp = iptables -A FORWARD -i %i -d 172.16.0.0/16 -j ACCEPT; iptables -A FORWARD -o %i -s 172.16.0.0/16 -j ACCEPT; iptables -t nat -A POSTROUTING -o %i -d 172.16.0.0/16 -j MASQUERADE
+PostDown = sysctl -w net.ipv4.ip_forward=0
+PostDown = iptables -D FORWARD -i %i -d 192.168.50.0/24 -j ACCEPT; iptables -D FORWARD -o %i -s 192.168.50.0/24 -j ACCEPT; iptables -t nat -D POSTROUTING -o %i -d 192.168.50.0/24 -j MASQUERADE; iptables -D FORWARD -i %i -d 192.168.60.0/24 -j ACCEPT; iptables -D FORWARD -o %i -s 192.168.60.0/24 -j ACCEPT; iptables -t nat -D POSTROUTING -o %i -d 192.168.60.0/24 -j MASQUERADE
+PostDown = iptables -D FORWARD -i %i -d 172.16.0.0/16 -j ACCEPT; iptables -D FORWARD -o %i -s 172.16.0.0/16 -j ACCEPT; iptables -t nat -D POSTROUTING -o %i -d 172.16.0.0/16 -j MASQUERADE
+`
+	if !strings.Contains(configs["hub"], "ListenPort = 51820\n"+want+"\n") {
+		t.Errorf("server config NAT rules differ, want:\n%s\ngot:\n%s", want, configs["hub"])
+	}
+
+	network, err := vnm.SetNATMode("nat", NATModeNone)
+	if err != nil {
+		t.Fatalf("SetNATMode() error = %v", err)
+	}
+	if network.EffectiveNATMode() != NATModeNone {
+		t.Errorf("EffectiveNATMode() = %s, want none", network.EffectiveNATMode())
+	}
+	configs, _, err = generator.GenerateConfigs("nat", storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if strings.Contains(configs["hub"], "iptables") || !strings.Contains(configs["hub"], "PostUp = sysctl -w net.ipv4.ip_forward=1\nPostDown = sysctl -w net.ipv4.ip_forward=0\n") {
+		t.Errorf("server config in NAT mode none should only enable forwarding:\n%s", configs["hub"])
+	}
+
+	if _, err := vnm.SetNATMode("nat", "snat"); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetNATMode(snat) error = %v, want ErrValidation", err)
+	}
+}
+
 func TestRouteNodeWithMultiplePeerNodes(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -1039,6 +1808,63 @@ func TestConfigHistory(t *testing.T) {
 	}
 }
 
+func TestConfigVersionMessage(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	network, err := vnm.CreateVirtualNetwork("msgnet", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("msgnet", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("msgnet", "n1", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(n1) error = %v", err)
+	}
+
+	generator := NewWireGuardConfigGenerator(storage)
+	v1, _, err := generator.SaveConfigVersionWithMessage("msgnet", "initial rollout")
+	if err != nil {
+		t.Fatalf("SaveConfigVersionWithMessage() error = %v", err)
+	}
+	if want := "initial rollout (servers: +srv; nodes: +n1)"; v1.Message != want {
+		t.Errorf("Message = %q, want %q", v1.Message, want)
+	}
+	if v1.ChangedBy != currentUsername() {
+		t.Errorf("ChangedBy = %q, want %q", v1.ChangedBy, currentUsername())
+	}
+
+	// Without a message the summary alone is recorded.
+	if _, err := vnm.CreateNode("msgnet", "n2", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(n2) error = %v", err)
+	}
+	v2, _, err := generator.SaveConfigVersion("msgnet")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if want := "servers: ~srv; nodes: +n2"; v2.Message != want {
+		t.Errorf("Message = %q, want %q", v2.Message, want)
+	}
+
+	// Versions saved before messages existed still load.
+	legacy := fmt.Sprintf(`{"id":"legacy","network_id":%q,"version":3,"content_hash":"h","configs":{"n1":"x"},"created_at":"2025-01-01T00:00:00Z"}`, network.ID)
+	if err := storage.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(BucketConfigs)).Put([]byte("legacy"), []byte(legacy)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketConfigsByVer)).Put([]byte(network.ID+":"+padVersion(3)), []byte("legacy"))
+	}); err != nil {
+		t.Fatalf("failed to write legacy version: %v", err)
+	}
+	history, err := generator.GetConfigHistory("msgnet")
+	if err != nil || len(history) != 3 {
+		t.Fatalf("GetConfigHistory() = %d versions (err %v), want 3", len(history), err)
+	}
+	if history[2].Message != "" || history[2].ChangedBy != "" {
+		t.Errorf("legacy version = %+v, want no message or user", history[2])
+	}
+}
+
 func TestGetSpecificConfigVersion(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -1123,20 +1949,29 @@ func TestContentHashConsistency(t *testing.T) {
 	}
 
 	generator := NewWireGuardConfigGenerator(storage)
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	generator.now = func() time.Time { return clock }
 
-	// Generate configs twice without changes
-	_, hash1, err := generator.GenerateConfigs("testnet", storage)
+	// Generate configs twice without changes, at different times
+	configs1, hash1, err := generator.GenerateConfigs("testnet", storage)
 	if err != nil {
 		t.Fatalf("GenerateConfigs() error = %v", err)
 	}
-	_, hash2, err := generator.GenerateConfigs("testnet", storage)
+	clock = clock.Add(time.Hour)
+	configs2, hash2, err := generator.GenerateConfigs("testnet", storage)
 	if err != nil {
 		t.Fatalf("GenerateConfigs() error = %v", err)
 	}
 
+	if configs1["server1"] == configs2["server1"] {
+		t.Errorf("configs generated an hour apart should differ in their header time")
+	}
 	if hash1 != hash2 {
 		t.Errorf("Content hash should be consistent: %s != %s", hash1, hash2)
 	}
+	if diffs := DiffConfigs(configs1, configs2, "", ""); len(diffs) != 0 {
+		t.Errorf("DiffConfigs() across generation times = %v, want no changes", diffs)
+	}
 
 	// Add node and regenerate
 	if _, err := vnm.CreateNode("testnet", "node1", "192.168.1.2", 51821, NodeTypePeer); err != nil {
@@ -1151,3 +1986,248 @@ func TestContentHashConsistency(t *testing.T) {
 		t.Errorf("Content hash should change when config changes")
 	}
 }
+
+// TestParallelGeneration checks that the worker pool generates the same
+// configs as a single worker, and that the streamed hash equals the SHA-256 of
+// the "name:config" concatenation the hash has always been.
+func TestParallelGeneration(t *testing.T) {
+	network, servers, nodes := syntheticNetwork(300)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sequential := &WireGuardConfigGenerator{now: func() time.Time { return clock }, workers: 1}
+	parallel := &WireGuardConfigGenerator{now: func() time.Time { return clock }, workers: 8}
+
+	want, err := sequential.generateAll(context.Background(), network, servers, nodes)
+	if err != nil {
+		t.Fatalf("generateAll() with one worker error = %v", err)
+	}
+	got, err := parallel.generateAll(context.Background(), network, servers, nodes)
+	if err != nil {
+		t.Fatalf("generateAll() with eight workers error = %v", err)
+	}
+	if len(got) != len(nodes)+1 {
+		t.Fatalf("generateAll() = %d configs, want %d", len(got), len(nodes)+1)
+	}
+	for name, config := range want {
+		if got[name] != config {
+			t.Errorf("%s config differs between one and eight workers", name)
+		}
+	}
+
+	names := make([]string, 0, len(got))
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var combined strings.Builder
+	for _, name := range names {
+		combined.WriteString(name + ":" + normalizeConfig(got[name]))
+	}
+	sum := sha256.Sum256([]byte(combined.String()))
+	if hash := parallel.calculateConfigHash(got); hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("calculateConfigHash() = %s, want the hash of the concatenation %x", hash, sum)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parallel.generateAll(ctx, network, servers, nodes); !errors.Is(err, context.Canceled) {
+		t.Errorf("generateAll() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+// TestConfigHashGolden pins the content hash of a fixed network, recorded
+// with the sequential generator that built one concatenated string, so the
+// worker pool and streamed hash are known to reproduce it.
+func TestConfigHashGolden(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "dev"
+
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("h", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("h", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for i := range 30 {
+		if i%4 == 0 {
+			_, err = vnm.CreateRouteNode("h", fmt.Sprintf("r%d", i), "", 51820, []string{fmt.Sprintf("192.168.%d.0/24", i)})
+		} else {
+			_, err = vnm.CreateNode("h", fmt.Sprintf("p%d", i), fmt.Sprintf("203.0.113.%d", i), 51820, NodeTypePeer)
+		}
+		if err != nil {
+			t.Fatalf("creating node %d error = %v", i, err)
+		}
+	}
+	if _, err := vnm.SetNodeInternalEndpoint("h", "p1", "192.168.0.1", 0); err != nil {
+		t.Fatalf("SetNodeInternalEndpoint() error = %v", err)
+	}
+	for _, name := range []string{"p2", "r4"} {
+		if _, err := vnm.SetNodePreferInternal("h", name, true); err != nil {
+			t.Fatalf("SetNodePreferInternal(%s) error = %v", name, err)
+		}
+	}
+
+	// Replace the generated keys so the configs are the same on every run.
+	if err := sm.UpdateServerKeys(server.ID, "priv-hub", "pub-hub"); err != nil {
+		t.Fatalf("UpdateServerKeys() error = %v", err)
+	}
+	nodes, err := sm.ListNodesByNetworkID(server.NetworkID)
+	if err != nil {
+		t.Fatalf("ListNodesByNetworkID() error = %v", err)
+	}
+	for _, node := range nodes {
+		if err := sm.UpdateNodeKeys(node.ID, "priv-"+node.Name, "pub-"+node.Name); err != nil {
+			t.Fatalf("UpdateNodeKeys(%s) error = %v", node.Name, err)
+		}
+	}
+
+	generator := NewWireGuardConfigGenerator(sm)
+	generator.now = func() time.Time { return time.Unix(0, 0) }
+	configs, hash, err := generator.GenerateConfigs("h", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	const want = "4ece5b3eb59e7f9e070e0275e70b3968359eb0c436e06552040e22fd58e34c3d"
+	if len(configs) != 31 || hash != want {
+		t.Errorf("GenerateConfigs() = %d configs with hash %s, want 31 with %s", len(configs), hash, want)
+	}
+}
+
+// TestConfigComments checks the header and peer name comments of generated
+// configs, that saving again later dedupes to the same version, and that
+// StripComments leaves the bare config.
+func TestConfigComments(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("office", "laptop", "203.0.113.5", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(laptop) error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("office", "router", "", 51820, []string{"192.168.10.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode() error = %v", err)
+	}
+
+	generator := NewWireGuardConfigGenerator(sm)
+	clock := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	generator.now = func() time.Time { return clock }
+	configs, _, err := generator.GenerateConfigs("office", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+
+	header := "# network: office, generated by wedevctl " + version.Version + " at 2026-03-01T09:30:00Z\n[Interface]\n"
+	for name, config := range configs {
+		if !strings.HasPrefix(config, header) {
+			t.Errorf("%s config does not start with %q:\n%s", name, header, config)
+		}
+	}
+	for name, want := range map[string][]string{
+		"hub":    {"\n# laptop (10.0.0.2)\n[Peer]\n", "\n# router (10.0.0.3)\n[Peer]\n"},
+		"laptop": {"\n# hub (10.0.0.1)\n[Peer]\n"},
+		"router": {"\n# hub (10.0.0.1)\n[Peer]\n", "\n# laptop (10.0.0.2)\n[Peer]\n"},
+	} {
+		for _, comment := range want {
+			if !strings.Contains(configs[name], comment) {
+				t.Errorf("%s config missing peer comment %q:\n%s", name, comment, configs[name])
+			}
+		}
+		if got, want := strings.Count(configs[name], "\n# "), strings.Count(configs[name], "[Peer]"); got != want {
+			t.Errorf("%s config has %d peer comments for %d peers", name, got, want)
+		}
+	}
+
+	first, created, err := generator.SaveConfigVersion("office")
+	if err != nil || !created {
+		t.Fatalf("SaveConfigVersion() = %v, %v, %v; want a new version", first, created, err)
+	}
+	clock = clock.Add(24 * time.Hour)
+	again, created, err := generator.SaveConfigVersion("office")
+	if err != nil || created || again.Version != first.Version {
+		t.Errorf("SaveConfigVersion() a day later = %v, %v, %v; want version %d unchanged", again, created, err, first.Version)
+	}
+
+	stripped := StripComments(configs["laptop"])
+	if strings.Contains(stripped, "#") || !strings.HasPrefix(stripped, "[Interface]\n") || !strings.HasSuffix(stripped, "\n\n") {
+		t.Errorf("StripComments() = %q, want the config without comments", stripped)
+	}
+	if strings.Count(stripped, "\n") != strings.Count(configs["laptop"], "\n")-2 {
+		t.Errorf("StripComments() removed more than the two comment lines:\n%s", stripped)
+	}
+}
+
+func TestNodeExpiry(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("ttl", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("ttl", "hub", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"contractor", "staff"} {
+		if _, err := vnm.CreateNode("ttl", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+
+	start := time.Date(2024, 7, 18, 9, 0, 0, 0, time.UTC)
+	expiresAt := start.Add(14 * 24 * time.Hour)
+	contractor, err := vnm.SetNodeExpiry("ttl", "contractor", &expiresAt)
+	if err != nil {
+		t.Fatalf("SetNodeExpiry() error = %v", err)
+	}
+	if contractor.ExpiresAt == nil || !contractor.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("ExpiresAt = %v, want %v", contractor.ExpiresAt, expiresAt)
+	}
+
+	clock := start
+	vnm.now = func() time.Time { return clock }
+	generator := NewWireGuardConfigGenerator(sm)
+	generator.now = func() time.Time { return clock }
+
+	configs, _, err := generator.GenerateConfigs("ttl", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if _, ok := configs["contractor"]; !ok || !hasPeer(configs["hub"], contractor.PublicKey) {
+		t.Errorf("unexpired contractor missing from configs: %v", configs)
+	}
+	if expired, err := vnm.ListExpiredNodes("ttl"); err != nil || len(expired) != 0 {
+		t.Errorf("ListExpiredNodes() before expiry = %v, %v", expired, err)
+	}
+
+	clock = expiresAt
+	configs, _, err = generator.GenerateConfigs("ttl", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if _, ok := configs["contractor"]; ok {
+		t.Error("expired contractor still gets a config")
+	}
+	if hasPeer(configs["hub"], contractor.PublicKey) {
+		t.Errorf("server still peers with the expired contractor:\n%s", configs["hub"])
+	}
+	if !hasPeer(configs["staff"], server.PublicKey) {
+		t.Errorf("staff lost its server peer:\n%s", configs["staff"])
+	}
+	if _, err := generator.GenerateConfig("ttl", "contractor"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("GenerateConfig(contractor) error = %v, want expired", err)
+	}
+
+	purged, err := vnm.PurgeExpiredNodes("ttl")
+	if err != nil || len(purged) != 1 || purged[0].Name != "contractor" {
+		t.Fatalf("PurgeExpiredNodes() = %v, %v, want [contractor]", purged, err)
+	}
+	if _, err := vnm.GetNode("ttl", "contractor"); !errors.Is(err, ErrNotFound) {
+		t.Error("purged node still exists")
+	}
+	report, err := vnm.AuditIPPool("ttl")
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() after purge = %+v, %v, want the IP released cleanly", report, err)
+	}
+}
@@ -0,0 +1,1519 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wedevctl/util"
+)
+
+// MemoryStorage is a Storage that keeps everything in memory, for fast unit
+// tests and for dry runs that make changes to a throwaway copy of a
+// network. It behaves as StorageManager does, down to the error kinds and
+// messages, revisions and history; every write is all-or-nothing, as a
+// bbolt transaction is. Its contents are lost when it is dropped.
+type MemoryStorage struct {
+	mu           sync.RWMutex
+	state        *memState
+	logger       *slog.Logger
+	historyLimit int
+
+	poolWriteFault func() error // see StorageManager.poolWriteFault
+}
+
+// memState is the contents of a MemoryStorage. Records are never changed in
+// place: a write stores a fresh copy, so a shallow clone of the maps is a
+// snapshot a failed write can be rolled back to.
+type memState struct {
+	networks    map[string]*VirtualNetwork
+	servers     map[string]*Server
+	nodes       map[string]*Node
+	configs     map[string][]*ConfigVersion // by network ID, ordered by version
+	pools       map[string]*util.IPPoolState
+	deployments map[string]*Deployment
+	revisions   map[string]uint64
+	history     map[string][]EntityRevision
+	tags        map[string]int    // by tagKey
+	guests      map[string]*Guest // by guestKey
+}
+
+// NewMemoryStorage creates an empty in-memory storage with default options.
+func NewMemoryStorage() *MemoryStorage {
+	return NewMemoryStorageWithOptions(StorageOptions{})
+}
+
+// NewMemoryStorageWithOptions creates an empty in-memory storage. Of opts,
+// only Logger and HistoryLimit apply.
+func NewMemoryStorageWithOptions(opts StorageOptions) *MemoryStorage {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.HistoryLimit <= 0 {
+		opts.HistoryLimit = DefaultHistoryLimit
+	}
+	return &MemoryStorage{
+		state: &memState{
+			networks:    make(map[string]*VirtualNetwork),
+			servers:     make(map[string]*Server),
+			nodes:       make(map[string]*Node),
+			configs:     make(map[string][]*ConfigVersion),
+			pools:       make(map[string]*util.IPPoolState),
+			deployments: make(map[string]*Deployment),
+			revisions:   make(map[string]uint64),
+			history:     make(map[string][]EntityRevision),
+			tags:        make(map[string]int),
+			guests:      make(map[string]*Guest),
+		},
+		logger:       opts.Logger,
+		historyLimit: opts.HistoryLimit,
+	}
+}
+
+// Logger returns the logger the storage was created with.
+func (ms *MemoryStorage) Logger() *slog.Logger {
+	return ms.logger
+}
+
+// Close does nothing; the storage stays usable.
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// update runs fn on a snapshot of the contents and keeps the snapshot only
+// when fn succeeds.
+func (ms *MemoryStorage) update(ctx context.Context, fn func(*memState) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	next := ms.state.clone()
+	if err := fn(next); err != nil {
+		return err
+	}
+	ms.state = next
+	return nil
+}
+
+// view runs fn on the contents, which it must not change.
+func (ms *MemoryStorage) view(ctx context.Context, fn func(*memState) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return fn(ms.state)
+}
+
+func (s *memState) clone() *memState {
+	return &memState{
+		networks:    maps.Clone(s.networks),
+		servers:     maps.Clone(s.servers),
+		nodes:       maps.Clone(s.nodes),
+		configs:     maps.Clone(s.configs),
+		pools:       maps.Clone(s.pools),
+		deployments: maps.Clone(s.deployments),
+		revisions:   maps.Clone(s.revisions),
+		history:     maps.Clone(s.history),
+		tags:        maps.Clone(s.tags),
+		guests:      maps.Clone(s.guests),
+	}
+}
+
+// copyRecord returns a deep copy of a record, as decoding it afresh from
+// bbolt would.
+func copyRecord[T any](record *T) *T {
+	out := new(T)
+	//nolint:errcheck // Records are plain data and always round-trip
+	data, _ := json.Marshal(record)
+	//nolint:errcheck // See above
+	_ = json.Unmarshal(data, out)
+	return out
+}
+
+// ========== VirtualNetwork Operations ==========
+
+// CreateNetwork creates a new virtual network.
+func (ms *MemoryStorage) CreateNetwork(name, cidr string) (*VirtualNetwork, error) {
+	return ms.CreateNetworkCtx(context.Background(), name, cidr)
+}
+
+// CreateNetworkCtx is CreateNetwork with a context.
+func (ms *MemoryStorage) CreateNetworkCtx(ctx context.Context, name, cidr string) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.update(ctx, func(s *memState) error {
+		if s.networkByName(name) != nil {
+			return kindErrorf(ErrAlreadyExists, "network name %q already exists", name)
+		}
+		network = &VirtualNetwork{ID: uuid.New().String(), Name: name, CIDR: cidr, CreatedAt: time.Now()}
+		s.networks[network.ID] = copyRecord(network)
+		s.bumpRevision(network.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// GetNetworkByName retrieves a network by name.
+func (ms *MemoryStorage) GetNetworkByName(name string) (*VirtualNetwork, error) {
+	return ms.GetNetworkByNameCtx(context.Background(), name)
+}
+
+// GetNetworkByNameCtx is GetNetworkByName with a context.
+func (ms *MemoryStorage) GetNetworkByNameCtx(ctx context.Context, name string) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.view(ctx, func(s *memState) error {
+		found := s.networkByName(name)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", name)
+		}
+		network = copyRecord(found)
+		return nil
+	})
+	return network, err
+}
+
+// GetNetworkByID retrieves a network by ID.
+func (ms *MemoryStorage) GetNetworkByID(id string) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.view(context.Background(), func(s *memState) error {
+		found := s.networks[id]
+		if found == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", id)
+		}
+		network = copyRecord(found)
+		return nil
+	})
+	return network, err
+}
+
+// ListNetworks lists all networks, sorted by name as StorageManager does.
+func (ms *MemoryStorage) ListNetworks() ([]*VirtualNetwork, error) {
+	return ms.ListNetworksCtx(context.Background())
+}
+
+// ListNetworksCtx is ListNetworks with a context.
+func (ms *MemoryStorage) ListNetworksCtx(ctx context.Context) ([]*VirtualNetwork, error) {
+	var networks []*VirtualNetwork
+	err := ms.view(ctx, func(s *memState) error {
+		for _, network := range s.networks {
+			networks = append(networks, copyRecord(network))
+		}
+		return nil
+	})
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, err
+}
+
+// RenameNetwork renames a network.
+func (ms *MemoryStorage) RenameNetwork(oldName, newName string) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.update(context.Background(), func(s *memState) error {
+		found := s.networkByName(oldName)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", oldName)
+		}
+		if s.networkByName(newName) != nil {
+			return kindErrorf(ErrAlreadyExists, "network name %q already exists", newName)
+		}
+		network = copyRecord(found)
+		network.Name = newName
+		s.networks[network.ID] = copyRecord(network)
+		s.bumpRevision(network.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// updateNetwork stores a copy of network id changed by change.
+func (ms *MemoryStorage) updateNetwork(id string, change func(*VirtualNetwork)) error {
+	_, err := ms.UpdateNetwork(id, func(n *VirtualNetwork) error {
+		change(n)
+		return nil
+	})
+	return err
+}
+
+// UpdateNetwork applies change to a network in one update; an error from
+// change leaves the network as it was.
+func (ms *MemoryStorage) UpdateNetwork(id string, change func(*VirtualNetwork) error) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.update(context.Background(), func(s *memState) error {
+		found := s.networks[id]
+		if found == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+		network = copyRecord(found)
+		if err := change(network); err != nil {
+			return err
+		}
+		s.networks[id] = copyRecord(network)
+		s.bumpRevision(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// UpdateNetworkLabels replaces a network's labels.
+func (ms *MemoryStorage) UpdateNetworkLabels(id string, labels map[string]string) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.Labels = maps.Clone(labels) })
+}
+
+// UpdateNetworkSettings replaces a network's settings.
+func (ms *MemoryStorage) UpdateNetworkSettings(id string, settings map[string]string) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.Settings = maps.Clone(settings) })
+}
+
+// UpdateNetworkDefaultPort sets the default node port of a network.
+func (ms *MemoryStorage) UpdateNetworkDefaultPort(id string, port int) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.DefaultPort = port })
+}
+
+// UpdateNetworkMaxNodes sets the node limit of a network.
+func (ms *MemoryStorage) UpdateNetworkMaxNodes(id string, maxNodes int) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.MaxNodes = maxNodes })
+}
+
+// UpdateNetworkPoolWarnPercent sets the IP pool utilization a network warns
+// about.
+func (ms *MemoryStorage) UpdateNetworkPoolWarnPercent(id string, percent int) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.PoolWarnPercent = percent })
+}
+
+// UpdateNetworkNATMode sets the NAT mode of a network.
+func (ms *MemoryStorage) UpdateNetworkNATMode(id string, mode NATMode) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) { n.NATMode = mode })
+}
+
+// UpdateNetworkFreeze freezes a network's config, or unfreezes it when
+// freeze is nil.
+func (ms *MemoryStorage) UpdateNetworkFreeze(id string, freeze *ConfigFreeze) error {
+	return ms.updateNetwork(id, func(n *VirtualNetwork) {
+		n.Freeze = nil
+		if freeze != nil {
+			n.Freeze = copyRecord(freeze)
+		}
+	})
+}
+
+// ResizeNetwork updates a network's CIDR and its IP pool state together.
+func (ms *MemoryStorage) ResizeNetwork(id, cidr string, state *util.IPPoolState) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := ms.update(context.Background(), func(s *memState) error {
+		found := s.networks[id]
+		if found == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+		network = copyRecord(found)
+		network.CIDR = cidr
+		s.networks[id] = copyRecord(network)
+		if err := ms.putIPPoolState(s, id, state); err != nil {
+			return err
+		}
+		s.bumpRevision(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// DeleteNetwork deletes a network and all its associated resources.
+func (ms *MemoryStorage) DeleteNetwork(name string) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		network := s.networkByName(name)
+		if network == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", name)
+		}
+		for id, server := range s.servers {
+			if server.NetworkID == network.ID {
+				s.deleteEntity(id)
+				delete(s.servers, id)
+			}
+		}
+		for id, node := range s.nodes {
+			if node.NetworkID == network.ID {
+				s.deleteEntity(id)
+				delete(s.nodes, id)
+			}
+		}
+		delete(s.configs, network.ID)
+		for key := range s.tags {
+			if strings.HasPrefix(key, network.ID+":") {
+				delete(s.tags, key)
+			}
+		}
+		for key := range s.guests {
+			if strings.HasPrefix(key, network.ID+":") {
+				delete(s.guests, key)
+			}
+		}
+		delete(s.pools, network.ID)
+		delete(s.revisions, network.ID)
+		delete(s.networks, network.ID)
+		return nil
+	})
+}
+
+// networkByName returns the stored network named name, or nil.
+func (s *memState) networkByName(name string) *VirtualNetwork {
+	for _, network := range s.networks {
+		if network.Name == name {
+			return network
+		}
+	}
+	return nil
+}
+
+// ========== Server Operations ==========
+
+// CreateServer creates a new server.
+func (ms *MemoryStorage) CreateServer(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error) {
+	var server *Server
+	err := ms.update(context.Background(), func(s *memState) error {
+		var err error
+		server, err = s.createServer(networkID, name, publicAddress, port, virtualIP, privateKey, publicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// CreateServerWithPoolState creates a new server and saves the network's IP
+// pool state together.
+func (ms *MemoryStorage) CreateServerWithPoolState(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string, state *util.IPPoolState) (*Server, error) {
+	var server *Server
+	err := ms.update(context.Background(), func(s *memState) error {
+		var err error
+		if server, err = s.createServer(networkID, name, publicAddress, port, virtualIP, privateKey, publicKey); err != nil {
+			return err
+		}
+		return ms.putIPPoolState(s, networkID, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func (s *memState) createServer(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error) {
+	if s.networks[networkID] == nil {
+		return nil, kindErrorf(ErrNotFound, "network %q not found", networkID)
+	}
+	if s.serverByName(networkID, name) != nil {
+		return nil, kindErrorf(ErrAlreadyExists, "server name %q already exists", name)
+	}
+	if err := s.checkPublicKeyUnique(networkID, "", publicKey); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		ID:            uuid.New().String(),
+		NetworkID:     networkID,
+		Name:          name,
+		PublicAddress: publicAddress,
+		Port:          port,
+		VirtualIP:     virtualIP,
+		PrivateKey:    privateKey,
+		PublicKey:     publicKey,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	s.servers[server.ID] = copyRecord(server)
+	s.bumpRevision(networkID)
+	return server, nil
+}
+
+// GetServerByName retrieves a server by name within a network.
+func (ms *MemoryStorage) GetServerByName(networkID, name string) (*Server, error) {
+	var server *Server
+	err := ms.view(context.Background(), func(s *memState) error {
+		found := s.serverByName(networkID, name)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "server %q not found", name)
+		}
+		server = copyRecord(found)
+		return nil
+	})
+	return server, err
+}
+
+// GetServerByNetworkID retrieves the network's first server, or an
+// ErrNotFound error when it has none.
+func (ms *MemoryStorage) GetServerByNetworkID(networkID string) (*Server, error) {
+	servers, err := ms.ListServersByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, kindErrorf(ErrNotFound, "no server found for network %q", networkID)
+	}
+	return servers[0], nil
+}
+
+// ListServersByNetworkID lists the servers of a network, oldest first.
+func (ms *MemoryStorage) ListServersByNetworkID(networkID string) ([]*Server, error) {
+	return ms.ListServersByNetworkIDCtx(context.Background(), networkID)
+}
+
+// ListServersByNetworkIDCtx is ListServersByNetworkID with a context.
+func (ms *MemoryStorage) ListServersByNetworkIDCtx(ctx context.Context, networkID string) ([]*Server, error) {
+	var servers []*Server
+	err := ms.view(ctx, func(s *memState) error {
+		servers = s.listServers(networkID)
+		return nil
+	})
+	return servers, err
+}
+
+// listServers returns copies of the servers of a network, ordered as
+// listServers orders them.
+func (s *memState) listServers(networkID string) []*Server {
+	var servers []*Server
+	for _, id := range slices.Sorted(maps.Keys(s.servers)) {
+		if server := s.servers[id]; server.NetworkID == networkID {
+			servers = append(servers, copyRecord(server))
+		}
+	}
+	sort.SliceStable(servers, func(i, j int) bool {
+		if !servers[i].CreatedAt.Equal(servers[j].CreatedAt) {
+			return servers[i].CreatedAt.Before(servers[j].CreatedAt)
+		}
+		return servers[i].Name < servers[j].Name
+	})
+	return servers
+}
+
+// serverByName returns the stored server of a network named name, or nil.
+func (s *memState) serverByName(networkID, name string) *Server {
+	for _, server := range s.servers {
+		if server.NetworkID == networkID && server.Name == name {
+			return server
+		}
+	}
+	return nil
+}
+
+// updateServer stores a copy of server id changed by change, recording the
+// change in its history under action unless action is "".
+func (ms *MemoryStorage) updateServer(id, action string, change func(*memState, *Server) error) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		found := s.servers[id]
+		if found == nil {
+			return kindErrorf(ErrNotFound, "server not found")
+		}
+		server := copyRecord(found)
+		if err := change(s, server); err != nil {
+			return err
+		}
+		server.Revision++
+		server.UpdatedAt = time.Now()
+		server.trackAddress(found.PublicAddress, server.PublicAddress, server.UpdatedAt)
+		s.servers[id] = server
+		if action != "" {
+			if err := ms.recordHistory(s, id, action, found, server); err != nil {
+				return err
+			}
+		}
+		s.bumpRevision(server.NetworkID)
+		return nil
+	})
+}
+
+// UpdateServer updates server information.
+func (ms *MemoryStorage) UpdateServer(id, publicAddress string, port int) error {
+	return ms.updateServer(id, HistoryUpdate, func(_ *memState, server *Server) error {
+		server.PublicAddress = publicAddress
+		server.Port = port
+		return nil
+	})
+}
+
+// ReplaceServer writes the editable fields of server over the stored record
+// unless it changed since revision.
+func (ms *MemoryStorage) ReplaceServer(server *Server, revision int) error {
+	return ms.updateServer(server.ID, HistoryUpdate, func(_ *memState, stored *Server) error {
+		if revision != AnyRevision && stored.Revision != revision {
+			return kindErrorf(ErrConflict, "server %q was modified by another process, re-run your edit", stored.Name)
+		}
+		copyServerEdit(stored, server)
+		return nil
+	})
+}
+
+// UpdateServerInternalEndpoint sets a server's internal endpoint; an empty
+// address removes it.
+func (ms *MemoryStorage) UpdateServerInternalEndpoint(id, address string, port int) error {
+	return ms.updateServer(id, "", func(_ *memState, server *Server) error {
+		server.InternalAddress = address
+		server.InternalPort = port
+		return nil
+	})
+}
+
+// UpdateServerKeys replaces a server's key pair.
+func (ms *MemoryStorage) UpdateServerKeys(id, privateKey, publicKey string) error {
+	return ms.updateServer(id, HistoryRekey, func(s *memState, server *Server) error {
+		if err := s.checkPublicKeyUnique(server.NetworkID, id, publicKey); err != nil {
+			return err
+		}
+		server.PrivateKey = privateKey
+		server.PublicKey = publicKey
+		return nil
+	})
+}
+
+// RenameServer renames a server of a network.
+func (ms *MemoryStorage) RenameServer(networkID, oldName, newName string) (*Server, error) {
+	var id string
+	err := ms.view(context.Background(), func(s *memState) error {
+		found := s.serverByName(networkID, oldName)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "server %q not found", oldName)
+		}
+		id = found.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var server *Server
+	err = ms.updateServer(id, HistoryRename, func(s *memState, renamed *Server) error {
+		if renamed.Name != oldName {
+			return kindErrorf(ErrNotFound, "server %q not found", oldName)
+		}
+		if s.serverByName(networkID, newName) != nil {
+			return kindErrorf(ErrAlreadyExists, "server name %q already exists", newName)
+		}
+		renamed.Name = newName
+		server = renamed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyRecord(server), nil
+}
+
+// DeleteServer deletes a server. Nodes assigned to it fall back to the
+// network's first remaining server.
+func (ms *MemoryStorage) DeleteServer(networkID, name string) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		return s.deleteServer(networkID, name)
+	})
+}
+
+// DeleteServerWithPoolState deletes a server and saves the network's IP pool
+// state together.
+func (ms *MemoryStorage) DeleteServerWithPoolState(networkID, name string, state *util.IPPoolState) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		if err := s.deleteServer(networkID, name); err != nil {
+			return err
+		}
+		return ms.putIPPoolState(s, networkID, state)
+	})
+}
+
+// DeleteServerAndNodesWithPoolState deletes a server together with nodes of
+// its network, and saves the network's IP pool state, all together.
+func (ms *MemoryStorage) DeleteServerAndNodesWithPoolState(networkID, name string, nodeNames []string, state *util.IPPoolState) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		for _, nodeName := range nodeNames {
+			if err := s.deleteNode(networkID, nodeName); err != nil {
+				return err
+			}
+		}
+		if err := s.deleteServer(networkID, name); err != nil {
+			return err
+		}
+		return ms.putIPPoolState(s, networkID, state)
+	})
+}
+
+// deleteServer removes a server and clears the assignment of nodes that
+// used it.
+func (s *memState) deleteServer(networkID, name string) error {
+	server := s.serverByName(networkID, name)
+	if server == nil {
+		return kindErrorf(ErrNotFound, "server %q not found", name)
+	}
+	delete(s.servers, server.ID)
+	s.deleteEntity(server.ID)
+
+	for id, node := range s.nodes {
+		if node.NetworkID != networkID || node.ServerID != server.ID {
+			continue
+		}
+		unassigned := copyRecord(node)
+		unassigned.ServerID = ""
+		unassigned.Revision++
+		unassigned.UpdatedAt = time.Now()
+		s.nodes[id] = unassigned
+	}
+	s.bumpRevision(networkID)
+	return nil
+}
+
+// ========== Node Operations ==========
+
+// CreateNode creates a new node.
+func (ms *MemoryStorage) CreateNode(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error) {
+	var node *Node
+	err := ms.update(context.Background(), func(s *memState) error {
+		var err error
+		node, err = s.createNode(networkID, name, publicAddress, port, virtualIP, nodeType, privateKey, publicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// CreateNodeWithPoolState creates a new node and saves the network's IP pool
+// state together.
+func (ms *MemoryStorage) CreateNodeWithPoolState(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string, state *util.IPPoolState) (*Node, error) {
+	var node *Node
+	err := ms.update(context.Background(), func(s *memState) error {
+		var err error
+		if node, err = s.createNode(networkID, name, publicAddress, port, virtualIP, nodeType, privateKey, publicKey); err != nil {
+			return err
+		}
+		return ms.putIPPoolState(s, networkID, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (s *memState) createNode(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error) {
+	if s.networks[networkID] == nil {
+		return nil, kindErrorf(ErrNotFound, "network %q not found", networkID)
+	}
+	if s.nodeByName(networkID, name) != nil {
+		return nil, kindErrorf(ErrAlreadyExists, "node name %q already exists", name)
+	}
+	if err := s.checkPublicKeyUnique(networkID, "", publicKey); err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		ID:            uuid.New().String(),
+		NetworkID:     networkID,
+		Name:          name,
+		PublicAddress: publicAddress,
+		Port:          port,
+		VirtualIP:     virtualIP,
+		Type:          nodeType,
+		PrivateKey:    privateKey,
+		PublicKey:     publicKey,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	s.nodes[node.ID] = copyRecord(node)
+	s.bumpRevision(networkID)
+	return node, nil
+}
+
+// GetNodeByName retrieves a node by name within a specific network.
+func (ms *MemoryStorage) GetNodeByName(networkID, name string) (*Node, error) {
+	var node *Node
+	err := ms.view(context.Background(), func(s *memState) error {
+		found := s.nodeByName(networkID, name)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "node %q not found", name)
+		}
+		node = copyRecord(found)
+		return nil
+	})
+	return node, err
+}
+
+// ListNodesByNetworkID lists all nodes in a network, sorted by name as
+// StorageManager does.
+func (ms *MemoryStorage) ListNodesByNetworkID(networkID string) ([]*Node, error) {
+	return ms.ListNodesByNetworkIDCtx(context.Background(), networkID)
+}
+
+// ListNodesByNetworkIDCtx is ListNodesByNetworkID with a context.
+func (ms *MemoryStorage) ListNodesByNetworkIDCtx(ctx context.Context, networkID string) ([]*Node, error) {
+	var nodes []*Node
+	err := ms.view(ctx, func(s *memState) error {
+		nodes = s.listNodes(networkID)
+		return nil
+	})
+	return nodes, err
+}
+
+// CountNodesByNetworkCtx returns the number of nodes of every network that
+// has any, by network ID.
+func (ms *MemoryStorage) CountNodesByNetworkCtx(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := ms.view(ctx, func(s *memState) error {
+		for _, node := range s.nodes {
+			counts[node.NetworkID]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// listNodes returns copies of the nodes of a network, sorted by name.
+func (s *memState) listNodes(networkID string) []*Node {
+	var nodes []*Node
+	for _, node := range s.nodes {
+		if node.NetworkID == networkID {
+			nodes = append(nodes, copyRecord(node))
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// nodeByName returns the stored node of a network named name, or nil.
+func (s *memState) nodeByName(networkID, name string) *Node {
+	for _, node := range s.nodes {
+		if node.NetworkID == networkID && node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// updateNode stores a copy of node id changed by change, recording the
+// change in its history under action unless action is "".
+func (ms *MemoryStorage) updateNode(id, action string, change func(*memState, *Node) error) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		found := s.nodes[id]
+		if found == nil {
+			return kindErrorf(ErrNotFound, "node not found")
+		}
+		node := copyRecord(found)
+		if err := change(s, node); err != nil {
+			return err
+		}
+		node.Revision++
+		node.UpdatedAt = time.Now()
+		node.trackAddress(found.PublicAddress, node.PublicAddress, node.UpdatedAt)
+		s.nodes[id] = node
+		if action != "" {
+			if err := ms.recordHistory(s, id, action, found, node); err != nil {
+				return err
+			}
+		}
+		s.bumpRevision(node.NetworkID)
+		return nil
+	})
+}
+
+// UpdateNode updates node information.
+func (ms *MemoryStorage) UpdateNode(id, publicAddress string, port int, nodeType NodeType) error {
+	return ms.updateNode(id, HistoryUpdate, func(_ *memState, node *Node) error {
+		node.PublicAddress = publicAddress
+		node.Port = port
+		node.Type = nodeType
+		return nil
+	})
+}
+
+// ReplaceNode writes the editable fields of node over the stored record
+// unless it changed since revision.
+func (ms *MemoryStorage) ReplaceNode(node *Node, revision int) error {
+	return ms.updateNode(node.ID, HistoryUpdate, func(_ *memState, stored *Node) error {
+		if revision != AnyRevision && stored.Revision != revision {
+			return kindErrorf(ErrConflict, "node %q was modified by another process, re-run your edit", stored.Name)
+		}
+		copyNodeEdit(stored, node)
+		return nil
+	})
+}
+
+// UpdateNodeRoutedCIDRs replaces the subnets a node routes for.
+func (ms *MemoryStorage) UpdateNodeRoutedCIDRs(id string, routedCIDRs []string) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.RoutedCIDRs = slices.Clone(routedCIDRs)
+		return nil
+	})
+}
+
+// UpdateNodeLabels replaces a node's labels.
+func (ms *MemoryStorage) UpdateNodeLabels(id string, labels map[string]string) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.Labels = maps.Clone(labels)
+		return nil
+	})
+}
+
+// UpdateNodeServer sets the server a node is assigned to and whether it
+// peers with every server of the network.
+func (ms *MemoryStorage) UpdateNodeServer(id, serverID string, meshServers bool) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.ServerID = serverID
+		node.MeshServers = meshServers
+		return nil
+	})
+}
+
+// UpdateNodeExpiry sets when a node's access ends; nil removes the expiry.
+func (ms *MemoryStorage) UpdateNodeExpiry(id string, expiresAt *time.Time) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.ExpiresAt = expiresAt
+		return nil
+	})
+}
+
+// UpdateNodeFullTunnel sets whether a node sends all its traffic through
+// the tunnel.
+func (ms *MemoryStorage) UpdateNodeFullTunnel(id string, fullTunnel bool) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.FullTunnel = fullTunnel
+		return nil
+	})
+}
+
+// UpdateNodeInternalEndpoint sets a node's internal endpoint; an empty
+// address removes it.
+func (ms *MemoryStorage) UpdateNodeInternalEndpoint(id, address string, port int) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.InternalAddress = address
+		node.InternalPort = port
+		return nil
+	})
+}
+
+// UpdateNodePreferInternal sets whether a node's peers reach it at its
+// internal endpoint.
+func (ms *MemoryStorage) UpdateNodePreferInternal(id string, preferInternal bool) error {
+	return ms.updateNode(id, "", func(_ *memState, node *Node) error {
+		node.PreferInternal = preferInternal
+		return nil
+	})
+}
+
+// UpdateNodeKeys replaces a node's key pair.
+func (ms *MemoryStorage) UpdateNodeKeys(id, privateKey, publicKey string) error {
+	return ms.updateNode(id, HistoryRekey, func(s *memState, node *Node) error {
+		if err := s.checkPublicKeyUnique(node.NetworkID, id, publicKey); err != nil {
+			return err
+		}
+		node.PrivateKey = privateKey
+		node.PublicKey = publicKey
+		return nil
+	})
+}
+
+// RenameNode renames a node of a network.
+func (ms *MemoryStorage) RenameNode(networkID, oldName, newName string) (*Node, error) {
+	var id string
+	err := ms.view(context.Background(), func(s *memState) error {
+		found := s.nodeByName(networkID, oldName)
+		if found == nil {
+			return kindErrorf(ErrNotFound, "node %q not found", oldName)
+		}
+		id = found.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var node *Node
+	err = ms.updateNode(id, HistoryRename, func(s *memState, renamed *Node) error {
+		if renamed.Name != oldName {
+			return kindErrorf(ErrNotFound, "node %q not found", oldName)
+		}
+		if s.nodeByName(networkID, newName) != nil {
+			return kindErrorf(ErrAlreadyExists, "node name %q already exists", newName)
+		}
+		renamed.Name = newName
+		node = renamed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyRecord(node), nil
+}
+
+// DeleteNode deletes a node.
+func (ms *MemoryStorage) DeleteNode(networkID, name string) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		return s.deleteNode(networkID, name)
+	})
+}
+
+// DeleteNodeWithPoolState deletes a node and saves the network's IP pool
+// state together.
+func (ms *MemoryStorage) DeleteNodeWithPoolState(networkID, name string, state *util.IPPoolState) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		if err := s.deleteNode(networkID, name); err != nil {
+			return err
+		}
+		return ms.putIPPoolState(s, networkID, state)
+	})
+}
+
+func (s *memState) deleteNode(networkID, name string) error {
+	node := s.nodeByName(networkID, name)
+	if node == nil {
+		return kindErrorf(ErrNotFound, "node %q not found", name)
+	}
+	delete(s.nodes, node.ID)
+	s.deleteEntity(node.ID)
+	s.bumpRevision(networkID)
+	return nil
+}
+
+// deleteEntity removes the deployment and history of a deleted server or
+// node.
+func (s *memState) deleteEntity(id string) {
+	delete(s.deployments, id)
+	delete(s.history, id)
+}
+
+// checkPublicKeyUnique is checkPublicKeyUnique on the stored records.
+func (s *memState) checkPublicKeyUnique(networkID, id, publicKey string) error {
+	if publicKey == "" {
+		return nil
+	}
+	for _, server := range s.listServers(networkID) {
+		if server.ID != id && server.PublicKey == publicKey {
+			return kindErrorf(ErrAlreadyExists, "public key is already used by server %q", server.Name)
+		}
+	}
+	for _, node := range s.listNodes(networkID) {
+		if node.ID != id && node.PublicKey == publicKey {
+			return kindErrorf(ErrAlreadyExists, "public key is already used by node %q", node.Name)
+		}
+	}
+	return nil
+}
+
+// ========== Config Operations ==========
+
+// SaveConfigVersion saves a new config version.
+func (ms *MemoryStorage) SaveConfigVersion(networkID, contentHash string, configs map[string]string) (*ConfigVersion, error) {
+	return ms.SaveConfigVersionWithMessage(networkID, contentHash, configs, "", "")
+}
+
+// SaveConfigVersionWithMessage saves a new config version annotated with
+// why it was saved and by whom.
+func (ms *MemoryStorage) SaveConfigVersionWithMessage(networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error) {
+	return ms.SaveConfigVersionWithMessageCtx(context.Background(), networkID, contentHash, configs, message, changedBy)
+}
+
+// SaveConfigVersionWithMessageCtx is SaveConfigVersionWithMessage with a
+// context.
+func (ms *MemoryStorage) SaveConfigVersionWithMessageCtx(ctx context.Context, networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error) {
+	var config *ConfigVersion
+	err := ms.update(ctx, func(s *memState) error {
+		versions := s.configs[networkID]
+		nextVer := 1
+		var previous map[string]string
+		if len(versions) > 0 {
+			last := versions[len(versions)-1]
+			nextVer = last.Version + 1
+			previous = last.Configs
+		}
+
+		config = &ConfigVersion{
+			ID:          uuid.New().String(),
+			NetworkID:   networkID,
+			Version:     nextVer,
+			ContentHash: contentHash,
+			Configs:     configs,
+			Changed:     changedConfigs(previous, configs),
+			Message:     message,
+			ChangedBy:   changedBy,
+			CreatedAt:   time.Now(),
+		}
+		s.configs[networkID] = append(slices.Clip(versions), copyRecord(config))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// GetLatestConfigVersion retrieves the latest config version for a network.
+func (ms *MemoryStorage) GetLatestConfigVersion(networkID string) (*ConfigVersion, error) {
+	return ms.GetLatestConfigVersionCtx(context.Background(), networkID)
+}
+
+// GetLatestConfigVersionCtx is GetLatestConfigVersion with a context.
+func (ms *MemoryStorage) GetLatestConfigVersionCtx(ctx context.Context, networkID string) (*ConfigVersion, error) {
+	var config *ConfigVersion
+	err := ms.view(ctx, func(s *memState) error {
+		versions := s.configs[networkID]
+		if len(versions) == 0 {
+			return kindErrorf(ErrNotFound, "no config version found for network %q", networkID)
+		}
+		config = copyRecord(versions[len(versions)-1])
+		return nil
+	})
+	return config, err
+}
+
+// GetConfigVersion retrieves a specific config version.
+func (ms *MemoryStorage) GetConfigVersion(networkID string, version int) (*ConfigVersion, error) {
+	var config *ConfigVersion
+	err := ms.view(context.Background(), func(s *memState) error {
+		for _, v := range s.configs[networkID] {
+			if v.Version == version {
+				config = copyRecord(v)
+				return nil
+			}
+		}
+		return kindErrorf(ErrNotFound, "config version %d not found for network %q", version, networkID)
+	})
+	return config, err
+}
+
+// ListConfigVersions lists all versions for a network, ordered by version.
+func (ms *MemoryStorage) ListConfigVersions(networkID string) ([]*ConfigVersion, error) {
+	return ms.ListConfigVersionsCtx(context.Background(), networkID)
+}
+
+// ListConfigVersionsCtx is ListConfigVersions with a context.
+func (ms *MemoryStorage) ListConfigVersionsCtx(ctx context.Context, networkID string) ([]*ConfigVersion, error) {
+	var versions []*ConfigVersion
+	err := ms.view(ctx, func(s *memState) error {
+		for _, v := range s.configs[networkID] {
+			versions = append(versions, copyRecord(v))
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// ListConfigVersionsFilteredCtx lists the versions of a network filter
+// selects, oldest first, and how many versions the network has in all.
+func (ms *MemoryStorage) ListConfigVersionsFilteredCtx(ctx context.Context, networkID string, filter ConfigVersionFilter) ([]*ConfigVersion, int, error) {
+	var versions []*ConfigVersion
+	total := 0
+	err := ms.view(ctx, func(s *memState) error {
+		total = len(s.configs[networkID])
+		for _, v := range s.configs[networkID] {
+			if filter.matches(v.CreatedAt) {
+				versions = append(versions, v)
+			}
+		}
+		if filter.Last > 0 && len(versions) > filter.Last {
+			versions = versions[len(versions)-filter.Last:]
+		}
+		for i, v := range versions {
+			versions[i] = copyRecord(v)
+		}
+		return nil
+	})
+	return versions, total, err
+}
+
+// GetConfigHashByVersion retrieves the hash of a specific version.
+func (ms *MemoryStorage) GetConfigHashByVersion(networkID string, version int) (string, error) {
+	config, err := ms.GetConfigVersion(networkID, version)
+	if err != nil {
+		return "", err
+	}
+	return config.ContentHash, nil
+}
+
+// SetConfigTag points tag at a saved config version; see
+// StorageManager.SetConfigTag.
+func (ms *MemoryStorage) SetConfigTag(networkID, tag string, version int, force bool) (int, error) {
+	previous := 0
+	err := ms.update(context.Background(), func(s *memState) error {
+		if !slices.ContainsFunc(s.configs[networkID], func(v *ConfigVersion) bool { return v.Version == version }) {
+			return kindErrorf(ErrNotFound, "config version %d not found for network %q", version, networkID)
+		}
+		key := string(tagKey(networkID, tag))
+		if current, ok := s.tags[key]; ok {
+			previous = current
+			if previous != version && !force {
+				return kindErrorf(ErrAlreadyExists, "tag %q already names version %d; use --force to move it", tag, previous)
+			}
+		}
+		s.tags[key] = version
+		return nil
+	})
+	return previous, err
+}
+
+// DeleteConfigTag removes a tag and returns the version it named.
+func (ms *MemoryStorage) DeleteConfigTag(networkID, tag string) (int, error) {
+	version := 0
+	err := ms.update(context.Background(), func(s *memState) error {
+		key := string(tagKey(networkID, tag))
+		current, ok := s.tags[key]
+		if !ok {
+			return kindErrorf(ErrNotFound, "tag %q not found", tag)
+		}
+		version = current
+		delete(s.tags, key)
+		return nil
+	})
+	return version, err
+}
+
+// GetConfigTag returns the config version a tag names.
+func (ms *MemoryStorage) GetConfigTag(networkID, tag string) (int, error) {
+	version := 0
+	err := ms.view(context.Background(), func(s *memState) error {
+		current, ok := s.tags[string(tagKey(networkID, tag))]
+		if !ok {
+			return kindErrorf(ErrNotFound, "tag %q not found", tag)
+		}
+		version = current
+		return nil
+	})
+	return version, err
+}
+
+// ListConfigTags returns the tags of a network, sorted by name.
+func (ms *MemoryStorage) ListConfigTags(networkID string) ([]ConfigTag, error) {
+	tags := []ConfigTag{}
+	err := ms.view(context.Background(), func(s *memState) error {
+		for key, version := range s.tags {
+			if tag, ok := strings.CutPrefix(key, networkID+":"); ok {
+				tags = append(tags, ConfigTag{Tag: tag, Version: version})
+			}
+		}
+		return nil
+	})
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+	return tags, err
+}
+
+// ========== Guest Operations ==========
+
+// CreateGuestWithPoolState stores a new guest and saves the network's IP
+// pool state in one write.
+func (ms *MemoryStorage) CreateGuestWithPoolState(networkID, name, virtualIP, publicKey, serverID string, expiresAt time.Time, state *util.IPPoolState) (*Guest, error) {
+	var guest *Guest
+	err := ms.update(context.Background(), func(s *memState) error {
+		if s.networks[networkID] == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", networkID)
+		}
+		key := string(guestKey(networkID, name))
+		if s.guests[key] != nil {
+			return kindErrorf(ErrAlreadyExists, "guest %q already exists", name)
+		}
+		guest = &Guest{
+			ID:        uuid.New().String(),
+			NetworkID: networkID,
+			Name:      name,
+			VirtualIP: virtualIP,
+			PublicKey: publicKey,
+			ServerID:  serverID,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}
+		s.guests[key] = copyRecord(guest)
+		if err := ms.putIPPoolState(s, networkID, state); err != nil {
+			return err
+		}
+		s.bumpRevision(networkID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return guest, nil
+}
+
+// ListGuestsByNetworkID returns the guests of a network, sorted by name.
+func (ms *MemoryStorage) ListGuestsByNetworkID(networkID string) ([]*Guest, error) {
+	guests := []*Guest{}
+	err := ms.view(context.Background(), func(s *memState) error {
+		for key, guest := range s.guests {
+			if strings.HasPrefix(key, networkID+":") {
+				guests = append(guests, copyRecord(guest))
+			}
+		}
+		return nil
+	})
+	sort.Slice(guests, func(i, j int) bool { return guests[i].Name < guests[j].Name })
+	return guests, err
+}
+
+// DeleteGuestsWithPoolState removes guests of a network and saves its IP
+// pool state in one write.
+func (ms *MemoryStorage) DeleteGuestsWithPoolState(networkID string, names []string, state *util.IPPoolState) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		for _, name := range names {
+			key := string(guestKey(networkID, name))
+			if s.guests[key] == nil {
+				return kindErrorf(ErrNotFound, "guest %q not found", name)
+			}
+			delete(s.guests, key)
+		}
+		if err := ms.putIPPoolState(s, networkID, state); err != nil {
+			return err
+		}
+		s.bumpRevision(networkID)
+		return nil
+	})
+}
+
+// ========== Deployment Operations ==========
+
+// SaveDeployment records a deployment, replacing the entity's previous one.
+func (ms *MemoryStorage) SaveDeployment(deployment *Deployment) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		s.deployments[deployment.EntityID] = copyRecord(deployment)
+		return nil
+	})
+}
+
+// ListDeployments returns the recorded deployments of a network's servers
+// and nodes, keyed by entity ID.
+func (ms *MemoryStorage) ListDeployments(networkID string) (map[string]*Deployment, error) {
+	return ms.ListDeploymentsCtx(context.Background(), networkID)
+}
+
+// ListDeploymentsCtx is ListDeployments with a context.
+func (ms *MemoryStorage) ListDeploymentsCtx(ctx context.Context, networkID string) (map[string]*Deployment, error) {
+	deployments := make(map[string]*Deployment)
+	err := ms.view(ctx, func(s *memState) error {
+		for id, deployment := range s.deployments {
+			if deployment.NetworkID == networkID {
+				deployments[id] = copyRecord(deployment)
+			}
+		}
+		return nil
+	})
+	return deployments, err
+}
+
+// ========== Revision and History Operations ==========
+
+// NetworkRevision returns the revision of a network; see
+// StorageManager.NetworkRevision.
+func (ms *MemoryStorage) NetworkRevision(networkID string) (uint64, error) {
+	return ms.NetworkRevisionCtx(context.Background(), networkID)
+}
+
+// NetworkRevisionCtx is NetworkRevision with a context.
+func (ms *MemoryStorage) NetworkRevisionCtx(ctx context.Context, networkID string) (uint64, error) {
+	var revision uint64
+	err := ms.view(ctx, func(s *memState) error {
+		revision = s.revisions[networkID]
+		return nil
+	})
+	return revision, err
+}
+
+func (s *memState) bumpRevision(networkID string) {
+	s.revisions[networkID]++
+}
+
+// EntityHistory returns the recorded revisions of a server or node, oldest
+// first; none when it was never changed.
+func (ms *MemoryStorage) EntityHistory(entityID string) ([]EntityRevision, error) {
+	var revisions []EntityRevision
+	err := ms.view(context.Background(), func(s *memState) error {
+		revisions = slices.Clone(s.history[entityID])
+		return nil
+	})
+	return revisions, err
+}
+
+// RecordResolutions stores what the public addresses of servers and nodes,
+// keyed by ID, resolved to at at.
+func (ms *MemoryStorage) RecordResolutions(resolutions map[string][]string, at time.Time) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		for id, ips := range resolutions {
+			var history *EndpointHistory
+			if server := s.servers[id]; server != nil {
+				server = copyRecord(server)
+				s.servers[id], history = server, &server.EndpointHistory
+			} else if node := s.nodes[id]; node != nil {
+				node = copyRecord(node)
+				s.nodes[id], history = node, &node.EndpointHistory
+			} else {
+				return kindErrorf(ErrNotFound, "server or node %s not found", id)
+			}
+			history.ResolvedIPs, history.ResolvedAt = slices.Clone(ips), &at
+		}
+		return nil
+	})
+}
+
+// recordHistory is StorageManager.recordHistory on s.
+func (ms *MemoryStorage) recordHistory(s *memState, entityID, action string, before, after any) error {
+	rev, err := newRevision(action, before, after)
+	if err != nil || rev == nil {
+		return err
+	}
+	limit := ms.historyLimit
+	if network, ok := s.networks[historyNetworkID(after)]; ok {
+		limit = network.historyLimit(limit)
+	}
+	s.history[entityID] = appendRevision(slices.Clone(s.history[entityID]), *rev, limit)
+	return nil
+}
+
+// ========== IP Pool Operations ==========
+
+// SaveIPPoolState saves a network's IP pool state. state.Revision is set to
+// the revision saved.
+func (ms *MemoryStorage) SaveIPPoolState(networkID string, state *util.IPPoolState) error {
+	return ms.update(context.Background(), func(s *memState) error {
+		return ms.putIPPoolState(s, networkID, state)
+	})
+}
+
+// putIPPoolState is StorageManager.putIPPoolState on s.
+func (ms *MemoryStorage) putIPPoolState(s *memState, networkID string, state *util.IPPoolState) error {
+	if state == nil {
+		return nil
+	}
+	if ms.poolWriteFault != nil {
+		if err := ms.poolWriteFault(); err != nil {
+			return err
+		}
+	}
+	state.Revision = 1
+	if saved := s.pools[networkID]; saved != nil {
+		state.Revision = saved.Revision + 1
+	}
+	s.pools[networkID] = copyRecord(state)
+	return nil
+}
+
+// GetIPPoolState retrieves a network's IP pool state.
+func (ms *MemoryStorage) GetIPPoolState(networkID string) (*util.IPPoolState, error) {
+	var state *util.IPPoolState
+	err := ms.view(context.Background(), func(s *memState) error {
+		saved := s.pools[networkID]
+		if saved == nil {
+			return kindErrorf(ErrNotFound, "IP pool state not found for network %s", networkID)
+		}
+		state = copyRecord(saved)
+		return nil
+	})
+	return state, err
+}
+
+// ========== Maintenance Operations ==========
+
+// ValidateNetwork checks a network's servers and nodes; see
+// StorageManager.ValidateNetwork.
+func (ms *MemoryStorage) ValidateNetwork(networkID string) (*ValidationReport, error) {
+	var report *ValidationReport
+	err := ms.view(context.Background(), func(s *memState) error {
+		network := s.networks[networkID]
+		if network == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", networkID)
+		}
+		report = validateNetwork(copyRecord(network), s.listServers(networkID), s.listNodes(networkID))
+		return nil
+	})
+	return report, err
+}
+
+// RepairIndexes removes nothing: a MemoryStorage has no indexes.
+func (ms *MemoryStorage) RepairIndexes() (int, error) {
+	return 0, nil
+}
+
+// CheckIntegrity reports only networks with an invalid CIDR: a
+// MemoryStorage has no indexes, and deleting a network or entity deletes
+// everything referring to it.
+func (ms *MemoryStorage) CheckIntegrity() (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		OrphanedIndexKeys:   []IntegrityRecord{},
+		DanglingReferences:  []IntegrityRecord{},
+		DuplicateVirtualIPs: []DuplicateVirtualIP{},
+		OrphanedConfigs:     []IntegrityRecord{},
+	}
+	err := ms.view(context.Background(), func(s *memState) error {
+		report.InvalidCIDRs = invalidCIDRs(s.networks)
+		return nil
+	})
+	return report, err
+}
+
+// FixIntegrity is CheckIntegrity; there is never anything it can fix.
+func (ms *MemoryStorage) FixIntegrity() (*IntegrityReport, error) {
+	return ms.CheckIntegrity()
+}
+
+// ========== Copying ==========
+
+// CopyNetwork copies a network from src into ms for a dry run: the network
+// record, its servers and nodes, its IP pool state, its latest config
+// version and its revision, keeping their IDs. Deployments, history and
+// older config versions are left behind. A network src does not have is
+// not copied, and no error.
+func (ms *MemoryStorage) CopyNetwork(ctx context.Context, src Storage, networkName string) error {
+	network, err := src.GetNetworkByNameCtx(ctx, networkName)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	servers, err := src.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	nodes, err := src.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	state, err := src.GetIPPoolState(network.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	revision, err := src.NetworkRevisionCtx(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	// A network without versions has no latest one; that is no error.
+	latest, latestErr := src.GetLatestConfigVersionCtx(ctx, network.ID)
+
+	return ms.update(ctx, func(s *memState) error {
+		if s.networkByName(networkName) != nil {
+			return kindErrorf(ErrAlreadyExists, "network name %q already exists", networkName)
+		}
+		s.networks[network.ID] = network
+		for _, server := range servers {
+			s.servers[server.ID] = server
+		}
+		for _, node := range nodes {
+			s.nodes[node.ID] = node
+		}
+		if state != nil {
+			s.pools[network.ID] = state
+		}
+		if latestErr == nil {
+			s.configs[network.ID] = []*ConfigVersion{latest}
+		}
+		s.revisions[network.ID] = revision
+		return nil
+	})
+}
@@ -0,0 +1,292 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// newMemoryTestManager is newTestManager on a MemoryStorage.
+func newMemoryTestManager(t *testing.T) (*VirtualNetworkManager, *MemoryStorage) {
+	t.Helper()
+	ms := NewMemoryStorage()
+	vnm, err := NewVirtualNetworkManager(ms, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	return vnm, ms
+}
+
+// storageOutcome is what backendScenario observed, free of IDs, keys and
+// times so the backends can be compared.
+type storageOutcome struct {
+	Errors    []string
+	Nodes     map[string]string // name -> virtual IP
+	Servers   []string
+	Revision  uint64
+	PoolRev   uint64
+	History   []string
+	Configs   []string
+	Versions  []int
+	Changed   []string
+	Findings  int
+	Networks  []string
+	AfterDrop []string
+}
+
+// backendScenario runs manager and generator operations on storage and
+// records what they did.
+func backendScenario(t *testing.T, storage Storage) storageOutcome {
+	t.Helper()
+	vnm, err := NewVirtualNetworkManager(storage, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(storage)
+	var out storageOutcome
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("scenario step error = %v", err)
+		}
+	}
+	expectErr := func(err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatal("scenario step succeeded, want an error")
+		}
+		out.Errors = append(out.Errors, err.Error())
+	}
+
+	_, err = vnm.CreateVirtualNetwork("office", "10.9.0.0/24")
+	must(err)
+	_, err = vnm.CreateVirtualNetwork("office", "10.9.0.0/24")
+	expectErr(err)
+	_, err = vnm.CreateServer("office", "hub", "vpn.example.com", 51820)
+	must(err)
+	_, err = vnm.CreateServer("office", "edge", "edge.example.com", 51820)
+	must(err)
+	for _, name := range []string{"laptop", "desk", "branch"} {
+		_, err = vnm.CreateNode("office", name, "", 0, NodeTypeRoute)
+		must(err)
+	}
+	_, err = vnm.CreateNode("office", "laptop", "", 0, NodeTypeRoute)
+	expectErr(err)
+	_, err = vnm.UpdateNode("office", "laptop", "198.51.100.7", 51821, NodeTypePeer)
+	must(err)
+	_, err = vnm.RenameNode("office", "desk", "workstation")
+	must(err)
+	_, err = vnm.RenameNode("office", "workstation", "laptop")
+	expectErr(err)
+	must(vnm.DeleteNode("office", "branch"))
+	_, err = vnm.CreateNode("office", "printer", "", 0, NodeTypeRoute)
+	must(err)
+	_, err = vnm.UpdateNode("office", "missing", "", 0, NodeTypePeer)
+	expectErr(err)
+
+	_, created, err := gen.SaveConfigVersion("office")
+	must(err)
+	if !created {
+		t.Fatal("SaveConfigVersion() created = false, want a first version")
+	}
+	_, err = vnm.UpdateServer("office", "hub", "vpn2.example.com", 51820)
+	must(err)
+	version, _, err := gen.SaveConfigVersion("office")
+	must(err)
+	out.Changed = version.Changed
+	must(vnm.DeleteServer("office", DeleteServerOptions{Name: "edge"}))
+
+	network, err := storage.GetNetworkByName("office")
+	must(err)
+	nodes, err := storage.ListNodesByNetworkID(network.ID)
+	must(err)
+	out.Nodes = make(map[string]string)
+	for _, node := range nodes {
+		out.Nodes[node.Name] = node.VirtualIP
+	}
+	servers, err := storage.ListServersByNetworkID(network.ID)
+	must(err)
+	for _, server := range servers {
+		out.Servers = append(out.Servers, server.Name)
+	}
+	out.Revision, err = storage.NetworkRevision(network.ID)
+	must(err)
+	state, err := storage.GetIPPoolState(network.ID)
+	must(err)
+	out.PoolRev = state.Revision
+	history, err := vnm.NodeHistory("office", "workstation")
+	must(err)
+	for _, rev := range history {
+		out.History = append(out.History, rev.Action)
+	}
+	configs, _, err := gen.GenerateConfigs("office", storage)
+	must(err)
+	out.Configs = slices.Sorted(maps.Keys(configs))
+	versions, err := storage.ListConfigVersions(network.ID)
+	must(err)
+	for _, v := range versions {
+		out.Versions = append(out.Versions, v.Version)
+	}
+	report, err := vnm.ValidateNetwork("office")
+	must(err)
+	out.Findings = len(report.Findings)
+
+	_, err = vnm.CreateVirtualNetwork("lab", "10.10.0.0/24")
+	must(err)
+	networks, err := storage.ListNetworks()
+	must(err)
+	for _, n := range networks {
+		out.Networks = append(out.Networks, n.Name)
+	}
+	slices.Sort(out.Networks)
+	must(vnm.DeleteVirtualNetwork("office"))
+	if _, err := storage.GetIPPoolState(network.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetIPPoolState() after network delete error = %v, want ErrNotFound", err)
+	}
+	if versions, _ := storage.ListConfigVersions(network.ID); len(versions) != 0 {
+		t.Errorf("config versions after network delete = %d, want none", len(versions))
+	}
+	if history, _ := storage.EntityHistory(nodes[0].ID); len(history) != 0 {
+		t.Errorf("history after network delete = %d revisions, want none", len(history))
+	}
+	networks, err = storage.ListNetworks()
+	must(err)
+	for _, n := range networks {
+		out.AfterDrop = append(out.AfterDrop, n.Name)
+	}
+	return out
+}
+
+func TestStorageBackendsAgree(t *testing.T) {
+	sm, err := NewStorageManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+
+	bolt := backendScenario(t, sm)
+	memory := backendScenario(t, NewMemoryStorage())
+	if !reflect.DeepEqual(bolt, memory) {
+		t.Errorf("backends disagree:\nbbolt:  %+v\nmemory: %+v", bolt, memory)
+	}
+	if len(bolt.Errors) != 4 || bolt.Versions == nil || !slices.Equal(bolt.Servers, []string{"hub"}) {
+		t.Errorf("scenario outcome = %+v, want 4 errors, versions and only hub left", bolt)
+	}
+}
+
+func TestMemoryStorage_WritesAreAtomic(t *testing.T) {
+	vnm, ms := newMemoryTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("atomic", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	network, err := ms.GetNetworkByName("atomic")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	before, _ := ms.NetworkRevision(network.ID)
+
+	ms.poolWriteFault = func() error { return errors.New("disk full") }
+	t.Cleanup(func() { ms.poolWriteFault = nil })
+	if _, err := vnm.CreateNode("atomic", "laptop", "", 0, NodeTypeRoute); err == nil {
+		t.Fatal("CreateNode() succeeded with the pool write failing")
+	}
+	ms.poolWriteFault = nil
+
+	if _, err := ms.GetNodeByName(network.ID, "laptop"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetNodeByName() error = %v, want ErrNotFound after the failed write", err)
+	}
+	if after, _ := ms.NetworkRevision(network.ID); after != before {
+		t.Errorf("revision = %d after the failed write, want %d", after, before)
+	}
+}
+
+func TestMemoryStorage_ReturnsCopies(t *testing.T) {
+	ms := NewMemoryStorage()
+	network, err := ms.CreateNetwork("copies", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	node, err := ms.CreateNode(network.ID, "laptop", "", 0, "10.0.0.2", NodeTypeRoute, "", "")
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if err := ms.UpdateNodeRoutedCIDRs(node.ID, []string{"192.168.1.0/24"}); err != nil {
+		t.Fatalf("UpdateNodeRoutedCIDRs() error = %v", err)
+	}
+
+	node.Name = "changed"
+	got, err := ms.GetNodeByName(network.ID, "laptop")
+	if err != nil {
+		t.Fatalf("GetNodeByName() error = %v", err)
+	}
+	got.RoutedCIDRs[0] = "10.1.0.0/16"
+	again, _ := ms.GetNodeByName(network.ID, "laptop")
+	if again.Name != "laptop" || again.RoutedCIDRs[0] != "192.168.1.0/24" {
+		t.Errorf("stored node = %+v, want it untouched by changes to returned records", again)
+	}
+}
+
+func TestMemoryStorage_CopyNetwork(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("src", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("src", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	node, err := vnm.CreateNode("src", "laptop", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(sm)
+	if _, _, err := gen.SaveConfigVersion("src"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, _, err := gen.SaveConfigVersion("src"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, err := vnm.UpdateNode("src", "laptop", "198.51.100.7", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("UpdateNode() error = %v", err)
+	}
+	latest, _, err := gen.SaveConfigVersion("src")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	ms := NewMemoryStorage()
+	if err := ms.CopyNetwork(context.Background(), sm, "src"); err != nil {
+		t.Fatalf("CopyNetwork() error = %v", err)
+	}
+	copied, err := ms.GetNodeByName(node.NetworkID, "laptop")
+	if err != nil || copied.ID != node.ID || copied.PublicAddress != "198.51.100.7" {
+		t.Fatalf("copied node = %+v, %v; want laptop with its ID and address", copied, err)
+	}
+
+	// The copy generates the same configs and numbers its next version
+	// after the latest one.
+	memGen := NewWireGuardConfigGenerator(ms)
+	version, created, err := memGen.SaveConfigVersion("src")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() on the copy error = %v", err)
+	}
+	if created || version.Version != latest.Version {
+		t.Errorf("copy saved version %d (created %v), want the unchanged latest %d", version.Version, created, latest.Version)
+	}
+	want, _ := sm.NetworkRevision(node.NetworkID)
+	if got, _ := ms.NetworkRevision(node.NetworkID); got != want {
+		t.Errorf("copied revision = %d, want %d", got, want)
+	}
+
+	if err := ms.CopyNetwork(context.Background(), sm, "missing"); err != nil {
+		t.Errorf("CopyNetwork(missing) error = %v, want none", err)
+	}
+	if err := ms.CopyNetwork(context.Background(), sm, "src"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("CopyNetwork() twice error = %v, want ErrAlreadyExists", err)
+	}
+}
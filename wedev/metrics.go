@@ -0,0 +1,292 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Metrics collects the commands and storage transactions of a run, and a
+// snapshot of the database, for export in the Prometheus text format. It is
+// passed in through StorageOptions.Metrics rather than kept globally, so
+// each run, and each test, collects its own. A nil *Metrics collects
+// nothing. Metrics is safe for concurrent use.
+type Metrics struct {
+	mu       sync.Mutex
+	commands map[resultKey]time.Duration // command -> total duration
+	txs      map[txKey]*txStat
+	database *DatabaseMetrics
+}
+
+// resultKey identifies a command by name and outcome ("ok" or "error").
+type resultKey struct {
+	name, result string
+}
+
+// txKey identifies a storage transaction by kind ("update" or "view") and
+// the StorageManager method that ran it.
+type txKey struct {
+	kind, op string
+}
+
+// txStat counts the transactions of one txKey.
+type txStat struct {
+	ok, errors int
+	duration   time.Duration
+}
+
+// DatabaseMetrics is a snapshot of a database for Metrics.
+type DatabaseMetrics struct {
+	Size     int64 // bytes
+	Networks []NetworkMetrics
+}
+
+// NetworkMetrics is the part of DatabaseMetrics about one network.
+type NetworkMetrics struct {
+	Name            string
+	Nodes           int
+	LastConfigSaved time.Time // zero when no config version was saved
+}
+
+// NewMetrics returns an empty collector.
+func NewMetrics() *Metrics {
+	return &Metrics{commands: make(map[resultKey]time.Duration), txs: make(map[txKey]*txStat)}
+}
+
+// ObserveCommand records a command that ran for d and failed with err, or
+// succeeded when err is nil.
+func (m *Metrics) ObserveCommand(command string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[resultKey{command, outcome(err)}] += d
+}
+
+// observeTx records a storage transaction.
+func (m *Metrics) observeTx(kind, op string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stat := m.txs[txKey{kind, op}]
+	if stat == nil {
+		stat = &txStat{}
+		m.txs[txKey{kind, op}] = stat
+	}
+	if err != nil {
+		stat.errors++
+	} else {
+		stat.ok++
+	}
+	stat.duration += d
+}
+
+// SetDatabase records the database snapshot to export, replacing any
+// earlier one.
+func (m *Metrics) SetDatabase(db *DatabaseMetrics) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.database = db
+}
+
+// outcome is the result label of err.
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format, with config version ages taken at now. Series are sorted, so the
+// output of equal metrics is equal.
+func (m *Metrics) WritePrometheus(w io.Writer, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &promWriter{w: w}
+	p.family("wedevctl_command_duration_seconds", "gauge", "How long the command ran, by outcome.")
+	for _, key := range sortedKeys(m.commands, func(a, b resultKey) int {
+		return strings.Compare(a.name+"\x00"+a.result, b.name+"\x00"+b.result)
+	}) {
+		p.sample("wedevctl_command_duration_seconds", m.commands[key].Seconds(), "command", key.name, "result", key.result)
+	}
+
+	txOrder := func(a, b txKey) int { return strings.Compare(a.kind+"\x00"+a.op, b.kind+"\x00"+b.op) }
+	p.family("wedevctl_storage_transactions_total", "counter", "Storage transactions run, by kind, operation and outcome.")
+	for _, key := range sortedKeys(m.txs, txOrder) {
+		stat := m.txs[key]
+		if stat.ok > 0 {
+			p.sample("wedevctl_storage_transactions_total", float64(stat.ok), "kind", key.kind, "op", key.op, "result", "ok")
+		}
+		if stat.errors > 0 {
+			p.sample("wedevctl_storage_transactions_total", float64(stat.errors), "kind", key.kind, "op", key.op, "result", "error")
+		}
+	}
+	p.family("wedevctl_storage_transaction_seconds_total", "counter", "Time spent in storage transactions, by kind and operation.")
+	for _, key := range sortedKeys(m.txs, txOrder) {
+		p.sample("wedevctl_storage_transaction_seconds_total", m.txs[key].duration.Seconds(), "kind", key.kind, "op", key.op)
+	}
+
+	if db := m.database; db != nil {
+		networks := slices.SortedFunc(slices.Values(db.Networks), func(a, b NetworkMetrics) int { return strings.Compare(a.Name, b.Name) })
+		p.family("wedevctl_db_size_bytes", "gauge", "Size of the database, as bbolt reports it.")
+		p.sample("wedevctl_db_size_bytes", float64(db.Size))
+		p.family("wedevctl_networks", "gauge", "Virtual networks in the database.")
+		p.sample("wedevctl_networks", float64(len(networks)))
+		p.family("wedevctl_nodes", "gauge", "Nodes in each virtual network.")
+		for _, network := range networks {
+			p.sample("wedevctl_nodes", float64(network.Nodes), "network", network.Name)
+		}
+		p.family("wedevctl_config_version_age_seconds", "gauge", "Time since the last config version of each virtual network was saved.")
+		for _, network := range networks {
+			if !network.LastConfigSaved.IsZero() {
+				p.sample("wedevctl_config_version_age_seconds", now.Sub(network.LastConfigSaved).Seconds(), "network", network.Name)
+			}
+		}
+	}
+	return p.err
+}
+
+// WriteFile writes the metrics to path as WritePrometheus does, through a
+// temporary file renamed into place, so a textfile collector reading the
+// directory never sees a partial file.
+func (m *Metrics) WriteFile(path string, now time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary metrics file: %w", err)
+	}
+	tmpName := tmp.Name()
+	//nolint:errcheck // Removing a renamed temp file is a harmless no-op
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if err := m.WritePrometheus(tmp, now); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	// The collector usually runs as another user.
+	if err := tmp.Chmod(0o644); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close metrics file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m sorted by cmp.
+func sortedKeys[K comparable, V any](m map[K]V, cmp func(a, b K) int) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+// promWriter writes metric families in the Prometheus text format, keeping
+// the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+// family writes the HELP and TYPE lines of a metric.
+func (p *promWriter) family(name, kind, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one series of a metric; labels alternate names and values.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	p.printf("%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+// escapeLabel escapes a label value as the text format requires.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// DatabaseMetrics takes the snapshot of the database that Metrics exports:
+// its size, and the nodes and time of the last config version of each
+// network. Config contents are not read, so it works on a locked encrypted
+// database.
+func (sm *StorageManager) DatabaseMetrics(ctx context.Context) (*DatabaseMetrics, error) {
+	db := &DatabaseMetrics{}
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		db.Size = tx.Size()
+		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+		configs := tx.Bucket([]byte(BucketConfigs))
+		return tx.Bucket([]byte(BucketNetworks)).ForEach(checkCtx(ctx, func(_, v []byte) error {
+			var network VirtualNetwork
+			if err := json.Unmarshal(v, &network); err != nil {
+				return fmt.Errorf("failed to unmarshal network: %w", err)
+			}
+			metrics := NetworkMetrics{Name: network.Name}
+			prefix := []byte(network.ID + ":")
+			if err := forEachWithPrefix(nodesByNetwork, prefix, func(_, _ []byte) error {
+				metrics.Nodes++
+				return nil
+			}); err != nil {
+				return err
+			}
+			if _, id := lastWithPrefix(configsByVer, prefix); id != nil {
+				var version struct {
+					CreatedAt time.Time `json:"created_at"`
+				}
+				if data := configs.Get(id); data != nil {
+					if err := json.Unmarshal(data, &version); err != nil {
+						return fmt.Errorf("failed to unmarshal config version: %w", err)
+					}
+					metrics.LastConfigSaved = version.CreatedAt
+				}
+			}
+			db.Networks = append(db.Networks, metrics)
+			return nil
+		}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
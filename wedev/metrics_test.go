@@ -0,0 +1,162 @@
+package wedev
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wedevctl/util"
+)
+
+// sampleLine matches a series of the text format: name, labels and value.
+var sampleLine = regexp.MustCompile(`^([a-z_]+)(?:\{(.*)\})? (\S+)$`)
+
+// promSeries parses text-format output into the label names of each metric
+// and the value of each series, keyed by its line without the value.
+func promSeries(t *testing.T, out string) (map[string][]string, map[string]string) {
+	t.Helper()
+	labels := make(map[string][]string)
+	values := make(map[string]string)
+	for line := range strings.Lines(out) {
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed line %q", line)
+		}
+		var names []string
+		for pair := range strings.SplitSeq(m[2], ",") {
+			if name, _, ok := strings.Cut(pair, "="); ok {
+				names = append(names, name)
+			}
+		}
+		labels[m[1]] = names
+		values[strings.TrimSuffix(line, " "+m[3])] = m[3]
+	}
+	return labels, values
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	sm, err := NewStorageManagerWithOptions(filepath.Join(t.TempDir(), "test.db"), StorageOptions{Metrics: metrics})
+	if err != nil {
+		t.Fatalf("NewStorageManagerWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+
+	steps := []func() error{
+		func() error { _, err := vnm.CreateVirtualNetwork("home", "10.0.0.0/24"); return err },
+		func() error { _, err := vnm.CreateVirtualNetwork("lab", "10.1.0.0/24"); return err },
+		func() error { _, err := vnm.CreateServer("home", "hub", "vpn.example.com", 51820); return err },
+		func() error { _, err := vnm.CreateNode("home", "nas", "", 0, NodeTypeClient); return err },
+		func() error { _, _, err := NewWireGuardConfigGenerator(sm).SaveConfigVersion("home"); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("setup error = %v", err)
+		}
+	}
+	if _, err := sm.GetNetworkByName("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetNetworkByName(missing) error = %v, want ErrNotFound", err)
+	}
+
+	db, err := sm.DatabaseMetrics(t.Context())
+	if err != nil {
+		t.Fatalf("DatabaseMetrics() error = %v", err)
+	}
+	metrics.SetDatabase(db)
+	metrics.ObserveCommand("vn node add", 1500*time.Millisecond, nil)
+	metrics.ObserveCommand(`odd "name"`, time.Second, errors.New("failed"))
+
+	// Ages are taken at the time given; an hour after the version was saved.
+	var saved time.Time
+	for _, network := range db.Networks {
+		if network.Name == "home" {
+			saved = network.LastConfigSaved
+		}
+	}
+	if saved.IsZero() {
+		t.Fatalf("DatabaseMetrics() = %+v, want the config version of home", db)
+	}
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf, saved.Add(time.Hour)); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	out := buf.String()
+
+	labels, values := promSeries(t, out)
+	wantLabels := map[string][]string{
+		"wedevctl_command_duration_seconds":          {"command", "result"},
+		"wedevctl_storage_transactions_total":        {"kind", "op", "result"},
+		"wedevctl_storage_transaction_seconds_total": {"kind", "op"},
+		"wedevctl_db_size_bytes":                     nil,
+		"wedevctl_networks":                          nil,
+		"wedevctl_nodes":                             {"network"},
+		"wedevctl_config_version_age_seconds":        {"network"},
+	}
+	for name, want := range wantLabels {
+		if got, ok := labels[name]; !ok || !slices.Equal(got, want) {
+			t.Errorf("metric %s has labels %v (present %v), want %v", name, got, ok, want)
+		}
+		if !strings.Contains(out, "# TYPE "+name+" ") {
+			t.Errorf("metric %s has no TYPE line", name)
+		}
+	}
+	for name := range labels {
+		if _, ok := wantLabels[name]; !ok {
+			t.Errorf("unexpected metric %s", name)
+		}
+	}
+
+	for series, want := range map[string]string{
+		`wedevctl_command_duration_seconds{command="vn node add",result="ok"}`:                  "1.5",
+		`wedevctl_command_duration_seconds{command="odd \"name\"",result="error"}`:              "1",
+		`wedevctl_storage_transactions_total{kind="update",op="CreateNetwork",result="ok"}`:     "2",
+		`wedevctl_storage_transactions_total{kind="view",op="GetNetworkByName",result="error"}`: "1",
+		`wedevctl_networks`:                                   "2",
+		`wedevctl_nodes{network="home"}`:                      "1",
+		`wedevctl_nodes{network="lab"}`:                       "0",
+		`wedevctl_config_version_age_seconds{network="home"}`: "3600",
+	} {
+		if got := values[series]; got != want {
+			t.Errorf("%s = %q, want %q", series, got, want)
+		}
+	}
+	if _, ok := values[`wedevctl_config_version_age_seconds{network="lab"}`]; ok {
+		t.Error("a network without config versions has a config version age")
+	}
+	if size := values["wedevctl_db_size_bytes"]; size == "" || size == "0" {
+		t.Errorf("wedevctl_db_size_bytes = %q, want the file size", size)
+	}
+
+	// Without a collector nothing is recorded, and nothing fails.
+	var none *Metrics
+	none.ObserveCommand("vn list", time.Second, nil)
+	none.SetDatabase(db)
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObserveCommand("version", time.Millisecond, nil)
+	path := filepath.Join(t.TempDir(), "wedevctl.prom")
+	if err := metrics.WriteFile(path, time.Now()); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*")); len(matches) != 0 {
+		t.Errorf("WriteFile() left temporary files %v", matches)
+	}
+	if err := metrics.WriteFile(filepath.Join(t.TempDir(), "missing", "wedevctl.prom"), time.Now()); err == nil {
+		t.Error("WriteFile() into a missing directory succeeded")
+	}
+}
@@ -0,0 +1,403 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// BucketMeta is the BoltDB bucket for database metadata such as the schema version.
+	BucketMeta = "meta"
+
+	metaKeySchemaVersion = "schema_version"
+	// Applied migrations are recorded as migration:<paddedVersion> -> RFC 3339 timestamp.
+	metaKeyMigrationPrefix = "migration:"
+)
+
+// Migration is a single schema upgrade step. Up runs inside the write
+// transaction that opens the database, so a failed migration leaves the
+// database untouched.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *bbolt.Tx) error
+}
+
+// migrations is the ordered registry of schema upgrades. Versions must be
+// consecutive starting at 1; append new entries, never reorder or remove.
+var migrations = []Migration{
+	{Version: 1, Description: "Backfill configs_by_version index", Up: backfillConfigVersionIndex},
+	{Version: 2, Description: "Backfill nodes_by_network index", Up: backfillNodesByNetworkIndex},
+	{Version: 3, Description: "Key servers_by_network index by server", Up: rekeyServersByNetworkIndex},
+	{Version: 4, Description: "Add deployments bucket and record changed configs per version", Up: addDeploymentTracking},
+	{Version: 5, Description: "Add revisions bucket", Up: addRevisions},
+	{Version: 6, Description: "Add history bucket", Up: addHistory},
+	{Version: 7, Description: "Add tags bucket", Up: addTags},
+	{Version: 8, Description: "Add sequences bucket and renumber duplicate config versions", Up: addSequences},
+	{Version: 9, Description: "Add guests bucket", Up: addGuests},
+	{Version: 10, Description: "Move DNS, topology and filename template into network settings", Up: moveNetworkSettings},
+}
+
+// LatestSchemaVersion returns the schema version this binary understands.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// MigrationState describes whether a registered migration has been applied.
+type MigrationState struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time // zero when the database predates migration tracking
+}
+
+// schemaVersion reads the stored schema version; databases created before
+// versioning was introduced report 0.
+func schemaVersion(tx *bbolt.Tx) (int, error) {
+	meta := tx.Bucket([]byte(BucketMeta))
+	if meta == nil {
+		return 0, nil
+	}
+	data := meta.Get([]byte(metaKeySchemaVersion))
+	if data == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", data, err)
+	}
+	return version, nil
+}
+
+// runMigrations applies every migration newer than the stored schema version
+// and refuses databases written by a newer wedevctl.
+func runMigrations(tx *bbolt.Tx, logger *slog.Logger) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(BucketMeta))
+	if err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", BucketMeta, err)
+	}
+
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this wedevctl supports (%d); upgrade wedevctl", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		appliedAt := []byte(time.Now().UTC().Format(time.RFC3339))
+		if err := meta.Put([]byte(metaKeyMigrationPrefix+padVersion(m.Version)), appliedAt); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		if err := meta.Put([]byte(metaKeySchemaVersion), []byte(strconv.Itoa(m.Version))); err != nil {
+			return fmt.Errorf("failed to update schema version: %w", err)
+		}
+		logger.Info("applied schema migration", "version", m.Version, "description", m.Description)
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version stored in the database.
+func (sm *StorageManager) SchemaVersion() (int, error) {
+	var version int
+	err := sm.view(func(tx *bbolt.Tx) error {
+		var err error
+		version, err = schemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+// ReadSchemaVersion returns the schema version of the database at dbPath. It
+// opens the file read-only, waiting up to timeout for a writer, and neither
+// creates, migrates nor otherwise checks it, so it also reads databases this
+// binary refuses to open. A missing file is an fs.ErrNotExist error.
+func ReadSchemaVersion(ctx context.Context, dbPath string, timeout time.Duration) (int, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	db, err := openWithRetry(ctx, dbPath, timeout, true)
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck // Read-only handle; nothing to flush on close
+	defer func() { _ = db.Close() }()
+
+	var version int
+	err = db.View(func(tx *bbolt.Tx) error {
+		version, err = schemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+// MigrationStatus lists every registered migration with its applied state.
+func (sm *StorageManager) MigrationStatus() ([]MigrationState, error) {
+	states := make([]MigrationState, 0, len(migrations))
+
+	err := sm.view(func(tx *bbolt.Tx) error {
+		current, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket([]byte(BucketMeta))
+
+		for _, m := range migrations {
+			state := MigrationState{Version: m.Version, Description: m.Description, Applied: m.Version <= current}
+			if meta != nil {
+				if data := meta.Get([]byte(metaKeyMigrationPrefix + padVersion(m.Version))); data != nil {
+					if t, err := time.Parse(time.RFC3339, string(data)); err == nil {
+						state.AppliedAt = t
+					}
+				}
+			}
+			states = append(states, state)
+		}
+		return nil
+	})
+
+	return states, err
+}
+
+// ========== Migrations ==========
+
+// backfillConfigVersionIndex rebuilds configs_by_version entries for config
+// versions saved before the index existed.
+func backfillConfigVersionIndex(tx *bbolt.Tx) error {
+	configsBucket := tx.Bucket([]byte(BucketConfigs))
+	configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+
+	return configsBucket.ForEach(func(k, v []byte) error {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(v, config); err != nil {
+			return fmt.Errorf("failed to unmarshal config %s: %w", k, err)
+		}
+		return configsByVer.Put([]byte(config.NetworkID+":"+padVersion(config.Version)), k)
+	})
+}
+
+// backfillNodesByNetworkIndex rebuilds nodes_by_network entries for nodes
+// created before the index existed.
+func backfillNodesByNetworkIndex(tx *bbolt.Tx) error {
+	nodesBucket := tx.Bucket([]byte(BucketNodes))
+	nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+
+	return nodesBucket.ForEach(func(k, v []byte) error {
+		node := &Node{}
+		if err := json.Unmarshal(v, node); err != nil {
+			return fmt.Errorf("failed to unmarshal node %s: %w", k, err)
+		}
+		return nodesByNetwork.Put([]byte(node.NetworkID+":"+node.ID), k)
+	})
+}
+
+// rekeyServersByNetworkIndex rewrites servers_by_network from one entry per
+// network (networkID -> server ID) to one per server (networkID:serverID ->
+// server ID), so a network can have several servers.
+func rekeyServersByNetworkIndex(tx *bbolt.Tx) error {
+	if err := tx.DeleteBucket([]byte(BucketServersByNetwork)); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+		return err
+	}
+	serversByNetwork, err := tx.CreateBucket([]byte(BucketServersByNetwork))
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(BucketServers)).ForEach(func(k, v []byte) error {
+		server := &Server{}
+		if err := json.Unmarshal(v, server); err != nil {
+			return fmt.Errorf("failed to unmarshal server %s: %w", k, err)
+		}
+		return serversByNetwork.Put([]byte(server.NetworkID+":"+server.ID), k)
+	})
+}
+
+// addDeploymentTracking creates the deployments bucket and fills in the
+// changed configs of versions saved before they were recorded, comparing
+// each version with the one before it in the same network.
+func addDeploymentTracking(tx *bbolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists([]byte(BucketDeployments)); err != nil {
+		return err
+	}
+
+	configsBucket := tx.Bucket([]byte(BucketConfigs))
+	configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+
+	// The index is ordered by network, then version. Collect the versions
+	// first: a bucket cannot be modified while it is being iterated.
+	var versions []*ConfigVersion
+	if err := configsByVer.ForEach(func(k, v []byte) error {
+		data := configsBucket.Get(v)
+		if data == nil {
+			return nil
+		}
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return fmt.Errorf("failed to unmarshal config %s: %w", v, err)
+		}
+		versions = append(versions, config)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var previous *ConfigVersion
+	for _, config := range versions {
+		var previousConfigs map[string]string
+		if previous != nil && previous.NetworkID == config.NetworkID {
+			previousConfigs = previous.Configs
+		}
+		previous = config
+		if config.Changed != nil {
+			continue
+		}
+		config.Changed = changedConfigs(previousConfigs, config.Configs)
+		data, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config %s: %w", config.ID, err)
+		}
+		if err := configsBucket.Put([]byte(config.ID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRevisions creates the revisions bucket. Networks start at revision 0
+// and get an entry on their first change.
+func addRevisions(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(BucketRevisions))
+	return err
+}
+
+// addHistory creates the history bucket. Servers and nodes start with no
+// history and get an entry on their first recorded change.
+func addHistory(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(BucketHistory))
+	return err
+}
+
+// addTags creates the tags bucket. No config version is tagged until
+// 'config tag' names one.
+func addTags(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(BucketTags))
+	return err
+}
+
+// addSequences creates the sequences bucket and gives config versions that
+// share their number with another the next free numbers. Sequences start
+// from the highest indexed version of their network on its next save.
+func addSequences(tx *bbolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists([]byte(BucketSequences)); err != nil {
+		return err
+	}
+	networks, err := loadNetworks(tx)
+	if err != nil {
+		return err
+	}
+	duplicates, _, err := checkConfigVersions(tx, networks)
+	if err != nil {
+		return err
+	}
+	return renumberConfigVersions(tx, duplicates)
+}
+
+// addGuests creates the guests bucket.
+func addGuests(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(BucketGuests))
+	return err
+}
+
+// movedNetworkFields maps the network record fields that became settings to
+// their setting keys.
+var movedNetworkFields = map[string]string{
+	"dns":               SettingDNS,
+	"topology":          SettingTopology,
+	"filename_template": SettingFilenameTemplate,
+}
+
+// moveNetworkSettings moves the DNS, topology and filename template fields
+// of each network record into its settings. A setting already present is
+// kept.
+func moveNetworkSettings(tx *bbolt.Tx) error {
+	networksBucket := tx.Bucket([]byte(BucketNetworks))
+	if networksBucket == nil {
+		return nil
+	}
+	updated := make(map[string][]byte)
+	err := networksBucket.ForEach(func(k, v []byte) error {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("failed to unmarshal network %s: %w", k, err)
+		}
+		settings := make(map[string]string)
+		if data, ok := record["settings"]; ok {
+			if err := json.Unmarshal(data, &settings); err != nil {
+				return fmt.Errorf("failed to unmarshal settings of network %s: %w", k, err)
+			}
+		}
+		moved := false
+		for field, key := range movedNetworkFields {
+			data, ok := record[field]
+			if !ok {
+				continue
+			}
+			delete(record, field)
+			moved = true
+			var value string
+			if field == "dns" {
+				var servers []string
+				if err := json.Unmarshal(data, &servers); err != nil {
+					return fmt.Errorf("failed to unmarshal dns of network %s: %w", k, err)
+				}
+				value = strings.Join(servers, ",")
+			} else if err := json.Unmarshal(data, &value); err != nil {
+				return fmt.Errorf("failed to unmarshal %s of network %s: %w", field, k, err)
+			}
+			if _, set := settings[key]; !set && value != "" {
+				settings[key] = value
+			}
+		}
+		if !moved {
+			return nil
+		}
+		if len(settings) > 0 {
+			data, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			record["settings"] = data
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network %s: %w", k, err)
+		}
+		updated[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, data := range updated {
+		if err := networksBucket.Put([]byte(id), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestMigrations_BackfillLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+
+	network, err := sm.CreateNetwork("legacy", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	if _, err := sm.CreateNode(network.ID, "node1", "1.2.3.4", 51820, "10.0.0.2", NodeTypePeer, "priv", "pub"); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, err := sm.SaveConfigVersion(network.ID, "hash", map[string]string{"node1": "cfg"}); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	// Simulate a database written before the indexes and versioning existed.
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{BucketConfigsByVer, BucketNodesByNetwork, BucketMeta} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to strip indexes: %v", err)
+	}
+	sm.Close()
+
+	sm, err = NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() on legacy database error = %v", err)
+	}
+	defer sm.Close()
+
+	version, err := sm.SchemaVersion()
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d (err %v), want %d", version, err, LatestSchemaVersion())
+	}
+	nodes, err := sm.ListNodesByNetworkID(network.ID)
+	if err != nil || len(nodes) != 1 {
+		t.Errorf("ListNodesByNetworkID() = %d nodes (err %v), want 1", len(nodes), err)
+	}
+	latest, err := sm.GetLatestConfigVersion(network.ID)
+	if err != nil || latest.Version != 1 {
+		t.Errorf("GetLatestConfigVersion() = %+v (err %v), want version 1", latest, err)
+	}
+
+	states, err := sm.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if len(states) != LatestSchemaVersion() {
+		t.Fatalf("MigrationStatus() returned %d entries, want %d", len(states), LatestSchemaVersion())
+	}
+	for _, s := range states {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("migration %d = %+v, want applied with a timestamp", s.Version, s)
+		}
+	}
+}
+
+func TestMigrations_RefuseNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		newer := strconv.Itoa(LatestSchemaVersion() + 1)
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(metaKeySchemaVersion), []byte(newer))
+	}); err != nil {
+		t.Fatalf("failed to bump schema version: %v", err)
+	}
+	sm.Close()
+
+	if _, err := NewStorageManager(dbPath); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("NewStorageManager() error = %v, want newer-schema refusal", err)
+	}
+
+	// ReadSchemaVersion still reads it, for 'wedevctl version' to report.
+	if version, err := ReadSchemaVersion(context.Background(), dbPath, time.Second); err != nil || version != LatestSchemaVersion()+1 {
+		t.Errorf("ReadSchemaVersion() = %d (err %v), want %d", version, err, LatestSchemaVersion()+1)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := ReadSchemaVersion(context.Background(), missing, time.Second); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadSchemaVersion() of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadSchemaVersion() created %s", missing)
+	}
+}
+
+func TestMigrations_RegistryIsConsecutive(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migrations[%d].Version = %d, want %d", i, m.Version, i+1)
+		}
+		if m.Up == nil || m.Description == "" {
+			t.Errorf("migration %d is missing Up or Description", m.Version)
+		}
+	}
+}
+
+func TestMigrations_MoveNetworkSettings(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	network, err := sm.CreateNetwork("legacy", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+
+	// Simulate a network record written before migration 10.
+	legacy := `{"id":"` + network.ID + `","name":"legacy","cidr":"10.0.0.0/24","dns":["10.0.0.1","1.1.1.1"],` +
+		`"topology":"mesh","filename_template":"wg-{{.Entity}}.conf","settings":{"mtu":"1380"}}`
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(BucketNetworks)).Put([]byte(network.ID), []byte(legacy)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(metaKeySchemaVersion), []byte("9"))
+	}); err != nil {
+		t.Fatalf("failed to write legacy network: %v", err)
+	}
+	sm.Close()
+
+	sm, err = NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() on legacy database error = %v", err)
+	}
+	defer sm.Close()
+
+	got, err := sm.GetNetworkByID(network.ID)
+	if err != nil {
+		t.Fatalf("GetNetworkByID() error = %v", err)
+	}
+	want := map[string]string{SettingMTU: "1380", SettingDNS: "10.0.0.1,1.1.1.1", SettingTopology: "mesh", SettingFilenameTemplate: "wg-{{.Entity}}.conf"}
+	if len(got.Settings) != len(want) {
+		t.Errorf("Settings = %v, want %v", got.Settings, want)
+	}
+	for key, value := range want {
+		if got.Settings[key] != value {
+			t.Errorf("Settings[%s] = %q, want %q", key, got.Settings[key], value)
+		}
+	}
+	if err := sm.db.View(func(tx *bbolt.Tx) error {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(tx.Bucket([]byte(BucketNetworks)).Get([]byte(network.ID)), &record); err != nil {
+			return err
+		}
+		for field := range movedNetworkFields {
+			if _, ok := record[field]; ok {
+				t.Errorf("migrated record still has %s", field)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,368 @@
+package wedev
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// newMultiServerNetwork creates network "multi" with servers hub1 and hub2.
+func newMultiServerNetwork(t *testing.T) (*VirtualNetworkManager, *StorageManager) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("multi", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("multi", "hub1", "vpn1.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer(hub1) error = %v", err)
+	}
+	if _, err := vnm.CreateServer("multi", "hub2", "vpn2.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer(hub2) error = %v", err)
+	}
+	return vnm, sm
+}
+
+// peerKeys returns the PublicKey lines of a config's [Peer] sections.
+func peerKeys(config string) []string {
+	var keys []string
+	for _, line := range strings.Split(config, "\n") {
+		if key, ok := strings.CutPrefix(line, "PublicKey = "); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func hasPeer(config, publicKey string) bool {
+	for _, key := range peerKeys(config) {
+		if key == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMultipleServers_Create(t *testing.T) {
+	vnm, _ := newMultiServerNetwork(t)
+
+	servers, err := vnm.ListServers("multi")
+	if err != nil {
+		t.Fatalf("ListServers() error = %v", err)
+	}
+	if len(servers) != 2 || servers[0].Name != "hub1" || servers[1].Name != "hub2" {
+		t.Fatalf("ListServers() = %v, want [hub1 hub2]", servers)
+	}
+	if servers[0].VirtualIP != "10.0.0.1" {
+		t.Errorf("first server IP = %s, want the reserved 10.0.0.1", servers[0].VirtualIP)
+	}
+	if servers[1].VirtualIP == servers[0].VirtualIP {
+		t.Errorf("second server reused IP %s", servers[1].VirtualIP)
+	}
+
+	if _, err := vnm.GetServer("multi", ""); err == nil {
+		t.Error("GetServer() without a name succeeded with two servers")
+	}
+	if server, err := vnm.GetServer("multi", "hub2"); err != nil || server.Name != "hub2" {
+		t.Errorf("GetServer(hub2) = %v, %v", server, err)
+	}
+
+	report, err := vnm.AuditIPPool("multi")
+	if err != nil {
+		t.Fatalf("AuditIPPool() error = %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() issues = %+v, want none", report.Issues)
+	}
+}
+
+func TestMultipleServers_GenerateConfigs(t *testing.T) {
+	vnm, sm := newMultiServerNetwork(t)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := vnm.CreateNode("multi", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.AssignNodeServer("multi", "b", "hub2", false); err != nil {
+		t.Fatalf("AssignNodeServer(b) error = %v", err)
+	}
+	if _, err := vnm.AssignNodeServer("multi", "c", "hub2", true); err != nil {
+		t.Fatalf("AssignNodeServer(c) error = %v", err)
+	}
+	if _, err := vnm.AssignNodeServer("multi", "a", "nope", false); err == nil {
+		t.Error("AssignNodeServer() to an unknown server succeeded")
+	}
+
+	configs, _, err := NewWireGuardConfigGenerator(sm).GenerateConfigs("multi", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	key := func(name string) string {
+		t.Helper()
+		if node, err := vnm.GetNode("multi", name); err == nil {
+			return node.PublicKey
+		}
+		server, err := vnm.GetServer("multi", name)
+		if err != nil {
+			t.Fatalf("no entity %s", name)
+		}
+		return server.PublicKey
+	}
+
+	tests := []struct {
+		config string
+		want   []string
+		absent []string
+	}{
+		{"hub1", []string{"a", "c", "hub2"}, []string{"b"}},
+		{"hub2", []string{"b", "c", "hub1"}, []string{"a"}},
+		{"a", []string{"hub1"}, []string{"hub2"}},
+		{"b", []string{"hub2"}, []string{"hub1"}},
+		{"c", []string{"hub1", "hub2"}, nil},
+	}
+	for _, tt := range tests {
+		config, ok := configs[tt.config]
+		if !ok {
+			t.Fatalf("no config generated for %s", tt.config)
+		}
+		for _, peer := range tt.want {
+			if !hasPeer(config, key(peer)) {
+				t.Errorf("%s config has no peer %s:\n%s", tt.config, peer, config)
+			}
+		}
+		for _, peer := range tt.absent {
+			if hasPeer(config, key(peer)) {
+				t.Errorf("%s config peers with %s:\n%s", tt.config, peer, config)
+			}
+		}
+	}
+
+	// hub1 reaches b through hub2, so hub2's peer carries b's address.
+	b, _ := vnm.GetNode("multi", "b")
+	if !strings.Contains(configs["hub1"], b.VirtualIP+"/32") {
+		t.Errorf("hub1 config does not route %s via hub2:\n%s", b.VirtualIP, configs["hub1"])
+	}
+}
+
+func TestDeleteServer_ReassignsNodes(t *testing.T) {
+	vnm, sm := newMultiServerNetwork(t)
+
+	if _, err := vnm.CreateNode("multi", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, err := vnm.AssignNodeServer("multi", "a", "hub2", false); err != nil {
+		t.Fatalf("AssignNodeServer() error = %v", err)
+	}
+	hub2, err := vnm.GetServer("multi", "hub2")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+
+	if err := vnm.DeleteServer("multi", DeleteServerOptions{Name: "hub2", KeepNodes: true}); err != nil {
+		t.Fatalf("DeleteServer() error = %v", err)
+	}
+
+	node, err := vnm.GetNode("multi", "a")
+	if err != nil {
+		t.Fatalf("GetNode() error = %v", err)
+	}
+	if node.ServerID != "" {
+		t.Errorf("node ServerID = %q after its server was deleted, want empty", node.ServerID)
+	}
+	state, err := sm.GetIPPoolState(node.NetworkID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	for _, ip := range state.Allocated {
+		if ip == hub2.VirtualIP {
+			t.Errorf("deleted server IP %s is still allocated", ip)
+		}
+	}
+	if server, err := vnm.GetServer("multi", ""); err != nil || server.Name != "hub1" {
+		t.Errorf("GetServer() = %v, %v, want the remaining hub1", server, err)
+	}
+}
+
+func TestDeleteServer_RefusesWithDependents(t *testing.T) {
+	vnm, _ := newMultiServerNetwork(t)
+	// a falls back to hub1 as the first server; b is assigned to hub2.
+	for _, name := range []string{"a", "b"} {
+		if _, err := vnm.CreateNode("multi", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if _, err := vnm.AssignNodeServer("multi", "b", "hub2", false); err != nil {
+		t.Fatalf("AssignNodeServer() error = %v", err)
+	}
+
+	dependents, err := vnm.ServerDependents("multi", "hub1")
+	if err != nil {
+		t.Fatalf("ServerDependents() error = %v", err)
+	}
+	if len(dependents) != 1 || dependents[0].Name != "a" {
+		t.Errorf("ServerDependents(hub1) = %v, want the falling back node a", dependents)
+	}
+
+	err = vnm.DeleteServer("multi", DeleteServerOptions{Name: "hub2"})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("DeleteServer() error = %v, want ErrValidation", err)
+	}
+	for _, want := range []string{`server "hub2" has 1 node(s) depending on it (b)`, `move to server "hub1"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("DeleteServer() error = %v, want it to contain %q", err, want)
+		}
+	}
+	if _, err := vnm.GetServer("multi", "hub2"); err != nil {
+		t.Errorf("GetServer(hub2) error = %v, want the refused server kept", err)
+	}
+
+	err = vnm.DeleteServer("multi", DeleteServerOptions{Name: "hub2", Cascade: true, KeepNodes: true})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("DeleteServer(cascade and keep-nodes) error = %v, want ErrValidation", err)
+	}
+
+	// Without dependents, no option is needed.
+	if _, err := vnm.CreateServer("multi", "hub3", "vpn3.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer(hub3) error = %v", err)
+	}
+	if err := vnm.DeleteServer("multi", DeleteServerOptions{Name: "hub3"}); err != nil {
+		t.Errorf("DeleteServer(hub3) error = %v", err)
+	}
+}
+
+func TestDeleteServer_Cascade(t *testing.T) {
+	vnm, sm := newMultiServerNetwork(t)
+	nodes := map[string]*Node{}
+	for _, name := range []string{"a", "b", "c"} {
+		node, err := vnm.CreateNode("multi", name, "", 0, NodeTypeRoute)
+		if err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+		nodes[name] = node
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := vnm.AssignNodeServer("multi", name, "hub2", false); err != nil {
+			t.Fatalf("AssignNodeServer(%s) error = %v", name, err)
+		}
+	}
+	hub2, err := vnm.GetServer("multi", "hub2")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+
+	if err := vnm.DeleteServer("multi", DeleteServerOptions{Name: "hub2", Cascade: true}); err != nil {
+		t.Fatalf("DeleteServer() error = %v", err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := vnm.GetNode("multi", name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetNode(%s) error = %v, want ErrNotFound after the cascade", name, err)
+		}
+	}
+	if _, err := vnm.GetNode("multi", "c"); err != nil {
+		t.Errorf("GetNode(c) error = %v, want hub1's node kept", err)
+	}
+
+	state, err := sm.GetIPPoolState(nodes["c"].NetworkID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	for _, ip := range []string{hub2.VirtualIP, nodes["a"].VirtualIP, nodes["b"].VirtualIP} {
+		if slices.Contains(state.Allocated, ip) {
+			t.Errorf("pool state allocated = %v, want %s released", state.Allocated, ip)
+		}
+	}
+	if !slices.Contains(state.Allocated, nodes["c"].VirtualIP) {
+		t.Errorf("pool state allocated = %v, want c's %s kept", state.Allocated, nodes["c"].VirtualIP)
+	}
+
+	// The released addresses are handed out again.
+	node, err := vnm.CreateNode("multi", "d", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(d) error = %v", err)
+	}
+	if node.VirtualIP != hub2.VirtualIP {
+		t.Errorf("new node got %s, want the released %s", node.VirtualIP, hub2.VirtualIP)
+	}
+}
+
+func TestMigrations_RekeyServersByNetwork(t *testing.T) {
+	vnm, sm := newMultiServerNetwork(t)
+	network, err := vnm.GetVirtualNetwork("multi")
+	if err != nil {
+		t.Fatalf("GetVirtualNetwork() error = %v", err)
+	}
+	hub1, err := vnm.GetServer("multi", "hub1")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+
+	// Rewrite the index the way schema 2 kept it: one entry per network.
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(BucketServersByNetwork)); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(BucketServersByNetwork))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(network.ID), []byte(hub1.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(metaKeySchemaVersion), []byte("2"))
+	}); err != nil {
+		t.Fatalf("failed to write legacy index: %v", err)
+	}
+
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		return runMigrations(tx, sm.Logger())
+	}); err != nil {
+		t.Fatalf("runMigrations() error = %v", err)
+	}
+
+	servers, err := sm.ListServersByNetworkID(network.ID)
+	if err != nil {
+		t.Fatalf("ListServersByNetworkID() error = %v", err)
+	}
+	if len(servers) != 2 {
+		t.Errorf("ListServersByNetworkID() = %d servers, want 2", len(servers))
+	}
+	if err := sm.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(BucketServersByNetwork)).Get([]byte(network.ID)) != nil {
+			t.Error("legacy networkID index key survived the migration")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+}
+
+func TestListServersEmpty(t *testing.T) {
+	bolt, _ := newTestManager(t)
+	memory, _ := newMemoryTestManager(t)
+	for name, vnm := range map[string]*VirtualNetworkManager{"bolt": bolt, "memory": memory} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := vnm.CreateVirtualNetwork("empty", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			servers, err := vnm.ListServers("empty")
+			if err != nil || len(servers) != 0 {
+				t.Errorf("ListServers() = %v, %v; want no servers and no error", servers, err)
+			}
+			if _, err := vnm.ListServers("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("ListServers(missing) error = %v, want ErrNotFound", err)
+			}
+
+			// A serverless network resizes without saving a config version.
+			if _, version, err := vnm.ResizeNetwork("empty", "10.0.0.0/23"); err != nil || version != nil {
+				t.Errorf("ResizeNetwork() = %v, %v; want no version", version, err)
+			}
+			if err := vnm.DeleteVirtualNetwork("empty"); err != nil {
+				t.Errorf("DeleteVirtualNetwork() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wedevctl/util"
+)
+
+// ErrNativeUnsupported is returned by NewNativeClient where wedevctl cannot
+// configure WireGuard devices itself: on platforms other than Linux, and on
+// kernels without WireGuard.
+var ErrNativeUnsupported = errors.New("native WireGuard configuration is not supported")
+
+// NativePeer is a peer of a WireGuard device.
+type NativePeer struct {
+	PublicKey           string // base64, as in configs
+	PresharedKey        string // base64; empty for none
+	Endpoint            netip.AddrPort
+	PersistentKeepalive time.Duration
+	AllowedIPs          []netip.Prefix
+}
+
+// NativeDevice is the state of a WireGuard device as the kernel reports it.
+type NativeDevice struct {
+	Name         string
+	PrivateKey   string // base64
+	ListenPort   int
+	FirewallMark int
+	Peers        []NativePeer
+}
+
+// NativePeerConfig is a change to one peer of a device: Remove deletes it,
+// otherwise it is added or updated, replacing its allowed IPs.
+type NativePeerConfig struct {
+	NativePeer
+	Remove bool
+}
+
+// NativeDeviceConfig is a change to a device, as wgctrl's wgtypes.Config:
+// nil fields are left as they are, and peers not listed are kept.
+type NativeDeviceConfig struct {
+	PrivateKey   *string
+	ListenPort   *int
+	FirewallMark *int
+	Peers        []NativePeerConfig
+}
+
+// NativeClient reads and configures WireGuard devices in the kernel. It
+// covers the part of wgctrl's Client the sync uses, plus creating a device,
+// so tests can substitute a fake.
+type NativeClient interface {
+	// Device returns the device named name, or an error matching
+	// fs.ErrNotExist when there is none.
+	Device(name string) (*NativeDevice, error)
+	// CreateDevice creates a WireGuard device named name and sets it up.
+	CreateDevice(name string) error
+	ConfigureDevice(name string, cfg NativeDeviceConfig) error
+	Close() error
+}
+
+// Resolver looks up the addresses of an endpoint host name.
+type Resolver func(ctx context.Context, host string) ([]netip.Addr, error)
+
+// NativeSyncPlan is the delta that brings a device in line with an entity's
+// generated config.
+type NativeSyncPlan struct {
+	Network   string
+	Entity    string
+	Interface string
+	Create    bool // the device does not exist yet
+	// Changes describe the delta, one line each; empty when the device
+	// already matches.
+	Changes []string
+	// Skipped are the [Interface] keys the native path leaves to the system,
+	// such as Address and DNS, which wg-quick would otherwise set up.
+	Skipped []string
+	Delta   NativeDeviceConfig
+	config  string
+}
+
+// NativeSyncer configures the local WireGuard device of a server or node
+// directly, without wg-quick or config files: it compares the device with
+// the generated config and applies only the difference.
+type NativeSyncer struct {
+	generator *WireGuardConfigGenerator
+	client    NativeClient
+	resolve   Resolver
+	geteuid   func() int
+}
+
+// NewNativeSyncer creates a NativeSyncer configuring devices through client.
+func NewNativeSyncer(storage Storage, client NativeClient) *NativeSyncer {
+	return &NativeSyncer{
+		generator: NewWireGuardConfigGenerator(storage),
+		client:    client,
+		resolve: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+		geteuid: os.Geteuid,
+	}
+}
+
+// Plan generates the config of one entity of a network and compares it with
+// the device iface (the network name when empty). Reading the device needs
+// the same privileges as changing it.
+func (ns *NativeSyncer) Plan(ctx context.Context, networkName, entityName, iface string) (*NativeSyncPlan, error) {
+	if iface == "" {
+		iface = networkName
+	}
+	if !interfaceNamePattern.MatchString(iface) {
+		return nil, kindErrorf(ErrValidation, "invalid interface name %q (at most 15 letters, digits, or _=+.-); use --interface", iface)
+	}
+	if err := ns.checkRoot(); err != nil {
+		return nil, err
+	}
+	config, err := ns.generator.GenerateConfigCtx(ctx, networkName, entityName)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := ns.desiredDevice(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := ns.client.Device(iface)
+	create := errors.Is(err, fs.ErrNotExist)
+	if create {
+		current = &NativeDevice{Name: iface}
+	} else if err != nil {
+		return nil, nativeError("read device "+iface, err)
+	}
+
+	plan := diffNativeDevice(current, desired)
+	plan.Network, plan.Entity, plan.Interface, plan.Create = networkName, entityName, iface, create
+	plan.config = config
+	if create {
+		plan.Changes = append([]string{"create device " + iface}, plan.Changes...)
+	}
+	return plan, nil
+}
+
+// Apply creates the device if needed and applies the planned delta, then
+// records the deployment. A plan without changes only records it.
+func (ns *NativeSyncer) Apply(ctx context.Context, plan *NativeSyncPlan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ns.checkRoot(); err != nil {
+		return err
+	}
+	if plan.Create {
+		if err := ns.client.CreateDevice(plan.Interface); err != nil {
+			return nativeError("create device "+plan.Interface, err)
+		}
+	}
+	if len(plan.Changes) > 0 {
+		if err := ns.client.ConfigureDevice(plan.Interface, plan.Delta); err != nil {
+			return nativeError("configure device "+plan.Interface, err)
+		}
+	}
+	if _, err := ns.generator.RecordDeploymentCtx(ctx, plan.Network, plan.Entity, plan.config); err != nil {
+		return fmt.Errorf("device configured, but recording the deployment failed: %w", err)
+	}
+	return nil
+}
+
+func (ns *NativeSyncer) checkRoot() error {
+	if ns.geteuid() != 0 {
+		return fmt.Errorf("configuring WireGuard devices natively requires root privileges (CAP_NET_ADMIN); try sudo")
+	}
+	return nil
+}
+
+// nativeError wraps an error of the client, with a hint when it was
+// refused for lack of privileges.
+func nativeError(action string, err error) error {
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("failed to %s: %w (needs CAP_NET_ADMIN; try sudo)", action, err)
+	}
+	return fmt.Errorf("failed to %s: %w", action, err)
+}
+
+// desiredPeer is a peer as a config asks for it, named by the comment above
+// its [Peer] section when there is one.
+type desiredPeer struct {
+	NativePeer
+	Name string
+}
+
+// desiredState is the device a config asks for.
+type desiredState struct {
+	device  NativeDevice
+	names   map[string]string // peer public key -> name
+	skipped []string
+}
+
+// desiredDevice parses a generated config into the device state it asks
+// for, resolving endpoint host names.
+func (ns *NativeSyncer) desiredDevice(ctx context.Context, config string) (*desiredState, error) {
+	sections, err := util.ParseWireGuardConfig(config)
+	if err != nil {
+		return nil, kindErrorf(ErrValidation, "failed to parse config: %v", err)
+	}
+	if len(sections) == 0 || sections[0].Name != "Interface" {
+		return nil, kindErrorf(ErrValidation, "config does not start with an [Interface] section")
+	}
+	lines := strings.Split(config, "\n")
+	desired := &desiredState{names: map[string]string{}}
+
+	for _, entry := range sections[0].Entries {
+		switch strings.ToLower(entry.Key) {
+		case "privatekey":
+			desired.device.PrivateKey = entry.Value
+		case "listenport", "fwmark":
+			n, err := strconv.Atoi(entry.Value)
+			if err != nil {
+				return nil, kindErrorf(ErrValidation, "line %d: invalid %s %q", entry.Line, entry.Key, entry.Value)
+			}
+			if strings.EqualFold(entry.Key, "listenport") {
+				desired.device.ListenPort = n
+			} else {
+				desired.device.FirewallMark = n
+			}
+		default:
+			if !slices.Contains(desired.skipped, entry.Key) {
+				desired.skipped = append(desired.skipped, entry.Key)
+			}
+		}
+	}
+
+	for _, section := range sections[1:] {
+		if section.Name != "Peer" {
+			return nil, kindErrorf(ErrValidation, "line %d: unexpected [%s] section", section.Line, section.Name)
+		}
+		var peer desiredPeer
+		if above := section.Line - 2; above >= 0 && strings.HasPrefix(lines[above], "# ") {
+			peer.Name = strings.TrimPrefix(lines[above], "# ")
+		}
+		for _, entry := range section.Entries {
+			switch strings.ToLower(entry.Key) {
+			case "publickey":
+				peer.PublicKey = entry.Value
+			case "presharedkey":
+				peer.PresharedKey = entry.Value
+			case "endpoint":
+				if peer.Endpoint, err = ns.resolveEndpoint(ctx, entry.Value); err != nil {
+					return nil, err
+				}
+			case "persistentkeepalive":
+				seconds, err := strconv.Atoi(entry.Value)
+				if err != nil {
+					return nil, kindErrorf(ErrValidation, "line %d: invalid PersistentKeepalive %q", entry.Line, entry.Value)
+				}
+				peer.PersistentKeepalive = time.Duration(seconds) * time.Second
+			case "allowedips":
+				for _, value := range splitList(entry.Value) {
+					prefix, err := netip.ParsePrefix(value)
+					if err != nil {
+						return nil, kindErrorf(ErrValidation, "line %d: invalid AllowedIPs entry %q", entry.Line, value)
+					}
+					peer.AllowedIPs = append(peer.AllowedIPs, prefix.Masked())
+				}
+			default:
+				return nil, kindErrorf(ErrValidation, "line %d: unknown peer key %s", entry.Line, entry.Key)
+			}
+		}
+		desired.device.Peers = append(desired.device.Peers, peer.NativePeer)
+		desired.names[peer.PublicKey] = peer.Name
+	}
+	return desired, nil
+}
+
+// resolveEndpoint turns an Endpoint value into an address and port,
+// looking up a host name and preferring its first IPv4 address.
+func (ns *NativeSyncer) resolveEndpoint(ctx context.Context, endpoint string) (netip.AddrPort, error) {
+	if addrPort, err := netip.ParseAddrPort(endpoint); err == nil {
+		return addrPort, nil
+	}
+	host, portText, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return netip.AddrPort{}, kindErrorf(ErrValidation, "invalid endpoint %q: %v", endpoint, err)
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, kindErrorf(ErrValidation, "invalid endpoint port in %q", endpoint)
+	}
+	addrs, err := ns.resolve(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return netip.AddrPort{}, fmt.Errorf("failed to resolve endpoint %s: %v", host, err)
+	}
+	addr := addrs[0]
+	for _, candidate := range addrs {
+		if candidate.Unmap().Is4() {
+			addr = candidate
+			break
+		}
+	}
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// diffNativeDevice works out the delta from current to desired: the keys and
+// ports that differ, and the peers to add, update, or remove.
+func diffNativeDevice(current *NativeDevice, desired *desiredState) *NativeSyncPlan {
+	plan := &NativeSyncPlan{Skipped: desired.skipped}
+	want := desired.device
+
+	if want.PrivateKey != current.PrivateKey {
+		plan.Delta.PrivateKey = &want.PrivateKey
+		plan.Changes = append(plan.Changes, "set private key")
+	}
+	if want.ListenPort != 0 && want.ListenPort != current.ListenPort {
+		plan.Delta.ListenPort = &want.ListenPort
+		plan.Changes = append(plan.Changes, fmt.Sprintf("set listen port %d (was %d)", want.ListenPort, current.ListenPort))
+	}
+	if want.FirewallMark != current.FirewallMark {
+		plan.Delta.FirewallMark = &want.FirewallMark
+		plan.Changes = append(plan.Changes, fmt.Sprintf("set firewall mark %d (was %d)", want.FirewallMark, current.FirewallMark))
+	}
+
+	have := make(map[string]NativePeer, len(current.Peers))
+	for _, peer := range current.Peers {
+		have[peer.PublicKey] = peer
+	}
+	for _, peer := range want.Peers {
+		label := peerLabel(desired.names[peer.PublicKey], peer.PublicKey)
+		old, ok := have[peer.PublicKey]
+		delete(have, peer.PublicKey)
+		if !ok {
+			plan.Delta.Peers = append(plan.Delta.Peers, NativePeerConfig{NativePeer: peer})
+			plan.Changes = append(plan.Changes, "add peer "+label)
+			continue
+		}
+		if fields := peerDifferences(old, peer); len(fields) > 0 {
+			plan.Delta.Peers = append(plan.Delta.Peers, NativePeerConfig{NativePeer: peer})
+			plan.Changes = append(plan.Changes, fmt.Sprintf("update peer %s: %s", label, strings.Join(fields, ", ")))
+		}
+	}
+	for _, peer := range current.Peers {
+		if _, stale := have[peer.PublicKey]; stale {
+			plan.Delta.Peers = append(plan.Delta.Peers, NativePeerConfig{NativePeer: NativePeer{PublicKey: peer.PublicKey}, Remove: true})
+			plan.Changes = append(plan.Changes, "remove peer "+peer.PublicKey)
+		}
+	}
+	return plan
+}
+
+// peerLabel names a peer by its config comment and public key.
+func peerLabel(name, publicKey string) string {
+	if name == "" {
+		return publicKey
+	}
+	return name + " " + publicKey
+}
+
+// peerDifferences lists the fields in which two versions of a peer differ.
+// An endpoint is only compared when desired sets one: the kernel learns
+// endpoints of roaming peers by itself.
+func peerDifferences(current, desired NativePeer) []string {
+	var fields []string
+	if current.PresharedKey != desired.PresharedKey {
+		fields = append(fields, "preshared key")
+	}
+	if desired.Endpoint.IsValid() && current.Endpoint != desired.Endpoint {
+		fields = append(fields, "endpoint "+desired.Endpoint.String())
+	}
+	if current.PersistentKeepalive != desired.PersistentKeepalive {
+		fields = append(fields, fmt.Sprintf("keepalive %s", desired.PersistentKeepalive))
+	}
+	if !samePrefixes(current.AllowedIPs, desired.AllowedIPs) {
+		fields = append(fields, "allowed IPs "+joinPrefixes(desired.AllowedIPs))
+	}
+	return fields
+}
+
+// samePrefixes reports whether a and b hold the same prefixes in any order.
+func samePrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := func(prefixes []netip.Prefix) []string {
+		s := make([]string, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			s = append(s, prefix.Masked().String())
+		}
+		slices.Sort(s)
+		return s
+	}
+	return slices.Equal(sorted(a), sorted(b))
+}
+
+// joinPrefixes joins prefixes as a config's AllowedIPs value.
+func joinPrefixes(prefixes []netip.Prefix) string {
+	s := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		s = append(s, prefix.String())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,464 @@
+package wedev
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// genlHeaderLen is the size of struct genlmsghdr: command, version and two
+// reserved bytes.
+const genlHeaderLen = 4
+
+// netlinkAttrTypeMask strips the nested and byte-order flags from an
+// attribute type.
+const netlinkAttrTypeMask = ^uint16(unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+
+// genlConfigurer is the deviceConfigurer speaking the kernel's WireGuard
+// generic netlink protocol, the one wg(8) and wgctrl use.
+type genlConfigurer struct {
+	fd     int
+	family uint16 // generic netlink family ID of "wireguard"
+	seq    uint32
+}
+
+// newDeviceConfigurer opens a generic netlink socket and looks up the
+// WireGuard family. A kernel without WireGuard is ErrNativeUnsupported.
+func newDeviceConfigurer() (deviceConfigurer, error) {
+	fd, err := openNetlink(unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	c := &genlConfigurer{fd: fd}
+	family, err := c.resolveFamily(unix.WG_GENL_NAME)
+	if errors.Is(err, unix.ENOENT) {
+		//nolint:errcheck // Nothing to do about a failed close of the socket
+		_ = c.Close()
+		return nil, fmt.Errorf("%w: the kernel has no WireGuard support; is the wireguard kernel module loaded? (modprobe wireguard)", ErrNativeUnsupported)
+	}
+	if err != nil {
+		//nolint:errcheck // Nothing to do about a failed close of the socket
+		_ = c.Close()
+		return nil, err
+	}
+	c.family = family
+	return c, nil
+}
+
+// resolveFamily returns the ID of the generic netlink family name.
+func (c *genlConfigurer) resolveFamily(name string) (uint16, error) {
+	attrs := appendNetlinkAttr(nil, unix.CTRL_ATTR_FAMILY_NAME, append([]byte(name), 0))
+	replies, err := c.request(unix.GENL_ID_CTRL, unix.CTRL_CMD_GETFAMILY, 1, unix.NLM_F_ACK, attrs, "CTRL_CMD_GETFAMILY")
+	if err != nil {
+		return 0, err
+	}
+	for _, reply := range replies {
+		attrs, err := parseNetlinkAttrs(reply[genlHeaderLen:])
+		if err != nil {
+			return 0, err
+		}
+		for _, attr := range attrs {
+			if attr.typ == unix.CTRL_ATTR_FAMILY_ID && len(attr.data) >= 2 {
+				return binary.NativeEndian.Uint16(attr.data), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no family ID in the netlink reply for %s", name)
+}
+
+// request sends a generic netlink command and returns the payloads of the
+// replies, each starting with its genlmsghdr.
+func (c *genlConfigurer) request(family uint16, cmd, version uint8, flags uint16, attrs []byte, op string) ([][]byte, error) {
+	c.seq++
+	msg := make([]byte, unix.NLMSG_HDRLEN+genlHeaderLen, unix.NLMSG_HDRLEN+genlHeaderLen+len(attrs))
+	binary.NativeEndian.PutUint16(msg[4:6], family)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], c.seq)
+	msg[unix.NLMSG_HDRLEN] = cmd
+	msg[unix.NLMSG_HDRLEN+1] = version
+	msg = append(msg, attrs...)
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	return netlinkRequest(c.fd, msg, op)
+}
+
+// Device reads the device named name with WG_CMD_GET_DEVICE. A missing
+// device is an error matching fs.ErrNotExist.
+func (c *genlConfigurer) Device(name string) (*NativeDevice, error) {
+	attrs := appendNetlinkAttr(nil, unix.WGDEVICE_A_IFNAME, append([]byte(name), 0))
+	replies, err := c.request(c.family, unix.WG_CMD_GET_DEVICE, unix.WG_GENL_VERSION, unix.NLM_F_DUMP, attrs, "WG_CMD_GET_DEVICE")
+	if errors.Is(err, unix.ENODEV) {
+		return nil, fmt.Errorf("device %s: %w", name, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseWireGuardDevice(replies)
+}
+
+// ConfigureDevice applies cfg to the device named name with
+// WG_CMD_SET_DEVICE, in as many requests as its peers need. Requests already
+// applied stay applied when a later one fails, as with wg(8).
+func (c *genlConfigurer) ConfigureDevice(name string, cfg NativeDeviceConfig) error {
+	requests, err := wireGuardSetAttrs(name, cfg, wireGuardSetLimit)
+	if err != nil {
+		return err
+	}
+	for _, attrs := range requests {
+		if _, err := c.request(c.family, unix.WG_CMD_SET_DEVICE, unix.WG_GENL_VERSION, unix.NLM_F_ACK, attrs, "WG_CMD_SET_DEVICE"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *genlConfigurer) Close() error {
+	return unix.Close(c.fd)
+}
+
+// wireGuardSetLimit bounds the attributes of one WG_CMD_SET_DEVICE request
+// so the whole message fits in a page, as wg(8) keeps it. The peers
+// attribute's 16-bit length could not describe a much larger one anyway.
+const wireGuardSetLimit = 4096 - unix.NLMSG_HDRLEN - genlHeaderLen
+
+// wireGuardSetAttrs encodes the attributes of the WG_CMD_SET_DEVICE requests
+// applying cfg to device name, each at most limit bytes. Peers are updated in
+// place, replacing their allowed IPs; peers not in cfg are kept. Peers that
+// do not fit in a request go on in the next one, and a peer whose allowed IPs
+// do not fit is continued there under its public key alone, which adds to
+// the allowed IPs the previous request set.
+func wireGuardSetAttrs(name string, cfg NativeDeviceConfig, limit int) ([][]byte, error) {
+	ifname := appendNetlinkAttr(nil, unix.WGDEVICE_A_IFNAME, append([]byte(name), 0))
+	attrs := slices.Clone(ifname)
+	if cfg.PrivateKey != nil {
+		key, err := decodeWireGuardKey(*cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		attrs = appendNetlinkAttr(attrs, unix.WGDEVICE_A_PRIVATE_KEY, key)
+	}
+	if cfg.ListenPort != nil {
+		attrs = appendNetlinkAttr(attrs, unix.WGDEVICE_A_LISTEN_PORT, binary.NativeEndian.AppendUint16(nil, uint16(*cfg.ListenPort)))
+	}
+	if cfg.FirewallMark != nil {
+		attrs = appendNetlinkAttr(attrs, unix.WGDEVICE_A_FWMARK, binary.NativeEndian.AppendUint32(nil, uint32(*cfg.FirewallMark)))
+	}
+	if len(cfg.Peers) == 0 {
+		return [][]byte{attrs}, nil
+	}
+
+	var requests [][]byte
+	var peers []byte // WGDEVICE_A_PEERS entries of the request being filled
+	entries := 0
+	flush := func() {
+		requests = append(requests, appendNetlinkAttr(attrs, unix.NLA_F_NESTED|unix.WGDEVICE_A_PEERS, peers))
+		attrs, peers, entries = slices.Clone(ifname), nil, 0
+	}
+	for _, peer := range cfg.Peers {
+		head, err := wireGuardPeerHead(peer)
+		if err != nil {
+			return nil, err
+		}
+		allowed := wireGuardAllowedIPs(peer.AllowedIPs)
+		for {
+			// The peer entry and its allowed IPs attribute each add a header.
+			room := limit - len(attrs) - unix.SizeofRtAttr - len(peers) - 2*unix.SizeofRtAttr - len(head)
+			n := 0
+			for size := 0; n < len(allowed) && size+unix.SizeofRtAttr+len(allowed[n]) <= room; n++ {
+				size += unix.SizeofRtAttr + len(allowed[n])
+			}
+			if room < 0 || (n == 0 && len(allowed) > 0) {
+				if entries == 0 {
+					return nil, fmt.Errorf("peer %q does not fit in a netlink message", peer.PublicKey)
+				}
+				flush()
+				continue
+			}
+
+			p := slices.Clone(head)
+			if !peer.Remove {
+				var list []byte
+				for i, a := range allowed[:n] {
+					list = appendNetlinkAttr(list, unix.NLA_F_NESTED|uint16(i), a)
+				}
+				p = appendNetlinkAttr(p, unix.NLA_F_NESTED|unix.WGPEER_A_ALLOWEDIPS, list)
+			}
+			peers = appendNetlinkAttr(peers, unix.NLA_F_NESTED|uint16(entries), p)
+			entries++
+			if allowed = allowed[n:]; len(allowed) == 0 {
+				break
+			}
+			flush()
+			head = appendNetlinkAttr(nil, unix.WGPEER_A_PUBLIC_KEY, head[unix.SizeofRtAttr:unix.SizeofRtAttr+unix.WG_KEY_LEN])
+		}
+	}
+	flush()
+	return requests, nil
+}
+
+// wireGuardPeerHead encodes the attributes of a peer entry that come before
+// its allowed IPs: the public key first, then the flags and settings.
+func wireGuardPeerHead(peer NativePeerConfig) ([]byte, error) {
+	publicKey, err := decodeWireGuardKey(peer.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", peer.PublicKey, err)
+	}
+	p := appendNetlinkAttr(nil, unix.WGPEER_A_PUBLIC_KEY, publicKey)
+	if peer.Remove {
+		return appendNetlinkAttr(p, unix.WGPEER_A_FLAGS, binary.NativeEndian.AppendUint32(nil, unix.WGPEER_F_REMOVE_ME)), nil
+	}
+	p = appendNetlinkAttr(p, unix.WGPEER_A_FLAGS, binary.NativeEndian.AppendUint32(nil, unix.WGPEER_F_REPLACE_ALLOWEDIPS))
+	// A zero preshared key clears one the peer had.
+	presharedKey := make([]byte, unix.WG_KEY_LEN)
+	if peer.PresharedKey != "" {
+		if presharedKey, err = decodeWireGuardKey(peer.PresharedKey); err != nil {
+			return nil, fmt.Errorf("invalid preshared key of peer %q: %w", peer.PublicKey, err)
+		}
+	}
+	p = appendNetlinkAttr(p, unix.WGPEER_A_PRESHARED_KEY, presharedKey)
+	p = appendNetlinkAttr(p, unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL, binary.NativeEndian.AppendUint16(nil, uint16(peer.PersistentKeepalive/time.Second)))
+	if peer.Endpoint.IsValid() {
+		p = appendNetlinkAttr(p, unix.WGPEER_A_ENDPOINT, encodeSockaddr(peer.Endpoint))
+	}
+	return p, nil
+}
+
+// wireGuardAllowedIPs encodes each prefix as the contents of one
+// WGPEER_A_ALLOWEDIPS entry.
+func wireGuardAllowedIPs(prefixes []netip.Prefix) [][]byte {
+	allowed := make([][]byte, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		family := uint16(unix.AF_INET)
+		if prefix.Addr().Is6() {
+			family = unix.AF_INET6
+		}
+		a := appendNetlinkAttr(nil, unix.WGALLOWEDIP_A_FAMILY, binary.NativeEndian.AppendUint16(nil, family))
+		a = appendNetlinkAttr(a, unix.WGALLOWEDIP_A_IPADDR, prefix.Addr().AsSlice())
+		a = appendNetlinkAttr(a, unix.WGALLOWEDIP_A_CIDR_MASK, []byte{uint8(prefix.Bits())})
+		allowed = append(allowed, a)
+	}
+	return allowed
+}
+
+// parseWireGuardDevice decodes the replies to WG_CMD_GET_DEVICE. A device
+// with many peers spans several replies, and a peer with many allowed IPs
+// continues in the next reply under the same public key.
+func parseWireGuardDevice(replies [][]byte) (*NativeDevice, error) {
+	device := &NativeDevice{}
+	for _, reply := range replies {
+		if len(reply) < genlHeaderLen {
+			return nil, fmt.Errorf("malformed netlink reply")
+		}
+		attrs, err := parseNetlinkAttrs(reply[genlHeaderLen:])
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			switch attr.typ {
+			case unix.WGDEVICE_A_IFNAME:
+				device.Name = cString(attr.data)
+			case unix.WGDEVICE_A_PRIVATE_KEY:
+				device.PrivateKey = encodeWireGuardKey(attr.data)
+			case unix.WGDEVICE_A_LISTEN_PORT:
+				if len(attr.data) >= 2 {
+					device.ListenPort = int(binary.NativeEndian.Uint16(attr.data))
+				}
+			case unix.WGDEVICE_A_FWMARK:
+				if len(attr.data) >= 4 {
+					device.FirewallMark = int(binary.NativeEndian.Uint32(attr.data))
+				}
+			case unix.WGDEVICE_A_PEERS:
+				if err := parseWireGuardPeers(device, attr.data); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return device, nil
+}
+
+// parseWireGuardPeers appends the peers of a WGDEVICE_A_PEERS attribute to
+// device, merging a peer continued from the previous reply.
+func parseWireGuardPeers(device *NativeDevice, data []byte) error {
+	entries, err := parseNetlinkAttrs(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		attrs, err := parseNetlinkAttrs(entry.data)
+		if err != nil {
+			return err
+		}
+		var peer NativePeer
+		for _, attr := range attrs {
+			switch attr.typ {
+			case unix.WGPEER_A_PUBLIC_KEY:
+				peer.PublicKey = encodeWireGuardKey(attr.data)
+			case unix.WGPEER_A_PRESHARED_KEY:
+				peer.PresharedKey = encodeWireGuardKey(attr.data)
+			case unix.WGPEER_A_ENDPOINT:
+				peer.Endpoint = decodeSockaddr(attr.data)
+			case unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL:
+				if len(attr.data) >= 2 {
+					peer.PersistentKeepalive = time.Duration(binary.NativeEndian.Uint16(attr.data)) * time.Second
+				}
+			case unix.WGPEER_A_ALLOWEDIPS:
+				if peer.AllowedIPs, err = parseAllowedIPs(attr.data); err != nil {
+					return err
+				}
+			}
+		}
+		if last := len(device.Peers) - 1; last >= 0 && device.Peers[last].PublicKey == peer.PublicKey {
+			device.Peers[last].AllowedIPs = append(device.Peers[last].AllowedIPs, peer.AllowedIPs...)
+			continue
+		}
+		device.Peers = append(device.Peers, peer)
+	}
+	return nil
+}
+
+// parseAllowedIPs decodes a WGPEER_A_ALLOWEDIPS attribute.
+func parseAllowedIPs(data []byte) ([]netip.Prefix, error) {
+	entries, err := parseNetlinkAttrs(data)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		attrs, err := parseNetlinkAttrs(entry.data)
+		if err != nil {
+			return nil, err
+		}
+		var addr netip.Addr
+		bits := -1
+		for _, attr := range attrs {
+			switch attr.typ {
+			case unix.WGALLOWEDIP_A_IPADDR:
+				addr, _ = netip.AddrFromSlice(attr.data)
+			case unix.WGALLOWEDIP_A_CIDR_MASK:
+				if len(attr.data) >= 1 {
+					bits = int(attr.data[0])
+				}
+			}
+		}
+		if !addr.IsValid() || bits < 0 {
+			return nil, fmt.Errorf("malformed allowed IP in netlink reply")
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, bits))
+	}
+	return prefixes, nil
+}
+
+// netlinkAttr is one decoded netlink attribute, its type without flags.
+type netlinkAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseNetlinkAttrs decodes a run of netlink attributes, in order.
+func parseNetlinkAttrs(b []byte) ([]netlinkAttr, error) {
+	var attrs []netlinkAttr
+	for len(b) >= unix.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		if length < unix.SizeofRtAttr || length > len(b) {
+			return nil, fmt.Errorf("malformed netlink attribute")
+		}
+		attrs = append(attrs, netlinkAttr{typ: binary.NativeEndian.Uint16(b[2:4]) & netlinkAttrTypeMask, data: b[unix.SizeofRtAttr:length]})
+		b = b[min(nlmAlign(length), len(b)):]
+	}
+	return attrs, nil
+}
+
+// encodeWireGuardKey encodes a key as configs write it; an all-zero key,
+// which the kernel reports for an unset preshared key, is "".
+func encodeWireGuardKey(key []byte) string {
+	for _, b := range key {
+		if b != 0 {
+			return base64.StdEncoding.EncodeToString(key)
+		}
+	}
+	return ""
+}
+
+// decodeWireGuardKey decodes a base64 key as configs write it.
+func decodeWireGuardKey(key string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != unix.WG_KEY_LEN {
+		return nil, fmt.Errorf("key is %d bytes, want %d", len(b), unix.WG_KEY_LEN)
+	}
+	return b, nil
+}
+
+// encodeSockaddr encodes an endpoint as a struct sockaddr_in or
+// sockaddr_in6.
+func encodeSockaddr(endpoint netip.AddrPort) []byte {
+	addr := endpoint.Addr().Unmap()
+	if addr.Is4() {
+		b := make([]byte, unix.SizeofSockaddrInet4)
+		binary.NativeEndian.PutUint16(b[0:2], unix.AF_INET)
+		binary.BigEndian.PutUint16(b[2:4], endpoint.Port())
+		ip := addr.As4()
+		copy(b[4:8], ip[:])
+		return b
+	}
+	b := make([]byte, unix.SizeofSockaddrInet6)
+	binary.NativeEndian.PutUint16(b[0:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(b[2:4], endpoint.Port())
+	ip := addr.As16()
+	copy(b[8:24], ip[:])
+	return b
+}
+
+// decodeSockaddr decodes a struct sockaddr_in or sockaddr_in6; anything
+// else is the zero AddrPort.
+func decodeSockaddr(b []byte) netip.AddrPort {
+	if len(b) < 4 {
+		return netip.AddrPort{}
+	}
+	port := binary.BigEndian.Uint16(b[2:4])
+	switch binary.NativeEndian.Uint16(b[0:2]) {
+	case unix.AF_INET:
+		if len(b) >= unix.SizeofSockaddrInet4 {
+			return netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[4:8])), port)
+		}
+	case unix.AF_INET6:
+		if len(b) >= unix.SizeofSockaddrInet6 {
+			return netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[8:24])).Unmap(), port)
+		}
+	}
+	return netip.AddrPort{}
+}
+
+// cString returns b up to its first NUL.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// openNetlink opens and binds a netlink socket of protocol.
+func openNetlink(protocol int) (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		//nolint:errcheck // Nothing to do about a failed close of the socket
+		_ = unix.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	return fd, nil
+}
@@ -0,0 +1,140 @@
+package wedev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"golang.org/x/sys/unix"
+)
+
+// deviceConfigurer reads and configures existing WireGuard devices, over
+// generic netlink (see genlConfigurer).
+type deviceConfigurer interface {
+	Device(name string) (*NativeDevice, error)
+	ConfigureDevice(name string, cfg NativeDeviceConfig) error
+	Close() error
+}
+
+// linuxNativeClient is the Linux NativeClient: devices are created over
+// rtnetlink and configured by a deviceConfigurer.
+type linuxNativeClient struct {
+	deviceConfigurer
+}
+
+// NewNativeClient opens the NativeClient of this platform. It fails with
+// ErrNativeUnsupported outside Linux, and on kernels without WireGuard.
+func NewNativeClient() (NativeClient, error) {
+	configurer, err := newDeviceConfigurer()
+	if err != nil {
+		return nil, err
+	}
+	return &linuxNativeClient{deviceConfigurer: configurer}, nil
+}
+
+// CreateDevice creates a WireGuard link named name and sets it up, as
+// 'ip link add <name> type wireguard && ip link set <name> up' does.
+func (*linuxNativeClient) CreateDevice(name string) error {
+	err := createWireGuardLink(name)
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w; is the wireguard kernel module loaded? (modprobe wireguard)", err)
+	}
+	return err
+}
+
+// createWireGuardLink sends an RTM_NEWLINK request for a WireGuard link and
+// waits for the kernel's acknowledgement.
+func createWireGuardLink(name string) error {
+	fd, err := openNetlink(unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		//nolint:errcheck // Nothing to do about a failed close of the socket
+		_ = unix.Close(fd)
+	}()
+	_, err = netlinkRequest(fd, newLinkMessage(name, 1), "RTM_NEWLINK")
+	return err
+}
+
+// netlinkRequest sends msg on the netlink socket fd and returns the payloads
+// of the replies carrying its sequence number, until the kernel acknowledges
+// the request or ends the dump. An error the kernel reports is a syscall
+// error of op.
+func netlinkRequest(fd int, msg []byte, op string) ([][]byte, error) {
+	seq := binary.NativeEndian.Uint32(msg[8:12])
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var replies [][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		for msg := buf[:n]; len(msg) >= unix.NLMSG_HDRLEN; {
+			length := binary.NativeEndian.Uint32(msg[0:4])
+			if length < unix.NLMSG_HDRLEN || int(length) > len(msg) {
+				return nil, fmt.Errorf("malformed netlink reply")
+			}
+			if binary.NativeEndian.Uint32(msg[8:12]) == seq {
+				switch binary.NativeEndian.Uint16(msg[4:6]) {
+				case unix.NLMSG_ERROR, unix.NLMSG_DONE:
+					// Both carry an errno, zero for success; a dump may
+					// end without one.
+					if length >= unix.NLMSG_HDRLEN+4 {
+						if errno := int32(binary.NativeEndian.Uint32(msg[unix.NLMSG_HDRLEN:])); errno != 0 {
+							return nil, os.NewSyscallError(op, unix.Errno(-errno))
+						}
+					}
+					return replies, nil
+				default:
+					replies = append(replies, slices.Clone(msg[unix.NLMSG_HDRLEN:length]))
+				}
+			}
+			msg = msg[min(nlmAlign(int(length)), len(msg)):]
+		}
+	}
+}
+
+// newLinkMessage encodes the RTM_NEWLINK request creating WireGuard link
+// name, up, failing if it exists.
+func newLinkMessage(name string, seq uint32) []byte {
+	var attrs []byte
+	attrs = appendNetlinkAttr(attrs, unix.IFLA_IFNAME, append([]byte(name), 0))
+	attrs = appendNetlinkAttr(attrs, unix.IFLA_LINKINFO, appendNetlinkAttr(nil, unix.IFLA_INFO_KIND, []byte("wireguard")))
+
+	msg := make([]byte, unix.NLMSG_HDRLEN+unix.SizeofIfInfomsg, unix.NLMSG_HDRLEN+unix.SizeofIfInfomsg+len(attrs))
+	binary.NativeEndian.PutUint16(msg[4:6], unix.RTM_NEWLINK)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	// ifinfomsg: family and index stay zero; flags and change set IFF_UP.
+	ifi := msg[unix.NLMSG_HDRLEN:]
+	binary.NativeEndian.PutUint32(ifi[8:12], unix.IFF_UP)
+	binary.NativeEndian.PutUint32(ifi[12:16], unix.IFF_UP)
+	msg = append(msg, attrs...)
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	return msg
+}
+
+// appendNetlinkAttr appends a route attribute, padded to 4 bytes, to b.
+func appendNetlinkAttr(b []byte, typ uint16, data []byte) []byte {
+	header := make([]byte, unix.SizeofRtAttr)
+	binary.NativeEndian.PutUint16(header[0:2], uint16(unix.SizeofRtAttr+len(data)))
+	binary.NativeEndian.PutUint16(header[2:4], typ)
+	b = append(b, header...)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// nlmAlign rounds a netlink message length up to its 4-byte alignment.
+func nlmAlign(n int) int {
+	return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
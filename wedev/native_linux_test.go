@@ -0,0 +1,285 @@
+package wedev
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewLinkMessage(t *testing.T) {
+	msg := newLinkMessage("wg0", 7)
+
+	if got := binary.NativeEndian.Uint32(msg[0:4]); int(got) != len(msg) || len(msg)%4 != 0 {
+		t.Fatalf("length field = %d for a %d-byte message, want equal and 4-byte aligned", got, len(msg))
+	}
+	if got := binary.NativeEndian.Uint16(msg[4:6]); got != unix.RTM_NEWLINK {
+		t.Errorf("type = %d, want RTM_NEWLINK", got)
+	}
+	if got, want := binary.NativeEndian.Uint16(msg[6:8]), uint16(unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_EXCL); got != want {
+		t.Errorf("flags = %#x, want %#x", got, want)
+	}
+	if got := binary.NativeEndian.Uint32(msg[8:12]); got != 7 {
+		t.Errorf("sequence = %d, want 7", got)
+	}
+	ifi := msg[unix.NLMSG_HDRLEN:]
+	if flags := binary.NativeEndian.Uint32(ifi[8:12]); flags != unix.IFF_UP {
+		t.Errorf("ifinfomsg flags = %#x, want IFF_UP", flags)
+	}
+
+	attrs := netlinkAttrsByType(t, msg[unix.NLMSG_HDRLEN+unix.SizeofIfInfomsg:])
+	if got := string(attrs[unix.IFLA_IFNAME]); got != "wg0\x00" {
+		t.Errorf("IFLA_IFNAME = %q, want wg0 NUL-terminated", got)
+	}
+	info := netlinkAttrsByType(t, attrs[unix.IFLA_LINKINFO])
+	if got := string(info[unix.IFLA_INFO_KIND]); got != "wireguard" {
+		t.Errorf("IFLA_INFO_KIND = %q, want wireguard", got)
+	}
+}
+
+// netlinkAttrsByType decodes a run of netlink attributes by type.
+func netlinkAttrsByType(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+	attrs, err := parseNetlinkAttrs(b)
+	if err != nil {
+		t.Fatalf("parseNetlinkAttrs(%x) error = %v", b, err)
+	}
+	byType := map[uint16][]byte{}
+	for _, attr := range attrs {
+		byType[attr.typ] = attr.data
+	}
+	return byType
+}
+
+func TestWireGuardDeviceAttrs(t *testing.T) {
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+	privateKey, port, mark := key(1), 51820, 0
+	cfg := NativeDeviceConfig{
+		PrivateKey:   &privateKey,
+		ListenPort:   &port,
+		FirewallMark: &mark,
+		Peers: []NativePeerConfig{
+			{NativePeer: NativePeer{
+				PublicKey:           key(2),
+				PresharedKey:        key(3),
+				Endpoint:            netip.MustParseAddrPort("203.0.113.7:51820"),
+				PersistentKeepalive: 25 * time.Second,
+				AllowedIPs:          []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32"), netip.MustParsePrefix("192.168.1.0/24")},
+			}},
+			{NativePeer: NativePeer{
+				PublicKey:  key(4),
+				Endpoint:   netip.MustParseAddrPort("[2001:db8::1]:51821"),
+				AllowedIPs: []netip.Prefix{netip.MustParsePrefix("fd00::/64")},
+			}},
+		},
+	}
+	requests, err := wireGuardSetAttrs("wg0", cfg, wireGuardSetLimit)
+	if err != nil || len(requests) != 1 {
+		t.Fatalf("wireGuardSetAttrs() = %d requests, %v; want 1", len(requests), err)
+	}
+	attrs := requests[0]
+
+	// A WG_CMD_GET_DEVICE reply lays out a device as a set request does, so
+	// decoding the request must give back the configured device.
+	reply := append(make([]byte, genlHeaderLen), attrs...)
+	device, err := parseWireGuardDevice([][]byte{reply})
+	if err != nil {
+		t.Fatalf("parseWireGuardDevice() error = %v", err)
+	}
+	want := &NativeDevice{Name: "wg0", PrivateKey: privateKey, ListenPort: port}
+	for _, peer := range cfg.Peers {
+		want.Peers = append(want.Peers, peer.NativePeer)
+	}
+	if !reflect.DeepEqual(device, want) {
+		t.Errorf("parseWireGuardDevice() = %+v\nwant %+v", device, want)
+	}
+
+	// A peer continued in the next reply keeps collecting allowed IPs.
+	continued, err := wireGuardSetAttrs("wg0", NativeDeviceConfig{Peers: []NativePeerConfig{{NativePeer: NativePeer{
+		PublicKey:  key(4),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("fd01::/64")},
+	}}}}, wireGuardSetLimit)
+	if err != nil {
+		t.Fatalf("wireGuardSetAttrs() error = %v", err)
+	}
+	device, err = parseWireGuardDevice([][]byte{reply, append(make([]byte, genlHeaderLen), continued[0]...)})
+	if err != nil {
+		t.Fatalf("parseWireGuardDevice() error = %v", err)
+	}
+	if len(device.Peers) != 2 || len(device.Peers[1].AllowedIPs) != 2 {
+		t.Errorf("parseWireGuardDevice(continued) peers = %+v, want the second peer with 2 allowed IPs", device.Peers)
+	}
+
+	// Removing a peer sends only its key and the flag.
+	removal, err := wireGuardSetAttrs("wg0", NativeDeviceConfig{Peers: []NativePeerConfig{{NativePeer: NativePeer{PublicKey: key(2)}, Remove: true}}}, wireGuardSetLimit)
+	if err != nil {
+		t.Fatalf("wireGuardSetAttrs(remove) error = %v", err)
+	}
+	peers := netlinkAttrsByType(t, netlinkAttrsByType(t, removal[0])[unix.WGDEVICE_A_PEERS])
+	peer := netlinkAttrsByType(t, peers[0])
+	if flags := binary.NativeEndian.Uint32(peer[unix.WGPEER_A_FLAGS]); flags != unix.WGPEER_F_REMOVE_ME || len(peer) != 2 {
+		t.Errorf("removed peer attributes = %v, want the public key and WGPEER_F_REMOVE_ME", peer)
+	}
+
+	invalid := "not a key"
+	if _, err := wireGuardSetAttrs("wg0", NativeDeviceConfig{PrivateKey: &invalid}, wireGuardSetLimit); err == nil {
+		t.Error("wireGuardSetAttrs(invalid key) succeeded")
+	}
+}
+
+// TestWireGuardSetAttrsSplit configures more peers and allowed IPs than fit
+// in one request and checks that the requests add up to the device.
+func TestWireGuardSetAttrsSplit(t *testing.T) {
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+	privateKey, limit := key(1), 512
+	cfg := NativeDeviceConfig{PrivateKey: &privateKey}
+	for i := range 6 {
+		peer := NativePeer{PublicKey: key(byte(10 + i)), Endpoint: netip.MustParseAddrPort("203.0.113.7:51820")}
+		for j := range 4 + 6*i {
+			peer.AllowedIPs = append(peer.AllowedIPs, netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), byte(j), 0}), 24))
+		}
+		cfg.Peers = append(cfg.Peers, NativePeerConfig{NativePeer: peer})
+	}
+
+	requests, err := wireGuardSetAttrs("wg0", cfg, limit)
+	if err != nil {
+		t.Fatalf("wireGuardSetAttrs() error = %v", err)
+	}
+	if len(requests) < 3 {
+		t.Fatalf("wireGuardSetAttrs() = %d requests, want the peers split over several", len(requests))
+	}
+	replies := make([][]byte, 0, len(requests))
+	var lastKey []byte
+	continued := 0
+	for i, attrs := range requests {
+		if len(attrs) > limit {
+			t.Errorf("request %d is %d bytes, over the limit of %d", i, len(attrs), limit)
+		}
+		byType := netlinkAttrsByType(t, attrs)
+		if cString(byType[unix.WGDEVICE_A_IFNAME]) != "wg0" {
+			t.Errorf("request %d names no device", i)
+		}
+		if _, ok := byType[unix.WGDEVICE_A_PRIVATE_KEY]; ok != (i == 0) {
+			t.Errorf("request %d carries the private key: %v, want only the first", i, ok)
+		}
+		peers, err := parseNetlinkAttrs(byType[unix.WGDEVICE_A_PEERS])
+		if err != nil || len(peers) == 0 {
+			t.Fatalf("request %d peers = %d, %v", i, len(peers), err)
+		}
+		// A peer continued from the previous request must not replace the
+		// allowed IPs that request set.
+		if first := netlinkAttrsByType(t, peers[0].data); bytes.Equal(first[unix.WGPEER_A_PUBLIC_KEY], lastKey) {
+			continued++
+			if len(first) != 2 {
+				t.Errorf("request %d continues a peer with attributes %v, want the public key and allowed IPs only", i, first)
+			}
+		}
+		lastKey = netlinkAttrsByType(t, peers[len(peers)-1].data)[unix.WGPEER_A_PUBLIC_KEY]
+		replies = append(replies, append(make([]byte, genlHeaderLen), attrs...))
+	}
+	if continued == 0 {
+		t.Error("no peer was continued in a later request, want the peers with many allowed IPs split")
+	}
+
+	device, err := parseWireGuardDevice(replies)
+	if err != nil {
+		t.Fatalf("parseWireGuardDevice() error = %v", err)
+	}
+	want := &NativeDevice{Name: "wg0", PrivateKey: privateKey}
+	for _, peer := range cfg.Peers {
+		want.Peers = append(want.Peers, peer.NativePeer)
+	}
+	if !reflect.DeepEqual(device, want) {
+		t.Errorf("requests decode to %+v\nwant %+v", device, want)
+	}
+
+	if _, err := wireGuardSetAttrs("wg0", cfg, 64); err == nil {
+		t.Error("wireGuardSetAttrs() with a limit below one peer succeeded")
+	}
+}
+
+// TestNativeClientDevice reads a missing device through the kernel where it
+// can: as root, with WireGuard available.
+func TestNativeClientDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("reading WireGuard devices needs root")
+	}
+	client, err := NewNativeClient()
+	if errors.Is(err, ErrNativeUnsupported) {
+		t.Skipf("no WireGuard in this kernel: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("NewNativeClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Device("wedevtest0"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Device(missing) error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+// TestNativeClientConfigureDevice configures a device with more peers and
+// allowed IPs than one request holds and reads it back through the kernel
+// where it can: as root, with WireGuard available.
+func TestNativeClientConfigureDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("configuring WireGuard devices needs root")
+	}
+	client, err := NewNativeClient()
+	if errors.Is(err, ErrNativeUnsupported) {
+		t.Skipf("no WireGuard in this kernel: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("NewNativeClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	const name = "wedevtest1"
+	if err := client.CreateDevice(name); err != nil {
+		t.Fatalf("CreateDevice() error = %v", err)
+	}
+	t.Cleanup(func() { _ = exec.Command("ip", "link", "del", name).Run() })
+
+	key := func(i int) string {
+		b := bytes.Repeat([]byte{byte(i)}, 32)
+		b[0] = 1 // never the all-zero key
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	privateKey := key(0)
+	cfg := NativeDeviceConfig{PrivateKey: &privateKey}
+	for i := 1; i <= 100; i++ {
+		peer := NativePeer{PublicKey: key(i)}
+		for j := range 40 {
+			peer.AllowedIPs = append(peer.AllowedIPs, netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), byte(j), 0}), 24))
+		}
+		cfg.Peers = append(cfg.Peers, NativePeerConfig{NativePeer: peer})
+	}
+	if err := client.ConfigureDevice(name, cfg); err != nil {
+		t.Fatalf("ConfigureDevice() error = %v", err)
+	}
+
+	device, err := client.Device(name)
+	if err != nil {
+		t.Fatalf("Device() error = %v", err)
+	}
+	if len(device.Peers) != len(cfg.Peers) {
+		t.Fatalf("device has %d peers, want %d", len(device.Peers), len(cfg.Peers))
+	}
+	for i, peer := range device.Peers {
+		if len(peer.AllowedIPs) != len(cfg.Peers[i].AllowedIPs) {
+			t.Errorf("peer %s has %d allowed IPs, want %d", peer.PublicKey, len(peer.AllowedIPs), len(cfg.Peers[i].AllowedIPs))
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package wedev
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewNativeClient fails: configuring WireGuard devices natively uses the
+// Linux kernel's netlink interfaces.
+func NewNativeClient() (NativeClient, error) {
+	return nil, fmt.Errorf("%w on %s: it needs Linux; apply without --native to use wg-quick", ErrNativeUnsupported, runtime.GOOS)
+}
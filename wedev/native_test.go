@@ -0,0 +1,240 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNativeClient keeps devices in memory and applies deltas to them the way
+// the kernel does.
+type fakeNativeClient struct {
+	devices    map[string]*NativeDevice
+	created    []string
+	configured []NativeDeviceConfig
+	readErr    error
+}
+
+func (f *fakeNativeClient) Device(name string) (*NativeDevice, error) {
+	if f.readErr != nil {
+		return nil, f.readErr
+	}
+	device, ok := f.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("device %s: %w", name, fs.ErrNotExist)
+	}
+	copied := *device
+	copied.Peers = slices.Clone(device.Peers)
+	return &copied, nil
+}
+
+func (f *fakeNativeClient) CreateDevice(name string) error {
+	if _, ok := f.devices[name]; ok {
+		return fs.ErrExist
+	}
+	f.devices[name] = &NativeDevice{Name: name}
+	f.created = append(f.created, name)
+	return nil
+}
+
+func (f *fakeNativeClient) ConfigureDevice(name string, cfg NativeDeviceConfig) error {
+	device, ok := f.devices[name]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	f.configured = append(f.configured, cfg)
+	if cfg.PrivateKey != nil {
+		device.PrivateKey = *cfg.PrivateKey
+	}
+	if cfg.ListenPort != nil {
+		device.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		device.FirewallMark = *cfg.FirewallMark
+	}
+	for _, change := range cfg.Peers {
+		i := slices.IndexFunc(device.Peers, func(p NativePeer) bool { return p.PublicKey == change.PublicKey })
+		switch {
+		case change.Remove && i >= 0:
+			device.Peers = slices.Delete(device.Peers, i, i+1)
+		case change.Remove:
+		case i >= 0:
+			device.Peers[i] = change.NativePeer
+		default:
+			device.Peers = append(device.Peers, change.NativePeer)
+		}
+	}
+	return nil
+}
+
+func (f *fakeNativeClient) Close() error { return nil }
+
+// newNativeTestSyncer creates a network with a server and one node, and a
+// syncer wired to a fake client that reports the given effective UID.
+func newNativeTestSyncer(t *testing.T, euid int) (*NativeSyncer, *fakeNativeClient) {
+	t.Helper()
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("nativenet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("nativenet", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("nativenet", "n1", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	client := &fakeNativeClient{devices: map[string]*NativeDevice{}}
+	ns := NewNativeSyncer(sm, client)
+	ns.resolve = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host != "vpn.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("203.0.113.10")}, nil
+	}
+	ns.geteuid = func() int { return euid }
+	return ns, client
+}
+
+func TestNativeSyncer_CreateThenNoop(t *testing.T) {
+	ctx := context.Background()
+	ns, client := newNativeTestSyncer(t, 0)
+
+	plan, err := ns.Plan(ctx, "nativenet", "n1", "")
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if !plan.Create || plan.Interface != "nativenet" {
+		t.Errorf("Plan() create = %v on %s, want a new nativenet", plan.Create, plan.Interface)
+	}
+	if len(plan.Changes) < 3 || plan.Changes[0] != "create device nativenet" || plan.Changes[1] != "set private key" ||
+		!strings.HasPrefix(plan.Changes[len(plan.Changes)-1], "add peer srv (") {
+		t.Errorf("Plan() changes = %q, want create, private key, and add peer srv", plan.Changes)
+	}
+	if !slices.Contains(plan.Skipped, "Address") {
+		t.Errorf("Plan() skipped = %v, want Address among them", plan.Skipped)
+	}
+	if err := ns.Apply(ctx, plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !slices.Equal(client.created, []string{"nativenet"}) || len(client.configured) != 1 {
+		t.Fatalf("Apply() created %v and configured %d times, want nativenet once", client.created, len(client.configured))
+	}
+	device := client.devices["nativenet"]
+	if len(device.Peers) != 1 {
+		t.Fatalf("device peers = %+v, want the server", device.Peers)
+	}
+	// The server's host name resolves to its IPv4 address.
+	if want := netip.MustParseAddrPort("203.0.113.10:51820"); device.Peers[0].Endpoint != want {
+		t.Errorf("server endpoint = %s, want %s", device.Peers[0].Endpoint, want)
+	}
+
+	plan, err = ns.Plan(ctx, "nativenet", "n1", "")
+	if err != nil {
+		t.Fatalf("Plan(again) error = %v", err)
+	}
+	if plan.Create || len(plan.Changes) != 0 {
+		t.Errorf("Plan(again) = create %v, changes %q, want no changes", plan.Create, plan.Changes)
+	}
+	if err := ns.Apply(ctx, plan); err != nil {
+		t.Fatalf("Apply(again) error = %v", err)
+	}
+	if len(client.configured) != 1 {
+		t.Errorf("Apply() without changes configured the device (%d times)", len(client.configured))
+	}
+}
+
+// TestNativeSyncer_Delta checks that only what differs is sent: a changed
+// peer is updated and a peer the config no longer lists is removed.
+func TestNativeSyncer_Delta(t *testing.T) {
+	ctx := context.Background()
+	ns, client := newNativeTestSyncer(t, 0)
+	plan, err := ns.Plan(ctx, "nativenet", "srv", "wg0")
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := ns.Apply(ctx, plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	const stale = "c3RhbGUtcGVlci1wdWJsaWMta2V5LWZvci10ZXN0cz0="
+	device := client.devices["wg0"]
+	node := device.Peers[0].PublicKey
+	device.Peers[0].PersistentKeepalive = 5 * time.Second
+	device.Peers = append(device.Peers, NativePeer{PublicKey: stale, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.99/32")}})
+	client.configured = nil
+
+	plan, err = ns.Plan(ctx, "nativenet", "srv", "wg0")
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []string{"update peer n1 (10.0.0.2) " + node + ": keepalive 0s", "remove peer " + stale}
+	if !slices.Equal(plan.Changes, want) {
+		t.Errorf("Plan() changes = %q, want %q", plan.Changes, want)
+	}
+	if plan.Delta.PrivateKey != nil || plan.Delta.ListenPort != nil || len(plan.Delta.Peers) != 2 {
+		t.Errorf("Plan() delta = %+v, want only the two peers", plan.Delta)
+	}
+	if err := ns.Apply(ctx, plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if peers := client.devices["wg0"].Peers; len(peers) != 1 || peers[0].PublicKey != node {
+		t.Errorf("device peers after Apply() = %+v, want only n1", peers)
+	}
+}
+
+func TestNativeSyncer_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	ns, _ := newNativeTestSyncer(t, 1000)
+	if _, err := ns.Plan(ctx, "nativenet", "n1", ""); err == nil || !strings.Contains(err.Error(), "root") {
+		t.Errorf("Plan() as non-root error = %v, want a root privileges error", err)
+	}
+
+	ns, client := newNativeTestSyncer(t, 0)
+	client.readErr = fmt.Errorf("netlink receive: %w", fs.ErrPermission)
+	if _, err := ns.Plan(ctx, "nativenet", "n1", ""); err == nil || !strings.Contains(err.Error(), "CAP_NET_ADMIN") {
+		t.Errorf("Plan() with a refused read error = %v, want a CAP_NET_ADMIN hint", err)
+	}
+
+	client.readErr = nil
+	if _, err := ns.Plan(ctx, "nativenet", "n1", "this-name-is-too-long"); !errors.Is(err, ErrValidation) {
+		t.Errorf("Plan() with a long interface name error = %v, want ErrValidation", err)
+	}
+	if _, err := ns.Plan(ctx, "nativenet", "ghost", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Plan() of a missing entity error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNativeSyncer_RecordsDeployment(t *testing.T) {
+	ctx := context.Background()
+	ns, _ := newNativeTestSyncer(t, 0)
+	if _, _, err := ns.generator.SaveConfigVersion("nativenet"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	plan, err := ns.Plan(ctx, "nativenet", "n1", "")
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := ns.Apply(ctx, plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	states, err := ns.generator.DeploymentStates("nativenet")
+	if err != nil {
+		t.Fatalf("DeploymentStates() error = %v", err)
+	}
+	for _, s := range states {
+		want := DeploymentNever
+		if s.Entity == "n1" {
+			want = DeploymentCurrent
+		}
+		if s.Status != want {
+			t.Errorf("%s status = %s, want %s", s.Entity, s.Status, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package wedev
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/wedevctl/util"
+)
+
+// NodeSelection picks nodes of a network for SelectNodes: the nodes named,
+// plus, when Selector or Pattern is set, the nodes whose labels match
+// Selector and whose name matches the glob Pattern (see path.Match).
+type NodeSelection struct {
+	Names    []string
+	Selector util.LabelSelector
+	Pattern  string
+}
+
+// NodeDeleteFailure is a node DeleteNodes could not delete.
+type NodeDeleteFailure struct {
+	Name string
+	Err  error
+}
+
+// NodeDeleteResult lists what DeleteNodes did, in the order it was given.
+type NodeDeleteResult struct {
+	Deleted []*Node
+	Failed  []NodeDeleteFailure
+}
+
+// SelectNodes resolves a selection to the nodes of a network, sorted by
+// name and without duplicates. A named node that does not exist is an
+// ErrNotFound error; filters matching nothing are not an error.
+func (vnm *VirtualNetworkManager) SelectNodes(networkName string, sel NodeSelection) ([]*Node, error) {
+	if sel.Pattern != "" {
+		if _, err := path.Match(sel.Pattern, ""); err != nil {
+			return nil, kindErrorf(ErrValidation, "invalid pattern %q: %v", sel.Pattern, err)
+		}
+	}
+
+	nodes, err := vnm.ListNodes(networkName)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Node, len(nodes))
+	for _, node := range nodes {
+		byName[node.Name] = node
+	}
+
+	selected := make(map[string]*Node)
+	for _, name := range sel.Names {
+		node, ok := byName[name]
+		if !ok {
+			return nil, kindErrorf(ErrNotFound, "node %q not found", name)
+		}
+		selected[name] = node
+	}
+	if len(sel.Selector) > 0 || sel.Pattern != "" {
+		for _, node := range nodes {
+			if !sel.Selector.Matches(node.Labels) {
+				continue
+			}
+			if matched, _ := path.Match(sel.Pattern, node.Name); sel.Pattern != "" && !matched {
+				continue
+			}
+			selected[node.Name] = node
+		}
+	}
+
+	result := make([]*Node, 0, len(selected))
+	for _, node := range selected {
+		result = append(result, node)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// DeleteNodes deletes several nodes of a network. Each node is deleted on
+// its own, so one that cannot be deleted is recorded in the result and the
+// rest are still deleted. Their IPs are released and the IP pool state is
+// saved once, after the last node. If that save fails, the error is
+// returned with the result: the nodes are gone, but their IPs stay
+// allocated until 'ip repair' rebuilds the pool.
+func (vnm *VirtualNetworkManager) DeleteNodes(networkName string, names []string) (*NodeDeleteResult, error) {
+	vnm.poolMu.Lock()
+	defer vnm.poolMu.Unlock()
+
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	// A network whose CIDR is invalid has no pool to release the IPs to;
+	// the nodes are still deleted, and fixing the CIDR rebuilds the pool.
+	var ipPool *util.IPPool
+	if checkNetworkCIDR(network) == nil {
+		if ipPool, err = vnm.loadIPPool(network); err != nil {
+			return nil, fmt.Errorf("failed to ensure IP pool: %w", err)
+		}
+	}
+
+	result := &NodeDeleteResult{}
+	for _, name := range names {
+		node, err := vnm.storage.GetNodeByName(network.ID, name)
+		if err == nil {
+			err = vnm.storage.DeleteNode(network.ID, name)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, NodeDeleteFailure{Name: name, Err: err})
+			continue
+		}
+		if ipPool != nil {
+			if err := ipPool.ReleaseNodeIP(node.VirtualIP); err != nil {
+				vnm.logger.Warn("failed to release IP", "ip", node.VirtualIP, "error", err)
+			}
+		}
+		result.Deleted = append(result.Deleted, node)
+	}
+	if len(result.Deleted) == 0 {
+		return result, nil
+	}
+	if ipPool == nil {
+		vnm.InvalidateIPPool(network.ID)
+		return result, nil
+	}
+
+	state := ipPool.GetState()
+	if err := vnm.storage.SaveIPPoolState(network.ID, state); err != nil {
+		vnm.InvalidateIPPool(network.ID)
+		return result, fmt.Errorf("failed to save IP pool state, run 'ip repair' to release the deleted nodes' IPs: %w", err)
+	}
+	vnm.cacheIPPool(network.ID, network.CIDR, ipPool, state)
+	return result, nil
+}
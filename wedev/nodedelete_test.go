@@ -0,0 +1,142 @@
+package wedev
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// newBulkNetwork creates network "bulk" with a server and the named route
+// nodes.
+func newBulkNetwork(t *testing.T, vnm *VirtualNetworkManager, names ...string) {
+	t.Helper()
+	if _, err := vnm.CreateVirtualNetwork("bulk", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("bulk", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range names {
+		if _, err := vnm.CreateNode("bulk", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+}
+
+func nodeNames(nodes []*Node) []string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func TestSelectNodes(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	newBulkNetwork(t, vnm, "loadtest1", "loadtest2", "loadtest3", "laptop")
+	for _, name := range []string{"loadtest1", "loadtest2", "laptop"} {
+		if _, err := vnm.UpdateNodeLabels("bulk", name, map[string]string{"env": "test"}, nil); err != nil {
+			t.Fatalf("UpdateNodeLabels() error = %v", err)
+		}
+	}
+	selector, _ := util.ParseLabelSelector("env=test")
+
+	tests := []struct {
+		name string
+		sel  NodeSelection
+		want []string
+	}{
+		{"names", NodeSelection{Names: []string{"laptop", "loadtest1", "laptop"}}, []string{"laptop", "loadtest1"}},
+		{"pattern", NodeSelection{Pattern: "loadtest*"}, []string{"loadtest1", "loadtest2", "loadtest3"}},
+		{"selector", NodeSelection{Selector: selector}, []string{"laptop", "loadtest1", "loadtest2"}},
+		{"selector and pattern", NodeSelection{Selector: selector, Pattern: "loadtest*"}, []string{"loadtest1", "loadtest2"}},
+		{"names and pattern", NodeSelection{Names: []string{"laptop"}, Pattern: "*3"}, []string{"laptop", "loadtest3"}},
+		{"no match", NodeSelection{Pattern: "db-*"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := vnm.SelectNodes("bulk", tt.sel)
+			if err != nil {
+				t.Fatalf("SelectNodes() error = %v", err)
+			}
+			if got := nodeNames(nodes); !slices.Equal(got, tt.want) {
+				t.Errorf("SelectNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := vnm.SelectNodes("bulk", NodeSelection{Names: []string{"missing"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SelectNodes(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := vnm.SelectNodes("bulk", NodeSelection{Pattern: "["}); !errors.Is(err, ErrValidation) {
+		t.Errorf("SelectNodes([) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestDeleteNodes(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	newBulkNetwork(t, vnm, "a", "b", "c")
+	network, _ := sm.GetNetworkByName("bulk")
+	before, _ := sm.GetIPPoolState(network.ID)
+
+	result, err := vnm.DeleteNodes("bulk", []string{"a", "missing", "c"})
+	if err != nil {
+		t.Fatalf("DeleteNodes() error = %v", err)
+	}
+	if got := nodeNames(result.Deleted); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("Deleted = %v, want a and c", got)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Name != "missing" || !errors.Is(result.Failed[0].Err, ErrNotFound) {
+		t.Errorf("Failed = %+v, want missing not found", result.Failed)
+	}
+
+	// The pool state was saved once, with both IPs released.
+	after, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	if after.Revision != before.Revision+1 {
+		t.Errorf("pool revision = %d, want one save past %d", after.Revision, before.Revision)
+	}
+	report, err := vnm.AuditIPPool("bulk")
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() = %+v, %v; want no drift", report, err)
+	}
+	node, err := vnm.CreateNode("bulk", "d", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if node.VirtualIP != "10.0.0.2" && node.VirtualIP != "10.0.0.4" {
+		t.Errorf("new node IP = %s, want a released one", node.VirtualIP)
+	}
+}
+
+func TestDeleteNodes_PoolSaveFails(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	newBulkNetwork(t, vnm, "a", "b")
+
+	sm.poolWriteFault = func() error { return errors.New("disk full") }
+	t.Cleanup(func() { sm.poolWriteFault = nil })
+	result, err := vnm.DeleteNodes("bulk", []string{"a", "b"})
+	sm.poolWriteFault = nil
+	if err == nil {
+		t.Fatal("DeleteNodes() succeeded with the pool write failing")
+	}
+	if len(result.Deleted) != 2 {
+		t.Errorf("Deleted = %v, want both nodes", nodeNames(result.Deleted))
+	}
+
+	// The nodes are gone and their IPs leaked until the pool is repaired.
+	network, _ := sm.GetNetworkByName("bulk")
+	if nodes, _ := sm.ListNodesByNetworkID(network.ID); len(nodes) != 0 {
+		t.Errorf("nodes left = %v, want none", nodeNames(nodes))
+	}
+	if report, _ := vnm.RepairIPPool("bulk"); report == nil || len(report.Issues) == 0 {
+		t.Errorf("RepairIPPool() = %+v, want the leaked IPs found", report)
+	}
+	if report, _ := vnm.AuditIPPool("bulk"); report == nil || len(report.Issues) != 0 {
+		t.Errorf("AuditIPPool() after repair = %+v, want no drift", report)
+	}
+}
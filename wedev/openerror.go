@@ -0,0 +1,139 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.etcd.io/bbolt"
+)
+
+// OpenErrorCause is why a database could not be opened, as
+// ClassifyOpenError tells from the error.
+type OpenErrorCause string
+
+const (
+	// OpenErrorUnknown is an error ClassifyOpenError has no hint for.
+	OpenErrorUnknown OpenErrorCause = "unknown"
+	// OpenErrorPermission is a database file or directory this user may not
+	// read or write, often left owned by root by a sudo run.
+	OpenErrorPermission OpenErrorCause = "permission"
+	// OpenErrorLocked is a database another process holds the lock of.
+	OpenErrorLocked OpenErrorCause = "locked"
+	// OpenErrorCorrupt is a file that is not a bbolt database, or a damaged
+	// one.
+	OpenErrorCorrupt OpenErrorCause = "corrupt"
+	// OpenErrorNoSpace is a full disk.
+	OpenErrorNoSpace OpenErrorCause = "no-space"
+	// OpenErrorReadOnlyFS is a database on a read-only filesystem.
+	OpenErrorReadOnlyFS OpenErrorCause = "read-only-fs"
+)
+
+// OpenError is an error opening the database at Path, with a hint at how to
+// fix it. It unwraps to the original error, so its error kind still
+// matches.
+type OpenError struct {
+	Path  string
+	Cause OpenErrorCause
+	Hint  string // empty when the original message already says what to do
+	Err   error
+}
+
+func (e *OpenError) Error() string {
+	if e.Hint == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + "; " + e.Hint
+}
+
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyOpenError tells why opening a database failed from the error
+// returned by NewStorageManager, or by creating its directory.
+func ClassifyOpenError(err error) OpenErrorCause {
+	switch {
+	case errors.Is(err, ErrDBLocked), errors.Is(err, bbolt.ErrTimeout):
+		return OpenErrorLocked
+	case errors.Is(err, fs.ErrPermission):
+		return OpenErrorPermission
+	case errors.Is(err, syscall.ENOSPC):
+		return OpenErrorNoSpace
+	case errors.Is(err, syscall.EROFS):
+		return OpenErrorReadOnlyFS
+	case errors.Is(err, bbolt.ErrInvalid), errors.Is(err, bbolt.ErrVersionMismatch),
+		errors.Is(err, bbolt.ErrChecksum), errors.Is(err, bbolt.ErrInvalidMapping),
+		// bbolt reports a truncated file without a sentinel error.
+		err != nil && strings.Contains(err.Error(), "file size too small"):
+		return OpenErrorCorrupt
+	}
+	return OpenErrorUnknown
+}
+
+// DiagnoseOpenError wraps an error opening the database at dbPath in an
+// OpenError whose hint matches its cause. Errors of unknown cause, and nil,
+// are returned as they are.
+func DiagnoseOpenError(dbPath string, err error) error {
+	cause := ClassifyOpenError(err)
+	if cause == OpenErrorUnknown {
+		return err
+	}
+	openErr := &OpenError{Path: dbPath, Cause: cause, Err: err}
+	switch cause {
+	case OpenErrorPermission:
+		openErr.Hint = permissionHint(dbPath)
+	case OpenErrorLocked:
+		if !errors.Is(err, ErrDBLocked) {
+			openErr.Hint = "another process holds the database lock; wait for it to finish or raise --db-timeout"
+		}
+	case OpenErrorCorrupt:
+		openErr.Hint = fmt.Sprintf("%s is not a wedevctl database or is damaged; restore the latest backup with 'wedevctl db restore <file>'", dbPath)
+	case OpenErrorNoSpace:
+		openErr.Hint = fmt.Sprintf("the disk holding %s is full; free some space and try again", dbPath)
+	case OpenErrorReadOnlyFS:
+		openErr.Hint = fmt.Sprintf("%s is on a read-only filesystem; point --db or $WEDEVCTL_DB_PATH at a writable location", dbPath)
+	}
+	return openErr
+}
+
+// permissionHint names the owner and mode of the database file, or of the
+// nearest directory above it that exists, and how to take it back.
+func permissionHint(dbPath string) string {
+	path := dbPath
+	info, err := os.Stat(path)
+	for err != nil && errors.Is(err, fs.ErrNotExist) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		return fmt.Sprintf("check the permissions of %s and the directories above it", dbPath)
+	}
+
+	uid, ok := fileOwner(info)
+	if !ok {
+		return fmt.Sprintf("%s has mode %s; check that this user may read and write it", path, info.Mode())
+	}
+	if uid == os.Getuid() {
+		return fmt.Sprintf("%s has mode %s; run 'chmod u+rw %s' to make it writable again", path, info.Mode(), path)
+	}
+	me := "$USER"
+	if u, err := user.Current(); err == nil {
+		me = u.Username
+	}
+	return fmt.Sprintf("%s is owned by %s with mode %s, probably from a run with sudo; run 'sudo chown -R %s %s' to take it back", path, userName(uid), info.Mode(), me, path)
+}
+
+// userName returns the name of the user with the given uid, or "uid N".
+func userName(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+	return "uid " + strconv.Itoa(uid)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package wedev
+
+import "io/fs"
+
+// fileOwner reports no owner: file ownership is a Unix notion.
+func fileOwner(fs.FileInfo) (int, bool) {
+	return 0, false
+}
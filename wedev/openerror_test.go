@@ -0,0 +1,86 @@
+package wedev
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestDiagnoseOpenError(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "wedevctl.db")
+	if err := os.WriteFile(dbPath, nil, 0o400); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	pathErr := func(errno syscall.Errno) error {
+		return fmt.Errorf("failed to open database: %w", &fs.PathError{Op: "open", Path: dbPath, Err: errno})
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		cause OpenErrorCause
+		hint  string // substring of the message; "" for none added
+	}{
+		{"permission", pathErr(syscall.EACCES), OpenErrorPermission, "chmod u+rw " + dbPath},
+		{"locked", lockedError(dbPath), OpenErrorLocked, ""},
+		{"bbolt timeout", fmt.Errorf("failed to open database: %w", bbolt.ErrTimeout), OpenErrorLocked, "another process holds the database lock"},
+		{"invalid magic", fmt.Errorf("failed to open database: %w", bbolt.ErrInvalid), OpenErrorCorrupt, "wedevctl db restore"},
+		{"truncated", errors.New("failed to open database: file size too small 4096"), OpenErrorCorrupt, "is damaged"},
+		{"disk full", pathErr(syscall.ENOSPC), OpenErrorNoSpace, "is full"},
+		{"read-only filesystem", pathErr(syscall.EROFS), OpenErrorReadOnlyFS, "$WEDEVCTL_DB_PATH"},
+		{"unknown", errors.New("something else"), OpenErrorUnknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cause := ClassifyOpenError(tt.err); cause != tt.cause {
+				t.Errorf("ClassifyOpenError() = %s, want %s", cause, tt.cause)
+			}
+			err := DiagnoseOpenError(dbPath, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("DiagnoseOpenError() = %v, want it to wrap %v", err, tt.err)
+			}
+			if !strings.HasPrefix(err.Error(), tt.err.Error()) {
+				t.Errorf("DiagnoseOpenError() = %q, want it to start with %q", err, tt.err)
+			}
+			if tt.hint == "" && err.Error() != tt.err.Error() {
+				t.Errorf("DiagnoseOpenError() = %q, want no hint", err)
+			}
+			if tt.hint != "" && !strings.Contains(err.Error(), tt.hint) {
+				t.Errorf("DiagnoseOpenError() = %q, want a hint containing %q", err, tt.hint)
+			}
+		})
+	}
+
+	if !errors.Is(DiagnoseOpenError(dbPath, lockedError(dbPath)), ErrDBLocked) {
+		t.Error("DiagnoseOpenError() lost the ErrDBLocked kind")
+	}
+	if DiagnoseOpenError(dbPath, nil) != nil {
+		t.Error("DiagnoseOpenError(nil) != nil")
+	}
+}
+
+func TestPermissionHint_MissingFile(t *testing.T) {
+	dir := t.TempDir()
+	hint := permissionHint(filepath.Join(dir, "sub", "wedevctl.db"))
+	if !strings.Contains(hint, dir+" has mode") {
+		t.Errorf("permissionHint() = %q, want the nearest existing directory %s", hint, dir)
+	}
+}
+
+func TestNewStorageManager_CorruptFile(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "wedevctl.db")
+	if err := os.WriteFile(dbPath, make([]byte, 40000), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	_, err := NewStorageManager(dbPath)
+	if cause := ClassifyOpenError(err); cause != OpenErrorCorrupt {
+		t.Errorf("ClassifyOpenError(%v) = %s, want %s", err, cause, OpenErrorCorrupt)
+	}
+}
@@ -0,0 +1,124 @@
+package wedev
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/wedevctl/util"
+)
+
+// DefaultIPPoolCacheSize is how many IP pools a VirtualNetworkManager keeps
+// in memory; the least recently used pool is evicted beyond it.
+const DefaultIPPoolCacheSize = 64
+
+// cachedIPPool is an IP pool as it was at a revision of its saved state.
+type cachedIPPool struct {
+	networkID string
+	cidr      string // the network's CIDR when the pool was cached
+	revision  uint64 // util.IPPoolState.Revision the pool matches
+	pool      *util.IPPool
+}
+
+// ipPoolCache holds the IP pools of the networks a manager used last, most
+// recently used first. It is safe for concurrent use; callers changing a
+// pool still serialize on VirtualNetworkManager.poolMu.
+type ipPoolCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // of *cachedIPPool, most recently used first
+	entries map[string]*list.Element // networkID -> element of order
+}
+
+// newIPPoolCache creates a cache holding at most size pools.
+func newIPPoolCache(size int) *ipPoolCache {
+	return &ipPoolCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached pool of a network and marks it used.
+func (c *ipPoolCache) get(networkID string) (*cachedIPPool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[networkID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedIPPool), true
+}
+
+// put caches the pool of a network, replacing any it held, and evicts the
+// least recently used pools beyond the size.
+func (c *ipPoolCache) put(entry *cachedIPPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.networkID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[entry.networkID] = c.order.PushFront(entry)
+	}
+	c.evict()
+}
+
+// remove drops the pool of a network.
+func (c *ipPoolCache) remove(networkID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[networkID]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, networkID)
+	}
+}
+
+// resize changes how many pools the cache holds, evicting the least
+// recently used ones beyond it.
+func (c *ipPoolCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = size
+	c.evict()
+}
+
+// len returns the number of cached pools.
+func (c *ipPoolCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// evict drops the least recently used pools beyond the size. Callers hold mu.
+func (c *ipPoolCache) evict() {
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedIPPool).networkID)
+	}
+}
+
+// InvalidateIPPool drops the cached IP pool of a network, so the next
+// operation on it restores the pool from the database.
+func (vnm *VirtualNetworkManager) InvalidateIPPool(networkID string) {
+	vnm.pools.remove(networkID)
+}
+
+// SetIPPoolCacheSize sets how many IP pools the manager keeps in memory
+// (DefaultIPPoolCacheSize unless set), evicting the least recently used
+// ones beyond it. A size of 0 disables caching.
+func (vnm *VirtualNetworkManager) SetIPPoolCacheSize(size int) error {
+	if size < 0 {
+		return kindErrorf(ErrValidation, "IP pool cache size must not be negative, got %d", size)
+	}
+	vnm.pools.resize(size)
+	return nil
+}
+
+// cacheIPPool records pool as the pool of the network with networkCIDR,
+// matching state, which was just saved; see loadIPPool.
+func (vnm *VirtualNetworkManager) cacheIPPool(networkID, networkCIDR string, pool *util.IPPool, state *util.IPPoolState) {
+	vnm.pools.put(&cachedIPPool{networkID: networkID, cidr: networkCIDR, revision: state.Revision, pool: pool})
+}
@@ -0,0 +1,197 @@
+package wedev
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+func TestIPPoolCache_LRU(t *testing.T) {
+	c := newIPPoolCache(2)
+	for _, id := range []string{"a", "b"} {
+		c.put(&cachedIPPool{networkID: id})
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("get(a) missed")
+	}
+	// b is now the least recently used, so c evicts it.
+	c.put(&cachedIPPool{networkID: "c"})
+	if _, ok := c.get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("len() = %d, want 2", c.len())
+	}
+
+	// Replacing an entry keeps one per network.
+	c.put(&cachedIPPool{networkID: "a", revision: 7})
+	if entry, _ := c.get("a"); entry.revision != 7 || c.len() != 2 {
+		t.Errorf("get(a) = %+v with %d entries, want revision 7 and 2 entries", entry, c.len())
+	}
+
+	c.resize(1)
+	if _, ok := c.get("c"); ok || c.len() != 1 {
+		t.Errorf("resize(1) kept c or %d entries, want only a", c.len())
+	}
+	c.remove("a")
+	if c.len() != 0 {
+		t.Errorf("len() after remove = %d, want 0", c.len())
+	}
+}
+
+func TestIPPoolCache_Manager(t *testing.T) {
+	sm, err := NewStorageManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer sm.Close()
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+
+	network, err := vnm.CreateVirtualNetwork("net", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("net", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("net", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+	cached, ok := vnm.pools.get(network.ID)
+	if !ok {
+		t.Fatal("the pool of net is not cached")
+	}
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	if cached.revision != state.Revision || state.Revision == 0 {
+		t.Errorf("cached revision = %d, saved revision = %d; want them equal and non-zero", cached.revision, state.Revision)
+	}
+
+	// Another process allocates 10.0.0.3: the saved state's newer revision
+	// makes the manager restore the pool rather than hand it out again.
+	state.Allocated = append(state.Allocated, "10.0.0.3")
+	state.NextIndex++
+	if err := sm.SaveIPPoolState(network.ID, state); err != nil {
+		t.Fatalf("SaveIPPoolState() error = %v", err)
+	}
+	b, err := vnm.CreateNode("net", "b", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(b) error = %v", err)
+	}
+	if b.VirtualIP != "10.0.0.4" {
+		t.Errorf("node b got %s, want 10.0.0.4", b.VirtualIP)
+	}
+
+	// A failed save drops the pool holding the unsaved allocation.
+	restore := breakIPPoolWrites(t, sm)
+	if _, err := vnm.CreateNode("net", "c", "", 0, NodeTypeRoute); err == nil {
+		t.Fatal("CreateNode(c) succeeded with IP pool writes failing")
+	}
+	restore()
+	if _, ok := vnm.pools.get(network.ID); ok {
+		t.Error("the pool of net is still cached after a failed save")
+	}
+
+	// The network's CIDR changes without the saved state following: the
+	// cached pool no longer matches, and a pool for the new CIDR is rebuilt.
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		network.CIDR = "10.0.0.0/23"
+		data, err := json.Marshal(network)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketNetworks)).Put([]byte(network.ID), data)
+	}); err != nil {
+		t.Fatalf("updating the network record error = %v", err)
+	}
+	if _, err := vnm.CreateNode("net", "d", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(d) error = %v", err)
+	}
+	if cached, ok := vnm.pools.get(network.ID); !ok || cached.cidr != "10.0.0.0/23" {
+		t.Errorf("cached pool = %+v, want one for 10.0.0.0/23", cached)
+	}
+
+	vnm.InvalidateIPPool(network.ID)
+	if _, ok := vnm.pools.get(network.ID); ok {
+		t.Error("InvalidateIPPool() left the pool cached")
+	}
+	if err := vnm.SetIPPoolCacheSize(-1); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetIPPoolCacheSize(-1) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestIPPoolCache_Concurrent(t *testing.T) {
+	sm, err := NewStorageManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer sm.Close()
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	// Three networks share a cache of two, so pools are evicted meanwhile.
+	if err := vnm.SetIPPoolCacheSize(2); err != nil {
+		t.Fatalf("SetIPPoolCacheSize() error = %v", err)
+	}
+	networks := []string{"n0", "n1", "n2"}
+	for i, name := range networks {
+		if _, err := vnm.CreateVirtualNetwork(name, fmt.Sprintf("10.%d.0.0/24", i)); err != nil {
+			t.Fatalf("CreateVirtualNetwork(%s) error = %v", name, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			network := networks[i%len(networks)]
+			if _, err := vnm.CreateNode(network, fmt.Sprintf("node%d", i), "", 0, NodeTypeRoute); err != nil {
+				errs <- err
+			}
+			if i%5 == 0 {
+				if n, err := vnm.GetVirtualNetwork(network); err == nil {
+					vnm.InvalidateIPPool(n.ID)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("CreateNode() error = %v", err)
+	}
+
+	for _, name := range networks {
+		nodes, err := vnm.ListNodes(name)
+		if err != nil {
+			t.Fatalf("ListNodes(%s) error = %v", name, err)
+		}
+		seen := make(map[string]string)
+		for _, node := range nodes {
+			if other, ok := seen[node.VirtualIP]; ok {
+				t.Errorf("nodes %s and %s of %s share %s", other, node.Name, name, node.VirtualIP)
+			}
+			seen[node.VirtualIP] = node.Name
+		}
+		if len(nodes) != 10 {
+			t.Errorf("network %s has %d nodes, want 10", name, len(nodes))
+		}
+	}
+	if got := vnm.pools.len(); got > 2 {
+		t.Errorf("cache holds %d pools, want at most 2", got)
+	}
+}
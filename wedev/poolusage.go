@@ -0,0 +1,128 @@
+package wedev
+
+import (
+	"fmt"
+
+	"github.com/wedevctl/util"
+)
+
+// DefaultPoolWarnPercent is the IP pool utilization, in percent, above which
+// adding a node warns when the network sets no threshold of its own.
+const DefaultPoolWarnPercent = 90
+
+// PoolUsage is how much of a network's virtual IP pool is taken.
+type PoolUsage struct {
+	Allocated int `json:"allocated"`          // addresses held, the reserved server address included
+	Recycled  int `json:"recycled"`           // released addresses waiting to be handed out again
+	Reserved  int `json:"reserved,omitempty"` // addresses kept from allocation by 'ip reserve'
+	Total     int `json:"total"`              // usable addresses in the network CIDR
+}
+
+// Percent returns the share of the pool allocated or reserved, from 0 to 100.
+func (u PoolUsage) Percent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return float64(u.Allocated+u.Reserved) * 100 / float64(u.Total)
+}
+
+// NearlyExhausted reports whether the allocated and reserved share of the
+// pool has reached threshold percent.
+func (u PoolUsage) NearlyExhausted(threshold int) bool {
+	return u.Percent() >= float64(threshold)
+}
+
+// poolUsage returns the utilization of an IP pool.
+func poolUsage(pool *util.IPPool) PoolUsage {
+	allocated, recycled, total := pool.Utilization()
+	return PoolUsage{Allocated: allocated, Recycled: recycled, Reserved: pool.ReservedCount(), Total: total}
+}
+
+// PoolUsage returns the utilization of a network's IP pool. It reads the
+// saved pool state, or rebuilds it from the records when none is saved, and
+// changes nothing, so it works on a database opened read-only.
+func (vnm *VirtualNetworkManager) PoolUsage(networkName string) (*PoolUsage, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := vnm.readIPPool(network)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := poolUsage(pool)
+	return &usage, nil
+}
+
+// readIPPool returns a network's IP pool as saved, or rebuilt from the
+// records when no state is saved, without caching or saving it.
+func (vnm *VirtualNetworkManager) readIPPool(network *VirtualNetwork) (*util.IPPool, error) {
+	if err := checkNetworkCIDR(network); err != nil {
+		return nil, err
+	}
+	var pool *util.IPPool
+	var err error
+	if state, stateErr := vnm.storage.GetIPPoolState(network.ID); stateErr == nil {
+		pool, err = util.RestoreIPPool(state)
+	}
+	if pool == nil {
+		pool, err = vnm.rebuildIPPool(network)
+	}
+	return pool, err
+}
+
+// SetMaxNodes sets how many nodes the network may hold; 0 removes the limit.
+// A limit below the current node count is rejected.
+func (vnm *VirtualNetworkManager) SetMaxNodes(name string, maxNodes int) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{MaxNodes: &maxNodes})
+}
+
+// checkMaxNodes returns an error if maxNodes is not a valid node limit for
+// the network as it is now.
+func (vnm *VirtualNetworkManager) checkMaxNodes(network *VirtualNetwork, maxNodes int) error {
+	if maxNodes < 0 {
+		return kindErrorf(ErrValidation, "max nodes must not be negative, got %d", maxNodes)
+	}
+	if maxNodes > 0 {
+		nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+		if err != nil {
+			return err
+		}
+		if len(nodes) > maxNodes {
+			return kindErrorf(ErrValidation, "network %q already has %d nodes, more than the limit of %d", network.Name, len(nodes), maxNodes)
+		}
+	}
+	return nil
+}
+
+// SetPoolWarnPercent sets the IP pool utilization, in percent, at which
+// adding a node to the network warns; 0 restores DefaultPoolWarnPercent.
+func (vnm *VirtualNetworkManager) SetPoolWarnPercent(name string, percent int) (*VirtualNetwork, error) {
+	return vnm.EditNetwork(name, NetworkEdit{PoolWarnPercent: &percent})
+}
+
+// checkNodeLimit returns an error if the network already holds as many nodes
+// as its MaxNodes allows.
+func (vnm *VirtualNetworkManager) checkNodeLimit(network *VirtualNetwork) error {
+	if network.MaxNodes == 0 {
+		return nil
+	}
+	nodes, err := vnm.storage.ListNodesByNetworkID(network.ID)
+	if err != nil {
+		return fmt.Errorf("failed to count nodes: %w", err)
+	}
+	if len(nodes) >= network.MaxNodes {
+		return kindErrorf(ErrValidation, "network %q has reached its limit of %d nodes", network.Name, network.MaxNodes)
+	}
+	return nil
+}
+
+// warnPoolUsage logs a warning when the network's IP pool has reached its
+// warning threshold.
+func (vnm *VirtualNetworkManager) warnPoolUsage(network *VirtualNetwork, pool *util.IPPool) {
+	usage := poolUsage(pool)
+	if usage.NearlyExhausted(network.PoolWarnThreshold()) {
+		vnm.logger.Warn("IP pool nearly exhausted", "network", network.Name, "used", usage.Allocated, "total", usage.Total, "percent", fmt.Sprintf("%.0f", usage.Percent()))
+	}
+}
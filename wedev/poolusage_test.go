@@ -0,0 +1,123 @@
+package wedev
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestCreateNode_MaxNodes(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("capped", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.SetMaxNodes("capped", 2); err != nil {
+		t.Fatalf("SetMaxNodes() error = %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := vnm.CreateNode("capped", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+
+	_, err := vnm.CreateNode("capped", "c", "", 0, NodeTypeRoute)
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "limit of 2 nodes") {
+		t.Fatalf("CreateNode() past the limit error = %v, want the node limit", err)
+	}
+	if _, err := vnm.SetMaxNodes("capped", 1); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetMaxNodes() below the node count error = %v, want ErrValidation", err)
+	}
+	if _, err := vnm.SetMaxNodes("capped", -1); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetMaxNodes(-1) error = %v, want ErrValidation", err)
+	}
+
+	// Removing the limit lets the node in.
+	if _, err := vnm.SetMaxNodes("capped", 0); err != nil {
+		t.Fatalf("SetMaxNodes(0) error = %v", err)
+	}
+	if _, err := vnm.CreateNode("capped", "c", "", 0, NodeTypeRoute); err != nil {
+		t.Errorf("CreateNode() without a limit error = %v", err)
+	}
+}
+
+func TestPoolUsage(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("usage", "10.0.0.0/29"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	// Before any change no pool state is saved; usage is rebuilt.
+	usage, err := vnm.PoolUsage("usage")
+	if err != nil {
+		t.Fatalf("PoolUsage() error = %v", err)
+	}
+	if want := (PoolUsage{Allocated: 1, Total: 6}); *usage != want {
+		t.Errorf("PoolUsage() of an empty network = %+v, want %+v", *usage, want)
+	}
+
+	if _, err := vnm.CreateServer("usage", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := vnm.CreateNode("usage", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if err := vnm.DeleteNode("usage", "b"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+
+	usage, err = vnm.PoolUsage("usage")
+	if err != nil {
+		t.Fatalf("PoolUsage() error = %v", err)
+	}
+	if want := (PoolUsage{Allocated: 3, Recycled: 1, Total: 6}); *usage != want {
+		t.Errorf("PoolUsage() = %+v, want %+v", *usage, want)
+	}
+	if got := usage.Percent(); got != 50 {
+		t.Errorf("Percent() = %v, want 50", got)
+	}
+	if !usage.NearlyExhausted(50) || usage.NearlyExhausted(51) {
+		t.Errorf("NearlyExhausted() at 50%% should hold for 50 and not 51")
+	}
+	if _, err := vnm.PoolUsage("ghost"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PoolUsage() for an unknown network error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateNode_PoolWarning(t *testing.T) {
+	vnm, _, buf := newLoggedManager(t, slog.LevelWarn)
+	// A /29 holds six addresses: the server's and five nodes'.
+	if _, err := vnm.CreateVirtualNetwork("small", "10.0.0.0/29"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.SetPoolWarnPercent("small", 101); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetPoolWarnPercent(101) error = %v, want ErrValidation", err)
+	}
+
+	// 4 of 6 is below the default 90%.
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := vnm.CreateNode("small", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("no warning expected below the threshold, got:\n%s", buf.String())
+	}
+
+	// With the threshold lowered to 75%, 5 of 6 warns.
+	network, err := vnm.SetPoolWarnPercent("small", 75)
+	if err != nil {
+		t.Fatalf("SetPoolWarnPercent() error = %v", err)
+	}
+	if got := network.PoolWarnThreshold(); got != 75 {
+		t.Errorf("PoolWarnThreshold() = %d, want 75", got)
+	}
+	if _, err := vnm.CreateNode("small", "d", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(d) error = %v", err)
+	}
+	if want := `msg="IP pool nearly exhausted" network=small used=5 total=6 percent=83`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log is missing %s:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,34 @@
+package wedev
+
+import "strings"
+
+// Redacted replaces secret values in redacted config output.
+const Redacted = "(redacted)"
+
+// secretConfigKeys are the WireGuard config keys whose values are secrets.
+var secretConfigKeys = map[string]bool{
+	"PrivateKey":   true,
+	"PresharedKey": true,
+}
+
+// RedactConfig returns content with the values of PrivateKey and
+// PresharedKey lines replaced by "(redacted)". Everything else, including a
+// missing trailing newline, is preserved. Lines of a unified diff of configs
+// (prefixed by ' ', '+', or '-') are redacted too.
+func RedactConfig(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		key, _, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		name := strings.TrimSpace(key)
+		if len(name) > 0 && strings.ContainsRune(" +-", rune(name[0])) {
+			name = strings.TrimSpace(name[1:])
+		}
+		if secretConfigKeys[name] {
+			lines[i] = key + "= " + Redacted
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,55 @@
+package wedev
+
+import "testing"
+
+func TestRedactConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "interface and peer keys",
+			content: "[Interface]\nPrivateKey = c2VjcmV0\nAddress = 10.0.0.2/32\n\n[Peer]\nPublicKey = cHVibGlj\nPresharedKey = cHNr\n",
+			want:    "[Interface]\nPrivateKey = (redacted)\nAddress = 10.0.0.2/32\n\n[Peer]\nPublicKey = cHVibGlj\nPresharedKey = (redacted)\n",
+		},
+		{
+			name:    "multiple key lines",
+			content: "PrivateKey = a\nPresharedKey = b\nPresharedKey = c\n",
+			want:    "PrivateKey = (redacted)\nPresharedKey = (redacted)\nPresharedKey = (redacted)\n",
+		},
+		{
+			name:    "key at end of file without trailing newline",
+			content: "[Peer]\nPresharedKey = cHNr",
+			want:    "[Peer]\nPresharedKey = (redacted)",
+		},
+		{
+			name:    "no spaces around equals",
+			content: "PrivateKey=c2VjcmV0=\n",
+			want:    "PrivateKey= (redacted)\n",
+		},
+		{
+			name:    "base64 padding in public key is kept",
+			content: "PublicKey = cHVibGljIGtleQ==\n",
+			want:    "PublicKey = cHVibGljIGtleQ==\n",
+		},
+		{
+			name:    "unified diff lines",
+			content: "@@ -1,2 +1,2 @@\n [Interface]\n-PrivateKey = old\n+PrivateKey = new\n",
+			want:    "@@ -1,2 +1,2 @@\n [Interface]\n-PrivateKey = (redacted)\n+PrivateKey = (redacted)\n",
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactConfig(tt.content); got != tt.want {
+				t.Errorf("RedactConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package wedev
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wedevctl/util"
+)
+
+// ConfigFormat is the dialect configs are written in.
+type ConfigFormat string
+
+const (
+	// ConfigWGQuick is the wg-quick config generated and versioned by
+	// default.
+	ConfigWGQuick ConfigFormat = "wg-quick"
+	// ConfigWG is the subset 'wg setconf' accepts: the [Interface] section
+	// keeps only PrivateKey, ListenPort and FwMark.
+	ConfigWG ConfigFormat = "wg"
+)
+
+// ParseConfigFormat validates a config format name.
+func ParseConfigFormat(s string) (ConfigFormat, error) {
+	switch ConfigFormat(s) {
+	case ConfigWGQuick, ConfigWG:
+		return ConfigFormat(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid config format: %s (must be '%s' or '%s')", s, ConfigWGQuick, ConfigWG)
+}
+
+// SetconfFiles is a wg-quick config translated for 'wg setconf'.
+type SetconfFiles struct {
+	Config string // the config without the wg-quick [Interface] keys
+	// Addresses lists the Address, DNS and MTU values taken out of Config,
+	// for setting up the interface by other means.
+	Addresses string
+	// Skipped are the other wg-quick keys taken out, such as PostUp, as
+	// "Key = Value". They are also listed in a comment at the end of
+	// Addresses.
+	Skipped []string
+}
+
+// setconfInterfaceKeys are the [Interface] keys 'wg setconf' accepts.
+var setconfInterfaceKeys = map[string]bool{"privatekey": true, "listenport": true, "fwmark": true}
+
+// TranslateSetconf translates a generated wg-quick config into the config
+// 'wg setconf' applies, which rejects the keys wg-quick adds to
+// [Interface]. Comments and [Peer] sections are kept as they are; the
+// config's content hash, and so its versions, are those of the wg-quick
+// config it came from.
+func TranslateSetconf(config string) (*SetconfFiles, error) {
+	sections, err := util.ParseWireGuardConfig(config)
+	if err != nil {
+		return nil, kindErrorf(ErrValidation, "failed to parse config: %v", err)
+	}
+	if len(sections) == 0 || sections[0].Name != "Interface" {
+		return nil, kindErrorf(ErrValidation, "config does not start with an [Interface] section")
+	}
+
+	lines := strings.Split(config, "\n")
+	var addresses strings.Builder
+	if strings.HasPrefix(config, configHeaderPrefix) {
+		addresses.WriteString(lines[0] + "\n")
+	}
+	addresses.WriteString("# Interface settings 'wg setconf' does not apply; set them up with ip(8) and resolvconf(8)\n")
+
+	files := &SetconfFiles{}
+	dropped := make(map[int]bool)
+	for _, entry := range sections[0].Entries {
+		key := strings.ToLower(entry.Key)
+		if setconfInterfaceKeys[key] {
+			continue
+		}
+		dropped[entry.Line] = true
+		switch key {
+		case "address", "dns", "mtu":
+			fmt.Fprintf(&addresses, "%s = %s\n", entry.Key, entry.Value)
+		default:
+			files.Skipped = append(files.Skipped, entry.Key+" = "+entry.Value)
+		}
+	}
+	if len(files.Skipped) > 0 {
+		addresses.WriteString("\n# 'wg setconf' does not run these wg-quick settings; apply them yourself:\n")
+		for _, skipped := range files.Skipped {
+			fmt.Fprintf(&addresses, "#   %s\n", skipped)
+		}
+	}
+
+	var out strings.Builder
+	out.Grow(len(config))
+	for i, line := range lines {
+		if dropped[i+1] {
+			continue
+		}
+		out.WriteString(line)
+		if i < len(lines)-1 {
+			out.WriteString("\n")
+		}
+	}
+	files.Config = out.String()
+	files.Addresses = addresses.String()
+	return files, nil
+}
+
+// AddressesFilename returns the name of the file listing the interface
+// settings of the config written to filename: the name with ".conf"
+// replaced by ".addresses".
+func AddressesFilename(filename string) string {
+	return strings.TrimSuffix(filename, ".conf") + ".addresses"
+}
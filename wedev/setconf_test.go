@@ -0,0 +1,69 @@
+package wedev
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFormat(t *testing.T) {
+	for _, s := range []string{"wg-quick", "wg"} {
+		if got, err := ParseConfigFormat(s); err != nil || string(got) != s {
+			t.Errorf("ParseConfigFormat(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseConfigFormat("setconf"); !errors.Is(err, ErrValidation) {
+		t.Errorf("ParseConfigFormat(setconf) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestTranslateSetconf_Golden(t *testing.T) {
+	for name, config := range map[string]string{"server": networkdServerConfig, "node": networkdNodeConfig} {
+		t.Run(name, func(t *testing.T) {
+			files, err := TranslateSetconf(config)
+			if err != nil {
+				t.Fatalf("TranslateSetconf() error = %v", err)
+			}
+			checkGolden(t, "setconf_"+name+".conf.golden", files.Config)
+			checkGolden(t, "setconf_"+name+".addresses.golden", files.Addresses)
+		})
+	}
+}
+
+func TestTranslateSetconf_Skipped(t *testing.T) {
+	files, err := TranslateSetconf(networkdServerConfig)
+	if err != nil {
+		t.Fatalf("TranslateSetconf() error = %v", err)
+	}
+	if len(files.Skipped) != 4 || files.Skipped[0] != "PostUp = sysctl -w net.ipv4.ip_forward=1" {
+		t.Errorf("Skipped = %q, want the four PostUp and PostDown commands", files.Skipped)
+	}
+	if _, err := TranslateSetconf("[Peer]\nPublicKey = x\n"); !errors.Is(err, ErrValidation) {
+		t.Errorf("TranslateSetconf(no interface) error = %v, want ErrValidation", err)
+	}
+}
+
+func TestTranslateSetconf_GeneratedConfigs(t *testing.T) {
+	vnm := newFirewallNetwork(t)
+	gen := NewWireGuardConfigGenerator(vnm.storage)
+	configs, _, err := gen.GenerateConfigs("office", vnm.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for name, config := range configs {
+		files, err := TranslateSetconf(config)
+		if err != nil {
+			t.Errorf("TranslateSetconf(%s) error = %v", name, err)
+			continue
+		}
+		if strings.Contains(files.Config, "Address =") || !strings.Contains(files.Addresses, "Address =") {
+			t.Errorf("TranslateSetconf(%s) kept Address in the config:\n%s", name, files.Config)
+		}
+		if strings.Count(files.Config, "[Peer]") != strings.Count(config, "[Peer]") {
+			t.Errorf("TranslateSetconf(%s) lost peers:\n%s", name, files.Config)
+		}
+	}
+	if got := AddressesFilename("office-hub.conf"); got != "office-hub.addresses" {
+		t.Errorf("AddressesFilename() = %q", got)
+	}
+}
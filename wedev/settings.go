@@ -0,0 +1,342 @@
+package wedev
+
+import (
+	"context"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Keys of the network settings wedevctl knows. Other keys can be stored with
+// SetNetworkSetting's raw option but mean nothing to wedevctl itself.
+const (
+	// SettingKeepalive is the PersistentKeepalive interval, in seconds, of
+	// the peers of route and client nodes; 0 turns it off.
+	SettingKeepalive = "keepalive"
+	// SettingMTU is the MTU written into every config of the network; unset
+	// leaves it to wg-quick.
+	SettingMTU = "mtu"
+	// SettingDNS is the comma-separated DNS servers written into the node
+	// configs of the network.
+	SettingDNS = "dns"
+	// SettingTopology is how the network's nodes peer; see Topology.
+	SettingTopology = "topology"
+	// SettingFilenameTemplate names the network's config files; see
+	// ParseFilenameTemplate.
+	SettingFilenameTemplate = "filename-template"
+	// SettingHistoryRetention is how many revisions of each server and node
+	// of the network are kept; unset keeps the database's limit.
+	SettingHistoryRetention = "history-retention"
+)
+
+// DefaultKeepalive is the PersistentKeepalive interval, in seconds, used
+// when the network does not set SettingKeepalive.
+const DefaultKeepalive = 25
+
+// SettingDef describes a known network setting: its default and how values
+// are validated.
+type SettingDef struct {
+	Key         string
+	Description string
+	Default     string // empty when unset means "not written"
+	// parse validates a value and returns it in canonical form.
+	parse func(value string) (string, error)
+}
+
+// knownSettings lists the known settings, in the order they are listed.
+var knownSettings = []SettingDef{
+	{
+		Key:         SettingKeepalive,
+		Description: "PersistentKeepalive seconds for peers of route and client nodes (0 disables)",
+		Default:     strconv.Itoa(DefaultKeepalive),
+		parse:       intRange(SettingKeepalive, 0, 65535),
+	},
+	{
+		Key:         SettingMTU,
+		Description: "MTU written into every config (unset leaves it to wg-quick)",
+		parse:       intRange(SettingMTU, 1280, 9000),
+	},
+	{
+		Key:         SettingDNS,
+		Description: "DNS servers written into node configs, comma-separated",
+		parse:       parseDNSSetting,
+	},
+	{
+		Key:         SettingTopology,
+		Description: "How nodes peer: hub-spoke or mesh",
+		Default:     string(TopologyHubSpoke),
+		parse: func(value string) (string, error) {
+			topology, err := ParseTopology(value)
+			return string(topology), err
+		},
+	},
+	{
+		Key:         SettingFilenameTemplate,
+		Description: "Go template config files are named with",
+		Default:     DefaultFilenameTemplate,
+		parse: func(value string) (string, error) {
+			if _, err := ParseFilenameTemplate(value); err != nil {
+				return "", withKind(ErrValidation, err)
+			}
+			return value, nil
+		},
+	},
+	{
+		Key:         SettingHistoryRetention,
+		Description: "Revisions kept per server and node (unset keeps the database's limit)",
+		parse:       intRange(SettingHistoryRetention, 1, 10000),
+	},
+}
+
+// settingKeyPattern matches setting keys: lowercase words of letters and
+// digits joined by dots or hyphens.
+var settingKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9]*([.-][a-z0-9]+)*$`)
+
+// maxSettingKeyLength bounds setting keys.
+const maxSettingKeyLength = 63
+
+// KnownSettings returns the definitions of the known network settings.
+func KnownSettings() []SettingDef {
+	return slices.Clone(knownSettings)
+}
+
+// LookupSetting returns the definition of a known setting.
+func LookupSetting(key string) (SettingDef, bool) {
+	i := slices.IndexFunc(knownSettings, func(def SettingDef) bool { return def.Key == key })
+	if i < 0 {
+		return SettingDef{}, false
+	}
+	return knownSettings[i], true
+}
+
+// ParseSetting validates a value of a known setting and returns it in
+// canonical form.
+func (def SettingDef) ParseSetting(value string) (string, error) {
+	return def.parse(value)
+}
+
+// intRange returns a parser accepting integers from lowest to highest.
+func intRange(key string, lowest, highest int) func(string) (string, error) {
+	return func(value string) (string, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < lowest || n > highest {
+			return "", kindErrorf(ErrValidation, "invalid %s %q: must be a whole number from %d to %d", key, value, lowest, highest)
+		}
+		return strconv.Itoa(n), nil
+	}
+}
+
+// Setting returns the value of a setting of the network and whether it is
+// set; a known setting that is not set returns its default.
+func (n *VirtualNetwork) Setting(key string) (string, bool) {
+	if value, ok := n.Settings[key]; ok {
+		return value, true
+	}
+	if def, ok := LookupSetting(key); ok {
+		return def.Default, false
+	}
+	return "", false
+}
+
+// intSetting returns a known integer setting, or its default. Stored values
+// were validated when set, so a value that does not parse falls back to the
+// default too.
+func (n *VirtualNetwork) intSetting(key string) int {
+	value, _ := n.Setting(key)
+	if value == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		def, _ := LookupSetting(key)
+		//nolint:errcheck // Defaults of integer settings are integers or empty
+		i, _ = strconv.Atoi(def.Default)
+	}
+	return i
+}
+
+// Keepalive returns the PersistentKeepalive interval, in seconds, of the
+// peers of route and client nodes; 0 means none is written.
+func (n *VirtualNetwork) Keepalive() int {
+	return n.intSetting(SettingKeepalive)
+}
+
+// MTU returns the MTU written into the network's configs; 0 means none.
+func (n *VirtualNetwork) MTU() int {
+	return n.intSetting(SettingMTU)
+}
+
+// DNS returns the DNS servers written into the network's node configs.
+func (n *VirtualNetwork) DNS() []string {
+	value, _ := n.Setting(SettingDNS)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// EffectiveTopology returns the network's topology, TopologyHubSpoke when
+// none is set.
+func (n *VirtualNetwork) EffectiveTopology() Topology {
+	value, _ := n.Setting(SettingTopology)
+	return Topology(value)
+}
+
+// FilenameTemplate returns the template the network's config files are
+// named with, DefaultFilenameTemplate when none is set.
+func (n *VirtualNetwork) FilenameTemplate() string {
+	value, _ := n.Setting(SettingFilenameTemplate)
+	return value
+}
+
+// HistoryRetention returns how many revisions of each server and node of
+// the network are kept; 0 means the database's limit.
+func (n *VirtualNetwork) HistoryRetention() int {
+	return n.intSetting(SettingHistoryRetention)
+}
+
+// historyLimit returns the revisions kept per server and node of the
+// network in a database keeping databaseLimit.
+func (n *VirtualNetwork) historyLimit(databaseLimit int) int {
+	if retention := n.HistoryRetention(); retention > 0 {
+		return retention
+	}
+	return databaseLimit
+}
+
+// parseDNSSetting validates a comma-separated list of DNS servers and
+// returns it in canonical form.
+func parseDNSSetting(value string) (string, error) {
+	servers, err := normalizeDNS(strings.Split(value, ","))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(servers, ","), nil
+}
+
+// putSetting stores value under key in the settings of n, removing the key
+// for an empty value.
+func (n *VirtualNetwork) putSetting(key, value string) {
+	if value == "" {
+		delete(n.Settings, key)
+		if len(n.Settings) == 0 {
+			n.Settings = nil
+		}
+		return
+	}
+	if n.Settings == nil {
+		n.Settings = map[string]string{}
+	}
+	n.Settings[key] = value
+}
+
+// NetworkSetting is one setting of a network as 'settings list' shows it.
+type NetworkSetting struct {
+	Key         string `json:"key" yaml:"key"`
+	Value       string `json:"value" yaml:"value"` // the default when not set
+	Set         bool   `json:"set" yaml:"set"`
+	Known       bool   `json:"known" yaml:"known"`
+	Default     string `json:"default,omitempty" yaml:"default,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// networkSetting describes one setting of network.
+func networkSetting(network *VirtualNetwork, key string) NetworkSetting {
+	value, set := network.Setting(key)
+	setting := NetworkSetting{Key: key, Value: value, Set: set}
+	if def, ok := LookupSetting(key); ok {
+		setting.Known, setting.Default, setting.Description = true, def.Default, def.Description
+	}
+	return setting
+}
+
+// NetworkSettingsCtx lists the settings of a network: every known setting,
+// set or not, then the other keys it holds, sorted.
+func (vnm *VirtualNetworkManager) NetworkSettingsCtx(ctx context.Context, name string) ([]NetworkSetting, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]NetworkSetting, 0, len(knownSettings)+len(network.Settings))
+	for _, def := range knownSettings {
+		settings = append(settings, networkSetting(network, def.Key))
+	}
+	for _, key := range slices.Sorted(maps.Keys(network.Settings)) {
+		if _, known := LookupSetting(key); !known {
+			settings = append(settings, networkSetting(network, key))
+		}
+	}
+	return settings, nil
+}
+
+// NetworkSettingCtx returns one setting of a network. A key that is neither
+// known nor set is not found.
+func (vnm *VirtualNetworkManager) NetworkSettingCtx(ctx context.Context, name, key string) (*NetworkSetting, error) {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	setting := networkSetting(network, key)
+	if !setting.Known && !setting.Set {
+		return nil, kindErrorf(ErrNotFound, "setting %q is not set on network %s", key, name)
+	}
+	return &setting, nil
+}
+
+// SetNetworkSetting sets a setting of a network. Values of known settings
+// are validated and stored in canonical form; unknown keys are rejected
+// unless raw is set, which stores the value as it is.
+func (vnm *VirtualNetworkManager) SetNetworkSetting(name, key, value string, raw bool) (*VirtualNetwork, error) {
+	if def, known := LookupSetting(key); known {
+		var err error
+		if value, err = def.ParseSetting(value); err != nil {
+			return nil, err
+		}
+	} else {
+		if !raw {
+			return nil, kindErrorf(ErrValidation, "unknown setting %q (known: %s); use --raw to store it anyway", key, knownSettingKeys())
+		}
+		if len(key) > maxSettingKeyLength || !settingKeyPattern.MatchString(key) {
+			return nil, kindErrorf(ErrValidation, "invalid setting key %q: use lowercase letters and digits, joined by dots or hyphens, at most %d characters", key, maxSettingKeyLength)
+		}
+	}
+
+	network, err := vnm.storage.GetNetworkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.UpdateNetwork(network.ID, func(n *VirtualNetwork) error {
+		if n.Settings == nil {
+			n.Settings = map[string]string{}
+		}
+		n.Settings[key] = value
+		return nil
+	})
+}
+
+// UnsetNetworkSetting removes a setting from a network, restoring the
+// default of a known setting. A key that is not set is not found.
+func (vnm *VirtualNetworkManager) UnsetNetworkSetting(name, key string) (*VirtualNetwork, error) {
+	network, err := vnm.storage.GetNetworkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.UpdateNetwork(network.ID, func(n *VirtualNetwork) error {
+		if _, ok := n.Settings[key]; !ok {
+			return kindErrorf(ErrNotFound, "setting %q is not set on network %s", key, name)
+		}
+		n.putSetting(key, "")
+		return nil
+	})
+}
+
+// knownSettingKeys lists the known setting keys for error messages.
+func knownSettingKeys() string {
+	keys := make([]string, 0, len(knownSettings))
+	for _, def := range knownSettings {
+		keys = append(keys, def.Key)
+	}
+	return strings.Join(keys, ", ")
+}
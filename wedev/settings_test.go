@@ -0,0 +1,320 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+func TestSettingDef_ParseSetting(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{SettingKeepalive, "15", "15", false},
+		{SettingKeepalive, "0", "0", false},
+		{SettingKeepalive, "015", "15", false},
+		{SettingKeepalive, "65535", "65535", false},
+		{SettingKeepalive, "65536", "", true},
+		{SettingKeepalive, "-1", "", true},
+		{SettingKeepalive, "25s", "", true},
+		{SettingKeepalive, "", "", true},
+		{SettingMTU, "1420", "1420", false},
+		{SettingMTU, "1280", "1280", false},
+		{SettingMTU, "1279", "", true},
+		{SettingMTU, "9001", "", true},
+		{SettingMTU, "auto", "", true},
+		{SettingDNS, "10.0.0.1", "10.0.0.1", false},
+		{SettingDNS, " 10.0.0.1 ,2001:DB8::1", "10.0.0.1,2001:db8::1", false},
+		{SettingDNS, "resolver", "", true},
+		{SettingDNS, "", "", true},
+		{SettingTopology, "mesh", "mesh", false},
+		{SettingTopology, "hub-spoke", "hub-spoke", false},
+		{SettingTopology, "ring", "", true},
+		{SettingFilenameTemplate, "wg-{{.Entity}}.conf", "wg-{{.Entity}}.conf", false},
+		{SettingFilenameTemplate, "{{.Bogus}}", "", true},
+		{SettingHistoryRetention, "5", "5", false},
+		{SettingHistoryRetention, "0", "", true},
+	}
+	for _, tt := range tests {
+		def, ok := LookupSetting(tt.key)
+		if !ok {
+			t.Fatalf("LookupSetting(%s) not found", tt.key)
+		}
+		got, err := def.ParseSetting(tt.value)
+		if tt.wantErr {
+			if !errors.Is(err, ErrValidation) {
+				t.Errorf("ParseSetting(%s, %q) error = %v, want ErrValidation", tt.key, tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSetting(%s, %q) = %q, %v, want %q", tt.key, tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, ok := LookupSetting("owner"); ok {
+		t.Error("LookupSetting(owner) found an unknown key")
+	}
+}
+
+func TestVirtualNetwork_SettingDefaults(t *testing.T) {
+	var network VirtualNetwork
+	if got := network.Keepalive(); got != DefaultKeepalive {
+		t.Errorf("Keepalive() unset = %d, want %d", got, DefaultKeepalive)
+	}
+	if got := network.MTU(); got != 0 {
+		t.Errorf("MTU() unset = %d, want 0", got)
+	}
+	if got := network.DNS(); got != nil {
+		t.Errorf("DNS() unset = %v, want none", got)
+	}
+	if got := network.EffectiveTopology(); got != TopologyHubSpoke {
+		t.Errorf("EffectiveTopology() unset = %s, want %s", got, TopologyHubSpoke)
+	}
+	if got := network.FilenameTemplate(); got != DefaultFilenameTemplate {
+		t.Errorf("FilenameTemplate() unset = %q, want %q", got, DefaultFilenameTemplate)
+	}
+	if got := network.historyLimit(20); got != 20 {
+		t.Errorf("historyLimit(20) unset = %d, want 20", got)
+	}
+	if value, set := network.Setting(SettingKeepalive); set || value != "25" {
+		t.Errorf("Setting(keepalive) unset = %q, %v, want the default", value, set)
+	}
+	if value, set := network.Setting("owner"); set || value != "" {
+		t.Errorf("Setting(owner) unset = %q, %v, want nothing", value, set)
+	}
+
+	network.Settings = map[string]string{SettingKeepalive: "0", SettingMTU: "1420", "owner": "ops",
+		SettingDNS: "10.0.0.1,1.1.1.1", SettingTopology: "mesh", SettingFilenameTemplate: "wg-{{.Entity}}.conf", SettingHistoryRetention: "5"}
+	if got := network.Keepalive(); got != 0 {
+		t.Errorf("Keepalive() = %d, want 0", got)
+	}
+	if got := network.DNS(); strings.Join(got, " ") != "10.0.0.1 1.1.1.1" {
+		t.Errorf("DNS() = %v, want both servers", got)
+	}
+	if got := network.EffectiveTopology(); got != TopologyMesh {
+		t.Errorf("EffectiveTopology() = %s, want %s", got, TopologyMesh)
+	}
+	if got := network.FilenameTemplate(); got != "wg-{{.Entity}}.conf" {
+		t.Errorf("FilenameTemplate() = %q", got)
+	}
+	if got := network.historyLimit(20); got != 5 {
+		t.Errorf("historyLimit(20) = %d, want the network's 5", got)
+	}
+	if got := network.MTU(); got != 1420 {
+		t.Errorf("MTU() = %d, want 1420", got)
+	}
+	if value, set := network.Setting("owner"); !set || value != "ops" {
+		t.Errorf("Setting(owner) = %q, %v, want ops", value, set)
+	}
+
+	// A value that bypassed validation falls back to the default.
+	network.Settings[SettingKeepalive] = "often"
+	if got := network.Keepalive(); got != DefaultKeepalive {
+		t.Errorf("Keepalive() of a corrupt value = %d, want %d", got, DefaultKeepalive)
+	}
+}
+
+func TestNetworkSettings(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"bbolt": func(t *testing.T) Storage {
+			_, sm := newTestManager(t)
+			return sm
+		},
+		"memory": func(*testing.T) Storage { return NewMemoryStorage() },
+	}
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			vnm, err := NewVirtualNetworkManager(newStorage(t), util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+			if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+
+			network, err := vnm.SetNetworkSetting("office", SettingKeepalive, "010", false)
+			if err != nil {
+				t.Fatalf("SetNetworkSetting(keepalive) error = %v", err)
+			}
+			if network.Settings[SettingKeepalive] != "10" || network.Keepalive() != 10 {
+				t.Errorf("keepalive = %q, want 10 in canonical form", network.Settings[SettingKeepalive])
+			}
+			if _, err := vnm.SetNetworkSetting("office", SettingMTU, "100", false); !errors.Is(err, ErrValidation) {
+				t.Errorf("SetNetworkSetting(mtu 100) error = %v, want ErrValidation", err)
+			}
+			if _, err := vnm.SetNetworkSetting("office", "owner", "ops", false); !errors.Is(err, ErrValidation) {
+				t.Errorf("SetNetworkSetting(unknown) error = %v, want ErrValidation", err)
+			}
+			for _, key := range []string{"Owner", "owner_team", "1owner", "owner.", strings.Repeat("k", 64)} {
+				if _, err := vnm.SetNetworkSetting("office", key, "ops", true); !errors.Is(err, ErrValidation) {
+					t.Errorf("SetNetworkSetting(raw %q) error = %v, want ErrValidation", key, err)
+				}
+			}
+			if _, err := vnm.SetNetworkSetting("office", "owner.team", "ops", true); err != nil {
+				t.Fatalf("SetNetworkSetting(raw owner.team) error = %v", err)
+			}
+			if _, err := vnm.SetNetworkSetting("ghost", SettingMTU, "1420", false); !errors.Is(err, ErrNotFound) {
+				t.Errorf("SetNetworkSetting() on a missing network error = %v, want ErrNotFound", err)
+			}
+
+			settings, err := vnm.NetworkSettingsCtx(ctx, "office")
+			if err != nil {
+				t.Fatalf("NetworkSettingsCtx() error = %v", err)
+			}
+			want := []NetworkSetting{
+				{Key: SettingKeepalive, Value: "10", Set: true, Known: true, Default: "25"},
+				{Key: SettingMTU, Known: true},
+				{Key: SettingDNS, Known: true},
+				{Key: SettingTopology, Value: "hub-spoke", Known: true, Default: "hub-spoke"},
+				{Key: SettingFilenameTemplate, Value: DefaultFilenameTemplate, Known: true, Default: DefaultFilenameTemplate},
+				{Key: SettingHistoryRetention, Known: true},
+				{Key: "owner.team", Value: "ops", Set: true},
+			}
+			if len(settings) != len(want) {
+				t.Fatalf("NetworkSettingsCtx() = %+v, want %d settings", settings, len(want))
+			}
+			for i, got := range settings {
+				got.Description = ""
+				if got != want[i] {
+					t.Errorf("setting %d = %+v, want %+v", i, got, want[i])
+				}
+			}
+
+			if setting, err := vnm.NetworkSettingCtx(ctx, "office", SettingMTU); err != nil || setting.Set || setting.Value != "" {
+				t.Errorf("NetworkSettingCtx(mtu) = %+v, %v, want known and unset", setting, err)
+			}
+			if _, err := vnm.NetworkSettingCtx(ctx, "office", "owner.name"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("NetworkSettingCtx(unset unknown) error = %v, want ErrNotFound", err)
+			}
+
+			network, err = vnm.UnsetNetworkSetting("office", SettingKeepalive)
+			if err != nil {
+				t.Fatalf("UnsetNetworkSetting() error = %v", err)
+			}
+			if network.Keepalive() != DefaultKeepalive {
+				t.Errorf("Keepalive() after unset = %d, want the default", network.Keepalive())
+			}
+			if _, err := vnm.UnsetNetworkSetting("office", SettingKeepalive); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UnsetNetworkSetting(again) error = %v, want ErrNotFound", err)
+			}
+			if network, err = vnm.UnsetNetworkSetting("office", "owner.team"); err != nil || network.Settings != nil {
+				t.Errorf("UnsetNetworkSetting(last) = %v, %v, want no settings left", network.Settings, err)
+			}
+		})
+	}
+}
+
+// TestNetworkSettings_Persist checks that settings survive reopening the
+// database and are copied by a clone.
+func TestNetworkSettings_Persist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.SetNetworkSetting("office", SettingMTU, "1420", false); err != nil {
+		t.Fatalf("SetNetworkSetting() error = %v", err)
+	}
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	sm, err = NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager(reopen) error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	vnm, err = NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	network, err := sm.GetNetworkByName("office")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	if network.MTU() != 1420 {
+		t.Errorf("MTU() after reopen = %d, want 1420", network.MTU())
+	}
+
+	if _, err := vnm.CloneVirtualNetwork("office", "branch", CloneOptions{CIDR: "10.1.0.0/24"}); err != nil {
+		t.Fatalf("CloneVirtualNetwork() error = %v", err)
+	}
+	clone, err := sm.GetNetworkByName("branch")
+	if err != nil {
+		t.Fatalf("GetNetworkByName(branch) error = %v", err)
+	}
+	if clone.MTU() != 1420 {
+		t.Errorf("MTU() of the clone = %d, want 1420", clone.MTU())
+	}
+}
+
+// TestNetworkSettings_Configs checks that the generator reads the known
+// settings: mtu goes into every config, and keepalive sets or drops the
+// PersistentKeepalive of route nodes.
+func TestNetworkSettings_Configs(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("office", "branch", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(sm)
+
+	configs, _, err := gen.GenerateConfigs("office", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if !strings.Contains(configs["branch"], "PersistentKeepalive = 25\n") || strings.Contains(configs["hub"], "MTU") {
+		t.Errorf("default configs = %q, want keepalive 25 and no MTU", configs)
+	}
+
+	if _, err := vnm.SetNetworkSetting("office", SettingMTU, "1420", false); err != nil {
+		t.Fatalf("SetNetworkSetting(mtu) error = %v", err)
+	}
+	if _, err := vnm.SetNetworkSetting("office", SettingKeepalive, "0", false); err != nil {
+		t.Fatalf("SetNetworkSetting(keepalive) error = %v", err)
+	}
+	configs, _, err = gen.GenerateConfigs("office", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for name, config := range configs {
+		if !strings.Contains(config, "ListenPort = 51820\nMTU = 1420\n") {
+			t.Errorf("%s config has no MTU after ListenPort:\n%s", name, config)
+		}
+		if strings.Contains(config, "PersistentKeepalive") {
+			t.Errorf("%s config keeps PersistentKeepalive with keepalive 0:\n%s", name, config)
+		}
+	}
+
+	if _, err := vnm.SetNetworkSetting("office", SettingKeepalive, "10", false); err != nil {
+		t.Fatalf("SetNetworkSetting(keepalive) error = %v", err)
+	}
+	configs, _, err = gen.GenerateConfigs("office", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if !strings.Contains(configs["branch"], "PersistentKeepalive = 10\n") {
+		t.Errorf("branch config = %q, want PersistentKeepalive = 10", configs["branch"])
+	}
+}
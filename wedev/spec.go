@@ -0,0 +1,850 @@
+package wedev
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/wedevctl/util"
+	"gopkg.in/yaml.v3"
+)
+
+// NetworkSpec is the desired state of one network, as read from a spec file
+// by 'wedevctl apply'. It names no keys or virtual IPs: entities that already
+// exist keep theirs and new ones get generated ones.
+//
+// Ports, default_port and topology may be left out; new entities then get the
+// defaults and existing ones keep their values. Every other field is
+// authoritative, so leaving out labels, dns or routed_cidrs clears them.
+type NetworkSpec struct {
+	Name        string            `yaml:"name"`
+	CIDR        string            `yaml:"cidr"`
+	DNS         []string          `yaml:"dns,omitempty"`
+	DefaultPort int               `yaml:"default_port,omitempty"`
+	Topology    Topology          `yaml:"topology,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Server      *ServerSpec       `yaml:"server,omitempty"` // shorthand for a single entry in Servers
+	Servers     []ServerSpec      `yaml:"servers,omitempty"`
+	Nodes       []NodeSpec        `yaml:"nodes,omitempty"`
+}
+
+// ServerSpec is the desired state of a server in a NetworkSpec.
+type ServerSpec struct {
+	Name            string `yaml:"name"`
+	PublicAddress   string `yaml:"public_address"`
+	Port            int    `yaml:"port,omitempty"`
+	InternalAddress string `yaml:"internal_address,omitempty"`
+	InternalPort    int    `yaml:"internal_port,omitempty"` // 0 means Port
+}
+
+// NodeSpec is the desired state of a node in a NetworkSpec.
+type NodeSpec struct {
+	Name            string            `yaml:"name"`
+	Type            NodeType          `yaml:"type,omitempty"` // empty means NodeTypePeer
+	PublicAddress   string            `yaml:"public_address,omitempty"`
+	Port            int               `yaml:"port,omitempty"`
+	InternalAddress string            `yaml:"internal_address,omitempty"`
+	InternalPort    int               `yaml:"internal_port,omitempty"` // 0 means Port
+	PreferInternal  bool              `yaml:"prefer_internal,omitempty"`
+	RoutedCIDRs     []string          `yaml:"routed_cidrs,omitempty"`
+	Server          string            `yaml:"server,omitempty"` // assigned server; empty means the first one
+	MeshServers     bool              `yaml:"mesh_servers,omitempty"`
+	FullTunnel      bool              `yaml:"full_tunnel,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+}
+
+// ParseNetworkSpec reads a NetworkSpec from YAML. Unknown fields are errors,
+// so a misspelt key (or an attempt to set keys or virtual IPs) is not
+// silently ignored. The servers and nodes are checked against each other;
+// the values themselves are validated when the spec is planned.
+func ParseNetworkSpec(data []byte) (*NetworkSpec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	spec := &NetworkSpec{}
+	if err := dec.Decode(spec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, kindErrorf(ErrValidation, "spec is empty")
+		}
+		return nil, kindErrorf(ErrValidation, "failed to parse spec: %w", err)
+	}
+
+	if spec.Name == "" {
+		return nil, kindErrorf(ErrValidation, "spec has no network name")
+	}
+	if spec.CIDR == "" {
+		return nil, kindErrorf(ErrValidation, "spec has no cidr")
+	}
+	if spec.Server != nil {
+		if len(spec.Servers) > 0 {
+			return nil, kindErrorf(ErrValidation, "spec sets both server and servers; use one")
+		}
+		spec.Servers = []ServerSpec{*spec.Server}
+		spec.Server = nil
+	}
+
+	names := make(map[string]bool)
+	servers := make(map[string]bool)
+	for _, s := range spec.Servers {
+		if names[s.Name] {
+			return nil, kindErrorf(ErrValidation, "name %q is used more than once in the spec", s.Name)
+		}
+		names[s.Name] = true
+		servers[s.Name] = true
+	}
+	for i := range spec.Nodes {
+		n := &spec.Nodes[i]
+		if names[n.Name] {
+			return nil, kindErrorf(ErrValidation, "name %q is used more than once in the spec", n.Name)
+		}
+		names[n.Name] = true
+
+		if n.Type == "" {
+			n.Type = NodeTypePeer
+		}
+		if _, err := ParseNodeType(string(n.Type)); err != nil {
+			return nil, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if n.Server != "" && !servers[n.Server] {
+			return nil, kindErrorf(ErrValidation, "node %q: server %q is not in the spec", n.Name, n.Server)
+		}
+	}
+
+	return spec, nil
+}
+
+// SpecAction is what a SpecChange does to an entity.
+type SpecAction string
+
+const (
+	// SpecActionCreate creates an entity the spec names but storage lacks.
+	SpecActionCreate SpecAction = "create"
+	// SpecActionUpdate changes an entity to match the spec.
+	SpecActionUpdate SpecAction = "update"
+	// SpecActionDelete deletes an entity the spec no longer names.
+	SpecActionDelete SpecAction = "delete"
+)
+
+// SpecChange is one step of a SpecPlan.
+type SpecChange struct {
+	Action  SpecAction
+	Kind    string // "network", "server" or "node"
+	Name    string
+	Details []string // what the step sets, e.g. "port: 51820 -> 51821"
+	run     func() error
+}
+
+// String renders the change as a plan line, e.g. "~ node web1 (port: 51820 -> 51821)".
+func (c SpecChange) String() string {
+	sign := map[SpecAction]string{SpecActionCreate: "+", SpecActionUpdate: "~", SpecActionDelete: "-"}[c.Action]
+	line := fmt.Sprintf("%s %s %s", sign, c.Kind, c.Name)
+	if len(c.Details) > 0 {
+		line += " (" + strings.Join(c.Details, ", ") + ")"
+	}
+	return line
+}
+
+// SpecPlan is the list of changes that make a network match a NetworkSpec.
+type SpecPlan struct {
+	Network   string
+	Changes   []SpecChange
+	Unmanaged []string // "server x" and "node y" missing from the spec, kept because prune is off
+}
+
+// PlanSpec compares spec with the stored network and returns the changes
+// that make them match, without making any. Servers and nodes missing from
+// the spec are deleted only with prune; otherwise they are listed in
+// Unmanaged.
+func (vnm *VirtualNetworkManager) PlanSpec(spec *NetworkSpec, prune bool) (*SpecPlan, error) {
+	return vnm.PlanSpecCtx(context.Background(), spec, prune)
+}
+
+// PlanSpecCtx is PlanSpec with a context.
+func (vnm *VirtualNetworkManager) PlanSpecCtx(ctx context.Context, spec *NetworkSpec, prune bool) (*SpecPlan, error) {
+	if err := vnm.validateSpec(spec); err != nil {
+		return nil, withKind(ErrValidation, err)
+	}
+	dns, err := normalizeDNS(spec.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := vnm.storage.ListNetworksCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var network *VirtualNetwork
+	for _, n := range networks {
+		if n.Name == spec.Name {
+			network = n
+			break
+		}
+	}
+
+	plan := &SpecPlan{Network: spec.Name}
+	var servers []*Server
+	var nodes []*Node
+	nodePort := spec.DefaultPort
+	if network == nil {
+		plan.Changes = append(plan.Changes, vnm.planNetworkCreate(spec, dns))
+		if nodePort == 0 {
+			nodePort = DefaultWireGuardPort
+		}
+	} else {
+		if change, ok := vnm.planNetworkUpdate(network, spec, dns); ok {
+			plan.Changes = append(plan.Changes, change)
+		}
+		if nodePort == 0 {
+			nodePort = network.NodePort()
+		}
+		if servers, err = vnm.storage.ListServersByNetworkIDCtx(ctx, network.ID); err != nil {
+			return nil, err
+		}
+		if nodes, err = vnm.storage.ListNodesByNetworkIDCtx(ctx, network.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	serversByName := make(map[string]*Server, len(servers))
+	serverNames := make(map[string]string, len(servers)) // ID -> name
+	for _, s := range servers {
+		serversByName[s.Name] = s
+		serverNames[s.ID] = s.Name
+	}
+	nodesByName := make(map[string]*Node, len(nodes))
+	for _, n := range nodes {
+		nodesByName[n.Name] = n
+	}
+
+	specNames := make(map[string]bool)
+	for _, s := range spec.Servers {
+		specNames[s.Name] = true
+		if change, ok := vnm.planServer(spec.Name, s, serversByName[s.Name]); ok {
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	for _, n := range spec.Nodes {
+		specNames[n.Name] = true
+	}
+
+	// Deleting nodes first frees their IPs for the nodes created after them.
+	for _, n := range nodes {
+		if specNames[n.Name] {
+			continue
+		}
+		if !prune {
+			plan.Unmanaged = append(plan.Unmanaged, "node "+n.Name)
+			continue
+		}
+		plan.Changes = append(plan.Changes, SpecChange{
+			Action: SpecActionDelete, Kind: "node", Name: n.Name,
+			run: func() error { return vnm.DeleteNode(spec.Name, n.Name) },
+		})
+	}
+	for _, n := range spec.Nodes {
+		if change, ok := vnm.planNode(spec.Name, n, nodesByName[n.Name], nodePort, serverNames); ok {
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	// Servers go last, once no node in the spec is assigned to them.
+	for _, s := range servers {
+		if specNames[s.Name] {
+			continue
+		}
+		if !prune {
+			plan.Unmanaged = append(plan.Unmanaged, "server "+s.Name)
+			continue
+		}
+		plan.Changes = append(plan.Changes, SpecChange{
+			Action: SpecActionDelete, Kind: "server", Name: s.Name,
+			run: func() error { return vnm.DeleteServer(spec.Name, DeleteServerOptions{Name: s.Name, KeepNodes: true}) },
+		})
+	}
+
+	return plan, nil
+}
+
+// ApplySpec makes the changes of plan, in order. It stops at the first
+// failure; the changes made before it stay.
+func (vnm *VirtualNetworkManager) ApplySpec(plan *SpecPlan) error {
+	return vnm.ApplySpecCtx(context.Background(), plan)
+}
+
+// ApplySpecCtx is ApplySpec with a context, checked before each change. A
+// network whose config is frozen is left unchanged (ErrFrozen), as no
+// version of the result could be saved.
+func (vnm *VirtualNetworkManager) ApplySpecCtx(ctx context.Context, plan *SpecPlan) error {
+	network, err := vnm.storage.GetNetworkByNameCtx(ctx, plan.Network)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		if err := checkFrozen(network); err != nil {
+			return err
+		}
+	}
+	for _, change := range plan.Changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := change.run(); err != nil {
+			return fmt.Errorf("failed to %s %s %s: %w", change.Action, change.Kind, change.Name, err)
+		}
+	}
+	return nil
+}
+
+// PreviewSpec applies spec to an in-memory copy of its network and saves a
+// config version there, to show what 'apply' would save without changing
+// anything. It returns that version, with the configs it changes in
+// Changed, and whether it would be saved at all: false when the configs
+// come out unchanged.
+func (vnm *VirtualNetworkManager) PreviewSpec(spec *NetworkSpec, prune bool) (*ConfigVersion, bool, error) {
+	return vnm.PreviewSpecCtx(context.Background(), spec, prune)
+}
+
+// PreviewSpecCtx is PreviewSpec with a context.
+func (vnm *VirtualNetworkManager) PreviewSpecCtx(ctx context.Context, spec *NetworkSpec, prune bool) (*ConfigVersion, bool, error) {
+	// The copy's changes are not worth logging; they are thrown away.
+	mem := NewMemoryStorageWithOptions(StorageOptions{Logger: slog.New(slog.DiscardHandler)})
+	if err := mem.CopyNetwork(ctx, vnm.storage, spec.Name); err != nil {
+		return nil, false, fmt.Errorf("failed to copy network: %w", err)
+	}
+	dry := &VirtualNetworkManager{
+		storage:   mem,
+		pools:     newIPPoolCache(DefaultIPPoolCacheSize),
+		validator: vnm.validator,
+		logger:    mem.Logger(),
+		now:       vnm.now,
+	}
+
+	plan, err := dry.PlanSpecCtx(ctx, spec, prune)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := dry.ApplySpecCtx(ctx, plan); err != nil {
+		return nil, false, err
+	}
+	gen := NewWireGuardConfigGenerator(mem)
+	gen.now = vnm.now
+	return gen.SaveConfigVersionWithMessageCtx(ctx, spec.Name, "")
+}
+
+// validateSpec checks the values of a spec before anything is planned, so a
+// bad entry fails the whole apply instead of leaving it half done.
+func (vnm *VirtualNetworkManager) validateSpec(spec *NetworkSpec) error {
+	if err := vnm.validator.IsValidNetworkName(spec.Name); err != nil {
+		return err
+	}
+	if reservedNetworkNames[spec.Name] {
+		return fmt.Errorf("network name %q is reserved (it collides with a CLI command)", spec.Name)
+	}
+	if err := vnm.validator.IsValidCIDR(spec.CIDR); err != nil {
+		return err
+	}
+	if spec.DefaultPort != 0 {
+		if err := util.ValidatePort(spec.DefaultPort); err != nil {
+			return err
+		}
+	}
+	if spec.Topology != "" {
+		if _, err := ParseTopology(string(spec.Topology)); err != nil {
+			return err
+		}
+	}
+	if err := validateSpecLabels(spec.Labels); err != nil {
+		return err
+	}
+
+	for _, s := range spec.Servers {
+		if err := vnm.validator.IsValidNetworkName(s.Name); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+		if err := vnm.validatePublicAddress(spec.CIDR, s.PublicAddress); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+		if s.Port != 0 {
+			if err := util.ValidatePort(s.Port); err != nil {
+				return fmt.Errorf("server %q: %w", s.Name, err)
+			}
+		}
+		if err := vnm.validateInternalEndpoint(s.InternalAddress, s.InternalPort); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+	}
+
+	for _, n := range spec.Nodes {
+		if err := vnm.validator.IsValidNetworkName(n.Name); err != nil {
+			return fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if err := validateNodeTypeAddress(n.Type, n.PublicAddress); err != nil {
+			return fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if n.PublicAddress != "" {
+			if err := vnm.validatePublicAddress(spec.CIDR, n.PublicAddress); err != nil {
+				return fmt.Errorf("node %q: %w", n.Name, err)
+			}
+		}
+		if n.Port != 0 {
+			if err := util.ValidatePort(n.Port); err != nil {
+				return fmt.Errorf("node %q: %w", n.Name, err)
+			}
+		}
+		if err := vnm.validateInternalEndpoint(n.InternalAddress, n.InternalPort); err != nil {
+			return fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if len(n.RoutedCIDRs) > 0 && n.Type != NodeTypeRoute {
+			return fmt.Errorf("node %q: routed CIDRs are only supported for route nodes", n.Name)
+		}
+		if _, err := canonicalCIDRs(n.RoutedCIDRs); err != nil {
+			return fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if err := validateSpecLabels(n.Labels); err != nil {
+			return fmt.Errorf("node %q: %w", n.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateSpecLabels checks the keys of a spec's labels.
+func validateSpecLabels(labels map[string]string) error {
+	for k := range labels {
+		if err := util.ValidateLabelKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalCIDRs returns cidrs with their host bits cleared, the form routed
+// CIDRs are stored in.
+func canonicalCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR notation: %w", err)
+		}
+		out = append(out, prefix.Masked().String())
+	}
+	return out, nil
+}
+
+// planNetworkCreate returns the change that creates the spec's network with
+// its settings.
+func (vnm *VirtualNetworkManager) planNetworkCreate(spec *NetworkSpec, dns []string) SpecChange {
+	details := []string{"cidr: " + spec.CIDR}
+	if spec.DefaultPort != 0 {
+		details = append(details, fmt.Sprintf("default_port: %d", spec.DefaultPort))
+	}
+	if spec.Topology != "" {
+		details = append(details, "topology: "+string(spec.Topology))
+	}
+	if len(dns) > 0 {
+		details = append(details, "dns: "+strings.Join(dns, ", "))
+	}
+	if len(spec.Labels) > 0 {
+		details = append(details, "labels: "+formatSpecLabels(spec.Labels))
+	}
+
+	return SpecChange{
+		Action: SpecActionCreate, Kind: "network", Name: spec.Name, Details: details,
+		run: func() error {
+			if _, err := vnm.CreateVirtualNetwork(spec.Name, spec.CIDR); err != nil {
+				return err
+			}
+			if spec.DefaultPort != 0 {
+				if _, err := vnm.SetDefaultPort(spec.Name, spec.DefaultPort); err != nil {
+					return err
+				}
+			}
+			if spec.Topology != "" {
+				if _, err := vnm.SetTopology(spec.Name, spec.Topology); err != nil {
+					return err
+				}
+			}
+			if len(dns) > 0 {
+				if _, err := vnm.SetDNS(spec.Name, dns); err != nil {
+					return err
+				}
+			}
+			if len(spec.Labels) > 0 {
+				if _, err := vnm.UpdateVirtualNetworkLabels(spec.Name, spec.Labels, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// planNetworkUpdate returns the change that brings an existing network's
+// settings in line with the spec, and false when they already match.
+func (vnm *VirtualNetworkManager) planNetworkUpdate(network *VirtualNetwork, spec *NetworkSpec, dns []string) (SpecChange, bool) {
+	var details []string
+	var steps []func() error
+
+	if network.CIDR != spec.CIDR {
+		details = append(details, fmt.Sprintf("cidr: %s -> %s", network.CIDR, spec.CIDR))
+		steps = append(steps, func() error {
+			_, _, err := vnm.ResizeNetwork(spec.Name, spec.CIDR)
+			return err
+		})
+	}
+	if spec.DefaultPort != 0 && network.NodePort() != spec.DefaultPort {
+		details = append(details, fmt.Sprintf("default_port: %d -> %d", network.NodePort(), spec.DefaultPort))
+		steps = append(steps, func() error {
+			_, err := vnm.SetDefaultPort(spec.Name, spec.DefaultPort)
+			return err
+		})
+	}
+	if spec.Topology != "" && network.EffectiveTopology() != spec.Topology {
+		details = append(details, fmt.Sprintf("topology: %s -> %s", network.EffectiveTopology(), spec.Topology))
+		steps = append(steps, func() error {
+			_, err := vnm.SetTopology(spec.Name, spec.Topology)
+			return err
+		})
+	}
+	if !slices.Equal(network.DNS(), dns) {
+		details = append(details, fmt.Sprintf("dns: %s -> %s", formatSpecList(network.DNS()), formatSpecList(dns)))
+		steps = append(steps, func() error {
+			_, err := vnm.SetDNS(spec.Name, dns)
+			return err
+		})
+	}
+	if !labelsEqual(network.Labels, spec.Labels) {
+		details = append(details, fmt.Sprintf("labels: %s -> %s", formatSpecLabels(network.Labels), formatSpecLabels(spec.Labels)))
+		remove := removedLabels(network.Labels, spec.Labels)
+		steps = append(steps, func() error {
+			_, err := vnm.UpdateVirtualNetworkLabels(spec.Name, spec.Labels, remove)
+			return err
+		})
+	}
+
+	if len(steps) == 0 {
+		return SpecChange{}, false
+	}
+	return SpecChange{
+		Action: SpecActionUpdate, Kind: "network", Name: spec.Name, Details: details,
+		run: runSteps(steps),
+	}, true
+}
+
+// planServer returns the change that creates or updates a server of the
+// spec, and false when the stored server already matches.
+func (vnm *VirtualNetworkManager) planServer(networkName string, s ServerSpec, current *Server) (SpecChange, bool) {
+	if current == nil {
+		port := s.Port
+		if port == 0 {
+			port = DefaultWireGuardPort
+		}
+		details := []string{"endpoint: " + util.FormatEndpoint(s.PublicAddress, port)}
+		if s.InternalAddress != "" {
+			details = append(details, "internal_endpoint: "+formatInternalEndpoint(s.InternalAddress, s.InternalPort))
+		}
+		return SpecChange{
+			Action: SpecActionCreate, Kind: "server", Name: s.Name, Details: details,
+			run: func() error {
+				if _, err := vnm.CreateServer(networkName, s.Name, s.PublicAddress, port); err != nil {
+					return err
+				}
+				if s.InternalAddress != "" {
+					if _, err := vnm.SetServerInternalEndpoint(networkName, s.Name, s.InternalAddress, s.InternalPort); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}, true
+	}
+
+	port := s.Port
+	if port == 0 {
+		port = current.Port
+	}
+	internalPort := s.InternalPort
+	if s.InternalAddress == "" {
+		internalPort = 0
+	}
+
+	var details []string
+	var steps []func() error
+	if current.PublicAddress != s.PublicAddress || current.Port != port {
+		details = append(details, fmt.Sprintf("endpoint: %s -> %s", util.FormatEndpoint(current.PublicAddress, current.Port), util.FormatEndpoint(s.PublicAddress, port)))
+		steps = append(steps, func() error {
+			_, err := vnm.UpdateServer(networkName, s.Name, s.PublicAddress, port)
+			return err
+		})
+	}
+	if current.InternalAddress != s.InternalAddress || current.InternalPort != internalPort {
+		details = append(details, fmt.Sprintf("internal_endpoint: %s -> %s", formatInternalEndpoint(current.InternalAddress, current.InternalPort), formatInternalEndpoint(s.InternalAddress, internalPort)))
+		steps = append(steps, func() error {
+			_, err := vnm.SetServerInternalEndpoint(networkName, s.Name, s.InternalAddress, internalPort)
+			return err
+		})
+	}
+
+	if len(steps) == 0 {
+		return SpecChange{}, false
+	}
+	return SpecChange{
+		Action: SpecActionUpdate, Kind: "server", Name: s.Name, Details: details,
+		run: runSteps(steps),
+	}, true
+}
+
+// planNode returns the change that creates or updates a node of the spec,
+// and false when the stored node already matches. nodePort is the port a
+// new node gets when the spec gives none; serverNames maps server IDs to
+// names.
+func (vnm *VirtualNetworkManager) planNode(networkName string, n NodeSpec, current *Node, nodePort int, serverNames map[string]string) (SpecChange, bool) {
+	//nolint:errcheck // Already validated by validateSpec
+	routed, _ := canonicalCIDRs(n.RoutedCIDRs)
+
+	if current == nil {
+		port := n.Port
+		if port == 0 {
+			port = nodePort
+		}
+		details := []string{"type: " + string(n.Type)}
+		if n.PublicAddress != "" {
+			details = append(details, "endpoint: "+formatSpecEndpoint(n.PublicAddress, port))
+		}
+		if n.InternalAddress != "" {
+			details = append(details, "internal_endpoint: "+formatInternalEndpoint(n.InternalAddress, n.InternalPort))
+		}
+		if n.PreferInternal {
+			details = append(details, "prefer_internal: true")
+		}
+		if len(routed) > 0 {
+			details = append(details, "routed_cidrs: "+strings.Join(routed, ", "))
+		}
+		if n.Server != "" {
+			details = append(details, "server: "+n.Server)
+		}
+		if n.FullTunnel {
+			details = append(details, "full_tunnel: true")
+		}
+		if len(n.Labels) > 0 {
+			details = append(details, "labels: "+formatSpecLabels(n.Labels))
+		}
+		return SpecChange{
+			Action: SpecActionCreate, Kind: "node", Name: n.Name, Details: details,
+			run: func() error {
+				var err error
+				if len(routed) > 0 {
+					_, err = vnm.CreateRouteNode(networkName, n.Name, n.PublicAddress, port, routed)
+				} else {
+					_, err = vnm.CreateNode(networkName, n.Name, n.PublicAddress, port, n.Type)
+				}
+				if err != nil {
+					return err
+				}
+				if len(n.Labels) > 0 {
+					if _, err := vnm.UpdateNodeLabels(networkName, n.Name, n.Labels, nil); err != nil {
+						return err
+					}
+				}
+				if n.Server != "" || n.MeshServers {
+					if _, err := vnm.AssignNodeServer(networkName, n.Name, n.Server, n.MeshServers); err != nil {
+						return err
+					}
+				}
+				if n.FullTunnel {
+					if _, err := vnm.SetNodeFullTunnel(networkName, n.Name, true); err != nil {
+						return err
+					}
+				}
+				if n.InternalAddress != "" {
+					if _, err := vnm.SetNodeInternalEndpoint(networkName, n.Name, n.InternalAddress, n.InternalPort); err != nil {
+						return err
+					}
+				}
+				if n.PreferInternal {
+					if _, err := vnm.SetNodePreferInternal(networkName, n.Name, true); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}, true
+	}
+
+	port := n.Port
+	if port == 0 {
+		port = current.Port
+	}
+
+	var details []string
+	var steps []func() error
+	routesChanged := !slices.Equal(current.RoutedCIDRs, routed)
+	// Routes are cleared before a type change away from route, and set after
+	// one towards it.
+	if routesChanged && len(routed) == 0 {
+		steps = append(steps, func() error {
+			_, err := vnm.SetNodeRoutedCIDRs(networkName, n.Name, nil)
+			return err
+		})
+	}
+	if current.Type != n.Type {
+		details = append(details, fmt.Sprintf("type: %s -> %s", current.Type, n.Type))
+	}
+	if current.PublicAddress != n.PublicAddress || current.Port != port {
+		details = append(details, fmt.Sprintf("endpoint: %s -> %s", formatSpecEndpoint(current.PublicAddress, current.Port), formatSpecEndpoint(n.PublicAddress, port)))
+	}
+	if current.Type != n.Type || current.PublicAddress != n.PublicAddress || current.Port != port {
+		steps = append(steps, func() error {
+			// The spec states the type; the plan shows the change.
+			_, err := vnm.updateNode(networkName, n.Name, n.PublicAddress, port, n.Type, true)
+			return err
+		})
+	}
+	if routesChanged {
+		details = append(details, fmt.Sprintf("routed_cidrs: %s -> %s", formatSpecList(current.RoutedCIDRs), formatSpecList(routed)))
+		if len(routed) > 0 {
+			steps = append(steps, func() error {
+				_, err := vnm.SetNodeRoutedCIDRs(networkName, n.Name, routed)
+				return err
+			})
+		}
+	}
+	if !labelsEqual(current.Labels, n.Labels) {
+		details = append(details, fmt.Sprintf("labels: %s -> %s", formatSpecLabels(current.Labels), formatSpecLabels(n.Labels)))
+		remove := removedLabels(current.Labels, n.Labels)
+		steps = append(steps, func() error {
+			_, err := vnm.UpdateNodeLabels(networkName, n.Name, n.Labels, remove)
+			return err
+		})
+	}
+	if currentServer := serverNames[current.ServerID]; currentServer != n.Server || current.MeshServers != n.MeshServers {
+		if currentServer != n.Server {
+			details = append(details, fmt.Sprintf("server: %s -> %s", formatSpecServer(currentServer), formatSpecServer(n.Server)))
+		}
+		if current.MeshServers != n.MeshServers {
+			details = append(details, fmt.Sprintf("mesh_servers: %t -> %t", current.MeshServers, n.MeshServers))
+		}
+		steps = append(steps, func() error {
+			_, err := vnm.AssignNodeServer(networkName, n.Name, n.Server, n.MeshServers)
+			return err
+		})
+	}
+	if current.FullTunnel != n.FullTunnel {
+		details = append(details, fmt.Sprintf("full_tunnel: %t -> %t", current.FullTunnel, n.FullTunnel))
+		steps = append(steps, func() error {
+			_, err := vnm.SetNodeFullTunnel(networkName, n.Name, n.FullTunnel)
+			return err
+		})
+	}
+	internalPort := n.InternalPort
+	if n.InternalAddress == "" {
+		internalPort = 0
+	}
+	if current.InternalAddress != n.InternalAddress || current.InternalPort != internalPort {
+		details = append(details, fmt.Sprintf("internal_endpoint: %s -> %s", formatInternalEndpoint(current.InternalAddress, current.InternalPort), formatInternalEndpoint(n.InternalAddress, internalPort)))
+		steps = append(steps, func() error {
+			_, err := vnm.SetNodeInternalEndpoint(networkName, n.Name, n.InternalAddress, internalPort)
+			return err
+		})
+	}
+	if current.PreferInternal != n.PreferInternal {
+		details = append(details, fmt.Sprintf("prefer_internal: %t -> %t", current.PreferInternal, n.PreferInternal))
+		steps = append(steps, func() error {
+			_, err := vnm.SetNodePreferInternal(networkName, n.Name, n.PreferInternal)
+			return err
+		})
+	}
+
+	if len(steps) == 0 {
+		return SpecChange{}, false
+	}
+	return SpecChange{
+		Action: SpecActionUpdate, Kind: "node", Name: n.Name, Details: details,
+		run: runSteps(steps),
+	}, true
+}
+
+// runSteps returns a function running steps in order until one fails.
+func runSteps(steps []func() error) func() error {
+	return func() error {
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// labelsEqual reports whether two label sets are equal, treating nil and
+// empty alike.
+func labelsEqual(a, b map[string]string) bool {
+	return len(a) == len(b) && maps.Equal(a, b)
+}
+
+// removedLabels returns the keys of current missing from desired.
+func removedLabels(current, desired map[string]string) []string {
+	var remove []string
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			remove = append(remove, k)
+		}
+	}
+	sort.Strings(remove)
+	return remove
+}
+
+// formatSpecLabels renders labels as "{k=v, ...}" with sorted keys.
+func formatSpecLabels(labels map[string]string) string {
+	keys := slices.Sorted(maps.Keys(labels))
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// formatSpecList renders a list for a plan line, "[]" when empty.
+func formatSpecList(items []string) string {
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// formatSpecEndpoint renders an endpoint for a plan line; a node without a
+// public address shows only its port.
+func formatSpecEndpoint(address string, port int) string {
+	if address == "" {
+		return fmt.Sprintf("port %d", port)
+	}
+	return util.FormatEndpoint(address, port)
+}
+
+// formatInternalEndpoint renders an internal endpoint for a plan line; a
+// port of 0 means the public port.
+func formatInternalEndpoint(address string, port int) string {
+	switch {
+	case address == "":
+		return "(none)"
+	case port == 0:
+		return address + " (public port)"
+	}
+	return util.FormatEndpoint(address, port)
+}
+
+// formatSpecServer renders a node's assigned server for a plan line.
+func formatSpecServer(name string) string {
+	if name == "" {
+		return "(first)"
+	}
+	return name
+}
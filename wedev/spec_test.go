@@ -0,0 +1,223 @@
+package wedev
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testSpec = `
+name: office
+cidr: 10.8.0.0/24
+dns: [10.8.0.1]
+labels: {env: prod}
+server:
+  name: hub
+  public_address: vpn.example.com
+nodes:
+  - name: laptop
+    public_address: 203.0.113.7
+    labels: {team: ops}
+  - name: branch
+    type: route
+    routed_cidrs: [192.168.10.0/24]
+`
+
+func TestParseNetworkSpec(t *testing.T) {
+	spec, err := ParseNetworkSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("ParseNetworkSpec() error = %v", err)
+	}
+	if spec.Server != nil || len(spec.Servers) != 1 || spec.Servers[0].Name != "hub" {
+		t.Errorf("Servers = %+v, want the server shorthand moved into Servers", spec.Servers)
+	}
+	if spec.Nodes[0].Type != NodeTypePeer {
+		t.Errorf("Nodes[0].Type = %q, want peer by default", spec.Nodes[0].Type)
+	}
+
+	for name, data := range map[string]string{
+		"empty":          "",
+		"no name":        "cidr: 10.0.0.0/24",
+		"no cidr":        "name: office",
+		"unknown field":  "name: office\ncidr: 10.0.0.0/24\nnodes: [{name: a, private_key: x}]",
+		"virtual ip":     "name: office\ncidr: 10.0.0.0/24\nserver: {name: hub, public_address: h, virtual_ip: 10.0.0.9}",
+		"both servers":   "name: office\ncidr: 10.0.0.0/24\nserver: {name: a, public_address: h}\nservers: [{name: b, public_address: h}]",
+		"duplicate name": "name: office\ncidr: 10.0.0.0/24\nserver: {name: a, public_address: h}\nnodes: [{name: a, type: route}]",
+		"bad type":       "name: office\ncidr: 10.0.0.0/24\nnodes: [{name: a, type: laptop}]",
+		"unknown server": "name: office\ncidr: 10.0.0.0/24\nnodes: [{name: a, type: route, server: nope}]",
+	} {
+		if _, err := ParseNetworkSpec([]byte(data)); err == nil {
+			t.Errorf("ParseNetworkSpec(%s) should fail", name)
+		}
+	}
+}
+
+func TestPlanAndApplySpec(t *testing.T) {
+	vnm, storage := newTestManager(t)
+
+	spec, err := ParseNetworkSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("ParseNetworkSpec() error = %v", err)
+	}
+	plan, err := vnm.PlanSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PlanSpec() error = %v", err)
+	}
+	var lines []string
+	for _, c := range plan.Changes {
+		lines = append(lines, c.String())
+	}
+	want := []string{
+		"+ network office (cidr: 10.8.0.0/24, dns: 10.8.0.1, labels: {env=prod})",
+		"+ server hub (endpoint: vpn.example.com:51820)",
+		"+ node laptop (type: peer, endpoint: 203.0.113.7:51820, labels: {team=ops})",
+		"+ node branch (type: route, routed_cidrs: 192.168.10.0/24)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := vnm.GetVirtualNetwork("office"); err == nil {
+		t.Error("PlanSpec() created the network")
+	}
+
+	if err := vnm.ApplySpec(plan); err != nil {
+		t.Fatalf("ApplySpec() error = %v", err)
+	}
+	laptop, err := vnm.GetNode("office", "laptop")
+	if err != nil {
+		t.Fatalf("GetNode(laptop) error = %v", err)
+	}
+	branch, err := vnm.GetNode("office", "branch")
+	if err != nil || len(branch.RoutedCIDRs) != 1 {
+		t.Fatalf("GetNode(branch) = %+v, %v; want its routed CIDR", branch, err)
+	}
+
+	// Applying the same spec again changes nothing.
+	plan, err = vnm.PlanSpec(spec, false)
+	if err != nil || len(plan.Changes) != 0 {
+		t.Fatalf("PlanSpec() again = %+v, %v; want no changes", plan, err)
+	}
+
+	// Edit the spec: relabel laptop and make it full-tunnel, drop branch,
+	// add a node.
+	spec.Nodes[0].Labels = map[string]string{"team": "dev"}
+	spec.Nodes[0].FullTunnel = true
+	spec.Nodes[0].InternalAddress = "10.10.0.7"
+	spec.Nodes[0].PreferInternal = true
+	spec.Nodes[1] = NodeSpec{Name: "desk", Type: NodeTypeRoute}
+	spec.DNS = nil
+	plan, err = vnm.PlanSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PlanSpec() error = %v", err)
+	}
+	if len(plan.Unmanaged) != 1 || plan.Unmanaged[0] != "node branch" {
+		t.Errorf("Unmanaged = %v, want branch kept without prune", plan.Unmanaged)
+	}
+	if len(plan.Changes) != 3 {
+		t.Errorf("plan has %d changes, want network, laptop and desk: %v", len(plan.Changes), plan.Changes)
+	} else if got := plan.Changes[1].String(); got != "~ node laptop (labels: {team=ops} -> {team=dev}, full_tunnel: false -> true, internal_endpoint: (none) -> 10.10.0.7 (public port), prefer_internal: false -> true)" {
+		t.Errorf("laptop change = %q", got)
+	}
+
+	plan, err = vnm.PlanSpec(spec, true)
+	if err != nil {
+		t.Fatalf("PlanSpec(prune) error = %v", err)
+	}
+	if err := vnm.ApplySpec(plan); err != nil {
+		t.Fatalf("ApplySpec(prune) error = %v", err)
+	}
+	if _, err := vnm.GetNode("office", "branch"); err == nil {
+		t.Error("prune kept branch")
+	}
+	updated, err := vnm.GetNode("office", "laptop")
+	if err != nil {
+		t.Fatalf("GetNode(laptop) error = %v", err)
+	}
+	if updated.Labels["team"] != "dev" || !updated.FullTunnel || updated.InternalAddress != "10.10.0.7" || !updated.PreferInternal {
+		t.Errorf("laptop = %+v, want team=dev, full tunnel and the internal endpoint", updated)
+	}
+	if updated.PublicKey != laptop.PublicKey || updated.VirtualIP != laptop.VirtualIP {
+		t.Error("apply replaced laptop's keys or virtual IP")
+	}
+	network, err := vnm.GetVirtualNetwork("office")
+	if err != nil || len(network.DNS()) != 0 {
+		t.Errorf("network DNS = %v, %v; want cleared", network.DNS(), err)
+	}
+
+	nodes, err := storage.ListNodesByNetworkID(network.ID)
+	if err != nil || len(nodes) != 2 {
+		t.Errorf("nodes = %d, %v; want laptop and desk", len(nodes), err)
+	}
+}
+
+func TestPlanSpec_Invalid(t *testing.T) {
+	vnm, _ := newTestManager(t)
+
+	for name, spec := range map[string]*NetworkSpec{
+		"bad cidr":        {Name: "office", CIDR: "10.8.0.0"},
+		"reserved name":   {Name: "list", CIDR: "10.8.0.0/24"},
+		"bad dns":         {Name: "office", CIDR: "10.8.0.0/24", DNS: []string{"resolver"}},
+		"peer no address": {Name: "office", CIDR: "10.8.0.0/24", Nodes: []NodeSpec{{Name: "a", Type: NodeTypePeer}}},
+		"peer routes":     {Name: "office", CIDR: "10.8.0.0/24", Nodes: []NodeSpec{{Name: "a", Type: NodeTypePeer, PublicAddress: "h", RoutedCIDRs: []string{"192.168.0.0/24"}}}},
+		"bad port":        {Name: "office", CIDR: "10.8.0.0/24", Servers: []ServerSpec{{Name: "hub", PublicAddress: "h", Port: 70000}}},
+		"bad internal":    {Name: "office", CIDR: "10.8.0.0/24", Servers: []ServerSpec{{Name: "hub", PublicAddress: "h", InternalPort: 70000}}},
+	} {
+		if _, err := vnm.PlanSpec(spec, false); err == nil {
+			t.Errorf("PlanSpec(%s) should fail", name)
+		}
+	}
+}
+
+func TestPreviewSpec(t *testing.T) {
+	vnm, storage := newTestManager(t)
+	spec, err := ParseNetworkSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("ParseNetworkSpec() error = %v", err)
+	}
+
+	// A new network previews as its first version.
+	version, created, err := vnm.PreviewSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PreviewSpec() error = %v", err)
+	}
+	if !created || version.Version != 1 || !slices.Equal(version.Changed, []string{"branch", "hub", "laptop"}) {
+		t.Errorf("preview = version %d %v (created %v), want version 1 of branch, hub and laptop", version.Version, version.Changed, created)
+	}
+	if networks, _ := storage.ListNetworks(); len(networks) != 0 {
+		t.Fatalf("PreviewSpec() created %d networks, want none", len(networks))
+	}
+
+	plan, err := vnm.PlanSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PlanSpec() error = %v", err)
+	}
+	if err := vnm.ApplySpec(plan); err != nil {
+		t.Fatalf("ApplySpec() error = %v", err)
+	}
+	if _, _, err := NewWireGuardConfigGenerator(storage).SaveConfigVersion("office"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, created, err := vnm.PreviewSpec(spec, false); err != nil || created {
+		t.Errorf("PreviewSpec() of the applied spec created = %v, %v; want unchanged", created, err)
+	}
+
+	network, err := vnm.GetVirtualNetwork("office")
+	if err != nil {
+		t.Fatalf("GetVirtualNetwork() error = %v", err)
+	}
+	revision, _ := storage.NetworkRevision(network.ID)
+	spec.Nodes[0].Port = 51999
+	version, created, err = vnm.PreviewSpec(spec, false)
+	if err != nil {
+		t.Fatalf("PreviewSpec() error = %v", err)
+	}
+	if !created || version.Version != 2 || !slices.Equal(version.Changed, []string{"branch", "hub", "laptop"}) {
+		t.Errorf("preview = version %d %v (created %v), want version 2 changing every config", version.Version, version.Changed, created)
+	}
+	if after, _ := storage.NetworkRevision(network.ID); after != revision {
+		t.Errorf("revision = %d after PreviewSpec(), want %d unchanged", after, revision)
+	}
+	if laptop, _ := vnm.GetNode("office", "laptop"); laptop == nil || laptop.Port == 51999 {
+		t.Errorf("laptop = %+v, want its stored port untouched", laptop)
+	}
+}
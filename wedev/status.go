@@ -0,0 +1,180 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handshakeFreshness is how recent a peer's last handshake must be for the
+// peer to count as connected. WireGuard re-handshakes every two minutes on an
+// active tunnel, so anything older means the session has gone quiet.
+const handshakeFreshness = 3 * time.Minute
+
+// Peer connection states reported by WireGuardStatusReader.
+const (
+	PeerStateConnected    = "connected"
+	PeerStateStale        = "no recent handshake"
+	PeerStateNotConnected = "not connected"
+	PeerStateUnmanaged    = "unmanaged"
+)
+
+// PeerStatus is the live state of one peer of a WireGuard interface.
+type PeerStatus struct {
+	Name            string     `json:"name,omitempty"`
+	PublicKey       string     `json:"public_key"`
+	Endpoint        string     `json:"endpoint,omitempty"`
+	LatestHandshake *time.Time `json:"latest_handshake,omitempty"` // nil when the peer never completed one
+	TransferRx      int64      `json:"transfer_rx"`
+	TransferTx      int64      `json:"transfer_tx"`
+	State           string     `json:"state"`
+}
+
+// InterfaceStatus is the live state of a WireGuard interface, with its peers
+// matched against the entities stored for a network.
+type InterfaceStatus struct {
+	Interface string       `json:"interface"`
+	Self      string       `json:"self,omitempty"` // stored entity owning the interface key
+	Peers     []PeerStatus `json:"peers"`
+}
+
+// WireGuardStatusReader reads live interface state with `wg show <iface> dump`.
+type WireGuardStatusReader struct {
+	storage Storage
+	runner  CommandRunner
+	now     func() time.Time
+}
+
+// NewWireGuardStatusReader creates a new WireGuardStatusReader
+func NewWireGuardStatusReader(storage Storage) *WireGuardStatusReader {
+	return &WireGuardStatusReader{storage: storage, runner: execRunner{}, now: time.Now}
+}
+
+// Status reports the peers of a local interface for a network. Peers are
+// matched to the network's server and nodes by public key; keys not stored
+// for the network are flagged unmanaged, and stored entities absent from the
+// interface are flagged not connected.
+func (sr *WireGuardStatusReader) Status(networkName, iface string) (*InterfaceStatus, error) {
+	return sr.StatusCtx(context.Background(), networkName, iface)
+}
+
+// StatusCtx is Status with a context.
+func (sr *WireGuardStatusReader) StatusCtx(ctx context.Context, networkName, iface string) (*InterfaceStatus, error) {
+	network, err := sr.storage.GetNetworkByNameCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+	if iface == "" {
+		iface = networkName
+	}
+	if !interfaceNamePattern.MatchString(iface) {
+		return nil, fmt.Errorf("invalid interface name %q", iface)
+	}
+
+	// public key -> entity name, for every server and node.
+	names := make(map[string]string)
+	servers, err := sr.storage.ListServersByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		names[server.PublicKey] = server.Name
+	}
+	nodes, err := sr.storage.ListNodesByNetworkIDCtx(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		names[node.PublicKey] = node.Name
+	}
+
+	if _, err := sr.runner.LookPath("wg"); err != nil {
+		return nil, fmt.Errorf("wg not found in PATH; install wireguard-tools: %w", err)
+	}
+	out, err := sr.runner.Output(ctx, "wg", "show", iface, "dump")
+	if err != nil {
+		msg := err.Error()
+		if strings.Contains(msg, "No such device") {
+			return nil, fmt.Errorf("interface %q does not exist (bring it up with 'config apply', or pass --interface)", iface)
+		}
+		if strings.Contains(msg, "Operation not permitted") {
+			return nil, fmt.Errorf("reading interface %q requires root privileges (try sudo)", iface)
+		}
+		return nil, fmt.Errorf("wg show %s failed: %w", iface, err)
+	}
+
+	return sr.parseDump(iface, string(out), names)
+}
+
+// parseDump parses `wg show <iface> dump` output. The first line describes
+// the interface (private key, public key, listen port, fwmark); each further
+// line is a peer: public key, preshared key, endpoint, allowed IPs, latest
+// handshake (unix seconds), rx bytes, tx bytes, persistent keepalive.
+func (sr *WireGuardStatusReader) parseDump(iface, dump string, names map[string]string) (*InterfaceStatus, error) {
+	lines := strings.Split(strings.TrimSpace(dump), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, fmt.Errorf("empty output from wg show %s dump", iface)
+	}
+
+	status := &InterfaceStatus{Interface: iface, Peers: []PeerStatus{}}
+	ifaceFields := strings.Split(lines[0], "\t")
+	if len(ifaceFields) >= 2 {
+		status.Self = names[ifaceFields[1]]
+		delete(names, ifaceFields[1])
+	}
+
+	now := sr.now()
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("unexpected wg dump line: %q", line)
+		}
+		peer := PeerStatus{PublicKey: fields[0]}
+		if fields[2] != "(none)" {
+			peer.Endpoint = fields[2]
+		}
+		counters := make([]int64, 3)
+		for i, field := range fields[4:7] {
+			v, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected wg dump line: %q: %w", line, err)
+			}
+			counters[i] = v
+		}
+		handshake := counters[0]
+		peer.TransferRx, peer.TransferTx = counters[1], counters[2]
+
+		name, managed := names[peer.PublicKey]
+		switch {
+		case !managed:
+			peer.State = PeerStateUnmanaged
+		case handshake > 0 && now.Sub(time.Unix(handshake, 0)) <= handshakeFreshness:
+			peer.State = PeerStateConnected
+		default:
+			peer.State = PeerStateStale
+		}
+		if handshake > 0 {
+			at := time.Unix(handshake, 0)
+			peer.LatestHandshake = &at
+		}
+		peer.Name = name
+		delete(names, peer.PublicKey)
+		status.Peers = append(status.Peers, peer)
+	}
+
+	// Whatever is left was stored for the network but is not on the interface.
+	for key, name := range names {
+		status.Peers = append(status.Peers, PeerStatus{Name: name, PublicKey: key, State: PeerStateNotConnected})
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		if status.Peers[i].Name != status.Peers[j].Name {
+			return status.Peers[i].Name < status.Peers[j].Name
+		}
+		return status.Peers[i].PublicKey < status.Peers[j].PublicKey
+	})
+
+	return status, nil
+}
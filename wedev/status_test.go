@@ -0,0 +1,131 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpRunner returns canned `wg show` output.
+type dumpRunner struct {
+	out []byte
+	err error
+}
+
+func (d *dumpRunner) LookPath(file string) (string, error) {
+	return "/usr/bin/" + file, nil
+}
+
+func (d *dumpRunner) Run(_ context.Context, _ string, _ ...string) ([]byte, error) {
+	return d.out, d.err
+}
+
+func (d *dumpRunner) Output(_ context.Context, _ string, _ ...string) ([]byte, error) {
+	return d.out, d.err
+}
+
+func TestWireGuardStatusReader_Status(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("stnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	server, err := vnm.CreateServer("stnet", "srv", "vpn.example.com", 51820)
+	if err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	live, _ := vnm.CreateNode("stnet", "live", "1.2.3.4", 51820, NodeTypePeer)
+	idle, _ := vnm.CreateNode("stnet", "idle", "1.2.3.5", 51820, NodeTypePeer)
+	if _, err := vnm.CreateNode("stnet", "absent", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(absent) error = %v", err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	dump := strings.Join([]string{
+		fmt.Sprintf("privkey\t%s\t51820\toff", server.PublicKey),
+		fmt.Sprintf("%s\t(none)\t1.2.3.4:51820\t10.0.0.2/32\t%d\t1024\t2048\toff", live.PublicKey, now.Unix()-30),
+		fmt.Sprintf("%s\t(none)\t(none)\t10.0.0.3/32\t0\t0\t0\toff", idle.PublicKey),
+		"strangerkey\t(none)\t9.9.9.9:1234\t10.0.0.99/32\t0\t0\t0\toff",
+	}, "\n")
+
+	sr := NewWireGuardStatusReader(sm)
+	sr.runner = &dumpRunner{out: []byte(dump)}
+	sr.now = func() time.Time { return now }
+
+	status, err := sr.Status("stnet", "")
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Interface != "stnet" || status.Self != "srv" {
+		t.Errorf("Status() interface/self = %s/%s, want stnet/srv", status.Interface, status.Self)
+	}
+
+	got := make(map[string]PeerStatus)
+	for _, p := range status.Peers {
+		key := p.Name
+		if key == "" {
+			key = p.PublicKey
+		}
+		got[key] = p
+	}
+	want := map[string]string{
+		"live":        PeerStateConnected,
+		"idle":        PeerStateStale,
+		"absent":      PeerStateNotConnected,
+		"strangerkey": PeerStateUnmanaged,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Status() peers = %+v, want %d entries", status.Peers, len(want))
+	}
+	for name, state := range want {
+		if got[name].State != state {
+			t.Errorf("peer %s state = %q, want %q", name, got[name].State, state)
+		}
+	}
+	if got["live"].Endpoint != "1.2.3.4:51820" || got["live"].TransferRx != 1024 || got["live"].TransferTx != 2048 {
+		t.Errorf("peer live = %+v, want endpoint and counters parsed", got["live"])
+	}
+	if h := got["live"].LatestHandshake; h == nil || h.Unix() != now.Unix()-30 {
+		t.Errorf("peer live handshake = %v, want 30s ago", h)
+	}
+
+	// A peer without a handshake has none, and JSON leaves the field out.
+	if got["idle"].LatestHandshake != nil {
+		t.Errorf("peer idle handshake = %v, want nil", got["idle"].LatestHandshake)
+	}
+	data, err := json.Marshal(got["idle"])
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(data), "latest_handshake") {
+		t.Errorf("peer idle JSON = %s, want no latest_handshake", data)
+	}
+}
+
+func TestWireGuardStatusReader_Errors(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("stnet", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	sr := NewWireGuardStatusReader(sm)
+
+	sr.runner = &dumpRunner{err: errors.New("exit status 1: Unable to access interface: No such device")}
+	if _, err := sr.Status("stnet", "wg7"); err == nil || !strings.Contains(err.Error(), `interface "wg7" does not exist`) {
+		t.Errorf("Status() on a missing interface error = %v, want does-not-exist", err)
+	}
+
+	sr.runner = &dumpRunner{out: []byte("priv\tpub\t51820\toff\ngarbage")}
+	if _, err := sr.Status("stnet", ""); err == nil {
+		t.Error("Status() should reject a malformed dump")
+	}
+
+	if _, err := sr.Status("stnet", "bad/name"); err == nil {
+		t.Error("Status() should reject an invalid interface name")
+	}
+	if _, err := sr.Status("missing", ""); err == nil {
+		t.Error("Status() for a missing network should fail")
+	}
+}
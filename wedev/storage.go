@@ -2,8 +2,17 @@ package wedev
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,7 +29,7 @@ const (
 	BucketServers = "servers"
 	// BucketServersByName is the index bucket for servers by name (networkID:name -> server ID).
 	BucketServersByName = "servers_by_name"
-	// BucketServersByNetwork is the index bucket for the server of a network (networkID -> server ID).
+	// BucketServersByNetwork is the index bucket for servers by network (networkID:serverID -> server ID).
 	BucketServersByNetwork = "servers_by_network"
 	// BucketNodes is the BoltDB bucket for node data.
 	BucketNodes = "nodes"
@@ -34,28 +43,147 @@ const (
 	BucketConfigsByVer = "configs_by_version"
 	// BucketIPPools is the BoltDB bucket for IP pool data.
 	BucketIPPools = "ip_pools"
+	// BucketDeployments is the BoltDB bucket for the last deployment of each
+	// server and node (entity ID -> Deployment). Migration 4 creates it.
+	BucketDeployments = "deployments"
+	// BucketRevisions is the BoltDB bucket for the revision of each network
+	// (network ID -> big-endian uint64). Migration 5 creates it.
+	BucketRevisions = "revisions"
+	// BucketHistory is the BoltDB bucket for the recent changes to each
+	// server and node (entity ID -> []EntityRevision). Migration 6 creates it.
+	BucketHistory = "history"
+	// BucketTags is the BoltDB bucket for named config versions
+	// (networkID:tag -> version, in decimal). Migration 7 creates it.
+	BucketTags = "tags"
+	// BucketSequences is the BoltDB bucket for the last config version
+	// number given out in each network (network ID -> big-endian uint64).
+	// Migration 8 creates it.
+	BucketSequences = "sequences"
 )
 
 // VirtualNetwork represents a virtual network
 type VirtualNetwork struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CIDR      string    `json:"cidr"`
-	CreatedAt time.Time `json:"created_at"`
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	CIDR            string            `json:"cidr"`
+	DefaultPort     int               `json:"default_port,omitempty"`      // node port when none is given; 0 means DefaultWireGuardPort
+	NATMode         NATMode           `json:"nat_mode,omitempty"`          // server rules for routed subnets; empty means NATModeMasquerade
+	MaxNodes        int               `json:"max_nodes,omitempty"`         // nodes the network may hold; 0 means no limit
+	PoolWarnPercent int               `json:"pool_warn_percent,omitempty"` // IP pool utilization warned about; 0 means DefaultPoolWarnPercent
+	Labels          map[string]string `json:"labels,omitempty"`
+	Settings        map[string]string `json:"settings,omitempty"` // see settings.go; read known keys through accessors
+	Freeze          *ConfigFreeze     `json:"freeze,omitempty"`   // set while the config is frozen; see freeze.go
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// NodePort returns the port new nodes get when none is given.
+func (n *VirtualNetwork) NodePort() int {
+	if n.DefaultPort == 0 {
+		return DefaultWireGuardPort
+	}
+	return n.DefaultPort
+}
+
+// PoolWarnThreshold returns the IP pool utilization, in percent, at which
+// adding a node warns that the pool is nearly exhausted.
+func (n *VirtualNetwork) PoolWarnThreshold() int {
+	if n.PoolWarnPercent == 0 {
+		return DefaultPoolWarnPercent
+	}
+	return n.PoolWarnPercent
+}
+
+// NeedsServer reports whether the network's configs can only be generated
+// with a server. Hub-spoke nodes reach each other through one; mesh nodes
+// can do without, peering only with each other.
+func (n *VirtualNetwork) NeedsServer() bool {
+	return n.EffectiveTopology() != TopologyMesh
+}
+
+// EffectiveNATMode returns the network's NAT mode, NATModeMasquerade when
+// none is set.
+func (n *VirtualNetwork) EffectiveNATMode() NATMode {
+	if n.NATMode == "" {
+		return NATModeMasquerade
+	}
+	return n.NATMode
+}
+
+// Topology is how a network's nodes peer with each other.
+type Topology string
+
+const (
+	// TopologyHubSpoke peers nodes with their server; peer nodes also peer
+	// with each other and route nodes with peer nodes.
+	TopologyHubSpoke Topology = "hub-spoke"
+	// TopologyMesh peers every pair of nodes where at least one has a public
+	// address; pairs where neither has one still go through the server.
+	TopologyMesh Topology = "mesh"
+)
+
+// ParseTopology validates a topology name.
+func ParseTopology(s string) (Topology, error) {
+	switch Topology(s) {
+	case TopologyHubSpoke, TopologyMesh:
+		return Topology(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid topology: %s (must be '%s' or '%s')", s, TopologyHubSpoke, TopologyMesh)
+}
+
+// NATMode is what server configs do for the subnets routed by route nodes.
+type NATMode string
+
+const (
+	// NATModeNone adds no firewall rules; the server hosts handle forwarding
+	// and return routes themselves.
+	NATModeNone NATMode = "none"
+	// NATModeMasquerade accepts forwarded traffic to and from each routed
+	// subnet on the WireGuard interface and masquerades it, so LAN hosts
+	// reply without a route back to the VPN.
+	NATModeMasquerade NATMode = "masquerade"
+)
+
+// ParseNATMode validates a NAT mode name.
+func ParseNATMode(s string) (NATMode, error) {
+	switch NATMode(s) {
+	case NATModeNone, NATModeMasquerade:
+		return NATMode(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid NAT mode: %s (must be '%s' or '%s')", s, NATModeNone, NATModeMasquerade)
 }
 
 // Server represents a WireGuard server
 type Server struct {
-	ID            string    `json:"id"`
-	NetworkID     string    `json:"network_id"`
-	Name          string    `json:"name"`
-	PublicAddress string    `json:"public_address"`
-	Port          int       `json:"port"`
-	VirtualIP     string    `json:"virtual_ip"`
-	PrivateKey    string    `json:"private_key"`
-	PublicKey     string    `json:"public_key"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID              string `json:"id"`
+	NetworkID       string `json:"network_id"`
+	Name            string `json:"name"`
+	PublicAddress   string `json:"public_address"`
+	Port            int    `json:"port"`
+	InternalAddress string `json:"internal_address,omitempty"` // endpoint for nodes with PreferInternal
+	InternalPort    int    `json:"internal_port,omitempty"`    // port of the internal endpoint; 0 means Port
+	// AdditionalAddresses are further public addresses of the server, on
+	// Port, that nodes dial by their EndpointPreference.
+	AdditionalAddresses []string  `json:"additional_addresses,omitempty"`
+	VirtualIP           string    `json:"virtual_ip"`
+	PrivateKey          string    `json:"private_key"`
+	PublicKey           string    `json:"public_key"`
+	EndpointHistory               // previous public addresses and the last resolution
+	Revision            int       `json:"revision,omitempty"` // incremented on every update; see ReplaceServer
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// EndpointFor returns the endpoint a node dials to reach the server: the
+// internal one when preferInternal is set and the server has one, otherwise
+// the public one.
+func (s *Server) EndpointFor(preferInternal bool) string {
+	return endpointFor(preferInternal, s.PublicAddress, s.Port, s.InternalAddress, s.InternalPort)
+}
+
+// ExternallyManaged reports whether the server's private key lives outside
+// wedevctl: only its public key was imported, so no config is generated for it.
+func (s *Server) ExternallyManaged() bool {
+	return s.PrivateKey == ""
 }
 
 // NodeType represents the type of node
@@ -66,21 +194,85 @@ const (
 	NodeTypePeer NodeType = "peer"
 	// NodeTypeRoute represents a route node.
 	NodeTypeRoute NodeType = "route"
+	// NodeTypeClient represents a roaming device without an endpoint: it
+	// peers with its server only, and no other node lists it as a peer.
+	NodeTypeClient NodeType = "client"
 )
 
+// ParseNodeType validates a node type name.
+func ParseNodeType(s string) (NodeType, error) {
+	switch NodeType(s) {
+	case NodeTypePeer, NodeTypeRoute, NodeTypeClient:
+		return NodeType(s), nil
+	}
+	return "", kindErrorf(ErrValidation, "invalid node type: %s (must be '%s', '%s' or '%s')", s, NodeTypePeer, NodeTypeRoute, NodeTypeClient)
+}
+
+// keepsAlive reports whether the node's tunnels need PersistentKeepalive:
+// route and client nodes connect outbound only, usually from behind NAT.
+func (n *Node) keepsAlive() bool {
+	return n.Type == NodeTypeRoute || n.Type == NodeTypeClient
+}
+
 // Node represents a node in the network
 type Node struct {
-	ID            string    `json:"id"`
-	NetworkID     string    `json:"network_id"`
-	Name          string    `json:"name"`
-	PublicAddress string    `json:"public_address"`
-	Port          int       `json:"port"`
-	VirtualIP     string    `json:"virtual_ip"`
-	Type          NodeType  `json:"type"`
-	PrivateKey    string    `json:"private_key"`
-	PublicKey     string    `json:"public_key"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID              string `json:"id"`
+	NetworkID       string `json:"network_id"`
+	Name            string `json:"name"`
+	PublicAddress   string `json:"public_address"`
+	Port            int    `json:"port"`
+	InternalAddress string `json:"internal_address,omitempty"` // endpoint for peers with PreferInternal
+	InternalPort    int    `json:"internal_port,omitempty"`    // port of the internal endpoint; 0 means Port
+	PreferInternal  bool   `json:"prefer_internal,omitempty"`  // dial peers at their internal endpoint when they have one
+	// EndpointPreference picks which of its server's public addresses the
+	// node dials; empty means EndpointPrimary.
+	EndpointPreference EndpointPreference `json:"endpoint_preference,omitempty"`
+	VirtualIP          string             `json:"virtual_ip"`
+	Type               NodeType           `json:"type"`
+	PrivateKey         string             `json:"private_key"`
+	PublicKey          string             `json:"public_key"`
+	RoutedCIDRs        []string           `json:"routed_cidrs,omitempty"` // LAN subnets exposed by a route node
+	ServerID           string             `json:"server_id,omitempty"`    // assigned server; empty means the network's first server
+	MeshServers        bool               `json:"mesh_servers,omitempty"` // peer with every server, not just the assigned one
+	FullTunnel         bool               `json:"full_tunnel,omitempty"`  // route all traffic through the assigned server
+	Labels             map[string]string  `json:"labels,omitempty"`
+	ExpiresAt          *time.Time         `json:"expires_at,omitempty"` // end of temporary access; nil never expires
+	EndpointHistory                       // previous public addresses and the last resolution
+	Revision           int                `json:"revision,omitempty"` // incremented on every update; see ReplaceNode
+	CreatedAt          time.Time          `json:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at"`
+}
+
+// EndpointFor returns the endpoint another node dials to reach this one:
+// the internal one when preferInternal is set and the node has one,
+// otherwise the public one ("" without a public address).
+func (n *Node) EndpointFor(preferInternal bool) string {
+	return endpointFor(preferInternal, n.PublicAddress, n.Port, n.InternalAddress, n.InternalPort)
+}
+
+// endpointFor picks between a public and an internal endpoint.
+func endpointFor(preferInternal bool, publicAddress string, port int, internalAddress string, internalPort int) string {
+	if preferInternal && internalAddress != "" {
+		if internalPort == 0 {
+			internalPort = port
+		}
+		return util.FormatEndpoint(internalAddress, internalPort)
+	}
+	if publicAddress == "" {
+		return ""
+	}
+	return util.FormatEndpoint(publicAddress, port)
+}
+
+// ExternallyManaged reports whether the node's private key lives outside
+// wedevctl: only its public key was imported, so no config is generated for it.
+func (n *Node) ExternallyManaged() bool {
+	return n.PrivateKey == ""
+}
+
+// Expired reports whether the node's access has ended at now.
+func (n *Node) Expired(now time.Time) bool {
+	return n.ExpiresAt != nil && !now.Before(*n.ExpiresAt)
 }
 
 // ConfigVersion represents a snapshot of WireGuard configurations
@@ -89,37 +281,135 @@ type ConfigVersion struct {
 	NetworkID   string            `json:"network_id"`
 	Version     int               `json:"version"`
 	ContentHash string            `json:"content_hash"`
-	Configs     map[string]string `json:"configs"` // name -> config content
+	Configs     map[string]string `json:"configs"`              // name -> config content
+	Changed     []string          `json:"changed,omitempty"`    // names whose config is new or differs from the previous version
+	Message     string            `json:"message,omitempty"`    // why the version exists, with a change summary
+	ChangedBy   string            `json:"changed_by,omitempty"` // OS user who saved the version
 	CreatedAt   time.Time         `json:"created_at"`
 }
 
+// ConfigVersionFilter selects config versions by when they were saved. The
+// zero value selects every version.
+type ConfigVersionFilter struct {
+	Since time.Time // saved at or after; zero for no lower bound
+	Until time.Time // saved at or before; zero for no upper bound
+	Last  int       // keep only the newest Last matching versions; 0 for all
+}
+
+// matches reports whether a version saved at createdAt lies within the
+// filter's time range.
+func (f ConfigVersionFilter) matches(createdAt time.Time) bool {
+	return (f.Since.IsZero() || !createdAt.Before(f.Since)) && (f.Until.IsZero() || !createdAt.After(f.Until))
+}
+
+// allBuckets lists every bucket a wedevctl database contains.
+var allBuckets = []string{
+	BucketNetworks, BucketNetworksByName,
+	BucketServers, BucketServersByName, BucketServersByNetwork,
+	BucketNodes, BucketNodesByName, BucketNodesByNetwork,
+	BucketConfigs, BucketConfigsByVer,
+	BucketIPPools,
+}
+
+// ErrReadOnly is returned for a write through a StorageManager opened with
+// StorageOptions.ReadOnly. It is a programming error: the caller opened a
+// read-only handle for an operation that writes.
+var ErrReadOnly = errors.New("write attempted through a read-only storage manager; open it without StorageOptions.ReadOnly to write")
+
+// ErrSchemaOutdated is returned when opening read-only a database whose
+// schema needs migrations, which only a read-write open runs.
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
 // StorageManager handles all BoltDB operations
 type StorageManager struct {
-	db *bbolt.DB
+	db       *bbolt.DB
+	logger   *slog.Logger
+	lockInfo bool // this manager wrote the lock info file and removes it on Close
+	readOnly bool // opened with StorageOptions.ReadOnly; writes fail with ErrReadOnly
+
+	historyLimit int      // revisions kept per server and node
+	metrics      *Metrics // collects transaction timings; nil when not collecting
+
+	encryption *encryptionHeader // set when private keys are stored encrypted
+	keys       *keyCipher        // set once an encrypted database is unlocked
+
+	// poolWriteFault, set by tests, runs before every IP pool state write;
+	// an error it returns fails the write, simulating a crash between a
+	// record write and the pool write that belongs with it.
+	poolWriteFault func() error
 }
 
-// NewStorageManager creates a new storage manager.
+// StorageOptions configures NewStorageManagerWithOptions. Zero values select
+// the defaults.
+type StorageOptions struct {
+	// LockTimeout is how long to wait for another process to release the
+	// database lock; DefaultLockTimeout when zero.
+	LockTimeout time.Duration
+	// Logger receives warnings and debug detail; slog.Default() when nil.
+	// Managers and generators built on the StorageManager share it.
+	Logger *slog.Logger
+	// ReadOnly opens an existing database under a shared lock: any number
+	// of read-only managers can be open at once, while writers wait for
+	// them (and they for writers). No buckets are created and no migrations
+	// run, so a missing database or one needing migrations is an error
+	// (fs.ErrNotExist or ErrSchemaOutdated). Writes fail with ErrReadOnly.
+	ReadOnly bool
+	// HistoryLimit is how many revisions of each server and node are kept;
+	// DefaultHistoryLimit when zero.
+	HistoryLimit int
+	// Metrics, when set, counts and times every transaction by operation.
+	Metrics *Metrics
+}
+
+// NewStorageManager creates a new storage manager with default options.
 func NewStorageManager(dbPath string) (*StorageManager, error) {
-	db, err := bbolt.Open(dbPath, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
+	return NewStorageManagerWithOptions(dbPath, StorageOptions{})
+}
+
+// NewStorageManagerWithOptions creates a new storage manager, retrying with
+// backoff while another process holds the database lock.
+func NewStorageManagerWithOptions(dbPath string, opts StorageOptions) (*StorageManager, error) {
+	return NewStorageManagerWithOptionsCtx(context.Background(), dbPath, opts)
+}
+
+// NewStorageManagerWithOptionsCtx is NewStorageManagerWithOptions with a
+// context; cancelling it stops waiting for the database lock.
+func NewStorageManagerWithOptionsCtx(ctx context.Context, dbPath string, opts StorageOptions) (*StorageManager, error) {
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.HistoryLimit <= 0 {
+		opts.HistoryLimit = DefaultHistoryLimit
+	}
+
+	if opts.ReadOnly {
+		return openReadOnly(ctx, dbPath, opts)
+	}
+
+	start := time.Now()
+	db, err := openWithRetry(ctx, dbPath, opts.LockTimeout, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
+	opts.Logger.Debug("opened database", "path", dbPath, "wait", time.Since(start))
 
-	// Initialize buckets
+	// Initialize buckets and bring the schema up to date
+	var encryption *encryptionHeader
 	if err := db.Update(func(tx *bbolt.Tx) error {
-		buckets := []string{
-			BucketNetworks, BucketNetworksByName,
-			BucketServers, BucketServersByName, BucketServersByNetwork,
-			BucketNodes, BucketNodesByName, BucketNodesByNetwork,
-			BucketConfigs, BucketConfigsByVer,
-			BucketIPPools,
-		}
-		for _, bucketName := range buckets {
+		for _, bucketName := range allBuckets {
 			if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
 				return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
 			}
 		}
-		return nil
+		if err := runMigrations(tx, opts.Logger); err != nil {
+			return err
+		}
+		var err error
+		encryption, err = readEncryptionHeader(tx)
+		return err
 	}); err != nil {
 		if closeErr := db.Close(); closeErr != nil {
 			return nil, fmt.Errorf("failed to close database after init error: %w", closeErr)
@@ -127,11 +417,111 @@ func NewStorageManager(dbPath string) (*StorageManager, error) {
 		return nil, err
 	}
 
-	return &StorageManager{db: db}, nil
+	writeLockInfo(dbPath)
+	return &StorageManager{db: db, logger: opts.Logger, lockInfo: true, historyLimit: opts.HistoryLimit, metrics: opts.Metrics, encryption: encryption}, nil
+}
+
+// openReadOnly is NewStorageManagerWithOptionsCtx for opts.ReadOnly.
+func openReadOnly(ctx context.Context, dbPath string, opts StorageOptions) (*StorageManager, error) {
+	// bbolt would create a missing file even in read-only mode.
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	start := time.Now()
+	db, err := openWithRetry(ctx, dbPath, opts.LockTimeout, true)
+	if err != nil {
+		return nil, err
+	}
+	opts.Logger.Debug("opened database read-only", "path", dbPath, "wait", time.Since(start))
+
+	var encryption *encryptionHeader
+	if err := db.View(func(tx *bbolt.Tx) error {
+		version, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		switch latest := LatestSchemaVersion(); {
+		case version > latest:
+			return fmt.Errorf("database schema version %d is newer than this wedevctl supports (%d); upgrade wedevctl", version, latest)
+		case version < latest:
+			return fmt.Errorf("%w: %s is at version %d, %d needed", ErrSchemaOutdated, dbPath, version, latest)
+		}
+		if err := checkBuckets(tx, dbPath); err != nil {
+			return err
+		}
+		encryption, err = readEncryptionHeader(tx)
+		return err
+	}); err != nil {
+		//nolint:errcheck // Read-only handle; nothing to flush on close
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &StorageManager{db: db, logger: opts.Logger, readOnly: true, historyLimit: opts.HistoryLimit, metrics: opts.Metrics, encryption: encryption}, nil
+}
+
+// OpenStorageReadOnly opens an existing database read-only (see
+// StorageOptions.ReadOnly), for callers such as shell completion that only
+// read. It fails instead of waiting when another process holds the write
+// lock.
+func OpenStorageReadOnly(dbPath string) (*StorageManager, error) {
+	return NewStorageManagerWithOptions(dbPath, StorageOptions{ReadOnly: true, LockTimeout: 100 * time.Millisecond})
+}
+
+// ReadOnly reports whether the manager was opened with StorageOptions.ReadOnly.
+func (sm *StorageManager) ReadOnly() bool {
+	return sm.readOnly
+}
+
+// Logger returns the logger the storage manager was opened with.
+func (sm *StorageManager) Logger() *slog.Logger {
+	return sm.logger
+}
+
+// update runs fn in a read-write transaction, logging its duration at debug
+// level and recording it in the manager's metrics.
+func (sm *StorageManager) update(fn func(*bbolt.Tx) error) error {
+	return sm.updateCtx(context.Background(), fn)
+}
+
+// updateCtx is update that does not start once ctx is cancelled. A
+// transaction already running completes or rolls back as a whole.
+func (sm *StorageManager) updateCtx(ctx context.Context, fn func(*bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	start := time.Now()
+	err := sm.db.Update(fn)
+	observeTx(sm.logger, sm.metrics, "update", start, err)
+	return err
+}
+
+// view runs fn in a read-only transaction, logging its duration at debug
+// level and recording it in the manager's metrics.
+func (sm *StorageManager) view(fn func(*bbolt.Tx) error) error {
+	return sm.viewCtx(context.Background(), fn)
+}
+
+// viewCtx is view that does not start once ctx is cancelled.
+func (sm *StorageManager) viewCtx(ctx context.Context, fn func(*bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := sm.db.View(fn)
+	observeTx(sm.logger, sm.metrics, "view", start, err)
+	return err
 }
 
 // Close closes the database
 func (sm *StorageManager) Close() error {
+	if sm.lockInfo {
+		removeLockInfo(sm.db.Path())
+	}
 	return sm.db.Close()
 }
 
@@ -141,6 +531,36 @@ func padVersion(version int) string {
 	return fmt.Sprintf("%020d", version)
 }
 
+// checkCtx wraps a ForEach callback so iteration stops with ctx's error once
+// ctx is cancelled; the surrounding transaction then rolls back.
+func checkCtx(ctx context.Context, fn func(k, v []byte) error) func(k, v []byte) error {
+	return func(k, v []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(k, v)
+	}
+}
+
+// lastWithPrefix returns the last entry of bucket whose key starts with
+// prefix, or nil, nil when there is none. It seeks rather than scans, so it
+// costs the same however many entries share the prefix.
+func lastWithPrefix(bucket *bbolt.Bucket, prefix []byte) (k, v []byte) {
+	c := bucket.Cursor()
+	// The first key after every key with the prefix: the prefix with its
+	// last byte incremented. Prefixes here end in ':', never 0xff.
+	end := append(bytes.Clone(prefix[:len(prefix)-1]), prefix[len(prefix)-1]+1)
+	if k, _ = c.Seek(end); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	return k, v
+}
+
 // forEachWithPrefix invokes fn for every key/value in bucket whose key starts
 // with prefix, in ascending key order. It uses a cursor seek, so cost is
 // proportional to the number of matching keys rather than the bucket size.
@@ -158,13 +578,18 @@ func forEachWithPrefix(bucket *bbolt.Bucket, prefix []byte, fn func(k, v []byte)
 
 // CreateNetwork creates a new virtual network.
 func (sm *StorageManager) CreateNetwork(name, cidr string) (*VirtualNetwork, error) {
+	return sm.CreateNetworkCtx(context.Background(), name, cidr)
+}
+
+// CreateNetworkCtx is CreateNetwork with a context.
+func (sm *StorageManager) CreateNetworkCtx(ctx context.Context, name, cidr string) (*VirtualNetwork, error) {
 	var network *VirtualNetwork
 
-	err := sm.db.Update(func(tx *bbolt.Tx) error {
+	err := sm.updateCtx(ctx, func(tx *bbolt.Tx) error {
 		// Check if name already exists
 		nameIdx := tx.Bucket([]byte(BucketNetworksByName))
 		if nameIdx.Get([]byte(name)) != nil {
-			return fmt.Errorf("network name %q already exists", name)
+			return kindErrorf(ErrAlreadyExists, "network name %q already exists", name)
 		}
 
 		network = &VirtualNetwork{
@@ -190,7 +615,7 @@ func (sm *StorageManager) CreateNetwork(name, cidr string) (*VirtualNetwork, err
 			return fmt.Errorf("failed to save name index: %w", err)
 		}
 
-		return nil
+		return bumpRevision(tx, network.ID)
 	})
 
 	return network, err
@@ -198,21 +623,26 @@ func (sm *StorageManager) CreateNetwork(name, cidr string) (*VirtualNetwork, err
 
 // GetNetworkByName retrieves a network by name
 func (sm *StorageManager) GetNetworkByName(name string) (*VirtualNetwork, error) {
+	return sm.GetNetworkByNameCtx(context.Background(), name)
+}
+
+// GetNetworkByNameCtx is GetNetworkByName with a context.
+func (sm *StorageManager) GetNetworkByNameCtx(ctx context.Context, name string) (*VirtualNetwork, error) {
 	var network *VirtualNetwork
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
 		// Get ID from name index
 		nameIdx := tx.Bucket([]byte(BucketNetworksByName))
 		id := nameIdx.Get([]byte(name))
 		if id == nil {
-			return fmt.Errorf("network %q not found", name)
+			return kindErrorf(ErrNotFound, "network %q not found", name)
 		}
 
 		// Get network from primary bucket
 		networksBucket := tx.Bucket([]byte(BucketNetworks))
 		data := networksBucket.Get(id)
 		if data == nil {
-			return fmt.Errorf("network data not found")
+			return kindErrorf(ErrNotFound, "network data not found")
 		}
 
 		network = &VirtualNetwork{}
@@ -230,11 +660,11 @@ func (sm *StorageManager) GetNetworkByName(name string) (*VirtualNetwork, error)
 func (sm *StorageManager) GetNetworkByID(id string) (*VirtualNetwork, error) {
 	var network *VirtualNetwork
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.view(func(tx *bbolt.Tx) error {
 		networksBucket := tx.Bucket([]byte(BucketNetworks))
 		data := networksBucket.Get([]byte(id))
 		if data == nil {
-			return fmt.Errorf("network %q not found", id)
+			return kindErrorf(ErrNotFound, "network %q not found", id)
 		}
 
 		network = &VirtualNetwork{}
@@ -248,486 +678,1645 @@ func (sm *StorageManager) GetNetworkByID(id string) (*VirtualNetwork, error) {
 	return network, err
 }
 
-// ListNetworks lists all networks
+// ListNetworks lists all networks, sorted by name.
 func (sm *StorageManager) ListNetworks() ([]*VirtualNetwork, error) {
+	return sm.ListNetworksCtx(context.Background())
+}
+
+// ListNetworksCtx is ListNetworks with a context.
+func (sm *StorageManager) ListNetworksCtx(ctx context.Context) ([]*VirtualNetwork, error) {
 	var networks []*VirtualNetwork
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
 		networksBucket := tx.Bucket([]byte(BucketNetworks))
-		return networksBucket.ForEach(func(_, v []byte) error {
+		return networksBucket.ForEach(checkCtx(ctx, func(_, v []byte) error {
 			network := &VirtualNetwork{}
 			if err := json.Unmarshal(v, network); err != nil {
 				return err
 			}
 			networks = append(networks, network)
 			return nil
-		})
+		}))
 	})
+	// The bucket is keyed by UUID; order by name so listings are stable.
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
 
 	return networks, err
 }
 
-// DeleteNetwork deletes a network and all its associated resources
-func (sm *StorageManager) DeleteNetwork(name string) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
-		// Get network ID
+// RenameNetwork renames a network. The primary record and the name index are
+// updated in one transaction; servers, nodes, and configs key on the network
+// ID and are left untouched.
+func (sm *StorageManager) RenameNetwork(oldName, newName string) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+
+	err := sm.update(func(tx *bbolt.Tx) error {
 		nameIdx := tx.Bucket([]byte(BucketNetworksByName))
-		id := nameIdx.Get([]byte(name))
+		id := nameIdx.Get([]byte(oldName))
 		if id == nil {
-			return fmt.Errorf("network %q not found", name)
+			return kindErrorf(ErrNotFound, "network %q not found", oldName)
 		}
-		idStr := string(id)
-		prefix := []byte(idStr + ":")
-
-		// Delete the server (one per network) via the by-network index.
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		serversByName := tx.Bucket([]byte(BucketServersByName))
-		serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
-		if serverID := serversByNetwork.Get([]byte(idStr)); serverID != nil {
-			serverID = append([]byte(nil), serverID...)
-			if data := serversBucket.Get(serverID); data != nil {
-				server := &Server{}
-				if err := json.Unmarshal(data, server); err != nil {
-					return err
-				}
-				if err := serversByName.Delete([]byte(idStr + ":" + server.Name)); err != nil {
-					return err
-				}
-			}
-			if err := serversBucket.Delete(serverID); err != nil {
-				return err
-			}
-			if err := serversByNetwork.Delete([]byte(idStr)); err != nil {
-				return err
-			}
+		id = append([]byte(nil), id...)
+		if nameIdx.Get([]byte(newName)) != nil {
+			return kindErrorf(ErrAlreadyExists, "network name %q already exists", newName)
 		}
 
-		// Delete all nodes via the by-network index prefix.
-		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		nodesByName := tx.Bucket([]byte(BucketNodesByName))
-		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
-		var nodeIdxKeys, nodeIDs [][]byte
-		if err := forEachWithPrefix(nodesByNetwork, prefix, func(k, v []byte) error {
-			nodeIdxKeys = append(nodeIdxKeys, append([]byte(nil), k...))
-			nodeIDs = append(nodeIDs, append([]byte(nil), v...))
-			return nil
-		}); err != nil {
-			return err
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get(id)
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
 		}
-		for i, nodeID := range nodeIDs {
-			if data := nodesBucket.Get(nodeID); data != nil {
-				node := &Node{}
-				if err := json.Unmarshal(data, node); err != nil {
-					return err
-				}
-				if err := nodesByName.Delete([]byte(idStr + ":" + node.Name)); err != nil {
-					return err
-				}
-			}
-			if err := nodesBucket.Delete(nodeID); err != nil {
-				return err
-			}
-			if err := nodesByNetwork.Delete(nodeIdxKeys[i]); err != nil {
-				return err
-			}
+		network = &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
 		}
+		network.Name = newName
 
-		// Delete all config versions via the by-network index prefix.
-		configsBucket := tx.Bucket([]byte(BucketConfigs))
-		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
-		var cfgIdxKeys, cfgIDs [][]byte
-		if err := forEachWithPrefix(configsByVer, prefix, func(k, v []byte) error {
-			cfgIdxKeys = append(cfgIdxKeys, append([]byte(nil), k...))
-			cfgIDs = append(cfgIDs, append([]byte(nil), v...))
-			return nil
-		}); err != nil {
-			return err
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
 		}
-		for i, cfgID := range cfgIDs {
-			if err := configsBucket.Delete(cfgID); err != nil {
-				return err
-			}
-			if err := configsByVer.Delete(cfgIdxKeys[i]); err != nil {
-				return err
-			}
+		if err := networksBucket.Put(id, updated); err != nil {
+			return fmt.Errorf("failed to save network: %w", err)
 		}
 
-		// Delete IP pool
-		ipPoolsBucket := tx.Bucket([]byte(BucketIPPools))
-		if err := ipPoolsBucket.Delete([]byte(idStr)); err != nil {
+		// Move the name index entry (old name -> new name)
+		if err := nameIdx.Delete([]byte(oldName)); err != nil {
 			return err
 		}
-
-		// Delete network
-		networksBucket := tx.Bucket([]byte(BucketNetworks))
-		if err := networksBucket.Delete([]byte(idStr)); err != nil {
-			return err
+		if err := nameIdx.Put([]byte(newName), id); err != nil {
+			return fmt.Errorf("failed to save name index: %w", err)
 		}
-		return nameIdx.Delete([]byte(name))
-	})
-}
 
-// ========== Server Operations ==========
+		return bumpRevision(tx, string(id))
+	})
 
-// CreateServer creates a new server.
-func (sm *StorageManager) CreateServer(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error) {
-	var server *Server
+	return network, err
+}
 
-	err := sm.db.Update(func(tx *bbolt.Tx) error {
-		// Get network to verify it exists
+// UpdateNetwork reads a network, applies change to it and writes it back in
+// one transaction, so a change made meanwhile by another process is not
+// lost. An error from change leaves the network as it was. It returns the
+// network as written.
+func (sm *StorageManager) UpdateNetwork(id string, change func(*VirtualNetwork) error) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+	err := sm.update(func(tx *bbolt.Tx) error {
 		networksBucket := tx.Bucket([]byte(BucketNetworks))
-		if networksBucket.Get([]byte(networkID)) == nil {
-			return fmt.Errorf("network %q not found", networkID)
-		}
-
-		// One server per network — O(1) check via the by-network index.
-		serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
-		if serversByNetwork.Get([]byte(networkID)) != nil {
-			return fmt.Errorf("server already exists for network %q", networkID)
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
 		}
 
-		// Check if name already exists in this network
-		serversByName := tx.Bucket([]byte(BucketServersByName))
-		nameKey := networkID + ":" + name
-		if serversByName.Get([]byte(nameKey)) != nil {
-			return fmt.Errorf("server name %q already exists", name)
+		network = &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
 		}
-
-		server = &Server{
-			ID:            uuid.New().String(),
-			NetworkID:     networkID,
-			Name:          name,
-			PublicAddress: publicAddress,
-			Port:          port,
-			VirtualIP:     virtualIP,
-			PrivateKey:    privateKey,
-			PublicKey:     publicKey,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
+		if err := change(network); err != nil {
+			return err
 		}
 
-		// Save to primary bucket
-		data, err := json.Marshal(server)
+		updated, err := json.Marshal(network)
 		if err != nil {
-			return fmt.Errorf("failed to marshal server: %w", err)
+			return fmt.Errorf("failed to marshal network: %w", err)
 		}
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		if err := serversBucket.Put([]byte(server.ID), data); err != nil {
-			return fmt.Errorf("failed to save server: %w", err)
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
 		}
+		return bumpRevision(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
 
-		// Save to index buckets (name -> id, networkID -> id)
-		if err := serversByName.Put([]byte(nameKey), []byte(server.ID)); err != nil {
-			return fmt.Errorf("failed to save name index: %w", err)
-		}
-		if err := serversByNetwork.Put([]byte(networkID), []byte(server.ID)); err != nil {
-			return fmt.Errorf("failed to save network index: %w", err)
+// UpdateNetworkLabels replaces a network's labels.
+func (sm *StorageManager) UpdateNetworkLabels(id string, labels map[string]string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.Labels = labels
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkSettings replaces a network's settings.
+func (sm *StorageManager) UpdateNetworkSettings(id string, settings map[string]string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.Settings = settings
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkDefaultPort sets the default node port of a network.
+func (sm *StorageManager) UpdateNetworkDefaultPort(id string, port int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.DefaultPort = port
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkMaxNodes sets the node limit of a network.
+func (sm *StorageManager) UpdateNetworkMaxNodes(id string, maxNodes int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.MaxNodes = maxNodes
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkPoolWarnPercent sets the IP pool utilization a network warns
+// about.
+func (sm *StorageManager) UpdateNetworkPoolWarnPercent(id string, percent int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.PoolWarnPercent = percent
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkNATMode sets the NAT mode of a network.
+func (sm *StorageManager) UpdateNetworkNATMode(id string, mode NATMode) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.NATMode = mode
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// UpdateNetworkFreeze freezes a network's config, or unfreezes it when
+// freeze is nil.
+func (sm *StorageManager) UpdateNetworkFreeze(id string, freeze *ConfigFreeze) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+
+		network.Freeze = freeze
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+}
+
+// ResizeNetwork updates a network's CIDR and its IP pool state in one
+// transaction, so the record and the pool never disagree.
+func (sm *StorageManager) ResizeNetwork(id, cidr string, state *util.IPPoolState) (*VirtualNetwork, error) {
+	var network *VirtualNetwork
+
+	err := sm.update(func(tx *bbolt.Tx) error {
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		data := networksBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network data not found")
+		}
+
+		network = &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return fmt.Errorf("failed to unmarshal network: %w", err)
+		}
+		network.CIDR = cidr
+
+		updated, err := json.Marshal(network)
+		if err != nil {
+			return fmt.Errorf("failed to marshal network: %w", err)
+		}
+		if err := networksBucket.Put([]byte(id), updated); err != nil {
+			return fmt.Errorf("failed to save network: %w", err)
+		}
+		if err := sm.putIPPoolState(tx, id, state); err != nil {
+			return err
+		}
+		return bumpRevision(tx, id)
+	})
+
+	return network, err
+}
+
+// DeleteNetwork deletes a network and all its associated resources
+func (sm *StorageManager) DeleteNetwork(name string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		// Get network ID
+		nameIdx := tx.Bucket([]byte(BucketNetworksByName))
+		id := nameIdx.Get([]byte(name))
+		if id == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", name)
+		}
+		idStr := string(id)
+		prefix := []byte(idStr + ":")
+
+		// Delete all servers via the by-network index prefix.
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		serversByName := tx.Bucket([]byte(BucketServersByName))
+		serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
+		var serverIdxKeys, serverIDs [][]byte
+		if err := forEachWithPrefix(serversByNetwork, prefix, func(k, v []byte) error {
+			serverIdxKeys = append(serverIdxKeys, append([]byte(nil), k...))
+			serverIDs = append(serverIDs, append([]byte(nil), v...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i, serverID := range serverIDs {
+			if data := serversBucket.Get(serverID); data != nil {
+				server := &Server{}
+				if err := json.Unmarshal(data, server); err != nil {
+					return err
+				}
+				if err := serversByName.Delete([]byte(idStr + ":" + server.Name)); err != nil {
+					return err
+				}
+			}
+			if err := serversBucket.Delete(serverID); err != nil {
+				return err
+			}
+			if err := deleteDeployment(tx, serverID); err != nil {
+				return err
+			}
+			if err := deleteHistory(tx, serverID); err != nil {
+				return err
+			}
+			if err := serversByNetwork.Delete(serverIdxKeys[i]); err != nil {
+				return err
+			}
+		}
+
+		// Delete all nodes via the by-network index prefix.
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		nodesByName := tx.Bucket([]byte(BucketNodesByName))
+		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+		var nodeIdxKeys, nodeIDs [][]byte
+		if err := forEachWithPrefix(nodesByNetwork, prefix, func(k, v []byte) error {
+			nodeIdxKeys = append(nodeIdxKeys, append([]byte(nil), k...))
+			nodeIDs = append(nodeIDs, append([]byte(nil), v...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i, nodeID := range nodeIDs {
+			if data := nodesBucket.Get(nodeID); data != nil {
+				node := &Node{}
+				if err := json.Unmarshal(data, node); err != nil {
+					return err
+				}
+				if err := nodesByName.Delete([]byte(idStr + ":" + node.Name)); err != nil {
+					return err
+				}
+			}
+			if err := nodesBucket.Delete(nodeID); err != nil {
+				return err
+			}
+			if err := deleteDeployment(tx, nodeID); err != nil {
+				return err
+			}
+			if err := deleteHistory(tx, nodeID); err != nil {
+				return err
+			}
+			if err := nodesByNetwork.Delete(nodeIdxKeys[i]); err != nil {
+				return err
+			}
+		}
+
+		// Delete all config versions via the by-network index prefix.
+		configsBucket := tx.Bucket([]byte(BucketConfigs))
+		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+		var cfgIdxKeys, cfgIDs [][]byte
+		if err := forEachWithPrefix(configsByVer, prefix, func(k, v []byte) error {
+			cfgIdxKeys = append(cfgIdxKeys, append([]byte(nil), k...))
+			cfgIDs = append(cfgIDs, append([]byte(nil), v...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i, cfgID := range cfgIDs {
+			if err := configsBucket.Delete(cfgID); err != nil {
+				return err
+			}
+			if err := configsByVer.Delete(cfgIdxKeys[i]); err != nil {
+				return err
+			}
+		}
+		if err := deleteConfigTags(tx, idStr); err != nil {
+			return err
+		}
+		if err := deleteGuests(tx, idStr); err != nil {
+			return err
+		}
+
+		// Delete IP pool
+		ipPoolsBucket := tx.Bucket([]byte(BucketIPPools))
+		if err := ipPoolsBucket.Delete([]byte(idStr)); err != nil {
+			return err
+		}
+
+		if err := deleteRevision(tx, idStr); err != nil {
+			return err
+		}
+		if err := deleteSequence(tx, idStr); err != nil {
+			return err
+		}
+
+		// Delete network
+		networksBucket := tx.Bucket([]byte(BucketNetworks))
+		if err := networksBucket.Delete([]byte(idStr)); err != nil {
+			return err
+		}
+		return nameIdx.Delete([]byte(name))
+	})
+}
+
+// ========== Server Operations ==========
+
+// CreateServer creates a new server.
+func (sm *StorageManager) CreateServer(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error) {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var server *Server
+	err = sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		server, err = createServer(tx, networkID, name, publicAddress, port, virtualIP, storedKey, publicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	server.PrivateKey = privateKey
+	return server, nil
+}
+
+// CreateServerWithPoolState creates a new server and saves the network's IP
+// pool state in one transaction, so a failure leaves neither written.
+func (sm *StorageManager) CreateServerWithPoolState(networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string, state *util.IPPoolState) (*Server, error) {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var server *Server
+	err = sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		if server, err = createServer(tx, networkID, name, publicAddress, port, virtualIP, storedKey, publicKey); err != nil {
+			return err
+		}
+		return sm.putIPPoolState(tx, networkID, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	server.PrivateKey = privateKey
+	return server, nil
+}
+
+// createServer writes a new server record and its indexes within tx.
+func createServer(tx *bbolt.Tx, networkID, name, publicAddress string, port int, virtualIP, privateKey, publicKey string) (*Server, error) {
+	// Get network to verify it exists
+	networksBucket := tx.Bucket([]byte(BucketNetworks))
+	if networksBucket.Get([]byte(networkID)) == nil {
+		return nil, kindErrorf(ErrNotFound, "network %q not found", networkID)
+	}
+
+	// Check if name already exists in this network
+	serversByName := tx.Bucket([]byte(BucketServersByName))
+	nameKey := networkID + ":" + name
+	if serversByName.Get([]byte(nameKey)) != nil {
+		return nil, kindErrorf(ErrAlreadyExists, "server name %q already exists", name)
+	}
+	if err := checkPublicKeyUnique(tx, networkID, "", publicKey); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		ID:            uuid.New().String(),
+		NetworkID:     networkID,
+		Name:          name,
+		PublicAddress: publicAddress,
+		Port:          port,
+		VirtualIP:     virtualIP,
+		PrivateKey:    privateKey,
+		PublicKey:     publicKey,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	// Save to primary bucket
+	data, err := json.Marshal(server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal server: %w", err)
+	}
+	serversBucket := tx.Bucket([]byte(BucketServers))
+	if err := serversBucket.Put([]byte(server.ID), data); err != nil {
+		return nil, fmt.Errorf("failed to save server: %w", err)
+	}
+
+	// Save to index buckets (name -> id, networkID:serverID -> id)
+	if err := serversByName.Put([]byte(nameKey), []byte(server.ID)); err != nil {
+		return nil, fmt.Errorf("failed to save name index: %w", err)
+	}
+	serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
+	if err := serversByNetwork.Put([]byte(networkID+":"+server.ID), []byte(server.ID)); err != nil {
+		return nil, fmt.Errorf("failed to save network index: %w", err)
+	}
+
+	return server, bumpRevision(tx, networkID)
+}
+
+// GetServerByName retrieves a server by name within a network
+func (sm *StorageManager) GetServerByName(networkID, name string) (*Server, error) {
+	var server *Server
+
+	err := sm.view(func(tx *bbolt.Tx) error {
+		serversByName := tx.Bucket([]byte(BucketServersByName))
+		nameKey := networkID + ":" + name
+		id := serversByName.Get([]byte(nameKey))
+		if id == nil {
+			return kindErrorf(ErrNotFound, "server %q not found", name)
+		}
+
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get(id)
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server data not found")
+		}
+
+		server = &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return fmt.Errorf("failed to unmarshal server: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return server, sm.openServers(server)
+}
+
+// GetServerByNetworkID retrieves the network's first server, the one nodes
+// without an assigned server use. A network without servers is an
+// ErrNotFound error; ListServersByNetworkID returns none without one.
+func (sm *StorageManager) GetServerByNetworkID(networkID string) (*Server, error) {
+	servers, err := sm.ListServersByNetworkID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, kindErrorf(ErrNotFound, "no server found for network %q", networkID)
+	}
+	return servers[0], nil
+}
+
+// ListServersByNetworkID lists the servers of a network, oldest first.
+func (sm *StorageManager) ListServersByNetworkID(networkID string) ([]*Server, error) {
+	return sm.ListServersByNetworkIDCtx(context.Background(), networkID)
+}
+
+// ListServersByNetworkIDCtx is ListServersByNetworkID with a context.
+func (sm *StorageManager) ListServersByNetworkIDCtx(ctx context.Context, networkID string) ([]*Server, error) {
+	var servers []*Server
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		servers, err = listServers(tx, networkID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return servers, sm.openServers(servers...)
+}
+
+// listServers reads the servers of a network within tx, oldest first.
+func listServers(tx *bbolt.Tx, networkID string) ([]*Server, error) {
+	var servers []*Server
+	serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
+	serversBucket := tx.Bucket([]byte(BucketServers))
+	err := forEachWithPrefix(serversByNetwork, []byte(networkID+":"), func(_, v []byte) error {
+		data := serversBucket.Get(v)
+		if data == nil {
+			return nil
+		}
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return err
+		}
+		servers = append(servers, server)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is keyed by UUID; order by creation so the first server is
+	// stable.
+	sort.SliceStable(servers, func(i, j int) bool {
+		if !servers[i].CreatedAt.Equal(servers[j].CreatedAt) {
+			return servers[i].CreatedAt.Before(servers[j].CreatedAt)
+		}
+		return servers[i].Name < servers[j].Name
+	})
+	return servers, nil
+}
+
+// UpdateServer updates server information.
+func (sm *StorageManager) UpdateServer(id, publicAddress string, port int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server not found")
+		}
+
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return err
+		}
+		before := *server
+
+		server.PublicAddress = publicAddress
+		server.Port = port
+		server.Revision++
+		server.UpdatedAt = time.Now()
+		server.trackAddress(before.PublicAddress, server.PublicAddress, server.UpdatedAt)
+
+		updated, err := json.Marshal(server)
+		if err != nil {
+			return fmt.Errorf("failed to marshal server: %w", err)
+		}
+		if err := serversBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, id, HistoryUpdate, &before, server); err != nil {
+			return err
+		}
+		return bumpRevision(tx, server.NetworkID)
+	})
+}
+
+// ReplaceServer writes the editable fields of server (its endpoints) over
+// the stored record with the same ID. The write is refused with ErrConflict
+// unless the stored revision still equals revision, the one the edit was
+// based on; AnyRevision skips the check.
+func (sm *StorageManager) ReplaceServer(server *Server, revision int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get([]byte(server.ID))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server not found")
+		}
+
+		stored := &Server{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			return err
+		}
+		if revision != AnyRevision && stored.Revision != revision {
+			return kindErrorf(ErrConflict, "server %q was modified by another process, re-run your edit", stored.Name)
+		}
+		before := *stored
+
+		copyServerEdit(stored, server)
+		stored.Revision++
+		stored.UpdatedAt = time.Now()
+		stored.trackAddress(before.PublicAddress, stored.PublicAddress, stored.UpdatedAt)
+
+		updated, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("failed to marshal server: %w", err)
+		}
+		if err := serversBucket.Put([]byte(server.ID), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, server.ID, HistoryUpdate, &before, stored); err != nil {
+			return err
+		}
+		return bumpRevision(tx, stored.NetworkID)
+	})
+}
+
+// UpdateServerInternalEndpoint sets a server's internal endpoint; an empty
+// address removes it.
+func (sm *StorageManager) UpdateServerInternalEndpoint(id, address string, port int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server not found")
+		}
+
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return err
+		}
+
+		server.InternalAddress = address
+		server.InternalPort = port
+		server.Revision++
+		server.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(server)
+		if err != nil {
+			return fmt.Errorf("failed to marshal server: %w", err)
+		}
+		if err := serversBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, server.NetworkID)
+	})
+}
+
+// UpdateServerKeys replaces a server's key pair.
+func (sm *StorageManager) UpdateServerKeys(id, privateKey, publicKey string) error {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return sm.update(func(tx *bbolt.Tx) error {
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server not found")
+		}
+
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return err
+		}
+		before := *server
+
+		if err := checkPublicKeyUnique(tx, server.NetworkID, id, publicKey); err != nil {
+			return err
+		}
+
+		server.PrivateKey = storedKey
+		server.PublicKey = publicKey
+		server.Revision++
+		server.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(server)
+		if err != nil {
+			return fmt.Errorf("failed to marshal server: %w", err)
+		}
+		if err := serversBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, id, HistoryRekey, &before, server); err != nil {
+			return err
+		}
+		return bumpRevision(tx, server.NetworkID)
+	})
+}
+
+// RenameServer renames a server of a network, updating the record and its
+// networkID:name index entry in one transaction.
+func (sm *StorageManager) RenameServer(networkID, oldName, newName string) (*Server, error) {
+	var server *Server
+
+	err := sm.update(func(tx *bbolt.Tx) error {
+		serversByName := tx.Bucket([]byte(BucketServersByName))
+		id := serversByName.Get([]byte(networkID + ":" + oldName))
+		if id == nil {
+			return kindErrorf(ErrNotFound, "server %q not found", oldName)
+		}
+		id = append([]byte(nil), id...)
+
+		newKey := networkID + ":" + newName
+		if serversByName.Get([]byte(newKey)) != nil {
+			return kindErrorf(ErrAlreadyExists, "server name %q already exists", newName)
+		}
+
+		serversBucket := tx.Bucket([]byte(BucketServers))
+		data := serversBucket.Get(id)
+		if data == nil {
+			return kindErrorf(ErrNotFound, "server data not found")
+		}
+		server = &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return err
+		}
+		before := *server
+		oldKey := networkID + ":" + server.Name
+		server.Name = newName
+		server.Revision++
+		server.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(server)
+		if err != nil {
+			return fmt.Errorf("failed to marshal server: %w", err)
+		}
+		if err := serversBucket.Put(id, updated); err != nil {
+			return fmt.Errorf("failed to save server: %w", err)
+		}
+		if err := sm.recordHistory(tx, string(id), HistoryRename, &before, server); err != nil {
+			return err
+		}
+
+		if err := serversByName.Delete([]byte(oldKey)); err != nil {
+			return err
+		}
+		if err := serversByName.Put([]byte(newKey), id); err != nil {
+			return fmt.Errorf("failed to save name index: %w", err)
+		}
+
+		return bumpRevision(tx, networkID)
+	})
+
+	return server, err
+}
+
+// DeleteServer deletes a server. Nodes assigned to it fall back to the
+// network's first remaining server.
+func (sm *StorageManager) DeleteServer(networkID, name string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		return deleteServer(tx, networkID, name)
+	})
+}
+
+// DeleteServerWithPoolState deletes a server and saves the network's IP pool
+// state, which no longer records the server's address, in one transaction.
+func (sm *StorageManager) DeleteServerWithPoolState(networkID, name string, state *util.IPPoolState) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		if err := deleteServer(tx, networkID, name); err != nil {
+			return err
+		}
+		return sm.putIPPoolState(tx, networkID, state)
+	})
+}
+
+// DeleteServerAndNodesWithPoolState deletes a server together with nodes of
+// its network, and saves the network's IP pool state, which no longer records
+// their addresses, in one transaction.
+func (sm *StorageManager) DeleteServerAndNodesWithPoolState(networkID, name string, nodeNames []string, state *util.IPPoolState) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		for _, nodeName := range nodeNames {
+			if err := deleteNode(tx, networkID, nodeName); err != nil {
+				return err
+			}
+		}
+		if err := deleteServer(tx, networkID, name); err != nil {
+			return err
+		}
+		return sm.putIPPoolState(tx, networkID, state)
+	})
+}
+
+// deleteServer removes a server record and its indexes within tx, and clears
+// the assignment of nodes that used it.
+func deleteServer(tx *bbolt.Tx, networkID, name string) error {
+	serversByName := tx.Bucket([]byte(BucketServersByName))
+	nameKey := networkID + ":" + name
+	id := serversByName.Get([]byte(nameKey))
+	if id == nil {
+		return kindErrorf(ErrNotFound, "server %q not found", name)
+	}
+	idStr := string(id)
+
+	serversBucket := tx.Bucket([]byte(BucketServers))
+	if err := serversBucket.Delete([]byte(idStr)); err != nil {
+		return err
+	}
+	if err := serversByName.Delete([]byte(nameKey)); err != nil {
+		return err
+	}
+	if err := deleteDeployment(tx, id); err != nil {
+		return err
+	}
+	if err := deleteHistory(tx, id); err != nil {
+		return err
+	}
+	serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
+	if err := serversByNetwork.Delete([]byte(networkID + ":" + idStr)); err != nil {
+		return err
+	}
+
+	// Collect the assigned nodes first: a bucket cannot be modified while
+	// it is being iterated.
+	nodesBucket := tx.Bucket([]byte(BucketNodes))
+	var assigned []*Node
+	if err := forEachWithPrefix(tx.Bucket([]byte(BucketNodesByNetwork)), []byte(networkID+":"), func(_, v []byte) error {
+		data := nodesBucket.Get(v)
+		if data == nil {
+			return nil
+		}
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
+		if node.ServerID == idStr {
+			assigned = append(assigned, node)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, node := range assigned {
+		node.ServerID = ""
+		node.Revision++
+		node.UpdatedAt = time.Now()
+		data, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(node.ID), data); err != nil {
+			return fmt.Errorf("failed to save node: %w", err)
+		}
+	}
+	return bumpRevision(tx, networkID)
+}
+
+// ========== Node Operations ==========
+
+// CreateNode creates a new node.
+func (sm *StorageManager) CreateNode(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error) {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var node *Node
+	err = sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		node, err = createNode(tx, networkID, name, publicAddress, port, virtualIP, nodeType, storedKey, publicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	node.PrivateKey = privateKey
+	return node, nil
+}
+
+// CreateNodeWithPoolState creates a new node and saves the network's IP pool
+// state, which records the node's address as allocated, in one transaction.
+// Either both are written or neither is, so a failure cannot leave a node
+// whose address the saved pool would hand out again.
+func (sm *StorageManager) CreateNodeWithPoolState(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string, state *util.IPPoolState) (*Node, error) {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var node *Node
+	err = sm.update(func(tx *bbolt.Tx) error {
+		var err error
+		if node, err = createNode(tx, networkID, name, publicAddress, port, virtualIP, nodeType, storedKey, publicKey); err != nil {
+			return err
+		}
+		return sm.putIPPoolState(tx, networkID, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	node.PrivateKey = privateKey
+	return node, nil
+}
+
+// createNode writes a new node record and its indexes within tx.
+func createNode(tx *bbolt.Tx, networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error) {
+	// Get network to verify it exists
+	networksBucket := tx.Bucket([]byte(BucketNetworks))
+	if networksBucket.Get([]byte(networkID)) == nil {
+		return nil, kindErrorf(ErrNotFound, "network %q not found", networkID)
+	}
+
+	// Check if name already exists in this network
+	nodesByName := tx.Bucket([]byte(BucketNodesByName))
+	nameKey := networkID + ":" + name
+	if nodesByName.Get([]byte(nameKey)) != nil {
+		return nil, kindErrorf(ErrAlreadyExists, "node name %q already exists", name)
+	}
+	if err := checkPublicKeyUnique(tx, networkID, "", publicKey); err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		ID:            uuid.New().String(),
+		NetworkID:     networkID,
+		Name:          name,
+		PublicAddress: publicAddress,
+		Port:          port,
+		VirtualIP:     virtualIP,
+		Type:          nodeType,
+		PrivateKey:    privateKey,
+		PublicKey:     publicKey,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	// Save to primary bucket
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node: %w", err)
+	}
+	nodesBucket := tx.Bucket([]byte(BucketNodes))
+	if err := nodesBucket.Put([]byte(node.ID), data); err != nil {
+		return nil, fmt.Errorf("failed to save node: %w", err)
+	}
+
+	// Save to index buckets (name -> id, networkID:nodeID -> id)
+	if err := nodesByName.Put([]byte(nameKey), []byte(node.ID)); err != nil {
+		return nil, fmt.Errorf("failed to save name index: %w", err)
+	}
+	nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+	if err := nodesByNetwork.Put([]byte(networkID+":"+node.ID), []byte(node.ID)); err != nil {
+		return nil, fmt.Errorf("failed to save network index: %w", err)
+	}
+
+	return node, bumpRevision(tx, networkID)
+}
+
+// GetNodeByName retrieves a node by name within a specific network
+func (sm *StorageManager) GetNodeByName(networkID, name string) (*Node, error) {
+	var node *Node
+
+	err := sm.view(func(tx *bbolt.Tx) error {
+		nodesByName := tx.Bucket([]byte(BucketNodesByName))
+		nameKey := networkID + ":" + name
+		id := nodesByName.Get([]byte(nameKey))
+		if id == nil {
+			return kindErrorf(ErrNotFound, "node %q not found", name)
+		}
+
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get(id)
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node data not found")
+		}
+
+		node = &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return fmt.Errorf("failed to unmarshal node: %w", err)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return node, sm.openNodes(node)
+}
+
+// ListNodesByNetworkID lists all nodes in a network, sorted by name.
+func (sm *StorageManager) ListNodesByNetworkID(networkID string) ([]*Node, error) {
+	return sm.ListNodesByNetworkIDCtx(context.Background(), networkID)
+}
+
+// ListNodesByNetworkIDCtx is ListNodesByNetworkID with a context.
+func (sm *StorageManager) ListNodesByNetworkIDCtx(ctx context.Context, networkID string) ([]*Node, error) {
+	var nodes []*Node
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		nodes, err = listNodes(ctx, tx, networkID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, sm.openNodes(nodes...)
+}
+
+// CountNodesByNetworkCtx returns the number of nodes of every network that
+// has any, by network ID, in one pass over the nodes_by_network index.
+func (sm *StorageManager) CountNodesByNetworkCtx(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BucketNodesByNetwork)).ForEach(checkCtx(ctx, func(k, _ []byte) error {
+			networkID, _, _ := bytes.Cut(k, []byte(":"))
+			counts[string(networkID)]++
+			return nil
+		}))
+	})
+
+	return counts, err
+}
+
+// listNodes reads the nodes of a network within tx, sorted by name,
+// stopping early when ctx is cancelled.
+func listNodes(ctx context.Context, tx *bbolt.Tx, networkID string) ([]*Node, error) {
+	var nodes []*Node
+	nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+	nodesBucket := tx.Bucket([]byte(BucketNodes))
+	err := forEachWithPrefix(nodesByNetwork, []byte(networkID+":"), checkCtx(ctx, func(_, v []byte) error {
+		data := nodesBucket.Get(v)
+		if data == nil {
+			return nil
+		}
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	// The index is keyed by UUID; order by name so listings are stable.
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// UpdateNode updates node information.
+func (sm *StorageManager) UpdateNode(id, publicAddress string, port int, nodeType NodeType) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
+		}
 
-	return server, err
-}
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
+		before := *node
 
-// GetServerByName retrieves a server by name within a network
-func (sm *StorageManager) GetServerByName(networkID, name string) (*Server, error) {
-	var server *Server
+		node.PublicAddress = publicAddress
+		node.Port = port
+		node.Type = nodeType
+		node.Revision++
+		node.UpdatedAt = time.Now()
+		node.trackAddress(before.PublicAddress, node.PublicAddress, node.UpdatedAt)
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
-		serversByName := tx.Bucket([]byte(BucketServersByName))
-		nameKey := networkID + ":" + name
-		id := serversByName.Get([]byte(nameKey))
-		if id == nil {
-			return fmt.Errorf("server %q not found", name)
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, id, HistoryUpdate, &before, node); err != nil {
+			return err
 		}
+		return bumpRevision(tx, node.NetworkID)
+	})
+}
 
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		data := serversBucket.Get(id)
+// ReplaceNode writes the editable fields of node over the stored record with
+// the same ID: everything 'node edit' changes, but not its name, keys or
+// virtual IP. The write is refused with ErrConflict unless the stored
+// revision still equals revision, the one the edit was based on;
+// AnyRevision skips the check.
+func (sm *StorageManager) ReplaceNode(node *Node, revision int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(node.ID))
 		if data == nil {
-			return fmt.Errorf("server data not found")
+			return kindErrorf(ErrNotFound, "node not found")
 		}
 
-		server = &Server{}
-		if err := json.Unmarshal(data, server); err != nil {
-			return fmt.Errorf("failed to unmarshal server: %w", err)
+		stored := &Node{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			return err
 		}
+		if revision != AnyRevision && stored.Revision != revision {
+			return kindErrorf(ErrConflict, "node %q was modified by another process, re-run your edit", stored.Name)
+		}
+		before := *stored
 
-		return nil
-	})
+		copyNodeEdit(stored, node)
+		stored.Revision++
+		stored.UpdatedAt = time.Now()
+		stored.trackAddress(before.PublicAddress, stored.PublicAddress, stored.UpdatedAt)
 
-	return server, err
+		updated, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(node.ID), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, node.ID, HistoryUpdate, &before, stored); err != nil {
+			return err
+		}
+		return bumpRevision(tx, stored.NetworkID)
+	})
 }
 
-// GetServerByNetworkID retrieves the server for a network
-func (sm *StorageManager) GetServerByNetworkID(networkID string) (*Server, error) {
-	var server *Server
-
-	err := sm.db.View(func(tx *bbolt.Tx) error {
-		serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
-		id := serversByNetwork.Get([]byte(networkID))
-		if id == nil {
-			return fmt.Errorf("no server found for network %q", networkID)
+// UpdateNodeRoutedCIDRs replaces the subnets a node routes for.
+func (sm *StorageManager) UpdateNodeRoutedCIDRs(id string, routedCIDRs []string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
 		}
 
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		data := serversBucket.Get(id)
-		if data == nil {
-			return fmt.Errorf("no server found for network %q", networkID)
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
 		}
 
-		server = &Server{}
-		if err := json.Unmarshal(data, server); err != nil {
-			return fmt.Errorf("failed to unmarshal server: %w", err)
+		node.RoutedCIDRs = routedCIDRs
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
 		}
-		return nil
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, node.NetworkID)
 	})
-
-	return server, err
 }
 
-// UpdateServer updates server information.
-func (sm *StorageManager) UpdateServer(id, publicAddress string, port int) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		data := serversBucket.Get([]byte(id))
+// UpdateNodeLabels replaces a node's labels.
+func (sm *StorageManager) UpdateNodeLabels(id string, labels map[string]string) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
 		if data == nil {
-			return fmt.Errorf("server not found")
+			return kindErrorf(ErrNotFound, "node not found")
 		}
 
-		server := &Server{}
-		if err := json.Unmarshal(data, server); err != nil {
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
 			return err
 		}
 
-		server.PublicAddress = publicAddress
-		server.Port = port
-		server.UpdatedAt = time.Now()
+		node.Labels = labels
+		node.Revision++
+		node.UpdatedAt = time.Now()
 
-		updated, err := json.Marshal(server)
+		updated, err := json.Marshal(node)
 		if err != nil {
-			return fmt.Errorf("failed to marshal server: %w", err)
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
 		}
-		return serversBucket.Put([]byte(id), updated)
+		return bumpRevision(tx, node.NetworkID)
 	})
 }
 
-// DeleteServer deletes a server
-func (sm *StorageManager) DeleteServer(networkID string) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
-		serversByNetwork := tx.Bucket([]byte(BucketServersByNetwork))
-		id := serversByNetwork.Get([]byte(networkID))
-		if id == nil {
-			return fmt.Errorf("server not found for network")
+// UpdateNodeServer sets the server a node is assigned to and whether it
+// peers with every server of the network.
+func (sm *StorageManager) UpdateNodeServer(id, serverID string, meshServers bool) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
 		}
-		id = append([]byte(nil), id...)
 
-		serversBucket := tx.Bucket([]byte(BucketServers))
-		serversByName := tx.Bucket([]byte(BucketServersByName))
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
 
-		if data := serversBucket.Get(id); data != nil {
-			server := &Server{}
-			if err := json.Unmarshal(data, server); err != nil {
-				return err
-			}
-			if err := serversByName.Delete([]byte(networkID + ":" + server.Name)); err != nil {
-				return err
-			}
+		node.ServerID = serverID
+		node.MeshServers = meshServers
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
 		}
-		if err := serversBucket.Delete(id); err != nil {
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
 			return err
 		}
-		return serversByNetwork.Delete([]byte(networkID))
+		return bumpRevision(tx, node.NetworkID)
 	})
 }
 
-// ========== Node Operations ==========
+// UpdateNodeExpiry sets when a node's access ends; nil removes the expiry.
+func (sm *StorageManager) UpdateNodeExpiry(id string, expiresAt *time.Time) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
+		}
 
-// CreateNode creates a new node.
-func (sm *StorageManager) CreateNode(networkID, name, publicAddress string, port int, virtualIP string, nodeType NodeType, privateKey, publicKey string) (*Node, error) {
-	var node *Node
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
 
-	err := sm.db.Update(func(tx *bbolt.Tx) error {
-		// Get network to verify it exists
-		networksBucket := tx.Bucket([]byte(BucketNetworks))
-		if networksBucket.Get([]byte(networkID)) == nil {
-			return fmt.Errorf("network %q not found", networkID)
+		node.ExpiresAt = expiresAt
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
 		}
+		return bumpRevision(tx, node.NetworkID)
+	})
+}
 
-		// Check if name already exists in this network
-		nodesByName := tx.Bucket([]byte(BucketNodesByName))
-		nameKey := networkID + ":" + name
-		if nodesByName.Get([]byte(nameKey)) != nil {
-			return fmt.Errorf("node name %q already exists", name)
+// UpdateNodeFullTunnel sets whether a node routes all of its traffic
+// through its server.
+func (sm *StorageManager) UpdateNodeFullTunnel(id string, fullTunnel bool) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
 		}
 
-		node = &Node{
-			ID:            uuid.New().String(),
-			NetworkID:     networkID,
-			Name:          name,
-			PublicAddress: publicAddress,
-			Port:          port,
-			VirtualIP:     virtualIP,
-			Type:          nodeType,
-			PrivateKey:    privateKey,
-			PublicKey:     publicKey,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
 		}
 
-		// Save to primary bucket
-		data, err := json.Marshal(node)
+		node.FullTunnel = fullTunnel
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
 		if err != nil {
 			return fmt.Errorf("failed to marshal node: %w", err)
 		}
-		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		if err := nodesBucket.Put([]byte(node.ID), data); err != nil {
-			return fmt.Errorf("failed to save node: %w", err)
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
 		}
+		return bumpRevision(tx, node.NetworkID)
+	})
+}
 
-		// Save to index buckets (name -> id, networkID:nodeID -> id)
-		if err := nodesByName.Put([]byte(nameKey), []byte(node.ID)); err != nil {
-			return fmt.Errorf("failed to save name index: %w", err)
+// UpdateNodeInternalEndpoint sets a node's internal endpoint; an empty
+// address removes it.
+func (sm *StorageManager) UpdateNodeInternalEndpoint(id, address string, port int) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
 		}
-		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
-		if err := nodesByNetwork.Put([]byte(networkID+":"+node.ID), []byte(node.ID)); err != nil {
-			return fmt.Errorf("failed to save network index: %w", err)
+
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
 		}
 
-		return nil
-	})
+		node.InternalAddress = address
+		node.InternalPort = port
+		node.Revision++
+		node.UpdatedAt = time.Now()
 
-	return node, err
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		return bumpRevision(tx, node.NetworkID)
+	})
 }
 
-// GetNodeByName retrieves a node by name within a specific network
-func (sm *StorageManager) GetNodeByName(networkID, name string) (*Node, error) {
-	var node *Node
+// UpdateNodePreferInternal sets whether a node dials its peers at their
+// internal endpoints.
+func (sm *StorageManager) UpdateNodePreferInternal(id string, preferInternal bool) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		nodesBucket := tx.Bucket([]byte(BucketNodes))
+		data := nodesBucket.Get([]byte(id))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "node not found")
+		}
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
-		nodesByName := tx.Bucket([]byte(BucketNodesByName))
-		nameKey := networkID + ":" + name
-		id := nodesByName.Get([]byte(nameKey))
-		if id == nil {
-			return fmt.Errorf("node %q not found", name)
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return err
+		}
+
+		node.PreferInternal = preferInternal
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
 		}
+		return bumpRevision(tx, node.NetworkID)
+	})
+}
 
+// UpdateNodeKeys replaces a node's key pair.
+func (sm *StorageManager) UpdateNodeKeys(id, privateKey, publicKey string) error {
+	storedKey, err := sm.sealKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return sm.update(func(tx *bbolt.Tx) error {
 		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		data := nodesBucket.Get(id)
+		data := nodesBucket.Get([]byte(id))
 		if data == nil {
-			return fmt.Errorf("node data not found")
+			return kindErrorf(ErrNotFound, "node not found")
 		}
 
-		node = &Node{}
+		node := &Node{}
 		if err := json.Unmarshal(data, node); err != nil {
-			return fmt.Errorf("failed to unmarshal node: %w", err)
+			return err
 		}
+		before := *node
 
-		return nil
-	})
+		if err := checkPublicKeyUnique(tx, node.NetworkID, id, publicKey); err != nil {
+			return err
+		}
 
-	return node, err
+		node.PrivateKey = storedKey
+		node.PublicKey = publicKey
+		node.Revision++
+		node.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %w", err)
+		}
+		if err := nodesBucket.Put([]byte(id), updated); err != nil {
+			return err
+		}
+		if err := sm.recordHistory(tx, id, HistoryRekey, &before, node); err != nil {
+			return err
+		}
+		return bumpRevision(tx, node.NetworkID)
+	})
 }
 
-// ListNodesByNetworkID lists all nodes in a network
-func (sm *StorageManager) ListNodesByNetworkID(networkID string) ([]*Node, error) {
-	var nodes []*Node
+// RenameNode renames a node within a network. The node keeps its ID, keys,
+// and virtual IP; only the record's name and its networkID:name index entry
+// change, in one transaction.
+func (sm *StorageManager) RenameNode(networkID, oldName, newName string) (*Node, error) {
+	var node *Node
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
-		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
-		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		return forEachWithPrefix(nodesByNetwork, []byte(networkID+":"), func(_, v []byte) error {
-			data := nodesBucket.Get(v)
-			if data == nil {
-				return nil
-			}
-			node := &Node{}
-			if err := json.Unmarshal(data, node); err != nil {
-				return err
-			}
-			nodes = append(nodes, node)
-			return nil
-		})
-	})
+	err := sm.update(func(tx *bbolt.Tx) error {
+		nodesByName := tx.Bucket([]byte(BucketNodesByName))
+		oldKey := networkID + ":" + oldName
+		id := nodesByName.Get([]byte(oldKey))
+		if id == nil {
+			return kindErrorf(ErrNotFound, "node %q not found", oldName)
+		}
+		id = append([]byte(nil), id...)
 
-	return nodes, err
-}
+		newKey := networkID + ":" + newName
+		if nodesByName.Get([]byte(newKey)) != nil {
+			return kindErrorf(ErrAlreadyExists, "node name %q already exists", newName)
+		}
 
-// UpdateNode updates node information.
-func (sm *StorageManager) UpdateNode(id, publicAddress string, port int, nodeType NodeType) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
 		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		data := nodesBucket.Get([]byte(id))
+		data := nodesBucket.Get(id)
 		if data == nil {
-			return fmt.Errorf("node not found")
+			return kindErrorf(ErrNotFound, "node data not found")
 		}
-
-		node := &Node{}
+		node = &Node{}
 		if err := json.Unmarshal(data, node); err != nil {
 			return err
 		}
-
-		node.PublicAddress = publicAddress
-		node.Port = port
-		node.Type = nodeType
+		before := *node
+		node.Name = newName
+		node.Revision++
 		node.UpdatedAt = time.Now()
 
 		updated, err := json.Marshal(node)
 		if err != nil {
 			return fmt.Errorf("failed to marshal node: %w", err)
 		}
-		return nodesBucket.Put([]byte(id), updated)
+		if err := nodesBucket.Put(id, updated); err != nil {
+			return fmt.Errorf("failed to save node: %w", err)
+		}
+		if err := sm.recordHistory(tx, string(id), HistoryRename, &before, node); err != nil {
+			return err
+		}
+
+		if err := nodesByName.Delete([]byte(oldKey)); err != nil {
+			return err
+		}
+		if err := nodesByName.Put([]byte(newKey), id); err != nil {
+			return fmt.Errorf("failed to save name index: %w", err)
+		}
+
+		return bumpRevision(tx, networkID)
 	})
+
+	return node, err
 }
 
 // DeleteNode deletes a node
 func (sm *StorageManager) DeleteNode(networkID, name string) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
-		nodesByName := tx.Bucket([]byte(BucketNodesByName))
-		nameKey := networkID + ":" + name
-		id := nodesByName.Get([]byte(nameKey))
-		if id == nil {
-			return fmt.Errorf("node %q not found", name)
-		}
-		idStr := string(id)
+	return sm.update(func(tx *bbolt.Tx) error {
+		return deleteNode(tx, networkID, name)
+	})
+}
 
-		nodesBucket := tx.Bucket([]byte(BucketNodes))
-		if err := nodesBucket.Delete([]byte(idStr)); err != nil {
-			return err
-		}
-		if err := nodesByName.Delete([]byte(nameKey)); err != nil {
+// DeleteNodeWithPoolState deletes a node and saves the network's IP pool
+// state, which no longer records the node's address, in one transaction.
+func (sm *StorageManager) DeleteNodeWithPoolState(networkID, name string, state *util.IPPoolState) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		if err := deleteNode(tx, networkID, name); err != nil {
 			return err
 		}
-		nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
-		return nodesByNetwork.Delete([]byte(networkID + ":" + idStr))
+		return sm.putIPPoolState(tx, networkID, state)
 	})
 }
 
+// deleteNode removes a node record and its indexes within tx.
+func deleteNode(tx *bbolt.Tx, networkID, name string) error {
+	nodesByName := tx.Bucket([]byte(BucketNodesByName))
+	nameKey := networkID + ":" + name
+	id := nodesByName.Get([]byte(nameKey))
+	if id == nil {
+		return kindErrorf(ErrNotFound, "node %q not found", name)
+	}
+	idStr := string(id)
+
+	nodesBucket := tx.Bucket([]byte(BucketNodes))
+	if err := nodesBucket.Delete([]byte(idStr)); err != nil {
+		return err
+	}
+	if err := nodesByName.Delete([]byte(nameKey)); err != nil {
+		return err
+	}
+	if err := deleteDeployment(tx, id); err != nil {
+		return err
+	}
+	if err := deleteHistory(tx, id); err != nil {
+		return err
+	}
+	nodesByNetwork := tx.Bucket([]byte(BucketNodesByNetwork))
+	if err := nodesByNetwork.Delete([]byte(networkID + ":" + idStr)); err != nil {
+		return err
+	}
+	return bumpRevision(tx, networkID)
+}
+
 // ========== Config Operations ==========
 
 // SaveConfigVersion saves a new config version.
 func (sm *StorageManager) SaveConfigVersion(networkID, contentHash string, configs map[string]string) (*ConfigVersion, error) {
+	return sm.SaveConfigVersionWithMessage(networkID, contentHash, configs, "", "")
+}
+
+// SaveConfigVersionWithMessage saves a new config version annotated with
+// why it was saved and by whom.
+func (sm *StorageManager) SaveConfigVersionWithMessage(networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error) {
+	return sm.SaveConfigVersionWithMessageCtx(context.Background(), networkID, contentHash, configs, message, changedBy)
+}
+
+// SaveConfigVersionWithMessageCtx is SaveConfigVersionWithMessage with a
+// context.
+func (sm *StorageManager) SaveConfigVersionWithMessageCtx(ctx context.Context, networkID, contentHash string, configs map[string]string, message, changedBy string) (*ConfigVersion, error) {
+	// Secrets seal to the same values every time, so the stored configs
+	// compare with the stored previous ones.
+	stored, err := sm.sealConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
 	var config *ConfigVersion
 
-	err := sm.db.Update(func(tx *bbolt.Tx) error {
+	err = sm.updateCtx(ctx, func(tx *bbolt.Tx) error {
 		configsBucket := tx.Bucket([]byte(BucketConfigs))
 		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
 
-		// Next version = highest existing version for this network + 1.
+		nextVer, err := nextConfigVersion(tx, networkID)
+		if err != nil {
+			return err
+		}
 		// The version index is keyed networkID:paddedVersion, so the last
-		// matching key holds the maximum version — only this network's
-		// (tiny) index entries are scanned.
-		nextVer := 1
-		prefix := []byte(networkID + ":")
-		c := configsByVer.Cursor()
-		var lastKey []byte
-		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
-			lastKey = k
-		}
-		if lastKey != nil {
-			var maxVer int
-			if _, err := fmt.Sscanf(string(lastKey[len(prefix):]), "%d", &maxVer); err == nil {
-				nextVer = maxVer + 1
+		// matching key is the previous version.
+		var previous map[string]string
+		if _, lastID := lastWithPrefix(configsByVer, []byte(networkID+":")); lastID != nil {
+			if data := configsBucket.Get(lastID); data != nil {
+				prev := &ConfigVersion{}
+				if err := json.Unmarshal(data, prev); err != nil {
+					return fmt.Errorf("failed to unmarshal previous config: %w", err)
+				}
+				previous = prev.Configs
 			}
 		}
 
@@ -736,7 +2325,10 @@ func (sm *StorageManager) SaveConfigVersion(networkID, contentHash string, confi
 			NetworkID:   networkID,
 			Version:     nextVer,
 			ContentHash: contentHash,
-			Configs:     configs,
+			Configs:     stored,
+			Changed:     changedConfigs(previous, stored),
+			Message:     message,
+			ChangedBy:   changedBy,
 			CreatedAt:   time.Now(),
 		}
 
@@ -756,73 +2348,101 @@ func (sm *StorageManager) SaveConfigVersion(networkID, contentHash string, confi
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	config.Configs = configs
+	return config, nil
+}
 
-	return config, err
+// changedConfigs returns the sorted names of the configs in current that are
+// missing from previous or differ from it.
+func changedConfigs(previous, current map[string]string) []string {
+	var changed []string
+	for name, config := range current {
+		if old, ok := previous[name]; !ok || normalizeConfig(old) != normalizeConfig(config) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
 }
 
 // GetLatestConfigVersion retrieves the latest config version for a network
 func (sm *StorageManager) GetLatestConfigVersion(networkID string) (*ConfigVersion, error) {
+	return sm.GetLatestConfigVersionCtx(context.Background(), networkID)
+}
+
+// GetLatestConfigVersionCtx is GetLatestConfigVersion with a context.
+func (sm *StorageManager) GetLatestConfigVersionCtx(ctx context.Context, networkID string) (*ConfigVersion, error) {
 	var latestConfig *ConfigVersion
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
 		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
 		configsBucket := tx.Bucket([]byte(BucketConfigs))
 
 		// The version index is sorted, so the last key for this network's
 		// prefix points at the highest version.
-		prefix := []byte(networkID + ":")
-		c := configsByVer.Cursor()
-		var lastID []byte
-		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			lastID = v
-		}
+		_, lastID := lastWithPrefix(configsByVer, []byte(networkID+":"))
 		if lastID == nil {
-			return fmt.Errorf("no config version found for network %q", networkID)
+			return kindErrorf(ErrNotFound, "no config version found for network %q", networkID)
 		}
 
 		data := configsBucket.Get(lastID)
 		if data == nil {
-			return fmt.Errorf("no config version found for network %q", networkID)
+			return kindErrorf(ErrNotFound, "no config version found for network %q", networkID)
 		}
 		latestConfig = &ConfigVersion{}
 		return json.Unmarshal(data, latestConfig)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return latestConfig, err
+	return latestConfig, sm.openConfigVersions(latestConfig)
 }
 
 // GetConfigVersion retrieves a specific config version
 func (sm *StorageManager) GetConfigVersion(networkID string, version int) (*ConfigVersion, error) {
 	var config *ConfigVersion
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.view(func(tx *bbolt.Tx) error {
 		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
 		id := configsByVer.Get([]byte(networkID + ":" + padVersion(version)))
 		if id == nil {
-			return fmt.Errorf("config version %d not found for network %q", version, networkID)
+			return kindErrorf(ErrNotFound, "config version %d not found for network %q", version, networkID)
 		}
 
 		data := tx.Bucket([]byte(BucketConfigs)).Get(id)
 		if data == nil {
-			return fmt.Errorf("config version %d not found for network %q", version, networkID)
+			return kindErrorf(ErrNotFound, "config version %d not found for network %q", version, networkID)
 		}
 		config = &ConfigVersion{}
 		return json.Unmarshal(data, config)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return config, err
+	return config, sm.openConfigVersions(config)
 }
 
 // ListConfigVersions lists all versions for a network, ordered by version.
 func (sm *StorageManager) ListConfigVersions(networkID string) ([]*ConfigVersion, error) {
+	return sm.ListConfigVersionsCtx(context.Background(), networkID)
+}
+
+// ListConfigVersionsCtx is ListConfigVersions with a context.
+func (sm *StorageManager) ListConfigVersionsCtx(ctx context.Context, networkID string) ([]*ConfigVersion, error) {
 	var versions []*ConfigVersion
 
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
 		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
 		configsBucket := tx.Bucket([]byte(BucketConfigs))
 
 		// Index keys are version-ordered, so results come out sorted.
-		return forEachWithPrefix(configsByVer, []byte(networkID+":"), func(_, v []byte) error {
+		return forEachWithPrefix(configsByVer, []byte(networkID+":"), checkCtx(ctx, func(_, v []byte) error {
 			data := configsBucket.Get(v)
 			if data == nil {
 				return nil
@@ -833,10 +2453,67 @@ func (sm *StorageManager) ListConfigVersions(networkID string) ([]*ConfigVersion
 			}
 			versions = append(versions, config)
 			return nil
-		})
+		}))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, sm.openConfigVersions(versions...)
+}
+
+// ListConfigVersionsFilteredCtx lists the versions of a network filter
+// selects, oldest first, and how many versions the network has in all. Only
+// the save time of each version is decoded to filter it; the configs of the
+// selected versions alone are read.
+func (sm *StorageManager) ListConfigVersionsFilteredCtx(ctx context.Context, networkID string, filter ConfigVersionFilter) ([]*ConfigVersion, int, error) {
+	var versions []*ConfigVersion
+	total := 0
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		configsByVer := tx.Bucket([]byte(BucketConfigsByVer))
+		configsBucket := tx.Bucket([]byte(BucketConfigs))
+
+		var selected [][]byte
+		err := forEachWithPrefix(configsByVer, []byte(networkID+":"), checkCtx(ctx, func(_, v []byte) error {
+			data := configsBucket.Get(v)
+			if data == nil {
+				return nil
+			}
+			total++
+			var header struct {
+				CreatedAt time.Time `json:"created_at"`
+			}
+			if err := json.Unmarshal(data, &header); err != nil {
+				return err
+			}
+			if filter.matches(header.CreatedAt) {
+				selected = append(selected, data)
+			}
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+		if filter.Last > 0 && len(selected) > filter.Last {
+			selected = selected[len(selected)-filter.Last:]
+		}
+
+		versions = make([]*ConfigVersion, 0, len(selected))
+		for _, data := range selected {
+			config := &ConfigVersion{}
+			if err := json.Unmarshal(data, config); err != nil {
+				return err
+			}
+			versions = append(versions, config)
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, 0, err
+	}
 
-	return versions, err
+	return versions, total, sm.openConfigVersions(versions...)
 }
 
 // GetConfigHashByVersion retrieves the hash of a specific version
@@ -848,31 +2525,452 @@ func (sm *StorageManager) GetConfigHashByVersion(networkID string, version int)
 	return config.ContentHash, nil
 }
 
-// ========== IP Pool Operations ==========
+// ========== Deployment Operations ==========
 
-// SaveIPPoolState persists IP pool state to the database
-func (sm *StorageManager) SaveIPPoolState(networkID string, state *util.IPPoolState) error {
-	return sm.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(BucketIPPools))
-		data, err := json.Marshal(state)
+// Deployment records the config last deployed to a server or node.
+type Deployment struct {
+	EntityID    string    `json:"entity_id"`
+	NetworkID   string    `json:"network_id"`
+	Version     int       `json:"version"`      // config version deployed; 0 if it was not saved yet
+	ContentHash string    `json:"content_hash"` // hash of the deployed config (see EntityConfigHash)
+	DeployedAt  time.Time `json:"deployed_at"`
+}
+
+// SaveDeployment records a deployment, replacing the entity's previous one.
+func (sm *StorageManager) SaveDeployment(deployment *Deployment) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(deployment)
+		if err != nil {
+			return fmt.Errorf("failed to marshal deployment: %w", err)
+		}
+		return tx.Bucket([]byte(BucketDeployments)).Put([]byte(deployment.EntityID), data)
+	})
+}
+
+// ListDeployments returns the recorded deployments of a network's servers
+// and nodes, keyed by entity ID.
+func (sm *StorageManager) ListDeployments(networkID string) (map[string]*Deployment, error) {
+	return sm.ListDeploymentsCtx(context.Background(), networkID)
+}
+
+// ListDeploymentsCtx is ListDeployments with a context.
+func (sm *StorageManager) ListDeploymentsCtx(ctx context.Context, networkID string) (map[string]*Deployment, error) {
+	deployments := make(map[string]*Deployment)
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketDeployments))
+		if bucket == nil {
+			// A read-only handle on a database not yet migrated.
+			return nil
+		}
+		return bucket.ForEach(checkCtx(ctx, func(k, v []byte) error {
+			deployment := &Deployment{}
+			if err := json.Unmarshal(v, deployment); err != nil {
+				return err
+			}
+			if deployment.NetworkID == networkID {
+				deployments[string(k)] = deployment
+			}
+			return nil
+		}))
+	})
+
+	return deployments, err
+}
+
+// deleteDeployment removes the deployment record of an entity within tx.
+func deleteDeployment(tx *bbolt.Tx, entityID []byte) error {
+	return tx.Bucket([]byte(BucketDeployments)).Delete(entityID)
+}
+
+// ========== Revision Operations ==========
+
+// NetworkRevision returns the revision of a network: a counter every change
+// to the network, its servers or its nodes increments, in the transaction
+// making the change. Saving config versions, deployments and IP pool state
+// leaves it alone. A network that has not changed since the counter was
+// introduced, or does not exist, is at revision 0.
+func (sm *StorageManager) NetworkRevision(networkID string) (uint64, error) {
+	return sm.NetworkRevisionCtx(context.Background(), networkID)
+}
+
+// NetworkRevisionCtx is NetworkRevision with a context.
+func (sm *StorageManager) NetworkRevisionCtx(ctx context.Context, networkID string) (uint64, error) {
+	var revision uint64
+
+	err := sm.viewCtx(ctx, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketRevisions))
+		if bucket == nil {
+			// A read-only handle on a database not yet migrated.
+			return nil
+		}
+		if data := bucket.Get([]byte(networkID)); len(data) == 8 {
+			revision = binary.BigEndian.Uint64(data)
+		}
+		return nil
+	})
+
+	return revision, err
+}
+
+// bumpRevision increments the revision of a network within tx.
+func bumpRevision(tx *bbolt.Tx, networkID string) error {
+	bucket := tx.Bucket([]byte(BucketRevisions))
+	var revision uint64
+	if data := bucket.Get([]byte(networkID)); len(data) == 8 {
+		revision = binary.BigEndian.Uint64(data)
+	}
+	return bucket.Put([]byte(networkID), binary.BigEndian.AppendUint64(nil, revision+1))
+}
+
+// deleteRevision removes the revision of a network within tx.
+func deleteRevision(tx *bbolt.Tx, networkID string) error {
+	return tx.Bucket([]byte(BucketRevisions)).Delete([]byte(networkID))
+}
+
+// nextConfigVersion allots the next config version number of a network
+// within tx from its sequence in BucketSequences. Numbers are never reused,
+// so a version number names one saved version even if versions are removed
+// later; the sequence is kept ahead of the version index in case the index
+// holds versions written without it.
+func nextConfigVersion(tx *bbolt.Tx, networkID string) (int, error) {
+	bucket := tx.Bucket([]byte(BucketSequences))
+	var last uint64
+	if data := bucket.Get([]byte(networkID)); len(data) == 8 {
+		last = binary.BigEndian.Uint64(data)
+	}
+	prefix := []byte(networkID + ":")
+	if k, _ := lastWithPrefix(tx.Bucket([]byte(BucketConfigsByVer)), prefix); k != nil {
+		indexed, err := strconv.ParseUint(string(k[len(prefix):]), 10, 64)
 		if err != nil {
-			return fmt.Errorf("failed to marshal IP pool state: %w", err)
+			return 0, fmt.Errorf("invalid config version index key %q: %w", k, err)
 		}
-		return bucket.Put([]byte(networkID), data)
+		last = max(last, indexed)
+	}
+	if err := bucket.Put([]byte(networkID), binary.BigEndian.AppendUint64(nil, last+1)); err != nil {
+		return 0, fmt.Errorf("failed to save config version sequence: %w", err)
+	}
+	return int(last + 1), nil
+}
+
+// deleteSequence removes the config version sequence of a network within tx.
+func deleteSequence(tx *bbolt.Tx, networkID string) error {
+	return tx.Bucket([]byte(BucketSequences)).Delete([]byte(networkID))
+}
+
+// ========== IP Pool Operations ==========
+
+// SaveIPPoolState persists IP pool state to the database. state.Revision is
+// set to the revision saved.
+func (sm *StorageManager) SaveIPPoolState(networkID string, state *util.IPPoolState) error {
+	return sm.update(func(tx *bbolt.Tx) error {
+		return sm.putIPPoolState(tx, networkID, state)
 	})
 }
 
+// putIPPoolState writes a network's IP pool state within tx, one revision
+// past the state it replaces, and sets state.Revision to match. A nil state,
+// from a network whose CIDR cannot hold a pool, leaves the saved one as it is.
+func (sm *StorageManager) putIPPoolState(tx *bbolt.Tx, networkID string, state *util.IPPoolState) error {
+	if state != nil && sm.poolWriteFault != nil {
+		if err := sm.poolWriteFault(); err != nil {
+			return err
+		}
+	}
+	return writeIPPoolState(tx, networkID, state)
+}
+
+// writeIPPoolState is putIPPoolState without a StorageManager, for repairs
+// that run on a bare transaction.
+func writeIPPoolState(tx *bbolt.Tx, networkID string, state *util.IPPoolState) error {
+	if state == nil {
+		return nil
+	}
+	bucket := tx.Bucket([]byte(BucketIPPools))
+	saved := &util.IPPoolState{}
+	if data := bucket.Get([]byte(networkID)); data != nil && json.Unmarshal(data, saved) == nil {
+		state.Revision = saved.Revision + 1
+	} else {
+		state.Revision = 1
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal IP pool state: %w", err)
+	}
+	return bucket.Put([]byte(networkID), data)
+}
+
 // GetIPPoolState retrieves IP pool state from the database
 func (sm *StorageManager) GetIPPoolState(networkID string) (*util.IPPoolState, error) {
 	var state *util.IPPoolState
-	err := sm.db.View(func(tx *bbolt.Tx) error {
+	err := sm.view(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(BucketIPPools))
 		data := bucket.Get([]byte(networkID))
 		if data == nil {
-			return fmt.Errorf("IP pool state not found for network %s", networkID)
+			return kindErrorf(ErrNotFound, "IP pool state not found for network %s", networkID)
 		}
 		state = &util.IPPoolState{}
 		return json.Unmarshal(data, state)
 	})
 	return state, err
 }
+
+// ========== Maintenance Operations ==========
+
+// indexKeyFunc derives the index key a primary record should be stored
+// under, so stale index entries can be told apart from live ones.
+type indexKeyFunc func(data []byte) (string, error)
+
+// staleIndexKeys returns the keys of every entry of index whose value does
+// not point at a record in primary, or whose record no longer maps back to
+// the entry's key.
+func staleIndexKeys(index, primary *bbolt.Bucket, keyOf indexKeyFunc) ([][]byte, error) {
+	var stale [][]byte
+	if err := index.ForEach(func(k, v []byte) error {
+		data := primary.Get(v)
+		if data != nil {
+			want, err := keyOf(data)
+			if err != nil {
+				return err
+			}
+			if want == string(k) {
+				return nil
+			}
+		}
+		stale = append(stale, append([]byte(nil), k...))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// pruneIndex removes the stale entries of index (see staleIndexKeys) and
+// returns how many it removed.
+func pruneIndex(index, primary *bbolt.Bucket, keyOf indexKeyFunc) (int, error) {
+	stale, err := staleIndexKeys(index, primary, keyOf)
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range stale {
+		if err := index.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+// indexChecks pairs each name and network index bucket with the primary
+// bucket its entries point into and the key a primary record belongs under.
+var indexChecks = []struct {
+	index, primary string
+	keyOf          indexKeyFunc
+}{
+	{BucketNetworksByName, BucketNetworks, func(data []byte) (string, error) {
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return "", err
+		}
+		return network.Name, nil
+	}},
+	{BucketServersByName, BucketServers, func(data []byte) (string, error) {
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return "", err
+		}
+		return server.NetworkID + ":" + server.Name, nil
+	}},
+	{BucketServersByNetwork, BucketServers, func(data []byte) (string, error) {
+		server := &Server{}
+		if err := json.Unmarshal(data, server); err != nil {
+			return "", err
+		}
+		return server.NetworkID + ":" + server.ID, nil
+	}},
+	{BucketNodesByName, BucketNodes, func(data []byte) (string, error) {
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return "", err
+		}
+		return node.NetworkID + ":" + node.Name, nil
+	}},
+	{BucketNodesByNetwork, BucketNodes, func(data []byte) (string, error) {
+		node := &Node{}
+		if err := json.Unmarshal(data, node); err != nil {
+			return "", err
+		}
+		return node.NetworkID + ":" + node.ID, nil
+	}},
+	{BucketConfigsByVer, BucketConfigs, func(data []byte) (string, error) {
+		config := &ConfigVersion{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return "", err
+		}
+		return config.NetworkID + ":" + padVersion(config.Version), nil
+	}},
+}
+
+// RepairIndexes scans the name, network and config version index buckets and
+// removes entries that no longer resolve to a matching record — for example
+// the server name-index entries an earlier DeleteServer left behind under the
+// wrong key. It returns the number of entries removed.
+func (sm *StorageManager) RepairIndexes() (int, error) {
+	removed := 0
+
+	err := sm.update(func(tx *bbolt.Tx) error {
+		for _, c := range indexChecks {
+			n, err := pruneIndex(tx.Bucket([]byte(c.index)), tx.Bucket([]byte(c.primary)), c.keyOf)
+			if err != nil {
+				return fmt.Errorf("failed to repair index %s: %w", c.index, err)
+			}
+			removed += n
+		}
+		return nil
+	})
+
+	return removed, err
+}
+
+// DatabaseInfo summarizes a database file for `db info`.
+type DatabaseInfo struct {
+	Path    string
+	Size    int64
+	Buckets map[string]int // bucket name -> record count
+}
+
+// Info reports the database path, size, and per-bucket record counts.
+func (sm *StorageManager) Info() (*DatabaseInfo, error) {
+	info := &DatabaseInfo{Path: sm.db.Path(), Buckets: make(map[string]int)}
+
+	err := sm.view(func(tx *bbolt.Tx) error {
+		info.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			info.Buckets[string(name)] = b.Stats().KeyN
+			return nil
+		})
+	})
+
+	return info, err
+}
+
+// Backup writes a consistent copy of the database to path while it stays in
+// use (a hot backup from a read transaction) and returns the bytes written.
+func (sm *StorageManager) Backup(path string) (int64, error) {
+	if abs, err := filepath.Abs(path); err == nil && abs == sm.db.Path() {
+		return 0, fmt.Errorf("backup path is the live database file")
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	var written int64
+	err = sm.view(func(tx *bbolt.Tx) error {
+		written, err = tx.WriteTo(f)
+		return err
+	})
+	if err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = f.Close()
+		return 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = f.Close()
+		return 0, fmt.Errorf("failed to sync backup: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close backup: %w", err)
+	}
+
+	return written, nil
+}
+
+// ValidateBackup checks that path is a bbolt database containing every
+// wedevctl bucket.
+func ValidateBackup(path string) error {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("%s is not a valid wedevctl database: %w", path, err)
+	}
+	//nolint:errcheck // Read-only handle; nothing to flush on close
+	defer func() { _ = db.Close() }()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		return checkBuckets(tx, path)
+	})
+}
+
+// checkBuckets verifies that every wedevctl bucket exists in the database.
+func checkBuckets(tx *bbolt.Tx, path string) error {
+	for _, name := range allBuckets {
+		if tx.Bucket([]byte(name)) == nil {
+			return fmt.Errorf("%s is not a valid wedevctl database: missing bucket %q", path, name)
+		}
+	}
+	return nil
+}
+
+// RestoreDatabase replaces the database at dbPath with the backup at
+// backupPath. The backup is validated first, and the restore refuses to run
+// while another process holds the database open. The backup is copied to a
+// temporary file beside dbPath and renamed into place, so the swap is atomic.
+func RestoreDatabase(dbPath, backupPath string) error {
+	if err := ValidateBackup(backupPath); err != nil {
+		return err
+	}
+
+	// Hold the database lock for the duration of the swap; failing to get
+	// it within the timeout means another wedevctl process is using it.
+	if _, err := os.Stat(dbPath); err == nil {
+		live, openErr := bbolt.Open(dbPath, 0o600, &bbolt.Options{Timeout: 500 * time.Millisecond})
+		if openErr != nil {
+			if errors.Is(openErr, bbolt.ErrTimeout) {
+				return lockedError(dbPath)
+			}
+			return fmt.Errorf("failed to open database: %w", openErr)
+		}
+		//nolint:errcheck // The file is replaced below; close only releases the lock
+		defer func() { _ = live.Close() }()
+	}
+
+	src, err := os.Open(filepath.Clean(backupPath))
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	//nolint:errcheck // Read-only handle
+	defer func() { _ = src.Close() }()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".wedevctl-restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	//nolint:errcheck // Removing a renamed temp file is a harmless no-op
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		//nolint:errcheck // Acceptable to ignore in error cleanup path
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync restored database: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close restored database: %w", err)
+	}
+
+	if err := os.Rename(tmpName, dbPath); err != nil {
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package wedev
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
+
+	"go.etcd.io/bbolt"
 )
 
 // TestDeleteServerCleansNameIndex covers the fix for the orphaned server
@@ -18,7 +22,7 @@ func TestDeleteServerCleansNameIndex(t *testing.T) {
 	if _, err := sm.CreateServer(net.ID, "srv", "vpn.example.com", 51820, "10.0.0.1", "priv", "pub"); err != nil {
 		t.Fatalf("CreateServer() error = %v", err)
 	}
-	if err := sm.DeleteServer(net.ID); err != nil {
+	if err := sm.DeleteServer(net.ID, "srv"); err != nil {
 		t.Fatalf("DeleteServer() error = %v", err)
 	}
 
@@ -67,12 +71,12 @@ func TestStorageNetworkScoping(t *testing.T) {
 
 	// Nodes — listing is scoped to a single network.
 	for i := 0; i < 3; i++ {
-		if _, err := sm.CreateNode(netA.ID, fmt.Sprintf("a%d", i), "", 51820, fmt.Sprintf("10.0.0.%d", i+2), NodeTypeRoute, "p", "p"); err != nil {
+		if _, err := sm.CreateNode(netA.ID, fmt.Sprintf("a%d", i), "", 51820, fmt.Sprintf("10.0.0.%d", i+2), NodeTypeRoute, "p", fmt.Sprintf("a%d", i)); err != nil {
 			t.Fatalf("CreateNode(A,%d) error = %v", i, err)
 		}
 	}
 	for i := 0; i < 2; i++ {
-		if _, err := sm.CreateNode(netB.ID, fmt.Sprintf("b%d", i), "", 51820, fmt.Sprintf("10.1.0.%d", i+2), NodeTypeRoute, "p", "p"); err != nil {
+		if _, err := sm.CreateNode(netB.ID, fmt.Sprintf("b%d", i), "", 51820, fmt.Sprintf("10.1.0.%d", i+2), NodeTypeRoute, "p", fmt.Sprintf("b%d", i)); err != nil {
 			t.Fatalf("CreateNode(B,%d) error = %v", i, err)
 		}
 	}
@@ -138,3 +142,104 @@ func TestStorageNetworkScoping(t *testing.T) {
 		t.Errorf("netA configs len = %d (err %v) after DeleteNetwork; want 0", len(vs), err)
 	}
 }
+
+// TestRepairIndexes verifies RepairIndexes removes orphaned index entries —
+// including the bare-name server entry the old DeleteServer bug left behind —
+// while leaving every live entry intact.
+func TestRepairIndexes(t *testing.T) {
+	_, sm := newTestManager(t)
+
+	net, err := sm.CreateNetwork("repnet", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	if _, err := sm.CreateServer(net.ID, "srv", "vpn.example.com", 51820, "10.0.0.1", "p", "p"); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := sm.CreateNode(net.ID, "n1", "", 51820, "10.0.0.2", NodeTypeRoute, "p", "n1"); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	// Nothing to repair in a consistent database.
+	if removed, err := sm.RepairIndexes(); err != nil || removed != 0 {
+		t.Fatalf("RepairIndexes() on clean db = %d (err %v); want 0", removed, err)
+	}
+
+	// Plant orphans: a stale composite server entry pointing at a deleted
+	// server, a bare-name entry from the old bug, and a dangling node entry.
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		serversByName := tx.Bucket([]byte(BucketServersByName))
+		if err := serversByName.Put([]byte(net.ID+":old"), []byte("gone")); err != nil {
+			return err
+		}
+		if err := serversByName.Put([]byte("srv"), []byte("gone")); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BucketNodesByName)).Put([]byte(net.ID+":ghost"), []byte("gone"))
+	}); err != nil {
+		t.Fatalf("seeding orphans error = %v", err)
+	}
+
+	removed, err := sm.RepairIndexes()
+	if err != nil {
+		t.Fatalf("RepairIndexes() error = %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("RepairIndexes() removed = %d; want 3", removed)
+	}
+
+	// Live records remain reachable, and the freed name is reusable.
+	if _, err := sm.GetServerByName(net.ID, "srv"); err != nil {
+		t.Errorf("GetServerByName(srv) after repair error = %v", err)
+	}
+	if _, err := sm.GetNodeByName(net.ID, "n1"); err != nil {
+		t.Errorf("GetNodeByName(n1) after repair error = %v", err)
+	}
+	if _, err := sm.CreateNode(net.ID, "ghost", "", 51820, "10.0.0.3", NodeTypeRoute, "p", "ghost"); err != nil {
+		t.Errorf("CreateNode(ghost) after repair error = %v", err)
+	}
+}
+
+// TestPruneIndex verifies pruneIndex drops an entry whose record still
+// exists but is indexed under another key, as a rename that missed the index
+// leaves, and keeps the entry under the record's current key.
+func TestPruneIndex(t *testing.T) {
+	_, sm := newTestManager(t)
+
+	net, err := sm.CreateNetwork("prunenet", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	node, err := sm.CreateNode(net.ID, "n1", "", 51820, "10.0.0.2", NodeTypeRoute, "p", "n1")
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	keyOf := func(data []byte) (string, error) {
+		n := &Node{}
+		if err := json.Unmarshal(data, n); err != nil {
+			return "", err
+		}
+		return n.NetworkID + ":" + n.Name, nil
+	}
+	var removed int
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(BucketNodesByName))
+		if err := index.Put([]byte(net.ID+":oldname"), []byte(node.ID)); err != nil {
+			return err
+		}
+		removed, err = pruneIndex(index, tx.Bucket([]byte(BucketNodes)), keyOf)
+		return err
+	}); err != nil {
+		t.Fatalf("pruneIndex() error = %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("pruneIndex() removed = %d; want 1", removed)
+	}
+	if _, err := sm.GetNodeByName(net.ID, "n1"); err != nil {
+		t.Errorf("GetNodeByName(n1) after prune error = %v", err)
+	}
+	if _, err := sm.GetNodeByName(net.ID, "oldname"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetNodeByName(oldname) after prune error = %v; want ErrNotFound", err)
+	}
+}
@@ -1,10 +1,16 @@
 package wedev
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/wedevctl/util"
 	"go.etcd.io/bbolt"
 )
 
@@ -64,7 +70,7 @@ func TestGetNetworkByName(t *testing.T) {
 
 	// Try to get non-existent network
 	_, err = sm.GetNetworkByName("nonexistent")
-	if err == nil {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("GetNetworkByName() should return error for non-existent network")
 	}
 }
@@ -104,6 +110,60 @@ func TestListNetworks(t *testing.T) {
 	}
 }
 
+func TestRenameNetwork(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer sm.Close()
+
+	net, _ := sm.CreateNetwork("oldnet", "10.0.0.0/24")
+	sm.CreateNetwork("taken", "10.1.0.0/24")
+	sm.CreateServer(net.ID, "server1", "vpn.example.com", 51820, "10.0.0.1", "pk", "pub")
+	sm.CreateNode(net.ID, "node1", "192.168.1.1", 51821, "10.0.0.2", NodeTypePeer, "pk", "pub-node1")
+
+	// Renaming onto an existing name must fail
+	if _, err := sm.RenameNetwork("oldnet", "taken"); err == nil {
+		t.Errorf("RenameNetwork() onto an existing name should fail")
+	}
+	// Renaming a missing network must fail
+	if _, err := sm.RenameNetwork("missing", "other"); err == nil {
+		t.Errorf("RenameNetwork() of a missing network should fail")
+	}
+
+	renamed, err := sm.RenameNetwork("oldnet", "newnet")
+	if err != nil {
+		t.Fatalf("RenameNetwork() error = %v", err)
+	}
+	if renamed.ID != net.ID || renamed.Name != "newnet" {
+		t.Errorf("RenameNetwork() = %+v, want ID %s and name newnet", renamed, net.ID)
+	}
+
+	// Old name is gone, new name resolves to the same network
+	if _, err := sm.GetNetworkByName("oldnet"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetNetworkByName(oldnet) should fail after rename")
+	}
+	got, err := sm.GetNetworkByName("newnet")
+	if err != nil || got.ID != net.ID {
+		t.Errorf("GetNetworkByName(newnet) = %v (err %v), want ID %s", got, err, net.ID)
+	}
+
+	// Server and nodes key on network ID and are untouched
+	if _, err := sm.GetServerByNetworkID(net.ID); err != nil {
+		t.Errorf("server should survive rename: %v", err)
+	}
+	if _, err := sm.GetNodeByName(net.ID, "node1"); err != nil {
+		t.Errorf("node should survive rename: %v", err)
+	}
+
+	// Old name is immediately reusable
+	if _, err := sm.CreateNetwork("oldnet", "10.2.0.0/24"); err != nil {
+		t.Errorf("CreateNetwork(oldnet) after rename error = %v", err)
+	}
+}
+
 func TestDeleteNetwork_CascadeDelete(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -322,6 +382,78 @@ func TestDeleteNode_NameIndexCleanup(t *testing.T) {
 	}
 }
 
+func TestRenameNode_KeepsIdentity(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer sm.Close()
+
+	net, _ := sm.CreateNetwork("testnet", "10.0.0.0/24")
+	orig, _ := sm.CreateNode(net.ID, "node1", "192.168.1.1", 51821, "10.0.0.2", NodeTypePeer, "pk", "pub")
+	sm.CreateNode(net.ID, "node2", "192.168.1.2", 51822, "10.0.0.3", NodeTypePeer, "pk2", "pub2")
+
+	if _, err := sm.RenameNode(net.ID, "node1", "node2"); err == nil {
+		t.Errorf("RenameNode() onto an existing name should fail")
+	}
+	if _, err := sm.RenameNode(net.ID, "missing", "node3"); err == nil {
+		t.Errorf("RenameNode() of a missing node should fail")
+	}
+
+	renamed, err := sm.RenameNode(net.ID, "node1", "laptop")
+	if err != nil {
+		t.Fatalf("RenameNode() error = %v", err)
+	}
+	if renamed.ID != orig.ID || renamed.VirtualIP != orig.VirtualIP || renamed.PublicKey != orig.PublicKey {
+		t.Errorf("RenameNode() changed identity: got %+v, want ID/IP/key of %+v", renamed, orig)
+	}
+
+	if _, err := sm.GetNodeByName(net.ID, "node1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetNodeByName(node1) should fail after rename")
+	}
+	if got, err := sm.GetNodeByName(net.ID, "laptop"); err != nil || got.ID != orig.ID {
+		t.Errorf("GetNodeByName(laptop) = %v (err %v), want ID %s", got, err, orig.ID)
+	}
+	if nodes, _ := sm.ListNodesByNetworkID(net.ID); len(nodes) != 2 {
+		t.Errorf("ListNodesByNetworkID() len = %d after rename, want 2", len(nodes))
+	}
+}
+
+func TestRenameServer(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	defer sm.Close()
+
+	net, _ := sm.CreateNetwork("testnet", "10.0.0.0/24")
+	if _, err := sm.RenameServer(net.ID, "server1", "srv"); err == nil {
+		t.Errorf("RenameServer() without a server should fail")
+	}
+	orig, _ := sm.CreateServer(net.ID, "server1", "vpn.example.com", 51820, "10.0.0.1", "pk", "pub")
+
+	renamed, err := sm.RenameServer(net.ID, "server1", "gateway")
+	if err != nil {
+		t.Fatalf("RenameServer() error = %v", err)
+	}
+	if renamed.ID != orig.ID || renamed.Name != "gateway" || renamed.PublicKey != orig.PublicKey {
+		t.Errorf("RenameServer() = %+v, want ID %s named gateway with same key", renamed, orig.ID)
+	}
+	if _, err := sm.GetServerByName(net.ID, "server1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetServerByName(server1) should fail after rename")
+	}
+	if _, err := sm.GetServerByName(net.ID, "gateway"); err != nil {
+		t.Errorf("GetServerByName(gateway) error = %v", err)
+	}
+	if _, err := sm.RenameServer(net.ID, "gateway", "gateway"); err == nil {
+		t.Errorf("RenameServer() to its current name should fail")
+	}
+}
+
 func TestCreateServer(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -347,12 +479,12 @@ func TestCreateServer(t *testing.T) {
 	}{
 		{"create valid server", "server1", false},
 		{"create duplicate server", "server1", true},
-		{"create second server same network", "server2", true},
+		{"create second server same network", "server2", false},
 	}
 
 	for i, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := sm.CreateServer(net.ID, tt.srvName, "192.168.1.1", 51820, "10.0.0.1", "pk", "pub")
+			_, err := sm.CreateServer(net.ID, tt.srvName, "192.168.1.1", 51820, "10.0.0.1", "pk", "pub-"+tt.srvName)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateServer() iteration %d error = %v, wantErr %v", i, err, tt.wantErr)
 			}
@@ -397,8 +529,11 @@ func TestGetServerByNetworkID(t *testing.T) {
 		t.Fatalf("CreateNetwork(testnet2) error = %v", err)
 	}
 	_, err = sm.GetServerByNetworkID(net2.ID)
-	if err == nil {
-		t.Errorf("GetServerByNetworkID() should return error when no server exists")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetServerByNetworkID() error = %v, want ErrNotFound when no server exists", err)
+	}
+	if servers, err := sm.ListServersByNetworkID(net2.ID); err != nil || len(servers) != 0 {
+		t.Errorf("ListServersByNetworkID() = %v, %v; want no servers and no error", servers, err)
 	}
 }
 
@@ -421,12 +556,13 @@ func TestCreateNode(t *testing.T) {
 	}{
 		{"create peer node", "node1", NodeTypePeer, false},
 		{"create route node", "node2", NodeTypeRoute, false},
+		{"create client node", "node3", NodeTypeClient, false},
 		{"duplicate name", "node1", NodeTypePeer, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := sm.CreateNode(net.ID, tt.nodeName, "192.168.1.1", 51821, "10.0.0.2", tt.nodeType, "pk", "pub")
+			_, err := sm.CreateNode(net.ID, tt.nodeName, "192.168.1.1", 51821, "10.0.0.2", tt.nodeType, "pk", "pub-"+tt.nodeName)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateNode() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -582,6 +718,56 @@ func TestListConfigVersions(t *testing.T) {
 	}
 }
 
+func TestListConfigVersionsFiltered(t *testing.T) {
+	_, bolt := newTestManager(t)
+	for name, storage := range map[string]Storage{"bolt": bolt, "memory": NewMemoryStorage()} {
+		t.Run(name, func(t *testing.T) {
+			net, err := storage.CreateNetwork("testnet", "10.0.0.0/24")
+			if err != nil {
+				t.Fatalf("CreateNetwork() error = %v", err)
+			}
+			var saved []*ConfigVersion
+			for i := 1; i <= 4; i++ {
+				v, err := storage.SaveConfigVersion(net.ID, fmt.Sprintf("hash%d", i), map[string]string{"srv": fmt.Sprintf("config %d", i)})
+				if err != nil {
+					t.Fatalf("SaveConfigVersion() error = %v", err)
+				}
+				saved = append(saved, v)
+			}
+
+			tests := []struct {
+				name   string
+				filter ConfigVersionFilter
+				want   []int
+			}{
+				{"all", ConfigVersionFilter{}, []int{1, 2, 3, 4}},
+				{"since", ConfigVersionFilter{Since: saved[2].CreatedAt}, []int{3, 4}},
+				{"until", ConfigVersionFilter{Until: saved[1].CreatedAt}, []int{1, 2}},
+				{"range", ConfigVersionFilter{Since: saved[1].CreatedAt, Until: saved[2].CreatedAt}, []int{2, 3}},
+				{"last", ConfigVersionFilter{Last: 2}, []int{3, 4}},
+				{"last of range", ConfigVersionFilter{Until: saved[2].CreatedAt, Last: 1}, []int{3}},
+				{"none", ConfigVersionFilter{Since: saved[3].CreatedAt.Add(time.Hour)}, nil},
+			}
+			for _, tt := range tests {
+				versions, total, err := storage.ListConfigVersionsFilteredCtx(context.Background(), net.ID, tt.filter)
+				if err != nil || total != 4 {
+					t.Fatalf("%s: ListConfigVersionsFilteredCtx() total = %d, error = %v", tt.name, total, err)
+				}
+				var got []int
+				for _, v := range versions {
+					got = append(got, v.Version)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s: versions = %v, want %v", tt.name, got, tt.want)
+				}
+				if len(versions) > 0 && versions[len(versions)-1].Configs["srv"] == "" {
+					t.Errorf("%s: selected versions lack their configs", tt.name)
+				}
+			}
+		})
+	}
+}
+
 func TestConfigVersionStoresContent(t *testing.T) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -945,3 +1131,225 @@ func TestNetworkDeletionCleansUpNameIndices(t *testing.T) {
 		t.Errorf("Database view error: %v", err)
 	}
 }
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	backupPath := filepath.Join(dir, "backup.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+
+	sm.CreateNetwork("keepnet", "10.0.0.0/24")
+	if _, err := sm.Backup(dbPath); err == nil {
+		t.Errorf("Backup() onto the live database should fail")
+	}
+	if n, err := sm.Backup(backupPath); err != nil || n == 0 {
+		t.Fatalf("Backup() = %d, %v", n, err)
+	}
+	if err := ValidateBackup(backupPath); err != nil {
+		t.Errorf("ValidateBackup() error = %v", err)
+	}
+
+	// Changes after the backup are rolled back by the restore.
+	sm.CreateNetwork("laternet", "10.1.0.0/24")
+
+	// The restore refuses while the database is held open.
+	if err := RestoreDatabase(dbPath, backupPath); err == nil {
+		t.Errorf("RestoreDatabase() should fail while the database is in use")
+	}
+	sm.Close()
+
+	if err := RestoreDatabase(dbPath, backupPath); err != nil {
+		t.Fatalf("RestoreDatabase() error = %v", err)
+	}
+	sm, err = NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() after restore error = %v", err)
+	}
+	defer sm.Close()
+	if _, err := sm.GetNetworkByName("keepnet"); err != nil {
+		t.Errorf("keepnet should exist after restore: %v", err)
+	}
+	if _, err := sm.GetNetworkByName("laternet"); err == nil {
+		t.Errorf("laternet should be gone after restore")
+	}
+
+	info, err := sm.Info()
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	if info.Path != dbPath || info.Size == 0 || info.Buckets[BucketNetworks] != 1 {
+		t.Errorf("Info() = %+v, want path %s with 1 network", info, dbPath)
+	}
+}
+
+func TestRestoreDatabase_RejectsInvalidBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+
+	junk := filepath.Join(dir, "junk.db")
+	os.WriteFile(junk, []byte("not a database"), 0o600)
+	if err := RestoreDatabase(dbPath, junk); err == nil {
+		t.Errorf("RestoreDatabase() should reject a non-bbolt file")
+	}
+
+	// A bbolt file without the wedevctl buckets is rejected too.
+	foreign := filepath.Join(dir, "foreign.db")
+	db, err := bbolt.Open(foreign, 0o600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open() error = %v", err)
+	}
+	db.Close()
+	if err := ValidateBackup(foreign); err == nil {
+		t.Errorf("ValidateBackup() should reject a database missing wedevctl buckets")
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		t.Errorf("a rejected restore must not create the database")
+	}
+}
+
+func TestOpenStorageReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	if _, err := OpenStorageReadOnly(dbPath); err == nil {
+		t.Error("OpenStorageReadOnly() on a missing file should fail")
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("OpenStorageReadOnly() created the database (stat err %v)", err)
+	}
+
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	if _, err := sm.CreateNetwork("ro", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	sm.Close()
+
+	ro, err := OpenStorageReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer ro.Close()
+	if networks, err := ro.ListNetworks(); err != nil || len(networks) != 1 {
+		t.Errorf("ListNetworks() = %d networks (err %v), want 1", len(networks), err)
+	}
+}
+
+func TestNetworkRevision(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sm, err := NewStorageManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+
+	// revision returns the revision of a network, failing the test on error.
+	revision := func(networkID string) uint64 {
+		t.Helper()
+		got, err := sm.NetworkRevision(networkID)
+		if err != nil {
+			t.Fatalf("NetworkRevision() error = %v", err)
+		}
+		return got
+	}
+
+	if got := revision("missing"); got != 0 {
+		t.Errorf("NetworkRevision(missing) = %d, want 0", got)
+	}
+	network, err := sm.CreateNetwork("net", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	other, err := sm.CreateNetwork("other", "10.1.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork(other) error = %v", err)
+	}
+	if got := revision(network.ID); got != 1 {
+		t.Errorf("revision after CreateNetwork() = %d, want 1", got)
+	}
+
+	var server *Server
+	var node *Node
+	mutations := []struct {
+		name string
+		fn   func() error
+	}{
+		{"CreateServer", func() (err error) {
+			server, err = sm.CreateServer(network.ID, "srv", "vpn.example.com", 51820, "10.0.0.1", "priv", "pub-srv")
+			return err
+		}},
+		{"CreateNode", func() (err error) {
+			node, err = sm.CreateNode(network.ID, "a", "", 51820, "10.0.0.2", NodeTypeRoute, "priv", "pub-a")
+			return err
+		}},
+		{"UpdateNetworkLabels", func() error { return sm.UpdateNetworkLabels(network.ID, map[string]string{"env": "prod"}) }},
+		{"UpdateNetworkSettings", func() error { return sm.UpdateNetworkSettings(network.ID, map[string]string{SettingMTU: "1420"}) }},
+		{"UpdateNetwork", func() error {
+			_, err := sm.UpdateNetwork(network.ID, func(n *VirtualNetwork) error { n.putSetting(SettingDNS, "10.0.0.53"); return nil })
+			return err
+		}},
+		{"UpdateServer", func() error { return sm.UpdateServer(server.ID, "vpn2.example.com", 51820) }},
+		{"UpdateNode", func() error { return sm.UpdateNode(node.ID, "", 51821, NodeTypeRoute) }},
+		{"UpdateNodeLabels", func() error { return sm.UpdateNodeLabels(node.ID, map[string]string{"group": "ops"}) }},
+		{"RenameNode", func() error { _, err := sm.RenameNode(network.ID, "a", "b"); return err }},
+		{"RenameServer", func() error { _, err := sm.RenameServer(network.ID, "srv", "hub"); return err }},
+		{"RenameNetwork", func() error { _, err := sm.RenameNetwork("net", "renamed"); return err }},
+		{"DeleteNode", func() error { return sm.DeleteNode(network.ID, "b") }},
+		{"DeleteServer", func() error { return sm.DeleteServer(network.ID, "hub") }},
+	}
+	want := uint64(1)
+	for _, m := range mutations {
+		if err := m.fn(); err != nil {
+			t.Fatalf("%s() error = %v", m.name, err)
+		}
+		want++
+		if got := revision(network.ID); got != want {
+			t.Errorf("revision after %s() = %d, want %d", m.name, got, want)
+		}
+	}
+	if got := revision(other.ID); got != 1 {
+		t.Errorf("revision of the other network = %d, want 1", got)
+	}
+
+	// Failed changes, config versions, deployments and IP pool state leave
+	// the revision alone.
+	if _, err := sm.CreateNetwork("renamed", "10.2.0.0/24"); err == nil {
+		t.Fatal("CreateNetwork() with a taken name should fail")
+	}
+	if err := sm.DeleteNode(network.ID, "ghost"); err == nil {
+		t.Fatal("DeleteNode(ghost) should fail")
+	}
+	if _, err := sm.SaveConfigVersion(network.ID, "hash", map[string]string{"a": "config"}); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if err := sm.SaveDeployment(&Deployment{EntityID: "x", NetworkID: network.ID}); err != nil {
+		t.Fatalf("SaveDeployment() error = %v", err)
+	}
+	if err := sm.SaveIPPoolState(network.ID, &util.IPPoolState{NetworkCIDR: network.CIDR}); err != nil {
+		t.Fatalf("SaveIPPoolState() error = %v", err)
+	}
+	if got := revision(network.ID); got != want {
+		t.Errorf("revision after non-topology writes = %d, want %d", got, want)
+	}
+
+	// Deleting a network drops its revision rather than leaving it dangling.
+	if err := sm.DeleteNetwork("other"); err != nil {
+		t.Fatalf("DeleteNetwork() error = %v", err)
+	}
+	if got := revision(other.ID); got != 0 {
+		t.Errorf("revision after DeleteNetwork() = %d, want 0", got)
+	}
+	sm.Close()
+
+	ro, err := OpenStorageReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenStorageReadOnly() error = %v", err)
+	}
+	defer ro.Close()
+	if got, err := ro.NetworkRevision(network.ID); err != nil || got != want {
+		t.Errorf("NetworkRevision() on a read-only handle = %d (err %v), want %d", got, err, want)
+	}
+}
@@ -0,0 +1,132 @@
+package wedev
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wedevctl/util"
+)
+
+// breakIPPoolWrites simulates a crash between writing a record and writing
+// the IP pool state by failing every pool write of sm. The returned function
+// makes pool writes succeed again.
+func breakIPPoolWrites(t *testing.T, sm *StorageManager) func() {
+	t.Helper()
+	sm.poolWriteFault = func() error { return errors.New("simulated crash before IP pool write") }
+	restore := func() { sm.poolWriteFault = nil }
+	t.Cleanup(restore)
+	return restore
+}
+
+// restartManager returns a fresh manager over sm, as a new process would get.
+func restartManager(t *testing.T, sm *StorageManager) *VirtualNetworkManager {
+	t.Helper()
+	vnm, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	return vnm
+}
+
+func TestCreateNode_PoolStateFailureLeavesNoNode(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("atomic", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("atomic", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+
+	restore := breakIPPoolWrites(t, sm)
+	if _, err := vnm.CreateNode("atomic", "b", "", 0, NodeTypeRoute); err == nil {
+		t.Fatal("CreateNode(b) with failing pool write should fail")
+	}
+	if _, err := sm.GetNodeByName(network.ID, "b"); err == nil {
+		t.Error("node b was written although its IP pool state was not")
+	}
+	restore()
+
+	// After a restart the saved pool agrees with the records: the next node
+	// gets a fresh address rather than one a node already holds.
+	c, err := restartManager(t, sm).CreateNode("atomic", "c", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(c) error = %v", err)
+	}
+	a, err := sm.GetNodeByName(network.ID, "a")
+	if err != nil {
+		t.Fatalf("GetNodeByName(a) error = %v", err)
+	}
+	if c.VirtualIP == a.VirtualIP {
+		t.Errorf("node c got %s, already held by node a", c.VirtualIP)
+	}
+}
+
+func TestCreateServer_PoolStateFailureLeavesNoServer(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("atomic", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("atomic", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+
+	restore := breakIPPoolWrites(t, sm)
+	if _, err := vnm.CreateServer("atomic", "srv", "vpn.example.com", 0); err == nil {
+		t.Fatal("CreateServer() with failing pool write should fail")
+	}
+	if _, err := sm.GetServerByNetworkID(network.ID); err == nil {
+		t.Error("server was written although its IP pool state was not")
+	}
+	restore()
+
+	if _, err := restartManager(t, sm).CreateServer("atomic", "srv", "vpn.example.com", 0); err != nil {
+		t.Errorf("CreateServer() after restore error = %v", err)
+	}
+}
+
+func TestDeleteNode_PoolStateFailureKeepsNode(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	network, err := vnm.CreateVirtualNetwork("atomic", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	a, err := vnm.CreateNode("atomic", "a", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+
+	restore := breakIPPoolWrites(t, sm)
+	if err := vnm.DeleteNode("atomic", "a"); err == nil {
+		t.Fatal("DeleteNode(a) with failing pool write should fail")
+	}
+	if _, err := sm.GetNodeByName(network.ID, "a"); err != nil {
+		t.Errorf("node a was deleted although its IP was not released: %v", err)
+	}
+	restore()
+
+	// The surviving node keeps its address: a restarted manager must not
+	// hand it out again.
+	b, err := restartManager(t, sm).CreateNode("atomic", "b", "", 0, NodeTypeRoute)
+	if err != nil {
+		t.Fatalf("CreateNode(b) error = %v", err)
+	}
+	if b.VirtualIP == a.VirtualIP {
+		t.Errorf("node b got %s, still held by node a", b.VirtualIP)
+	}
+
+	// Once the write succeeds, the delete and the release land together.
+	if err := vnm.DeleteNode("atomic", "a"); err != nil {
+		t.Fatalf("DeleteNode(a) error = %v", err)
+	}
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil {
+		t.Fatalf("GetIPPoolState() error = %v", err)
+	}
+	for _, ip := range state.Allocated {
+		if ip == a.VirtualIP {
+			t.Errorf("pool state still allocates %s after DeleteNode", ip)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package wedev
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/wedevctl/util"
+)
+
+// SystemdUnitName is the name of the service unit 'config generate
+// --systemd' writes for the wg-quick interface iface.
+func SystemdUnitName(iface string) string {
+	return "wireguard-" + iface + ".service"
+}
+
+// SystemdRestartDropIn is the drop-in, written to <unit>.d/restart.conf,
+// that restarts the interface when bringing it up fails, as it can while
+// the network or DNS is not ready yet at boot.
+const SystemdRestartDropIn = `[Service]
+Restart=on-failure
+RestartSec=5s
+`
+
+// SystemdUnitData is what the unit template is executed with.
+type SystemdUnitData struct {
+	Network   string
+	Entity    string
+	Interface string
+}
+
+// systemdUnitTemplate brings a wg-quick interface up and down, as the
+// wg-quick@.service unit shipped with wireguard-tools does, for hosts
+// without it and deploy scripts that want a unit per interface.
+var systemdUnitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{"unit": SystemdUnitName}).Parse(`# WireGuard interface {{.Interface}} of network {{.Network}} ({{.Entity}}), generated by wedevctl.
+# Install {{.Interface}}.conf as /etc/wireguard/{{.Interface}}.conf and this file in
+# /etc/systemd/system, then run 'systemctl enable --now {{.Interface | unit}}'.
+# Where wireguard-tools ships wg-quick@.service, 'systemctl enable --now
+# wg-quick@{{.Interface}}' does the same without this file.
+[Unit]
+Description=WireGuard interface {{.Interface}} (wedevctl network {{.Network}})
+Documentation=man:wg-quick(8)
+After=network-online.target nss-lookup.target
+Wants=network-online.target nss-lookup.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/wg-quick up {{.Interface}}
+ExecStop=/usr/bin/wg-quick down {{.Interface}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// SystemdUnit renders the service unit of the wg-quick interface iface,
+// which runs the config of entity in network.
+func SystemdUnit(networkName, entity, iface string) (string, error) {
+	var b strings.Builder
+	if err := systemdUnitTemplate.Execute(&b, SystemdUnitData{Network: networkName, Entity: entity, Interface: iface}); err != nil {
+		return "", fmt.Errorf("failed to render systemd unit: %w", err)
+	}
+	return b.String(), nil
+}
+
+// NetworkdFiles is a wg-quick config translated for systemd-networkd.
+type NetworkdFiles struct {
+	NetDev  string // <iface>.netdev: the interface, its private key and peers
+	Network string // <iface>.network: addresses, DNS, forwarding and routes
+	// Skipped are the PostUp and PostDown commands systemd-networkd has no
+	// equivalent for, with %i replaced by the interface name. They are
+	// also listed in a comment at the end of Network.
+	Skipped []string
+}
+
+// ipForwardCommand is the PostUp command server configs enable forwarding
+// with, and ipForwardOffCommand the PostDown command undoing it. Both map
+// to IPForward= in the .network file.
+const (
+	ipForwardCommand    = "sysctl -w net.ipv4.ip_forward=1"
+	ipForwardOffCommand = "sysctl -w net.ipv4.ip_forward=0"
+)
+
+// TranslateNetworkd translates a generated wg-quick config into the
+// .netdev and .network files creating the same interface, named iface,
+// under systemd-networkd. [Interface] keys become [NetDev], [WireGuard] and
+// [Network] keys, and each [Peer] a [WireGuardPeer] section. wg-quick adds a
+// route for every allowed IP; networkd does not, so allowed IPs outside the
+// interface's own subnets get a [Route] section.
+//
+// A config routing all traffic through the tunnel (an allowed IP of /0) is
+// an ErrValidation error: wg-quick sets that up with policy routing that
+// systemd-networkd does not replicate.
+func TranslateNetworkd(config, iface string) (*NetworkdFiles, error) {
+	sections, err := util.ParseWireGuardConfig(config)
+	if err != nil {
+		return nil, kindErrorf(ErrValidation, "failed to parse config: %v", err)
+	}
+	if len(sections) == 0 || sections[0].Name != "Interface" {
+		return nil, kindErrorf(ErrValidation, "config does not start with an [Interface] section")
+	}
+	lines := strings.Split(config, "\n")
+	header := ""
+	if strings.HasPrefix(config, configHeaderPrefix) {
+		header = lines[0] + "\n"
+	}
+
+	var (
+		netdev, wireguard, peers, network strings.Builder
+		addresses                         []netip.Prefix
+		routes                            []string
+		files                             = &NetworkdFiles{}
+	)
+	fmt.Fprintf(&netdev, "%s[NetDev]\nName=%s\nKind=wireguard\n", header, iface)
+	wireguard.WriteString("\n[WireGuard]\n")
+	fmt.Fprintf(&network, "%s[Match]\nName=%s\n\n[Network]\n", header, iface)
+
+	for _, entry := range sections[0].Entries {
+		switch strings.ToLower(entry.Key) {
+		case "privatekey":
+			fmt.Fprintf(&wireguard, "PrivateKey=%s\n", entry.Value)
+		case "listenport":
+			fmt.Fprintf(&wireguard, "ListenPort=%s\n", entry.Value)
+		case "fwmark":
+			fmt.Fprintf(&wireguard, "FirewallMark=%s\n", entry.Value)
+		case "mtu":
+			fmt.Fprintf(&netdev, "MTUBytes=%s\n", entry.Value)
+		case "address":
+			for _, value := range splitList(entry.Value) {
+				prefix, err := netip.ParsePrefix(value)
+				if err != nil {
+					return nil, kindErrorf(ErrValidation, "line %d: invalid Address %q", entry.Line, value)
+				}
+				addresses = append(addresses, prefix)
+				fmt.Fprintf(&network, "Address=%s\n", value)
+			}
+		case "dns":
+			for _, value := range splitList(entry.Value) {
+				if _, err := netip.ParseAddr(value); err == nil {
+					fmt.Fprintf(&network, "DNS=%s\n", value)
+				} else {
+					fmt.Fprintf(&network, "Domains=%s\n", value)
+				}
+			}
+		case "postup", "postdown":
+			switch entry.Value {
+			case ipForwardCommand:
+				network.WriteString("IPForward=ipv4\n")
+			case ipForwardOffCommand:
+			default:
+				files.Skipped = append(files.Skipped, entry.Key+" = "+strings.ReplaceAll(entry.Value, "%i", iface))
+			}
+		default:
+			return nil, kindErrorf(ErrValidation, "line %d: %s has no systemd-networkd equivalent", entry.Line, entry.Key)
+		}
+	}
+
+	for _, section := range sections[1:] {
+		if section.Name != "Peer" {
+			return nil, kindErrorf(ErrValidation, "line %d: unexpected [%s] section", section.Line, section.Name)
+		}
+		peers.WriteString("\n")
+		if above := section.Line - 2; above >= 0 && strings.HasPrefix(lines[above], "#") {
+			peers.WriteString(lines[above] + "\n")
+		}
+		peers.WriteString("[WireGuardPeer]\n")
+		for _, entry := range section.Entries {
+			switch strings.ToLower(entry.Key) {
+			case "publickey", "presharedkey", "endpoint", "persistentkeepalive":
+				fmt.Fprintf(&peers, "%s=%s\n", canonicalPeerKey(entry.Key), entry.Value)
+			case "allowedips":
+				for _, value := range splitList(entry.Value) {
+					prefix, err := netip.ParsePrefix(value)
+					if err != nil {
+						return nil, kindErrorf(ErrValidation, "line %d: invalid AllowedIPs entry %q", entry.Line, value)
+					}
+					if prefix.Bits() == 0 {
+						return nil, kindErrorf(ErrValidation, "line %d: AllowedIPs %s routes all traffic through the tunnel, which systemd-networkd cannot set up as wg-quick does; use wg-quick for this host", entry.Line, value)
+					}
+					fmt.Fprintf(&peers, "AllowedIPs=%s\n", value)
+					if !coveredBy(prefix, addresses) && !slices.Contains(routes, value) {
+						routes = append(routes, value)
+					}
+				}
+			default:
+				return nil, kindErrorf(ErrValidation, "line %d: peer key %s has no systemd-networkd equivalent", entry.Line, entry.Key)
+			}
+		}
+	}
+
+	for _, route := range routes {
+		fmt.Fprintf(&network, "\n[Route]\nDestination=%s\n", route)
+	}
+	if len(files.Skipped) > 0 {
+		network.WriteString("\n# systemd-networkd does not run these wg-quick commands; run them from a unit of their own:\n")
+		for _, command := range files.Skipped {
+			fmt.Fprintf(&network, "#   %s\n", command)
+		}
+	}
+
+	files.NetDev = netdev.String() + wireguard.String() + peers.String()
+	files.Network = network.String()
+	return files, nil
+}
+
+// canonicalPeerKey spells a [Peer] key as systemd-networkd does.
+func canonicalPeerKey(key string) string {
+	switch strings.ToLower(key) {
+	case "publickey":
+		return "PublicKey"
+	case "presharedkey":
+		return "PresharedKey"
+	case "endpoint":
+		return "Endpoint"
+	default:
+		return "PersistentKeepalive"
+	}
+}
+
+// coveredBy reports whether prefix lies within the subnet of one of the
+// interface addresses, which the kernel already routes.
+func coveredBy(prefix netip.Prefix, addresses []netip.Prefix) bool {
+	for _, address := range addresses {
+		if address.Bits() <= prefix.Bits() && address.Masked().Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
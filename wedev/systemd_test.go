@@ -0,0 +1,133 @@
+package wedev
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkGolden compares got with testdata/<name>, rewriting it with -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+	}
+}
+
+// networkdServerConfig and networkdNodeConfig are configs as the generator
+// writes them for a server with a route node behind it, and for that node.
+const (
+	networkdServerConfig = `# network: office, generated by wedevctl dev at 2026-01-18T10:30:00Z
+[Interface]
+PrivateKey = c2VydmVyLXByaXZhdGUta2V5LWZvci10ZXN0cy0xMjM=
+Address = 10.0.0.1/24
+ListenPort = 51820
+PostUp = sysctl -w net.ipv4.ip_forward=1
+PostUp = iptables -A FORWARD -i %i -d 192.168.10.0/24 -j ACCEPT; iptables -t nat -A POSTROUTING -o %i -d 192.168.10.0/24 -j MASQUERADE
+PostDown = sysctl -w net.ipv4.ip_forward=0
+PostDown = iptables -D FORWARD -i %i -d 192.168.10.0/24 -j ACCEPT; iptables -t nat -D POSTROUTING -o %i -d 192.168.10.0/24 -j MASQUERADE
+
+# branch (10.0.0.2)
+[Peer]
+PublicKey = YnJhbmNoLXB1YmxpYy1rZXktZm9yLXRlc3RzLTEyMzQ=
+AllowedIPs = 10.0.0.2/32, 192.168.10.0/24
+Endpoint = 203.0.113.5:51820
+
+# phone (10.0.0.3)
+[Peer]
+PublicKey = cGhvbmUtcHVibGljLWtleS1mb3ItdGVzdHMtMTIzNDU=
+AllowedIPs = 10.0.0.3/32
+`
+	networkdNodeConfig = `# network: office, generated by wedevctl dev at 2026-01-18T10:30:00Z
+[Interface]
+PrivateKey = YnJhbmNoLXByaXZhdGUta2V5LWZvci10ZXN0cy0xMjM=
+Address = 10.0.0.2/24
+ListenPort = 51820
+DNS = 10.0.0.1, office.example
+
+# hub (10.0.0.1)
+[Peer]
+PublicKey = aHViLXB1YmxpYy1rZXktZm9yLXRlc3RzLTEyMzQ1Njc=
+AllowedIPs = 10.0.0.0/24, 172.16.0.0/16
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = 25
+`
+)
+
+func TestSystemdUnit_Golden(t *testing.T) {
+	unit, err := SystemdUnit("office", "hub", "wg0")
+	if err != nil {
+		t.Fatalf("SystemdUnit() error = %v", err)
+	}
+	checkGolden(t, "systemd_unit.golden", unit)
+	if name := SystemdUnitName("wg0"); name != "wireguard-wg0.service" {
+		t.Errorf("SystemdUnitName() = %q", name)
+	}
+}
+
+func TestTranslateNetworkd_Golden(t *testing.T) {
+	for name, config := range map[string]string{"server": networkdServerConfig, "node": networkdNodeConfig} {
+		t.Run(name, func(t *testing.T) {
+			files, err := TranslateNetworkd(config, "office")
+			if err != nil {
+				t.Fatalf("TranslateNetworkd() error = %v", err)
+			}
+			checkGolden(t, "networkd_"+name+".netdev.golden", files.NetDev)
+			checkGolden(t, "networkd_"+name+".network.golden", files.Network)
+		})
+	}
+}
+
+func TestTranslateNetworkd_Skipped(t *testing.T) {
+	files, err := TranslateNetworkd(networkdServerConfig, "office")
+	if err != nil {
+		t.Fatalf("TranslateNetworkd() error = %v", err)
+	}
+	if len(files.Skipped) != 2 || !strings.HasPrefix(files.Skipped[0], "PostUp = iptables -A FORWARD -i office ") {
+		t.Errorf("Skipped = %q, want the two iptables commands with %%i replaced", files.Skipped)
+	}
+}
+
+func TestTranslateNetworkd_Unsupported(t *testing.T) {
+	tests := map[string]string{
+		"full tunnel":   strings.Replace(networkdNodeConfig, "10.0.0.0/24, 172.16.0.0/16", "0.0.0.0/0, ::/0", 1),
+		"table":         strings.Replace(networkdNodeConfig, "ListenPort = 51820", "Table = off", 1),
+		"no interface":  "[Peer]\nPublicKey = x\n",
+		"bad allowedip": strings.Replace(networkdNodeConfig, "172.16.0.0/16", "172.16.0.0", 1),
+	}
+	for name, config := range tests {
+		if _, err := TranslateNetworkd(config, "office"); !errors.Is(err, ErrValidation) {
+			t.Errorf("TranslateNetworkd(%s) error = %v, want ErrValidation", name, err)
+		}
+	}
+}
+
+func TestTranslateNetworkd_GeneratedConfigs(t *testing.T) {
+	vnm := newFirewallNetwork(t)
+	configs, _, err := NewWireGuardConfigGenerator(vnm.storage).GenerateConfigs("office", vnm.storage)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for name, config := range configs {
+		files, err := TranslateNetworkd(config, "office")
+		if err != nil {
+			t.Errorf("TranslateNetworkd(%s) error = %v", name, err)
+			continue
+		}
+		if strings.Count(files.NetDev, "[WireGuardPeer]") != strings.Count(config, "[Peer]") {
+			t.Errorf("TranslateNetworkd(%s) lost peers:\n%s", name, files.NetDev)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package wedev
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// ConfigTag is a name given to a config version of a network, such as
+// "prod" for the version rolled out to production. A tag names one version
+// at a time; a version can have several tags.
+type ConfigTag struct {
+	Tag     string `json:"tag"`
+	Version int    `json:"version"`
+}
+
+// ValidateConfigTag checks a tag name. Tags follow the rules for network
+// names, so they start with a letter and can never be mistaken for a
+// version number.
+func ValidateConfigTag(tag string) error {
+	if err := (&util.DefaultIPValidator{}).IsValidNetworkName(tag); err != nil {
+		return kindErrorf(ErrValidation, "invalid tag %q: a tag must start with a letter and contain only alphanumeric characters", tag)
+	}
+	return nil
+}
+
+// tagKey is the key of a tag in BucketTags.
+func tagKey(networkID, tag string) []byte {
+	return []byte(networkID + ":" + tag)
+}
+
+// SetConfigTag points tag at a saved config version of a network and
+// returns the version it named before, or 0 when it is new. A tag already
+// naming another version is moved only with force; otherwise the error is
+// ErrAlreadyExists.
+func (sm *StorageManager) SetConfigTag(networkID, tag string, version int, force bool) (int, error) {
+	previous := 0
+	err := sm.update(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(BucketConfigsByVer)).Get([]byte(networkID+":"+padVersion(version))) == nil {
+			return kindErrorf(ErrNotFound, "config version %d not found for network %q", version, networkID)
+		}
+		bucket := tx.Bucket([]byte(BucketTags))
+		if data := bucket.Get(tagKey(networkID, tag)); data != nil {
+			var err error
+			if previous, err = strconv.Atoi(string(data)); err != nil {
+				return fmt.Errorf("failed to decode tag %q: %w", tag, err)
+			}
+			if previous != version && !force {
+				return kindErrorf(ErrAlreadyExists, "tag %q already names version %d; use --force to move it", tag, previous)
+			}
+		}
+		return bucket.Put(tagKey(networkID, tag), []byte(strconv.Itoa(version)))
+	})
+	return previous, err
+}
+
+// DeleteConfigTag removes a tag of a network and returns the version it
+// named.
+func (sm *StorageManager) DeleteConfigTag(networkID, tag string) (int, error) {
+	version := 0
+	err := sm.update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketTags))
+		data := bucket.Get(tagKey(networkID, tag))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "tag %q not found", tag)
+		}
+		var err error
+		if version, err = strconv.Atoi(string(data)); err != nil {
+			return fmt.Errorf("failed to decode tag %q: %w", tag, err)
+		}
+		return bucket.Delete(tagKey(networkID, tag))
+	})
+	return version, err
+}
+
+// GetConfigTag returns the config version a tag of a network names.
+func (sm *StorageManager) GetConfigTag(networkID, tag string) (int, error) {
+	version := 0
+	err := sm.view(func(tx *bbolt.Tx) error {
+		var data []byte
+		if bucket := tx.Bucket([]byte(BucketTags)); bucket != nil {
+			// A read-only handle on a database not yet migrated has none.
+			data = bucket.Get(tagKey(networkID, tag))
+		}
+		if data == nil {
+			return kindErrorf(ErrNotFound, "tag %q not found", tag)
+		}
+		var err error
+		version, err = strconv.Atoi(string(data))
+		return err
+	})
+	return version, err
+}
+
+// ListConfigTags returns the tags of a network, sorted by name.
+func (sm *StorageManager) ListConfigTags(networkID string) ([]ConfigTag, error) {
+	tags := []ConfigTag{}
+	err := sm.view(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketTags))
+		if bucket == nil {
+			// A read-only handle on a database not yet migrated.
+			return nil
+		}
+		prefix := []byte(networkID + ":")
+		return forEachWithPrefix(bucket, prefix, func(k, v []byte) error {
+			version, err := strconv.Atoi(string(v))
+			if err != nil {
+				return fmt.Errorf("failed to decode tag %q: %w", k, err)
+			}
+			tags = append(tags, ConfigTag{Tag: string(bytes.TrimPrefix(k, prefix)), Version: version})
+			return nil
+		})
+	})
+	return tags, err
+}
+
+// deleteConfigTags removes the tags of a network within tx.
+func deleteConfigTags(tx *bbolt.Tx, networkID string) error {
+	bucket := tx.Bucket([]byte(BucketTags))
+	var keys [][]byte
+	if err := forEachWithPrefix(bucket, []byte(networkID+":"), func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TagConfigVersion names a saved config version of a network with tag and
+// returns the version the tag named before, or 0 when it is new. Moving a
+// tag from another version needs force.
+func (wcg *WireGuardConfigGenerator) TagConfigVersion(networkName string, version int, tag string, force bool) (int, error) {
+	if err := ValidateConfigTag(tag); err != nil {
+		return 0, err
+	}
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return 0, err
+	}
+	return wcg.storage.SetConfigTag(network.ID, tag, version, force)
+}
+
+// UntagConfigVersion removes a tag of a network and returns the version it
+// named.
+func (wcg *WireGuardConfigGenerator) UntagConfigVersion(networkName, tag string) (int, error) {
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return 0, err
+	}
+	return wcg.storage.DeleteConfigTag(network.ID, tag)
+}
+
+// ListConfigTags returns the tags of a network, sorted by name.
+func (wcg *WireGuardConfigGenerator) ListConfigTags(networkName string) ([]ConfigTag, error) {
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return wcg.storage.ListConfigTags(network.ID)
+}
+
+// ConfigTagsByVersion returns the tags of a network by the version they
+// name, each version's sorted by name.
+func (wcg *WireGuardConfigGenerator) ConfigTagsByVersion(networkName string) (map[int][]string, error) {
+	tags, err := wcg.ListConfigTags(networkName)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int][]string)
+	for _, t := range tags {
+		byVersion[t.Version] = append(byVersion[t.Version], t.Tag)
+	}
+	return byVersion, nil
+}
+
+// ResolveConfigVersion turns a version reference, as given on the command
+// line, into a version number: either the number itself or a tag of the
+// network.
+func (wcg *WireGuardConfigGenerator) ResolveConfigVersion(networkName, ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if version, err := strconv.Atoi(ref); err == nil {
+		return version, nil
+	}
+	if ValidateConfigTag(ref) != nil {
+		return 0, kindErrorf(ErrValidation, "invalid version %q: expected a version number or a tag", ref)
+	}
+	network, err := wcg.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return 0, err
+	}
+	version, err := wcg.storage.GetConfigTag(network.ID, ref)
+	if errors.Is(err, ErrNotFound) {
+		return 0, kindErrorf(ErrNotFound, "no config version of network %q is tagged %q", networkName, ref)
+	}
+	return version, err
+}
+
+// GetConfigByRef is GetConfig for a version number or tag; see
+// ResolveConfigVersion.
+func (wcg *WireGuardConfigGenerator) GetConfigByRef(networkName, ref string) (*ConfigVersion, error) {
+	version, err := wcg.ResolveConfigVersion(networkName, ref)
+	if err != nil {
+		return nil, err
+	}
+	return wcg.GetConfig(networkName, version)
+}
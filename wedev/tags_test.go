@@ -0,0 +1,152 @@
+package wedev
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// newTagTestNetwork creates network "office" on storage with two saved
+// config versions.
+func newTagTestNetwork(t *testing.T, storage Storage) *WireGuardConfigGenerator {
+	t.Helper()
+	vnm, err := NewVirtualNetworkManager(storage, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	gen := NewWireGuardConfigGenerator(storage)
+	if _, err := vnm.CreateVirtualNetwork("office", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("office", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"n1", "n2"} {
+		if _, err := vnm.CreateNode("office", name, "", 0, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode() error = %v", err)
+		}
+		if _, _, err := gen.SaveConfigVersion("office"); err != nil {
+			t.Fatalf("SaveConfigVersion() error = %v", err)
+		}
+	}
+	return gen
+}
+
+func TestConfigTags(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"bbolt": func(t *testing.T) Storage {
+			_, sm := newTestManager(t)
+			return sm
+		},
+		"memory": func(*testing.T) Storage { return NewMemoryStorage() },
+	}
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			gen := newTagTestNetwork(t, storage)
+
+			if previous, err := gen.TagConfigVersion("office", 1, "prod", false); err != nil || previous != 0 {
+				t.Fatalf("TagConfigVersion(prod) = %d, %v; want a new tag", previous, err)
+			}
+			if _, err := gen.TagConfigVersion("office", 2, "staging", false); err != nil {
+				t.Fatalf("TagConfigVersion(staging) error = %v", err)
+			}
+			if _, err := gen.TagConfigVersion("office", 1, "prod", false); err != nil {
+				t.Errorf("TagConfigVersion() to the same version error = %v, want none", err)
+			}
+			if _, err := gen.TagConfigVersion("office", 2, "prod", false); !errors.Is(err, ErrAlreadyExists) {
+				t.Errorf("TagConfigVersion() moving a tag error = %v, want ErrAlreadyExists", err)
+			}
+			if _, err := gen.TagConfigVersion("office", 3, "next", false); !errors.Is(err, ErrNotFound) {
+				t.Errorf("TagConfigVersion(missing version) error = %v, want ErrNotFound", err)
+			}
+			for _, bad := range []string{"2024-06-01", "1st", "", "a b"} {
+				if _, err := gen.TagConfigVersion("office", 1, bad, false); !errors.Is(err, ErrValidation) {
+					t.Errorf("TagConfigVersion(%q) error = %v, want ErrValidation", bad, err)
+				}
+			}
+
+			for ref, want := range map[string]int{"1": 1, "2": 2, "prod": 1, "staging": 2} {
+				if got, err := gen.ResolveConfigVersion("office", ref); err != nil || got != want {
+					t.Errorf("ResolveConfigVersion(%q) = %d, %v; want %d", ref, got, err, want)
+				}
+			}
+			if _, err := gen.ResolveConfigVersion("office", "qa"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("ResolveConfigVersion(untagged) error = %v, want ErrNotFound", err)
+			}
+			if _, err := gen.ResolveConfigVersion("office", "v-1"); !errors.Is(err, ErrValidation) {
+				t.Errorf("ResolveConfigVersion(v-1) error = %v, want ErrValidation", err)
+			}
+			if config, err := gen.GetConfigByRef("office", "staging"); err != nil || config.Version != 2 {
+				t.Errorf("GetConfigByRef(staging) = %v, %v; want version 2", config, err)
+			}
+
+			if previous, err := gen.TagConfigVersion("office", 2, "prod", true); err != nil || previous != 1 {
+				t.Errorf("TagConfigVersion(force) = %d, %v; want the tag moved from 1", previous, err)
+			}
+			byVersion, err := gen.ConfigTagsByVersion("office")
+			if want := map[int][]string{2: {"prod", "staging"}}; err != nil || !reflect.DeepEqual(byVersion, want) {
+				t.Errorf("ConfigTagsByVersion() = %v, %v; want %v", byVersion, err, want)
+			}
+
+			if version, err := gen.UntagConfigVersion("office", "staging"); err != nil || version != 2 {
+				t.Errorf("UntagConfigVersion() = %d, %v; want 2", version, err)
+			}
+			if _, err := gen.UntagConfigVersion("office", "staging"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UntagConfigVersion() twice error = %v, want ErrNotFound", err)
+			}
+			tags, err := gen.ListConfigTags("office")
+			if want := []ConfigTag{{Tag: "prod", Version: 2}}; err != nil || !reflect.DeepEqual(tags, want) {
+				t.Errorf("ListConfigTags() = %v, %v; want %v", tags, err, want)
+			}
+
+			network, err := storage.GetNetworkByName("office")
+			if err != nil {
+				t.Fatalf("GetNetworkByName() error = %v", err)
+			}
+			if err := storage.DeleteNetwork("office"); err != nil {
+				t.Fatalf("DeleteNetwork() error = %v", err)
+			}
+			if tags, _ := storage.ListConfigTags(network.ID); len(tags) != 0 {
+				t.Errorf("tags after network delete = %v, want none", tags)
+			}
+		})
+	}
+}
+
+func TestCheckIntegrity_DanglingTag(t *testing.T) {
+	sm, err := NewStorageManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewStorageManager() error = %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	gen := newTagTestNetwork(t, sm)
+	if _, err := gen.TagConfigVersion("office", 1, "prod", false); err != nil {
+		t.Fatalf("TagConfigVersion() error = %v", err)
+	}
+	network, err := sm.GetNetworkByName("office")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BucketTags)).Put(tagKey(network.ID, "gone"), []byte("9"))
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	report, err := sm.FixIntegrity()
+	if err != nil {
+		t.Fatalf("FixIntegrity() error = %v", err)
+	}
+	want := []IntegrityRecord{{Bucket: BucketTags, Key: network.ID + ":gone", NetworkID: network.ID}}
+	if !reflect.DeepEqual(report.DanglingReferences, want) {
+		t.Errorf("DanglingReferences = %+v, want %+v", report.DanglingReferences, want)
+	}
+	if tags, _ := sm.ListConfigTags(network.ID); !reflect.DeepEqual(tags, []ConfigTag{{Tag: "prod", Version: 1}}) {
+		t.Errorf("tags after fix = %v, want only prod", tags)
+	}
+}
@@ -0,0 +1,265 @@
+package wedev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
+)
+
+// ValidationSeverity says whether a ValidationFinding breaks configs or
+// only looks suspicious.
+type ValidationSeverity string
+
+const (
+	// ValidationError is a finding that produces broken configs.
+	ValidationError ValidationSeverity = "error"
+	// ValidationWarning is a finding that may be intended, such as two
+	// nodes behind one NAT address sharing a port.
+	ValidationWarning ValidationSeverity = "warning"
+)
+
+// Validation rules reported in ValidationFinding.Rule.
+const (
+	RuleDuplicatePublicKey = "duplicate_public_key"
+	RuleDuplicateVirtualIP = "duplicate_virtual_ip"
+	RuleDuplicateEndpoint  = "duplicate_endpoint"
+	RuleInvalidCIDR        = "invalid_cidr"
+	RuleIPOutsideCIDR      = "ip_outside_cidr"
+	RulePeerWithoutAddress = "peer_without_address"
+	RuleUnknownServer      = "unknown_server"
+	RuleRoutedCIDR         = "routed_cidr"
+	RuleNoServer           = "no_server"
+)
+
+// ValidationFinding is one broken consistency rule. Entities are listed as
+// "server <name>" or "node <name>".
+type ValidationFinding struct {
+	Severity ValidationSeverity `json:"severity"`
+	Rule     string             `json:"rule"`
+	Entities []string           `json:"entities,omitempty"`
+	Message  string             `json:"message"`
+}
+
+// ValidationReport lists the findings of ValidateNetwork, errors first.
+type ValidationReport struct {
+	Network  string              `json:"network"`
+	Findings []ValidationFinding `json:"findings"`
+}
+
+// Errors returns the number of error findings.
+func (r *ValidationReport) Errors() int {
+	return r.count(ValidationError)
+}
+
+// Warnings returns the number of warning findings.
+func (r *ValidationReport) Warnings() int {
+	return r.count(ValidationWarning)
+}
+
+func (r *ValidationReport) count(severity ValidationSeverity) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidateNetwork checks a network's servers and nodes against the rules
+// configs depend on and reports every finding. Errors: a network CIDR that
+// cannot hold an IP pool, public keys or virtual IPs held more than once,
+// virtual IPs outside the network's CIDR, peer nodes without a public
+// address, nodes assigned to a server that does not exist, and routed CIDRs
+// on non-route nodes or overlapping the network or each other. Warnings: public endpoints (address:port) used more than
+// once, and a hub-spoke network without a server. It changes nothing.
+func (sm *StorageManager) ValidateNetwork(networkID string) (*ValidationReport, error) {
+	var report *ValidationReport
+	err := sm.view(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(BucketNetworks)).Get([]byte(networkID))
+		if data == nil {
+			return kindErrorf(ErrNotFound, "network %q not found", networkID)
+		}
+		network := &VirtualNetwork{}
+		if err := json.Unmarshal(data, network); err != nil {
+			return err
+		}
+		servers, err := listServers(tx, networkID)
+		if err != nil {
+			return err
+		}
+		nodes, err := listNodes(context.Background(), tx, networkID)
+		if err != nil {
+			return err
+		}
+		report = validateNetwork(network, servers, nodes)
+		return nil
+	})
+	return report, err
+}
+
+// validateNetwork does the work of ValidateNetwork on records already read.
+func validateNetwork(network *VirtualNetwork, servers []*Server, nodes []*Node) *ValidationReport {
+	report := &ValidationReport{Network: network.Name, Findings: []ValidationFinding{}}
+	add := func(severity ValidationSeverity, rule string, entities []string, format string, args ...any) {
+		report.Findings = append(report.Findings, ValidationFinding{
+			Severity: severity, Rule: rule, Entities: entities, Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	keys := map[string][]string{}
+	ips := map[string][]string{}
+	endpoints := map[string][]string{}
+	var order []string // first-seen order of keys, IPs and endpoints
+	record := func(m map[string][]string, key, entity string) {
+		if _, ok := m[key]; !ok {
+			order = append(order, key)
+		}
+		m[key] = append(m[key], entity)
+	}
+
+	// Addresses are not checked against a CIDR that is itself invalid.
+	prefix, prefixErr := netip.ParsePrefix(network.CIDR)
+	if err := checkNetworkCIDR(network); err != nil {
+		add(ValidationError, RuleInvalidCIDR, nil, "%v", err)
+		prefixErr = err
+	}
+	checkIP := func(entity, ip string) {
+		if prefixErr != nil {
+			return
+		}
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !prefix.Contains(addr) {
+			add(ValidationError, RuleIPOutsideCIDR, []string{entity}, "%s has virtual IP %s outside %s", entity, ip, network.CIDR)
+		}
+	}
+
+	serverIDs := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		entity := "server " + server.Name
+		serverIDs[server.ID] = true
+		record(keys, server.PublicKey, entity)
+		record(ips, server.VirtualIP, entity)
+		if server.PublicAddress != "" {
+			record(endpoints, util.FormatEndpoint(server.PublicAddress, server.Port), entity)
+		}
+		checkIP(entity, server.VirtualIP)
+	}
+	if len(servers) == 0 && network.NeedsServer() {
+		add(ValidationWarning, RuleNoServer, nil, "network has no server, so no configs can be generated")
+	}
+
+	var routes []netip.Prefix
+	routeOwners := map[netip.Prefix]string{}
+	for _, node := range nodes {
+		entity := "node " + node.Name
+		if node.PublicKey != "" {
+			record(keys, node.PublicKey, entity)
+		}
+		record(ips, node.VirtualIP, entity)
+		if node.PublicAddress != "" {
+			record(endpoints, util.FormatEndpoint(node.PublicAddress, node.Port), entity)
+		} else if node.Type == NodeTypePeer {
+			add(ValidationError, RulePeerWithoutAddress, []string{entity}, "%s is a peer node without a public address", entity)
+		}
+		checkIP(entity, node.VirtualIP)
+		if node.ServerID != "" && !serverIDs[node.ServerID] {
+			add(ValidationError, RuleUnknownServer, []string{entity}, "%s is assigned to a server that does not exist", entity)
+		}
+
+		if len(node.RoutedCIDRs) > 0 && node.Type != NodeTypeRoute {
+			add(ValidationError, RuleRoutedCIDR, []string{entity}, "%s routes %s but is not a route node", entity, strings.Join(node.RoutedCIDRs, ", "))
+		}
+		for _, cidr := range node.RoutedCIDRs {
+			route, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				add(ValidationError, RuleRoutedCIDR, []string{entity}, "%s routes invalid CIDR %q", entity, cidr)
+				continue
+			}
+			if prefixErr == nil && route.Overlaps(prefix) {
+				add(ValidationError, RuleRoutedCIDR, []string{entity}, "%s routes %s, which overlaps the network %s", entity, cidr, network.CIDR)
+			}
+			for _, other := range routes {
+				if route.Overlaps(other) && routeOwners[other] != entity {
+					add(ValidationError, RuleRoutedCIDR, []string{routeOwners[other], entity}, "%s routes %s, which overlaps %s routed by %s", entity, cidr, other, routeOwners[other])
+				}
+			}
+			routes = append(routes, route)
+			routeOwners[route] = entity
+		}
+	}
+
+	for _, key := range order {
+		if holders := keys[key]; len(holders) > 1 {
+			add(ValidationError, RuleDuplicatePublicKey, holders, "public key %s is used by %s", key, strings.Join(holders, ", "))
+		}
+		if holders := ips[key]; len(holders) > 1 {
+			add(ValidationError, RuleDuplicateVirtualIP, holders, "virtual IP %s is held by %s", key, strings.Join(holders, ", "))
+		}
+		if holders := endpoints[key]; len(holders) > 1 {
+			add(ValidationWarning, RuleDuplicateEndpoint, holders, "endpoint %s is used by %s", key, strings.Join(holders, ", "))
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity == ValidationError && report.Findings[j].Severity != ValidationError
+	})
+	return report
+}
+
+// checkPublicKeyUnique returns an error when another server or node of the
+// network (one whose ID is not id) already uses publicKey: WireGuard
+// identifies peers by public key, so a duplicate would make two peers
+// indistinguishable.
+func checkPublicKeyUnique(tx *bbolt.Tx, networkID, id, publicKey string) error {
+	if publicKey == "" {
+		return nil
+	}
+	// Only the fields compared are decoded; this runs on every create.
+	type keyHolder struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+	}
+	for _, kind := range []struct{ label, index, primary string }{
+		{"server", BucketServersByNetwork, BucketServers},
+		{"node", BucketNodesByNetwork, BucketNodes},
+	} {
+		primary := tx.Bucket([]byte(kind.primary))
+		err := forEachWithPrefix(tx.Bucket([]byte(kind.index)), []byte(networkID+":"), func(_, v []byte) error {
+			data := primary.Get(v)
+			if data == nil {
+				return nil
+			}
+			var holder keyHolder
+			if err := json.Unmarshal(data, &holder); err != nil {
+				return err
+			}
+			if holder.ID != id && holder.PublicKey == publicKey {
+				return kindErrorf(ErrAlreadyExists, "public key is already used by %s %q", kind.label, holder.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateNetwork is StorageManager.ValidateNetwork for a network given by
+// name.
+func (vnm *VirtualNetworkManager) ValidateNetwork(networkName string) (*ValidationReport, error) {
+	network, err := vnm.storage.GetNetworkByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return vnm.storage.ValidateNetwork(network.ID)
+}
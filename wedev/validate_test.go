@@ -0,0 +1,139 @@
+package wedev
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPublicKeyUniqueness checks that storage refuses a public key another
+// server or node of the network holds, on create and on key replacement,
+// while other networks may reuse it.
+func TestPublicKeyUniqueness(t *testing.T) {
+	_, sm := newTestManager(t)
+
+	net, err := sm.CreateNetwork("keys", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork() error = %v", err)
+	}
+	other, err := sm.CreateNetwork("other", "10.1.0.0/24")
+	if err != nil {
+		t.Fatalf("CreateNetwork(other) error = %v", err)
+	}
+	if _, err := sm.CreateServer(net.ID, "hub", "vpn.example.com", 51820, "10.0.0.1", "priv", "hub-key"); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	node, err := sm.CreateNode(net.ID, "n1", "", 51820, "10.0.0.2", NodeTypeRoute, "priv", "n1-key")
+	if err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	if _, err := sm.CreateNode(net.ID, "n2", "", 51820, "10.0.0.3", NodeTypeRoute, "priv", "hub-key"); err == nil || !strings.Contains(err.Error(), `server "hub"`) {
+		t.Errorf("CreateNode() with the server's key error = %v, want duplicate key error", err)
+	}
+	if _, err := sm.CreateServer(net.ID, "hub2", "vpn2.example.com", 51820, "10.0.0.4", "priv", "n1-key"); err == nil || !strings.Contains(err.Error(), `node "n1"`) {
+		t.Errorf("CreateServer() with a node's key error = %v, want duplicate key error", err)
+	}
+	if err := sm.UpdateNodeKeys(node.ID, "", "hub-key"); err == nil {
+		t.Error("UpdateNodeKeys() to the server's key should fail")
+	}
+	if err := sm.UpdateNodeKeys(node.ID, "priv2", "n1-key"); err != nil {
+		t.Errorf("UpdateNodeKeys() keeping its own public key error = %v", err)
+	}
+	if _, err := sm.CreateNode(other.ID, "n1", "", 51820, "10.1.0.2", NodeTypeRoute, "priv", "n1-key"); err != nil {
+		t.Errorf("CreateNode() in another network error = %v", err)
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	vnm, sm := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("valid", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	report, err := vnm.ValidateNetwork("valid")
+	if err != nil {
+		t.Fatalf("ValidateNetwork() error = %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Rule != RuleNoServer || report.Warnings() != 1 {
+		t.Errorf("findings without a server = %+v, want one no_server warning", report.Findings)
+	}
+
+	if _, err := vnm.CreateServer("valid", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("valid", "a", "203.0.113.1", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(a) error = %v", err)
+	}
+	if _, err := vnm.CreateRouteNode("valid", "office", "", 51821, []string{"192.168.10.0/24"}); err != nil {
+		t.Fatalf("CreateRouteNode() error = %v", err)
+	}
+	if report, err = vnm.ValidateNetwork("valid"); err != nil || len(report.Findings) != 0 {
+		t.Errorf("ValidateNetwork() = %+v, %v; want no findings", report, err)
+	}
+
+	// A shared endpoint is allowed on request, but reported.
+	if _, err := vnm.CreateNodeWithOptions("valid", "b", "203.0.113.1", 51821, NodeTypePeer, NodeOptions{AllowDuplicateEndpoint: true}); err != nil {
+		t.Fatalf("CreateNode(b) error = %v", err)
+	}
+	report, err = vnm.ValidateNetwork("valid")
+	if err != nil {
+		t.Fatalf("ValidateNetwork() error = %v", err)
+	}
+	if report.Errors() != 0 || report.Warnings() != 1 || report.Findings[0].Rule != RuleDuplicateEndpoint {
+		t.Errorf("findings = %+v, want one duplicate_endpoint warning", report.Findings)
+	}
+
+	if _, err := vnm.ValidateNetwork("missing"); err == nil {
+		t.Error("ValidateNetwork(missing) should fail")
+	}
+	network, err := sm.GetNetworkByName("valid")
+	if err != nil {
+		t.Fatalf("GetNetworkByName() error = %v", err)
+	}
+	if _, err := sm.ValidateNetwork(network.ID + "x"); err == nil {
+		t.Error("StorageManager.ValidateNetwork() of a missing ID should fail")
+	}
+}
+
+// TestValidateNetworkRules runs the rules storage cannot prevent on records
+// built by hand, as a damaged or hand-edited database would hold them.
+func TestValidateNetworkRules(t *testing.T) {
+	network := &VirtualNetwork{Name: "broken", CIDR: "10.0.0.0/24"}
+	servers := []*Server{
+		{ID: "s1", Name: "hub", PublicAddress: "vpn.example.com", Port: 51820, VirtualIP: "10.0.0.1", PublicKey: "k1"},
+	}
+	nodes := []*Node{
+		{Name: "clone", Type: NodeTypeRoute, VirtualIP: "10.0.0.2", PublicKey: "k1"},
+		{Name: "dup", Type: NodeTypeRoute, VirtualIP: "10.0.0.2", PublicKey: "k3"},
+		{Name: "far", Type: NodeTypeRoute, VirtualIP: "10.9.0.2", PublicKey: "k4"},
+		{Name: "lost", Type: NodeTypeRoute, VirtualIP: "10.0.0.5", PublicKey: "k5", ServerID: "gone"},
+		{Name: "peer", Type: NodeTypePeer, VirtualIP: "10.0.0.6", PublicKey: "k6", RoutedCIDRs: []string{"192.168.1.0/24"}},
+		{Name: "wide", Type: NodeTypeRoute, VirtualIP: "10.0.0.7", PublicKey: "k7", RoutedCIDRs: []string{"10.0.0.0/16"}},
+		{Name: "lan", Type: NodeTypeRoute, VirtualIP: "10.0.0.8", PublicKey: "k8", RoutedCIDRs: []string{"192.168.0.0/16"}},
+	}
+
+	report := validateNetwork(network, servers, nodes)
+	rules := map[string]int{}
+	for _, f := range report.Findings {
+		if f.Severity != ValidationError {
+			t.Errorf("finding %+v, want only errors", f)
+		}
+		rules[f.Rule]++
+	}
+	want := map[string]int{
+		RuleDuplicatePublicKey: 1,
+		RuleDuplicateVirtualIP: 1,
+		RuleIPOutsideCIDR:      1,
+		RuleUnknownServer:      1,
+		RulePeerWithoutAddress: 1,
+		RuleRoutedCIDR:         3, // peer routes, wide overlaps the network, lan overlaps peer
+	}
+	for rule, n := range want {
+		if rules[rule] != n {
+			t.Errorf("%s findings = %d, want %d: %+v", rule, rules[rule], n, report.Findings)
+		}
+	}
+	if report.Errors() != len(report.Findings) {
+		t.Errorf("Errors() = %d, want %d", report.Errors(), len(report.Findings))
+	}
+}
@@ -0,0 +1,187 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetConfigByHash returns the configuration version of a network whose
+// content hash starts with prefix. When several versions share the hash,
+// because a later version restored earlier configs, the latest is returned.
+// A prefix matching versions with different hashes is an error.
+func (wcg *WireGuardConfigGenerator) GetConfigByHash(networkName, prefix string) (*ConfigVersion, error) {
+	return wcg.GetConfigByHashCtx(context.Background(), networkName, prefix)
+}
+
+// GetConfigByHashCtx is GetConfigByHash with a context.
+func (wcg *WireGuardConfigGenerator) GetConfigByHashCtx(ctx context.Context, networkName, prefix string) (*ConfigVersion, error) {
+	prefix = strings.ToLower(prefix)
+	if prefix == "" || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return nil, kindErrorf(ErrValidation, "invalid hash prefix %q: expected hexadecimal digits", prefix)
+	}
+	history, err := wcg.GetConfigHistoryCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*ConfigVersion
+	for _, version := range history {
+		if strings.HasPrefix(version.ContentHash, prefix) {
+			matches = append(matches, version)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, kindErrorf(ErrNotFound, "no configuration version of network %q has a hash starting with %q", networkName, prefix)
+	}
+
+	found := matches[len(matches)-1]
+	for _, version := range matches {
+		if version.ContentHash == found.ContentHash {
+			continue
+		}
+		numbers := make([]string, 0, len(matches))
+		for _, v := range matches {
+			numbers = append(numbers, strconv.Itoa(v.Version))
+		}
+		return nil, kindErrorf(ErrValidation, "hash prefix %q matches versions %s of network %q; give more characters", prefix, strings.Join(numbers, ", "), networkName)
+	}
+	return found, nil
+}
+
+// ConfigMatch is a saved config identical to a verified file.
+type ConfigMatch struct {
+	Entity    string    `json:"entity"`
+	Versions  []int     `json:"versions"`   // versions holding the config, oldest first
+	CreatedAt time.Time `json:"created_at"` // when the first of them was saved
+	// WithoutComments is set when the file matches the config with its
+	// comments stripped, as 'config generate --no-comments' writes it.
+	WithoutComments bool `json:"without_comments,omitempty"`
+}
+
+// ConfigVerification is what VerifyConfig found for one file.
+type ConfigVerification struct {
+	File          string        `json:"file"`
+	Matches       []ConfigMatch `json:"matches"`                  // empty when the file is unknown or modified
+	LatestVersion int           `json:"latest_version,omitempty"` // the network's latest version
+	// Closest is the entity of the latest version the file was compared
+	// with when nothing matches: the one named like the file, or else the
+	// one with the file's interface address. Empty when there is none.
+	Closest string `json:"closest,omitempty"`
+	Diff    string `json:"diff,omitempty"` // unified diff from Closest's config to the file
+}
+
+// Verified reports whether the file is a saved config.
+func (v *ConfigVerification) Verified() bool {
+	return len(v.Matches) > 0
+}
+
+// VerifyConfig looks up the saved configuration versions of a network that
+// hold content, the content of the config file file, for any server or
+// node. Generation times in the header are ignored, as when versions are
+// compared. When nothing matches, the file is diffed against the closest
+// config of the latest version; with redact set, private and preshared keys
+// are redacted from both sides first, so a file differing only in its keys
+// gets an empty diff. It changes nothing.
+func (wcg *WireGuardConfigGenerator) VerifyConfig(networkName, file, content string, redact bool) (*ConfigVerification, error) {
+	return wcg.VerifyConfigCtx(context.Background(), networkName, file, content, redact)
+}
+
+// VerifyConfigCtx is VerifyConfig with a context.
+func (wcg *WireGuardConfigGenerator) VerifyConfigCtx(ctx context.Context, networkName, file, content string, redact bool) (*ConfigVerification, error) {
+	history, err := wcg.GetConfigHistoryCtx(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConfigVerification{File: file, Matches: []ConfigMatch{}}
+	if len(history) == 0 {
+		return result, nil
+	}
+	latest := history[len(history)-1]
+	result.LatestVersion = latest.Version
+
+	normalized := normalizeConfig(content)
+	noComments := StripComments(content) == content
+	byEntity := make(map[string]int) // entity -> index in result.Matches
+	for _, version := range history {
+		names := make([]string, 0, len(version.Configs))
+		for name := range version.Configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			stored := version.Configs[name]
+			exact := normalizeConfig(stored) == normalized
+			if !exact && (!noComments || StripComments(stored) != content) {
+				continue
+			}
+			i, ok := byEntity[name]
+			if !ok {
+				i = len(result.Matches)
+				byEntity[name] = i
+				result.Matches = append(result.Matches, ConfigMatch{Entity: name, CreatedAt: version.CreatedAt, WithoutComments: !exact})
+			}
+			result.Matches[i].Versions = append(result.Matches[i].Versions, version.Version)
+		}
+	}
+	if result.Verified() {
+		return result, nil
+	}
+
+	result.Closest = closestEntity(latest.Configs, file, content)
+	if result.Closest == "" {
+		return result, nil
+	}
+	stored := normalizeConfig(latest.Configs[result.Closest])
+	if noComments {
+		stored = StripComments(stored)
+	}
+	if redact {
+		stored, normalized = RedactConfig(stored), RedactConfig(normalized)
+	}
+	result.Diff = UnifiedDiff(fmt.Sprintf("%s (version %d)", result.Closest, latest.Version), file, stored, normalized)
+	return result, nil
+}
+
+// closestEntity returns the entity of configs a config file most likely
+// belongs to: the one its file name names, or else the one with the same
+// [Interface] Address. Empty when neither matches.
+func closestEntity(configs map[string]string, file, content string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".conf")
+	if _, ok := configs[name]; ok {
+		return name
+	}
+
+	address := interfaceAddressOf(content)
+	if address == "" {
+		return ""
+	}
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if interfaceAddressOf(configs[name]) == address {
+			return name
+		}
+	}
+	return ""
+}
+
+// interfaceAddressOf returns the first Address value of a config, or "".
+func interfaceAddressOf(config string) string {
+	for _, line := range strings.Split(config, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(key) == "Address" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
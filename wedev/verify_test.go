@@ -0,0 +1,134 @@
+package wedev
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyConfig(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	generator := NewWireGuardConfigGenerator(sm)
+	if _, err := vnm.CreateVirtualNetwork("verify", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("verify", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("verify", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	v1, _, err := generator.SaveConfigVersion("verify")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("verify", "b", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	v2, _, err := generator.SaveConfigVersion("verify")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+
+	// A regenerated file differs from the saved one only in its header time.
+	configs, _, err := generator.GenerateConfigs("verify", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	result, err := generator.VerifyConfig("verify", "/etc/wireguard/wg0.conf", configs["a"], true)
+	if err != nil {
+		t.Fatalf("VerifyConfig() error = %v", err)
+	}
+	if !result.Verified() || len(result.Matches) != 1 || result.Matches[0].Entity != "a" ||
+		len(result.Matches[0].Versions) != 2 || !result.Matches[0].CreatedAt.Equal(v1.CreatedAt) {
+		t.Errorf("VerifyConfig(a) = %+v, want a in versions 1 and 2", result)
+	}
+
+	// Files written with --no-comments match too.
+	result, err = generator.VerifyConfig("verify", "srv.conf", StripComments(v1.Configs["srv"]), true)
+	if err != nil {
+		t.Fatalf("VerifyConfig() error = %v", err)
+	}
+	if len(result.Matches) != 1 || !result.Matches[0].WithoutComments || result.Matches[0].Versions[0] != 1 || result.LatestVersion != 2 {
+		t.Errorf("VerifyConfig(srv without comments) = %+v, want srv of version 1 only", result)
+	}
+
+	// A modified file is diffed against the entity with its address.
+	modified := strings.Replace(v2.Configs["b"], "ListenPort = 51820", "ListenPort = 51999", 1)
+	result, err = generator.VerifyConfig("verify", "wg-laptop.conf", modified, true)
+	if err != nil {
+		t.Fatalf("VerifyConfig() error = %v", err)
+	}
+	if result.Verified() || result.Closest != "b" || !strings.Contains(result.Diff, "+ListenPort = 51999") || strings.Contains(result.Diff, "PrivateKey = "+strings.TrimSpace(keyOf(v2.Configs["b"]))) {
+		t.Errorf("VerifyConfig(modified b) = %+v, want a redacted diff against b", result)
+	}
+
+	// A changed key alone leaves the redacted diff empty.
+	rekeyed := strings.Replace(v2.Configs["b"], keyOf(v2.Configs["b"]), "AAAA", 1)
+	if result, _ := generator.VerifyConfig("verify", "b.conf", rekeyed, true); result.Verified() || result.Closest != "b" || result.Diff != "" {
+		t.Errorf("VerifyConfig(rekeyed b) = %+v, want an empty redacted diff", result)
+	}
+	if result, _ := generator.VerifyConfig("verify", "b.conf", rekeyed, false); !strings.Contains(result.Diff, "+PrivateKey = AAAA") {
+		t.Errorf("VerifyConfig(rekeyed b) diff = %q, want the key change", result.Diff)
+	}
+}
+
+// keyOf returns the PrivateKey value of a config.
+func keyOf(config string) string {
+	for _, line := range strings.Split(config, "\n") {
+		if key, ok := strings.CutPrefix(line, "PrivateKey = "); ok {
+			return key
+		}
+	}
+	return ""
+}
+
+func TestGetConfigByHash(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	generator := NewWireGuardConfigGenerator(sm)
+	if _, err := vnm.CreateVirtualNetwork("hash", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("hash", "srv", "vpn.example.com", 0); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	v1, _, err := generator.SaveConfigVersion("hash")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("hash", "a", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, _, err := generator.SaveConfigVersion("hash"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	// Deleting the node restores the configs of version 1 as version 3.
+	if err := vnm.DeleteNode("hash", "a"); err != nil {
+		t.Fatalf("DeleteNode() error = %v", err)
+	}
+	v3, _, err := generator.SaveConfigVersion("hash")
+	if err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	if v3.ContentHash != v1.ContentHash {
+		t.Fatalf("version 3 hash = %s, want the hash of version 1", v3.ContentHash)
+	}
+
+	got, err := generator.GetConfigByHash("hash", strings.ToUpper(v1.ContentHash[:8]))
+	if err != nil || got.Version != 3 {
+		t.Errorf("GetConfigByHash(version 1 hash) = %v, %v; want version 3", got, err)
+	}
+	if _, err := generator.GetConfigByHash("hash", ""); !errors.Is(err, ErrValidation) {
+		t.Errorf("GetConfigByHash(\"\") error = %v, want ErrValidation", err)
+	}
+	if _, err := generator.GetConfigByHash("hash", "xyz"); !errors.Is(err, ErrValidation) {
+		t.Errorf("GetConfigByHash(xyz) error = %v, want ErrValidation", err)
+	}
+	missing := "0"
+	if strings.HasPrefix(v1.ContentHash, missing) {
+		missing = "1"
+	}
+	if got, err := generator.GetConfigByHash("hash", missing+v1.ContentHash[1:]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetConfigByHash(unknown) = %v, %v; want ErrNotFound", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package wedev
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RevisionWatcher polls a network revision (see StorageManager.NetworkRevision)
+// and calls Changed once it settles on a new value. A revision is settled
+// when two polls in a row read it, so a burst of edits made between polls
+// leads to one call rather than one per edit. The first poll calls Changed
+// straight away, to bring whatever Changed maintains in line at startup.
+type RevisionWatcher struct {
+	Interval time.Duration
+	Revision func(ctx context.Context) (uint64, error)
+	Changed  func(ctx context.Context, revision uint64) error
+	// Failed is called with the errors of Revision and Changed; the watcher
+	// carries on polling, and retries Changed on the next poll.
+	Failed func(err error)
+}
+
+// Run polls until ctx is done and then returns nil.
+func (w *RevisionWatcher) Run(ctx context.Context) error {
+	if w.Interval <= 0 {
+		return kindErrorf(ErrValidation, "watch interval must be positive, got %s", w.Interval)
+	}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	var applied, candidate uint64
+	synced := false
+	for {
+		revision, err := w.Revision(ctx)
+		if err != nil {
+			w.fail(ctx, err)
+		} else {
+			if !synced || (revision == candidate && revision != applied) {
+				if err := w.Changed(ctx, revision); err != nil {
+					w.fail(ctx, err)
+				} else {
+					applied, synced = revision, true
+				}
+			}
+			candidate = revision
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fail reports err unless it only says that ctx is done.
+func (w *RevisionWatcher) fail(ctx context.Context, err error) {
+	if ctx.Err() == nil && w.Failed != nil {
+		w.Failed(err)
+	}
+}
+
+// WriteChangedConfigs writes the configs whose file in dir, named by
+// filenames (entity -> file name), is missing or differs from them, and
+// returns the file names it wrote, sorted. Generation times in the header are
+// ignored, so regenerating an unchanged network writes nothing. Files are
+// readable by their owner only; files of configs not in configs are left
+// alone.
+func WriteChangedConfigs(dir string, configs, filenames map[string]string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	written := []string{}
+	for _, name := range names {
+		filename := filenames[name]
+		if filename == "" {
+			filename = name + ".conf"
+		}
+		path := filepath.Join(dir, filename)
+		if existing, err := os.ReadFile(path); err == nil && normalizeConfig(string(existing)) == normalizeConfig(configs[name]) {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(configs[name]), 0o600); err != nil {
+			return written, fmt.Errorf("failed to write config file %s: %w", path, err)
+		}
+		written = append(written, filename)
+	}
+	sort.Strings(written)
+	return written, nil
+}
@@ -0,0 +1,110 @@
+package wedev
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRevisionWatcher(t *testing.T) {
+	// Each poll reads the next of these revisions, then stays at the last.
+	polls := []uint64{3, 3, 4, 5, 6, 6, 6, 7, 7, 7}
+	var mu sync.Mutex
+	var changed []uint64
+	var failures int
+	poll := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &RevisionWatcher{
+		Interval: time.Millisecond,
+		Revision: func(context.Context) (uint64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if poll == 8 {
+				poll++
+				return 0, errors.New("database is locked")
+			}
+			revision := polls[min(poll, len(polls)-1)]
+			poll++
+			if poll > len(polls)+2 {
+				cancel()
+			}
+			return revision, nil
+		},
+		Changed: func(_ context.Context, revision uint64) error {
+			mu.Lock()
+			defer mu.Unlock()
+			changed = append(changed, revision)
+			if revision == 7 && len(changed) == 3 {
+				return errors.New("disk full")
+			}
+			return nil
+		},
+		Failed: func(error) { failures++ },
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the context was cancelled")
+	}
+
+	// 3 syncs at startup; 4 and 5 never settle; 6 does; 7 fails once and is
+	// retried on the next poll.
+	if want := []uint64{3, 6, 7, 7}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Changed() calls = %v, want %v", changed, want)
+	}
+	if failures != 2 {
+		t.Errorf("Failed() calls = %d, want 2", failures)
+	}
+
+	if err := (&RevisionWatcher{}).Run(context.Background()); !errors.Is(err, ErrValidation) {
+		t.Errorf("Run() without an interval error = %v, want ErrValidation", err)
+	}
+}
+
+func TestWriteChangedConfigs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	configs := map[string]string{
+		"srv": "# network: net, generated by wedevctl dev at 2026-01-01T00:00:00Z\n[Interface]\nAddress = 10.0.0.1/24\n",
+		"a":   "[Interface]\nAddress = 10.0.0.2/24\n",
+	}
+	filenames := map[string]string{"srv": "wg-srv.conf"}
+
+	written, err := WriteChangedConfigs(dir, configs, filenames)
+	if err != nil {
+		t.Fatalf("WriteChangedConfigs() error = %v", err)
+	}
+	if want := []string{"a.conf", "wg-srv.conf"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("WriteChangedConfigs() wrote %v, want %v", written, want)
+	}
+	info, err := os.Stat(filepath.Join(dir, "wg-srv.conf"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("wg-srv.conf mode = %v (err %v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// A new generation time alone is not a change; new content is.
+	configs["srv"] = "# network: net, generated by wedevctl dev at 2026-02-02T00:00:00Z\n[Interface]\nAddress = 10.0.0.1/24\n"
+	configs["a"] = "[Interface]\nAddress = 10.0.0.3/24\n"
+	written, err = WriteChangedConfigs(dir, configs, filenames)
+	if err != nil {
+		t.Fatalf("WriteChangedConfigs() error = %v", err)
+	}
+	if want := []string{"a.conf"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("WriteChangedConfigs() wrote %v, want %v", written, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.conf")); string(data) != configs["a"] {
+		t.Errorf("a.conf = %q, want %q", data, configs["a"])
+	}
+}
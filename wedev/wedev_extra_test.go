@@ -1,10 +1,16 @@
 package wedev
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/wedevctl/util"
+	"go.etcd.io/bbolt"
 )
 
 // newTestManager returns a VirtualNetworkManager backed by a temp-file BoltDB.
@@ -43,7 +49,7 @@ func TestVirtualNetwork_GetListDelete(t *testing.T) {
 	}
 
 	// GetVirtualNetwork — not found.
-	if _, err := vnm.GetVirtualNetwork("missing"); err == nil {
+	if _, err := vnm.GetVirtualNetwork("missing"); !errors.Is(err, ErrNotFound) {
 		t.Error("GetVirtualNetwork(missing) should return an error")
 	}
 
@@ -65,6 +71,32 @@ func TestVirtualNetwork_GetListDelete(t *testing.T) {
 	}
 }
 
+func TestRenameVirtualNetwork(t *testing.T) {
+	vnm, _ := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("neta", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork(neta) error = %v", err)
+	}
+
+	// Invalid and reserved names are rejected.
+	for _, name := range []string{"bad-name", "1net", "rename", "list"} {
+		if _, err := vnm.RenameVirtualNetwork("neta", name); err == nil {
+			t.Errorf("RenameVirtualNetwork(neta, %q) should fail", name)
+		}
+	}
+
+	got, err := vnm.RenameVirtualNetwork("neta", "netb")
+	if err != nil {
+		t.Fatalf("RenameVirtualNetwork() error = %v", err)
+	}
+	if got.Name != "netb" || got.CIDR != "10.0.0.0/24" {
+		t.Errorf("RenameVirtualNetwork() = %+v, want netb with CIDR 10.0.0.0/24", got)
+	}
+	if _, err := vnm.GetVirtualNetwork("neta"); err == nil {
+		t.Error("GetVirtualNetwork(neta) should fail after rename")
+	}
+}
+
 func TestServer_GetUpdateDelete(t *testing.T) {
 	vnm, _ := newTestManager(t)
 	if _, err := vnm.CreateVirtualNetwork("svcnet", "10.0.0.0/24"); err != nil {
@@ -75,7 +107,7 @@ func TestServer_GetUpdateDelete(t *testing.T) {
 	}
 
 	// GetServer.
-	srv, err := vnm.GetServer("svcnet")
+	srv, err := vnm.GetServer("svcnet", "")
 	if err != nil {
 		t.Fatalf("GetServer() error = %v", err)
 	}
@@ -84,7 +116,7 @@ func TestServer_GetUpdateDelete(t *testing.T) {
 	}
 
 	// UpdateServer — success.
-	updated, err := vnm.UpdateServer("svcnet", "new.example.com", 51821)
+	updated, err := vnm.UpdateServer("svcnet", "", "new.example.com", 51821)
 	if err != nil {
 		t.Fatalf("UpdateServer() error = %v", err)
 	}
@@ -93,23 +125,23 @@ func TestServer_GetUpdateDelete(t *testing.T) {
 	}
 
 	// UpdateServer — invalid address.
-	if _, err := vnm.UpdateServer("svcnet", "bad address", 51821); err == nil {
+	if _, err := vnm.UpdateServer("svcnet", "", "bad address", 51821); err == nil {
 		t.Error("UpdateServer() with invalid address should fail")
 	}
 
 	// GetServer / UpdateServer / DeleteServer — unknown network.
-	if _, err := vnm.GetServer("nope"); err == nil {
+	if _, err := vnm.GetServer("nope", ""); err == nil {
 		t.Error("GetServer(nope) should fail")
 	}
-	if _, err := vnm.UpdateServer("nope", "x.example.com", 1); err == nil {
+	if _, err := vnm.UpdateServer("nope", "", "x.example.com", 1); err == nil {
 		t.Error("UpdateServer(nope) should fail")
 	}
-	if err := vnm.DeleteServer("nope"); err == nil {
+	if err := vnm.DeleteServer("nope", DeleteServerOptions{}); err == nil {
 		t.Error("DeleteServer(nope) should fail")
 	}
 
 	// DeleteServer — success.
-	if err := vnm.DeleteServer("svcnet"); err != nil {
+	if err := vnm.DeleteServer("svcnet", DeleteServerOptions{}); err != nil {
 		t.Errorf("DeleteServer() error = %v", err)
 	}
 }
@@ -192,6 +224,47 @@ func TestNode_GetListUpdate(t *testing.T) {
 	}
 }
 
+func TestRenameNodeAndServer(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("net", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("net", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("net", "n1", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	// Node rename: invalid name, server-name collision, missing network.
+	if _, err := vnm.RenameNode("net", "n1", "bad-name"); err == nil {
+		t.Error("RenameNode() to an invalid name should fail")
+	}
+	if _, err := vnm.RenameNode("net", "n1", "srv"); err == nil {
+		t.Error("RenameNode() to the server's name should fail")
+	}
+	if _, err := vnm.RenameNode("missing", "n1", "n2"); err == nil {
+		t.Error("RenameNode() in a missing network should fail")
+	}
+	if node, err := vnm.RenameNode("net", "n1", "n2"); err != nil || node.Name != "n2" {
+		t.Errorf("RenameNode() = %v (err %v), want n2", node, err)
+	}
+
+	// Server rename: invalid name, node-name collision, missing network.
+	if _, err := vnm.RenameServer("net", "", "bad-name"); err == nil {
+		t.Error("RenameServer() to an invalid name should fail")
+	}
+	if _, err := vnm.RenameServer("net", "", "n2"); err == nil {
+		t.Error("RenameServer() to a node's name should fail")
+	}
+	if _, err := vnm.RenameServer("missing", "", "gw"); err == nil {
+		t.Error("RenameServer() in a missing network should fail")
+	}
+	if server, err := vnm.RenameServer("net", "", "gw"); err != nil || server.Name != "gw" {
+		t.Errorf("RenameServer() = %v (err %v), want gw", server, err)
+	}
+}
+
 func TestNode_CreateErrors(t *testing.T) {
 	vnm, _ := newTestManager(t)
 	if _, err := vnm.CreateVirtualNetwork("cn", "10.0.0.0/24"); err != nil {
@@ -366,3 +439,487 @@ func TestConfigGenerator_ErrorPaths(t *testing.T) {
 		t.Error("SaveConfigVersion() with no server should fail")
 	}
 }
+
+func TestLabels_UpdateAndRoundTrip(t *testing.T) {
+	vnm, _ := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("labeled", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	network, err := vnm.UpdateVirtualNetworkLabels("labeled", map[string]string{"team": "payments", "env": "prod"}, nil)
+	if err != nil {
+		t.Fatalf("UpdateVirtualNetworkLabels() error = %v", err)
+	}
+	network, err = vnm.UpdateVirtualNetworkLabels("labeled", map[string]string{"env": "staging"}, []string{"team"})
+	if err != nil {
+		t.Fatalf("UpdateVirtualNetworkLabels() error = %v", err)
+	}
+	if len(network.Labels) != 1 || network.Labels["env"] != "staging" {
+		t.Errorf("network labels = %v, want env=staging", network.Labels)
+	}
+	if _, err := vnm.UpdateVirtualNetworkLabels("labeled", map[string]string{"bad key": "x"}, nil); err == nil {
+		t.Error("UpdateVirtualNetworkLabels() should reject a key with spaces")
+	}
+
+	if _, err := vnm.CreateNode("labeled", "node1", "", 51820, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	if _, err := vnm.UpdateNodeLabels("labeled", "node1", map[string]string{"role": "db"}, nil); err != nil {
+		t.Fatalf("UpdateNodeLabels() error = %v", err)
+	}
+	node, err := vnm.GetNode("labeled", "node1")
+	if err != nil || node.Labels["role"] != "db" {
+		t.Errorf("GetNode() labels = %v (err %v), want role=db", node.Labels, err)
+	}
+	node, err = vnm.UpdateNodeLabels("labeled", "node1", nil, []string{"role"})
+	if err != nil || node.Labels != nil {
+		t.Errorf("UpdateNodeLabels() removing last label = %v (err %v), want nil", node.Labels, err)
+	}
+}
+
+func TestConfigGenerator_PreviewConfigs(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	gen := NewWireGuardConfigGenerator(sm)
+
+	if _, err := vnm.CreateVirtualNetwork("preview", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("preview", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+
+	preview, err := gen.PreviewConfigs("preview")
+	if err != nil {
+		t.Fatalf("PreviewConfigs() error = %v", err)
+	}
+	if preview.BaseVersion != 0 || preview.Unchanged || len(preview.Files) != 1 || preview.Files[0].Status != FileAdded {
+		t.Errorf("PreviewConfigs() with no saved version = %+v", preview)
+	}
+
+	if _, _, err := gen.SaveConfigVersion("preview"); err != nil {
+		t.Fatalf("SaveConfigVersion() error = %v", err)
+	}
+	preview, err = gen.PreviewConfigs("preview")
+	if err != nil || !preview.Unchanged || preview.BaseVersion != 1 || len(preview.Files) != 0 {
+		t.Errorf("PreviewConfigs() after save = %+v (err %v), want unchanged", preview, err)
+	}
+
+	if _, err := vnm.CreateNode("preview", "n1", "1.2.3.4", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+	preview, err = gen.PreviewConfigs("preview")
+	if err != nil || preview.Unchanged || len(preview.Files) != 2 {
+		t.Fatalf("PreviewConfigs() after node add = %+v (err %v), want 2 changed files", preview, err)
+	}
+	if preview.Files[0].Name != "n1" || preview.Files[0].Status != FileAdded || preview.Files[1].Status != FileModified {
+		t.Errorf("PreviewConfigs() files = %+v", preview.Files)
+	}
+
+	// Previewing never saves a version.
+	history, err := gen.GetConfigHistory("preview")
+	if err != nil || len(history) != 1 {
+		t.Errorf("GetConfigHistory() = %d versions (err %v), want 1", len(history), err)
+	}
+}
+
+func TestResizeNetwork(t *testing.T) {
+	vnm, sm := newTestManager(t)
+
+	if _, err := vnm.CreateVirtualNetwork("small", "10.0.0.0/29"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("small", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, err := vnm.CreateNode("small", fmt.Sprintf("n%d", i), "", 51820, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode() error = %v", err)
+		}
+	}
+	if _, err := vnm.CreateNode("small", "full", "", 51820, NodeTypeRoute); err == nil {
+		t.Fatal("CreateNode() should fail once the /29 is exhausted")
+	}
+
+	// Shrinking and re-basing are rejected, listing the stranded addresses.
+	if _, _, err := vnm.ResizeNetwork("small", "10.0.0.0/30"); err == nil || !strings.Contains(err.Error(), "10.0.0.6 (n5)") {
+		t.Errorf("ResizeNetwork() shrink error = %v, want it to list 10.0.0.6 (n5)", err)
+	}
+	if _, _, err := vnm.ResizeNetwork("small", "10.1.0.0/24"); err == nil || !strings.Contains(err.Error(), "10.0.0.1 (srv)") {
+		t.Errorf("ResizeNetwork() re-base error = %v, want it to list 10.0.0.1 (srv)", err)
+	}
+	if _, _, err := vnm.ResizeNetwork("small", "10.0.0.0/29"); err == nil {
+		t.Error("ResizeNetwork() to the same CIDR should fail")
+	}
+
+	network, version, err := vnm.ResizeNetwork("small", "10.0.0.0/28")
+	if err != nil {
+		t.Fatalf("ResizeNetwork() error = %v", err)
+	}
+	if network.CIDR != "10.0.0.0/28" {
+		t.Errorf("ResizeNetwork() CIDR = %s, want 10.0.0.0/28", network.CIDR)
+	}
+	if version == nil || !strings.Contains(version.Configs["n1"], "10.0.0.0/28") {
+		t.Errorf("ResizeNetwork() should save a config version routing the new CIDR, got %+v", version)
+	}
+
+	state, err := sm.GetIPPoolState(network.ID)
+	if err != nil || state.NetworkCIDR != "10.0.0.0/28" {
+		t.Errorf("IP pool state = %+v (err %v), want CIDR 10.0.0.0/28", state, err)
+	}
+
+	// A fresh manager restores the persisted pool and allocates past the old range.
+	fresh, err := NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+	if err != nil {
+		t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+	}
+	node, err := fresh.CreateNode("small", "n6", "", 51820, NodeTypeRoute)
+	if err != nil || node.VirtualIP != "10.0.0.7" {
+		t.Errorf("CreateNode() after resize = %+v (err %v), want 10.0.0.7", node, err)
+	}
+}
+
+// setNetworkCIDR overwrites a network's stored CIDR unchecked, as an older
+// build or a hand edit could have left it.
+func setNetworkCIDR(t *testing.T, sm *StorageManager, networkID, cidr string) {
+	t.Helper()
+	if err := sm.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(BucketNetworks))
+		var record map[string]any
+		if err := json.Unmarshal(bucket.Get([]byte(networkID)), &record); err != nil {
+			return err
+		}
+		record["cidr"] = cidr
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(networkID), data)
+	}); err != nil {
+		t.Fatalf("setting CIDR %s error = %v", cidr, err)
+	}
+}
+
+func TestInvalidNetworkCIDR(t *testing.T) {
+	for _, cidr := range []string{"fd00::/64", "10.0.0/24"} {
+		t.Run(cidr, func(t *testing.T) {
+			vnm, sm := newTestManager(t)
+			network, err := vnm.CreateVirtualNetwork("legacy", "10.0.0.0/24")
+			if err != nil {
+				t.Fatalf("CreateVirtualNetwork() error = %v", err)
+			}
+			if _, err := vnm.CreateServer("legacy", "hub", "vpn.example.com", 51820); err != nil {
+				t.Fatalf("CreateServer() error = %v", err)
+			}
+			for _, name := range []string{"nas", "lab"} {
+				if _, err := vnm.CreateNode("legacy", name, "", 51820, NodeTypeRoute); err != nil {
+					t.Fatalf("CreateNode(%s) error = %v", name, err)
+				}
+			}
+			setNetworkCIDR(t, sm, network.ID, cidr)
+			// A manager starting afresh has no cached pool to fall back on.
+			vnm, err = NewVirtualNetworkManager(sm, util.NewDefaultIPValidator())
+			if err != nil {
+				t.Fatalf("NewVirtualNetworkManager() error = %v", err)
+			}
+
+			// What does not need the pool still works.
+			if nodes, err := vnm.ListNodes("legacy"); err != nil || len(nodes) != 2 {
+				t.Errorf("ListNodes() = %d nodes, %v; want 2", len(nodes), err)
+			}
+			summary, err := vnm.DescribeNetwork("legacy")
+			if err != nil || summary.Pool != nil || summary.Nodes != 2 {
+				t.Errorf("DescribeNetwork() = %+v, %v; want the contents without a pool", summary, err)
+			}
+			if overviews, err := vnm.NetworkOverviews(t.Context(), []*VirtualNetwork{summary.Network}); err != nil || !overviews[network.ID].InvalidCIDR {
+				t.Errorf("NetworkOverviews() = %+v, %v; want InvalidCIDR", overviews[network.ID], err)
+			}
+			if err := vnm.DeleteNode("legacy", "lab"); err != nil {
+				t.Errorf("DeleteNode() error = %v", err)
+			}
+
+			// What needs it names the network, the CIDR and the fix.
+			want := fmt.Sprintf("network legacy has invalid CIDR '%s'; fix with 'vn edit --cidr'", cidr)
+			for name, op := range map[string]func() error{
+				"CreateNode": func() error { _, err := vnm.CreateNode("legacy", "new", "", 51820, NodeTypeRoute); return err },
+				"ListIPs":    func() error { _, err := vnm.ListIPs("legacy"); return err },
+				"GenerateConfigs": func() error {
+					_, _, err := NewWireGuardConfigGenerator(sm).GenerateConfigs("legacy", sm)
+					return err
+				},
+			} {
+				if err := op(); !errors.Is(err, ErrValidation) || err.Error() != want {
+					t.Errorf("%s() error = %v, want %q", name, err, want)
+				}
+			}
+
+			report, err := vnm.ValidateNetwork("legacy")
+			if err != nil || len(report.Findings) != 1 || report.Findings[0].Rule != RuleInvalidCIDR {
+				t.Errorf("ValidateNetwork() = %+v, %v; want only %s", report, err, RuleInvalidCIDR)
+			}
+			integrity, err := vnm.FixIntegrity()
+			if err != nil || len(integrity.InvalidCIDRs) != 1 || integrity.InvalidCIDRs[0].CIDR != cidr || integrity.Repairable() != 0 || integrity.Fixed {
+				t.Errorf("FixIntegrity() = %+v, %v; want the CIDR reported and left alone", integrity, err)
+			}
+
+			// Any valid range holding the addresses fixes it; the pool is
+			// rebuilt from the records, so lab's deleted address is free.
+			if _, _, err := vnm.ResizeNetwork("legacy", "10.1.0.0/24"); !errors.Is(err, ErrValidation) {
+				t.Errorf("ResizeNetwork() to a range without the addresses error = %v, want ErrValidation", err)
+			}
+			fixed, version, err := vnm.ResizeNetwork("legacy", "10.0.0.0/25")
+			if err != nil || fixed.CIDR != "10.0.0.0/25" || version == nil {
+				t.Fatalf("ResizeNetwork() = %+v, %+v, %v; want the CIDR set and a version saved", fixed, version, err)
+			}
+			node, err := vnm.CreateNode("legacy", "new", "", 51820, NodeTypeRoute)
+			if err != nil || node.VirtualIP != "10.0.0.3" {
+				t.Errorf("CreateNode() after the fix = %+v, %v; want lab's old address 10.0.0.3", node, err)
+			}
+			if report, err := vnm.AuditIPPool("legacy"); err != nil || len(report.Issues) != 0 {
+				t.Errorf("AuditIPPool() after the fix = %+v, %v; want no issues", report, err)
+			}
+			if err := vnm.DeleteVirtualNetwork("legacy"); err != nil {
+				t.Errorf("DeleteVirtualNetwork() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestImportKeys(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("imp", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("imp", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	for _, name := range []string{"own", "ext"} {
+		if _, err := vnm.CreateNode("imp", name, "", 51820, NodeTypeRoute); err != nil {
+			t.Fatalf("CreateNode(%s) error = %v", name, err)
+		}
+	}
+
+	full, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+	node, err := vnm.ImportNodeKeys("imp", "own", full)
+	if err != nil {
+		t.Fatalf("ImportNodeKeys(full) error = %v", err)
+	}
+	if node.PrivateKey != full.PrivateKey || node.PublicKey != full.PublicKey || node.ExternallyManaged() {
+		t.Errorf("ImportNodeKeys(full) = %+v, want imported pair", node)
+	}
+
+	other, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+	public := &util.WireGuardKeyPair{PublicKey: other.PublicKey}
+	if node, err = vnm.ImportNodeKeys("imp", "ext", public); err != nil || !node.ExternallyManaged() {
+		t.Fatalf("ImportNodeKeys(public only) = %+v, %v; want externally managed node", node, err)
+	}
+
+	// A public key already held by another entity is rejected.
+	if _, err := vnm.ImportNodeKeys("imp", "ext", full); err == nil || !strings.Contains(err.Error(), `node "own"`) {
+		t.Errorf("ImportNodeKeys(duplicate) error = %v, want duplicate key error", err)
+	}
+	if _, err := vnm.ImportServerKeys("imp", "", public); err == nil {
+		t.Error("ImportServerKeys(duplicate) should fail")
+	}
+
+	gen := NewWireGuardConfigGenerator(sm)
+	configs, _, err := gen.GenerateConfigs("imp", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if _, ok := configs["ext"]; ok {
+		t.Error("GenerateConfigs() wrote a config for the externally managed node")
+	}
+	if !strings.Contains(configs["srv"], "PublicKey = "+other.PublicKey) {
+		t.Error("server config is missing the externally managed node as a peer")
+	}
+	if !strings.Contains(configs["own"], "PrivateKey = "+full.PrivateKey) {
+		t.Error("node config does not use the imported private key")
+	}
+
+	applier := NewConfigApplier(sm)
+	if _, err := applier.Plan("imp", "ext", ApplyOptions{}); err == nil || !strings.Contains(err.Error(), "managed outside wedevctl") {
+		t.Errorf("Plan(ext) error = %v, want externally managed error", err)
+	}
+
+	// A public-only server keeps peers' configs but gets none of its own.
+	serverKeys, err := util.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys() error = %v", err)
+	}
+	server, err := vnm.ImportServerKeys("imp", "", &util.WireGuardKeyPair{PublicKey: serverKeys.PublicKey})
+	if err != nil || !server.ExternallyManaged() {
+		t.Fatalf("ImportServerKeys(public only) = %+v, %v", server, err)
+	}
+	configs, _, err = gen.GenerateConfigs("imp", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	if _, ok := configs["srv"]; ok {
+		t.Error("GenerateConfigs() wrote a config for the externally managed server")
+	}
+	if !strings.Contains(configs["own"], "PublicKey = "+serverKeys.PublicKey) {
+		t.Error("node config does not reference the imported server public key")
+	}
+}
+
+func TestNodePorts(t *testing.T) {
+	vnm, _ := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("ports", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+
+	if _, err := vnm.SetDefaultPort("ports", 70000); err == nil {
+		t.Error("SetDefaultPort(70000) should fail")
+	}
+	network, err := vnm.SetDefaultPort("ports", 51900)
+	if err != nil || network.NodePort() != 51900 {
+		t.Fatalf("SetDefaultPort() = %+v, %v; want default port 51900", network, err)
+	}
+
+	if _, err := vnm.CreateServer("ports", "srv", "host.example.com", 51900); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	node, err := vnm.CreateNode("ports", "n1", "other.example.com", 0, NodeTypePeer)
+	if err != nil || node.Port != 51900 {
+		t.Fatalf("CreateNode(port 0) = %+v, %v; want network default port 51900", node, err)
+	}
+	if _, err := vnm.CreateNode("ports", "n2", "host.example.com", 51901, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(n2) error = %v", err)
+	}
+
+	// The server and n2 share host.example.com on 51900 and 51901.
+	if port, err := vnm.NextFreePort("ports", "host.example.com", 51900, 51910); err != nil || port != 51902 {
+		t.Errorf("NextFreePort(host) = %d, %v; want 51902", port, err)
+	}
+	if port, err := vnm.NextFreePort("ports", "fresh.example.com", 51900, 51910); err != nil || port != 51900 {
+		t.Errorf("NextFreePort(fresh) = %d, %v; want 51900", port, err)
+	}
+	if _, err := vnm.NextFreePort("ports", "host.example.com", 51900, 51901); err == nil {
+		t.Error("NextFreePort() with an exhausted range should fail")
+	}
+	if _, err := vnm.NextFreePort("ports", "host.example.com", 51910, 51900); err == nil {
+		t.Error("NextFreePort() with an inverted range should fail")
+	}
+
+	tests := []struct {
+		name    string
+		entity  string
+		address string
+		port    int
+		want    string
+	}{
+		{"server endpoint", "n3", "host.example.com", 51900, `server "srv"`},
+		{"node endpoint", "n3", "other.example.com", 51900, `node "n1"`},
+		{"own endpoint", "n1", "other.example.com", 51900, ""},
+		{"free port", "n3", "host.example.com", 51902, ""},
+		{"no address", "n3", "", 51900, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := vnm.CheckEndpointAvailable("ports", tt.entity, tt.address, tt.port)
+			if tt.want == "" && err != nil {
+				t.Errorf("CheckEndpointAvailable() error = %v, want nil", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("CheckEndpointAvailable() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeEndpointDuplicates(t *testing.T) {
+	vnm, _, buf := newLoggedManager(t, slog.LevelWarn)
+	if _, err := vnm.CreateVirtualNetwork("dup", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("dup", "hub", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("dup", "n1", "198.51.100.1", 51820, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(n1) error = %v", err)
+	}
+
+	// The server's endpoint, copied onto a node.
+	_, err := vnm.CreateNode("dup", "n2", "vpn.example.com", 51820, NodeTypePeer)
+	if !errors.Is(err, ErrAlreadyExists) || !strings.Contains(err.Error(), `server "hub"`) || !strings.Contains(err.Error(), "use port 51821") {
+		t.Errorf("CreateNode(server endpoint) error = %v, want one naming the server and port 51821", err)
+	}
+	_, err = vnm.CreateRouteNode("dup", "n2", "198.51.100.1", 51820, []string{"192.168.10.0/24"})
+	if !errors.Is(err, ErrAlreadyExists) || !strings.Contains(err.Error(), `node "n1"`) {
+		t.Errorf("CreateRouteNode(node endpoint) error = %v, want one naming n1", err)
+	}
+	if _, err := vnm.GetNode("dup", "n2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a refused node was created: %v", err)
+	}
+
+	// The same address on another port is accepted with a warning.
+	if _, err := vnm.CreateNode("dup", "n2", "vpn.example.com", 51821, NodeTypePeer); err != nil {
+		t.Fatalf("CreateNode(shared address) error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "public address is shared on another port") || !strings.Contains(buf.String(), `with="server \"hub\""`) {
+		t.Errorf("no warning about the shared address:\n%s", buf.String())
+	}
+
+	// Updates are checked as creations are, but not when the endpoint stays.
+	if _, err := vnm.UpdateNode("dup", "n2", "198.51.100.1", 51820, NodeTypePeer); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("UpdateNode(node endpoint) error = %v, want ErrAlreadyExists", err)
+	}
+	address, port := "198.51.100.1", 51820
+	if _, err := vnm.EditNode("dup", "n2", NodeEdit{IgnoreConflict: true, PublicAddress: &address, Port: &port}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("EditNode(node endpoint) error = %v, want ErrAlreadyExists", err)
+	}
+
+	// Allowed duplicates are created, warned about, and then left alone.
+	buf.Reset()
+	if _, err := vnm.EditNode("dup", "n2", NodeEdit{IgnoreConflict: true, PublicAddress: &address, Port: &port, AllowDuplicateEndpoint: true}); err != nil {
+		t.Fatalf("EditNode(AllowDuplicateEndpoint) error = %v", err)
+	}
+	if _, err := vnm.CreateNodeWithOptions("dup", "n3", "vpn.example.com", 51820, NodeTypePeer, NodeOptions{AllowDuplicateEndpoint: true}); err != nil {
+		t.Fatalf("CreateNodeWithOptions(AllowDuplicateEndpoint) error = %v", err)
+	}
+	if strings.Count(buf.String(), "endpoint is shared") != 2 {
+		t.Errorf("allowed duplicates were not warned about:\n%s", buf.String())
+	}
+	if _, err := vnm.EditNode("dup", "n2", NodeEdit{IgnoreConflict: true, SetLabels: map[string]string{"site": "lab"}}); err != nil {
+		t.Errorf("EditNode(labels) of a node with a duplicate endpoint error = %v", err)
+	}
+}
+
+func TestConfigGenerator_GenerateConfig(t *testing.T) {
+	vnm, sm := newTestManager(t)
+	if _, err := vnm.CreateVirtualNetwork("one", "10.0.0.0/24"); err != nil {
+		t.Fatalf("CreateVirtualNetwork() error = %v", err)
+	}
+	if _, err := vnm.CreateServer("one", "srv", "vpn.example.com", 51820); err != nil {
+		t.Fatalf("CreateServer() error = %v", err)
+	}
+	if _, err := vnm.CreateNode("one", "n1", "", 0, NodeTypeRoute); err != nil {
+		t.Fatalf("CreateNode() error = %v", err)
+	}
+
+	gen := NewWireGuardConfigGenerator(sm)
+	configs, _, err := gen.GenerateConfigs("one", sm)
+	if err != nil {
+		t.Fatalf("GenerateConfigs() error = %v", err)
+	}
+	for _, name := range []string{"srv", "n1"} {
+		config, err := gen.GenerateConfig("one", name)
+		if err != nil || config != configs[name] {
+			t.Errorf("GenerateConfig(%s) = %q, %v; want the generated config", name, config, err)
+		}
+	}
+	if _, err := gen.GenerateConfig("one", "ghost"); err == nil || !strings.Contains(err.Error(), `no server or node named "ghost"`) {
+		t.Errorf("GenerateConfig(ghost) error = %v", err)
+	}
+
+	selected, err := gen.SelectConfigs("one", configs, []string{"n1"})
+	if err != nil || len(selected) != 1 || selected["n1"] != configs["n1"] {
+		t.Errorf("SelectConfigs(n1) = %v, %v", selected, err)
+	}
+}